@@ -0,0 +1,47 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/capture"
+)
+
+// compile prints the socket filter of a filter expression as tcpdump -d
+// does, for an expression to be checked before targeting a capture with it
+// over the debug socket
+func compile(args []string) int {
+	if len(args) == 0 {
+		log.Error().Msg("Please provide a filter expression")
+		return 2
+	}
+
+	filter, err := capture.TargetFilter(capture.Target{Expression: strings.Join(args, " ")}, nil)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return 2
+	}
+
+	for _, line := range capture.Disassemble(filter) {
+		fmt.Println(line)
+	}
+
+	return 0
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/loadgen"
+)
+
+// generate transmits synthetic traffic on an interface, typically one end of
+// a veth pair or a TAP with netmon observing the other, and prints what was
+// sent once done
+func generate(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	mix := loadgen.DefaultMix()
+
+	rate := flags.Float64("rate", 1000, "frames per second, 0 for as fast as possible")
+	count := flags.Uint64("count", 0, "frames to send, 0 for no limit")
+	duration := flags.Duration("duration", 0, "how long to send for, 0 for no limit")
+	hosts := flags.Int("hosts", 256, "number of hosts exchanging frames")
+	prefix := flags.String("prefix", "10.0.0.0/8", "IPv4 subnet of the hosts")
+	seed := flags.Uint64("seed", 0, "seed of the frames")
+
+	flags.Float64Var(&mix.ARPRequest, "arp", mix.ARPRequest, "share of ARP requests")
+	flags.Float64Var(&mix.GratuitousARP, "gratuitous-arp", mix.GratuitousARP, "share of gratuitous ARPs")
+	flags.Float64Var(&mix.DHCP, "dhcp", mix.DHCP, "share of DHCP messages")
+	flags.Float64Var(&mix.IPv4, "ipv4", mix.IPv4, "share of IPv4 datagrams")
+	flags.Float64Var(&mix.Malformed, "malformed", mix.Malformed, "share of malformed frames")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		log.Error().Msg("Please provide the interface to send on")
+		return 2
+	}
+
+	subnet, err := netip.ParsePrefix(*prefix)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return 2
+	}
+
+	iface := flags.Arg(0)
+
+	gen, err := loadgen.New(loadgen.WithRate(*rate), loadgen.WithCount(*count), loadgen.WithHosts(*hosts),
+		loadgen.WithPrefix(subnet), loadgen.WithSeed(*seed), loadgen.WithMix(mix), loadgen.WithInterface(iface))
+	if err != nil {
+		log.Error().Err(err).Send()
+		return 2
+	}
+
+	conn, err := capture.Listen(iface)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return 1
+	}
+
+	defer conn.Close() //nolint:errcheck // nothing is written to conn anymore
+
+	if *duration > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	log.Info().Str("interface", iface).Float64("rate", *rate).Msg("Generating traffic")
+
+	// the frames are of made up hosts, the guard would reject every one
+	w := capture.NewGuardedWriter(conn, iface, capture.WithGuardOverride())
+
+	summary, transmitErr := gen.Transmit(ctx, w)
+	if transmitErr != nil {
+		log.Error().Err(transmitErr).Send()
+	}
+
+	log.Info().Uint64("frames", summary.Frames).Float64("rate", summary.Rate()).
+		Dur("elapsed", summary.End.Sub(summary.Start).Round(time.Millisecond)).Msg("Traffic generated")
+
+	if err := json.NewEncoder(os.Stdout).Encode(summary); err != nil {
+		log.Error().Err(err).Send()
+		return 1
+	}
+
+	if transmitErr != nil {
+		return 1
+	}
+
+	return 0
+}
@@ -18,17 +18,36 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/sync/errgroup"
 
+	"maas.io/core/src/maasagent/internal/alert"
+	"maas.io/core/src/maasagent/internal/apiclient"
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/debugserver"
+	"maas.io/core/src/maasagent/internal/dhcpd/leasefile"
+	"maas.io/core/src/maasagent/internal/journal"
+	"maas.io/core/src/maasagent/internal/lifecycle"
+	"maas.io/core/src/maasagent/internal/netif"
 	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/schema"
+	"maas.io/core/src/maasagent/internal/upload"
 )
 
+// uploadTimeout bounds a request of the uploads to the region, a chunk
+// over a slow link
+const uploadTimeout = time.Minute
+
 func Run() int {
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 
@@ -47,32 +66,132 @@ func Run() int {
 		return 2
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	switch os.Args[1] {
+	case "selftest":
+		return selfTest(ctx, os.Args[2:])
+	case "generate":
+		return generate(ctx, os.Args[2:])
+	case "compile":
+		return compile(os.Args[2:])
+	case "replay":
+		return replayCapture(ctx, os.Args[2:])
+	case "dissect":
+		return dissect(os.Args[2:])
+	}
+
 	iface := os.Args[1]
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	inv := netif.NewInventory()
+	self := netif.NewSelfMACs(inv)
+
+	// without CAP_NET_RAW, such as under strict confinement, the capture is
+	// disabled once and the bindings only come from the kernel neighbor
+	// cache and the leases
+	caps, err := netmon.DetectCapabilities(capture.CheckRawAccess)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return 1
+	}
+
+	caps.Log()
+
+	options := []netmon.ServiceOption{netmon.WithSelfMACs(self), netmon.WithCapabilities(caps)}
+
+	// the assertions are reloaded on SIGHUP, a failed reload keeps the
+	// previous ones
+	assertionsPath, hasAssertions := os.LookupEnv("NETMON_ASSERTIONS")
+	assertions := netmon.NewAssertions()
+
+	if hasAssertions {
+		if err := assertions.LoadFile(assertionsPath); err != nil {
+			log.Error().Err(err).Send()
+			return 1
+		}
+
+		options = append(options, netmon.WithAssertions(assertions))
+	}
+
+	// the journal is opt-in, it keeps the results until they are written
+	// out so a crash in between doesn't lose them
+	var results *journal.Journal
+
+	if journalPath, ok := os.LookupEnv("NETMON_JOURNAL"); ok {
+		j, err := journal.Open(journalPath)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return 1
+		}
+
+		defer j.Close()
+
+		results = j
+	}
+
+	// the alerts are opt-in, the high-severity results are posted to the
+	// webhook on top of being written out
+	var (
+		alerts  *alert.Router
+		webhook *alert.Webhook
+	)
+
+	if webhookURL, ok := os.LookupEnv("NETMON_ALERT_WEBHOOK"); ok {
+		var err error
+
+		alerts, webhook, err = newAlerts(webhookURL)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return 1
+		}
+	}
+
+	// the uploads are opt-in, the results are journaled and uploaded to
+	// the region in chunks on top of being written out
+	var uploader *upload.Uploader
+
+	if regionURL, ok := os.LookupEnv("NETMON_UPLOAD_URL"); ok {
+		u, j, err := newUploader(regionURL)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return 1
+		}
+
+		defer j.Close()
 
-	sigC := make(chan os.Signal, 2)
-	signal.Notify(sigC, syscall.SIGTERM, syscall.SIGINT)
+		uploader = u
+	}
 
 	resultC := make(chan netmon.Result)
+	svc := netmon.NewService(iface, options...)
 
-	g, ctx := errgroup.WithContext(ctx)
-	g.SetLimit(2)
+	// the debug socket is opt-in, it exposes the state of the capture
+	debugSocket, hasDebugSocket := os.LookupEnv("NETMON_DEBUG_SOCKET")
+	events := debugserver.NewEventLog(0)
 
-	svc := netmon.NewService(iface)
+	// the encoder consumes what netmon produces, so is stopped after it
+	g := lifecycle.NewGroup()
+	g.Add("inventory", inv)
+	g.Add("self-macs", self)
 
-	g.Go(func() error {
-		return svc.Start(ctx, resultC)
-	})
+	if alerts != nil {
+		g.Add("alert-webhook", webhook)
+		g.Add("alerts", alerts)
+	}
 
-	g.Go(func() error {
-		encoder := json.NewEncoder(os.Stdout)
+	if uploader != nil {
+		g.Add("uploader", uploader)
+	}
+
+	g.Add("encoder", lifecycle.RunnerFunc(func(ctx context.Context) error {
+		if err := replay(os.Stdout, results); err != nil {
+			return err
+		}
 
 		for {
 			select {
-			case <-sigC:
-				cancel()
+			case <-ctx.Done():
 				return nil
 			case res, ok := <-resultC:
 				if !ok {
@@ -80,17 +199,84 @@ func Run() int {
 					return nil
 				}
 
-				err := encoder.Encode(res)
-				if err != nil {
+				if hasDebugSocket {
+					events.Record(iface, res)
+				}
+
+				if alerts != nil {
+					alerts.Handle(iface, res)
+				}
+
+				if uploader != nil {
+					if _, err := uploader.Append(res); err != nil {
+						log.Warn().Err(err).Msg("Result not journaled for the upload")
+					}
+				}
+
+				if err := emit(os.Stdout, results, res); err != nil {
 					return err
 				}
 			}
 		}
-	})
+	}))
+	if hasAssertions {
+		g.Add("assertions", lifecycle.RunnerFunc(func(ctx context.Context) error {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+
+			defer signal.Stop(hup)
+
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-hup:
+					if err := assertions.LoadFile(assertionsPath); err != nil {
+						log.Warn().Err(err).Msg("Keeping the previous binding assertions")
+						continue
+					}
+
+					log.Info().Int("assertions", len(assertions.List())).Msg("Binding assertions reloaded")
+				}
+			}
+		}))
+	}
+
+	if hasDebugSocket {
+		g.Add("debug", debugserver.NewServer(debugSocket, debugserver.WithServices(svc),
+			debugserver.WithEventLog(events)))
+	}
+
+	g.Add("netmon", lifecycle.RunnerFunc(func(ctx context.Context) error {
+		return svc.Start(ctx, resultC)
+	}))
+	// the leases of a dhcpd on the host are opt-in, they are ingested as
+	// observations of the bindings
+	if leasesPath, ok := os.LookupEnv("NETMON_DHCPD_LEASES"); ok {
+		g.Add("dhcpd-leases", lifecycle.RunnerFunc(func(ctx context.Context) error {
+			return leasefile.NewFollower(leasesPath, svc, leasefile.WithResults(func(res netmon.Result) {
+				select {
+				case resultC <- res:
+				case <-ctx.Done():
+				}
+			})).Run(ctx)
+		}))
+	}
+
+	g.Add("reconciler", netmon.NewReconciler(inv, []*netmon.Service{svc},
+		netmon.WithReconcileReports(func(rep netmon.ReconcileReport) {
+			log.Info().
+				Str("interface", rep.Interface).
+				Int("kernel_only", len(rep.KernelOnly)).
+				Int("observed_only", len(rep.ObservedOnly)).
+				Int("mismatched", len(rep.Mismatched)).
+				Msg("Kernel neighbor cache differs from the observed bindings")
+		}),
+	))
 
 	log.Info().Msg("Service netmon started")
 
-	if err := g.Wait(); err != nil {
+	if err := g.Run(ctx); err != nil {
 		log.Error().Err(err).Send()
 		return 1
 	}
@@ -98,6 +284,151 @@ func Run() int {
 	return 0
 }
 
+// newAlerts returns the Router of the alerts and the Webhook posting them
+// to webhookURL, signed with NETMON_ALERT_SECRET. NETMON_ALERT_SEVERITIES is
+// a JSON object overriding the severity of events, such as
+// {"MOVED": "critical"}.
+func newAlerts(webhookURL string) (*alert.Router, *alert.Webhook, error) {
+	var severities map[netmon.Event]alert.Severity
+
+	if s, ok := os.LookupEnv("NETMON_ALERT_SEVERITIES"); ok {
+		if err := json.Unmarshal([]byte(s), &severities); err != nil {
+			return nil, nil, fmt.Errorf("invalid NETMON_ALERT_SEVERITIES: %w", err)
+		}
+	}
+
+	webhook, err := alert.NewWebhook(webhookURL, alert.WithSecret([]byte(os.Getenv("NETMON_ALERT_SECRET"))))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the webhook queues the alerts itself, Deliver never blocks
+	router := alert.NewRouter(alert.WithSeverities(severities))
+	if err := router.Register("webhook", webhook); err != nil {
+		return nil, nil, err
+	}
+
+	return router, webhook, nil
+}
+
+// newUploader returns the Uploader of the observations to the region API
+// at regionURL, and the journal it keeps them in until the region has them,
+// at NETMON_UPLOAD_JOURNAL
+func newUploader(regionURL string) (*upload.Uploader, *journal.Journal, error) {
+	base, err := url.Parse(regionURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid NETMON_UPLOAD_URL: %w", err)
+	}
+
+	journalPath, ok := os.LookupEnv("NETMON_UPLOAD_JOURNAL")
+	if !ok {
+		return nil, nil, errors.New("NETMON_UPLOAD_URL needs NETMON_UPLOAD_JOURNAL")
+	}
+
+	j, err := journal.Open(journalPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := apiclient.NewAPIClient(base, &http.Client{Timeout: uploadTimeout})
+
+	u, err := upload.NewUploader(j, upload.NewHTTPTransport(client, "/netmon"), schema.NameObservation)
+	if err != nil {
+		//nolint:errcheck,gosec // we already return a more important error
+		j.Close()
+
+		return nil, nil, err
+	}
+
+	return u, j, nil
+}
+
+// emit writes res as a line of JSON to w. With a journal, the line is
+// appended to it first and acknowledged once written.
+func emit(w io.Writer, j *journal.Journal, res schema.Observation) error {
+	line, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	if j == nil {
+		_, err = w.Write(line)
+		return err
+	}
+
+	// a result the journal can't keep is still written out
+	seq, journalErr := j.Append(line)
+	if journalErr != nil {
+		log.Warn().Err(journalErr).Msg("Result not journaled")
+	}
+
+	if _, err := w.Write(line); err != nil {
+		return err
+	}
+
+	if journalErr != nil {
+		return nil
+	}
+
+	return j.Ack(seq)
+}
+
+// replay writes to w the results a previous run journaled but didn't write
+// out, and acknowledges them
+func replay(w io.Writer, j *journal.Journal) error {
+	if j == nil {
+		return nil
+	}
+
+	records, err := j.Replay()
+	if err != nil {
+		return err
+	}
+
+	if len(records) > 0 {
+		log.Info().Int("results", len(records)).Msg("Replaying the journaled results")
+	}
+
+	for _, r := range records {
+		if _, err := w.Write(r.Data); err != nil {
+			return err
+		}
+
+		if err := j.Ack(r.Seq); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selfTest checks frames sent on each interface are seen by the capture
+// path, and fails unless all of them are
+func selfTest(ctx context.Context, ifaces []string) int {
+	if len(ifaces) == 0 {
+		log.Error().Msg("Please provide the interfaces to test")
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	code := 0
+
+	for _, res := range capture.SelfTestAll(ctx, ifaces) {
+		if err := encoder.Encode(res); err != nil {
+			log.Error().Err(err).Send()
+			return 1
+		}
+
+		if !res.Received {
+			code = 1
+		}
+	}
+
+	return code
+}
+
 func main() {
 	os.Exit(Run())
 }
@@ -0,0 +1,127 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/discovery"
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+// timeRangeFlags adds the -from and -to flags, RFC 3339 times bounding the
+// frames of a capture file read, to flags
+func timeRangeFlags(flags *flag.FlagSet) (from, to *time.Time) {
+	from, to = new(time.Time), new(time.Time)
+
+	parse := func(t *time.Time) func(string) error {
+		return func(s string) (err error) {
+			*t, err = time.Parse(time.RFC3339Nano, s)
+			return err
+		}
+	}
+
+	flags.Func("from", "only read the frames captured from this RFC 3339 time", parse(from))
+	flags.Func("to", "only read the frames captured before this RFC 3339 time", parse(to))
+
+	return from, to
+}
+
+// replayCapture runs the detectors of netmon over the frames of a pcap or
+// pcapng file, as if captured on an interface, and prints the results
+func replayCapture(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+
+	from, to := timeRangeFlags(flags)
+	iface := flags.String("interface", "replay", "name of the interface the frames were captured on")
+	streaming := flags.Bool("streaming", false, "read the file rather than map it in memory")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		log.Error().Msg("Please provide the capture file to replay")
+		return 2
+	}
+
+	var options []capture.PcapFileOption
+	if *streaming {
+		options = append(options, capture.WithPcapStreaming())
+	}
+
+	f, err := capture.OpenPcapFile(flags.Arg(0), *iface, options...)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return 1
+	}
+
+	defer f.Close() //nolint:errcheck // the file is only read
+
+	if !from.IsZero() || !to.IsZero() {
+		if err := f.SetTimeRange(*from, *to); err != nil {
+			log.Error().Err(err).Send()
+			return 1
+		}
+	}
+
+	log.Info().Str("file", flags.Arg(0)).Bool("mapped", f.Mapped()).Msg("Replaying capture file")
+
+	resultC := make(chan netmon.Result)
+	errC := make(chan error, 1)
+
+	go func() { errC <- netmon.NewService(*iface).Serve(ctx, f, resultC) }()
+
+	for res := range resultC {
+		if err := emit(os.Stdout, nil, res); err != nil {
+			log.Error().Err(err).Send()
+			return 1
+		}
+	}
+
+	if err := <-errC; err != nil && !errors.Is(err, context.Canceled) {
+		log.Error().Err(err).Send()
+		return 1
+	}
+
+	return 0
+}
+
+// dissect prints what the decoders find in the frames of a pcap or pcapng
+// file, a JSON object per frame
+func dissect(args []string) int {
+	flags := flag.NewFlagSet("dissect", flag.ContinueOnError)
+
+	from, to := timeRangeFlags(flags)
+	first := flags.Int("first", 0, "number of the first record dissected, from 0")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		log.Error().Msg("Please provide the capture file to dissect")
+		return 2
+	}
+
+	err := discovery.DissectPCAP(flags.Arg(0), os.Stdout,
+		discovery.WithDissectTimeRange(*from, *to), discovery.WithDissectFirstRecord(*first))
+	if err != nil {
+		log.Error().Err(err).Send()
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package addrutil validates and normalizes the MAC and IP addresses the
+// exported APIs are given by the configuration or the region controller,
+// so that a malformed one is rejected with the field it was given in rather
+// than failing further down: an EUI-64 used as the key of an ethernet
+// address, or an IPv4-mapped IPv6 address never matching the IPv4 address
+// of a packet.
+package addrutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// mappedBits are the bits of the prefix of the IPv4-mapped IPv6 addresses,
+// ::ffff:0:0/96
+const mappedBits = 96
+
+var (
+	// ErrInvalidMAC is returned for a MAC which isn't an ethernet address,
+	// or isn't one a host can have
+	ErrInvalidMAC = errors.New("invalid MAC address")
+	// ErrInvalidIP is returned for an IP which is the zero netip.Addr, or
+	// isn't one a host can have
+	ErrInvalidIP = errors.New("invalid IP address")
+	// ErrInvalidPrefix is returned for a prefix which is the zero
+	// netip.Prefix, or of IPv4-mapped addresses and others
+	ErrInvalidPrefix = errors.New("invalid prefix")
+)
+
+// FieldError is an invalid address given in a field of an API, it matches
+// the error of the address, such as ErrInvalidMAC
+type FieldError struct {
+	Err   error
+	Field string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Index returns the field of the i-th element of the list field, such as
+// "targets[2]"
+func Index(field string, i int) string {
+	return field + "[" + strconv.Itoa(i) + "]"
+}
+
+func fieldError(field string, err error) error {
+	return &FieldError{Field: field, Err: err}
+}
+
+// MAC returns a copy of mac if it is an ethernet address, of 6 bytes
+func MAC(field string, mac net.HardwareAddr) (net.HardwareAddr, error) {
+	if len(mac) != 6 {
+		return nil, fieldError(field, fmt.Errorf("%w %q: %d bytes, ethernet addresses have 6", ErrInvalidMAC,
+			mac, len(mac)))
+	}
+
+	return bytes.Clone(mac), nil
+}
+
+// UnicastMAC returns a copy of mac if it is the ethernet address of a host,
+// neither a group address nor all zeros
+func UnicastMAC(field string, mac net.HardwareAddr) (net.HardwareAddr, error) {
+	mac, err := MAC(field, mac)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case mac[0]&0x01 != 0:
+		return nil, fieldError(field, fmt.Errorf("%w %s: group address", ErrInvalidMAC, mac))
+	case bytes.Equal(mac, make(net.HardwareAddr, 6)):
+		return nil, fieldError(field, fmt.Errorf("%w %s: all zeros", ErrInvalidMAC, mac))
+	}
+
+	return mac, nil
+}
+
+// ParseMAC parses s as a MAC and returns it if it is an ethernet address,
+// net.ParseMAC accepts the longer EUI-64 and InfiniBand addresses too
+func ParseMAC(field, s string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, fieldError(field, fmt.Errorf("%w: %w", ErrInvalidMAC, err))
+	}
+
+	return MAC(field, mac)
+}
+
+// IP returns ip in its normal form, an IPv4-mapped IPv6 address as the
+// IPv4 address it maps, if it is set
+func IP(field string, ip netip.Addr) (netip.Addr, error) {
+	if !ip.IsValid() {
+		return netip.Addr{}, fieldError(field, fmt.Errorf("%w: missing", ErrInvalidIP))
+	}
+
+	return ip.Unmap(), nil
+}
+
+// UnicastIP returns ip in its normal form, as IP does, if it can be the
+// address of a host: neither unspecified, multicast nor the IPv4 limited
+// broadcast address
+func UnicastIP(field string, ip netip.Addr) (netip.Addr, error) {
+	ip, err := IP(field, ip)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	switch {
+	case ip.IsUnspecified():
+		return netip.Addr{}, fieldError(field, fmt.Errorf("%w %s: unspecified", ErrInvalidIP, ip))
+	case ip.IsMulticast():
+		return netip.Addr{}, fieldError(field, fmt.Errorf("%w %s: multicast", ErrInvalidIP, ip))
+	case ip == netip.AddrFrom4([4]byte{0xff, 0xff, 0xff, 0xff}):
+		return netip.Addr{}, fieldError(field, fmt.Errorf("%w %s: broadcast", ErrInvalidIP, ip))
+	}
+
+	return ip, nil
+}
+
+// ParseUnicastIP parses s as an IP and returns it as UnicastIP does
+func ParseUnicastIP(field, s string) (netip.Addr, error) {
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fieldError(field, fmt.Errorf("%w: %w", ErrInvalidIP, err))
+	}
+
+	return UnicastIP(field, ip)
+}
+
+// Prefix returns p in its normal form if it is set: a prefix of
+// IPv4-mapped IPv6 addresses is the prefix of the IPv4 addresses they map,
+// one mixing them with other IPv6 addresses is rejected
+func Prefix(field string, p netip.Prefix) (netip.Prefix, error) {
+	if !p.IsValid() {
+		return netip.Prefix{}, fieldError(field, fmt.Errorf("%w: missing", ErrInvalidPrefix))
+	}
+
+	if !p.Addr().Is4In6() {
+		return p, nil
+	}
+
+	if p.Bits() < mappedBits {
+		return netip.Prefix{}, fieldError(field, fmt.Errorf("%w %s: IPv4-mapped and IPv6 addresses",
+			ErrInvalidPrefix, p))
+	}
+
+	return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-mappedBits), nil
+}
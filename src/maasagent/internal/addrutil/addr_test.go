@@ -0,0 +1,258 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package addrutil
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertFieldError checks err is the error of field matching target
+func assertFieldError(t *testing.T, err error, field string, target error) {
+	t.Helper()
+
+	require.ErrorIs(t, err, target)
+
+	var fe *FieldError
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, field, fe.Field)
+	assert.Contains(t, err.Error(), field+": ")
+}
+
+func TestMAC(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in      net.HardwareAddr
+		unicast bool
+		err     bool
+	}{
+		"unicast": {
+			in:      net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01},
+			unicast: true,
+		},
+		"group address": {
+			in: net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0x01},
+		},
+		"broadcast": {
+			in: net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		},
+		"all zeros": {
+			in: net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		"missing": {
+			err: true,
+		},
+		"7 bytes": {
+			in:  net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01, 0x02},
+			err: true,
+		},
+		"EUI-64": {
+			in:  net.HardwareAddr{0x00, 0x16, 0x3e, 0xff, 0xfe, 0x00, 0x00, 0x01},
+			err: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			mac, err := MAC("mac", tc.in)
+			if tc.err {
+				assertFieldError(t, err, "mac", ErrInvalidMAC)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.in, mac)
+			}
+
+			mac, err = UnicastMAC("mac", tc.in)
+			if !tc.unicast {
+				assertFieldError(t, err, "mac", ErrInvalidMAC)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.in, mac)
+
+			// the MAC returned is a copy
+			mac[5] = 0xff
+			assert.NotEqual(t, tc.in, mac)
+		})
+	}
+}
+
+func TestParseMAC(t *testing.T) {
+	t.Parallel()
+
+	mac, err := ParseMAC("mac", "00-16-3E-00-00-01")
+	require.NoError(t, err)
+	assert.Equal(t, net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}, mac)
+
+	for _, s := range []string{"", "00:16:3e", "00:16:3e:ff:fe:00:00:01",
+		"00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01"} {
+		_, err := ParseMAC("assertions[1].mac", s)
+		assertFieldError(t, err, "assertions[1].mac", ErrInvalidMAC)
+	}
+}
+
+func TestIP(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in      netip.Addr
+		out     netip.Addr
+		unicast bool
+		err     bool
+	}{
+		"IPv4": {
+			in:      netip.MustParseAddr("10.0.0.1"),
+			out:     netip.MustParseAddr("10.0.0.1"),
+			unicast: true,
+		},
+		"IPv6": {
+			in:      netip.MustParseAddr("fe80::1%eth0"),
+			out:     netip.MustParseAddr("fe80::1%eth0"),
+			unicast: true,
+		},
+		"IPv4-mapped": {
+			in:      netip.MustParseAddr("::ffff:10.0.0.1"),
+			out:     netip.MustParseAddr("10.0.0.1"),
+			unicast: true,
+		},
+		"unspecified IPv4": {
+			in:  netip.IPv4Unspecified(),
+			out: netip.IPv4Unspecified(),
+		},
+		"unspecified IPv6": {
+			in:  netip.IPv6Unspecified(),
+			out: netip.IPv6Unspecified(),
+		},
+		"unspecified IPv4-mapped": {
+			in:  netip.MustParseAddr("::ffff:0.0.0.0"),
+			out: netip.IPv4Unspecified(),
+		},
+		"multicast IPv4": {
+			in:  netip.MustParseAddr("224.0.0.251"),
+			out: netip.MustParseAddr("224.0.0.251"),
+		},
+		"multicast IPv6": {
+			in:  netip.MustParseAddr("ff02::1"),
+			out: netip.MustParseAddr("ff02::1"),
+		},
+		"broadcast": {
+			in:  netip.MustParseAddr("255.255.255.255"),
+			out: netip.MustParseAddr("255.255.255.255"),
+		},
+		"missing": {
+			err: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ip, err := IP("ip", tc.in)
+			if tc.err {
+				assertFieldError(t, err, "ip", ErrInvalidIP)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.out, ip)
+			}
+
+			ip, err = UnicastIP("ip", tc.in)
+			if !tc.unicast {
+				assertFieldError(t, err, "ip", ErrInvalidIP)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, ip)
+		})
+	}
+}
+
+func TestParseUnicastIP(t *testing.T) {
+	t.Parallel()
+
+	ip, err := ParseUnicastIP("ip", "::ffff:10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), ip)
+
+	for _, s := range []string{"", "10.0.0", "::", "ff02::1"} {
+		_, err := ParseUnicastIP("ip", s)
+		assertFieldError(t, err, "ip", ErrInvalidIP)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  netip.Prefix
+		out netip.Prefix
+		err bool
+	}{
+		"IPv4": {
+			in:  netip.MustParsePrefix("10.0.0.0/24"),
+			out: netip.MustParsePrefix("10.0.0.0/24"),
+		},
+		"IPv6": {
+			in:  netip.MustParsePrefix("2001:db8::/64"),
+			out: netip.MustParsePrefix("2001:db8::/64"),
+		},
+		"IPv4-mapped": {
+			in:  netip.MustParsePrefix("::ffff:10.0.0.0/120"),
+			out: netip.MustParsePrefix("10.0.0.0/24"),
+		},
+		"every IPv4-mapped": {
+			in:  netip.MustParsePrefix("::ffff:0.0.0.0/96"),
+			out: netip.MustParsePrefix("0.0.0.0/0"),
+		},
+		"IPv4-mapped and IPv6": {
+			in:  netip.MustParsePrefix("::ffff:0.0.0.0/80"),
+			err: true,
+		},
+		"missing": {
+			err: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := Prefix(Index("targets", 2), tc.in)
+			if tc.err {
+				assertFieldError(t, err, "targets[2]", ErrInvalidPrefix)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, p)
+		})
+	}
+}
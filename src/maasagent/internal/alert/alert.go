@@ -0,0 +1,176 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package alert pushes the high-severity observations of netmon, such as
+// binding violations and address conflicts, to sinks registered with a
+// Router: callbacks of the agent or an HTTP webhook. Each sink has its own
+// bounded queue, a sink falling behind loses alerts rather than stall the
+// capture loops.
+package alert
+
+import (
+	"context"
+	"maps"
+
+	"go.opentelemetry.io/otel/metric"
+
+	"maas.io/core/src/maasagent/internal/dispatch"
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+var (
+	// ErrInvalidSeverity is returned when parsing an unknown severity
+	ErrInvalidSeverity = netmon.ErrInvalidSeverity
+)
+
+// Severity is how urgently an event needs an operator, see netmon.Severity
+type Severity = netmon.Severity
+
+const (
+	// SeverityDebug is for the routine events, such as a binding refreshed
+	SeverityDebug = netmon.SeverityDebug
+	// SeverityInfo is for the events of the normal life of a network
+	SeverityInfo = netmon.SeverityInfo
+	// SeverityWarning is for the events which may need an operator
+	SeverityWarning = netmon.SeverityWarning
+	// SeverityCritical is for the events an operator must act upon
+	SeverityCritical = netmon.SeverityCritical
+)
+
+// DefaultSeverities returns the Severity of each netmon Event when the
+// Router isn't told otherwise, see netmon.DefaultSeverities. The events the
+// Router doesn't know of are SeverityInfo.
+func DefaultSeverities() map[netmon.Event]Severity {
+	return netmon.DefaultSeverities()
+}
+
+// Alert is a netmon Result worth pushing to an operator, its JSON is
+// described by Schema
+type Alert struct {
+	// Interface is the name of the interface the Result was observed on
+	Interface string `json:"interface"`
+	netmon.Result
+	Severity Severity `json:"severity"`
+}
+
+// Sink receives the alerts of a Router, one at a time
+type Sink interface {
+	Deliver(a Alert)
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(a Alert)
+
+// Deliver calls f with a
+func (f SinkFunc) Deliver(a Alert) {
+	f(a)
+}
+
+// Router classifies the Results observed and delivers those of a high
+// enough Severity to its sinks
+type Router struct {
+	severities map[netmon.Event]Severity
+	sinks      *dispatch.Dispatcher[Alert]
+	min        Severity
+}
+
+type routerConfig struct {
+	severities map[netmon.Event]Severity
+	meter      metric.Meter
+	min        Severity
+}
+
+// RouterOption configures a Router
+type RouterOption func(*routerConfig)
+
+// WithSeverities overrides the Severity of the events of severities, the
+// others keep their default
+func WithSeverities(severities map[netmon.Event]Severity) RouterOption {
+	return func(c *routerConfig) {
+		maps.Copy(c.severities, severities)
+	}
+}
+
+// WithMinSeverity sets the Severity from which the events are delivered,
+// SeverityWarning by default
+func WithMinSeverity(s Severity) RouterOption {
+	return func(c *routerConfig) {
+		if s.Valid() {
+			c.min = s
+		}
+	}
+}
+
+// WithMetricMeter sets the OpenTelemetry meter collecting the alerts each
+// sink was delivered and lost
+func WithMetricMeter(meter metric.Meter) RouterOption {
+	return func(c *routerConfig) {
+		c.meter = meter
+	}
+}
+
+// NewRouter returns a Router without sinks
+func NewRouter(options ...RouterOption) *Router {
+	cfg := routerConfig{severities: DefaultSeverities(), min: SeverityWarning}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	var dispatchOpts []dispatch.DispatcherOption
+	if cfg.meter != nil {
+		dispatchOpts = append(dispatchOpts, dispatch.WithMetricMeter(cfg.meter))
+	}
+
+	return &Router{
+		severities: cfg.severities,
+		sinks:      dispatch.NewDispatcher[Alert](dispatchOpts...),
+		min:        cfg.min,
+	}
+}
+
+// Register adds a sink the alerts are delivered to once the Router runs,
+// it must be called before Run. The queue of the sink drops the newest
+// alerts when full, unless options say otherwise.
+func (r *Router) Register(name string, s Sink, options ...dispatch.SubscriberOption) error {
+	return r.sinks.Subscribe(name, s.Deliver, options...)
+}
+
+// Severity returns the Severity of the event
+func (r *Router) Severity(event netmon.Event) Severity {
+	return netmon.SeverityOf(r.severities, event)
+}
+
+// Handle queues res, observed on iface, for the sinks when its Severity is
+// high enough. It never waits for the sinks, unless one was registered
+// with the dispatch.BlockWithTimeout policy.
+func (r *Router) Handle(iface string, res netmon.Result) {
+	severity := r.Severity(res.Event)
+	if severity < r.min {
+		return
+	}
+
+	r.sinks.Publish(Alert{Interface: iface, Result: res, Severity: severity})
+}
+
+// Stats returns the queue counters of every sink
+func (r *Router) Stats() []dispatch.QueueStats {
+	return r.sinks.Stats()
+}
+
+// Run delivers the queued alerts to the sinks until ctx is done
+func (r *Router) Run(ctx context.Context) error {
+	return r.sinks.Run(ctx)
+}
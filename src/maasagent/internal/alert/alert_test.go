@@ -0,0 +1,252 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/dispatch"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+func start(t *testing.T, r *Router) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- r.Run(ctx) }()
+
+	return func() {
+		cancel()
+		assert.NoError(t, <-errC)
+	}
+}
+
+func TestSeveritiesJSON(t *testing.T) {
+	t.Parallel()
+
+	var severities map[netmon.Event]Severity
+
+	require.NoError(t, json.Unmarshal([]byte(`{"MOVED": "critical", "NEW": "warning"}`), &severities))
+	assert.Equal(t, map[netmon.Event]Severity{
+		netmon.EventMoved: SeverityCritical,
+		netmon.EventNew:   SeverityWarning,
+	}, severities)
+
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"MOVED": "fatal"}`), &severities), ErrInvalidSeverity)
+}
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		options []RouterOption
+		in      []netmon.Event
+		out     []Alert
+	}{
+		"defaults": {
+			in: []netmon.Event{netmon.EventNew, netmon.EventMoved, netmon.EventRefreshed, netmon.EventBindingViolation},
+			out: []Alert{
+				{Interface: "eth0", Result: netmon.Result{Event: netmon.EventMoved}, Severity: SeverityWarning},
+				{Interface: "eth0", Result: netmon.Result{Event: netmon.EventBindingViolation}, Severity: SeverityCritical},
+			},
+		},
+		"critical only": {
+			options: []RouterOption{WithMinSeverity(SeverityCritical)},
+			in:      []netmon.Event{netmon.EventMoved, netmon.EventDADConflict},
+			out: []Alert{
+				{Interface: "eth0", Result: netmon.Result{Event: netmon.EventDADConflict}, Severity: SeverityCritical},
+			},
+		},
+		"overridden": {
+			options: []RouterOption{WithSeverities(map[netmon.Event]Severity{
+				netmon.EventNew:   SeverityCritical,
+				netmon.EventMoved: SeverityInfo,
+			})},
+			in: []netmon.Event{netmon.EventNew, netmon.EventMoved, netmon.EventDuplicateMACLocation},
+			out: []Alert{
+				{Interface: "eth0", Result: netmon.Result{Event: netmon.EventNew}, Severity: SeverityCritical},
+				{Interface: "eth0", Result: netmon.Result{Event: netmon.EventDuplicateMACLocation},
+					Severity: SeverityWarning},
+			},
+		},
+		"unknown event": {
+			in: []netmon.Event{netmon.Event(0xff)},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(tc.options...)
+			alerts := make(chan Alert, len(tc.in))
+
+			require.NoError(t, r.Register("test", SinkFunc(func(a Alert) { alerts <- a })))
+
+			stop := start(t, r)
+			defer stop()
+
+			for _, event := range tc.in {
+				r.Handle("eth0", netmon.Result{Event: event})
+			}
+
+			var out []Alert
+
+			for range tc.out {
+				out = append(out, <-alerts)
+			}
+
+			assert.Equal(t, tc.out, out)
+			assert.Empty(t, alerts)
+		})
+	}
+}
+
+func TestRouterSlowSink(t *testing.T) {
+	defer leak.Check(t)()
+
+	r := NewRouter()
+	release := make(chan struct{})
+	delivered := make(chan Alert, 1)
+
+	require.NoError(t, r.Register("stuck", SinkFunc(func(a Alert) {
+		delivered <- a
+		<-release
+	}), dispatch.WithQueueSize(1)))
+
+	stop := start(t, r)
+	defer stop()
+
+	r.Handle("eth0", netmon.Result{Event: netmon.EventMoved})
+	<-delivered
+
+	// the stuck sink loses alerts rather than block the Router
+	done := make(chan struct{})
+
+	go func() {
+		for range 10 {
+			r.Handle("eth0", netmon.Result{Event: netmon.EventMoved})
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Handle blocked on a stuck sink")
+	}
+
+	assert.Equal(t, []dispatch.QueueStats{{
+		Name:     "stuck",
+		Policy:   "drop-newest",
+		Depth:    1,
+		Capacity: 1,
+		Dropped:  9,
+	}}, r.Stats())
+
+	close(release)
+	<-delivered
+}
+
+func TestSchema(t *testing.T) {
+	t.Parallel()
+
+	var schema struct {
+		Properties map[string]struct {
+			Enum []string `json:"enum"`
+		} `json:"properties"`
+		Required []string `json:"required"`
+	}
+
+	require.NoError(t, json.Unmarshal(Schema, &schema))
+
+	// the enums list every Event and Severity
+	var events []string
+
+	for e := netmon.Event(1); e.String() != "UNKNOWN"; e++ {
+		events = append(events, e.String())
+	}
+
+	assert.ElementsMatch(t, events, schema.Properties["event"].Enum)
+
+	var severities []string
+
+	for s := SeverityDebug; s.Valid(); s++ {
+		severities = append(severities, s.String())
+	}
+
+	assert.ElementsMatch(t, severities, schema.Properties["severity"].Enum)
+
+	// the properties are those of an Alert with every field set
+	vid := uint16(12)
+	b, err := json.Marshal(Alert{
+		Interface: "eth0",
+		Result: netmon.Result{
+			VID:         &vid,
+			Duplicate:   &netmon.DuplicateMACLocation{},
+			Evidence:    &netmon.ResultEvidence{},
+			Violation:   &netmon.BindingViolation{},
+			DAD:         &netmon.DADConflict{},
+			PortAuth:    &netmon.PortAuthFinding{},
+			Ingress:     &netmon.Ingress{Port: "eth1", Attributed: true},
+			Responder:   &netmon.MappingStatus{},
+			Critical:    &netmon.CriticalHostStatus{},
+			SelfAddress: &netmon.SelfAddressStatus{},
+			Upstream:    &netmon.UpstreamChange{},
+			NativeVLAN:  &netmon.NativeVLANMismatch{},
+			Mode:        &netmon.ModeChange{},
+			Layer:       testLayer{},
+			IP:          "10.0.0.1",
+			MAC:         "52:54:00:00:00:01",
+			PreviousMAC: "52:54:00:00:00:02",
+			Tags:        []ethernet.Tag{{VID: 100}, {VID: 12}},
+			Labels:      map[string]string{"fabric": "fabric-0"},
+			Time:        1700000000,
+			Event:       netmon.EventMoved,
+			Untagged:    true,
+		},
+		Severity: SeverityWarning,
+	})
+	require.NoError(t, err)
+
+	var fields map[string]json.RawMessage
+
+	require.NoError(t, json.Unmarshal(b, &fields))
+
+	keys := slices.Collect(maps.Keys(fields))
+
+	assert.ElementsMatch(t, slices.Collect(maps.Keys(schema.Properties)), keys)
+	assert.Subset(t, keys, schema.Required)
+}
+
+type testLayer struct{}
+
+func (testLayer) LayerName() string { return "test" }
@@ -0,0 +1,368 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/schema"
+)
+
+const (
+	defaultWebhookQueueSize = 128
+	defaultWebhookAttempts  = 5
+	defaultWebhookBackoff   = time.Second
+	defaultWebhookTimeout   = 10 * time.Second
+	maxWebhookBackoff       = time.Minute
+)
+
+const (
+	// SignatureHeader holds the HMAC-SHA256 of the body of a webhook
+	// request, as sha256=<hex>, when the Webhook has a secret
+	SignatureHeader = "X-MAAS-Signature"
+	// EventHeader holds the netmon Event of the Alert of the request
+	EventHeader = "X-MAAS-Event"
+)
+
+var (
+	// ErrInvalidWebhook is returned for a webhook URL which isn't HTTP
+	ErrInvalidWebhook = errors.New("invalid webhook URL")
+	// ErrWebhookRejected is returned when the webhook refuses an alert for
+	// good, trying again won't help
+	ErrWebhookRejected = errors.New("webhook rejected the alert")
+)
+
+// Schema is the JSON schema of the alerts posted by a Webhook
+//
+//go:embed schema.json
+var Schema []byte
+
+// WebhookStats are the delivery counters of a Webhook
+type WebhookStats struct {
+	// Queued is the number of alerts waiting to be posted
+	Queued int `json:"queued"`
+	// Delivered is the number of alerts the webhook accepted
+	Delivered uint64 `json:"delivered"`
+	// Failed is the number of alerts given up on after the retries, or
+	// rejected
+	Failed uint64 `json:"failed"`
+	// Dropped is the number of alerts discarded because the queue was full
+	Dropped uint64 `json:"dropped"`
+	// Retried is the number of posts tried again
+	Retried uint64 `json:"retried"`
+}
+
+// Webhook is a Sink posting the alerts as JSON to a URL. Deliver only
+// queues them, Run posts them one at a time and retries those which fail
+// with an exponential backoff.
+type Webhook struct {
+	clock      clock.Clock
+	meter      metric.Meter
+	client     *http.Client
+	queue      chan Alert
+	url        string
+	secret     []byte
+	backoff    time.Duration
+	attempts   int
+	delivered  atomic.Uint64
+	failed     atomic.Uint64
+	dropped    atomic.Uint64
+	retried    atomic.Uint64
+	queueSize  int
+	maxBackoff time.Duration
+}
+
+// WebhookOption configures a Webhook
+type WebhookOption func(*Webhook)
+
+// WithHTTPClient sets the client posting the alerts, one timing out after
+// 10 seconds by default
+func WithHTTPClient(c *http.Client) WebhookOption {
+	return func(w *Webhook) {
+		if c != nil {
+			w.client = c
+		}
+	}
+}
+
+// WithSecret signs the body of every request with secret, see
+// SignatureHeader
+func WithSecret(secret []byte) WebhookOption {
+	return func(w *Webhook) {
+		w.secret = secret
+	}
+}
+
+// WithQueueSize sets the number of alerts waiting to be posted before
+// Deliver drops the newest ones
+func WithQueueSize(n int) WebhookOption {
+	return func(w *Webhook) {
+		if n > 0 {
+			w.queueSize = n
+		}
+	}
+}
+
+// WithAttempts sets the number of times an alert is posted before being
+// given up on
+func WithAttempts(n int) WebhookOption {
+	return func(w *Webhook) {
+		if n > 0 {
+			w.attempts = n
+		}
+	}
+}
+
+// WithBackoff sets the time before the first retry, each of the next ones
+// waits twice as long as the previous one, up to maxBackoff
+func WithBackoff(d, maxBackoff time.Duration) WebhookOption {
+	return func(w *Webhook) {
+		if d > 0 {
+			w.backoff = d
+		}
+
+		if maxBackoff > 0 {
+			w.maxBackoff = maxBackoff
+		}
+	}
+}
+
+// WithWebhookClock sets the clock timing the retries
+func WithWebhookClock(c clock.Clock) WebhookOption {
+	return func(w *Webhook) {
+		w.clock = c
+	}
+}
+
+// WithWebhookMeter sets the OpenTelemetry meter collecting the delivery
+// counters and the depth of the queue
+func WithWebhookMeter(meter metric.Meter) WebhookOption {
+	return func(w *Webhook) {
+		w.meter = meter
+	}
+}
+
+// NewWebhook returns a Webhook posting to rawURL, an http or https one
+func NewWebhook(rawURL string, options ...WebhookOption) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidWebhook, err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidWebhook, rawURL)
+	}
+
+	w := &Webhook{
+		clock:      clock.System{},
+		client:     &http.Client{Timeout: defaultWebhookTimeout},
+		url:        u.String(),
+		backoff:    defaultWebhookBackoff,
+		maxBackoff: maxWebhookBackoff,
+		attempts:   defaultWebhookAttempts,
+		queueSize:  defaultWebhookQueueSize,
+	}
+
+	for _, opt := range options {
+		opt(w)
+	}
+
+	w.queue = make(chan Alert, w.queueSize)
+
+	if w.meter != nil {
+		w.registerMetrics(w.meter)
+	}
+
+	return w, nil
+}
+
+func (w *Webhook) registerMetrics(meter metric.Meter) {
+	delivered := attribute.String("type", "delivered")
+	failed := attribute.String("type", "failed")
+	dropped := attribute.String("type", "dropped")
+	retried := attribute.String("type", "retried")
+
+	must(meter.Int64ObservableCounter("alert.webhook.alerts",
+		metric.WithUnit("{count}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			st := w.Stats()
+			o.Observe(int64(st.Delivered), metric.WithAttributes(delivered)) //nolint:gosec // counters fit
+			o.Observe(int64(st.Failed), metric.WithAttributes(failed))       //nolint:gosec // counters fit
+			o.Observe(int64(st.Dropped), metric.WithAttributes(dropped))     //nolint:gosec // counters fit
+			o.Observe(int64(st.Retried), metric.WithAttributes(retried))     //nolint:gosec // counters fit
+
+			return nil
+		})))
+
+	must(meter.Int64ObservableGauge("alert.webhook.queue.depth",
+		metric.WithUnit("{count}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(len(w.queue)))
+
+			return nil
+		})))
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// Deliver queues a to be posted, or drops it when the queue is full
+func (w *Webhook) Deliver(a Alert) {
+	select {
+	case w.queue <- a:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Stats returns the delivery counters of the Webhook
+func (w *Webhook) Stats() WebhookStats {
+	return WebhookStats{
+		Queued:    len(w.queue),
+		Delivered: w.delivered.Load(),
+		Failed:    w.failed.Load(),
+		Dropped:   w.dropped.Load(),
+		Retried:   w.retried.Load(),
+	}
+}
+
+// Run posts the queued alerts until ctx is done, the alerts still queued
+// then are discarded
+func (w *Webhook) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case a := <-w.queue:
+			w.send(ctx, a)
+		}
+	}
+}
+
+// send posts a, retrying until it is accepted, the attempts are spent or
+// ctx is done
+func (w *Webhook) send(ctx context.Context, a Alert) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		w.failed.Add(1)
+		log.Warn().Err(err).Str("event", a.Event.String()).Msg("Alert can't be encoded")
+
+		return
+	}
+
+	backoff := w.backoff
+
+	for attempt := 1; ; attempt++ {
+		err = w.post(ctx, a, body)
+		if err == nil {
+			w.delivered.Add(1)
+			return
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, ErrWebhookRejected) || attempt == w.attempts {
+			w.failed.Add(1)
+			log.Warn().Err(err).Str("event", a.Event.String()).Int("attempts", attempt).
+				Msg("Alert not delivered to the webhook")
+
+			return
+		}
+
+		w.retried.Add(1)
+
+		t := w.clock.NewTimer(backoff)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C():
+		}
+
+		backoff = min(2*backoff, w.maxBackoff)
+	}
+}
+
+// post sends body, the encoding of a, once
+func (w *Webhook) post(ctx context.Context, a Alert, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWebhookRejected, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(schema.Header, strconv.Itoa(schema.Version))
+	req.Header.Set(EventHeader, a.Event.String())
+
+	if len(w.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// the body is drained so that the connection is reused
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck,gosec // the status is all that matters
+	resp.Body.Close()              //nolint:errcheck,gosec // nothing was written
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= 500:
+		return fmt.Errorf("webhook replied %s", resp.Status)
+	default:
+		return fmt.Errorf("%w: %s", ErrWebhookRejected, resp.Status)
+	}
+}
+
+// Sign returns the value of SignatureHeader for body and secret, for the
+// receivers of a Webhook to compare with hmac.Equal
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body) //nolint:errcheck,gosec // writing to a hash never fails
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,306 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package alert
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+
+	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/schema"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+var testAlert = Alert{
+	Interface: "eth0",
+	Result: netmon.Result{
+		IP:    "10.0.0.5",
+		MAC:   "52:54:00:00:00:01",
+		Time:  1700000000,
+		Event: netmon.EventBindingViolation,
+	},
+	Severity: SeverityCritical,
+}
+
+// webhookServer replies with the statuses in turn, then 200, and sends the
+// requests it received to requests
+type webhookServer struct {
+	requests chan *http.Request
+	bodies   chan []byte
+	statuses []int
+	calls    atomic.Int32
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) (*webhookServer, string) {
+	t.Helper()
+
+	s := &webhookServer{
+		requests: make(chan *http.Request, 16),
+		bodies:   make(chan []byte, 16),
+		statuses: statuses,
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		s.requests <- r
+		s.bodies <- body
+
+		if n := int(s.calls.Add(1)); n <= len(s.statuses) {
+			w.WriteHeader(s.statuses[n-1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return s, srv.URL
+}
+
+func runWebhook(t *testing.T, w *Webhook) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- w.Run(ctx) }()
+
+	return func() {
+		cancel()
+		assert.NoError(t, <-errC)
+	}
+}
+
+func waitStats(t *testing.T, w *Webhook, done func(WebhookStats) bool) WebhookStats {
+	t.Helper()
+
+	require.Eventually(t, func() bool { return done(w.Stats()) }, time.Second, time.Millisecond)
+
+	return w.Stats()
+}
+
+func TestNewWebhook(t *testing.T) {
+	t.Parallel()
+
+	for _, u := range []string{"", "ftp://example.com/alerts", "http://", "://example.com"} {
+		_, err := NewWebhook(u)
+		assert.ErrorIs(t, err, ErrInvalidWebhook, u)
+	}
+
+	_, err := NewWebhook("https://example.com/alerts")
+	assert.NoError(t, err)
+}
+
+func TestWebhook(t *testing.T) {
+	t.Parallel()
+
+	srv, u := newWebhookServer(t)
+	secret := []byte("s3cr3t")
+
+	w, err := NewWebhook(u, WithSecret(secret))
+	require.NoError(t, err)
+
+	stop := runWebhook(t, w)
+	defer stop()
+
+	w.Deliver(testAlert)
+
+	req, body := <-srv.requests, <-srv.bodies
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, strconv.Itoa(schema.Version), req.Header.Get(schema.Header))
+	assert.Equal(t, "BINDING_VIOLATION", req.Header.Get(EventHeader))
+	assert.True(t, hmac.Equal([]byte(Sign(secret, body)), []byte(req.Header.Get(SignatureHeader))))
+
+	var payload map[string]any
+
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, "eth0", payload["interface"])
+	assert.Equal(t, "critical", payload["severity"])
+	assert.Equal(t, "BINDING_VIOLATION", payload["event"])
+	assert.Equal(t, "10.0.0.5", payload["ip"])
+
+	assert.Equal(t, WebhookStats{Delivered: 1}, waitStats(t, w, func(st WebhookStats) bool {
+		return st.Delivered == 1
+	}))
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	t.Parallel()
+
+	srv, u := newWebhookServer(t)
+
+	w, err := NewWebhook(u)
+	require.NoError(t, err)
+
+	stop := runWebhook(t, w)
+	defer stop()
+
+	w.Deliver(testAlert)
+
+	assert.Empty(t, (<-srv.requests).Header.Get(SignatureHeader))
+}
+
+func TestWebhookRetries(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		statuses []int
+		out      WebhookStats
+		attempts int
+	}{
+		"accepted after retries": {
+			statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			attempts: 3,
+			out:      WebhookStats{Delivered: 1, Retried: 2},
+		},
+		"attempts spent": {
+			statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK},
+			attempts: 2,
+			out:      WebhookStats{Failed: 1, Retried: 1},
+		},
+		"rejected": {
+			statuses: []int{http.StatusBadRequest},
+			attempts: 3,
+			out:      WebhookStats{Failed: 1},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, u := newWebhookServer(t, tc.statuses...)
+			clk := clocktest.NewFake(time.Unix(1700000000, 0))
+
+			w, err := NewWebhook(u, WithAttempts(tc.attempts), WithWebhookClock(clk),
+				WithBackoff(time.Second, 3*time.Second))
+			require.NoError(t, err)
+
+			stop := runWebhook(t, w)
+			defer stop()
+
+			w.Deliver(testAlert)
+
+			// the backoff doubles between the retries
+			backoff := time.Second
+			for range tc.out.Retried {
+				<-srv.requests
+				clk.BlockUntil(1)
+				clk.Advance(backoff)
+
+				backoff *= 2
+			}
+
+			assert.Equal(t, tc.out, waitStats(t, w, func(st WebhookStats) bool {
+				return st.Delivered+st.Failed == 1
+			}))
+		})
+	}
+}
+
+func TestWebhookQueue(t *testing.T) {
+	t.Parallel()
+
+	_, u := newWebhookServer(t)
+
+	w, err := NewWebhook(u, WithQueueSize(2))
+	require.NoError(t, err)
+
+	// nothing posts them yet
+	for range 5 {
+		w.Deliver(testAlert)
+	}
+
+	assert.Equal(t, WebhookStats{Queued: 2, Dropped: 3}, w.Stats())
+
+	stop := runWebhook(t, w)
+	defer stop()
+
+	assert.Equal(t, WebhookStats{Delivered: 2, Dropped: 3}, waitStats(t, w, func(st WebhookStats) bool {
+		return st.Delivered == 2
+	}))
+}
+
+func TestWebhookMetrics(t *testing.T) {
+	t.Parallel()
+
+	_, u := newWebhookServer(t, http.StatusForbidden)
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	w, err := NewWebhook(u, WithQueueSize(1), WithWebhookMeter(provider.Meter("test")))
+	require.NoError(t, err)
+
+	w.Deliver(testAlert)
+	w.Deliver(testAlert)
+
+	stop := runWebhook(t, w)
+	defer stop()
+
+	waitStats(t, w, func(st WebhookStats) bool { return st.Failed == 1 })
+
+	expected := metricdata.ScopeMetrics{
+		Scope: instrumentation.Scope{Name: "test"},
+		Metrics: []metricdata.Metrics{
+			{
+				Name: "alert.webhook.alerts",
+				Unit: "{count}",
+				Data: metricdata.Sum[int64]{
+					DataPoints: []metricdata.DataPoint[int64]{
+						{Attributes: attribute.NewSet(attribute.String("type", "delivered"))},
+						{Attributes: attribute.NewSet(attribute.String("type", "failed")), Value: 1},
+						{Attributes: attribute.NewSet(attribute.String("type", "dropped")), Value: 1},
+						{Attributes: attribute.NewSet(attribute.String("type", "retried"))},
+					},
+					Temporality: metricdata.CumulativeTemporality,
+					IsMonotonic: true,
+				},
+			},
+			{
+				Name: "alert.webhook.queue.depth",
+				Unit: "{count}",
+				Data: metricdata.Gauge[int64]{
+					DataPoints: []metricdata.DataPoint[int64]{{}},
+				},
+			},
+		},
+	}
+
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	metricdatatest.AssertEqual(t, expected, rm.ScopeMetrics[0], metricdatatest.IgnoreTimestamp())
+}
@@ -40,9 +40,20 @@ func NewAPIClient(baseURL *url.URL, httpClient *http.Client) *APIClient {
 	}
 }
 
+// RequestOption configures a request made by APIClient.Request
+type RequestOption func(*http.Request)
+
+// WithHeader sets the header key of the request to value, the
+// Content-Type set by default included
+func WithHeader(key, value string) RequestOption {
+	return func(req *http.Request) {
+		req.Header.Set(key, value)
+	}
+}
+
 // Request is a generic method for making HTTP requests to the internal MAAS API.
 func (c *APIClient) Request(ctx context.Context, method, path string,
-	body []byte) (*http.Response, error) {
+	body []byte, options ...RequestOption) (*http.Response, error) {
 	url, err := url.JoinPath(c.baseURL.String(), path)
 	if err != nil {
 		return nil, fmt.Errorf("wrong URL path: %s", path)
@@ -55,6 +66,10 @@ func (c *APIClient) Request(ctx context.Context, method, path string,
 
 	req.Header.Set("Content-Type", "application/json")
 
+	for _, opt := range options {
+		opt(req)
+	}
+
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
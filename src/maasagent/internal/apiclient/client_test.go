@@ -16,15 +16,46 @@
 package apiclient
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func TestRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		assert.Equal(t, "/api/leases", r.URL.Path)
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		assert.Equal(t, []byte("{}"), body)
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	base, err := url.Parse(srv.URL + "/api")
+	require.NoError(t, err)
+
+	client := NewAPIClient(base, srv.Client())
+
+	resp, err := client.Request(context.Background(), http.MethodPost, "/leases", []byte("{}"),
+		WithHeader("Content-Encoding", "gzip"), WithHeader("Content-Type", "application/x-ndjson"))
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+}
+
 func TestTLSConfigWithFingerprintPinning(t *testing.T) {
 	srv := httptest.NewUnstartedServer(http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,253 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
+)
+
+// Message is a single frame of a batched read
+type Message struct {
+	// Buffer receives the frame and must be provided by the caller
+	Buffer []byte
+	Metadata
+}
+
+// Frame returns the captured bytes of the message
+func (m *Message) Frame() []byte {
+	return m.Buffer[:m.CaptureLength]
+}
+
+// BatchReader reads several frames with a single system call
+type BatchReader interface {
+	// ReadFrames fills msgs with at least one frame, blocking until one
+	// is available, and returns the number of messages filled
+	ReadFrames(msgs []Message) (int, error)
+}
+
+// BatchWriter writes several frames with a single system call
+type BatchWriter interface {
+	// WriteFrames transmits frames in order and returns how many were sent
+	WriteFrames(frames [][]byte) (int, error)
+}
+
+// ReadFrames reads a batch of frames from r, using a single system call
+// when r is a BatchReader and a single ReadFrame otherwise
+func ReadFrames(r FrameReader, msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	if br, ok := r.(BatchReader); ok {
+		return br.ReadFrames(msgs)
+	}
+
+	md, err := ReadFrameMetadata(r, msgs[0].Buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	msgs[0].Metadata = md
+
+	return 1, nil
+}
+
+// WriteFrames writes frames to w, batching them when w is a BatchWriter
+func WriteFrames(w FrameWriter, frames [][]byte) (int, error) {
+	if bw, ok := w.(BatchWriter); ok {
+		return bw.WriteFrames(frames)
+	}
+
+	for i, f := range frames {
+		if err := w.WriteFrame(f); err != nil {
+			return i, err
+		}
+	}
+
+	return len(frames), nil
+}
+
+// mmsghdr mirrors struct mmsghdr, which golang.org/x/sys doesn't define
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// ReadFrames reads up to len(msgs) frames with recvmmsg(2)
+func (c *Conn) ReadFrames(msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	// a batch may only hold frames a filter swap discards
+	for {
+		n, err := c.readFrames(msgs)
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (c *Conn) readFrames(msgs []Message) (int, error) {
+
+	hdrs := make([]mmsghdr, len(msgs))
+	iovs := make([]unix.Iovec, len(msgs))
+	names := make([]unix.RawSockaddrLinklayer, len(msgs))
+	oob := make([]byte, len(msgs)*controlSpace)
+
+	for i := range msgs {
+		if len(msgs[i].Buffer) == 0 {
+			return 0, fmt.Errorf("message %d: %w", i, unix.EINVAL)
+		}
+
+		iovs[i].Base = &msgs[i].Buffer[0]
+		iovs[i].SetLen(len(msgs[i].Buffer))
+		hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		hdrs[i].hdr.Namelen = unix.SizeofSockaddrLinklayer
+		hdrs[i].hdr.Iov = &iovs[i]
+		hdrs[i].hdr.SetIovlen(1)
+		hdrs[i].hdr.Control = &oob[i*controlSpace]
+		hdrs[i].hdr.SetControllen(controlSpace)
+	}
+
+	var (
+		n     int
+		errno error
+	)
+
+	rerr := c.raw.Read(func(fd uintptr) bool {
+		swap := c.pendingSwap()
+
+		r, _, e := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&hdrs[0])),
+			uintptr(len(hdrs)), unix.MSG_DONTWAIT, 0, 0)
+		if e != 0 {
+			errno = e
+
+			if errors.Is(e, unix.EAGAIN) {
+				c.endSwap(swap)
+
+				return false
+			}
+
+			return true
+		}
+
+		n, errno = int(r), nil
+
+		return true
+	})
+	if rerr != nil {
+		return 0, c.wrapClosed(rerr)
+	}
+
+	if errno != nil {
+		return 0, fmt.Errorf("failed reading from %s: %w", c.iface.Name, errno)
+	}
+
+	admitted := 0
+
+	for i := range n {
+		controllen := int(hdrs[i].hdr.Controllen) //nolint:gosec // bounded by controlSpace
+
+		md := c.metadata(int(hdrs[i].len), names[i].Pkttype, oob[i*controlSpace:i*controlSpace+controllen])
+
+		length, ok := c.admit(msgs[i].Buffer, md.CaptureLength, &md)
+		if !ok {
+			continue
+		}
+
+		md.CaptureLength = length
+
+		// the messages of the frames discarded by a filter swap are moved
+		// after the ones returned, with their buffers
+		msgs[admitted], msgs[i] = msgs[i], msgs[admitted]
+		msgs[admitted].Metadata = md
+		admitted++
+	}
+
+	return admitted, nil
+}
+
+// WriteFrames transmits frames with sendmmsg(2), it returns the number of
+// frames sent together with the error that stopped the batch, if any
+func (c *Conn) WriteFrames(frames [][]byte) (int, error) {
+	if len(frames) == 0 {
+		return 0, nil
+	}
+
+	sa := unix.RawSockaddrLinklayer{
+		Family:   unix.AF_PACKET,
+		Protocol: hostorder.Htons(c.cfg.protocol),
+		Ifindex:  int32(c.iface.Index), //nolint:gosec // ifindex fits in int32
+	}
+
+	hdrs := make([]mmsghdr, len(frames))
+	iovs := make([]unix.Iovec, len(frames))
+
+	for i, f := range frames {
+		if len(f) == 0 {
+			return 0, fmt.Errorf("frame %d: %w", i, unix.EINVAL)
+		}
+
+		iovs[i].Base = &f[0]
+		iovs[i].SetLen(len(f))
+		hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&sa))
+		hdrs[i].hdr.Namelen = unix.SizeofSockaddrLinklayer
+		hdrs[i].hdr.Iov = &iovs[i]
+		hdrs[i].hdr.SetIovlen(1)
+	}
+
+	sent := 0
+
+	for sent < len(hdrs) {
+		var (
+			n     int
+			errno error
+		)
+
+		werr := c.raw.Write(func(fd uintptr) bool {
+			r, _, e := unix.Syscall6(unix.SYS_SENDMMSG, fd, uintptr(unsafe.Pointer(&hdrs[sent])),
+				uintptr(len(hdrs)-sent), unix.MSG_DONTWAIT, 0, 0)
+			if e != 0 {
+				errno = e
+
+				return !errors.Is(e, unix.EAGAIN)
+			}
+
+			n, errno = int(r), nil
+
+			return true
+		})
+		if werr != nil {
+			return sent, c.wrapClosed(werr)
+		}
+
+		if errno != nil {
+			return sent, fmt.Errorf("failed writing to %s: %w", c.iface.Name, errno)
+		}
+
+		// sendmmsg returns early when the socket buffer fills up
+		sent += n
+	}
+
+	return sent, nil
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type frameRecorder struct {
+	err    error
+	frames [][]byte
+	failAt int
+}
+
+func (r *frameRecorder) WriteFrame(frame []byte) error {
+	if r.err != nil && len(r.frames) == r.failAt {
+		return r.err
+	}
+
+	r.frames = append(r.frames, frame)
+
+	return nil
+}
+
+func (r *frameRecorder) ReadFrame(buf []byte) (int, error) {
+	if len(r.frames) == 0 {
+		return 0, r.err
+	}
+
+	n := copy(buf, r.frames[0])
+	r.frames = r.frames[1:]
+
+	return n, nil
+}
+
+func (r *frameRecorder) SetReadDeadline(time.Time) error { return nil }
+
+func (r *frameRecorder) Close() error { return nil }
+
+func TestWriteFramesFallback(t *testing.T) {
+	t.Parallel()
+
+	errWrite := errors.New("write failed")
+	frames := [][]byte{testFrame("a"), testFrame("b"), testFrame("c")}
+
+	w := &frameRecorder{}
+	n, err := WriteFrames(w, frames)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, frames, w.frames)
+
+	w = &frameRecorder{err: errWrite, failAt: 1}
+	n, err = WriteFrames(w, frames)
+	assert.ErrorIs(t, err, errWrite)
+	assert.Equal(t, 1, n)
+}
+
+func TestReadFramesFallback(t *testing.T) {
+	t.Parallel()
+
+	r := &frameRecorder{frames: [][]byte{testFrame("a"), testFrame("b")}, err: ErrClosed}
+	msgs := []Message{{Buffer: make([]byte, 64)}, {Buffer: make([]byte, 64)}}
+
+	// readers without batching return a single frame per call
+	n, err := ReadFrames(r, msgs)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, testFrame("a"), msgs[0].Frame())
+	assert.False(t, msgs[0].Truncated())
+
+	n, err = ReadFrames(r, msgs)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, testFrame("b"), msgs[0].Frame())
+
+	_, err = ReadFrames(r, msgs)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestConnBatch(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	r, err := Listen(iface, WithFilter(testFilter(t)))
+	require.NoError(t, err)
+
+	defer r.Close() //nolint:errcheck // test cleanup
+
+	w, err := Listen(iface, WithProtocol(testEthertype))
+	require.NoError(t, err)
+
+	defer w.Close() //nolint:errcheck // test cleanup
+
+	frames := make([][]byte, 64)
+	for i := range frames {
+		frames[i] = testFrame("batch " + string(rune('A'+i)))
+	}
+
+	n, err := w.WriteFrames(frames)
+	require.NoError(t, err)
+	require.Equal(t, len(frames), n)
+
+	msgs := make([]Message, 16)
+	for i := range msgs {
+		msgs[i].Buffer = make([]byte, 1500)
+	}
+
+	require.NoError(t, r.SetReadDeadline(time.Now().Add(time.Second)))
+
+	seen := 0
+
+	// lo delivers both the outgoing and the looped back copy
+	for seen < len(frames) {
+		n, err := r.ReadFrames(msgs)
+		require.NoError(t, err)
+
+		for _, m := range msgs[:n] {
+			if seen < len(frames) && bytes.Equal(m.Frame(), frames[seen]) {
+				seen++
+			}
+
+			assert.Equal(t, m.CaptureLength, m.Length)
+			assert.Equal(t, iface, m.Interface)
+		}
+	}
+}
+
+// The benchmarks compare the cost of transmitting frames one system call
+// at a time with sendmmsg(2), for example over a veth pair:
+// sudo ip link add cap0 type veth peer name cap1
+// sudo ip link set cap0 up && sudo ip link set cap1 up
+// sudo TEST_CAPTURE_IFACE=cap0 \
+// go test maas.io/core/src/maasagent/internal/capture -run - -bench Write
+func benchmarkWrite(b *testing.B, batch int) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		b.Skip("set TEST_CAPTURE_IFACE to run this benchmark")
+	}
+
+	w, err := Listen(iface, WithProtocol(testEthertype))
+	require.NoError(b, err)
+
+	defer w.Close() //nolint:errcheck // benchmark cleanup
+
+	frames := make([][]byte, batch)
+	for i := range frames {
+		frames[i] = testFrame("benchmark padding to the minimum frame size.")
+	}
+
+	syscalls := 0
+
+	b.ResetTimer()
+
+	for sent := 0; sent < b.N; {
+		if batch == 1 {
+			require.NoError(b, w.WriteFrame(frames[0]))
+
+			sent++
+			syscalls++
+
+			continue
+		}
+
+		n, err := w.WriteFrames(frames[:min(batch, b.N-sent)])
+		require.NoError(b, err)
+
+		sent += n
+		syscalls++
+	}
+
+	b.ReportMetric(float64(syscalls)/float64(b.N), "syscalls/frame")
+}
+
+func BenchmarkWriteFrame(b *testing.B) {
+	benchmarkWrite(b, 1)
+}
+
+func BenchmarkWriteFrames(b *testing.B) {
+	benchmarkWrite(b, 64)
+}
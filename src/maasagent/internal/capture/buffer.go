@@ -0,0 +1,164 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// skbOverhead approximates the memory the kernel charges to the
+	// socket for each queued frame on top of its data (struct sk_buff
+	// and skb_shared_info)
+	skbOverhead = 768
+	// minBuffer and maxBuffer bound the recommended buffer size
+	minBuffer = 256 << 10
+	maxBuffer = 64 << 20
+)
+
+// Stats are the kernel counters of a capture socket
+type Stats struct {
+	// Packets is the number of frames which reached the socket,
+	// including the ones that were dropped
+	Packets uint64 `json:"packets"`
+	// Drops is the number of frames dropped because the receive buffer
+	// was full
+	Drops uint64 `json:"drops"`
+	// Drained is the number of frames queued before a filter swap which
+	// the new filter rejects, discarded by SetFilter
+	Drained uint64 `json:"drained"`
+}
+
+// setBuffer sets a socket buffer size, trying the privileged variant that
+// ignores net.core.rmem_max and wmem_max first
+func setBuffer(fd, force, opt, size int) error {
+	err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, force, size)
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, unix.EPERM) {
+		return err
+	}
+
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, opt, size)
+}
+
+func (c *Conn) setBuffers(fd int) error {
+	if c.cfg.readBuffer > 0 {
+		if err := setBuffer(fd, unix.SO_RCVBUFFORCE, unix.SO_RCVBUF, c.cfg.readBuffer); err != nil {
+			return fmt.Errorf("failed to set receive buffer size: %w", err)
+		}
+	}
+
+	if c.cfg.writeBuffer > 0 {
+		if err := setBuffer(fd, unix.SO_SNDBUFFORCE, unix.SO_SNDBUF, c.cfg.writeBuffer); err != nil {
+			return fmt.Errorf("failed to set send buffer size: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// BufferSizes returns the receive and send buffer sizes granted by the
+// kernel. They are usually double the requested size, because the kernel
+// reserves room for its bookkeeping, or smaller when the request exceeded
+// net.core.rmem_max or wmem_max without CAP_NET_ADMIN.
+func (c *Conn) BufferSizes() (int, int, error) {
+	var (
+		read, write int
+		err         error
+	)
+
+	cerr := c.raw.Control(func(fd uintptr) {
+		read, err = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+		if err != nil {
+			return
+		}
+
+		write, err = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF)
+	})
+	if cerr != nil {
+		return 0, 0, c.wrapClosed(cerr)
+	}
+
+	return read, write, err
+}
+
+// Stats returns the counters accumulated since the socket was opened. The
+// first time drops are seen a warning is logged, as they usually mean the
+// receive buffer is too small for the traffic.
+func (c *Conn) Stats() (Stats, error) {
+	var (
+		st  *unix.TpacketStats
+		err error
+	)
+
+	cerr := c.raw.Control(func(fd uintptr) {
+		st, err = unix.GetsockoptTpacketStats(int(fd), unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	})
+	if cerr != nil {
+		return Stats{}, c.wrapClosed(cerr)
+	}
+
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed reading statistics of %s: %w", c.iface.Name, err)
+	}
+
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	// the kernel resets its counters on every read
+	c.stats.Packets += uint64(st.Packets)
+	c.stats.Drops += uint64(st.Drops)
+	c.stats.Drained = c.drained.Load()
+
+	if c.stats.Drops > 0 && !c.dropsWarned {
+		c.dropsWarned = true
+
+		read, _, _ := c.BufferSizes()
+
+		log.Warn().Str("iface", c.iface.Name).Uint64("drops", c.stats.Drops).
+			Int("receive_buffer", read).
+			Msg("Capture socket is dropping frames, consider a larger receive buffer")
+	}
+
+	return c.stats, nil
+}
+
+// RecommendedReadBuffer returns a receive buffer size able to absorb a burst
+// of pps frames per second of frameSize bytes lasting burst, for instance
+// the replies to a scan. The result is bounded between 256KiB and 64MiB.
+func RecommendedReadBuffer(pps, frameSize int, burst time.Duration) int {
+	if pps <= 0 || frameSize <= 0 || burst <= 0 {
+		return minBuffer
+	}
+
+	frames := math.Ceil(float64(pps) * burst.Seconds())
+	size := frames * float64(frameSize+skbOverhead)
+
+	// the kernel doubles the value it is given for its bookkeeping, which
+	// skbOverhead already accounts for
+	size /= 2
+
+	return int(min(max(size, minBuffer), maxBuffer))
+}
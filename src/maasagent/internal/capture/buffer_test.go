@@ -0,0 +1,126 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecommendedReadBuffer(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		pps       int
+		frameSize int
+		burst     time.Duration
+		out       int
+	}{
+		"ARP replies to a /16 scan": {
+			pps:       20000,
+			frameSize: 64,
+			burst:     time.Second,
+			// 20000 * (64 + 768) / 2
+			out: 8320000,
+		},
+		"small burst gets the minimum": {
+			pps:       100,
+			frameSize: 64,
+			burst:     100 * time.Millisecond,
+			out:       minBuffer,
+		},
+		"huge burst gets the maximum": {
+			pps:       1000000,
+			frameSize: 1514,
+			burst:     time.Second,
+			out:       maxBuffer,
+		},
+		"invalid estimate": {
+			pps: -1,
+			out: minBuffer,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, RecommendedReadBuffer(tc.pps, tc.frameSize, tc.burst))
+		})
+	}
+}
+
+func TestConnBuffers(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	c, err := Listen(iface, WithReadBuffer(1<<20), WithWriteBuffer(1<<19))
+	require.NoError(t, err)
+
+	defer c.Close() //nolint:errcheck // test cleanup
+
+	read, write, err := c.BufferSizes()
+	require.NoError(t, err)
+
+	// without CAP_NET_ADMIN the kernel caps the sizes to rmem_max and wmem_max
+	t.Logf("granted receive buffer %d, send buffer %d", read, write)
+	assert.Positive(t, read)
+	assert.Positive(t, write)
+}
+
+func TestConnStats(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	// the smallest buffer the kernel allows overflows almost immediately
+	r, err := Listen(iface, WithFilter(testFilter(t)), WithReadBuffer(1))
+	require.NoError(t, err)
+
+	defer r.Close() //nolint:errcheck // test cleanup
+
+	w, err := Listen(iface, WithProtocol(testEthertype))
+	require.NoError(t, err)
+
+	defer w.Close() //nolint:errcheck // test cleanup
+
+	frames := make([][]byte, 256)
+	for i := range frames {
+		frames[i] = testFrame("drop me")
+	}
+
+	_, err = w.WriteFrames(frames)
+	require.NoError(t, err)
+
+	st, err := r.Stats()
+	require.NoError(t, err)
+	assert.Positive(t, st.Packets)
+	assert.Positive(t, st.Drops)
+
+	// counters accumulate across reads
+	again, err := r.Stats()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, again.Drops, st.Drops)
+}
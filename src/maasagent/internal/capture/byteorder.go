@@ -0,0 +1,84 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The byte order of a capture file is the one of the host which wrote it,
+// which the magic numbers tell: readFileMagic and sectionByteOrder are the
+// only places reading them, every other field is read in the order they
+// return. The frames themselves are in network byte order.
+const (
+	// pcapMagicMicros and pcapMagicNanos start a pcap file with
+	// microsecond and nanosecond timestamps
+	pcapMagicMicros = 0xa1b2c3d4
+	pcapMagicNanos  = 0xa1b23c4d
+	// pcapNgMagic is the block type of the section header block starting
+	// a pcapng file, the same in either byte order
+	pcapNgMagic = 0x0a0d0d0a
+	// pcapNgByteOrder is the byte-order magic of a pcapng section header
+	pcapNgByteOrder = 0x1a2b3c4d
+)
+
+// fileMagic is what the magic number starting a capture file tells
+type fileMagic struct {
+	// order is the byte order of a pcap file, the sections of a pcapng
+	// file each tell theirs, see sectionByteOrder
+	order  binary.ByteOrder
+	format FileFormat
+	nanos  bool
+}
+
+// readFileMagic reads the magic number of a capture file from its first 4
+// bytes b
+func readFileMagic(b []byte) (fileMagic, error) {
+	if len(b) < 4 {
+		return fileMagic{}, fmt.Errorf("%w: no magic number", ErrMalformedPcap)
+	}
+
+	switch {
+	case binary.BigEndian.Uint32(b) == pcapNgMagic:
+		return fileMagic{format: FormatPcapNg}, nil
+	case binary.LittleEndian.Uint32(b) == pcapMagicMicros:
+		return fileMagic{format: FormatPcap, order: binary.LittleEndian}, nil
+	case binary.LittleEndian.Uint32(b) == pcapMagicNanos:
+		return fileMagic{format: FormatPcap, order: binary.LittleEndian, nanos: true}, nil
+	case binary.BigEndian.Uint32(b) == pcapMagicMicros:
+		return fileMagic{format: FormatPcap, order: binary.BigEndian}, nil
+	case binary.BigEndian.Uint32(b) == pcapMagicNanos:
+		return fileMagic{format: FormatPcap, order: binary.BigEndian, nanos: true}, nil
+	}
+
+	return fileMagic{}, fmt.Errorf("%w: unknown magic %#08x", ErrMalformedPcap, binary.BigEndian.Uint32(b))
+}
+
+// sectionByteOrder reads the byte order of a pcapng section from the 4
+// bytes b of the byte-order magic of its header
+func sectionByteOrder(b []byte) (binary.ByteOrder, error) {
+	switch {
+	case len(b) < 4:
+		return nil, fmt.Errorf("%w: no byte-order magic", ErrMalformedPcap)
+	case binary.LittleEndian.Uint32(b) == pcapNgByteOrder:
+		return binary.LittleEndian, nil
+	case binary.BigEndian.Uint32(b) == pcapNgByteOrder:
+		return binary.BigEndian, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown byte-order magic %#08x", ErrMalformedPcap, binary.BigEndian.Uint32(b))
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFileMagic(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in    []byte
+		magic fileMagic
+		err   bool
+	}{
+		"pcap little endian": {
+			in:    []byte{0xd4, 0xc3, 0xb2, 0xa1},
+			magic: fileMagic{format: FormatPcap, order: binary.LittleEndian},
+		},
+		"pcap big endian": {
+			in:    []byte{0xa1, 0xb2, 0xc3, 0xd4},
+			magic: fileMagic{format: FormatPcap, order: binary.BigEndian},
+		},
+		"pcap nanoseconds little endian": {
+			in:    []byte{0x4d, 0x3c, 0xb2, 0xa1},
+			magic: fileMagic{format: FormatPcap, order: binary.LittleEndian, nanos: true},
+		},
+		"pcap nanoseconds big endian": {
+			in:    []byte{0xa1, 0xb2, 0x3c, 0x4d},
+			magic: fileMagic{format: FormatPcap, order: binary.BigEndian, nanos: true},
+		},
+		"pcapng": {
+			in:    []byte{0x0a, 0x0d, 0x0d, 0x0a},
+			magic: fileMagic{format: FormatPcapNg},
+		},
+		"unknown": {
+			in:  []byte("not "),
+			err: true,
+		},
+		"short": {
+			in:  []byte{0xa1, 0xb2},
+			err: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			magic, err := readFileMagic(tc.in)
+			if tc.err {
+				assert.ErrorIs(t, err, ErrMalformedPcap)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.magic, magic)
+		})
+	}
+}
+
+func TestSectionByteOrder(t *testing.T) {
+	t.Parallel()
+
+	order, err := sectionByteOrder([]byte{0x4d, 0x3c, 0x2b, 0x1a})
+	require.NoError(t, err)
+	assert.Equal(t, binary.LittleEndian, order)
+
+	order, err = sectionByteOrder([]byte{0x1a, 0x2b, 0x3c, 0x4d})
+	require.NoError(t, err)
+	assert.Equal(t, binary.BigEndian, order)
+
+	_, err = sectionByteOrder([]byte{0x1a, 0x2b, 0x4d, 0x3c})
+	assert.ErrorIs(t, err, ErrMalformedPcap)
+
+	_, err = sectionByteOrder(nil)
+	assert.ErrorIs(t, err, ErrMalformedPcap)
+}
+
+// fixtureFrame is a frame of a fixture with the metadata read with it
+type fixtureFrame struct {
+	Metadata
+	frame string
+}
+
+// readFixture reads the frames of the file at path with a PcapReader, or
+// with a PcapFile with options
+func readFixture(t *testing.T, path string, reader bool, options ...PcapFileOption) []fixtureFrame {
+	t.Helper()
+
+	var r interface {
+		ReadFrameMetadata([]byte) (Metadata, error)
+		Close() error
+	}
+
+	if reader {
+		file, err := os.Open(path)
+		require.NoError(t, err)
+
+		r, err = NewPcapReader(file, "")
+		require.NoError(t, err)
+	} else {
+		f, err := OpenPcapFile(path, "", options...)
+		require.NoError(t, err)
+
+		r = f
+	}
+
+	defer r.Close() //nolint:errcheck // only read
+
+	var frames []fixtureFrame
+
+	buf := make([]byte, 65535)
+
+	for {
+		md, err := r.ReadFrameMetadata(buf)
+		if err == io.EOF {
+			return frames
+		}
+
+		require.NoError(t, err)
+
+		md.Timestamp = md.Timestamp.UTC()
+		frames = append(frames, fixtureFrame{Metadata: md, frame: string(buf[:md.CaptureLength])})
+	}
+}
+
+// TestByteSwappedFixtures reads the files of testdata, the same frames
+// written on a little endian and on a big endian host: frames-le and
+// frames-be differ in the byte order of their headers only, an ARP request,
+// a tagged ARP reply and an IPv6 packet cut to 40 bytes, captured with
+// nanosecond timestamps on eth0.
+func TestByteSwappedFixtures(t *testing.T) {
+	t.Parallel()
+
+	readers := map[string]func(t *testing.T, path string) []fixtureFrame{
+		"reader": func(t *testing.T, path string) []fixtureFrame {
+			t.Helper()
+			return readFixture(t, path, true)
+		},
+		"mapped": func(t *testing.T, path string) []fixtureFrame {
+			t.Helper()
+			return readFixture(t, path, false)
+		},
+		"streamed": func(t *testing.T, path string) []fixtureFrame {
+			t.Helper()
+			return readFixture(t, path, false, WithPcapStreaming())
+		},
+	}
+
+	for _, ext := range []string{"pcap", "pcapng"} {
+		for name, read := range readers {
+			t.Run(ext+" "+name, func(t *testing.T) {
+				t.Parallel()
+
+				le := read(t, filepath.Join("testdata", "frames-le."+ext))
+				be := read(t, filepath.Join("testdata", "frames-be."+ext))
+
+				require.Len(t, le, 3)
+				assert.Equal(t, le, be)
+
+				assert.Equal(t, time.Unix(1700000000, 123456789).UTC(), le[0].Timestamp)
+				assert.Equal(t, time.Unix(1700000001, 5).UTC(), le[1].Timestamp)
+				assert.Equal(t, 40, le[2].CaptureLength)
+				assert.Equal(t, 62, le[2].Length)
+
+				// the frames are in network byte order whatever the file
+				assert.Equal(t, uint16(0x0806), binary.BigEndian.Uint16([]byte(le[0].frame[12:14])))
+				assert.Equal(t, uint16(100), binary.BigEndian.Uint16([]byte(le[1].frame[14:16])))
+
+				if ext == "pcapng" {
+					assert.Equal(t, "eth0", le[0].Interface)
+				}
+			})
+		}
+	}
+}
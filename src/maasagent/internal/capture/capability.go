@@ -0,0 +1,49 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNoRawAccess is returned when the process may not open raw sockets,
+// lacking CAP_NET_RAW such as under strict snap confinement or in an
+// unprivileged container
+var ErrNoRawAccess = errors.New("raw sockets are not permitted")
+
+// CheckRawAccess opens and closes an AF_PACKET socket, the error matches
+// ErrNoRawAccess when the process isn't allowed to capture
+func CheckRawAccess() error {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return socketError(err)
+	}
+
+	return unix.Close(fd)
+}
+
+// socketError wraps the error of opening an AF_PACKET socket, into
+// ErrNoRawAccess when it was denied
+func socketError(err error) error {
+	if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+		return fmt.Errorf("failed opening AF_PACKET socket: %w: %w", ErrNoRawAccess, err)
+	}
+
+	return fmt.Errorf("failed opening AF_PACKET socket: %w", err)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestSocketError(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in     error
+		denied bool
+	}{
+		"not permitted": {
+			in:     unix.EPERM,
+			denied: true,
+		},
+		"access denied by a security module": {
+			in:     unix.EACCES,
+			denied: true,
+		},
+		"unsupported family": {
+			in: unix.EAFNOSUPPORT,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := socketError(tc.in)
+			assert.ErrorIs(t, err, tc.in)
+			assert.Equal(t, tc.denied, errors.Is(err, ErrNoRawAccess))
+		})
+	}
+}
+
+// TestCheckRawAccess requires CAP_NET_RAW:
+// sudo TEST_CAPTURE_IFACE=lo \
+// go test maas.io/core/src/maasagent/internal/capture -run TestCheckRawAccess -count 1 -v
+func TestCheckRawAccess(t *testing.T) {
+	if os.Getenv("TEST_CAPTURE_IFACE") == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	assert.NoError(t, CheckRawAccess())
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/bpf"
+)
+
+const (
+	// ProtocolAll captures frames of every ethertype (ETH_P_ALL)
+	ProtocolAll uint16 = 0x0003
+)
+
+var (
+	// ErrClosed is returned when reading from or writing to a closed backend
+	ErrClosed = errors.New("capture closed")
+	// ErrUnsupported is returned when a backend is not supported by the
+	// kernel, the NIC or the build
+	ErrUnsupported = errors.New("capture backend not supported")
+)
+
+// FrameReader reads link layer frames from a network interface
+type FrameReader interface {
+	// ReadFrame reads a single frame into buf and returns its length
+	ReadFrame(buf []byte) (int, error)
+	// SetReadDeadline sets the deadline for future ReadFrame calls
+	SetReadDeadline(t time.Time) error
+	// Close releases the resources held by the reader
+	Close() error
+}
+
+// FrameWriter writes link layer frames to a network interface
+type FrameWriter interface {
+	// WriteFrame transmits a single frame, which must include
+	// the ethernet header
+	WriteFrame(frame []byte) error
+}
+
+// Backend is the kernel mechanism used to receive frames
+type Backend uint8
+
+const (
+	// BackendPacket uses an AF_PACKET socket
+	BackendPacket Backend = iota + 1
+	// BackendXDP uses an XDP program redirecting interesting frames to
+	// AF_XDP sockets, see OpenXDP
+	BackendXDP
+)
+
+// XDPStats are the counters of the XDP pre-filter
+type XDPStats struct {
+	// Filtered is the number of frames that didn't match and were passed
+	// to the kernel stack or dropped in the driver
+	Filtered uint64 `json:"filtered"`
+	// Delivered is the number of frames redirected to the AF_XDP sockets
+	Delivered uint64 `json:"delivered"`
+	// Dropped is the number of redirected frames the sockets couldn't
+	// accept because their rings were full
+	Dropped uint64 `json:"dropped"`
+}
+
+type config struct {
+	filter      []bpf.RawInstruction
+	groups      []net.HardwareAddr
+	readBuffer  int
+	writeBuffer int
+	protocol    uint16
+	backend     Backend
+	membership  Membership
+	xdpDrop     bool
+	hwTimestamp bool
+}
+
+func newConfig(options []Option) config {
+	c := config{
+		protocol: ProtocolAll,
+		backend:  BackendPacket,
+	}
+
+	for _, opt := range options {
+		opt(&c)
+	}
+
+	return c
+}
+
+// Option configures how frames are captured
+type Option func(*config)
+
+// WithProtocol restricts capture to frames of the given ethertype
+func WithProtocol(ethertype uint16) Option {
+	return func(c *config) {
+		if ethertype == 0 {
+			return
+		}
+
+		c.protocol = ethertype
+	}
+}
+
+// WithPromiscuous enables promiscuous mode for the lifetime of the capture,
+// see MembershipPromiscuous
+func WithPromiscuous() Option {
+	return WithMembership(MembershipPromiscuous)
+}
+
+// WithFilter attaches a classic BPF program, frames it rejects are dropped
+// by the kernel before reaching the socket
+func WithFilter(filter []bpf.RawInstruction) Option {
+	return func(c *config) {
+		c.filter = filter
+	}
+}
+
+// WithReadBuffer sets the socket receive buffer size in bytes, see
+// RecommendedReadBuffer
+func WithReadBuffer(size int) Option {
+	return func(c *config) {
+		c.readBuffer = size
+	}
+}
+
+// WithWriteBuffer sets the socket send buffer size in bytes
+func WithWriteBuffer(size int) Option {
+	return func(c *config) {
+		c.writeBuffer = size
+	}
+}
+
+// WithBackend selects the preferred capture backend for Open
+func WithBackend(b Backend) Option {
+	return func(c *config) {
+		c.backend = b
+	}
+}
+
+// WithXDPDrop makes the XDP backend drop, rather than pass to the kernel,
+// the frames it doesn't redirect. This is only safe on dedicated monitoring
+// ports where the host doesn't need the traffic.
+func WithXDPDrop() Option {
+	return func(c *config) {
+		c.xdpDrop = true
+	}
+}
+
+// WithHardwareTimestamps asks the NIC to timestamp received frames. When
+// the interface doesn't support it frames get software timestamps, the
+// source of each timestamp is reported in its Metadata.
+func WithHardwareTimestamps() Option {
+	return func(c *config) {
+		c.hwTimestamp = true
+	}
+}
+
+// Open starts capturing on the named interface with the preferred backend.
+// When the XDP backend is requested but unavailable (kernel, NIC or build
+// without the xdp tag) it falls back to AF_PACKET.
+func Open(iface string, options ...Option) (FrameReader, error) {
+	cfg := newConfig(options)
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", iface, err)
+	}
+
+	if cfg.backend == BackendXDP {
+		r, err := openXDP(ifi, cfg)
+		if err == nil {
+			return r, nil
+		}
+
+		log.Warn().Err(err).Str("iface", iface).
+			Msg("XDP capture is not available, falling back to AF_PACKET")
+	}
+
+	return listen(ifi, cfg)
+}
+
+// InterruptReads makes the pending and future reads of r fail once ctx is
+// done, with a read deadline in the past rather than by closing r. A read
+// loop checks ctx.Err() when a read fails. The returned function stops the
+// interruption.
+func InterruptReads(ctx context.Context, r FrameReader) func() bool {
+	return context.AfterFunc(ctx, func() {
+		r.SetReadDeadline(time.Unix(1, 0)) //nolint:errcheck,gosec // reads of a closed reader fail too
+	})
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+// testEthertype is the IEEE local experimental ethertype
+const testEthertype = 0x88b5
+
+func TestNewConfig(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []Option
+		out config
+	}{
+		"defaults": {
+			out: config{protocol: ProtocolAll, backend: BackendPacket},
+		},
+		"zero protocol keeps the default": {
+			in:  []Option{WithProtocol(0)},
+			out: config{protocol: ProtocolAll, backend: BackendPacket},
+		},
+		"XDP with drop": {
+			in: []Option{WithBackend(BackendXDP), WithXDPDrop(), WithProtocol(testEthertype), WithPromiscuous()},
+			out: config{
+				protocol:   testEthertype,
+				backend:    BackendXDP,
+				membership: MembershipPromiscuous,
+				xdpDrop:    true,
+			},
+		},
+		"widest membership": {
+			in: []Option{WithPromiscuous(), WithMulticastGroups(testGroup), WithMembership(MembershipAllMulticast)},
+			out: config{
+				protocol:   ProtocolAll,
+				backend:    BackendPacket,
+				membership: MembershipPromiscuous,
+				groups:     []net.HardwareAddr{testGroup},
+			},
+		},
+		"multicast groups": {
+			in: []Option{WithMulticastGroups(testGroup)},
+			out: config{
+				protocol:   ProtocolAll,
+				backend:    BackendPacket,
+				membership: MembershipGroups,
+				groups:     []net.HardwareAddr{testGroup},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, newConfig(tc.in))
+		})
+	}
+}
+
+func testFilter(t *testing.T) []bpf.RawInstruction {
+	t.Helper()
+
+	filter, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: testEthertype, SkipFalse: 1},
+		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: 0},
+	})
+	require.NoError(t, err)
+
+	return filter
+}
+
+func testFrame(payload string) []byte {
+	frame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x16, 0x3e, 0x00, 0x00, 0x01,
+		testEthertype >> 8, testEthertype & 0xff,
+	}
+
+	return append(frame, payload...)
+}
+
+// TestConn requires CAP_NET_RAW and an interface which loops frames back,
+// such as lo:
+// sudo TEST_CAPTURE_IFACE=lo \
+// go test maas.io/core/src/maasagent/internal/capture -run TestConn -count 1 -v
+func TestConn(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	r, err := Listen(iface, WithFilter(testFilter(t)))
+	require.NoError(t, err)
+
+	defer r.Close() //nolint:errcheck // test cleanup
+
+	w, err := Listen(iface, WithProtocol(testEthertype))
+	require.NoError(t, err)
+
+	defer w.Close() //nolint:errcheck // test cleanup
+
+	frame := testFrame("capture")
+	require.NoError(t, w.WriteFrame(frame))
+
+	buf := make([]byte, 1500)
+
+	require.NoError(t, r.SetReadDeadline(time.Now().Add(time.Second)))
+
+	for {
+		n, err := r.ReadFrame(buf)
+		require.NoError(t, err)
+
+		// lo delivers both the outgoing and the looped back copy
+		if bytes.Equal(buf[:n], frame) {
+			break
+		}
+	}
+
+	// nothing else matches the filter
+	require.NoError(t, r.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+
+	for {
+		n, err := r.ReadFrame(buf)
+		if err != nil {
+			assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+			break
+		}
+
+		assert.Equal(t, frame, buf[:n])
+	}
+
+	require.NoError(t, r.SetReadDeadline(time.Time{}))
+
+	errC := make(chan error, 1)
+
+	go func() {
+		_, err := r.ReadFrame(buf)
+		errC <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, r.Close())
+
+	select {
+	case err := <-errC:
+		assert.True(t, errors.Is(err, ErrClosed), err)
+	case <-time.After(time.Second):
+		t.Fatal("Close did not unblock ReadFrame")
+	}
+}
+
+func TestOpenFallback(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	r, err := Open(iface, WithBackend(BackendXDP), WithFilter(testFilter(t)))
+	require.NoError(t, err)
+
+	defer r.Close() //nolint:errcheck // test cleanup
+
+	if _, ok := r.(*Conn); !ok {
+		t.Logf("XDP backend is in use on %s", iface)
+	}
+}
+
+func TestOpenMissingInterface(t *testing.T) {
+	t.Parallel()
+
+	_, err := Open("does-not-exist0")
+	assert.Error(t, err)
+}
+
+// pipeReader is a FrameReader reading from a pipe, which the runtime poller
+// handles like a capture socket
+type pipeReader struct {
+	*os.File
+}
+
+func (r pipeReader) ReadFrame(buf []byte) (int, error) {
+	return r.Read(buf)
+}
+
+func TestInterruptReads(t *testing.T) {
+	defer leak.Check(t)()
+
+	pr, pw, err := os.Pipe()
+	require.NoError(t, err)
+
+	defer pr.Close() //nolint:errcheck // test cleanup
+	defer pw.Close() //nolint:errcheck // test cleanup
+
+	r := pipeReader{pr}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stop := InterruptReads(ctx, r)
+	defer stop()
+
+	errC := make(chan error, 1)
+	buf := make([]byte, 64)
+
+	go func() {
+		_, err := r.ReadFrame(buf)
+		errC <- err
+	}()
+
+	cancel()
+	assert.ErrorIs(t, <-errC, os.ErrDeadlineExceeded)
+
+	// unlike Close, the reader is still usable
+	require.NoError(t, r.SetReadDeadline(time.Time{}))
+
+	_, err = pw.Write(testFrame("again"))
+	require.NoError(t, err)
+
+	n, err := r.ReadFrame(buf)
+	require.NoError(t, err)
+	assert.Equal(t, testFrame("again"), buf[:n])
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"net"
+	"slices"
+	"strings"
+
+	"maas.io/core/src/maasagent/internal/dnsmsg"
+)
+
+const (
+	// maxDNSHosts bounds the hosts whose DNS traffic is accounted per
+	// interval
+	maxDNSHosts = 256
+	// MaxDNSNames is the largest sample of queried names kept per host
+	MaxDNSNames = 16
+)
+
+// DNSHost is the DNS traffic a host sent during the interval
+type DNSHost struct {
+	MAC string `json:"mac"`
+	// Names are the names the host queried most recently, the latest
+	// first, when the summarizer records them
+	Names   []string `json:"names,omitempty"`
+	Queries uint64   `json:"queries"`
+	// Responses counts the responses the host sent, a resolver would
+	Responses uint64 `json:"responses,omitempty"`
+	// Addresses counts the A and AAAA answers of the responses
+	Addresses uint64 `json:"addresses,omitempty"`
+}
+
+// DNSSummary reports the plain DNS traffic seen on an interface
+type DNSSummary struct {
+	Hosts []DNSHost `json:"hosts"`
+	// Truncated is set when hosts were ignored to bound the summary
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type dnsActivity struct {
+	names     []string
+	queries   uint64
+	responses uint64
+	addresses uint64
+}
+
+// dnsTracker accounts the DNS messages sent by the hosts of an interface,
+// the queried names are only kept when names is positive
+type dnsTracker struct {
+	hosts     map[hwAddr]*dnsActivity
+	names     int
+	truncated bool
+}
+
+func newDNSTracker() *dnsTracker {
+	return &dnsTracker{hosts: make(map[hwAddr]*dnsActivity)}
+}
+
+func (t *dnsTracker) add(frame []byte) {
+	msg, _, err := dnsmsg.ParseFrame(frame)
+	if err != nil {
+		return
+	}
+
+	host := hwAddr(frame[6:12])
+
+	a, ok := t.hosts[host]
+	if !ok {
+		if len(t.hosts) >= maxDNSHosts {
+			t.truncated = true
+			return
+		}
+
+		a = &dnsActivity{}
+		t.hosts[host] = a
+	}
+
+	if msg.Response {
+		a.responses++
+		a.addresses += uint64(len(msg.Answers))
+
+		return
+	}
+
+	a.queries++
+
+	for _, q := range msg.Questions {
+		t.record(a, q.Name)
+	}
+}
+
+// record moves name to the front of the sample of the host, dropping the
+// oldest name once the sample is full
+func (t *dnsTracker) record(a *dnsActivity, name string) {
+	if t.names == 0 || name == "" {
+		return
+	}
+
+	name = strings.ToLower(name)
+
+	if i := slices.Index(a.names, name); i >= 0 {
+		a.names = slices.Delete(a.names, i, i+1)
+	} else if len(a.names) >= t.names {
+		a.names = a.names[:t.names-1]
+	}
+
+	a.names = slices.Insert(a.names, 0, name)
+}
+
+func (t *dnsTracker) summary() *DNSSummary {
+	if len(t.hosts) == 0 {
+		return nil
+	}
+
+	summary := &DNSSummary{Truncated: t.truncated}
+
+	for host, a := range t.hosts {
+		summary.Hosts = append(summary.Hosts, DNSHost{
+			MAC:       net.HardwareAddr(host[:]).String(),
+			Names:     a.names,
+			Queries:   a.queries,
+			Responses: a.responses,
+			Addresses: a.addresses,
+		})
+	}
+
+	slices.SortFunc(summary.Hosts, func(a, b DNSHost) int {
+		return strings.Compare(a.MAC, b.MAC)
+	})
+
+	return summary
+}
+
+func (t *dnsTracker) reset() {
+	clear(t.hosts)
+	t.truncated = false
+}
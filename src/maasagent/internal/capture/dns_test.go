@@ -0,0 +1,157 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dnsFrame returns a DNS message sent over IPv4 by 00:16:3e:00:00:<src>,
+// a query is sent to port 53 and a response from it
+func dnsFrame(src byte, msg []byte) []byte {
+	ports := []byte{0x9c, 0x40, 0x00, 0x35}
+	if msg[2]&0x80 != 0 {
+		ports = []byte{0x00, 0x35, 0x9c, 0x40}
+	}
+
+	udp := append(ports, 0x00, 0x00, 0x00, 0x00)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(msg))) //nolint:gosec // test datagrams are small
+	udp = append(udp, msg...)
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(udp))) //nolint:gosec // test packets are small
+	ip[9] = 17
+
+	return append(append(summaryFrame(src, 0x08, 0x00), ip...), udp...)
+}
+
+func dnsName(name string) []byte {
+	var buf []byte
+
+	for _, label := range strings.Split(name, ".") {
+		buf = append(append(buf, byte(len(label))), label...)
+	}
+
+	return append(buf, 0x00)
+}
+
+func dnsQuery(name string) []byte {
+	msg := []byte{0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	return append(append(msg, dnsName(name)...), 0x00, 0x01, 0x00, 0x01)
+}
+
+// dnsResponse answers a query of name with an A record
+func dnsResponse(name string) []byte {
+	msg := dnsQuery(name)
+	msg[2], msg[7] = 0x81, 0x01
+
+	return append(msg, 0xc0, 12, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x04, 10, 0, 0, 1)
+}
+
+func TestSummarizerDNS(t *testing.T) {
+	t.Parallel()
+
+	frames := [][]byte{
+		dnsFrame(2, dnsQuery("maas.example.com")),
+		dnsFrame(2, dnsQuery("archive.ubuntu.com")),
+		dnsFrame(2, dnsQuery("MAAS.example.com")),
+		dnsFrame(3, dnsQuery("ntp.ubuntu.com")),
+		dnsFrame(53, dnsResponse("maas.example.com")),
+	}
+
+	testcases := map[string]struct {
+		options []SummarizerOption
+		out     DNSSummary
+	}{
+		"counters only": {
+			out: DNSSummary{Hosts: []DNSHost{
+				{MAC: "00:16:3e:00:00:02", Queries: 3},
+				{MAC: "00:16:3e:00:00:03", Queries: 1},
+				{MAC: "00:16:3e:00:00:35", Responses: 1, Addresses: 1},
+			}},
+		},
+		"names": {
+			options: []SummarizerOption{WithDNSNames(2)},
+			out: DNSSummary{Hosts: []DNSHost{
+				{
+					MAC:     "00:16:3e:00:00:02",
+					Names:   []string{"maas.example.com", "archive.ubuntu.com"},
+					Queries: 3,
+				},
+				{MAC: "00:16:3e:00:00:03", Names: []string{"ntp.ubuntu.com"}, Queries: 1},
+				{MAC: "00:16:3e:00:00:35", Responses: 1, Addresses: 1},
+			}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewSummarizer("eth0", tc.options...)
+
+			for _, frame := range frames {
+				s.Add(frame, Metadata{})
+			}
+
+			summary := s.Snapshot()
+
+			require.NotNil(t, summary.DNS)
+			assert.Equal(t, tc.out, *summary.DNS)
+			assert.Nil(t, s.Snapshot().DNS)
+		})
+	}
+}
+
+func TestSummarizerBoundsDNS(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0", WithDNSNames(MaxDNSNames+10))
+
+	for i := range MaxDNSNames + 4 {
+		s.Add(dnsFrame(1, dnsQuery(fmt.Sprintf("host%d.example.com", i))), Metadata{})
+	}
+
+	for i := range maxDNSHosts + 1 {
+		frame := dnsFrame(0, dnsQuery("a.example.com"))
+		frame[10], frame[11] = byte(i>>8)+1, byte(i)
+
+		s.Add(frame, Metadata{})
+	}
+
+	summary := s.Snapshot()
+
+	require.NotNil(t, summary.DNS)
+	assert.True(t, summary.DNS.Truncated)
+	assert.Len(t, summary.DNS.Hosts, maxDNSHosts)
+
+	for _, host := range summary.DNS.Hosts {
+		if host.MAC == "00:16:3e:00:00:01" {
+			assert.Len(t, host.Names, MaxDNSNames)
+			assert.Equal(t, fmt.Sprintf("host%d.example.com", MaxDNSNames+3), host.Names[0])
+		}
+	}
+}
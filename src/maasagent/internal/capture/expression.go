@@ -0,0 +1,888 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/bpf"
+)
+
+const (
+	// maxExpressionDepth bounds the nesting of the parentheses and negations
+	// of an expression
+	maxExpressionDepth = 64
+	// maxFilterInsns is the kernel limit of the instructions of a socket
+	// filter
+	maxFilterInsns = 4096
+
+	etherTypeQinQ    = 0x88a8
+	etherTypeQinQOld = 0x9100
+
+	ipProtoTCP = 6
+	ipProtoUDP = 17
+)
+
+var (
+	// ErrInvalidExpression is returned for a filter expression which
+	// doesn't parse
+	ErrInvalidExpression = errors.New("invalid filter expression")
+	// ErrUnsupportedExpression is returned for a filter expression using a
+	// construct of pcap-filter(7) outside of the subset compiled
+	ErrUnsupportedExpression = errors.New("unsupported filter expression")
+)
+
+// unsupportedKeywords are the keywords of pcap-filter(7) outside of the
+// subset compiled, they are refused with ErrUnsupportedExpression rather
+// than as unknown
+var unsupportedKeywords = map[string]bool{
+	"net": true, "mask": true, "gateway": true, "portrange": true, "proto": true, "protochain": true,
+	"less": true, "greater": true, "len": true, "broadcast": true, "multicast": true,
+	"icmp": true, "icmp6": true, "igmp": true, "pim": true, "vrrp": true, "carp": true, "sctp": true,
+	"ah": true, "esp": true, "rarp": true, "atalk": true, "aarp": true, "decnet": true, "iso": true,
+	"stp": true, "ipx": true, "netbeui": true, "lat": true, "moprc": true, "mopdl": true, "llc": true,
+	"mpls": true, "pppoed": true, "pppoes": true, "geneve": true, "vxlan": true, "fddi": true,
+	"tr": true, "wlan": true, "link": true, "inbound": true, "outbound": true, "ifname": true,
+	"on": true, "rnr": true, "rulenum": true, "reason": true, "rset": true, "ruleset": true,
+	"srnr": true, "subrulenum": true, "action": true, "type": true, "subtype": true, "dir": true,
+	"ra": true, "ta": true, "addr1": true, "addr2": true, "addr3": true, "addr4": true,
+}
+
+// exprToken is a token of an expression, at pos in its text
+type exprToken struct {
+	text string
+	pos  int
+}
+
+// exprNode is a node of a parsed expression
+type exprNode interface {
+	// gen generates the instructions jumping to match when the frame
+	// matches, and to fail otherwise
+	gen(g *exprGen, match, fail string)
+}
+
+// exprGen generates the instructions of an expression in a filterProgram
+type exprGen struct {
+	p *filterProgram
+	n int
+}
+
+// local returns two labels of the generator, those a primitive jumps to
+// when the frame matches and when it doesn't
+func (g *exprGen) local() (string, string) {
+	g.n++
+
+	return fmt.Sprintf("expr%d.match", g.n), fmt.Sprintf("expr%d.fail", g.n)
+}
+
+// done ends a primitive, its labels going on to match and fail. The
+// conditional jumps of the primitive stay short however long the program.
+func (g *exprGen) done(t, f, match, fail string) {
+	g.p.label(t)
+	g.p.jump(match)
+	g.p.label(f)
+	g.p.jump(fail)
+}
+
+// exprBinary is an and or an or of two expressions
+type exprBinary struct {
+	left, right exprNode
+	and         bool
+}
+
+func (n exprBinary) gen(g *exprGen, match, fail string) {
+	g.n++
+	right := fmt.Sprintf("expr%d.right", g.n)
+
+	if n.and {
+		n.left.gen(g, right, fail)
+	} else {
+		n.left.gen(g, match, right)
+	}
+
+	g.p.label(right)
+	n.right.gen(g, match, fail)
+}
+
+// exprNot negates an expression
+type exprNot struct {
+	x exprNode
+}
+
+func (n exprNot) gen(g *exprGen, match, fail string) {
+	n.x.gen(g, fail, match)
+}
+
+// exprEtherHost matches the source or destination MAC of the frame
+type exprEtherHost struct {
+	mac net.HardwareAddr
+	dir string
+}
+
+func (n exprEtherHost) gen(g *exprGen, match, fail string) {
+	t, f := g.local()
+
+	switch n.dir {
+	case "src":
+		g.p.compare(6, n.mac, t, f)
+	case "dst":
+		g.p.compare(0, n.mac, t, f)
+	default:
+		g.p.compare(0, n.mac, t, f+".src")
+		g.p.label(f + ".src")
+		g.p.compare(6, n.mac, t, f)
+	}
+
+	g.done(t, f, match, fail)
+}
+
+// exprEtherType matches the ethertype of the frame, at link bytes past the
+// MACs
+type exprEtherType struct {
+	link    uint32
+	ethType uint32
+}
+
+func (n exprEtherType) gen(g *exprGen, match, fail string) {
+	t, f := g.local()
+
+	g.p.add(bpf.LoadAbsolute{Off: 12 + n.link, Size: 2})
+	g.p.jumpIf(n.ethType, t, f)
+	g.done(t, f, match, fail)
+}
+
+// exprVLAN matches an 802.1Q or 802.1ad tag at link bytes past the MACs,
+// of the VLAN id when set
+type exprVLAN struct {
+	id   *uint16
+	link uint32
+}
+
+func (n exprVLAN) gen(g *exprGen, match, fail string) {
+	t, f := g.local()
+
+	g.p.add(bpf.LoadAbsolute{Off: 12 + n.link, Size: 2})
+	g.p.jumpIf(etherTypeVLAN, f+".tag", "")
+	g.p.jumpIf(etherTypeQinQ, f+".tag", "")
+	g.p.jumpIf(etherTypeQinQOld, f+".tag", f)
+	g.p.label(f + ".tag")
+
+	if n.id != nil {
+		g.p.add(bpf.LoadAbsolute{Off: 14 + n.link, Size: 2})
+		g.p.add(bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xfff})
+		g.p.jumpIf(uint32(*n.id), t, f)
+	}
+
+	g.done(t, f, match, fail)
+}
+
+// exprTransport matches the TCP or UDP packets, in IPv4 or IPv6, at link
+// bytes past the MACs. IPv6 packets only match by the next header of their
+// fixed header, as with libpcap.
+type exprTransport struct {
+	protos []uint32
+	link   uint32
+}
+
+func (n exprTransport) gen(g *exprGen, match, fail string) {
+	t, f := g.local()
+
+	n.ipv4(g, t, f+".ipv6", f)
+	g.p.label(f + ".ipv6")
+	n.ipv6(g, t, f)
+	g.done(t, f, match, fail)
+}
+
+// ipv4 jumps to match for the IPv4 packets of the protocols, to other with
+// the ethertype loaded for the other ethertypes, and to fail otherwise
+func (n exprTransport) ipv4(g *exprGen, match, other, fail string) {
+	g.p.add(bpf.LoadAbsolute{Off: 12 + n.link, Size: 2})
+	g.p.jumpIf(etherTypeIPv4, "", other)
+	g.p.add(bpf.LoadAbsolute{Off: 23 + n.link, Size: 1})
+	n.proto(g, match, fail)
+}
+
+// ipv6 jumps to match for the IPv6 packets of the protocols, with the
+// ethertype loaded, and to fail otherwise
+func (n exprTransport) ipv6(g *exprGen, match, fail string) {
+	g.p.jumpIf(etherTypeIPv6, "", fail)
+	g.p.add(bpf.LoadAbsolute{Off: 20 + n.link, Size: 1})
+	n.proto(g, match, fail)
+}
+
+// proto jumps to match when the protocol loaded is one of the protocols,
+// and to fail otherwise
+func (n exprTransport) proto(g *exprGen, match, fail string) {
+	for i, proto := range n.protos {
+		if i == len(n.protos)-1 {
+			g.p.jumpIf(proto, match, fail)
+		} else {
+			g.p.jumpIf(proto, match, "")
+		}
+	}
+}
+
+// exprPort matches the source or destination port of the TCP or UDP
+// packets. The IPv4 fragments but the first have no port, they never
+// match.
+type exprPort struct {
+	dir string
+	exprTransport
+	port uint16
+}
+
+func (n exprPort) gen(g *exprGen, match, fail string) {
+	t, f := g.local()
+
+	ports := func(off uint32, load func(off uint32) bpf.Instruction) {
+		if n.dir != "dst" {
+			g.p.add(load(off))
+			g.p.jumpIf(uint32(n.port), t, "")
+		}
+
+		if n.dir != "src" {
+			g.p.add(load(off + 2))
+			g.p.jumpIf(uint32(n.port), t, "")
+		}
+
+		g.p.jump(f)
+	}
+
+	n.ipv4(g, f+".ipv4", f+".ipv6", f)
+	g.p.label(f + ".ipv4")
+	g.p.add(bpf.LoadAbsolute{Off: 20 + n.link, Size: 2})
+	g.p.jumpTest(bpf.JumpBitsSet, 0x1fff, f, "")
+	g.p.add(bpf.LoadMemShift{Off: 14 + n.link})
+	ports(14+n.link, func(off uint32) bpf.Instruction { return bpf.LoadIndirect{Off: off, Size: 2} })
+	g.p.label(f + ".ipv6")
+	n.ipv6(g, f+".ipv6ports", f)
+	g.p.label(f + ".ipv6ports")
+	ports(54+n.link, func(off uint32) bpf.Instruction { return bpf.LoadAbsolute{Off: off, Size: 2} })
+	g.done(t, f, match, fail)
+}
+
+// exprHost matches the source or destination address of the IPv4, ARP or
+// IPv6 packets, those of the families set
+type exprHost struct {
+	dir  string
+	addr netip.Addr
+	exprFamilies
+	link uint32
+}
+
+// exprFamilies are the packets a host primitive looks into
+type exprFamilies struct {
+	ipv4, arp, ipv6 bool
+}
+
+func (n exprHost) gen(g *exprGen, match, fail string) {
+	t, f := g.local()
+
+	families := []struct {
+		name     string
+		ethType  uint32
+		src, dst uint32
+		set      bool
+	}{
+		{name: "ipv4", ethType: etherTypeIPv4, src: 26, dst: 30, set: n.ipv4 && n.addr.Is4()},
+		{name: "arp", ethType: etherTypeARP, src: 28, dst: 38, set: n.arp && n.addr.Is4()},
+		{name: "ipv6", ethType: etherTypeIPv6, src: 22, dst: 38, set: n.ipv6 && n.addr.Is6()},
+	}
+
+	g.p.add(bpf.LoadAbsolute{Off: 12 + n.link, Size: 2})
+
+	for _, fam := range families {
+		if fam.set {
+			g.p.jumpIf(fam.ethType, f+"."+fam.name, "")
+		}
+	}
+
+	g.p.jump(f)
+
+	for _, fam := range families {
+		if !fam.set {
+			continue
+		}
+
+		g.p.label(f + "." + fam.name)
+
+		if n.dir != "dst" {
+			g.p.compare(fam.src+n.link, n.addr.AsSlice(), t, f+"."+fam.name+".dst")
+		}
+
+		g.p.label(f + "." + fam.name + ".dst")
+
+		if n.dir != "src" {
+			g.p.compare(fam.dst+n.link, n.addr.AsSlice(), t, f)
+		} else {
+			g.p.jump(f)
+		}
+	}
+
+	g.done(t, f, match, fail)
+}
+
+// exprParser parses an expression with the grammar of pcap-filter(7): not
+// binds tighter than and and or, which have the same precedence and
+// associate to the left
+type exprParser struct {
+	// last parses a bare value with the qualifiers of the last primitive,
+	// "port 67 or 68" being "port 67 or port 68"
+	last   func(tok exprToken) (exprNode, error)
+	tokens []exprToken
+	pos    int
+	depth  int
+	// link is the length of the tags the vlan primitives parsed so far
+	// skip, they shift the primitives following them
+	link uint32
+}
+
+// parseExpression parses expr, nil when it has no primitive
+func parseExpression(expr string) (exprNode, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil //nolint:nilnil // the empty expression matches every frame
+	}
+
+	p := &exprParser{tokens: tokens}
+
+	n, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok, ok := p.peek(); ok {
+		return nil, p.unexpected(tok)
+	}
+
+	return n, nil
+}
+
+// tokenize splits expr into its words, parentheses and operators
+func tokenize(expr string) ([]exprToken, error) {
+	var tokens []exprToken
+
+	for i := 0; i < len(expr); {
+		c := expr[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || (c == '!' && !strings.HasPrefix(expr[i:], "!=")):
+			tokens = append(tokens, exprToken{text: expr[i : i+1], pos: i})
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, exprToken{text: expr[i : i+2], pos: i})
+			i += 2
+		case isWordByte(c):
+			start := i
+			for i < len(expr) && isWordByte(expr[i]) {
+				i++
+			}
+
+			tokens = append(tokens, exprToken{text: expr[start:i], pos: start})
+		case strings.IndexByte("[]<>=!+-*/%&|^", c) >= 0:
+			return nil, fmt.Errorf("%w: %q at %d, packet data accesses and arithmetic aren't supported",
+				ErrUnsupportedExpression, c, i)
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidExpression, c, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+// isWordByte returns true if c is part of a keyword, a number or an address
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '.' || c == ':' || c == '_' || c == '/'
+}
+
+func (p *exprParser) peek() (exprToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return exprToken{}, false
+	}
+
+	return p.tokens[p.pos], true
+}
+
+// peekIs returns true if the next token is one of words
+func (p *exprParser) peekIs(words ...string) bool {
+	tok, ok := p.peek()
+
+	return ok && isOneOf(tok.text, words...)
+}
+
+func (p *exprParser) next(what string) (exprToken, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return exprToken{}, fmt.Errorf("%w: expected %s, got the end", ErrInvalidExpression, what)
+	}
+
+	p.pos++
+
+	return tok, nil
+}
+
+// unexpected returns the error of tok where it doesn't belong
+func (p *exprParser) unexpected(tok exprToken) error {
+	if unsupportedKeywords[tok.text] {
+		return fmt.Errorf("%w: %q at %d", ErrUnsupportedExpression, tok.text, tok.pos)
+	}
+
+	return fmt.Errorf("%w: unexpected %q at %d", ErrInvalidExpression, tok.text, tok.pos)
+}
+
+// expression parses the primaries joined by and and or
+func (p *exprParser) expression() (exprNode, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.text == ")" {
+			return left, nil
+		}
+
+		and := isOneOf(tok.text, "and", "&&")
+		if !and && !isOneOf(tok.text, "or", "||") {
+			return nil, p.unexpected(tok)
+		}
+
+		p.pos++
+
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = exprBinary{left: left, right: right, and: and}
+	}
+}
+
+// unary parses a negated or parenthesized expression, or a primitive
+func (p *exprParser) unary() (exprNode, error) {
+	tok, err := p.next("a primitive")
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.text == "not" || tok.text == "!" || tok.text == "(" {
+		p.depth++
+		defer func() { p.depth-- }()
+
+		if p.depth > maxExpressionDepth {
+			return nil, fmt.Errorf("%w: nested deeper than %d at %d", ErrInvalidExpression, maxExpressionDepth, tok.pos)
+		}
+	}
+
+	switch tok.text {
+	case "not", "!":
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return exprNot{x: x}, nil
+	case "(":
+		x, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+
+		if closing, err := p.next(`")"`); err != nil || closing.text != ")" {
+			return nil, fmt.Errorf("%w: unbalanced \"(\" at %d", ErrInvalidExpression, tok.pos)
+		}
+
+		return x, nil
+	}
+
+	return p.primitive(tok)
+}
+
+// primitive parses the primitive starting with tok
+func (p *exprParser) primitive(tok exprToken) (exprNode, error) {
+	switch tok.text {
+	case "ether":
+		return p.etherHost()
+	case "arp", "ip", "ip6":
+		families := map[string]exprFamilies{"arp": {arp: true}, "ip": {ipv4: true}, "ip6": {ipv6: true}}
+		if p.peekIs("host", "src", "dst") {
+			return p.host(families[tok.text])
+		}
+
+		ethTypes := map[string]uint32{"arp": etherTypeARP, "ip": etherTypeIPv4, "ip6": etherTypeIPv6}
+
+		return exprEtherType{ethType: ethTypes[tok.text], link: p.link}, nil
+	case "tcp", "udp":
+		protos := map[string]uint32{"tcp": ipProtoTCP, "udp": ipProtoUDP}
+		transport := exprTransport{protos: []uint32{protos[tok.text]}, link: p.link}
+
+		if p.peekIs("port", "src", "dst") {
+			return p.port(transport)
+		}
+
+		return transport, nil
+	case "vlan":
+		return p.vlan()
+	case "host":
+		p.pos--
+		return p.host(exprFamilies{ipv4: true, arp: true, ipv6: true})
+	case "src", "dst":
+		p.pos--
+		if p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].text == "port" {
+			return p.port(exprTransport{protos: []uint32{ipProtoTCP, ipProtoUDP}})
+		}
+
+		return p.host(exprFamilies{ipv4: true, arp: true, ipv6: true})
+	case "port":
+		p.pos--
+		return p.port(exprTransport{protos: []uint32{ipProtoTCP, ipProtoUDP}})
+	}
+
+	if isOneOf(tok.text, "and", "or", ")", "&&", "||") || unsupportedKeywords[tok.text] {
+		return nil, p.unexpected(tok)
+	}
+
+	// a bare value takes the qualifiers of the primitive before it
+	if p.last != nil {
+		return p.last(tok)
+	}
+
+	return nil, fmt.Errorf("%w: unknown primitive %q at %d", ErrInvalidExpression, tok.text, tok.pos)
+}
+
+// direction parses the optional src or dst qualifier
+func (p *exprParser) direction() (string, error) {
+	if !p.peekIs("src", "dst") {
+		return "", nil
+	}
+
+	tok, _ := p.next("a direction")
+
+	if p.peekIs("or", "and") {
+		return "", fmt.Errorf("%w: %q at %d, the directions are src or dst", ErrUnsupportedExpression,
+			tok.text+" "+p.tokens[p.pos].text, tok.pos)
+	}
+
+	return tok.text, nil
+}
+
+// etherHost parses ether [src|dst] [host] MAC
+func (p *exprParser) etherHost() (exprNode, error) {
+	dir, err := p.direction()
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == "" || p.peekIs("host") {
+		tok, err := p.next(`"host"`)
+		if err != nil {
+			return nil, err
+		}
+
+		if tok.text != "host" {
+			return nil, p.unexpected(tok)
+		}
+	}
+
+	p.last = func(tok exprToken) (exprNode, error) {
+		mac, err := net.ParseMAC(tok.text)
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("%w: %q at %d isn't an ethernet address", ErrInvalidExpression, tok.text, tok.pos)
+		}
+
+		return exprEtherHost{mac: mac, dir: dir}, nil
+	}
+
+	return p.value("an ethernet address")
+}
+
+// value parses the value of the primitive, with the qualifiers in p.last
+func (p *exprParser) value(what string) (exprNode, error) {
+	tok, err := p.next(what)
+	if err != nil {
+		return nil, err
+	}
+
+	if isOneOf(tok.text, "and", "or", "not", "(", ")", "!", "&&", "||") || unsupportedKeywords[tok.text] {
+		return nil, p.unexpected(tok)
+	}
+
+	return p.last(tok)
+}
+
+// host parses [src|dst] [host] ADDR, in the families
+func (p *exprParser) host(families exprFamilies) (exprNode, error) {
+	dir, err := p.direction()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peekIs("host") {
+		p.pos++
+	} else if dir == "" {
+		tok, err := p.next(`"host"`)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, p.unexpected(tok)
+	}
+
+	link := p.link
+
+	p.last = func(tok exprToken) (exprNode, error) {
+		if _, err := net.ParseMAC(tok.text); err == nil {
+			return nil, fmt.Errorf("%w: ethernet address %q at %d without \"ether\"", ErrInvalidExpression,
+				tok.text, tok.pos)
+		}
+
+		addr, err := netip.ParseAddr(tok.text)
+		if err != nil {
+			if strings.Trim(tok.text, "0123456789.:abcdefABCDEF") != "" && !strings.Contains(tok.text, "/") {
+				return nil, fmt.Errorf("%w: host name %q at %d, names aren't resolved", ErrUnsupportedExpression,
+					tok.text, tok.pos)
+			}
+
+			return nil, fmt.Errorf("%w: %q at %d isn't an address", ErrInvalidExpression, tok.text, tok.pos)
+		}
+
+		addr = addr.Unmap()
+		if addr.Zone() != "" || (addr.Is4() && !families.ipv4 && !families.arp) || (addr.Is6() && !families.ipv6) {
+			return nil, fmt.Errorf("%w: address %q at %d of another family", ErrInvalidExpression, tok.text, tok.pos)
+		}
+
+		return exprHost{dir: dir, addr: addr, exprFamilies: families, link: link}, nil
+	}
+
+	return p.value("an address")
+}
+
+// port parses [src|dst] port N, of the protocols of transport
+func (p *exprParser) port(transport exprTransport) (exprNode, error) {
+	dir, err := p.direction()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok, err := p.next(`"port"`); err != nil {
+		return nil, err
+	} else if tok.text != "port" {
+		return nil, p.unexpected(tok)
+	}
+
+	transport.link = p.link
+
+	p.last = func(tok exprToken) (exprNode, error) {
+		if strings.Trim(tok.text, "0123456789") != "" {
+			return nil, fmt.Errorf("%w: port name %q at %d, names aren't resolved", ErrUnsupportedExpression,
+				tok.text, tok.pos)
+		}
+
+		port, err := strconv.ParseUint(tok.text, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%w: port %q at %d", ErrInvalidExpression, tok.text, tok.pos)
+		}
+
+		return exprPort{dir: dir, exprTransport: transport, port: uint16(port)}, nil
+	}
+
+	return p.value("a port")
+}
+
+// vlan parses vlan [ID], the primitives following it look past the tag
+func (p *exprParser) vlan() (exprNode, error) {
+	n := exprVLAN{link: p.link}
+
+	if tok, ok := p.peek(); ok && tok.text != "" && tok.text[0] >= '0' && tok.text[0] <= '9' {
+		p.pos++
+
+		id, err := strconv.ParseUint(tok.text, 10, 12)
+		if err != nil {
+			return nil, fmt.Errorf("%w: VLAN %q at %d", ErrInvalidExpression, tok.text, tok.pos)
+		}
+
+		n.id = new(uint16)
+		*n.id = uint16(id)
+	}
+
+	p.link += 4
+	p.last = nil
+
+	return n, nil
+}
+
+func isOneOf(s string, words ...string) bool {
+	for _, w := range words {
+		if s == w {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compileExpression generates the instructions of expr in p, jumping to
+// match or fail
+func compileExpression(p *filterProgram, expr, match, fail string) error {
+	n, err := parseExpression(expr)
+	if err != nil {
+		return err
+	}
+
+	if n == nil {
+		p.jump(match)
+		return nil
+	}
+
+	n.gen(&exprGen{p: p}, match, fail)
+
+	if len(p.insns) > maxFilterInsns {
+		return fmt.Errorf("%w: %d instructions, the kernel takes at most %d", ErrUnsupportedExpression,
+			len(p.insns), maxFilterInsns)
+	}
+
+	return nil
+}
+
+// expressionVM returns the VM running expr, for the frames filtered in
+// userspace
+func expressionVM(expr string) (*bpf.VM, error) {
+	p := &filterProgram{labels: make(map[string]int)}
+
+	if err := compileExpression(p, expr, "accept", "reject"); err != nil {
+		return nil, err
+	}
+
+	p.label("reject")
+	p.add(bpf.RetConstant{Val: 0})
+	p.label("accept")
+	p.add(bpf.RetConstant{Val: 1})
+
+	raw, err := p.assemble()
+	if err != nil {
+		return nil, err
+	}
+
+	insns, _ := bpf.Disassemble(raw)
+
+	return bpf.NewVM(insns)
+}
+
+// Disassemble returns the instructions of filter as tcpdump -d prints them,
+// their jumps to the absolute positions they go to
+func Disassemble(filter []bpf.RawInstruction) []string {
+	lines := make([]string, 0, len(filter))
+
+	for pc, ins := range filter {
+		op, operand := instructionImage(ins)
+
+		var line string
+
+		switch {
+		case ins.Op == 0x05:
+			line = fmt.Sprintf("(%03d) %-8s %d", pc, op, pc+1+int(ins.K))
+		case ins.Op&0x07 == 0x05 && op != "unimp":
+			line = fmt.Sprintf("(%03d) %-8s %-16s jt %d\tjf %d", pc, op, operand,
+				pc+1+int(ins.Jt), pc+1+int(ins.Jf))
+		default:
+			line = strings.TrimRight(fmt.Sprintf("(%03d) %-8s %s", pc, op, operand), " ")
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// instructionImage returns the mnemonic and the operand of ins, with the
+// opcodes of linux/filter.h
+//
+//nolint:cyclop,gocyclo // a case per addressing mode
+func instructionImage(ins bpf.RawInstruction) (string, string) {
+	sizes := map[uint16]string{0x00: "ld", 0x08: "ldh", 0x10: "ldb"}
+	alu := map[uint16]string{0x00: "add", 0x10: "sub", 0x20: "mul", 0x30: "div", 0x40: "or", 0x50: "and",
+		0x60: "lsh", 0x70: "rsh", 0x90: "mod", 0xa0: "xor"}
+	jumps := map[uint16]string{0x10: "jeq", 0x20: "jgt", 0x30: "jge", 0x40: "jset"}
+
+	k := ins.K
+
+	switch class, mode := ins.Op&0x07, ins.Op&0xe0; {
+	case class == 0x00 && mode == 0x20 && sizes[ins.Op&0x18] != "":
+		return sizes[ins.Op&0x18], fmt.Sprintf("[%d]", int32(k)) //nolint:gosec // the extensions are negative
+	case class == 0x00 && mode == 0x40 && sizes[ins.Op&0x18] != "":
+		return sizes[ins.Op&0x18], fmt.Sprintf("[x + %d]", k)
+	case ins.Op == 0x00:
+		return "ld", fmt.Sprintf("#0x%x", k)
+	case ins.Op == 0x01:
+		return "ldx", fmt.Sprintf("#0x%x", k)
+	case ins.Op == 0x80:
+		return "ld", "#pktlen"
+	case ins.Op == 0x81:
+		return "ldx", "#pktlen"
+	case ins.Op == 0x60:
+		return "ld", fmt.Sprintf("M[%d]", k)
+	case ins.Op == 0x61:
+		return "ldx", fmt.Sprintf("M[%d]", k)
+	case ins.Op == 0xb1:
+		return "ldxb", fmt.Sprintf("4*([%d]&0xf)", k)
+	case ins.Op == 0x02:
+		return "st", fmt.Sprintf("M[%d]", k)
+	case ins.Op == 0x03:
+		return "stx", fmt.Sprintf("M[%d]", k)
+	case ins.Op == 0x06:
+		return "ret", fmt.Sprintf("#%d", k)
+	case ins.Op == 0x16:
+		return "ret", ""
+	case ins.Op == 0x05:
+		return "ja", ""
+	case class == 0x05 && jumps[ins.Op&0xf0] != "":
+		if ins.Op&0x08 != 0 {
+			return jumps[ins.Op&0xf0], "x"
+		}
+
+		return jumps[ins.Op&0xf0], fmt.Sprintf("#0x%x", k)
+	case ins.Op == 0x84:
+		return "neg", ""
+	case class == 0x04 && alu[ins.Op&0xf0] != "":
+		switch {
+		case ins.Op&0x08 != 0:
+			return alu[ins.Op&0xf0], "x"
+		case isOneOf(alu[ins.Op&0xf0], "and", "or", "xor"):
+			return alu[ins.Op&0xf0], fmt.Sprintf("#0x%x", k)
+		}
+
+		return alu[ins.Op&0xf0], fmt.Sprintf("#%d", k)
+	case ins.Op == 0x07:
+		return "tax", ""
+	case ins.Op == 0x87:
+		return "txa", ""
+	}
+
+	return "unimp", fmt.Sprintf("0x%x", ins.Op)
+}
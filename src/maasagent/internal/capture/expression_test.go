@@ -0,0 +1,315 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/binary"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+// l4Packet returns an IPv4 or IPv6 packet of proto, the ports following its
+// header. The IPv4 header is ihl words long, of the fragment at offset.
+func l4Packet(proto uint8, src, dst netip.AddrPort, ihl int, offset uint16) func(*ethernet.FrameBuilder) {
+	var pkt []byte
+
+	if src.Addr().Is4() {
+		pkt = make([]byte, ihl*4)
+		pkt[0] = 0x40 | byte(ihl) //nolint:gosec // at most 15
+		binary.BigEndian.PutUint16(pkt[6:8], offset)
+		pkt[8] = 64
+		pkt[9] = proto
+		copy(pkt[12:16], src.Addr().AsSlice())
+		copy(pkt[16:20], dst.Addr().AsSlice())
+	} else {
+		pkt = make([]byte, 40)
+		pkt[0] = 0x60
+		pkt[6] = proto
+		pkt[7] = 64
+		copy(pkt[8:24], src.Addr().AsSlice())
+		copy(pkt[24:40], dst.Addr().AsSlice())
+	}
+
+	pkt = binary.BigEndian.AppendUint16(pkt, src.Port())
+	pkt = binary.BigEndian.AppendUint16(pkt, dst.Port())
+	pkt = append(pkt, make([]byte, 16)...)
+
+	ethType := ethernet.EthernetTypeIPv4
+	if src.Addr().Is6() {
+		ethType = ethernet.EthernetTypeIPv6
+	}
+
+	return func(b *ethernet.FrameBuilder) {
+		b.Payload(ethType, pkt)
+	}
+}
+
+// referenceFrames are a frame of every kind the primitives tell apart
+func referenceFrames(t *testing.T) map[string][]byte {
+	t.Helper()
+
+	port := netip.AddrPortFrom
+
+	qinq, err := ethernet.NewFrame().Src(otherMAC).Dst(otherMAC).
+		VLAN(20, ethernet.WithTPID(ethernet.EthernetTypeQinQ)).VLAN(10).
+		ARPRequest(targetV4, otherV4).Build()
+	require.NoError(t, err)
+
+	return map[string][]byte{
+		"arp": targetFrame(t, targetMAC, otherMAC, 0, arp(targetV4, otherV4)),
+		"udp4": targetFrame(t, otherMAC, targetMAC, 0,
+			l4Packet(ipProtoUDP, port(otherV4, 1000), port(targetV4, 53), 5, 0)),
+		// the IPv4 options shift the ports
+		"tcp4": targetFrame(t, targetMAC, otherMAC, 0,
+			l4Packet(ipProtoTCP, port(targetV4, 80), port(otherV4, 40000), 6, 0)),
+		// a fragment but the first has no ports, its payload looks like
+		// those of port 53
+		"frag4": targetFrame(t, otherMAC, otherMAC, 0,
+			l4Packet(ipProtoUDP, port(otherV4, 53), port(otherV4, 53), 5, 100)),
+		"udp6": targetFrame(t, otherMAC, otherMAC, 0,
+			l4Packet(ipProtoUDP, port(targetV6, 53), port(otherV6, 33000), 0, 0)),
+		"tcp6": targetFrame(t, otherMAC, otherMAC, 0,
+			l4Packet(ipProtoTCP, port(otherV6, 40000), port(targetV6, 80), 0, 0)),
+		"vlan10": targetFrame(t, otherMAC, otherMAC, 10,
+			l4Packet(ipProtoUDP, port(otherV4, 1000), port(targetV4, 53), 5, 0)),
+		"qinq": qinq,
+	}
+}
+
+func TestExpression(t *testing.T) {
+	t.Parallel()
+
+	frames := referenceFrames(t)
+	all := slices.Sorted(maps.Keys(frames))
+
+	testcases := map[string][]string{
+		"":                                 all,
+		"arp":                              {"arp"},
+		"ip":                               {"frag4", "tcp4", "udp4"},
+		"ip6":                              {"tcp6", "udp6"},
+		"udp":                              {"frag4", "udp4", "udp6"},
+		"tcp":                              {"tcp4", "tcp6"},
+		"vlan":                             {"qinq", "vlan10"},
+		"vlan 10":                          {"vlan10"},
+		"vlan 20":                          {"qinq"},
+		"vlan 20 and vlan 10 and arp":      {"qinq"},
+		"vlan and ip":                      {"vlan10"},
+		"vlan 10 and udp dst port 53":      {"vlan10"},
+		"vlan and host 10.0.0.1":           {"vlan10"},
+		"ether host 00:16:3e:00:00:01":     {"arp", "tcp4", "udp4"},
+		"ether src 00:16:3e:00:00:01":      {"arp", "tcp4"},
+		"ether src host 00:16:3e:00:00:01": {"arp", "tcp4"},
+		"ether dst 00:16:3e:00:00:01":      {"udp4"},
+		"ether host 00:16:3e:00:00:01 or 00:16:3e:00:00:02": all,
+		"port 53":                      {"udp4", "udp6"},
+		"src port 53":                  {"udp6"},
+		"dst port 53":                  {"udp4"},
+		"udp port 53":                  {"udp4", "udp6"},
+		"tcp port 53":                  nil,
+		"tcp port 80":                  {"tcp4", "tcp6"},
+		"tcp src port 80":              {"tcp4"},
+		"tcp dst port 80":              {"tcp6"},
+		"udp port 80":                  nil,
+		"port 53 or 80":                {"tcp4", "tcp6", "udp4", "udp6"},
+		"udp and not port 53":          {"frag4"},
+		"host 10.0.0.1":                {"arp", "tcp4", "udp4"},
+		"src host 10.0.0.1":            {"arp", "tcp4"},
+		"src 10.0.0.1":                 {"arp", "tcp4"},
+		"dst host 10.0.0.1":            {"udp4"},
+		"ip host 10.0.0.1":             {"tcp4", "udp4"},
+		"arp host 10.0.0.1":            {"arp"},
+		"arp dst host 10.0.0.2":        {"arp"},
+		"host 2001:db8::1":             {"tcp6", "udp6"},
+		"ip6 dst host 2001:db8::1":     {"tcp6"},
+		"host 10.0.0.1 or 2001:db8::1": {"arp", "tcp4", "tcp6", "udp4", "udp6"},
+		"host ::ffff:10.0.0.1":         {"arp", "tcp4", "udp4"},
+		"not arp":                      {"frag4", "qinq", "tcp4", "tcp6", "udp4", "udp6", "vlan10"},
+		"! ip && ! ip6":                {"arp", "qinq", "vlan10"},
+		"arp || ip6":                   {"arp", "tcp6", "udp6"},
+		"not not arp":                  {"arp"},
+		// and and or have the same precedence, as with libpcap
+		"arp or ip and udp":        {"frag4", "udp4"},
+		"arp or (ip and udp)":      {"arp", "frag4", "udp4"},
+		"not (ip or ip6)":          {"arp", "qinq", "vlan10"},
+		"(tcp or udp) and not ip6": {"frag4", "tcp4", "udp4"},
+	}
+
+	for expr, match := range testcases {
+		expr, match := expr, match
+
+		t.Run(expr, func(t *testing.T) {
+			t.Parallel()
+
+			filter, err := TargetFilter(Target{Expression: expr}, nil)
+			require.NoError(t, err)
+
+			norm, err := Target{Expression: expr}.Normalize()
+			require.NoError(t, err)
+
+			for _, name := range all {
+				want := slices.Contains(match, name)
+
+				assert.Equal(t, want, runFilter(t, filter, frames[name]), "filter of %s", name)
+				assert.Equal(t, want, norm.Match(frames[name]), "match of %s", name)
+				assert.Equal(t, want, Target{Expression: expr}.Match(frames[name]), "match of %s", name)
+			}
+		})
+	}
+}
+
+func TestExpressionTarget(t *testing.T) {
+	t.Parallel()
+
+	frames := referenceFrames(t)
+
+	// the expression and the hosts both have to match
+	target := Target{MACs: []net.HardwareAddr{targetMAC}, IPs: []netip.Addr{targetV6}, Expression: "udp"}
+
+	filter, err := TargetFilter(target, nil)
+	require.NoError(t, err)
+
+	norm, err := target.Normalize()
+	require.NoError(t, err)
+
+	for name, match := range map[string]bool{"arp": false, "udp4": true, "tcp4": false, "udp6": true, "frag4": false} {
+		assert.Equal(t, match, runFilter(t, filter, frames[name]), name)
+		assert.Equal(t, match, norm.Match(frames[name]), name)
+	}
+
+	// the frames too short for the expression never match
+	assert.False(t, runFilter(t, filter, frames["udp4"][:20]))
+	assert.False(t, norm.Match(frames["udp4"][:20]))
+
+	assert.False(t, Target{Expression: "  "}.hosts())
+	assert.True(t, Target{Expression: " \t"}.Empty())
+	assert.False(t, Target{Expression: "arp"}.Empty())
+
+	norm, err = Target{Expression: " arp "}.Normalize()
+	require.NoError(t, err)
+	assert.Equal(t, "arp", norm.Expression)
+}
+
+func TestExpressionErrors(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]error{
+		"arp and":                 ErrInvalidExpression,
+		"and arp":                 ErrInvalidExpression,
+		"(arp":                    ErrInvalidExpression,
+		"arp)":                    ErrInvalidExpression,
+		"arp ip":                  ErrInvalidExpression,
+		"foo":                     ErrInvalidExpression,
+		"ether":                   ErrInvalidExpression,
+		"ether host 10.0.0.1":     ErrInvalidExpression,
+		"ether 00:16:3e:00:00:01": ErrInvalidExpression,
+		"host 00:16:3e:00:00:01":  ErrInvalidExpression,
+		"host 10.0.0.0/8":         ErrInvalidExpression,
+		"host fe80::1%eth0":       ErrUnsupportedExpression,
+		"ip6 host 10.0.0.1":       ErrInvalidExpression,
+		"ip host 2001:db8::1":     ErrInvalidExpression,
+		"port 65536":              ErrInvalidExpression,
+		"port":                    ErrInvalidExpression,
+		"vlan 4096":               ErrInvalidExpression,
+		"vlan 10 or 20":           ErrInvalidExpression,
+		"arp $":                   ErrInvalidExpression,
+		strings.Repeat("(", 100) + "arp" + strings.Repeat(")", 100): ErrInvalidExpression,
+		"net 10.0.0.0/8":           ErrUnsupportedExpression,
+		"icmp":                     ErrUnsupportedExpression,
+		"ip proto 17":              ErrUnsupportedExpression,
+		"ether broadcast":          ErrUnsupportedExpression,
+		"ether[0] & 1 != 0":        ErrUnsupportedExpression,
+		"tcp portrange 1-1024":     ErrUnsupportedExpression,
+		"less 100":                 ErrUnsupportedExpression,
+		"host example.com":         ErrUnsupportedExpression,
+		"port domain":              ErrUnsupportedExpression,
+		"src or dst host 10.0.0.1": ErrUnsupportedExpression,
+		"arp and inbound":          ErrUnsupportedExpression,
+		strings.Repeat("port 1 or ", 300) + "port 1": ErrUnsupportedExpression,
+	}
+
+	for expr, want := range testcases {
+		expr, want := expr, want
+
+		t.Run(expr, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := TargetFilter(Target{Expression: expr}, nil)
+			require.ErrorIs(t, err, want)
+
+			_, err = Target{Expression: expr}.Normalize()
+			require.ErrorIs(t, err, want)
+
+			assert.False(t, Target{Expression: expr}.Match(make([]byte, 64)))
+		})
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	t.Parallel()
+
+	raw, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: etherTypeIPv4, SkipTrue: 0, SkipFalse: 13},
+		bpf.LoadAbsolute{Off: 23, Size: 1},
+		bpf.LoadAbsolute{Off: 26, Size: 4},
+		bpf.LoadMemShift{Off: 14},
+		bpf.LoadIndirect{Off: 16, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: 1},
+		bpf.JumpIf{Cond: bpf.JumpGreaterThan, Val: 1024, SkipFalse: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xfff},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: 4},
+		bpf.StoreScratch{Src: bpf.RegA, N: 1},
+		bpf.LoadScratch{Dst: bpf.RegX, N: 1},
+		bpf.TXA{},
+		bpf.LoadExtension{Num: bpf.ExtLen},
+		bpf.Jump{Skip: 1},
+		bpf.RetConstant{Val: 0},
+		bpf.RetA{},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"(000) ldh      [12]",
+		"(001) jeq      #0x800           jt 2\tjf 15",
+		"(002) ldb      [23]",
+		"(003) ld       [26]",
+		"(004) ldxb     4*([14]&0xf)",
+		"(005) ldh      [x + 16]",
+		"(006) jset     #0x1fff          jt 8\tjf 7",
+		"(007) jgt      #0x400           jt 8\tjf 9",
+		"(008) and      #0xfff",
+		"(009) add      #4",
+		"(010) st       M[1]",
+		"(011) ldx      M[1]",
+		"(012) txa",
+		"(013) ld       #pktlen",
+		"(014) ja       16",
+		"(015) ret      #0",
+		"(016) ret",
+	}, Disassemble(raw))
+
+	assert.Equal(t, []string{"(000) unimp    0xffff"}, Disassemble([]bpf.RawInstruction{{Op: 0xffff}}))
+}
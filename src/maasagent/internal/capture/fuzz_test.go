@@ -0,0 +1,46 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+func FuzzSummarizerAdd(f *testing.F) {
+	for _, seed := range [][]byte{
+		summaryFrame(1, 0x08, 0x06),
+		summaryFrame(1, 0x81, 0x00, 0x00, 0x64, 0x86, 0xdd),
+		ptpFrame(0x0b, 0, 1, 128),
+		mldFrame(1, mldv1(130, "::")...),
+		mldFrame(1, mldv2Record(4, "ff05::1:3", 1)...),
+		lacpduFrame(0x3d),
+		cfmFrame(5, 1),
+		lldpduFrame(0x64),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		s := NewSummarizer("eth0")
+
+		fuzz.Bounded(t, in, func() {
+			s.Add(in, Metadata{Length: len(in)})
+			_ = s.Snapshot()
+		})
+	})
+}
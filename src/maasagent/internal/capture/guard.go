@@ -0,0 +1,242 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+// ErrTransmitRejected is returned by a GuardedWriter for a frame it refuses
+// to send, one whose source isn't the interface it is sent on
+var ErrTransmitRejected = errors.New("transmit rejected")
+
+// LinkAddrSource looks up the addresses of the host interfaces,
+// netif.Inventory implements it
+type LinkAddrSource interface {
+	// LinkAddrs returns the MAC and the addresses configured on the
+	// interface name, or on its VLAN sub-interface vid unless 0
+	LinkAddrs(name string, vid uint16) (net.HardwareAddr, []netip.Prefix, bool)
+}
+
+// SystemLinkAddrs looks the interfaces up with the net package, it doesn't
+// know of their VLAN sub-interfaces
+type SystemLinkAddrs struct{}
+
+// LinkAddrs implements LinkAddrSource
+func (SystemLinkAddrs) LinkAddrs(name string, vid uint16) (net.HardwareAddr, []netip.Prefix, bool) {
+	if vid != 0 {
+		return nil, nil, false
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, nil, false
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(addrs))
+
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			ip, _ := netip.AddrFromSlice(ipNet.IP)
+			ones, _ := ipNet.Mask.Size()
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ones))
+		}
+	}
+
+	return iface.HardwareAddr, prefixes, true
+}
+
+// GuardedWriter writes the frames sourced from its interface and rejects
+// the others, which the port security and the dynamic ARP inspection of a
+// switch could punish by disabling the port. A frame is sourced from the
+// interface when its source MAC is the interface's, the VLAN sub-interface's
+// of its tag or a registered virtual MAC, and the sender of an ARP packet is
+// also one of them with an address configured on the interface or that
+// sub-interface, or unspecified as in a probe.
+type GuardedWriter struct {
+	w        FrameWriter
+	links    LinkAddrSource
+	virtual  map[[6]byte]struct{}
+	iface    string
+	override bool
+}
+
+// GuardOption configures a GuardedWriter
+type GuardOption func(*GuardedWriter)
+
+// WithGuardSource sets where the addresses of the interface are looked up,
+// in place of SystemLinkAddrs
+func WithGuardSource(links LinkAddrSource) GuardOption {
+	return func(g *GuardedWriter) {
+		if links != nil {
+			g.links = links
+		}
+	}
+}
+
+// WithVirtualMACs lets the frames be sourced from macs as well, such as
+// those of the virtual routers the host takes part in
+func WithVirtualMACs(macs ...net.HardwareAddr) GuardOption {
+	return func(g *GuardedWriter) {
+		for _, mac := range macs {
+			if len(mac) == 6 {
+				g.virtual[[6]byte(mac)] = struct{}{}
+			}
+		}
+	}
+}
+
+// WithGuardOverride writes every frame unchecked, for the writers sending
+// the frames of other hosts on purpose, such as a replay or a traffic
+// generator, which must not be pointed at a production port
+func WithGuardOverride() GuardOption {
+	return func(g *GuardedWriter) {
+		g.override = true
+	}
+}
+
+// NewGuardedWriter returns a GuardedWriter sending on iface through w
+func NewGuardedWriter(w FrameWriter, iface string, options ...GuardOption) *GuardedWriter {
+	g := &GuardedWriter{
+		w:       w,
+		links:   SystemLinkAddrs{},
+		virtual: make(map[[6]byte]struct{}),
+		iface:   iface,
+	}
+
+	for _, opt := range options {
+		opt(g)
+	}
+
+	return g
+}
+
+// Check returns an error matching ErrTransmitRejected if frame isn't
+// sourced from the interface
+func (g *GuardedWriter) Check(frame []byte) error {
+	if g.override {
+		return nil
+	}
+
+	var eth ethernet.EthernetFrame
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return fmt.Errorf("%w on %s: %w", ErrTransmitRejected, g.iface, err)
+	}
+
+	mac, addrs, ok := g.links.LinkAddrs(g.iface, 0)
+	if !ok {
+		return fmt.Errorf("%w: %s isn't a known interface", ErrTransmitRejected, g.iface)
+	}
+
+	macs := []net.HardwareAddr{mac}
+
+	if vlan, err := eth.ExtractVLAN(); err == nil && vlan.ID != 0 {
+		if subMAC, subAddrs, ok := g.links.LinkAddrs(g.iface, vlan.ID); ok {
+			macs = append(macs, subMAC)
+			addrs = append(addrs[:len(addrs):len(addrs)], subAddrs...)
+		}
+	}
+
+	if !g.ours(macs, eth.SrcMAC) {
+		return fmt.Errorf("%w: source MAC %s isn't one of %s", ErrTransmitRejected, eth.SrcMAC, g.iface)
+	}
+
+	// only ARP carries a sender to check
+	pkt, err := eth.ExtractARPPacket()
+	if errors.Is(err, ethernet.ErrNotARP) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w on %s: %w", ErrTransmitRejected, g.iface, err)
+	}
+
+	if !g.ours(macs, pkt.SendHwAddr) {
+		return fmt.Errorf("%w: ARP sender MAC %s isn't one of %s", ErrTransmitRejected, pkt.SendHwAddr, g.iface)
+	}
+
+	ip := pkt.SenderAddr()
+	if ip.IsUnspecified() {
+		return nil
+	}
+
+	for _, p := range addrs {
+		if p.Addr().Unmap() == ip {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: ARP sender %s isn't configured on %s", ErrTransmitRejected, ip, g.iface)
+}
+
+func (g *GuardedWriter) ours(macs []net.HardwareAddr, mac net.HardwareAddr) bool {
+	for _, m := range macs {
+		if len(m) == 6 && bytes.Equal(m, mac) {
+			return true
+		}
+	}
+
+	if len(mac) != 6 {
+		return false
+	}
+
+	_, ok := g.virtual[[6]byte(mac)]
+
+	return ok
+}
+
+// WriteFrame writes frame unless Check rejects it
+func (g *GuardedWriter) WriteFrame(frame []byte) error {
+	if err := g.Check(frame); err != nil {
+		return err
+	}
+
+	return g.w.WriteFrame(frame)
+}
+
+// WriteFrames writes frames in order up to the first Check rejects, which
+// error is returned with the number of frames sent before it
+func (g *GuardedWriter) WriteFrames(frames [][]byte) (int, error) {
+	allowed := len(frames)
+
+	var rejected error
+
+	for i, f := range frames {
+		if err := g.Check(f); err != nil {
+			allowed, rejected = i, err
+			break
+		}
+	}
+
+	n, err := WriteFrames(g.w, frames[:allowed])
+	if err != nil {
+		return n, err
+	}
+
+	return n, rejected
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var (
+	guardMAC     = net.HardwareAddr{0x00, 0x16, 0x3e, 0x12, 0x34, 0x56}
+	guardVLANMAC = net.HardwareAddr{0x00, 0x16, 0x3e, 0x12, 0x34, 0x57}
+	guardVirtual = net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x01, 0x01}
+	guardOther   = net.HardwareAddr{0x52, 0x54, 0x00, 0xaa, 0xbb, 0xcc}
+)
+
+// guardLinks is eth0 with 10.0.0.2/24, and its VLAN 12 sub-interface with
+// 10.0.12.2/24
+type guardLinks struct{}
+
+func (guardLinks) LinkAddrs(name string, vid uint16) (net.HardwareAddr, []netip.Prefix, bool) {
+	switch {
+	case name != "eth0":
+		return nil, nil, false
+	case vid == 0:
+		return guardMAC, []netip.Prefix{netip.MustParsePrefix("10.0.0.2/24")}, true
+	case vid == 12:
+		return guardVLANMAC, []netip.Prefix{netip.MustParsePrefix("10.0.12.2/24")}, true
+	}
+
+	return nil, nil, false
+}
+
+func TestGuardedWriter(t *testing.T) {
+	t.Parallel()
+
+	arp := func(src net.HardwareAddr, vid uint16, ip string) []byte {
+		b := ethernet.NewFrame().Src(src)
+		if vid != 0 {
+			b = b.VLAN(vid)
+		}
+
+		frame, err := b.ARPReply(netip.MustParseAddr(ip), guardOther, netip.MustParseAddr("10.0.0.9")).Build()
+		require.NoError(t, err)
+
+		return frame
+	}
+
+	build := func(b *ethernet.FrameBuilder) []byte {
+		frame, err := b.Build()
+		require.NoError(t, err)
+
+		return frame
+	}
+
+	// a reply sent from the interface for another host
+	relayed := arp(guardMAC, 0, "10.0.0.2")
+	copy(relayed[14+8:], guardOther)
+
+	testcases := map[string]struct {
+		frame   []byte
+		iface   string
+		options []GuardOption
+		ok      bool
+	}{
+		"own MAC": {
+			frame: build(ethernet.NewFrame().Src(guardMAC).WakeOnLAN(guardOther)),
+			ok:    true,
+		},
+		"other MAC": {
+			frame: build(ethernet.NewFrame().Src(guardOther).WakeOnLAN(guardOther)),
+		},
+		"virtual MAC": {
+			frame:   build(ethernet.NewFrame().Src(guardVirtual).WakeOnLAN(guardOther)),
+			options: []GuardOption{WithVirtualMACs(guardVirtual)},
+			ok:      true,
+		},
+		"MAC of the VLAN sub-interface": {
+			frame: build(ethernet.NewFrame().Src(guardVLANMAC).VLAN(12).WakeOnLAN(guardOther)),
+			ok:    true,
+		},
+		"MAC of the VLAN sub-interface untagged": {
+			frame: build(ethernet.NewFrame().Src(guardVLANMAC).WakeOnLAN(guardOther)),
+		},
+		"ARP from a configured address": {
+			frame: arp(guardMAC, 0, "10.0.0.2"),
+			ok:    true,
+		},
+		"ARP from another address": {
+			frame: arp(guardMAC, 0, "10.0.0.3"),
+		},
+		"ARP from another host": {
+			frame: relayed,
+		},
+		"ARP from an address of the VLAN": {
+			frame: arp(guardMAC, 12, "10.0.12.2"),
+			ok:    true,
+		},
+		"ARP from an address of another VLAN": {
+			frame: arp(guardMAC, 13, "10.0.12.2"),
+		},
+		"ARP probe": {
+			frame: build(ethernet.NewFrame().Src(guardMAC).VLAN(13).ARPRequest(netip.IPv4Unspecified(),
+				netip.MustParseAddr("169.254.1.1"))),
+			ok: true,
+		},
+		"unknown interface": {
+			frame: build(ethernet.NewFrame().Src(guardMAC).WakeOnLAN(guardOther)),
+			iface: "eth1",
+		},
+		"override": {
+			frame:   arp(guardOther, 0, "10.0.0.3"),
+			options: []GuardOption{WithGuardOverride()},
+			ok:      true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			iface := "eth0"
+			if tc.iface != "" {
+				iface = tc.iface
+			}
+
+			r := &frameRecorder{}
+			g := NewGuardedWriter(r, iface, append([]GuardOption{WithGuardSource(guardLinks{})}, tc.options...)...)
+
+			err := g.WriteFrame(tc.frame)
+			if !tc.ok {
+				assert.ErrorIs(t, err, ErrTransmitRejected)
+				assert.Empty(t, r.frames)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, [][]byte{tc.frame}, r.frames)
+		})
+	}
+}
+
+func TestGuardedWriterBatch(t *testing.T) {
+	t.Parallel()
+
+	build := func(src net.HardwareAddr) []byte {
+		frame, err := ethernet.NewFrame().Src(src).WakeOnLAN(guardOther).Build()
+		require.NoError(t, err)
+
+		return frame
+	}
+
+	frames := [][]byte{build(guardMAC), build(guardMAC), build(guardOther), build(guardMAC)}
+
+	r := &frameRecorder{}
+	g := NewGuardedWriter(r, "eth0", WithGuardSource(guardLinks{}))
+
+	n, err := WriteFrames(g, frames)
+	assert.ErrorIs(t, err, ErrTransmitRejected)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, frames[:2], r.frames)
+
+	assert.ErrorIs(t, g.WriteFrame([]byte{0x01}), ErrTransmitRejected)
+}
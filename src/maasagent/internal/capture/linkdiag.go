@@ -0,0 +1,219 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"cmp"
+	"slices"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/lldp"
+)
+
+// cfmLevels is the number of maintenance domain levels
+const cfmLevels = 8
+
+// LACPPort is one end of an LACP link as described by an LACPDU
+type LACPPort struct {
+	System         string `json:"system"`
+	State          string `json:"state"`
+	SystemPriority uint16 `json:"system_priority"`
+	Key            uint16 `json:"key"`
+	PortPriority   uint16 `json:"port_priority"`
+	Port           uint16 `json:"port"`
+}
+
+// LACPSummary reports the LACPDUs received during the interval, which mean
+// the switch expects the port to be a member of a LAG
+type LACPSummary struct {
+	// Actor is the switch port of the latest LACPDU, Partner is what the
+	// switch knows of the host
+	Actor   LACPPort `json:"actor"`
+	Partner LACPPort `json:"partner"`
+	PDUs    uint64   `json:"pdus"`
+	// Aggregated is true when the switch has the port in sync with an
+	// aggregate, a port left defaulted or out of sync isn't forwarding
+	Aggregated bool `json:"aggregated"`
+}
+
+// CFMLevel counts the CFM PDUs of a maintenance domain level by opcode
+type CFMLevel struct {
+	OpCodes map[string]uint64 `json:"opcodes"`
+	Level   uint8             `json:"level"`
+}
+
+// LLDPVLAN is a VLAN of the switch port
+type LLDPVLAN struct {
+	Name string `json:"name,omitempty"`
+	ID   uint16 `json:"id"`
+}
+
+// LLDPAggregation is the link aggregation status of the switch port
+type LLDPAggregation struct {
+	// PortID is the ifIndex of the aggregate on the switch
+	PortID  uint32 `json:"port_id,omitempty"`
+	Capable bool   `json:"capable"`
+	Enabled bool   `json:"enabled"`
+}
+
+// LLDPSummary is the switch port the interface is connected to, from the
+// latest LLDPDU of the interval
+type LLDPSummary struct {
+	// Aggregation is set when the switch reports the link aggregation
+	// status of the port
+	Aggregation     *LLDPAggregation `json:"aggregation,omitempty"`
+	Chassis         string           `json:"chassis"`
+	Port            string           `json:"port"`
+	PortDescription string           `json:"port_description,omitempty"`
+	SystemName      string           `json:"system_name,omitempty"`
+	// VLANs are the VLANs of the port the switch named
+	VLANs []LLDPVLAN `json:"vlans,omitempty"`
+	PDUs  uint64     `json:"pdus"`
+	// NativeVLAN is the untagged VLAN of the port, 0 when the switch
+	// doesn't tell
+	NativeVLAN   uint16 `json:"native_vlan,omitempty"`
+	MaxFrameSize uint16 `json:"max_frame_size,omitempty"`
+}
+
+// linkTracker accounts the link diagnostics protocols seen on an interface
+type linkTracker struct {
+	lacp     *ethernet.LACPPacket
+	lldp     *LLDPSummary
+	cfm      [cfmLevels]map[ethernet.CFMOpCode]uint64
+	lacpPDUs uint64
+}
+
+func (t *linkTracker) add(frame []byte) {
+	if d, err := lldp.ParseFrame(frame); err == nil {
+		t.addLLDP(d)
+		return
+	}
+
+	var eth ethernet.EthernetFrame
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return
+	}
+
+	if lacp, err := eth.ExtractLACP(); err == nil {
+		// the LACPDU aliases the frame, which the caller reuses
+		lacp.Actor.System = slices.Clone(lacp.Actor.System)
+		lacp.Partner.System = slices.Clone(lacp.Partner.System)
+		t.lacp = lacp
+		t.lacpPDUs++
+
+		return
+	}
+
+	if c, err := eth.ExtractCFM(); err == nil {
+		if t.cfm[c.Level] == nil {
+			t.cfm[c.Level] = make(map[ethernet.CFMOpCode]uint64)
+		}
+
+		t.cfm[c.Level][c.OpCode]++
+	}
+}
+
+// addLLDP replaces the switch port by the one of d, which only the counter
+// outlives. The summary holds strings, so doesn't alias the frame.
+func (t *linkTracker) addLLDP(d lldp.LLDPDU) {
+	var pdus uint64
+	if t.lldp != nil {
+		pdus = t.lldp.PDUs
+	}
+
+	t.lldp = &LLDPSummary{
+		Chassis:         d.ChassisID.String(),
+		Port:            d.PortID.String(),
+		PortDescription: d.PortDescription,
+		SystemName:      d.SystemName,
+		NativeVLAN:      d.PortVLAN,
+		MaxFrameSize:    d.MaxFrameSize,
+		PDUs:            pdus + 1,
+	}
+
+	if d.Aggregation != nil {
+		t.lldp.Aggregation = &LLDPAggregation{
+			Capable: d.Aggregation.Capable,
+			Enabled: d.Aggregation.Enabled,
+			PortID:  d.Aggregation.PortID,
+		}
+	}
+
+	for _, v := range d.VLANNames {
+		t.lldp.VLANs = append(t.lldp.VLANs, LLDPVLAN{ID: v.ID, Name: v.Name})
+	}
+}
+
+func (t *linkTracker) lacpSummary() *LACPSummary {
+	if t.lacp == nil {
+		return nil
+	}
+
+	state := t.lacp.Actor.State
+
+	return &LACPSummary{
+		Actor:   lacpPort(t.lacp.Actor),
+		Partner: lacpPort(t.lacp.Partner),
+		PDUs:    t.lacpPDUs,
+		Aggregated: state.Has(ethernet.LACPStateAggregation|ethernet.LACPStateSynchronization) &&
+			!state.Has(ethernet.LACPStateDefaulted),
+	}
+}
+
+func (t *linkTracker) cfmSummary() []CFMLevel {
+	var levels []CFMLevel
+
+	for level, opcodes := range t.cfm {
+		if opcodes == nil {
+			continue
+		}
+
+		l := CFMLevel{
+			Level:   uint8(level), //nolint:gosec // bounded by cfmLevels
+			OpCodes: make(map[string]uint64, len(opcodes)),
+		}
+
+		for op, n := range opcodes {
+			l.OpCodes[op.String()] = n
+		}
+
+		levels = append(levels, l)
+	}
+
+	slices.SortFunc(levels, func(a, b CFMLevel) int {
+		return cmp.Compare(a.Level, b.Level)
+	})
+
+	return levels
+}
+
+func (t *linkTracker) reset() {
+	t.lacp, t.lacpPDUs = nil, 0
+	t.lldp = nil
+	clear(t.cfm[:])
+}
+
+func lacpPort(info ethernet.LACPPortInfo) LACPPort {
+	return LACPPort{
+		System:         info.System.String(),
+		State:          info.State.String(),
+		SystemPriority: info.SystemPriority,
+		Key:            info.Key,
+		PortPriority:   info.PortPriority,
+		Port:           info.Port,
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lacpduFrame(actorState byte) []byte {
+	pdu := []byte{
+		0x01, 0x01,
+		0x01, 0x14, 0x80, 0x00, 0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x00, 0x00, 0x0a, 0x80, 0x00, 0x00, 0x11,
+		actorState, 0x00, 0x00, 0x00,
+		0x02, 0x14, 0xff, 0xff, 0x52, 0x54, 0x00, 0x12, 0x34, 0x56, 0x00, 0x09, 0x00, 0xff, 0x00, 0x01,
+		0x3f, 0x00, 0x00, 0x00,
+	}
+
+	return append(summaryFrame(1, 0x88, 0x09), pdu...)
+}
+
+func cfmFrame(level, opcode byte) []byte {
+	pdu := []byte{level << 5, opcode, 0x04, 0x46, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01}
+
+	return append(summaryFrame(1, 0x81, 0x00, 0x00, 0x64, 0x89, 0x02), pdu...)
+}
+
+func TestSummarizerLinkDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0")
+
+	s.Add(lacpduFrame(0x45), Metadata{})
+
+	frame := lacpduFrame(0x3d)
+	s.Add(frame, Metadata{})
+	// the summary must not alias the frame buffer
+	clear(frame)
+
+	s.Add(cfmFrame(5, 1), Metadata{})
+	s.Add(cfmFrame(5, 1), Metadata{})
+	s.Add(cfmFrame(2, 3), Metadata{})
+	s.Add(cfmFrame(2, 3)[:17], Metadata{})
+
+	summary := s.Snapshot()
+
+	require.NotNil(t, summary.LACP)
+	assert.Equal(t, LACPSummary{
+		Actor: LACPPort{
+			System:         "00:1c:73:aa:bb:00",
+			State:          "Activity|Aggregation|Synchronization|Collecting|Distributing",
+			SystemPriority: 32768,
+			Key:            10,
+			PortPriority:   32768,
+			Port:           17,
+		},
+		Partner: LACPPort{
+			System:         "52:54:00:12:34:56",
+			State:          "Activity|Timeout|Aggregation|Synchronization|Collecting|Distributing",
+			SystemPriority: 65535,
+			Key:            9,
+			PortPriority:   255,
+			Port:           1,
+		},
+		PDUs:       2,
+		Aggregated: true,
+	}, *summary.LACP)
+	assert.Equal(t, []CFMLevel{
+		{Level: 2, OpCodes: map[string]uint64{"LBM": 1}},
+		{Level: 5, OpCodes: map[string]uint64{"CCM": 2}},
+	}, summary.CFM)
+
+	next := s.Snapshot()
+
+	assert.Nil(t, next.LACP)
+	assert.Empty(t, next.CFM)
+}
+
+func TestSummarizerLACPDefaulted(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0")
+
+	// a switch port which never heard from the host keeps defaults
+	s.Add(lacpduFrame(0x4d), Metadata{})
+
+	summary := s.Snapshot()
+
+	require.NotNil(t, summary.LACP)
+	assert.False(t, summary.LACP.Aggregated)
+}
+
+func lldpduFrame(pvid byte) []byte {
+	pdu := []byte{
+		0x02, 0x07, 0x04, 0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x01,
+		0x04, 0x06, 0x05, 'E', 't', 'h', '1', '2',
+		0x06, 0x02, 0x00, 0x78,
+		0x0a, 0x06, 't', 'o', 'r', '-', '0', '1',
+		0xfe, 0x06, 0x00, 0x80, 0xc2, 0x01, 0x00, pvid,
+		0xfe, 0x0b, 0x00, 0x80, 0xc2, 0x03, 0x00, pvid, 0x04, 'p', 'x', 'e', '0',
+		0xfe, 0x09, 0x00, 0x80, 0xc2, 0x07, 0x03, 0x00, 0x00, 0x01, 0xf5,
+		0xfe, 0x06, 0x00, 0x12, 0x0f, 0x04, 0x23, 0x28,
+		0x00, 0x00,
+	}
+
+	return append(summaryFrame(1, 0x88, 0xcc), pdu...)
+}
+
+func TestSummarizerLLDP(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0")
+
+	s.Add(lldpduFrame(0x0a), Metadata{})
+
+	frame := lldpduFrame(0x64)
+	s.Add(frame, Metadata{})
+	// the summary must not alias the frame buffer
+	clear(frame)
+
+	// a truncated LLDPDU is only counted as an ethertype
+	s.Add(lldpduFrame(0x64)[:20], Metadata{})
+
+	summary := s.Snapshot()
+
+	require.NotNil(t, summary.LLDP)
+	assert.Equal(t, LLDPSummary{
+		Chassis:    "00:1c:73:aa:bb:01",
+		Port:       "Eth12",
+		SystemName: "tor-01",
+		NativeVLAN: 100,
+		VLANs:      []LLDPVLAN{{ID: 100, Name: "pxe0"}},
+		Aggregation: &LLDPAggregation{
+			Capable: true,
+			Enabled: true,
+			PortID:  501,
+		},
+		MaxFrameSize: 9000,
+		PDUs:         2,
+	}, *summary.LLDP)
+
+	assert.Nil(t, s.Snapshot().LLDP)
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/atomicfile"
+)
+
+const (
+	// ManifestVersion is the version of the Manifest format
+	ManifestVersion = 1
+	// manifestSuffix is appended to the path of a capture file to name its
+	// sidecar manifest
+	manifestSuffix = ".manifest.json"
+	manifestMode   = 0o600
+)
+
+var (
+	// ErrNoManifest is returned when a capture file has no manifest, neither
+	// a sidecar nor an embedded one
+	ErrNoManifest = errors.New("capture file has no manifest")
+)
+
+// CaptureConfig is how a Conn was opened and is configured, the filter and
+// the membership being the current ones
+type CaptureConfig struct {
+	Filter []bpf.RawInstruction `json:"filter,omitempty"`
+	// Groups are the multicast groups joined besides DefaultMulticastGroups
+	Groups      []string   `json:"groups,omitempty"`
+	ReadBuffer  int        `json:"read_buffer,omitempty"`
+	WriteBuffer int        `json:"write_buffer,omitempty"`
+	Protocol    uint16     `json:"protocol"`
+	Membership  Membership `json:"membership"`
+	// HardwareTimestamps is set when the NIC was asked to timestamp the
+	// frames, the clock actually used is Manifest.ClockSource
+	HardwareTimestamps bool `json:"hardware_timestamps,omitempty"`
+}
+
+// Options returns the options opening a Conn configured as c
+func (c CaptureConfig) Options() ([]Option, error) {
+	options := []Option{WithProtocol(c.Protocol), WithMembership(c.Membership)}
+
+	if c.Filter != nil {
+		options = append(options, WithFilter(c.Filter))
+	}
+
+	for _, group := range c.Groups {
+		mac, err := net.ParseMAC(group)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multicast group %q: %w", group, err)
+		}
+
+		options = append(options, WithMulticastGroups(mac))
+	}
+
+	if c.ReadBuffer > 0 {
+		options = append(options, WithReadBuffer(c.ReadBuffer))
+	}
+
+	if c.WriteBuffer > 0 {
+		options = append(options, WithWriteBuffer(c.WriteBuffer))
+	}
+
+	if c.HardwareTimestamps {
+		options = append(options, WithHardwareTimestamps())
+	}
+
+	return options, nil
+}
+
+// ManifestStats are the counters of a capture at a point in time
+type ManifestStats struct {
+	Time time.Time `json:"time"`
+	Stats
+}
+
+// Manifest is the context needed to interpret a capture file and to
+// reproduce the capture: the interface, its configuration and how it
+// behaved. It is written next to the capture files as a JSON sidecar, see
+// WriteManifest, and embedded in the pcapng files, see PcapNgWriter.
+type Manifest struct {
+	// Preflight is the report of the interface by netif.Preflight, as it
+	// encodes to JSON
+	Preflight json.RawMessage `json:"preflight,omitempty"`
+	// Agent is the version of the agent which captured the frames
+	Agent     string `json:"agent"`
+	Interface string `json:"interface"`
+	// ClockSource is the clock the frames were timestamped with, as
+	// TimestampSource names it
+	ClockSource string        `json:"clock_source"`
+	Config      CaptureConfig `json:"config"`
+	// Start is when the capture started, End when the file was written
+	Start   ManifestStats `json:"start"`
+	End     ManifestStats `json:"end"`
+	Version int           `json:"version"`
+	// Snaplen is the number of bytes of each frame kept in the file, 0
+	// when the frames are whole
+	Snaplen uint32 `json:"snaplen,omitempty"`
+	// Redaction is how the payloads of the frames were removed, nil when
+	// the frames are whole
+	Redaction *Redaction `json:"redaction,omitempty"`
+}
+
+// ManifestSource tells how its frames are captured, such as a Conn
+type ManifestSource interface {
+	Manifest() (Manifest, error)
+}
+
+// NewManifest returns the Manifest of a capture on iface, only telling the
+// version of the agent
+func NewManifest(iface string) Manifest {
+	return Manifest{Version: ManifestVersion, Agent: agentVersion(), Interface: iface}
+}
+
+// agentVersion returns the version of the module of the running binary
+func agentVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+
+	return info.Main.Version
+}
+
+// Manifest returns the Manifest of the capture, its End being now
+func (c *Conn) Manifest() (Manifest, error) {
+	st, err := c.Stats()
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	m := NewManifest(c.iface.Name)
+	m.ClockSource = TimestampSoftware.String()
+
+	if c.hwTimestamps {
+		m.ClockSource = TimestampHardware.String()
+	}
+
+	c.filterMu.Lock()
+	filter := c.cfg.filter
+	c.filterMu.Unlock()
+
+	m.Config = CaptureConfig{
+		Filter:             filter,
+		ReadBuffer:         c.cfg.readBuffer,
+		WriteBuffer:        c.cfg.writeBuffer,
+		Protocol:           c.cfg.protocol,
+		Membership:         c.Membership(),
+		HardwareTimestamps: c.cfg.hwTimestamp,
+	}
+
+	for _, group := range c.cfg.groups {
+		m.Config.Groups = append(m.Config.Groups, group.String())
+	}
+
+	m.Start = ManifestStats{Time: c.opened}
+	m.End = ManifestStats{Time: time.Now(), Stats: st}
+
+	return m, nil
+}
+
+// ListenManifest opens a Conn reproducing the capture of m, on its
+// interface unless options say otherwise
+func ListenManifest(m Manifest, options ...Option) (*Conn, error) {
+	cfgOptions, err := m.Config.Options()
+	if err != nil {
+		return nil, err
+	}
+
+	return Listen(m.Interface, append(cfgOptions, options...)...)
+}
+
+// ManifestPath returns the path of the sidecar manifest of the capture file
+// at path
+func ManifestPath(path string) string {
+	return path + manifestSuffix
+}
+
+// WriteManifest writes m as the sidecar manifest of the capture file at
+// path, replacing it atomically
+func WriteManifest(path string, m Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(ManifestPath(path), append(b, '\n'), manifestMode)
+}
+
+// ReadManifest returns the manifest of the capture file at path, its
+// sidecar or else the one a pcapng file embeds. It returns an error
+// matching ErrNoManifest when there is neither.
+func ReadManifest(path string) (Manifest, error) {
+	b, err := os.ReadFile(ManifestPath(filepath.Clean(path)))
+	if err == nil {
+		return decodeManifest(b)
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return Manifest{}, err
+	}
+
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	defer f.Close() //nolint:errcheck // the file is only read
+
+	r, err := NewPcapReader(f, "")
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	m, ok, err := r.Manifest()
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	// the statistics closing a pcapng file follow the frames
+	if ok && m.End.Time.IsZero() {
+		buf := make([]byte, defaultSnaplen)
+
+		for err == nil {
+			_, err = r.ReadFrame(buf)
+		}
+
+		if !errors.Is(err, io.EOF) {
+			return Manifest{}, err
+		}
+
+		m, _, err = r.Manifest()
+		if err != nil {
+			return Manifest{}, err
+		}
+	}
+
+	if !ok {
+		return Manifest{}, fmt.Errorf("%w: %s", ErrNoManifest, path)
+	}
+
+	return m, nil
+}
+
+func decodeManifest(b []byte) (Manifest, error) {
+	var m Manifest
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return Manifest{}, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	if m.Version != ManifestVersion {
+		return Manifest{}, fmt.Errorf("%w: manifest version %d", ErrUnsupported, m.Version)
+	}
+
+	return m, nil
+}
+
+// describe returns a line telling how the frames of m were captured, for
+// the readers of a file which don't decode the manifest
+func (m Manifest) describe() string {
+	s := fmt.Sprintf("membership %s, clock %s, agent %s", m.Config.Membership, m.ClockSource, m.Agent)
+
+	if m.Redaction != nil {
+		s += ", payloads redacted"
+	}
+
+	return s
+}
+
+// filterString returns filter as tcpdump -dd prints it, the format of the
+// pcapng interface filter option
+func filterString(filter []bpf.RawInstruction) string {
+	lines := make([]string, 0, len(filter))
+
+	for _, ins := range filter {
+		lines = append(lines, fmt.Sprintf("{ 0x%x, %d, %d, 0x%08x },", ins.Op, ins.Jt, ins.Jf, ins.K))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testManifest returns the Manifest of a capture with every field set
+func testManifest(t *testing.T) Manifest {
+	t.Helper()
+
+	m := NewManifest("eth0")
+	m.Preflight = json.RawMessage(`{"name":"eth0","mtu":1500,"vlan_offload":"on"}`)
+	m.ClockSource = TimestampSoftware.String()
+	m.Config = CaptureConfig{
+		Filter:      testFilter(t),
+		Groups:      []string{testGroup.String()},
+		ReadBuffer:  4 << 20,
+		WriteBuffer: 1 << 20,
+		Protocol:    testEthertype,
+		Membership:  MembershipPromiscuous,
+	}
+	m.Start = ManifestStats{Time: time.Unix(1700000000, 0).UTC(), Stats: Stats{Packets: 10}}
+	m.End = ManifestStats{Time: time.Unix(1700000060, 0).UTC(), Stats: Stats{Packets: 110, Drops: 2, Drained: 1}}
+
+	return m
+}
+
+func TestCaptureConfigOptions(t *testing.T) {
+	t.Parallel()
+
+	m := testManifest(t)
+	m.Config.HardwareTimestamps = true
+
+	options, err := m.Config.Options()
+	require.NoError(t, err)
+	assert.Equal(t, config{
+		filter:      m.Config.Filter,
+		groups:      []net.HardwareAddr{testGroup},
+		readBuffer:  4 << 20,
+		writeBuffer: 1 << 20,
+		protocol:    testEthertype,
+		backend:     BackendPacket,
+		membership:  MembershipPromiscuous,
+		hwTimestamp: true,
+	}, newConfig(options))
+
+	_, err = CaptureConfig{Groups: []string{"group"}}.Options()
+	assert.Error(t, err)
+}
+
+func TestManifestJSON(t *testing.T) {
+	t.Parallel()
+
+	m := testManifest(t)
+
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"membership":"promiscuous"`)
+	assert.Contains(t, string(b), `"end":{"time":"2023-11-14T22:14:20Z","packets":110,"drops":2,"drained":1}`)
+
+	decoded, err := decodeManifest(b)
+	require.NoError(t, err)
+	assert.Equal(t, m, decoded)
+
+	_, err = decodeManifest([]byte(`{"version": 2}`))
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = decodeManifest([]byte(`{"version": 1, "config": {"membership": "all"}}`))
+	assert.Error(t, err)
+}
+
+func TestReadManifest(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	m := testManifest(t)
+
+	// a pcap file only has a sidecar
+	path := filepath.Join(dir, "eth0.pcap")
+
+	f, err := os.Create(path) //nolint:gosec // the path is in the test directory
+	require.NoError(t, err)
+
+	_, err = NewPcapWriter(f, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = ReadManifest(path)
+	assert.ErrorIs(t, err, ErrNoManifest)
+
+	require.NoError(t, WriteManifest(path, m))
+	assert.FileExists(t, filepath.Join(dir, "eth0.pcap.manifest.json"))
+
+	read, err := ReadManifest(path)
+	require.NoError(t, err)
+
+	// the sidecar is indented
+	assert.JSONEq(t, string(m.Preflight), string(read.Preflight))
+
+	read.Preflight = m.Preflight
+	assert.Equal(t, m, read)
+
+	_, err = ReadManifest(filepath.Join(dir, "missing.pcap"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// TestConnManifest requires CAP_NET_RAW:
+// sudo TEST_CAPTURE_IFACE=lo \
+// go test maas.io/core/src/maasagent/internal/capture -run TestConnManifest -count 1 -v
+func TestConnManifest(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	c, err := Listen(iface, WithProtocol(testEthertype), WithMulticastGroups(testGroup))
+	require.NoError(t, err)
+
+	defer c.Close() //nolint:errcheck // test cleanup
+
+	require.NoError(t, c.SetFilter(testFilter(t)))
+
+	m, err := c.Manifest()
+	require.NoError(t, err)
+	assert.Equal(t, iface, m.Interface)
+	assert.Equal(t, TimestampSoftware.String(), m.ClockSource)
+	assert.Equal(t, CaptureConfig{
+		Filter:     testFilter(t),
+		Groups:     []string{testGroup.String()},
+		Protocol:   testEthertype,
+		Membership: MembershipGroups,
+	}, m.Config)
+	assert.False(t, m.End.Time.Before(m.Start.Time))
+
+	// the capture is reproduced from the manifest
+	reopened, err := ListenManifest(m)
+	require.NoError(t, err)
+
+	defer reopened.Close() //nolint:errcheck // test cleanup
+
+	again, err := reopened.Manifest()
+	require.NoError(t, err)
+	assert.Equal(t, m.Config, again.Config)
+}
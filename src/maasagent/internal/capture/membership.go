@@ -0,0 +1,185 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// Membership is what a capture asks the interface to receive beyond the
+// frames the host receives anyway: those sent to its MACs, the broadcasts
+// and the multicast groups its stack joined. Each mode sees what the
+// previous ones do.
+type Membership uint8
+
+const (
+	// MembershipUnicast receives what the host receives. ARP requests are
+	// seen but not the replies to other hosts, nor any frame between third
+	// parties.
+	MembershipUnicast Membership = iota
+	// MembershipGroups also joins the multicast groups of
+	// DefaultMulticastGroups and WithMulticastGroups, with
+	// PACKET_MR_MULTICAST. The NDP messages sent to the solicited-node
+	// groups of other hosts are still lost, such as the probes of their
+	// tentative addresses, and so are the unicast frames between third
+	// parties. A NIC which can't filter as many groups falls back to
+	// MembershipAllMulticast.
+	MembershipGroups
+	// MembershipAllMulticast receives every multicast frame, with
+	// PACKET_MR_ALLMULTI. The unicast frames between third parties, such as
+	// ARP replies, neighbor advertisements and DHCP OFFERs sent to other
+	// hosts, are still lost.
+	MembershipAllMulticast
+	// MembershipPromiscuous receives every frame, with PACKET_MR_PROMISC,
+	// which some security policies forbid
+	MembershipPromiscuous
+)
+
+var membershipNames = map[Membership]string{
+	MembershipUnicast:      "unicast",
+	MembershipGroups:       "multicast_groups",
+	MembershipAllMulticast: "all_multicast",
+	MembershipPromiscuous:  "promiscuous",
+}
+
+// String returns the string version of the Membership
+func (m Membership) String() string {
+	if name, ok := membershipNames[m]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Membership(%d)", uint8(m))
+}
+
+// MarshalText implements encoding.TextMarshaler for Membership
+func (m Membership) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for Membership
+func (m *Membership) UnmarshalText(text []byte) error {
+	for membership, name := range membershipNames {
+		if name == string(text) {
+			*m = membership
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown membership %q", text)
+}
+
+// DefaultMulticastGroups returns the groups MembershipGroups joins: the
+// all-nodes group of NDP and the groups of mDNS over IPv4 and IPv6
+func DefaultMulticastGroups() []net.HardwareAddr {
+	return []net.HardwareAddr{
+		{0x33, 0x33, 0x00, 0x00, 0x00, 0x01},
+		{0x01, 0x00, 0x5e, 0x00, 0x00, 0xfb},
+		{0x33, 0x33, 0x00, 0x00, 0x00, 0xfb},
+	}
+}
+
+// SolicitedNodeGroup returns the MAC of the solicited-node group of addr,
+// to which the neighbor solicitations for addr are sent
+func SolicitedNodeGroup(addr netip.Addr) net.HardwareAddr {
+	b := addr.As16()
+
+	return net.HardwareAddr{0x33, 0x33, 0xff, b[13], b[14], b[15]}
+}
+
+// WithMembership widens what the interface receives for the lifetime of
+// the capture, the widest of the modes asked for applies
+func WithMembership(m Membership) Option {
+	return func(c *config) {
+		c.membership = max(c.membership, m)
+	}
+}
+
+// WithMulticastGroups joins the multicast groups of the MACs as well as
+// DefaultMulticastGroups, such as the solicited-node groups of the
+// addresses of interest
+func WithMulticastGroups(groups ...net.HardwareAddr) Option {
+	return func(c *config) {
+		c.membership = max(c.membership, MembershipGroups)
+		c.groups = append(c.groups, groups...)
+	}
+}
+
+// Membership returns the mode the socket receives frames in, which is
+// MembershipAllMulticast when the groups couldn't all be joined
+func (c *Conn) Membership() Membership {
+	c.membershipMu.Lock()
+	defer c.membershipMu.Unlock()
+
+	return c.membership
+}
+
+// join adds the memberships of mode m to the socket, they are dropped by
+// the kernel when it is closed
+func (c *Conn) join(fd int, m Membership) error {
+	c.membership = m
+
+	switch m {
+	case MembershipUnicast:
+	case MembershipGroups:
+		for _, group := range append(DefaultMulticastGroups(), c.cfg.groups...) {
+			err := c.addMembership(fd, unix.PACKET_MR_MULTICAST, group)
+			if err == nil {
+				continue
+			}
+
+			// the host's own groups are joined alike, a NIC out of
+			// filters receives all of them rather than some
+			log.Warn().Err(err).Str("iface", c.iface.Name).Stringer("group", group).
+				Msg("Failed to join the multicast group, receiving all multicast instead")
+
+			return c.join(fd, MembershipAllMulticast)
+		}
+	case MembershipAllMulticast:
+		if err := c.addMembership(fd, unix.PACKET_MR_ALLMULTI, nil); err != nil {
+			return fmt.Errorf("failed to receive all multicast: %w", err)
+		}
+	case MembershipPromiscuous:
+		if err := c.addMembership(fd, unix.PACKET_MR_PROMISC, nil); err != nil {
+			return fmt.Errorf("failed to enable promiscuous mode: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Conn) addMembership(fd int, kind uint16, addr net.HardwareAddr) error {
+	return unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, c.packetMreq(kind, addr))
+}
+
+func (c *Conn) dropMembership(fd int, kind uint16, addr net.HardwareAddr) error {
+	return unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_DROP_MEMBERSHIP, c.packetMreq(kind, addr))
+}
+
+func (c *Conn) packetMreq(kind uint16, addr net.HardwareAddr) *unix.PacketMreq {
+	mreq := unix.PacketMreq{
+		Ifindex: int32(c.iface.Index), //nolint:gosec // ifindex fits in int32
+		Type:    kind,
+	}
+
+	mreq.Alen = uint16(copy(mreq.Address[:], addr)) //nolint:gosec // at most the 8 bytes of the address
+
+	return &mreq
+}
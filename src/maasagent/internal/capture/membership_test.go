@@ -0,0 +1,51 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/json"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGroup is the MAC of the solicited-node group of 2001:db8::1:2:3
+var testGroup = net.HardwareAddr{0x33, 0x33, 0xff, 0x02, 0x00, 0x03}
+
+func TestSolicitedNodeGroup(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, testGroup, SolicitedNodeGroup(netip.MustParseAddr("2001:db8::1:2:3")))
+	assert.Equal(t, net.HardwareAddr{0x33, 0x33, 0xff, 0x00, 0x00, 0x01},
+		SolicitedNodeGroup(netip.MustParseAddr("fe80::1")))
+}
+
+func TestMembership(t *testing.T) {
+	t.Parallel()
+
+	out, err := json.Marshal([]Membership{MembershipUnicast, MembershipGroups, MembershipAllMulticast,
+		MembershipPromiscuous, Membership(9)})
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `["unicast","multicast_groups","all_multicast","promiscuous","Membership(9)"]`, string(out))
+
+	// each mode sees what the narrower ones do
+	assert.Less(t, MembershipGroups, MembershipAllMulticast)
+	assert.Less(t, MembershipAllMulticast, MembershipPromiscuous)
+}
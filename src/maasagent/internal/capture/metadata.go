@@ -0,0 +1,296 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
+)
+
+// TimestampSource is the clock that timestamped a frame
+type TimestampSource uint8
+
+const (
+	// TimestampUnknown is used when the source was not recorded, for
+	// instance for frames read from a pcap file
+	TimestampUnknown TimestampSource = iota
+	// TimestampWallClock is the time the frame reached userspace
+	TimestampWallClock
+	// TimestampSoftware is the time the kernel received the frame
+	TimestampSoftware
+	// TimestampHardware is the time the NIC received the frame
+	TimestampHardware
+)
+
+func (s TimestampSource) String() string {
+	switch s {
+	case TimestampWallClock:
+		return "wall-clock"
+	case TimestampSoftware:
+		return "software"
+	case TimestampHardware:
+		return "hardware"
+	default:
+		return "unknown"
+	}
+}
+
+// Direction tells whether a frame was received or sent by the host
+type Direction uint8
+
+const (
+	// DirectionUnknown is used when the backend can't tell the direction
+	DirectionUnknown Direction = iota
+	// DirectionInbound is a frame received by the interface
+	DirectionInbound
+	// DirectionOutbound is a frame sent by the host
+	DirectionOutbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionInbound:
+		return "inbound"
+	case DirectionOutbound:
+		return "outbound"
+	default:
+		return "unknown"
+	}
+}
+
+// PacketClass is who a frame was addressed to, as seen by the host
+type PacketClass uint8
+
+const (
+	// ClassUnknown is used when the backend can't tell the class
+	ClassUnknown PacketClass = iota
+	// ClassHost is a unicast frame addressed to the host
+	ClassHost
+	// ClassBroadcast is a frame sent to the broadcast address
+	ClassBroadcast
+	// ClassMulticast is a frame sent to a multicast group
+	ClassMulticast
+	// ClassOtherHost is a unicast frame addressed to another host, captured
+	// in promiscuous mode
+	ClassOtherHost
+	// ClassOutbound is a frame sent by the host, looped back to the socket
+	ClassOutbound
+)
+
+func (c PacketClass) String() string {
+	switch c {
+	case ClassHost:
+		return "host"
+	case ClassBroadcast:
+		return "broadcast"
+	case ClassMulticast:
+		return "multicast"
+	case ClassOtherHost:
+		return "otherhost"
+	case ClassOutbound:
+		return "outbound"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the class by name
+func (c PacketClass) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// VLANInfo is a VLAN tag stripped from the frame by the NIC
+type VLANInfo struct {
+	// TCI is the tag control information, which holds the VLAN ID
+	TCI uint16
+	// TPID is the ethertype of the tag
+	TPID uint16
+	// Valid is set when a tag was stripped
+	Valid bool
+}
+
+// ID returns the VLAN ID of the tag
+func (v VLANInfo) ID() uint16 {
+	return v.TCI & 0x0fff
+}
+
+// Metadata describes where and when a frame was captured
+type Metadata struct {
+	// Timestamp is when the frame was captured, according to TimestampSource
+	Timestamp time.Time
+	// Interface is the name of the capturing interface
+	Interface string
+	// VLAN is the tag the NIC stripped from the frame, if any
+	VLAN VLANInfo
+	// Ifindex is the index of the capturing interface
+	Ifindex int
+	// CaptureLength is the number of bytes of the frame that were captured
+	CaptureLength int
+	// Length is the length of the frame on the wire
+	Length int
+	// TimestampSource is the clock Timestamp was taken from
+	TimestampSource TimestampSource
+	// Direction tells whether the frame was received or sent
+	Direction Direction
+	// PacketType is the sockaddr_ll packet type (PACKET_HOST,
+	// PACKET_BROADCAST, ...) for frames captured with AF_PACKET
+	PacketType uint8
+	// Labels are those of the capturing interface, such as its fabric, set
+	// by the consumer of the frame which knows them
+	Labels map[string]string
+}
+
+// Truncated reports whether only part of the frame was captured
+func (m *Metadata) Truncated() bool {
+	return m.Length > m.CaptureLength
+}
+
+// Class returns the class of frame. The destination address decides
+// between broadcast and multicast, as XDP doesn't set a packet type, and the
+// packet type between host and otherhost.
+func (m *Metadata) Class(frame []byte) PacketClass {
+	if m.Direction == DirectionOutbound {
+		return ClassOutbound
+	}
+
+	if len(frame) >= 6 && frame[0]&0x01 != 0 {
+		if bytes.Equal(frame[:6], broadcastAddr[:]) {
+			return ClassBroadcast
+		}
+
+		return ClassMulticast
+	}
+
+	switch {
+	case m.Direction == DirectionUnknown || len(frame) < 6:
+		return ClassUnknown
+	case m.PacketType == unix.PACKET_OTHERHOST:
+		return ClassOtherHost
+	default:
+		return ClassHost
+	}
+}
+
+// MetadataReader reads frames together with their capture metadata
+type MetadataReader interface {
+	// ReadFrameMetadata reads a single frame into buf, the number of bytes
+	// read is the CaptureLength of the returned Metadata
+	ReadFrameMetadata(buf []byte) (Metadata, error)
+}
+
+// ReadFrameMetadata reads a frame with its metadata from r. Readers which
+// don't provide metadata get a wall-clock timestamp and lengths only.
+func ReadFrameMetadata(r FrameReader, buf []byte) (Metadata, error) {
+	if mr, ok := r.(MetadataReader); ok {
+		return mr.ReadFrameMetadata(buf)
+	}
+
+	n, err := r.ReadFrame(buf)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	return Metadata{
+		Timestamp:       time.Now(),
+		TimestampSource: TimestampWallClock,
+		CaptureLength:   n,
+		Length:          n,
+	}, nil
+}
+
+var broadcastAddr = [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+const (
+	// tpidDot1Q is reported when the kernel only sets TP_STATUS_VLAN_VALID
+	tpidDot1Q uint16 = 0x8100
+)
+
+var (
+	// controlSpace is the control message buffer needed per frame
+	controlSpace = unix.CmsgSpace(hostorder.SizeofAuxdata) + unix.CmsgSpace(3*hostorder.SizeofTimespec)
+)
+
+// parseControl fills md from the control messages received with a frame
+func parseControl(md *Metadata, oob []byte) {
+	cmsgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+
+	for _, cmsg := range cmsgs {
+		switch {
+		case cmsg.Header.Level == unix.SOL_PACKET && cmsg.Header.Type == unix.PACKET_AUXDATA:
+			aux, ok := hostorder.Auxdata(cmsg.Data)
+			if !ok {
+				continue
+			}
+
+			md.Length = int(aux.Len)
+
+			if aux.Status&unix.TP_STATUS_VLAN_VALID != 0 {
+				md.VLAN = VLANInfo{TCI: aux.Vlan_tci, TPID: tpidDot1Q, Valid: true}
+
+				if aux.Status&unix.TP_STATUS_VLAN_TPID_VALID != 0 {
+					md.VLAN.TPID = aux.Vlan_tpid
+				}
+			}
+		case cmsg.Header.Level == unix.SOL_SOCKET && cmsg.Header.Type == unix.SO_TIMESTAMPNS:
+			ts, ok := hostorder.Timespec(cmsg.Data)
+			if !ok {
+				continue
+			}
+
+			md.Timestamp = ts
+			md.TimestampSource = TimestampSoftware
+		case cmsg.Header.Level == unix.SOL_SOCKET && cmsg.Header.Type == unix.SCM_TIMESTAMPING &&
+			len(cmsg.Data) >= 3*hostorder.SizeofTimespec:
+			parseTimestamping(md, cmsg.Data)
+		}
+	}
+}
+
+// packetType returns the sockaddr_ll packet type of a frame received by the
+// interface with address hwAddr, for the backends the kernel doesn't give one
+func packetType(frame []byte, hwAddr net.HardwareAddr) uint8 {
+	switch {
+	case len(frame) < 6:
+		return unix.PACKET_HOST
+	case bytes.Equal(frame[:6], broadcastAddr[:]):
+		return unix.PACKET_BROADCAST
+	case frame[0]&0x01 != 0:
+		return unix.PACKET_MULTICAST
+	case len(hwAddr) == 6 && !bytes.Equal(frame[:6], hwAddr):
+		return unix.PACKET_OTHERHOST
+	default:
+		return unix.PACKET_HOST
+	}
+}
+
+// parseSockaddr fills md from the link layer address of a received frame
+func parseSockaddr(md *Metadata, pktType uint8) {
+	md.PacketType = pktType
+
+	if pktType == unix.PACKET_OUTGOING {
+		md.Direction = DirectionOutbound
+	} else {
+		md.Direction = DirectionInbound
+	}
+}
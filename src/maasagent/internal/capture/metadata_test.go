@@ -0,0 +1,313 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
+)
+
+func cmsg(level, typ int32, data unsafe.Pointer, size int) []byte {
+	buf := make([]byte, unix.CmsgSpace(size))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&buf[0]))
+	h.Level = level
+	h.Type = typ
+	h.SetLen(unix.CmsgLen(size))
+	copy(buf[unix.CmsgLen(0):], unsafe.Slice((*byte)(data), size))
+
+	return buf
+}
+
+func auxdataCmsg(aux unix.TpacketAuxdata) []byte {
+	return cmsg(unix.SOL_PACKET, unix.PACKET_AUXDATA, unsafe.Pointer(&aux), hostorder.SizeofAuxdata)
+}
+
+func timestampCmsg(t time.Time) []byte {
+	ts := unix.NsecToTimespec(t.UnixNano())
+
+	return cmsg(unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, unsafe.Pointer(&ts), hostorder.SizeofTimespec)
+}
+
+func timestampingCmsg(software, hardware time.Time) []byte {
+	ts := [3]unix.Timespec{
+		unix.NsecToTimespec(software.UnixNano()),
+		{},
+		unix.NsecToTimespec(hardware.UnixNano()),
+	}
+
+	return cmsg(unix.SOL_SOCKET, unix.SCM_TIMESTAMPING, unsafe.Pointer(&ts), 3*hostorder.SizeofTimespec)
+}
+
+func TestParseControl(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Unix(1700000000, 123456789)
+
+	testcases := map[string]struct {
+		in  []byte
+		out Metadata
+	}{
+		"untagged": {
+			in:  auxdataCmsg(unix.TpacketAuxdata{Len: 60}),
+			out: Metadata{CaptureLength: 60, Length: 60},
+		},
+		"truncated": {
+			in:  auxdataCmsg(unix.TpacketAuxdata{Len: 1514}),
+			out: Metadata{CaptureLength: 60, Length: 1514},
+		},
+		"stripped 802.1Q tag": {
+			in: auxdataCmsg(unix.TpacketAuxdata{
+				Len:      60,
+				Status:   unix.TP_STATUS_VLAN_VALID,
+				Vlan_tci: 0x2064,
+			}),
+			out: Metadata{
+				CaptureLength: 60,
+				Length:        60,
+				VLAN:          VLANInfo{TCI: 0x2064, TPID: 0x8100, Valid: true},
+			},
+		},
+		"stripped 802.1ad tag": {
+			in: auxdataCmsg(unix.TpacketAuxdata{
+				Len:       60,
+				Status:    unix.TP_STATUS_VLAN_VALID | unix.TP_STATUS_VLAN_TPID_VALID,
+				Vlan_tci:  200,
+				Vlan_tpid: 0x88a8,
+			}),
+			out: Metadata{
+				CaptureLength: 60,
+				Length:        60,
+				VLAN:          VLANInfo{TCI: 200, TPID: 0x88a8, Valid: true},
+			},
+		},
+		"software timestamp": {
+			in: append(auxdataCmsg(unix.TpacketAuxdata{Len: 60}), timestampCmsg(ts)...),
+			out: Metadata{
+				CaptureLength:   60,
+				Length:          60,
+				Timestamp:       ts,
+				TimestampSource: TimestampSoftware,
+			},
+		},
+		"hardware timestamp": {
+			in: timestampingCmsg(ts.Add(time.Microsecond), ts),
+			out: Metadata{
+				CaptureLength:   60,
+				Length:          60,
+				Timestamp:       ts,
+				TimestampSource: TimestampHardware,
+			},
+		},
+		"frame missed by the hardware filter": {
+			in: timestampingCmsg(ts, time.Unix(0, 0)),
+			out: Metadata{
+				CaptureLength:   60,
+				Length:          60,
+				Timestamp:       ts,
+				TimestampSource: TimestampSoftware,
+			},
+		},
+		"no control message": {
+			out: Metadata{CaptureLength: 60, Length: 60},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			md := Metadata{CaptureLength: 60, Length: 60}
+			parseControl(&md, tc.in)
+			assert.Equal(t, tc.out, md)
+		})
+	}
+}
+
+func TestVLANInfoID(t *testing.T) {
+	t.Parallel()
+
+	// priority 1, VLAN 100
+	assert.Equal(t, uint16(100), VLANInfo{TCI: 0x2064, Valid: true}.ID())
+}
+
+func TestMetadataClass(t *testing.T) {
+	t.Parallel()
+
+	unicast := []byte{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01, 0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+	broadcast := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+	multicast := []byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e, 0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+
+	testcases := map[string]struct {
+		in  []byte
+		md  Metadata
+		out PacketClass
+	}{
+		"host": {
+			in:  unicast,
+			md:  Metadata{Direction: DirectionInbound, PacketType: unix.PACKET_HOST},
+			out: ClassHost,
+		},
+		"otherhost": {
+			in:  unicast,
+			md:  Metadata{Direction: DirectionInbound, PacketType: unix.PACKET_OTHERHOST},
+			out: ClassOtherHost,
+		},
+		"broadcast": {
+			in:  broadcast,
+			md:  Metadata{Direction: DirectionInbound, PacketType: unix.PACKET_BROADCAST},
+			out: ClassBroadcast,
+		},
+		"multicast": {
+			in:  multicast,
+			md:  Metadata{Direction: DirectionInbound, PacketType: unix.PACKET_MULTICAST},
+			out: ClassMulticast,
+		},
+		"outbound broadcast": {
+			in:  broadcast,
+			md:  Metadata{Direction: DirectionOutbound, PacketType: unix.PACKET_OUTGOING},
+			out: ClassOutbound,
+		},
+		"multicast without packet type": {
+			in:  multicast,
+			out: ClassMulticast,
+		},
+		"unicast without direction": {
+			in:  unicast,
+			out: ClassUnknown,
+		},
+		"short frame": {
+			in:  []byte{0x00, 0x16},
+			md:  Metadata{Direction: DirectionInbound},
+			out: ClassUnknown,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.md.Class(tc.in))
+		})
+	}
+}
+
+func TestPacketType(t *testing.T) {
+	t.Parallel()
+
+	hwAddr := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	testcases := map[string]struct {
+		in  []byte
+		out uint8
+	}{
+		"host": {
+			in:  []byte{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01},
+			out: unix.PACKET_HOST,
+		},
+		"otherhost": {
+			in:  []byte{0x00, 0x16, 0x3e, 0x00, 0x00, 0x09},
+			out: unix.PACKET_OTHERHOST,
+		},
+		"broadcast": {
+			in:  []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			out: unix.PACKET_BROADCAST,
+		},
+		"multicast": {
+			in:  []byte{0x33, 0x33, 0x00, 0x00, 0x00, 0x01},
+			out: unix.PACKET_MULTICAST,
+		},
+		"short frame": {
+			in:  []byte{0x00},
+			out: unix.PACKET_HOST,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, packetType(tc.in, hwAddr))
+		})
+	}
+}
+
+func TestReadFrameMetadataFallback(t *testing.T) {
+	t.Parallel()
+
+	r := &frameRecorder{frames: [][]byte{testFrame("a")}, err: errors.New("done")}
+	buf := make([]byte, 64)
+
+	before := time.Now()
+
+	md, err := ReadFrameMetadata(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, len(testFrame("a")), md.CaptureLength)
+	assert.Equal(t, TimestampWallClock, md.TimestampSource)
+	assert.False(t, md.Timestamp.Before(before))
+}
+
+func TestConnReadFrameMetadata(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	r, err := Listen(iface, WithFilter(testFilter(t)))
+	require.NoError(t, err)
+
+	defer r.Close() //nolint:errcheck // test cleanup
+
+	w, err := Listen(iface, WithProtocol(testEthertype))
+	require.NoError(t, err)
+
+	defer w.Close() //nolint:errcheck // test cleanup
+
+	before := time.Now()
+	frame := testFrame("metadata")
+
+	require.NoError(t, w.WriteFrame(frame))
+	require.NoError(t, r.SetReadDeadline(time.Now().Add(time.Second)))
+
+	buf := make([]byte, 16)
+
+	md, err := r.ReadFrameMetadata(buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, iface, md.Interface)
+	assert.NotZero(t, md.Ifindex)
+	assert.Equal(t, TimestampSoftware, md.TimestampSource)
+	assert.False(t, md.Timestamp.Before(before.Add(-time.Second)))
+	assert.Equal(t, 16, md.CaptureLength)
+	assert.Equal(t, len(frame), md.Length)
+	assert.True(t, md.Truncated())
+	assert.NotEqual(t, DirectionUnknown, md.Direction)
+}
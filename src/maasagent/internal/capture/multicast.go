@@ -0,0 +1,165 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"maas.io/core/src/maasagent/internal/mld"
+)
+
+const (
+	// maxMulticastGroups and maxGroupListeners bound the membership kept
+	// per interval, solicited-node groups alone grow with the hosts
+	maxMulticastGroups = 256
+	maxGroupListeners  = 64
+	maxQueriers        = 8
+)
+
+// MulticastGroup is the membership of a multicast group reported during
+// the interval, the hosts are identified by their MAC address
+type MulticastGroup struct {
+	Group     string   `json:"group"`
+	Listeners []string `json:"listeners,omitempty"`
+	// Left are the hosts whose last report of the interval left the group
+	Left []string `json:"left,omitempty"`
+}
+
+// MulticastSummary reports the multicast group membership seen on an
+// interface
+type MulticastSummary struct {
+	Groups []MulticastGroup `json:"groups,omitempty"`
+	// Queriers are the routers sending membership queries, a segment
+	// without one relies on switches flooding multicast
+	Queriers []string `json:"queriers,omitempty"`
+	// Truncated is set when reports were ignored to bound the summary
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// multicastTracker accounts the group membership reports of an interface
+type multicastTracker struct {
+	// groups maps the listeners of a group to whether they still listen
+	groups    map[netip.Addr]map[hwAddr]bool
+	queriers  map[netip.Addr]struct{}
+	truncated bool
+}
+
+func newMulticastTracker() *multicastTracker {
+	return &multicastTracker{
+		groups:   make(map[netip.Addr]map[hwAddr]bool),
+		queriers: make(map[netip.Addr]struct{}),
+	}
+}
+
+func (t *multicastTracker) add(frame []byte) {
+	msg, pkt, err := mld.ParseFrame(frame)
+	if err != nil {
+		return
+	}
+
+	host := hwAddr(frame[6:12])
+
+	switch msg.Type {
+	case mld.TypeQuery:
+		if _, ok := t.queriers[pkt.Src]; !ok && len(t.queriers) >= maxQueriers {
+			t.truncated = true
+			return
+		}
+
+		t.queriers[pkt.Src] = struct{}{}
+	case mld.TypeReportV1:
+		t.set(msg.Multicast, host, true)
+	case mld.TypeDone:
+		t.set(msg.Multicast, host, false)
+	case mld.TypeReportV2:
+		for _, r := range msg.Records {
+			switch {
+			case r.Listening():
+				t.set(r.Multicast, host, true)
+			case r.Type == mld.RecordChangeToInclude:
+				t.set(r.Multicast, host, false)
+			}
+		}
+	}
+}
+
+func (t *multicastTracker) set(group netip.Addr, host hwAddr, listening bool) {
+	listeners, ok := t.groups[group]
+	if !ok {
+		if len(t.groups) >= maxMulticastGroups {
+			t.truncated = true
+			return
+		}
+
+		listeners = make(map[hwAddr]bool)
+		t.groups[group] = listeners
+	}
+
+	if _, ok := listeners[host]; !ok && len(listeners) >= maxGroupListeners {
+		t.truncated = true
+		return
+	}
+
+	listeners[host] = listening
+}
+
+func (t *multicastTracker) summary() *MulticastSummary {
+	if len(t.groups) == 0 && len(t.queriers) == 0 {
+		return nil
+	}
+
+	summary := &MulticastSummary{Truncated: t.truncated}
+
+	for group, listeners := range t.groups {
+		g := MulticastGroup{Group: group.String()}
+
+		for host, listening := range listeners {
+			mac := net.HardwareAddr(host[:]).String()
+
+			if listening {
+				g.Listeners = append(g.Listeners, mac)
+			} else {
+				g.Left = append(g.Left, mac)
+			}
+		}
+
+		slices.Sort(g.Listeners)
+		slices.Sort(g.Left)
+
+		summary.Groups = append(summary.Groups, g)
+	}
+
+	slices.SortFunc(summary.Groups, func(a, b MulticastGroup) int {
+		return strings.Compare(a.Group, b.Group)
+	})
+
+	for querier := range t.queriers {
+		summary.Queriers = append(summary.Queriers, querier.String())
+	}
+
+	slices.Sort(summary.Queriers)
+
+	return summary
+}
+
+func (t *multicastTracker) reset() {
+	clear(t.groups)
+	clear(t.queriers)
+	t.truncated = false
+}
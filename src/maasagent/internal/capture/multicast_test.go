@@ -0,0 +1,109 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mldFrame returns an MLD message sent by 00:16:3e:00:00:<src> with a
+// Router Alert option
+func mldFrame(src byte, icmp ...byte) []byte {
+	payload := append([]byte{58, 0x00, 0x05, 0x02, 0x00, 0x00, 0x01, 0x00}, icmp...)
+
+	ip := make([]byte, 40)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(len(payload))) //nolint:gosec // test packets are small
+	ip[7] = 1
+	copy(ip[8:24], netip.MustParseAddr("fe80::1").AsSlice())
+	copy(ip[24:40], netip.MustParseAddr("ff02::16").AsSlice())
+
+	frame := append(summaryFrame(src, 0x86, 0xdd), ip...)
+	// MLD is sent to the MAC of an IPv6 multicast group
+	frame[0], frame[1] = 0x33, 0x33
+
+	return append(frame, payload...)
+}
+
+func mldv1(t byte, group string) []byte {
+	return append([]byte{t, 0, 0, 0, 0, 0, 0, 0}, netip.MustParseAddr(group).AsSlice()...)
+}
+
+func mldv2Record(recordType byte, group string, sources uint16) []byte {
+	msg := []byte{143, 0, 0, 0, 0, 0, 0, 1, recordType, 0, byte(sources >> 8), byte(sources)}
+	msg = append(msg, netip.MustParseAddr(group).AsSlice()...)
+
+	for range sources {
+		msg = append(msg, netip.MustParseAddr("2001:db8::1").AsSlice()...)
+	}
+
+	return msg
+}
+
+func TestSummarizerMulticast(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0")
+
+	s.Add(mldFrame(1, mldv1(130, "::")...), Metadata{})
+	s.Add(mldFrame(2, mldv1(131, "ff02::fb")...), Metadata{})
+	s.Add(mldFrame(3, mldv2Record(4, "ff02::fb", 0)...), Metadata{})
+	s.Add(mldFrame(3, mldv2Record(5, "ff05::1:3", 1)...), Metadata{})
+	s.Add(mldFrame(4, mldv2Record(4, "ff02::fb", 0)...), Metadata{})
+	s.Add(mldFrame(4, mldv2Record(3, "ff02::fb", 0)...), Metadata{})
+	s.Add(mldFrame(2, mldv1(132, "ff02::1:2")...), Metadata{})
+	// blocking sources doesn't leave the group
+	s.Add(mldFrame(3, mldv2Record(6, "ff05::1:3", 1)...), Metadata{})
+
+	summary := s.Snapshot()
+
+	require.NotNil(t, summary.Multicast)
+	assert.Equal(t, MulticastSummary{
+		Groups: []MulticastGroup{
+			{Group: "ff02::1:2", Left: []string{"00:16:3e:00:00:02"}},
+			{
+				Group:     "ff02::fb",
+				Listeners: []string{"00:16:3e:00:00:02", "00:16:3e:00:00:03"},
+				Left:      []string{"00:16:3e:00:00:04"},
+			},
+			{Group: "ff05::1:3", Listeners: []string{"00:16:3e:00:00:03"}},
+		},
+		Queriers: []string{"fe80::1"},
+	}, *summary.Multicast)
+
+	assert.Nil(t, s.Snapshot().Multicast)
+}
+
+func TestSummarizerBoundsMulticast(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0")
+
+	for i := range maxGroupListeners + 1 {
+		s.Add(mldFrame(byte(i), mldv1(131, "ff02::fb")...), Metadata{})
+	}
+
+	summary := s.Snapshot()
+
+	require.NotNil(t, summary.Multicast)
+	assert.True(t, summary.Multicast.Truncated)
+	assert.Len(t, summary.Multicast.Groups[0].Listeners, maxGroupListeners)
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
+)
+
+// Conn is an AF_PACKET socket bound to a single interface.
+//
+// The filter, the memberships and the auxdata of a Conn can change while it
+// receives, with SetFilter, SetMembership and SetAuxData, without losing a
+// frame nor resetting its Stats. The protocol it is bound to and the
+// buffers of the XDP backend, whose rings are sized when it is opened,
+// can't: they take a new Conn.
+type Conn struct {
+	raw   syscall.RawConn
+	file  *os.File
+	iface *net.Interface
+	cfg   config
+
+	// swap is the filter swap in progress, see SetFilter
+	swap     atomic.Pointer[filterSwap]
+	filterMu sync.Mutex
+	drained  atomic.Uint64
+
+	stats   Stats
+	statsMu sync.Mutex
+	// baseline is the Stats when the filter was last replaced
+	baseline Stats
+	// opened is when the socket was opened
+	opened time.Time
+	// hwTimestamps is set when the NIC timestamps the frames
+	hwTimestamps bool
+	closed       atomic.Bool
+	membership   Membership
+	membershipMu sync.Mutex
+	dropsWarned  bool
+}
+
+// Listen opens an AF_PACKET socket on the named interface
+func Listen(iface string, options ...Option) (*Conn, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", iface, err)
+	}
+
+	return listen(ifi, newConfig(options))
+}
+
+func listen(ifi *net.Interface, cfg config) (*Conn, error) {
+	// the socket is opened with protocol 0, so it receives nothing until
+	// it is bound, this way no frame reaches it before the filter is set
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		return nil, socketError(err)
+	}
+
+	c := &Conn{iface: ifi, cfg: cfg, opened: time.Now()}
+
+	if err := c.setup(fd); err != nil {
+		unix.Close(fd) //nolint:errcheck // already returning the setup error
+
+		return nil, err
+	}
+
+	// a non-blocking fd wrapped in os.File is handled by the runtime
+	// poller, which provides deadlines and unblocks reads on Close
+	c.file = os.NewFile(uintptr(fd), "packet:"+ifi.Name)
+
+	c.raw, err = c.file.SyscallConn()
+	if err != nil {
+		c.file.Close() //nolint:errcheck,gosec // already returning the error
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Conn) setup(fd int) error {
+	if err := c.setBuffers(fd); err != nil {
+		return err
+	}
+
+	if c.cfg.filter != nil {
+		if err := setFilter(fd, c.cfg.filter); err != nil {
+			return err
+		}
+	}
+
+	if err := c.join(fd, c.cfg.membership); err != nil {
+		return err
+	}
+
+	// VLAN tags stripped by the NIC are only reported through auxdata
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_AUXDATA, 1); err != nil {
+		return fmt.Errorf("failed to enable auxdata: %w", err)
+	}
+
+	if err := c.setTimestamping(fd); err != nil {
+		return err
+	}
+
+	sa := &unix.SockaddrLinklayer{
+		Protocol: hostorder.Htons(c.cfg.protocol),
+		Ifindex:  c.iface.Index,
+	}
+
+	if err := unix.Bind(fd, sa); err != nil {
+		return fmt.Errorf("failed to bind to %s: %w", c.iface.Name, err)
+	}
+
+	return nil
+}
+
+// Interface returns the interface the socket is bound to
+func (c *Conn) Interface() *net.Interface {
+	return c.iface
+}
+
+// ReadFrame reads a single frame into buf, frames larger than buf are
+// truncated
+func (c *Conn) ReadFrame(buf []byte) (int, error) {
+	for {
+		var (
+			n   int
+			err error
+		)
+
+		rerr := c.raw.Read(func(fd uintptr) bool {
+			swap := c.pendingSwap()
+			n, _, err = unix.Recvfrom(int(fd), buf, 0)
+
+			if errors.Is(err, unix.EAGAIN) {
+				c.endSwap(swap)
+
+				return false
+			}
+
+			return true
+		})
+		if rerr != nil {
+			return 0, c.wrapClosed(rerr)
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("failed reading from %s: %w", c.iface.Name, err)
+		}
+
+		if n, ok := c.admit(buf, n, nil); ok {
+			return n, nil
+		}
+	}
+}
+
+// ReadFrameMetadata reads a single frame into buf together with its
+// metadata, frames larger than buf are truncated
+func (c *Conn) ReadFrameMetadata(buf []byte) (Metadata, error) {
+	var (
+		n, oobn int
+		from    unix.Sockaddr
+		err     error
+	)
+
+	oob := make([]byte, controlSpace)
+
+	for {
+		rerr := c.raw.Read(func(fd uintptr) bool {
+			swap := c.pendingSwap()
+			n, oobn, _, from, err = unix.Recvmsg(int(fd), buf, oob, 0)
+
+			if errors.Is(err, unix.EAGAIN) {
+				c.endSwap(swap)
+
+				return false
+			}
+
+			return true
+		})
+		if rerr != nil {
+			return Metadata{}, c.wrapClosed(rerr)
+		}
+
+		if err != nil {
+			return Metadata{}, fmt.Errorf("failed reading from %s: %w", c.iface.Name, err)
+		}
+
+		var pktType uint8
+
+		if sa, ok := from.(*unix.SockaddrLinklayer); ok {
+			pktType = sa.Pkttype
+		}
+
+		md := c.metadata(n, pktType, oob[:oobn])
+
+		if n, ok := c.admit(buf, n, &md); ok {
+			md.CaptureLength = n
+
+			return md, nil
+		}
+	}
+}
+
+func (c *Conn) metadata(n int, pktType uint8, oob []byte) Metadata {
+	md := Metadata{
+		Interface:     c.iface.Name,
+		Ifindex:       c.iface.Index,
+		CaptureLength: n,
+		Length:        n,
+	}
+
+	parseSockaddr(&md, pktType)
+	parseControl(&md, oob)
+
+	if md.TimestampSource == TimestampUnknown {
+		md.Timestamp = time.Now()
+		md.TimestampSource = TimestampWallClock
+	}
+
+	return md
+}
+
+// WriteFrame transmits a frame on the interface
+func (c *Conn) WriteFrame(frame []byte) error {
+	sa := &unix.SockaddrLinklayer{
+		Protocol: hostorder.Htons(c.cfg.protocol),
+		Ifindex:  c.iface.Index,
+	}
+
+	var err error
+
+	werr := c.raw.Write(func(fd uintptr) bool {
+		err = unix.Sendto(int(fd), frame, 0, sa)
+
+		return !errors.Is(err, unix.EAGAIN)
+	})
+	if werr != nil {
+		return c.wrapClosed(werr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed writing to %s: %w", c.iface.Name, err)
+	}
+
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future ReadFrame calls
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.file.SetReadDeadline(t)
+}
+
+// Close closes the socket, unblocking any pending ReadFrame
+func (c *Conn) Close() error {
+	c.closed.Store(true)
+
+	return c.file.Close()
+}
+
+func setFilter(fd int, filter []bpf.RawInstruction) error {
+	if len(filter) == 0 {
+		return fmt.Errorf("failed to attach filter: %w", unix.EINVAL)
+	}
+
+	// bpf.RawInstruction has the same memory layout as struct sock_filter
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)), //nolint:gosec // BPF programs are at most 4096 instructions
+		Filter: (*unix.SockFilter)(unsafe.Pointer(&filter[0])),
+	}
+
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		return fmt.Errorf("failed to attach filter: %w", err)
+	}
+
+	return nil
+}
+
+// wrapClosed maps the errors of the runtime poller for a closed file, which
+// RawConn doesn't translate to os.ErrClosed, to ErrClosed
+func (c *Conn) wrapClosed(err error) error {
+	if c.closed.Load() || errors.Is(err, os.ErrClosed) {
+		return fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+
+	return err
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	macHeaderLen = 12
+	vlanTagLen   = 4
+	// defaultSnaplen is the snapshot length tcpdump uses
+	defaultSnaplen = 262144
+)
+
+var (
+	// ErrNoTimestamp is returned when writing a frame whose metadata
+	// carries no timestamp
+	ErrNoTimestamp = errors.New("frame metadata has no timestamp")
+)
+
+// PcapWriter writes frames to a pcap file with nanosecond timestamps
+type PcapWriter struct {
+	w         *pcapgo.Writer
+	redaction *Redaction
+	buf       []byte
+	snaplen   uint32
+}
+
+// PcapWriterOption configures a PcapWriter
+type PcapWriterOption func(*PcapWriter)
+
+// WithRedaction removes the payloads of the frames as r tells, before they
+// are cut to the snaplen
+func WithRedaction(r Redaction) PcapWriterOption {
+	return func(w *PcapWriter) {
+		w.redaction = &r
+	}
+}
+
+// NewPcapWriter writes the pcap file header to w, frames are cut to snaplen
+// bytes and a snaplen of 0 keeps whole frames
+func NewPcapWriter(w io.Writer, snaplen uint32, options ...PcapWriterOption) (*PcapWriter, error) {
+	if snaplen == 0 {
+		snaplen = defaultSnaplen
+	}
+
+	pw := &PcapWriter{w: pcapgo.NewWriterNanos(w), snaplen: snaplen}
+
+	for _, opt := range options {
+		opt(pw)
+	}
+
+	if pw.redaction != nil {
+		if err := pw.redaction.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := pw.w.WriteFileHeader(snaplen, layers.LinkTypeEthernet); err != nil {
+		return nil, fmt.Errorf("failed writing pcap header: %w", err)
+	}
+
+	return pw, nil
+}
+
+// WriteFrame records a frame with the time and lengths from its metadata,
+// so a replay sees the frames as they were captured. The pcap format has
+// no room for the interface, which the reader is told instead. A VLAN tag stripped
+// by the NIC is put back in the frame, as libpcap does.
+func (w *PcapWriter) WriteFrame(frame []byte, md Metadata) error {
+	if md.Timestamp.IsZero() {
+		return ErrNoTimestamp
+	}
+
+	frame, ci := record(&w.buf, frame, md, w.redaction, w.snaplen)
+
+	return w.w.WritePacket(ci, frame)
+}
+
+// record returns the frame to write to a capture file and its capture
+// info, with the VLAN tag stripped by the NIC put back into buf, redacted
+// unless redaction is nil and cut to snaplen bytes
+func record(buf *[]byte, frame []byte, md Metadata, redaction *Redaction,
+	snaplen uint32,
+) ([]byte, gopacket.CaptureInfo) {
+	length := max(md.Length, len(frame))
+
+	if md.VLAN.Valid && len(frame) >= macHeaderLen {
+		b := append((*buf)[:0], frame[:macHeaderLen]...)
+		b = binary.BigEndian.AppendUint16(b, md.VLAN.TPID)
+		b = binary.BigEndian.AppendUint16(b, md.VLAN.TCI)
+		b = append(b, frame[macHeaderLen:]...)
+		*buf = b
+		frame = b
+		length += vlanTagLen
+	}
+
+	if redaction != nil {
+		*buf = redaction.Redact(*buf, frame)
+		frame = *buf
+	}
+
+	if len(frame) > int(snaplen) {
+		frame = frame[:snaplen]
+	}
+
+	return frame, gopacket.CaptureInfo{
+		Timestamp:     md.Timestamp,
+		CaptureLength: len(frame),
+		Length:        length,
+	}
+}
+
+// packetSource is a reader of the frames of a pcap or pcapng file
+type packetSource interface {
+	ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// PcapReader replays the frames of a pcap or pcapng file, it implements
+// FrameReader and MetadataReader
+type PcapReader struct {
+	r  packetSource
+	c  io.Closer
+	ng *pcapgo.NgReader
+	// stats are the last interface statistics of a pcapng file, nil until
+	// read
+	stats *pcapgo.NgInterfaceStatistics
+	iface string
+}
+
+// NewPcapReader reads the header of the pcap or pcapng file r, only
+// ethernet captures are supported. iface is reported as the capturing
+// interface, or the one a pcapng file names when empty.
+func NewPcapReader(r io.Reader, iface string) (*PcapReader, error) {
+	br := bufio.NewReader(r)
+	pr := &PcapReader{iface: iface}
+	pr.c, _ = r.(io.Closer)
+
+	b, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading pcap header: %w", err)
+	}
+
+	magic, err := readFileMagic(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading pcap header: %w", err)
+	}
+
+	if magic.format == FormatPcapNg {
+		options := pcapgo.DefaultNgReaderOptions
+		options.StatisticsCallback = func(_ int, st pcapgo.NgInterfaceStatistics) {
+			pr.stats = &st
+		}
+
+		ng, err := pcapgo.NewNgReader(br, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading pcapng header: %w", err)
+		}
+
+		pr.r, pr.ng = ng, ng
+
+		if intf, err := ng.Interface(0); err == nil && pr.iface == "" {
+			pr.iface = intf.Name
+		}
+	} else {
+		r, err := pcapgo.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading pcap header: %w", err)
+		}
+
+		pr.r = r
+	}
+
+	if pr.r.LinkType() != layers.LinkTypeEthernet {
+		return nil, fmt.Errorf("%w: link type %s", ErrUnsupported, pr.r.LinkType())
+	}
+
+	return pr, nil
+}
+
+// Manifest returns the manifest a pcapng file embeds, false for a pcap file
+// or a pcapng file written by another tool. The end of the capture is read
+// from the statistics at the end of the file unless the manifest tells it,
+// once the frames were read.
+func (r *PcapReader) Manifest() (Manifest, bool, error) {
+	if r.ng == nil {
+		return Manifest{}, false, nil
+	}
+
+	comment := r.ng.SectionInfo().Comment
+	if !strings.HasPrefix(comment, "{") {
+		return Manifest{}, false, nil
+	}
+
+	m, err := decodeManifest([]byte(comment))
+	if err != nil {
+		return Manifest{}, false, err
+	}
+
+	if m.End.Time.IsZero() && r.stats != nil {
+		m.End = ManifestStats{Time: r.stats.EndTime, Stats: Stats{
+			Packets: m.Start.Packets + r.stats.PacketsReceived,
+			Drops:   m.Start.Drops + r.stats.PacketsDropped,
+		}}
+	}
+
+	return m, true, nil
+}
+
+// ReadFrame reads the next frame into buf, it returns io.EOF at the end
+// of the file
+func (r *PcapReader) ReadFrame(buf []byte) (int, error) {
+	md, err := r.ReadFrameMetadata(buf)
+
+	return md.CaptureLength, err
+}
+
+// ReadFrameMetadata reads the next frame into buf with the timestamp and
+// lengths recorded in the file
+func (r *PcapReader) ReadFrameMetadata(buf []byte) (Metadata, error) {
+	data, ci, err := r.r.ZeroCopyReadPacketData()
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	n := copy(buf, data)
+
+	return Metadata{
+		Timestamp:       ci.Timestamp,
+		TimestampSource: TimestampUnknown,
+		Interface:       r.iface,
+		CaptureLength:   n,
+		Length:          max(ci.Length, n),
+	}, nil
+}
+
+// SetReadDeadline is a no-op, reading from a file never blocks
+func (r *PcapReader) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+// Close closes the underlying reader if it is an io.Closer
+func (r *PcapReader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+
+	return r.c.Close()
+}
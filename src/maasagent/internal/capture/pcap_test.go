@@ -0,0 +1,119 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPcapRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Unix(1700000000, 123456789)
+
+	testcases := map[string]struct {
+		frame   []byte
+		md      Metadata
+		snaplen uint32
+		out     []byte
+		outMD   Metadata
+	}{
+		"untagged": {
+			frame: testFrame("pcap"),
+			md:    Metadata{Timestamp: ts, Ifindex: 3, CaptureLength: 18, Length: 18},
+			out:   testFrame("pcap"),
+			outMD: Metadata{Timestamp: ts, Interface: "eth0", CaptureLength: 18, Length: 18},
+		},
+		"stripped tag is reinserted": {
+			frame: testFrame("pcap"),
+			md: Metadata{
+				Timestamp:     ts,
+				CaptureLength: 18,
+				Length:        18,
+				VLAN:          VLANInfo{TCI: 0x0064, TPID: 0x8100, Valid: true},
+			},
+			out:   append(append(testFrame("")[:12:12], 0x81, 0x00, 0x00, 0x64), testFrame("pcap")[12:]...),
+			outMD: Metadata{Timestamp: ts, Interface: "eth0", CaptureLength: 22, Length: 22},
+		},
+		"snaplen keeps the wire length": {
+			frame:   testFrame("pcap"),
+			md:      Metadata{Timestamp: ts, CaptureLength: 18, Length: 1514},
+			snaplen: 14,
+			out:     testFrame(""),
+			outMD:   Metadata{Timestamp: ts, Interface: "eth0", CaptureLength: 14, Length: 1514},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var file bytes.Buffer
+
+			w, err := NewPcapWriter(&file, tc.snaplen)
+			require.NoError(t, err)
+			require.NoError(t, w.WriteFrame(tc.frame, tc.md))
+
+			r, err := NewPcapReader(&file, "eth0")
+			require.NoError(t, err)
+
+			buf := make([]byte, 1500)
+
+			md, err := r.ReadFrameMetadata(buf)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.out, buf[:md.CaptureLength])
+			assert.True(t, tc.outMD.Timestamp.Equal(md.Timestamp), md.Timestamp)
+
+			md.Timestamp = tc.outMD.Timestamp
+			assert.Equal(t, tc.outMD, md)
+
+			_, err = r.ReadFrame(buf)
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestPcapWriterRequiresTimestamp(t *testing.T) {
+	t.Parallel()
+
+	w, err := NewPcapWriter(io.Discard, 0)
+	require.NoError(t, err)
+
+	err = w.WriteFrame(testFrame(""), Metadata{CaptureLength: 14, Length: 14})
+	assert.ErrorIs(t, err, ErrNoTimestamp)
+}
+
+func TestPcapReaderLinkType(t *testing.T) {
+	t.Parallel()
+
+	var file bytes.Buffer
+
+	require.NoError(t, pcapgo.NewWriter(&file).WriteFileHeader(0, layers.LinkTypeRaw))
+
+	_, err := NewPcapReader(&file, "eth0")
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
@@ -0,0 +1,821 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// defaultMappedWindow and defaultStreamedWindow are the parts of a
+	// file mapped, or read, at a time
+	defaultMappedWindow   = 64 << 20
+	defaultStreamedWindow = 1 << 20
+	// maxPcapRecord bounds a record, or a pcapng block, a frame of the
+	// largest snaplen fits many times over
+	maxPcapRecord = 16 << 20
+	// defaultIndexEntries bounds the entries of the index of a file, each
+	// covers indexStride records or a multiple of them
+	defaultIndexEntries = 1 << 16
+	indexStride         = 256
+	// maxNgInterfaces bounds the interfaces of a pcapng section
+	maxNgInterfaces = 4096
+
+	pcapHeaderLen       = 24
+	pcapRecordHeaderLen = 16
+
+	ngBlockInterface      = 0x00000001
+	ngBlockSimplePacket   = 0x00000003
+	ngBlockEnhancedPacket = 0x00000006
+	ngOptionEnd           = 0
+	ngOptionName          = 2
+	ngOptionTsResol       = 9
+	ngOptionTsOffset      = 14
+)
+
+var (
+	// ErrMalformedPcap is returned for a capture file which can't be read
+	ErrMalformedPcap = errors.New("malformed capture file")
+	// ErrRecordOutOfRange is returned when seeking past the last record of
+	// a capture file
+	ErrRecordOutOfRange = errors.New("record out of range")
+)
+
+// pcapWindow is the part of a file read at a time, mapped in memory or read
+// into a buffer when the file can't be mapped
+type pcapWindow struct {
+	file *os.File
+	// data holds the bytes of the file from off
+	data   []byte
+	buf    []byte
+	off    int64
+	size   int64
+	window int
+	mapped bool
+}
+
+// bytes returns the n bytes of the file at off, they are valid until the
+// next call
+func (w *pcapWindow) bytes(off int64, n int) ([]byte, error) {
+	if off < 0 || n < 0 || off+int64(n) > w.size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if off >= w.off && off+int64(n) <= w.off+int64(len(w.data)) {
+		return w.data[off-w.off : off-w.off+int64(n)], nil
+	}
+
+	// the window starts on a page, as a mapping must
+	start := off - off%int64(os.Getpagesize())
+	length := min(max(int64(w.window), off-start+int64(n)), w.size-start)
+
+	if err := w.release(); err != nil {
+		return nil, err
+	}
+
+	if w.mapped {
+		data, err := unix.Mmap(int(w.file.Fd()), start, int(length), unix.PROT_READ, unix.MAP_SHARED) //nolint:gosec // a file descriptor
+		if err != nil {
+			return nil, fmt.Errorf("failed mapping %s: %w", w.file.Name(), err)
+		}
+
+		// the records are mostly read in order, the kernel reads ahead
+		_ = unix.Madvise(data, unix.MADV_SEQUENTIAL) //nolint:errcheck // only a hint
+
+		w.data = data
+	} else {
+		w.buf = slices.Grow(w.buf[:0], int(length))[:length]
+
+		if _, err := w.file.ReadAt(w.buf, start); err != nil {
+			return nil, fmt.Errorf("failed reading %s: %w", w.file.Name(), err)
+		}
+
+		w.data = w.buf
+	}
+
+	w.off = start
+
+	return w.data[off-start : off-start+int64(n)], nil
+}
+
+// release unmaps the window of a mapped file
+func (w *pcapWindow) release() error {
+	if !w.mapped || w.data == nil {
+		return nil
+	}
+
+	data := w.data
+	w.data = nil
+
+	return unix.Munmap(data)
+}
+
+// ngSection is the state of a pcapng section its blocks are read with
+type ngSection struct {
+	order  binary.ByteOrder
+	ifaces []ngInterface
+}
+
+// ngInterface is an interface of a pcapng section
+type ngInterface struct {
+	name string
+	// tsOffset is added to the timestamps, in seconds
+	tsOffset int64
+	// tsUnit is the number of timestamp units in a second, a power of 10
+	// or of 2
+	tsUnit uint64
+}
+
+// time returns the time of the timestamp ts of the interface
+func (i ngInterface) time(ts uint64) time.Time {
+	sec, frac := ts/i.tsUnit, ts%i.tsUnit
+
+	// frac is less than tsUnit, so is the high word of its product
+	hi, lo := bits.Mul64(frac, uint64(time.Second))
+	nsec, _ := bits.Div64(hi, lo, i.tsUnit)
+
+	return time.Unix(int64(sec)+i.tsOffset, int64(nsec)) //nolint:gosec // far from overflowing
+}
+
+// pcapIndexEntry locates a run of records of a file, their timestamps are
+// from first to last in nanoseconds since the epoch, in any order
+type pcapIndexEntry struct {
+	section *ngSection
+	offset  int64
+	record  int
+	first   int64
+	last    int64
+}
+
+// overlaps returns true if a record of the entry may be from from to to
+func (e pcapIndexEntry) overlaps(from, to int64) bool {
+	return e.last >= from && e.first < to
+}
+
+// PcapFile reads the frames of a pcap or pcapng file in place, which may be
+// much larger than the memory: the file is mapped a window at a time, or
+// read a window at a time where it can't be mapped, such as on some network
+// filesystems. NextFrame returns the frames without copying them.
+//
+// The first call needing the number of records or random access, such as
+// SeekRecord or SetTimeRange, indexes the records in a pass over the file.
+// The index locates runs of records and their range of timestamps, it
+// holds a bounded number of entries: the runs are merged two by two once
+// it is full. Whatever the size of the file, a PcapFile holds a window and
+// the index.
+//
+// A PcapFile implements FrameReader and MetadataReader, it isn't safe for
+// concurrent use.
+type PcapFile struct {
+	window  pcapWindow
+	order   binary.ByteOrder
+	section *ngSection
+	index   []pcapIndexEntry
+	iface   string
+	// start is the offset of the first record, or pcapng block after the
+	// first section header
+	start int64
+	// pos is the offset of the next record, or pcapng block, and at that
+	// of the record last read
+	pos int64
+	at  int64
+	// record is the number of the next record, from 0, and records the
+	// number of records once indexed
+	record  int
+	records int
+	// stride is the number of records of an entry of the index, limit the
+	// number of entries
+	stride int
+	limit  int
+	// from and to are the range of the timestamps of the frames read,
+	// in nanoseconds since the epoch
+	from    int64
+	to      int64
+	ranged  bool
+	nanos   bool
+	ng      bool
+	indexed bool
+}
+
+// PcapFileOption configures a PcapFile
+type PcapFileOption func(*PcapFile)
+
+// WithPcapStreaming reads the file into a buffer rather than map it
+func WithPcapStreaming() PcapFileOption {
+	return func(f *PcapFile) {
+		f.window.mapped = false
+	}
+}
+
+// WithPcapWindow sets the bytes of the file mapped or read at a time, a
+// record larger than that is read whole
+func WithPcapWindow(n int) PcapFileOption {
+	return func(f *PcapFile) {
+		if n > 0 {
+			f.window.window = n
+		}
+	}
+}
+
+// WithPcapIndexLimit bounds the entries of the index to n
+func WithPcapIndexLimit(n int) PcapFileOption {
+	return func(f *PcapFile) {
+		if n > 1 {
+			f.limit = n
+		}
+	}
+}
+
+// OpenPcapFile opens the pcap or pcapng file at path, only ethernet captures
+// are supported. iface is reported as the capturing interface, or those a
+// pcapng file names when empty. The file is mapped unless that fails, or
+// WithPcapStreaming is given.
+func OpenPcapFile(path, iface string, options ...PcapFileOption) (*PcapFile, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := file.Stat()
+	if err != nil {
+		file.Close() //nolint:errcheck,gosec // already returning the stat error
+		return nil, err
+	}
+
+	f := &PcapFile{
+		window: pcapWindow{file: file, size: st.Size(), mapped: st.Mode().IsRegular()},
+		iface:  iface,
+		stride: indexStride,
+		limit:  defaultIndexEntries,
+		from:   math.MinInt64,
+		to:     math.MaxInt64,
+	}
+
+	for _, opt := range options {
+		opt(f)
+	}
+
+	if err := f.readHeader(); err != nil {
+		f.Close() //nolint:errcheck,gosec // already returning the header error
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// readHeader reads the header of the file, it falls back to reading the
+// file when it can't be mapped
+func (f *PcapFile) readHeader() error {
+	if f.window.window == 0 {
+		f.window.window = defaultStreamedWindow
+		if f.window.mapped {
+			f.window.window = defaultMappedWindow
+		}
+	}
+
+	_, err := f.window.bytes(0, min(int(f.window.size), pcapHeaderLen))
+	if err != nil && f.window.mapped {
+		log.Debug().Err(err).Msg("Reading the capture file rather than mapping it")
+
+		f.window.mapped, f.window.window = false, min(f.window.window, defaultStreamedWindow)
+	}
+
+	hdr, err := f.window.bytes(0, 8)
+	if err != nil {
+		return fmt.Errorf("%w: no header", ErrMalformedPcap)
+	}
+
+	magic, err := readFileMagic(hdr)
+	if err != nil {
+		return err
+	}
+
+	if magic.format == FormatPcapNg {
+		f.ng = true
+
+		if err := f.readSection(0); err != nil {
+			return err
+		}
+
+		f.start = f.pos
+
+		return nil
+	}
+
+	f.order, f.nanos = magic.order, magic.nanos
+
+	hdr, err = f.window.bytes(0, pcapHeaderLen)
+	if err != nil {
+		return fmt.Errorf("%w: no header", ErrMalformedPcap)
+	}
+
+	// the high bits of the link type tell the FCS the frames carry
+	if link := layers.LinkType(f.order.Uint32(hdr[20:]) & 0xffff); link != layers.LinkTypeEthernet {
+		return fmt.Errorf("%w: link type %s", ErrUnsupported, link)
+	}
+
+	f.start, f.pos = pcapHeaderLen, pcapHeaderLen
+
+	return nil
+}
+
+// Mapped returns true if the file is mapped rather than read
+func (f *PcapFile) Mapped() bool {
+	return f.window.mapped
+}
+
+// Format returns the format of the file
+func (f *PcapFile) Format() FileFormat {
+	if f.ng {
+		return FormatPcapNg
+	}
+
+	return FormatPcap
+}
+
+// block returns the pcapng block at off and its type, the pcapng blocks
+// are read in the byte order of their section
+func (f *PcapFile) block(off int64) (uint32, []byte, error) {
+	hdr, err := f.window.bytes(off, 12)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: truncated block at %d", ErrMalformedPcap, off)
+	}
+
+	var order binary.ByteOrder
+
+	// a section header tells the byte order of its section, the blocks
+	// after it are read in the order of the current one
+	if binary.BigEndian.Uint32(hdr) == pcapNgMagic {
+		if order, err = sectionByteOrder(hdr[8:]); err != nil {
+			return 0, nil, fmt.Errorf("%w at %d", err, off)
+		}
+	} else {
+		order = f.section.order
+	}
+
+	kind := order.Uint32(hdr)
+
+	length := order.Uint32(hdr[4:])
+	if length < 12 || length%4 != 0 || length > maxPcapRecord {
+		return 0, nil, fmt.Errorf("%w: block of %d bytes at %d", ErrMalformedPcap, length, off)
+	}
+
+	b, err := f.window.bytes(off, int(length))
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: truncated block at %d", ErrMalformedPcap, off)
+	}
+
+	return kind, b, nil
+}
+
+// readSection starts the section whose header is at off
+func (f *PcapFile) readSection(off int64) error {
+	_, b, err := f.block(off)
+	if err != nil {
+		return err
+	}
+
+	order, err := sectionByteOrder(b[8:])
+	if err != nil {
+		return fmt.Errorf("%w at %d", err, off)
+	}
+
+	f.section = &ngSection{order: order}
+
+	f.pos = off + int64(len(b))
+
+	return nil
+}
+
+// readInterface adds the interface described by the block b to the section
+func (f *PcapFile) readInterface(b []byte) error {
+	order := f.section.order
+
+	if len(b) < 20 {
+		return fmt.Errorf("%w: interface block of %d bytes", ErrMalformedPcap, len(b))
+	}
+
+	if len(f.section.ifaces) >= maxNgInterfaces {
+		return fmt.Errorf("%w: more than %d interfaces", ErrMalformedPcap, maxNgInterfaces)
+	}
+
+	if link := layers.LinkType(order.Uint16(b[8:])); link != layers.LinkTypeEthernet {
+		return fmt.Errorf("%w: link type %s", ErrUnsupported, link)
+	}
+
+	intf := ngInterface{tsUnit: uint64(time.Second / time.Microsecond)}
+
+	for opts := b[16 : len(b)-4]; len(opts) >= 4; {
+		code, n := order.Uint16(opts), int(order.Uint16(opts[2:]))
+		if code == ngOptionEnd {
+			break
+		}
+
+		padded := 4 + (n+3)&^3
+		if padded > len(opts) {
+			return fmt.Errorf("%w: truncated interface option %d", ErrMalformedPcap, code)
+		}
+
+		value := opts[4 : 4+n]
+
+		switch {
+		case code == ngOptionName:
+			intf.name = string(value)
+		case code == ngOptionTsResol && n == 1:
+			unit, ok := tsUnit(value[0])
+			if !ok {
+				return fmt.Errorf("%w: timestamp resolution %#02x", ErrMalformedPcap, value[0])
+			}
+
+			intf.tsUnit = unit
+		case code == ngOptionTsOffset && n == 8:
+			intf.tsOffset = int64(order.Uint64(value)) //nolint:gosec // signed on the wire
+		}
+
+		opts = opts[padded:]
+	}
+
+	f.section.ifaces = append(f.section.ifaces, intf)
+
+	return nil
+}
+
+// tsUnit returns the number of units in a second of the if_tsresol option
+// resol, a negative power of 10, or of 2 with the high bit set
+func tsUnit(resol byte) (uint64, bool) {
+	exp := resol & 0x7f
+
+	if resol&0x80 != 0 {
+		return 1 << exp, exp < 64
+	}
+
+	if exp > 19 {
+		return 0, false
+	}
+
+	unit := uint64(1)
+	for range exp {
+		unit *= 10
+	}
+
+	return unit, true
+}
+
+// next reads the next record, its frame is valid until the next read
+func (f *PcapFile) next() ([]byte, Metadata, error) {
+	if f.ng {
+		return f.nextNg()
+	}
+
+	if f.pos == f.window.size {
+		return nil, Metadata{}, io.EOF
+	}
+
+	hdr, err := f.window.bytes(f.pos, pcapRecordHeaderLen)
+	if err != nil {
+		return nil, Metadata{}, fmt.Errorf("%w: truncated record %d", ErrMalformedPcap, f.record)
+	}
+
+	sec, frac := f.order.Uint32(hdr), f.order.Uint32(hdr[4:])
+	captured, length := f.order.Uint32(hdr[8:]), f.order.Uint32(hdr[12:])
+
+	if captured > maxPcapRecord {
+		return nil, Metadata{}, fmt.Errorf("%w: record %d of %d bytes", ErrMalformedPcap, f.record, captured)
+	}
+
+	b, err := f.window.bytes(f.pos, pcapRecordHeaderLen+int(captured))
+	if err != nil {
+		return nil, Metadata{}, fmt.Errorf("%w: truncated record %d", ErrMalformedPcap, f.record)
+	}
+
+	if !f.nanos {
+		frac *= uint32(time.Microsecond)
+	}
+
+	f.at, f.pos = f.pos, f.pos+int64(len(b))
+	f.record++
+
+	frame := b[pcapRecordHeaderLen:]
+
+	return frame, f.metadata(time.Unix(int64(sec), int64(frac)), f.iface, len(frame), int(length)), nil
+}
+
+// nextNg reads the next packet block, reading the blocks describing the
+// section and its interfaces on the way
+func (f *PcapFile) nextNg() ([]byte, Metadata, error) {
+	for f.pos < f.window.size {
+		kind, b, err := f.block(f.pos)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		order := f.section.order
+
+		switch kind {
+		case pcapNgMagic:
+			if err := f.readSection(f.pos); err != nil {
+				return nil, Metadata{}, err
+			}
+
+			continue
+		case ngBlockInterface:
+			if err := f.readInterface(b); err != nil {
+				return nil, Metadata{}, err
+			}
+		case ngBlockEnhancedPacket:
+			if len(b) < 32 {
+				return nil, Metadata{}, fmt.Errorf("%w: packet block of %d bytes", ErrMalformedPcap, len(b))
+			}
+
+			id, captured := order.Uint32(b[8:]), order.Uint32(b[20:])
+			if int(id) >= len(f.section.ifaces) || captured > uint32(len(b)-32) { //nolint:gosec // bounded
+				return nil, Metadata{}, fmt.Errorf("%w: packet block at %d", ErrMalformedPcap, f.pos)
+			}
+
+			intf := f.section.ifaces[id]
+			ts := intf.time(uint64(order.Uint32(b[12:]))<<32 | uint64(order.Uint32(b[16:])))
+
+			return f.packet(b, b[28:28+captured], ts, intf, int(order.Uint32(b[24:])))
+		case ngBlockSimplePacket:
+			if len(b) < 16 || len(f.section.ifaces) == 0 {
+				return nil, Metadata{}, fmt.Errorf("%w: simple packet block at %d", ErrMalformedPcap, f.pos)
+			}
+
+			length := int(order.Uint32(b[8:]))
+
+			// the simple packets carry no timestamp
+			return f.packet(b, b[12:12+min(length, len(b)-16)], time.Time{}, f.section.ifaces[0], length)
+		}
+
+		f.pos += int64(len(b))
+	}
+
+	return nil, Metadata{}, io.EOF
+}
+
+// packet returns the frame of the packet block b at f.pos
+func (f *PcapFile) packet(b, frame []byte, ts time.Time, intf ngInterface, length int) ([]byte, Metadata, error) {
+	f.at, f.pos = f.pos, f.pos+int64(len(b))
+	f.record++
+
+	iface := f.iface
+	if iface == "" {
+		iface = intf.name
+	}
+
+	return frame, f.metadata(ts, iface, len(frame), length), nil
+}
+
+// metadata returns the metadata of a frame of the file
+func (f *PcapFile) metadata(ts time.Time, iface string, captured, length int) Metadata {
+	return Metadata{
+		Timestamp:       ts,
+		TimestampSource: TimestampUnknown,
+		Interface:       iface,
+		CaptureLength:   captured,
+		Length:          max(length, captured),
+	}
+}
+
+// NextFrame returns the next frame in the time range of the file, and its
+// metadata, it returns io.EOF at the end of the file. The frame is a slice
+// of the file, valid until the next read: it must be copied to be kept.
+func (f *PcapFile) NextFrame() ([]byte, Metadata, error) {
+	for {
+		// the runs of records out of the range are skipped whole
+		if f.ranged && f.record%f.stride == 0 {
+			i := f.record / f.stride
+			for i < len(f.index) && !f.index[i].overlaps(f.from, f.to) {
+				i++
+			}
+
+			if i == len(f.index) {
+				return nil, Metadata{}, io.EOF
+			}
+
+			if f.index[i].record != f.record {
+				f.seekEntry(i)
+			}
+		}
+
+		frame, md, err := f.next()
+		if err != nil || !f.ranged {
+			return frame, md, err
+		}
+
+		if ts := unixNano(md.Timestamp); ts >= f.from && ts < f.to {
+			return frame, md, nil
+		}
+	}
+}
+
+// ReadFrame reads the next frame into buf, it returns io.EOF at the end
+// of the file
+func (f *PcapFile) ReadFrame(buf []byte) (int, error) {
+	md, err := f.ReadFrameMetadata(buf)
+
+	return md.CaptureLength, err
+}
+
+// ReadFrameMetadata reads the next frame into buf with the timestamp and
+// lengths recorded in the file
+func (f *PcapFile) ReadFrameMetadata(buf []byte) (Metadata, error) {
+	frame, md, err := f.NextFrame()
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	md.CaptureLength = copy(buf, frame)
+
+	return md, nil
+}
+
+// Record returns the number of the record NextFrame read last, from 1, 0
+// until a record is read
+func (f *PcapFile) Record() int {
+	return f.record
+}
+
+// Records returns the number of records of the file, indexing it
+func (f *PcapFile) Records() (int, error) {
+	if err := f.buildIndex(); err != nil {
+		return 0, err
+	}
+
+	return f.records, nil
+}
+
+// SeekRecord moves to the record n, from 0, which the next read returns
+// unless out of the time range. Seeking to the number of records moves to
+// the end of the file.
+func (f *PcapFile) SeekRecord(n int) error {
+	if err := f.buildIndex(); err != nil {
+		return err
+	}
+
+	if n < 0 || n > f.records {
+		return fmt.Errorf("%w: %d of %d", ErrRecordOutOfRange, n, f.records)
+	}
+
+	if n == f.records {
+		f.pos, f.record = f.window.size, n
+		return nil
+	}
+
+	f.seekEntry(n / f.stride)
+
+	for f.record < n {
+		if _, _, err := f.next(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetTimeRange restricts the frames read to those captured from from, and
+// before to, and moves to the first of them. A zero from or to leaves the
+// range open on that side.
+func (f *PcapFile) SetTimeRange(from, to time.Time) error {
+	if err := f.buildIndex(); err != nil {
+		return err
+	}
+
+	f.ranged, f.from, f.to = true, math.MinInt64, math.MaxInt64
+
+	if !from.IsZero() {
+		f.from = from.UnixNano()
+	}
+
+	if !to.IsZero() {
+		f.to = to.UnixNano()
+	}
+
+	return f.SeekRecord(0)
+}
+
+// seekEntry moves to the first record of the entry i of the index
+func (f *PcapFile) seekEntry(i int) {
+	e := f.index[i]
+	f.pos, f.record, f.section = e.offset, e.record, e.section
+}
+
+// buildIndex indexes the records of the file in a pass, unless done
+func (f *PcapFile) buildIndex() error {
+	if f.indexed {
+		return nil
+	}
+
+	pos, record, section := f.pos, f.record, f.section
+	f.pos, f.record, f.index = f.start, 0, nil
+
+	if f.ng {
+		// the interfaces of the first section precede the first record
+		if err := f.readSection(0); err != nil {
+			return err
+		}
+	}
+
+	for {
+		_, md, err := f.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			f.pos, f.record, f.section = pos, record, section
+			return err
+		}
+
+		ts := unixNano(md.Timestamp)
+
+		// a full index is compacted at the start of a run, which starts
+		// one of the merged entries or extends the last
+		if (f.record-1)%f.stride == 0 && len(f.index) == f.limit {
+			f.compact()
+		}
+
+		if (f.record-1)%f.stride != 0 {
+			e := &f.index[len(f.index)-1]
+			e.first, e.last = min(e.first, ts), max(e.last, ts)
+
+			continue
+		}
+
+		f.index = append(f.index, pcapIndexEntry{
+			section: f.section,
+			offset:  f.at,
+			record:  f.record - 1,
+			first:   ts,
+			last:    ts,
+		})
+	}
+
+	f.records, f.indexed = f.record, true
+	f.pos, f.record, f.section = pos, record, section
+
+	return nil
+}
+
+// compact merges the entries of the index two by two, each covering twice
+// the records
+func (f *PcapFile) compact() {
+	for i := 0; i < len(f.index); i += 2 {
+		e := f.index[i]
+
+		if i+1 < len(f.index) {
+			e.first, e.last = min(e.first, f.index[i+1].first), max(e.last, f.index[i+1].last)
+		}
+
+		f.index[i/2] = e
+	}
+
+	f.index = f.index[:(len(f.index)+1)/2]
+	f.stride *= 2
+}
+
+// unixNano returns t in nanoseconds since the epoch, the frames without a
+// timestamp being before any other
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return math.MinInt64
+	}
+
+	return t.UnixNano()
+}
+
+// SetReadDeadline is a no-op, reading from a file never blocks
+func (f *PcapFile) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+// Close unmaps and closes the file
+func (f *PcapFile) Close() error {
+	return errors.Join(f.window.release(), f.window.file.Close())
+}
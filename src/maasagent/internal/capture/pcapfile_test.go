@@ -0,0 +1,445 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var pcapFileEpoch = time.Unix(1700000000, 0)
+
+// pcapFileFrames writes n frames to a capture file of format in dir, the
+// frame i sent at the second offsets[i], or i without offsets
+func pcapFileFrames(t *testing.T, dir string, format FileFormat, n int, offsets ...int) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "frames."+string(format))
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+
+	defer file.Close() //nolint:errcheck // closed once written
+
+	var (
+		w interface {
+			WriteFrame(frame []byte, md Metadata) error
+		}
+		ng *PcapNgWriter
+	)
+
+	if format == FormatPcapNg {
+		ng, err = NewPcapNgWriter(file, testManifest(t))
+		require.NoError(t, err)
+
+		w = ng
+	} else {
+		w, err = NewPcapWriter(file, 0)
+		require.NoError(t, err)
+	}
+
+	for i := range n {
+		at := i
+		if len(offsets) > 0 {
+			at = offsets[i]
+		}
+
+		frame := testFrame(strconv.Itoa(i))
+		require.NoError(t, w.WriteFrame(frame, Metadata{
+			Timestamp:     pcapFileEpoch.Add(time.Duration(at) * time.Second),
+			CaptureLength: len(frame),
+			Length:        len(frame) + 10,
+		}))
+	}
+
+	// the statistics closing the file follow the frames
+	if ng != nil {
+		require.NoError(t, ng.Finish(ManifestStats{Time: pcapFileEpoch.Add(time.Hour)}))
+	}
+
+	require.NoError(t, file.Close())
+
+	return path
+}
+
+// readPcapFile returns the payloads of the frames left in f
+func readPcapFile(t *testing.T, f *PcapFile) []string {
+	t.Helper()
+
+	var payloads []string
+
+	for {
+		frame, _, err := f.NextFrame()
+		if err == io.EOF {
+			return payloads
+		}
+
+		require.NoError(t, err)
+
+		payloads = append(payloads, string(frame[14:]))
+	}
+}
+
+// payloads returns the payloads of the frames from to to, excluded
+func payloads(from, to int) []string {
+	var p []string
+
+	for i := from; i < to; i++ {
+		p = append(p, strconv.Itoa(i))
+	}
+
+	return p
+}
+
+func TestPcapFile(t *testing.T) {
+	t.Parallel()
+
+	const frames = 50
+
+	testcases := map[string]struct {
+		format  FileFormat
+		options []PcapFileOption
+		mapped  bool
+	}{
+		"pcap mapped": {
+			format: FormatPcap,
+			mapped: true,
+		},
+		"pcap streamed": {
+			format:  FormatPcap,
+			options: []PcapFileOption{WithPcapStreaming()},
+		},
+		"pcapng mapped": {
+			format: FormatPcapNg,
+			mapped: true,
+		},
+		"pcapng streamed": {
+			format:  FormatPcapNg,
+			options: []PcapFileOption{WithPcapStreaming()},
+		},
+		"small windows and index": {
+			format:  FormatPcapNg,
+			options: []PcapFileOption{WithPcapWindow(64), WithPcapIndexLimit(3)},
+			mapped:  true,
+		},
+		"small buffer and index": {
+			format:  FormatPcap,
+			options: []PcapFileOption{WithPcapStreaming(), WithPcapWindow(64), WithPcapIndexLimit(3)},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := pcapFileFrames(t, t.TempDir(), tc.format, frames)
+
+			f, err := OpenPcapFile(path, "", tc.options...)
+			require.NoError(t, err)
+
+			defer f.Close() //nolint:errcheck // only read
+
+			assert.Equal(t, tc.mapped, f.Mapped())
+			assert.Equal(t, tc.format, f.Format())
+
+			// the frames are those of a PcapReader
+			file, err := os.Open(path)
+			require.NoError(t, err)
+
+			defer file.Close() //nolint:errcheck // only read
+
+			r, err := NewPcapReader(file, "")
+			require.NoError(t, err)
+
+			buf := make([]byte, 1500)
+
+			for i := range frames {
+				want, err := r.ReadFrameMetadata(buf)
+				require.NoError(t, err)
+
+				frame, md, err := f.NextFrame()
+				require.NoError(t, err)
+				assert.Equal(t, buf[:want.CaptureLength], frame)
+				assert.True(t, want.Timestamp.Equal(md.Timestamp))
+
+				md.Timestamp = want.Timestamp
+				assert.Equal(t, want, md)
+				assert.Equal(t, i+1, f.Record())
+			}
+
+			_, err = f.ReadFrame(buf)
+			require.ErrorIs(t, err, io.EOF)
+
+			// the index locates the records
+			n, err := f.Records()
+			require.NoError(t, err)
+			assert.Equal(t, frames, n)
+
+			require.NoError(t, f.SeekRecord(17))
+			assert.Equal(t, payloads(17, frames), readPcapFile(t, f))
+
+			require.NoError(t, f.SetTimeRange(pcapFileEpoch.Add(10*time.Second), pcapFileEpoch.Add(20*time.Second)))
+			assert.Equal(t, payloads(10, 20), readPcapFile(t, f))
+
+			require.NoError(t, f.SetTimeRange(pcapFileEpoch.Add(45*time.Second), time.Time{}))
+			assert.Equal(t, payloads(45, frames), readPcapFile(t, f))
+
+			// the range holds after seeking
+			require.NoError(t, f.SeekRecord(0))
+			assert.Equal(t, payloads(45, frames), readPcapFile(t, f))
+
+			require.NoError(t, f.SeekRecord(frames))
+			assert.Empty(t, readPcapFile(t, f))
+			require.ErrorIs(t, f.SeekRecord(frames+1), ErrRecordOutOfRange)
+		})
+	}
+}
+
+func TestPcapFileUnordered(t *testing.T) {
+	t.Parallel()
+
+	// a frame of each run of the index was sent later
+	offsets := make([]int, 20)
+	for i := range offsets {
+		offsets[i] = i
+	}
+
+	offsets[3], offsets[11] = 100, 101
+
+	path := pcapFileFrames(t, t.TempDir(), FormatPcap, len(offsets), offsets...)
+
+	f, err := OpenPcapFile(path, "eth0", WithPcapIndexLimit(4))
+	require.NoError(t, err)
+
+	defer f.Close() //nolint:errcheck // only read
+
+	require.NoError(t, f.SetTimeRange(pcapFileEpoch.Add(100*time.Second), time.Time{}))
+	assert.Equal(t, []string{"3", "11"}, readPcapFile(t, f))
+
+	require.NoError(t, f.SetTimeRange(time.Time{}, pcapFileEpoch.Add(2*time.Second)))
+	assert.Equal(t, []string{"0", "1"}, readPcapFile(t, f))
+}
+
+func TestPcapFileBigEndian(t *testing.T) {
+	t.Parallel()
+
+	// a pcap file with microsecond timestamps written on a big endian host
+	file := binary.BigEndian.AppendUint32(nil, pcapMagicMicros)
+	file = binary.BigEndian.AppendUint16(file, 2)
+	file = binary.BigEndian.AppendUint16(file, 4)
+	file = append(file, make([]byte, 8)...)
+	file = binary.BigEndian.AppendUint32(file, 65535)
+	file = binary.BigEndian.AppendUint32(file, uint32(layers.LinkTypeEthernet))
+
+	frame := testFrame("be")
+	file = binary.BigEndian.AppendUint32(file, 1700000000)
+	file = binary.BigEndian.AppendUint32(file, 250000)
+	file = binary.BigEndian.AppendUint32(file, uint32(len(frame)))
+	file = binary.BigEndian.AppendUint32(file, uint32(len(frame)))
+	file = append(file, frame...)
+
+	path := filepath.Join(t.TempDir(), "be.pcap")
+	require.NoError(t, os.WriteFile(path, file, 0o600))
+
+	f, err := OpenPcapFile(path, "eth0")
+	require.NoError(t, err)
+
+	defer f.Close() //nolint:errcheck // only read
+
+	got, md, err := f.NextFrame()
+	require.NoError(t, err)
+	assert.Equal(t, frame, got)
+	assert.True(t, time.Unix(1700000000, 250000000).Equal(md.Timestamp), md.Timestamp)
+	assert.Equal(t, "eth0", md.Interface)
+}
+
+func TestPcapFileErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	write := func(name string, b []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, b, 0o600))
+
+		return path
+	}
+
+	var raw bytes.Buffer
+
+	require.NoError(t, pcapgo.NewWriter(&raw).WriteFileHeader(0, layers.LinkTypeRaw))
+
+	valid, err := os.ReadFile(pcapFileFrames(t, dir, FormatPcap, 2))
+	require.NoError(t, err)
+
+	_, err = OpenPcapFile(write("raw.pcap", raw.Bytes()), "")
+	require.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = OpenPcapFile(write("empty.pcap", nil), "")
+	require.ErrorIs(t, err, ErrMalformedPcap)
+
+	_, err = OpenPcapFile(write("text.pcap", []byte("not a capture file")), "")
+	require.ErrorIs(t, err, ErrMalformedPcap)
+
+	_, err = OpenPcapFile(filepath.Join(dir, "missing.pcap"), "")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// a truncated record fails the read, and the index
+	f, err := OpenPcapFile(write("truncated.pcap", valid[:len(valid)-4]), "")
+	require.NoError(t, err)
+
+	defer f.Close() //nolint:errcheck // only read
+
+	_, _, err = f.NextFrame()
+	require.NoError(t, err)
+
+	_, _, err = f.NextFrame()
+	require.ErrorIs(t, err, ErrMalformedPcap)
+
+	_, err = f.Records()
+	require.ErrorIs(t, err, ErrMalformedPcap)
+}
+
+func TestTsUnit(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[byte]struct {
+		unit uint64
+		ok   bool
+	}{
+		6:    {unit: 1000000, ok: true},
+		9:    {unit: 1000000000, ok: true},
+		19:   {unit: 10000000000000000000, ok: true},
+		20:   {},
+		0x8a: {unit: 1024, ok: true},
+		0xbf: {unit: 1 << 63, ok: true},
+		0xc0: {},
+	}
+
+	for resol, tc := range testcases {
+		unit, ok := tsUnit(resol)
+		assert.Equal(t, tc.ok, ok, "%#02x", resol)
+
+		if tc.ok {
+			assert.Equal(t, tc.unit, unit, "%#02x", resol)
+		}
+	}
+
+	intf := ngInterface{tsUnit: 1024, tsOffset: 10}
+	assert.Equal(t, time.Unix(12, 500000000), intf.time(2*1024+512))
+}
+
+// BenchmarkPcapFile reads a synthetic capture file of BENCH_PCAP_BYTES, 2 GiB
+// by default, mapped, streamed and with a PcapReader:
+// BENCH_PCAP_BYTES=4294967296 go test -run '^$' -bench PcapFile ./internal/capture
+func BenchmarkPcapFile(b *testing.B) {
+	size := int64(2 << 30)
+
+	if env := os.Getenv("BENCH_PCAP_BYTES"); env != "" {
+		n, err := strconv.ParseInt(env, 10, 64)
+		require.NoError(b, err)
+
+		size = n
+	}
+
+	path := filepath.Join(b.TempDir(), "bench.pcap")
+
+	file, err := os.Create(path)
+	require.NoError(b, err)
+
+	out := bufio.NewWriterSize(file, 1<<20)
+
+	w, err := NewPcapWriter(out, 0)
+	require.NoError(b, err)
+
+	frame := append(testFrame(""), make([]byte, 1500)...)
+
+	for written := int64(pcapHeaderLen); written < size; written += int64(pcapRecordHeaderLen + len(frame)) {
+		md := Metadata{Timestamp: pcapFileEpoch.Add(time.Duration(written)), CaptureLength: len(frame)}
+		require.NoError(b, w.WriteFrame(frame, md))
+	}
+
+	require.NoError(b, out.Flush())
+	require.NoError(b, file.Close())
+
+	st, err := os.Stat(path)
+	require.NoError(b, err)
+
+	read := func(b *testing.B, open func() (FrameReader, error)) {
+		b.Helper()
+		b.SetBytes(st.Size())
+		b.ReportAllocs()
+
+		buf := make([]byte, 65535)
+
+		for range b.N {
+			r, err := open()
+			require.NoError(b, err)
+
+			for err == nil {
+				_, err = r.ReadFrame(buf)
+			}
+
+			require.ErrorIs(b, err, io.EOF)
+			require.NoError(b, r.Close())
+		}
+	}
+
+	for name, options := range map[string][]PcapFileOption{
+		"mapped":   nil,
+		"streamed": {WithPcapStreaming()},
+	} {
+		b.Run(name, func(b *testing.B) {
+			read(b, func() (FrameReader, error) { return OpenPcapFile(path, "", options...) })
+		})
+
+		b.Run(name+" indexed", func(b *testing.B) {
+			read(b, func() (FrameReader, error) {
+				f, err := OpenPcapFile(path, "", options...)
+				if err != nil {
+					return nil, err
+				}
+
+				return f, f.SetTimeRange(pcapFileEpoch.Add(time.Duration(size/2)), time.Time{})
+			})
+		})
+	}
+
+	b.Run("reader", func(b *testing.B) {
+		read(b, func() (FrameReader, error) {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+
+			return NewPcapReader(f, "")
+		})
+	})
+}
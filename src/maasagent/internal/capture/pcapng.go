@@ -0,0 +1,131 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// FileFormat is the format of a capture file
+type FileFormat string
+
+const (
+	// FormatPcap is the libpcap format, without room for metadata
+	FormatPcap FileFormat = "pcap"
+	// FormatPcapNg is the pcapng format, embedding the manifest
+	FormatPcapNg FileFormat = "pcapng"
+)
+
+// PcapNgWriter writes frames to a pcapng file embedding the Manifest of
+// the capture: the section header tells the version of the agent and holds
+// the manifest as its comment, the interface description names the
+// interface, its filter and how it captured, so that Wireshark shows them
+// in the properties of the file. Finish closes the file with the
+// statistics of the capture.
+type PcapNgWriter struct {
+	w         *pcapgo.NgWriter
+	redaction *Redaction
+	buf       []byte
+	start     ManifestStats
+	snaplen   uint32
+}
+
+// NewPcapNgWriter writes the section header and the interface description
+// of m to w, frames are redacted as the Redaction of m tells and cut to
+// the snaplen of m, a snaplen of 0 keeping whole frames
+func NewPcapNgWriter(w io.Writer, m Manifest) (*PcapNgWriter, error) {
+	if m.Redaction != nil {
+		if err := m.Redaction.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	comment, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// the length of a pcapng option is 16 bits
+	if len(comment) > math.MaxUint16 {
+		return nil, fmt.Errorf("manifest of %d bytes is too large for pcapng", len(comment))
+	}
+
+	intf := pcapgo.NgInterface{
+		Name:                m.Interface,
+		Description:         m.describe(),
+		Filter:              filterString(m.Config.Filter),
+		OS:                  runtime.GOOS,
+		LinkType:            layers.LinkTypeEthernet,
+		SnapLength:          m.Snaplen,
+		TimestampResolution: 9,
+	}
+
+	pw, err := pcapgo.NewNgWriterInterface(w, intf, pcapgo.NgWriterOptions{
+		SectionInfo: pcapgo.NgSectionInfo{
+			Hardware:    runtime.GOARCH,
+			OS:          runtime.GOOS,
+			Application: "maas-agent " + m.Agent,
+			Comment:     string(comment),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed writing pcapng header: %w", err)
+	}
+
+	snaplen := m.Snaplen
+	if snaplen == 0 {
+		snaplen = defaultSnaplen
+	}
+
+	return &PcapNgWriter{w: pw, redaction: m.Redaction, start: m.Start, snaplen: snaplen}, nil
+}
+
+// WriteFrame records a frame as PcapWriter.WriteFrame does
+func (w *PcapNgWriter) WriteFrame(frame []byte, md Metadata) error {
+	if md.Timestamp.IsZero() {
+		return ErrNoTimestamp
+	}
+
+	frame, ci := record(&w.buf, frame, md, w.redaction, w.snaplen)
+
+	return w.w.WritePacket(ci, frame)
+}
+
+// Finish writes the statistics of the capture from its start until end,
+// unless end is zero, and flushes the file. w isn't closed.
+func (w *PcapNgWriter) Finish(end ManifestStats) error {
+	if !end.Time.IsZero() {
+		err := w.w.WriteInterfaceStats(0, pcapgo.NgInterfaceStatistics{
+			LastUpdate:      end.Time,
+			StartTime:       w.start.Time,
+			EndTime:         end.Time,
+			PacketsReceived: end.Packets - w.start.Packets,
+			PacketsDropped:  end.Drops - w.start.Drops,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.w.Flush()
+}
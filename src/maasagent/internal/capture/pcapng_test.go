@@ -0,0 +1,115 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPcapNgRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Unix(1700000000, 123456789)
+	m := testManifest(t)
+
+	var file bytes.Buffer
+
+	w, err := NewPcapNgWriter(&file, m)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteFrame(testFrame("pcapng"), Metadata{Timestamp: ts, CaptureLength: 20, Length: 20,
+		VLAN: VLANInfo{TCI: 0x0064, TPID: 0x8100, Valid: true}}))
+	assert.ErrorIs(t, w.WriteFrame(testFrame(""), Metadata{CaptureLength: 14, Length: 14}), ErrNoTimestamp)
+	require.NoError(t, w.Finish(m.End))
+
+	// Wireshark shows the options of the section and of the interface
+	ng, err := pcapgo.NewNgReader(bytes.NewReader(file.Bytes()), pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+	assert.Equal(t, "maas-agent "+m.Agent, ng.SectionInfo().Application)
+
+	intf, err := ng.Interface(0)
+	require.NoError(t, err)
+	assert.Equal(t, "eth0", intf.Name)
+	assert.Equal(t, "membership promiscuous, clock software, agent "+m.Agent, intf.Description)
+	assert.True(t, strings.HasPrefix(intf.Filter, "{ 0x28, 0, 0, 0x0000000c },\n"), intf.Filter)
+
+	// the frames are replayed as those of a pcap file, on the interface of
+	// the file
+	r, err := NewPcapReader(&file, "")
+	require.NoError(t, err)
+
+	buf := make([]byte, 1500)
+
+	md, err := r.ReadFrameMetadata(buf)
+	require.NoError(t, err)
+	assert.Equal(t, append(append(testFrame("")[:12:12], 0x81, 0x00, 0x00, 0x64), testFrame("pcapng")[12:]...),
+		buf[:md.CaptureLength])
+	assert.True(t, ts.Equal(md.Timestamp), md.Timestamp)
+	assert.Equal(t, "eth0", md.Interface)
+
+	_, err = r.ReadFrame(buf)
+	assert.ErrorIs(t, err, io.EOF)
+
+	embedded, ok, err := r.Manifest()
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, m, embedded)
+}
+
+func TestPcapNgStatistics(t *testing.T) {
+	t.Parallel()
+
+	// a file written as frames are captured only knows the end of the
+	// capture from its last block
+	m := testManifest(t)
+	end := m.End
+	m.End = ManifestStats{}
+
+	path := filepath.Join(t.TempDir(), "eth0.pcapng")
+
+	f, err := os.Create(path) //nolint:gosec // the path is in the test directory
+	require.NoError(t, err)
+
+	w, err := NewPcapNgWriter(f, m)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteFrame(testFrame("a"), Metadata{Timestamp: end.Time, CaptureLength: 15, Length: 15}))
+	require.NoError(t, w.Finish(end))
+	require.NoError(t, f.Close())
+
+	read, err := ReadManifest(path)
+	require.NoError(t, err)
+	assert.True(t, end.Time.Equal(read.End.Time))
+	assert.Equal(t, Stats{Packets: 110, Drops: 2}, read.End.Stats)
+}
+
+func TestPcapNgWriterManifestSize(t *testing.T) {
+	t.Parallel()
+
+	m := NewManifest("eth0")
+	m.Preflight = []byte(`"` + strings.Repeat("a", 1<<16) + `"`)
+
+	_, err := NewPcapNgWriter(io.Discard, m)
+	assert.Error(t, err)
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"cmp"
+	"slices"
+
+	"maas.io/core/src/maasagent/internal/ptp"
+)
+
+// maxPTPGrandmasters bounds the grandmasters tracked per domain, a domain
+// normally has a single one and a handful during a failover
+const maxPTPGrandmasters = 16
+
+// PTPDomain summarises the PTP traffic of a domain
+type PTPDomain struct {
+	// Messages counts the messages by type, such as Sync or Announce
+	Messages map[string]uint64 `json:"messages"`
+	// Grandmasters are the grandmasters advertised in Announce messages,
+	// the one the best master clock algorithm prefers first
+	Grandmasters []PTPGrandmaster `json:"grandmasters,omitempty"`
+	Domain       uint8            `json:"domain"`
+}
+
+// PTPGrandmaster is a grandmaster clock advertised in Announce messages
+type PTPGrandmaster struct {
+	Identity      string `json:"identity"`
+	Announces     uint64 `json:"announces"`
+	Variance      uint16 `json:"variance"`
+	StepsRemoved  uint16 `json:"steps_removed"`
+	Priority1     uint8  `json:"priority1"`
+	Priority2     uint8  `json:"priority2"`
+	ClockClass    uint8  `json:"clock_class"`
+	ClockAccuracy uint8  `json:"clock_accuracy"`
+}
+
+type ptpGrandmaster struct {
+	announce  ptp.Announce
+	announces uint64
+}
+
+type ptpDomain struct {
+	messages     map[ptp.MessageType]uint64
+	grandmasters map[ptp.ClockIdentity]*ptpGrandmaster
+}
+
+// ptpTracker accounts the PTP messages seen on an interface
+type ptpTracker struct {
+	domains map[uint8]*ptpDomain
+}
+
+func newPTPTracker() *ptpTracker {
+	return &ptpTracker{domains: make(map[uint8]*ptpDomain)}
+}
+
+func (t *ptpTracker) add(frame []byte) {
+	msg, _, ok := ptp.Decapsulate(frame)
+	if !ok {
+		return
+	}
+
+	var hdr ptp.Header
+
+	// other versions share the ports but not the header layout
+	if err := hdr.UnmarshalBinary(msg); err != nil || hdr.Version != 2 {
+		return
+	}
+
+	d, ok := t.domains[hdr.Domain]
+	if !ok {
+		d = &ptpDomain{
+			messages:     make(map[ptp.MessageType]uint64),
+			grandmasters: make(map[ptp.ClockIdentity]*ptpGrandmaster),
+		}
+		t.domains[hdr.Domain] = d
+	}
+
+	d.messages[hdr.MessageType]++
+
+	if hdr.MessageType != ptp.MessageAnnounce {
+		return
+	}
+
+	var announce ptp.Announce
+
+	if err := announce.UnmarshalBinary(msg); err != nil {
+		return
+	}
+
+	gm, ok := d.grandmasters[announce.GrandmasterIdentity]
+	if !ok {
+		if len(d.grandmasters) >= maxPTPGrandmasters {
+			return
+		}
+
+		gm = &ptpGrandmaster{}
+		d.grandmasters[announce.GrandmasterIdentity] = gm
+	}
+
+	// the latest announce wins, the priorities may be changed at runtime
+	gm.announce = announce
+	gm.announces++
+}
+
+func (t *ptpTracker) summary() []PTPDomain {
+	if len(t.domains) == 0 {
+		return nil
+	}
+
+	domains := make([]PTPDomain, 0, len(t.domains))
+
+	for n, d := range t.domains {
+		domain := PTPDomain{
+			Domain:   n,
+			Messages: make(map[string]uint64, len(d.messages)),
+		}
+
+		for mt, count := range d.messages {
+			domain.Messages[mt.String()] = count
+		}
+
+		for _, gm := range d.grandmasters {
+			domain.Grandmasters = append(domain.Grandmasters, PTPGrandmaster{
+				Identity:      gm.announce.GrandmasterIdentity.String(),
+				Announces:     gm.announces,
+				Variance:      gm.announce.GrandmasterQuality.Variance,
+				StepsRemoved:  gm.announce.StepsRemoved,
+				Priority1:     gm.announce.GrandmasterPriority1,
+				Priority2:     gm.announce.GrandmasterPriority2,
+				ClockClass:    gm.announce.GrandmasterQuality.Class,
+				ClockAccuracy: gm.announce.GrandmasterQuality.Accuracy,
+			})
+		}
+
+		slices.SortFunc(domain.Grandmasters, compareGrandmasters)
+
+		domains = append(domains, domain)
+	}
+
+	slices.SortFunc(domains, func(a, b PTPDomain) int {
+		return cmp.Compare(a.Domain, b.Domain)
+	})
+
+	return domains
+}
+
+func (t *ptpTracker) reset() {
+	clear(t.domains)
+}
+
+// compareGrandmasters orders grandmasters the way the best master clock
+// algorithm of IEEE 1588 compares them, lower values win
+func compareGrandmasters(a, b PTPGrandmaster) int {
+	return cmp.Or(
+		cmp.Compare(a.Priority1, b.Priority1),
+		cmp.Compare(a.ClockClass, b.ClockClass),
+		cmp.Compare(a.ClockAccuracy, b.ClockAccuracy),
+		cmp.Compare(a.Variance, b.Variance),
+		cmp.Compare(a.Priority2, b.Priority2),
+		cmp.Compare(a.Identity, b.Identity),
+	)
+}
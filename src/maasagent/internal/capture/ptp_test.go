@@ -0,0 +1,107 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ptpFrame returns a PTP over ethernet frame, Announce messages advertise
+// the grandmaster 00163e.fffe.0000<gm> with the given priority1
+func ptpFrame(msgType, domain, gm, priority1 byte) []byte {
+	msg := make([]byte, 64)
+	msg[0] = msgType
+	msg[1] = 0x02
+	msg[4] = domain
+	msg[47] = priority1
+	msg[48] = 248
+	msg[49] = 0xfe
+	msg[52] = 128
+	copy(msg[53:61], []byte{0x00, 0x16, 0x3e, 0xff, 0xfe, 0x00, 0x00, gm})
+
+	return append(summaryFrame(gm, 0x88, 0xf7), msg...)
+}
+
+func TestSummarizerPTP(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0")
+
+	s.Add(ptpFrame(0xb, 0, 2, 128), Metadata{})
+	s.Add(ptpFrame(0xb, 0, 1, 128), Metadata{})
+	s.Add(ptpFrame(0xb, 0, 1, 100), Metadata{})
+	s.Add(ptpFrame(0x0, 0, 1, 0), Metadata{})
+	s.Add(ptpFrame(0x8, 0, 1, 0), Metadata{})
+	s.Add(ptpFrame(0x1, 24, 3, 0), Metadata{})
+
+	// PTPv1 and truncated messages are only counted by ethertype
+	v1 := ptpFrame(0x0, 0, 1, 0)
+	v1[15] = 0x01
+	s.Add(v1, Metadata{})
+	s.Add(ptpFrame(0x0, 0, 1, 0)[:40], Metadata{})
+
+	summary := s.Snapshot()
+
+	assert.Equal(t, uint64(8), summary.Ethertypes[0x88f7])
+	require.Len(t, summary.PTP, 2)
+	assert.Equal(t, PTPDomain{
+		Domain:   0,
+		Messages: map[string]uint64{"Announce": 3, "Sync": 1, "Follow_Up": 1},
+		Grandmasters: []PTPGrandmaster{
+			{
+				Identity:      "00163e.fffe.000001",
+				Announces:     2,
+				Priority1:     100,
+				Priority2:     128,
+				ClockClass:    248,
+				ClockAccuracy: 0xfe,
+			},
+			{
+				Identity:      "00163e.fffe.000002",
+				Announces:     1,
+				Priority1:     128,
+				Priority2:     128,
+				ClockClass:    248,
+				ClockAccuracy: 0xfe,
+			},
+		},
+	}, summary.PTP[0])
+	assert.Equal(t, PTPDomain{
+		Domain:   24,
+		Messages: map[string]uint64{"Delay_Req": 1},
+	}, summary.PTP[1])
+
+	assert.Empty(t, s.Snapshot().PTP)
+}
+
+func TestSummarizerBoundsPTPGrandmasters(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0")
+
+	for i := range maxPTPGrandmasters + 4 {
+		s.Add(ptpFrame(0xb, 0, byte(i), 128), Metadata{})
+	}
+
+	summary := s.Snapshot()
+
+	require.Len(t, summary.PTP, 1)
+	assert.Len(t, summary.PTP[0].Grandmasters, maxPTPGrandmasters)
+	assert.Equal(t, uint64(maxPTPGrandmasters+4), summary.PTP[0].Messages["Announce"])
+}
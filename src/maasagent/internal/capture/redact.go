@@ -0,0 +1,343 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	ethernetHeaderLen = 14
+	ipv4HeaderLen     = 20
+	ipv6HeaderLen     = 40
+	udpHeaderLen      = 8
+	tcpHeaderLen      = 20
+	icmpHeaderLen     = 8
+	// maxIPv6Extensions bounds the extension headers walked before the
+	// transport header
+	maxIPv6Extensions = 8
+)
+
+const (
+	protocolICMP   = 1
+	protocolTCP    = 6
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+	// the IPv6 extension headers
+	extensionHopByHop = 0
+	extensionRouting  = 43
+	extensionFragment = 44
+	extensionDestOpts = 60
+)
+
+// ErrInvalidRedaction is returned for a Redaction of an unknown mode or
+// protocol
+var ErrInvalidRedaction = errors.New("invalid redaction")
+
+// RedactedProtocol names a protocol whose payload a Redaction may keep
+type RedactedProtocol string
+
+const (
+	// RedactedDHCP are the DHCPv4 and DHCPv6 messages, with the hostnames
+	// and the options of the clients
+	RedactedDHCP RedactedProtocol = "dhcp"
+	// RedactedDNS are the DNS messages over UDP and TCP, with the names
+	// queried
+	RedactedDNS RedactedProtocol = "dns"
+	// RedactedMDNS are the mDNS messages, with the names and the services
+	// of the hosts
+	RedactedMDNS RedactedProtocol = "mdns"
+	// RedactedNDP are the options of the neighbor discovery messages, such
+	// as the search domains of the router advertisements, the fixed part
+	// of the messages is always kept
+	RedactedNDP RedactedProtocol = "ndp"
+	// RedactedLLDP are the TLVs of LLDP, with the names and descriptions
+	// of the switches
+	RedactedLLDP RedactedProtocol = "lldp"
+)
+
+var redactedProtocols = []RedactedProtocol{RedactedDHCP, RedactedDNS, RedactedMDNS, RedactedNDP, RedactedLLDP}
+
+// RedactionMode is how a Redaction removes the payloads
+type RedactionMode string
+
+const (
+	// RedactTruncate cuts the frames after their last header kept, the
+	// capture files still tell their original length
+	RedactTruncate RedactionMode = "truncate"
+	// RedactZero zeroes the payloads, the frames keep their length
+	RedactZero RedactionMode = "zero"
+)
+
+// Redaction removes the payloads of the frames written to the capture
+// files, so that they only hold what L2 and L3 debugging needs: the
+// Ethernet and VLAN headers, ARP, the IPv4 and IPv6 headers and the UDP,
+// TCP and ICMP ones. Everything after the highest header parsed is
+// payload, the payloads of the protocols of Keep excepted. A frame which
+// can't be parsed further loses what follows its last valid header.
+//
+// It applies as the frames are written, the frames in memory, such as
+// those of a PcapRing, are whole.
+type Redaction struct {
+	// Mode is RedactTruncate unless set
+	Mode RedactionMode      `json:"mode,omitempty"`
+	Keep []RedactedProtocol `json:"keep,omitempty"`
+}
+
+// Validate returns an error matching ErrInvalidRedaction for an unknown
+// mode or protocol
+func (r Redaction) Validate() error {
+	if r.Mode != "" && r.Mode != RedactTruncate && r.Mode != RedactZero {
+		return fmt.Errorf("%w: mode %q", ErrInvalidRedaction, r.Mode)
+	}
+
+	for _, p := range r.Keep {
+		if !slices.Contains(redactedProtocols, p) {
+			return fmt.Errorf("%w: protocol %q", ErrInvalidRedaction, p)
+		}
+	}
+
+	return nil
+}
+
+// Redact returns frame without its payloads, appended to buf, frame isn't
+// modified
+func (r Redaction) Redact(buf, frame []byte) []byte {
+	buf = append(buf[:0], frame...)
+
+	keep := r.kept(buf)
+	if r.Mode == RedactZero {
+		clear(buf[keep:])
+		return buf
+	}
+
+	return buf[:keep]
+}
+
+// keeps returns true if the payload of p is kept
+func (r Redaction) keeps(p RedactedProtocol) bool {
+	return slices.Contains(r.Keep, p)
+}
+
+// kept returns the number of bytes of frame the headers, and the payloads
+// kept, span
+func (r Redaction) kept(frame []byte) int {
+	if len(frame) < ethernetHeaderLen {
+		return len(frame)
+	}
+
+	off := macHeaderLen
+	ethertype := ethernet.EthernetType(binary.BigEndian.Uint16(frame[off:]))
+
+	for isTag(ethertype) && len(frame) >= off+vlanTagLen+2 {
+		off += vlanTagLen
+		ethertype = ethernet.EthernetType(binary.BigEndian.Uint16(frame[off:]))
+	}
+
+	off += 2
+
+	switch {
+	case ethertype < ethernet.EthernetTypeIPv4:
+		// an 802.3 length, the LLC header and its SNAP extension
+		return min(len(frame), off+llcHeaderLen(frame[off:]))
+	case ethertype == ethernet.EthernetTypeARP:
+		return min(len(frame), off+arpLen(frame[off:]))
+	case ethertype == ethernet.EthernetTypeIPv4:
+		return r.ipv4(frame, off)
+	case ethertype == ethernet.EthernetTypeIPv6:
+		return r.ipv6(frame, off)
+	case ethertype == ethernet.EthernetTypeLLDP && r.keeps(RedactedLLDP):
+		return len(frame)
+	default:
+		return min(len(frame), off)
+	}
+}
+
+func isTag(t ethernet.EthernetType) bool {
+	return t == ethernet.EthernetTypeVLAN || t == ethernet.EthernetTypeQinQ || t == ethernet.EthernetTypeQinQLegacy
+}
+
+// llcHeaderLen returns the length of the LLC header of b, with its SNAP
+// extension, CDP and STP have theirs
+func llcHeaderLen(b []byte) int {
+	if len(b) >= 8 && b[0] == 0xaa && b[1] == 0xaa && b[2] == 0x03 {
+		return 8
+	}
+
+	return 3
+}
+
+// arpLen returns the length of the ARP packet b, its header and addresses
+func arpLen(b []byte) int {
+	if len(b) < 6 {
+		return len(b)
+	}
+
+	return 8 + 2*int(b[4]) + 2*int(b[5])
+}
+
+// ipv4 returns the bytes kept of the IPv4 packet at off
+func (r Redaction) ipv4(frame []byte, off int) int {
+	if len(frame) < off+ipv4HeaderLen || frame[off]>>4 != 4 {
+		return off
+	}
+
+	ihl := int(frame[off]&0x0f) * 4
+	if ihl < ipv4HeaderLen || len(frame) < off+ihl {
+		return off
+	}
+
+	// the fragments after the first don't start with a transport header
+	if binary.BigEndian.Uint16(frame[off+6:])&0x1fff != 0 {
+		return off + ihl
+	}
+
+	return r.transport(frame, off+ihl, frame[off+9])
+}
+
+// ipv6 returns the bytes kept of the IPv6 packet at off, its extension
+// headers are kept
+func (r Redaction) ipv6(frame []byte, off int) int {
+	if len(frame) < off+ipv6HeaderLen || frame[off]>>4 != 6 {
+		return off
+	}
+
+	next := frame[off+6]
+	off += ipv6HeaderLen
+
+	for range maxIPv6Extensions {
+		var n int
+
+		switch next {
+		case extensionHopByHop, extensionRouting, extensionDestOpts:
+			if len(frame) < off+2 {
+				return off
+			}
+
+			n = (int(frame[off+1]) + 1) * 8
+		case extensionFragment:
+			if len(frame) < off+8 {
+				return off
+			}
+
+			n = 8
+
+			if binary.BigEndian.Uint16(frame[off+2:])&0xfff8 != 0 {
+				return off + n
+			}
+		default:
+			return r.transport(frame, off, next)
+		}
+
+		if len(frame) < off+n {
+			return off
+		}
+
+		next = frame[off]
+		off += n
+	}
+
+	return off
+}
+
+// transport returns the bytes kept of the transport header of proto at
+// off, and of its payload
+func (r Redaction) transport(frame []byte, off int, proto byte) int {
+	switch proto {
+	case protocolUDP:
+		if len(frame) < off+udpHeaderLen {
+			return off
+		}
+
+		src, dst := binary.BigEndian.Uint16(frame[off:]), binary.BigEndian.Uint16(frame[off+2:])
+		if r.keeps(udpProtocol(src, dst)) {
+			return len(frame)
+		}
+
+		return off + udpHeaderLen
+	case protocolTCP:
+		if len(frame) < off+tcpHeaderLen {
+			return off
+		}
+
+		n := int(frame[off+12]>>4) * 4
+		if n < tcpHeaderLen || len(frame) < off+n {
+			return off
+		}
+
+		src, dst := binary.BigEndian.Uint16(frame[off:]), binary.BigEndian.Uint16(frame[off+2:])
+		if (src == 53 || dst == 53) && r.keeps(RedactedDNS) {
+			return len(frame)
+		}
+
+		return off + n
+	case protocolICMP:
+		return min(len(frame), off+icmpHeaderLen)
+	case protocolICMPv6:
+		if len(frame) < off+icmpHeaderLen {
+			return off
+		}
+
+		n, ndp := ndpMessageLen(frame[off])
+		if ndp && r.keeps(RedactedNDP) {
+			return len(frame)
+		}
+
+		return min(len(frame), off+n)
+	default:
+		return off
+	}
+}
+
+// udpProtocol returns the protocol of the UDP ports, empty for those whose
+// payload is never kept
+func udpProtocol(src, dst uint16) RedactedProtocol {
+	for _, port := range []uint16{src, dst} {
+		switch port {
+		case 67, 68, 546, 547:
+			return RedactedDHCP
+		case 53:
+			return RedactedDNS
+		case 5353:
+			return RedactedMDNS
+		}
+	}
+
+	return ""
+}
+
+// ndpMessageLen returns the length of the fixed part of the ICMPv6 message
+// of type t, which is a neighbor discovery message if ndp, before its
+// options
+func ndpMessageLen(t byte) (int, bool) {
+	switch t {
+	case 133: // router solicitation
+		return 8, true
+	case 134: // router advertisement
+		return 16, true
+	case 135, 136: // neighbor solicitation and advertisement
+		return 24, true
+	case 137: // redirect
+		return 40, true
+	default:
+		return icmpHeaderLen, false
+	}
+}
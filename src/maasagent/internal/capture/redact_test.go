@@ -0,0 +1,287 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hexFrame returns the bytes of s, in hex with spaces
+func hexFrame(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	require.NoError(t, err)
+
+	return b
+}
+
+const redactMACs = "ffffffffffff 00163e000001 "
+
+func TestRedaction(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		frame string
+		keep  []RedactedProtocol
+		// kept is the number of bytes of frame kept
+		kept int
+	}{
+		"arp without its padding": {
+			frame: redactMACs + "0806 0001 0800 06 04 0001 00163e000001 0a000001 000000000000 0a000002" +
+				"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			kept: 42,
+		},
+		"tagged dns": {
+			frame: redactMACs + "8100 0064 0800 4500 0024 0000 4000 4011 0000 0a000001 0a000002" +
+				"c000 0035 0010 0000 71756572 79000000",
+			kept: 46,
+		},
+		"tagged dns kept": {
+			frame: redactMACs + "8100 0064 0800 4500 0024 0000 4000 4011 0000 0a000001 0a000002" +
+				"c000 0035 0010 0000 71756572 79000000",
+			keep: []RedactedProtocol{RedactedDNS},
+			kept: 54,
+		},
+		"double tagged dhcp": {
+			frame: redactMACs + "88a8 0001 8100 0064 0800 4500 0024 0000 0000 4011 0000 00000000 ffffffff" +
+				"0044 0043 0010 0000 01010600 12345678",
+			keep: []RedactedProtocol{RedactedDNS},
+			kept: 50,
+		},
+		"dhcp kept": {
+			frame: redactMACs + "0800 4500 0024 0000 0000 4011 0000 00000000 ffffffff" +
+				"0044 0043 0010 0000 01010600 12345678",
+			keep: []RedactedProtocol{RedactedDHCP},
+			kept: 50,
+		},
+		"ipv4 options": {
+			frame: redactMACs + "0800 4600 0028 0000 0000 0111 0000 0a000001 e0000016 94040000" +
+				"1000 1000 0010 0000 22000000 00000000",
+			kept: 46,
+		},
+		"ipv4 fragment": {
+			frame: redactMACs + "0800 4500 0024 0000 00b9 4011 0000 0a000001 0a000002" +
+				"0044 0043 0010 0000 01010600 12345678",
+			keep: []RedactedProtocol{RedactedDHCP},
+			kept: 34,
+		},
+		"tcp with options": {
+			frame: redactMACs + "0800 4500 0034 0000 4000 4006 0000 0a000001 0a000002" +
+				"c000 0016 00000001 00000000 6018 ffff 0000 0000 020405b4 5353482d",
+			keep: []RedactedProtocol{RedactedDNS},
+			kept: 58,
+		},
+		"icmp echo": {
+			frame: redactMACs + "0800 4500 0020 0000 4000 4001 0000 0a000001 0a000002" +
+				"0800 0000 0001 0001 70696e67",
+			kept: 42,
+		},
+		"neighbor solicitation": {
+			frame: redactMACs + "86dd 6000 0000 0020 3aff fe800000000000000000000000000001 ff020000000000000000000000000002" +
+				"8700 0000 00000000 fe800000000000000000000000000002 0101 00163e000001",
+			kept: 78,
+		},
+		"neighbor solicitation kept": {
+			frame: redactMACs + "86dd 6000 0000 0020 3aff fe800000000000000000000000000001 ff020000000000000000000000000002" +
+				"8700 0000 00000000 fe800000000000000000000000000002 0101 00163e000001",
+			keep: []RedactedProtocol{RedactedNDP},
+			kept: 86,
+		},
+		"mdns after hop-by-hop": {
+			frame: redactMACs + "86dd 6000 0000 0018 0001 fe800000000000000000000000000001 ff0200000000000000000000000000fb" +
+				"1100 0000 0000 0000 14e9 14e9 0010 0000 00000000 00000000",
+			kept: 70,
+		},
+		"mdns kept after hop-by-hop": {
+			frame: redactMACs + "86dd 6000 0000 0018 0001 fe800000000000000000000000000001 ff0200000000000000000000000000fb" +
+				"1100 0000 0000 0000 14e9 14e9 0010 0000 00000000 00000000",
+			keep: []RedactedProtocol{RedactedMDNS},
+			kept: 78,
+		},
+		"ipv6 fragment": {
+			frame: redactMACs + "86dd 6000 0000 0018 2c40 fe800000000000000000000000000001 fe800000000000000000000000000002" +
+				"1100 0009 00000001 14e9 14e9 0010 0000",
+			keep: []RedactedProtocol{RedactedMDNS},
+			kept: 62,
+		},
+		"lldp": {
+			frame: redactMACs + "88cc 0207 04 00163e000001 0403 05 657430 0000",
+			kept:  14,
+		},
+		"lldp kept": {
+			frame: redactMACs + "88cc 0207 04 00163e000001 0403 05 657430 0000",
+			keep:  []RedactedProtocol{RedactedLLDP},
+			kept:  31,
+		},
+		"snap": {
+			frame: redactMACs + "0016 aaaa 03 00000c 2000 02b40001 00000000 00000000",
+			kept:  22,
+		},
+		"unknown ethertype": {
+			frame: redactMACs + "88b5 7061796c6f6164",
+			kept:  14,
+		},
+		"truncated ipv4 header": {
+			frame: redactMACs + "0800 4f00 0024 0000 4000 4011 0000 0a000001 0a000002",
+			kept:  14,
+		},
+		"truncated udp header": {
+			frame: redactMACs + "0800 4500 0024 0000 4000 4011 0000 0a000001 0a000002 c000 0035",
+			kept:  34,
+		},
+		"runt": {
+			frame: "ffffffffffff 00163e00",
+			kept:  10,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			frame := hexFrame(t, tc.frame)
+			whole := bytes.Clone(frame)
+
+			r := Redaction{Keep: tc.keep}
+			require.NoError(t, r.Validate())
+			assert.Equal(t, frame[:tc.kept], r.Redact(nil, frame))
+
+			r.Mode = RedactZero
+			assert.Equal(t, append(bytes.Clone(frame[:tc.kept]), make([]byte, len(frame)-tc.kept)...),
+				r.Redact(nil, frame))
+
+			// frame is left as it was
+			assert.Equal(t, whole, frame)
+		})
+	}
+}
+
+func TestRedactionValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, Redaction{}.Validate())
+	assert.NoError(t, Redaction{Mode: RedactZero, Keep: []RedactedProtocol{RedactedDHCP, RedactedLLDP}}.Validate())
+	assert.ErrorIs(t, Redaction{Mode: "scramble"}.Validate(), ErrInvalidRedaction)
+	assert.ErrorIs(t, Redaction{Keep: []RedactedProtocol{"smb"}}.Validate(), ErrInvalidRedaction)
+}
+
+func TestPcapWriterRedaction(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Unix(1700000000, 0)
+	frame := hexFrame(t, redactMACs+"0800 4500 0024 0000 4000 4011 0000 0a000001 0a000002"+
+		"c000 0035 0010 0000 71756572 79000000")
+	md := Metadata{Timestamp: ts, CaptureLength: len(frame), Length: len(frame),
+		VLAN: VLANInfo{TCI: 0x0064, TPID: 0x8100, Valid: true}}
+
+	// the tag stripped by the NIC is put back before the redaction, which
+	// the snaplen then cuts
+	tagged := hexFrame(t, redactMACs+"8100 0064 0800 4500 0024 0000 4000 4011 0000 0a000001 0a000002"+
+		"c000 0035 0010 0000 00000000 00000000")
+
+	testcases := map[string]struct {
+		redaction Redaction
+		snaplen   uint32
+		out       []byte
+	}{
+		"truncate": {out: tagged[:46]},
+		"zero":     {redaction: Redaction{Mode: RedactZero}, out: tagged},
+		"snaplen":  {redaction: Redaction{Mode: RedactZero}, snaplen: 20, out: tagged[:20]},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var file bytes.Buffer
+
+			w, err := NewPcapWriter(&file, tc.snaplen, WithRedaction(tc.redaction))
+			require.NoError(t, err)
+			require.NoError(t, w.WriteFrame(frame, md))
+
+			r, err := NewPcapReader(&file, "eth0")
+			require.NoError(t, err)
+
+			buf := make([]byte, 1500)
+
+			got, err := r.ReadFrameMetadata(buf)
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, buf[:got.CaptureLength])
+			assert.Equal(t, len(tagged), got.Length)
+		})
+	}
+
+	_, err := NewPcapWriter(&bytes.Buffer{}, 0, WithRedaction(Redaction{Mode: "scramble"}))
+	assert.ErrorIs(t, err, ErrInvalidRedaction)
+}
+
+func TestPcapNgWriterRedaction(t *testing.T) {
+	t.Parallel()
+
+	m := testManifest(t)
+	m.Redaction = &Redaction{Keep: []RedactedProtocol{RedactedDNS}}
+
+	frames := [][]byte{
+		hexFrame(t, redactMACs+"0800 4500 0024 0000 4000 4011 0000 0a000001 0a000002"+
+			"c000 0035 0010 0000 71756572 79000000"),
+		hexFrame(t, redactMACs+"0800 4500 0024 0000 0000 4011 0000 00000000 ffffffff"+
+			"0044 0043 0010 0000 01010600 12345678"),
+	}
+
+	var file bytes.Buffer
+
+	w, err := NewPcapNgWriter(&file, m)
+	require.NoError(t, err)
+
+	for _, frame := range frames {
+		require.NoError(t, w.WriteFrame(frame, Metadata{Timestamp: m.End.Time, Length: len(frame)}))
+	}
+
+	require.NoError(t, w.Finish(ManifestStats{}))
+
+	r, err := NewPcapReader(&file, "")
+	require.NoError(t, err)
+
+	read, ok, err := r.Manifest()
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, m.Redaction, read.Redaction)
+
+	buf := make([]byte, 1500)
+
+	// the DNS payload is kept, not the DHCP one
+	for _, out := range [][]byte{frames[0], frames[1][:42]} {
+		n, err := r.ReadFrame(buf)
+		require.NoError(t, err)
+		assert.Equal(t, out, buf[:n])
+	}
+
+	m.Redaction = &Redaction{Mode: "scramble"}
+	_, err = NewPcapNgWriter(&bytes.Buffer{}, m)
+	assert.ErrorIs(t, err, ErrInvalidRedaction)
+}
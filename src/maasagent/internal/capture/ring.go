@@ -0,0 +1,258 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/atomicfile"
+)
+
+const pcapFileMode = 0o600
+
+// pcapRingEntry is a frame kept by a PcapRing
+type pcapRingEntry struct {
+	frame []byte
+	md    Metadata
+}
+
+// PcapRing keeps the latest frames in memory until they are downloaded as a
+// pcap file, it is meant to be left running and dumped on demand
+type PcapRing struct {
+	source    ManifestSource
+	redaction *Redaction
+	entries   []pcapRingEntry
+	next      int
+	size      int
+	mu        sync.Mutex
+	full      bool
+}
+
+// NewPcapRing returns a ring of the given number of frames
+func NewPcapRing(size int) *PcapRing {
+	return &PcapRing{
+		entries: make([]pcapRingEntry, max(size, 1)),
+		size:    max(size, 1),
+	}
+}
+
+// Add copies the frame into the ring, replacing the oldest one when it is
+// full. The caller keeps ownership of frame and may reuse it on return.
+func (r *PcapRing) Add(frame []byte, md Metadata) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := &r.entries[r.next]
+	e.frame = append(e.frame[:0], frame...)
+	e.md = md
+
+	r.next = (r.next + 1) % r.size
+	r.full = r.full || r.next == 0
+}
+
+// Len returns the number of frames in the ring
+func (r *PcapRing) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.full {
+		return r.size
+	}
+
+	return r.next
+}
+
+// SetManifestSource sets the capture the frames of the ring come from,
+// whose Manifest is the one of the dumps. A TargetedReader sets its Conn.
+func (r *PcapRing) SetManifestSource(src ManifestSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.source = src
+}
+
+// SetRedaction removes the payloads of the frames of the dumps as red
+// tells, whatever the manifest of the dump, nil leaving the frames whole.
+// The frames of the ring are kept whole.
+func (r *PcapRing) SetRedaction(red *Redaction) error {
+	if red != nil {
+		if err := red.Validate(); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.redaction = red
+
+	return nil
+}
+
+// Manifest returns the Manifest of the capture the frames come from, as of
+// now, with the redaction of the ring. Without a source, or once it is
+// closed, only the version of the agent is known.
+func (r *PcapRing) Manifest() Manifest {
+	r.mu.Lock()
+	src := r.source
+	r.mu.Unlock()
+
+	m := NewManifest("")
+
+	if src != nil {
+		var err error
+
+		m, err = src.Manifest()
+		if err != nil {
+			log.Debug().Err(err).Msg("Manifest of the frame ring source unavailable")
+
+			m = NewManifest("")
+		}
+	}
+
+	return r.redact(m)
+}
+
+// redact returns m with the redaction of the ring, which prevails over
+// the one of m
+func (r *PcapRing) redact(m Manifest) Manifest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.redaction != nil {
+		m.Redaction = r.redaction
+	}
+
+	return m
+}
+
+// snapshot returns a copy of the frames of the ring, the oldest first
+func (r *PcapRing) snapshot() []pcapRingEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var entries []pcapRingEntry
+
+	if r.full {
+		entries = append(entries, r.entries[r.next:]...)
+	}
+
+	entries = append(entries, r.entries[:r.next]...)
+
+	// the frames are copied so a slow writer doesn't hold up Add
+	for i := range entries {
+		entries[i].frame = slices.Clone(entries[i].frame)
+	}
+
+	return entries
+}
+
+// WritePcap writes the frames of the ring to w as a pcap file, the oldest
+// first, redacted as the ring is. The ring keeps its frames.
+func (r *PcapRing) WritePcap(w io.Writer) error {
+	_, err := r.writePcap(w, r.redact(Manifest{}).Redaction)
+
+	return err
+}
+
+func (r *PcapRing) writePcap(w io.Writer, redaction *Redaction) (int, error) {
+	var options []PcapWriterOption
+
+	if redaction != nil {
+		options = append(options, WithRedaction(*redaction))
+	}
+
+	pw, err := NewPcapWriter(w, 0, options...)
+	if err != nil {
+		return 0, err
+	}
+
+	entries := r.snapshot()
+
+	for _, e := range entries {
+		if err := pw.WriteFrame(e.frame, e.md); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(entries), nil
+}
+
+// WritePcapNg writes the frames of the ring to w as a pcapng file
+// embedding m, the oldest first, redacted as the ring is or else as m
+// tells. The ring keeps its frames.
+func (r *PcapRing) WritePcapNg(w io.Writer, m Manifest) error {
+	_, err := r.writePcapNg(w, r.redact(m))
+
+	return err
+}
+
+func (r *PcapRing) writePcapNg(w io.Writer, m Manifest) (int, error) {
+	pw, err := NewPcapNgWriter(w, m)
+	if err != nil {
+		return 0, err
+	}
+
+	entries := r.snapshot()
+
+	for _, e := range entries {
+		if err := pw.WriteFrame(e.frame, e.md); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(entries), pw.Finish(m.End)
+}
+
+// Dump writes the frames of the ring to a capture file at path in format,
+// and m as its sidecar manifest, see WriteManifest. The frames are redacted
+// as the ring is or else as m tells, and the manifest records it. The
+// files are replaced atomically, the ring keeps its frames. It returns the
+// number of frames written.
+func (r *PcapRing) Dump(path string, format FileFormat, m Manifest) (int, error) {
+	var (
+		buf    bytes.Buffer
+		frames int
+		err    error
+	)
+
+	m = r.redact(m)
+
+	switch format {
+	case FormatPcap, "":
+		frames, err = r.writePcap(&buf, m.Redaction)
+	case FormatPcapNg:
+		frames, err = r.writePcapNg(&buf, m)
+	default:
+		return 0, fmt.Errorf("%w: capture file format %q", ErrUnsupported, format)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := atomicfile.WriteFile(path, buf.Bytes(), pcapFileMode); err != nil {
+		return 0, err
+	}
+
+	return frames, WriteManifest(path, m)
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+// readPcap returns the payloads of the frames of a pcap file
+func readPcap(t *testing.T, file io.Reader) []string {
+	t.Helper()
+
+	r, err := NewPcapReader(file, "eth0")
+	require.NoError(t, err)
+
+	var payloads []string
+
+	buf := make([]byte, 1500)
+
+	for {
+		n, err := r.ReadFrame(buf)
+		if err == io.EOF {
+			return payloads
+		}
+
+		require.NoError(t, err)
+
+		payloads = append(payloads, string(buf[14:n]))
+	}
+}
+
+func TestPcapRing(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Unix(1700000000, 0)
+
+	testcases := map[string]struct {
+		in   []string
+		size int
+		out  []string
+	}{
+		"empty": {
+			size: 2,
+		},
+		"partly filled": {
+			in:   []string{"a"},
+			size: 2,
+			out:  []string{"a"},
+		},
+		"full": {
+			in:   []string{"a", "b"},
+			size: 2,
+			out:  []string{"a", "b"},
+		},
+		"oldest replaced": {
+			in:   []string{"a", "b", "c", "d", "e"},
+			size: 3,
+			out:  []string{"c", "d", "e"},
+		},
+		"at least one frame": {
+			in:  []string{"a", "b"},
+			out: []string{"b"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ring := NewPcapRing(tc.size)
+
+			for i, payload := range tc.in {
+				frame := testFrame(payload)
+				ring.Add(frame, Metadata{Timestamp: ts.Add(time.Duration(i)), CaptureLength: len(frame), Length: len(frame)})
+				// the ring keeps a copy
+				frame[14] = 'x'
+			}
+
+			assert.Equal(t, len(tc.out), ring.Len())
+
+			var file bytes.Buffer
+
+			require.NoError(t, ring.WritePcap(&file))
+			assert.Equal(t, tc.out, readPcap(t, &file))
+
+			// and keeps its frames once written
+			assert.Equal(t, len(tc.out), ring.Len())
+		})
+	}
+}
+
+// TestPcapRingBufferReuse adds frames from a single reused buffer, as a
+// capture loop does, while the ring is written out by another goroutine. It
+// is meant to be run with the race detector.
+func TestPcapRingBufferReuse(t *testing.T) {
+	defer leak.Check(t)()
+
+	ring := NewPcapRing(8)
+	frame := testFrame("aaaa")
+	done := make(chan struct{})
+
+	var (
+		wg   sync.WaitGroup
+		torn []string
+	)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			var file bytes.Buffer
+
+			if err := ring.WritePcap(&file); err != nil {
+				torn = append(torn, err.Error())
+				return
+			}
+
+			// readPcap can't be used, it fails the test outside of its
+			// goroutine
+			r, err := NewPcapReader(&file, "eth0")
+			if err != nil {
+				torn = append(torn, err.Error())
+				return
+			}
+
+			buf := make([]byte, 1500)
+
+			for {
+				n, err := r.ReadFrame(buf)
+				if err != nil {
+					break
+				}
+
+				if payload := string(buf[14:n]); payload != strings.Repeat(payload[:1], len(payload)) {
+					torn = append(torn, payload)
+				}
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		for j := 14; j < len(frame); j++ {
+			frame[j] = 'a' + byte(i%26)
+		}
+
+		ring.Add(frame, Metadata{CaptureLength: len(frame), Length: len(frame)})
+	}
+
+	close(done)
+	wg.Wait()
+
+	assert.Empty(t, torn)
+}
+
+// manifestReader is a FrameReader telling its Manifest, as a Conn does
+type manifestReader struct {
+	pipeReader
+	m Manifest
+}
+
+func (r manifestReader) Manifest() (Manifest, error) {
+	return r.m, nil
+}
+
+func TestPcapRingDump(t *testing.T) {
+	t.Parallel()
+
+	m := testManifest(t)
+	ring := NewPcapRing(4)
+
+	// without a source only the agent is known
+	assert.Equal(t, NewManifest(""), ring.Manifest())
+
+	// a TargetedReader tells the ring where its frames come from
+	NewTargetedReader(manifestReader{m: m}, WithTargetRing(ring))
+	assert.Equal(t, m, ring.Manifest())
+
+	for _, payload := range []string{"a", "b"} {
+		frame := testFrame(payload)
+		ring.Add(frame, Metadata{Timestamp: m.End.Time, CaptureLength: len(frame), Length: len(frame)})
+	}
+
+	dir := t.TempDir()
+
+	for _, format := range []FileFormat{FormatPcap, FormatPcapNg} {
+		path := filepath.Join(dir, "eth0."+string(format))
+
+		frames, err := ring.Dump(path, format, m)
+		require.NoError(t, err)
+		assert.Equal(t, 2, frames)
+
+		f, err := os.Open(path) //nolint:gosec // the path is in the test directory
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, readPcap(t, f))
+		require.NoError(t, f.Close())
+
+		read, err := ReadManifest(path)
+		require.NoError(t, err)
+		assert.Equal(t, m.Interface, read.Interface)
+		assert.True(t, m.End.Time.Equal(read.End.Time))
+	}
+
+	_, err := ring.Dump(filepath.Join(dir, "eth0.cap"), "erf", m)
+	assert.ErrorIs(t, err, ErrUnsupported)
+	assert.NoFileExists(t, filepath.Join(dir, "eth0.cap"))
+}
+
+func TestPcapRingRedaction(t *testing.T) {
+	t.Parallel()
+
+	m := testManifest(t)
+	ring := NewPcapRing(4)
+	NewTargetedReader(manifestReader{m: m}, WithTargetRing(ring))
+
+	frame := testFrame("secret")
+	ring.Add(frame, Metadata{Timestamp: m.End.Time, CaptureLength: len(frame), Length: len(frame)})
+
+	assert.ErrorIs(t, ring.SetRedaction(&Redaction{Keep: []RedactedProtocol{"smb"}}), ErrInvalidRedaction)
+	require.NoError(t, ring.SetRedaction(&Redaction{Mode: RedactZero}))
+	assert.Equal(t, &Redaction{Mode: RedactZero}, ring.Manifest().Redaction)
+
+	var file bytes.Buffer
+
+	require.NoError(t, ring.WritePcap(&file))
+	assert.Equal(t, []string{"\x00\x00\x00\x00\x00\x00"}, readPcap(t, &file))
+
+	dir := t.TempDir()
+
+	// the redaction of the ring prevails over the one of the manifest
+	m.Redaction = &Redaction{Keep: []RedactedProtocol{RedactedLLDP}}
+
+	for _, format := range []FileFormat{FormatPcap, FormatPcapNg} {
+		path := filepath.Join(dir, "eth0."+string(format))
+
+		_, err := ring.Dump(path, format, m)
+		require.NoError(t, err)
+
+		f, err := os.Open(path) //nolint:gosec // the path is in the test directory
+		require.NoError(t, err)
+		assert.Equal(t, []string{"\x00\x00\x00\x00\x00\x00"}, readPcap(t, f))
+		require.NoError(t, f.Close())
+
+		read, err := ReadManifest(path)
+		require.NoError(t, err)
+		assert.Equal(t, &Redaction{Mode: RedactZero}, read.Redaction)
+	}
+
+	// the frames of the ring are whole
+	assert.Equal(t, frame, ring.snapshot()[0].frame)
+
+	// without its own, the ring redacts as the manifest tells
+	require.NoError(t, ring.SetRedaction(nil))
+
+	path := filepath.Join(dir, "eth0.pcap")
+
+	_, err := ring.Dump(path, FormatPcap, Manifest{Version: ManifestVersion, Redaction: &Redaction{}})
+	require.NoError(t, err)
+
+	f, err := os.Open(path) //nolint:gosec // the path is in the test directory
+	require.NoError(t, err)
+
+	defer f.Close() //nolint:errcheck // the file is only read
+
+	assert.Equal(t, []string{""}, readPcap(t, f))
+}
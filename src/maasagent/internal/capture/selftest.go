@@ -0,0 +1,330 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	// selfTestEthertype is the IEEE local experimental ethertype, which
+	// nothing on the link acts upon
+	selfTestEthertype = 0x88b5
+	// defaultSelfTestTimeout is how long to wait for the probe to be captured
+	defaultSelfTestTimeout = time.Second
+	// selfTestTokenLen is the length of the random tag of each probe
+	selfTestTokenLen = 16
+)
+
+// selfTestMagic starts the payload of every probe
+var selfTestMagic = []byte("maas-selftest")
+
+// ErrProbeNotCaptured is returned when the probe wasn't captured in time
+var ErrProbeNotCaptured = errors.New("probe was not captured")
+
+// SelfTestResult reports whether a probe sent on an interface was seen
+// through the capture path
+type SelfTestResult struct {
+	Interface string `json:"interface"`
+	Error     string `json:"error,omitempty"`
+	// Latency is the time from sending the probe to it being captured
+	Latency time.Duration `json:"latency,omitempty"`
+	// VLAN is the ID the probe was tagged with, 0 when it was untagged
+	VLAN     uint16 `json:"vlan,omitempty"`
+	Sent     bool   `json:"sent"`
+	Received bool   `json:"received"`
+	// VLANPreserved is set when the captured probe still had its tag,
+	// either in the frame or stripped by the NIC into the metadata
+	VLANPreserved bool `json:"vlan_preserved,omitempty"`
+	// VLANOffloaded is set when the tag was only found in the metadata
+	VLANOffloaded bool `json:"vlan_offloaded,omitempty"`
+	// Unsolicited are the probes of other self-tests captured meanwhile,
+	// such as those of another agent on the segment
+	Unsolicited int `json:"unsolicited,omitempty"`
+}
+
+// probeConn is the part of a Conn a self-test needs
+type probeConn interface {
+	FrameReader
+	FrameWriter
+	MetadataReader
+	Interface() *net.Interface
+}
+
+type selfTestConfig struct {
+	listen  func(iface string, options ...Option) (probeConn, error)
+	timeout time.Duration
+	vid     uint16
+}
+
+// SelfTestOption configures a self-test
+type SelfTestOption func(*selfTestConfig)
+
+// WithSelfTestTimeout sets how long to wait for the probe to be captured
+func WithSelfTestTimeout(d time.Duration) SelfTestOption {
+	return func(c *selfTestConfig) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// WithSelfTestVLAN tags the probe with the VLAN ID, to check the tag
+// survives the VLAN offload of the NIC
+func WithSelfTestVLAN(vid uint16) SelfTestOption {
+	return func(c *selfTestConfig) {
+		c.vid = vid & 0x0fff
+	}
+}
+
+func listenConn(iface string, options ...Option) (probeConn, error) {
+	return Listen(iface, options...)
+}
+
+// SelfTest sends a uniquely tagged probe from the interface to its own
+// address and waits for it to be captured, the outgoing copy is enough.
+// Setup and send failures are reported in the result rather than returned,
+// so the results of several interfaces can be aggregated.
+func SelfTest(ctx context.Context, iface string, options ...SelfTestOption) SelfTestResult {
+	cfg := selfTestConfig{
+		listen:  listenConn,
+		timeout: defaultSelfTestTimeout,
+	}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	res := SelfTestResult{Interface: iface, VLAN: cfg.vid}
+
+	if err := selfTest(ctx, cfg, &res); err != nil {
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// SelfTestAll runs SelfTest on every interface in parallel, the results are
+// in the order of ifaces
+func SelfTestAll(ctx context.Context, ifaces []string, options ...SelfTestOption) []SelfTestResult {
+	results := make([]SelfTestResult, len(ifaces))
+
+	var wg sync.WaitGroup
+
+	for i, iface := range ifaces {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			results[i] = SelfTest(ctx, iface, options...)
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+func selfTest(ctx context.Context, cfg selfTestConfig, res *SelfTestResult) error {
+	filter, err := selfTestFilter()
+	if err != nil {
+		return err
+	}
+
+	r, err := cfg.listen(res.Interface, WithFilter(filter))
+	if err != nil {
+		return err
+	}
+
+	defer r.Close() //nolint:errcheck // nothing was written
+
+	protocol := uint16(selfTestEthertype)
+	if cfg.vid != 0 {
+		protocol = uint16(ethernet.EthernetTypeVLAN)
+	}
+
+	w, err := cfg.listen(res.Interface, WithProtocol(protocol))
+	if err != nil {
+		return err
+	}
+
+	defer w.Close() //nolint:errcheck // the probe was written or failed
+
+	token := make([]byte, selfTestTokenLen)
+	//nolint:errcheck,gosec // rand.Read() never returns an error
+	rand.Read(token)
+
+	probe := selfTestProbe(w.Interface().HardwareAddr, cfg.vid, token)
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	stop := InterruptReads(ctx, r)
+	defer stop()
+
+	sent := time.Now()
+
+	if err := w.WriteFrame(probe); err != nil {
+		return err
+	}
+
+	res.Sent = true
+
+	buf := make([]byte, len(probe)+4)
+
+	for {
+		md, err := r.ReadFrameMetadata(buf)
+		if err != nil {
+			// reads are interrupted with a deadline, so the context knows why
+			switch {
+			case errors.Is(ctx.Err(), context.Canceled):
+				return ctx.Err()
+			case ctx.Err() != nil, errors.Is(err, os.ErrDeadlineExceeded):
+				return fmt.Errorf("%w within %s", ErrProbeNotCaptured, cfg.timeout)
+			}
+
+			return err
+		}
+
+		vid, tagged, ok := matchProbe(buf[:md.CaptureLength], token)
+		if !ok {
+			if _, _, _, probe := parseProbe(buf[:md.CaptureLength]); probe {
+				res.Unsolicited++
+			}
+
+			continue
+		}
+
+		res.Received = true
+
+		// hardware timestamps don't come from the clock sent was read from
+		res.Latency = time.Since(sent)
+		if md.TimestampSource == TimestampSoftware && md.Timestamp.After(sent) {
+			res.Latency = md.Timestamp.Sub(sent)
+		}
+
+		if cfg.vid != 0 {
+			switch {
+			case tagged:
+				res.VLANPreserved = vid == cfg.vid
+			case md.VLAN.Valid:
+				res.VLANPreserved = md.VLAN.ID() == cfg.vid
+				res.VLANOffloaded = res.VLANPreserved
+			}
+		}
+
+		return nil
+	}
+}
+
+// selfTestProbe builds a probe from and to hwAddr, tagged when vid isn't 0.
+// Interfaces without an ethernet address, such as lo, use a zero address.
+func selfTestProbe(hwAddr net.HardwareAddr, vid uint16, token []byte) []byte {
+	if len(hwAddr) != 6 {
+		hwAddr = make(net.HardwareAddr, 6)
+	}
+
+	frame := make([]byte, 0, 18+len(selfTestMagic)+len(token))
+	frame = append(frame, hwAddr...)
+	frame = append(frame, hwAddr...)
+
+	if vid != 0 {
+		frame = binary.BigEndian.AppendUint16(frame, uint16(ethernet.EthernetTypeVLAN))
+		frame = binary.BigEndian.AppendUint16(frame, vid)
+	}
+
+	frame = binary.BigEndian.AppendUint16(frame, selfTestEthertype)
+	frame = append(frame, selfTestMagic...)
+
+	return append(frame, token...)
+}
+
+// matchProbe reports whether frame is the probe carrying token, and the
+// VLAN ID of its tag if it still has one
+func matchProbe(frame, token []byte) (uint16, bool, bool) {
+	got, vid, tagged, ok := parseProbe(frame)
+	if !ok || !bytes.Equal(got, token) {
+		return 0, false, false
+	}
+
+	return vid, tagged, true
+}
+
+// parseProbe reports whether frame is the probe of a self-test, and returns
+// its token and the VLAN ID of its tag if it still has one
+func parseProbe(frame []byte) ([]byte, uint16, bool, bool) {
+	var (
+		vid    uint16
+		tagged bool
+	)
+
+	if len(frame) < 14 {
+		return nil, 0, false, false
+	}
+
+	payload := frame[12:]
+
+	if binary.BigEndian.Uint16(payload) == uint16(ethernet.EthernetTypeVLAN) {
+		if len(payload) < 6 {
+			return nil, 0, false, false
+		}
+
+		vid = binary.BigEndian.Uint16(payload[2:]) & 0x0fff
+		tagged = true
+		payload = payload[4:]
+	}
+
+	if binary.BigEndian.Uint16(payload) != selfTestEthertype {
+		return nil, 0, false, false
+	}
+
+	payload = payload[2:]
+
+	if !bytes.HasPrefix(payload, selfTestMagic) {
+		return nil, 0, false, false
+	}
+
+	return payload[len(selfTestMagic):], vid, tagged, true
+}
+
+// selfTestFilter accepts frames of the self-test ethertype, with or without
+// an 802.1Q tag
+func selfTestFilter() ([]bpf.RawInstruction, error) {
+	return bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: selfTestEthertype, SkipFalse: 1},
+		bpf.RetConstant{Val: 0xffff},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeVLAN), SkipFalse: 3},
+		bpf.LoadAbsolute{Off: 16, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: selfTestEthertype, SkipFalse: 1},
+		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: 0},
+	})
+}
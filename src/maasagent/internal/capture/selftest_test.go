@@ -0,0 +1,337 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loopLink delivers what is written on an interface to its readers, after
+// deliver has had its way with the frame
+type loopLink struct {
+	deliver func(frame []byte) ([]byte, Metadata, bool)
+	frames  chan []byte
+	mds     chan Metadata
+	openErr error
+	sendErr error
+	iface   net.Interface
+}
+
+func newLoopLink(name string) *loopLink {
+	return &loopLink{
+		iface: net.Interface{
+			Name:         name,
+			HardwareAddr: net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01},
+		},
+		frames: make(chan []byte, 8),
+		mds:    make(chan Metadata, 8),
+	}
+}
+
+func (l *loopLink) listen(string, ...Option) (probeConn, error) {
+	if l.openErr != nil {
+		return nil, l.openErr
+	}
+
+	return &loopConn{link: l, kick: make(chan struct{}, 1)}, nil
+}
+
+type loopConn struct {
+	deadline time.Time
+	link     *loopLink
+	kick     chan struct{}
+	mu       sync.Mutex
+}
+
+func (c *loopConn) Interface() *net.Interface { return &c.link.iface }
+
+func (c *loopConn) WriteFrame(frame []byte) error {
+	if c.link.sendErr != nil {
+		return c.link.sendErr
+	}
+
+	md := Metadata{Direction: DirectionOutbound, TimestampSource: TimestampWallClock}
+
+	if c.link.deliver != nil {
+		var ok bool
+
+		frame, md, ok = c.link.deliver(frame)
+		if !ok {
+			return nil
+		}
+	}
+
+	c.link.frames <- frame
+	c.link.mds <- md
+
+	return nil
+}
+
+func (c *loopConn) ReadFrame(buf []byte) (int, error) {
+	md, err := c.ReadFrameMetadata(buf)
+
+	return md.CaptureLength, err
+}
+
+func (c *loopConn) ReadFrameMetadata(buf []byte) (Metadata, error) {
+	for {
+		c.mu.Lock()
+		deadline := c.deadline
+		c.mu.Unlock()
+
+		var timeout <-chan time.Time
+
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return Metadata{}, os.ErrDeadlineExceeded
+			}
+
+			timeout = time.After(d)
+		}
+
+		select {
+		case frame := <-c.link.frames:
+			md := <-c.link.mds
+			md.CaptureLength = copy(buf, frame)
+			md.Length = len(frame)
+
+			return md, nil
+		case <-timeout:
+		case <-c.kick:
+		}
+	}
+}
+
+func (c *loopConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (c *loopConn) Close() error { return nil }
+
+func withSelfTestListen(f func(string, ...Option) (probeConn, error)) SelfTestOption {
+	return func(c *selfTestConfig) {
+		c.listen = f
+	}
+}
+
+// stripTag moves the VLAN tag of the frame into the metadata, as a NIC with
+// VLAN offload does
+func stripTag(frame []byte) ([]byte, Metadata, bool) {
+	md := Metadata{Direction: DirectionInbound}
+
+	if vid, tagged, ok := matchProbe(frame, frame[len(frame)-selfTestTokenLen:]); ok && tagged {
+		md.VLAN = VLANInfo{TCI: vid, TPID: 0x8100, Valid: true}
+		frame = append(frame[:12:12], frame[16:]...)
+	}
+
+	return frame, md, true
+}
+
+func TestSelfTest(t *testing.T) {
+	t.Parallel()
+
+	sendErr := errors.New("network is down")
+
+	testcases := map[string]struct {
+		setup   func(l *loopLink)
+		options []SelfTestOption
+		out     SelfTestResult
+		err     string
+	}{
+		"looped back": {
+			out: SelfTestResult{Sent: true, Received: true},
+		},
+		"tag survives": {
+			options: []SelfTestOption{WithSelfTestVLAN(42)},
+			out:     SelfTestResult{VLAN: 42, Sent: true, Received: true, VLANPreserved: true},
+		},
+		"tag offloaded": {
+			setup: func(l *loopLink) {
+				l.deliver = stripTag
+			},
+			options: []SelfTestOption{WithSelfTestVLAN(42)},
+			out: SelfTestResult{
+				VLAN: 42, Sent: true, Received: true, VLANPreserved: true, VLANOffloaded: true,
+			},
+		},
+		"tag lost": {
+			setup: func(l *loopLink) {
+				l.deliver = func(frame []byte) ([]byte, Metadata, bool) {
+					return append(frame[:12:12], frame[16:]...), Metadata{}, true
+				}
+			},
+			options: []SelfTestOption{WithSelfTestVLAN(42)},
+			out:     SelfTestResult{VLAN: 42, Sent: true, Received: true},
+		},
+		"other frames are ignored": {
+			setup: func(l *loopLink) {
+				l.deliver = func(frame []byte) ([]byte, Metadata, bool) {
+					l.frames <- testFrame("not a probe")
+					l.mds <- Metadata{}
+
+					return frame, Metadata{}, true
+				}
+			},
+			out: SelfTestResult{Sent: true, Received: true},
+		},
+		"probes of other self-tests are unsolicited": {
+			setup: func(l *loopLink) {
+				l.deliver = func(frame []byte) ([]byte, Metadata, bool) {
+					l.frames <- testFrame("maas-selftest not the token")
+					l.mds <- Metadata{}
+
+					return frame, Metadata{}, true
+				}
+			},
+			out: SelfTestResult{Sent: true, Received: true, Unsolicited: 1},
+		},
+		"not captured": {
+			setup: func(l *loopLink) {
+				l.deliver = func([]byte) ([]byte, Metadata, bool) {
+					return nil, Metadata{}, false
+				}
+			},
+			options: []SelfTestOption{WithSelfTestTimeout(20 * time.Millisecond)},
+			out:     SelfTestResult{Sent: true},
+			err:     "probe was not captured within 20ms",
+		},
+		"send failure": {
+			setup: func(l *loopLink) {
+				l.sendErr = sendErr
+			},
+			err: "network is down",
+		},
+		"open failure": {
+			setup: func(l *loopLink) {
+				l.openErr = ErrClosed
+			},
+			err: ErrClosed.Error(),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			link := newLoopLink("eth0")
+			if tc.setup != nil {
+				tc.setup(link)
+			}
+
+			options := append([]SelfTestOption{withSelfTestListen(link.listen)}, tc.options...)
+			res := SelfTest(context.Background(), "eth0", options...)
+
+			assert.Equal(t, tc.err, res.Error)
+
+			if res.Received {
+				assert.Positive(t, res.Latency)
+			}
+
+			res.Latency = 0
+			tc.out.Interface = "eth0"
+			tc.out.Error = tc.err
+			assert.Equal(t, tc.out, res)
+		})
+	}
+}
+
+func TestSelfTestCanceled(t *testing.T) {
+	t.Parallel()
+
+	link := newLoopLink("eth0")
+	link.deliver = func([]byte) ([]byte, Metadata, bool) {
+		return nil, Metadata{}, false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	res := SelfTest(ctx, "eth0", withSelfTestListen(link.listen))
+	assert.True(t, res.Sent)
+	assert.False(t, res.Received)
+	assert.Equal(t, context.Canceled.Error(), res.Error)
+}
+
+func TestSelfTestAll(t *testing.T) {
+	t.Parallel()
+
+	links := map[string]*loopLink{
+		"eth0": newLoopLink("eth0"),
+		"eth1": newLoopLink("eth1"),
+		"eth2": newLoopLink("eth2"),
+	}
+	links["eth1"].openErr = os.ErrPermission
+
+	listen := func(iface string, options ...Option) (probeConn, error) {
+		return links[iface].listen(iface, options...)
+	}
+
+	results := SelfTestAll(context.Background(), []string{"eth2", "eth1", "eth0"}, withSelfTestListen(listen))
+	require.Len(t, results, 3)
+
+	for i, iface := range []string{"eth2", "eth1", "eth0"} {
+		assert.Equal(t, iface, results[i].Interface)
+		assert.Equal(t, iface != "eth1", results[i].Received, iface)
+	}
+
+	assert.Equal(t, os.ErrPermission.Error(), results[1].Error)
+}
+
+// TestSelfTestLoopback requires CAP_NET_RAW:
+// sudo TEST_CAPTURE_IFACE=lo \
+// go test maas.io/core/src/maasagent/internal/capture -run TestSelfTestLoopback -count 1 -v
+func TestSelfTestLoopback(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	results := SelfTestAll(context.Background(), []string{iface, iface})
+	require.Len(t, results, 2)
+
+	for _, res := range results {
+		assert.Empty(t, res.Error)
+		assert.True(t, res.Sent)
+		assert.True(t, res.Received)
+	}
+
+	res := SelfTest(context.Background(), iface, WithSelfTestVLAN(42))
+	assert.Empty(t, res.Error)
+	assert.True(t, res.Received)
+	assert.True(t, res.VLANPreserved)
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"container/heap"
+	"net"
+	"slices"
+)
+
+// hwAddr is a MAC address usable as map key
+type hwAddr [6]byte
+
+// talkerCount is a counter of the space-saving sketch
+type talkerCount struct {
+	addr hwAddr
+	// count overestimates the frequency of addr by at most err
+	count uint64
+	err   uint64
+	index int
+}
+
+// spaceSaving finds the most frequent MAC addresses of a stream with a fixed
+// number of counters (Metwally et al., "Efficient Computation of Frequent
+// and Top-k Elements in Data Streams"). Any address seen more than
+// total/capacity times is guaranteed to be tracked.
+type spaceSaving struct {
+	counters map[hwAddr]*talkerCount
+	// heap orders the counters by count, the smallest is evicted first
+	heap     talkerHeap
+	capacity int
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{
+		counters: make(map[hwAddr]*talkerCount, capacity),
+		heap:     make(talkerHeap, 0, capacity),
+		capacity: capacity,
+	}
+}
+
+func (s *spaceSaving) add(addr hwAddr) {
+	if c, ok := s.counters[addr]; ok {
+		c.count++
+		heap.Fix(&s.heap, c.index)
+
+		return
+	}
+
+	if len(s.heap) < s.capacity {
+		c := &talkerCount{addr: addr, count: 1}
+		s.counters[addr] = c
+		heap.Push(&s.heap, c)
+
+		return
+	}
+
+	// the new address takes over the smallest counter, inheriting its
+	// count as the possible overestimation
+	c := s.heap[0]
+	delete(s.counters, c.addr)
+
+	c.addr = addr
+	c.err = c.count
+	c.count++
+	s.counters[addr] = c
+	heap.Fix(&s.heap, 0)
+}
+
+// top returns up to n talkers by decreasing count
+func (s *spaceSaving) top(n int) []Talker {
+	counts := slices.Clone(s.heap)
+
+	slices.SortFunc(counts, func(a, b *talkerCount) int {
+		switch {
+		case a.count > b.count:
+			return -1
+		case a.count < b.count:
+			return 1
+		default:
+			return slices.Compare(a.addr[:], b.addr[:])
+		}
+	})
+
+	talkers := make([]Talker, 0, min(n, len(counts)))
+
+	for _, c := range counts[:min(n, len(counts))] {
+		talkers = append(talkers, Talker{
+			MAC:    net.HardwareAddr(c.addr[:]).String(),
+			Frames: c.count,
+			Error:  c.err,
+		})
+	}
+
+	return talkers
+}
+
+func (s *spaceSaving) reset() {
+	clear(s.counters)
+	s.heap = s.heap[:0]
+}
+
+// talkerHeap is a min-heap of counters, see
+// https://pkg.go.dev/container/heap#example-package-PriorityQueue
+type talkerHeap []*talkerCount
+
+func (h talkerHeap) Len() int {
+	return len(h)
+}
+
+func (h talkerHeap) Less(i, j int) bool {
+	return h[i].count < h[j].count
+}
+
+func (h talkerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *talkerHeap) Push(x any) {
+	c, ok := x.(*talkerCount)
+	if !ok {
+		panic("x should be of type *talkerCount")
+	}
+
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *talkerHeap) Pop() any {
+	prev := *h
+	n := len(prev)
+	x := prev[n-1]
+	prev[n-1] = nil
+	*h = prev[0 : n-1]
+
+	return x
+}
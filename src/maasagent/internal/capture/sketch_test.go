@@ -0,0 +1,107 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"math/rand/v2"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAddr(i int) hwAddr {
+	return hwAddr{0x00, 0x16, 0x3e, 0x00, byte(i >> 8), byte(i)}
+}
+
+func TestSpaceSavingExact(t *testing.T) {
+	t.Parallel()
+
+	s := newSpaceSaving(4)
+
+	for i, n := range []int{5, 3, 1} {
+		for range n {
+			s.add(testAddr(i))
+		}
+	}
+
+	assert.Equal(t, []Talker{
+		{MAC: "00:16:3e:00:00:00", Frames: 5},
+		{MAC: "00:16:3e:00:00:01", Frames: 3},
+	}, s.top(2))
+}
+
+func TestSpaceSavingHeavyHitters(t *testing.T) {
+	t.Parallel()
+
+	const (
+		capacity = 32
+		frames   = 100000
+	)
+
+	s := newSpaceSaving(capacity)
+	rng := rand.New(rand.NewPCG(1, 2)) //nolint:gosec // deterministic test data
+	exact := make(map[hwAddr]uint64)
+
+	// three heavy talkers hidden in the noise of thousands of addresses
+	for range frames {
+		var addr hwAddr
+
+		switch r := rng.IntN(100); {
+		case r < 20:
+			addr = testAddr(0xfff0)
+		case r < 30:
+			addr = testAddr(0xfff1)
+		case r < 35:
+			addr = testAddr(0xfff2)
+		default:
+			addr = testAddr(rng.IntN(5000))
+		}
+
+		exact[addr]++
+		s.add(addr)
+	}
+
+	assert.Len(t, s.counters, capacity)
+
+	top := s.top(3)
+	require.Len(t, top, 3)
+
+	for i, addr := range []hwAddr{testAddr(0xfff0), testAddr(0xfff1), testAddr(0xfff2)} {
+		want := exact[addr]
+
+		assert.Equal(t, net.HardwareAddr(addr[:]).String(), top[i].MAC)
+		// counts are overestimated by at most the error, which is
+		// bounded by frames/capacity
+		assert.GreaterOrEqual(t, top[i].Frames, want)
+		assert.LessOrEqual(t, top[i].Frames-top[i].Error, want)
+		assert.LessOrEqual(t, top[i].Error, uint64(frames/capacity))
+	}
+}
+
+func TestSpaceSavingReset(t *testing.T) {
+	t.Parallel()
+
+	s := newSpaceSaving(2)
+	s.add(testAddr(1))
+	s.reset()
+
+	assert.Empty(t, s.top(10))
+
+	s.add(testAddr(2))
+	assert.Equal(t, []Talker{{MAC: "00:16:3e:00:00:02", Frames: 1}}, s.top(10))
+}
@@ -0,0 +1,374 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/lldp"
+	"maas.io/core/src/maasagent/internal/ptp"
+)
+
+const (
+	defaultTopTalkers = 10
+	// talkerCapacity is the number of sketch counters kept per reported
+	// talker, more counters make the reported counts more accurate
+	talkerCapacity = 8
+	// maxEthertypes bounds the ethertype distribution, frames of other
+	// ethertypes are only counted in EthertypesOther
+	maxEthertypes = 64
+	// ethertypeLLC groups the 802.3 frames, which carry a length
+	// rather than an ethertype
+	ethertypeLLC Ethertype = 0
+)
+
+// sizeBuckets are the upper bounds of the frame size histogram, the last
+// bucket holds jumbo frames
+var sizeBuckets = []int{64, 128, 256, 512, 1024, 1518}
+
+// Ethertype is the protocol of a frame, it is encoded as a hexadecimal
+// string so it can be used as JSON object key
+type Ethertype uint16
+
+// MarshalText implements encoding.TextMarshaler for Ethertype
+func (e Ethertype) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "0x%04x", uint16(e)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for Ethertype
+func (e *Ethertype) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseUint(string(text), 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid ethertype %q: %w", text, err)
+	}
+
+	*e = Ethertype(v)
+
+	return nil
+}
+
+// Talker is a source MAC address and the number of frames it sent
+type Talker struct {
+	MAC string `json:"mac"`
+	// Frames may overestimate the real count by up to Error
+	Frames uint64 `json:"frames"`
+	Error  uint64 `json:"error,omitempty"`
+}
+
+// SizeBucket counts the frames of at most UpTo bytes which didn't fit in
+// the previous bucket, UpTo is 0 for the last bucket
+type SizeBucket struct {
+	UpTo   int    `json:"up_to,omitempty"`
+	Frames uint64 `json:"frames"`
+}
+
+// Summary describes the traffic of an interface over an interval
+type Summary struct {
+	Start      time.Time            `json:"start"`
+	End        time.Time            `json:"end"`
+	Ethertypes map[Ethertype]uint64 `json:"ethertypes"`
+	// Drops is the number of frames the kernel dropped during the
+	// interval, it is omitted when the summarizer has no StatsSource
+	Drops *uint64 `json:"drops,omitempty"`
+	// LACP is set when the interface received LACPDUs
+	LACP *LACPSummary `json:"lacp,omitempty"`
+	// LLDP is set when the interface received LLDPDUs
+	LLDP *LLDPSummary `json:"lldp,omitempty"`
+	// Multicast is the group membership reported with MLD
+	Multicast *MulticastSummary `json:"multicast,omitempty"`
+	// DNS is the plain DNS traffic of the hosts
+	DNS        *DNSSummary  `json:"dns,omitempty"`
+	Interface  string       `json:"interface"`
+	TopTalkers []Talker     `json:"top_talkers"`
+	FrameSizes []SizeBucket `json:"frame_sizes"`
+	// PTP lists the PTP domains seen during the interval
+	PTP []PTPDomain `json:"ptp,omitempty"`
+	// CFM lists the maintenance domain levels with CFM traffic
+	CFM    []CFMLevel `json:"cfm,omitempty"`
+	Frames uint64     `json:"frames"`
+	Bytes  uint64     `json:"bytes"`
+	// Outbound is the number of frames sent by the host, which are left
+	// out of the other counters unless the summarizer includes them
+	Outbound        uint64 `json:"outbound,omitempty"`
+	EthertypesOther uint64 `json:"ethertypes_other,omitempty"`
+}
+
+// StatsSource provides the kernel counters of a capture, Conn implements it
+type StatsSource interface {
+	Stats() (Stats, error)
+}
+
+// Summarizer accumulates the frames of an interface into periodic
+// summaries with bounded memory
+type Summarizer struct {
+	start      time.Time
+	clock      clock.Clock
+	stats      StatsSource
+	talkers    *spaceSaving
+	ptp        *ptpTracker
+	multicast  *multicastTracker
+	dns        *dnsTracker
+	link       linkTracker
+	ethertypes map[Ethertype]uint64
+	iface      string
+	sizes      []uint64
+	lastStats  Stats
+	topN       int
+	frames     uint64
+	bytes      uint64
+	other      uint64
+	outbound   uint64
+	mu         sync.Mutex
+	// ownTraffic accounts the outbound frames like the received ones
+	ownTraffic bool
+}
+
+// SummarizerOption configures a Summarizer
+type SummarizerOption func(*Summarizer)
+
+// WithTopTalkers sets the number of source MAC addresses reported
+func WithTopTalkers(n int) SummarizerOption {
+	return func(s *Summarizer) {
+		if n > 0 {
+			s.topN = n
+		}
+	}
+}
+
+// WithStatsSource reports the kernel drops of source in the summaries
+func WithStatsSource(source StatsSource) SummarizerOption {
+	return func(s *Summarizer) {
+		s.stats = source
+	}
+}
+
+// WithSummarizerClock sets the clock timing the summary intervals
+func WithSummarizerClock(c clock.Clock) SummarizerOption {
+	return func(s *Summarizer) {
+		s.clock = c
+	}
+}
+
+// WithOwnTraffic accounts the frames sent by the host like the received ones,
+// to debug what the agent itself transmits
+func WithOwnTraffic() SummarizerOption {
+	return func(s *Summarizer) {
+		s.ownTraffic = true
+	}
+}
+
+// WithDNSNames keeps a sample of the n names each host queried most
+// recently, at most MaxDNSNames. The names tell a lot about the users of a
+// host, so by default only the queries are counted.
+func WithDNSNames(n int) SummarizerOption {
+	return func(s *Summarizer) {
+		s.dns.names = min(max(n, 0), MaxDNSNames)
+	}
+}
+
+// NewSummarizer returns a Summarizer for the named interface
+func NewSummarizer(iface string, options ...SummarizerOption) *Summarizer {
+	s := &Summarizer{
+		iface:      iface,
+		topN:       defaultTopTalkers,
+		ethertypes: make(map[Ethertype]uint64),
+		sizes:      make([]uint64, len(sizeBuckets)+1),
+		ptp:        newPTPTracker(),
+		multicast:  newMulticastTracker(),
+		dns:        newDNSTracker(),
+		clock:      clock.System{},
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	s.start = s.clock.Now()
+
+	s.talkers = newSpaceSaving(s.topN * talkerCapacity)
+
+	return s
+}
+
+// Add accounts a captured frame
+func (s *Summarizer) Add(frame []byte, md Metadata) {
+	size := max(md.Length, len(frame))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if md.Direction == DirectionOutbound {
+		s.outbound++
+
+		if !s.ownTraffic {
+			return
+		}
+	}
+
+	s.frames++
+	s.bytes += uint64(size) //nolint:gosec // frame sizes are positive
+
+	bucket := len(sizeBuckets)
+
+	for i, upTo := range sizeBuckets {
+		if size <= upTo {
+			bucket = i
+			break
+		}
+	}
+
+	s.sizes[bucket]++
+
+	if len(frame) < 14 {
+		return
+	}
+
+	s.talkers.add(hwAddr(frame[6:12]))
+	e := frameEthertype(frame)
+	s.addEthertype(e)
+
+	switch e {
+	case ptp.EthernetType:
+		s.ptp.add(frame)
+	case 0x0800:
+		s.ptp.add(frame)
+		s.dns.add(frame)
+	case 0x86dd:
+		s.ptp.add(frame)
+		s.multicast.add(frame)
+		s.dns.add(frame)
+	case Ethertype(ethernet.EthernetTypeSlowProtocols), Ethertype(ethernet.EthernetTypeCFM), lldp.EthernetType:
+		s.link.add(frame)
+	}
+}
+
+func (s *Summarizer) addEthertype(e Ethertype) {
+	if _, ok := s.ethertypes[e]; !ok && len(s.ethertypes) >= maxEthertypes {
+		s.other++
+		return
+	}
+
+	s.ethertypes[e]++
+}
+
+// frameEthertype returns the ethertype of the payload, after any VLAN tag
+func frameEthertype(frame []byte) Ethertype {
+	off := 12
+	e := binary.BigEndian.Uint16(frame[off:])
+
+	for (e == 0x8100 || e == 0x88a8) && len(frame) >= off+6 {
+		off += 4
+		e = binary.BigEndian.Uint16(frame[off:])
+	}
+
+	if e < 0x0600 {
+		return ethertypeLLC
+	}
+
+	return Ethertype(e)
+}
+
+// Snapshot returns the summary of the frames added since the previous
+// snapshot and starts a new interval
+func (s *Summarizer) Snapshot() Summary {
+	var (
+		stats    Stats
+		statsErr error
+	)
+
+	// read the kernel counters first so the socket lock isn't taken
+	// while holding ours
+	if s.stats != nil {
+		stats, statsErr = s.stats.Stats()
+	}
+
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := Summary{
+		Interface:       s.iface,
+		Start:           s.start,
+		End:             now,
+		Frames:          s.frames,
+		Bytes:           s.bytes,
+		TopTalkers:      s.talkers.top(s.topN),
+		FrameSizes:      make([]SizeBucket, len(s.sizes)),
+		Ethertypes:      s.ethertypes,
+		EthertypesOther: s.other,
+		Outbound:        s.outbound,
+		PTP:             s.ptp.summary(),
+		CFM:             s.link.cfmSummary(),
+		LACP:            s.link.lacpSummary(),
+		LLDP:            s.link.lldp,
+		Multicast:       s.multicast.summary(),
+		DNS:             s.dns.summary(),
+	}
+
+	for i, n := range s.sizes {
+		summary.FrameSizes[i].Frames = n
+
+		if i < len(sizeBuckets) {
+			summary.FrameSizes[i].UpTo = sizeBuckets[i]
+		}
+	}
+
+	switch {
+	case statsErr != nil:
+		log.Debug().Err(statsErr).Str("iface", s.iface).Msg("Summary without kernel drops")
+	case s.stats != nil:
+		drops := stats.Drops - s.lastStats.Drops
+		summary.Drops = &drops
+		s.lastStats = stats
+	}
+
+	s.start = now
+	s.frames, s.bytes, s.other, s.outbound = 0, 0, 0, 0
+	s.ethertypes = make(map[Ethertype]uint64)
+	s.talkers.reset()
+	s.ptp.reset()
+	s.link.reset()
+	s.multicast.reset()
+	s.dns.reset()
+	clear(s.sizes)
+
+	return summary
+}
+
+// Run emits a snapshot every interval until ctx is done
+func (s *Summarizer) Run(ctx context.Context, interval time.Duration, emit func(Summary)) {
+	ticker := s.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			emit(s.Snapshot())
+		}
+	}
+}
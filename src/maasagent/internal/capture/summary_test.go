@@ -0,0 +1,260 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+type fakeStats struct {
+	err   error
+	stats []Stats
+}
+
+func (f *fakeStats) Stats() (Stats, error) {
+	if f.err != nil {
+		return Stats{}, f.err
+	}
+
+	st := f.stats[0]
+	f.stats = f.stats[1:]
+
+	return st, nil
+}
+
+func summaryFrame(src byte, ethertype ...byte) []byte {
+	frame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x16, 0x3e, 0x00, 0x00, src,
+	}
+
+	return append(frame, ethertype...)
+}
+
+func TestFrameEthertype(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []byte
+		out Ethertype
+	}{
+		"ARP": {
+			in:  summaryFrame(1, 0x08, 0x06),
+			out: 0x0806,
+		},
+		"802.1Q": {
+			in:  summaryFrame(1, 0x81, 0x00, 0x00, 0x64, 0x86, 0xdd),
+			out: 0x86dd,
+		},
+		"QinQ": {
+			in:  summaryFrame(1, 0x88, 0xa8, 0x00, 0x64, 0x81, 0x00, 0x00, 0x02, 0x08, 0x00),
+			out: 0x0800,
+		},
+		"truncated tag": {
+			in:  summaryFrame(1, 0x81, 0x00, 0x00),
+			out: 0x8100,
+		},
+		"802.3 length": {
+			in:  summaryFrame(1, 0x00, 0x26),
+			out: ethertypeLLC,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, frameEthertype(tc.in))
+		})
+	}
+}
+
+func TestSummarizer(t *testing.T) {
+	t.Parallel()
+
+	stats := &fakeStats{stats: []Stats{{Packets: 10, Drops: 2}, {Packets: 30, Drops: 7}}}
+	s := NewSummarizer("eth0", WithTopTalkers(2), WithStatsSource(stats))
+
+	for range 3 {
+		s.Add(summaryFrame(1, 0x08, 0x06), Metadata{Length: 60})
+	}
+
+	s.Add(summaryFrame(2, 0x08, 0x00), Metadata{Length: 1514})
+	s.Add(summaryFrame(2, 0x08, 0x00), Metadata{Length: 9000})
+	s.Add(summaryFrame(3, 0x86, 0xdd), Metadata{Length: 200})
+	s.Add([]byte{0xff}, Metadata{})
+
+	summary := s.Snapshot()
+
+	assert.Equal(t, "eth0", summary.Interface)
+	assert.Equal(t, uint64(7), summary.Frames)
+	assert.Equal(t, uint64(3*60+1514+9000+200+1), summary.Bytes)
+	assert.Equal(t, []Talker{
+		{MAC: "00:16:3e:00:00:01", Frames: 3},
+		{MAC: "00:16:3e:00:00:02", Frames: 2},
+	}, summary.TopTalkers)
+	assert.Equal(t, map[Ethertype]uint64{0x0806: 3, 0x0800: 2, 0x86dd: 1}, summary.Ethertypes)
+	assert.Equal(t, []SizeBucket{
+		{UpTo: 64, Frames: 4},
+		{UpTo: 128},
+		{UpTo: 256, Frames: 1},
+		{UpTo: 512},
+		{UpTo: 1024},
+		{UpTo: 1518, Frames: 1},
+		{Frames: 1},
+	}, summary.FrameSizes)
+	require.NotNil(t, summary.Drops)
+	assert.Equal(t, uint64(2), *summary.Drops)
+
+	next := s.Snapshot()
+
+	assert.Zero(t, next.Frames)
+	assert.Empty(t, next.TopTalkers)
+	assert.Empty(t, next.Ethertypes)
+	assert.Equal(t, summary.End, next.Start)
+	require.NotNil(t, next.Drops)
+	assert.Equal(t, uint64(5), *next.Drops)
+}
+
+func TestSummarizerOwnTraffic(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		options []SummarizerOption
+		frames  uint64
+	}{
+		"excluded": {
+			frames: 1,
+		},
+		"included": {
+			options: []SummarizerOption{WithOwnTraffic()},
+			frames:  3,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewSummarizer("eth0", tc.options...)
+			s.Add(summaryFrame(1, 0x08, 0x06), Metadata{Direction: DirectionInbound})
+			s.Add(summaryFrame(2, 0x08, 0x06), Metadata{Direction: DirectionOutbound})
+			s.Add(summaryFrame(2, 0x08, 0x00), Metadata{Direction: DirectionOutbound})
+
+			summary := s.Snapshot()
+
+			assert.Equal(t, tc.frames, summary.Frames)
+			assert.Equal(t, uint64(2), summary.Outbound)
+			assert.Zero(t, s.Snapshot().Outbound)
+		})
+	}
+}
+
+func TestSummarizerBoundsEthertypes(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0")
+
+	for i := range maxEthertypes + 10 {
+		s.Add(summaryFrame(1, 0x90, byte(i)), Metadata{})
+	}
+
+	summary := s.Snapshot()
+
+	assert.Len(t, summary.Ethertypes, maxEthertypes)
+	assert.Equal(t, uint64(10), summary.EthertypesOther)
+	assert.Nil(t, summary.Drops)
+}
+
+func TestSummarizerStatsError(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0", WithStatsSource(&fakeStats{err: errors.New("closed")}))
+
+	assert.Nil(t, s.Snapshot().Drops)
+}
+
+func TestSummaryJSON(t *testing.T) {
+	t.Parallel()
+
+	s := NewSummarizer("eth0", WithStatsSource(&fakeStats{stats: []Stats{{Drops: 1}}}))
+	s.Add(summaryFrame(1, 0x08, 0x06), Metadata{Length: 60})
+
+	summary := s.Snapshot()
+
+	b, err := json.Marshal(summary)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"ethertypes":{"0x0806":1}`)
+	assert.Contains(t, string(b), `"top_talkers":[{"mac":"00:16:3e:00:00:01","frames":1}]`)
+
+	var decoded Summary
+
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.True(t, summary.Start.Equal(decoded.Start))
+
+	decoded.Start, decoded.End = summary.Start, summary.End
+	assert.Equal(t, summary, decoded)
+}
+
+func TestSummarizerRun(t *testing.T) {
+	defer leak.Check(t)()
+
+	start := time.Unix(1700000000, 0)
+	clock := clocktest.NewFake(start)
+	s := NewSummarizer("eth0", WithSummarizerClock(clock))
+	s.Add(summaryFrame(1, 0x08, 0x06), Metadata{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	summaries := make(chan Summary)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		s.Run(ctx, time.Minute, func(summary Summary) {
+			summaries <- summary
+		})
+	}()
+
+	clock.BlockUntil(1)
+
+	// the frame is only in the first interval
+	for i, frames := range []uint64{1, 0, 0} {
+		clock.Advance(time.Minute)
+
+		summary := <-summaries
+		assert.Equal(t, start.Add(time.Duration(i)*time.Minute), summary.Start)
+		assert.Equal(t, start.Add(time.Duration(i+1)*time.Minute), summary.End)
+		assert.Equal(t, frames, summary.Frames)
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// filterSwap is the transition from a replaced filter to its successor.
+// The frames queued before the successor was attached only matched the
+// replaced filter, they are matched against the successor in userspace
+// until the queue is known to hold none of them.
+type filterSwap struct {
+	vm *bpf.VM
+	// attached is when the successor was attached, in Unix nanoseconds, 0
+	// while it is being attached
+	attached atomic.Int64
+}
+
+// admit returns the length of the frame the successor accepts, false when
+// it rejects the frame
+func (s *filterSwap) admit(frame []byte) (int, bool) {
+	n, err := s.vm.Run(frame)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+
+	return min(n, len(frame)), true
+}
+
+// after returns true for a frame the kernel timestamped once the successor
+// was attached, which it matched: the frames queued after it did too. The
+// hardware timestamps are of another clock and prove nothing.
+func (s *filterSwap) after(md *Metadata) bool {
+	attached := s.attached.Load()
+
+	return attached != 0 && md != nil && md.TimestampSource == TimestampSoftware &&
+		md.Timestamp.UnixNano() > attached
+}
+
+// pendingSwap returns the swap in progress whose successor is attached,
+// which an empty queue ends, nil when there is none
+func (c *Conn) pendingSwap() *filterSwap {
+	s := c.swap.Load()
+	if s == nil || s.attached.Load() == 0 {
+		return nil
+	}
+
+	return s
+}
+
+// endSwap ends s, the queue being empty since its successor was attached.
+// A swap which replaced s in the meantime is left in progress.
+func (c *Conn) endSwap(s *filterSwap) {
+	if s != nil {
+		c.swap.CompareAndSwap(s, nil)
+	}
+}
+
+// admit passes the frame read into buf[:n] through the swap in progress,
+// if any. It returns the length of the frame, false when it is discarded.
+func (c *Conn) admit(buf []byte, n int, md *Metadata) (int, bool) {
+	s := c.swap.Load()
+	if s == nil {
+		return n, true
+	}
+
+	if s.after(md) {
+		c.endSwap(s)
+
+		return n, true
+	}
+
+	n, ok := s.admit(buf[:n])
+	if !ok {
+		c.drained.Add(1)
+	}
+
+	return n, ok
+}
+
+// SetFilter replaces the classic BPF program attached to the socket while
+// it receives. The kernel swaps the programs atomically, no frame is lost
+// and the counters carry on, but the frames already queued only matched the
+// replaced program: they are matched again in userspace until the queue
+// drained, and those the new program rejects are discarded and counted in
+// Stats.Drained. A program using extensions the userspace VM lacks can't be
+// matched again, the queued frames are then delivered as they are.
+// FilterStats counts from the swap.
+func (c *Conn) SetFilter(filter []bpf.RawInstruction) error {
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+
+	before, err := c.Stats()
+	if err != nil {
+		return err
+	}
+
+	var swap *filterSwap
+
+	if insns, ok := bpf.Disassemble(filter); ok {
+		if vm, err := bpf.NewVM(insns); err == nil {
+			swap = &filterSwap{vm: vm}
+		} else {
+			log.Debug().Err(err).Str("iface", c.iface.Name).
+				Msg("Frames queued before the filter swap are not matched again")
+		}
+	}
+
+	// the swap is in progress before the program is attached, a frame
+	// queued under the replaced one can't be read unmatched in between
+	prev := c.swap.Swap(swap)
+
+	cerr := c.raw.Control(func(fd uintptr) {
+		err = setFilter(int(fd), filter)
+	})
+	if cerr != nil || err != nil {
+		c.swap.CompareAndSwap(swap, prev)
+
+		if cerr != nil {
+			return c.wrapClosed(cerr)
+		}
+
+		return err
+	}
+
+	if swap != nil {
+		swap.attached.Store(time.Now().UnixNano())
+	}
+
+	c.cfg.filter = filter
+
+	c.statsMu.Lock()
+	c.baseline = before
+	c.statsMu.Unlock()
+
+	return nil
+}
+
+// FilterStats returns the counters accumulated since the filter was last
+// replaced by SetFilter, or since the socket was opened
+func (c *Conn) FilterStats() (Stats, error) {
+	st, err := c.Stats()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	return Stats{
+		Packets: st.Packets - c.baseline.Packets,
+		Drops:   st.Drops - c.baseline.Drops,
+		Drained: st.Drained - c.baseline.Drained,
+	}, nil
+}
+
+// SetMembership changes what the interface receives for the capture, as
+// WithMembership does when opening it. The new memberships are added
+// before the previous ones are dropped, so nothing the two modes share is
+// missed in between.
+func (c *Conn) SetMembership(m Membership) error {
+	var err error
+
+	cerr := c.raw.Control(func(fd uintptr) {
+		c.membershipMu.Lock()
+		defer c.membershipMu.Unlock()
+
+		prev := c.membership
+		if prev == m {
+			return
+		}
+
+		if err = c.join(int(fd), m); err != nil {
+			c.membership = prev
+			return
+		}
+
+		if c.membership != prev {
+			c.leave(int(fd), prev)
+		}
+	})
+	if cerr != nil {
+		return c.wrapClosed(cerr)
+	}
+
+	return err
+}
+
+// leave drops the memberships of mode m, the failures are only logged as
+// the socket receives more than asked at worst
+func (c *Conn) leave(fd int, m Membership) {
+	var err error
+
+	switch m {
+	case MembershipUnicast:
+	case MembershipGroups:
+		for _, group := range append(DefaultMulticastGroups(), c.cfg.groups...) {
+			err = errors.Join(err, c.dropMembership(fd, unix.PACKET_MR_MULTICAST, group))
+		}
+	case MembershipAllMulticast:
+		err = c.dropMembership(fd, unix.PACKET_MR_ALLMULTI, nil)
+	case MembershipPromiscuous:
+		err = c.dropMembership(fd, unix.PACKET_MR_PROMISC, nil)
+	}
+
+	if err != nil {
+		log.Warn().Err(err).Str("iface", c.iface.Name).Stringer("membership", m).
+			Msg("Failed to drop the previous membership of the capture")
+	}
+}
+
+// SetAuxData enables or disables the PACKET_AUXDATA control messages, the
+// only source of the VLAN tags the NIC strips and of Metadata.Length
+func (c *Conn) SetAuxData(enabled bool) error {
+	value := 0
+	if enabled {
+		value = 1
+	}
+
+	var err error
+
+	cerr := c.raw.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_PACKET, unix.PACKET_AUXDATA, value)
+	})
+	if cerr != nil {
+		return c.wrapClosed(cerr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to set auxdata: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+)
+
+// testOtherEthertype is the second IEEE local experimental ethertype
+const testOtherEthertype = 0x88b6
+
+// ethertypeFilter returns a filter accepting the frames of the ethertypes
+func ethertypeFilter(t *testing.T, ethertypes ...uint16) []bpf.RawInstruction {
+	t.Helper()
+
+	insns := []bpf.Instruction{bpf.LoadAbsolute{Off: 12, Size: 2}}
+
+	for i, ethertype := range ethertypes {
+		insns = append(insns, bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethertype),
+			SkipTrue: uint8(len(ethertypes) - i)}) //nolint:gosec // a few ethertypes
+	}
+
+	filter, err := bpf.Assemble(append(insns, bpf.RetConstant{Val: 0}, bpf.RetConstant{Val: 0xffff}))
+	require.NoError(t, err)
+
+	return filter
+}
+
+// sequencedFrame returns a frame of ethertype carrying seq
+func sequencedFrame(ethertype uint16, seq uint32) []byte {
+	frame := testFrame("")
+	binary.BigEndian.PutUint16(frame[12:], ethertype)
+
+	return binary.BigEndian.AppendUint32(frame, seq)
+}
+
+func testSwap(t *testing.T, ethertypes ...uint16) *filterSwap {
+	t.Helper()
+
+	insns, ok := bpf.Disassemble(ethertypeFilter(t, ethertypes...))
+	require.True(t, ok)
+
+	vm, err := bpf.NewVM(insns)
+	require.NoError(t, err)
+
+	return &filterSwap{vm: vm}
+}
+
+func TestConnAdmit(t *testing.T) {
+	t.Parallel()
+
+	c := &Conn{}
+	frame := sequencedFrame(testEthertype, 1)
+	other := sequencedFrame(testOtherEthertype, 2)
+
+	// no swap in progress
+	n, ok := c.admit(other, len(other), nil)
+	assert.True(t, ok)
+	assert.Equal(t, len(other), n)
+
+	swap := testSwap(t, testEthertype)
+	c.swap.Store(swap)
+
+	// the frames queued are matched again, even once the filter is
+	// attached until one is received after it
+	_, ok = c.admit(other, len(other), nil)
+	assert.False(t, ok)
+
+	swap.attached.Store(time.Unix(1700000000, 0).UnixNano())
+
+	n, ok = c.admit(frame, len(frame), &Metadata{Timestamp: time.Unix(1700000000, 0),
+		TimestampSource: TimestampSoftware})
+	assert.True(t, ok)
+	assert.Equal(t, len(frame), n)
+
+	_, ok = c.admit(other, len(other), &Metadata{Timestamp: time.Unix(1700000001, 0),
+		TimestampSource: TimestampHardware})
+	assert.False(t, ok)
+	assert.Equal(t, uint64(2), c.drained.Load())
+	assert.Same(t, swap, c.pendingSwap())
+
+	// the frames received after the swap passed the new filter, and the
+	// swap is over
+	n, ok = c.admit(other, len(other), &Metadata{Timestamp: time.Unix(1700000001, 0),
+		TimestampSource: TimestampSoftware})
+	assert.True(t, ok)
+	assert.Equal(t, len(other), n)
+	assert.Nil(t, c.swap.Load())
+}
+
+func TestConnEndSwap(t *testing.T) {
+	t.Parallel()
+
+	c := &Conn{}
+	swap := testSwap(t, testEthertype)
+	c.swap.Store(swap)
+
+	// a swap whose filter isn't attached yet isn't ended by an empty queue
+	assert.Nil(t, c.pendingSwap())
+
+	swap.attached.Store(1)
+	pending := c.pendingSwap()
+	require.Same(t, swap, pending)
+
+	// nor one swapped in since the queue was found empty
+	next := testSwap(t, testOtherEthertype)
+	c.swap.Store(next)
+	c.endSwap(pending)
+	assert.Same(t, next, c.swap.Load())
+
+	next.attached.Store(1)
+	c.endSwap(c.pendingSwap())
+	assert.Nil(t, c.swap.Load())
+}
+
+// TestConnSetFilter swaps the filter under continuous traffic, it requires
+// CAP_NET_RAW and an interface which loops frames back, such as lo, or a
+// veth pair:
+// sudo ip link add cap0 type veth peer name cap1
+// sudo ip link set cap0 up && sudo ip link set cap1 up
+// sudo TEST_CAPTURE_IFACE=cap0 \
+// go test maas.io/core/src/maasagent/internal/capture -run TestConnSetFilter -count 1 -v
+func TestConnSetFilter(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	r, err := Listen(iface, WithFilter(ethertypeFilter(t, testEthertype, testOtherEthertype)),
+		WithReadBuffer(16<<20))
+	require.NoError(t, err)
+
+	defer r.Close() //nolint:errcheck // test cleanup
+
+	w, err := Listen(iface, WithProtocol(testEthertype))
+	require.NoError(t, err)
+
+	defer w.Close() //nolint:errcheck // test cleanup
+
+	var (
+		sent uint32
+		wg   sync.WaitGroup
+	)
+
+	stop := make(chan struct{})
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for ; ; sent++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			assert.NoError(t, w.WriteFrame(sequencedFrame(testEthertype, sent)))
+			assert.NoError(t, w.WriteFrame(sequencedFrame(testOtherEthertype, sent)))
+
+			if sent%64 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	// the frames of both ethertypes are queued when the filter is swapped
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, r.SetFilter(ethertypeFilter(t, testEthertype)))
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	seen := make(map[uint32]bool)
+	buf := make([]byte, 1500)
+
+	require.NoError(t, r.SetReadDeadline(time.Now().Add(time.Second)))
+
+	for {
+		md, err := r.ReadFrameMetadata(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, uint16(testEthertype), binary.BigEndian.Uint16(buf[12:]),
+			"a frame the new filter rejects got through")
+
+		seen[binary.BigEndian.Uint32(buf[14:md.CaptureLength])] = true
+	}
+
+	st, err := r.Stats()
+	require.NoError(t, err)
+	require.Zero(t, st.Drops, "the receive buffer overflowed, the test proves nothing")
+	assert.Positive(t, st.Drained)
+
+	for seq := range sent {
+		require.True(t, seen[seq], "frame %d was lost", seq)
+	}
+
+	// the counters carry on across the swap, and are counted from it too
+	since, err := r.FilterStats()
+	require.NoError(t, err)
+	assert.Less(t, since.Packets, st.Packets)
+	assert.Equal(t, st.Drained, since.Drained)
+}
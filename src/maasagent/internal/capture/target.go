@@ -0,0 +1,602 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+)
+
+const (
+	// maxTargets bounds the MACs and IPs of a Target, which keeps the
+	// filter well under the kernel limit of 4096 instructions
+	maxTargets = 64
+	// targetSnaplen is what the filter of a Target keeps of the frames
+	// without a base filter
+	targetSnaplen = 0xffff
+
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+)
+
+var (
+	// ErrTooManyTargets is returned when a Target has more than 64 MACs
+	// and IPs
+	ErrTooManyTargets = errors.New("too many targets")
+	// ErrInvalidTarget is returned when a MAC of a Target isn't an
+	// ethernet address or an IP is invalid
+	ErrInvalidTarget = errors.New("invalid target")
+)
+
+// Target is a set of hosts to restrict the capture to. A frame matches when
+// one of the MACs is its source or destination, or one of the IPs is the
+// source or destination of the IPv4, IPv6 or ARP packet it carries.
+//
+// Expression further restricts the capture to the frames matching a filter
+// expression of pcap-filter(7), of the subset of ether host, src and dst,
+// arp, ip, ip6, vlan [ID], [src|dst] host, [tcp|udp] [src|dst] port, and,
+// or, not and parentheses. As with tcpdump, a primitive only looks past the
+// VLAN tags that the vlan primitives before it skip, and vlan doesn't see
+// the tags the NIC stripped.
+type Target struct {
+	// vm runs Expression, once normalized
+	vm         *bpf.VM
+	Expression string
+	MACs       []net.HardwareAddr
+	IPs        []netip.Addr
+}
+
+// Empty returns true if the Target doesn't restrict the capture
+func (t Target) Empty() bool {
+	return len(t.MACs) == 0 && len(t.IPs) == 0 && strings.TrimSpace(t.Expression) == ""
+}
+
+// hosts returns true if the Target has MACs or IPs
+func (t Target) hosts() bool {
+	return len(t.MACs) > 0 || len(t.IPs) > 0
+}
+
+// Normalize returns a copy of the Target with its IPs in their normal
+// form, an IPv4-mapped IPv6 address as the IPv4 address it maps, or an
+// error matching ErrInvalidTarget and the addrutil error of the field. The
+// Expression is compiled, its errors match ErrInvalidExpression or
+// ErrUnsupportedExpression.
+func (t Target) Normalize() (Target, error) {
+	if len(t.MACs)+len(t.IPs) > maxTargets {
+		return Target{}, fmt.Errorf("%w: %d, at most %d", ErrTooManyTargets, len(t.MACs)+len(t.IPs), maxTargets)
+	}
+
+	var norm Target
+
+	for i, mac := range t.MACs {
+		mac, err := addrutil.MAC(addrutil.Index("macs", i), mac)
+		if err != nil {
+			return Target{}, fmt.Errorf("%w: %w", ErrInvalidTarget, err)
+		}
+
+		norm.MACs = append(norm.MACs, mac)
+	}
+
+	for i, ip := range t.IPs {
+		ip, err := addrutil.IP(addrutil.Index("ips", i), ip)
+		if err != nil {
+			return Target{}, fmt.Errorf("%w: %w", ErrInvalidTarget, err)
+		}
+
+		norm.IPs = append(norm.IPs, ip)
+	}
+
+	if norm.Expression = strings.TrimSpace(t.Expression); norm.Expression != "" {
+		vm, err := expressionVM(norm.Expression)
+		if err != nil {
+			return Target{}, err
+		}
+
+		norm.vm = vm
+	}
+
+	return norm, nil
+}
+
+// Match returns true if the frame is from or to one of the hosts, the frame
+// may carry an 802.1Q tag, and matches the Expression. The Expression of a
+// Target which isn't normalized is compiled on every call.
+func (t Target) Match(frame []byte) bool {
+	if t.Empty() {
+		return true
+	}
+
+	if !t.matchExpression(frame) {
+		return false
+	}
+
+	if !t.hosts() {
+		return true
+	}
+
+	if len(frame) < 14 {
+		return false
+	}
+
+	for _, mac := range t.MACs {
+		if bytes.Equal(frame[0:6], mac) || bytes.Equal(frame[6:12], mac) {
+			return true
+		}
+	}
+
+	if len(t.IPs) == 0 {
+		return false
+	}
+
+	src, dst, ok := packetAddrs(frame)
+	if !ok {
+		return false
+	}
+
+	for _, ip := range t.IPs {
+		if ip.Unmap() == src || ip.Unmap() == dst {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchExpression returns true if the frame matches the Expression, or
+// there is none
+func (t Target) matchExpression(frame []byte) bool {
+	vm := t.vm
+
+	if vm == nil {
+		if strings.TrimSpace(t.Expression) == "" {
+			return true
+		}
+
+		var err error
+
+		if vm, err = expressionVM(t.Expression); err != nil {
+			return false
+		}
+	}
+
+	n, err := vm.Run(frame)
+
+	return err == nil && n > 0
+}
+
+// packetAddrs returns the source and destination of the IPv4, IPv6 or ARP
+// packet of the frame
+func packetAddrs(frame []byte) (netip.Addr, netip.Addr, bool) {
+	ethType := binary.BigEndian.Uint16(frame[12:14])
+	l3 := frame[14:]
+
+	if ethType == etherTypeVLAN {
+		if len(l3) < 4 {
+			return netip.Addr{}, netip.Addr{}, false
+		}
+
+		ethType = binary.BigEndian.Uint16(l3[2:4])
+		l3 = l3[4:]
+	}
+
+	var srcOff, dstOff, size int
+
+	switch ethType {
+	case etherTypeIPv4:
+		srcOff, dstOff, size = 12, 16, 4
+	case etherTypeARP:
+		srcOff, dstOff, size = 14, 24, 4
+	case etherTypeIPv6:
+		srcOff, dstOff, size = 8, 24, 16
+	default:
+		return netip.Addr{}, netip.Addr{}, false
+	}
+
+	if len(l3) < dstOff+size {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+
+	src, _ := netip.AddrFromSlice(l3[srcOff : srcOff+size])
+	dst, _ := netip.AddrFromSlice(l3[dstOff : dstOff+size])
+
+	return src, dst, true
+}
+
+// filterProgram assembles instructions whose jumps go to labels
+type filterProgram struct {
+	labels map[string]int
+	insns  []filterInsn
+}
+
+// filterInsn is an instruction, or a jump to labels resolved once the
+// program is complete
+type filterInsn struct {
+	insn bpf.Instruction
+	// jumps to ifTrue when A passes test against val and to ifFalse
+	// otherwise, or always to ifTrue when cond is false
+	ifTrue  string
+	ifFalse string
+	val     uint32
+	test    bpf.JumpTest
+	cond    bool
+}
+
+func (p *filterProgram) add(insn bpf.Instruction) {
+	p.insns = append(p.insns, filterInsn{insn: insn})
+}
+
+func (p *filterProgram) label(name string) {
+	p.labels[name] = len(p.insns)
+}
+
+func (p *filterProgram) jump(label string) {
+	p.insns = append(p.insns, filterInsn{ifTrue: label})
+}
+
+func (p *filterProgram) jumpIf(val uint32, ifTrue, ifFalse string) {
+	p.jumpTest(bpf.JumpEqual, val, ifTrue, ifFalse)
+}
+
+func (p *filterProgram) jumpTest(test bpf.JumpTest, val uint32, ifTrue, ifFalse string) {
+	p.insns = append(p.insns, filterInsn{cond: true, test: test, val: val, ifTrue: ifTrue, ifFalse: ifFalse})
+}
+
+// compare jumps to match when the bytes at off are value, and to next
+// otherwise
+func (p *filterProgram) compare(off uint32, value []byte, match, next string) {
+	for len(value) > 0 {
+		size := min(len(value), 4)
+		if size == 3 {
+			size = 2
+		}
+
+		var v uint32
+
+		switch size {
+		case 4:
+			v = binary.BigEndian.Uint32(value)
+		case 2:
+			v = uint32(binary.BigEndian.Uint16(value))
+		default:
+			v = uint32(value[0])
+		}
+
+		p.add(bpf.LoadAbsolute{Off: off, Size: size})
+
+		value = value[size:]
+		off += uint32(size) //nolint:gosec // size is at most 4
+
+		p.jumpIf(v, "", next)
+	}
+
+	// match may be further than a conditional jump reaches
+	p.jump(match)
+}
+
+func (p *filterProgram) assemble() ([]bpf.RawInstruction, error) {
+	insns := make([]bpf.Instruction, 0, len(p.insns))
+
+	for i, in := range p.insns {
+		if in.insn != nil {
+			insns = append(insns, in.insn)
+
+			continue
+		}
+
+		skip := func(label string) (int, error) {
+			if label == "" {
+				return 0, nil
+			}
+
+			to, ok := p.labels[label]
+			if !ok || to <= i {
+				return 0, fmt.Errorf("invalid filter label %q", label)
+			}
+
+			return to - i - 1, nil
+		}
+
+		skipTrue, err := skip(in.ifTrue)
+		if err != nil {
+			return nil, err
+		}
+
+		if !in.cond {
+			insns = append(insns, bpf.Jump{Skip: uint32(skipTrue)}) //nolint:gosec // labels are ahead
+
+			continue
+		}
+
+		skipFalse, err := skip(in.ifFalse)
+		if err != nil {
+			return nil, err
+		}
+
+		if skipTrue > 0xff || skipFalse > 0xff {
+			return nil, fmt.Errorf("filter jump of %d instructions is too long", max(skipTrue, skipFalse))
+		}
+
+		insns = append(insns, bpf.JumpIf{
+			Cond:      in.test,
+			Val:       in.val,
+			SkipTrue:  uint8(skipTrue),  //nolint:gosec // checked above
+			SkipFalse: uint8(skipFalse), //nolint:gosec // checked above
+		})
+	}
+
+	return bpf.Assemble(insns)
+}
+
+// TargetFilter returns a classic BPF program accepting the frames matching
+// the target, with or without an 802.1Q tag, and passing them on to base.
+// A nil base accepts the whole frame, an empty target gives base as is. The
+// Expression is tested first, the hosts of the frames matching it next.
+func TargetFilter(t Target, base []bpf.RawInstruction) ([]bpf.RawInstruction, error) {
+	t, err := t.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Empty() && base != nil {
+		return base, nil
+	}
+
+	p := &filterProgram{labels: make(map[string]int)}
+
+	if t.Expression != "" {
+		if err := compileExpression(p, t.Expression, "hosts", "reject"); err != nil {
+			return nil, err
+		}
+
+		p.label("hosts")
+	}
+
+	for i, mac := range t.MACs {
+		for _, off := range []uint32{0, 6} {
+			next := fmt.Sprintf("mac%d.%d", i, off)
+			p.compare(off, mac, "accept", next)
+			p.label(next)
+		}
+	}
+
+	var v4, v6 []netip.Addr
+
+	for _, ip := range t.IPs {
+		if ip.Is4() {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	if !t.hosts() {
+		p.jump("accept")
+	} else if len(t.IPs) > 0 {
+		p.add(bpf.LoadAbsolute{Off: 12, Size: 2})
+		p.jumpIf(etherTypeVLAN, "", "untagged")
+		p.jump("tagged")
+		p.label("untagged")
+		targetPackets(p, "untagged", 14, v4, v6)
+		p.label("tagged")
+		p.add(bpf.LoadAbsolute{Off: 16, Size: 2})
+		targetPackets(p, "tagged", 18, v4, v6)
+	}
+
+	p.label("reject")
+	p.add(bpf.RetConstant{Val: 0})
+	p.label("accept")
+
+	if base == nil {
+		p.add(bpf.RetConstant{Val: targetSnaplen})
+	}
+
+	raw, err := p.assemble()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(raw, base...), nil
+}
+
+// targetPackets matches the addresses of the packets starting at l3, with
+// their ethertype loaded
+func targetPackets(p *filterProgram, prefix string, l3 uint32, v4, v6 []netip.Addr) {
+	families := []struct {
+		name     string
+		ips      []netip.Addr
+		ethType  uint32
+		src, dst uint32
+	}{
+		{name: "ipv4", ethType: etherTypeIPv4, ips: v4, src: 12, dst: 16},
+		{name: "arp", ethType: etherTypeARP, ips: v4, src: 14, dst: 24},
+		{name: "ipv6", ethType: etherTypeIPv6, ips: v6, src: 8, dst: 24},
+	}
+
+	for _, f := range families {
+		if len(f.ips) == 0 {
+			continue
+		}
+
+		next := prefix + "." + f.name + ".skip"
+		p.jumpIf(f.ethType, "", next)
+		p.jump(prefix + "." + f.name)
+		p.label(next)
+	}
+
+	p.jump("reject")
+
+	for _, f := range families {
+		if len(f.ips) == 0 {
+			continue
+		}
+
+		p.label(prefix + "." + f.name)
+
+		for i, ip := range f.ips {
+			for _, off := range []uint32{f.src, f.dst} {
+				next := fmt.Sprintf("%s.%s%d.%d", prefix, f.name, i, off)
+				p.compare(l3+off, ip.AsSlice(), "accept", next)
+				p.label(next)
+			}
+		}
+
+		p.jump("reject")
+	}
+}
+
+// filterSetter is a reader whose socket filter can be replaced, such as a
+// Conn
+type filterSetter interface {
+	SetFilter(filter []bpf.RawInstruction) error
+}
+
+// TargetedReader restricts the frames of a reader to a Target which can be
+// changed while reading. The socket filter of the reader is swapped, which
+// the kernel does atomically, and the frames queued before the swap are
+// matched again so none of the previous target get through.
+type TargetedReader struct {
+	r      FrameReader
+	ring   *PcapRing
+	base   []bpf.RawInstruction
+	target Target
+	mu     sync.RWMutex
+}
+
+// TargetedReaderOption configures a TargetedReader
+type TargetedReaderOption func(*TargetedReader)
+
+// WithBaseFilter is the filter the reader was opened with, the target
+// filter only passes the matching frames on to it
+func WithBaseFilter(filter []bpf.RawInstruction) TargetedReaderOption {
+	return func(t *TargetedReader) {
+		t.base = filter
+	}
+}
+
+// WithTargetRing copies the frames matching a non empty target into ring
+func WithTargetRing(ring *PcapRing) TargetedReaderOption {
+	return func(t *TargetedReader) {
+		t.ring = ring
+	}
+}
+
+// NewTargetedReader wraps r, which reads every frame until SetTarget is
+// called
+func NewTargetedReader(r FrameReader, options ...TargetedReaderOption) *TargetedReader {
+	t := &TargetedReader{r: r}
+
+	for _, opt := range options {
+		opt(t)
+	}
+
+	if src, ok := r.(ManifestSource); ok && t.ring != nil {
+		t.ring.SetManifestSource(src)
+	}
+
+	return t
+}
+
+// SetTarget replaces the target, an empty Target reads every frame the base
+// filter accepts. Readers without a socket filter, such as XDP, are
+// filtered in userspace only.
+func (t *TargetedReader) SetTarget(target Target) error {
+	target, err := target.Normalize()
+	if err != nil {
+		return err
+	}
+
+	filter, err := TargetFilter(target, t.base)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if fs, ok := t.r.(filterSetter); ok {
+		if err := fs.SetFilter(filter); err != nil {
+			return err
+		}
+	}
+
+	t.target = target
+
+	return nil
+}
+
+// Target returns the current target
+func (t *TargetedReader) Target() Target {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.target
+}
+
+// ReadFrameMetadata reads the next frame matching the target
+func (t *TargetedReader) ReadFrameMetadata(buf []byte) (Metadata, error) {
+	for {
+		md, err := ReadFrameMetadata(t.r, buf)
+		if err != nil {
+			return md, err
+		}
+
+		frame := buf[:md.CaptureLength]
+
+		t.mu.RLock()
+		target := t.target
+		t.mu.RUnlock()
+
+		if !target.Match(frame) {
+			continue
+		}
+
+		if t.ring != nil && !target.Empty() {
+			t.ring.Add(frame, md)
+		}
+
+		return md, nil
+	}
+}
+
+// ReadFrame reads the next frame matching the target
+func (t *TargetedReader) ReadFrame(buf []byte) (int, error) {
+	md, err := t.ReadFrameMetadata(buf)
+
+	return md.CaptureLength, err
+}
+
+// SetReadDeadline sets the deadline of the wrapped reader
+func (t *TargetedReader) SetReadDeadline(deadline time.Time) error {
+	return t.r.SetReadDeadline(deadline)
+}
+
+// Close closes the wrapped reader
+func (t *TargetedReader) Close() error {
+	return t.r.Close()
+}
@@ -0,0 +1,428 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var (
+	targetMAC = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	otherMAC  = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+	targetV4  = netip.MustParseAddr("10.0.0.1")
+	otherV4   = netip.MustParseAddr("10.0.0.2")
+	targetV6  = netip.MustParseAddr("2001:db8::1")
+	otherV6   = netip.MustParseAddr("2001:db8::2")
+)
+
+// runFilter returns true if the filter accepts the frame
+func runFilter(t *testing.T, raw []bpf.RawInstruction, frame []byte) bool {
+	t.Helper()
+
+	insns, ok := bpf.Disassemble(raw)
+	require.True(t, ok)
+
+	vm, err := bpf.NewVM(insns)
+	require.NoError(t, err)
+
+	n, err := vm.Run(frame)
+	require.NoError(t, err)
+
+	return n > 0
+}
+
+func targetFrame(t *testing.T, src, dst net.HardwareAddr, vid uint16, payload func(*ethernet.FrameBuilder)) []byte {
+	t.Helper()
+
+	b := ethernet.NewFrame().Src(src).Dst(dst)
+	if vid != 0 {
+		b.VLAN(vid)
+	}
+
+	payload(b)
+
+	frame, err := b.Build()
+	require.NoError(t, err)
+
+	return frame
+}
+
+func udp(src, dst netip.Addr) func(*ethernet.FrameBuilder) {
+	return func(b *ethernet.FrameBuilder) {
+		b.UDP(netip.AddrPortFrom(src, 1000), netip.AddrPortFrom(dst, 2000), []byte("target"))
+	}
+}
+
+func arp(sender, target netip.Addr) func(*ethernet.FrameBuilder) {
+	return func(b *ethernet.FrameBuilder) {
+		b.ARPRequest(sender, target)
+	}
+}
+
+func TestTargetFilter(t *testing.T) {
+	t.Parallel()
+
+	both := Target{MACs: []net.HardwareAddr{targetMAC}, IPs: []netip.Addr{targetV4, targetV6}}
+
+	testcases := map[string]struct {
+		target Target
+		frame  func(t *testing.T, vid uint16) []byte
+		match  bool
+	}{
+		"source MAC": {
+			target: both,
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, targetMAC, otherMAC, vid, udp(otherV4, otherV4))
+			},
+			match: true,
+		},
+		"destination MAC": {
+			target: both,
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, targetMAC, vid, udp(otherV4, otherV4))
+			},
+			match: true,
+		},
+		"IPv4 source": {
+			target: both,
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, otherMAC, vid, udp(targetV4, otherV4))
+			},
+			match: true,
+		},
+		"IPv4 destination": {
+			target: both,
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, otherMAC, vid, udp(otherV4, targetV4))
+			},
+			match: true,
+		},
+		"ARP sender": {
+			target: both,
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, otherMAC, vid, arp(targetV4, otherV4))
+			},
+			match: true,
+		},
+		"ARP target": {
+			target: both,
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, otherMAC, vid, arp(otherV4, targetV4))
+			},
+			match: true,
+		},
+		"IPv6 destination": {
+			target: both,
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, otherMAC, vid, udp(otherV6, targetV6))
+			},
+			match: true,
+		},
+		"IPv4 mapped target": {
+			target: Target{IPs: []netip.Addr{netip.AddrFrom16(targetV4.As16())}},
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, otherMAC, vid, udp(targetV4, otherV4))
+			},
+			match: true,
+		},
+		"other hosts": {
+			target: both,
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, otherMAC, vid, udp(otherV6, otherV6))
+			},
+		},
+		"other ARP": {
+			target: both,
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, otherMAC, vid, arp(otherV4, otherV4))
+			},
+		},
+		"MACs only": {
+			target: Target{MACs: []net.HardwareAddr{targetMAC}},
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, otherMAC, vid, udp(targetV4, targetV4))
+			},
+		},
+		"IPv6 only": {
+			target: Target{IPs: []netip.Addr{targetV6}},
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, targetMAC, otherMAC, vid, udp(otherV4, otherV4))
+			},
+		},
+		"empty target": {
+			frame: func(t *testing.T, vid uint16) []byte {
+				return targetFrame(t, otherMAC, otherMAC, vid, udp(otherV4, otherV4))
+			},
+			match: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			filter, err := TargetFilter(tc.target, nil)
+			require.NoError(t, err)
+
+			for _, vid := range []uint16{0, 100} {
+				frame := tc.frame(t, vid)
+
+				assert.Equal(t, tc.match, runFilter(t, filter, frame), "vid %d", vid)
+				assert.Equal(t, tc.match, tc.target.Match(frame), "vid %d", vid)
+			}
+		})
+	}
+}
+
+func TestTargetFilterLimits(t *testing.T) {
+	t.Parallel()
+
+	var target Target
+
+	for i := range maxTargets / 2 {
+		target.MACs = append(target.MACs, net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x01, byte(i)})
+		target.IPs = append(target.IPs, netip.MustParseAddr(fmt.Sprintf("2001:db8::%x", i+0x100)))
+	}
+
+	filter, err := TargetFilter(target, nil)
+	require.NoError(t, err)
+
+	// the last of each is the furthest from the accept
+	lastMAC := target.MACs[len(target.MACs)-1]
+	lastIP := target.IPs[len(target.IPs)-1]
+
+	assert.True(t, runFilter(t, filter, targetFrame(t, otherMAC, lastMAC, 100, udp(otherV6, otherV6))))
+	assert.True(t, runFilter(t, filter, targetFrame(t, otherMAC, otherMAC, 100, udp(otherV6, lastIP))))
+	assert.False(t, runFilter(t, filter, targetFrame(t, otherMAC, otherMAC, 100, udp(otherV6, otherV6))))
+
+	target.IPs = append(target.IPs, targetV4)
+
+	_, err = TargetFilter(target, nil)
+	assert.ErrorIs(t, err, ErrTooManyTargets)
+
+	_, err = TargetFilter(Target{MACs: []net.HardwareAddr{{0x00}}}, nil)
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+}
+
+func TestTargetNormalize(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		target Target
+		norm   Target
+		field  string
+	}{
+		"mapped IP": {
+			target: Target{MACs: []net.HardwareAddr{targetMAC}, IPs: []netip.Addr{netip.MustParseAddr("::ffff:10.0.0.1")}},
+			norm:   Target{MACs: []net.HardwareAddr{targetMAC}, IPs: []netip.Addr{targetV4}},
+		},
+		"EUI-64": {
+			target: Target{MACs: []net.HardwareAddr{targetMAC, {0x00, 0x16, 0x3e, 0xff, 0xfe, 0x00, 0x00, 0x01}}},
+			field:  "macs[1]",
+		},
+		"7-byte MAC": {
+			target: Target{MACs: []net.HardwareAddr{{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01, 0x02}}},
+			field:  "macs[0]",
+		},
+		"missing IP": {
+			target: Target{IPs: []netip.Addr{targetV4, {}}},
+			field:  "ips[1]",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			norm, err := tc.target.Normalize()
+			if tc.field == "" {
+				require.NoError(t, err)
+				assert.Equal(t, tc.norm, norm)
+
+				return
+			}
+
+			assert.ErrorIs(t, err, ErrInvalidTarget)
+
+			var fe *addrutil.FieldError
+			require.ErrorAs(t, err, &fe)
+			assert.Equal(t, tc.field, fe.Field)
+		})
+	}
+}
+
+func TestTargetFilterBase(t *testing.T) {
+	t.Parallel()
+
+	// the base only accepts ARP, as netmon does
+	base, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: etherTypeARP, SkipFalse: 1},
+		bpf.RetConstant{Val: 64},
+		bpf.RetConstant{Val: 0},
+	})
+	require.NoError(t, err)
+
+	untargeted, err := TargetFilter(Target{}, base)
+	require.NoError(t, err)
+	assert.Equal(t, base, untargeted)
+
+	filter, err := TargetFilter(Target{MACs: []net.HardwareAddr{targetMAC}}, base)
+	require.NoError(t, err)
+
+	assert.True(t, runFilter(t, filter, targetFrame(t, targetMAC, otherMAC, 0, arp(otherV4, otherV4))))
+	assert.False(t, runFilter(t, filter, targetFrame(t, targetMAC, otherMAC, 0, udp(otherV4, otherV4))))
+	assert.False(t, runFilter(t, filter, targetFrame(t, otherMAC, otherMAC, 0, arp(otherV4, otherV4))))
+}
+
+// filterRecorder is a FrameReader with a socket filter
+type filterRecorder struct {
+	*PcapReader
+	filters [][]bpf.RawInstruction
+}
+
+func (r *filterRecorder) SetFilter(filter []bpf.RawInstruction) error {
+	r.filters = append(r.filters, filter)
+
+	return nil
+}
+
+func TestTargetedReader(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Unix(1700000000, 0)
+	frames := [][]byte{
+		targetFrame(t, otherMAC, otherMAC, 0, arp(otherV4, otherV4)),
+		targetFrame(t, targetMAC, otherMAC, 0, arp(otherV4, otherV4)),
+		targetFrame(t, otherMAC, otherMAC, 100, arp(otherV4, targetV4)),
+		targetFrame(t, otherMAC, otherMAC, 0, arp(otherV4, otherV4)),
+	}
+
+	var file bytes.Buffer
+
+	w, err := NewPcapWriter(&file, 0)
+	require.NoError(t, err)
+
+	for _, f := range frames {
+		require.NoError(t, w.WriteFrame(f, Metadata{Timestamp: ts, CaptureLength: len(f), Length: len(f)}))
+	}
+
+	pr, err := NewPcapReader(&file, "eth0")
+	require.NoError(t, err)
+
+	rec := &filterRecorder{PcapReader: pr}
+	ring := NewPcapRing(8)
+	r := NewTargetedReader(rec, WithTargetRing(ring))
+
+	buf := make([]byte, 1500)
+
+	// every frame goes through until there is a target
+	md, err := r.ReadFrameMetadata(buf)
+	require.NoError(t, err)
+	assert.Equal(t, frames[0], buf[:md.CaptureLength])
+
+	target := Target{MACs: []net.HardwareAddr{targetMAC}, IPs: []netip.Addr{targetV4}}
+	require.NoError(t, r.SetTarget(target))
+	require.Len(t, rec.filters, 1)
+	assert.Equal(t, target, r.Target())
+
+	// an invalid target keeps the previous one
+	assert.ErrorIs(t, r.SetTarget(Target{MACs: []net.HardwareAddr{{0x00}}}), ErrInvalidTarget)
+	assert.Len(t, rec.filters, 1)
+	assert.Equal(t, target, r.Target())
+
+	for _, want := range frames[1:3] {
+		n, err := r.ReadFrame(buf)
+		require.NoError(t, err)
+		assert.Equal(t, want, buf[:n])
+	}
+
+	_, err = r.ReadFrame(buf)
+	assert.ErrorIs(t, err, io.EOF)
+
+	assert.Equal(t, 2, ring.Len())
+}
+
+// TestTargetedConn requires CAP_NET_RAW and an interface which loops frames
+// back, such as lo:
+// sudo TEST_CAPTURE_IFACE=lo \
+// go test maas.io/core/src/maasagent/internal/capture -run TestTargetedConn -count 1 -v
+func TestTargetedConn(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	conn, err := Listen(iface, WithFilter(testFilter(t)))
+	require.NoError(t, err)
+
+	r := NewTargetedReader(conn, WithBaseFilter(testFilter(t)))
+
+	defer r.Close() //nolint:errcheck // test cleanup
+
+	w, err := Listen(iface, WithProtocol(testEthertype))
+	require.NoError(t, err)
+
+	defer w.Close() //nolint:errcheck // test cleanup
+
+	require.NoError(t, r.SetTarget(Target{MACs: []net.HardwareAddr{targetMAC}}))
+
+	other := slices.Concat([][]byte{otherMAC, otherMAC, testFrame("other")[12:]}...)
+	targeted := slices.Concat([][]byte{otherMAC, targetMAC, testFrame("target")[12:]}...)
+
+	require.NoError(t, w.WriteFrame(other))
+	require.NoError(t, w.WriteFrame(targeted))
+
+	require.NoError(t, r.SetReadDeadline(time.Now().Add(time.Second)))
+
+	buf := make([]byte, 1500)
+
+	n, err := r.ReadFrame(buf)
+	require.NoError(t, err)
+	assert.Equal(t, targeted, buf[:n])
+
+	// the socket is kept while the target changes
+	require.NoError(t, r.SetTarget(Target{}))
+	require.NoError(t, w.WriteFrame(other))
+
+	for {
+		n, err := r.ReadFrame(buf)
+		require.NoError(t, err)
+
+		if bytes.Equal(buf[:n], other) {
+			break
+		}
+	}
+}
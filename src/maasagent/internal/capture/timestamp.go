@@ -0,0 +1,150 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
+)
+
+const (
+	softwareTimestamping = unix.SOF_TIMESTAMPING_RX_SOFTWARE | unix.SOF_TIMESTAMPING_SOFTWARE
+	hardwareTimestamping = unix.SOF_TIMESTAMPING_RX_HARDWARE | unix.SOF_TIMESTAMPING_RAW_HARDWARE
+)
+
+// TimestampCapabilities are the receive timestamping features of an
+// interface
+type TimestampCapabilities struct {
+	// Hardware is set when the NIC can timestamp every received frame
+	Hardware bool `json:"hardware"`
+	// Software is set when the kernel can timestamp received frames
+	Software bool `json:"software"`
+	// PHCIndex is the index of the PTP hardware clock of the NIC, or -1
+	PHCIndex int `json:"phc_index"`
+}
+
+// ProbeTimestamping returns the receive timestamping capabilities of the
+// named interface
+func ProbeTimestamping(iface string) (TimestampCapabilities, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return TimestampCapabilities{}, fmt.Errorf("failed opening ethtool socket: %w", err)
+	}
+
+	defer unix.Close(fd) //nolint:errcheck // ignoring close error on query socket
+
+	info, err := unix.IoctlGetEthtoolTsInfo(fd, iface)
+	if err != nil {
+		// drivers without ETHTOOL_GET_TS_INFO still get software
+		// timestamps from the core network stack
+		if errors.Is(err, unix.EOPNOTSUPP) {
+			return TimestampCapabilities{Software: true, PHCIndex: -1}, nil
+		}
+
+		return TimestampCapabilities{}, fmt.Errorf("failed reading timestamping capabilities of %s: %w", iface, err)
+	}
+
+	return timestampCapabilities(info), nil
+}
+
+func timestampCapabilities(info *unix.EthtoolTsInfo) TimestampCapabilities {
+	return TimestampCapabilities{
+		// a NIC only timestamping PTP frames is of no use for capture
+		Hardware: info.So_timestamping&hardwareTimestamping == hardwareTimestamping &&
+			info.Rx_filters&(1<<unix.HWTSTAMP_FILTER_ALL) != 0,
+		Software: info.So_timestamping&unix.SOF_TIMESTAMPING_RX_SOFTWARE != 0,
+		PHCIndex: int(info.Phc_index),
+	}
+}
+
+// setTimestamping enables receive timestamps on the socket, hardware ones
+// when they were requested and the NIC supports them
+func (c *Conn) setTimestamping(fd int) error {
+	flags := softwareTimestamping
+
+	if c.cfg.hwTimestamp {
+		if err := enableHardwareTimestamps(fd, c.iface.Name); err != nil {
+			log.Warn().Err(err).Str("iface", c.iface.Name).
+				Msg("Hardware timestamping is not available, using software timestamps")
+		} else {
+			flags |= hardwareTimestamping
+			c.hwTimestamps = true
+		}
+	}
+
+	err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags)
+	if err == nil {
+		return nil
+	}
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1); err != nil {
+		return fmt.Errorf("failed to enable timestamps: %w", err)
+	}
+
+	return nil
+}
+
+// enableHardwareTimestamps makes the NIC timestamp all received frames. A
+// filter already configured, for instance by a PTP daemon, is left alone:
+// the frames it doesn't match get software timestamps.
+func enableHardwareTimestamps(fd int, iface string) error {
+	caps, err := ProbeTimestamping(iface)
+	if err != nil {
+		return err
+	}
+
+	if !caps.Hardware {
+		return fmt.Errorf("%s can't timestamp all received frames: %w", iface, unix.EOPNOTSUPP)
+	}
+
+	cfg, err := unix.IoctlGetHwTstamp(fd, iface)
+	if err != nil {
+		return fmt.Errorf("failed reading hardware timestamping configuration: %w", err)
+	}
+
+	if cfg.Rx_filter != unix.HWTSTAMP_FILTER_NONE {
+		return nil
+	}
+
+	cfg.Rx_filter = unix.HWTSTAMP_FILTER_ALL
+
+	if err := unix.IoctlSetHwTstamp(fd, iface, cfg); err != nil {
+		return fmt.Errorf("failed enabling hardware timestamping: %w", err)
+	}
+
+	return nil
+}
+
+// parseTimestamping reads the timestamps of a SCM_TIMESTAMPING control
+// message: software, deprecated and raw hardware
+func parseTimestamping(md *Metadata, data []byte) {
+	software, _ := hostorder.Timespec(data)
+	hardware, _ := hostorder.Timespec(data[2*hostorder.SizeofTimespec:])
+
+	switch {
+	case hardware.UnixNano() != 0:
+		md.Timestamp = hardware
+		md.TimestampSource = TimestampHardware
+	case software.UnixNano() != 0:
+		md.Timestamp = software
+		md.TimestampSource = TimestampSoftware
+	}
+}
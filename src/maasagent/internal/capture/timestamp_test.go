@@ -0,0 +1,103 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package capture
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestTimestampCapabilities(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  unix.EthtoolTsInfo
+		out TimestampCapabilities
+	}{
+		"software only": {
+			in: unix.EthtoolTsInfo{
+				So_timestamping: softwareTimestamping,
+				Phc_index:       -1,
+			},
+			out: TimestampCapabilities{Software: true, PHCIndex: -1},
+		},
+		"hardware": {
+			in: unix.EthtoolTsInfo{
+				So_timestamping: softwareTimestamping | hardwareTimestamping,
+				Rx_filters:      1<<unix.HWTSTAMP_FILTER_NONE | 1<<unix.HWTSTAMP_FILTER_ALL,
+				Phc_index:       0,
+			},
+			out: TimestampCapabilities{Hardware: true, Software: true, PHCIndex: 0},
+		},
+		"PTP frames only": {
+			in: unix.EthtoolTsInfo{
+				So_timestamping: softwareTimestamping | hardwareTimestamping,
+				Rx_filters:      1<<unix.HWTSTAMP_FILTER_NONE | 1<<unix.HWTSTAMP_FILTER_PTP_V2_EVENT,
+				Phc_index:       1,
+			},
+			out: TimestampCapabilities{Software: true, PHCIndex: 1},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, timestampCapabilities(&tc.in))
+		})
+	}
+}
+
+func TestHardwareTimestampsFallback(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	caps, err := ProbeTimestamping(iface)
+	require.NoError(t, err)
+
+	r, err := Listen(iface, WithFilter(testFilter(t)), WithHardwareTimestamps())
+	require.NoError(t, err)
+
+	defer r.Close() //nolint:errcheck // test cleanup
+
+	w, err := Listen(iface, WithProtocol(testEthertype))
+	require.NoError(t, err)
+
+	defer w.Close() //nolint:errcheck // test cleanup
+
+	require.NoError(t, w.WriteFrame(testFrame("timestamp")))
+	require.NoError(t, r.SetReadDeadline(time.Now().Add(time.Second)))
+
+	md, err := r.ReadFrameMetadata(make([]byte, 64))
+	require.NoError(t, err)
+
+	if caps.Hardware {
+		assert.Contains(t, []TimestampSource{TimestampHardware, TimestampSoftware}, md.TimestampSource)
+	} else {
+		assert.Equal(t, TimestampSoftware, md.TimestampSource)
+	}
+
+	assert.WithinDuration(t, time.Now(), md.Timestamp, time.Second)
+}
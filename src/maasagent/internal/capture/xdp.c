@@ -0,0 +1,159 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_endian.h>
+#include <bpf/bpf_helpers.h>
+
+#define ETH_PROTO_IP 0x0800
+#define ETH_PROTO_ARP 0x0806
+#define ETH_PROTO_VLAN 0x8100
+#define ETH_PROTO_QINQ 0x88A8
+#define ETH_PROTO_IPV6 0x86DD
+#define ETH_PROTO_LLDP 0x88CC
+#define IP_PROTO_UDP 0x11
+#define IP_PROTO_ICMPV6 0x3A
+#define DHCP_SERVER_PORT 67
+#define DHCP_CLIENT_PORT 68
+#define DHCPV6_CLIENT_PORT 546
+#define DHCPV6_SERVER_PORT 547
+// NDP uses ICMPv6 types 133 (router solicitation) to 137 (redirect)
+#define NDP_TYPE_MIN 133
+#define NDP_TYPE_MAX 137
+#define MAX_QUEUES 64
+
+enum counter {
+    COUNTER_FILTERED,
+    COUNTER_DELIVERED,
+    COUNTER_MAX,
+};
+
+// drop_unmatched is rewritten before loading, when set frames which are not
+// redirected are dropped in the driver instead of being passed to the stack
+volatile const bool drop_unmatched = false;
+
+struct {
+    __uint(type, BPF_MAP_TYPE_XSKMAP);
+    __uint(max_entries, MAX_QUEUES);
+    __type(key, __u32);
+    __type(value, __u32);
+} xsks SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, COUNTER_MAX);
+    __type(key, __u32);
+    __type(value, __u64);
+} counters SEC(".maps");
+
+static __always_inline void count(__u32 key) {
+    __u64 *value = bpf_map_lookup_elem(&counters, &key);
+    if (value) {
+        *value += 1;
+    }
+}
+
+static __always_inline int is_dhcp(__u16 port) {
+    return port == bpf_htons(DHCP_SERVER_PORT) || port == bpf_htons(DHCP_CLIENT_PORT);
+}
+
+static __always_inline int is_dhcpv6(__u16 port) {
+    return port == bpf_htons(DHCPV6_SERVER_PORT) || port == bpf_htons(DHCPV6_CLIENT_PORT);
+}
+
+// interesting returns 1 for the ARP, NDP, DHCP and LLDP frames the agent
+// observes, with at most one VLAN tag
+static __always_inline int interesting(struct xdp_md *ctx) {
+    void *data_end = (void *)(long)ctx->data_end;
+    void *data = (void *)(long)ctx->data;
+
+    struct ethhdr *eth = data;
+    if ((void *)(eth + 1) > data_end) {
+        return 0;
+    }
+
+    __u16 proto = eth->h_proto;
+    void *l3 = (void *)(eth + 1);
+
+    if (proto == bpf_htons(ETH_PROTO_VLAN) || proto == bpf_htons(ETH_PROTO_QINQ)) {
+        struct vlan_hdr *vlan = l3;
+        if ((void *)(vlan + 1) > data_end) {
+            return 0;
+        }
+
+        proto = vlan->h_vlan_encapsulated_proto;
+        l3 = (void *)(vlan + 1);
+    }
+
+    if (proto == bpf_htons(ETH_PROTO_ARP) || proto == bpf_htons(ETH_PROTO_LLDP)) {
+        return 1;
+    }
+
+    if (proto == bpf_htons(ETH_PROTO_IP)) {
+        struct iphdr *ip = l3;
+        if ((void *)(ip + 1) > data_end) {
+            return 0;
+        }
+
+        if (ip->protocol != IP_PROTO_UDP || ip->ihl < 5) {
+            return 0;
+        }
+
+        struct udphdr *udp = l3 + ip->ihl * 4;
+        if ((void *)(udp + 1) > data_end) {
+            return 0;
+        }
+
+        return is_dhcp(udp->dest);
+    }
+
+    if (proto == bpf_htons(ETH_PROTO_IPV6)) {
+        struct ipv6hdr *ip6 = l3;
+        if ((void *)(ip6 + 1) > data_end) {
+            return 0;
+        }
+
+        // extension headers are not followed, neither NDP nor DHCPv6
+        // messages are expected to carry any
+        if (ip6->nexthdr == IP_PROTO_ICMPV6) {
+            struct icmp6hdr *icmp6 = (void *)(ip6 + 1);
+            if ((void *)(icmp6 + 1) > data_end) {
+                return 0;
+            }
+
+            return icmp6->icmp6_type >= NDP_TYPE_MIN && icmp6->icmp6_type <= NDP_TYPE_MAX;
+        }
+
+        if (ip6->nexthdr == IP_PROTO_UDP) {
+            struct udphdr *udp = (void *)(ip6 + 1);
+            if ((void *)(udp + 1) > data_end) {
+                return 0;
+            }
+
+            return is_dhcpv6(udp->dest);
+        }
+    }
+
+    return 0;
+}
+
+SEC("xdp")
+int xdp_capture_func(struct xdp_md *ctx) {
+    int fallback = drop_unmatched ? XDP_DROP : XDP_PASS;
+
+    if (!interesting(ctx)) {
+        count(COUNTER_FILTERED);
+        return fallback;
+    }
+
+    // frames arriving on a queue without a socket are treated as unmatched
+    int action = bpf_redirect_map(&xsks, ctx->rx_queue_index, fallback);
+    if (action == XDP_REDIRECT) {
+        count(COUNTER_DELIVERED);
+    } else {
+        count(COUNTER_FILTERED);
+    }
+
+    return action;
+}
+
+char __license[] SEC("license") = "GPL";
@@ -0,0 +1,347 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build xdp
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
+)
+
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -makebase "$MAKEDIR" -tags xdp bpf xdp.c -- -I../ebpf/include
+
+const (
+	// maxXDPQueues must match MAX_QUEUES in xdp.c
+	maxXDPQueues = 64
+
+	counterFiltered  uint32 = 0
+	counterDelivered uint32 = 1
+)
+
+// XDPConn receives the frames redirected by the XDP pre-filter, with one
+// AF_XDP socket per receive queue of the interface.
+//
+// Frames the program doesn't match are passed to the kernel stack, or
+// dropped in the driver with WithXDPDrop. Only use it on dedicated
+// monitoring ports: while attached, the redirected frames no longer
+// reach the host network stack or other AF_PACKET sockets.
+type XDPConn struct {
+	objs     bpfObjects
+	link     link.Link
+	iface    *net.Interface
+	deadline atomic.Pointer[time.Time]
+	sockets  []*xsk
+	pollfds  []unix.PollFd
+	wake     int
+	// next is the socket the following read starts from, so a busy
+	// queue cannot starve the others
+	next int
+	// mu serialises readers, sockMu protects the sockets from being
+	// released while Stats reads them
+	sockMu    sync.RWMutex
+	closeOnce sync.Once
+	mu        sync.Mutex
+	closed    atomic.Bool
+}
+
+// OpenXDP attaches the pre-filter program to the named interface
+func OpenXDP(iface string, options ...Option) (*XDPConn, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", iface, err)
+	}
+
+	return openXDPConn(ifi, newConfig(options))
+}
+
+func openXDP(ifi *net.Interface, cfg config) (FrameReader, error) {
+	return openXDPConn(ifi, cfg)
+}
+
+func openXDPConn(ifi *net.Interface, cfg config) (*XDPConn, error) {
+	queues, err := rxQueues(ifi.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rlimit.RemoveMemlock(); err != nil {
+		log.Warn().Err(err).Msg("unable to set rlimit, continuing with default")
+	}
+
+	spec, err := loadBpf()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupported, err)
+	}
+
+	if err := spec.Variables["drop_unmatched"].Set(cfg.xdpDrop); err != nil {
+		return nil, err
+	}
+
+	c := &XDPConn{iface: ifi, wake: -1}
+
+	if err := c.setup(spec, queues); err != nil {
+		c.Close() //nolint:errcheck,gosec // already returning the setup error
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *XDPConn) setup(spec *ebpf.CollectionSpec, queues int) error {
+	var err error
+
+	if err = spec.LoadAndAssign(&c.objs, nil); err != nil {
+		return fmt.Errorf("%w: failed loading XDP program: %w", ErrUnsupported, err)
+	}
+
+	c.wake, err = unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("failed creating eventfd: %w", err)
+	}
+
+	// sockets are bound and registered before the program is attached,
+	// so no matching frame is passed up while the queues are set up
+	for q := range queues {
+		s, err := newXSK(c.iface.Index, q)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrUnsupported, err)
+		}
+
+		c.sockets = append(c.sockets, s)
+		c.pollfds = append(c.pollfds, unix.PollFd{Fd: int32(s.fd), Events: unix.POLLIN}) //nolint:gosec // fds fit in int32
+
+		if err := c.objs.Xsks.Put(uint32(q), uint32(s.fd)); err != nil { //nolint:gosec // fds are positive
+			return fmt.Errorf("failed registering queue %d: %w", q, err)
+		}
+	}
+
+	c.pollfds = append(c.pollfds, unix.PollFd{Fd: int32(c.wake), Events: unix.POLLIN}) //nolint:gosec // fds fit in int32
+
+	c.link, err = link.AttachXDP(link.XDPOptions{
+		Program:   c.objs.XdpCaptureFunc,
+		Interface: c.iface.Index,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: failed attaching XDP program to %s: %w", ErrUnsupported, c.iface.Name, err)
+	}
+
+	return nil
+}
+
+// Interface returns the interface the program is attached to
+func (c *XDPConn) Interface() *net.Interface {
+	return c.iface
+}
+
+// ReadFrame reads a single redirected frame into buf, frames larger than
+// buf are truncated
+func (c *XDPConn) ReadFrame(buf []byte) (int, error) {
+	md, err := c.ReadFrameMetadata(buf)
+
+	return md.CaptureLength, err
+}
+
+// ReadFrameMetadata reads a single redirected frame into buf together with
+// its metadata. AF_XDP provides no kernel timestamp, frames are stamped
+// when they are read.
+func (c *XDPConn) ReadFrameMetadata(buf []byte) (Metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		if c.closed.Load() {
+			return Metadata{}, ErrClosed
+		}
+
+		for i := range c.sockets {
+			s := c.sockets[(c.next+i)%len(c.sockets)]
+
+			if n, length, ok := s.read(buf); ok {
+				c.next = (c.next + i + 1) % len(c.sockets)
+
+				return Metadata{
+					Timestamp:       time.Now(),
+					TimestampSource: TimestampWallClock,
+					Interface:       c.iface.Name,
+					Ifindex:         c.iface.Index,
+					CaptureLength:   n,
+					Length:          length,
+					Direction:       DirectionInbound,
+					PacketType:      packetType(buf[:n], c.iface.HardwareAddr),
+				}, nil
+			}
+		}
+
+		timeout := -1
+
+		if d := c.deadline.Load(); d != nil && !d.IsZero() {
+			left := time.Until(*d)
+			if left <= 0 {
+				return Metadata{}, os.ErrDeadlineExceeded
+			}
+
+			timeout = int(left.Milliseconds()) + 1
+		}
+
+		_, err := unix.Poll(c.pollfds, timeout)
+		if err != nil && !errors.Is(err, unix.EINTR) {
+			return Metadata{}, fmt.Errorf("failed polling %s: %w", c.iface.Name, err)
+		}
+
+		if c.pollfds[len(c.pollfds)-1].Revents&unix.POLLIN != 0 {
+			var b [8]byte
+
+			_, _ = unix.Read(c.wake, b[:])
+		}
+	}
+}
+
+// SetReadDeadline sets the deadline for future ReadFrame calls, a pending
+// ReadFrame picks up the new deadline
+func (c *XDPConn) SetReadDeadline(t time.Time) error {
+	if c.closed.Load() {
+		return ErrClosed
+	}
+
+	c.deadline.Store(&t)
+
+	return c.notify()
+}
+
+// Stats returns the in-kernel counters of the pre-filter
+func (c *XDPConn) Stats() (XDPStats, error) {
+	var (
+		stats  XDPStats
+		values []uint64
+	)
+
+	if err := c.objs.Counters.Lookup(counterFiltered, &values); err != nil {
+		return stats, err
+	}
+
+	for _, v := range values {
+		stats.Filtered += v
+	}
+
+	if err := c.objs.Counters.Lookup(counterDelivered, &values); err != nil {
+		return stats, err
+	}
+
+	for _, v := range values {
+		stats.Delivered += v
+	}
+
+	c.sockMu.RLock()
+	defer c.sockMu.RUnlock()
+
+	for _, s := range c.sockets {
+		dropped, err := s.dropped()
+		if err != nil {
+			return stats, err
+		}
+
+		stats.Dropped += dropped
+	}
+
+	return stats, nil
+}
+
+// Close detaches the program from the interface and releases the sockets
+func (c *XDPConn) Close() error {
+	var errs []error
+
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+
+		// detach first, so frames flow to the kernel stack again
+		// before the sockets go away
+		if c.link != nil {
+			errs = append(errs, c.link.Close())
+		}
+
+		if c.wake >= 0 {
+			errs = append(errs, c.notify())
+		}
+
+		// wait for a pending ReadFrame to notice the close
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.sockMu.Lock()
+		defer c.sockMu.Unlock()
+
+		for _, s := range c.sockets {
+			errs = append(errs, s.close())
+		}
+
+		c.sockets = nil
+
+		if c.wake >= 0 {
+			errs = append(errs, unix.Close(c.wake))
+		}
+
+		errs = append(errs, c.objs.Close())
+	})
+
+	return errors.Join(errs...)
+}
+
+func (c *XDPConn) notify() error {
+	var b [8]byte
+
+	hostorder.PutUint64(b[:], 1)
+
+	_, err := unix.Write(c.wake, b[:])
+	if errors.Is(err, unix.EAGAIN) {
+		return nil
+	}
+
+	return err
+}
+
+// rxQueues returns the number of receive queues of the interface
+func rxQueues(name string) (int, error) {
+	queues, err := filepath.Glob(filepath.Join("/sys/class/net", name, "queues", "rx-*"))
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case len(queues) == 0:
+		return 0, fmt.Errorf("%w: %s has no receive queues", ErrUnsupported, name)
+	case len(queues) > maxXDPQueues:
+		return 0, fmt.Errorf("%w: %s has %d receive queues, at most %d are supported",
+			ErrUnsupported, name, len(queues), maxXDPQueues)
+	}
+
+	return len(queues), nil
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build xdp
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	xskFrameSize  = 2048
+	xskFrameCount = 2048
+	// rings must be a power of two, the fill ring holds every frame
+	// so the kernel never runs out of buffers
+	xskRingSize = xskFrameCount
+
+	sizeofXDPDesc = int(unsafe.Sizeof(unix.XDPDesc{}))
+	sizeofAddr    = 8
+)
+
+// xskRing is a single producer single consumer ring shared with the kernel
+type xskRing struct {
+	producer *uint32
+	consumer *uint32
+	descs    unsafe.Pointer
+	mem      []byte
+	mask     uint32
+}
+
+func mapRing(fd int, off unix.XDPRingOffset, pgoff int64, size uintptr) (xskRing, error) {
+	//nolint:gosec // ring offsets are small
+	mem, err := unix.Mmap(fd, pgoff, int(off.Desc)+xskRingSize*int(size),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return xskRing{}, fmt.Errorf("failed mapping ring: %w", err)
+	}
+
+	return xskRing{
+		producer: (*uint32)(unsafe.Pointer(&mem[off.Producer])),
+		consumer: (*uint32)(unsafe.Pointer(&mem[off.Consumer])),
+		descs:    unsafe.Pointer(&mem[off.Desc]),
+		mem:      mem,
+		mask:     xskRingSize - 1,
+	}, nil
+}
+
+// xsk is an AF_XDP socket receiving from a single queue, with its own UMEM
+type xsk struct {
+	umem  []byte
+	fill  xskRing
+	rx    xskRing
+	fd    int
+	queue int
+}
+
+func newXSK(ifindex, queue int) (*xsk, error) {
+	fd, err := unix.Socket(unix.AF_XDP, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening AF_XDP socket: %w", err)
+	}
+
+	s := &xsk{fd: fd, queue: queue}
+
+	if err := s.setup(ifindex); err != nil {
+		s.close() //nolint:errcheck,gosec // already returning the setup error
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *xsk) setup(ifindex int) error {
+	var err error
+
+	s.umem, err = unix.Mmap(-1, 0, xskFrameSize*xskFrameCount,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return fmt.Errorf("failed allocating UMEM: %w", err)
+	}
+
+	reg := unix.XDPUmemReg{
+		Addr: uint64(uintptr(unsafe.Pointer(&s.umem[0]))),
+		Len:  uint64(len(s.umem)),
+		Size: xskFrameSize,
+	}
+
+	if err = setsockopt(s.fd, unix.XDP_UMEM_REG, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return fmt.Errorf("failed registering UMEM: %w", err)
+	}
+
+	// the completion ring is only used for transmit, but the kernel
+	// refuses to bind without one
+	for _, opt := range []int{unix.XDP_UMEM_FILL_RING, unix.XDP_UMEM_COMPLETION_RING, unix.XDP_RX_RING} {
+		if err = unix.SetsockoptInt(s.fd, unix.SOL_XDP, opt, xskRingSize); err != nil {
+			return fmt.Errorf("failed sizing rings: %w", err)
+		}
+	}
+
+	var off unix.XDPMmapOffsets
+
+	if err = getsockopt(s.fd, unix.XDP_MMAP_OFFSETS, unsafe.Pointer(&off), unsafe.Sizeof(off)); err != nil {
+		return fmt.Errorf("failed reading ring offsets: %w", err)
+	}
+
+	if s.fill, err = mapRing(s.fd, off.Fr, unix.XDP_UMEM_PGOFF_FILL_RING, sizeofAddr); err != nil {
+		return err
+	}
+
+	if s.rx, err = mapRing(s.fd, off.Rx, unix.XDP_PGOFF_RX_RING, uintptr(sizeofXDPDesc)); err != nil {
+		return err
+	}
+
+	// hand every frame to the kernel
+	for i := range uint32(xskFrameCount) {
+		*s.fill.addr(i) = uint64(i) * xskFrameSize
+	}
+
+	atomic.StoreUint32(s.fill.producer, xskFrameCount)
+
+	sa := &unix.SockaddrXDP{
+		Ifindex: uint32(ifindex), //nolint:gosec // ifindex is positive
+		QueueID: uint32(s.queue), //nolint:gosec // queue is positive
+	}
+
+	if err = unix.Bind(s.fd, sa); err != nil {
+		return fmt.Errorf("failed binding to queue %d: %w", s.queue, err)
+	}
+
+	return nil
+}
+
+func (r *xskRing) addr(i uint32) *uint64 {
+	return (*uint64)(unsafe.Add(r.descs, uintptr(i&r.mask)*sizeofAddr))
+}
+
+func (r *xskRing) desc(i uint32) *unix.XDPDesc {
+	return (*unix.XDPDesc)(unsafe.Add(r.descs, uintptr(i&r.mask)*uintptr(sizeofXDPDesc)))
+}
+
+// read copies the next received frame into buf and returns its buffer to
+// the fill ring. It returns the captured and original lengths, and false
+// when the ring is empty.
+func (s *xsk) read(buf []byte) (int, int, bool) {
+	cons := atomic.LoadUint32(s.rx.consumer)
+	if cons == atomic.LoadUint32(s.rx.producer) {
+		return 0, 0, false
+	}
+
+	d := s.rx.desc(cons)
+	n := copy(buf, s.umem[d.Addr:d.Addr+uint64(d.Len)])
+	// in aligned mode the address may point past the headroom, the
+	// kernel only needs any address within the chunk
+	addr := d.Addr &^ (xskFrameSize - 1)
+
+	atomic.StoreUint32(s.rx.consumer, cons+1)
+
+	prod := atomic.LoadUint32(s.fill.producer)
+	*s.fill.addr(prod) = addr
+
+	atomic.StoreUint32(s.fill.producer, prod+1)
+
+	return n, int(d.Len), true
+}
+
+// dropped returns the frames the kernel couldn't deliver to the socket
+func (s *xsk) dropped() (uint64, error) {
+	var stats unix.XDPStatistics
+
+	if err := getsockopt(s.fd, unix.XDP_STATISTICS, unsafe.Pointer(&stats), unsafe.Sizeof(stats)); err != nil {
+		return 0, fmt.Errorf("failed reading statistics of queue %d: %w", s.queue, err)
+	}
+
+	return stats.Rx_dropped + stats.Rx_ring_full, nil
+}
+
+func (s *xsk) close() error {
+	errs := []error{unix.Close(s.fd)}
+
+	for _, mem := range [][]byte{s.rx.mem, s.fill.mem, s.umem} {
+		if mem != nil {
+			errs = append(errs, unix.Munmap(mem))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func setsockopt(fd, opt int, val unsafe.Pointer, size uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, uintptr(fd), unix.SOL_XDP, uintptr(opt),
+		uintptr(val), size, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func getsockopt(fd, opt int, val unsafe.Pointer, size uintptr) error {
+	l := uint32(size)
+
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.SOL_XDP, uintptr(opt),
+		uintptr(val), uintptr(unsafe.Pointer(&l)), 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
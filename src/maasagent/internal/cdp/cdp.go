@@ -0,0 +1,281 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package cdp decodes the packets of the Cisco Discovery Protocol, which
+// the Cisco switches send alongside LLDP, or instead of it, to tell the
+// port an interface is connected to
+package cdp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+const (
+	// headerLen is the version, the TTL and the checksum
+	headerLen    = 4
+	tlvHeaderLen = 4
+	// maxTLVs bounds the TLVs of a packet, which fits in a frame
+	maxTLVs = 512
+
+	ethernetHeaderLen = 14
+	// snapLen is the LLC and SNAP headers of an 802.3 frame
+	snapLen = 8
+	// maxLength is the largest length of an 802.3 frame, the type field
+	// of the others is an ethertype
+	maxLength = 1500
+)
+
+var (
+	// ErrMalformedPacket is returned when a CDP packet or one of its TLVs
+	// is truncated
+	ErrMalformedPacket = errors.New("malformed CDP packet")
+	// ErrNotCDP is returned by ParseFrame for frames of another protocol
+	ErrNotCDP = errors.New("ethernet frame not of protocol CDP")
+)
+
+var (
+	// Multicast is the destination MAC of the CDP frames
+	Multicast = net.HardwareAddr{0x01, 0x00, 0x0c, 0xcc, 0xcc, 0xcc}
+	// snapHeader is the LLC header of SNAP, the Cisco OUI and the
+	// protocol ID of CDP
+	snapHeader = []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00}
+	// ipv6Protocol is the 802.2 protocol of an IPv6 address of the
+	// Addresses TLV
+	ipv6Protocol = []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0x00, 0x86, 0xdd}
+)
+
+// TLVType is the type of a TLV
+type TLVType uint16
+
+// The TLVs decoded
+const (
+	TLVDeviceID            TLVType = 0x0001
+	TLVAddresses           TLVType = 0x0002
+	TLVPortID              TLVType = 0x0003
+	TLVCapabilities        TLVType = 0x0004
+	TLVSoftwareVersion     TLVType = 0x0005
+	TLVPlatform            TLVType = 0x0006
+	TLVVTPDomain           TLVType = 0x0009
+	TLVNativeVLAN          TLVType = 0x000a
+	TLVDuplex              TLVType = 0x000b
+	TLVManagementAddresses TLVType = 0x0016
+)
+
+// Duplex is the duplex of the port
+type Duplex uint8
+
+const (
+	// DuplexUnknown is the Duplex of a packet without the TLV
+	DuplexUnknown Duplex = iota
+	// DuplexHalf is the Duplex of a half duplex port
+	DuplexHalf
+	// DuplexFull is the Duplex of a full duplex port
+	DuplexFull
+)
+
+// String returns the name of the Duplex
+func (d Duplex) String() string {
+	switch d {
+	case DuplexHalf:
+		return "half"
+	case DuplexFull:
+		return "full"
+	default:
+		return "unknown"
+	}
+}
+
+// Packet is a CDP packet, with its TLVs decoded. The TLVs not decoded are
+// skipped.
+type Packet struct {
+	// DeviceID identifies the switch, usually by its host name
+	DeviceID string
+	// PortID is the name of the port, such as GigabitEthernet1/0/12
+	PortID          string
+	Platform        string
+	SoftwareVersion string
+	VTPDomain       string
+	// Addresses are the addresses of the switch, ManagementAddresses those
+	// it is managed through, only IPv4 and IPv6 are decoded
+	Addresses           []netip.Addr
+	ManagementAddresses []netip.Addr
+	// Capabilities are the bits of the Capabilities TLV, 0x08 for a
+	// switch and 0x01 for a router
+	Capabilities uint32
+	// NativeVLAN is the untagged VLAN of the port, 0 when the switch
+	// doesn't tell
+	NativeVLAN uint16
+	Duplex     Duplex
+	Version    uint8
+	// TTL is the number of seconds the information is valid
+	TTL uint8
+}
+
+// UnmarshalBinary parses a CDP packet, the payload of the SNAP header
+func (p *Packet) UnmarshalBinary(buf []byte) error {
+	if len(buf) < headerLen {
+		return fmt.Errorf("%w: truncated header", ErrMalformedPacket)
+	}
+
+	*p = Packet{Version: buf[0], TTL: buf[1]}
+	buf = buf[headerLen:]
+
+	for n := 0; len(buf) > 0; n++ {
+		if n == maxTLVs {
+			return fmt.Errorf("%w: more than %d TLVs", ErrMalformedPacket, maxTLVs)
+		}
+
+		if len(buf) < tlvHeaderLen {
+			return fmt.Errorf("%w: truncated TLV header", ErrMalformedPacket)
+		}
+
+		typ := TLVType(binary.BigEndian.Uint16(buf))
+		length := int(binary.BigEndian.Uint16(buf[2:]))
+
+		if length < tlvHeaderLen || length > len(buf) {
+			return fmt.Errorf("%w: TLV %d of length %d", ErrMalformedPacket, typ, length)
+		}
+
+		if err := p.decode(typ, buf[tlvHeaderLen:length]); err != nil {
+			return err
+		}
+
+		buf = buf[length:]
+	}
+
+	return nil
+}
+
+// decode decodes the TLV of typ into p
+func (p *Packet) decode(typ TLVType, value []byte) error {
+	var err error
+
+	switch typ {
+	case TLVDeviceID:
+		p.DeviceID = string(value)
+	case TLVPortID:
+		p.PortID = string(value)
+	case TLVPlatform:
+		p.Platform = string(value)
+	case TLVSoftwareVersion:
+		p.SoftwareVersion = string(value)
+	case TLVVTPDomain:
+		p.VTPDomain = string(value)
+	case TLVAddresses:
+		p.Addresses, err = decodeAddresses(value)
+	case TLVManagementAddresses:
+		p.ManagementAddresses, err = decodeAddresses(value)
+	case TLVCapabilities:
+		if len(value) < 4 {
+			return fmt.Errorf("%w: truncated capabilities", ErrMalformedPacket)
+		}
+
+		p.Capabilities = binary.BigEndian.Uint32(value)
+	case TLVNativeVLAN:
+		if len(value) < 2 {
+			return fmt.Errorf("%w: truncated native VLAN", ErrMalformedPacket)
+		}
+
+		p.NativeVLAN = binary.BigEndian.Uint16(value)
+	case TLVDuplex:
+		if len(value) < 1 {
+			return fmt.Errorf("%w: truncated duplex", ErrMalformedPacket)
+		}
+
+		p.Duplex = DuplexHalf
+		if value[0] == 1 {
+			p.Duplex = DuplexFull
+		}
+	}
+
+	return err
+}
+
+// decodeAddresses decodes the IPv4 and IPv6 addresses of an Addresses TLV,
+// the addresses of the other protocols are skipped
+func decodeAddresses(value []byte) ([]netip.Addr, error) {
+	if len(value) < 4 {
+		return nil, fmt.Errorf("%w: truncated addresses", ErrMalformedPacket)
+	}
+
+	count := binary.BigEndian.Uint32(value)
+	value = value[4:]
+
+	var addrs []netip.Addr
+
+	for range min(count, maxTLVs) {
+		if len(value) < 2 || len(value) < 2+int(value[1])+2 {
+			return nil, fmt.Errorf("%w: truncated address", ErrMalformedPacket)
+		}
+
+		protocolType, protocol := value[0], value[2:2+int(value[1])]
+		value = value[2+len(protocol):]
+
+		n := int(binary.BigEndian.Uint16(value))
+		if len(value) < 2+n {
+			return nil, fmt.Errorf("%w: truncated address", ErrMalformedPacket)
+		}
+
+		addr, ok := netip.AddrFromSlice(value[2 : 2+n])
+		value = value[2+n:]
+
+		// the NLPID of IP is 0xcc
+		if ok && (protocolType == 1 && bytes.Equal(protocol, []byte{0xcc}) && addr.Is4() ||
+			protocolType == 2 && bytes.Equal(protocol, ipv6Protocol) && addr.Is6()) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs, nil
+}
+
+// ParseFrame returns the CDP packet of an 802.3 frame, after any VLAN tags,
+// or ErrNotCDP for a frame of another protocol
+func ParseFrame(frame []byte) (Packet, error) {
+	if len(frame) < ethernetHeaderLen || !bytes.Equal(frame[:6], Multicast) {
+		return Packet{}, ErrNotCDP
+	}
+
+	off := 12
+	length := binary.BigEndian.Uint16(frame[off:])
+
+	for (length == 0x8100 || length == 0x88a8) && len(frame) >= off+6 {
+		off += 4
+		length = binary.BigEndian.Uint16(frame[off:])
+	}
+
+	payload := frame[off+2:]
+	if length > maxLength || length < snapLen || len(payload) < snapLen || !bytes.Equal(payload[:snapLen], snapHeader) {
+		return Packet{}, ErrNotCDP
+	}
+
+	// the frame may be padded beyond its length
+	if int(length) < len(payload) {
+		payload = payload[:length]
+	}
+
+	var p Packet
+
+	if err := p.UnmarshalBinary(payload[snapLen:]); err != nil {
+		return Packet{}, err
+	}
+
+	return p, nil
+}
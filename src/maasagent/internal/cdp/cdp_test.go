@@ -0,0 +1,159 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cdp
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTLV(typ TLVType, value ...byte) []byte {
+	tlv := make([]byte, tlvHeaderLen, tlvHeaderLen+len(value))
+	binary.BigEndian.PutUint16(tlv, uint16(typ))
+	binary.BigEndian.PutUint16(tlv[2:], uint16(tlvHeaderLen+len(value))) //nolint:gosec // test TLVs are small
+
+	return append(tlv, value...)
+}
+
+func testPacket(tlvs ...[]byte) []byte {
+	pkt := []byte{0x02, 0xb4, 0x00, 0x00}
+	pkt = append(pkt, testTLV(TLVDeviceID, []byte("sw1.example.com")...)...)
+	pkt = append(pkt, testTLV(TLVPortID, []byte("GigabitEthernet1/0/12")...)...)
+
+	for _, tlv := range tlvs {
+		pkt = append(pkt, tlv...)
+	}
+
+	return pkt
+}
+
+// testFrame returns the 802.3 frame of pkt, tagged with the tags given
+func testFrame(pkt []byte, tags ...uint16) []byte {
+	frame := append([]byte{}, Multicast...)
+	frame = append(frame, 0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x01)
+
+	for _, vid := range tags {
+		frame = append(frame, 0x81, 0x00, byte(vid>>8), byte(vid))
+	}
+
+	frame = binary.BigEndian.AppendUint16(frame, uint16(snapLen+len(pkt))) //nolint:gosec // test packets are small
+	frame = append(frame, snapHeader...)
+
+	return append(frame, pkt...)
+}
+
+func TestParseFrame(t *testing.T) {
+	t.Parallel()
+
+	addresses := []byte{0x00, 0x00, 0x00, 0x03,
+		// 10.0.0.2
+		0x01, 0x01, 0xcc, 0x00, 0x04, 10, 0, 0, 2,
+		// 2001:db8::2
+		0x02, 0x08, 0xaa, 0xaa, 0x03, 0x00, 0x00, 0x00, 0x86, 0xdd, 0x00, 0x10,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
+		// an address of another protocol
+		0x01, 0x01, 0x81, 0x00, 0x01, 0x01,
+	}
+
+	testcases := map[string]struct {
+		in  []byte
+		out Packet
+		err error
+	}{
+		"mandatory": {
+			in:  testFrame(testPacket()),
+			out: Packet{Version: 2, TTL: 180, DeviceID: "sw1.example.com", PortID: "GigabitEthernet1/0/12"},
+		},
+		"decoded TLVs": {
+			in: testFrame(testPacket(
+				testTLV(TLVAddresses, addresses...),
+				testTLV(TLVCapabilities, 0x00, 0x00, 0x00, 0x28),
+				testTLV(TLVSoftwareVersion, []byte("IOS 15.2")...),
+				testTLV(TLVPlatform, []byte("cisco WS-C2960X")...),
+				testTLV(TLVVTPDomain, []byte("maas")...),
+				testTLV(TLVNativeVLAN, 0x00, 0x64),
+				testTLV(TLVDuplex, 0x01),
+				testTLV(0x0100, 0xff),
+			), 100),
+			out: Packet{
+				Version:         2,
+				TTL:             180,
+				DeviceID:        "sw1.example.com",
+				PortID:          "GigabitEthernet1/0/12",
+				Addresses:       []netip.Addr{netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("2001:db8::2")},
+				Capabilities:    0x28,
+				SoftwareVersion: "IOS 15.2",
+				Platform:        "cisco WS-C2960X",
+				VTPDomain:       "maas",
+				NativeVLAN:      100,
+				Duplex:          DuplexFull,
+			},
+		},
+		"padded": {
+			in:  append(testFrame(testPacket()), 0, 0, 0, 0),
+			out: Packet{Version: 2, TTL: 180, DeviceID: "sw1.example.com", PortID: "GigabitEthernet1/0/12"},
+		},
+		"not CDP": {
+			in:  append([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 1, 0x08, 0x06}, make([]byte, 28)...),
+			err: ErrNotCDP,
+		},
+		"other SNAP protocol": {
+			in:  append(testFrame(nil)[:20], 0x20, 0x04),
+			err: ErrNotCDP,
+		},
+		"truncated TLV": {
+			in:  testFrame(append(testPacket(), 0x00, 0x06, 0x00, 0x10)),
+			err: ErrMalformedPacket,
+		},
+		"truncated native VLAN": {
+			in:  testFrame(testPacket(testTLV(TLVNativeVLAN, 0x00))),
+			err: ErrMalformedPacket,
+		},
+		"truncated addresses": {
+			in:  testFrame(testPacket(testTLV(TLVAddresses, addresses[:12]...))),
+			err: ErrMalformedPacket,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := ParseFrame(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, p)
+		})
+	}
+}
+
+func TestDuplexString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "full", DuplexFull.String())
+	assert.Equal(t, "half", DuplexHalf.String())
+	assert.Equal(t, "unknown", DuplexUnknown.String())
+}
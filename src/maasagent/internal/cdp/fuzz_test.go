@@ -0,0 +1,41 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cdp
+
+import (
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+func FuzzParseFrame(f *testing.F) {
+	for _, seed := range [][]byte{
+		testFrame(testPacket()),
+		testFrame(testPacket(
+			testTLV(TLVAddresses, 0x00, 0x00, 0x00, 0x01, 0x01, 0x01, 0xcc, 0x00, 0x04, 10, 0, 0, 2),
+			testTLV(TLVNativeVLAN, 0x00, 0x64),
+		), 100),
+		testFrame(nil),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			_, _ = ParseFrame(in)
+		})
+	})
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package checksum computes and verifies the internet checksum of the
+// transport protocols carried by IPv4 and IPv6, RFC 1071
+package checksum
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// ProtocolTCP is the IP protocol number of TCP
+	ProtocolTCP = 6
+	// ProtocolUDP is the IP protocol number of UDP
+	ProtocolUDP = 17
+	// ProtocolICMPv6 is the IP protocol number of ICMPv6, whose checksum
+	// also covers a pseudo-header
+	ProtocolICMPv6 = 58
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	// unset is the UDP checksum of a sender which didn't compute one
+	unset = 0
+)
+
+var (
+	// ErrMalformed is returned when the headers are too short
+	ErrMalformed = errors.New("malformed packet")
+	// ErrUnsupported is returned for a protocol without a pseudo-header
+	// checksum, or an IPv6 header followed by extension headers
+	ErrUnsupported = errors.New("unsupported protocol")
+	// ErrMismatch is returned by Verify when the checksum is wrong
+	ErrMismatch = errors.New("checksum mismatch")
+	// ErrMissing is returned by Verify for an IPv6 UDP datagram without a
+	// checksum, which is mandatory over IPv6
+	ErrMissing = errors.New("checksum missing")
+)
+
+// Sum returns the internet checksum of the parts as if they were
+// concatenated, a sum over data including a correct checksum is 0
+func Sum(parts ...[]byte) uint16 {
+	var (
+		sum uint32
+		odd bool
+	)
+
+	for _, p := range parts {
+		// a byte left over from the previous part is the high byte of
+		// this 16 bit word
+		if odd && len(p) > 0 {
+			sum += uint32(p[0])
+			p = p[1:]
+			odd = false
+		}
+
+		for ; len(p) >= 2; p = p[2:] {
+			sum += uint32(binary.BigEndian.Uint16(p))
+		}
+
+		if len(p) == 1 {
+			// a final odd byte is padded with zero
+			sum += uint32(p[0]) << 8
+			odd = true
+		}
+	}
+
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum) //nolint:gosec // folded to 16 bits
+}
+
+// Compute returns the checksum of segment, a TCP segment or UDP datagram
+// with its payload or an ICMPv6 message, carried by the IPv4 or IPv6 header
+// ipHeader. The checksum field of segment is ignored. The IPv6 header must
+// be directly followed by the segment.
+func Compute(ipHeader, segment []byte) (uint16, error) {
+	protocol, off, err := parse(ipHeader, segment)
+	if err != nil {
+		return 0, err
+	}
+
+	var buf [ipv6HeaderLen]byte
+
+	sum := Sum(pseudoHeader(&buf, ipHeader, protocol, len(segment)), segment[:off], segment[off+2:])
+
+	// zero means unset for UDP, the same sum is sent as all ones
+	if protocol == ProtocolUDP && sum == unset {
+		sum = 0xffff
+	}
+
+	return sum, nil
+}
+
+// Verify checks the checksum of segment like Compute. A UDP datagram over
+// IPv4 without a checksum is accepted, as its sender didn't compute one.
+func Verify(ipHeader, segment []byte) error {
+	protocol, off, err := parse(ipHeader, segment)
+	if err != nil {
+		return err
+	}
+
+	if protocol == ProtocolUDP && binary.BigEndian.Uint16(segment[off:]) == unset {
+		if ipHeader[0]>>4 == 4 {
+			return nil
+		}
+
+		return ErrMissing
+	}
+
+	var buf [ipv6HeaderLen]byte
+
+	if Sum(pseudoHeader(&buf, ipHeader, protocol, len(segment)), segment) != 0 {
+		return ErrMismatch
+	}
+
+	return nil
+}
+
+// parse returns the protocol of the segment and the offset of its checksum
+func parse(ipHeader, segment []byte) (uint8, int, error) {
+	var protocol uint8
+
+	switch {
+	case len(ipHeader) >= ipv4HeaderLen && ipHeader[0]>>4 == 4:
+		protocol = ipHeader[9]
+	case len(ipHeader) >= ipv6HeaderLen && ipHeader[0]>>4 == 6:
+		protocol = ipHeader[6]
+	default:
+		return 0, 0, fmt.Errorf("%w: IP header of %d bytes", ErrMalformed, len(ipHeader))
+	}
+
+	var off int
+
+	switch protocol {
+	case ProtocolTCP:
+		off = 16
+	case ProtocolUDP:
+		off = 6
+	case ProtocolICMPv6:
+		off = 2
+	default:
+		return 0, 0, fmt.Errorf("%w: %d", ErrUnsupported, protocol)
+	}
+
+	if len(segment) < off+2 {
+		return 0, 0, fmt.Errorf("%w: segment of %d bytes", ErrMalformed, len(segment))
+	}
+
+	return protocol, off, nil
+}
+
+// pseudoHeader returns the part of the IP header covered by the checksum,
+// in buf
+func pseudoHeader(buf *[ipv6HeaderLen]byte, ipHeader []byte, protocol uint8, length int) []byte {
+	if ipHeader[0]>>4 == 4 {
+		hdr := buf[:12]
+		copy(hdr, ipHeader[12:20])
+		hdr[8] = 0
+		hdr[9] = protocol
+		binary.BigEndian.PutUint16(hdr[10:], uint16(length)) //nolint:gosec // bounded by the IP length fields
+
+		return hdr
+	}
+
+	hdr := buf[:]
+	copy(hdr, ipHeader[8:40])
+	binary.BigEndian.PutUint32(hdr[32:], uint32(length)) //nolint:gosec // bounded by the IP length fields
+	hdr[36], hdr[37], hdr[38] = 0, 0, 0
+	hdr[39] = protocol
+
+	return hdr
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package checksum
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/testing/alloc"
+)
+
+func TestSum(t *testing.T) {
+	t.Parallel()
+
+	// the example of RFC 1071 section 3
+	data := []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}
+
+	testcases := map[string]struct {
+		parts [][]byte
+		out   uint16
+	}{
+		"RFC 1071": {
+			parts: [][]byte{data},
+			out:   ^uint16(0xddf2),
+		},
+		"split on a word": {
+			parts: [][]byte{data[:2], data[2:]},
+			out:   ^uint16(0xddf2),
+		},
+		"split within a word": {
+			parts: [][]byte{data[:3], data[3:5], {}, data[5:]},
+			out:   ^uint16(0xddf2),
+		},
+		"odd length": {
+			parts: [][]byte{{0x01, 0x02, 0x03}},
+			out:   ^uint16(0x0102 + 0x0300),
+		},
+		"empty": {
+			out: 0xffff,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, Sum(tc.parts...))
+		})
+	}
+}
+
+// serialize builds a packet with gopacket, which computes the checksums
+// independently, and returns its IP header and transport segment
+func serialize(t *testing.T, ip gopacket.NetworkLayer, transport gopacket.SerializableLayer, payload []byte) ([]byte, []byte) {
+	t.Helper()
+
+	switch l := transport.(type) {
+	case *layers.UDP:
+		require.NoError(t, l.SetNetworkLayerForChecksum(ip))
+	case *layers.TCP:
+		require.NoError(t, l.SetNetworkLayerForChecksum(ip))
+	case *layers.ICMPv6:
+		require.NoError(t, l.SetNetworkLayerForChecksum(ip))
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+
+	require.NoError(t, gopacket.SerializeLayers(buf, opts,
+		ip.(gopacket.SerializableLayer), transport, gopacket.Payload(payload)))
+
+	pkt := buf.Bytes()
+	hdrLen := ipv6HeaderLen
+
+	if pkt[0]>>4 == 4 {
+		hdrLen = int(pkt[0]&0x0f) * 4
+	}
+
+	return pkt[:hdrLen], pkt[hdrLen:]
+}
+
+func TestComputeVerify(t *testing.T) {
+	t.Parallel()
+
+	v4 := func(protocol layers.IPProtocol) *layers.IPv4 {
+		return &layers.IPv4{Version: 4, TTL: 64, Protocol: protocol,
+			SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	}
+	v6 := func(protocol layers.IPProtocol) *layers.IPv6 {
+		return &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: protocol,
+			SrcIP: net.ParseIP("fe80::1"), DstIP: net.ParseIP("ff02::1:2")}
+	}
+	tcp := func() *layers.TCP {
+		return &layers.TCP{SrcPort: 40000, DstPort: 53, Seq: 1, SYN: true, Window: 64240}
+	}
+
+	testcases := map[string]struct {
+		ip        gopacket.NetworkLayer
+		transport gopacket.SerializableLayer
+		payload   []byte
+	}{
+		"UDP over IPv4": {
+			ip:        v4(layers.IPProtocolUDP),
+			transport: &layers.UDP{SrcPort: 68, DstPort: 67},
+			payload:   []byte("discover"),
+		},
+		"UDP over IPv4 odd length": {
+			ip:        v4(layers.IPProtocolUDP),
+			transport: &layers.UDP{SrcPort: 5353, DstPort: 5353},
+			payload:   []byte("odd"),
+		},
+		"UDP over IPv4 with options": {
+			ip: &layers.IPv4{Version: 4, TTL: 1, Protocol: layers.IPProtocolUDP,
+				SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{224, 0, 0, 251},
+				Options: []layers.IPv4Option{{OptionType: 148, OptionLength: 4, OptionData: []byte{0, 0}}}},
+			transport: &layers.UDP{SrcPort: 5353, DstPort: 5353},
+			payload:   []byte("query"),
+		},
+		"UDP over IPv6": {
+			ip:        v6(layers.IPProtocolUDP),
+			transport: &layers.UDP{SrcPort: 546, DstPort: 547},
+			payload:   []byte("solicit"),
+		},
+		"TCP over IPv4": {
+			ip:        v4(layers.IPProtocolTCP),
+			transport: tcp(),
+		},
+		"TCP over IPv6 odd length": {
+			ip:        v6(layers.IPProtocolTCP),
+			transport: tcp(),
+			payload:   []byte{0x01, 0x02, 0x03},
+		},
+		"ICMPv6": {
+			ip:        v6(layers.IPProtocolICMPv6),
+			transport: &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0)},
+			payload:   make([]byte, 20),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			hdr, segment := serialize(t, tc.ip, tc.transport, tc.payload)
+			off := map[uint8]int{ProtocolTCP: 16, ProtocolUDP: 6, ProtocolICMPv6: 2}[pick(hdr)]
+			want := binary.BigEndian.Uint16(segment[off:])
+
+			require.NoError(t, Verify(hdr, segment))
+
+			got, err := Compute(hdr, segment)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			segment[len(segment)-1] ^= 0xff
+			assert.ErrorIs(t, Verify(hdr, segment), ErrMismatch)
+		})
+	}
+}
+
+func pick(ipHeader []byte) uint8 {
+	if ipHeader[0]>>4 == 4 {
+		return ipHeader[9]
+	}
+
+	return ipHeader[6]
+}
+
+func TestUnsetUDPChecksum(t *testing.T) {
+	t.Parallel()
+
+	hdr4, udp4 := serialize(t, &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}, &layers.UDP{SrcPort: 1, DstPort: 2}, []byte("x"))
+	hdr6, udp6 := serialize(t, &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("fe80::1"), DstIP: net.ParseIP("fe80::2")}, &layers.UDP{SrcPort: 1, DstPort: 2}, []byte("x"))
+
+	clear(udp4[6:8])
+	clear(udp6[6:8])
+
+	assert.NoError(t, Verify(hdr4, udp4), "IPv4 senders may leave the checksum unset")
+	assert.ErrorIs(t, Verify(hdr6, udp6), ErrMissing)
+}
+
+func TestComputeZeroSum(t *testing.T) {
+	t.Parallel()
+
+	hdr, udp := serialize(t, &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}, &layers.UDP{SrcPort: 1, DstPort: 2}, []byte{0, 0})
+
+	// choose the payload so that the datagram sums to 0xffff
+	sum, err := Compute(hdr, udp)
+	require.NoError(t, err)
+	binary.BigEndian.PutUint16(udp[8:], sum)
+
+	sum, err = Compute(hdr, udp)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0xffff), sum, "a zero UDP checksum must be sent as all ones")
+
+	binary.BigEndian.PutUint16(udp[6:], sum)
+	assert.NoError(t, Verify(hdr, udp))
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+
+	ipv4 := func(protocol uint8) []byte {
+		return []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 64, protocol, 0, 0, 10, 0, 0, 1, 10, 0, 0, 2}
+	}
+
+	testcases := map[string]struct {
+		hdr     []byte
+		segment []byte
+		err     error
+	}{
+		"short IP header": {
+			hdr:     ipv4(ProtocolUDP)[:19],
+			segment: make([]byte, 8),
+			err:     ErrMalformed,
+		},
+		"not IP": {
+			hdr:     make([]byte, 40),
+			segment: make([]byte, 8),
+			err:     ErrMalformed,
+		},
+		"short UDP header": {
+			hdr:     ipv4(ProtocolUDP),
+			segment: make([]byte, 7),
+			err:     ErrMalformed,
+		},
+		"short TCP header": {
+			hdr:     ipv4(ProtocolTCP),
+			segment: make([]byte, 17),
+			err:     ErrMalformed,
+		},
+		"ICMP": {
+			hdr:     ipv4(1),
+			segment: make([]byte, 8),
+			err:     ErrUnsupported,
+		},
+		"IPv6 extension header": {
+			hdr:     append([]byte{0x60, 0, 0, 0, 0, 8, 0, 64}, make([]byte, 32)...),
+			segment: make([]byte, 8),
+			err:     ErrUnsupported,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Compute(tc.hdr, tc.segment)
+			assert.ErrorIs(t, err, tc.err)
+			assert.ErrorIs(t, Verify(tc.hdr, tc.segment), tc.err)
+		})
+	}
+}
+
+func TestVerifyAllocations(t *testing.T) {
+	hdr, udp := serialize(t, &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("fe80::1"), DstIP: net.ParseIP("ff02::fb")}, &layers.UDP{SrcPort: 5353, DstPort: 5353},
+		make([]byte, 512))
+
+	alloc.Budget(t, "checksum.Verify", 0, func() {
+		_ = Verify(hdr, udp)
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package clock abstracts the passing of time, so the code expiring entries
+// or acting periodically can be driven by a fake clock in tests
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time and creates the timers and tickers
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	// Sleep waits for d to pass and returns ctx.Err() when ctx is done first
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// Timer is a time.Timer whose channel is returned by C
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker whose channel is returned by C
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// System is the Clock of the host
+type System struct{}
+
+// Now returns time.Now()
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer returns a time.Timer
+func (System) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+// NewTicker returns a time.Ticker
+func (System) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+// Sleep pauses for d or until ctx is done
+func (c System) Sleep(ctx context.Context, d time.Duration) error {
+	return Sleep(ctx, c, d)
+}
+
+// Sleep waits on a timer of c, it implements Clock.Sleep for any Clock
+func Sleep(ctx context.Context, c Clock, d time.Duration) error {
+	t := c.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C():
+		return nil
+	}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
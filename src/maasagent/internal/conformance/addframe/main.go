@@ -0,0 +1,190 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Command addframe adds the frames of a pcap file to the conformance
+// corpus, recording what the decoders currently parse as the expected
+// result. The result is printed so it can be checked before committing:
+//
+//	go run ./internal/conformance/addframe -pcap lldp.pcap -frame 2 \
+//		-name lldp-switch -description "LLDPDU of a ToR switch" -anonymize
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/conformance"
+)
+
+const maxFrameLen = 65536
+
+func Run() int {
+	pcapPath := flag.String("pcap", "", "pcap file to read the frames from")
+	manifestPath := flag.String("manifest", "internal/conformance/testdata/manifest.json", "manifest of the corpus")
+	name := flag.String("name", "", "name of the frame, suffixed with its index when adding several")
+	description := flag.String("description", "", "what the frame is and where it comes from")
+	index := flag.Int("frame", 0, "index of the frame to add from 1, all frames are added when 0")
+	anonymize := flag.Bool("anonymize", false, "replace the unicast MAC addresses with locally administered ones")
+
+	flag.Parse()
+
+	if *pcapPath == "" || *name == "" {
+		flag.Usage()
+		return 2
+	}
+
+	frames, err := readFrames(*pcapPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *index > 0 {
+		if *index > len(frames) {
+			fmt.Fprintf(os.Stderr, "%s has %d frames\n", *pcapPath, len(frames))
+			return 1
+		}
+
+		frames = frames[*index-1 : *index]
+	}
+
+	if *anonymize {
+		anonymizeMACs(frames)
+	}
+
+	entries, err := add(*manifestPath, *name, *description, frames)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(string(out))
+
+	return 0
+}
+
+func readFrames(path string) ([][]byte, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := capture.NewPcapReader(f, "")
+	if err != nil {
+		return nil, errors.Join(err, f.Close())
+	}
+	//nolint:errcheck // the file is only read
+	defer r.Close()
+
+	var frames [][]byte
+
+	buf := make([]byte, maxFrameLen)
+
+	for {
+		n, err := r.ReadFrame(buf)
+		if errors.Is(err, io.EOF) {
+			return frames, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed reading %s: %w", path, err)
+		}
+
+		frames = append(frames, slices.Clone(buf[:n]))
+	}
+}
+
+// anonymizeMACs replaces every occurrence of the unicast addresses found in
+// the ethernet headers, such as the sender of an ARP packet, consistently
+// across the frames. IP addresses are kept.
+func anonymizeMACs(frames [][]byte) {
+	var seen [][]byte
+
+	for _, frame := range frames {
+		for off := 0; off+6 <= min(len(frame), 12); off += 6 {
+			mac := frame[off : off+6]
+			if mac[0]&0x01 == 0 && !slices.ContainsFunc(seen, func(m []byte) bool { return string(m) == string(mac) }) {
+				seen = append(seen, slices.Clone(mac))
+			}
+		}
+	}
+
+	for i, mac := range seen {
+		anon := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, byte(i >> 8), byte(i + 1)}
+
+		for _, frame := range frames {
+			for off := 0; off+6 <= len(frame); off++ {
+				if string(frame[off:off+6]) == string(mac) {
+					copy(frame[off:], anon)
+				}
+			}
+		}
+	}
+}
+
+func add(manifestPath, name, description string, frames [][]byte) ([]conformance.Entry, error) {
+	m, err := conformance.LoadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]conformance.Entry, 0, len(frames))
+
+	for i, frame := range frames {
+		entry := conformance.Entry{
+			Name:        name,
+			Description: description,
+			Expected:    conformance.Decode(frame),
+		}
+
+		if len(frames) > 1 {
+			entry.Name = fmt.Sprintf("%s-%d", name, i+1)
+		}
+
+		if slices.ContainsFunc(m.Entries, func(e conformance.Entry) bool { return e.Name == entry.Name }) {
+			return nil, fmt.Errorf("%s is already in the corpus", entry.Name)
+		}
+
+		entry.File = filepath.Join(conformance.CorpusDir, entry.Name+".hex")
+
+		if err := conformance.WriteFrame(filepath.Join(filepath.Dir(manifestPath), entry.File), frame); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	m.Entries = append(m.Entries, entries...)
+
+	return entries, m.Save(manifestPath)
+}
+
+func main() {
+	os.Exit(Run())
+}
@@ -0,0 +1,587 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package conformance runs the frame decoders against a corpus of frames
+// and records what they parsed, the golden results of the corpus are kept
+// in testdata/manifest.json.
+//
+// Frames are added from a pcap file with the addframe command. After a
+// change to a decoder, the expected results are refreshed with
+//
+//	go test ./internal/conformance -run TestConformance -update
+//
+// and the diff of the manifest is part of the review.
+package conformance
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/fhrp"
+	"maas.io/core/src/maasagent/internal/lldp"
+	"maas.io/core/src/maasagent/internal/mld"
+	"maas.io/core/src/maasagent/internal/ptp"
+)
+
+const (
+	// CorpusDir holds a file per frame, relative to the manifest
+	CorpusDir = "corpus"
+
+	hexBytesPerLine = 16
+)
+
+// Entry is a frame of the corpus and the result expected from the decoders
+type Entry struct {
+	Expected    Result `json:"expected"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// File is the hex dump of the frame, relative to the manifest
+	File string `json:"file"`
+}
+
+// Manifest lists the frames of the corpus
+type Manifest struct {
+	Entries []Entry `json:"entries"`
+}
+
+// LoadManifest reads the manifest at path
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed parsing %s: %w", path, err)
+	}
+
+	return m, nil
+}
+
+// Save writes the manifest to path, indented so changes to the expected
+// results are reviewable
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// ReadFrame reads a hex dump written by WriteFrame, whitespace is ignored
+func ReadFrame(path string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	frame, err := hex.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding %s: %w", path, err)
+	}
+
+	return frame, nil
+}
+
+// WriteFrame writes frame to path as a hex dump of 16 bytes per line
+func WriteFrame(path string, frame []byte) error {
+	var b strings.Builder
+
+	for len(frame) > 0 {
+		n := min(len(frame), hexBytesPerLine)
+		b.WriteString(hex.EncodeToString(frame[:n]))
+		b.WriteByte('\n')
+		frame = frame[n:]
+	}
+
+	return os.WriteFile(path, []byte(b.String()), 0o600)
+}
+
+// Result is what the decoders parsed from a frame. A decoder finding a
+// frame of another protocol leaves its field empty, other decoding errors
+// are recorded in Errors by decoder name.
+type Result struct {
+	Errors   map[string]string `json:"errors,omitempty"`
+	Ethernet *Ethernet         `json:"ethernet,omitempty"`
+	VLAN     *VLAN             `json:"vlan,omitempty"`
+	ARP      *ARP              `json:"arp,omitempty"`
+	LACP     *LACP             `json:"lacp,omitempty"`
+	CFM      *CFM              `json:"cfm,omitempty"`
+	LLDP     *LLDP             `json:"lldp,omitempty"`
+	MLD      *MLD              `json:"mld,omitempty"`
+	PTP      *PTP              `json:"ptp,omitempty"`
+	HSRP     *HSRP             `json:"hsrp,omitempty"`
+	GLBP     *GLBP             `json:"glbp,omitempty"`
+	// Layer is what the decoder registered for the ethertype or the UDP
+	// port of the frame returned, none of the built-in types has one
+	Layer ethernet.Layer `json:"layer,omitempty"`
+}
+
+// Ethernet is the ethernet header of a frame
+type Ethernet struct {
+	Dst        string `json:"dst"`
+	Src        string `json:"src"`
+	Ethertype  string `json:"ethertype"`
+	PayloadLen int    `json:"payload_len"`
+	// Len is the length field of an 802.3 frame
+	Len uint16 `json:"len,omitempty"`
+}
+
+// VLAN is the outer VLAN tag of a frame
+type VLAN struct {
+	Ethertype    string `json:"ethertype"`
+	ID           uint16 `json:"id"`
+	Priority     uint8  `json:"priority"`
+	DropEligible bool   `json:"drop_eligible"`
+}
+
+// ARP is an ARP packet and the outcome of its strict validation
+type ARP struct {
+	SenderMAC string `json:"sender_mac"`
+	SenderIP  string `json:"sender_ip"`
+	TargetMAC string `json:"target_mac"`
+	TargetIP  string `json:"target_ip"`
+	Invalid   string `json:"invalid,omitempty"`
+	Op        uint16 `json:"op"`
+}
+
+// LACPPort is an actor or partner of an LACPDU
+type LACPPort struct {
+	System         string `json:"system"`
+	State          string `json:"state"`
+	SystemPriority uint16 `json:"system_priority"`
+	Key            uint16 `json:"key"`
+	PortPriority   uint16 `json:"port_priority"`
+	Port           uint16 `json:"port"`
+}
+
+// LACP is an LACPDU
+type LACP struct {
+	Actor   LACPPort `json:"actor"`
+	Partner LACPPort `json:"partner"`
+	Version uint8    `json:"version"`
+}
+
+// CFM is a CFM PDU
+type CFM struct {
+	OpCode string `json:"op_code"`
+	MEPID  uint16 `json:"mep_id"`
+	Level  uint8  `json:"level"`
+	Flags  uint8  `json:"flags"`
+}
+
+// LLDPOrg is an organizationally specific TLV the LLDP decoder left raw
+type LLDPOrg struct {
+	OUI     string `json:"oui"`
+	Info    string `json:"info"`
+	Subtype uint8  `json:"subtype"`
+}
+
+// LLDP is an LLDPDU and the 802.1 and 802.3 TLVs decoded
+type LLDP struct {
+	Chassis         string    `json:"chassis"`
+	Port            string    `json:"port"`
+	PortDescription string    `json:"port_description,omitempty"`
+	SystemName      string    `json:"system_name,omitempty"`
+	VLANs           []string  `json:"vlans,omitempty"`
+	Unknown         []LLDPOrg `json:"unknown,omitempty"`
+	TTL             uint16    `json:"ttl"`
+	PortVLAN        uint16    `json:"port_vlan,omitempty"`
+	MaxFrameSize    uint16    `json:"max_frame_size,omitempty"`
+}
+
+// MLDRecord is an address record of an MLDv2 report
+type MLDRecord struct {
+	Multicast string   `json:"multicast"`
+	Sources   []string `json:"sources,omitempty"`
+	Type      uint8    `json:"type"`
+}
+
+// MLD is an MLD message
+type MLD struct {
+	Type             string      `json:"type"`
+	Multicast        string      `json:"multicast,omitempty"`
+	MaxResponseDelay string      `json:"max_response_delay,omitempty"`
+	Records          []MLDRecord `json:"records,omitempty"`
+	Version          uint8       `json:"version"`
+}
+
+// PTP is the header of a PTP message, and the grandmaster of an announce
+type PTP struct {
+	Transport   string `json:"transport"`
+	MessageType string `json:"message_type"`
+	Source      string `json:"source"`
+	Grandmaster string `json:"grandmaster,omitempty"`
+	Sequence    uint16 `json:"sequence"`
+	Domain      uint8  `json:"domain"`
+	Version     uint8  `json:"version"`
+}
+
+// HSRP is an HSRP message
+type HSRP struct {
+	VirtualIP  string `json:"virtual_ip,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+	OpCode     string `json:"op_code"`
+	State      string `json:"state"`
+	HelloTime  string `json:"hello_time"`
+	HoldTime   string `json:"hold_time"`
+	Priority   uint32 `json:"priority"`
+	Group      uint16 `json:"group"`
+	Version    uint8  `json:"version"`
+}
+
+// GLBP is a GLBP message
+type GLBP struct {
+	VirtualIP  string   `json:"virtual_ip,omitempty"`
+	Owner      string   `json:"owner"`
+	State      string   `json:"state"`
+	Forwarders []string `json:"forwarders,omitempty"`
+	Group      uint16   `json:"group"`
+	Priority   uint8    `json:"priority"`
+}
+
+// Decode runs every decoder against frame
+func Decode(frame []byte) Result {
+	return DecodeWith(frame, ethernet.ParserOptions{})
+}
+
+// DecodeWith runs every decoder against frame with opts, the decoders with
+// no decision left to the options decode as Decode does
+func DecodeWith(frame []byte, opts ethernet.ParserOptions) Result {
+	r := Result{Errors: make(map[string]string)}
+
+	decodeEthernet(&r, frame, opts)
+	decodeLLDP(&r, frame, opts)
+	decodeMLD(&r, frame, opts)
+	decodePTP(&r, frame)
+	decodeFHRP(&r, frame)
+	decodeLayer(&r, frame)
+
+	if len(r.Errors) == 0 {
+		r.Errors = nil
+	}
+
+	return r
+}
+
+func decodeEthernet(r *Result, frame []byte, opts ethernet.ParserOptions) {
+	eth := &ethernet.EthernetFrame{}
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		r.Errors["ethernet"] = err.Error()
+		return
+	}
+
+	r.Ethernet = &Ethernet{
+		Dst:        eth.DstMAC.String(),
+		Src:        eth.SrcMAC.String(),
+		Ethertype:  eth.EthernetType.String(),
+		Len:        eth.Len,
+		PayloadLen: len(eth.Payload),
+	}
+
+	ethertype := eth.EthernetType
+
+	if eth.EthernetType == ethernet.EthernetTypeVLAN {
+		vlan, err := eth.ExtractVLANWith(opts)
+		if err != nil {
+			r.Errors["vlan"] = err.Error()
+			return
+		}
+
+		r.VLAN = &VLAN{
+			ID:           vlan.ID,
+			Priority:     vlan.Priority,
+			DropEligible: vlan.DropEligible,
+			Ethertype:    vlan.EthernetType.String(),
+		}
+		ethertype = vlan.EthernetType
+	}
+
+	// a frame of another type is not an error of the ARP decoder
+	if ethertype == ethernet.EthernetTypeARP {
+		decodeARP(r, eth, opts)
+	}
+
+	if lacp, err := eth.ExtractLACP(); err == nil {
+		r.LACP = &LACP{Actor: lacpPort(lacp.Actor), Partner: lacpPort(lacp.Partner), Version: lacp.Version}
+	} else if !errors.Is(err, ethernet.ErrNotLACP) {
+		r.Errors["lacp"] = err.Error()
+	}
+
+	if cfm, err := eth.ExtractCFM(); err == nil {
+		r.CFM = &CFM{OpCode: cfm.OpCode.String(), MEPID: cfm.MEPID, Level: cfm.Level, Flags: cfm.Flags}
+	} else if !errors.Is(err, ethernet.ErrNotCFM) {
+		r.Errors["cfm"] = err.Error()
+	}
+}
+
+// decodeLayer decodes what the registered decoders handle, after the
+// built-in protocols
+func decodeLayer(r *Result, frame []byte) {
+	if r.Ethernet == nil {
+		return
+	}
+
+	layer, err := ethernet.DecodeLayer(frame)
+	if err != nil {
+		if !errors.Is(err, ethernet.ErrNotRegistered) {
+			r.Errors["layer"] = err.Error()
+		}
+
+		return
+	}
+
+	r.Layer = layer
+}
+
+func decodeARP(r *Result, eth *ethernet.EthernetFrame, opts ethernet.ParserOptions) {
+	// the packets whose protocol addresses aren't IP addresses are reported
+	// invalid rather than failing to decode
+	pkt, err := eth.ExtractARPPacket(ethernet.WithParserOptions(opts), ethernet.WithOpaqueProtocolAddrs())
+	if err != nil {
+		r.Errors["arp"] = err.Error()
+		return
+	}
+
+	r.ARP = &ARP{
+		Op:        pkt.OpCode,
+		SenderMAC: pkt.SendHwAddr.String(),
+		SenderIP:  pkt.SenderAddr().String(),
+		TargetMAC: pkt.TgtHwAddr.String(),
+		TargetIP:  pkt.TargetAddr().String(),
+	}
+
+	if err := pkt.Validate(ethernet.ValidationStrict, eth); err != nil {
+		r.ARP.Invalid = err.Error()
+	}
+}
+
+func lacpPort(p ethernet.LACPPortInfo) LACPPort {
+	return LACPPort{
+		System:         p.System.String(),
+		State:          p.State.String(),
+		SystemPriority: p.SystemPriority,
+		Key:            p.Key,
+		PortPriority:   p.PortPriority,
+		Port:           p.Port,
+	}
+}
+
+func decodeLLDP(r *Result, frame []byte, opts ethernet.ParserOptions) {
+	d, err := lldp.ParseFrameWith(frame, opts)
+	if errors.Is(err, lldp.ErrNotLLDP) {
+		return
+	} else if err != nil {
+		r.Errors["lldp"] = err.Error()
+		return
+	}
+
+	r.LLDP = &LLDP{
+		Chassis:         d.ChassisID.String(),
+		Port:            d.PortID.String(),
+		PortDescription: d.PortDescription,
+		SystemName:      d.SystemName,
+		TTL:             d.TTL,
+		PortVLAN:        d.PortVLAN,
+		MaxFrameSize:    d.MaxFrameSize,
+	}
+
+	for _, v := range d.VLANNames {
+		r.LLDP.VLANs = append(r.LLDP.VLANs, fmt.Sprintf("%d/%s", v.ID, v.Name))
+	}
+
+	for _, org := range d.Unknown {
+		r.LLDP.Unknown = append(r.LLDP.Unknown, LLDPOrg{
+			OUI:     org.OUI.String(),
+			Subtype: org.Subtype,
+			Info:    hex.EncodeToString(org.Info),
+		})
+	}
+}
+
+func decodeMLD(r *Result, frame []byte, opts ethernet.ParserOptions) {
+	msg, _, err := mld.ParseFrameWith(frame, opts)
+	if errors.Is(err, mld.ErrNotMLD) {
+		return
+	}
+
+	if err != nil {
+		r.Errors["mld"] = err.Error()
+		return
+	}
+
+	r.MLD = &MLD{Type: msg.Type.String(), Version: msg.Version}
+
+	if msg.Multicast.IsValid() {
+		r.MLD.Multicast = msg.Multicast.String()
+	}
+
+	if msg.MaxResponseDelay != 0 {
+		r.MLD.MaxResponseDelay = msg.MaxResponseDelay.String()
+	}
+
+	for _, rec := range msg.Records {
+		out := MLDRecord{Multicast: rec.Multicast.String(), Type: uint8(rec.Type)}
+
+		for _, src := range rec.Sources {
+			out.Sources = append(out.Sources, src.String())
+		}
+
+		r.MLD.Records = append(r.MLD.Records, out)
+	}
+}
+
+var ptpTransports = map[ptp.Transport]string{
+	ptp.TransportEthernet: "ethernet",
+	ptp.TransportUDPv4:    "udp4",
+	ptp.TransportUDPv6:    "udp6",
+}
+
+func decodePTP(r *Result, frame []byte) {
+	msg, transport, ok := ptp.Decapsulate(frame)
+	if !ok {
+		return
+	}
+
+	var hdr ptp.Header
+
+	if err := hdr.UnmarshalBinary(msg); err != nil {
+		r.Errors["ptp"] = err.Error()
+		return
+	}
+
+	r.PTP = &PTP{
+		Transport:   ptpTransports[transport],
+		MessageType: hdr.MessageType.String(),
+		Source:      fmt.Sprintf("%s/%d", hdr.SourcePortIdentity.ClockIdentity, hdr.SourcePortIdentity.PortNumber),
+		Sequence:    hdr.SequenceID,
+		Domain:      hdr.Domain,
+		Version:     hdr.Version,
+	}
+
+	if hdr.MessageType != ptp.MessageAnnounce {
+		return
+	}
+
+	var announce ptp.Announce
+
+	if err := announce.UnmarshalBinary(msg); err != nil {
+		r.Errors["ptp"] = err.Error()
+		return
+	}
+
+	r.PTP.Grandmaster = announce.GrandmasterIdentity.String()
+}
+
+func decodeFHRP(r *Result, frame []byte) {
+	port, payload, ok := udpDatagram(frame)
+	if !ok {
+		return
+	}
+
+	switch port {
+	case fhrp.HSRPPort, fhrp.HSRPv6Port:
+		var h fhrp.HSRP
+
+		if err := h.UnmarshalBinary(payload); err != nil {
+			r.Errors["hsrp"] = err.Error()
+			return
+		}
+
+		r.HSRP = &HSRP{
+			OpCode:    h.OpCode.String(),
+			State:     h.State.String(),
+			HelloTime: h.HelloTime.String(),
+			HoldTime:  h.HoldTime.String(),
+			Priority:  h.Priority,
+			Group:     h.Group,
+			Version:   h.Version,
+		}
+
+		if h.VirtualIP.IsValid() {
+			r.HSRP.VirtualIP = h.VirtualIP.String()
+		}
+
+		if h.Identifier != nil {
+			r.HSRP.Identifier = h.Identifier.String()
+		}
+	case fhrp.GLBPPort:
+		var g fhrp.GLBP
+
+		if err := g.UnmarshalBinary(payload); err != nil {
+			r.Errors["glbp"] = err.Error()
+			return
+		}
+
+		r.GLBP = &GLBP{Owner: g.Owner.String(), State: g.State.String(), Group: g.Group, Priority: g.Priority}
+
+		if g.VirtualIP.IsValid() {
+			r.GLBP.VirtualIP = g.VirtualIP.String()
+		}
+
+		for _, f := range g.Forwarders {
+			r.GLBP.Forwarders = append(r.GLBP.Forwarders, fmt.Sprintf("%d %s %s", f.Number, f.VirtualMAC, f.State))
+		}
+	}
+}
+
+// udpDatagram returns the destination port and payload of an untagged UDP
+// datagram, the FHRP decoders only parse the UDP payload
+func udpDatagram(frame []byte) (uint16, []byte, bool) {
+	if len(frame) < 14 {
+		return 0, nil, false
+	}
+
+	pkt := frame[14:]
+
+	switch binary.BigEndian.Uint16(frame[12:14]) {
+	case uint16(ethernet.EthernetTypeIPv4):
+		if len(pkt) < 20 || pkt[9] != 17 {
+			return 0, nil, false
+		}
+
+		ihl := int(pkt[0]&0x0f) * 4
+		if ihl < 20 || len(pkt) < ihl {
+			return 0, nil, false
+		}
+
+		pkt = pkt[ihl:]
+	case uint16(ethernet.EthernetTypeIPv6):
+		if len(pkt) < 40 || pkt[6] != 17 {
+			return 0, nil, false
+		}
+
+		pkt = pkt[40:]
+	default:
+		return 0, nil, false
+	}
+
+	if len(pkt) < 8 {
+		return 0, nil, false
+	}
+
+	return binary.BigEndian.Uint16(pkt[2:4]), pkt[8:], true
+}
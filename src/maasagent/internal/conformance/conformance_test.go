@@ -0,0 +1,187 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package conformance
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected results of the manifest with the current ones")
+
+var manifestPath = filepath.Join("testdata", "manifest.json")
+
+// TestConformance runs the decoders against every frame of the corpus, a
+// change of parsing behaviour is accepted by running the test with -update
+// and reviewing the diff of the manifest
+func TestConformance(t *testing.T) {
+	m, err := LoadManifest(manifestPath)
+	require.NoError(t, err)
+
+	for i := range m.Entries {
+		entry := &m.Entries[i]
+
+		t.Run(entry.Name, func(t *testing.T) {
+			frame, err := ReadFrame(filepath.Join("testdata", entry.File))
+			require.NoError(t, err)
+
+			got := Decode(frame)
+
+			if *update {
+				entry.Expected = got
+				return
+			}
+
+			expected, err := json.Marshal(entry.Expected)
+			require.NoError(t, err)
+
+			actual, err := json.Marshal(got)
+			require.NoError(t, err)
+
+			assert.JSONEq(t, string(expected), string(actual), entry.Description)
+		})
+	}
+
+	if *update {
+		require.NoError(t, m.Save(manifestPath))
+	}
+}
+
+// TestStrictness runs the decoders against every frame of the corpus at
+// each Strictness, a frame decodes as the manifest expects unless listed
+// with what the Strictness changes
+func TestStrictness(t *testing.T) {
+	t.Parallel()
+
+	m, err := LoadManifest(manifestPath)
+	require.NoError(t, err)
+
+	differs := map[string]map[ethernet.Strictness]func(t *testing.T, r Result){
+		"arp-reserved-vid": {
+			ethernet.StrictnessStrict: func(t *testing.T, r Result) {
+				assert.Contains(t, r.Errors["vlan"], "reserved VLAN ID")
+				assert.Nil(t, r.VLAN)
+				assert.Nil(t, r.ARP)
+			},
+		},
+		"arp-bad-lengths": {
+			ethernet.StrictnessLenient: func(t *testing.T, r Result) {
+				require.NotNil(t, r.ARP)
+				assert.Equal(t, "192.168.10.26", r.ARP.SenderIP)
+				assert.Equal(t, "192.168.10.25", r.ARP.TargetIP)
+				assert.Empty(t, r.ARP.Invalid)
+			},
+			ethernet.StrictnessStrict: func(t *testing.T, r Result) {
+				assert.Contains(t, r.Errors["arp"], "lengths 6 and 0")
+				assert.Nil(t, r.ARP)
+			},
+		},
+		"lldp-reserved-tlv": {
+			ethernet.StrictnessStrict: func(t *testing.T, r Result) {
+				assert.Contains(t, r.Errors["lldp"], "reserved type 20")
+				assert.Nil(t, r.LLDP)
+			},
+		},
+		"lldp-truncated-tlv": {
+			ethernet.StrictnessLenient: func(t *testing.T, r Result) {
+				assert.Empty(t, r.Errors)
+				require.NotNil(t, r.LLDP)
+				assert.Equal(t, "Ethernet1/12", r.LLDP.Port)
+				assert.Empty(t, r.LLDP.PortDescription)
+			},
+		},
+		"mld-bad-checksum": {
+			ethernet.StrictnessStrict: func(t *testing.T, r Result) {
+				assert.Contains(t, r.Errors["mld"], "checksum mismatch")
+				assert.Nil(t, r.MLD)
+			},
+		},
+	}
+
+	for _, entry := range m.Entries {
+		frame, err := ReadFrame(filepath.Join("testdata", entry.File))
+		require.NoError(t, err)
+
+		expected, err := json.Marshal(entry.Expected)
+		require.NoError(t, err)
+
+		for _, s := range []ethernet.Strictness{
+			ethernet.StrictnessLenient, ethernet.StrictnessStandard, ethernet.StrictnessStrict,
+		} {
+			t.Run(entry.Name+"/"+s.String(), func(t *testing.T) {
+				got := DecodeWith(frame, ethernet.ParserOptions{Strictness: s})
+
+				if check, ok := differs[entry.Name][s]; ok {
+					check(t, got)
+					return
+				}
+
+				actual, err := json.Marshal(got)
+				require.NoError(t, err)
+
+				assert.JSONEq(t, string(expected), string(actual), entry.Description)
+			})
+		}
+	}
+}
+
+func TestCorpusListed(t *testing.T) {
+	t.Parallel()
+
+	m, err := LoadManifest(manifestPath)
+	require.NoError(t, err)
+
+	names := make(map[string]bool)
+	listed := make(map[string]bool)
+
+	for _, entry := range m.Entries {
+		assert.False(t, names[entry.Name], "%s is listed twice", entry.Name)
+		names[entry.Name] = true
+		listed[entry.File] = true
+	}
+
+	files, err := os.ReadDir(filepath.Join("testdata", CorpusDir))
+	require.NoError(t, err)
+
+	for _, f := range files {
+		assert.True(t, listed[filepath.Join(CorpusDir, f.Name())], "%s is not in the manifest", f.Name())
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "frame.hex")
+	frame := make([]byte, 37)
+
+	for i := range frame {
+		frame[i] = byte(i * 7)
+	}
+
+	require.NoError(t, WriteFrame(path, frame))
+
+	got, err := ReadFrame(path)
+	require.NoError(t, err)
+	assert.Equal(t, frame, got)
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package debugserver exposes the capture and observation state of the
+// agent as JSON over a local unix socket, for debugging in the field
+// without restarting the agent. Every endpoint is read-only but for
+// triggering a scan, dumping a frame ring and setting the target of a
+// capture, whose filter expressions follow pcap-filter(7).
+package debugserver
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/netif"
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+const (
+	// socketMode restricts the socket to its owner, it reveals the hosts
+	// of the segments
+	socketMode        = 0o600
+	readHeaderTimeout = 5 * time.Second
+	shutdownTimeout   = 5 * time.Second
+)
+
+// Server serves the debug endpoints
+type Server struct {
+	scheduler  *netmon.Scheduler
+	gate       *netmon.TransmitGate
+	events     *EventLog
+	services   map[string]*netmon.Service
+	rings      map[string]*capture.PcapRing
+	preflight  func(iface string) (*netif.Report, error)
+	mux        *http.ServeMux
+	socketPath string
+	// order is the order the services were added in
+	order []string
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithServices exposes the captures and neighbor tables of the services
+func WithServices(services ...*netmon.Service) Option {
+	return func(s *Server) {
+		for _, svc := range services {
+			if _, ok := s.services[svc.Interface()]; !ok {
+				s.order = append(s.order, svc.Interface())
+			}
+
+			s.services[svc.Interface()] = svc
+		}
+	}
+}
+
+// WithScheduler exposes the status of the scan jobs of scheduler, and lets
+// them be triggered
+func WithScheduler(scheduler *netmon.Scheduler) Option {
+	return func(s *Server) {
+		s.scheduler = scheduler
+	}
+}
+
+// WithTransmitGate exposes the mode of the agent held by g, for the
+// operators to tell the active agent from those in standby
+func WithTransmitGate(g *netmon.TransmitGate) Option {
+	return func(s *Server) {
+		s.gate = g
+	}
+}
+
+// WithEventLog exposes the recent events recorded in l
+func WithEventLog(l *EventLog) Option {
+	return func(s *Server) {
+		s.events = l
+	}
+}
+
+// WithRing lets the frames of ring, kept for the interface iface, be dumped
+// to a pcap file
+func WithRing(iface string, ring *capture.PcapRing) Option {
+	return func(s *Server) {
+		s.rings[iface] = ring
+	}
+}
+
+// WithPreflight adds the report of preflight on the interface, such as
+// netif.Preflight, to the manifests of the dumps of its frame ring
+func WithPreflight(preflight func(iface string) (*netif.Report, error)) Option {
+	return func(s *Server) {
+		s.preflight = preflight
+	}
+}
+
+// NewServer returns a Server listening on socketPath once running
+func NewServer(socketPath string, options ...Option) *Server {
+	s := &Server{
+		services:   make(map[string]*netmon.Service),
+		rings:      make(map[string]*capture.PcapRing),
+		mux:        http.NewServeMux(),
+		socketPath: socketPath,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	// the method of every route is explicit, the others are refused with
+	// 405 Method Not Allowed
+	s.mux.HandleFunc("GET /captures", s.handleCaptures)
+	s.mux.HandleFunc("GET /filters", s.handleFilters)
+	s.mux.HandleFunc("PUT /filters/{interface}", s.handleSetTarget)
+	s.mux.HandleFunc("GET /neighbors", s.handleNeighbors)
+	s.mux.HandleFunc("GET /events", s.handleEvents)
+	s.mux.HandleFunc("GET /scans", s.handleScans)
+	s.mux.HandleFunc("POST /scans/{id}/trigger", s.handleTriggerScan)
+	s.mux.HandleFunc("POST /pcap", s.handleDumpPcap)
+	s.mux.HandleFunc("GET /mode", s.handleMode)
+
+	return s
+}
+
+// Handler returns the handler of the endpoints
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Run serves the endpoints on the socket until ctx is done, a stale socket
+// left by a previous run is replaced
+func (s *Server) Run(ctx context.Context) error {
+	if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var lc net.ListenConfig
+
+	l, err := lc.Listen(ctx, "unix", s.socketPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(s.socketPath, socketMode); err != nil {
+		//nolint:errcheck // the chmod error is the one worth returning
+		l.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	errC := make(chan error, 1)
+
+	go func() {
+		errC <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+
+	err = srv.Shutdown(shutdownCtx)
+	<-errC
+
+	return err
+}
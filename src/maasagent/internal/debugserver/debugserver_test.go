@@ -0,0 +1,623 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package debugserver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/netif"
+	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/schema"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+var (
+	testMAC   = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	testOther = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+)
+
+func uint16Pointer(v uint16) *uint16 {
+	return &v
+}
+
+// testServer returns a Server of two services, eth0 having bindings on
+// VLANs 2 and 3, a scan job and a ring of one frame
+func testServer(t *testing.T) *Server {
+	t.Helper()
+
+	eth0 := netmon.NewService("eth0")
+	eth1 := netmon.NewService("eth1")
+	timestamp := time.Unix(1700000000, 0)
+
+	eth0.Observe(netmon.ObservationARPReply, netip.MustParseAddr("10.0.0.1"), testMAC, uint16Pointer(2), timestamp)
+	eth0.Observe(netmon.ObservationARPReply, netip.MustParseAddr("10.0.0.2"), testOther, uint16Pointer(3), timestamp)
+	require.NoError(t, eth0.SetTarget(capture.Target{MACs: []net.HardwareAddr{testMAC}}))
+
+	scheduler := netmon.NewScheduler()
+	require.NoError(t, scheduler.Add(netmon.ScanJob{
+		ID:        "eth0/lan",
+		Interface: "eth0",
+		Targets:   []netip.Prefix{netip.MustParsePrefix("10.0.0.0/30")},
+		Interval:  time.Hour,
+	}))
+
+	events := NewEventLog(2)
+	events.Record("eth0", netmon.Result{IP: "10.0.0.1", Event: netmon.EventNew})
+	events.Record("eth0", netmon.Result{IP: "10.0.0.2", Event: netmon.EventNew})
+	events.Record("eth1", netmon.Result{IP: "10.0.1.1", Event: netmon.EventNew})
+
+	ring := capture.NewPcapRing(4)
+	ring.Add(make([]byte, 60), capture.Metadata{Timestamp: timestamp})
+
+	return NewServer("", WithServices(eth0, eth1), WithScheduler(scheduler),
+		WithEventLog(events), WithRing("eth0", ring))
+}
+
+func do(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+
+	var v T
+
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.Equal(t, strconv.Itoa(schema.Version), rec.Header().Get(schema.Header))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &v))
+
+	return v
+}
+
+func TestReadOnlyEndpoints(t *testing.T) {
+	t.Parallel()
+
+	h := testServer(t).Handler()
+
+	for _, path := range []string{"/captures", "/filters", "/neighbors", "/events", "/scans", "/mode"} {
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			rec := do(t, h, method, path, "{}")
+			assert.Equal(t, http.StatusMethodNotAllowed, rec.Code, method+" "+path)
+		}
+
+		assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, path, "").Code, path)
+	}
+
+	for _, path := range []string{"/scans/eth0%2Flan/trigger", "/pcap", "/filters/eth0"} {
+		assert.Equal(t, http.StatusMethodNotAllowed, do(t, h, http.MethodGet, path, "").Code, path)
+	}
+
+	assert.Equal(t, http.StatusNotFound, do(t, h, http.MethodGet, "/unknown", "").Code)
+}
+
+func TestCaptures(t *testing.T) {
+	t.Parallel()
+
+	rec := do(t, testServer(t).Handler(), http.MethodGet, "/captures", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// the services aren't capturing, there are no counters
+	assert.Equal(t, []Capture{
+		{Interface: "eth0", Decoders: []string{"arp", "ndp", "dhcp"}},
+		{Interface: "eth1", Decoders: []string{"arp", "ndp", "dhcp"}},
+	}, decode[[]Capture](t, rec))
+}
+
+func TestCapturesDegraded(t *testing.T) {
+	t.Parallel()
+
+	caps, err := netmon.DetectCapabilities(func() error { return capture.ErrNoRawAccess })
+	require.NoError(t, err)
+
+	svc := netmon.NewService("eth0", netmon.WithCapabilities(caps))
+
+	rec := do(t, NewServer("", WithServices(svc)).Handler(), http.MethodGet, "/captures", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// the region shows why the data of the interface is partial
+	assert.Contains(t, rec.Body.String(), `"degraded":[{"capability":"CAP_NET_RAW",`+
+		`"error":"raw sockets are not permitted","disabled":["capture","probing"],`+
+		`"running":["kernel_neighbors","ingestion"]}]`)
+	assert.Equal(t, caps.Degraded, decode[[]Capture](t, rec)[0].Degraded)
+}
+
+func TestFilters(t *testing.T) {
+	t.Parallel()
+
+	rec := do(t, testServer(t).Handler(), http.MethodGet, "/filters", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	filters := decode[[]Filter](t, rec)
+	require.Len(t, filters, 2)
+
+	assert.Equal(t, "eth0", filters[0].Interface)
+	assert.Equal(t, Target{MACs: []string{testMAC.String()}, IPs: []string{}}, filters[0].Target)
+	assert.Equal(t, Target{MACs: []string{}, IPs: []string{}}, filters[1].Target)
+	// the target filter runs before the base one
+	assert.Greater(t, len(filters[0].Instructions), len(filters[1].Instructions))
+	assert.NotEmpty(t, filters[1].Instructions)
+	assert.Empty(t, filters[0].Error)
+}
+
+func TestSetTarget(t *testing.T) {
+	t.Parallel()
+
+	h := testServer(t).Handler()
+
+	rec := do(t, h, http.MethodPut, "/filters/eth1",
+		`{"expression":"arp or udp port 67","macs":["00:16:3e:00:00:02"],"ips":["10.0.0.1"]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	f := decode[Filter](t, rec)
+	assert.Equal(t, "eth1", f.Interface)
+	assert.Equal(t, Target{Expression: "arp or udp port 67", MACs: []string{testOther.String()},
+		IPs: []string{"10.0.0.1"}}, f.Target)
+	assert.Contains(t, f.Instructions, "ldx 4*([14]&0xf)", "the expression is compiled")
+	assert.Empty(t, f.Error)
+
+	// the filters list the target set
+	filters := decode[[]Filter](t, do(t, h, http.MethodGet, "/filters", ""))
+	assert.Equal(t, f, filters[1])
+
+	// an empty target observes the whole segment again
+	rec = do(t, h, http.MethodPut, "/filters/eth1", `{}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, Target{MACs: []string{}, IPs: []string{}}, decode[Filter](t, rec).Target)
+
+	testcases := map[string]struct {
+		path string
+		body string
+		code int
+	}{
+		"unknown interface":      {path: "/filters/eth9", body: `{}`, code: http.StatusNotFound},
+		"invalid body":           {path: "/filters/eth1", body: `{"expression":`, code: http.StatusBadRequest},
+		"unknown field":          {path: "/filters/eth1", body: `{"hosts":[]}`, code: http.StatusBadRequest},
+		"invalid MAC":            {path: "/filters/eth1", body: `{"macs":["00:16:3e"]}`, code: http.StatusBadRequest},
+		"invalid IP":             {path: "/filters/eth1", body: `{"ips":["10.0.0"]}`, code: http.StatusBadRequest},
+		"invalid expression":     {path: "/filters/eth1", body: `{"expression":"arp and"}`, code: http.StatusBadRequest},
+		"unsupported expression": {path: "/filters/eth1", body: `{"expression":"icmp"}`, code: http.StatusBadRequest},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := do(t, h, http.MethodPut, tc.path, tc.body)
+			assert.Equal(t, tc.code, rec.Code)
+			assert.NotEmpty(t, decode[errorResponse](t, rec).Error)
+		})
+	}
+
+	// the failed requests left the target
+	filters = decode[[]Filter](t, do(t, h, http.MethodGet, "/filters", ""))
+	assert.Equal(t, Target{MACs: []string{}, IPs: []string{}}, filters[1].Target)
+}
+
+func TestNeighbors(t *testing.T) {
+	t.Parallel()
+
+	h := testServer(t).Handler()
+
+	testcases := map[string]struct {
+		query string
+		out   map[string][]string
+		code  int
+	}{
+		"all": {
+			out:  map[string][]string{"eth0": {"10.0.0.1", "10.0.0.2"}, "eth1": nil},
+			code: http.StatusOK,
+		},
+		"interface": {
+			query: "interface=eth1",
+			out:   map[string][]string{"eth1": nil},
+			code:  http.StatusOK,
+		},
+		"VLAN": {
+			query: "vlan=3",
+			out:   map[string][]string{"eth0": {"10.0.0.2"}, "eth1": nil},
+			code:  http.StatusOK,
+		},
+		"MAC": {
+			query: "interface=eth0&mac=00-16-3E-00-00-01",
+			out:   map[string][]string{"eth0": {"10.0.0.1"}},
+			code:  http.StatusOK,
+		},
+		"no match": {
+			query: "interface=eth0&vlan=2&mac=00:16:3e:00:00:02",
+			out:   map[string][]string{"eth0": nil},
+			code:  http.StatusOK,
+		},
+		"unknown interface": {
+			query: "interface=eth9",
+			code:  http.StatusNotFound,
+		},
+		"invalid VLAN": {
+			query: "vlan=4096",
+			code:  http.StatusBadRequest,
+		},
+		"invalid MAC": {
+			query: "mac=nope",
+			code:  http.StatusBadRequest,
+		},
+		"EUI-64": {
+			query: "mac=00:16:3e:ff:fe:00:00:01",
+			code:  http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := do(t, h, http.MethodGet, "/neighbors?"+tc.query, "")
+			require.Equal(t, tc.code, rec.Code)
+
+			if tc.code != http.StatusOK {
+				assert.NotEmpty(t, decode[errorResponse](t, rec).Error)
+				return
+			}
+
+			out := make(map[string][]string)
+
+			for _, table := range decode[[]Neighbors](t, rec) {
+				require.NotNil(t, table.Bindings)
+
+				out[table.Interface] = nil
+				for _, b := range table.Bindings {
+					out[table.Interface] = append(out[table.Interface], b.IP)
+				}
+			}
+
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestNeighborsDontTakeSnapshots(t *testing.T) {
+	t.Parallel()
+
+	svc := netmon.NewService("eth0")
+	h := NewServer("", WithServices(svc)).Handler()
+
+	first := svc.Snapshot()
+
+	require.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/neighbors", "").Code)
+	assert.Equal(t, first.Sequence+1, svc.Snapshot().Sequence)
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	h := testServer(t).Handler()
+
+	rec := do(t, h, http.MethodGet, "/events", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// the log keeps the latest two
+	events := decode[[]Event](t, rec)
+	require.Len(t, events, 2)
+	assert.Equal(t, "10.0.0.2", events[0].IP)
+	assert.Equal(t, "eth1", events[1].Interface)
+
+	rec = do(t, h, http.MethodGet, "/events?limit=1", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "10.0.1.1", decode[[]Event](t, rec)[0].IP)
+
+	assert.Equal(t, http.StatusBadRequest, do(t, h, http.MethodGet, "/events?limit=-1", "").Code)
+
+	// an empty list without a log
+	rec = do(t, NewServer("").Handler(), http.MethodGet, "/events", "")
+	assert.Equal(t, "[]\n", rec.Body.String())
+}
+
+func TestScans(t *testing.T) {
+	t.Parallel()
+
+	h := testServer(t).Handler()
+
+	rec := do(t, h, http.MethodGet, "/scans", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	status := decode[[]netmon.ScanJobStatus](t, rec)
+	require.Len(t, status, 1)
+	assert.Equal(t, "eth0/lan", status[0].ID)
+
+	rec = do(t, h, http.MethodPost, "/scans/eth0%2Flan/trigger", "")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, TriggerResponse{ID: "eth0/lan"}, decode[TriggerResponse](t, rec))
+
+	assert.Equal(t, http.StatusNotFound, do(t, h, http.MethodPost, "/scans/unknown/trigger", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(t, NewServer("").Handler(), http.MethodPost,
+		"/scans/eth0%2Flan/trigger", "").Code)
+}
+
+func TestMode(t *testing.T) {
+	t.Parallel()
+
+	rec := do(t, NewServer("").Handler(), http.MethodGet, "/mode", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, netmon.ModeActive, decode[netmon.TransmitGateStatus](t, rec).Mode)
+
+	gate := netmon.NewTransmitGate(netmon.ModeStandby)
+	h := NewServer("", WithTransmitGate(gate)).Handler()
+
+	require.Error(t, gate.Writer(netmon.TransmitScan, nil).WriteFrame(make([]byte, 60)))
+
+	status := decode[netmon.TransmitGateStatus](t, do(t, h, http.MethodGet, "/mode", ""))
+	assert.Equal(t, netmon.ModeStandby, status.Mode)
+	assert.Equal(t, uint64(1), status.Refused["scan"])
+
+	_, err := gate.Promote(context.Background())
+	require.NoError(t, err)
+
+	status = decode[netmon.TransmitGateStatus](t, do(t, h, http.MethodGet, "/mode", ""))
+	assert.Equal(t, netmon.ModeActive, status.Mode)
+	assert.Equal(t, uint64(1), status.Transitions)
+}
+
+func TestDumpPcap(t *testing.T) {
+	t.Parallel()
+
+	h := testServer(t).Handler()
+	path := filepath.Join(t.TempDir(), "eth0.pcap")
+
+	testcases := map[string]struct {
+		body string
+		code int
+	}{
+		"relative path": {
+			body: `{"interface": "eth0", "path": "eth0.pcap"}`,
+			code: http.StatusBadRequest,
+		},
+		"unknown field": {
+			body: `{"interface": "eth0", "path": "` + path + `", "mode": 511}`,
+			code: http.StatusBadRequest,
+		},
+		"not JSON": {
+			body: "eth0",
+			code: http.StatusBadRequest,
+		},
+		"without a ring": {
+			body: `{"interface": "eth1", "path": "` + path + `"}`,
+			code: http.StatusNotFound,
+		},
+		"unknown format": {
+			body: `{"interface": "eth0", "path": "` + path + `", "format": "erf"}`,
+			code: http.StatusBadRequest,
+		},
+		"unknown redacted protocol": {
+			body: `{"interface": "eth0", "path": "` + path + `", "redaction": {"keep": ["smb"]}}`,
+			code: http.StatusBadRequest,
+		},
+		"missing directory": {
+			body: `{"interface": "eth0", "path": "` + filepath.Join(path, "missing", "eth0.pcap") + `"}`,
+			code: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := do(t, h, http.MethodPost, "/pcap", tc.body)
+			assert.Equal(t, tc.code, rec.Code)
+			assert.NotEmpty(t, decode[errorResponse](t, rec).Error)
+		})
+	}
+
+	rec := do(t, h, http.MethodPost, "/pcap", `{"interface": "eth0", "path": "`+path+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, DumpResponse{Interface: "eth0", Path: path, Manifest: path + ".manifest.json",
+		Format: capture.FormatPcap, Frames: 1}, decode[DumpResponse](t, rec))
+
+	f, err := os.Open(path) //nolint:gosec // the path is in the test directory
+	require.NoError(t, err)
+
+	defer f.Close() //nolint:errcheck // the file is only read
+
+	r, err := capture.NewPcapReader(f, "eth0")
+	require.NoError(t, err)
+
+	buf := make([]byte, 128)
+	n, err := r.ReadFrame(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 60, n)
+
+	m, err := capture.ReadManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, "eth0", m.Interface)
+	assert.Empty(t, m.Preflight)
+}
+
+func TestDumpPcapNg(t *testing.T) {
+	t.Parallel()
+
+	ring := capture.NewPcapRing(4)
+	ring.Add(make([]byte, 60), capture.Metadata{Timestamp: time.Unix(1700000000, 0)})
+
+	preflight := func(iface string) (*netif.Report, error) {
+		return &netif.Report{Name: iface, MTU: 1500}, nil
+	}
+
+	h := NewServer("", WithRing("eth0", ring), WithPreflight(preflight)).Handler()
+	path := filepath.Join(t.TempDir(), "eth0.pcapng")
+
+	rec := do(t, h, http.MethodPost, "/pcap", `{"interface": "eth0", "path": "`+path+`", "format": "pcapng"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, DumpResponse{Interface: "eth0", Path: path, Manifest: path + ".manifest.json",
+		Format: capture.FormatPcapNg, Frames: 1}, decode[DumpResponse](t, rec))
+
+	// the manifest is embedded in the file as well as in its sidecar
+	require.NoError(t, os.Remove(path+".manifest.json"))
+
+	m, err := capture.ReadManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, "eth0", m.Interface)
+
+	assert.Contains(t, string(m.Preflight), `"name":"eth0"`)
+	assert.Contains(t, string(m.Preflight), `"mtu":1500`)
+}
+
+func TestDumpPcapRedacted(t *testing.T) {
+	t.Parallel()
+
+	// a DNS query over IPv4
+	frame := append(make([]byte, 12), 0x08, 0x00, 0x45)
+	frame = append(frame, make([]byte, 19)...)
+	frame[23] = 17
+	frame = append(frame, 0xc0, 0x00, 0x00, 0x35, 0x00, 0x0c, 0x00, 0x00, 'q', 'u', 'e', 'r')
+
+	ring := capture.NewPcapRing(4)
+	ring.Add(frame, capture.Metadata{Timestamp: time.Unix(1700000000, 0)})
+
+	h := NewServer("", WithRing("eth0", ring)).Handler()
+	path := filepath.Join(t.TempDir(), "eth0.pcap")
+
+	rec := do(t, h, http.MethodPost, "/pcap", `{"interface": "eth0", "path": "`+path+`", "redaction": {}}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, decode[DumpResponse](t, rec).Redacted)
+
+	f, err := os.Open(path) //nolint:gosec // the path is in the test directory
+	require.NoError(t, err)
+
+	defer f.Close() //nolint:errcheck // the file is only read
+
+	r, err := capture.NewPcapReader(f, "eth0")
+	require.NoError(t, err)
+
+	// the query is cut after the UDP header, the file telling its length
+	buf := make([]byte, 128)
+	md, err := r.ReadFrameMetadata(buf)
+	require.NoError(t, err)
+	assert.Equal(t, frame[:42], buf[:md.CaptureLength])
+	assert.Equal(t, len(frame), md.Length)
+
+	m, err := capture.ReadManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, &capture.Redaction{}, m.Redaction)
+
+	// the redaction of the ring prevails
+	require.NoError(t, ring.SetRedaction(&capture.Redaction{Mode: capture.RedactZero}))
+
+	rec = do(t, h, http.MethodPost, "/pcap", `{"interface": "eth0", "path": "`+path+`", "redaction": {}}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	m, err = capture.ReadManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, capture.RedactZero, m.Redaction.Mode)
+}
+
+func TestEventLog(t *testing.T) {
+	t.Parallel()
+
+	l := NewEventLog(3)
+	assert.Empty(t, l.Recent(0))
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		l.Record("eth0", netmon.Result{IP: ip})
+	}
+
+	ips := func(events []Event) []string {
+		var out []string
+		for _, e := range events {
+			out = append(out, e.IP)
+		}
+
+		return out
+	}
+
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"}, ips(l.Recent(0)))
+	assert.Equal(t, []string{"10.0.0.3", "10.0.0.4"}, ips(l.Recent(2)))
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"}, ips(l.Recent(10)))
+	assert.Len(t, NewEventLog(0).events, defaultEventLogSize)
+}
+
+func TestServerRun(t *testing.T) {
+	defer leak.Check(t)()
+
+	socket := filepath.Join(t.TempDir(), "debug.sock")
+
+	// a stale socket is replaced
+	require.NoError(t, os.WriteFile(socket, nil, 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+
+	go func() {
+		errC <- NewServer(socket, WithServices(netmon.NewService("eth0"))).Run(ctx)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	defer client.CloseIdleConnections()
+
+	require.Eventually(t, func() bool {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://debug/captures", nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return false
+		}
+
+		defer resp.Body.Close() //nolint:errcheck // the body is only read
+
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(socketMode), info.Mode().Perm())
+
+	client.CloseIdleConnections()
+	cancel()
+	require.NoError(t, <-errC)
+
+	// the listener removes the socket once closed
+	_, err = os.Stat(socket)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
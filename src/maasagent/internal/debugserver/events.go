@@ -0,0 +1,78 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package debugserver
+
+import (
+	"sync"
+
+	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/schema"
+)
+
+// defaultEventLogSize is what NewEventLog keeps with a size under 1
+const defaultEventLogSize = 256
+
+// Event is a Result recorded in an EventLog
+type Event = schema.Event
+
+// EventLog keeps the latest events for the debug endpoints to list
+type EventLog struct {
+	events []Event
+	next   int
+	mu     sync.Mutex
+	full   bool
+}
+
+// NewEventLog returns a log of the given number of events
+func NewEventLog(size int) *EventLog {
+	if size < 1 {
+		size = defaultEventLogSize
+	}
+
+	return &EventLog{events: make([]Event, size)}
+}
+
+// Record adds the Result observed on iface, replacing the oldest event when
+// the log is full
+func (l *EventLog) Record(iface string, res netmon.Result) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events[l.next] = Event{Interface: iface, Result: res}
+	l.next = (l.next + 1) % len(l.events)
+	l.full = l.full || l.next == 0
+}
+
+// Recent returns the latest n events at most, the oldest first. n under 1
+// returns all of them.
+func (l *EventLog) Recent(n int) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := make([]Event, 0, len(l.events))
+
+	if l.full {
+		events = append(events, l.events[l.next:]...)
+	}
+
+	events = append(events, l.events[:l.next]...)
+
+	if n > 0 && n < len(events) {
+		events = events[len(events)-n:]
+	}
+
+	return events
+}
@@ -0,0 +1,493 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package debugserver
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/schema"
+)
+
+const (
+	// maxRequestBody bounds the body of the mutating requests
+	maxRequestBody = 4096
+)
+
+// Capture is the state of the capture of an interface
+type Capture struct {
+	// Stats are nil unless the capture is running
+	Stats     *capture.Stats `json:"stats,omitempty"`
+	Interface string         `json:"interface"`
+	// Error is set when the counters couldn't be read
+	Error string `json:"error,omitempty"`
+	// Malformed and Truncated count the frames which failed to decode,
+	// the truncated ones only because of the snaplen
+	Malformed uint64 `json:"malformed"`
+	Truncated uint64 `json:"truncated"`
+	// LimitExceeded counts the frames abandoned at a decode limit
+	LimitExceeded uint64 `json:"limit_exceeded"`
+	// Decoders are the names of the protocols the pipeline decodes
+	Decoders []string `json:"decoders"`
+	// Degraded are the capabilities the capture runs without, and the
+	// features they disable
+	Degraded []netmon.DegradedCapability `json:"degraded,omitempty"`
+	Running  bool                        `json:"running"`
+}
+
+// Target is the JSON form of a capture.Target
+type Target struct {
+	// Expression is a filter expression of pcap-filter(7), of the subset
+	// capture.Target compiles
+	Expression string   `json:"expression,omitempty"`
+	MACs       []string `json:"macs"`
+	IPs        []string `json:"ips"`
+}
+
+// Filter is the socket filter of the capture of an interface
+type Filter struct {
+	Interface string `json:"interface"`
+	Error     string `json:"error,omitempty"`
+	// Instructions are the instructions of the filter, disassembled
+	Instructions []string `json:"instructions"`
+	Target       Target   `json:"target"`
+}
+
+// Neighbors are the bindings of the neighbor table of an interface
+type Neighbors struct {
+	Interface string                   `json:"interface"`
+	Bindings  []netmon.SnapshotBinding `json:"bindings"`
+}
+
+// DumpRequest is the body of a request dumping the frame ring of an
+// interface to a capture file
+type DumpRequest struct {
+	Interface string `json:"interface"`
+	// Path is the absolute path of the file, which is replaced if it
+	// exists
+	Path string `json:"path"`
+	// Format is the format of the file, pcap unless set
+	Format capture.FileFormat `json:"format,omitempty"`
+	// Redaction removes the payloads of the frames, unless the ring has a
+	// redaction of its own which prevails
+	Redaction *capture.Redaction `json:"redaction,omitempty"`
+}
+
+// DumpResponse tells where the frames and their manifest were dumped
+type DumpResponse struct {
+	Interface string             `json:"interface"`
+	Path      string             `json:"path"`
+	Manifest  string             `json:"manifest"`
+	Format    capture.FileFormat `json:"format"`
+	Frames    int                `json:"frames"`
+	// Redacted is set when the payloads of the frames were removed
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// TriggerResponse tells the scan job to run
+type TriggerResponse struct {
+	ID string `json:"id"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(schema.Header, strconv.Itoa(schema.Version))
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debug().Err(err).Msg("Failed writing a debug response")
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
+
+func (s *Server) handleCaptures(w http.ResponseWriter, _ *http.Request) {
+	captures := make([]Capture, 0, len(s.order))
+
+	for _, name := range s.order {
+		st, err := s.services[name].CaptureStatus()
+
+		c := Capture{
+			Interface:     name,
+			Stats:         st.Stats,
+			Malformed:     st.Malformed,
+			Truncated:     st.Truncated,
+			LimitExceeded: st.LimitExceeded,
+			Decoders:      st.Decoders.Names(),
+			Degraded:      st.Capabilities.Degraded,
+			Running:       st.Running,
+		}
+		if err != nil {
+			c.Error = err.Error()
+		}
+
+		captures = append(captures, c)
+	}
+
+	writeJSON(w, http.StatusOK, captures)
+}
+
+func (s *Server) handleFilters(w http.ResponseWriter, _ *http.Request) {
+	filters := make([]Filter, 0, len(s.order))
+
+	for _, name := range s.order {
+		filters = append(filters, s.filter(name))
+	}
+
+	writeJSON(w, http.StatusOK, filters)
+}
+
+// filter returns the Filter of the capture of the interface name
+func (s *Server) filter(name string) Filter {
+	st, err := s.services[name].CaptureStatus()
+
+	f := Filter{
+		Interface:    name,
+		Instructions: disassemble(st.Filter),
+		Target:       jsonTarget(st.Target),
+	}
+	if err != nil {
+		f.Error = err.Error()
+	}
+
+	return f
+}
+
+func disassemble(raw []bpf.RawInstruction) []string {
+	out := make([]string, 0, len(raw))
+
+	// the instructions which don't decode are kept raw
+	instructions, _ := bpf.Disassemble(raw)
+
+	for _, ins := range instructions {
+		out = append(out, fmt.Sprint(ins))
+	}
+
+	return out
+}
+
+func jsonTarget(t capture.Target) Target {
+	out := Target{
+		Expression: t.Expression,
+		MACs:       make([]string, 0, len(t.MACs)),
+		IPs:        make([]string, 0, len(t.IPs)),
+	}
+
+	for _, mac := range t.MACs {
+		out.MACs = append(out.MACs, mac.String())
+	}
+
+	for _, ip := range t.IPs {
+		out.IPs = append(out.IPs, ip.String())
+	}
+
+	return out
+}
+
+// captureTarget parses the Target into a capture.Target
+func (t Target) captureTarget() (capture.Target, error) {
+	out := capture.Target{Expression: t.Expression}
+
+	for i, v := range t.MACs {
+		mac, err := addrutil.ParseMAC(addrutil.Index("macs", i), v)
+		if err != nil {
+			return capture.Target{}, err
+		}
+
+		out.MACs = append(out.MACs, mac)
+	}
+
+	for i, v := range t.IPs {
+		ip, err := netip.ParseAddr(v)
+		if err != nil {
+			return capture.Target{}, fmt.Errorf("%s: %w", addrutil.Index("ips", i), err)
+		}
+
+		out.IPs = append(out.IPs, ip)
+	}
+
+	return out, nil
+}
+
+// handleSetTarget restricts the capture of an interface to the target of
+// the body, and responds with the filter it runs then
+func (s *Server) handleSetTarget(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("interface")
+
+	svc, ok := s.services[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown interface %q", name))
+		return
+	}
+
+	var req Target
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid target: %w", err))
+		return
+	}
+
+	target, err := req.captureTarget()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid target: %w", err))
+		return
+	}
+
+	if err := svc.SetTarget(target); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, capture.ErrInvalidTarget) || errors.Is(err, capture.ErrTooManyTargets) ||
+			errors.Is(err, capture.ErrInvalidExpression) || errors.Is(err, capture.ErrUnsupportedExpression) {
+			code = http.StatusBadRequest
+		}
+
+		writeError(w, code, err)
+
+		return
+	}
+
+	log.Info().Str("iface", name).Str("expression", target.Expression).Int("macs", len(target.MACs)).
+		Int("ips", len(target.IPs)).Msg("Capture target set over the debug socket")
+
+	writeJSON(w, http.StatusOK, s.filter(name))
+}
+
+// handleNeighbors lists the neighbor tables of the interfaces, optionally
+// only the bindings of the interface, VLAN or MAC of the query
+func (s *Server) handleNeighbors(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	names := s.order
+
+	if name := query.Get("interface"); name != "" {
+		if _, ok := s.services[name]; !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown interface %q", name))
+			return
+		}
+
+		names = []string{name}
+	}
+
+	var vid *uint16
+
+	if v := query.Get("vlan"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 12)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid VLAN %q", v))
+			return
+		}
+
+		vid = new(uint16)
+		*vid = uint16(id)
+	}
+
+	var mac string
+
+	if v := query.Get("mac"); v != "" {
+		// a longer address than an ethernet one would match no binding
+		hw, err := addrutil.ParseMAC("mac", v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		mac = hw.String()
+	}
+
+	tables := make([]Neighbors, 0, len(names))
+
+	for _, name := range names {
+		bindings := make([]netmon.SnapshotBinding, 0)
+
+		for _, b := range s.services[name].Bindings() {
+			if vid != nil && (b.VID == nil || *b.VID != *vid) {
+				continue
+			}
+
+			if mac != "" && b.MAC != mac {
+				continue
+			}
+
+			bindings = append(bindings, b)
+		}
+
+		tables = append(tables, Neighbors{Interface: name, Bindings: bindings})
+	}
+
+	writeJSON(w, http.StatusOK, tables)
+}
+
+// handleEvents lists the recent events, the latest limit of them if the
+// query has one
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+
+		limit = n
+	}
+
+	events := []Event{}
+	if s.events != nil {
+		events = s.events.Recent(limit)
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (s *Server) handleScans(w http.ResponseWriter, _ *http.Request) {
+	status := []netmon.ScanJobStatus{}
+	if s.scheduler != nil {
+		status = s.scheduler.Status()
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleMode returns the mode of the agent, one without a TransmitGate is
+// always active
+func (s *Server) handleMode(w http.ResponseWriter, _ *http.Request) {
+	status := netmon.TransmitGateStatus{Mode: netmon.ModeActive, Refused: map[string]uint64{}}
+	if s.gate != nil {
+		status = s.gate.Status()
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handleTriggerScan(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if s.scheduler == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", netmon.ErrUnknownScanJob, id))
+		return
+	}
+
+	if err := s.scheduler.Trigger(id); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, netmon.ErrUnknownScanJob) {
+			code = http.StatusNotFound
+		}
+
+		writeError(w, code, err)
+
+		return
+	}
+
+	log.Info().Str("job", id).Msg("Scan job triggered over the debug socket")
+
+	writeJSON(w, http.StatusAccepted, TriggerResponse{ID: id})
+}
+
+func (s *Server) handleDumpPcap(w http.ResponseWriter, r *http.Request) {
+	var req DumpRequest
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid dump request: %w", err))
+		return
+	}
+
+	if !filepath.IsAbs(req.Path) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("dump path %q isn't absolute", req.Path))
+		return
+	}
+
+	if req.Redaction != nil {
+		if err := req.Redaction.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	ring, ok := s.rings[req.Interface]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no frame ring for interface %q", req.Interface))
+		return
+	}
+
+	path := filepath.Clean(req.Path)
+	manifest := s.manifest(req.Interface, ring)
+	manifest.Redaction = cmp.Or(manifest.Redaction, req.Redaction)
+
+	frames, err := ring.Dump(path, req.Format, manifest)
+	if errors.Is(err, capture.ErrUnsupported) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Info().Str("iface", req.Interface).Str("path", path).Int("frames", frames).
+		Msg("Frame ring dumped over the debug socket")
+
+	format := cmp.Or(req.Format, capture.FormatPcap)
+
+	writeJSON(w, http.StatusOK, DumpResponse{Interface: req.Interface, Path: path,
+		Manifest: capture.ManifestPath(path), Format: format, Frames: frames, Redacted: manifest.Redaction != nil})
+}
+
+// manifest returns the Manifest of the frames of ring, kept for iface, with
+// the preflight report of the interface when the Server runs Preflight
+func (s *Server) manifest(iface string, ring *capture.PcapRing) capture.Manifest {
+	m := ring.Manifest()
+	m.Interface = cmp.Or(m.Interface, iface)
+
+	if s.preflight == nil {
+		return m
+	}
+
+	report, err := s.preflight(iface)
+	if err == nil {
+		m.Preflight, err = json.Marshal(report)
+	}
+
+	if err != nil {
+		log.Warn().Err(err).Str("iface", iface).Msg("Dump manifest without the preflight report")
+	}
+
+	return m
+}
@@ -17,7 +17,6 @@ package dhcp
 
 import (
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
@@ -33,6 +32,7 @@ import (
 	"golang.org/x/sync/semaphore"
 
 	"maas.io/core/src/maasagent/internal/dhcp/xdp"
+	"maas.io/core/src/maasagent/internal/hostorder"
 )
 
 const (
@@ -268,28 +268,20 @@ func (s *Server) serveXDP(ctx context.Context) error {
 				var (
 					msg Message
 					idx int
+					err error
 				)
 
 				log.Debug().Msg("received DHCP packet via XDP")
 
-				n, err := binary.Decode(pkt.RawSample[idx:idx+4], binary.LittleEndian, &msg.IfaceIdx)
-				if err != nil {
-					readErrs <- fmt.Errorf("error reading interface info: %w", err)
-					return
-				}
-
-				idx += n
+				// the record of the XDP program is in host order
+				msg.IfaceIdx = hostorder.Uint32(pkt.RawSample[idx : idx+4])
+				idx += 4
 
 				copy(msg.SrcMAC, pkt.RawSample[idx:idx+6])
 				idx += 6
 
-				n, err = binary.Decode(pkt.RawSample[idx:idx+2], binary.LittleEndian, &msg.SrcPort)
-				if err != nil {
-					readErrs <- fmt.Errorf("error reading port info: %w", err)
-					return
-				}
-
-				idx += n
+				msg.SrcPort = hostorder.Uint16(pkt.RawSample[idx : idx+2])
+				idx += 2
 
 				ip4 := make(net.IP, 4)
 				copy(ip4, pkt.RawSample[idx:idx+4])
@@ -28,6 +28,8 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
 )
 
 type xdpAction uint32
@@ -175,7 +177,7 @@ func TestDHCP_XDP_IPv4(t *testing.T) {
 
 				var e bpfDhcpData
 				assert.NoError(t, binary.Read(bytes.NewReader(record.RawSample),
-					binary.LittleEndian, &e))
+					hostorder.Order, &e))
 
 				done <- e
 			}()
@@ -0,0 +1,274 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package leasefile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+const (
+	// DefaultSource is the source of the observations of a Follower
+	// without WithSource
+	DefaultSource = "dhcpd-leases"
+	// defaultPollInterval is how often the file is read for new leases
+	defaultPollInterval = 5 * time.Second
+)
+
+// Ingester takes the observations of a Follower, netmon.Service implements
+// it
+type Ingester interface {
+	Ingest(o netmon.Observation) ([]netmon.Result, error)
+}
+
+// Follower tails a leases file and ingests the leases the server
+// acknowledged, following the file when dhcpd rewrites it
+type Follower struct {
+	clock   clock.Clock
+	ingest  Ingester
+	results func(netmon.Result)
+	file    *os.File
+	info    fs.FileInfo
+	// seen is the last lease ingested for each address, the leases written
+	// again when the file is rewritten aren't ingested again
+	seen map[netip.Addr]Lease
+	path string
+	// pending is the statement being written when the file was last read
+	pending  []byte
+	source   string
+	offset   int64
+	interval time.Duration
+}
+
+// FollowerOption configures a Follower
+type FollowerOption func(*Follower)
+
+// WithSource sets the source of the observations, DefaultSource otherwise
+func WithSource(name string) FollowerOption {
+	return func(f *Follower) {
+		f.source = name
+	}
+}
+
+// WithPollInterval sets how often the file is read for new leases
+func WithPollInterval(d time.Duration) FollowerOption {
+	return func(f *Follower) {
+		if d > 0 {
+			f.interval = d
+		}
+	}
+}
+
+// WithFollowerClock sets the clock the file is polled with
+func WithFollowerClock(c clock.Clock) FollowerOption {
+	return func(f *Follower) {
+		f.clock = c
+	}
+}
+
+// WithResults sets the function the Results of the ingested leases are
+// delivered to, they are dropped otherwise
+func WithResults(fn func(netmon.Result)) FollowerOption {
+	return func(f *Follower) {
+		f.results = fn
+	}
+}
+
+// NewFollower returns a Follower of the leases file at path ingesting the
+// leases into ingest
+func NewFollower(path string, ingest Ingester, options ...FollowerOption) *Follower {
+	f := &Follower{
+		clock:    clock.System{},
+		ingest:   ingest,
+		seen:     make(map[netip.Addr]Lease),
+		path:     path,
+		source:   DefaultSource,
+		interval: defaultPollInterval,
+	}
+
+	for _, opt := range options {
+		opt(f)
+	}
+
+	return f
+}
+
+// Run reads the file every poll interval until ctx is done. A missing file
+// is waited for, dhcpd may not have written it yet.
+func (f *Follower) Run(ctx context.Context) error {
+	defer f.close()
+
+	ticker := f.clock.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.Poll(); err != nil {
+			log.Warn().Err(err).Str("path", f.path).Msg("Failed reading the leases file")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
+
+// Poll reads what was written to the file since the last Poll, and ingests
+// the leases acknowledged since. It reopens the file when dhcpd renamed
+// another over it, and reads it again from the start when it was
+// truncated.
+func (f *Follower) Poll() error {
+	info, err := os.Stat(f.path)
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// between the removal of the old file and the rename of the new one
+		return nil
+	case err != nil:
+		return err
+	}
+
+	if f.file == nil || !os.SameFile(f.info, info) {
+		if err := f.open(); err != nil {
+			return err
+		}
+	} else if info.Size() < f.offset {
+		f.offset, f.pending = 0, nil
+	}
+
+	data, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	leases, n, err := parse(data)
+	if err != nil {
+		// the statement is skipped, the next ones may parse
+		f.pending = nil
+
+		return err
+	}
+
+	f.pending = bytes.Clone(data[n:])
+
+	for _, l := range leases {
+		f.observe(l)
+	}
+
+	return nil
+}
+
+// open opens the file at the path, in place of the one it replaced
+func (f *Follower) open() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close() //nolint:errcheck,gosec // nothing was read
+
+		return err
+	}
+
+	f.close()
+	f.file, f.info = file, info
+	f.offset, f.pending = 0, nil
+
+	return nil
+}
+
+func (f *Follower) close() {
+	if f.file != nil {
+		f.file.Close() //nolint:errcheck,gosec // the file is only read
+		f.file = nil
+	}
+}
+
+// read returns the pending statement followed by what was written since
+// the last read
+func (f *Follower) read() ([]byte, error) {
+	if _, err := f.file.Seek(f.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(f.file)
+	f.offset += int64(len(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(f.pending, data...), nil
+}
+
+// observe ingests l if the server acknowledged it, and it changed since the
+// lease of its address last ingested
+func (f *Follower) observe(l Lease) {
+	if !l.Acked() {
+		// the address is free again, a new lease is a new observation
+		delete(f.seen, l.IP)
+
+		return
+	}
+
+	if prev, ok := f.seen[l.IP]; ok && bytes.Equal(prev.MAC, l.MAC) && prev.CLTT.Equal(l.CLTT) &&
+		prev.Starts.Equal(l.Starts) {
+		return
+	}
+
+	f.seen[l.IP] = l
+
+	timestamp := l.CLTT
+	if timestamp.IsZero() {
+		timestamp = l.Starts
+	}
+
+	res, err := f.ingest.Ingest(netmon.Observation{
+		Time:       timestamp,
+		Source:     f.source,
+		IP:         l.IP,
+		MAC:        l.MAC,
+		Kind:       netmon.ObservationDHCPAck,
+		Confidence: netmon.ConfidenceHigh,
+		Hostname:   l.Hostname,
+		ClientID:   l.ClientID,
+	})
+	if err != nil {
+		log.Debug().Err(err).Str("ip", l.IP.String()).Msg("Skipping lease")
+
+		return
+	}
+
+	if f.results != nil {
+		for _, r := range res {
+			f.results(r)
+		}
+	}
+}
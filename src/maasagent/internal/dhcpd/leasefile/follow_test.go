@@ -0,0 +1,220 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package leasefile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/netmon"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+const (
+	ackedLease = `lease 10.0.0.5 {
+  starts 3 2025/01/15 10:00:00;
+  cltt 3 2025/01/15 10:00:00;
+  binding state active;
+  hardware ethernet 52:54:00:00:00:05;
+}
+`
+	renewedLease = `lease 10.0.0.5 {
+  starts 3 2025/01/15 10:30:00;
+  cltt 3 2025/01/15 10:30:00;
+  binding state active;
+  hardware ethernet 52:54:00:00:00:05;
+}
+`
+	freedLease = `lease 10.0.0.5 {
+  starts 3 2025/01/15 10:30:00;
+  binding state free;
+  hardware ethernet 52:54:00:00:00:05;
+}
+`
+	otherLease = `lease 10.0.0.6 {
+  starts 3 2025/01/15 10:40:00;
+  binding state active;
+  hardware ethernet 52:54:00:00:00:06;
+}
+`
+)
+
+func appendFile(t *testing.T, path, s string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+
+	_, err = f.WriteString(s)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+// rewriteFile writes s under a temporary name renamed over path, as dhcpd
+// does
+func rewriteFile(t *testing.T, path, s string) {
+	t.Helper()
+
+	tmp := path + ".new"
+	require.NoError(t, os.WriteFile(tmp, []byte(s), 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+// leaseTimes returns the times of the observations ingested into s
+func leaseTimes(s *netmon.Service) map[string]time.Time {
+	times := make(map[string]time.Time)
+
+	for _, b := range s.Bindings() {
+		times[b.IP+" "+b.MAC] = time.Unix(b.Time, 0).UTC()
+	}
+
+	return times
+}
+
+func TestFollower(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dhcpd.leases")
+	svc := netmon.NewService("eth0")
+
+	var events []netmon.Event
+
+	f := NewFollower(path, svc, WithResults(func(r netmon.Result) {
+		events = append(events, r.Event)
+	}))
+
+	defer f.close()
+
+	// dhcpd didn't write the file yet
+	require.NoError(t, f.Poll())
+	assert.Empty(t, svc.Bindings())
+
+	// a lease cut short is ingested once complete
+	appendFile(t, path, ackedLease[:40])
+	require.NoError(t, f.Poll())
+	assert.Empty(t, svc.Bindings())
+
+	appendFile(t, path, ackedLease[40:])
+	require.NoError(t, f.Poll())
+	assert.Equal(t, map[string]time.Time{
+		"10.0.0.5 52:54:00:00:00:05": time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC),
+	}, leaseTimes(svc))
+	assert.Equal(t, []netmon.Event{netmon.EventNew}, events)
+
+	snap := svc.Snapshot()
+	require.Len(t, snap.Bindings, 1)
+	assert.Equal(t, DefaultSource, snap.Bindings[0].Origin)
+	assert.Equal(t, "dhcp_ack", snap.Bindings[0].Observation)
+
+	// the renewal refreshes the binding
+	appendFile(t, path, renewedLease)
+	require.NoError(t, f.Poll())
+	assert.Equal(t, map[string]time.Time{
+		"10.0.0.5 52:54:00:00:00:05": time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC),
+	}, leaseTimes(svc))
+	assert.Equal(t, []netmon.Event{netmon.EventNew, netmon.EventRefreshed}, events)
+
+	// the rewritten file holds the leases already ingested
+	rewriteFile(t, path, renewedLease+otherLease)
+	require.NoError(t, f.Poll())
+	assert.Len(t, svc.Bindings(), 2)
+	assert.Equal(t, []netmon.Event{netmon.EventNew, netmon.EventRefreshed, netmon.EventNew}, events)
+	assert.Len(t, f.seen, 2)
+
+	// a freed address is forgotten
+	appendFile(t, path, freedLease)
+	require.NoError(t, f.Poll())
+	assert.Len(t, f.seen, 1)
+
+	// a truncated file is read again from the start
+	require.NoError(t, os.Truncate(path, 0))
+	appendFile(t, path, ackedLease)
+	require.NoError(t, f.Poll())
+	assert.Len(t, f.seen, 2)
+}
+
+func TestFollowerRun(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dhcpd.leases")
+	require.NoError(t, os.WriteFile(path, []byte(ackedLease), 0o600))
+
+	clock := clocktest.NewFake(time.Unix(1700000000, 0))
+	svc := netmon.NewService("eth0")
+	results := make(chan netmon.Result, 2)
+
+	f := NewFollower(path, svc, WithFollowerClock(clock), WithPollInterval(time.Minute),
+		WithSource("leases"), WithResults(func(r netmon.Result) { results <- r }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+
+	go func() { errC <- f.Run(ctx) }()
+
+	assert.Equal(t, "10.0.0.5", (<-results).IP)
+
+	appendFile(t, path, otherLease)
+
+	// the ticker of Run may not exist yet
+	require.Eventually(t, func() bool {
+		clock.Advance(time.Minute)
+
+		select {
+		case r := <-results:
+			return r.IP == "10.0.0.6"
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-errC)
+
+	assert.Equal(t, "leases", svc.Snapshot().Bindings[0].Origin)
+}
+
+func TestFollowerIdentity(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dhcpd.leases")
+	require.NoError(t, os.WriteFile(path, []byte(`lease 10.0.0.7 {
+  starts 3 2025/01/15 10:00:00;
+  binding state active;
+  hardware ethernet 52:54:00:00:00:07;
+  uid "\001RT\000\000\000\007";
+  client-hostname "node-7";
+}
+`), 0o600))
+
+	identities := netmon.NewCorrelator()
+	svc := netmon.NewService("eth0", netmon.WithCorrelator(identities))
+
+	f := NewFollower(path, svc)
+	defer f.close()
+
+	require.NoError(t, f.Poll())
+
+	snap := identities.Correlate(svc.Snapshot())
+	require.Len(t, snap.Identities, 1)
+	assert.Equal(t, []string{"node-7"}, snap.Identities[0].Hostnames)
+	assert.Equal(t, []string{"01:52:54:00:00:00:07"}, snap.Identities[0].ClientIDs)
+}
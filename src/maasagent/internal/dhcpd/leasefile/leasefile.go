@@ -0,0 +1,354 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package leasefile reads the leases file of ISC dhcpd, see dhcpd.leases(5),
+// and feeds the leases the server acknowledged to the neighbor table of a
+// netmon.Service as they are written.
+//
+// dhcpd appends every change of a lease to the file as a new lease
+// statement, the last statement of an address is its current state, and
+// now and then rewrites the file from scratch under a temporary name it
+// renames over the old one.
+package leasefile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayout is that of the dates of the default db-time-format, in UTC,
+// after the day of the week
+const dateLayout = "2006/01/02 15:04:05"
+
+// ErrMalformed is returned for a file whose statements don't parse, such
+// as an unbalanced block
+var ErrMalformed = errors.New("malformed leases file")
+
+// Lease is a lease statement of an IPv4 address
+type Lease struct {
+	// Starts and Ends bound the lease, Ends is zero for a lease which
+	// never ends
+	Starts time.Time
+	Ends   time.Time
+	// CLTT is the time of the last transaction with the client
+	CLTT time.Time
+	IP   netip.Addr
+	MAC  net.HardwareAddr
+	// State is the binding state of the lease, such as "active" or "free"
+	State    string
+	Hostname string
+	// ClientID is the DHCP client identifier of the client, option 61, in
+	// colon separated hexadecimal
+	ClientID string
+}
+
+// Acked returns whether the server acknowledged the lease, and still holds
+// it for the client
+func (l Lease) Acked() bool {
+	return l.State == "active"
+}
+
+// Parse returns the leases of the IPv4 addresses in the leases file r, in
+// the order they were written. The lease6 statements and the lease
+// statements with invalid values are skipped.
+func Parse(r io.Reader) ([]Lease, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the file is complete, its last word or comment too
+	data = append(data, '\n')
+
+	leases, n, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if rest := strings.TrimSpace(string(data[n:])); rest != "" {
+		return nil, fmt.Errorf("%w: unterminated statement %q", ErrMalformed, firstLine(rest))
+	}
+
+	return leases, nil
+}
+
+// parse returns the leases of the complete statements of data, and the
+// bytes they span. The statement the data ends in the middle of is left
+// for when the rest of it is written.
+func parse(data []byte) ([]Lease, int, error) {
+	var (
+		leases []Lease
+		n      int
+	)
+
+	lex := lexer{data: data}
+	// stack holds the statements whose block is open, under the top level
+	stack := []*statement{{}}
+	cur := &statement{}
+
+	for {
+		tok, ok := lex.next()
+		if !ok {
+			return leases, n, nil
+		}
+
+		switch tok {
+		case ";":
+			if len(stack) > 1 {
+				parent := stack[len(stack)-1]
+				parent.block = append(parent.block, *cur)
+			}
+
+			cur = &statement{}
+		case "{":
+			stack = append(stack, cur)
+			cur = &statement{}
+		case "}":
+			if len(stack) == 1 {
+				return nil, 0, fmt.Errorf("%w: unexpected }", ErrMalformed)
+			}
+
+			closed := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			cur = &statement{}
+
+			if len(stack) > 1 {
+				parent := stack[len(stack)-1]
+				parent.block = append(parent.block, *closed)
+
+				continue
+			}
+
+			if l, ok := closed.lease(); ok {
+				leases = append(leases, l)
+			}
+		default:
+			cur.words = append(cur.words, tok)
+
+			continue
+		}
+
+		// a statement ended at the top level
+		if len(stack) == 1 {
+			n = lex.pos
+		}
+	}
+}
+
+// statement is a statement of the leases file, its words and the
+// statements of its block
+type statement struct {
+	words []string
+	block []statement
+}
+
+// lease returns the lease of a lease statement with valid values
+func (s statement) lease() (Lease, bool) {
+	if len(s.words) != 2 || s.words[0] != "lease" {
+		return Lease{}, false
+	}
+
+	ip, err := netip.ParseAddr(s.words[1])
+	if err != nil || !ip.Is4() {
+		return Lease{}, false
+	}
+
+	l := Lease{IP: ip}
+
+	for _, st := range s.block {
+		w := st.words
+		if len(w) < 2 {
+			continue
+		}
+
+		switch {
+		case w[0] == "starts":
+			l.Starts, err = parseDate(w[1:])
+		case w[0] == "ends":
+			l.Ends, err = parseDate(w[1:])
+		case w[0] == "cltt":
+			l.CLTT, err = parseDate(w[1:])
+		case w[0] == "binding" && w[1] == "state" && len(w) == 3:
+			l.State = w[2]
+		case w[0] == "hardware" && w[1] == "ethernet" && len(w) == 3:
+			l.MAC, err = net.ParseMAC(w[2])
+		case w[0] == "client-hostname":
+			l.Hostname = w[1]
+		case w[0] == "uid":
+			l.ClientID = clientID(w[1])
+		}
+
+		if err != nil {
+			return Lease{}, false
+		}
+	}
+
+	return l, true
+}
+
+// clientID returns the uid of a lease in colon separated hexadecimal. dhcpd
+// writes a uid either so already, or as a string of its bytes.
+func clientID(uid string) string {
+	b, ok := hexBytes(uid)
+	if !ok {
+		b = []byte(uid)
+	}
+
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02x", c)
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// hexBytes returns the bytes of s in colon separated hexadecimal, or false
+// if s is not such
+func hexBytes(s string) ([]byte, bool) {
+	if !strings.Contains(s, ":") {
+		return nil, false
+	}
+
+	var b []byte
+
+	for _, part := range strings.Split(s, ":") {
+		if len(part) == 0 || len(part) > 2 {
+			return nil, false
+		}
+
+		c, err := strconv.ParseUint(part, 16, 8)
+		if err != nil {
+			return nil, false
+		}
+
+		b = append(b, byte(c))
+	}
+
+	return b, true
+}
+
+// parseDate parses the date of a lease, "never", "epoch <seconds>" or
+// "<weekday> <yyyy/mm/dd> <hh:mm:ss>" in UTC
+func parseDate(w []string) (time.Time, error) {
+	switch {
+	case len(w) == 1 && w[0] == "never":
+		return time.Time{}, nil
+	case len(w) == 2 && w[0] == "epoch":
+		sec, err := strconv.ParseInt(w[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return time.Unix(sec, 0).UTC(), nil
+	case len(w) == 3:
+		return time.Parse(dateLayout, w[1]+" "+w[2])
+	}
+
+	return time.Time{}, fmt.Errorf("%w: invalid date %q", ErrMalformed, strings.Join(w, " "))
+}
+
+// lexer splits a leases file into words, quoted strings and the {, } and
+// ; punctuation, dropping the comments
+type lexer struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next token, or false at the end of the data or of the
+// complete tokens it holds
+func (l *lexer) next() (string, bool) {
+	for l.pos < len(l.data) {
+		switch c := l.data[l.pos]; {
+		case c == ' ', c == '\t', c == '\r', c == '\n':
+			l.pos++
+		case c == '#':
+			end := strings.IndexByte(string(l.data[l.pos:]), '\n')
+			if end < 0 {
+				return "", false
+			}
+
+			l.pos += end + 1
+		case c == '{', c == '}', c == ';':
+			l.pos++
+
+			return string(c), true
+		case c == '"':
+			return l.quoted()
+		default:
+			return l.word()
+		}
+	}
+
+	return "", false
+}
+
+// quoted returns a quoted string without its quotes, with its escapes
+// resolved
+func (l *lexer) quoted() (string, bool) {
+	var b strings.Builder
+
+	for i := l.pos + 1; i < len(l.data); i++ {
+		c := l.data[i]
+
+		switch {
+		case c == '"':
+			l.pos = i + 1
+
+			return b.String(), true
+		case c == '\\' && i+3 < len(l.data) && isOctal(l.data[i+1]) && isOctal(l.data[i+2]) && isOctal(l.data[i+3]):
+			b.WriteByte((l.data[i+1]-'0')<<6 | (l.data[i+2]-'0')<<3 | (l.data[i+3] - '0'))
+			i += 3
+		case c == '\\' && i+1 < len(l.data):
+			b.WriteByte(l.data[i+1])
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", false
+}
+
+// word returns the word at the position, a word at the end of the data may
+// be cut short and is only returned once followed by something else
+func (l *lexer) word() (string, bool) {
+	for i := l.pos; i < len(l.data); i++ {
+		switch l.data[i] {
+		case ' ', '\t', '\r', '\n', '{', '}', ';', '"', '#':
+			w := string(l.data[l.pos:i])
+			l.pos = i
+
+			return w, true
+		}
+	}
+
+	return "", false
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+
+	return line
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package leasefile
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseMAC(s string) net.HardwareAddr {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return mac
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		file   string
+		leases []Lease
+	}{
+		"dhcpd.leases": {
+			file: "testdata/dhcpd.leases",
+			leases: []Lease{
+				{
+					Starts:   time.Date(2025, 1, 15, 10, 20, 30, 0, time.UTC),
+					Ends:     time.Date(2025, 1, 15, 11, 20, 30, 0, time.UTC),
+					CLTT:     time.Date(2025, 1, 15, 10, 20, 30, 0, time.UTC),
+					IP:       netip.MustParseAddr("10.20.0.101"),
+					MAC:      mustParseMAC("52:54:00:12:34:56"),
+					State:    "active",
+					Hostname: "node-1",
+					ClientID: "01:52:54:00:12:34:56",
+				},
+				{
+					Starts:   time.Date(2025, 1, 15, 10, 21, 2, 0, time.UTC),
+					Ends:     time.Date(2025, 1, 15, 11, 21, 2, 0, time.UTC),
+					CLTT:     time.Date(2025, 1, 15, 10, 21, 2, 0, time.UTC),
+					IP:       netip.MustParseAddr("10.20.0.102"),
+					MAC:      mustParseMAC("52:54:00:ab:cd:ef"),
+					State:    "active",
+					Hostname: `node "two"`,
+				},
+				{
+					Starts: time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC),
+					Ends:   time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC),
+					CLTT:   time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC),
+					IP:     netip.MustParseAddr("10.20.0.103"),
+					MAC:    mustParseMAC("52:54:00:00:00:03"),
+					State:  "free",
+				},
+				{
+					Starts:   time.Date(2025, 1, 15, 10, 50, 30, 0, time.UTC),
+					Ends:     time.Date(2025, 1, 15, 11, 50, 30, 0, time.UTC),
+					CLTT:     time.Date(2025, 1, 15, 10, 50, 30, 0, time.UTC),
+					IP:       netip.MustParseAddr("10.20.0.101"),
+					MAC:      mustParseMAC("52:54:00:12:34:56"),
+					State:    "active",
+					Hostname: "node-1",
+					ClientID: "01:52:54:00:12:34:56",
+				},
+			},
+		},
+		"failover with epoch dates": {
+			file: "testdata/failover.leases",
+			leases: []Lease{
+				{
+					Starts:   time.Unix(1738656000, 0).UTC(),
+					Ends:     time.Unix(1738659600, 0).UTC(),
+					CLTT:     time.Unix(1738656000, 0).UTC(),
+					IP:       netip.MustParseAddr("192.168.10.50"),
+					MAC:      mustParseMAC("00:16:3e:5a:01:02"),
+					State:    "active",
+					Hostname: "rack-01",
+					ClientID: "00:16:3e:5a:01:02",
+				},
+				{
+					Starts: time.Unix(1738656100, 0).UTC(),
+					CLTT:   time.Unix(1738656100, 0).UTC(),
+					IP:     netip.MustParseAddr("192.168.10.51"),
+					MAC:    mustParseMAC("00:16:3e:5a:01:03"),
+					State:  "backup",
+				},
+				// the hardware isn't ethernet
+				{
+					Starts: time.Unix(1738656200, 0).UTC(),
+					Ends:   time.Unix(1738659800, 0).UTC(),
+					IP:     netip.MustParseAddr("192.168.10.52"),
+					State:  "active",
+				},
+				// the addresses are validated when ingested
+				{
+					Starts: time.Unix(1738656300, 0).UTC(),
+					IP:     netip.MustParseAddr("192.168.10.53"),
+					MAC:    mustParseMAC("00:16:3e:5a:01:05:06:07"),
+					State:  "active",
+				},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := os.Open(tc.file)
+			require.NoError(t, err)
+
+			defer f.Close() //nolint:errcheck // only read
+
+			leases, err := Parse(f)
+			require.NoError(t, err)
+			assert.Equal(t, tc.leases, leases)
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]string{
+		"unbalanced block": "lease 10.0.0.1 {\n  binding state active;\n}\n}\n",
+		"unterminated":     "lease 10.0.0.1 {\n  binding state active;\n",
+		"missing ;":        "authoring-byte-order little-endian\n",
+	}
+
+	for name, in := range testcases {
+		in := in
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Parse(strings.NewReader(in))
+			assert.ErrorIs(t, err, ErrMalformed)
+		})
+	}
+
+	// the leases with invalid values are skipped
+	leases, err := Parse(strings.NewReader("lease 10.0.0.1 {\n  starts 3 2025/13/45 10:00:00;\n}\n" +
+		"lease fd00::1 {\n  binding state active;\n}\n" +
+		"lease 10.0.0.2 {\n  hardware ethernet 52:54:00;\n}\n"))
+	require.NoError(t, err)
+	assert.Empty(t, leases)
+}
+
+// TestParsePartial cuts the file at every byte, as a read racing dhcpd
+// appending to it does: the complete statements are parsed, the rest is
+// left for the next read
+func TestParsePartial(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile("testdata/dhcpd.leases")
+	require.NoError(t, err)
+
+	want, err := Parse(strings.NewReader(string(data)))
+	require.NoError(t, err)
+
+	for cut := range len(data) {
+		head, n, err := parse(data[:cut])
+		require.NoError(t, err, cut)
+
+		tail, _, err := parse(append(data[n:cut:cut], data[cut:]...))
+		require.NoError(t, err, cut)
+
+		assert.Equal(t, want, append(head, tail...), cut)
+	}
+}
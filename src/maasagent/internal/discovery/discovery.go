@@ -0,0 +1,518 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package discovery provides single-call entry points to scan a subnet,
+// watch interfaces for neighbours and dissect a capture file. They wire the
+// capture, netmon and decoder packages with defaults suitable for most
+// callers, who can use those packages directly for anything else.
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/conformance"
+	"maas.io/core/src/maasagent/internal/netif"
+	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/schema"
+)
+
+const (
+	defaultBatchSize     = 64
+	defaultBatchInterval = 100 * time.Millisecond
+)
+
+var (
+	// ErrNotOnLink is returned when scanning a subnet no address of the
+	// interface is in, the replies would come from a router
+	ErrNotOnLink = errors.New("subnet is not on the interface")
+	// ErrNoInterfaces is returned when watching no interface
+	ErrNoInterfaces = errors.New("no interface to watch")
+)
+
+// Host is a host which replied to a scan
+type Host struct {
+	IP netip.Addr
+	// Source is the address the probe was sent from
+	Source netip.Addr
+	MAC    net.HardwareAddr
+	// RTT is the time the probe took to be answered, 0 when the scanner
+	// doesn't stream its replies, see WithStreamScanner
+	RTT time.Duration
+}
+
+// ScanProgress is how far a scan of StreamSubnet got
+type ScanProgress struct {
+	// Total is the number of addresses of the subnet, Probed that of the
+	// addresses probed so far and Found that of the hosts which replied
+	Total  int
+	Probed int
+	Found  int
+	// Outstanding is the number of addresses probed by the batch in flight
+	// which didn't reply yet. They are given up on when the batch is over,
+	// the addresses aren't probed again.
+	Outstanding int
+	// Throttled is the time spent pausing between batches
+	Throttled time.Duration
+	// Done is set on the last progress of the scan, whether it completed
+	// or was cancelled
+	Done bool
+}
+
+// ScanUpdate is what StreamSubnet gives its handler, either a Host which
+// replied or the Progress of the scan
+type ScanUpdate struct {
+	Host     *Host
+	Progress *ScanProgress
+}
+
+// ScanSummary is the outcome of StreamSubnet
+type ScanSummary struct {
+	// Hosts are the hosts which replied, ordered by address
+	Hosts []Host
+	ScanProgress
+}
+
+type scanConfig struct {
+	clock         clock.Clock
+	scan          netmon.SourceStreamFunc
+	sourceOpts    []netif.SourceOption
+	batchSize     int
+	batchInterval time.Duration
+}
+
+// ScanOption configures ScanSubnet
+type ScanOption func(*scanConfig)
+
+// WithScanner sets the function probing the addresses, which sends them
+// from the source it likes
+func WithScanner(f netmon.ScanFunc) ScanOption {
+	return WithSourceScanner(func(ctx context.Context, _ netip.Addr, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		return f(ctx, ips)
+	})
+}
+
+// WithSourceScanner sets the function probing the addresses from the
+// selected source. The hosts which replied to a batch are only known once
+// it is over, in the order of the batch, see WithStreamScanner.
+func WithSourceScanner(f netmon.SourceScanFunc) ScanOption {
+	return func(c *scanConfig) {
+		c.scan = func(ctx context.Context, src netip.Addr, ips []netip.Addr, found func(netmon.ScanReply)) error {
+			macs, err := f(ctx, src, ips)
+			if err != nil {
+				return err
+			}
+
+			for _, ip := range ips {
+				if mac := macs[ip]; mac != nil {
+					found(netmon.ScanReply{IP: ip, MAC: mac})
+				}
+			}
+
+			return nil
+		}
+	}
+}
+
+// WithStreamScanner sets the function probing the addresses from the
+// selected source and giving the replies as they come, the StreamFrom of
+// a netmon.Scanner by default
+func WithStreamScanner(f netmon.SourceStreamFunc) ScanOption {
+	return func(c *scanConfig) {
+		c.scan = f
+	}
+}
+
+// WithSourceOptions configures the selection of the source of the probes,
+// such as netif.WithSourceOverride, see netif.SelectSource
+func WithSourceOptions(options ...netif.SourceOption) ScanOption {
+	return func(c *scanConfig) {
+		c.sourceOpts = append(c.sourceOpts, options...)
+	}
+}
+
+// WithBatchSize sets the number of addresses probed at once
+func WithBatchSize(n int) ScanOption {
+	return func(c *scanConfig) {
+		if n > 0 {
+			c.batchSize = n
+		}
+	}
+}
+
+// WithBatchInterval sets the pause between two batches, 0 sends the next
+// batch as soon as the previous one is done
+func WithBatchInterval(d time.Duration) ScanOption {
+	return func(c *scanConfig) {
+		if d >= 0 {
+			c.batchInterval = d
+		}
+	}
+}
+
+// linkAddrs returns the addresses of the named interface
+func linkAddrs(iface string) ([]netip.Prefix, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", iface, err)
+	}
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing the addresses of %s: %w", iface, err)
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(addrs))
+
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip, ok := netip.AddrFromSlice(ipnet.IP)
+		if !ok {
+			continue
+		}
+
+		ones, _ := ipnet.Mask.Size()
+		prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ones))
+	}
+
+	return prefixes, nil
+}
+
+// ScanSubnet probes every address of cidr, which must be on iface, and
+// returns the hosts which replied ordered by address. The addresses are
+// probed in batches so a large subnet doesn't flood the link, from the
+// address of iface on the subnet.
+func ScanSubnet(ctx context.Context, iface, cidr string, options ...ScanOption) ([]Host, error) {
+	summary, err := StreamSubnet(ctx, iface, cidr, nil, options...)
+
+	return summary.Hosts, err
+}
+
+// StreamSubnet is ScanSubnet calling handler with every host as it replies,
+// and with the progress of the scan after every batch, rather than only
+// returning them at the end. The hosts of a batch come in the order they
+// replied, not that of their addresses, each is given once however many
+// replies it sent. The handler is called one update at a time. Once the
+// probes started, its last call is a progress which is Done, also when ctx
+// is cancelled, after which the summary of what was found so far is
+// returned with the error of ctx. handler may be nil.
+func StreamSubnet(ctx context.Context, iface, cidr string, handler func(ScanUpdate),
+	options ...ScanOption) (ScanSummary, error) {
+	var summary ScanSummary
+
+	cfg := scanConfig{
+		clock:         clock.System{},
+		batchSize:     defaultBatchSize,
+		batchInterval: defaultBatchInterval,
+	}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	if cfg.scan == nil {
+		cfg.scan = netmon.NewScanner(netmon.WithScanClock(cfg.clock)).StreamFrom
+	}
+
+	if handler == nil {
+		handler = func(ScanUpdate) {}
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return summary, fmt.Errorf("invalid subnet %q: %w", cidr, err)
+	}
+
+	prefix, err = addrutil.Prefix("cidr", prefix)
+	if err != nil {
+		return summary, err
+	}
+
+	addrs, err := linkAddrs(iface)
+	if err != nil {
+		return summary, err
+	}
+
+	if !slices.ContainsFunc(addrs, prefix.Masked().Overlaps) {
+		return summary, fmt.Errorf("%w: %s on %s", ErrNotOnLink, cidr, iface)
+	}
+
+	src, err := netif.SelectSource(addrs, prefix, cfg.sourceOpts...)
+	if err != nil {
+		return summary, err
+	}
+
+	if src.Is6() && src.IsLinkLocalUnicast() && src.Zone() == "" {
+		src = src.WithZone(iface)
+	}
+
+	ips, err := netmon.ScanJob{Targets: []netip.Prefix{prefix}}.Addresses()
+	if err != nil {
+		return summary, err
+	}
+
+	summary.Total = len(ips)
+
+	// the progress given last is that of the summary returned
+	done := func(err error) (ScanSummary, error) {
+		summary.Outstanding = 0
+		summary.Done = true
+		progress := summary.ScanProgress
+		handler(ScanUpdate{Progress: &progress})
+
+		return summary, err
+	}
+
+	for start := 0; start < len(ips); start += cfg.batchSize {
+		if err := ctx.Err(); err != nil {
+			return done(err)
+		}
+
+		if start > 0 && cfg.batchInterval > 0 {
+			err := cfg.clock.Sleep(ctx, cfg.batchInterval)
+			if err != nil {
+				return done(err)
+			}
+
+			summary.Throttled += cfg.batchInterval
+		}
+
+		batch := ips[start:min(start+cfg.batchSize, len(ips))]
+
+		hosts, err := streamBatch(ctx, &cfg, src, batch, &summary, handler)
+		if err != nil {
+			return done(err)
+		}
+
+		// the batches are in order, so are their hosts
+		summary.Hosts = append(summary.Hosts, hosts...)
+
+		progress := summary.ScanProgress
+		handler(ScanUpdate{Progress: &progress})
+	}
+
+	return done(ctx.Err())
+}
+
+// streamBatch probes batch, giving handler the hosts which reply once, and
+// returns them ordered by address
+func streamBatch(ctx context.Context, cfg *scanConfig, src netip.Addr, batch []netip.Addr,
+	summary *ScanSummary, handler func(ScanUpdate)) ([]Host, error) {
+	var (
+		mu     sync.Mutex
+		over   bool
+		queued = make(map[netip.Addr]bool, len(batch))
+		found  = make(map[netip.Addr]Host)
+	)
+
+	for _, ip := range batch {
+		queued[ip] = true
+	}
+
+	summary.Probed += len(batch)
+	summary.Outstanding = len(batch)
+
+	// the replies to other probes, the repeated ones and those a scanner
+	// gives after returning are ignored
+	err := cfg.scan(ctx, src, batch, func(reply netmon.ScanReply) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if over || !queued[reply.IP] || reply.MAC == nil {
+			return
+		}
+
+		delete(queued, reply.IP)
+
+		host := Host{IP: reply.IP, MAC: reply.MAC, Source: src, RTT: reply.RTT}
+		found[reply.IP] = host
+		summary.Found++
+		summary.Outstanding--
+
+		handler(ScanUpdate{Host: &host})
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	over = true
+
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]Host, 0, len(found))
+
+	for _, ip := range batch {
+		if host, ok := found[ip]; ok {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts, nil
+}
+
+// Event is a netmon Result observed on an interface
+type Event = schema.Event
+
+// Watch observes the ARP traffic of ifaces and calls handler with every
+// Result, one at a time, until ctx is done. The frames the host sends are
+// ignored, MACs seen on more than one of the interfaces are reported, and
+// the events of a binding changing carry its history. The events are
+// queued for handler, which loses the newest ones when it falls too far
+// behind. Rather than in promiscuous mode, the interfaces join the
+// multicast groups the detectors need, see Profile.RequiredMembership, so
+// the ARP replies between other hosts aren't seen.
+func Watch(ctx context.Context, ifaces []string, handler func(Event)) error {
+	if len(ifaces) == 0 {
+		return ErrNoInterfaces
+	}
+
+	m := NewMultiplexer()
+
+	// a stuck handler drops events rather than stall the capture loops
+	if err := m.Subscribe("handler", handler); err != nil {
+		return err
+	}
+
+	profiles := make(map[string]Profile, len(ifaces))
+	for _, iface := range ifaces {
+		profiles[iface] = watchProfile()
+	}
+
+	if err := m.ApplyProfiles(profiles); err != nil {
+		return err
+	}
+
+	return m.Run(ctx)
+}
+
+// watchProfile returns the Profile of the interfaces of Watch, in the
+// narrowest membership its detectors allow
+func watchProfile() Profile {
+	p := Profile{DetectDuplicates: true, RecordEvidence: true}
+	p.Membership = p.RequiredMembership()
+
+	return p
+}
+
+// DissectedFrame is what the decoders found in a frame of a capture file
+type DissectedFrame struct {
+	Time    time.Time          `json:"time"`
+	Decoded conformance.Result `json:"decoded"`
+	// Frame is the position of the frame in the file, from 1
+	Frame  int `json:"frame"`
+	Length int `json:"length"`
+	// Captured is the number of bytes of the frame in the file
+	Captured int `json:"captured"`
+}
+
+// dissectConfig is the configuration of DissectPCAP
+type dissectConfig struct {
+	from time.Time
+	to   time.Time
+	// first is the record dissected first, from 0
+	first int
+}
+
+// DissectOption configures DissectPCAP
+type DissectOption func(*dissectConfig)
+
+// WithDissectTimeRange only dissects the frames captured from from, and
+// before to. A zero from or to leaves the range open on that side.
+func WithDissectTimeRange(from, to time.Time) DissectOption {
+	return func(c *dissectConfig) {
+		c.from, c.to = from, to
+	}
+}
+
+// WithDissectFirstRecord starts the dissection at the record n of the file,
+// from 0
+func WithDissectFirstRecord(n int) DissectOption {
+	return func(c *dissectConfig) {
+		c.first = n
+	}
+}
+
+// DissectPCAP decodes every frame of the pcap or pcapng file at path and
+// writes what was found to w, as a DissectedFrame JSON object per line. The
+// file is mapped in memory when it can be, the frames are decoded where
+// they are.
+func DissectPCAP(path string, w io.Writer, options ...DissectOption) error {
+	var c dissectConfig
+
+	for _, opt := range options {
+		opt(&c)
+	}
+
+	f, err := capture.OpenPcapFile(filepath.Clean(path), "")
+	if err != nil {
+		return err
+	}
+
+	defer f.Close() //nolint:errcheck // the file is only read
+
+	if !c.from.IsZero() || !c.to.IsZero() {
+		if err = f.SetTimeRange(c.from, c.to); err != nil {
+			return err
+		}
+	}
+
+	if c.first > 0 {
+		if err = f.SeekRecord(c.first); err != nil {
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(w)
+
+	for {
+		frame, md, err := f.NextFrame()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed reading frame %d: %w", f.Record()+1, err)
+		}
+
+		err = enc.Encode(DissectedFrame{
+			Frame:    f.Record(),
+			Time:     md.Timestamp.UTC(),
+			Length:   md.Length,
+			Captured: md.CaptureLength,
+			Decoded:  conformance.Decode(frame),
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
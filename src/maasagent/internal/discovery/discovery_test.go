@@ -0,0 +1,432 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/netif"
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+func TestScanSubnet(t *testing.T) {
+	t.Parallel()
+
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	var batches [][]netip.Addr
+
+	scan := func(_ context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		batches = append(batches, ips)
+
+		found := make(map[netip.Addr]net.HardwareAddr, len(ips))
+		for _, ip := range ips {
+			found[ip] = nil
+		}
+
+		// only the odd addresses reply
+		for _, ip := range ips {
+			if ip.As4()[3]%2 == 1 {
+				found[ip] = mac
+			}
+		}
+
+		return found, nil
+	}
+
+	hosts, err := ScanSubnet(context.Background(), "lo", "127.0.0.0/29",
+		WithScanner(scan), WithBatchSize(4), WithBatchInterval(0))
+	require.NoError(t, err)
+
+	src := netip.MustParseAddr("127.0.0.1")
+
+	assert.Equal(t, []Host{
+		{IP: netip.MustParseAddr("127.0.0.1"), MAC: mac, Source: src},
+		{IP: netip.MustParseAddr("127.0.0.3"), MAC: mac, Source: src},
+		{IP: netip.MustParseAddr("127.0.0.5"), MAC: mac, Source: src},
+	}, hosts)
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 4)
+	assert.Len(t, batches[1], 2)
+}
+
+func TestScanSubnetSource(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		options []netif.SourceOption
+		src     netip.Addr
+	}{
+		"selected": {
+			src: netip.MustParseAddr("127.0.0.1"),
+		},
+		"override": {
+			options: []netif.SourceOption{netif.WithSourceOverride(netip.MustParseAddr("127.0.0.2"))},
+			src:     netip.MustParseAddr("127.0.0.2"),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var sources []netip.Addr
+
+			scan := func(_ context.Context, src netip.Addr, _ []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+				sources = append(sources, src)
+
+				return nil, nil
+			}
+
+			_, err := ScanSubnet(context.Background(), "lo", "127.0.0.0/30",
+				WithSourceScanner(scan), WithSourceOptions(tc.options...), WithBatchInterval(0))
+			require.NoError(t, err)
+			assert.Equal(t, []netip.Addr{tc.src}, sources)
+		})
+	}
+}
+
+func TestScanSubnetErrors(t *testing.T) {
+	t.Parallel()
+
+	errScan := errors.New("scan failed")
+
+	testcases := map[string]struct {
+		iface string
+		cidr  string
+		scan  error
+		err   error
+	}{
+		"not on link": {
+			iface: "lo",
+			cidr:  "192.0.2.0/24",
+			err:   ErrNotOnLink,
+		},
+		"scan failure": {
+			iface: "lo",
+			cidr:  "127.0.0.0/30",
+			scan:  errScan,
+			err:   errScan,
+		},
+		"mapped and IPv6": {
+			iface: "lo",
+			cidr:  "::ffff:0.0.0.0/64",
+			err:   addrutil.ErrInvalidPrefix,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			scan := func(context.Context, []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+				return nil, tc.scan
+			}
+
+			_, err := ScanSubnet(context.Background(), tc.iface, tc.cidr, WithScanner(scan))
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	_, err := ScanSubnet(context.Background(), "lo", "127.0.0.0")
+	assert.Error(t, err)
+
+	_, err = ScanSubnet(context.Background(), "nonexistent0", "127.0.0.0/30")
+	assert.Error(t, err)
+}
+
+// TestScanSubnetMapped scans a subnet of IPv4-mapped addresses as the IPv4
+// subnet they map
+func TestScanSubnetMapped(t *testing.T) {
+	t.Parallel()
+
+	var probed []netip.Addr
+
+	scan := func(_ context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		probed = append(probed, ips...)
+		return nil, nil
+	}
+
+	_, err := ScanSubnet(context.Background(), "lo", "::ffff:127.0.0.0/126", WithScanner(scan))
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("127.0.0.2")}, probed)
+}
+
+func TestScanSubnetCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	scan := func(context.Context, []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		cancel()
+		return nil, nil
+	}
+
+	_, err := ScanSubnet(ctx, "lo", "127.0.0.0/28", WithScanner(scan), WithBatchSize(4),
+		WithBatchInterval(time.Hour))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestStreamSubnet(t *testing.T) {
+	t.Parallel()
+
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	var late func(netmon.ScanReply)
+
+	// the odd addresses reply in reverse order, twice, along with a host
+	// which wasn't probed
+	scan := func(_ context.Context, _ netip.Addr, ips []netip.Addr, found func(netmon.ScanReply)) error {
+		for range 2 {
+			for i := len(ips) - 1; i >= 0; i-- {
+				if ips[i].As4()[3]%2 == 1 {
+					found(netmon.ScanReply{IP: ips[i], MAC: mac, RTT: time.Duration(i+1) * time.Millisecond})
+				}
+			}
+		}
+
+		found(netmon.ScanReply{IP: netip.MustParseAddr("127.0.0.100"), MAC: mac})
+
+		late = found
+
+		return nil
+	}
+
+	var updates []ScanUpdate
+
+	summary, err := StreamSubnet(context.Background(), "lo", "127.0.0.0/29", func(u ScanUpdate) {
+		updates = append(updates, u)
+	}, WithStreamScanner(scan), WithBatchSize(4), WithBatchInterval(time.Millisecond))
+	require.NoError(t, err)
+
+	// a reply after the scanner returned is ignored
+	late(netmon.ScanReply{IP: netip.MustParseAddr("127.0.0.4"), MAC: mac})
+
+	src := netip.MustParseAddr("127.0.0.1")
+	host := func(ip string, rtt time.Duration) *Host {
+		return &Host{IP: netip.MustParseAddr(ip), MAC: mac, Source: src, RTT: rtt}
+	}
+
+	assert.Equal(t, []ScanUpdate{
+		{Host: host("127.0.0.3", 3*time.Millisecond)},
+		{Host: host("127.0.0.1", time.Millisecond)},
+		{Progress: &ScanProgress{Total: 6, Probed: 4, Found: 2, Outstanding: 2}},
+		{Host: host("127.0.0.5", time.Millisecond)},
+		{Progress: &ScanProgress{Total: 6, Probed: 6, Found: 3, Outstanding: 1, Throttled: time.Millisecond}},
+		{Progress: &ScanProgress{Total: 6, Probed: 6, Found: 3, Throttled: time.Millisecond, Done: true}},
+	}, updates)
+
+	assert.Equal(t, ScanSummary{
+		Hosts: []Host{
+			*host("127.0.0.1", time.Millisecond),
+			*host("127.0.0.3", 3*time.Millisecond),
+			*host("127.0.0.5", time.Millisecond),
+		},
+		ScanProgress: *updates[len(updates)-1].Progress,
+	}, summary)
+}
+
+func TestStreamSubnetCancelled(t *testing.T) {
+	t.Parallel()
+
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var batches int
+
+	// the scan is cancelled while the second batch is in flight
+	scan := func(_ context.Context, _ netip.Addr, ips []netip.Addr, found func(netmon.ScanReply)) error {
+		batches++
+
+		found(netmon.ScanReply{IP: ips[0], MAC: mac})
+
+		if batches == 2 {
+			cancel()
+		}
+
+		return nil
+	}
+
+	var updates []ScanUpdate
+
+	summary, err := StreamSubnet(ctx, "lo", "127.0.0.0/28", func(u ScanUpdate) {
+		updates = append(updates, u)
+	}, WithStreamScanner(scan), WithBatchSize(4), WithBatchInterval(0))
+	require.ErrorIs(t, err, context.Canceled)
+
+	assert.Equal(t, 2, batches)
+	assert.Len(t, summary.Hosts, 2)
+
+	// the stream ends with a progress which is done, nothing follows it
+	require.Len(t, updates, 5)
+	assert.Equal(t, &ScanProgress{Total: 14, Probed: 8, Found: 2, Done: true}, updates[4].Progress)
+	assert.Equal(t, summary.ScanProgress, *updates[4].Progress)
+}
+
+func TestWatchNoInterfaces(t *testing.T) {
+	t.Parallel()
+
+	assert.ErrorIs(t, Watch(context.Background(), nil, func(Event) {}), ErrNoInterfaces)
+}
+
+func TestEventJSON(t *testing.T) {
+	t.Parallel()
+
+	data, err := json.Marshal(Event{Interface: "eth0", Result: netmon.Result{
+		IP:    "10.0.0.2",
+		MAC:   "00:16:3e:00:00:01",
+		Time:  1700000000,
+		Event: netmon.EventNew,
+	}})
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"interface": "eth0",
+		"vid": null,
+		"ip": "10.0.0.2",
+		"mac": "00:16:3e:00:00:01",
+		"time": 1700000000,
+		"event": "NEW"
+	}`, string(data))
+}
+
+func TestDissectPCAP(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "frames.pcap")
+
+	var buf bytes.Buffer
+
+	w, err := capture.NewPcapWriter(&buf, 65535)
+	require.NoError(t, err)
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	arp, err := ethernet.NewFrame().Src(src).ARPRequest(netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")).Build()
+	require.NoError(t, err)
+
+	vlan, err := ethernet.NewFrame().Src(src).VLAN(100).Payload(ethernet.EthernetTypeIPv4, []byte{0x45}).Build()
+	require.NoError(t, err)
+
+	require.NoError(t, w.WriteFrame(arp, capture.Metadata{Timestamp: time.Unix(1700000000, 0), Length: len(arp)}))
+	require.NoError(t, w.WriteFrame(vlan[:18], capture.Metadata{Timestamp: time.Unix(1700000001, 0), Length: 64}))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+
+	var out bytes.Buffer
+
+	require.NoError(t, DissectPCAP(path, &out))
+
+	dec := json.NewDecoder(&out)
+
+	var frames []DissectedFrame
+
+	for dec.More() {
+		var f DissectedFrame
+
+		require.NoError(t, dec.Decode(&f))
+
+		frames = append(frames, f)
+	}
+
+	require.Len(t, frames, 2)
+	assert.Equal(t, 1, frames[0].Frame)
+	assert.NotNil(t, frames[0].Decoded.ARP)
+	assert.Equal(t, 2, frames[1].Frame)
+	assert.Equal(t, 64, frames[1].Length)
+	assert.Equal(t, 18, frames[1].Captured)
+	require.NotNil(t, frames[1].Decoded.VLAN)
+	assert.Equal(t, time.Unix(1700000001, 0).UTC(), frames[1].Time)
+}
+
+func TestDissectPCAPRange(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "frames.pcap")
+
+	var buf bytes.Buffer
+
+	w, err := capture.NewPcapWriter(&buf, 65535)
+	require.NoError(t, err)
+
+	for i := range 4 {
+		arp, err := ethernet.NewFrame().Src(net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}).
+			ARPRequest(netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")).Build()
+		require.NoError(t, err)
+		require.NoError(t, w.WriteFrame(arp, capture.Metadata{
+			Timestamp: time.Unix(1700000000+int64(i), 0), Length: len(arp),
+		}))
+	}
+
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+
+	dissected := func(options ...DissectOption) []int {
+		var out bytes.Buffer
+
+		require.NoError(t, DissectPCAP(path, &out, options...))
+
+		var numbers []int
+
+		for dec := json.NewDecoder(&out); dec.More(); {
+			var f DissectedFrame
+
+			require.NoError(t, dec.Decode(&f))
+
+			numbers = append(numbers, f.Frame)
+		}
+
+		return numbers
+	}
+
+	assert.Equal(t, []int{1, 2, 3, 4}, dissected())
+	assert.Equal(t, []int{2, 3}, dissected(WithDissectTimeRange(time.Unix(1700000001, 0), time.Unix(1700000003, 0))))
+	assert.Equal(t, []int{3, 4}, dissected(WithDissectTimeRange(time.Unix(1700000002, 0), time.Time{})))
+	assert.Equal(t, []int{4}, dissected(WithDissectFirstRecord(3)))
+	assert.Equal(t, []int{3}, dissected(WithDissectFirstRecord(2), WithDissectTimeRange(time.Time{}, time.Unix(1700000003, 0))))
+
+	var out bytes.Buffer
+
+	assert.ErrorIs(t, DissectPCAP(path, &out, WithDissectFirstRecord(5)), capture.ErrRecordOutOfRange)
+}
+
+func TestDissectPCAPErrors(t *testing.T) {
+	t.Parallel()
+
+	assert.ErrorIs(t, DissectPCAP(filepath.Join(t.TempDir(), "missing.pcap"), &bytes.Buffer{}), os.ErrNotExist)
+
+	path := filepath.Join(t.TempDir(), "garbage.pcap")
+	require.NoError(t, os.WriteFile(path, []byte("not a pcap file"), 0o600))
+	assert.Error(t, DissectPCAP(path, &bytes.Buffer{}))
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package discovery_test
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/discovery"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+// ScanSubnet needs the privileges to send ICMP probes and to capture the
+// replies, so this example isn't run
+func ExampleScanSubnet() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	hosts, err := discovery.ScanSubnet(ctx, "eth0", "192.168.1.0/24")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, h := range hosts {
+		fmt.Println(h.IP, h.MAC)
+	}
+}
+
+// A smaller batch every second scans a subnet more gently
+func ExampleScanSubnet_paced() {
+	hosts, err := discovery.ScanSubnet(context.Background(), "eth0", "10.0.0.0/22",
+		discovery.WithBatchSize(16), discovery.WithBatchInterval(time.Second))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(len(hosts), "hosts replied")
+}
+
+// StreamSubnet shows the hosts of a large subnet as they reply, and how far
+// the scan got after every batch
+func ExampleStreamSubnet() {
+	summary, err := discovery.StreamSubnet(context.Background(), "eth0", "10.0.0.0/16", func(u discovery.ScanUpdate) {
+		switch {
+		case u.Host != nil:
+			fmt.Println(u.Host.IP, u.Host.MAC, u.Host.RTT)
+		case u.Progress != nil:
+			fmt.Printf("%d/%d probed, %d found\n", u.Progress.Probed, u.Progress.Total, u.Progress.Found)
+		}
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(len(summary.Hosts), "hosts replied")
+}
+
+// Watch runs until the context is done, here for ten minutes
+func ExampleWatch() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	err := discovery.Watch(ctx, []string{"eth0", "eth1"}, func(e discovery.Event) {
+		fmt.Println(e.Interface, e.Event, e.IP, e.MAC)
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func ExampleDissectPCAP() {
+	dir, err := os.MkdirTemp("", "dissect")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	defer os.RemoveAll(dir) //nolint:errcheck // example cleanup
+
+	path := filepath.Join(dir, "arp.pcap")
+
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	frame, err := ethernet.NewFrame().
+		Src([]byte{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}).
+		ARPRequest(netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")).
+		Build()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	w, err := capture.NewPcapWriter(f, 65535)
+	if err == nil {
+		err = w.WriteFrame(frame, capture.Metadata{Timestamp: time.Unix(1700000000, 0), Length: len(frame)})
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if err = f.Close(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if err = discovery.DissectPCAP(path, os.Stdout); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// {"time":"2023-11-14T22:13:20Z","decoded":{"ethernet":{"dst":"ff:ff:ff:ff:ff:ff","src":"00:16:3e:00:00:01","ethertype":"ARP","payload_len":28},"arp":{"sender_mac":"00:16:3e:00:00:01","sender_ip":"10.0.0.2","target_mac":"00:00:00:00:00:00","target_ip":"10.0.0.1","op":1}},"frame":1,"length":42,"captured":42}
+}
@@ -0,0 +1,1166 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/dispatch"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/lifecycle"
+	"maas.io/core/src/maasagent/internal/netif"
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+var (
+	// ErrInvalidProfile is returned when applying a Profile which can't be
+	// run
+	ErrInvalidProfile = errors.New("invalid capture profile")
+	// ErrNotCaptured is returned for an interface without a Profile
+	ErrNotCaptured = errors.New("interface not captured")
+)
+
+// Profile is the configuration of the capture of an interface. Changing the
+// Target, the Scans, the EventRate, the Labels or the NativeVLAN of a
+// running capture doesn't restart it, changing any other field does.
+type Profile struct {
+	// Target restricts the capture to the frames of some hosts
+	Target capture.Target
+	// Scans are the jobs probing the segment of the interface, their
+	// Interface is set to it. Probing is disabled without any.
+	Scans []netmon.ScanJob
+	// EventRate is the number of events published per second for the
+	// interface, the others are dropped. 0 doesn't limit them.
+	EventRate int
+	// Labels attribute the events and the bindings of the interface, such
+	// as to its fabric, space or zone, see netmon.WithLabels
+	Labels map[string]string
+	// NativeVLAN is the VLAN the frames the interface receives untagged are
+	// attributed to, the native VLAN of the trunk it is connected to, see
+	// netmon.Service.SetNativeVLAN. 0 attributes them to none.
+	NativeVLAN uint16
+	// Membership widens what the interface receives for the capture, see
+	// RequiredMembership
+	Membership capture.Membership
+	// Parser sets how the frames of the interface are decoded, see
+	// ethernet.Strictness. The shared detectors are configured on the
+	// Multiplexer.
+	Parser ethernet.ParserOptions
+	// Decoders are the protocols the frames of the interface are decoded
+	// for, netmon.DefaultDecoders and those of the detectors of the Profile
+	// when zero
+	Decoders netmon.DecoderSet
+	// Promiscuous captures the frames sent to other hosts, as
+	// capture.MembershipPromiscuous does
+	Promiscuous bool
+	// OwnTraffic observes the frames sent by the host like the others
+	OwnTraffic bool
+	// DetectDuplicates reports the MACs seen on more than one interface
+	DetectDuplicates bool
+	// RecordEvidence attaches the recent history of a binding to the
+	// events about it
+	RecordEvidence bool
+	// DetectDAD reports the IPv6 addresses a host probed but another one
+	// defended
+	DetectDAD bool
+	// DetectProxies marks the bindings learned from the proxy-ARP and NDP
+	// proxy routers of the interface
+	DetectProxies bool
+	// DetectPortAuth reports the segments where PXE is likely blocked by
+	// 802.1X port authentication
+	DetectPortAuth bool
+	// AttributeIngress captures the member ports of the interface, a bridge
+	// or bond, to tell which one the frames of its Events entered on, see
+	// netmon.PortAttributor. The members are those of the interface when the
+	// capture starts; without any, the frames are attributed to the
+	// interface itself.
+	AttributeIngress bool
+	// CheckHosts answers Multiplexer.CheckHost for the interface, from the
+	// bindings fresh enough or by probing the host, see netmon.HostChecker
+	CheckHosts bool
+	// PrimeNeighbors answers Multiplexer.PrimeNeighbor for the interface,
+	// installing the bindings of the hosts about to boot into the kernel
+	// neighbor cache, see netmon.Primer
+	PrimeNeighbors bool
+}
+
+// Validate returns an error if the Profile can't be run
+func (p Profile) Validate() error {
+	if _, err := capture.TargetFilter(p.Target, nil); err != nil {
+		return err
+	}
+
+	if p.EventRate < 0 {
+		return fmt.Errorf("negative event rate %d", p.EventRate)
+	}
+
+	if err := netmon.ValidateLabels(p.Labels); err != nil {
+		return err
+	}
+
+	if p.NativeVLAN > 4094 {
+		return fmt.Errorf("%w: %d", netmon.ErrInvalidNativeVLAN, p.NativeVLAN)
+	}
+
+	if err := p.Parser.Validate(); err != nil {
+		return err
+	}
+
+	ids := make(map[string]struct{}, len(p.Scans))
+
+	for _, job := range p.Scans {
+		if err := job.Validate(); err != nil {
+			return err
+		}
+
+		if _, ok := ids[job.ID]; ok {
+			return fmt.Errorf("%w: %s", netmon.ErrDuplicateScanJob, job.ID)
+		}
+
+		ids[job.ID] = struct{}{}
+	}
+
+	return nil
+}
+
+// RequiredMembership returns the narrowest membership in which the
+// detectors of the Profile see what they need. The bindings are learned
+// from the ARP requests and the NDP messages to the all-nodes group; DAD
+// probes go to the solicited-node groups of addresses not known in
+// advance; the replies of proxies and the OFFERs of DHCP servers are
+// unicast to other hosts.
+func (p Profile) RequiredMembership() capture.Membership {
+	switch {
+	case p.DetectProxies || p.DetectPortAuth:
+		return capture.MembershipPromiscuous
+	case p.DetectDAD:
+		return capture.MembershipAllMulticast
+	default:
+		return capture.MembershipGroups
+	}
+}
+
+// membership returns the mode the capture of the Profile runs in
+func (p Profile) membership() capture.Membership {
+	if p.Promiscuous {
+		return capture.MembershipPromiscuous
+	}
+
+	return p.Membership
+}
+
+// decoders returns the protocols the capture of the Profile decodes
+func (p Profile) decoders() netmon.DecoderSet {
+	if p.Decoders != 0 {
+		return p.Decoders
+	}
+
+	if p.DetectPortAuth {
+		return netmon.DefaultDecoders | netmon.DecoderEAPOL
+	}
+
+	return netmon.DefaultDecoders
+}
+
+// serviceConfig holds the fields of a Profile the Service is created with,
+// a capture is restarted when they change
+type serviceConfig struct {
+	membership       capture.Membership
+	parser           ethernet.ParserOptions
+	decoders         netmon.DecoderSet
+	ownTraffic       bool
+	detectDuplicates bool
+	recordEvidence   bool
+	detectDAD        bool
+	detectProxies    bool
+	detectPortAuth   bool
+	attributeIngress bool
+	checkHosts       bool
+	primeNeighbors   bool
+}
+
+func (p Profile) serviceConfig() serviceConfig {
+	return serviceConfig{
+		membership:       p.membership(),
+		parser:           p.Parser,
+		decoders:         p.decoders(),
+		ownTraffic:       p.OwnTraffic,
+		detectDuplicates: p.DetectDuplicates,
+		recordEvidence:   p.RecordEvidence,
+		detectDAD:        p.DetectDAD,
+		detectProxies:    p.DetectProxies,
+		detectPortAuth:   p.DetectPortAuth,
+		attributeIngress: p.AttributeIngress,
+		checkHosts:       p.CheckHosts,
+		primeNeighbors:   p.PrimeNeighbors,
+	}
+}
+
+// eventLimiter lets through at most rate events per second, the count is
+// reset at the start of every second
+type eventLimiter struct {
+	clock clock.Clock
+	start time.Time
+	rate  int
+	count int
+	mu    sync.Mutex
+}
+
+func (l *eventLimiter) setRate(rate int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = rate
+}
+
+func (l *eventLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return true
+	}
+
+	if now := l.clock.Now(); now.Sub(l.start) >= time.Second {
+		l.start, l.count = now, 0
+	}
+
+	if l.count >= l.rate {
+		return false
+	}
+
+	l.count++
+
+	return true
+}
+
+// startFunc runs the capture of a Service until ctx is done, like
+// netmon.Service.Start
+type startFunc func(ctx context.Context, iface string, svc *netmon.Service, resultC chan<- netmon.Result) error
+
+// memberFunc records the frames of port, a member of iface, in the
+// PortAttributor of svc until ctx is done, like
+// netmon.Service.CaptureMember
+type memberFunc func(ctx context.Context, iface, port string, svc *netmon.Service) error
+
+// profiledCapture is the running capture of an interface, done is closed
+// once it stopped
+type profiledCapture struct {
+	svc     *netmon.Service
+	limiter *eventLimiter
+	cancel  context.CancelFunc
+	done    chan struct{}
+	profile Profile
+}
+
+// MultiplexerOption configures a Multiplexer
+type MultiplexerOption func(*Multiplexer)
+
+// WithSchedulerOptions configures the Scheduler running the scans of the
+// profiles
+func WithSchedulerOptions(options ...netmon.SchedulerOption) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.schedulerOpts = append(m.schedulerOpts, options...)
+	}
+}
+
+// WithScanCoordination coordinates the scans of the profiles with those of
+// the other agents of their segments through c, see netmon.ScanCoordinator.
+// Every capture sends and receives the intents, the Scheduler announces
+// its runs and defers those a peer scans.
+func WithScanCoordination(c *netmon.ScanCoordinator) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.coordinator = c
+		m.schedulerOpts = append(m.schedulerOpts, netmon.WithSchedulerCoordinator(c))
+	}
+}
+
+// WithProxyDetectorOptions configures the ProxyDetector shared by the
+// profiles detecting proxies, such as its threshold or the known proxies
+func WithProxyDetectorOptions(options ...netmon.ProxyDetectorOption) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.proxyOpts = append(m.proxyOpts, options...)
+	}
+}
+
+// WithPortAuthDetectorOptions configures the PortAuthDetector shared by the
+// profiles detecting port authentication, such as its window
+func WithPortAuthDetectorOptions(options ...netmon.PortAuthDetectorOption) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.portAuthOpts = append(m.portAuthOpts, options...)
+	}
+}
+
+// WithWakerOptions configures the Waker of Wake, such as its window and
+// retries
+func WithWakerOptions(options ...netmon.WakerOption) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.wakerOpts = append(m.wakerOpts, options...)
+	}
+}
+
+// WithHostCheckerOptions configures the HostChecker of CheckHost, such as
+// the freshness of the bindings and the probes sent
+func WithHostCheckerOptions(options ...netmon.HostCheckOption) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.checkerOpts = append(m.checkerOpts, options...)
+	}
+}
+
+// WithPrimerOptions configures the Primer of PrimeNeighbor, such as the
+// window of the deployments and the confirmation of the primed neighbors
+func WithPrimerOptions(options ...netmon.PrimerOption) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.primerOpts = append(m.primerOpts, options...)
+	}
+}
+
+// WithPortAttributorOptions configures the PortAttributor shared by the
+// profiles attributing ingress, such as its window
+func WithPortAttributorOptions(options ...netmon.PortAttributorOption) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.ingressOpts = append(m.ingressOpts, options...)
+	}
+}
+
+// WithHistory records the results of every capture in h, the captures
+// keep no history without
+func WithHistory(h *netmon.History) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.history = h
+	}
+}
+
+// WithLimits bounds the state the captures and the detectors they share
+// keep per remote host, in place of netmon.DefaultLimits
+func WithLimits(l netmon.Limits) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.limits = l
+	}
+}
+
+// WithEventSeverities overrides the netmon.Severity of the events of
+// severities, the others keep that of netmon.DefaultSeverities
+func WithEventSeverities(severities map[netmon.Event]netmon.Severity) MultiplexerOption {
+	return func(m *Multiplexer) {
+		maps.Copy(m.severities, severities)
+	}
+}
+
+// WithDeduplication suppresses the frames captured on more than one
+// interface, such as a bridge and its member port, before they reach the
+// detectors or are published twice, see netmon.Deduplicator. The frames
+// are remembered within the limits of WithLimits.
+func WithDeduplication(options ...netmon.DeduplicatorOption) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.deduplicate = true
+		m.dedupOpts = append(m.dedupOpts, options...)
+	}
+}
+
+// WithReordering orders the observations the captures feed the detectors
+// correlating interfaces, the duplicate MACs and the DAD conflicts, by
+// their capture timestamp rather than the order the captures deliver
+// them, see netmon.Reorderer. The observations are held within the limits
+// of WithLimits.
+func WithReordering(options ...netmon.ReordererOption) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.reordering = true
+		m.reorderOpts = append(m.reorderOpts, options...)
+	}
+}
+
+// WithFrameSource makes the Services observe the frames of the reader open
+// returns for their interface rather than capture them, such as those of a
+// simulated segment, see netmon.Service.Serve. The member ports attributing
+// ingress are read from open as well. open is called whenever a capture
+// starts, the reader is closed once it stops.
+func WithFrameSource(open func(iface string) (capture.FrameReader, error)) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.member = func(ctx context.Context, iface, port string, _ *netmon.Service) error {
+			r, err := open(port)
+			if err != nil {
+				return err
+			}
+
+			defer r.Close() //nolint:errcheck // nothing is read from r anymore
+
+			return m.ingress.Serve(ctx, iface, port, r)
+		}
+
+		m.start = func(ctx context.Context, iface string, svc *netmon.Service, resultC chan<- netmon.Result) error {
+			r, err := open(iface)
+			if err != nil {
+				close(resultC)
+				return err
+			}
+
+			defer r.Close() //nolint:errcheck // nothing is read from r anymore
+
+			return svc.Serve(ctx, r, resultC)
+		}
+	}
+}
+
+// WithCapabilityCheck sets how Run checks the process may capture,
+// capture.CheckRawAccess otherwise, see netmon.DetectCapabilities
+func WithCapabilityCheck(check func() error) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.check = check
+	}
+}
+
+// WithTransmitGate shares g with the captures, the scans and the wakes of
+// the Multiplexer, which transmit nothing while it is in standby, see
+// Promote and Demote. It is active otherwise.
+func WithTransmitGate(g *netmon.TransmitGate) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.gate = g
+	}
+}
+
+// WithMultiplexerClock sets the clock the event rates are measured with
+func WithMultiplexerClock(c clock.Clock) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.clock = c
+	}
+}
+
+// Multiplexer runs a capture per interface, each with its own Profile, and
+// publishes their Results as Events. The profiles can be changed while it
+// runs.
+type Multiplexer struct {
+	// ctx is the context of the running captures, kept for those
+	// ApplyProfiles starts, nil unless Run is capturing. mu protects it,
+	// the profiles and the captures.
+	ctx        context.Context
+	clock      clock.Clock
+	inv        *netif.Inventory
+	self       *netif.SelfMACs
+	duplicates *netmon.DuplicateMACDetector
+	evidence   *netmon.EvidenceLog
+	dad        *netmon.DADDetector
+	proxies    *netmon.ProxyDetector
+	portAuth   *netmon.PortAuthDetector
+	waker      *netmon.Waker
+	checker    *netmon.HostChecker
+	primer     *netmon.Primer
+	identities *netmon.Correlator
+	history    *netmon.History
+	dedup      *netmon.Deduplicator
+	reorder    *netmon.Reorderer
+	ingress    *netmon.PortAttributor
+	events     *dispatch.Dispatcher[Event]
+	scheduler  *netmon.Scheduler
+	gate       *netmon.TransmitGate
+	profiles   map[string]Profile
+	captures   map[string]*profiledCapture
+	// severities grade the Events published
+	severities map[netmon.Event]netmon.Severity
+	// capabilities are those Run found missing
+	capabilities netmon.Capabilities
+	check        func() error
+	// coordinator is shared by the captures and the Scheduler, nil
+	// without WithScanCoordination
+	coordinator *netmon.ScanCoordinator
+	// failed receives the error of the first capture stopping on its own
+	failed        chan error
+	start         startFunc
+	member        memberFunc
+	members       func(iface string) []string
+	schedulerOpts []netmon.SchedulerOption
+	proxyOpts     []netmon.ProxyDetectorOption
+	portAuthOpts  []netmon.PortAuthDetectorOption
+	wakerOpts     []netmon.WakerOption
+	checkerOpts   []netmon.HostCheckOption
+	primerOpts    []netmon.PrimerOption
+	dedupOpts     []netmon.DeduplicatorOption
+	reorderOpts   []netmon.ReordererOption
+	ingressOpts   []netmon.PortAttributorOption
+	limits        netmon.Limits
+	mu            sync.Mutex
+	deduplicate   bool
+	reordering    bool
+}
+
+// NewMultiplexer returns a Multiplexer without any interface
+func NewMultiplexer(options ...MultiplexerOption) *Multiplexer {
+	inv := netif.NewInventory()
+
+	m := &Multiplexer{
+		clock:      clock.System{},
+		inv:        inv,
+		self:       netif.NewSelfMACs(inv),
+		limits:     netmon.DefaultLimits(),
+		events:     dispatch.NewDispatcher[Event](),
+		profiles:   make(map[string]Profile),
+		captures:   make(map[string]*profiledCapture),
+		severities: netmon.DefaultSeverities(),
+		failed:     make(chan error, 1),
+		check:      capture.CheckRawAccess,
+		start: func(ctx context.Context, _ string, svc *netmon.Service, resultC chan<- netmon.Result) error {
+			return svc.Start(ctx, resultC)
+		},
+		member: func(ctx context.Context, _, port string, svc *netmon.Service) error {
+			return svc.CaptureMember(ctx, port)
+		},
+		members: func(iface string) []string {
+			return members(inv, iface)
+		},
+	}
+
+	for _, opt := range options {
+		opt(m)
+	}
+
+	if m.gate == nil {
+		m.gate = netmon.NewTransmitGate(netmon.ModeActive)
+	}
+
+	m.duplicates = netmon.NewDuplicateMACDetector(netmon.WithLinkSource(inv), netmon.WithDuplicateLimits(m.limits))
+	m.evidence = netmon.NewEvidenceLog(netmon.WithEvidenceLimits(m.limits))
+	m.dad = netmon.NewDADDetector(netmon.WithDADLimits(m.limits))
+	m.proxies = netmon.NewProxyDetector(append([]netmon.ProxyDetectorOption{netmon.WithProxyLimits(m.limits)},
+		m.proxyOpts...)...)
+	m.portAuth = netmon.NewPortAuthDetector(m.portAuthOpts...)
+	m.ingress = netmon.NewPortAttributor(append([]netmon.PortAttributorOption{netmon.WithIngressLimits(m.limits)},
+		m.ingressOpts...)...)
+	m.waker = netmon.NewWaker(append([]netmon.WakerOption{netmon.WithWakeGuard(capture.WithGuardSource(inv))},
+		m.wakerOpts...)...)
+	m.checker = netmon.NewHostChecker(m.checkerOpts...)
+	m.primer = netmon.NewPrimer(inv, m.primerOpts...)
+	m.identities = netmon.NewCorrelator(netmon.WithCorrelatorClock(m.clock), netmon.WithIdentityLimits(m.limits))
+	m.scheduler = netmon.NewScheduler(append([]netmon.SchedulerOption{netmon.WithSourceSelection(inv),
+		netmon.WithSchedulerGate(m.gate)}, m.schedulerOpts...)...)
+
+	if m.deduplicate {
+		m.dedup = netmon.NewDeduplicator(append([]netmon.DeduplicatorOption{netmon.WithDedupLimits(m.limits)},
+			m.dedupOpts...)...)
+	}
+
+	if m.reordering {
+		m.reorder = netmon.NewReorderer(append([]netmon.ReordererOption{netmon.WithReorderLimits(m.limits)},
+			m.reorderOpts...)...)
+	}
+
+	return m
+}
+
+// Subscribe calls handler with the Events of every interface, see
+// dispatch.Dispatcher.Subscribe. WithMinSeverity and WithEventTypes leave
+// out the Events the subscriber doesn't want before they are queued.
+func (m *Multiplexer) Subscribe(name string, handler func(Event), options ...dispatch.SubscriberOption) error {
+	return m.events.Subscribe(name, handler, options...)
+}
+
+// WithMinSeverity subscribes to the Events of at least severity s
+func WithMinSeverity(s netmon.Severity) dispatch.SubscriberOption {
+	return dispatch.WithFilter(func(e Event) bool {
+		return e.Severity >= s
+	})
+}
+
+// WithEventTypes subscribes to the Events of one of events only
+func WithEventTypes(events ...netmon.Event) dispatch.SubscriberOption {
+	return dispatch.WithFilter(func(e Event) bool {
+		return slices.Contains(events, e.Event)
+	})
+}
+
+// ApplyProfiles sets the profiles of the interfaces, those not in profiles
+// are no longer captured. Only the captures whose profile changed in a way
+// the running Service can't follow are restarted. The invalid profiles are
+// reported in an error matching ErrInvalidProfile and leave the interface
+// as it was, the others are applied anyway.
+func (m *Multiplexer) ApplyProfiles(profiles map[string]Profile) error {
+	var errs []error
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	desired := make(map[string]Profile, len(profiles))
+
+	for _, iface := range slices.Sorted(maps.Keys(profiles)) {
+		p := profiles[iface]
+
+		if err := p.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%w for %s: %w", ErrInvalidProfile, iface, err))
+
+			if previous, ok := m.profiles[iface]; ok {
+				desired[iface] = previous
+			}
+
+			continue
+		}
+
+		desired[iface] = p
+	}
+
+	for iface, previous := range m.profiles {
+		if _, ok := desired[iface]; !ok {
+			m.removeScans(iface, previous.Scans)
+		}
+	}
+
+	for _, iface := range slices.Sorted(maps.Keys(desired)) {
+		if err := m.updateScans(iface, m.profiles[iface].Scans, desired[iface].Scans); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	m.profiles = desired
+
+	if m.ctx != nil {
+		errs = append(errs, m.reconcile())
+	}
+
+	return errors.Join(errs...)
+}
+
+// scheduledScan returns the job as added to the Scheduler, the profiles of
+// two interfaces may use the same IDs
+func scheduledScan(iface string, job netmon.ScanJob) netmon.ScanJob {
+	job.ID, job.Interface = iface+"/"+job.ID, iface
+
+	return job
+}
+
+func (m *Multiplexer) removeScans(iface string, jobs []netmon.ScanJob) {
+	for _, job := range jobs {
+		//nolint:errcheck // the job was added with the profile
+		m.scheduler.Remove(scheduledScan(iface, job).ID)
+	}
+}
+
+// updateScans replaces the jobs of previous which changed in jobs, the jobs
+// which didn't change aren't rescheduled
+func (m *Multiplexer) updateScans(iface string, previous, jobs []netmon.ScanJob) error {
+	current := make(map[string]netmon.ScanJob, len(previous))
+
+	for _, job := range previous {
+		job = scheduledScan(iface, job)
+		current[job.ID] = job
+	}
+
+	var errs []error
+
+	for _, job := range jobs {
+		job = scheduledScan(iface, job)
+
+		old, ok := current[job.ID]
+		delete(current, job.ID)
+
+		if ok && scanJobEqual(old, job) {
+			continue
+		}
+
+		if ok {
+			//nolint:errcheck // the job was added with the previous profile
+			m.scheduler.Remove(job.ID)
+		}
+
+		if err := m.scheduler.Add(job); err != nil {
+			errs = append(errs, fmt.Errorf("failed adding scan %s: %w", job.ID, err))
+		}
+	}
+
+	for id := range current {
+		//nolint:errcheck // the job was added with the previous profile
+		m.scheduler.Remove(id)
+	}
+
+	return errors.Join(errs...)
+}
+
+// reconcile starts, updates and stops the captures to match the profiles
+func (m *Multiplexer) reconcile() error {
+	var errs []error
+
+	for iface, c := range m.captures {
+		if _, ok := m.profiles[iface]; !ok {
+			c.stop()
+			delete(m.captures, iface)
+		}
+	}
+
+	for _, iface := range slices.Sorted(maps.Keys(m.profiles)) {
+		p := m.profiles[iface]
+
+		c, ok := m.captures[iface]
+		if ok && c.profile.serviceConfig() == p.serviceConfig() {
+			if err := c.svc.SetTarget(p.Target); err != nil {
+				errs = append(errs, fmt.Errorf("failed updating the target of %s: %w", iface, err))
+				continue
+			}
+
+			//nolint:errcheck // the profile has been validated
+			c.svc.SetLabels(p.Labels)
+			//nolint:errcheck // the profile has been validated
+			c.svc.SetNativeVLAN(p.NativeVLAN)
+
+			c.limiter.setRate(p.EventRate)
+			c.profile = p
+
+			continue
+		}
+
+		if ok {
+			c.stop()
+		}
+
+		m.captures[iface] = m.startCapture(iface, p)
+	}
+
+	return errors.Join(errs...)
+}
+
+// startCapture runs a Service for iface until it is stopped or m.ctx is done
+func (m *Multiplexer) startCapture(iface string, p Profile) *profiledCapture {
+	options := []netmon.ServiceOption{netmon.WithSelfMACs(m.self), netmon.WithLimits(m.limits),
+		netmon.WithTransmitGuard(capture.WithGuardSource(m.inv)), netmon.WithLabels(p.Labels),
+		netmon.WithDecoders(p.decoders()), netmon.WithCapabilities(m.capabilities),
+		netmon.WithCorrelator(m.identities), netmon.WithTransmitGate(m.gate)}
+
+	if m := p.membership(); m != capture.MembershipUnicast {
+		options = append(options, netmon.WithCaptureOptions(capture.WithMembership(m)))
+	}
+
+	if p.Parser != (ethernet.ParserOptions{}) {
+		options = append(options, netmon.WithParserOptions(p.Parser))
+	}
+
+	if p.NativeVLAN != 0 {
+		options = append(options, netmon.WithNativeVLAN(p.NativeVLAN))
+	}
+
+	if p.OwnTraffic {
+		options = append(options, netmon.WithOwnTraffic())
+	}
+
+	if p.DetectDuplicates {
+		options = append(options, netmon.WithDuplicateMACDetector(m.duplicates))
+	}
+
+	if p.RecordEvidence {
+		options = append(options, netmon.WithEvidenceLog(m.evidence))
+	}
+
+	if p.DetectDAD {
+		options = append(options, netmon.WithDADDetector(m.dad))
+	}
+
+	if p.DetectProxies {
+		options = append(options, netmon.WithProxyDetector(m.proxies))
+	}
+
+	if p.DetectPortAuth {
+		options = append(options, netmon.WithPortAuthDetector(m.portAuth))
+	}
+
+	if p.CheckHosts {
+		options = append(options, netmon.WithHostChecker(m.checker))
+	}
+
+	if p.PrimeNeighbors {
+		options = append(options, netmon.WithPrimer(m.primer))
+	}
+
+	if m.history != nil {
+		options = append(options, netmon.WithHistory(m.history))
+	}
+
+	if m.coordinator != nil {
+		options = append(options, netmon.WithScanCoordinator(m.coordinator))
+	}
+
+	if m.dedup != nil {
+		options = append(options, netmon.WithDeduplicator(m.dedup))
+	}
+
+	if m.reorder != nil {
+		options = append(options, netmon.WithReorderer(m.reorder))
+	}
+
+	var ports []string
+
+	// the member ports aren't captured either without CAP_NET_RAW
+	if p.AttributeIngress {
+		options = append(options, netmon.WithPortAttributor(m.ingress))
+
+		if !m.capabilities.Lacks(netmon.CapabilityNetRaw) {
+			ports = m.members(iface)
+		}
+	}
+
+	svc := netmon.NewService(iface, options...)
+
+	//nolint:errcheck // the profile has been validated and svc isn't capturing yet
+	svc.SetTarget(p.Target)
+
+	ctx, cancel := context.WithCancel(m.ctx)
+
+	c := &profiledCapture{
+		svc:     svc,
+		limiter: &eventLimiter{clock: m.clock, rate: p.EventRate},
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		profile: p,
+	}
+
+	go func() {
+		defer close(c.done)
+
+		var wg sync.WaitGroup
+		defer wg.Wait()
+
+		// a member port that can't be captured only loses the attribution
+		// of its frames, which fall back to the interface
+		for _, port := range ports {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				if err := m.member(ctx, iface, port, svc); err != nil && ctx.Err() == nil {
+					log.Warn().Err(err).Str("interface", iface).Str("port", port).
+						Msg("Member port not captured, its frames are attributed to the interface")
+				}
+			}()
+		}
+
+		resultC := make(chan netmon.Result)
+		errC := make(chan error, 1)
+
+		go func() { errC <- m.start(ctx, iface, svc, resultC) }()
+
+		for res := range resultC {
+			if c.limiter.allow() {
+				m.events.Publish(Event{
+					Result:    res,
+					Interface: iface,
+					Severity:  netmon.SeverityOf(m.severities, res.Event),
+				})
+			}
+		}
+
+		if err := <-errC; err != nil && ctx.Err() == nil {
+			select {
+			case m.failed <- fmt.Errorf("capture of %s failed: %w", iface, err):
+			default:
+			}
+		}
+	}()
+
+	return c
+}
+
+// stop stops the capture and waits for it to return
+func (c *profiledCapture) stop() {
+	c.cancel()
+	<-c.done
+}
+
+// runCaptures runs the captures of the profiles until ctx is done or one of
+// them fails
+func (m *Multiplexer) runCaptures(ctx context.Context) error {
+	m.mu.Lock()
+	m.ctx = ctx
+
+	if err := m.reconcile(); err != nil {
+		log.Warn().Err(err).Msg("Some capture profiles couldn't be applied")
+	}
+
+	m.mu.Unlock()
+
+	var err error
+
+	select {
+	case <-ctx.Done():
+	case err = <-m.failed:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ctx = nil
+
+	for iface, c := range m.captures {
+		c.stop()
+		delete(m.captures, iface)
+	}
+
+	return err
+}
+
+// Deduplication returns the frames each interface delivered and the pairs
+// of interfaces delivering the same ones, false without WithDeduplication
+func (m *Multiplexer) Deduplication() (netmon.DedupReport, bool) {
+	if m.dedup == nil {
+		return netmon.DedupReport{}, false
+	}
+
+	return m.dedup.Report(), true
+}
+
+// Reordering returns the counters of the ordering of the cross-interface
+// observations, false without WithReordering
+func (m *Multiplexer) Reordering() (netmon.ReorderStats, bool) {
+	if m.reorder == nil {
+		return netmon.ReorderStats{}, false
+	}
+
+	return m.reorder.Stats(), true
+}
+
+// Attribution returns the frames of each bridge or bond attributed to one of
+// its member ports and those attributed to itself
+func (m *Multiplexer) Attribution() map[string]netmon.IngressStats {
+	return m.ingress.Stats()
+}
+
+// tables returns the neighbor tables of the captures, without taking
+// snapshots of them
+func (m *Multiplexer) tables() []netmon.Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tables := make([]netmon.Snapshot, 0, len(m.captures))
+
+	for iface, c := range m.captures {
+		tables = append(tables, netmon.Snapshot{Interface: iface, Bindings: c.svc.Bindings()})
+	}
+
+	return tables
+}
+
+// Identities returns the hosts bound on the interfaces captured, each with
+// the addresses of its MACs on every segment, see netmon.Correlator
+func (m *Multiplexer) Identities() netmon.IdentitySnapshot {
+	return m.identities.Correlate(m.tables()...)
+}
+
+// UpdateIdentities returns the hosts bound on the interfaces captured, with
+// the events since the previous call
+func (m *Multiplexer) UpdateIdentities() (netmon.IdentitySnapshot, []netmon.IdentityEvent) {
+	return m.identities.Update(m.tables()...)
+}
+
+// members returns the names of the member ports of iface in inv
+func members(inv *netif.Inventory, iface string) []string {
+	master, ok := inv.LinkByName(iface)
+	if !ok {
+		return nil
+	}
+
+	var ports []string
+
+	for _, l := range inv.Links() {
+		if l.MasterIndex == master.Index {
+			ports = append(ports, l.Name)
+		}
+	}
+
+	return ports
+}
+
+// Wake sends Wake-on-LAN magic packets to mac on the interface and VLAN the
+// captures last saw it on, see netmon.Waker.Wake. A MAC none of them knows
+// returns an error matching netmon.ErrMACNotFound, and one matching
+// netmon.ErrMissingCapability is returned without CAP_NET_RAW.
+func (m *Multiplexer) Wake(ctx context.Context, mac net.HardwareAddr) (netmon.WakeOutcome, error) {
+	m.mu.Lock()
+
+	if err := m.capabilities.Err(netmon.CapabilityNetRaw); err != nil {
+		m.mu.Unlock()
+		return netmon.WakeOutcome{MAC: mac.String()}, err
+	}
+
+	services := make([]*netmon.Service, 0, len(m.captures))
+	for _, c := range m.captures {
+		services = append(services, c.svc)
+	}
+
+	m.mu.Unlock()
+
+	loc, err := netmon.LocateMAC(mac, services...)
+	if err != nil {
+		return netmon.WakeOutcome{MAC: mac.String()}, err
+	}
+
+	ctx, release, err := m.gate.Begin(ctx)
+	if err != nil {
+		return netmon.WakeOutcome{MAC: mac.String()}, err
+	}
+
+	defer release()
+
+	return m.waker.Wake(ctx, mac, loc)
+}
+
+// Mode returns whether the Multiplexer transmits, see Promote
+func (m *Multiplexer) Mode() netmon.Mode {
+	return m.gate.Mode()
+}
+
+// Promote makes the Multiplexer transmit again: the scans held run, the
+// announcements of the host refused in standby are sent, then an
+// EventModeChanged is published. ctx bounds the replay of the
+// announcements. Promoting an active Multiplexer does nothing.
+func (m *Multiplexer) Promote(ctx context.Context) (netmon.ModeChange, error) {
+	change, err := m.gate.Promote(ctx)
+	m.publishMode(change)
+
+	return change, err
+}
+
+// Demote holds the transmissions of the Multiplexer while the captures,
+// the parsing and the neighbor tables go on, and publishes an
+// EventModeChanged. The scans running are cancelled and waited for until
+// ctx is done, they run again once promoted. Demoting a Multiplexer in
+// standby does nothing.
+func (m *Multiplexer) Demote(ctx context.Context) (netmon.ModeChange, error) {
+	change, err := m.gate.Demote(ctx)
+	m.publishMode(change)
+
+	return change, err
+}
+
+// publishMode publishes the EventModeChanged of change, unless it changed
+// nothing
+func (m *Multiplexer) publishMode(change netmon.ModeChange) {
+	if !change.Changed() {
+		return
+	}
+
+	log.Info().Stringer("previous", change.Previous).Stringer("mode", change.Current).
+		Int("replayed", change.Replayed).Int("cancelled", change.Cancelled).Msg("Mode changed")
+
+	m.events.Publish(Event{
+		Result:   netmon.Result{Mode: &change, Time: m.clock.Now().Unix(), Event: netmon.EventModeChanged},
+		Severity: netmon.SeverityOf(m.severities, netmon.EventModeChanged),
+	})
+}
+
+// CheckHost tells whether the host with ip is up on the interface and vid,
+// nil for the untagged frames, and whether it answers with expected, which
+// may be nil, see netmon.Service.CheckHost. An interface without a Profile
+// returns an error matching ErrNotCaptured, and one whose Profile doesn't
+// have CheckHosts netmon.ErrHostCheckDisabled.
+func (m *Multiplexer) CheckHost(ctx context.Context, iface string, vid *uint16, ip netip.Addr,
+	expected net.HardwareAddr) (netmon.HostCheck, error) {
+	m.mu.Lock()
+	c, ok := m.captures[iface]
+	m.mu.Unlock()
+
+	if !ok {
+		return netmon.HostCheck{Interface: iface, IP: ip.String()}, fmt.Errorf("%w: %s", ErrNotCaptured, iface)
+	}
+
+	return c.svc.CheckHost(ctx, vid, ip, expected)
+}
+
+// PrimeNeighbor installs the binding of ip to mac on the interface and vid,
+// nil for the untagged frames, into the kernel neighbor cache ahead of the
+// host booting, see netmon.Service.PrimeNeighbor. An interface without a
+// Profile returns an error matching ErrNotCaptured, and one whose Profile
+// doesn't have PrimeNeighbors netmon.ErrPrimeDisabled.
+func (m *Multiplexer) PrimeNeighbor(ctx context.Context, iface string, vid *uint16, ip netip.Addr,
+	mac net.HardwareAddr) error {
+	m.mu.Lock()
+	c, ok := m.captures[iface]
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotCaptured, iface)
+	}
+
+	return c.svc.PrimeNeighbor(ctx, vid, ip, mac)
+}
+
+// Capabilities returns the capabilities the Multiplexer runs without, which
+// tell why its discovery data is partial
+func (m *Multiplexer) Capabilities() netmon.Capabilities {
+	m.mu.Lock()
+	caps := m.capabilities
+	m.mu.Unlock()
+
+	// the Primer finds out on its own it can't change the kernel cache
+	caps.Degraded = append(slices.Clone(caps.Degraded), m.primer.Capabilities().Degraded...)
+
+	return caps
+}
+
+// detectCapabilities checks what the process may do, and reports the
+// capabilities it lacks once
+func (m *Multiplexer) detectCapabilities() error {
+	caps, err := netmon.DetectCapabilities(m.check)
+	if err != nil {
+		return err
+	}
+
+	caps.Log()
+
+	m.mu.Lock()
+	m.capabilities = caps
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Run runs the captures and the scans of the profiles until ctx is done or
+// a capture fails. Without CAP_NET_RAW it runs degraded: the Services
+// capture nothing and no scan is sent, see Capabilities. In standby they
+// capture, but nothing is sent until Promote.
+func (m *Multiplexer) Run(ctx context.Context) error {
+	if err := m.detectCapabilities(); err != nil {
+		return err
+	}
+
+	g := lifecycle.NewGroup()
+	g.Add("inventory", m.inv)
+	g.Add("self-macs", m.self)
+	g.Add("dispatch", m.events)
+	g.Add("captures", lifecycle.RunnerFunc(m.runCaptures))
+	g.Add("primer", m.primer)
+
+	if !m.Capabilities().Lacks(netmon.CapabilityNetRaw) {
+		g.Add("scans", m.scheduler)
+	}
+
+	return g.Run(ctx)
+}
+
+// scanJobEqual returns true if the jobs are the same, ScanJob isn't
+// comparable
+func scanJobEqual(a, b netmon.ScanJob) bool {
+	return a.ID == b.ID && a.Interface == b.Interface &&
+		((a.VID == nil && b.VID == nil) || (a.VID != nil && b.VID != nil && *a.VID == *b.VID)) &&
+		slices.Equal(a.Targets, b.Targets) && slices.Equal(a.Blackouts, b.Blackouts) &&
+		a.Interval == b.Interval && a.MissThreshold == b.MissThreshold && a.FullRefresh == b.FullRefresh &&
+		a.Source == b.Source && slices.Equal(a.Encapsulation, b.Encapsulation)
+}
@@ -0,0 +1,868 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/dispatch"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/netif"
+	"maas.io/core/src/maasagent/internal/netmon"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+func scanJob(id string) netmon.ScanJob {
+	return netmon.ScanJob{
+		ID:       id,
+		Targets:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/30")},
+		Interval: time.Hour,
+	}
+}
+
+func TestProfileValidate(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		err     error
+		profile Profile
+	}{
+		"empty": {},
+		"complete": {
+			profile: Profile{
+				Target: capture.Target{
+					MACs: []net.HardwareAddr{{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}},
+					IPs:  []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+				},
+				Scans:            []netmon.ScanJob{scanJob("a"), scanJob("b")},
+				EventRate:        10,
+				Promiscuous:      true,
+				DetectDuplicates: true,
+			},
+		},
+		"invalid target": {
+			profile: Profile{Target: capture.Target{MACs: []net.HardwareAddr{{0x01}}}},
+			err:     capture.ErrInvalidTarget,
+		},
+		"IPv4-mapped target": {
+			profile: Profile{Target: capture.Target{IPs: []netip.Addr{netip.MustParseAddr("::ffff:10.0.0.1")}}},
+		},
+		"EUI-64 target": {
+			profile: Profile{Target: capture.Target{MACs: []net.HardwareAddr{{0x00, 0x16, 0x3e, 0xff, 0xfe, 0x00, 0x00, 0x01}}}},
+			err:     addrutil.ErrInvalidMAC,
+		},
+		"invalid scan": {
+			profile: Profile{Scans: []netmon.ScanJob{{ID: "a"}}},
+			err:     netmon.ErrInvalidScanJob,
+		},
+		"multicast scan source": {
+			profile: Profile{Scans: []netmon.ScanJob{func() netmon.ScanJob {
+				job := scanJob("a")
+				job.Source = netip.MustParseAddr("224.0.0.1")
+
+				return job
+			}()}},
+			err: addrutil.ErrInvalidIP,
+		},
+		"duplicate scan": {
+			profile: Profile{Scans: []netmon.ScanJob{scanJob("a"), scanJob("a")}},
+			err:     netmon.ErrDuplicateScanJob,
+		},
+		"empty label key": {
+			profile: Profile{Labels: map[string]string{"": "fabric-0"}},
+			err:     netmon.ErrInvalidLabels,
+		},
+		"native VLAN out of range": {
+			profile: Profile{NativeVLAN: 4095},
+			err:     netmon.ErrInvalidNativeVLAN,
+		},
+		"unknown strictness": {
+			profile: Profile{Parser: ethernet.ParserOptions{Strictness: 9}},
+			err:     ethernet.ErrInvalidStrictness,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.profile.Validate()
+			if tc.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	assert.Error(t, Profile{EventRate: -1}.Validate())
+}
+
+func TestEventLimiter(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1000, 0))
+	l := &eventLimiter{clock: clk, rate: 2}
+
+	assert.True(t, l.allow())
+	assert.True(t, l.allow())
+	assert.False(t, l.allow())
+
+	clk.Advance(time.Second)
+	assert.True(t, l.allow())
+
+	l.setRate(0)
+
+	for range 10 {
+		assert.True(t, l.allow())
+	}
+}
+
+// fakeCaptures stands for the captures of a Multiplexer, each sends results
+// then blocks until stopped or fails when the interface is in fail
+type fakeCaptures struct {
+	results map[string][]netmon.Result
+	fail    map[string]error
+	started chan string
+	starts  map[string]int
+	stops   map[string]int
+	mu      sync.Mutex
+}
+
+func newFakeCaptures() *fakeCaptures {
+	return &fakeCaptures{
+		results: make(map[string][]netmon.Result),
+		fail:    make(map[string]error),
+		started: make(chan string, 16),
+		starts:  make(map[string]int),
+		stops:   make(map[string]int),
+	}
+}
+
+func (f *fakeCaptures) start(ctx context.Context, iface string, _ *netmon.Service, resultC chan<- netmon.Result) error {
+	defer close(resultC)
+
+	f.mu.Lock()
+	f.starts[iface]++
+	results, err := f.results[iface], f.fail[iface]
+	f.mu.Unlock()
+
+	f.started <- iface
+
+	for _, res := range results {
+		resultC <- res
+	}
+
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+
+	f.mu.Lock()
+	f.stops[iface]++
+	f.mu.Unlock()
+
+	return nil
+}
+
+func (f *fakeCaptures) counts() (map[string]int, map[string]int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	starts, stops := make(map[string]int), make(map[string]int)
+
+	for iface, n := range f.starts {
+		starts[iface] = n
+	}
+
+	for iface, n := range f.stops {
+		stops[iface] = n
+	}
+
+	return starts, stops
+}
+
+func (f *fakeCaptures) waitStarted(t *testing.T, ifaces ...string) {
+	t.Helper()
+
+	var started []string
+
+	for range ifaces {
+		select {
+		case iface := <-f.started:
+			started = append(started, iface)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("captures started: %v, expected %v", started, ifaces)
+		}
+	}
+
+	sort.Strings(started)
+	assert.Equal(t, ifaces, started)
+}
+
+func runCaptures(t *testing.T, m *Multiplexer) (func(), <-chan error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+
+	go func() { errC <- m.runCaptures(ctx) }()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			cancel()
+			assert.NoError(t, <-errC)
+		})
+	}, errC
+}
+
+func scheduledScans(m *Multiplexer) []string {
+	var ids []string
+
+	for _, s := range m.scheduler.Status() {
+		ids = append(ids, s.Interface+" "+s.ID)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+func TestMultiplexerApplyProfiles(t *testing.T) {
+	defer leak.Check(t)()
+
+	captures := newFakeCaptures()
+	m := NewMultiplexer()
+	m.start = captures.start
+
+	target := capture.Target{IPs: []netip.Addr{netip.MustParseAddr("10.0.0.1")}}
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{
+		"eth0": {Scans: []netmon.ScanJob{scanJob("a")}},
+		"eth1": {Scans: []netmon.ScanJob{scanJob("a")}},
+		"eth2": {},
+	}))
+
+	stop, _ := runCaptures(t, m)
+	defer stop()
+
+	captures.waitStarted(t, "eth0", "eth1", "eth2")
+	assert.Equal(t, []string{"eth0 eth0/a", "eth1 eth1/a"}, scheduledScans(m))
+
+	// eth0 changes live, eth1 is restarted, eth2 is stopped, eth3 is
+	// started and eth4 is invalid
+	err := m.ApplyProfiles(map[string]Profile{
+		"eth0": {Target: target, EventRate: 5, Scans: []netmon.ScanJob{scanJob("a"), scanJob("b")}},
+		"eth1": {Promiscuous: true},
+		"eth3": {},
+		"eth4": {Target: capture.Target{MACs: []net.HardwareAddr{{0x00}}}},
+	})
+	require.ErrorIs(t, err, ErrInvalidProfile)
+	assert.ErrorContains(t, err, "eth4")
+
+	captures.waitStarted(t, "eth1", "eth3")
+
+	starts, stops := captures.counts()
+	assert.Equal(t, map[string]int{"eth0": 1, "eth1": 2, "eth2": 1, "eth3": 1}, starts)
+	assert.Equal(t, map[string]int{"eth1": 1, "eth2": 1}, stops)
+	assert.Equal(t, []string{"eth0 eth0/a", "eth0 eth0/b"}, scheduledScans(m))
+
+	// an invalid profile keeps the running one
+	err = m.ApplyProfiles(map[string]Profile{
+		"eth0": {EventRate: -1},
+		"eth1": {Promiscuous: true},
+		"eth3": {},
+	})
+	require.ErrorIs(t, err, ErrInvalidProfile)
+
+	starts, stops = captures.counts()
+	assert.Equal(t, map[string]int{"eth0": 1, "eth1": 2, "eth2": 1, "eth3": 1}, starts)
+	assert.Equal(t, map[string]int{"eth1": 1, "eth2": 1}, stops)
+	assert.Equal(t, []string{"eth0 eth0/a", "eth0 eth0/b"}, scheduledScans(m))
+
+	m.mu.Lock()
+	assert.Equal(t, target, m.captures["eth0"].profile.Target)
+	m.mu.Unlock()
+}
+
+func TestMultiplexerCaptureFailure(t *testing.T) {
+	defer leak.Check(t)()
+
+	captures := newFakeCaptures()
+	captures.fail["eth1"] = capture.ErrClosed
+
+	m := NewMultiplexer()
+	m.start = captures.start
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"eth0": {}, "eth1": {}}))
+
+	_, errC := runCaptures(t, m)
+
+	select {
+	case err := <-errC:
+		assert.ErrorIs(t, err, capture.ErrClosed)
+		assert.ErrorContains(t, err, "eth1")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the failed capture didn't stop the others")
+	}
+
+	_, stops := captures.counts()
+	assert.Equal(t, map[string]int{"eth0": 1}, stops)
+}
+
+func TestMultiplexerEventRate(t *testing.T) {
+	defer leak.Check(t)()
+
+	clk := clocktest.NewFake(time.Unix(1000, 0))
+	captures := newFakeCaptures()
+	captures.results["eth0"] = []netmon.Result{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}, {IP: "10.0.0.3"}}
+	captures.results["eth1"] = []netmon.Result{{IP: "10.0.0.4"}}
+
+	m := NewMultiplexer(WithMultiplexerClock(clk))
+	m.start = captures.start
+
+	var (
+		mu     sync.Mutex
+		events []Event
+	)
+
+	received := make(chan struct{}, 8)
+
+	require.NoError(t, m.Subscribe("test", func(e Event) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+
+		received <- struct{}{}
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dispatchC := make(chan error, 1)
+
+	go func() { dispatchC <- m.events.Run(ctx) }()
+
+	defer func() {
+		cancel()
+		require.NoError(t, <-dispatchC)
+	}()
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"eth0": {EventRate: 2}, "eth1": {}}))
+
+	stop, _ := runCaptures(t, m)
+	defer stop()
+
+	for range 3 {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("missing events")
+		}
+	}
+
+	stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var ips []string
+	for _, e := range events {
+		ips = append(ips, e.Interface+" "+e.IP)
+	}
+
+	sort.Strings(ips)
+	assert.Equal(t, []string{"eth0 10.0.0.1", "eth0 10.0.0.2", "eth1 10.0.0.4"}, ips)
+}
+
+func TestMultiplexerSeverity(t *testing.T) {
+	defer leak.Check(t)()
+
+	captures := newFakeCaptures()
+	captures.results["eth0"] = []netmon.Result{
+		{IP: "10.0.0.1", Event: netmon.EventNew},
+		{IP: "10.0.0.1", Event: netmon.EventRefreshed},
+		{IP: "10.0.0.2", Event: netmon.EventMoved},
+		{IP: "10.0.0.3", Event: netmon.EventAddressTheft},
+	}
+
+	m := NewMultiplexer(WithEventSeverities(map[netmon.Event]netmon.Severity{netmon.EventNew: netmon.SeverityWarning}))
+	m.start = captures.start
+
+	subscribe := func(name string, n int, options ...dispatch.SubscriberOption) <-chan Event {
+		eventC := make(chan Event, n)
+
+		require.NoError(t, m.Subscribe(name, func(e Event) { eventC <- e }, options...))
+
+		return eventC
+	}
+
+	all := subscribe("all", 4)
+	warnings := subscribe("warnings", 3, WithMinSeverity(netmon.SeverityWarning))
+	// the filters of a subscriber all apply
+	refreshes := subscribe("refreshes", 1, WithEventTypes(netmon.EventRefreshed, netmon.EventAddressTheft),
+		WithMinSeverity(netmon.SeverityInfo))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dispatchC := make(chan error, 1)
+
+	go func() { dispatchC <- m.events.Run(ctx) }()
+
+	defer func() {
+		cancel()
+		require.NoError(t, <-dispatchC)
+	}()
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"eth0": {}}))
+
+	stop, _ := runCaptures(t, m)
+	defer stop()
+
+	receive := func(eventC <-chan Event, n int) []string {
+		var received []string
+
+		for range n {
+			select {
+			case e := <-eventC:
+				received = append(received, e.IP+" "+e.Severity.String())
+			case <-time.After(5 * time.Second):
+				t.Fatalf("events received: %v, expected %d", received, n)
+			}
+		}
+
+		return received
+	}
+
+	assert.Equal(t, []string{"10.0.0.1 warning", "10.0.0.1 debug", "10.0.0.2 warning", "10.0.0.3 critical"},
+		receive(all, 4))
+	assert.Equal(t, []string{"10.0.0.1 warning", "10.0.0.2 warning", "10.0.0.3 critical"}, receive(warnings, 3))
+	assert.Equal(t, []string{"10.0.0.3 critical"}, receive(refreshes, 1))
+
+	stop()
+
+	filtered := make(map[string]uint64)
+	for _, st := range m.events.Stats() {
+		filtered[st.Name] = st.Filtered
+	}
+
+	assert.Equal(t, map[string]uint64{"all": 0, "warnings": 1, "refreshes": 3}, filtered)
+}
+
+func TestMultiplexerStandby(t *testing.T) {
+	defer leak.Check(t)()
+
+	captures := newFakeCaptures()
+	captures.results["eth0"] = []netmon.Result{{IP: "10.0.0.1", Event: netmon.EventNew}}
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	m := NewMultiplexer(WithTransmitGate(netmon.NewTransmitGate(netmon.ModeStandby)), WithMultiplexerClock(clk))
+	m.start = captures.start
+
+	eventC := make(chan Event, 4)
+	require.NoError(t, m.Subscribe("events", func(e Event) { eventC <- e }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dispatchC := make(chan error, 1)
+
+	go func() { dispatchC <- m.events.Run(ctx) }()
+
+	defer func() {
+		cancel()
+		require.NoError(t, <-dispatchC)
+	}()
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"eth0": {}}))
+
+	stop, _ := runCaptures(t, m)
+	defer stop()
+
+	receive := func() Event {
+		select {
+		case e := <-eventC:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+			return Event{}
+		}
+	}
+
+	// the captures run in standby
+	assert.Equal(t, netmon.ModeStandby, m.Mode())
+	assert.Equal(t, "10.0.0.1", receive().IP)
+
+	change, err := m.Promote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, netmon.ModeActive, m.Mode())
+
+	e := receive()
+	assert.Equal(t, netmon.EventModeChanged, e.Event)
+	assert.Equal(t, &change, e.Mode)
+	assert.Equal(t, netmon.ModeStandby, e.Mode.Previous)
+	assert.Equal(t, netmon.SeverityWarning, e.Severity)
+	assert.Equal(t, int64(1700000000), e.Time)
+
+	// promoting an active Multiplexer publishes nothing
+	_, err = m.Promote(context.Background())
+	require.NoError(t, err)
+
+	_, err = m.Demote(context.Background())
+	require.NoError(t, err)
+
+	e = receive()
+	assert.Equal(t, netmon.ModeChange{Previous: netmon.ModeActive, Current: netmon.ModeStandby}, *e.Mode)
+	assert.Empty(t, eventC)
+}
+
+func TestMultiplexerNoCaptureBeforeRun(t *testing.T) {
+	t.Parallel()
+
+	captures := newFakeCaptures()
+	m := NewMultiplexer()
+	m.start = captures.start
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"eth0": {}}))
+
+	starts, _ := captures.counts()
+	assert.Empty(t, starts)
+	assert.True(t, errors.Is(m.ApplyProfiles(map[string]Profile{"eth0": {EventRate: -1}}), ErrInvalidProfile))
+}
+
+func TestMultiplexerWakeUnknownMAC(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiplexer()
+
+	_, err := m.Wake(context.Background(), net.HardwareAddr{0x52, 0x54, 0x00, 0xaa, 0xbb, 0xcc})
+	assert.ErrorIs(t, err, netmon.ErrMACNotFound)
+}
+
+func TestMultiplexerCheckHost(t *testing.T) {
+	defer leak.Check(t)()
+
+	captures := newFakeCaptures()
+	m := NewMultiplexer()
+	m.start = captures.start
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"eth0": {CheckHosts: true}, "eth1": {}}))
+
+	stop, _ := runCaptures(t, m)
+	defer stop()
+
+	captures.waitStarted(t, "eth0", "eth1")
+
+	ip, mac := netip.MustParseAddr("10.0.0.1"), net.HardwareAddr{0x52, 0x54, 0x00, 0xaa, 0xbb, 0xcc}
+
+	m.mu.Lock()
+	m.captures["eth0"].svc.Observe(netmon.ObservationARPReply, ip, mac, nil, time.Time{})
+	m.mu.Unlock()
+
+	check, err := m.CheckHost(context.Background(), "eth0", nil, ip, mac)
+	require.NoError(t, err)
+	assert.True(t, check.Cached)
+	assert.True(t, check.Matched)
+
+	_, err = m.CheckHost(context.Background(), "eth1", nil, ip, mac)
+	assert.ErrorIs(t, err, netmon.ErrHostCheckDisabled)
+
+	_, err = m.CheckHost(context.Background(), "eth2", nil, ip, mac)
+	assert.ErrorIs(t, err, ErrNotCaptured)
+}
+
+func TestMultiplexerPrimeNeighbor(t *testing.T) {
+	defer leak.Check(t)()
+
+	captures := newFakeCaptures()
+	m := NewMultiplexer()
+	m.start = captures.start
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"eth0": {PrimeNeighbors: true}, "eth1": {}}))
+
+	stop, _ := runCaptures(t, m)
+	defer stop()
+
+	captures.waitStarted(t, "eth0", "eth1")
+
+	ip, mac := netip.MustParseAddr("10.0.0.1"), net.HardwareAddr{0x52, 0x54, 0x00, 0xaa, 0xbb, 0xcc}
+
+	// the Primer is reached, the inventory doesn't know the interface
+	err := m.PrimeNeighbor(context.Background(), "eth0", nil, ip, mac)
+	assert.ErrorIs(t, err, netif.ErrLinkNotFound)
+
+	err = m.PrimeNeighbor(context.Background(), "eth1", nil, ip, mac)
+	assert.ErrorIs(t, err, netmon.ErrPrimeDisabled)
+
+	err = m.PrimeNeighbor(context.Background(), "eth2", nil, ip, mac)
+	assert.ErrorIs(t, err, ErrNotCaptured)
+}
+
+func TestMultiplexerIdentities(t *testing.T) {
+	defer leak.Check(t)()
+
+	captures := newFakeCaptures()
+	m := NewMultiplexer()
+	m.start = captures.start
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"eth0": {}, "eth1": {}}))
+
+	stop, _ := runCaptures(t, m)
+	defer stop()
+
+	captures.waitStarted(t, "eth0", "eth1")
+
+	// two NICs of a host on different segments, tied by its hostname
+	for iface, o := range map[string]netmon.Observation{
+		"eth0": {IP: netip.MustParseAddr("10.0.0.1"), MAC: net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}},
+		"eth1": {IP: netip.MustParseAddr("10.0.1.1"), MAC: net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}},
+	} {
+		o.Source = "dhcpd-leases"
+		o.Kind = netmon.ObservationDHCPAck
+		o.Confidence = netmon.ConfidenceHigh
+		o.Hostname = "node1"
+
+		m.mu.Lock()
+		svc := m.captures[iface].svc
+		m.mu.Unlock()
+
+		_, err := svc.Ingest(o)
+		require.NoError(t, err)
+	}
+
+	snap, events := m.UpdateIdentities()
+
+	require.Len(t, snap.Identities, 1)
+	assert.Equal(t, []string{"52:54:00:00:00:01", "52:54:00:00:00:02"}, snap.Identities[0].MACs)
+	assert.Equal(t, []string{netmon.IdentityLinkHostname}, snap.Identities[0].LinkedBy)
+	require.Len(t, events, 1)
+	assert.Equal(t, netmon.IdentityNew, events[0].Event)
+
+	_, events = m.UpdateIdentities()
+	assert.Empty(t, events)
+	assert.Equal(t, snap.Identities, m.Identities().Identities)
+}
+
+func TestMultiplexerDegraded(t *testing.T) {
+	defer leak.Check(t)()
+
+	// the capture socket is denied, as it is without CAP_NET_RAW
+	m := NewMultiplexer(WithCapabilityCheck(func() error {
+		return fmt.Errorf("failed opening AF_PACKET socket: %w", capture.ErrNoRawAccess)
+	}))
+	require.NoError(t, m.detectCapabilities())
+	assert.True(t, m.Capabilities().Lacks(netmon.CapabilityNetRaw))
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{
+		"eth0": {Scans: []netmon.ScanJob{scanJob("a")}, AttributeIngress: true},
+		"eth1": {},
+	}))
+
+	// the Services start, without capturing
+	stop, errC := runCaptures(t, m)
+	defer stop()
+
+	select {
+	case err := <-errC:
+		t.Fatalf("degraded captures stopped: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.mu.Lock()
+
+	for iface, c := range m.captures {
+		assert.True(t, c.svc.Capabilities().Lacks(netmon.CapabilityNetRaw), iface)
+	}
+
+	m.mu.Unlock()
+
+	_, err := m.Wake(context.Background(), net.HardwareAddr{0x52, 0x54, 0x00, 0xaa, 0xbb, 0xcc})
+	assert.ErrorIs(t, err, netmon.ErrMissingCapability)
+
+	// a check failing otherwise stops Run
+	m = NewMultiplexer(WithCapabilityCheck(func() error { return capture.ErrUnsupported }))
+	assert.ErrorIs(t, m.Run(context.Background()), capture.ErrUnsupported)
+}
+
+func TestMultiplexerDeduplication(t *testing.T) {
+	t.Parallel()
+
+	_, ok := NewMultiplexer().Deduplication()
+	assert.False(t, ok)
+
+	report, ok := NewMultiplexer(WithDeduplication(netmon.WithDedupWindow(time.Second))).Deduplication()
+	assert.True(t, ok)
+	assert.Empty(t, report.Interfaces)
+	assert.Empty(t, report.Paths)
+}
+
+func TestMultiplexerReordering(t *testing.T) {
+	t.Parallel()
+
+	_, ok := NewMultiplexer().Reordering()
+	assert.False(t, ok)
+
+	stats, ok := NewMultiplexer(WithReordering(netmon.WithReorderHorizon(time.Second))).Reordering()
+	assert.True(t, ok)
+	assert.Equal(t, netmon.ReorderStats{}, stats)
+}
+
+// TestMultiplexerIngressAttribution captures the member ports of the
+// interfaces attributing ingress, as long as their capture runs
+func TestMultiplexerIngressAttribution(t *testing.T) {
+	defer leak.Check(t)()
+
+	captures := newFakeCaptures()
+	m := NewMultiplexer()
+	m.start = captures.start
+	m.members = func(iface string) []string {
+		return map[string][]string{"br0": {"eth0", "eth1"}, "bond0": {"eth2"}}[iface]
+	}
+
+	members := newFakeCaptures()
+	m.member = func(ctx context.Context, iface, port string, _ *netmon.Service) error {
+		// the member captures have no results
+		return members.start(ctx, iface+"/"+port, nil, make(chan netmon.Result))
+	}
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"br0": {AttributeIngress: true}, "bond0": {}}))
+
+	stop, _ := runCaptures(t, m)
+	defer stop()
+
+	captures.waitStarted(t, "bond0", "br0")
+	members.waitStarted(t, "br0/eth0", "br0/eth1")
+
+	// enabling it restarts the capture, disabling it stops the members
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"br0": {}, "bond0": {AttributeIngress: true}}))
+
+	captures.waitStarted(t, "bond0", "br0")
+	members.waitStarted(t, "bond0/eth2")
+
+	starts, stops := members.counts()
+	assert.Equal(t, map[string]int{"br0/eth0": 1, "br0/eth1": 1, "bond0/eth2": 1}, starts)
+	assert.Equal(t, map[string]int{"br0/eth0": 1, "br0/eth1": 1}, stops)
+	assert.Empty(t, m.Attribution())
+}
+
+// TestMultiplexerRelabel relabels a running capture without restarting it,
+// and changes its native VLAN
+func TestMultiplexerRelabel(t *testing.T) {
+	defer leak.Check(t)()
+
+	captures := newFakeCaptures()
+	m := NewMultiplexer()
+	m.start = captures.start
+
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{
+		"eth0": {Labels: map[string]string{"fabric": "fabric-0"}},
+	}))
+
+	stop, _ := runCaptures(t, m)
+	defer stop()
+
+	captures.waitStarted(t, "eth0")
+
+	m.mu.Lock()
+	svc := m.captures["eth0"].svc
+	m.mu.Unlock()
+
+	assert.Equal(t, map[string]string{"fabric": "fabric-0"}, svc.Labels())
+
+	labels := map[string]string{"fabric": "fabric-1", "space": "management"}
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"eth0": {Labels: labels}}))
+	assert.Equal(t, labels, svc.Labels())
+
+	// the native VLAN is changed in place too
+	require.NoError(t, m.ApplyProfiles(map[string]Profile{"eth0": {Labels: labels, NativeVLAN: 10}}))
+
+	native, advertised := svc.NativeVLAN()
+	assert.Equal(t, uint16(10), native)
+	assert.False(t, advertised)
+
+	starts, stops := captures.counts()
+	assert.Equal(t, map[string]int{"eth0": 1}, starts)
+	assert.Empty(t, stops)
+}
+
+func TestProfileMembership(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in       Profile
+		required capture.Membership
+	}{
+		"bindings": {
+			in:       Profile{DetectDuplicates: true, RecordEvidence: true},
+			required: capture.MembershipGroups,
+		},
+		"DAD": {
+			in:       Profile{DetectDAD: true},
+			required: capture.MembershipAllMulticast,
+		},
+		"proxies": {
+			in:       Profile{DetectDAD: true, DetectProxies: true},
+			required: capture.MembershipPromiscuous,
+		},
+		"port authentication": {
+			in:       Profile{DetectPortAuth: true},
+			required: capture.MembershipPromiscuous,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.required, tc.in.RequiredMembership())
+		})
+	}
+
+	assert.Equal(t, capture.MembershipGroups, watchProfile().Membership)
+
+	// a capture is restarted in another membership, Promiscuous is the same
+	// as MembershipPromiscuous
+	promiscuous := Profile{Membership: capture.MembershipPromiscuous}
+	assert.NotEqual(t, Profile{}.serviceConfig(), watchProfile().serviceConfig())
+	assert.Equal(t, promiscuous.serviceConfig(), Profile{Promiscuous: true}.serviceConfig())
+
+	// so is a capture decoding at another Strictness
+	strict := Profile{Parser: ethernet.ParserOptions{Strictness: ethernet.StrictnessStrict}}
+	assert.NotEqual(t, Profile{}.serviceConfig(), strict.serviceConfig())
+
+	// or other decoders, port authentication needing EAPOL
+	lldp := Profile{Decoders: netmon.DefaultDecoders | netmon.DecoderLLDP}
+	assert.NotEqual(t, Profile{}.serviceConfig(), lldp.serviceConfig())
+	assert.Equal(t, Profile{Decoders: netmon.DefaultDecoders}.serviceConfig(), Profile{}.serviceConfig())
+	assert.Equal(t, netmon.DefaultDecoders|netmon.DecoderEAPOL, Profile{DetectPortAuth: true}.decoders())
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build stress
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+const (
+	stressInterfaces = 4
+	stressReloads    = 300
+)
+
+// observeLoop stands for a capture observing hosts as fast as it can, until
+// ctx is done
+func observeLoop(ctx context.Context, _ string, svc *netmon.Service, resultC chan<- netmon.Result) error {
+	defer close(resultC)
+
+	for n := 0; ; n++ {
+		ip := netip.AddrFrom4([4]byte{10, 2, byte(n >> 8), byte(n)})
+		mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x02, byte(n >> 8), byte(n)}
+
+		for _, res := range svc.Observe(netmon.ObservationARPReply, ip, mac, nil, time.Time{}) {
+			select {
+			case resultC <- res:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func TestStressMultiplexer(t *testing.T) {
+	defer leak.Check(t)()
+
+	m := NewMultiplexer()
+	m.start = observeLoop
+
+	// the subscribers check the events they get carry the MAC of a host
+	var events sync.Map
+
+	for i := range 3 {
+		name := fmt.Sprintf("subscriber-%d", i)
+
+		require.NoError(t, m.Subscribe(name, func(e Event) {
+			if e.MAC == "" || e.MAC == "00:00:00:00:00:00" {
+				t.Errorf("event of %s without a MAC", e.Interface)
+			}
+
+			n, _ := events.LoadOrStore(name, new(int))
+			*n.(*int)++ //nolint:forcetypeassert // only *int are stored
+		}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, m.events.Run(ctx))
+	}()
+
+	stop, _ := runCaptures(t, m)
+
+	// the profiles are reloaded while the captures publish, some changes
+	// followed live and others restarting the captures
+	for range 2 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for n := range stressReloads {
+				profiles := make(map[string]Profile)
+
+				for i := range stressInterfaces {
+					if (n+i)%5 == 0 {
+						continue
+					}
+
+					profiles[fmt.Sprintf("eth%d", i)] = Profile{
+						Target:      capture.Target{IPs: []netip.Addr{netip.AddrFrom4([4]byte{10, 2, 0, byte(n)})}},
+						EventRate:   n % 3 * 100,
+						Labels:      map[string]string{"fabric": fmt.Sprintf("fabric-%d", n%4)},
+						Promiscuous: n%7 == 0,
+						Scans:       []netmon.ScanJob{scanJob(fmt.Sprintf("scan-%d", n%2))},
+					}
+				}
+
+				assert.NoError(t, m.ApplyProfiles(profiles))
+			}
+		}()
+	}
+
+	// and the state of the captures is read meanwhile
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for range stressReloads {
+			m.Attribution()
+			m.Capabilities()
+			m.events.Stats()
+			m.scheduler.Status()
+
+			_, err := m.Wake(ctx, net.HardwareAddr{0x52, 0x54, 0x00, 0xff, 0xff, 0xff})
+			assert.ErrorIs(t, err, netmon.ErrMACNotFound)
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	stop()
+	cancel()
+	wg.Wait()
+
+	// at quiescence no capture is left running, and the scans follow the
+	// last profiles
+	m.mu.Lock()
+	assert.Empty(t, m.captures)
+	m.mu.Unlock()
+
+	assert.NotEmpty(t, scheduledScans(m))
+
+	for i := range 3 {
+		_, ok := events.Load(fmt.Sprintf("subscriber-%d", i))
+		assert.True(t, ok, "subscriber-%d got no event", i)
+	}
+}
@@ -0,0 +1,505 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package dispatch delivers values to subscribers through bounded queues,
+// so a slow or stuck subscriber never blocks the producer. It sits between
+// the capture loops, which must keep up with the kernel, and the handlers
+// of their results.
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+const (
+	defaultQueueSize     = 256
+	defaultBlockTimeout  = 100 * time.Millisecond
+	defaultSlowThreshold = 0.1
+	defaultSlowWindow    = time.Minute
+)
+
+var (
+	// ErrStarted is returned when subscribing to a running Dispatcher
+	ErrStarted = errors.New("dispatcher already started")
+	// ErrFilterType is returned when subscribing with a filter of another
+	// type than the values of the Dispatcher
+	ErrFilterType = errors.New("filter of another type than the values")
+)
+
+// OverflowPolicy is what happens to a value published to a full queue
+type OverflowPolicy uint8
+
+const (
+	// DropNewest discards the published value, the default as it never
+	// delays the producer
+	DropNewest OverflowPolicy = iota
+	// DropOldest discards the oldest queued value to make room
+	DropOldest
+	// BlockWithTimeout waits for room up to the block timeout, then
+	// discards the published value
+	BlockWithTimeout
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case BlockWithTimeout:
+		return "block-with-timeout"
+	default:
+		return "unknown"
+	}
+}
+
+// QueueStats are the counters of a subscriber queue
+type QueueStats struct {
+	Name string `json:"name"`
+	// Policy is the overflow policy of the queue
+	Policy string `json:"policy"`
+	// Depth is the number of values waiting to be handled
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+	// Delivered is the number of values handled
+	Delivered uint64 `json:"delivered"`
+	// Dropped is the number of values discarded because the queue was full
+	Dropped uint64 `json:"dropped"`
+	// Filtered is the number of values the filters of the subscriber
+	// rejected, they were never queued
+	Filtered uint64 `json:"filtered"`
+}
+
+// SlowConsumer reports a subscriber which dropped more than the threshold
+// of the values published over a window
+type SlowConsumer struct {
+	Name      string        `json:"name"`
+	Window    time.Duration `json:"window"`
+	Published uint64        `json:"published"`
+	Dropped   uint64        `json:"dropped"`
+}
+
+type subscriberConfig struct {
+	// filters are the func(T) bool of WithFilter
+	filters      []any
+	size         int
+	blockTimeout time.Duration
+	policy       OverflowPolicy
+}
+
+// SubscriberOption configures the queue of a subscriber
+type SubscriberOption func(*subscriberConfig)
+
+// WithQueueSize sets the number of values a subscriber can lag behind
+func WithQueueSize(n int) SubscriberOption {
+	return func(c *subscriberConfig) {
+		if n > 0 {
+			c.size = n
+		}
+	}
+}
+
+// WithOverflowPolicy sets what happens to the values published when the
+// queue is full, DropNewest by default
+func WithOverflowPolicy(p OverflowPolicy) SubscriberOption {
+	return func(c *subscriberConfig) {
+		c.policy = p
+	}
+}
+
+// WithBlockTimeout sets how long BlockWithTimeout waits for room
+func WithBlockTimeout(d time.Duration) SubscriberOption {
+	return func(c *subscriberConfig) {
+		if d > 0 {
+			c.blockTimeout = d
+		}
+	}
+}
+
+// WithFilter only queues the values f accepts for the subscriber, those it
+// rejects don't take room in the queue and are counted apart. f is called
+// by the publishers, it must be quick. The filters of a subscriber must
+// take the values of the Dispatcher, a value is queued when all accept it.
+func WithFilter[T any](f func(T) bool) SubscriberOption {
+	return func(c *subscriberConfig) {
+		c.filters = append(c.filters, f)
+	}
+}
+
+type subscriber[T any] struct {
+	// the counters of the current slow consumer window, mu protects them
+	windowStart     time.Time
+	handler         func(T)
+	queue           chan T
+	name            string
+	filters         []func(T) bool
+	config          subscriberConfig
+	windowPublished uint64
+	windowDropped   uint64
+	delivered       atomic.Uint64
+	dropped         atomic.Uint64
+	filtered        atomic.Uint64
+	mu              sync.Mutex
+}
+
+// accepts returns true if every filter of s accepts v
+func (s *subscriber[T]) accepts(v T) bool {
+	for _, f := range s.filters {
+		if !f(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Dispatcher delivers the values published to every subscriber, each from
+// its own goroutine. Publish returns without waiting for the handlers, what
+// a full queue does to a value depends on the policy of the subscriber.
+type Dispatcher[T any] struct {
+	clock         clock.Clock
+	slow          func(SlowConsumer)
+	subscribers   []*subscriber[T]
+	slowThreshold float64
+	slowWindow    time.Duration
+	mu            sync.Mutex
+	started       bool
+}
+
+// dispatcherConfig holds the options which don't depend on the type of
+// the values
+type dispatcherConfig struct {
+	clock         clock.Clock
+	slow          func(SlowConsumer)
+	meter         metric.Meter
+	slowThreshold float64
+	slowWindow    time.Duration
+}
+
+// DispatcherOption configures a Dispatcher
+type DispatcherOption func(*dispatcherConfig)
+
+// WithClock sets the clock timing the slow consumer windows and the
+// blocking publications
+func WithClock(c clock.Clock) DispatcherOption {
+	return func(d *dispatcherConfig) {
+		d.clock = c
+	}
+}
+
+// WithSlowConsumerHandler calls f when a subscriber drops more than the
+// threshold of the values published over a window, f must not block
+func WithSlowConsumerHandler(f func(SlowConsumer)) DispatcherOption {
+	return func(d *dispatcherConfig) {
+		d.slow = f
+	}
+}
+
+// WithSlowConsumerThreshold sets the fraction of the values a subscriber
+// may drop over window before being reported as a slow consumer
+func WithSlowConsumerThreshold(rate float64, window time.Duration) DispatcherOption {
+	return func(d *dispatcherConfig) {
+		if rate >= 0 {
+			d.slowThreshold = rate
+		}
+
+		if window > 0 {
+			d.slowWindow = window
+		}
+	}
+}
+
+// WithMetricMeter sets the OpenTelemetry meter collecting the queue depths
+// and the delivered and dropped values
+func WithMetricMeter(meter metric.Meter) DispatcherOption {
+	return func(d *dispatcherConfig) {
+		d.meter = meter
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// NewDispatcher returns a Dispatcher without subscribers
+func NewDispatcher[T any](options ...DispatcherOption) *Dispatcher[T] {
+	cfg := dispatcherConfig{
+		clock:         clock.System{},
+		slowThreshold: defaultSlowThreshold,
+		slowWindow:    defaultSlowWindow,
+	}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	d := &Dispatcher[T]{
+		clock:         cfg.clock,
+		slow:          cfg.slow,
+		slowThreshold: cfg.slowThreshold,
+		slowWindow:    cfg.slowWindow,
+	}
+
+	if cfg.meter != nil {
+		d.registerMetrics(cfg.meter)
+	}
+
+	return d
+}
+
+func (d *Dispatcher[T]) registerMetrics(meter metric.Meter) {
+	delivered := attribute.String("type", "delivered")
+	dropped := attribute.String("type", "dropped")
+	filtered := attribute.String("type", "filtered")
+
+	must(meter.Int64ObservableCounter("dispatch.values",
+		metric.WithUnit("{count}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			for _, st := range d.Stats() {
+				name := attribute.String("subscriber", st.Name)
+				o.Observe(int64(st.Delivered), metric.WithAttributes(name, delivered)) //nolint:gosec // counters fit
+				o.Observe(int64(st.Dropped), metric.WithAttributes(name, dropped))     //nolint:gosec // counters fit
+				o.Observe(int64(st.Filtered), metric.WithAttributes(name, filtered))   //nolint:gosec // counters fit
+			}
+
+			return nil
+		})))
+
+	must(meter.Int64ObservableGauge("dispatch.queue.depth",
+		metric.WithUnit("{count}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			for _, st := range d.Stats() {
+				o.Observe(int64(st.Depth), metric.WithAttributes(attribute.String("subscriber", st.Name)))
+			}
+
+			return nil
+		})))
+}
+
+// Subscribe adds a subscriber handling the values published once the
+// Dispatcher runs, it must be called before Run
+func (d *Dispatcher[T]) Subscribe(name string, handler func(T), options ...SubscriberOption) error {
+	cfg := subscriberConfig{size: defaultQueueSize, blockTimeout: defaultBlockTimeout}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	filters := make([]func(T) bool, 0, len(cfg.filters))
+
+	for _, f := range cfg.filters {
+		filter, ok := f.(func(T) bool)
+		if !ok {
+			return fmt.Errorf("%w: %T for %s", ErrFilterType, f, name)
+		}
+
+		filters = append(filters, filter)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.started {
+		return ErrStarted
+	}
+
+	d.subscribers = append(d.subscribers, &subscriber[T]{
+		name:        name,
+		handler:     handler,
+		filters:     filters,
+		config:      cfg,
+		queue:       make(chan T, cfg.size),
+		windowStart: d.clock.Now(),
+	})
+
+	return nil
+}
+
+// Publish queues v for every subscriber. It only waits for a subscriber
+// with the BlockWithTimeout policy and a full queue.
+func (d *Dispatcher[T]) Publish(v T) {
+	d.mu.Lock()
+	subscribers := d.subscribers
+	d.mu.Unlock()
+
+	for _, s := range subscribers {
+		// the values filtered out never take the room of the others
+		if !s.accepts(v) {
+			s.filtered.Add(1)
+			continue
+		}
+
+		dropped := d.enqueue(s, v)
+		s.dropped.Add(dropped)
+
+		d.account(s, dropped > 0)
+	}
+}
+
+// enqueue returns the number of values discarded to publish v, v itself
+// for DropNewest and BlockWithTimeout or older values for DropOldest
+func (d *Dispatcher[T]) enqueue(s *subscriber[T], v T) uint64 {
+	select {
+	case s.queue <- v:
+		return 0
+	default:
+	}
+
+	switch s.config.policy {
+	case DropOldest:
+		var dropped uint64
+
+		// the handler and the other producers race for the room, old
+		// values are discarded until v fits
+		for {
+			select {
+			case <-s.queue:
+				dropped++
+			default:
+			}
+
+			select {
+			case s.queue <- v:
+				return dropped
+			default:
+			}
+		}
+	case BlockWithTimeout:
+		t := d.clock.NewTimer(s.config.blockTimeout)
+		defer t.Stop()
+
+		select {
+		case s.queue <- v:
+			return 0
+		case <-t.C():
+			return 1
+		}
+	default:
+		return 1
+	}
+}
+
+// account counts a publication in the slow consumer window of s and
+// reports s when the window is over and too much was dropped
+func (d *Dispatcher[T]) account(s *subscriber[T], dropped bool) {
+	now := d.clock.Now()
+
+	s.mu.Lock()
+
+	s.windowPublished++
+	if dropped {
+		s.windowDropped++
+	}
+
+	var report *SlowConsumer
+
+	if elapsed := now.Sub(s.windowStart); elapsed >= d.slowWindow {
+		if float64(s.windowDropped) > d.slowThreshold*float64(s.windowPublished) {
+			report = &SlowConsumer{
+				Name:      s.name,
+				Window:    elapsed,
+				Published: s.windowPublished,
+				Dropped:   s.windowDropped,
+			}
+		}
+
+		s.windowStart, s.windowPublished, s.windowDropped = now, 0, 0
+	}
+
+	s.mu.Unlock()
+
+	if report == nil {
+		return
+	}
+
+	log.Warn().Str("subscriber", report.Name).Uint64("dropped", report.Dropped).
+		Uint64("published", report.Published).Msg("Slow consumer is dropping values")
+
+	if d.slow != nil {
+		d.slow(*report)
+	}
+}
+
+// Stats returns the counters of every subscriber, in subscription order
+func (d *Dispatcher[T]) Stats() []QueueStats {
+	d.mu.Lock()
+	subscribers := d.subscribers
+	d.mu.Unlock()
+
+	stats := make([]QueueStats, 0, len(subscribers))
+
+	for _, s := range subscribers {
+		stats = append(stats, QueueStats{
+			Name:      s.name,
+			Policy:    s.config.policy.String(),
+			Depth:     len(s.queue),
+			Capacity:  cap(s.queue),
+			Delivered: s.delivered.Load(),
+			Dropped:   s.dropped.Load(),
+			Filtered:  s.filtered.Load(),
+		})
+	}
+
+	return stats
+}
+
+// Run calls the handlers with the queued values until ctx is done, the
+// values still queued then are discarded. It returns once every handler
+// has returned, a handler stuck forever blocks it.
+func (d *Dispatcher[T]) Run(ctx context.Context) error {
+	d.mu.Lock()
+	d.started = true
+	subscribers := d.subscribers
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+
+	for _, s := range subscribers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case v := <-s.queue:
+					s.handler(v)
+					s.delivered.Add(1)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return nil
+}
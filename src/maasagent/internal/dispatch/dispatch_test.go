@@ -0,0 +1,373 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dispatch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+// wedged is a handler that blocks on the first value until released, and
+// records the values it handles
+type wedged struct {
+	started chan int
+	release chan struct{}
+	values  []int
+	mu      sync.Mutex
+}
+
+func newWedged() *wedged {
+	return &wedged{started: make(chan int, 1), release: make(chan struct{})}
+}
+
+func (w *wedged) handle(v int) {
+	select {
+	case w.started <- v:
+		<-w.release
+	default:
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.values = append(w.values, v)
+}
+
+func (w *wedged) handled() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]int(nil), w.values...)
+}
+
+func start[T any](t *testing.T, d *Dispatcher[T]) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- d.Run(ctx) }()
+
+	return func() {
+		cancel()
+		assert.NoError(t, <-errC)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	defer leak.Check(t)()
+
+	d := NewDispatcher[int]()
+
+	var (
+		a, b []int
+		wg   sync.WaitGroup
+	)
+
+	wg.Add(6)
+	require.NoError(t, d.Subscribe("a", func(v int) { a = append(a, v); wg.Done() }))
+	require.NoError(t, d.Subscribe("b", func(v int) { b = append(b, v); wg.Done() }))
+
+	stop := start(t, d)
+	defer stop()
+
+	for i := range 3 {
+		d.Publish(i)
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, []int{0, 1, 2}, a)
+	assert.Equal(t, []int{0, 1, 2}, b)
+	assert.Eventually(t, func() bool { return d.Stats()[1].Delivered == 3 }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, d.Subscribe("c", func(int) {}), ErrStarted)
+}
+
+func TestDispatcherPolicies(t *testing.T) {
+	defer leak.Check(t)()
+
+	testcases := map[string]struct {
+		policy  OverflowPolicy
+		handled []int
+	}{
+		"drop newest": {
+			policy:  DropNewest,
+			handled: []int{1, 2, 3},
+		},
+		"drop oldest": {
+			policy:  DropOldest,
+			handled: []int{1, 4, 5},
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			d := NewDispatcher[int]()
+			w := newWedged()
+
+			require.NoError(t, d.Subscribe("wedged", w.handle, WithQueueSize(2), WithOverflowPolicy(tc.policy)))
+
+			stop := start(t, d)
+			defer stop()
+
+			d.Publish(1)
+			<-w.started
+
+			for v := 2; v <= 5; v++ {
+				d.Publish(v)
+			}
+
+			assert.Equal(t, QueueStats{
+				Name:     "wedged",
+				Policy:   tc.policy.String(),
+				Depth:    2,
+				Capacity: 2,
+				Dropped:  2,
+			}, d.Stats()[0])
+
+			close(w.release)
+
+			assert.Eventually(t, func() bool { return len(w.handled()) == 3 }, time.Second, time.Millisecond)
+			assert.Equal(t, tc.handled, w.handled())
+		})
+	}
+}
+
+func TestDispatcherBlockWithTimeout(t *testing.T) {
+	defer leak.Check(t)()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	d := NewDispatcher[int](WithClock(clk))
+	w := newWedged()
+
+	require.NoError(t, d.Subscribe("wedged", w.handle, WithQueueSize(1),
+		WithOverflowPolicy(BlockWithTimeout), WithBlockTimeout(time.Second)))
+
+	stop := start(t, d)
+	defer stop()
+
+	d.Publish(1)
+	<-w.started
+	d.Publish(2)
+
+	// the queue is full, the value is dropped once the timeout expires
+	done := make(chan struct{})
+
+	go func() {
+		d.Publish(3)
+		close(done)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	<-done
+
+	assert.Equal(t, uint64(1), d.Stats()[0].Dropped)
+
+	// or queued when the handler makes room in time
+	done = make(chan struct{})
+
+	go func() {
+		d.Publish(4)
+		close(done)
+	}()
+
+	clk.BlockUntil(1)
+	close(w.release)
+	<-done
+
+	assert.Eventually(t, func() bool { return len(w.handled()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, []int{1, 2, 4}, w.handled())
+	assert.Equal(t, uint64(1), d.Stats()[0].Dropped)
+}
+
+// TestDispatcherWedgedHandler checks that a handler that never returns
+// doesn't hold up the producer, and is reported as a slow consumer
+func TestDispatcherWedgedHandler(t *testing.T) {
+	defer leak.Check(t)()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+
+	var slow []SlowConsumer
+
+	d := NewDispatcher[int](WithClock(clk), WithSlowConsumerThreshold(0.5, time.Minute),
+		WithSlowConsumerHandler(func(s SlowConsumer) { slow = append(slow, s) }))
+
+	w := newWedged()
+	healthy := make(chan int, 1000)
+
+	require.NoError(t, d.Subscribe("wedged", w.handle, WithQueueSize(8)))
+	require.NoError(t, d.Subscribe("healthy", func(v int) { healthy <- v }, WithQueueSize(1000)))
+
+	stop := start(t, d)
+	defer stop()
+
+	d.Publish(0)
+	<-w.started
+
+	published := make(chan struct{})
+
+	go func() {
+		defer close(published)
+
+		for i := 1; i < 1000; i++ {
+			d.Publish(i)
+		}
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(10 * time.Second):
+		t.Fatal("publishing is blocked by the wedged handler")
+	}
+
+	assert.Empty(t, slow)
+
+	clk.Advance(time.Minute)
+	d.Publish(1000)
+
+	require.Len(t, slow, 1)
+	assert.Equal(t, SlowConsumer{Name: "wedged", Window: time.Minute, Published: 1001, Dropped: 992}, slow[0])
+
+	stats := d.Stats()
+	assert.Equal(t, uint64(992), stats[0].Dropped)
+	assert.Equal(t, 8, stats[0].Depth)
+	assert.Zero(t, stats[1].Dropped)
+
+	for range 1001 {
+		<-healthy
+	}
+
+	close(w.release)
+}
+
+func TestDispatcherFilter(t *testing.T) {
+	defer leak.Check(t)()
+
+	d := NewDispatcher[int]()
+	w := newWedged()
+	everything := newWedged()
+
+	// a flood of the values filtered out never evicts those accepted
+	require.NoError(t, d.Subscribe("hundreds", w.handle, WithQueueSize(4), WithOverflowPolicy(DropOldest),
+		WithFilter(func(v int) bool { return v%100 == 0 }), WithFilter(func(v int) bool { return v < 500 })))
+	require.NoError(t, d.Subscribe("everything", everything.handle, WithQueueSize(4),
+		WithOverflowPolicy(DropOldest)))
+
+	stop := start(t, d)
+	defer stop()
+
+	d.Publish(0)
+	<-w.started
+	<-everything.started
+
+	for i := 1; i < 1100; i++ {
+		d.Publish(i)
+	}
+
+	stats := d.Stats()
+	assert.Equal(t, QueueStats{Name: "hundreds", Policy: "drop-oldest", Depth: 4, Capacity: 4, Filtered: 1095},
+		stats[0])
+	assert.Equal(t, uint64(1095), stats[1].Dropped)
+	assert.Zero(t, stats[1].Filtered)
+
+	close(w.release)
+	close(everything.release)
+
+	assert.ErrorIs(t, NewDispatcher[int]().Subscribe("string", func(int) {},
+		WithFilter(func(string) bool { return true })), ErrFilterType)
+}
+
+func TestDispatcherMetrics(t *testing.T) {
+	defer leak.Check(t)()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	d := NewDispatcher[int](WithMetricMeter(provider.Meter("test")))
+	w := newWedged()
+
+	require.NoError(t, d.Subscribe("wedged", w.handle, WithQueueSize(1),
+		WithFilter(func(v int) bool { return v != 4 })))
+
+	stop := start(t, d)
+	defer stop()
+
+	d.Publish(1)
+	<-w.started
+	d.Publish(2)
+	d.Publish(3)
+	d.Publish(4)
+
+	subscriber := attribute.String("subscriber", "wedged")
+	expected := metricdata.ScopeMetrics{
+		Scope: instrumentation.Scope{Name: "test"},
+		Metrics: []metricdata.Metrics{
+			{
+				Name: "dispatch.values",
+				Unit: "{count}",
+				Data: metricdata.Sum[int64]{
+					DataPoints: []metricdata.DataPoint[int64]{
+						{Attributes: attribute.NewSet(subscriber, attribute.String("type", "delivered"))},
+						{Attributes: attribute.NewSet(subscriber, attribute.String("type", "dropped")), Value: 1},
+						{Attributes: attribute.NewSet(subscriber, attribute.String("type", "filtered")), Value: 1},
+					},
+					Temporality: metricdata.CumulativeTemporality,
+					IsMonotonic: true,
+				},
+			},
+			{
+				Name: "dispatch.queue.depth",
+				Unit: "{count}",
+				Data: metricdata.Gauge[int64]{
+					DataPoints: []metricdata.DataPoint[int64]{
+						{Attributes: attribute.NewSet(subscriber), Value: 1},
+					},
+				},
+			},
+		},
+	}
+
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	metricdatatest.AssertEqual(t, expected, rm.ScopeMetrics[0], metricdatatest.IgnoreTimestamp())
+
+	close(w.release)
+}
+
+func TestOverflowPolicyString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "drop-newest", DropNewest.String())
+	assert.Equal(t, "drop-oldest", DropOldest.String())
+	assert.Equal(t, "block-with-timeout", BlockWithTimeout.String())
+	assert.Equal(t, "unknown", OverflowPolicy(9).String())
+}
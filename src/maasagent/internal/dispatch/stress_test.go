@@ -0,0 +1,138 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build stress
+
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	stressPublishers  = 8
+	stressSubscribers = 6
+	stressValues      = 5000
+)
+
+// sequenced is a value numbered by its publisher
+type sequenced struct {
+	publisher int
+	seq       int
+}
+
+// ordered is a subscriber checking the values of every publisher come in
+// the order they were published, some of them dropped
+type ordered struct {
+	t    *testing.T
+	last [stressPublishers]int
+	mu   sync.Mutex
+}
+
+func (o *ordered) handle(v sequenced) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if v.seq <= o.last[v.publisher] {
+		o.t.Errorf("value %d of publisher %d after %d", v.seq, v.publisher, o.last[v.publisher])
+	}
+
+	o.last[v.publisher] = v.seq
+}
+
+func TestStressDispatcher(t *testing.T) {
+	d := NewDispatcher[sequenced](WithSlowConsumerThreshold(0.5, time.Millisecond))
+	policies := []OverflowPolicy{DropNewest, DropOldest, BlockWithTimeout}
+
+	// the subscribers join while the Dispatcher starts, those too late are
+	// refused rather than never served
+	var (
+		joined  []string
+		joinsMu sync.Mutex
+		joins   sync.WaitGroup
+	)
+
+	for i := range stressSubscribers {
+		joins.Add(1)
+
+		go func() {
+			defer joins.Done()
+
+			name := fmt.Sprintf("subscriber-%d", i)
+			err := d.Subscribe(name, (&ordered{t: t}).handle, WithQueueSize(16),
+				WithOverflowPolicy(policies[i%len(policies)]), WithBlockTimeout(time.Millisecond))
+
+			switch {
+			case err == nil:
+				joinsMu.Lock()
+				joined = append(joined, name)
+				joinsMu.Unlock()
+			case !errors.Is(err, ErrStarted):
+				t.Error(err)
+			}
+		}()
+	}
+
+	stop := start(t, d)
+	defer stop()
+
+	joins.Wait()
+
+	var publishers sync.WaitGroup
+
+	for p := range stressPublishers {
+		publishers.Add(1)
+
+		go func() {
+			defer publishers.Done()
+
+			for seq := 1; seq <= stressValues; seq++ {
+				d.Publish(sequenced{publisher: p, seq: seq})
+
+				if seq%100 == 0 {
+					d.Stats()
+				}
+			}
+		}()
+	}
+
+	publishers.Wait()
+
+	// at quiescence, every value was either delivered or dropped
+	require.Eventually(t, func() bool {
+		for _, st := range d.Stats() {
+			if st.Delivered+st.Dropped != stressPublishers*stressValues {
+				return false
+			}
+		}
+
+		return true
+	}, 10*time.Second, 10*time.Millisecond)
+
+	stats := d.Stats()
+	assert.Len(t, stats, len(joined))
+
+	for _, st := range stats {
+		assert.Contains(t, joined, st.Name)
+		assert.Zero(t, st.Depth, st.Name)
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package dnsmsg decodes the DNS messages seen in passing on the wire, RFC
+// 1035. Only the questions and the A and AAAA answers are kept, which is
+// what tells a host is alive and what it looks for.
+package dnsmsg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var (
+	// ErrMalformedMessage is returned when a DNS message, or the packet
+	// carrying it, is too short or inconsistent
+	ErrMalformedMessage = errors.New("malformed DNS message")
+	// ErrNotDNS is returned when parsing a frame which doesn't carry a DNS
+	// message over UDP
+	ErrNotDNS = errors.New("not a DNS message")
+)
+
+const (
+	headerLen = 12
+	// fixedRRLen is the length of the type, class, TTL and data length of
+	// a resource record
+	fixedRRLen = 10
+	// maxNameLen and maxLabelLen are the limits of RFC 1035 section 2.3.4
+	maxNameLen  = 255
+	maxLabelLen = 63
+	// maxPointers bounds the compression pointers followed for a name,
+	// a valid name has fewer labels than that
+	maxPointers = 64
+	// maxQuestions and maxAnswers bound the records decoded, the others
+	// are ignored
+	maxQuestions = 16
+	maxAnswers   = 32
+
+	flagResponse = 0x8000
+)
+
+// Type is the type of a resource record
+type Type uint16
+
+const (
+	// TypeA is an IPv4 address
+	TypeA Type = 1
+	// TypeAAAA is an IPv6 address
+	TypeAAAA Type = 28
+)
+
+// Question is a name queried, with the type of the records asked for
+type Question struct {
+	Name string
+	Type Type
+}
+
+// Answer is an A or AAAA record of a response
+type Answer struct {
+	Addr netip.Addr
+	Name string
+	TTL  uint32
+}
+
+// Message is a DNS query or response
+type Message struct {
+	Questions []Question
+	// Answers are the A and AAAA records of the answer section, the
+	// records of other types are skipped
+	Answers []Answer
+	ID      uint16
+	// Response is set for a response and unset for a query
+	Response bool
+	// RCode is the response code, 0 when the query succeeded
+	RCode uint8
+}
+
+// UnmarshalBinary parses a DNS message
+func (m *Message) UnmarshalBinary(buf []byte) error {
+	return m.UnmarshalWith(buf, ethernet.ParserOptions{})
+}
+
+// UnmarshalWith is UnmarshalBinary within the limits of opts, see
+// ethernet.DecodeLimits: the labels and pointers of every name spend the
+// budget of a frame, which bounds the work of the names pointing at one
+// another however many records the header counts
+func (m *Message) UnmarshalWith(buf []byte, opts ethernet.ParserOptions) error {
+	budget := opts.Budget()
+
+	return m.unmarshal(buf, opts.DecodeLimits().Labels, &budget)
+}
+
+// unmarshal is UnmarshalWith reading names of up to labels labels
+func (m *Message) unmarshal(buf []byte, labels int, budget *ethernet.DecodeBudget) error {
+	if len(buf) < headerLen {
+		return ErrMalformedMessage
+	}
+
+	flags := binary.BigEndian.Uint16(buf[2:4])
+
+	*m = Message{
+		ID:       binary.BigEndian.Uint16(buf[0:2]),
+		Response: flags&flagResponse != 0,
+		RCode:    uint8(flags & 0x000f), //nolint:gosec // masked to 4 bits
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(buf[4:6]))
+	ancount := int(binary.BigEndian.Uint16(buf[6:8]))
+	off := headerLen
+
+	for i := range qdcount {
+		name, next, err := readName(buf, off, labels, budget)
+		if err != nil {
+			return fmt.Errorf("question %d: %w", i, err)
+		}
+
+		if len(buf) < next+4 {
+			return fmt.Errorf("%w: truncated question %d", ErrMalformedMessage, i)
+		}
+
+		if len(m.Questions) < maxQuestions {
+			m.Questions = append(m.Questions, Question{
+				Name: name,
+				Type: Type(binary.BigEndian.Uint16(buf[next:])),
+			})
+		}
+
+		off = next + 4
+	}
+
+	for i := range ancount {
+		name, next, err := readName(buf, off, labels, budget)
+		if err != nil {
+			return fmt.Errorf("answer %d: %w", i, err)
+		}
+
+		if len(buf) < next+fixedRRLen {
+			return fmt.Errorf("%w: truncated answer %d", ErrMalformedMessage, i)
+		}
+
+		typ := Type(binary.BigEndian.Uint16(buf[next:]))
+		ttl := binary.BigEndian.Uint32(buf[next+4:])
+		length := int(binary.BigEndian.Uint16(buf[next+8:]))
+		data := next + fixedRRLen
+
+		if len(buf) < data+length {
+			return fmt.Errorf("%w: truncated data of answer %d", ErrMalformedMessage, i)
+		}
+
+		off = data + length
+
+		if len(m.Answers) >= maxAnswers {
+			continue
+		}
+
+		var addr netip.Addr
+
+		switch {
+		case typ == TypeA && length == 4:
+			addr = netip.AddrFrom4([4]byte(buf[data:off]))
+		case typ == TypeAAAA && length == 16:
+			addr = netip.AddrFrom16([16]byte(buf[data:off]))
+		default:
+			continue
+		}
+
+		m.Answers = append(m.Answers, Answer{Name: name, Addr: addr, TTL: ttl})
+	}
+
+	return nil
+}
+
+// readName decodes the possibly compressed name at off in msg, it returns
+// the name in presentation format, without the trailing dot, and the
+// offset following it. Its labels and pointers, of which there may be
+// limit, spend budget. The mDNS messages compress names the same way.
+func readName(msg []byte, off, limit int, budget *ethernet.DecodeBudget) (string, int, error) {
+	var (
+		name     strings.Builder
+		next     = -1
+		pointers int
+		length   int
+	)
+
+	for n := 0; ; n++ {
+		if n == limit {
+			return "", 0, ethernet.LimitExceeded("DNS", "name", ErrMalformedMessage, limit, "labels")
+		}
+
+		if err := budget.Spend("DNS", ErrMalformedMessage); err != nil {
+			return "", 0, err
+		}
+
+		if off >= len(msg) {
+			return "", 0, fmt.Errorf("%w: truncated name", ErrMalformedMessage)
+		}
+
+		label := int(msg[off])
+
+		switch label & 0xc0 {
+		case 0x00:
+			if label == 0 {
+				if next < 0 {
+					next = off + 1
+				}
+
+				return name.String(), next, nil
+			}
+
+			end := off + 1 + label
+			if end > len(msg) {
+				return "", 0, fmt.Errorf("%w: truncated label", ErrMalformedMessage)
+			}
+
+			length += label + 1
+			if length > maxNameLen {
+				return "", 0, fmt.Errorf("%w: name longer than %d bytes", ErrMalformedMessage, maxNameLen)
+			}
+
+			if name.Len() > 0 {
+				name.WriteByte('.')
+			}
+
+			name.Write(msg[off+1 : end])
+			off = end
+		case 0xc0:
+			if off+2 > len(msg) {
+				return "", 0, fmt.Errorf("%w: truncated pointer", ErrMalformedMessage)
+			}
+
+			// a pointer must go backwards, which also rules out loops
+			target := int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			if target >= off || pointers >= maxPointers {
+				return "", 0, fmt.Errorf("%w: invalid compression pointer", ErrMalformedMessage)
+			}
+
+			if next < 0 {
+				next = off + 2
+			}
+
+			pointers++
+			off = target
+		default:
+			return "", 0, fmt.Errorf("%w: label type 0x%02x", ErrMalformedMessage, label&0xc0)
+		}
+	}
+}
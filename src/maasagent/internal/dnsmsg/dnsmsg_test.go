@@ -0,0 +1,257 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dnsmsg
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+func encodeName(name string) []byte {
+	var buf []byte
+
+	for _, label := range strings.Split(name, ".") {
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+
+	return append(buf, 0x00)
+}
+
+func header(id, flags uint16, qdcount, ancount int) []byte {
+	buf := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(buf[0:2], id)
+	binary.BigEndian.PutUint16(buf[2:4], flags)
+	binary.BigEndian.PutUint16(buf[4:6], uint16(qdcount)) //nolint:gosec // test messages are small
+	binary.BigEndian.PutUint16(buf[6:8], uint16(ancount)) //nolint:gosec // test messages are small
+
+	return buf
+}
+
+func query(id uint16, name string, typ Type) []byte {
+	msg := header(id, 0x0100, 1, 0)
+	msg = append(msg, encodeName(name)...)
+
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(msg, uint16(typ)), 1)
+}
+
+func record(name []byte, typ Type, ttl uint32, data []byte) []byte {
+	rr := append([]byte{}, name...)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(typ))
+	rr = binary.BigEndian.AppendUint16(rr, 1)
+	rr = binary.BigEndian.AppendUint32(rr, ttl)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(data))) //nolint:gosec // test records are small
+
+	return append(rr, data...)
+}
+
+// response answers a query of host.example.com with a CNAME to www, then
+// the A and AAAA records of www, the names compressed against the question
+func response() []byte {
+	msg := header(0x1234, 0x8180, 1, 3)
+	msg = append(msg, encodeName("host.example.com")...)
+	msg = append(msg, 0x00, 0x01, 0x00, 0x01)
+
+	// the question name is at offset 12, example.com at 17
+	www := len(msg) + 2 + fixedRRLen
+	cname := append([]byte{0x03, 'w', 'w', 'w'}, 0xc0, 17)
+	msg = append(msg, record([]byte{0xc0, 12}, 5, 300, cname)...)
+
+	msg = append(msg, record([]byte{0xc0, byte(www)}, TypeA, 60, []byte{10, 0, 0, 1})...)
+
+	return append(msg, record([]byte{0xc0, byte(www)}, TypeAAAA, 60,
+		netip.MustParseAddr("2001:db8::1").AsSlice())...)
+}
+
+func TestMessageUnmarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		err error
+		in  []byte
+		out Message
+	}{
+		"query": {
+			in: query(0x0102, "maas.example.com", TypeAAAA),
+			out: Message{
+				ID:        0x0102,
+				Questions: []Question{{Name: "maas.example.com", Type: TypeAAAA}},
+			},
+		},
+		"compressed response": {
+			in: response(),
+			out: Message{
+				ID:        0x1234,
+				Response:  true,
+				Questions: []Question{{Name: "host.example.com", Type: TypeA}},
+				Answers: []Answer{
+					{Name: "www.example.com", Addr: netip.MustParseAddr("10.0.0.1"), TTL: 60},
+					{Name: "www.example.com", Addr: netip.MustParseAddr("2001:db8::1"), TTL: 60},
+				},
+			},
+		},
+		"name error": {
+			in: header(7, 0x8183, 0, 0),
+			out: Message{
+				ID:       7,
+				Response: true,
+				RCode:    3,
+			},
+		},
+		"truncated header": {
+			in:  header(1, 0, 0, 0)[:11],
+			err: ErrMalformedMessage,
+		},
+		"missing question": {
+			in:  header(1, 0, 1, 0),
+			err: ErrMalformedMessage,
+		},
+		"truncated question": {
+			in:  append(header(1, 0, 1, 0), encodeName("a.b")...),
+			err: ErrMalformedMessage,
+		},
+		"truncated answer data": {
+			in:  append(header(1, 0x8000, 0, 1), record([]byte{0x00}, TypeA, 1, []byte{10, 0, 0, 1})[:13]...),
+			err: ErrMalformedMessage,
+		},
+		"forward pointer": {
+			in:  append(header(1, 0, 1, 0), 0xc0, 14, 0x00, 0x00, 0x01, 0x00, 0x01),
+			err: ErrMalformedMessage,
+		},
+		"pointer to itself": {
+			in:  append(header(1, 0, 1, 0), 0xc0, 12, 0x00, 0x01, 0x00, 0x01),
+			err: ErrMalformedMessage,
+		},
+		"reserved label type": {
+			in:  append(header(1, 0, 1, 0), 0x40, 0x00, 0x00, 0x01, 0x00, 0x01),
+			err: ErrMalformedMessage,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var msg Message
+
+			err := msg.UnmarshalBinary(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, msg)
+		})
+	}
+}
+
+func TestReadNameLimits(t *testing.T) {
+	t.Parallel()
+
+	budget := ethernet.ParserOptions{}.Budget()
+	long := strings.Repeat(strings.Repeat("a", maxLabelLen)+".", 4) + "com"
+
+	_, _, err := readName(encodeName(long), 0, ethernet.DefaultLabelLimit, &budget)
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+
+	// a chain of pointers, each to the previous one
+	chain, prev := encodeName("a"), 0
+	for range maxPointers + 1 {
+		next := len(chain)
+		chain = append(chain, 0xc0, byte(prev))
+		prev = next
+	}
+
+	_, _, err = readName(chain, len(chain)-2, ethernet.DefaultLabelLimit, &budget)
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+
+	// the labels of a name within the limits of RFC 1035, but not those of
+	// the options
+	labels := strings.Repeat("a.", 20) + "com"
+
+	_, _, err = readName(encodeName(labels), 0, 20, &budget)
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+	assert.ErrorIs(t, err, ethernet.ErrDecodeLimitExceeded)
+}
+
+// TestMessageBudget has every question of a message point at the end of a
+// chain of pointers as long as allowed: each costs a few bytes of the
+// message and tens of steps, the budget of the frame stops the decode
+func TestMessageBudget(t *testing.T) {
+	t.Parallel()
+
+	const questions = 2000
+
+	msg := header(1, 0, questions, 0)
+
+	first := len(msg)
+	msg = append(msg, encodeName("a")...)
+	msg = append(msg, 0x00, 0x01, 0x00, 0x01)
+
+	prev := first
+	for range maxPointers - 1 {
+		next := len(msg)
+		msg = append(msg, 0xc0|byte(prev>>8), byte(prev), 0x00, 0x01, 0x00, 0x01)
+		prev = next
+	}
+
+	// a jumbo frame
+	for len(msg) < 9000 {
+		msg = append(msg, 0xc0|byte(prev>>8), byte(prev), 0x00, 0x01, 0x00, 0x01)
+	}
+
+	var m Message
+
+	err := m.UnmarshalBinary(msg)
+	require.ErrorIs(t, err, ErrMalformedMessage)
+	assert.ErrorIs(t, err, ethernet.ErrDecodeLimitExceeded)
+
+	// the same message with fewer questions fits
+	binary.BigEndian.PutUint16(msg[4:6], maxPointers)
+	require.NoError(t, m.UnmarshalBinary(msg))
+	assert.Len(t, m.Questions, maxQuestions)
+}
+
+func TestMessageRecordLimits(t *testing.T) {
+	t.Parallel()
+
+	msg := header(1, 0x8000, maxQuestions+1, maxAnswers+1)
+
+	for range maxQuestions + 1 {
+		msg = append(msg, encodeName("a.example")...)
+		msg = append(msg, 0x00, 0x01, 0x00, 0x01)
+	}
+
+	for range maxAnswers + 1 {
+		msg = append(msg, record([]byte{0xc0, 12}, TypeA, 1, []byte{10, 0, 0, 1})...)
+	}
+
+	var m Message
+
+	require.NoError(t, m.UnmarshalBinary(msg))
+	assert.Len(t, m.Questions, maxQuestions)
+	assert.Len(t, m.Answers, maxAnswers)
+}
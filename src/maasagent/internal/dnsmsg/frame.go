@@ -0,0 +1,164 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dnsmsg
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	ethernetHeaderLen = 14
+	ethertypeIPv4     = 0x0800
+	ethertypeIPv6     = 0x86dd
+	ipv6HeaderLen     = 40
+	udpHeaderLen      = 8
+	protocolUDP       = 17
+	// Port is the port of plain DNS, the messages to and from other ports
+	// aren't parsed
+	Port = 53
+)
+
+// Packet is the addressing of a DNS message
+type Packet struct {
+	Src     netip.Addr
+	Dst     netip.Addr
+	SrcPort uint16
+	DstPort uint16
+}
+
+// ParseFrame returns the DNS message of an ethernet frame carrying a UDP
+// datagram from or to Port, after any VLAN tags, together with its
+// addressing. The IPv6 packets with extension headers and the fragments
+// aren't parsed.
+func ParseFrame(frame []byte) (Message, Packet, error) {
+	return ParseFrameWith(frame, ethernet.ParserOptions{})
+}
+
+// ParseFrameWith is ParseFrame within the limits of opts, see
+// ethernet.DecodeLimits. The tags and the names of the frame share its
+// budget.
+func ParseFrameWith(frame []byte, opts ethernet.ParserOptions) (Message, Packet, error) {
+	var (
+		msg Message
+		pkt Packet
+	)
+
+	if len(frame) < ethernetHeaderLen {
+		return msg, pkt, ErrMalformedMessage
+	}
+
+	off := 12
+	ethertype := binary.BigEndian.Uint16(frame[off:])
+	budget := opts.Budget()
+	limits := opts.DecodeLimits()
+
+	for n := 0; (ethertype == 0x8100 || ethertype == 0x88a8) && len(frame) >= off+6; n++ {
+		if n == limits.Tags {
+			return msg, pkt, ethernet.LimitExceeded("VLAN", "tags", ErrMalformedMessage, limits.Tags, "tags")
+		}
+
+		if err := budget.Spend("VLAN", ErrMalformedMessage); err != nil {
+			return msg, pkt, err
+		}
+
+		off += 4
+		ethertype = binary.BigEndian.Uint16(frame[off:])
+	}
+
+	var (
+		udp []byte
+		ok  bool
+	)
+
+	switch ethertype {
+	case ethertypeIPv4:
+		udp, ok = ipv4Payload(frame[off+2:], &pkt)
+	case ethertypeIPv6:
+		udp, ok = ipv6Payload(frame[off+2:], &pkt)
+	}
+
+	if !ok {
+		return msg, pkt, ErrNotDNS
+	}
+
+	if len(udp) < udpHeaderLen {
+		return msg, pkt, ErrMalformedMessage
+	}
+
+	pkt.SrcPort = binary.BigEndian.Uint16(udp[0:2])
+	pkt.DstPort = binary.BigEndian.Uint16(udp[2:4])
+
+	if pkt.SrcPort != Port && pkt.DstPort != Port {
+		return msg, pkt, ErrNotDNS
+	}
+
+	// trailing bytes are ethernet padding
+	length := int(binary.BigEndian.Uint16(udp[4:6]))
+	if length < udpHeaderLen || length > len(udp) {
+		return msg, pkt, ErrMalformedMessage
+	}
+
+	err := msg.unmarshal(udp[udpHeaderLen:length], limits.Labels, &budget)
+
+	return msg, pkt, err
+}
+
+// ipv4Payload returns the payload of an unfragmented IPv4 packet carrying
+// UDP
+func ipv4Payload(buf []byte, pkt *Packet) ([]byte, bool) {
+	if len(buf) < 20 || buf[0]>>4 != 4 {
+		return nil, false
+	}
+
+	ihl := int(buf[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(buf[2:4]))
+
+	if ihl < 20 || total < ihl || total > len(buf) || buf[9] != protocolUDP {
+		return nil, false
+	}
+
+	// only the first fragment has the UDP header, and reassembly is out
+	// of scope
+	if binary.BigEndian.Uint16(buf[6:8])&0x3fff != 0 {
+		return nil, false
+	}
+
+	pkt.Src = netip.AddrFrom4([4]byte(buf[12:16]))
+	pkt.Dst = netip.AddrFrom4([4]byte(buf[16:20]))
+
+	return buf[ihl:total], true
+}
+
+// ipv6Payload returns the payload of an IPv6 packet carrying UDP without
+// extension headers
+func ipv6Payload(buf []byte, pkt *Packet) ([]byte, bool) {
+	if len(buf) < ipv6HeaderLen || buf[0]>>4 != 6 || buf[6] != protocolUDP {
+		return nil, false
+	}
+
+	length := int(binary.BigEndian.Uint16(buf[4:6]))
+	if len(buf) < ipv6HeaderLen+length {
+		return nil, false
+	}
+
+	pkt.Src = netip.AddrFrom16([16]byte(buf[8:24]))
+	pkt.Dst = netip.AddrFrom16([16]byte(buf[24:40]))
+
+	return buf[ipv6HeaderLen : ipv6HeaderLen+length], true
+}
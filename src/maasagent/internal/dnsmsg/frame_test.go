@@ -0,0 +1,157 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dnsmsg
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testClient   = netip.MustParseAddr("10.0.0.2")
+	testResolver = netip.MustParseAddr("10.0.0.53")
+)
+
+func udpDatagram(src, dst uint16, payload []byte) []byte {
+	udp := make([]byte, udpHeaderLen)
+	binary.BigEndian.PutUint16(udp[0:2], src)
+	binary.BigEndian.PutUint16(udp[2:4], dst)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpHeaderLen+len(payload))) //nolint:gosec // test datagrams are small
+
+	return append(udp, payload...)
+}
+
+func ipv4Frame(src, dst netip.Addr, udp []byte) []byte {
+	frame := []byte{
+		0x00, 0x16, 0x3e, 0x00, 0x00, 0x35,
+		0x00, 0x16, 0x3e, 0x00, 0x00, 0x02,
+		0x08, 0x00,
+	}
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(udp))) //nolint:gosec // test packets are small
+	ip[8] = 64
+	ip[9] = protocolUDP
+	copy(ip[12:16], src.AsSlice())
+	copy(ip[16:20], dst.AsSlice())
+
+	return append(append(frame, ip...), udp...)
+}
+
+func ipv6Frame(src, dst netip.Addr, udp []byte) []byte {
+	frame := []byte{
+		0x33, 0x33, 0x00, 0x00, 0x00, 0x35,
+		0x00, 0x16, 0x3e, 0x00, 0x00, 0x02,
+		0x86, 0xdd,
+	}
+
+	ip := make([]byte, ipv6HeaderLen)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(len(udp))) //nolint:gosec // test packets are small
+	ip[6] = protocolUDP
+	ip[7] = 64
+	copy(ip[8:24], src.AsSlice())
+	copy(ip[24:40], dst.AsSlice())
+
+	return append(append(frame, ip...), udp...)
+}
+
+func TestParseFrame(t *testing.T) {
+	t.Parallel()
+
+	q := query(1, "maas.example.com", TypeA)
+
+	tagged := ipv4Frame(testClient, testResolver, udpDatagram(40000, Port, q))
+	tagged = append(tagged[:12:12], append([]byte{0x81, 0x00, 0x00, 0x02}, tagged[12:]...)...)
+
+	long := udpDatagram(40000, Port, q)
+	binary.BigEndian.PutUint16(long[4:6], uint16(len(long)+1)) //nolint:gosec // test datagrams are small
+
+	fragment := ipv4Frame(testClient, testResolver, udpDatagram(40000, Port, q))
+	fragment[14+6] = 0x20
+
+	testcases := map[string]struct {
+		err error
+		in  []byte
+		pkt Packet
+	}{
+		"IPv4 query": {
+			in:  ipv4Frame(testClient, testResolver, udpDatagram(40000, Port, q)),
+			pkt: Packet{Src: testClient, Dst: testResolver, SrcPort: 40000, DstPort: Port},
+		},
+		"IPv4 response with padding": {
+			in:  append(ipv4Frame(testResolver, testClient, udpDatagram(Port, 40000, q)), 0x00, 0x00),
+			pkt: Packet{Src: testResolver, Dst: testClient, SrcPort: Port, DstPort: 40000},
+		},
+		"IPv6 query": {
+			in: ipv6Frame(netip.MustParseAddr("2001:db8::2"), netip.MustParseAddr("2001:db8::53"),
+				udpDatagram(40000, Port, q)),
+			pkt: Packet{
+				Src:     netip.MustParseAddr("2001:db8::2"),
+				Dst:     netip.MustParseAddr("2001:db8::53"),
+				SrcPort: 40000,
+				DstPort: Port,
+			},
+		},
+		"VLAN tagged": {
+			in:  tagged,
+			pkt: Packet{Src: testClient, Dst: testResolver, SrcPort: 40000, DstPort: Port},
+		},
+		"other port": {
+			in:  ipv4Frame(testClient, testResolver, udpDatagram(40000, 5353, q)),
+			err: ErrNotDNS,
+		},
+		"fragment": {
+			in:  fragment,
+			err: ErrNotDNS,
+		},
+		"ARP": {
+			in:  append(make([]byte, 12), 0x08, 0x06),
+			err: ErrNotDNS,
+		},
+		"UDP length beyond the packet": {
+			in:  ipv4Frame(testClient, testResolver, long),
+			err: ErrMalformedMessage,
+		},
+		"truncated frame": {
+			in:  []byte{0x00},
+			err: ErrMalformedMessage,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, pkt, err := ParseFrame(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.pkt, pkt)
+			assert.Equal(t, []Question{{Name: "maas.example.com", Type: TypeA}}, msg.Questions)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dnsmsg
+
+import (
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+func FuzzParseFrame(f *testing.F) {
+	for _, seed := range [][]byte{
+		ipv4Frame(testClient, testResolver, udpDatagram(40000, Port, query(1, "maas.example.com", TypeA))),
+		ipv4Frame(testResolver, testClient, udpDatagram(Port, 40000, response())),
+		ipv6Frame(testClient, testResolver, udpDatagram(40000, Port, query(2, "a.b", TypeAAAA))),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			_, _, _ = ParseFrame(in)
+		})
+	})
+}
+
+func FuzzMessage(f *testing.F) {
+	f.Add(query(1, "maas.example.com", TypeA))
+	f.Add(response())
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			var msg Message
+
+			_ = msg.UnmarshalBinary(in)
+		})
+	})
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package eapol decodes the EAP over LAN frames of IEEE 802.1X, which an
+// authenticator, usually the switch, exchanges with the supplicant of a
+// port before letting its other traffic through
+package eapol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	// EthernetType is the ethertype of EAPOL
+	EthernetType = 0x888e
+
+	headerLen         = 4
+	eapHeaderLen      = 4
+	ethernetHeaderLen = 14
+)
+
+var (
+	// ErrMalformedPacket is returned when an EAPOL packet or the EAP packet
+	// it carries is truncated
+	ErrMalformedPacket = errors.New("malformed EAPOL packet")
+	// ErrNotEAPOL is returned by ParseFrame for frames of another type
+	ErrNotEAPOL = errors.New("ethernet frame not of type EAPOL")
+	// ErrNotEAP is returned by Packet.EAP for the packets not carrying an
+	// EAP packet
+	ErrNotEAP = errors.New("EAPOL packet not of type EAP")
+)
+
+// PAEGroupAddress is the destination of the EAPOL frames on a port with a
+// single supplicant
+var PAEGroupAddress = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x03}
+
+// PacketType is the type of an EAPOL packet
+type PacketType uint8
+
+const (
+	// PacketTypeEAP carries an EAP packet
+	PacketTypeEAP PacketType = 0
+	// PacketTypeStart is sent by a supplicant to start the authentication
+	PacketTypeStart PacketType = 1
+	// PacketTypeLogoff is sent by a supplicant leaving the port
+	PacketTypeLogoff PacketType = 2
+	// PacketTypeKey carries keying material
+	PacketTypeKey PacketType = 3
+)
+
+func (t PacketType) String() string {
+	switch t {
+	case PacketTypeEAP:
+		return "EAP"
+	case PacketTypeStart:
+		return "Start"
+	case PacketTypeLogoff:
+		return "Logoff"
+	case PacketTypeKey:
+		return "Key"
+	}
+
+	return fmt.Sprintf("PacketType(%d)", uint8(t))
+}
+
+// Code is the code of an EAP packet
+type Code uint8
+
+const (
+	// CodeRequest is sent by the authenticator
+	CodeRequest Code = 1
+	// CodeResponse answers a request
+	CodeResponse Code = 2
+	// CodeSuccess ends a successful authentication
+	CodeSuccess Code = 3
+	// CodeFailure ends a failed authentication
+	CodeFailure Code = 4
+)
+
+// MethodIdentity is the EAP method of the request for, and the response
+// with, the identity of the supplicant
+const MethodIdentity = 1
+
+// Packet is an EAPOL packet, Body aliases the buffer it was parsed from
+type Packet struct {
+	// Body is the content of the packet, without the padding of the frame
+	Body    []byte
+	Version uint8
+	Type    PacketType
+}
+
+// UnmarshalBinary parses an EAPOL packet, the body is cut to the length
+// of the header
+func (p *Packet) UnmarshalBinary(buf []byte) error {
+	if len(buf) < headerLen {
+		return fmt.Errorf("%w: %d bytes are too short for a header: %w", ErrMalformedPacket, len(buf),
+			io.ErrUnexpectedEOF)
+	}
+
+	length := int(binary.BigEndian.Uint16(buf[2:4]))
+	if headerLen+length > len(buf) {
+		return fmt.Errorf("%w: body of %d bytes in %d: %w", ErrMalformedPacket, length, len(buf)-headerLen,
+			io.ErrUnexpectedEOF)
+	}
+
+	p.Version = buf[0]
+	p.Type = PacketType(buf[1])
+	p.Body = buf[headerLen : headerLen+length : headerLen+length]
+
+	return nil
+}
+
+// EAP is an EAP packet, Data aliases the buffer it was parsed from
+type EAP struct {
+	// Data follows the method of requests and responses, and the header
+	// of the other codes
+	Data []byte
+	Code Code
+	ID   uint8
+	// Method is only set for requests and responses
+	Method uint8
+}
+
+// EAP returns the EAP packet of p, or ErrNotEAP for the other types
+func (p Packet) EAP() (EAP, error) {
+	if p.Type != PacketTypeEAP {
+		return EAP{}, ErrNotEAP
+	}
+
+	var e EAP
+
+	if err := e.UnmarshalBinary(p.Body); err != nil {
+		return EAP{}, err
+	}
+
+	return e, nil
+}
+
+// UnmarshalBinary parses an EAP packet
+func (e *EAP) UnmarshalBinary(buf []byte) error {
+	if len(buf) < eapHeaderLen {
+		return fmt.Errorf("%w: %d bytes are too short for an EAP header: %w", ErrMalformedPacket, len(buf),
+			io.ErrUnexpectedEOF)
+	}
+
+	length := int(binary.BigEndian.Uint16(buf[2:4]))
+	if length < eapHeaderLen {
+		return fmt.Errorf("%w: EAP length of %d", ErrMalformedPacket, length)
+	}
+
+	if length > len(buf) {
+		return fmt.Errorf("%w: EAP length of %d in %d bytes: %w", ErrMalformedPacket, length, len(buf),
+			io.ErrUnexpectedEOF)
+	}
+
+	e.Code = Code(buf[0])
+	e.ID = buf[1]
+	e.Method = 0
+	e.Data = buf[eapHeaderLen:length:length]
+
+	if e.Code == CodeRequest || e.Code == CodeResponse {
+		if len(e.Data) == 0 {
+			return fmt.Errorf("%w: %d without a method", ErrMalformedPacket, e.Code)
+		}
+
+		e.Method = e.Data[0]
+		e.Data = e.Data[1:]
+	}
+
+	return nil
+}
+
+// RequestIdentity returns whether e is the request the authenticator of a
+// port sends, and repeats, until a supplicant answers
+func (e EAP) RequestIdentity() bool {
+	return e.Code == CodeRequest && e.Method == MethodIdentity
+}
+
+// ParseFrame returns the EAPOL packet of an ethernet frame, tagged or not,
+// or ErrNotEAPOL if the frame is of another type
+func ParseFrame(frame []byte) (Packet, error) {
+	if len(frame) < ethernetHeaderLen {
+		return Packet{}, ErrNotEAPOL
+	}
+
+	off := 12
+	ethertype := binary.BigEndian.Uint16(frame[off:])
+
+	for (ethertype == 0x8100 || ethertype == 0x88a8) && len(frame) >= off+6 {
+		off += 4
+		ethertype = binary.BigEndian.Uint16(frame[off:])
+	}
+
+	if ethertype != EthernetType {
+		return Packet{}, ErrNotEAPOL
+	}
+
+	var p Packet
+
+	if err := p.UnmarshalBinary(frame[off+2:]); err != nil {
+		return Packet{}, err
+	}
+
+	return p, nil
+}
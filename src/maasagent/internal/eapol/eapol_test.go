@@ -0,0 +1,168 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eapol
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var authenticator = []byte{0x00, 0x1b, 0x21, 0xaa, 0xbb, 0xcc}
+
+// eapolFrame returns a frame from the authenticator to the PAE group
+// address carrying pkt, padded to the minimum length
+func eapolFrame(pkt ...byte) []byte {
+	frame := append([]byte{}, PAEGroupAddress...)
+	frame = append(frame, authenticator...)
+	frame = append(frame, 0x88, 0x8e)
+	frame = append(frame, pkt...)
+
+	for len(frame) < 60 {
+		frame = append(frame, 0)
+	}
+
+	return frame
+}
+
+// requestIdentity is an EAPOL packet of 802.1X-2004 carrying an EAP
+// Request-Identity
+var requestIdentity = []byte{0x02, 0x00, 0x00, 0x05, 0x01, 0x01, 0x00, 0x05, 0x01}
+
+func TestParseFrame(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in      []byte
+		packet  Packet
+		eap     EAP
+		eapErr  error
+		err     error
+		request bool
+	}{
+		"request identity": {
+			in: eapolFrame(requestIdentity...),
+			packet: Packet{
+				Version: 2,
+				Type:    PacketTypeEAP,
+				Body:    []byte{0x01, 0x01, 0x00, 0x05, 0x01},
+			},
+			eap:     EAP{Code: CodeRequest, ID: 1, Method: MethodIdentity, Data: []byte{}},
+			request: true,
+		},
+		"tagged response identity": {
+			in: append(append(append(append([]byte{}, PAEGroupAddress...), authenticator...),
+				0x81, 0x00, 0x00, 0x0c, 0x88, 0x8e),
+				0x01, 0x00, 0x00, 0x09, 0x02, 0x07, 0x00, 0x09, 0x01, 'h', 'o', 's', 't'),
+			packet: Packet{
+				Version: 1,
+				Type:    PacketTypeEAP,
+				Body:    []byte{0x02, 0x07, 0x00, 0x09, 0x01, 'h', 'o', 's', 't'},
+			},
+			eap: EAP{Code: CodeResponse, ID: 7, Method: MethodIdentity, Data: []byte("host")},
+		},
+		"failure": {
+			in:     eapolFrame(0x02, 0x00, 0x00, 0x04, 0x04, 0x03, 0x00, 0x04),
+			packet: Packet{Version: 2, Type: PacketTypeEAP, Body: []byte{0x04, 0x03, 0x00, 0x04}},
+			eap:    EAP{Code: CodeFailure, ID: 3, Data: []byte{}},
+		},
+		"start": {
+			in:     eapolFrame(0x01, 0x01, 0x00, 0x00),
+			packet: Packet{Version: 1, Type: PacketTypeStart, Body: []byte{}},
+			eapErr: ErrNotEAP,
+		},
+		"request without method": {
+			in:     eapolFrame(0x02, 0x00, 0x00, 0x04, 0x01, 0x01, 0x00, 0x04),
+			packet: Packet{Version: 2, Type: PacketTypeEAP, Body: []byte{0x01, 0x01, 0x00, 0x04}},
+			eapErr: ErrMalformedPacket,
+		},
+		"EAP longer than the body": {
+			in:     eapolFrame(0x02, 0x00, 0x00, 0x04, 0x01, 0x01, 0x00, 0x09),
+			packet: Packet{Version: 2, Type: PacketTypeEAP, Body: []byte{0x01, 0x01, 0x00, 0x09}},
+			eapErr: ErrMalformedPacket,
+		},
+		"body longer than the frame": {
+			in:  eapolFrame(0x02, 0x00, 0x01, 0x00),
+			err: ErrMalformedPacket,
+		},
+		"truncated header": {
+			in:  append(append(append([]byte{}, PAEGroupAddress...), authenticator...), 0x88, 0x8e, 0x02),
+			err: ErrMalformedPacket,
+		},
+		"ARP": {
+			in:  append(append(append([]byte{}, PAEGroupAddress...), authenticator...), 0x08, 0x06),
+			err: ErrNotEAPOL,
+		},
+		"runt": {
+			in:  []byte{0x01, 0x80},
+			err: ErrNotEAPOL,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := ParseFrame(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.packet, p)
+
+			eap, err := p.EAP()
+			if tc.eapErr != nil {
+				assert.ErrorIs(t, err, tc.eapErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.eap, eap)
+			assert.Equal(t, tc.request, eap.RequestIdentity())
+		})
+	}
+}
+
+func TestPacketTypeString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "EAP", PacketTypeEAP.String())
+	assert.Equal(t, "Logoff", PacketTypeLogoff.String())
+	assert.Equal(t, "PacketType(9)", PacketType(9).String())
+}
+
+func TestParseFrameSnaplen(t *testing.T) {
+	t.Parallel()
+
+	in := eapolFrame(requestIdentity...)
+
+	// a snaplen keeping the ethernet header but cutting the packet leaves it
+	// truncated rather than inconsistent
+	for n := 14; n < 14+len(requestIdentity); n++ {
+		pkt, err := ParseFrame(in[:n])
+		if err == nil {
+			_, err = pkt.EAP()
+		}
+
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "%d bytes", n)
+	}
+}
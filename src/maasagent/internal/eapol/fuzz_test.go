@@ -0,0 +1,40 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eapol
+
+import (
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+func FuzzParseFrame(f *testing.F) {
+	f.Add(eapolFrame(requestIdentity...))
+	f.Add(eapolFrame(0x01, 0x01, 0x00, 0x00))
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			p, err := ParseFrame(in)
+			if err != nil {
+				return
+			}
+
+			if eap, err := p.EAP(); err == nil {
+				_ = eap.RequestIdentity()
+			}
+		})
+	})
+}
@@ -18,7 +18,6 @@ package ethernet
 import (
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"net/netip"
@@ -86,6 +85,9 @@ const (
 	OpReply
 )
 
+// arpEthernetIPv4Len is the length of an ARP packet of ethernet and IPv4
+const arpEthernetIPv4Len = 28
+
 var (
 	// ErrMalformedPacket is an error returned when parsing a malformed ARP packet
 	ErrMalformedARPPacket = errors.New("malformed ARP packet")
@@ -93,10 +95,17 @@ var (
 
 // ARPPacket is a struct containing the data of an ARP packet
 type ARPPacket struct {
-	SendIPAddr      netip.Addr
-	TgtIPAddr       netip.Addr
-	SendHwAddr      net.HardwareAddr
-	TgtHwAddr       net.HardwareAddr
+	// SendIPAddr and TgtIPAddr are only valid for 4 and 16 byte protocol
+	// addresses, which are the only ones decoded unless opaque ones are
+	// asked for, IPv4-mapped IPv6 addresses are unmapped
+	SendIPAddr netip.Addr
+	TgtIPAddr  netip.Addr
+	SendHwAddr net.HardwareAddr
+	TgtHwAddr  net.HardwareAddr
+	// SendProtoAddr and TgtProtoAddr are the protocol addresses as found
+	// on the wire, whatever the protocol type
+	SendProtoAddr   []byte
+	TgtProtoAddr    []byte
 	HardwareType    HardwareType
 	OpCode          uint16
 	ProtocolType    ProtocolType
@@ -104,27 +113,44 @@ type ARPPacket struct {
 	ProtocolAddrLen uint8
 }
 
-func checkPacketLen(buf []byte, bytesRead, length int) error {
+// checkPacketLen returns an error matching ErrTruncated unless length bytes
+// of field follow bytesRead
+func checkPacketLen(buf []byte, bytesRead, length int, field, detail string) error {
 	if len(buf) == 0 {
-		return io.ErrUnexpectedEOF
+		return &DecodeError{Kind: ErrTruncated, Err: io.ErrUnexpectedEOF, Protocol: "ARP", Field: field, Detail: detail}
 	}
 
 	if len(buf[bytesRead:]) < length {
-		return ErrMalformedARPPacket
+		return &DecodeError{Kind: ErrTruncated, Err: ErrMalformedARPPacket, Protocol: "ARP", Field: field, Detail: detail}
 	}
 
 	return nil
 }
 
-// UnmarshalBinary takes the ARP packet bytes and parses it into a Packet
+// UnmarshalBinary takes the ARP packet bytes and parses it into a Packet,
+// protocol addresses neither 4 nor 16 bytes long are malformed
 func (pkt *ARPPacket) UnmarshalBinary(buf []byte) error {
+	return pkt.unmarshal(buf, ParserOptions{}, false)
+}
+
+// UnmarshalOpaque is UnmarshalBinary keeping the protocol addresses of any
+// length in SendProtoAddr and TgtProtoAddr, those which aren't IP addresses
+// leave SendIPAddr and TgtIPAddr invalid
+func (pkt *ARPPacket) UnmarshalOpaque(buf []byte) error {
+	return pkt.unmarshal(buf, ParserOptions{}, true)
+}
+
+// unmarshal parses the packet, with the address lengths of an ethernet and
+// IPv4 packet checked or salvaged as the options decide, and protocol
+// addresses which aren't IP addresses only decoded when opaque
+func (pkt *ARPPacket) unmarshal(buf []byte, opts ParserOptions, opaque bool) error {
 	var (
 		bytesRead int
 	)
 
-	err := checkPacketLen(buf, bytesRead, 8)
+	err := checkPacketLen(buf, bytesRead, 8, "header", "packet missing initial ARP fields")
 	if err != nil {
-		return fmt.Errorf("%w: packet missing initial ARP fields", err)
+		return err
 	}
 
 	pkt.HardwareType = HardwareType(binary.BigEndian.Uint16(buf[0:2]))
@@ -133,60 +159,94 @@ func (pkt *ARPPacket) UnmarshalBinary(buf []byte) error {
 	pkt.ProtocolAddrLen = buf[5]
 	pkt.OpCode = binary.BigEndian.Uint16(buf[6:8])
 
+	ethernetIPv4 := pkt.HardwareType == HardwareTypeEthernet && pkt.ProtocolType == ProtocolTypeIPv4
+	inconsistent := pkt.HardwareType == HardwareTypeEthernet && pkt.HardwareAddrLen != hwAddrLen ||
+		pkt.ProtocolType == ProtocolTypeIPv4 && pkt.ProtocolAddrLen != 4
+
+	switch {
+	case inconsistent && opts.RejectARPLengths():
+		return malformed("ARP", "address lengths", ErrMalformedARPPacket).
+			detailf("lengths %d and %d for %s and %s", pkt.HardwareAddrLen, pkt.ProtocolAddrLen,
+				pkt.HardwareType, pkt.ProtocolType)
+	case inconsistent && ethernetIPv4 && opts.SalvageARPLengths() && len(buf) >= arpEthernetIPv4Len:
+		pkt.HardwareAddrLen, pkt.ProtocolAddrLen = hwAddrLen, 4
+	}
+
 	bytesRead = 8
 	hwdAddrLen := int(pkt.HardwareAddrLen)
 	ipAddrLen := int(pkt.ProtocolAddrLen)
 
-	err = checkPacketLen(buf, bytesRead, hwdAddrLen)
+	err = checkPacketLen(buf, bytesRead, hwdAddrLen, "sender hardware address", "packet too short for sender hardware address")
 	if err != nil {
-		return fmt.Errorf("%w: packet too short for sender hardware address", err)
+		return err
 	}
 
-	sendHwAddrBuf := make([]byte, hwdAddrLen)
-	copy(sendHwAddrBuf, buf[bytesRead:bytesRead+hwdAddrLen])
-	pkt.SendHwAddr = sendHwAddrBuf
-	bytesRead += hwdAddrLen
+	// the addresses outlive buf, they are copied to a single array
+	addrs := make([]byte, 0, 2*(hwdAddrLen+ipAddrLen))
+	addr := func(n int) []byte {
+		start := len(addrs)
+		addrs = append(addrs, buf[bytesRead:bytesRead+n]...)
+		bytesRead += n
+
+		return addrs[start:len(addrs):len(addrs)]
+	}
 
-	err = checkPacketLen(buf, bytesRead, ipAddrLen)
+	pkt.SendHwAddr = addr(hwdAddrLen)
+
+	err = checkPacketLen(buf, bytesRead, ipAddrLen, "sender IP address", "packet too short for sender IP address")
 	if err != nil {
-		return fmt.Errorf("%w: packet too short for sender IP address", err)
+		return err
 	}
 
-	var ok bool
+	pkt.SendProtoAddr = addr(ipAddrLen)
+	pkt.SendIPAddr = protoAddr(pkt.SendProtoAddr)
 
-	sendIPAddrBuf := make([]byte, ipAddrLen)
-	copy(sendIPAddrBuf, buf[bytesRead:bytesRead+ipAddrLen])
+	if !pkt.SendIPAddr.IsValid() && !opaque {
+		return malformed("ARP", "sender IP address", ErrMalformedARPPacket).detailf("invalid sender IP address")
+	}
 
-	pkt.SendIPAddr, ok = netip.AddrFromSlice(sendIPAddrBuf)
-	if !ok {
-		return fmt.Errorf("%w: invalid sender IP address", ErrMalformedARPPacket)
+	err = checkPacketLen(buf, bytesRead, hwdAddrLen, "target hardware address", "packet too short for target hardware address")
+	if err != nil {
+		return err
 	}
 
-	bytesRead += ipAddrLen
+	pkt.TgtHwAddr = addr(hwdAddrLen)
 
-	err = checkPacketLen(buf, bytesRead, hwdAddrLen)
+	err = checkPacketLen(buf, bytesRead, ipAddrLen, "target IP address", "packet too short for target IP address")
 	if err != nil {
-		return fmt.Errorf("%w: packet too short for target hardware address", err)
+		return err
 	}
 
-	tgtHwAddrBuf := make([]byte, hwdAddrLen)
-	copy(tgtHwAddrBuf, buf[bytesRead:bytesRead+hwdAddrLen])
-
-	pkt.TgtHwAddr = tgtHwAddrBuf
-	bytesRead += hwdAddrLen
+	pkt.TgtProtoAddr = addr(ipAddrLen)
+	pkt.TgtIPAddr = protoAddr(pkt.TgtProtoAddr)
 
-	err = checkPacketLen(buf, bytesRead, ipAddrLen)
-	if err != nil {
-		return fmt.Errorf("%w: packet too short for target IP address", err)
+	if !pkt.TgtIPAddr.IsValid() && !opaque {
+		return malformed("ARP", "target IP address", ErrMalformedARPPacket).detailf("invalid target IP address")
 	}
 
-	tgtIPAddrBuf := make([]byte, ipAddrLen)
-	copy(tgtIPAddrBuf, buf[bytesRead:bytesRead+ipAddrLen])
+	return nil
+}
 
-	pkt.TgtIPAddr, ok = netip.AddrFromSlice(tgtIPAddrBuf)
+// protoAddr converts a protocol address to a netip.Addr, which is invalid
+// when the address is neither 4 nor 16 bytes long
+func protoAddr(buf []byte) netip.Addr {
+	addr, ok := netip.AddrFromSlice(buf)
 	if !ok {
-		return fmt.Errorf("%w: invalid target IP address", ErrMalformedARPPacket)
+		return netip.Addr{}
 	}
 
-	return nil
+	// 4-in-6 addresses must compare equal to their IPv4 form
+	return addr.Unmap()
+}
+
+// SenderAddr returns the sender protocol address, IPv4-mapped IPv6
+// addresses are returned in their IPv4 form
+func (pkt *ARPPacket) SenderAddr() netip.Addr {
+	return pkt.SendIPAddr.Unmap()
+}
+
+// TargetAddr returns the target protocol address, IPv4-mapped IPv6
+// addresses are returned in their IPv4 form
+func (pkt *ARPPacket) TargetAddr() netip.Addr {
+	return pkt.TgtIPAddr.Unmap()
 }
@@ -44,8 +44,10 @@ func TestUnmarshal(t *testing.T) {
 				OpCode:          OpRequest,
 				SendHwAddr:      []byte{0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25},
 				SendIPAddr:      netip.MustParseAddr("192.168.10.26"),
+				SendProtoAddr:   []byte{0xc0, 0xa8, 0x0a, 0x1a},
 				TgtHwAddr:       []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x0},
 				TgtIPAddr:       netip.MustParseAddr("192.168.10.25"),
+				TgtProtoAddr:    []byte{0xc0, 0xa8, 0x0a, 0x19},
 			},
 		},
 		"valid reply packet": {
@@ -61,8 +63,10 @@ func TestUnmarshal(t *testing.T) {
 				OpCode:          OpReply,
 				SendHwAddr:      []byte{0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16},
 				SendIPAddr:      netip.MustParseAddr("192.168.1.108"),
+				SendProtoAddr:   []byte{0xc0, 0xa8, 0x01, 0x6c},
 				TgtHwAddr:       []byte{0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26},
 				TgtIPAddr:       netip.MustParseAddr("192.168.1.80"),
+				TgtProtoAddr:    []byte{0xc0, 0xa8, 0x01, 0x50},
 			},
 		},
 		"empty packet": {
@@ -94,3 +98,81 @@ func TestUnmarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestUnmarshalProtocolAddrs(t *testing.T) {
+	t.Parallel()
+
+	header := func(plen byte) []byte {
+		return []byte{0x00, 0x01, 0x08, 0x00, 0x06, plen, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25}
+	}
+	mac := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	mapped := netip.MustParseAddr("::ffff:192.168.10.26").AsSlice()
+
+	testcases := map[string]struct {
+		in         []byte
+		sender     netip.Addr
+		target     netip.Addr
+		senderRaw  []byte
+		targetRaw  []byte
+		comparable bool
+	}{
+		"IPv4-mapped IPv6 is unmapped": {
+			in:         concat(header(16), mapped, mac, netip.MustParseAddr("2001:db8::1").AsSlice()),
+			sender:     netip.MustParseAddr("192.168.10.26"),
+			target:     netip.MustParseAddr("2001:db8::1"),
+			senderRaw:  mapped,
+			targetRaw:  netip.MustParseAddr("2001:db8::1").AsSlice(),
+			comparable: true,
+		},
+		"non-IP protocol addresses keep the raw bytes": {
+			in:        concat(header(2), []byte{0x0a, 0x0b}, mac, []byte{0x0c, 0x0d}),
+			senderRaw: []byte{0x0a, 0x0b},
+			targetRaw: []byte{0x0c, 0x0d},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pkt := &ARPPacket{}
+			assert.NoError(t, pkt.UnmarshalOpaque(tc.in))
+			assert.Equal(t, tc.sender, pkt.SenderAddr())
+			assert.Equal(t, tc.target, pkt.TargetAddr())
+			assert.Equal(t, tc.senderRaw, pkt.SendProtoAddr)
+			assert.Equal(t, tc.targetRaw, pkt.TgtProtoAddr)
+
+			if tc.comparable {
+				// the unmapped sender is usable as a map key alongside
+				// addresses parsed from IPv4 packets
+				seen := map[netip.Addr]bool{netip.MustParseAddr("192.168.10.26"): true}
+				assert.True(t, seen[pkt.SenderAddr()])
+			}
+		})
+	}
+}
+
+func TestARPPacketAddrAccessors(t *testing.T) {
+	t.Parallel()
+
+	// packets built by hand may still carry mapped addresses
+	pkt := &ARPPacket{
+		SendIPAddr: netip.MustParseAddr("::ffff:10.0.0.1"),
+		TgtIPAddr:  netip.MustParseAddr("10.0.0.2"),
+	}
+
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), pkt.SenderAddr())
+	assert.Equal(t, netip.MustParseAddr("10.0.0.2"), pkt.TargetAddr())
+}
+
+func concat(bufs ...[]byte) []byte {
+	var out []byte
+
+	for _, b := range bufs {
+		out = append(out, b...)
+	}
+
+	return out
+}
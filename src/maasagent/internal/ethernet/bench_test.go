@@ -0,0 +1,261 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/alloc"
+)
+
+var (
+	benchSrc = net.HardwareAddr{0x00, 0x16, 0x3e, 0x4a, 0x10, 0x01}
+	benchIP  = netip.MustParseAddr("192.168.10.26")
+)
+
+// benchFrames returns an ARP request untagged, behind an 802.1Q tag and
+// behind an 802.1ad and an 802.1Q tag
+func benchFrames(tb testing.TB) (untagged, tagged, qinq []byte) {
+	tb.Helper()
+
+	arp := func(b *FrameBuilder) []byte {
+		return mustBuild(tb, b.Src(benchSrc).Padded().ARPRequest(benchIP, benchIP))
+	}
+
+	return arp(NewFrame()), arp(NewFrame().VLAN(100)), arp(NewFrame().VLAN(100, WithTPID(0x88a8)).VLAN(2))
+}
+
+// mixedFrames is a sample of the traffic of a busy segment, most of it
+// not ARP
+func mixedFrames(tb testing.TB) [][]byte {
+	tb.Helper()
+
+	untagged, tagged, qinq := benchFrames(tb)
+	ip6 := netip.MustParseAddr("fe80::216:3eff:fe4a:1001")
+
+	return [][]byte{
+		untagged,
+		tagged,
+		qinq,
+		lacpFrame,
+		mustBuild(tb, NewFrame().Src(benchSrc).DHCPDiscover(1)),
+		mustBuild(tb, NewFrame().Src(benchSrc).NeighborSolicitation(ip6, ip6)),
+		mustBuild(tb, NewFrame().Src(benchSrc).UDP(netip.MustParseAddrPort("10.0.0.1:4789"),
+			netip.MustParseAddrPort("10.0.0.2:4789"), make([]byte, 1400))),
+		mustBuild(tb, NewFrame().Src(benchSrc).Dst(net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x35}).VLAN(100).
+			Padded().Payload(EthernetTypeCFM, []byte{0xa0, 0x01, 0x04, 0x46, 0x00, 0x00, 0x00, 0x2a, 0x00, 0x65})),
+	}
+}
+
+// decode extracts what the frame carries, as a consumer of every protocol
+// would
+func decode(frame *EthernetFrame, buf []byte) {
+	if frame.UnmarshalBinary(buf) != nil {
+		return
+	}
+
+	ethType := frame.EthernetType
+
+	if ethType == EthernetTypeVLAN {
+		vlan, err := frame.ExtractVLAN()
+		if err != nil {
+			return
+		}
+
+		ethType = vlan.EthernetType
+	}
+
+	switch ethType {
+	case EthernetTypeARP:
+		if pkt, err := frame.ExtractARPPacket(); err == nil {
+			_ = pkt.Validate(ValidationStrict, frame)
+		}
+	case EthernetTypeSlowProtocols:
+		_, _ = frame.ExtractLACP() //nolint:errcheck // only the cost matters
+	case EthernetTypeCFM:
+		_, _ = frame.ExtractCFM() //nolint:errcheck // only the cost matters
+	}
+}
+
+func BenchmarkEthernetFrameUnmarshal(b *testing.B) {
+	untagged, tagged, qinq := benchFrames(b)
+
+	for name, buf := range map[string][]byte{"untagged": untagged, "tagged": tagged, "qinq": qinq} {
+		b.Run(name, func(b *testing.B) {
+			frame := &EthernetFrame{}
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(buf)))
+
+			for b.Loop() {
+				_ = frame.UnmarshalBinary(buf)
+			}
+		})
+	}
+}
+
+func BenchmarkEthernetFrameExtractVLAN(b *testing.B) {
+	_, tagged, _ := benchFrames(b)
+	frame := &EthernetFrame{}
+
+	if err := frame.UnmarshalBinary(tagged); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		_, _ = frame.ExtractVLAN() //nolint:errcheck // the frame is valid
+	}
+}
+
+func BenchmarkEthernetFrameExtractARP(b *testing.B) {
+	untagged, tagged, _ := benchFrames(b)
+
+	for name, buf := range map[string][]byte{"untagged": untagged, "tagged": tagged} {
+		b.Run(name, func(b *testing.B) {
+			frame := &EthernetFrame{}
+
+			if err := frame.UnmarshalBinary(buf); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+
+			for b.Loop() {
+				_, _ = frame.ExtractARPPacket() //nolint:errcheck // the frame is valid
+			}
+		})
+	}
+}
+
+func BenchmarkEthernetFrameARPView(b *testing.B) {
+	untagged, _, _ := benchFrames(b)
+	frame := &EthernetFrame{}
+
+	if err := frame.UnmarshalBinary(untagged); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		view, _ := frame.ARPView() //nolint:errcheck // the frame is valid
+		_ = view.SenderAddr()
+		_ = view.TargetAddr()
+	}
+}
+
+func BenchmarkValidate(b *testing.B) {
+	untagged, _, _ := benchFrames(b)
+	frame := &EthernetFrame{}
+
+	if err := frame.UnmarshalBinary(untagged); err != nil {
+		b.Fatal(err)
+	}
+
+	pkt, err := frame.ExtractARPPacket()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		_ = frame.Validate(ValidationStrict)
+		_ = pkt.Validate(ValidationStrict, frame)
+	}
+}
+
+func BenchmarkDecodeMixed(b *testing.B) {
+	frames := mixedFrames(b)
+	frame := &EthernetFrame{}
+
+	var size int
+
+	for _, buf := range frames {
+		size += len(buf)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+
+	for b.Loop() {
+		for _, buf := range frames {
+			decode(frame, buf)
+		}
+	}
+}
+
+// TestAllocationBudgets pins the allocations of the hot paths, a change
+// exceeding a budget must be justified and the budget raised with it
+func TestAllocationBudgets(t *testing.T) {
+	untagged, tagged, qinq := benchFrames(t)
+	frames := mixedFrames(t)
+
+	parsed := func(buf []byte) *EthernetFrame {
+		frame := &EthernetFrame{}
+
+		if err := frame.UnmarshalBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		return frame
+	}
+
+	untaggedFrame, taggedFrame := parsed(untagged), parsed(tagged)
+
+	pkt, err := untaggedFrame.ExtractARPPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reused := &EthernetFrame{}
+
+	testcases := []struct {
+		path   string
+		f      func()
+		budget int
+	}{
+		{path: "EthernetFrame.UnmarshalBinary untagged", budget: 0, f: func() { _ = reused.UnmarshalBinary(untagged) }},
+		{path: "EthernetFrame.UnmarshalBinary tagged", budget: 0, f: func() { _ = reused.UnmarshalBinary(tagged) }},
+		{path: "EthernetFrame.UnmarshalBinary QinQ", budget: 0, f: func() { _ = reused.UnmarshalBinary(qinq) }},
+		{path: "EthernetFrame.ExtractVLAN", budget: 1, f: func() { _, _ = taggedFrame.ExtractVLAN() }},             //nolint:errcheck // valid
+		{path: "EthernetFrame.ExtractARPPacket", budget: 2, f: func() { _, _ = untaggedFrame.ExtractARPPacket() }}, //nolint:errcheck // valid
+		{path: "EthernetFrame.Validate", budget: 0, f: func() { _ = untaggedFrame.Validate(ValidationStrict) }},
+		{path: "ARPPacket.Validate", budget: 0, f: func() { _ = pkt.Validate(ValidationStrict, untaggedFrame) }},
+		{path: "ARPView accessors", budget: 0, f: func() {
+			view, _ := untaggedFrame.ARPView() //nolint:errcheck // valid
+			_ = view.SenderHardwareAddr()
+			_ = view.SenderAddr()
+			_ = view.TargetAddr()
+		}},
+		{path: "EthernetFrame.Hash", budget: 0, f: func() { _ = taggedFrame.Hash() }},
+		{path: "EthernetFrame.Equal", budget: 0, f: func() { _ = taggedFrame.Equal(untaggedFrame) }},
+		{path: "EthernetFrame.DedupKey", budget: 0, f: func() { _ = taggedFrame.DedupKey() }},
+		{path: "decode of the mixed frames", budget: 6, f: func() {
+			for _, buf := range frames {
+				decode(reused, buf)
+			}
+		}},
+	}
+
+	for _, tc := range testcases {
+		alloc.Budget(t, tc.path, tc.budget, tc.f)
+	}
+}
@@ -0,0 +1,508 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"maas.io/core/src/maasagent/internal/checksum"
+)
+
+const (
+	// minFrameLen is the shortest frame on the wire without its FCS, Padded
+	// frames are extended to it
+	minFrameLen   = 60
+	ipv6HeaderLen = 40
+
+	dhcpClientPort = 68
+	dhcpServerPort = 67
+
+	wakeOnLANRepeat = 16
+)
+
+// NAFlags are the flags of an NDP neighbor advertisement
+type NAFlags uint8
+
+const (
+	// NAFlagOverride asks the receivers to update their cached address
+	NAFlagOverride NAFlags = 1 << (5 + iota)
+	// NAFlagSolicited marks the answer to a neighbor solicitation
+	NAFlagSolicited
+	// NAFlagRouter is set by routers
+	NAFlagRouter
+)
+
+var (
+	// Broadcast is the ethernet broadcast address
+	Broadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	// ErrBuildFrame is returned by FrameBuilder.Build when the frame
+	// described is inconsistent
+	ErrBuildFrame = errors.New("cannot build frame")
+)
+
+// VLANOption configures a tag added by FrameBuilder.VLAN
+type VLANOption func(*vlanTag)
+
+// WithPriority sets the priority code point of the tag, from 0 to 7
+func WithPriority(pcp uint8) VLANOption {
+	return func(t *vlanTag) {
+		t.priority = pcp
+	}
+}
+
+// WithDropEligible sets the drop eligible indicator of the tag
+func WithDropEligible() VLANOption {
+	return func(t *vlanTag) {
+		t.dropEligible = true
+	}
+}
+
+// WithTPID sets the tag protocol identifier, 0x88a8 for an 802.1ad service
+// tag, instead of 802.1Q
+func WithTPID(tpid EthernetType) VLANOption {
+	return func(t *vlanTag) {
+		t.tpid = tpid
+	}
+}
+
+type vlanTag struct {
+	id           uint16
+	tpid         EthernetType
+	priority     uint8
+	dropEligible bool
+}
+
+// payload is what follows the ethernet header, build is called once the
+// addresses of the frame are known
+type payload struct {
+	build     func(b *FrameBuilder) ([]byte, error)
+	name      string
+	ethertype EthernetType
+}
+
+// FrameBuilder builds ethernet frames for tests and probes. The setters can
+// be chained, errors are reported by Build:
+//
+//	frame, err := NewFrame().Src(mac).Dst(Broadcast).VLAN(100, WithPriority(3)).
+//		ARPRequest(srcIP, targetIP).Build()
+type FrameBuilder struct {
+	payload   *payload
+	ethertype *EthernetType
+	src       net.HardwareAddr
+	dst       net.HardwareAddr
+	tags      []vlanTag
+	errs      []error
+	pad       bool
+}
+
+// NewFrame returns an empty FrameBuilder, the destination defaults to the
+// broadcast address, or to the multicast MAC of an IPv6 destination
+func NewFrame() *FrameBuilder {
+	return &FrameBuilder{}
+}
+
+// Src sets the source MAC, which is also the sender of the ARP and NDP
+// payloads
+func (b *FrameBuilder) Src(mac net.HardwareAddr) *FrameBuilder {
+	b.src = mac
+	return b
+}
+
+// Dst sets the destination MAC
+func (b *FrameBuilder) Dst(mac net.HardwareAddr) *FrameBuilder {
+	b.dst = mac
+	return b
+}
+
+// VLAN adds a tag, the first tag added is the outermost
+func (b *FrameBuilder) VLAN(id uint16, options ...VLANOption) *FrameBuilder {
+	tag := vlanTag{id: id, tpid: EthernetTypeVLAN}
+
+	for _, opt := range options {
+		opt(&tag)
+	}
+
+	b.tags = append(b.tags, tag)
+
+	return b
+}
+
+// EtherType sets the ethertype of the frame, it must be the one of the
+// payload, if any
+func (b *FrameBuilder) EtherType(ethertype EthernetType) *FrameBuilder {
+	b.ethertype = &ethertype
+	return b
+}
+
+// Padded extends the frame to the 60 bytes minimum of ethernet
+func (b *FrameBuilder) Padded() *FrameBuilder {
+	b.pad = true
+	return b
+}
+
+// Payload sets raw bytes following the ethernet header
+func (b *FrameBuilder) Payload(ethertype EthernetType, data []byte) *FrameBuilder {
+	return b.setPayload(&payload{
+		name:      "raw",
+		ethertype: ethertype,
+		build: func(*FrameBuilder) ([]byte, error) {
+			return data, nil
+		},
+	})
+}
+
+// ARPRequest sets an ARP request from sender for target
+func (b *FrameBuilder) ARPRequest(sender, target netip.Addr) *FrameBuilder {
+	return b.arp(OpRequest, sender, make(net.HardwareAddr, hwAddrLen), target)
+}
+
+// ARPReply sets an ARP reply from sender to the host with targetMAC and
+// target
+func (b *FrameBuilder) ARPReply(sender netip.Addr, targetMAC net.HardwareAddr, target netip.Addr) *FrameBuilder {
+	return b.arp(OpReply, sender, targetMAC, target)
+}
+
+func (b *FrameBuilder) arp(op uint16, sender netip.Addr, targetMAC net.HardwareAddr,
+	target netip.Addr) *FrameBuilder {
+	return b.setPayload(&payload{
+		name:      "ARP",
+		ethertype: EthernetTypeARP,
+		build: func(b *FrameBuilder) ([]byte, error) {
+			if !sender.Is4() || !target.Is4() {
+				return nil, fmt.Errorf("%w: ARP needs IPv4 addresses, got %s and %s", ErrBuildFrame, sender, target)
+			}
+
+			if len(targetMAC) != hwAddrLen {
+				return nil, fmt.Errorf("%w: ARP target MAC %s", ErrBuildFrame, targetMAC)
+			}
+
+			pkt := make([]byte, 8, 28)
+			binary.BigEndian.PutUint16(pkt[0:2], uint16(HardwareTypeEthernet))
+			binary.BigEndian.PutUint16(pkt[2:4], uint16(ProtocolTypeIPv4))
+			pkt[4] = hwAddrLen
+			pkt[5] = 4
+			binary.BigEndian.PutUint16(pkt[6:8], op)
+			pkt = append(pkt, b.src...)
+			pkt = append(pkt, sender.AsSlice()...)
+			pkt = append(pkt, targetMAC...)
+
+			return append(pkt, target.AsSlice()...), nil
+		},
+	})
+}
+
+// UDP sets a UDP datagram in an IPv4 or IPv6 packet, depending on the
+// addresses, with the lengths and checksums filled in
+func (b *FrameBuilder) UDP(src, dst netip.AddrPort, data []byte) *FrameBuilder {
+	ethertype := EthernetTypeIPv4
+	if src.Addr().Is6() {
+		ethertype = EthernetTypeIPv6
+	}
+
+	return b.setPayload(&payload{
+		name:      "UDP",
+		ethertype: ethertype,
+		build: func(*FrameBuilder) ([]byte, error) {
+			return udpPacket(src, dst, data)
+		},
+	})
+}
+
+// DHCPDiscover sets a DHCPv4 discover broadcast by the source MAC
+func (b *FrameBuilder) DHCPDiscover(xid uint32) *FrameBuilder {
+	return b.setPayload(&payload{
+		name:      "DHCP",
+		ethertype: EthernetTypeIPv4,
+		build: func(b *FrameBuilder) ([]byte, error) {
+			msg := make([]byte, 236, 244)
+			msg[0] = 1 // BOOTREQUEST
+			msg[1] = byte(HardwareTypeEthernet)
+			msg[2] = hwAddrLen
+			binary.BigEndian.PutUint32(msg[4:8], xid)
+			// the client has no address yet, answers must be broadcast
+			binary.BigEndian.PutUint16(msg[10:12], 0x8000)
+			copy(msg[28:44], b.src)
+			// magic cookie, DHCP message type discover, end
+			msg = append(msg, 99, 130, 83, 99, 53, 1, 1, 0xff)
+
+			return udpPacket(netip.AddrPortFrom(netip.IPv4Unspecified(), dhcpClientPort),
+				netip.AddrPortFrom(netip.AddrFrom4([4]byte{255, 255, 255, 255}), dhcpServerPort), msg)
+		},
+	})
+}
+
+// WakeOnLAN sets a magic packet waking the host with the MAC target, six
+// bytes of 0xff followed by sixteen copies of target. The destination
+// defaults to the broadcast address, the switches may have forgotten the
+// port of a host which is asleep.
+func (b *FrameBuilder) WakeOnLAN(target net.HardwareAddr) *FrameBuilder {
+	return b.setPayload(&payload{
+		name:      "Wake-on-LAN",
+		ethertype: EthernetTypeWakeOnLAN,
+		build: func(*FrameBuilder) ([]byte, error) {
+			if len(target) != hwAddrLen {
+				return nil, fmt.Errorf("%w: Wake-on-LAN target MAC %q", ErrBuildFrame, target)
+			}
+
+			pkt := make([]byte, 0, hwAddrLen*(1+wakeOnLANRepeat))
+			pkt = append(pkt, Broadcast...)
+
+			for range wakeOnLANRepeat {
+				pkt = append(pkt, target...)
+			}
+
+			return pkt, nil
+		},
+	})
+}
+
+// NeighborSolicitation sets an NDP neighbor solicitation for target, sent
+// to its solicited-node multicast address. An unspecified src makes it a
+// duplicate address detection probe, without the source link-layer address.
+func (b *FrameBuilder) NeighborSolicitation(src, target netip.Addr) *FrameBuilder {
+	return b.setPayload(&payload{
+		name:      "NDP",
+		ethertype: EthernetTypeIPv6,
+		build: func(b *FrameBuilder) ([]byte, error) {
+			if !src.Is6() || !target.Is6() {
+				return nil, fmt.Errorf("%w: NDP needs IPv6 addresses, got %s and %s", ErrBuildFrame, src, target)
+			}
+
+			t := target.As16()
+			dst := netip.AddrFrom16([16]byte{0xff, 0x02, 11: 0x01, 12: 0xff, 13: t[13], 14: t[14], 15: t[15]})
+
+			msg := make([]byte, 8, 32)
+			msg[0] = 135
+			msg = append(msg, t[:]...)
+
+			if !src.IsUnspecified() {
+				msg = append(append(msg, 1, 1), b.src...)
+			}
+
+			return icmpv6Packet(src, dst, msg)
+		},
+	})
+}
+
+// NeighborAdvertisement sets an NDP neighbor advertisement of src, with the
+// source MAC as target link-layer address
+func (b *FrameBuilder) NeighborAdvertisement(src, dst netip.Addr, flags NAFlags) *FrameBuilder {
+	return b.setPayload(&payload{
+		name:      "NDP",
+		ethertype: EthernetTypeIPv6,
+		build: func(b *FrameBuilder) ([]byte, error) {
+			if !src.Is6() || !dst.Is6() {
+				return nil, fmt.Errorf("%w: NDP needs IPv6 addresses, got %s and %s", ErrBuildFrame, src, dst)
+			}
+
+			msg := make([]byte, 8, 32)
+			msg[0] = 136
+			msg[4] = byte(flags)
+			msg = append(msg, src.AsSlice()...)
+			msg = append(append(msg, 2, 1), b.src...)
+
+			return icmpv6Packet(src, dst, msg)
+		},
+	})
+}
+
+func (b *FrameBuilder) setPayload(p *payload) *FrameBuilder {
+	if b.payload != nil {
+		b.errs = append(b.errs, fmt.Errorf("%w: %s payload after %s payload", ErrBuildFrame, p.name, b.payload.name))
+	}
+
+	b.payload = p
+
+	return b
+}
+
+// Build returns the bytes of the frame, or an error wrapping ErrBuildFrame
+// if the frame is inconsistent: a payload under another ethertype,
+// addresses of the wrong family or out of range VLAN fields
+func (b *FrameBuilder) Build() ([]byte, error) {
+	if err := errors.Join(b.errs...); err != nil {
+		return nil, err
+	}
+
+	if len(b.src) != hwAddrLen {
+		return nil, fmt.Errorf("%w: source MAC %q", ErrBuildFrame, b.src)
+	}
+
+	ethertype, data, err := b.content()
+	if err != nil {
+		return nil, err
+	}
+
+	dst := b.dst
+	if dst == nil {
+		dst = defaultDst(ethertype, data)
+	}
+
+	if len(dst) != hwAddrLen {
+		return nil, fmt.Errorf("%w: destination MAC %q", ErrBuildFrame, dst)
+	}
+
+	frame := make([]byte, 0, minFrameLen)
+	frame = append(frame, dst...)
+	frame = append(frame, b.src...)
+
+	for _, tag := range b.tags {
+		if tag.id > 0x0fff || tag.priority > 7 {
+			return nil, fmt.Errorf("%w: VLAN %d with priority %d", ErrBuildFrame, tag.id, tag.priority)
+		}
+
+		tci := uint16(tag.priority)<<13 | tag.id
+		if tag.dropEligible {
+			tci |= 0x1000
+		}
+
+		frame = binary.BigEndian.AppendUint16(frame, uint16(tag.tpid))
+		frame = binary.BigEndian.AppendUint16(frame, tci)
+	}
+
+	frame = binary.BigEndian.AppendUint16(frame, uint16(ethertype))
+	frame = append(frame, data...)
+
+	if b.pad && len(frame) < minFrameLen {
+		frame = append(frame, make([]byte, minFrameLen-len(frame))...)
+	}
+
+	return frame, nil
+}
+
+// BuildFrame returns the frame built by Build, decoded
+func (b *FrameBuilder) BuildFrame() (*EthernetFrame, error) {
+	buf, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &EthernetFrame{}
+
+	if err := e.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// content returns the ethertype and payload of the frame
+func (b *FrameBuilder) content() (EthernetType, []byte, error) {
+	if b.payload == nil {
+		if b.ethertype == nil {
+			return 0, nil, fmt.Errorf("%w: no payload", ErrBuildFrame)
+		}
+
+		return *b.ethertype, nil, nil
+	}
+
+	if b.ethertype != nil && *b.ethertype != b.payload.ethertype {
+		return 0, nil, fmt.Errorf("%w: %s payload under ethertype %s", ErrBuildFrame, b.payload.name, *b.ethertype)
+	}
+
+	data, err := b.payload.build(b)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return b.payload.ethertype, data, nil
+}
+
+// defaultDst returns the multicast MAC of an IPv6 multicast destination and
+// the broadcast address otherwise
+func defaultDst(ethertype EthernetType, data []byte) net.HardwareAddr {
+	if ethertype == EthernetTypeIPv6 && len(data) >= 40 && data[24] == 0xff {
+		return net.HardwareAddr{0x33, 0x33, data[36], data[37], data[38], data[39]}
+	}
+
+	return Broadcast
+}
+
+func udpPacket(src, dst netip.AddrPort, data []byte) ([]byte, error) {
+	if src.Addr().Is4() != dst.Addr().Is4() || !src.Addr().IsValid() || !dst.Addr().IsValid() {
+		return nil, fmt.Errorf("%w: UDP from %s to %s", ErrBuildFrame, src, dst)
+	}
+
+	datagram := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint16(datagram[0:2], src.Port())
+	binary.BigEndian.PutUint16(datagram[2:4], dst.Port())
+	binary.BigEndian.PutUint16(datagram[4:6], uint16(8+len(data))) //nolint:gosec // frames are far smaller
+	datagram = append(datagram, data...)
+
+	var pkt []byte
+
+	if src.Addr().Is6() {
+		pkt = ipv6Packet(src.Addr(), dst.Addr(), checksum.ProtocolUDP, 64, datagram)
+	} else {
+		pkt = ipv4Packet(src.Addr(), dst.Addr(), checksum.ProtocolUDP, datagram)
+	}
+
+	return pkt, setChecksum(pkt, 6)
+}
+
+func icmpv6Packet(src, dst netip.Addr, msg []byte) ([]byte, error) {
+	// NDP messages must not have been forwarded
+	pkt := ipv6Packet(src, dst, checksum.ProtocolICMPv6, 255, msg)
+
+	return pkt, setChecksum(pkt, 2)
+}
+
+// setChecksum fills in the checksum at offset off of the segment carried by
+// the IP packet pkt
+func setChecksum(pkt []byte, off int) error {
+	hdrLen := ipv6HeaderLen
+	if pkt[0]>>4 == 4 {
+		hdrLen = ipv4HeaderLen
+	}
+
+	sum, err := checksum.Compute(pkt[:hdrLen], pkt[hdrLen:])
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBuildFrame, err)
+	}
+
+	binary.BigEndian.PutUint16(pkt[hdrLen+off:], sum)
+
+	return nil
+}
+
+func ipv4Packet(src, dst netip.Addr, protocol uint8, data []byte) []byte {
+	pkt := make([]byte, ipv4HeaderLen, ipv4HeaderLen+len(data))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(ipv4HeaderLen+len(data))) //nolint:gosec // frames are far smaller
+	pkt[8] = 64
+	pkt[9] = protocol
+	copy(pkt[12:16], src.AsSlice())
+	copy(pkt[16:20], dst.AsSlice())
+	binary.BigEndian.PutUint16(pkt[10:12], checksum.Sum(pkt))
+
+	return append(pkt, data...)
+}
+
+func ipv6Packet(src, dst netip.Addr, nextHeader, hopLimit uint8, data []byte) []byte {
+	pkt := make([]byte, ipv6HeaderLen, ipv6HeaderLen+len(data))
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:6], uint16(len(data))) //nolint:gosec // frames are far smaller
+	pkt[6] = nextHeader
+	pkt[7] = hopLimit
+	copy(pkt[8:24], src.AsSlice())
+	copy(pkt[24:40], dst.AsSlice())
+
+	return append(pkt, data...)
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/checksum"
+)
+
+// mustBuild returns the bytes of the frame, failing the test on error
+func mustBuild(tb testing.TB, b *FrameBuilder) []byte {
+	tb.Helper()
+
+	buf, err := b.Build()
+	require.NoError(tb, err)
+
+	return buf
+}
+
+func TestFrameBuilderARPRequest(t *testing.T) {
+	t.Parallel()
+
+	// the builder must produce the same bytes as the captured request
+	buf := mustBuild(t, NewFrame().
+		Src(net.HardwareAddr{0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25}).
+		ARPRequest(netip.MustParseAddr("192.168.10.26"), netip.MustParseAddr("192.168.10.25")))
+
+	assert.Equal(t, arpFrame, buf)
+}
+
+func TestFrameBuilderBuild(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	dst := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+	ip4 := netip.MustParseAddr("10.0.0.1")
+	ip6 := netip.MustParseAddr("fe80::1")
+
+	testcases := map[string]struct {
+		builder *FrameBuilder
+		check   func(t *testing.T, buf []byte)
+	}{
+		"ARP reply": {
+			builder: NewFrame().Src(src).Dst(dst).ARPReply(ip4, dst, netip.MustParseAddr("10.0.0.2")),
+			check: func(t *testing.T, buf []byte) {
+				frame := &EthernetFrame{}
+				require.NoError(t, frame.UnmarshalBinary(buf))
+				assert.Equal(t, dst, frame.DstMAC)
+
+				pkt, err := frame.ExtractARPPacket()
+				require.NoError(t, err)
+				assert.Equal(t, OpReply, pkt.OpCode)
+				assert.Equal(t, src, pkt.SendHwAddr)
+				assert.Equal(t, dst, pkt.TgtHwAddr)
+				assert.Equal(t, ip4, pkt.SenderAddr())
+				assert.NoError(t, pkt.Validate(ValidationStrict, frame))
+			},
+		},
+		"VLAN with priority and drop eligible": {
+			builder: NewFrame().Src(src).VLAN(100, WithPriority(3), WithDropEligible()).ARPRequest(ip4, ip4),
+			check: func(t *testing.T, buf []byte) {
+				assert.Equal(t, []byte{0x81, 0x00, 0x70, 0x64, 0x08, 0x06}, buf[12:18])
+
+				frame := &EthernetFrame{}
+				require.NoError(t, frame.UnmarshalBinary(buf))
+
+				vlan, err := frame.ExtractVLAN()
+				require.NoError(t, err)
+				assert.Equal(t, &VLAN{Priority: 3, DropEligible: true, ID: 100, EthernetType: EthernetTypeARP}, vlan)
+			},
+		},
+		"QinQ": {
+			builder: NewFrame().Src(src).VLAN(100, WithTPID(0x88a8)).VLAN(2).ARPRequest(ip4, ip4),
+			check: func(t *testing.T, buf []byte) {
+				assert.Equal(t, []byte{0x88, 0xa8, 0x00, 0x64, 0x81, 0x00, 0x00, 0x02, 0x08, 0x06}, buf[12:22])
+			},
+		},
+		"padded": {
+			builder: NewFrame().Src(src).Padded().ARPRequest(ip4, ip4),
+			check: func(t *testing.T, buf []byte) {
+				assert.Len(t, buf, minFrameLen)
+			},
+		},
+		"raw payload": {
+			builder: NewFrame().Src(src).Dst(dst).EtherType(EthernetTypeCFM).Payload(EthernetTypeCFM, []byte{0xa0, 0x01}),
+			check: func(t *testing.T, buf []byte) {
+				assert.Equal(t, concat(dst, src, []byte{0x89, 0x02, 0xa0, 0x01}), buf)
+			},
+		},
+		"UDP over IPv4": {
+			builder: NewFrame().Src(src).Dst(dst).UDP(
+				netip.AddrPortFrom(ip4, 1234), netip.MustParseAddrPort("10.0.0.2:53"), []byte("query")),
+			check: func(t *testing.T, buf []byte) {
+				ip := buf[14:]
+				assert.Equal(t, uint16(EthernetTypeIPv4), binary.BigEndian.Uint16(buf[12:14]))
+				assert.Equal(t, uint16(33), binary.BigEndian.Uint16(ip[2:4]))
+				assert.Equal(t, uint16(0), checksum.Sum(ip[:20]))
+
+				udp := ip[20:]
+				assert.Equal(t, uint16(13), binary.BigEndian.Uint16(udp[4:6]))
+				assert.NoError(t, checksum.Verify(ip[:20], udp))
+			},
+		},
+		"UDP over IPv6": {
+			builder: NewFrame().Src(src).UDP(
+				netip.AddrPortFrom(ip6, 546), netip.MustParseAddrPort("[ff02::1:2]:547"), []byte{0x01}),
+			check: func(t *testing.T, buf []byte) {
+				assert.Equal(t, net.HardwareAddr{0x33, 0x33, 0x00, 0x01, 0x00, 0x02}, net.HardwareAddr(buf[0:6]))
+
+				ip := buf[14:]
+				assert.Equal(t, uint16(9), binary.BigEndian.Uint16(ip[4:6]))
+				assert.Equal(t, uint8(64), ip[7])
+				assert.NoError(t, checksum.Verify(ip[:40], ip[40:]))
+			},
+		},
+		"DHCP discover": {
+			builder: NewFrame().Src(src).DHCPDiscover(0xdeadbeef),
+			check: func(t *testing.T, buf []byte) {
+				assert.Equal(t, Broadcast, net.HardwareAddr(buf[0:6]))
+
+				ip := buf[14:]
+				assert.Equal(t, []byte{255, 255, 255, 255}, ip[16:20])
+
+				udp := ip[20:]
+				assert.Equal(t, uint16(dhcpClientPort), binary.BigEndian.Uint16(udp[0:2]))
+				assert.Equal(t, uint16(dhcpServerPort), binary.BigEndian.Uint16(udp[2:4]))
+
+				msg := udp[8:]
+				assert.Equal(t, uint32(0xdeadbeef), binary.BigEndian.Uint32(msg[4:8]))
+				assert.Equal(t, []byte(src), msg[28:34])
+				assert.Equal(t, []byte{99, 130, 83, 99, 53, 1, 1, 0xff}, msg[236:])
+			},
+		},
+		"Wake-on-LAN": {
+			builder: NewFrame().Src(src).WakeOnLAN(net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}),
+			check: func(t *testing.T, buf []byte) {
+				assert.Equal(t, Broadcast, net.HardwareAddr(buf[0:6]))
+				assert.Equal(t, []byte{0x08, 0x42}, buf[12:14])
+				require.Len(t, buf, 14+102)
+				assert.Equal(t, []byte(Broadcast), buf[14:20])
+
+				for i := 20; i < len(buf); i += 6 {
+					assert.Equal(t, []byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}, buf[i:i+6])
+				}
+			},
+		},
+		"neighbor solicitation": {
+			builder: NewFrame().Src(src).NeighborSolicitation(ip6, netip.MustParseAddr("2001:db8::aa:bbcc")),
+			check: func(t *testing.T, buf []byte) {
+				assert.Equal(t, net.HardwareAddr{0x33, 0x33, 0xff, 0xaa, 0xbb, 0xcc}, net.HardwareAddr(buf[0:6]))
+
+				ip := buf[14:]
+				assert.Equal(t, uint8(255), ip[7])
+				assert.Equal(t, netip.MustParseAddr("ff02::1:ffaa:bbcc").AsSlice(), ip[24:40])
+
+				msg := ip[40:]
+				assert.Equal(t, uint8(135), msg[0])
+				assert.Equal(t, concat([]byte{1, 1}, src), msg[24:])
+				assert.NoError(t, checksum.Verify(ip[:40], msg))
+			},
+		},
+		"duplicate address detection": {
+			builder: NewFrame().Src(src).NeighborSolicitation(netip.IPv6Unspecified(), ip6),
+			check: func(t *testing.T, buf []byte) {
+				assert.Len(t, buf[14+40:], 24)
+			},
+		},
+		"neighbor advertisement": {
+			builder: NewFrame().Src(src).Dst(dst).NeighborAdvertisement(ip6, netip.MustParseAddr("fe80::2"),
+				NAFlagSolicited|NAFlagOverride),
+			check: func(t *testing.T, buf []byte) {
+				msg := buf[14+40:]
+				assert.Equal(t, uint8(136), msg[0])
+				assert.Equal(t, uint8(0x60), msg[4])
+				assert.Equal(t, ip6.AsSlice(), msg[8:24])
+				assert.Equal(t, concat([]byte{2, 1}, src), msg[24:])
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tc.check(t, mustBuild(t, tc.builder))
+		})
+	}
+}
+
+func TestFrameBuilderErrors(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	ip4 := netip.MustParseAddr("10.0.0.1")
+	ip6 := netip.MustParseAddr("fe80::1")
+
+	testcases := map[string]*FrameBuilder{
+		"no payload":              NewFrame().Src(src),
+		"no source":               NewFrame().ARPRequest(ip4, ip4),
+		"bad destination":         NewFrame().Src(src).Dst(net.HardwareAddr{0x01}).ARPRequest(ip4, ip4),
+		"two payloads":            NewFrame().Src(src).ARPRequest(ip4, ip4).DHCPDiscover(1),
+		"VLAN ID out of range":    NewFrame().Src(src).VLAN(4096).ARPRequest(ip4, ip4),
+		"priority out of range":   NewFrame().Src(src).VLAN(1, WithPriority(8)).ARPRequest(ip4, ip4),
+		"conflicting ethertype":   NewFrame().Src(src).EtherType(EthernetTypeIPv6).ARPRequest(ip4, ip4),
+		"ARP with IPv6":           NewFrame().Src(src).ARPRequest(ip6, ip4),
+		"NDP with IPv4":           NewFrame().Src(src).NeighborSolicitation(ip4, ip6),
+		"UDP with mixed":          NewFrame().Src(src).UDP(netip.AddrPortFrom(ip4, 1), netip.AddrPortFrom(ip6, 1), nil),
+		"ARP reply to bad MAC":    NewFrame().Src(src).ARPReply(ip4, net.HardwareAddr{0x01}, ip4),
+		"advertisement for IPv4":  NewFrame().Src(src).NeighborAdvertisement(ip6, ip4, 0),
+		"Wake-on-LAN without MAC": NewFrame().Src(src).WakeOnLAN(nil),
+	}
+
+	for name, builder := range testcases {
+		builder := builder
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := builder.Build()
+			assert.ErrorIs(t, err, ErrBuildFrame)
+
+			_, err = builder.BuildFrame()
+			assert.ErrorIs(t, err, ErrBuildFrame)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	minCFMLen = 4
+	// ccmMEPIDOffset is the offset of the MEP ID within a CCM
+	ccmMEPIDOffset = 8
+)
+
+var (
+	// ErrNotCFM is an error returned when calling EthernetFrame.ExtractCFM
+	// if the frame is not of type EthernetTypeCFM
+	ErrNotCFM = errors.New("ethernet frame not of type CFM")
+	// ErrMalformedCFM is an error returned when parsing a malformed CFM PDU
+	ErrMalformedCFM = errors.New("malformed CFM packet")
+
+	errTruncatedCFM error = truncated("CFM", "header", ErrMalformedCFM)
+	errTruncatedCCM error = truncated("CFM", "MEPID", ErrMalformedCFM)
+)
+
+// CFMOpCode is the type of a CFM PDU, IEEE 802.1ag defines the first
+// five, ITU-T Y.1731 the performance monitoring ones
+type CFMOpCode uint8
+
+const (
+	// CFMOpCodeCCM is a continuity check message
+	CFMOpCodeCCM CFMOpCode = 1
+	// CFMOpCodeLBR is a loopback reply
+	CFMOpCodeLBR CFMOpCode = 2
+	// CFMOpCodeLBM is a loopback message
+	CFMOpCodeLBM CFMOpCode = 3
+	// CFMOpCodeLTR is a linktrace reply
+	CFMOpCodeLTR CFMOpCode = 4
+	// CFMOpCodeLTM is a linktrace message
+	CFMOpCodeLTM CFMOpCode = 5
+	// CFMOpCodeAIS is an alarm indication signal
+	CFMOpCodeAIS CFMOpCode = 33
+	// CFMOpCodeLCK is a locked signal
+	CFMOpCodeLCK CFMOpCode = 35
+	// CFMOpCodeLMR is a loss measurement reply
+	CFMOpCodeLMR CFMOpCode = 42
+	// CFMOpCodeLMM is a loss measurement message
+	CFMOpCodeLMM CFMOpCode = 43
+	// CFMOpCodeDMR is a delay measurement reply
+	CFMOpCodeDMR CFMOpCode = 46
+	// CFMOpCodeDMM is a delay measurement message
+	CFMOpCodeDMM CFMOpCode = 47
+)
+
+var cfmOpCodeNames = map[CFMOpCode]string{
+	CFMOpCodeCCM: "CCM",
+	CFMOpCodeLBR: "LBR",
+	CFMOpCodeLBM: "LBM",
+	CFMOpCodeLTR: "LTR",
+	CFMOpCodeLTM: "LTM",
+	CFMOpCodeAIS: "AIS",
+	CFMOpCodeLCK: "LCK",
+	CFMOpCodeLMR: "LMR",
+	CFMOpCodeLMM: "LMM",
+	CFMOpCodeDMR: "DMR",
+	CFMOpCodeDMM: "DMM",
+}
+
+// String returns the abbreviation of the opcode
+func (o CFMOpCode) String() string {
+	if name, ok := cfmOpCodeNames[o]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("CFMOpCode(%d)", uint8(o))
+}
+
+// CFMPacket is the common header of a connectivity fault management PDU
+type CFMPacket struct {
+	// MEPID identifies the maintenance end point sending a CCM, it is 0
+	// for the other opcodes
+	MEPID uint16
+	// Level is the maintenance domain level, from 0 to 7, higher levels
+	// span larger parts of the network
+	Level   uint8
+	Version uint8
+	OpCode  CFMOpCode
+	Flags   uint8
+}
+
+// UnmarshalBinary parses the payload of a CFM frame into a CFMPacket
+func (c *CFMPacket) UnmarshalBinary(buf []byte) error {
+	if len(buf) < minCFMLen {
+		return errTruncatedCFM
+	}
+
+	c.Level = buf[0] >> 5
+	c.Version = buf[0] & 0x1f
+	c.OpCode = CFMOpCode(buf[1])
+	c.Flags = buf[2]
+	c.MEPID = 0
+
+	if c.OpCode == CFMOpCodeCCM {
+		if len(buf) < ccmMEPIDOffset+2 {
+			return errTruncatedCCM
+		}
+
+		c.MEPID = binary.BigEndian.Uint16(buf[ccmMEPIDOffset:]) & 0x1fff
+	}
+
+	return nil
+}
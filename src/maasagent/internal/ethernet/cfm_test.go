@@ -0,0 +1,154 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCFMUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []byte
+		out *CFMPacket
+		err error
+	}{
+		"CCM": {
+			in: []byte{
+				0xa0, 0x01, 0x04, 0x46, 0x00, 0x00, 0x00, 0x2a, 0x00, 0x65, 0x04, 0x03, 'm', 'd', '1',
+			},
+			out: &CFMPacket{
+				Level:  5,
+				OpCode: CFMOpCodeCCM,
+				Flags:  0x04,
+				MEPID:  101,
+			},
+		},
+		"LBM": {
+			in: []byte{0x23, 0x03, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x00},
+			out: &CFMPacket{
+				Level:   1,
+				Version: 3,
+				OpCode:  CFMOpCodeLBM,
+			},
+		},
+		"truncated": {
+			in:  []byte{0xa0, 0x01, 0x04},
+			err: ErrMalformedCFM,
+		},
+		"truncated CCM": {
+			in:  []byte{0xa0, 0x01, 0x04, 0x46, 0x00, 0x00, 0x00, 0x2a, 0x00},
+			err: ErrMalformedCFM,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pkt := &CFMPacket{}
+
+			err := pkt.UnmarshalBinary(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+
+			if err == nil {
+				assert.Equal(t, tc.out, pkt)
+			}
+		})
+	}
+}
+
+func TestCFMOpCodeString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "LTM", CFMOpCodeLTM.String())
+	assert.Equal(t, "CFMOpCode(64)", CFMOpCode(64).String())
+}
+
+func TestEthernetFrameExtractCFM(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x01}
+	ccmDst := net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x35}
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	testcases := map[string]struct {
+		in  []byte
+		out *CFMPacket
+		err error
+	}{
+		"VLAN tagged CCM": {
+			in: mustBuild(t, NewFrame().Src(src).Dst(ccmDst).VLAN(100).
+				Payload(EthernetTypeCFM, []byte{0xa0, 0x01, 0x04, 0x46, 0x00, 0x00, 0x00, 0x2a, 0x00, 0x65})),
+			out: &CFMPacket{
+				Level:  5,
+				OpCode: CFMOpCodeCCM,
+				Flags:  0x04,
+				MEPID:  101,
+			},
+		},
+		"QinQ tagged CCM": {
+			in: mustBuild(t, NewFrame().Src(src).Dst(ccmDst).
+				Tags(Tag{TPID: EthernetTypeQinQ, VID: 100}, Tag{VID: 10}).
+				Payload(EthernetTypeCFM, []byte{0xa0, 0x01, 0x04, 0x46, 0x00, 0x00, 0x00, 0x2a, 0x00, 0x65})),
+			out: &CFMPacket{
+				Level:  5,
+				OpCode: CFMOpCodeCCM,
+				Flags:  0x04,
+				MEPID:  101,
+			},
+		},
+		"VLAN tagged ARP": {
+			in:  mustBuild(t, NewFrame().Src(src).VLAN(2).ARPRequest(ip, ip)),
+			err: ErrNotCFM,
+		},
+		"truncated VLAN tag": {
+			in: []byte{
+				0x01, 0x80, 0xc2, 0x00, 0x00, 0x35, 0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x01, 0x81, 0x00, 0x00,
+			},
+			err: ErrMalformedVLAN,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			eth := &EthernetFrame{}
+
+			err := eth.UnmarshalBinary(tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkt, err := eth.ExtractCFM()
+			assert.ErrorIs(t, err, tc.err)
+
+			if err == nil {
+				assert.Equal(t, tc.out, pkt)
+			}
+		})
+	}
+}
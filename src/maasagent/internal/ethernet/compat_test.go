@@ -0,0 +1,311 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet_test
+
+// The tests of this file hold the API the package had before its decoders
+// were reorganized, called the way the rest of the agent calls it, from
+// outside the package. A change breaking one of them breaks a caller: the
+// behaviours changed since are only reached through the options.
+
+import (
+	"encoding"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var (
+	legacySrc = net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	legacyDst = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	// legacyARP is a request of 10.0.0.1 for 10.0.0.2
+	legacyARP = []byte{
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+		0x52, 0x54, 0x00, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x02,
+	}
+)
+
+// legacyFrame returns the bytes of a frame of ethType carrying payload
+func legacyFrame(ethType uint16, payload ...[]byte) []byte {
+	b := append(append([]byte{}, legacyDst...), legacySrc...)
+	b = append(b, byte(ethType>>8), byte(ethType))
+
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+
+	return b
+}
+
+func TestLegacyConstants(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, map[ethernet.EthernetType]uint16{
+		ethernet.EthernetTypeLLC:        0,
+		ethernet.EthernetTypeIPv4:       0x0800,
+		ethernet.EthernetTypeARP:        0x0806,
+		ethernet.EthernetTypeIPv6:       0x86dd,
+		ethernet.EthernetTypeVLAN:       0x8100,
+		ethernet.NonStdLenEthernetTypes: 0x0600,
+	}, map[ethernet.EthernetType]uint16{
+		ethernet.EthernetTypeLLC:        uint16(ethernet.EthernetTypeLLC),
+		ethernet.EthernetTypeIPv4:       uint16(ethernet.EthernetTypeIPv4),
+		ethernet.EthernetTypeARP:        uint16(ethernet.EthernetTypeARP),
+		ethernet.EthernetTypeIPv6:       uint16(ethernet.EthernetTypeIPv6),
+		ethernet.EthernetTypeVLAN:       uint16(ethernet.EthernetTypeVLAN),
+		ethernet.NonStdLenEthernetTypes: uint16(ethernet.NonStdLenEthernetTypes),
+	})
+
+	assert.Equal(t, []ethernet.HardwareType{0, 1, 2, 3, 4, 5, 18, 19, 28, 29, 30, 31, 32}, []ethernet.HardwareType{
+		ethernet.HardwareTypeReserved, ethernet.HardwareTypeEthernet, ethernet.HardwareTypeExpEth,
+		ethernet.HardwareTypeAX25, ethernet.HardwareTypeChaos, ethernet.HardwareTypeIEEE802,
+		ethernet.HardwareTypeFiberChannel, ethernet.HardwareTypeSerialLine, ethernet.HardwareTypeHIPARP,
+		ethernet.HardwareTypeIPARPISO7163, ethernet.HardwareTypeARPSec, ethernet.HardwareTypeIPSec,
+		ethernet.HardwareTypeInfiniBand,
+	})
+	assert.Equal(t, []ethernet.ProtocolType{0x0800, 0x86dd, 0x0806},
+		[]ethernet.ProtocolType{ethernet.ProtocolTypeIPv4, ethernet.ProtocolTypeIPv6, ethernet.ProtocolTypeARP})
+	assert.Equal(t, []uint16{0, 1, 2}, []uint16{ethernet.OpReserved, ethernet.OpRequest, ethernet.OpReply})
+
+	// the names the stringer gave the types are kept
+	assert.Equal(t, "VLAN", ethernet.EthernetTypeVLAN.String())
+	assert.Equal(t, "Ethernet", ethernet.HardwareTypeEthernet.String())
+	assert.Equal(t, "IPv4", ethernet.ProtocolTypeIPv4.String())
+}
+
+func TestLegacyEthernetFrameUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in      []byte
+		payload []byte
+		err     error
+		ethType ethernet.EthernetType
+		length  uint16
+	}{
+		"ARP": {
+			in:      legacyFrame(0x0806, legacyARP),
+			payload: legacyARP,
+			ethType: ethernet.EthernetTypeARP,
+		},
+		"empty": {
+			in:  []byte{},
+			err: io.ErrUnexpectedEOF,
+		},
+		"short header": {
+			in:  legacyFrame(0x0806)[:13],
+			err: ethernet.ErrMalformedFrame,
+		},
+		"LLC padding truncated to its length": {
+			in:      legacyFrame(4, []byte{0xaa, 0xaa, 0x03, 0x00}, make([]byte, 42)),
+			payload: []byte{0xaa, 0xaa, 0x03, 0x00},
+			ethType: ethernet.EthernetTypeLLC,
+			length:  4,
+		},
+		"LLC exact length": {
+			in:      legacyFrame(2, []byte{0xaa, 0xaa}),
+			payload: []byte{0xaa, 0xaa},
+			ethType: ethernet.EthernetTypeLLC,
+			length:  2,
+		},
+		"LLC length past the payload": {
+			in:  legacyFrame(8, []byte{0xaa, 0xaa}),
+			err: ethernet.ErrMalformedFrame,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			eth := &ethernet.EthernetFrame{}
+
+			err := eth.UnmarshalBinary(tc.in)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+
+				// the empty buffer is an EOF, the others malformed
+				assert.Equal(t, tc.err == io.ErrUnexpectedEOF, !errors.Is(err, ethernet.ErrMalformedFrame))
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, legacyDst, eth.DstMAC)
+			assert.Equal(t, legacySrc, eth.SrcMAC)
+			assert.Equal(t, tc.ethType, eth.EthernetType)
+			assert.Equal(t, tc.length, eth.Len)
+			assert.Equal(t, tc.payload, eth.Payload)
+		})
+	}
+}
+
+func TestLegacyEthernetFrameMarshal(t *testing.T) {
+	t.Parallel()
+
+	var m encoding.BinaryMarshaler = &ethernet.EthernetFrame{
+		DstMAC: legacyDst, SrcMAC: legacySrc, EthernetType: ethernet.EthernetTypeARP,
+	}
+
+	b, err := m.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, legacyFrame(0x0806), b)
+
+	// the header only, the payload isn't written
+	b, err = (&ethernet.EthernetFrame{DstMAC: legacyDst, SrcMAC: legacySrc, Len: 4, Payload: []byte{1}}).
+		MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, legacyFrame(4), b)
+
+	_, err = (&ethernet.EthernetFrame{EthernetType: ethernet.EthernetTypeLLC}).MarshalBinary()
+	assert.ErrorIs(t, err, ethernet.ErrMalformedFrame)
+}
+
+func TestLegacyExtractVLAN(t *testing.T) {
+	t.Parallel()
+
+	eth := &ethernet.EthernetFrame{}
+
+	// the guard of the capture skips the frames tagged with a VLAN
+	require.NoError(t, eth.UnmarshalBinary(legacyFrame(0x8100, []byte{0xa0, 0x64, 0x08, 0x06}, legacyARP)))
+
+	vlan, err := eth.ExtractVLAN()
+	require.NoError(t, err)
+	assert.Equal(t, &ethernet.VLAN{Priority: 5, ID: 100, EthernetType: ethernet.EthernetTypeARP}, vlan)
+
+	require.NoError(t, eth.UnmarshalBinary(legacyFrame(0x0806, legacyARP)))
+
+	_, err = eth.ExtractVLAN()
+	assert.ErrorIs(t, err, ethernet.ErrNotVLAN)
+
+	require.NoError(t, eth.UnmarshalBinary(legacyFrame(0x8100, []byte{0x00, 0x64})))
+
+	_, err = eth.ExtractVLAN()
+	assert.ErrorIs(t, err, ethernet.ErrMalformedVLAN)
+
+	var u encoding.BinaryUnmarshaler = &ethernet.VLAN{}
+	assert.ErrorIs(t, u.UnmarshalBinary([]byte{0x00}), ethernet.ErrMalformedVLAN)
+	require.NoError(t, u.UnmarshalBinary([]byte{0x10, 0x01, 0x08, 0x00}))
+	assert.Equal(t, &ethernet.VLAN{DropEligible: true, ID: 1, EthernetType: ethernet.EthernetTypeIPv4}, u)
+}
+
+func TestLegacyExtractARPPacket(t *testing.T) {
+	t.Parallel()
+
+	expected := &ethernet.ARPPacket{
+		HardwareType:    ethernet.HardwareTypeEthernet,
+		ProtocolType:    ethernet.ProtocolTypeIPv4,
+		HardwareAddrLen: 6,
+		ProtocolAddrLen: 4,
+		OpCode:          ethernet.OpRequest,
+		SendHwAddr:      legacySrc,
+		SendIPAddr:      netip.MustParseAddr("10.0.0.1"),
+		TgtHwAddr:       net.HardwareAddr{0, 0, 0, 0, 0, 0},
+		TgtIPAddr:       netip.MustParseAddr("10.0.0.2"),
+	}
+
+	for name, frame := range map[string][]byte{
+		"untagged": legacyFrame(0x0806, legacyARP),
+		"tagged":   legacyFrame(0x8100, []byte{0x00, 0x64, 0x08, 0x06}, legacyARP),
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			eth := &ethernet.EthernetFrame{}
+			require.NoError(t, eth.UnmarshalBinary(frame))
+
+			pkt, err := eth.ExtractARPPacket()
+			require.NoError(t, err)
+			assert.Equal(t, expected.SendHwAddr, pkt.SendHwAddr)
+			assert.Equal(t, expected.SendIPAddr, pkt.SendIPAddr)
+			assert.Equal(t, expected.TgtHwAddr, pkt.TgtHwAddr)
+			assert.Equal(t, expected.TgtIPAddr, pkt.TgtIPAddr)
+			assert.Equal(t, []any{expected.HardwareType, expected.ProtocolType, expected.HardwareAddrLen,
+				expected.ProtocolAddrLen, expected.OpCode}, []any{pkt.HardwareType, pkt.ProtocolType,
+				pkt.HardwareAddrLen, pkt.ProtocolAddrLen, pkt.OpCode})
+		})
+	}
+
+	// a payload of another type is parsed as ARP only when asked to
+	eth := &ethernet.EthernetFrame{}
+	require.NoError(t, eth.UnmarshalBinary(legacyFrame(0x0800, legacyARP)))
+
+	_, err := eth.ExtractARPPacket()
+	require.ErrorIs(t, err, ethernet.ErrNotARP)
+
+	pkt, err := eth.ExtractARPPacket(ethernet.WithLenientEthertype())
+	require.NoError(t, err)
+	assert.Equal(t, expected.SendIPAddr, pkt.SendIPAddr)
+}
+
+func TestLegacyARPPacketUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	var pkt encoding.BinaryUnmarshaler = &ethernet.ARPPacket{}
+
+	err := pkt.UnmarshalBinary(nil)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.NotErrorIs(t, err, ethernet.ErrMalformedARPPacket)
+
+	for _, n := range []int{1, 7, 8, 14, 18, 24, len(legacyARP) - 1} {
+		err := pkt.UnmarshalBinary(legacyARP[:n])
+		assert.ErrorIs(t, err, ethernet.ErrMalformedARPPacket, "%d bytes", n)
+	}
+
+	buf := append([]byte{}, legacyARP...)
+	require.NoError(t, pkt.UnmarshalBinary(buf))
+
+	// the addresses don't alias the buffer
+	clear(buf)
+	assert.Equal(t, legacySrc, pkt.(*ethernet.ARPPacket).SendHwAddr)
+}
+
+func TestLegacyARPProtocolAddrLen(t *testing.T) {
+	t.Parallel()
+
+	// protocol addresses of 6 bytes, neither IPv4 nor IPv6
+	arp := []byte{
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x06, 0x00, 0x01,
+		0x52, 0x54, 0x00, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x01, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x02, 0x00, 0x00,
+	}
+
+	var pkt encoding.BinaryUnmarshaler = &ethernet.ARPPacket{}
+
+	assert.ErrorIs(t, pkt.UnmarshalBinary(arp), ethernet.ErrMalformedARPPacket)
+
+	eth := &ethernet.EthernetFrame{}
+	require.NoError(t, eth.UnmarshalBinary(legacyFrame(0x0806, arp)))
+
+	_, err := eth.ExtractARPPacket()
+	assert.ErrorIs(t, err, ethernet.ErrMalformedARPPacket)
+
+	// they are only decoded when asked to
+	opaque, err := eth.ExtractARPPacket(ethernet.WithOpaqueProtocolAddrs())
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0a, 0x00, 0x00, 0x01, 0x00, 0x00}, opaque.SendProtoAddr)
+	assert.False(t, opaque.SenderAddr().IsValid())
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The decoders of the package return a *DecodeError, which matches one of
+// these with errors.Is, as well as the sentinel of the protocol, such as
+// ErrMalformedFrame
+var (
+	// ErrTruncated is matched by errors for input which ends before a field,
+	// these also match io.ErrUnexpectedEOF
+	ErrTruncated = errors.New("truncated")
+	// ErrTruncatedBySnaplen is matched rather than ErrTruncated by errors
+	// for a captured frame which ends before a field only because the
+	// capture kept its first bytes, see Snapped. These also match
+	// io.ErrUnexpectedEOF.
+	ErrTruncatedBySnaplen = errors.New("truncated by the snaplen")
+	// ErrMalformed is matched by errors for a field holding a value the
+	// protocol doesn't allow
+	ErrMalformed = errors.New("malformed")
+	// ErrUnsupported is matched by errors for a protocol, or a version or
+	// type of one, which the decoder doesn't handle
+	ErrUnsupported = errors.New("unsupported")
+	// ErrChecksum is matched by errors for a checksum which doesn't match
+	// the data it covers
+	ErrChecksum = errors.New("checksum mismatch")
+	// ErrDecodeLimitExceeded is matched by errors for input chaining more
+	// structures than the decoders follow, see DecodeLimits
+	ErrDecodeLimitExceeded = errors.New("decode limit exceeded")
+)
+
+// DecodeError describes why a decoder failed, and where
+type DecodeError struct {
+	// Kind is one of ErrTruncated, ErrTruncatedBySnaplen, ErrMalformed,
+	// ErrUnsupported, ErrChecksum and ErrDecodeLimitExceeded
+	Kind error
+	// Err is the sentinel of the protocol, it may be nil
+	Err error
+	// Protocol is the protocol being decoded, such as "ARP"
+	Protocol string
+	// Field is the field which failed to decode, such as "sender hardware
+	// address"
+	Field string
+	// Detail is appended to the message of Err
+	Detail string
+}
+
+// Error returns the message of Err, so that wrapping doesn't change the
+// messages of the sentinels which predate DecodeError
+func (e *DecodeError) Error() string {
+	msg := fmt.Sprintf("%s %s: %s", e.Protocol, e.Field, e.Kind)
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+
+	return msg
+}
+
+// Unwrap returns the kind of the error and the sentinel of the protocol
+func (e *DecodeError) Unwrap() []error {
+	errs := []error{e.Kind}
+
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+
+	if e.Kind == ErrTruncated || e.Kind == ErrTruncatedBySnaplen {
+		errs = append(errs, io.ErrUnexpectedEOF)
+	}
+
+	return errs
+}
+
+func (e *DecodeError) detailf(format string, args ...any) *DecodeError {
+	e.Detail = fmt.Sprintf(format, args...)
+
+	return e
+}
+
+// Snapped returns err as an error matching ErrTruncatedBySnaplen when it is
+// a truncation, matching io.ErrUnexpectedEOF, of a frame of length bytes on
+// the wire of which only captured were kept. Other errors, and those of a
+// frame captured whole, are returned as they are. The decoders of the other
+// packages return truncations matching io.ErrUnexpectedEOF for this.
+func Snapped(err error, captured, length int) error {
+	if err == nil || captured >= length || !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	var decodeErr *DecodeError
+
+	if errors.As(err, &decodeErr) {
+		snapped := *decodeErr
+		snapped.Kind = ErrTruncatedBySnaplen
+
+		return &snapped
+	}
+
+	return &DecodeError{Kind: ErrTruncatedBySnaplen, Err: err}
+}
+
+// truncated returns an error for input ending before field, err is the
+// sentinel of the protocol
+func truncated(protocol, field string, err error) *DecodeError {
+	return &DecodeError{Kind: ErrTruncated, Err: err, Protocol: protocol, Field: field}
+}
+
+// malformed returns an error for a field holding a value protocol doesn't
+// allow
+func malformed(protocol, field string, err error) *DecodeError {
+	return &DecodeError{Kind: ErrMalformed, Err: err, Protocol: protocol, Field: field}
+}
+
+// unsupported returns an error for a value of field the decoder doesn't
+// handle, such as the ethertype of another protocol
+func unsupported(protocol, field string, err error) *DecodeError {
+	return &DecodeError{Kind: ErrUnsupported, Err: err, Protocol: protocol, Field: field}
+}
+
+// badChecksum returns an error for a checksum not matching the data
+func badChecksum(protocol, field string, err error) *DecodeError {
+	return &DecodeError{Kind: ErrChecksum, Err: err, Protocol: protocol, Field: field}
+}
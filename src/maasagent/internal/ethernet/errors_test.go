@@ -0,0 +1,488 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeErrors(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	kinds := []error{ErrTruncated, ErrTruncatedBySnaplen, ErrMalformed, ErrUnsupported, ErrChecksum}
+
+	frame := func(t *testing.T, buf []byte) *EthernetFrame {
+		t.Helper()
+
+		e := &EthernetFrame{}
+		require.NoError(t, e.UnmarshalBinary(buf))
+
+		return e
+	}
+
+	udp := func(t *testing.T) IPv4View {
+		t.Helper()
+
+		v, err := frame(t, mustBuild(t, NewFrame().Src(src).UDP(netip.MustParseAddrPort("10.0.0.1:67"),
+			netip.MustParseAddrPort("10.0.0.2:68"), []byte{0x01}))).IPv4View()
+		require.NoError(t, err)
+
+		return v
+	}
+
+	testcases := map[string]struct {
+		decode   func(t *testing.T) error
+		kind     error
+		sentinel error
+		protocol string
+		field    string
+	}{
+		"empty frame": {
+			decode:   func(*testing.T) error { return (&EthernetFrame{}).UnmarshalBinary(nil) },
+			kind:     ErrTruncated,
+			sentinel: io.ErrUnexpectedEOF,
+			protocol: "ethernet",
+			field:    "header",
+		},
+		"short frame": {
+			decode:   func(*testing.T) error { return (&EthernetFrame{}).UnmarshalBinary(arpFrame[:13]) },
+			kind:     ErrTruncated,
+			sentinel: ErrMalformedFrame,
+			protocol: "ethernet",
+			field:    "header",
+		},
+		"LLC length over the payload": {
+			decode: func(*testing.T) error {
+				return (&EthernetFrame{}).UnmarshalBinary(concat(Broadcast, src, []byte{0x00, 0x10, 0xaa}))
+			},
+			kind:     ErrTruncated,
+			sentinel: ErrMalformedFrame,
+			protocol: "ethernet",
+			field:    "payload",
+		},
+		"LLC frame without length": {
+			decode: func(*testing.T) error {
+				_, err := (&EthernetFrame{EthernetType: EthernetTypeLLC}).MarshalBinary()
+				return err
+			},
+			kind:     ErrMalformed,
+			sentinel: ErrMalformedFrame,
+			protocol: "ethernet",
+			field:    "length",
+		},
+		"short VLAN tag": {
+			decode:   func(*testing.T) error { return (&VLAN{}).UnmarshalBinary([]byte{0x00, 0x64}) },
+			kind:     ErrTruncated,
+			sentinel: ErrMalformedVLAN,
+			protocol: "VLAN",
+			field:    "tag",
+		},
+		"untagged frame": {
+			decode: func(t *testing.T) error {
+				_, err := frame(t, arpFrame).ExtractVLAN()
+				return err
+			},
+			kind:     ErrUnsupported,
+			sentinel: ErrNotVLAN,
+			protocol: "ethernet",
+			field:    "ethertype",
+		},
+		"short ARP packet": {
+			decode:   func(*testing.T) error { return (&ARPPacket{}).UnmarshalBinary(arpFrame[14:20]) },
+			kind:     ErrTruncated,
+			sentinel: ErrMalformedARPPacket,
+			protocol: "ARP",
+			field:    "header",
+		},
+		"ARP packet without target address": {
+			decode:   func(*testing.T) error { return (&ARPPacket{}).UnmarshalBinary(arpFrame[14:40]) },
+			kind:     ErrTruncated,
+			sentinel: ErrMalformedARPPacket,
+			protocol: "ARP",
+			field:    "target IP address",
+		},
+		"ARP view of another ethertype": {
+			decode: func(t *testing.T) error {
+				_, err := frame(t, lacpFrame).ARPView()
+				return err
+			},
+			kind:     ErrUnsupported,
+			sentinel: ErrNotARP,
+			protocol: "ethernet",
+			field:    "ethertype",
+		},
+		"short ARP view": {
+			decode: func(*testing.T) error {
+				_, err := ARPView(arpFrame[14:30]).Validated()
+				return err
+			},
+			kind:     ErrTruncated,
+			sentinel: ErrMalformedARPPacket,
+			protocol: "ARP",
+			field:    "addresses",
+		},
+		"LACP of another ethertype": {
+			decode: func(t *testing.T) error {
+				_, err := frame(t, arpFrame).ExtractLACP()
+				return err
+			},
+			kind:     ErrUnsupported,
+			sentinel: ErrNotLACP,
+			protocol: "ethernet",
+			field:    "ethertype",
+		},
+		"slow protocol other than LACP": {
+			decode: func(*testing.T) error {
+				buf := append([]byte{0x03}, lacpFrame[15:]...)
+				return (&LACPPacket{}).UnmarshalBinary(buf)
+			},
+			kind:     ErrUnsupported,
+			sentinel: ErrNotLACP,
+			protocol: "slow protocols",
+			field:    "subtype",
+		},
+		"short LACPDU": {
+			decode:   func(*testing.T) error { return (&LACPPacket{}).UnmarshalBinary(lacpFrame[14:40]) },
+			kind:     ErrTruncated,
+			sentinel: ErrMalformedLACP,
+			protocol: "LACP",
+			field:    "LACPDU",
+		},
+		"LACPDU with another TLV": {
+			decode: func(*testing.T) error {
+				buf := append([]byte{}, lacpFrame[14:]...)
+				buf[2] = 0x03
+
+				return (&LACPPacket{}).UnmarshalBinary(buf)
+			},
+			kind:     ErrMalformed,
+			sentinel: ErrMalformedLACP,
+			protocol: "LACP",
+			field:    "TLV",
+		},
+		"CFM of another ethertype": {
+			decode: func(t *testing.T) error {
+				_, err := frame(t, arpFrame).ExtractCFM()
+				return err
+			},
+			kind:     ErrUnsupported,
+			sentinel: ErrNotCFM,
+			protocol: "ethernet",
+			field:    "ethertype",
+		},
+		"short CFM PDU": {
+			decode:   func(*testing.T) error { return (&CFMPacket{}).UnmarshalBinary([]byte{0xa0, 0x01}) },
+			kind:     ErrTruncated,
+			sentinel: ErrMalformedCFM,
+			protocol: "CFM",
+			field:    "header",
+		},
+		"CCM without MEPID": {
+			decode: func(*testing.T) error {
+				return (&CFMPacket{}).UnmarshalBinary([]byte{0xa0, byte(CFMOpCodeCCM), 0x04, 0x46, 0x00})
+			},
+			kind:     ErrTruncated,
+			sentinel: ErrMalformedCFM,
+			protocol: "CFM",
+			field:    "MEPID",
+		},
+		"IPv4 view of another ethertype": {
+			decode: func(t *testing.T) error {
+				_, err := frame(t, arpFrame).IPv4View()
+				return err
+			},
+			kind:     ErrUnsupported,
+			sentinel: ErrNotIPv4,
+			protocol: "ethernet",
+			field:    "ethertype",
+		},
+		"short IPv4 header": {
+			decode: func(*testing.T) error {
+				_, err := IPv4View(make([]byte, 19)).Validated()
+				return err
+			},
+			kind:     ErrTruncated,
+			sentinel: ErrMalformedIPv4,
+			protocol: "IPv4",
+			field:    "header",
+		},
+		"IPv6 in IPv4 view": {
+			decode: func(*testing.T) error {
+				v := make(IPv4View, 40)
+				v[0] = 0x60
+
+				_, err := v.Validated()
+
+				return err
+			},
+			kind:     ErrMalformed,
+			sentinel: ErrMalformedIPv4,
+			protocol: "IPv4",
+			field:    "version",
+		},
+		"IPv4 total length over the buffer": {
+			decode: func(t *testing.T) error {
+				_, err := udp(t)[:25].Validated()
+				return err
+			},
+			kind:     ErrMalformed,
+			sentinel: ErrMalformedIPv4,
+			protocol: "IPv4",
+			field:    "length",
+		},
+		"IPv4 header checksum": {
+			decode: func(t *testing.T) error {
+				v := udp(t)
+				v[8]--
+
+				return v.VerifyChecksum()
+			},
+			kind:     ErrChecksum,
+			protocol: "IPv4",
+			field:    "header checksum",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.decode(t)
+
+			for _, kind := range kinds {
+				assert.Equal(t, kind == tc.kind, errors.Is(err, kind), "errors.Is(%v, %v)", err, kind)
+			}
+
+			if tc.sentinel != nil {
+				assert.ErrorIs(t, err, tc.sentinel)
+			}
+
+			assert.Equal(t, tc.kind == ErrTruncated, errors.Is(err, io.ErrUnexpectedEOF))
+
+			var decodeErr *DecodeError
+
+			require.ErrorAs(t, err, &decodeErr)
+			assert.Equal(t, tc.protocol, decodeErr.Protocol)
+			assert.Equal(t, tc.field, decodeErr.Field)
+		})
+	}
+}
+
+func TestSnaplen(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	arp := func(f *EthernetFrame) error {
+		_, err := f.ExtractARPPacket()
+		return err
+	}
+
+	testcases := map[string]struct {
+		frame  []byte
+		decode func(*EthernetFrame) error
+	}{
+		"ARP": {
+			frame:  arpFrame,
+			decode: arp,
+		},
+		"QinQ ARP": {
+			frame: mustBuild(t, NewFrame().Src(src).Tags(Tag{TPID: EthernetTypeQinQ, VID: 10}, Tag{VID: 20}).Padded().
+				ARPRequest(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2"))),
+			decode: func(f *EthernetFrame) error {
+				if _, _, _, err := f.AppendTags(nil); err != nil {
+					return err
+				}
+
+				return arp(f)
+			},
+		},
+		"LACP": {
+			frame: lacpFrame,
+			decode: func(f *EthernetFrame) error {
+				_, err := f.ExtractLACP()
+				return err
+			},
+		},
+		"tagged CFM": {
+			frame: mustBuild(t, NewFrame().Src(src).VLAN(100).Padded().
+				Payload(EthernetTypeCFM, []byte{0xa0, 0x01, 0x04, 0x46, 0x00, 0x00, 0x00, 0x2a, 0x00, 0x65})),
+			decode: func(f *EthernetFrame) error {
+				_, err := f.ExtractCFM()
+				return err
+			},
+		},
+		"IPv4": {
+			frame: mustBuild(t, NewFrame().Src(src).UDP(netip.MustParseAddrPort("10.0.0.1:67"),
+				netip.MustParseAddrPort("10.0.0.2:68"), make([]byte, 300))),
+			decode: func(f *EthernetFrame) error {
+				_, err := f.IPv4View()
+				return err
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var whole EthernetFrame
+
+			require.NoError(t, whole.UnmarshalCaptured(tc.frame, len(tc.frame)))
+			require.NoError(t, tc.decode(&whole))
+
+			// the frame cut at every snaplen fails as the same bytes do
+			// without the length of the frame, but never as malformed
+			for snaplen := 1; snaplen < len(tc.frame); snaplen++ {
+				var captured, cut EthernetFrame
+
+				buf := tc.frame[:snaplen]
+
+				err := captured.UnmarshalCaptured(buf, len(tc.frame))
+				if err == nil {
+					err = tc.decode(&captured)
+				}
+
+				want := cut.UnmarshalBinary(buf)
+				if want == nil {
+					want = tc.decode(&cut)
+				}
+
+				if want == nil {
+					assert.NoError(t, err, "snaplen %d", snaplen)
+					continue
+				}
+
+				assert.ErrorIs(t, err, ErrTruncatedBySnaplen, "snaplen %d", snaplen)
+				assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "snaplen %d", snaplen)
+				assert.NotErrorIs(t, err, ErrTruncated, "snaplen %d", snaplen)
+				assert.NotErrorIs(t, err, ErrMalformed, "snaplen %d", snaplen)
+				assert.NotErrorIs(t, want, ErrTruncatedBySnaplen, "snaplen %d", snaplen)
+			}
+		})
+	}
+}
+
+func TestSnaplenRecovered(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	// the tuple of an ARP packet fits in any snaplen keeping the header
+	var frame EthernetFrame
+
+	require.NoError(t, frame.UnmarshalBinary(arpFrame))
+
+	want, err := frame.ExtractARPPacket()
+	require.NoError(t, err)
+
+	padded := concat(arpFrame, make([]byte, 18))
+	require.NoError(t, frame.UnmarshalCaptured(padded[:42], len(padded)))
+
+	pkt, err := frame.ExtractARPPacket()
+	require.NoError(t, err)
+	assert.Equal(t, want, pkt)
+
+	// the header of an IPv4 packet the snaplen cut is still read, a packet
+	// longer than the frame is malformed
+	udp := mustBuild(t, NewFrame().Src(src).UDP(netip.MustParseAddrPort("10.0.0.1:67"),
+		netip.MustParseAddrPort("10.0.0.2:68"), make([]byte, 300)))
+
+	require.NoError(t, frame.UnmarshalCaptured(udp[:96], len(udp)))
+
+	view, err := frame.IPv4View()
+	require.ErrorIs(t, err, ErrTruncatedBySnaplen)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), view.Src())
+	assert.Equal(t, len(udp)-14, view.TotalLen())
+	assert.Len(t, view.Payload(), 96-34)
+
+	require.NoError(t, frame.UnmarshalCaptured(udp[:96], 100))
+
+	_, err = frame.IPv4View()
+	require.ErrorIs(t, err, ErrMalformed)
+	assert.NotErrorIs(t, err, ErrTruncatedBySnaplen)
+
+	// the frames decoded whole are classified as before
+	require.NoError(t, frame.UnmarshalBinary(udp[:96]))
+
+	_, err = frame.IPv4View()
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestSnapped(t *testing.T) {
+	t.Parallel()
+
+	short := truncated("ARP", "header", ErrMalformedARPPacket)
+
+	err := Snapped(short, 20, 60)
+	require.ErrorIs(t, err, ErrTruncatedBySnaplen)
+	require.ErrorIs(t, err, ErrMalformedARPPacket)
+	assert.EqualError(t, err, short.Error())
+
+	var decodeErr *DecodeError
+
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, "header", decodeErr.Field)
+
+	// the decoders of the other packages wrap io.ErrUnexpectedEOF
+	other := fmt.Errorf("malformed packet: truncated header: %w", io.ErrUnexpectedEOF)
+	require.ErrorIs(t, Snapped(other, 20, 60), ErrTruncatedBySnaplen)
+	assert.EqualError(t, Snapped(other, 20, 60), other.Error())
+
+	// a frame captured whole, and the errors which aren't truncations, are
+	// left as they are
+	assert.Equal(t, short, Snapped(short, 60, 60))
+	assert.Equal(t, errNotARP, Snapped(errNotARP, 20, 60))
+	assert.NoError(t, Snapped(nil, 20, 60))
+}
+
+func TestDecodeErrorMessage(t *testing.T) {
+	t.Parallel()
+
+	// the sentinels' messages predate DecodeError and are kept
+	assert.EqualError(t, (&VLAN{}).UnmarshalBinary(nil), "VLAN tag is malformed")
+	assert.EqualError(t, (&ARPPacket{}).UnmarshalBinary(arpFrame[14:20]),
+		"malformed ARP packet: packet missing initial ARP fields")
+
+	err := badChecksum("IPv4", "header checksum", nil).detailf("checksum 0x%04x", 0xbeef)
+	assert.EqualError(t, err, "IPv4 header checksum: checksum mismatch: checksum 0xbeef")
+}
+
+func TestIPv4ViewVerifyChecksum(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	frame := &EthernetFrame{}
+
+	require.NoError(t, frame.UnmarshalBinary(mustBuild(t, NewFrame().Src(src).UDP(
+		netip.MustParseAddrPort("10.0.0.1:67"), netip.MustParseAddrPort("10.0.0.2:68"), nil))))
+
+	view, err := frame.IPv4View()
+	require.NoError(t, err)
+	assert.NoError(t, view.VerifyChecksum())
+}
@@ -26,10 +26,11 @@ const (
 	minEthernetLen = 14
 )
 
+// EthernetType is the ethertype of a frame, naming the protocol of its
+// payload. Those below NonStdLenEthernetTypes are the length of an 802.3
+// frame instead.
 type EthernetType uint16
 
-//go:generate go run golang.org/x/tools/cmd/stringer -type=EthernetType -trimprefix=EthernetType
-
 const (
 	// EthernetTypeLLC is a special ethernet type, if found the frame is truncated
 	EthernetTypeLLC EthernetType = 0
@@ -42,6 +43,50 @@ const (
 	// EthernetTypeVLAN is the ethernet type for a frame containing a VLAN tag,
 	// the VLAN tag bytes will indicate the actual type of packet the frame contains
 	EthernetTypeVLAN EthernetType = 0x8100
+	// EthernetTypeSlowProtocols is the ethernet type for a frame containing
+	// a slow protocol PDU, such as LACP
+	EthernetTypeSlowProtocols EthernetType = 0x8809
+	// EthernetTypeCFM is the ethernet type for a frame containing an
+	// 802.1ag connectivity fault management PDU
+	EthernetTypeCFM EthernetType = 0x8902
+	// EthernetTypeWakeOnLAN is the ethertype AMD registered for the magic
+	// packet, NICs look for the pattern under any type though
+	EthernetTypeWakeOnLAN EthernetType = 0x0842
+	// EthernetTypeRARP is the ethernet type for a frame containing a
+	// reverse ARP packet
+	EthernetTypeRARP EthernetType = 0x8035
+	// EthernetTypeTEB is the ethernet type for transparent ethernet
+	// bridging, what Geneve and NVGRE carry an inner frame as
+	EthernetTypeTEB EthernetType = 0x6558
+	// EthernetTypeQinQ is the 802.1ad service tag, which carries an 802.1Q
+	// tag in QinQ frames
+	EthernetTypeQinQ EthernetType = 0x88a8
+	// EthernetTypeQinQLegacy is the service tag some switches used before
+	// 802.1ad assigned one
+	EthernetTypeQinQLegacy EthernetType = 0x9100
+	// EthernetTypeMPLS is the ethernet type for a frame containing an MPLS
+	// unicast label stack
+	EthernetTypeMPLS EthernetType = 0x8847
+	// EthernetTypeMPLSMulticast is the ethernet type for a frame containing
+	// an MPLS multicast label stack
+	EthernetTypeMPLSMulticast EthernetType = 0x8848
+	// EthernetTypePPPoEDiscovery is the ethernet type for the PPPoE
+	// discovery stage
+	EthernetTypePPPoEDiscovery EthernetType = 0x8863
+	// EthernetTypePPPoESession is the ethernet type for the PPPoE session
+	// stage
+	EthernetTypePPPoESession EthernetType = 0x8864
+	// EthernetTypeEAPOL is the ethernet type for a frame containing an
+	// 802.1X EAP over LAN PDU
+	EthernetTypeEAPOL EthernetType = 0x888e
+	// EthernetTypeLLDP is the ethernet type for a frame containing an LLDPDU
+	EthernetTypeLLDP EthernetType = 0x88cc
+	// EthernetTypeMACsec is the ethernet type for a frame protected by
+	// 802.1AE
+	EthernetTypeMACsec EthernetType = 0x88e5
+	// EthernetTypePTP is the ethernet type for a frame containing an IEEE
+	// 1588 PTP message
+	EthernetTypePTP EthernetType = 0x88f7
 
 	// NonStdLenEthernetTypes is a magic number to find any non-standard types
 	// and mark them as EthernetTypeLLC
@@ -60,6 +105,26 @@ var (
 	ErrMalformedFrame = errors.New("malformed ethernet frame")
 )
 
+// the errors without detail are allocated once and held as error values,
+// returning one costs no more than returning a sentinel so the decoders
+// stay inlinable and what they return stays on the stack
+var (
+	errNotVLAN error = unsupported("ethernet", "ethertype", ErrNotVLAN)
+	errNotLACP error = unsupported("ethernet", "ethertype", ErrNotLACP)
+	errNotCFM  error = unsupported("ethernet", "ethertype", ErrNotCFM)
+	errNotARP  error = unsupported("ethernet", "ethertype", ErrNotARP)
+	errNotIPv4 error = unsupported("ethernet", "ethertype", ErrNotIPv4)
+
+	errTruncatedVLAN  error = truncated("VLAN", "tag", ErrMalformedVLAN)
+	errEmptyFrame     error = truncated("ethernet", "header", io.ErrUnexpectedEOF)
+	errTruncatedFrame error = truncated("ethernet", "header", ErrMalformedFrame)
+	errShortPayload   error = truncated("ethernet", "payload", ErrMalformedFrame)
+	errFrameLen       error = malformed("ethernet", "length", ErrMalformedFrame)
+
+	errTagLimit error = &DecodeError{Kind: ErrDecodeLimitExceeded, Err: ErrMalformedVLAN, Protocol: "VLAN",
+		Field: "tags", Detail: "too many tags"}
+)
+
 // VLAN represents a VLAN tag within an ethernet frame
 type VLAN struct {
 	Priority     uint8
@@ -72,7 +137,7 @@ type VLAN struct {
 // and extract a VLAN tag if one is present
 func (v *VLAN) UnmarshalBinary(buf []byte) error {
 	if len(buf) < 4 {
-		return ErrMalformedVLAN
+		return errTruncatedVLAN
 	}
 
 	// extract the first 3 bits
@@ -87,60 +152,273 @@ func (v *VLAN) UnmarshalBinary(buf []byte) error {
 	return nil
 }
 
-// EthernetFrame represents an ethernet frame
+// EthernetFrame represents an ethernet frame. The MACs and the payload set
+// by UnmarshalBinary alias the buffer it was given, a frame kept once the
+// buffer is reused, such as by another goroutine, must be detached first.
 type EthernetFrame struct {
-	SrcMAC       net.HardwareAddr
-	DstMAC       net.HardwareAddr
-	Payload      []byte
+	SrcMAC  net.HardwareAddr
+	DstMAC  net.HardwareAddr
+	Payload []byte
+	// captured and length are the bytes UnmarshalCaptured was given and
+	// the length of the frame on the wire, 0 for UnmarshalBinary
+	captured     int
+	length       int
 	Len          uint16
 	EthernetType EthernetType
 }
 
+// ExtractOption configures EthernetFrame.ExtractARPPacket
+type ExtractOption func(*extractConfig)
+
+type extractConfig struct {
+	parser  ParserOptions
+	lenient bool
+	opaque  bool
+}
+
+// WithLenientEthertype parses the payload as ARP whatever the ethertype,
+// for the callers which have already classified the frame another way
+func WithLenientEthertype() ExtractOption {
+	return func(c *extractConfig) {
+		c.lenient = true
+	}
+}
+
+// WithOpaqueProtocolAddrs decodes the packets whose protocol addresses
+// aren't IP addresses, as ARPPacket.UnmarshalOpaque does, rather than
+// return ErrMalformedARPPacket
+func WithOpaqueProtocolAddrs() ExtractOption {
+	return func(c *extractConfig) {
+		c.opaque = true
+	}
+}
+
+// WithParserOptions decodes the tags and the packet as the options decide,
+// see Strictness
+func WithParserOptions(o ParserOptions) ExtractOption {
+	return func(c *extractConfig) {
+		c.parser = o
+	}
+}
+
+func newExtractConfig(opts []ExtractOption) extractConfig {
+	var cfg extractConfig
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
 // ExtractARPPacket will extract an ARP packet from the ethernet frame's
-// payload
-func (e *EthernetFrame) ExtractARPPacket() (*ARPPacket, error) {
-	var buf []byte
-	if e.EthernetType == EthernetTypeVLAN {
-		buf = e.Payload[4:]
-	} else {
-		buf = e.Payload
+// payload, following the VLAN tags if there are any, and return ErrNotARP
+// if the frame is of another type
+func (e *EthernetFrame) ExtractARPPacket(opts ...ExtractOption) (*ARPPacket, error) {
+	var cfg extractConfig
+
+	// the options escape, they are only applied when there are any so that
+	// the common call doesn't allocate
+	if len(opts) > 0 {
+		cfg = newExtractConfig(opts)
+	}
+
+	ethType, buf, err := e.innerPayload(cfg.parser)
+	if err != nil {
+		return nil, e.snapped(err)
+	}
+
+	if ethType != EthernetTypeARP && !cfg.lenient {
+		return nil, errNotARP
 	}
 
 	a := &ARPPacket{}
 
-	err := a.UnmarshalBinary(buf)
+	err = a.unmarshal(buf, cfg.parser, cfg.opaque)
 	if err != nil {
-		return nil, err
+		return nil, e.snapped(err)
 	}
 
 	return a, nil
 }
 
+// ExtractARPPacketStrict is ExtractARPPacket followed by ARPPacket.Validate
+// at ValidationStrict, the violations are returned in an error matching
+// ErrMalformed. The packet isn't checked against the frame, the callers
+// which want that call Validate themselves.
+func (e *EthernetFrame) ExtractARPPacketStrict(opts ...ExtractOption) (*ARPPacket, error) {
+	pkt, err := e.ExtractARPPacket(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pkt.Validate(ValidationStrict, nil); err != nil {
+		return nil, malformed("ARP", "packet", err)
+	}
+
+	return pkt, nil
+}
+
+// ExtractLACP will extract an LACPDU from the ethernet frame's payload,
+// following the VLAN tags if there are any, and return ErrNotLACP if the
+// frame is of another type
+func (e *EthernetFrame) ExtractLACP() (*LACPPacket, error) {
+	ethType, buf, err := e.innerPayload(ParserOptions{})
+	if err != nil {
+		return nil, e.snapped(err)
+	}
+
+	if ethType != EthernetTypeSlowProtocols {
+		return nil, errNotLACP
+	}
+
+	l := &LACPPacket{}
+
+	err = l.UnmarshalBinary(buf)
+	if err != nil {
+		return nil, e.snapped(err)
+	}
+
+	return l, nil
+}
+
+// ExtractCFM will extract a CFM PDU from the ethernet frame's payload,
+// following the VLAN tags if there are any, and return ErrNotCFM if the
+// frame is of another type
+func (e *EthernetFrame) ExtractCFM() (*CFMPacket, error) {
+	ethType, buf, err := e.innerPayload(ParserOptions{})
+	if err != nil {
+		return nil, e.snapped(err)
+	}
+
+	if ethType != EthernetTypeCFM {
+		return nil, errNotCFM
+	}
+
+	c := &CFMPacket{}
+
+	err = c.UnmarshalBinary(buf)
+	if err != nil {
+		return nil, e.snapped(err)
+	}
+
+	return c, nil
+}
+
+// innerPayload returns the ethernet type and payload following the VLAN
+// tags, 802.1Q and 802.1ad ones stacked up to the tag limit of parser,
+// which checks each of them
+func (e *EthernetFrame) innerPayload(parser ParserOptions) (EthernetType, []byte, error) {
+	ethType, buf := e.EthernetType, e.Payload
+	limit := parser.tagLimit()
+
+	for i := 0; IsTPID(ethType); i++ {
+		if len(buf) < vlanTagLen {
+			return 0, nil, errTruncatedVLAN
+		}
+
+		if i == limit {
+			return 0, nil, errTagLimit
+		}
+
+		if err := parser.checkTag(binary.BigEndian.Uint16(buf[0:2])); err != nil {
+			return 0, nil, err
+		}
+
+		ethType = EthernetType(binary.BigEndian.Uint16(buf[2:4]))
+		buf = buf[vlanTagLen:]
+	}
+
+	return ethType, buf, nil
+}
+
 // ExtractVLAN will extract the VLAN tag from the ethernet frame's
-// payload if one is present and return ErrNotVLAN if not
+// payload if one is present and return ErrNotVLAN if not. A snaplen keeps
+// more than the tag, its errors aren't classified so that the extraction
+// is inlined and the tag doesn't escape.
 func (e *EthernetFrame) ExtractVLAN() (*VLAN, error) {
 	if e.EthernetType != EthernetTypeVLAN {
-		return nil, ErrNotVLAN
+		return nil, errNotVLAN
 	}
 
 	v := &VLAN{}
 
-	err := v.UnmarshalBinary(e.Payload[0:4])
+	err := v.UnmarshalBinary(e.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// ExtractVLANWith is ExtractVLAN with the tag checked as the options
+// decide, see ParserOptions.RejectReservedVID
+func (e *EthernetFrame) ExtractVLANWith(o ParserOptions) (*VLAN, error) {
+	v, err := e.ExtractVLAN()
 	if err != nil {
 		return nil, err
 	}
 
+	if err := o.checkTag(v.ID); err != nil {
+		return nil, err
+	}
+
 	return v, nil
 }
 
-// UnmarshalBinary parses ethernet frame bytes into an EthernetFrame
+// Detach copies the MACs and the payload of the frame into memory it owns,
+// so that the frame outlives the buffer it was decoded from. It returns the
+// frame.
+func (e *EthernetFrame) Detach() *EthernetFrame {
+	// the fields share a single allocation, each capped so that appending
+	// to one doesn't overwrite the next
+	buf := make([]byte, 0, len(e.DstMAC)+len(e.SrcMAC)+len(e.Payload))
+	own := func(b []byte) []byte {
+		if b == nil {
+			return nil
+		}
+
+		start := len(buf)
+		buf = append(buf, b...)
+
+		return buf[start:len(buf):len(buf)]
+	}
+
+	e.DstMAC = own(e.DstMAC)
+	e.SrcMAC = own(e.SrcMAC)
+	e.Payload = own(e.Payload)
+
+	return e
+}
+
+// UnmarshalCaptured is UnmarshalBinary for the first bytes of a frame of
+// length bytes on the wire, such as those a capture with a snaplen kept.
+// The errors of the frame and of its extractors for the fields past buf
+// match ErrTruncatedBySnaplen rather than ErrTruncated.
+func (e *EthernetFrame) UnmarshalCaptured(buf []byte, length int) error {
+	err := e.UnmarshalBinary(buf)
+	e.captured, e.length = len(buf), length
+
+	return e.snapped(err)
+}
+
+// snapped returns err as truncated by the snaplen if the frame wasn't
+// captured whole, see Snapped
+func (e *EthernetFrame) snapped(err error) error {
+	return Snapped(err, e.captured, e.length)
+}
+
+// UnmarshalBinary parses ethernet frame bytes into an EthernetFrame, the
+// frame aliases buf until it is detached
 func (e *EthernetFrame) UnmarshalBinary(buf []byte) error {
+	e.captured, e.length = 0, 0
+
 	if len(buf) < minEthernetLen {
 		if len(buf) == 0 {
-			return io.ErrUnexpectedEOF
+			return errEmptyFrame
 		}
 
-		return ErrMalformedFrame
+		return errTruncatedFrame
 	}
 
 	e.DstMAC = buf[0:6]
@@ -157,7 +435,7 @@ func (e *EthernetFrame) UnmarshalBinary(buf []byte) error {
 
 		cmp := len(e.Payload) - int(e.Len)
 		if cmp < 0 {
-			return ErrMalformedFrame
+			return errShortPayload
 		} else if cmp > 0 {
 			e.Payload = e.Payload[:len(e.Payload)-cmp]
 		}
@@ -174,7 +452,7 @@ func (e *EthernetFrame) MarshalBinary() ([]byte, error) {
 
 	if e.EthernetType == EthernetTypeLLC {
 		if e.Len <= 0 {
-			return nil, ErrMalformedFrame
+			return nil, errFrameLen
 		}
 
 		binary.BigEndian.PutUint16(buf[12:], e.Len)
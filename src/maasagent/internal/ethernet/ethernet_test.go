@@ -16,12 +16,17 @@
 package ethernet
 
 import (
+	"bytes"
 	"io"
 	"net"
 	"net/netip"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/testing/leak"
 )
 
 func TestVLANUnmarshal(t *testing.T) {
@@ -164,6 +169,12 @@ func TestEthernetFrameExtractVLAN(t *testing.T) {
 			},
 			err: ErrNotVLAN,
 		},
+		"truncated VLAN tag": {
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x81, 0x00, 0x00, 0x02,
+			},
+			err: ErrMalformedVLAN,
+		},
 	}
 
 	for name, tc := range testcases {
@@ -192,10 +203,17 @@ func TestEthernetFrameExtractVLAN(t *testing.T) {
 func TestEthernetFrameExtractARP(t *testing.T) {
 	t.Parallel()
 
+	vlanIPv4 := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x81, 0x00, 0x00, 0x02,
+		0x08, 0x00, 0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25,
+		0xc0, 0xa8, 0x0a, 0x1a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19,
+	}
+
 	testcases := map[string]struct {
-		in  []byte
-		out *ARPPacket
-		err error
+		in   []byte
+		out  *ARPPacket
+		err  error
+		opts []ExtractOption
 	}{
 		"ethernet frame is VLAN": {
 			in: []byte{
@@ -212,8 +230,10 @@ func TestEthernetFrameExtractARP(t *testing.T) {
 				OpCode:          OpRequest,
 				SendHwAddr:      []byte{0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25},
 				SendIPAddr:      netip.MustParseAddr("192.168.10.26"),
+				SendProtoAddr:   []byte{0xc0, 0xa8, 0x0a, 0x1a},
 				TgtHwAddr:       []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
 				TgtIPAddr:       netip.MustParseAddr("192.168.10.25"),
+				TgtProtoAddr:    []byte{0xc0, 0xa8, 0x0a, 0x19},
 			},
 		},
 		"ethernet frame is not VLAN": {
@@ -230,8 +250,41 @@ func TestEthernetFrameExtractARP(t *testing.T) {
 				OpCode:          OpReply,
 				SendHwAddr:      []byte{0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16},
 				SendIPAddr:      netip.MustParseAddr("192.168.1.108"),
+				SendProtoAddr:   []byte{0xc0, 0xa8, 0x01, 0x6c},
 				TgtHwAddr:       []byte{0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26},
 				TgtIPAddr:       netip.MustParseAddr("192.168.1.80"),
+				TgtProtoAddr:    []byte{0xc0, 0xa8, 0x01, 0x50},
+			},
+		},
+		"truncated VLAN tag": {
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x81, 0x00,
+			},
+			err: ErrMalformedVLAN,
+		},
+		"VLAN tag of another ethertype": {
+			in:  vlanIPv4,
+			err: ErrNotARP,
+		},
+		"untagged frame of another ethertype": {
+			in:  append(append([]byte{}, vlanIPv4[:12]...), vlanIPv4[16:]...),
+			err: ErrNotARP,
+		},
+		"lenient on another ethertype": {
+			in:   vlanIPv4,
+			opts: []ExtractOption{WithLenientEthertype()},
+			out: &ARPPacket{
+				HardwareType:    HardwareTypeEthernet,
+				ProtocolType:    ProtocolTypeIPv4,
+				HardwareAddrLen: 6,
+				ProtocolAddrLen: 4,
+				OpCode:          OpRequest,
+				SendHwAddr:      []byte{0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25},
+				SendIPAddr:      netip.MustParseAddr("192.168.10.26"),
+				SendProtoAddr:   []byte{0xc0, 0xa8, 0x0a, 0x1a},
+				TgtHwAddr:       []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+				TgtIPAddr:       netip.MustParseAddr("192.168.10.25"),
+				TgtProtoAddr:    []byte{0xc0, 0xa8, 0x0a, 0x19},
 			},
 		},
 	}
@@ -249,7 +302,7 @@ func TestEthernetFrameExtractARP(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			pkt, err := eth.ExtractARPPacket()
+			pkt, err := eth.ExtractARPPacket(tc.opts...)
 			assert.ErrorIs(t, err, tc.err)
 
 			if err == nil {
@@ -259,6 +312,148 @@ func TestEthernetFrameExtractARP(t *testing.T) {
 	}
 }
 
+func TestEthernetFrameExtractARPPacketStrict(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25}
+	request := func(op uint16, sender net.HardwareAddr) []byte {
+		return concat(Broadcast, src, []byte{0x08, 0x06, 0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, byte(op)},
+			sender, []byte{0xc0, 0xa8, 0x0a, 0x1a}, make([]byte, 6), []byte{0xc0, 0xa8, 0x0a, 0x19})
+	}
+
+	testcases := map[string]struct {
+		in  []byte
+		err error
+	}{
+		"request": {
+			in: request(OpRequest, src),
+		},
+		"unknown opcode": {
+			in:  request(9, src),
+			err: ErrInvalidARPPacket,
+		},
+		"multicast sender": {
+			in:  request(OpReply, Broadcast),
+			err: ErrInvalidARPPacket,
+		},
+		"another ethertype": {
+			in:  concat(Broadcast, src, []byte{0x08, 0x00}, make([]byte, 28)),
+			err: ErrNotARP,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			eth := &EthernetFrame{}
+			require.NoError(t, eth.UnmarshalBinary(tc.in))
+
+			pkt, err := eth.ExtractARPPacketStrict()
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				assert.Nil(t, pkt)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, OpRequest, pkt.OpCode)
+		})
+	}
+
+	// the violations are malformations in the taxonomy of the decoders
+	eth := &EthernetFrame{}
+	require.NoError(t, eth.UnmarshalBinary(request(9, src)))
+
+	_, err := eth.ExtractARPPacketStrict()
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestEthernetFrameDetach(t *testing.T) {
+	t.Parallel()
+
+	buf := make([]byte, len(arpFrame))
+	copy(buf, arpFrame)
+
+	frame := &EthernetFrame{}
+	require.NoError(t, frame.UnmarshalBinary(buf))
+	assert.Same(t, frame, frame.Detach())
+
+	clear(buf)
+
+	assert.Equal(t, net.HardwareAddr(arpFrame[0:6]), frame.DstMAC)
+	assert.Equal(t, net.HardwareAddr(arpFrame[6:12]), frame.SrcMAC)
+	assert.Equal(t, arpFrame[14:], frame.Payload)
+
+	// a field grown by its owner must not spill into the next one
+	frame.DstMAC = append(frame.DstMAC, 0xff)
+	assert.Equal(t, net.HardwareAddr(arpFrame[6:12]), frame.SrcMAC)
+}
+
+// TestEthernetFrameDetachBufferReuse reuses a single buffer for every frame,
+// as a capture loop does, while the detached frames are read by another
+// goroutine. It is meant to be run with the race detector.
+func TestEthernetFrameDetachBufferReuse(t *testing.T) {
+	defer leak.Check(t)()
+
+	const frames = 1000
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x00}
+	buf := make([]byte, 64)
+	kept := make(chan *EthernetFrame, 16)
+
+	var (
+		wg   sync.WaitGroup
+		errs []string
+	)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; ; i++ {
+			frame, ok := <-kept
+			if !ok {
+				return
+			}
+
+			want := byte(i)
+			if frame.SrcMAC[5] != want || !bytes.Equal(frame.Payload, bytes.Repeat([]byte{want}, 50)) {
+				errs = append(errs, frame.SrcMAC.String())
+			}
+		}
+	}()
+
+	frame := &EthernetFrame{}
+
+	for i := 0; i < frames; i++ {
+		copy(buf, Broadcast)
+		copy(buf[6:], src)
+		buf[11] = byte(i)
+		buf[12], buf[13] = 0x08, 0x00
+
+		for j := 14; j < len(buf); j++ {
+			buf[j] = byte(i)
+		}
+
+		require.NoError(t, frame.UnmarshalBinary(buf))
+
+		kept <- frame.Detach()
+
+		// the next frame must not be decoded into the one handed over
+		frame = &EthernetFrame{}
+	}
+
+	close(kept)
+	wg.Wait()
+
+	assert.Empty(t, errs)
+}
+
 func TestEthernetFrameMarshalBinary(t *testing.T) {
 	testcases := map[string]struct {
 		in  *EthernetFrame
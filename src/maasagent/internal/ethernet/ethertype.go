@@ -0,0 +1,116 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidEtherType is returned when parsing a string which is neither
+// the name of a known ethertype nor a 16 bits hexadecimal number
+var ErrInvalidEtherType = errors.New("invalid ethertype")
+
+var ethernetTypeNames = map[EthernetType]string{
+	EthernetTypeLLC:            "LLC",
+	EthernetTypeIPv4:           "IPv4",
+	EthernetTypeARP:            "ARP",
+	EthernetTypeWakeOnLAN:      "WakeOnLAN",
+	EthernetTypeTEB:            "TEB",
+	EthernetTypeRARP:           "RARP",
+	EthernetTypeVLAN:           "VLAN",
+	EthernetTypeIPv6:           "IPv6",
+	EthernetTypeMPLS:           "MPLS",
+	EthernetTypeMPLSMulticast:  "MPLSMulticast",
+	EthernetTypePPPoEDiscovery: "PPPoEDiscovery",
+	EthernetTypePPPoESession:   "PPPoESession",
+	EthernetTypeEAPOL:          "EAPOL",
+	EthernetTypeQinQ:           "QinQ",
+	EthernetTypeQinQLegacy:     "QinQLegacy",
+	EthernetTypeSlowProtocols:  "SlowProtocols",
+	EthernetTypeLLDP:           "LLDP",
+	EthernetTypeMACsec:         "MACsec",
+	EthernetTypePTP:            "PTP",
+	EthernetTypeCFM:            "CFM",
+}
+
+// ethernetTypeAliases are the other names ParseEtherType accepts, those of
+// the standards defining the types
+var ethernetTypeAliases = map[string]EthernetType{
+	"802.1q":  EthernetTypeVLAN,
+	"802.1ad": EthernetTypeQinQ,
+	"802.1x":  EthernetTypeEAPOL,
+	"802.1ab": EthernetTypeLLDP,
+	"802.1ae": EthernetTypeMACsec,
+	"802.1ag": EthernetTypeCFM,
+	"802.3ad": EthernetTypeSlowProtocols,
+}
+
+// String returns the name of the ethertype, or its value in hexadecimal
+// when it has none
+func (t EthernetType) String() string {
+	if name, ok := ethernetTypeNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("0x%04x", uint16(t))
+}
+
+// MarshalText implements encoding.TextMarshaler for EthernetType
+func (t EthernetType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for EthernetType, it
+// accepts what ParseEtherType does
+func (t *EthernetType) UnmarshalText(text []byte) error {
+	parsed, err := ParseEtherType(string(text))
+	if err != nil {
+		return err
+	}
+
+	*t = parsed
+
+	return nil
+}
+
+// ParseEtherType returns the ethertype named s, case insensitively, as
+// String names it or by the standard defining it, such as 802.1Q. Any
+// other ethertype is given in hexadecimal, with or without 0x.
+func ParseEtherType(s string) (EthernetType, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+
+	for t, known := range ethernetTypeNames {
+		if strings.ToLower(known) == name {
+			return t, nil
+		}
+	}
+
+	if t, ok := ethernetTypeAliases[name]; ok {
+		return t, nil
+	}
+
+	digits := strings.TrimPrefix(name, "0x")
+
+	v, err := strconv.ParseUint(digits, 16, 16)
+	if err != nil || digits == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidEtherType, s)
+	}
+
+	return EthernetType(v), nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthernetTypeString(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  EthernetType
+		out string
+	}{
+		"known": {
+			in:  EthernetTypeLLDP,
+			out: "LLDP",
+		},
+		"LLC": {
+			in:  EthernetTypeLLC,
+			out: "LLC",
+		},
+		"unknown": {
+			in:  0x88b5,
+			out: "0x88b5",
+		},
+		"unknown padded": {
+			in:  0x0600,
+			out: "0x0600",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.in.String())
+		})
+	}
+}
+
+func TestEthernetTypeNames(t *testing.T) {
+	t.Parallel()
+
+	// every name round-trips, so none shadows another or a hex value
+	for typ, name := range ethernetTypeNames {
+		parsed, err := ParseEtherType(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, typ, parsed, name)
+	}
+}
+
+func TestParseEtherType(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  string
+		out EthernetType
+		err error
+	}{
+		"name": {
+			in:  "IPv6",
+			out: EthernetTypeIPv6,
+		},
+		"name case insensitive": {
+			in:  "eapol",
+			out: EthernetTypeEAPOL,
+		},
+		"standard": {
+			in:  "802.1Q",
+			out: EthernetTypeVLAN,
+		},
+		"hex": {
+			in:  "0x88B5",
+			out: 0x88b5,
+		},
+		"hex without prefix": {
+			in:  "88f7",
+			out: EthernetTypePTP,
+		},
+		"surrounding spaces": {
+			in:  " ARP ",
+			out: EthernetTypeARP,
+		},
+		"empty": {
+			err: ErrInvalidEtherType,
+		},
+		"prefix only": {
+			in:  "0x",
+			err: ErrInvalidEtherType,
+		},
+		"too large": {
+			in:  "0x10000",
+			err: ErrInvalidEtherType,
+		},
+		"unknown name": {
+			in:  "IPX",
+			err: ErrInvalidEtherType,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := ParseEtherType(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestEthernetTypeJSON(t *testing.T) {
+	t.Parallel()
+
+	in := []EthernetType{EthernetTypeARP, EthernetTypeQinQ, 0x88b5}
+
+	data, err := json.Marshal(in)
+	require.NoError(t, err)
+	assert.JSONEq(t, `["ARP", "QinQ", "0x88b5"]`, string(data))
+
+	var out []EthernetType
+
+	require.NoError(t, json.Unmarshal(data, &out))
+	assert.Equal(t, in, out)
+
+	assert.ErrorIs(t, json.Unmarshal([]byte(`["IPX"]`), &out), ErrInvalidEtherType)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+// arpFrame is an ARP request for 192.168.10.25 from 192.168.10.26
+var arpFrame = []byte{
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x08, 0x06, 0x00, 0x01,
+	0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0xc0, 0xa8, 0x0a, 0x1a,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19,
+}
+
+func FuzzEthernetFrame(f *testing.F) {
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	ip := netip.MustParseAddr("192.168.10.26")
+
+	for _, seed := range [][]byte{
+		arpFrame,
+		mustBuild(f, NewFrame().Src(src).VLAN(100).ARPRequest(ip, ip)),
+		mustBuild(f, NewFrame().Src(src).VLAN(100, WithTPID(0x88a8)).VLAN(2).ARPRequest(ip, ip)),
+		mustBuild(f, NewFrame().Src(src).DHCPDiscover(1)),
+		lacpFrame,
+		mustBuild(f, NewFrame().Src(src).Dst(lacpFrame[:6]).VLAN(10).
+			Payload(EthernetTypeSlowProtocols, lacpFrame[14:])),
+		mustBuild(f, NewFrame().Src(src).Dst(net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x35}).
+			Payload(EthernetTypeCFM, []byte{0xa0, 0x01, 0x04, 0x46, 0x00, 0x00, 0x00, 0x2a, 0x00, 0x65, 0x04, 0x03, 'm', 'd', '1'})),
+		arpFrame[:14],
+		{},
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			var frame EthernetFrame
+
+			if err := frame.UnmarshalBinary(in); err != nil {
+				return
+			}
+
+			// every extraction is attempted whatever the ethertype,
+			// they must reject the payloads that don't match
+			_, _ = frame.ExtractVLAN() //nolint:errcheck // only panics matter
+
+			if pkt, err := frame.ExtractARPPacket(); err == nil {
+				_ = pkt.SenderAddr()
+				_ = pkt.TargetAddr()
+				_ = pkt.Validate(ValidationStrict, &frame)
+			}
+
+			// validated views must never read out of bounds
+			if view, err := frame.ARPView(); err == nil {
+				_ = view.SenderHardwareAddr()
+				_ = view.SenderAddr()
+				_ = view.TargetHardwareAddr()
+				_ = view.TargetAddr()
+			}
+
+			if view, err := frame.IPv4View(); err == nil {
+				_ = view.Options()
+				_ = view.Payload()
+				_ = view.ChecksumValid()
+			}
+
+			_, _ = frame.ExtractLACP() //nolint:errcheck // only panics matter
+			_, _ = frame.ExtractCFM()  //nolint:errcheck // only panics matter
+			_ = frame.Validate(ValidationStrict)
+		})
+	})
+}
+
+func FuzzVLAN(f *testing.F) {
+	f.Add([]byte{0x00, 0x64, 0x08, 0x06})
+	f.Add([]byte{0xe0, 0x02})
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			var vlan VLAN
+
+			if err := vlan.UnmarshalBinary(in); err == nil && vlan.ID > 0x0fff {
+				t.Errorf("VLAN ID %d out of range", vlan.ID)
+			}
+		})
+	})
+}
+
+func FuzzARPPacket(f *testing.F) {
+	f.Add(arpFrame[14:])
+	f.Add(arpFrame[14:20])
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			var pkt ARPPacket
+
+			if err := pkt.UnmarshalBinary(in); err != nil {
+				return
+			}
+
+			_ = pkt.SenderAddr()
+			_ = pkt.TargetAddr()
+			_ = pkt.Validate(ValidationStrict, nil)
+		})
+	})
+}
+
+func FuzzLACPPacket(f *testing.F) {
+	f.Add(lacpFrame[14:])
+	f.Add(lacpFrame[14:40])
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			var pkt LACPPacket
+
+			if err := pkt.UnmarshalBinary(in); err == nil {
+				_ = pkt.Actor.State.String()
+			}
+		})
+	})
+}
+
+func FuzzCFMPacket(f *testing.F) {
+	f.Add([]byte{0xa0, 0x01, 0x04, 0x46, 0x00, 0x00, 0x00, 0x2a, 0x00, 0x65, 0x04, 0x03, 'm', 'd', '1'})
+	f.Add([]byte{0x23, 0x03, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x00})
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			var pkt CFMPacket
+
+			if err := pkt.UnmarshalBinary(in); err == nil {
+				_ = pkt.OpCode.String()
+			}
+		})
+	})
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+const (
+	// vlanIDMask keeps the VLAN ID of a tag control field, without the
+	// priority and drop eligibility
+	vlanIDMask = 0x0fff
+
+	// the FNV-1a parameters, the hashes are persisted and must not change
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// fnv64 is a 64-bit FNV-1a hash, implemented here for the hashing not to
+// allocate
+type fnv64 uint64
+
+func newFNV64() fnv64 {
+	return fnvOffset64
+}
+
+func (h fnv64) write(b []byte) fnv64 {
+	for _, c := range b {
+		h ^= fnv64(c)
+		h *= fnvPrime64
+	}
+
+	return h
+}
+
+func (h fnv64) writeUint16(v uint16) fnv64 {
+	return h.write(binary.BigEndian.AppendUint16(nil, v))
+}
+
+// writeMAC writes a MAC as MarshalBinary does, in 6 bytes
+func (h fnv64) writeMAC(mac net.HardwareAddr) fnv64 {
+	var buf [6]byte
+
+	copy(buf[:], mac)
+
+	return h.write(buf[:])
+}
+
+// typeField returns the ethertype, or the length of an 802.3 frame, as on
+// the wire
+func (e *EthernetFrame) typeField() uint16 {
+	if e.EthernetType == EthernetTypeLLC {
+		return e.Len
+	}
+
+	return uint16(e.EthernetType)
+}
+
+// canonicalPayload returns the payload without the bytes following the
+// ARP, IPv4 or IPv6 packet it carries, such as the padding to the minimum
+// frame size or an FCS. The payload of the other types is kept whole, but
+// for the truncation of an 802.3 frame to its length by UnmarshalBinary.
+func (e *EthernetFrame) canonicalPayload() []byte {
+	ethType, inner := e.untagged()
+	tags := len(e.Payload) - len(inner)
+	n, _ := packetLen(ethType, inner)
+
+	return e.Payload[:tags+n]
+}
+
+// packetLen returns the length of the ARP, IPv4 or IPv6 packet at the start
+// of buf and true, or the length of buf and false when the packet is
+// malformed or of another type
+func packetLen(ethType EthernetType, buf []byte) (int, bool) {
+	switch ethType {
+	case EthernetTypeARP:
+		if len(buf) >= arpHeaderLen {
+			if n := arpHeaderLen + 2*(int(buf[4])+int(buf[5])); n <= len(buf) {
+				return n, true
+			}
+		}
+	case EthernetTypeIPv4:
+		if len(buf) >= ipv4HeaderLen {
+			if n := int(binary.BigEndian.Uint16(buf[2:4])); n >= ipv4HeaderLen && n <= len(buf) {
+				return n, true
+			}
+		}
+	case EthernetTypeIPv6:
+		// a payload length of 0 is a jumbogram, whose length is in an
+		// extension header
+		if len(buf) >= ipv6HeaderLen {
+			if plen := int(binary.BigEndian.Uint16(buf[4:6])); plen > 0 && ipv6HeaderLen+plen <= len(buf) {
+				return ipv6HeaderLen + plen, true
+			}
+		}
+	}
+
+	return len(buf), false
+}
+
+// Hash returns a hash of the frame as on the wire, the padding following
+// an ARP, IPv4 or IPv6 packet and an FCS excluded, so that the frames
+// Equal hash the same. The hash is FNV-1a, it is stable across processes
+// and can be persisted.
+func (e *EthernetFrame) Hash() uint64 {
+	h := newFNV64().writeMAC(e.DstMAC).writeMAC(e.SrcMAC).writeUint16(e.typeField())
+
+	return uint64(h.write(e.canonicalPayload()))
+}
+
+// Equal returns true if both frames have the same content, ignoring the
+// padding and FCS Hash ignores, whatever buffers they alias
+func (e *EthernetFrame) Equal(other *EthernetFrame) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+
+	return e.typeField() == other.typeField() &&
+		bytes.Equal(e.DstMAC, other.DstMAC) &&
+		bytes.Equal(e.SrcMAC, other.SrcMAC) &&
+		bytes.Equal(e.canonicalPayload(), other.canonicalPayload())
+}
+
+// DedupKey returns a hash of the frame which ignores what differs between
+// the retransmissions of a packet, for them to be told apart from new
+// packets:
+//   - the priority and drop eligibility of the VLAN tags, which a switch
+//     may rewrite
+//   - for ARP, everything but the address lengths, the operation and the
+//     addresses, which ignores the hardware and protocol types
+//   - for IPv4, the identification, the TTL and the header checksum
+//   - for IPv6, the hop limit
+//   - the padding and FCS ignored by Hash
+//
+// The key of a malformed packet covers it whole. Like Hash, the key is
+// stable across processes.
+func (e *EthernetFrame) DedupKey() uint64 {
+	h := newFNV64().writeMAC(e.DstMAC).writeMAC(e.SrcMAC).writeUint16(e.typeField())
+	ethType, buf := e.EthernetType, e.Payload
+
+	for IsTPID(ethType) && len(buf) >= vlanTagLen {
+		h = h.writeUint16(binary.BigEndian.Uint16(buf[0:2]) & vlanIDMask).write(buf[2:4])
+		ethType = EthernetType(binary.BigEndian.Uint16(buf[2:4]))
+		buf = buf[vlanTagLen:]
+	}
+
+	n, ok := packetLen(ethType, buf)
+	if !ok {
+		return uint64(h.write(buf))
+	}
+
+	switch ethType {
+	case EthernetTypeARP:
+		h = h.write(buf[4:n])
+	case EthernetTypeIPv4:
+		h = h.write(buf[0:4]).write(buf[6:8]).write(buf[9:10]).write(buf[12:n])
+	case EthernetTypeIPv6:
+		h = h.write(buf[0:7]).write(buf[8:n])
+	}
+
+	return uint64(h)
+}
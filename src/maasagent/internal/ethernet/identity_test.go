@@ -0,0 +1,317 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	identitySrc = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	identityIP  = netip.MustParseAddr("10.0.0.1")
+	identityTgt = netip.MustParseAddr("10.0.0.2")
+)
+
+// mustParse returns the frame of buf, which it aliases
+func mustParse(tb testing.TB, buf []byte) *EthernetFrame {
+	tb.Helper()
+
+	frame := &EthernetFrame{}
+	require.NoError(tb, frame.UnmarshalBinary(buf))
+
+	return frame
+}
+
+// udpFrame returns an untagged IPv4 UDP frame
+func udpFrame(tb testing.TB, data string) []byte {
+	tb.Helper()
+
+	return mustBuild(tb, NewFrame().Src(identitySrc).Padded().UDP(netip.AddrPortFrom(identityIP, 67),
+		netip.AddrPortFrom(identityTgt, 68), []byte(data)))
+}
+
+// edit returns a copy of buf changed by f
+func edit(buf []byte, f func([]byte)) []byte {
+	out := append([]byte(nil), buf...)
+	f(out)
+
+	return out
+}
+
+func TestEthernetFrameHashWire(t *testing.T) {
+	t.Parallel()
+
+	arp := mustBuild(t, NewFrame().Src(identitySrc).ARPRequest(identityIP, identityTgt))
+
+	// the hash is FNV-1a of the frame as on the wire
+	h := fnv.New64a()
+	_, err := h.Write(arp)
+	require.NoError(t, err)
+
+	frame := mustParse(t, arp)
+	assert.Equal(t, h.Sum64(), frame.Hash())
+
+	// pinned, persisted hashes must stay valid across releases
+	assert.Equal(t, uint64(0x5c347a37f5aae2b6), frame.Hash())
+}
+
+func TestEthernetFrameHashEqual(t *testing.T) {
+	t.Parallel()
+
+	arp := mustBuild(t, NewFrame().Src(identitySrc).ARPRequest(identityIP, identityTgt))
+	udp := udpFrame(t, "payload")
+	ns := mustBuild(t, NewFrame().Src(identitySrc).NeighborSolicitation(netip.MustParseAddr("fe80::1"),
+		netip.MustParseAddr("fe80::2")))
+	fcs := []byte{0xde, 0xad, 0xbe, 0xef}
+
+	testcases := map[string]struct {
+		a, b  []byte
+		equal bool
+	}{
+		"same": {
+			a: arp, b: arp, equal: true,
+		},
+		"ARP padded": {
+			a:     arp,
+			b:     mustBuild(t, NewFrame().Src(identitySrc).Padded().ARPRequest(identityIP, identityTgt)),
+			equal: true,
+		},
+		"ARP with an FCS": {
+			a: arp, b: append(append([]byte(nil), arp...), fcs...), equal: true,
+		},
+		"tagged ARP padded": {
+			a:     mustBuild(t, NewFrame().Src(identitySrc).VLAN(2).ARPRequest(identityIP, identityTgt)),
+			b:     mustBuild(t, NewFrame().Src(identitySrc).VLAN(2).Padded().ARPRequest(identityIP, identityTgt)),
+			equal: true,
+		},
+		"IPv4 with an FCS": {
+			a: udp, b: append(append([]byte(nil), udp...), fcs...), equal: true,
+		},
+		"IPv6 with an FCS": {
+			a: ns, b: append(append([]byte(nil), ns...), fcs...), equal: true,
+		},
+		"other source": {
+			a: arp, b: edit(arp, func(b []byte) { b[11] ^= 1 }),
+		},
+		"other destination": {
+			a: arp, b: edit(arp, func(b []byte) { b[0] ^= 1 }),
+		},
+		"other ethertype": {
+			a: arp, b: edit(arp, func(b []byte) { b[13] ^= 1 }),
+		},
+		"other target": {
+			a: arp, b: mustBuild(t, NewFrame().Src(identitySrc).ARPRequest(identityIP, identityIP)),
+		},
+		"other VLAN": {
+			a: mustBuild(t, NewFrame().Src(identitySrc).VLAN(2).ARPRequest(identityIP, identityTgt)),
+			b: mustBuild(t, NewFrame().Src(identitySrc).VLAN(3).ARPRequest(identityIP, identityTgt)),
+		},
+		"other priority": {
+			a: mustBuild(t, NewFrame().Src(identitySrc).VLAN(2).ARPRequest(identityIP, identityTgt)),
+			b: mustBuild(t, NewFrame().Src(identitySrc).VLAN(2, WithPriority(5)).ARPRequest(identityIP, identityTgt)),
+		},
+		"other UDP payload": {
+			a: udp, b: udpFrame(t, "payloaD"),
+		},
+		// the trailing bytes of the other types are content
+		"other type with an FCS": {
+			a: lacpFrame, b: append(append([]byte(nil), lacpFrame...), fcs...),
+		},
+		// a priority tag isn't the absence of one
+		"tagged and untagged": {
+			a: arp, b: mustBuild(t, NewFrame().Src(identitySrc).VLAN(0).ARPRequest(identityIP, identityTgt)),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			a, b := mustParse(t, tc.a), mustParse(t, tc.b)
+
+			assert.Equal(t, tc.equal, a.Equal(b))
+			assert.Equal(t, tc.equal, b.Equal(a))
+			assert.Equal(t, tc.equal, a.Hash() == b.Hash())
+		})
+	}
+}
+
+func TestEthernetFrameEqualBuffers(t *testing.T) {
+	t.Parallel()
+
+	buf := mustBuild(t, NewFrame().Src(identitySrc).ARPRequest(identityIP, identityTgt))
+	frame := mustParse(t, buf)
+	detached := mustParse(t, buf).Detach()
+
+	assert.True(t, frame.Equal(detached))
+	assert.Equal(t, frame.Hash(), detached.Hash())
+
+	// the frame changes with its buffer, the detached one doesn't
+	buf[11] ^= 1
+
+	assert.False(t, frame.Equal(detached))
+
+	var nilFrame *EthernetFrame
+
+	assert.True(t, nilFrame.Equal(nil))
+	assert.False(t, nilFrame.Equal(frame))
+	assert.False(t, frame.Equal(nil))
+}
+
+// TestEthernetFrameHashFields checks moving bytes from a field to the next
+// doesn't collide, the fields are hashed at fixed offsets
+func TestEthernetFrameHashFields(t *testing.T) {
+	t.Parallel()
+
+	a := &EthernetFrame{
+		DstMAC:       net.HardwareAddr{1, 2, 3, 4, 5},
+		SrcMAC:       net.HardwareAddr{6, 7, 8, 9, 10, 11, 12},
+		EthernetType: EthernetTypeIPv4,
+	}
+	b := &EthernetFrame{
+		DstMAC:       net.HardwareAddr{1, 2, 3, 4, 5, 6},
+		SrcMAC:       net.HardwareAddr{7, 8, 9, 10, 11, 12},
+		EthernetType: EthernetTypeIPv4,
+	}
+
+	assert.NotEqual(t, a.Hash(), b.Hash())
+	assert.NotEqual(t, a.DedupKey(), b.DedupKey())
+}
+
+// TestEthernetFrameHashCollisions hashes frames differing by a few bits,
+// none of them may collide
+func TestEthernetFrameHashCollisions(t *testing.T) {
+	t.Parallel()
+
+	hashes := make(map[uint64]netip.Addr)
+	keys := make(map[uint64]netip.Addr)
+
+	ip := netip.MustParseAddr("10.0.0.0")
+
+	for range 1 << 16 {
+		frame := mustParse(t, mustBuild(t, NewFrame().Src(identitySrc).Padded().ARPRequest(ip, identityTgt)))
+
+		if other, ok := hashes[frame.Hash()]; ok {
+			t.Fatalf("the hashes of %s and %s collide", ip, other)
+		}
+
+		if other, ok := keys[frame.DedupKey()]; ok {
+			t.Fatalf("the dedup keys of %s and %s collide", ip, other)
+		}
+
+		hashes[frame.Hash()], keys[frame.DedupKey()] = ip, ip
+		ip = ip.Next()
+	}
+}
+
+func TestEthernetFrameDedupKey(t *testing.T) {
+	t.Parallel()
+
+	arp := mustBuild(t, NewFrame().Src(identitySrc).VLAN(2).ARPRequest(identityIP, identityTgt))
+	udp := udpFrame(t, "payload")
+	ns := mustBuild(t, NewFrame().Src(identitySrc).NeighborSolicitation(netip.MustParseAddr("fe80::1"),
+		netip.MustParseAddr("fe80::2")))
+
+	const ipv4 = 14
+
+	testcases := map[string]struct {
+		a, b []byte
+		same bool
+	}{
+		"ARP padded": {
+			a:    arp,
+			b:    mustBuild(t, NewFrame().Src(identitySrc).VLAN(2).Padded().ARPRequest(identityIP, identityTgt)),
+			same: true,
+		},
+		"VLAN priority": {
+			a: arp,
+			b: mustBuild(t, NewFrame().Src(identitySrc).VLAN(2, WithPriority(5), WithDropEligible()).
+				ARPRequest(identityIP, identityTgt)),
+			same: true,
+		},
+		"ARP hardware type": {
+			a:    arp,
+			b:    edit(arp, func(b []byte) { b[19] = byte(HardwareTypeExpEth) }),
+			same: true,
+		},
+		"IPv4 identification, TTL and checksum": {
+			a: udp,
+			b: edit(udp, func(b []byte) {
+				binary.BigEndian.PutUint16(b[ipv4+4:], 0x1234)
+				b[ipv4+8] = 63
+				binary.BigEndian.PutUint16(b[ipv4+10:], 0xbeef)
+			}),
+			same: true,
+		},
+		"IPv6 hop limit": {
+			a: ns, b: edit(ns, func(b []byte) { b[14+7] = 64 }), same: true,
+		},
+		"VLAN": {
+			a: arp,
+			b: mustBuild(t, NewFrame().Src(identitySrc).VLAN(3).ARPRequest(identityIP, identityTgt)),
+		},
+		"ARP operation": {
+			a: arp, b: edit(arp, func(b []byte) { b[25] = byte(OpReply) }),
+		},
+		"ARP target": {
+			a: arp,
+			b: mustBuild(t, NewFrame().Src(identitySrc).VLAN(2).ARPRequest(identityIP, identityIP)),
+		},
+		"IPv4 protocol": {
+			a: udp, b: edit(udp, func(b []byte) { b[ipv4+9] = 6 }),
+		},
+		"IPv4 payload": {
+			a: udp, b: udpFrame(t, "payloaD"),
+		},
+		"IPv6 flow label": {
+			a: ns, b: edit(ns, func(b []byte) { b[14+3] = 1 }),
+		},
+		// a malformed packet is keyed whole
+		"malformed IPv4": {
+			a: edit(udp, func(b []byte) { binary.BigEndian.PutUint16(b[ipv4+2:], 0xffff) }),
+			b: edit(udp, func(b []byte) {
+				binary.BigEndian.PutUint16(b[ipv4+2:], 0xffff)
+				b[ipv4+8] = 63
+			}),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			a, b := mustParse(t, tc.a), mustParse(t, tc.b)
+
+			assert.Equal(t, tc.same, a.DedupKey() == b.DedupKey())
+
+			// the retransmissions differ by more than their padding
+			if tc.same && name != "ARP padded" {
+				assert.NotEqual(t, a.Hash(), b.Hash())
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+const (
+	// SlowProtocolLACP is the subtype of LACP within the slow protocols
+	SlowProtocolLACP = 1
+
+	lacpTLVActor   = 1
+	lacpTLVPartner = 2
+	lacpInfoLen    = 20
+	// minLACPLen covers the header and the actor and partner TLVs, the
+	// collector TLV and the trailing padding aren't decoded
+	minLACPLen = 2 + 2*lacpInfoLen
+)
+
+var (
+	// ErrNotLACP is an error returned when calling EthernetFrame.ExtractLACP
+	// if the frame is not an LACPDU
+	ErrNotLACP = errors.New("ethernet frame not of type LACP")
+	// ErrMalformedLACP is an error returned when parsing a malformed LACPDU
+	ErrMalformedLACP = errors.New("malformed LACP packet")
+
+	errNotLACPSubtype error = unsupported("slow protocols", "subtype", ErrNotLACP)
+	errTruncatedLACP  error = truncated("LACP", "LACPDU", ErrMalformedLACP)
+	errLACPTLV        error = malformed("LACP", "TLV", ErrMalformedLACP)
+)
+
+// LACPState holds the state bits of an LACP port
+type LACPState uint8
+
+const (
+	// LACPStateActivity is set for an active port, clear for a passive one
+	LACPStateActivity LACPState = 1 << iota
+	// LACPStateTimeout is set when the port requests a short timeout
+	LACPStateTimeout
+	// LACPStateAggregation is set when the port may be aggregated
+	LACPStateAggregation
+	// LACPStateSynchronization is set when the port is attached to the
+	// right aggregator
+	LACPStateSynchronization
+	// LACPStateCollecting is set when the port receives frames of the LAG
+	LACPStateCollecting
+	// LACPStateDistributing is set when the port sends frames of the LAG
+	LACPStateDistributing
+	// LACPStateDefaulted is set when the port uses default partner values,
+	// as it received no LACPDU from its partner
+	LACPStateDefaulted
+	// LACPStateExpired is set when the partner information timed out
+	LACPStateExpired
+)
+
+var lacpStateNames = []string{
+	"Activity", "Timeout", "Aggregation", "Synchronization",
+	"Collecting", "Distributing", "Defaulted", "Expired",
+}
+
+// Has returns true when all the bits of flag are set
+func (s LACPState) Has(flag LACPState) bool {
+	return s&flag == flag
+}
+
+// String returns the names of the bits set, separated by '|'
+func (s LACPState) String() string {
+	var names []string
+
+	for i, name := range lacpStateNames {
+		if s&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return strings.Join(names, "|")
+}
+
+// LACPPortInfo describes one end of an LACP link
+type LACPPortInfo struct {
+	// System is the MAC address identifying the system
+	System         net.HardwareAddr
+	SystemPriority uint16
+	// Key identifies the LAG on the system, ports with the same key can
+	// be aggregated together
+	Key          uint16
+	PortPriority uint16
+	Port         uint16
+	State        LACPState
+}
+
+func (i *LACPPortInfo) unmarshalBinary(buf []byte) {
+	i.SystemPriority = binary.BigEndian.Uint16(buf[2:4])
+	i.System = net.HardwareAddr(buf[4:10])
+	i.Key = binary.BigEndian.Uint16(buf[10:12])
+	i.PortPriority = binary.BigEndian.Uint16(buf[12:14])
+	i.Port = binary.BigEndian.Uint16(buf[14:16])
+	i.State = LACPState(buf[16])
+}
+
+// LACPPacket is an LACPDU, as defined by IEEE 802.1AX
+type LACPPacket struct {
+	// Actor is the sender of the LACPDU
+	Actor LACPPortInfo
+	// Partner is what the sender knows about the other end of the link
+	Partner LACPPortInfo
+	Version uint8
+}
+
+// UnmarshalBinary parses the payload of a slow protocols frame into an
+// LACPPacket
+func (l *LACPPacket) UnmarshalBinary(buf []byte) error {
+	if len(buf) < minLACPLen {
+		return errTruncatedLACP
+	}
+
+	if buf[0] != SlowProtocolLACP {
+		return errNotLACPSubtype
+	}
+
+	actor, partner := buf[2:2+lacpInfoLen], buf[2+lacpInfoLen:2+2*lacpInfoLen]
+
+	if actor[0] != lacpTLVActor || actor[1] != lacpInfoLen ||
+		partner[0] != lacpTLVPartner || partner[1] != lacpInfoLen {
+		return errLACPTLV
+	}
+
+	l.Version = buf[1]
+	l.Actor.unmarshalBinary(actor)
+	l.Partner.unmarshalBinary(partner)
+
+	return nil
+}
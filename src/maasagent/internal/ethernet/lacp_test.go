@@ -0,0 +1,178 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lacpFrame is an LACPDU sent by a switch whose port is collecting and
+// distributing as part of a LAG with the host 52:54:00:12:34:56
+var lacpFrame = []byte{
+	0x01, 0x80, 0xc2, 0x00, 0x00, 0x02, 0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x01, 0x88, 0x09,
+	0x01, 0x01,
+	0x01, 0x14, 0x80, 0x00, 0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x00, 0x00, 0x0a, 0x80, 0x00, 0x00, 0x11,
+	0x3d, 0x00, 0x00, 0x00,
+	0x02, 0x14, 0xff, 0xff, 0x52, 0x54, 0x00, 0x12, 0x34, 0x56, 0x00, 0x09, 0x00, 0xff, 0x00, 0x01,
+	0x3f, 0x00, 0x00, 0x00,
+	0x03, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00,
+}
+
+func TestLACPStateString(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  LACPState
+		out string
+	}{
+		"none": {
+			in: 0,
+		},
+		"collecting distributing": {
+			in:  0x3d,
+			out: "Activity|Aggregation|Synchronization|Collecting|Distributing",
+		},
+		"defaulted expired": {
+			in:  LACPStateDefaulted | LACPStateExpired,
+			out: "Defaulted|Expired",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.in.String())
+		})
+	}
+}
+
+func TestLACPUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []byte
+		out *LACPPacket
+		err error
+	}{
+		"LACPDU": {
+			in: lacpFrame[14:],
+			out: &LACPPacket{
+				Version: 1,
+				Actor: LACPPortInfo{
+					System:         net.HardwareAddr{0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x00},
+					SystemPriority: 32768,
+					Key:            10,
+					PortPriority:   32768,
+					Port:           17,
+					State:          0x3d,
+				},
+				Partner: LACPPortInfo{
+					System:         net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56},
+					SystemPriority: 65535,
+					Key:            9,
+					PortPriority:   255,
+					Port:           1,
+					State:          0x3f,
+				},
+			},
+		},
+		"marker protocol": {
+			in:  append([]byte{0x02}, lacpFrame[15:]...),
+			err: ErrNotLACP,
+		},
+		"truncated": {
+			in:  lacpFrame[14:50],
+			err: ErrMalformedLACP,
+		},
+		"bad partner TLV": {
+			in:  append(append([]byte{}, lacpFrame[14:36]...), make([]byte, 40)...),
+			err: ErrMalformedLACP,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pkt := &LACPPacket{}
+
+			err := pkt.UnmarshalBinary(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+
+			if err == nil {
+				assert.Equal(t, tc.out, pkt)
+				assert.True(t, pkt.Actor.State.Has(LACPStateAggregation|LACPStateSynchronization))
+			}
+		})
+	}
+}
+
+func TestEthernetFrameExtractLACP(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []byte
+		err error
+	}{
+		"slow protocols": {
+			in: lacpFrame,
+		},
+		"QinQ tagged": {
+			in: mustBuild(t, NewFrame().Src(lacpFrame[6:12]).Dst(lacpFrame[:6]).
+				Tags(Tag{TPID: EthernetTypeQinQ, VID: 100}, Tag{VID: 10}).
+				Payload(EthernetTypeSlowProtocols, lacpFrame[14:])),
+		},
+		"ARP": {
+			in: []byte{
+				0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16, 0x08, 0x06, 0x00, 0x01,
+				0x08, 0x00, 0x06, 0x04, 0x00, 0x02, 0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16, 0xc0, 0xa8, 0x01, 0x6c,
+				0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0xc0, 0xa8, 0x01, 0x50,
+			},
+			err: ErrNotLACP,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			eth := &EthernetFrame{}
+
+			err := eth.UnmarshalBinary(tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkt, err := eth.ExtractLACP()
+			assert.ErrorIs(t, err, tc.err)
+
+			if err == nil {
+				assert.Equal(t, uint16(10), pkt.Actor.Key)
+			}
+		})
+	}
+}
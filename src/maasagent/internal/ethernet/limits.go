@@ -0,0 +1,127 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+const (
+	// DefaultOptionLimit bounds the options or TLVs of a packet, an LLDPDU
+	// of TLVs without value fits about that many in a frame
+	DefaultOptionLimit = 512
+	// DefaultExtensionHeaderLimit bounds the IPv6 extension headers walked,
+	// well past the handful a legitimate packet chains
+	DefaultExtensionHeaderLimit = 16
+	// DefaultLabelLimit bounds the labels and compression pointers of a DNS
+	// name, a name of 255 bytes has at most 127 labels
+	DefaultLabelLimit = 128
+	// DefaultFrameBudget bounds the iterations of every loop decoding a
+	// frame, a jumbo frame of one byte options stays within it
+	DefaultFrameBudget = 10000
+)
+
+// DecodeLimits bound the loops of the decoders whose count the input sets,
+// so that a frame crafted to chain thousands of small structures costs no
+// more than a frame of legitimate ones. A decoder reaching a limit returns
+// an error matching ErrDecodeLimitExceeded, as well as the sentinel of its
+// protocol. A zero field keeps its default.
+type DecodeLimits struct {
+	// Tags bounds the VLAN tags followed, MaxTags by default
+	Tags int
+	// Options bounds the options or TLVs of a packet, such as the LLDP
+	// TLVs and the NDP and DHCP options, DefaultOptionLimit by default
+	Options int
+	// ExtensionHeaders bounds the IPv6 extension headers walked,
+	// DefaultExtensionHeaderLimit by default
+	ExtensionHeaders int
+	// Labels bounds the labels of a DNS name, its compression pointers
+	// included, DefaultLabelLimit by default
+	Labels int
+	// Frame bounds the iterations of the loops decoding a frame, whatever
+	// their kind, DefaultFrameBudget by default
+	Frame int
+}
+
+// DecodeLimits returns the limits of the options, their zero fields set to
+// the defaults
+func (o ParserOptions) DecodeLimits() DecodeLimits {
+	l := o.Limits
+	l.Tags = o.tagLimit()
+
+	if l.Options <= 0 {
+		l.Options = DefaultOptionLimit
+	}
+
+	if l.ExtensionHeaders <= 0 {
+		l.ExtensionHeaders = DefaultExtensionHeaderLimit
+	}
+
+	if l.Labels <= 0 {
+		l.Labels = DefaultLabelLimit
+	}
+
+	if l.Frame <= 0 {
+		l.Frame = DefaultFrameBudget
+	}
+
+	return l
+}
+
+// tagLimit returns the Tags of the limits, without the others for the
+// decoders of the tags to stay inlinable
+func (o ParserOptions) tagLimit() int {
+	if o.Limits.Tags <= 0 {
+		return MaxTags
+	}
+
+	return o.Limits.Tags
+}
+
+// DecodeBudget is what is left of the frame budget of DecodeLimits while
+// decoding a frame. The decoders of a frame share one, each iteration of
+// their loops spending a step of it.
+type DecodeBudget struct {
+	limit int
+	spent int
+}
+
+// Budget returns the DecodeBudget of a frame decoded as the options decide
+func (o ParserOptions) Budget() DecodeBudget {
+	return DecodeBudget{limit: o.DecodeLimits().Frame}
+}
+
+// Spend takes a step of the budget, it returns an error matching
+// ErrDecodeLimitExceeded and err, the sentinel of protocol, once the
+// budget is spent
+func (b *DecodeBudget) Spend(protocol string, err error) error {
+	if b.spent >= b.limit {
+		return LimitExceeded(protocol, "frame", err, b.limit, "iterations")
+	}
+
+	b.spent++
+
+	return nil
+}
+
+// Spent returns the steps of the budget taken
+func (b *DecodeBudget) Spent() int {
+	return b.spent
+}
+
+// LimitExceeded returns an error matching ErrDecodeLimitExceeded and err,
+// the sentinel of protocol, for a field holding more than limit of what
+// are counted
+func LimitExceeded(protocol, field string, err error, limit int, what string) error {
+	return (&DecodeError{Kind: ErrDecodeLimitExceeded, Err: err, Protocol: protocol, Field: field}).
+		detailf("more than %d %s", limit, what)
+}
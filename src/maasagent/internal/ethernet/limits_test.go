@@ -0,0 +1,123 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeLimits(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, DecodeLimits{
+		Tags:             MaxTags,
+		Options:          DefaultOptionLimit,
+		ExtensionHeaders: DefaultExtensionHeaderLimit,
+		Labels:           DefaultLabelLimit,
+		Frame:            DefaultFrameBudget,
+	}, ParserOptions{}.DecodeLimits())
+
+	limits := DecodeLimits{Tags: 1, Options: 2, ExtensionHeaders: 3, Labels: 4, Frame: 5}
+	assert.Equal(t, limits, ParserOptions{Limits: limits}.DecodeLimits())
+
+	// the negative limits keep their defaults too
+	assert.Equal(t, ParserOptions{}.DecodeLimits(), ParserOptions{Limits: DecodeLimits{Tags: -1, Frame: -1}}.DecodeLimits())
+}
+
+func TestDecodeBudget(t *testing.T) {
+	t.Parallel()
+
+	errProto := errors.New("malformed proto")
+	budget := ParserOptions{Limits: DecodeLimits{Frame: 3}}.Budget()
+
+	for range 3 {
+		require.NoError(t, budget.Spend("proto", errProto))
+	}
+
+	assert.Equal(t, 3, budget.Spent())
+
+	err := budget.Spend("proto", errProto)
+	require.ErrorIs(t, err, ErrDecodeLimitExceeded)
+	require.ErrorIs(t, err, errProto)
+
+	var de *DecodeError
+
+	require.ErrorAs(t, err, &de)
+	assert.Equal(t, "proto", de.Protocol)
+	assert.Equal(t, "frame", de.Field)
+	assert.Equal(t, "more than 3 iterations", de.Detail)
+	assert.Equal(t, 3, budget.Spent(), "a spent budget stays spent")
+}
+
+func TestTagLimit(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	opts := ParserOptions{Limits: DecodeLimits{Tags: 2}}
+
+	testcases := map[string]struct {
+		tags int
+		err  bool
+	}{
+		"within the limit": {tags: 2},
+		"past the limit":   {tags: 3, err: true},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			b := NewFrame().Src(src)
+			for i := range tc.tags {
+				b.VLAN(uint16(i + 1)) //nolint:gosec // a few tags
+			}
+
+			var frame EthernetFrame
+
+			require.NoError(t, frame.UnmarshalBinary(mustBuild(t, b.ARPRequest(netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.0.2")))))
+
+			tags, _, _, err := frame.AppendTagsWith(nil, opts)
+			pkt, arpErr := frame.ExtractARPPacket(WithParserOptions(opts))
+
+			if !tc.err {
+				require.NoError(t, err)
+				require.NoError(t, arpErr)
+				assert.Len(t, tags, tc.tags)
+				assert.NotNil(t, pkt)
+
+				return
+			}
+
+			for _, err := range []error{err, arpErr} {
+				assert.ErrorIs(t, err, ErrDecodeLimitExceeded)
+				assert.ErrorIs(t, err, ErrMalformedVLAN)
+			}
+
+			// the default limit lets them through
+			_, _, _, err = frame.AppendTags(nil)
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// maxRegistered bounds the ethertypes, and the UDP ports, which can be
+// registered, the capture filters test each of them
+const maxRegistered = 32
+
+var (
+	// ErrProtocolRegistered is returned when registering an ethertype or a
+	// UDP port already registered, or decoded by a built-in protocol
+	ErrProtocolRegistered = errors.New("protocol already registered")
+	// ErrInvalidRegistration is returned when registering a decoder which
+	// can't be consulted, such as for an ethertype that is a length
+	ErrInvalidRegistration = errors.New("invalid protocol registration")
+	// ErrNotRegistered is returned by Registry.Decode for a frame no
+	// registered protocol decodes
+	ErrNotRegistered = errors.New("protocol not registered")
+)
+
+// builtinEtherTypes are the ethertypes decoded by the packages of the
+// agent, such as lldp and ptp, which can't be registered
+var builtinEtherTypes = map[EthernetType]struct{}{
+	EthernetTypeIPv4:          {},
+	EthernetTypeARP:           {},
+	EthernetTypeIPv6:          {},
+	EthernetTypeVLAN:          {},
+	EthernetTypeQinQ:          {},
+	EthernetTypeQinQLegacy:    {},
+	EthernetTypeSlowProtocols: {},
+	EthernetTypeCFM:           {},
+	EthernetTypeWakeOnLAN:     {},
+	EthernetTypeEAPOL:         {},
+	EthernetTypeLLDP:          {},
+	EthernetTypePTP:           {},
+}
+
+// builtinUDPPorts are the UDP ports decoded by the packages of the agent
+var builtinUDPPorts = map[uint16]struct{}{
+	53:             {}, // DNS
+	dhcpServerPort: {},
+	dhcpClientPort: {},
+	319:            {}, // PTP event
+	320:            {}, // PTP general
+	546:            {}, // DHCPv6 client
+	547:            {}, // DHCPv6 server
+	1985:           {}, // HSRP
+	2029:           {}, // HSRPv6
+	3222:           {}, // GLBP
+}
+
+// Layer is a frame of a protocol registered with RegisterEtherType or
+// RegisterUDPPort. The pipelines don't look into it, they carry it as is to
+// the handlers of their events.
+type Layer interface {
+	// LayerName names the protocol, such as in the logs
+	LayerName() string
+}
+
+// LayerDecoder decodes the payload of a registered protocol: the payload
+// following the VLAN tags for an ethertype, the UDP payload for a port. The
+// payload aliases the frame, which the pipeline reuses once the decoder
+// returns, so the layer must keep copies of what it holds on to.
+type LayerDecoder func(payload []byte) (Layer, error)
+
+// Registry is a set of registered protocols, it is never modified once
+// returned by Registered
+type Registry struct {
+	etherTypes map[EthernetType]LayerDecoder
+	udpPorts   map[uint16]LayerDecoder
+}
+
+var (
+	registry   atomic.Pointer[Registry]
+	registerMu sync.Mutex
+)
+
+// RegisterEtherType registers the decoder of the frames of ethertype t,
+// consulted by the decoders of the agent after the built-in protocols.
+//
+// The protocols must be registered before the pipelines start, such as
+// from an init function: a pipeline takes the registered protocols once, as
+// it is created, and never sees the later ones. A protocol can't be
+// replaced or unregistered.
+func RegisterEtherType(t EthernetType, decode LayerDecoder) error {
+	if t < NonStdLenEthernetTypes || decode == nil {
+		return fmt.Errorf("%w: ethertype 0x%04x", ErrInvalidRegistration, uint16(t))
+	}
+
+	if _, ok := builtinEtherTypes[t]; ok {
+		return fmt.Errorf("%w: ethertype 0x%04x is built in", ErrProtocolRegistered, uint16(t))
+	}
+
+	return register(func(r *Registry) error {
+		if _, ok := r.etherTypes[t]; ok {
+			return fmt.Errorf("%w: ethertype 0x%04x", ErrProtocolRegistered, uint16(t))
+		}
+
+		if len(r.etherTypes) >= maxRegistered {
+			return fmt.Errorf("%w: more than %d ethertypes", ErrInvalidRegistration, maxRegistered)
+		}
+
+		r.etherTypes[t] = decode
+
+		return nil
+	})
+}
+
+// RegisterUDPPort registers the decoder of the UDP datagrams to or from
+// port, over IPv4 or IPv6, like RegisterEtherType. The destination port of a
+// datagram is looked up before its source port.
+func RegisterUDPPort(port uint16, decode LayerDecoder) error {
+	if port == 0 || decode == nil {
+		return fmt.Errorf("%w: UDP port %d", ErrInvalidRegistration, port)
+	}
+
+	if _, ok := builtinUDPPorts[port]; ok {
+		return fmt.Errorf("%w: UDP port %d is built in", ErrProtocolRegistered, port)
+	}
+
+	return register(func(r *Registry) error {
+		if _, ok := r.udpPorts[port]; ok {
+			return fmt.Errorf("%w: UDP port %d", ErrProtocolRegistered, port)
+		}
+
+		if len(r.udpPorts) >= maxRegistered {
+			return fmt.Errorf("%w: more than %d UDP ports", ErrInvalidRegistration, maxRegistered)
+		}
+
+		r.udpPorts[port] = decode
+
+		return nil
+	})
+}
+
+// register applies add to a copy of the registry, which replaces it unless
+// add fails, the readers keep the one they already have
+func register(add func(*Registry) error) error {
+	registerMu.Lock()
+	defer registerMu.Unlock()
+
+	next := &Registry{
+		etherTypes: make(map[EthernetType]LayerDecoder),
+		udpPorts:   make(map[uint16]LayerDecoder),
+	}
+
+	if r := registry.Load(); r != nil {
+		maps.Copy(next.etherTypes, r.etherTypes)
+		maps.Copy(next.udpPorts, r.udpPorts)
+	}
+
+	if err := add(next); err != nil {
+		return err
+	}
+
+	registry.Store(next)
+
+	return nil
+}
+
+// Registered returns the protocols registered so far, or nil if none is
+func Registered() *Registry {
+	return registry.Load()
+}
+
+// Only returns the protocols of r whose ethertype, or UDP port, keep
+// accepts, or nil if none is. The decoders of the others are never called
+// by the returned Registry.
+func (r *Registry) Only(keepType func(EthernetType) bool, keepPort func(uint16) bool) *Registry {
+	if r == nil {
+		return nil
+	}
+
+	only := &Registry{
+		etherTypes: make(map[EthernetType]LayerDecoder),
+		udpPorts:   make(map[uint16]LayerDecoder),
+	}
+
+	for t, decode := range r.etherTypes {
+		if keepType(t) {
+			only.etherTypes[t] = decode
+		}
+	}
+
+	for port, decode := range r.udpPorts {
+		if keepPort(port) {
+			only.udpPorts[port] = decode
+		}
+	}
+
+	if len(only.etherTypes) == 0 && len(only.udpPorts) == 0 {
+		return nil
+	}
+
+	return only
+}
+
+// EtherTypes returns the registered ethertypes, in order
+func (r *Registry) EtherTypes() []EthernetType {
+	if r == nil {
+		return nil
+	}
+
+	return slices.Sorted(maps.Keys(r.etherTypes))
+}
+
+// UDPPorts returns the registered UDP ports, in order
+func (r *Registry) UDPPorts() []uint16 {
+	if r == nil {
+		return nil
+	}
+
+	return slices.Sorted(maps.Keys(r.udpPorts))
+}
+
+// Handles returns whether a frame of ethertype t, following the VLAN tags,
+// may be of a registered protocol
+func (r *Registry) Handles(t EthernetType) bool {
+	if r == nil {
+		return false
+	}
+
+	if _, ok := r.etherTypes[t]; ok {
+		return true
+	}
+
+	return len(r.udpPorts) > 0 && (t == EthernetTypeIPv4 || t == EthernetTypeIPv6)
+}
+
+// Decode decodes frame with the decoder registered for its ethertype, or
+// for a port of the UDP datagram it carries. It returns ErrNotRegistered
+// when no registered protocol decodes frame, and the error of the decoder
+// otherwise.
+func (r *Registry) Decode(frame []byte) (Layer, error) {
+	if r == nil {
+		return nil, ErrNotRegistered
+	}
+
+	var eth EthernetFrame
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return nil, err
+	}
+
+	ethType, payload := eth.untagged()
+
+	if decode, ok := r.etherTypes[ethType]; ok {
+		return decode(payload)
+	}
+
+	if len(r.udpPorts) == 0 {
+		return nil, ErrNotRegistered
+	}
+
+	src, dst, data, ok := udpPayload(ethType, payload)
+	if !ok {
+		return nil, ErrNotRegistered
+	}
+
+	for _, port := range []uint16{dst, src} {
+		if decode, ok := r.udpPorts[port]; ok {
+			return decode(data)
+		}
+	}
+
+	return nil, ErrNotRegistered
+}
+
+// DecodeLayer decodes frame with the protocols registered so far, see
+// Registry.Decode
+func DecodeLayer(frame []byte) (Layer, error) {
+	return Registered().Decode(frame)
+}
+
+// udpPayload returns the ports and the payload of the UDP datagram of an
+// IPv4 packet, unless a fragment, or an IPv6 packet without extension
+// headers
+func udpPayload(ethType EthernetType, pkt []byte) (uint16, uint16, []byte, bool) {
+	switch ethType {
+	case EthernetTypeIPv4:
+		v, err := IPv4View(pkt).Validated()
+		if err != nil || v.Protocol() != 17 || v.MoreFragments() || v.FragmentOffset() != 0 {
+			return 0, 0, nil, false
+		}
+
+		pkt = v[v.HeaderLen():]
+	case EthernetTypeIPv6:
+		if len(pkt) < 40 || pkt[6] != 17 {
+			return 0, 0, nil, false
+		}
+
+		pkt = pkt[40:]
+	default:
+		return 0, 0, nil, false
+	}
+
+	if len(pkt) < 8 {
+		return 0, 0, nil, false
+	}
+
+	return binary.BigEndian.Uint16(pkt[0:2]), binary.BigEndian.Uint16(pkt[2:4]), pkt[8:], true
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// toyLayer is the payload of a registered protocol
+type toyLayer []byte
+
+func (toyLayer) LayerName() string { return "toy" }
+
+var errToy = errors.New("not a toy")
+
+func decodeToy(payload []byte) (Layer, error) {
+	if len(payload) == 0 || payload[0] != 't' {
+		return nil, errToy
+	}
+
+	return toyLayer(append([]byte(nil), payload...)), nil
+}
+
+// emptyRegistry empties the registry for the test and restores it after,
+// the tests using it can't run in parallel
+func emptyRegistry(tb testing.TB) {
+	tb.Helper()
+
+	previous := registry.Swap(nil)
+
+	tb.Cleanup(func() {
+		registry.Store(previous)
+	})
+}
+
+func TestRegister(t *testing.T) {
+	emptyRegistry(t)
+
+	require.NoError(t, RegisterEtherType(0x88b6, decodeToy))
+	require.NoError(t, RegisterUDPPort(4500, decodeToy))
+
+	for name, err := range map[string]error{
+		"ethertype twice":      RegisterEtherType(0x88b6, decodeToy),
+		"UDP port twice":       RegisterUDPPort(4500, decodeToy),
+		"built-in ethertype":   RegisterEtherType(EthernetTypeARP, decodeToy),
+		"built-in UDP port":    RegisterUDPPort(67, decodeToy),
+		"built-in VLAN":        RegisterEtherType(EthernetTypeQinQ, decodeToy),
+		"built-in UDP of PTP":  RegisterUDPPort(319, decodeToy),
+		"built-in LLDP":        RegisterEtherType(0x88cc, decodeToy),
+		"built-in HSRP":        RegisterUDPPort(1985, decodeToy),
+		"built-in DHCPv6":      RegisterUDPPort(547, decodeToy),
+		"built-in Wake-on-LAN": RegisterEtherType(0x0842, decodeToy),
+	} {
+		assert.ErrorIs(t, err, ErrProtocolRegistered, name)
+	}
+
+	for name, err := range map[string]error{
+		"length":      RegisterEtherType(0x0100, decodeToy),
+		"nil decoder": RegisterEtherType(0x88b7, nil),
+		"port 0":      RegisterUDPPort(0, decodeToy),
+	} {
+		assert.ErrorIs(t, err, ErrInvalidRegistration, name)
+	}
+
+	// what was returned before isn't changed by the next registrations
+	r := Registered()
+
+	for i := range maxRegistered - 1 {
+		require.NoError(t, RegisterUDPPort(uint16(5000+i), decodeToy)) //nolint:gosec // a few ports
+	}
+
+	assert.ErrorIs(t, RegisterUDPPort(6000, decodeToy), ErrInvalidRegistration)
+	assert.Equal(t, []uint16{4500}, r.UDPPorts())
+	assert.Len(t, Registered().UDPPorts(), maxRegistered)
+	assert.Equal(t, []EthernetType{0x88b6}, Registered().EtherTypes())
+}
+
+func TestRegistryDecode(t *testing.T) {
+	emptyRegistry(t)
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	build := func(b *FrameBuilder) []byte {
+		frame, err := b.Src(src).Build()
+		require.NoError(t, err)
+
+		return frame
+	}
+
+	arp := build(NewFrame().ARPRequest(netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")))
+
+	layer, err := DecodeLayer(arp)
+	assert.ErrorIs(t, err, ErrNotRegistered)
+	assert.Nil(t, layer)
+	assert.False(t, Registered().Handles(EthernetTypeIPv4))
+
+	require.NoError(t, RegisterEtherType(0x88b6, decodeToy))
+	require.NoError(t, RegisterUDPPort(4500, decodeToy))
+
+	testcases := map[string]struct {
+		err   error
+		in    []byte
+		layer Layer
+	}{
+		"ethertype": {
+			in:    build(NewFrame().Payload(0x88b6, []byte("toy"))),
+			layer: toyLayer("toy"),
+		},
+		"tagged ethertype": {
+			in:    build(NewFrame().VLAN(12).Payload(0x88b6, []byte("toy"))),
+			layer: toyLayer("toy"),
+		},
+		"destination port": {
+			in: build(NewFrame().UDP(netip.MustParseAddrPort("10.0.0.2:40000"),
+				netip.MustParseAddrPort("10.0.0.1:4500"), []byte("toy"))),
+			layer: toyLayer("toy"),
+		},
+		"source port over IPv6": {
+			in: build(NewFrame().UDP(netip.MustParseAddrPort("[fe80::2]:4500"),
+				netip.MustParseAddrPort("[fe80::1]:40000"), []byte("toy"))),
+			layer: toyLayer("toy"),
+		},
+		"other port": {
+			in: build(NewFrame().UDP(netip.MustParseAddrPort("10.0.0.2:40000"),
+				netip.MustParseAddrPort("10.0.0.1:4501"), []byte("toy"))),
+			err: ErrNotRegistered,
+		},
+		"built-in": {
+			in:  arp,
+			err: ErrNotRegistered,
+		},
+		"decoder error": {
+			in:  build(NewFrame().Payload(0x88b6, []byte("not"))),
+			err: errToy,
+		},
+		"truncated": {
+			in:  []byte{0x00},
+			err: ErrTruncated,
+		},
+	}
+
+	for name, tc := range testcases {
+		layer, err := Registered().Decode(tc.in)
+		if tc.err != nil {
+			assert.ErrorIs(t, err, tc.err, name)
+			continue
+		}
+
+		require.NoError(t, err, name)
+		assert.Equal(t, tc.layer, layer, name)
+	}
+
+	assert.True(t, Registered().Handles(0x88b6))
+	assert.True(t, Registered().Handles(EthernetTypeIPv6))
+	assert.False(t, Registered().Handles(EthernetTypeARP))
+}
+
+func TestRegistryOnly(t *testing.T) {
+	emptyRegistry(t)
+
+	all := func(uint16) bool { return true }
+	allTypes := func(EthernetType) bool { return true }
+	noPort := func(uint16) bool { return false }
+	noType := func(EthernetType) bool { return false }
+
+	assert.Nil(t, Registered().Only(allTypes, all))
+
+	require.NoError(t, RegisterEtherType(0x88b6, decodeToy))
+	require.NoError(t, RegisterUDPPort(4500, decodeToy))
+	require.NoError(t, RegisterUDPPort(4501, decodeToy))
+
+	r := Registered()
+
+	only := r.Only(noType, func(port uint16) bool { return port == 4501 })
+	assert.Empty(t, only.EtherTypes())
+	assert.Equal(t, []uint16{4501}, only.UDPPorts())
+	assert.False(t, only.Handles(0x88b6))
+
+	frame, err := NewFrame().Src(net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}).
+		UDP(netip.MustParseAddrPort("10.0.0.2:40000"), netip.MustParseAddrPort("10.0.0.1:4500"), []byte("toy")).
+		Build()
+	require.NoError(t, err)
+
+	_, err = only.Decode(frame)
+	assert.ErrorIs(t, err, ErrNotRegistered)
+
+	// the registry is left as it is
+	assert.Equal(t, []uint16{4500, 4501}, r.UDPPorts())
+	assert.Equal(t, []EthernetType{0x88b6}, r.Only(allTypes, noPort).EtherTypes())
+	assert.Nil(t, r.Only(noType, noPort))
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Strictness selects how the decoders settle what a broken or ambiguous
+// frame leaves open. A monitoring deployment extracts whatever it can, one
+// acting on what it decodes rejects the ambiguity. Unlike a ValidationLevel,
+// which judges a packet once decoded, the Strictness decides whether and
+// how it is decoded.
+//
+// The decisions, each made by a method of ParserOptions:
+//
+//	decision                        Lenient   Standard  Strict
+//	VerifyChecksums                 no        no        yes
+//	RejectReservedVID               no        no        yes
+//	SalvageARPLengths               yes       no        no
+//	RejectARPLengths                no        no        yes
+//	SkipUnknownOptions              yes       yes       no
+//	KeepTruncatedOptions            yes       no        no
+type Strictness uint8
+
+const (
+	// StrictnessStandard is the behaviour of the decoders without options
+	StrictnessStandard Strictness = iota
+	// StrictnessLenient salvages what it can of broken frames
+	StrictnessLenient
+	// StrictnessStrict rejects the frames a well-behaved host wouldn't send
+	StrictnessStrict
+)
+
+// ReservedVID is the VLAN ID 802.1Q reserves, no frame is to carry it
+const ReservedVID = 0x0fff
+
+var (
+	// ErrInvalidStrictness is returned by ParseStrictness for an unknown
+	// name
+	ErrInvalidStrictness = errors.New("invalid strictness")
+	// ErrReservedVID is matched by the errors for a VLAN tag carrying
+	// ReservedVID at StrictnessStrict
+	ErrReservedVID = errors.New("reserved VLAN ID")
+
+	errReservedVID error = malformed("VLAN", "ID", fmt.Errorf("%w: %w", ErrMalformedVLAN, ErrReservedVID))
+)
+
+func (s Strictness) String() string {
+	switch s {
+	case StrictnessLenient:
+		return "lenient"
+	case StrictnessStandard:
+		return "standard"
+	case StrictnessStrict:
+		return "strict"
+	default:
+		return fmt.Sprintf("Strictness(%d)", uint8(s))
+	}
+}
+
+// ParseStrictness returns the Strictness named s, as returned by String
+func ParseStrictness(s string) (Strictness, error) {
+	for _, st := range []Strictness{StrictnessLenient, StrictnessStandard, StrictnessStrict} {
+		if st.String() == s {
+			return st, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrInvalidStrictness, s)
+}
+
+// ParserOptions configure the decoders of every protocol, the zero value
+// decodes at StrictnessStandard within the default DecodeLimits
+type ParserOptions struct {
+	Limits     DecodeLimits
+	Strictness Strictness
+}
+
+// Validate returns an error matching ErrInvalidStrictness for a Strictness
+// which isn't defined
+func (o ParserOptions) Validate() error {
+	if o.Strictness > StrictnessStrict {
+		return fmt.Errorf("%w: %s", ErrInvalidStrictness, o.Strictness)
+	}
+
+	return nil
+}
+
+// VerifyChecksums tells whether a checksum which doesn't match aborts the
+// decode. The NICs offloading the checksums hand the frames the host sends
+// to the capture before computing them, so only StrictnessStrict verifies
+// them.
+func (o ParserOptions) VerifyChecksums() bool {
+	return o.Strictness == StrictnessStrict
+}
+
+// RejectReservedVID tells whether a VLAN tag carrying ReservedVID is an
+// error, rather than a VLAN like any other. Only StrictnessStrict rejects
+// it.
+func (o ParserOptions) RejectReservedVID() bool {
+	return o.Strictness == StrictnessStrict
+}
+
+// SalvageARPLengths tells whether an ARP packet of ethernet and IPv4 whose
+// address lengths aren't 6 and 4 is decoded with those, as long as it holds
+// enough bytes for them. Only StrictnessLenient salvages it, the others
+// decode the addresses with the lengths of the packet, the protocol ones
+// being malformed unless they are IP addresses or WithOpaqueProtocolAddrs
+// is given.
+func (o ParserOptions) SalvageARPLengths() bool {
+	return o.Strictness == StrictnessLenient
+}
+
+// RejectARPLengths tells whether an ARP packet of ethernet or IPv4 whose
+// address lengths aren't 6 or 4 is an error. Only StrictnessStrict rejects
+// it.
+func (o ParserOptions) RejectARPLengths() bool {
+	return o.Strictness == StrictnessStrict
+}
+
+// SkipUnknownOptions tells whether the options or TLVs of a type the
+// protocol doesn't define, such as a reserved LLDP TLV type, are skipped
+// rather than abort the decode. Only StrictnessStrict aborts.
+func (o ParserOptions) SkipUnknownOptions() bool {
+	return o.Strictness != StrictnessStrict
+}
+
+// KeepTruncatedOptions tells whether an option or TLV running past the end
+// of the packet ends the options, keeping those before it, rather than
+// abort the decode. Only StrictnessLenient keeps them.
+func (o ParserOptions) KeepTruncatedOptions() bool {
+	return o.Strictness == StrictnessLenient
+}
+
+// CheckTags returns an error matching ErrReservedVID, ErrMalformedVLAN and
+// ErrMalformed for the first of tags, as returned by EthernetFrame.AppendTags, the options
+// reject
+func (o ParserOptions) CheckTags(tags []Tag) error {
+	for _, t := range tags {
+		if err := o.checkTag(t.VID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkTag returns an error matching ErrReservedVID, ErrMalformedVLAN and
+// ErrMalformed for a tag of ReservedVID the options reject
+func (o ParserOptions) checkTag(tci uint16) error {
+	if tci&vlanIDMask == ReservedVID && o.RejectReservedVID() {
+		return errReservedVID
+	}
+
+	return nil
+}
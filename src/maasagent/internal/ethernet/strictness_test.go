@@ -0,0 +1,148 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var strictnesses = []Strictness{StrictnessLenient, StrictnessStandard, StrictnessStrict}
+
+func TestParseStrictness(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range strictnesses {
+		got, err := ParseStrictness(s.String())
+		require.NoError(t, err)
+		assert.Equal(t, s, got)
+	}
+
+	_, err := ParseStrictness("pedantic")
+	assert.ErrorIs(t, err, ErrInvalidStrictness)
+	assert.Equal(t, "Strictness(9)", Strictness(9).String())
+
+	assert.NoError(t, ParserOptions{Strictness: StrictnessStrict}.Validate())
+	assert.ErrorIs(t, ParserOptions{Strictness: 9}.Validate(), ErrInvalidStrictness)
+}
+
+func TestStrictnessReservedVID(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	frame, err := NewFrame().Src(src).VLAN(ReservedVID).ARPRequest(ip, ip).BuildFrame()
+	require.NoError(t, err)
+
+	var stack [MaxTags]Tag
+
+	tags, _, _, err := frame.AppendTags(stack[:0])
+	require.NoError(t, err)
+
+	for _, s := range strictnesses {
+		t.Run(s.String(), func(t *testing.T) {
+			t.Parallel()
+
+			o := ParserOptions{Strictness: s}
+
+			_, vlanErr := frame.ExtractVLANWith(o)
+			_, arpErr := frame.ExtractARPPacket(WithParserOptions(o))
+			tagsErr := o.CheckTags(tags)
+
+			if s != StrictnessStrict {
+				assert.NoError(t, vlanErr)
+				assert.NoError(t, arpErr)
+				assert.NoError(t, tagsErr)
+
+				return
+			}
+
+			for _, err := range []error{vlanErr, arpErr, tagsErr} {
+				assert.ErrorIs(t, err, ErrReservedVID)
+				assert.ErrorIs(t, err, ErrMalformedVLAN)
+				assert.ErrorIs(t, err, ErrMalformed)
+			}
+		})
+	}
+
+	// the other IDs are left alone
+	other, err := NewFrame().Src(src).VLAN(ReservedVID-1).ARPRequest(ip, ip).BuildFrame()
+	require.NoError(t, err)
+
+	_, err = other.ExtractVLANWith(ParserOptions{Strictness: StrictnessStrict})
+	assert.NoError(t, err)
+}
+
+func TestStrictnessARPLengths(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	sender, target := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+
+	// a protocol address length of 0, the packet holding 4 bytes addresses
+	frame, err := NewFrame().Src(src).ARPRequest(sender, target).BuildFrame()
+	require.NoError(t, err)
+
+	frame.Payload[5] = 0
+
+	testcases := map[Strictness]func(t *testing.T, pkt *ARPPacket, err error){
+		StrictnessLenient: func(t *testing.T, pkt *ARPPacket, err error) {
+			require.NoError(t, err)
+			assert.Equal(t, uint8(4), pkt.ProtocolAddrLen)
+			assert.Equal(t, sender, pkt.SenderAddr())
+			assert.Equal(t, target, pkt.TargetAddr())
+		},
+		StrictnessStandard: func(t *testing.T, _ *ARPPacket, err error) {
+			// the empty protocol addresses aren't IP addresses
+			assert.ErrorIs(t, err, ErrMalformedARPPacket)
+			assert.ErrorIs(t, err, ErrMalformed)
+		},
+		StrictnessStrict: func(t *testing.T, _ *ARPPacket, err error) {
+			assert.ErrorIs(t, err, ErrMalformedARPPacket)
+			assert.ErrorIs(t, err, ErrMalformed)
+		},
+	}
+
+	for s, check := range testcases {
+		s, check := s, check
+
+		t.Run(s.String(), func(t *testing.T) {
+			t.Parallel()
+
+			pkt, err := frame.ExtractARPPacket(WithParserOptions(ParserOptions{Strictness: s}))
+			check(t, pkt, err)
+		})
+	}
+
+	// opaque protocol addresses are decoded with the lengths of the packet
+	pkt, err := frame.ExtractARPPacket(WithOpaqueProtocolAddrs())
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0), pkt.ProtocolAddrLen)
+	assert.False(t, pkt.TargetAddr().IsValid())
+
+	// a packet too short for the addresses of ethernet and IPv4 isn't
+	// salvaged, its empty protocol addresses are opaque
+	short := &ARPPacket{}
+	buf := append([]byte(nil), frame.Payload[:20]...)
+
+	require.NoError(t, short.unmarshal(buf, ParserOptions{Strictness: StrictnessLenient}, true))
+	assert.Equal(t, uint8(0), short.ProtocolAddrLen)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MaxTags bounds the VLAN tags followed in a frame by default, deeper
+// stacks are treated as malformed, see DecodeLimits
+const MaxTags = 8
+
+// Tag is a VLAN tag of a frame, an encapsulation is a list of them from the
+// outermost
+type Tag struct {
+	// TPID is the tag protocol identifier, EthernetTypeVLAN for a customer
+	// tag, EthernetTypeQinQ or EthernetTypeQinQLegacy for a service tag
+	TPID EthernetType `json:"tpid"`
+	// VID is the VLAN ID
+	VID uint16 `json:"vid"`
+	// Priority is the priority code point, from 0 to 7
+	Priority uint8 `json:"priority,omitempty"`
+}
+
+// IsTPID returns whether t is the protocol identifier of a VLAN tag rather
+// than the ethertype of a payload
+func IsTPID(t EthernetType) bool {
+	return t == EthernetTypeVLAN || t == EthernetTypeQinQ || t == EthernetTypeQinQLegacy
+}
+
+// AppendTags appends the VLAN tags of the frame to tags, outermost first,
+// and returns them with the ethertype and payload following the innermost.
+// Appending to a slice of an array on the stack walks them without
+// allocating.
+func (e *EthernetFrame) AppendTags(tags []Tag) ([]Tag, EthernetType, []byte, error) {
+	return e.AppendTagsWith(tags, ParserOptions{})
+}
+
+// AppendTagsWith is AppendTags following up to the tags of the limits of
+// the options, see DecodeLimits
+func (e *EthernetFrame) AppendTagsWith(tags []Tag, o ParserOptions) ([]Tag, EthernetType, []byte, error) {
+	ethType, buf := e.EthernetType, e.Payload
+	limit := o.tagLimit()
+
+	for n := 0; IsTPID(ethType); n++ {
+		if len(buf) < vlanTagLen {
+			return tags, 0, nil, e.snapped(errTruncatedVLAN)
+		}
+
+		if n == limit {
+			return tags, 0, nil, errTagLimit
+		}
+
+		tci := binary.BigEndian.Uint16(buf[0:2])
+		tags = append(tags, Tag{TPID: ethType, VID: tci & vlanIDMask, Priority: uint8(tci >> 13)})
+
+		ethType = EthernetType(binary.BigEndian.Uint16(buf[2:4]))
+		buf = buf[vlanTagLen:]
+	}
+
+	return tags, ethType, buf, nil
+}
+
+// Tags adds the tags of an encapsulation, outermost first, after those
+// already added. A tag without a TPID is an 802.1Q one.
+func (b *FrameBuilder) Tags(tags ...Tag) *FrameBuilder {
+	for _, t := range tags {
+		tpid := t.TPID
+		if tpid == 0 {
+			tpid = EthernetTypeVLAN
+		}
+
+		if !IsTPID(tpid) {
+			b.errs = append(b.errs, fmt.Errorf("%w: VLAN %d with TPID %s", ErrBuildFrame, t.VID, tpid))
+			continue
+		}
+
+		b.VLAN(t.VID, WithTPID(tpid), WithPriority(t.Priority))
+	}
+
+	return b
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagsRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	testcases := map[string]struct {
+		tags []Tag
+	}{
+		"untagged": {},
+		"single": {
+			tags: []Tag{{TPID: EthernetTypeVLAN, VID: 100, Priority: 5}},
+		},
+		"double": {
+			tags: []Tag{{TPID: EthernetTypeQinQ, VID: 300, Priority: 1}, {TPID: EthernetTypeVLAN, VID: 100}},
+		},
+		"legacy double": {
+			tags: []Tag{{TPID: EthernetTypeQinQLegacy, VID: 300}, {TPID: EthernetTypeVLAN, VID: 100, Priority: 7}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			frame, err := NewFrame().Src(src).Tags(tc.tags...).ARPRequest(ip, ip).BuildFrame()
+			require.NoError(t, err)
+
+			var stack [MaxTags]Tag
+
+			tags, ethType, payload, err := frame.AppendTags(stack[:0])
+			require.NoError(t, err)
+			assert.Equal(t, tc.tags, append([]Tag(nil), tags...))
+			assert.Equal(t, EthernetTypeARP, ethType)
+			assert.Len(t, payload, 28)
+
+			pkt, err := frame.ExtractARPPacket()
+			require.NoError(t, err)
+			assert.Equal(t, ip, pkt.TargetAddr())
+		})
+	}
+}
+
+func TestAppendTagsMalformed(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	deep := NewFrame().Src(src)
+	for range MaxTags + 1 {
+		deep.VLAN(1)
+	}
+
+	testcases := map[string]struct {
+		frame []byte
+	}{
+		"too deep": {
+			frame: mustBuild(t, deep.EtherType(EthernetTypeARP)),
+		},
+		"truncated": {
+			frame: concat(Broadcast, src, []byte{0x88, 0xa8, 0x00, 0x64, 0x81, 0x00}),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var frame EthernetFrame
+
+			require.NoError(t, frame.UnmarshalBinary(tc.frame))
+
+			_, _, _, err := frame.AppendTags(nil)
+			assert.ErrorIs(t, err, ErrMalformedVLAN)
+
+			_, err = frame.ExtractARPPacket()
+			assert.ErrorIs(t, err, ErrMalformedVLAN)
+		})
+	}
+}
+
+func TestBuilderTagsTPID(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	// a tag without TPID is a customer tag
+	buf := mustBuild(t, NewFrame().Src(src).Tags(Tag{VID: 2}).ARPRequest(ip, ip))
+	assert.Equal(t, []byte{0x81, 0x00, 0x00, 0x02, 0x08, 0x06}, buf[12:18])
+
+	_, err := NewFrame().Src(src).Tags(Tag{TPID: EthernetTypeIPv4, VID: 2}).ARPRequest(ip, ip).Build()
+	assert.ErrorIs(t, err, ErrBuildFrame)
+}
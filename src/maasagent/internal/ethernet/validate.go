@@ -0,0 +1,196 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// ValidationLevel selects how strictly frames and packets are validated
+type ValidationLevel uint8
+
+const (
+	// ValidationLenient only rejects what is structurally inconsistent,
+	// it suits observing whatever is on the wire
+	ValidationLenient ValidationLevel = iota + 1
+	// ValidationStrict also rejects what a well-behaved host would not
+	// send, it suits anything acted upon or recorded as authoritative
+	ValidationStrict
+)
+
+const (
+	hwAddrLen = 6
+	// maxLLCLen is the largest valid value of the IEEE 802.3 length field
+	maxLLCLen = 1500
+	// maxPayloadLen allows for jumbo frames
+	maxPayloadLen = 9216
+	vlanTagLen    = 4
+)
+
+var (
+	// ErrInvalidFrame is wrapped by every violation reported by
+	// EthernetFrame.Validate
+	ErrInvalidFrame = errors.New("invalid ethernet frame")
+	// ErrInvalidARPPacket is wrapped by every violation reported by
+	// ARPPacket.Validate
+	ErrInvalidARPPacket = errors.New("invalid ARP packet")
+
+	zeroHwAddr = make(net.HardwareAddr, hwAddrLen)
+)
+
+func isMulticast(mac net.HardwareAddr) bool {
+	return len(mac) > 0 && mac[0]&0x01 != 0
+}
+
+func isZero(mac net.HardwareAddr) bool {
+	return bytes.Equal(mac, zeroHwAddr)
+}
+
+// protoAddrLen prefers the length on the wire, as addr may have been
+// unmapped, and falls back to addr for packets built by hand
+func protoAddrLen(raw []byte, addr netip.Addr) int {
+	if raw != nil {
+		return len(raw)
+	}
+
+	return addr.BitLen() / 8
+}
+
+// Validate checks the frame at the given level and returns every violation
+// found joined in a single error, or nil when the frame is valid
+func (e *EthernetFrame) Validate(level ValidationLevel) error {
+	var errs []error
+
+	violation := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidFrame}, args...)...))
+	}
+
+	if len(e.DstMAC) != hwAddrLen {
+		violation("destination MAC has length %d", len(e.DstMAC))
+	}
+
+	if len(e.SrcMAC) != hwAddrLen {
+		violation("source MAC has length %d", len(e.SrcMAC))
+	}
+
+	if len(e.Payload) > maxPayloadLen {
+		violation("payload of %d bytes exceeds %d", len(e.Payload), maxPayloadLen)
+	}
+
+	switch e.EthernetType {
+	case EthernetTypeLLC:
+		if e.Len == 0 || int(e.Len) > len(e.Payload) {
+			violation("length field %d doesn't match payload of %d bytes", e.Len, len(e.Payload))
+		}
+	case EthernetTypeVLAN:
+		if len(e.Payload) < vlanTagLen {
+			violation("payload of %d bytes is too short for a VLAN tag", len(e.Payload))
+		}
+	}
+
+	if level < ValidationStrict {
+		return errors.Join(errs...)
+	}
+
+	if isMulticast(e.SrcMAC) {
+		violation("source MAC %s is multicast", e.SrcMAC)
+	}
+
+	if isZero(e.SrcMAC) {
+		violation("source MAC is all zeroes")
+	}
+
+	if e.EthernetType == EthernetTypeLLC && e.Len > maxLLCLen {
+		violation("length field %d exceeds %d", e.Len, maxLLCLen)
+	}
+
+	// values between the largest length and the smallest ethertype are
+	// undefined, UnmarshalBinary doesn't reject them
+	if e.EthernetType != EthernetTypeLLC && e.EthernetType < NonStdLenEthernetTypes {
+		violation("ethertype %#04x is undefined", uint16(e.EthernetType))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate checks the packet at the given level and returns every violation
+// found joined in a single error, or nil when the packet is valid.
+// When frame is not nil, the strict level also checks the packet against
+// the ethernet frame carrying it.
+func (pkt *ARPPacket) Validate(level ValidationLevel, frame *EthernetFrame) error {
+	var errs []error
+
+	violation := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidARPPacket}, args...)...))
+	}
+
+	if pkt.HardwareType == HardwareTypeEthernet && pkt.HardwareAddrLen != hwAddrLen {
+		violation("hardware address length %d for ethernet", pkt.HardwareAddrLen)
+	}
+
+	if pkt.ProtocolType == ProtocolTypeIPv4 && pkt.ProtocolAddrLen != 4 {
+		violation("protocol address length %d for IPv4", pkt.ProtocolAddrLen)
+	}
+
+	if len(pkt.SendHwAddr) != int(pkt.HardwareAddrLen) || len(pkt.TgtHwAddr) != int(pkt.HardwareAddrLen) {
+		violation("hardware addresses don't match length %d", pkt.HardwareAddrLen)
+	}
+
+	if protoAddrLen(pkt.SendProtoAddr, pkt.SendIPAddr) != int(pkt.ProtocolAddrLen) ||
+		protoAddrLen(pkt.TgtProtoAddr, pkt.TgtIPAddr) != int(pkt.ProtocolAddrLen) {
+		violation("protocol addresses don't match length %d", pkt.ProtocolAddrLen)
+	}
+
+	if level < ValidationStrict {
+		return errors.Join(errs...)
+	}
+
+	if pkt.HardwareType != HardwareTypeEthernet {
+		violation("hardware type %s is not ethernet", pkt.HardwareType)
+	}
+
+	if pkt.ProtocolType != ProtocolTypeIPv4 {
+		violation("protocol type %s is not IPv4", pkt.ProtocolType)
+	}
+
+	if pkt.OpCode != OpRequest && pkt.OpCode != OpReply {
+		violation("unknown opcode %d", pkt.OpCode)
+	}
+
+	if isMulticast(pkt.SendHwAddr) {
+		violation("sender MAC %s is multicast", pkt.SendHwAddr)
+	}
+
+	if isZero(pkt.SendHwAddr) {
+		violation("sender MAC is all zeroes")
+	}
+
+	if frame != nil {
+		if frame.EthernetType != EthernetTypeARP && frame.EthernetType != EthernetTypeVLAN {
+			violation("carried in a frame of type %s", frame.EthernetType)
+		}
+
+		if !bytes.Equal(frame.SrcMAC, pkt.SendHwAddr) {
+			violation("sender MAC %s differs from frame source %s", pkt.SendHwAddr, frame.SrcMAC)
+		}
+	}
+
+	return errors.Join(errs...)
+}
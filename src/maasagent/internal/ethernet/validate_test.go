@@ -0,0 +1,265 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testSrcMAC = net.HardwareAddr{0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25}
+	testDstMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+// violations returns the number of errors joined in err
+func violations(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok { //nolint:errorlint // inspecting the join itself
+		return len(joined.Unwrap())
+	}
+
+	return 1
+}
+
+func TestEthernetFrameValidate(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in      EthernetFrame
+		lenient int
+		strict  int
+	}{
+		"valid ARP frame": {
+			in: EthernetFrame{
+				SrcMAC:       testSrcMAC,
+				DstMAC:       testDstMAC,
+				EthernetType: EthernetTypeARP,
+				Payload:      make([]byte, 28),
+			},
+		},
+		"valid LLC frame": {
+			in: EthernetFrame{
+				SrcMAC:       testSrcMAC,
+				DstMAC:       testDstMAC,
+				EthernetType: EthernetTypeLLC,
+				Len:          3,
+				Payload:      make([]byte, 3),
+			},
+		},
+		"multicast and zero source are only rejected when strict": {
+			in: EthernetFrame{
+				SrcMAC:       net.HardwareAddr{0x01, 0x00, 0x00, 0x00, 0x00, 0x00},
+				DstMAC:       testDstMAC,
+				EthernetType: EthernetTypeIPv4,
+			},
+			strict: 1,
+		},
+		"zero source": {
+			in: EthernetFrame{
+				SrcMAC:       make(net.HardwareAddr, 6),
+				DstMAC:       testDstMAC,
+				EthernetType: EthernetTypeIPv4,
+			},
+			strict: 1,
+		},
+		"every violation is reported": {
+			in: EthernetFrame{
+				SrcMAC:       net.HardwareAddr{0x01, 0x02},
+				DstMAC:       testDstMAC,
+				EthernetType: EthernetTypeVLAN,
+				Payload:      []byte{0x00},
+			},
+			lenient: 2,
+			// short multicast source
+			strict: 3,
+		},
+		"length field larger than payload": {
+			in: EthernetFrame{
+				SrcMAC:       testSrcMAC,
+				DstMAC:       testDstMAC,
+				EthernetType: EthernetTypeLLC,
+				Len:          10,
+				Payload:      make([]byte, 3),
+			},
+			lenient: 1,
+			strict:  1,
+		},
+		"oversized length field": {
+			in: EthernetFrame{
+				SrcMAC:       testSrcMAC,
+				DstMAC:       testDstMAC,
+				EthernetType: EthernetTypeLLC,
+				Len:          1530,
+				Payload:      make([]byte, 1530),
+			},
+			strict: 1,
+		},
+		"undefined ethertype": {
+			in: EthernetFrame{
+				SrcMAC:       testSrcMAC,
+				DstMAC:       testDstMAC,
+				EthernetType: 0x0200,
+			},
+			strict: 1,
+		},
+		"oversized payload": {
+			in: EthernetFrame{
+				SrcMAC:       testSrcMAC,
+				DstMAC:       testDstMAC,
+				EthernetType: EthernetTypeIPv4,
+				Payload:      make([]byte, 10000),
+			},
+			lenient: 1,
+			strict:  1,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.in.Validate(ValidationLenient)
+			assert.Equal(t, tc.lenient, violations(err), err)
+
+			if err != nil {
+				assert.ErrorIs(t, err, ErrInvalidFrame)
+			}
+
+			err = tc.in.Validate(ValidationStrict)
+			assert.Equal(t, tc.strict, violations(err), err)
+
+			if err != nil {
+				assert.ErrorIs(t, err, ErrInvalidFrame)
+			}
+		})
+	}
+}
+
+func testARPPacket() ARPPacket {
+	return ARPPacket{
+		HardwareType:    HardwareTypeEthernet,
+		ProtocolType:    ProtocolTypeIPv4,
+		HardwareAddrLen: 6,
+		ProtocolAddrLen: 4,
+		OpCode:          OpRequest,
+		SendHwAddr:      testSrcMAC,
+		SendIPAddr:      netip.MustParseAddr("192.168.10.26"),
+		TgtHwAddr:       make(net.HardwareAddr, 6),
+		TgtIPAddr:       netip.MustParseAddr("192.168.10.25"),
+	}
+}
+
+func TestARPPacketValidate(t *testing.T) {
+	t.Parallel()
+
+	arpFrame := &EthernetFrame{SrcMAC: testSrcMAC, DstMAC: testDstMAC, EthernetType: EthernetTypeARP}
+
+	testcases := map[string]struct {
+		in      func(*ARPPacket)
+		frame   *EthernetFrame
+		lenient int
+		strict  int
+	}{
+		"valid request": {
+			frame: arpFrame,
+		},
+		"valid request without frame": {},
+		"probe with unspecified sender IP": {
+			in: func(p *ARPPacket) {
+				p.SendIPAddr = netip.IPv4Unspecified()
+			},
+			frame: arpFrame,
+		},
+		"hardware length inconsistent with ethernet": {
+			in: func(p *ARPPacket) {
+				p.HardwareAddrLen = 8
+			},
+			// the addresses don't match the declared length either
+			lenient: 2,
+			strict:  2,
+		},
+		"protocol length inconsistent with IPv4": {
+			in: func(p *ARPPacket) {
+				p.ProtocolAddrLen = 16
+			},
+			lenient: 2,
+			strict:  2,
+		},
+		"reserved opcode": {
+			in: func(p *ARPPacket) {
+				p.OpCode = OpReserved
+			},
+			strict: 1,
+		},
+		"sender MAC differs from frame": {
+			in: func(p *ARPPacket) {
+				p.SendHwAddr = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+			},
+			frame:  arpFrame,
+			strict: 1,
+		},
+		"multicast sender": {
+			in: func(p *ARPPacket) {
+				p.SendHwAddr = net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0x01}
+			},
+			strict: 1,
+		},
+		"experimental ethernet and ARP protocol type": {
+			in: func(p *ARPPacket) {
+				p.HardwareType = HardwareTypeExpEth
+				p.ProtocolType = ProtocolTypeARP
+			},
+			strict: 2,
+		},
+		"wrong frame type": {
+			frame:  &EthernetFrame{SrcMAC: testSrcMAC, DstMAC: testDstMAC, EthernetType: EthernetTypeIPv4},
+			strict: 1,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pkt := testARPPacket()
+			if tc.in != nil {
+				tc.in(&pkt)
+			}
+
+			err := pkt.Validate(ValidationLenient, tc.frame)
+			assert.Equal(t, tc.lenient, violations(err), err)
+
+			err = pkt.Validate(ValidationStrict, tc.frame)
+			assert.Equal(t, tc.strict, violations(err), err)
+
+			if err != nil {
+				assert.True(t, errors.Is(err, ErrInvalidARPPacket))
+			}
+		})
+	}
+}
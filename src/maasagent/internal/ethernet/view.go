@@ -0,0 +1,299 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+
+	"maas.io/core/src/maasagent/internal/checksum"
+)
+
+const (
+	arpHeaderLen  = 8
+	ipv4HeaderLen = 20
+)
+
+var (
+	// ErrNotARP is returned by EthernetFrame.ARPView if the frame is of
+	// another type
+	ErrNotARP = errors.New("ethernet frame not of type ARP")
+	// ErrNotIPv4 is returned by EthernetFrame.IPv4View if the frame is of
+	// another type
+	ErrNotIPv4 = errors.New("ethernet frame not of type IPv4")
+	// ErrMalformedIPv4 is returned when validating an IPv4 header which
+	// doesn't fit its buffer
+	ErrMalformedIPv4 = errors.New("malformed IPv4 packet")
+)
+
+// untagged returns the ethertype and payload after every VLAN tag, a
+// truncated tag is left in the payload, as are the tags past MaxTags
+func (e *EthernetFrame) untagged() (EthernetType, []byte) {
+	ethType, buf := e.EthernetType, e.Payload
+
+	for n := 0; IsTPID(ethType) && len(buf) >= vlanTagLen && n < MaxTags; n++ {
+		ethType = EthernetType(binary.BigEndian.Uint16(buf[2:4]))
+		buf = buf[vlanTagLen:]
+	}
+
+	return ethType, buf
+}
+
+// PayloadReader returns a reader over the payload following any VLAN tags.
+// It reads the frame's buffer in place.
+func (e *EthernetFrame) PayloadReader() *bytes.Reader {
+	_, buf := e.untagged()
+
+	return bytes.NewReader(buf)
+}
+
+// ARPView returns a validated view of the ARP packet following any VLAN
+// tags, or ErrNotARP if the frame is of another type
+func (e *EthernetFrame) ARPView() (ARPView, error) {
+	ethType, buf := e.untagged()
+	if ethType != EthernetTypeARP {
+		return nil, errNotARP
+	}
+
+	v, err := ARPView(buf).Validated()
+
+	return v, e.snapped(err)
+}
+
+// IPv4View returns a validated view of the IPv4 packet following any VLAN
+// tags, or ErrNotIPv4 if the frame is of another type. The view of a packet
+// the snaplen cut, see UnmarshalCaptured, is returned with an error matching
+// ErrTruncatedBySnaplen, its header is whole and the Payload is what the
+// capture kept.
+func (e *EthernetFrame) IPv4View() (IPv4View, error) {
+	ethType, buf := e.untagged()
+	if ethType != EthernetTypeIPv4 {
+		return nil, errNotIPv4
+	}
+
+	v, err := IPv4View(buf).validated(e.length - e.captured)
+
+	return v, e.snapped(err)
+}
+
+// ARPView interprets an ARP packet in place, each accessor decodes its
+// field from the bytes when called. Addresses are returned as sub-slices,
+// which like the view itself are invalid once the frame's buffer is reused.
+// The accessors don't check bounds, a view which didn't come from Validated
+// may panic.
+type ARPView []byte
+
+// Validated checks that the addresses fit in the view, which is returned
+// without the bytes following the packet
+func (v ARPView) Validated() (ARPView, error) {
+	if len(v) < arpHeaderLen {
+		return nil, truncated("ARP", "header", ErrMalformedARPPacket).detailf("packet missing initial ARP fields")
+	}
+
+	n := arpHeaderLen + 2*(int(v[4])+int(v[5]))
+	if len(v) < n {
+		return nil, truncated("ARP", "addresses", ErrMalformedARPPacket).
+			detailf("%d bytes are too short for the addresses", len(v))
+	}
+
+	return v[:n:n], nil
+}
+
+// HardwareType returns the hardware type
+func (v ARPView) HardwareType() HardwareType {
+	return HardwareType(binary.BigEndian.Uint16(v[0:2]))
+}
+
+// ProtocolType returns the protocol type
+func (v ARPView) ProtocolType() ProtocolType {
+	return ProtocolType(binary.BigEndian.Uint16(v[2:4]))
+}
+
+// OpCode returns the operation, OpRequest or OpReply
+func (v ARPView) OpCode() uint16 {
+	return binary.BigEndian.Uint16(v[6:8])
+}
+
+func (v ARPView) field(index int) []byte {
+	hw, proto := int(v[4]), int(v[5])
+	off := arpHeaderLen + index/2*(hw+proto)
+	n := hw
+
+	if index%2 == 1 {
+		off += hw
+		n = proto
+	}
+
+	return v[off : off+n : off+n]
+}
+
+// SenderHardwareAddr returns the sender hardware address
+func (v ARPView) SenderHardwareAddr() net.HardwareAddr {
+	return v.field(0)
+}
+
+// SenderProtocolAddr returns the sender protocol address as on the wire
+func (v ARPView) SenderProtocolAddr() []byte {
+	return v.field(1)
+}
+
+// TargetHardwareAddr returns the target hardware address
+func (v ARPView) TargetHardwareAddr() net.HardwareAddr {
+	return v.field(2)
+}
+
+// TargetProtocolAddr returns the target protocol address as on the wire
+func (v ARPView) TargetProtocolAddr() []byte {
+	return v.field(3)
+}
+
+// SenderAddr returns the sender protocol address like
+// ARPPacket.SenderAddr, it is invalid unless 4 or 16 bytes long
+func (v ARPView) SenderAddr() netip.Addr {
+	return protoAddr(v.SenderProtocolAddr())
+}
+
+// TargetAddr returns the target protocol address like
+// ARPPacket.TargetAddr, it is invalid unless 4 or 16 bytes long
+func (v ARPView) TargetAddr() netip.Addr {
+	return protoAddr(v.TargetProtocolAddr())
+}
+
+// IPv4View interprets an IPv4 packet in place, like ARPView. It is invalid
+// once the frame's buffer is reused and must come from Validated for the
+// accessors not to panic.
+type IPv4View []byte
+
+// Validated checks the version and that the header and the total length
+// fit in the view, which is returned without the padding of the frame
+func (v IPv4View) Validated() (IPv4View, error) {
+	return v.validated(0)
+}
+
+// validated is Validated for a view missing bytes at its end, which the
+// total length may cover
+func (v IPv4View) validated(missing int) (IPv4View, error) {
+	if len(v) < ipv4HeaderLen {
+		return nil, truncated("IPv4", "header", ErrMalformedIPv4).detailf("%d bytes are too short for a header", len(v))
+	}
+
+	if version := v[0] >> 4; version != 4 {
+		return nil, malformed("IPv4", "version", ErrMalformedIPv4).detailf("version %d", version)
+	}
+
+	hdrLen := int(v[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(v[2:4]))
+
+	if hdrLen < ipv4HeaderLen || total < hdrLen || total > len(v)+max(missing, 0) {
+		return nil, malformed("IPv4", "length", ErrMalformedIPv4).
+			detailf("header of %d and total length of %d bytes in %d bytes", hdrLen, total, len(v))
+	}
+
+	if hdrLen > len(v) {
+		return nil, truncated("IPv4", "options", ErrMalformedIPv4).
+			detailf("%d bytes are too short for a header of %d", len(v), hdrLen)
+	}
+
+	if total > len(v) {
+		return v, truncated("IPv4", "payload", ErrMalformedIPv4).detailf("%d of %d bytes", len(v), total)
+	}
+
+	return v[:total:total], nil
+}
+
+// HeaderLen returns the length of the header with its options
+func (v IPv4View) HeaderLen() int {
+	return int(v[0]&0x0f) * 4
+}
+
+// TotalLen returns the length of the packet
+func (v IPv4View) TotalLen() int {
+	return int(binary.BigEndian.Uint16(v[2:4]))
+}
+
+// ID returns the identification of the fragments of a datagram
+func (v IPv4View) ID() uint16 {
+	return binary.BigEndian.Uint16(v[4:6])
+}
+
+// DontFragment returns whether the datagram may not be fragmented
+func (v IPv4View) DontFragment() bool {
+	return v[6]&0x40 != 0
+}
+
+// MoreFragments returns whether fragments follow this one
+func (v IPv4View) MoreFragments() bool {
+	return v[6]&0x20 != 0
+}
+
+// FragmentOffset returns the offset of the fragment in bytes
+func (v IPv4View) FragmentOffset() int {
+	return int(binary.BigEndian.Uint16(v[6:8])&0x1fff) * 8
+}
+
+// TTL returns the time to live
+func (v IPv4View) TTL() uint8 {
+	return v[8]
+}
+
+// Protocol returns the protocol of the payload, such as 17 for UDP
+func (v IPv4View) Protocol() uint8 {
+	return v[9]
+}
+
+// Checksum returns the header checksum as on the wire
+func (v IPv4View) Checksum() uint16 {
+	return binary.BigEndian.Uint16(v[10:12])
+}
+
+// ChecksumValid returns whether the header checksum is correct
+func (v IPv4View) ChecksumValid() bool {
+	return checksum.Sum(v[:v.HeaderLen()]) == 0
+}
+
+// VerifyChecksum returns an error matching ErrChecksum unless the header
+// checksum is correct
+func (v IPv4View) VerifyChecksum() error {
+	if !v.ChecksumValid() {
+		return badChecksum("IPv4", "header checksum", nil).detailf("checksum 0x%04x", v.Checksum())
+	}
+
+	return nil
+}
+
+// Src returns the source address
+func (v IPv4View) Src() netip.Addr {
+	return netip.AddrFrom4([4]byte(v[12:16]))
+}
+
+// Dst returns the destination address
+func (v IPv4View) Dst() netip.Addr {
+	return netip.AddrFrom4([4]byte(v[16:20]))
+}
+
+// Options returns the options of the header, if any
+func (v IPv4View) Options() []byte {
+	return v[ipv4HeaderLen:v.HeaderLen():v.HeaderLen()]
+}
+
+// Payload returns the payload up to the total length, or what a capture
+// kept of it
+func (v IPv4View) Payload() []byte {
+	return v[v.HeaderLen():min(v.TotalLen(), len(v))]
+}
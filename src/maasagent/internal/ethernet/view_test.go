@@ -0,0 +1,258 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ethernet
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/checksum"
+)
+
+func TestEthernetFramePayloadReader(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	payload := []byte{0xde, 0xad, 0xbe, 0xef}
+
+	testcases := map[string]struct {
+		in  []byte
+		out []byte
+	}{
+		"untagged": {
+			in:  mustBuild(t, NewFrame().Src(src).Payload(EthernetTypeIPv4, payload)),
+			out: payload,
+		},
+		"802.1Q": {
+			in:  mustBuild(t, NewFrame().Src(src).VLAN(100).Payload(EthernetTypeIPv4, payload)),
+			out: payload,
+		},
+		"QinQ": {
+			in:  mustBuild(t, NewFrame().Src(src).VLAN(100, WithTPID(0x88a8)).VLAN(2).Payload(EthernetTypeIPv4, payload)),
+			out: payload,
+		},
+		"truncated tag": {
+			in:  concat(Broadcast, src, []byte{0x81, 0x00, 0x00, 0x64}),
+			out: []byte{0x00, 0x64},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			frame := &EthernetFrame{}
+			require.NoError(t, frame.UnmarshalBinary(tc.in))
+
+			out, err := io.ReadAll(frame.PayloadReader())
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestEthernetFrameARPView(t *testing.T) {
+	t.Parallel()
+
+	sender := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	target := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+
+	testcases := map[string]struct {
+		in  []byte
+		err error
+	}{
+		"request": {
+			in: arpFrame,
+		},
+		"tagged padded reply": {
+			in: mustBuild(t, NewFrame().Src(sender).VLAN(100).Padded().
+				ARPReply(netip.MustParseAddr("10.0.0.1"), target, netip.MustParseAddr("10.0.0.2"))),
+		},
+		"truncated": {
+			in:  arpFrame[:40],
+			err: ErrMalformedARPPacket,
+		},
+		"missing fields": {
+			in:  arpFrame[:20],
+			err: ErrMalformedARPPacket,
+		},
+		"IPv4": {
+			in:  mustBuild(t, NewFrame().Src(sender).DHCPDiscover(1)),
+			err: ErrNotARP,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			frame := &EthernetFrame{}
+			require.NoError(t, frame.UnmarshalBinary(tc.in))
+
+			view, err := frame.ARPView()
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			// the view must agree with the decoded packet
+			pkt, err := frame.ExtractARPPacket()
+			require.NoError(t, err)
+
+			assert.Equal(t, pkt.HardwareType, view.HardwareType())
+			assert.Equal(t, pkt.ProtocolType, view.ProtocolType())
+			assert.Equal(t, pkt.OpCode, view.OpCode())
+			assert.Equal(t, pkt.SendHwAddr, view.SenderHardwareAddr())
+			assert.Equal(t, pkt.SendProtoAddr, view.SenderProtocolAddr())
+			assert.Equal(t, pkt.TgtHwAddr, view.TargetHardwareAddr())
+			assert.Equal(t, pkt.TgtProtoAddr, view.TargetProtocolAddr())
+			assert.Equal(t, pkt.SenderAddr(), view.SenderAddr())
+			assert.Equal(t, pkt.TargetAddr(), view.TargetAddr())
+			assert.Len(t, view, 28)
+		})
+	}
+}
+
+func TestARPViewInPlace(t *testing.T) {
+	t.Parallel()
+
+	buf := append([]byte(nil), arpFrame...)
+	frame := &EthernetFrame{}
+	require.NoError(t, frame.UnmarshalBinary(buf))
+
+	view, err := frame.ARPView()
+	require.NoError(t, err)
+
+	// reusing the buffer changes what the view reads
+	buf[14+7] = byte(OpReply)
+	assert.Equal(t, OpReply, view.OpCode())
+
+	// the addresses can't grow over the next field
+	assert.Equal(t, 6, cap(view.SenderHardwareAddr()))
+}
+
+func TestEthernetFrameIPv4View(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	udp := mustBuild(t, NewFrame().Src(src).VLAN(10).Padded().UDP(
+		netip.MustParseAddrPort("10.0.0.1:1234"), netip.MustParseAddrPort("10.0.0.2:53"), []byte("q")))
+
+	frame := &EthernetFrame{}
+	require.NoError(t, frame.UnmarshalBinary(udp))
+
+	view, err := frame.IPv4View()
+	require.NoError(t, err)
+
+	assert.Equal(t, 20, view.HeaderLen())
+	assert.Equal(t, 29, view.TotalLen())
+	assert.Len(t, view, 29, "padding is not part of the view")
+	assert.Equal(t, uint8(64), view.TTL())
+	assert.Equal(t, uint8(checksum.ProtocolUDP), view.Protocol())
+	assert.True(t, view.ChecksumValid())
+	assert.False(t, view.DontFragment())
+	assert.False(t, view.MoreFragments())
+	assert.Zero(t, view.FragmentOffset())
+	assert.Zero(t, view.ID())
+	assert.Empty(t, view.Options())
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), view.Src())
+	assert.Equal(t, netip.MustParseAddr("10.0.0.2"), view.Dst())
+	assert.Len(t, view.Payload(), 9)
+	assert.Equal(t, []byte("q"), view.Payload()[8:])
+}
+
+func TestIPv4ViewValidated(t *testing.T) {
+	t.Parallel()
+
+	hdr := func(verIHL byte, total uint16, n int) IPv4View {
+		v := make(IPv4View, n)
+		v[0] = verIHL
+		v[2], v[3] = byte(total>>8), byte(total)
+
+		return v
+	}
+
+	testcases := map[string]struct {
+		in  IPv4View
+		err error
+	}{
+		"minimal": {
+			in: hdr(0x45, 20, 20),
+		},
+		"options": {
+			in: hdr(0x46, 24, 30),
+		},
+		"short": {
+			in:  hdr(0x45, 20, 19),
+			err: ErrMalformedIPv4,
+		},
+		"IPv6": {
+			in:  hdr(0x65, 20, 40),
+			err: ErrMalformedIPv4,
+		},
+		"header length under 20": {
+			in:  hdr(0x44, 20, 20),
+			err: ErrMalformedIPv4,
+		},
+		"total length under the header": {
+			in:  hdr(0x46, 20, 24),
+			err: ErrMalformedIPv4,
+		},
+		"total length over the buffer": {
+			in:  hdr(0x45, 21, 20),
+			err: ErrMalformedIPv4,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			view, err := tc.in.Validated()
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, view.Options(), view.HeaderLen()-20)
+			assert.Empty(t, view.Payload())
+		})
+	}
+}
+
+func TestEthernetFrameIPv4ViewNotIPv4(t *testing.T) {
+	t.Parallel()
+
+	frame := &EthernetFrame{}
+	require.NoError(t, frame.UnmarshalBinary(arpFrame))
+
+	_, err := frame.IPv4View()
+	assert.ErrorIs(t, err, ErrNotIPv4)
+}
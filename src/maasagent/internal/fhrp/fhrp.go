@@ -0,0 +1,171 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package fhrp decodes the first hop redundancy protocols routers use to
+// share a virtual gateway, so the movement of a gateway MAC during a
+// failover can be told apart from a misconfiguration.
+package fhrp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+var (
+	// ErrMalformedMessage is returned when a message is too short or its
+	// fields are inconsistent
+	ErrMalformedMessage = errors.New("malformed message")
+	// ErrUnsupportedVersion is returned for protocol versions which aren't
+	// decoded
+	ErrUnsupportedVersion = errors.New("unsupported version")
+)
+
+var (
+	// HSRPGroup is the destination of HSRPv1 messages
+	HSRPGroup = netip.MustParseAddr("224.0.0.2")
+	// HSRPv2Group is the destination of HSRPv2 and GLBP messages
+	HSRPv2Group = netip.MustParseAddr("224.0.0.102")
+	// HSRPv6Group is the destination of HSRP messages for IPv6 groups
+	HSRPv6Group = netip.MustParseAddr("ff02::66")
+)
+
+const (
+	// HSRPPort is the UDP port of HSRP for IPv4
+	HSRPPort = 1985
+	// HSRPv6Port is the UDP port of HSRP for IPv6
+	HSRPv6Port = 2029
+	// GLBPPort is the UDP port of GLBP
+	GLBPPort = 3222
+)
+
+// Protocol is a first hop redundancy protocol
+type Protocol uint8
+
+const (
+	// ProtocolVRRP is the Virtual Router Redundancy Protocol, RFC 5798
+	ProtocolVRRP Protocol = iota + 1
+	// ProtocolHSRP is the Cisco Hot Standby Router Protocol
+	ProtocolHSRP
+	// ProtocolGLBP is the Cisco Gateway Load Balancing Protocol
+	ProtocolGLBP
+)
+
+// String returns the name of the protocol
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolVRRP:
+		return "VRRP"
+	case ProtocolHSRP:
+		return "HSRP"
+	case ProtocolGLBP:
+		return "GLBP"
+	}
+
+	return fmt.Sprintf("Protocol(%d)", uint8(p))
+}
+
+// State is the state of a router in a redundancy group, the protocols
+// encode it differently on the wire
+type State uint8
+
+const (
+	// StateUnknown is a state code which isn't defined by the protocol
+	StateUnknown State = iota
+	// StateDisabled is a GLBP gateway which isn't configured
+	StateDisabled
+	// StateInitial is a router starting up
+	StateInitial
+	// StateLearn is an HSRP router which doesn't know the virtual IP yet
+	StateLearn
+	// StateListen is a router which is neither active nor standby
+	StateListen
+	// StateSpeak is a router taking part in the election
+	StateSpeak
+	// StateStandby is the router taking over if the active one fails
+	StateStandby
+	// StateActive is the router forwarding for the virtual IP
+	StateActive
+)
+
+var stateNames = map[State]string{
+	StateUnknown:  "Unknown",
+	StateDisabled: "Disabled",
+	StateInitial:  "Initial",
+	StateLearn:    "Learn",
+	StateListen:   "Listen",
+	StateSpeak:    "Speak",
+	StateStandby:  "Standby",
+	StateActive:   "Active",
+}
+
+// String returns the name of the state
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("State(%d)", uint8(s))
+}
+
+// virtualMAC is a range of MAC addresses used for virtual gateways
+type virtualMAC struct {
+	prefix   net.HardwareAddr
+	bits     int
+	protocol Protocol
+}
+
+// virtualMACs are the addresses the protocols derive from the group number,
+// a gateway configured with a burnt-in or custom MAC can't be classified by
+// its address alone
+var virtualMACs = []virtualMAC{
+	{prefix: net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x01, 0x00}, bits: 40, protocol: ProtocolVRRP},
+	{prefix: net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x02, 0x00}, bits: 40, protocol: ProtocolVRRP},
+	{prefix: net.HardwareAddr{0x00, 0x00, 0x0c, 0x07, 0xac, 0x00}, bits: 40, protocol: ProtocolHSRP},
+	// HSRPv2 has 12 bit group numbers
+	{prefix: net.HardwareAddr{0x00, 0x00, 0x0c, 0x9f, 0xf0, 0x00}, bits: 36, protocol: ProtocolHSRP},
+	// GLBP encodes the group and the forwarder number
+	{prefix: net.HardwareAddr{0x00, 0x07, 0xb4, 0x00, 0x00, 0x00}, bits: 24, protocol: ProtocolGLBP},
+}
+
+// VirtualMAC returns the protocol using mac as a virtual gateway address,
+// such addresses are expected to move between routers on a failover
+func VirtualMAC(mac net.HardwareAddr) (Protocol, bool) {
+	if len(mac) != 6 {
+		return 0, false
+	}
+
+	for _, v := range virtualMACs {
+		full := v.bits / 8
+
+		if !bytes.Equal(mac[:full], v.prefix[:full]) {
+			continue
+		}
+
+		if rem := v.bits % 8; rem != 0 {
+			mask := byte(0xff) << (8 - rem)
+
+			if mac[full]&mask != v.prefix[full] {
+				continue
+			}
+		}
+
+		return v.protocol, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fhrp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVirtualMAC(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in       string
+		protocol Protocol
+		ok       bool
+	}{
+		"VRRP IPv4": {
+			in:       "00:00:5e:00:01:0a",
+			protocol: ProtocolVRRP,
+			ok:       true,
+		},
+		"VRRP IPv6": {
+			in:       "00:00:5e:00:02:0a",
+			protocol: ProtocolVRRP,
+			ok:       true,
+		},
+		"IANA unicast outside VRRP": {
+			in: "00:00:5e:00:03:0a",
+		},
+		"HSRPv1": {
+			in:       "00:00:0c:07:ac:ff",
+			protocol: ProtocolHSRP,
+			ok:       true,
+		},
+		"HSRPv2": {
+			in:       "00:00:0c:9f:ff:ff",
+			protocol: ProtocolHSRP,
+			ok:       true,
+		},
+		"Cisco outside HSRPv2": {
+			in: "00:00:0c:9f:ef:ff",
+		},
+		"GLBP": {
+			in:       "00:07:b4:00:01:02",
+			protocol: ProtocolGLBP,
+			ok:       true,
+		},
+		"other": {
+			in: "00:16:3e:00:00:01",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			mac, err := net.ParseMAC(tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			protocol, ok := VirtualMAC(mac)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.protocol, protocol)
+		})
+	}
+}
+
+func TestVirtualMACLength(t *testing.T) {
+	t.Parallel()
+
+	_, ok := VirtualMAC(net.HardwareAddr{0x00, 0x07, 0xb4})
+	assert.False(t, ok)
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fhrp
+
+import (
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+func FuzzHSRP(f *testing.F) {
+	f.Add(hsrpv1Hello())
+	f.Add(hsrpv2Hello(4, []byte{192, 168, 1, 254}))
+	f.Add(hsrpv2Hello(6, make([]byte, 16)))
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			var h HSRP
+
+			if err := h.UnmarshalBinary(in); err == nil {
+				_ = h.State.String()
+			}
+		})
+	})
+}
+
+func FuzzGLBP(f *testing.F) {
+	f.Add(glbpMessage(glbpHello))
+	f.Add(glbpMessage(glbpHello, glbpForwarder))
+	f.Add(glbpMessage(glbpForwarder, glbpForwarder))
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			var g GLBP
+
+			_ = g.UnmarshalBinary(in)
+		})
+	})
+}
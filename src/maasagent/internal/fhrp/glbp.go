@@ -0,0 +1,155 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fhrp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	glbpHeaderLen = 12
+
+	glbpTLVHello   = 1
+	glbpTLVRequest = 2
+
+	glbpHelloLen   = 22
+	glbpRequestLen = 18
+
+	glbpAddressIPv4 = 1
+	glbpAddressIPv6 = 2
+)
+
+var glbpStates = map[uint8]State{
+	0x01: StateDisabled, 0x02: StateInitial, 0x04: StateListen, 0x08: StateSpeak, 0x10: StateStandby, 0x20: StateActive,
+}
+
+// GLBPForwarder is a virtual forwarder of a GLBP group, the active virtual
+// gateway hands out the MACs of the forwarders to balance the hosts
+type GLBPForwarder struct {
+	VirtualMAC net.HardwareAddr
+	Number     uint8
+	State      State
+	Priority   uint8
+	Weight     uint8
+}
+
+// GLBP is a GLBP message. Cisco doesn't document the protocol, only the
+// fields needed to follow the gateway and forwarder roles are decoded.
+type GLBP struct {
+	// VirtualIP is only set when the message carries a hello
+	VirtualIP netip.Addr
+	// Owner is the MAC address of the sending router
+	Owner      net.HardwareAddr
+	Forwarders []GLBPForwarder
+	HelloTime  time.Duration
+	HoldTime   time.Duration
+	Group      uint16
+	// State and Priority are those of the virtual gateway, they are only
+	// set when the message carries a hello
+	State    State
+	Priority uint8
+}
+
+// UnmarshalBinary parses the payload of a GLBP datagram
+func (g *GLBP) UnmarshalBinary(buf []byte) error {
+	if len(buf) < glbpHeaderLen {
+		return ErrMalformedMessage
+	}
+
+	if buf[0] != 1 {
+		return fmt.Errorf("%w: GLBP version %d", ErrUnsupportedVersion, buf[0])
+	}
+
+	*g = GLBP{
+		Group: binary.BigEndian.Uint16(buf[2:4]),
+		Owner: net.HardwareAddr(append([]byte(nil), buf[6:12]...)),
+	}
+
+	buf = buf[glbpHeaderLen:]
+
+	// unlike HSRP, the TLV length includes the type and length bytes
+	for len(buf) >= 2 {
+		typ, length := buf[0], int(buf[1])
+
+		if length < 2 || len(buf) < length {
+			return fmt.Errorf("%w: truncated TLV %d", ErrMalformedMessage, typ)
+		}
+
+		var err error
+
+		switch typ {
+		case glbpTLVHello:
+			err = g.unmarshalHello(buf[2:length])
+		case glbpTLVRequest:
+			err = g.unmarshalForwarder(buf[2:length])
+		}
+
+		if err != nil {
+			return err
+		}
+
+		buf = buf[length:]
+	}
+
+	return nil
+}
+
+func (g *GLBP) unmarshalHello(buf []byte) error {
+	if len(buf) < glbpHelloLen {
+		return fmt.Errorf("%w: hello TLV of %d bytes", ErrMalformedMessage, len(buf))
+	}
+
+	g.State = glbpStates[buf[1]]
+	g.Priority = buf[3]
+	g.HelloTime = time.Duration(binary.BigEndian.Uint32(buf[6:10])) * time.Millisecond
+	g.HoldTime = time.Duration(binary.BigEndian.Uint32(buf[10:14])) * time.Millisecond
+
+	addrType, addrLen := buf[20], int(buf[21])
+	addr := buf[glbpHelloLen:]
+
+	switch {
+	case len(addr) < addrLen:
+		return fmt.Errorf("%w: truncated virtual IP", ErrMalformedMessage)
+	case addrType == glbpAddressIPv4 && addrLen == 4:
+		g.VirtualIP = netip.AddrFrom4([4]byte(addr))
+	case addrType == glbpAddressIPv6 && addrLen == 16:
+		g.VirtualIP = netip.AddrFrom16([16]byte(addr))
+	default:
+		return fmt.Errorf("%w: virtual IP of type %d and length %d", ErrMalformedMessage, addrType, addrLen)
+	}
+
+	return nil
+}
+
+func (g *GLBP) unmarshalForwarder(buf []byte) error {
+	if len(buf) < glbpRequestLen {
+		return fmt.Errorf("%w: request/response TLV of %d bytes", ErrMalformedMessage, len(buf))
+	}
+
+	g.Forwarders = append(g.Forwarders, GLBPForwarder{
+		Number:     buf[0],
+		State:      glbpStates[buf[1]],
+		Priority:   buf[3],
+		Weight:     buf[4],
+		VirtualMAC: net.HardwareAddr(append([]byte(nil), buf[12:18]...)),
+	})
+
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fhrp
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	glbpHeader = []byte{
+		0x01, 0x00, 0x00, 0x0a, 0x00, 0x00,
+		0x00, 0x16, 0x3e, 0x00, 0x00, 0x01,
+	}
+	glbpHello = []byte{
+		0x01, 0x1c,
+		0x00, 0x20, 0x00, 0x64, 0x00, 0x00,
+		0x00, 0x00, 0x0b, 0xb8,
+		0x00, 0x00, 0x27, 0x10,
+		0x02, 0x58, 0x38, 0x40, 0x00, 0x00,
+		0x01, 0x04, 10, 0, 0, 1,
+	}
+	glbpForwarder = []byte{
+		0x02, 0x14,
+		0x01, 0x20, 0x00, 0xa7, 0x64,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x07, 0xb4, 0x00, 0x0a, 0x01,
+	}
+)
+
+func glbpMessage(tlvs ...[]byte) []byte {
+	msg := append([]byte(nil), glbpHeader...)
+
+	for _, tlv := range tlvs {
+		msg = append(msg, tlv...)
+	}
+
+	return msg
+}
+
+func TestGLBPUnmarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	owner := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	testcases := map[string]struct {
+		in  []byte
+		out GLBP
+		err error
+	}{
+		"hello and forwarder": {
+			in: glbpMessage(glbpHello, glbpForwarder),
+			out: GLBP{
+				VirtualIP: netip.MustParseAddr("10.0.0.1"),
+				Owner:     owner,
+				Forwarders: []GLBPForwarder{
+					{
+						VirtualMAC: net.HardwareAddr{0x00, 0x07, 0xb4, 0x00, 0x0a, 0x01},
+						Number:     1,
+						State:      StateActive,
+						Priority:   167,
+						Weight:     100,
+					},
+				},
+				HelloTime: 3 * time.Second,
+				HoldTime:  10 * time.Second,
+				Group:     10,
+				State:     StateActive,
+				Priority:  100,
+			},
+		},
+		"forwarder only": {
+			in: glbpMessage(glbpForwarder),
+			out: GLBP{
+				Owner: owner,
+				Forwarders: []GLBPForwarder{
+					{
+						VirtualMAC: net.HardwareAddr{0x00, 0x07, 0xb4, 0x00, 0x0a, 0x01},
+						Number:     1,
+						State:      StateActive,
+						Priority:   167,
+						Weight:     100,
+					},
+				},
+				Group: 10,
+			},
+		},
+		"unknown TLV": {
+			in: glbpMessage([]byte{0x03, 0x04, 0x00, 0x00}),
+			out: GLBP{
+				Owner: owner,
+				Group: 10,
+			},
+		},
+		"truncated header": {
+			in:  glbpHeader[:11],
+			err: ErrMalformedMessage,
+		},
+		"truncated TLV": {
+			in:  glbpMessage(glbpHello[:20]),
+			err: ErrMalformedMessage,
+		},
+		"zero length TLV": {
+			in:  glbpMessage([]byte{0x03, 0x00}),
+			err: ErrMalformedMessage,
+		},
+		"short hello": {
+			in:  glbpMessage([]byte{0x01, 0x06, 0x00, 0x20, 0x00, 0x64}),
+			err: ErrMalformedMessage,
+		},
+		"bad version": {
+			in:  append([]byte{0x02}, glbpHeader[1:]...),
+			err: ErrUnsupportedVersion,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var g GLBP
+
+			err := g.UnmarshalBinary(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, g)
+		})
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fhrp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	hsrpv1Len = 20
+
+	hsrpTLVGroupState = 1
+	hsrpGroupStateLen = 40
+)
+
+// HSRPOpCode is the type of an HSRP message
+type HSRPOpCode uint8
+
+const (
+	// HSRPHello is sent periodically by the active and standby routers
+	HSRPHello HSRPOpCode = 0
+	// HSRPCoup is sent by a router taking over the active role
+	HSRPCoup HSRPOpCode = 1
+	// HSRPResign is sent by the active router giving up its role
+	HSRPResign HSRPOpCode = 2
+)
+
+// String returns the name of the opcode
+func (o HSRPOpCode) String() string {
+	switch o {
+	case HSRPHello:
+		return "Hello"
+	case HSRPCoup:
+		return "Coup"
+	case HSRPResign:
+		return "Resign"
+	}
+
+	return fmt.Sprintf("HSRPOpCode(%d)", uint8(o))
+}
+
+// the state codes of HSRPv1 are bit flags, those of HSRPv2 are sequential
+var (
+	hsrpv1States = map[uint8]State{
+		0: StateInitial, 1: StateLearn, 2: StateListen, 4: StateSpeak, 8: StateStandby, 16: StateActive,
+	}
+	hsrpv2States = map[uint8]State{
+		0: StateDisabled, 1: StateInitial, 2: StateLearn, 3: StateListen, 4: StateSpeak, 5: StateStandby, 6: StateActive,
+	}
+)
+
+// HSRP is the group state advertised by an HSRP router, RFC 2281 defines
+// version 1, version 2 is only documented by Cisco
+type HSRP struct {
+	// VirtualIP is invalid while a router is learning it
+	VirtualIP netip.Addr
+	// Identifier is the MAC address of the sending router, it is only
+	// carried by version 2
+	Identifier net.HardwareAddr
+	HelloTime  time.Duration
+	HoldTime   time.Duration
+	Priority   uint32
+	Group      uint16
+	// Version is 1 or 2, version 1 appears as 0 on the wire
+	Version uint8
+	OpCode  HSRPOpCode
+	State   State
+}
+
+// UnmarshalBinary parses the payload of an HSRP datagram
+func (h *HSRP) UnmarshalBinary(buf []byte) error {
+	if len(buf) == 0 {
+		return ErrMalformedMessage
+	}
+
+	if buf[0] == 0 {
+		return h.unmarshalV1(buf)
+	}
+
+	// version 2 is a sequence of TLVs, only the group state is decoded,
+	// authentication TLVs are skipped
+	for len(buf) >= 2 {
+		typ, length := buf[0], int(buf[1])
+
+		if len(buf) < 2+length {
+			return fmt.Errorf("%w: truncated TLV %d", ErrMalformedMessage, typ)
+		}
+
+		if typ == hsrpTLVGroupState {
+			return h.unmarshalGroupState(buf[2 : 2+length])
+		}
+
+		buf = buf[2+length:]
+	}
+
+	return fmt.Errorf("%w: no group state TLV", ErrMalformedMessage)
+}
+
+func (h *HSRP) unmarshalV1(buf []byte) error {
+	if len(buf) < hsrpv1Len {
+		return ErrMalformedMessage
+	}
+
+	*h = HSRP{
+		Version:   1,
+		OpCode:    HSRPOpCode(buf[1]),
+		State:     hsrpv1States[buf[2]],
+		HelloTime: time.Duration(buf[3]) * time.Second,
+		HoldTime:  time.Duration(buf[4]) * time.Second,
+		Priority:  uint32(buf[5]),
+		Group:     uint16(buf[6]),
+	}
+
+	// bytes 8 to 16 hold the clear text authentication data
+	if ip := netip.AddrFrom4([4]byte(buf[16:20])); !ip.IsUnspecified() {
+		h.VirtualIP = ip
+	}
+
+	return nil
+}
+
+func (h *HSRP) unmarshalGroupState(buf []byte) error {
+	if len(buf) < hsrpGroupStateLen {
+		return fmt.Errorf("%w: group state TLV of %d bytes", ErrMalformedMessage, len(buf))
+	}
+
+	if buf[0] != 2 {
+		return fmt.Errorf("%w: HSRP version %d", ErrUnsupportedVersion, buf[0])
+	}
+
+	*h = HSRP{
+		Version:    2,
+		OpCode:     HSRPOpCode(buf[1]),
+		State:      hsrpv2States[buf[2]],
+		Group:      binary.BigEndian.Uint16(buf[4:6]),
+		Identifier: net.HardwareAddr(append([]byte(nil), buf[6:12]...)),
+		Priority:   binary.BigEndian.Uint32(buf[12:16]),
+		HelloTime:  time.Duration(binary.BigEndian.Uint32(buf[16:20])) * time.Millisecond,
+		HoldTime:   time.Duration(binary.BigEndian.Uint32(buf[20:24])) * time.Millisecond,
+	}
+
+	var ip netip.Addr
+
+	switch buf[3] {
+	case 4:
+		ip = netip.AddrFrom4([4]byte(buf[24:28]))
+	case 6:
+		ip = netip.AddrFrom16([16]byte(buf[24:40]))
+	default:
+		return fmt.Errorf("%w: IP version %d", ErrMalformedMessage, buf[3])
+	}
+
+	if !ip.IsUnspecified() {
+		h.VirtualIP = ip
+	}
+
+	return nil
+}
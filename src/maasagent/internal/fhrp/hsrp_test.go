@@ -0,0 +1,159 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fhrp
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hsrpv1Hello() []byte {
+	return []byte{
+		0x00, 0x00, 0x10, 0x03, 0x0a, 0x78, 0x01, 0x00,
+		'c', 'i', 's', 'c', 'o', 0x00, 0x00, 0x00,
+		192, 168, 1, 254,
+	}
+}
+
+func hsrpv2Hello(ipVersion byte, ip []byte) []byte {
+	tlv := []byte{
+		0x01, 0x28,
+		0x02, 0x00, 0x06, ipVersion,
+		0x01, 0x02,
+		0x00, 0x16, 0x3e, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x96,
+		0x00, 0x00, 0x0b, 0xb8,
+		0x00, 0x00, 0x27, 0x10,
+	}
+	tlv = append(tlv, ip...)
+
+	return append(tlv, make([]byte, 42-len(tlv))...)
+}
+
+func TestHSRPUnmarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	auth := []byte{0x03, 0x08, 'c', 'i', 's', 'c', 'o', 0x00, 0x00, 0x00}
+
+	testcases := map[string]struct {
+		in  []byte
+		out HSRP
+		err error
+	}{
+		"v1 active": {
+			in: hsrpv1Hello(),
+			out: HSRP{
+				VirtualIP: netip.MustParseAddr("192.168.1.254"),
+				HelloTime: 3 * time.Second,
+				HoldTime:  10 * time.Second,
+				Priority:  120,
+				Group:     1,
+				Version:   1,
+				OpCode:    HSRPHello,
+				State:     StateActive,
+			},
+		},
+		"v1 learning": {
+			in: append([]byte{0x00, 0x00, 0x01}, make([]byte, 17)...),
+			out: HSRP{
+				Version: 1,
+				State:   StateLearn,
+			},
+		},
+		"v2 IPv4": {
+			in: hsrpv2Hello(4, []byte{10, 0, 0, 1}),
+			out: HSRP{
+				VirtualIP:  netip.MustParseAddr("10.0.0.1"),
+				Identifier: net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01},
+				HelloTime:  3 * time.Second,
+				HoldTime:   10 * time.Second,
+				Priority:   150,
+				Group:      258,
+				Version:    2,
+				OpCode:     HSRPHello,
+				State:      StateActive,
+			},
+		},
+		"v2 IPv6 after authentication": {
+			in: append(auth, hsrpv2Hello(6, netip.MustParseAddr("fe80::1").AsSlice())...),
+			out: HSRP{
+				VirtualIP:  netip.MustParseAddr("fe80::1"),
+				Identifier: net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01},
+				HelloTime:  3 * time.Second,
+				HoldTime:   10 * time.Second,
+				Priority:   150,
+				Group:      258,
+				Version:    2,
+				OpCode:     HSRPHello,
+				State:      StateActive,
+			},
+		},
+		"v1 truncated": {
+			in:  hsrpv1Hello()[:19],
+			err: ErrMalformedMessage,
+		},
+		"v2 truncated": {
+			in:  hsrpv2Hello(4, []byte{10, 0, 0, 1})[:30],
+			err: ErrMalformedMessage,
+		},
+		"v2 without group state": {
+			in:  auth,
+			err: ErrMalformedMessage,
+		},
+		"v2 bad IP version": {
+			in:  hsrpv2Hello(5, nil),
+			err: ErrMalformedMessage,
+		},
+		"empty": {
+			err: ErrMalformedMessage,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var h HSRP
+
+			err := h.UnmarshalBinary(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, h)
+		})
+	}
+}
+
+func TestHSRPUnsupportedVersion(t *testing.T) {
+	t.Parallel()
+
+	msg := hsrpv2Hello(4, []byte{10, 0, 0, 1})
+	msg[2] = 3
+
+	var h HSRP
+
+	assert.ErrorIs(t, h.UnmarshalBinary(msg), ErrUnsupportedVersion)
+}
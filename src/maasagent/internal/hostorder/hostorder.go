@@ -0,0 +1,126 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package hostorder reads and writes the structures the kernel shares with
+// the agent, such as the netlink messages, the control messages of the
+// packet sockets and the records of the eBPF programs, in the byte order of
+// the host. What comes from the wire is in network byte order and read
+// with encoding/binary.BigEndian, never with these helpers.
+package hostorder
+
+import (
+	"encoding/binary"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// SizeofAuxdata is the size of a struct tpacket_auxdata
+	SizeofAuxdata = 20
+	// SizeofTimespec is the size of a struct timespec, two words
+	SizeofTimespec = int(unsafe.Sizeof(unix.Timespec{}))
+)
+
+// Order is the byte order of the host, for the whole structures read with
+// binary.Read or binary.Decode
+var Order binary.ByteOrder = binary.NativeEndian
+
+// Uint16 reads a host order uint16 from b
+func Uint16(b []byte) uint16 {
+	return binary.NativeEndian.Uint16(b)
+}
+
+// Uint32 reads a host order uint32 from b
+func Uint32(b []byte) uint32 {
+	return binary.NativeEndian.Uint32(b)
+}
+
+// Uint64 reads a host order uint64 from b
+func Uint64(b []byte) uint64 {
+	return binary.NativeEndian.Uint64(b)
+}
+
+// PutUint16 writes v to b in host order
+func PutUint16(b []byte, v uint16) {
+	binary.NativeEndian.PutUint16(b, v)
+}
+
+// PutUint32 writes v to b in host order
+func PutUint32(b []byte, v uint32) {
+	binary.NativeEndian.PutUint32(b, v)
+}
+
+// PutUint64 writes v to b in host order
+func PutUint64(b []byte, v uint64) {
+	binary.NativeEndian.PutUint64(b, v)
+}
+
+// AppendUint16 appends v to b in host order
+func AppendUint16(b []byte, v uint16) []byte {
+	return binary.NativeEndian.AppendUint16(b, v)
+}
+
+// AppendUint32 appends v to b in host order
+func AppendUint32(b []byte, v uint32) []byte {
+	return binary.NativeEndian.AppendUint32(b, v)
+}
+
+// Htons returns the host order value whose bytes are v in network order,
+// as the protocol of sockaddr_ll and socket(2) is given
+func Htons(v uint16) uint16 {
+	var b [2]byte
+
+	binary.BigEndian.PutUint16(b[:], v)
+
+	return Uint16(b[:])
+}
+
+// Auxdata reads the struct tpacket_auxdata of a PACKET_AUXDATA control
+// message, false when b is too short
+func Auxdata(b []byte) (unix.TpacketAuxdata, bool) {
+	if len(b) < SizeofAuxdata {
+		return unix.TpacketAuxdata{}, false
+	}
+
+	return unix.TpacketAuxdata{
+		Status:    Uint32(b[0:4]),
+		Len:       Uint32(b[4:8]),
+		Snaplen:   Uint32(b[8:12]),
+		Mac:       Uint16(b[12:14]),
+		Net:       Uint16(b[14:16]),
+		Vlan_tci:  Uint16(b[16:18]),
+		Vlan_tpid: Uint16(b[18:20]),
+	}, true
+}
+
+// Timespec reads the struct timespec at the start of b, whose words are
+// of the size of those of the host, false when b is too short
+func Timespec(b []byte) (time.Time, bool) {
+	if len(b) < SizeofTimespec {
+		return time.Time{}, false
+	}
+
+	if SizeofTimespec == 8 {
+		sec, nsec := int32(Uint32(b[0:4])), int32(Uint32(b[4:8])) //nolint:gosec // the words are signed
+
+		return time.Unix(int64(sec), int64(nsec)), true
+	}
+
+	sec, nsec := int64(Uint64(b[0:8])), int64(Uint64(b[8:16])) //nolint:gosec // the words are signed
+
+	return time.Unix(sec, nsec), true
+}
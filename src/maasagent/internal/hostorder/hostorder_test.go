@@ -0,0 +1,109 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hostorder
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// bigEndian returns true if the host is big-endian
+func bigEndian() bool {
+	v := uint16(1)
+
+	return *(*byte)(unsafe.Pointer(&v)) == 0
+}
+
+func TestOrder(t *testing.T) {
+	t.Parallel()
+
+	b := AppendUint32(AppendUint16(nil, 0x0102), 0x03040506)
+	b = binary.NativeEndian.AppendUint64(b, 0x0708090a0b0c0d0e)
+
+	if bigEndian() {
+		assert.Equal(t, []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}, b[:6])
+	} else {
+		assert.Equal(t, []byte{0x02, 0x01, 0x06, 0x05, 0x04, 0x03}, b[:6])
+	}
+
+	assert.Equal(t, uint16(0x0102), Uint16(b[0:2]))
+	assert.Equal(t, uint32(0x03040506), Uint32(b[2:6]))
+	assert.Equal(t, uint64(0x0708090a0b0c0d0e), Uint64(b[6:14]))
+	assert.Equal(t, uint16(0x0102), Order.Uint16(b[0:2]))
+
+	var put [14]byte
+
+	PutUint16(put[0:2], 0x0102)
+	PutUint32(put[2:6], 0x03040506)
+	PutUint64(put[6:14], 0x0708090a0b0c0d0e)
+	assert.Equal(t, b, put[:])
+}
+
+func TestHtons(t *testing.T) {
+	t.Parallel()
+
+	var b [2]byte
+
+	// the bytes of the value are in network order, whatever the host
+	binary.NativeEndian.PutUint16(b[:], Htons(unix.ETH_P_ALL))
+	assert.Equal(t, [2]byte{0x00, 0x03}, b)
+}
+
+func TestAuxdata(t *testing.T) {
+	t.Parallel()
+
+	want := unix.TpacketAuxdata{
+		Status:    unix.TP_STATUS_VLAN_VALID | unix.TP_STATUS_VLAN_TPID_VALID,
+		Len:       1514,
+		Snaplen:   128,
+		Mac:       2,
+		Net:       18,
+		Vlan_tci:  100,
+		Vlan_tpid: 0x88a8,
+	}
+
+	require.Equal(t, int(unsafe.Sizeof(want)), SizeofAuxdata)
+
+	// the kernel copies the structure as it is in memory
+	b := unsafe.Slice((*byte)(unsafe.Pointer(&want)), SizeofAuxdata)
+
+	aux, ok := Auxdata(b)
+	require.True(t, ok)
+	assert.Equal(t, want, aux)
+
+	_, ok = Auxdata(b[:SizeofAuxdata-1])
+	assert.False(t, ok)
+}
+
+func TestTimespec(t *testing.T) {
+	t.Parallel()
+
+	want := unix.NsecToTimespec(time.Unix(1700000000, 123456789).UnixNano())
+	b := unsafe.Slice((*byte)(unsafe.Pointer(&want)), SizeofTimespec)
+
+	ts, ok := Timespec(b)
+	require.True(t, ok)
+	assert.Equal(t, time.Unix(1700000000, 123456789), ts)
+
+	_, ok = Timespec(b[:SizeofTimespec-1])
+	assert.False(t, ok)
+}
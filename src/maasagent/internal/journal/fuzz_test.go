@@ -0,0 +1,77 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+func FuzzOpen(f *testing.F) {
+	seed := filepath.Join(f.TempDir(), "seed")
+
+	j, err := Open(seed)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, r := range []string{"new", "moved"} {
+		if _, err := j.Append([]byte(r)); err != nil {
+			f.Fatal(err)
+		}
+	}
+
+	if err := j.Close(); err != nil {
+		f.Fatal(err)
+	}
+
+	data, err := os.ReadFile(seed)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(data)
+	f.Add(data[:len(data)-1])
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		path := filepath.Join(t.TempDir(), "events")
+		if err := os.WriteFile(path, in, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		fuzz.Bounded(t, in, func() {
+			j, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer j.Close()
+
+			// the valid prefix is replayed whole, and the journal can
+			// be appended to after it
+			if _, err := j.Replay(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := j.Append([]byte("next")); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+}
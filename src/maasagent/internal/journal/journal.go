@@ -0,0 +1,510 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package journal is an append-only file of records, each numbered and
+// checksummed, kept until a consumer acknowledges having delivered it. The
+// records not yet acknowledged survive a crash and are replayed on the next
+// start, the tail a crash left half written is dropped.
+//
+// A record is framed as
+//
+//	length (4 bytes) | CRC-32C (4 bytes) | sequence (8 bytes) | data
+//
+// in network byte order, the checksum covering the sequence and the data.
+// The last acknowledged sequence is kept next to the journal, in a file of
+// the same name ending in .ack.
+package journal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/atomicfile"
+)
+
+const (
+	headerLen = 16
+	// MaxRecordLen bounds the data of a record, a longer length read back
+	// is corruption
+	MaxRecordLen = 1 << 20
+
+	defaultMaxSize = 64 << 20
+	// defaultCompactSize is the acknowledged prefix left in the file
+	// before it is rewritten without it
+	defaultCompactSize = 1 << 20
+
+	ackSuffix = ".ack"
+)
+
+var (
+	// ErrFull is returned by Append when the records not acknowledged fill
+	// the journal
+	ErrFull = errors.New("journal is full")
+	// ErrTooLarge is returned by Append for data longer than MaxRecordLen
+	ErrTooLarge = errors.New("record too large")
+	// ErrUnknownSequence is returned by Ack for a sequence not appended yet
+	ErrUnknownSequence = errors.New("unknown sequence")
+	// ErrClosed is returned once the journal is closed
+	ErrClosed = errors.New("journal is closed")
+	// ErrCorruptAck is returned by Open when the acknowledgement file
+	// can't be parsed
+	ErrCorruptAck = errors.New("corrupt journal acknowledgement")
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errChecksum = errors.New("record checksum mismatch")
+)
+
+// Record is a record of the journal
+type Record struct {
+	Data []byte
+	Seq  uint64
+}
+
+// pending is a record not acknowledged yet, at offset in the file
+type pending struct {
+	seq    uint64
+	offset int64
+}
+
+// Journal is an append-only file of records. It is safe for concurrent use.
+type Journal struct {
+	file *os.File
+	path string
+	// pending are the records not acknowledged, in order
+	pending     []pending
+	size        int64
+	maxSize     int64
+	compactSize int64
+	dropped     int64
+	last        uint64
+	acked       uint64
+	mu          sync.Mutex
+}
+
+// Option configures a Journal
+type Option func(*Journal)
+
+// WithMaxSize bounds the file, Append fails with ErrFull rather than grow it
+// past n bytes
+func WithMaxSize(n int64) Option {
+	return func(j *Journal) {
+		if n > 0 {
+			j.maxSize = n
+		}
+	}
+}
+
+// WithCompactSize sets how large the acknowledged prefix of the file grows
+// before Ack rewrites the file without it
+func WithCompactSize(n int64) Option {
+	return func(j *Journal) {
+		if n > 0 {
+			j.compactSize = n
+		}
+	}
+}
+
+// Open opens the journal at path, creating it if it doesn't exist. The
+// records after the first one failing its checksum, or framed past the end
+// of the file, are dropped and the file truncated after the valid prefix.
+func Open(path string, options ...Option) (*Journal, error) {
+	j := &Journal{
+		path:        path,
+		maxSize:     defaultMaxSize,
+		compactSize: defaultCompactSize,
+	}
+
+	for _, opt := range options {
+		opt(j)
+	}
+
+	acked, err := readAck(path + ackSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	j.acked, j.last = acked, acked
+
+	f, err := os.OpenFile(filepath.Clean(path), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	j.file = f
+
+	if err := j.scan(); err != nil {
+		//nolint:errcheck,gosec // we already return a more important error
+		f.Close()
+
+		return nil, err
+	}
+
+	if j.dropped > 0 {
+		log.Warn().Str("journal", path).Int64("bytes", j.dropped).Msg("Dropped the corrupt tail of the journal")
+	}
+
+	return j, nil
+}
+
+// readAck returns the last acknowledged sequence, 0 without a file
+func readAck(path string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read journal acknowledgement: %w", err)
+	}
+
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrCorruptAck, err)
+	}
+
+	return seq, nil
+}
+
+// scan indexes the records not acknowledged and truncates the file after
+// the last valid one
+func (j *Journal) scan() error {
+	info, err := j.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat journal: %w", err)
+	}
+
+	var (
+		offset int64
+		seen   uint64
+		header [headerLen]byte
+	)
+
+	r := io.NewSectionReader(j.file, 0, info.Size())
+
+	for {
+		seq, n, err := readRecord(r, info.Size(), offset, header[:], nil)
+		if err != nil || (offset > 0 && seq <= seen) {
+			break
+		}
+
+		if seq > j.acked {
+			j.pending = append(j.pending, pending{seq: seq, offset: offset})
+		}
+
+		seen = seq
+		offset += n
+	}
+
+	j.last = max(j.last, seen)
+	j.size = offset
+	j.dropped = info.Size() - offset
+
+	if j.dropped > 0 {
+		if err := j.file.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate journal: %w", err)
+		}
+
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync journal: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// readRecord reads the record at offset of a file of size bytes, it returns
+// its sequence and its framed length. The data is read into data, when not
+// nil.
+func readRecord(r io.ReaderAt, size, offset int64, header []byte, data *[]byte) (uint64, int64, error) {
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return 0, 0, err
+	}
+
+	// the length is checked before allocating, it may be garbage
+	n := binary.BigEndian.Uint32(header[0:4])
+	if n > MaxRecordLen {
+		return 0, 0, fmt.Errorf("%w: %d bytes read back", ErrTooLarge, n)
+	}
+
+	if offset+headerLen+int64(n) > size {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, offset+headerLen); err != nil {
+		return 0, 0, err
+	}
+
+	crc := crc32.Update(crc32.Checksum(header[8:16], castagnoli), castagnoli, buf)
+	if crc != binary.BigEndian.Uint32(header[4:8]) {
+		return 0, 0, errChecksum
+	}
+
+	if data != nil {
+		*data = buf
+	}
+
+	return binary.BigEndian.Uint64(header[8:16]), headerLen + int64(n), nil
+}
+
+// Append writes data as the next record and syncs it to disk before
+// returning its sequence
+func (j *Journal) Append(data []byte) (uint64, error) {
+	if len(data) > MaxRecordLen {
+		return 0, ErrTooLarge
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return 0, ErrClosed
+	}
+
+	n := int64(headerLen + len(data))
+
+	if j.size+n > j.maxSize {
+		// the acknowledged prefix may be all there is to make room
+		if err := j.compact(); err != nil {
+			return 0, err
+		}
+
+		if j.size+n > j.maxSize {
+			return 0, ErrFull
+		}
+	}
+
+	seq := j.last + 1
+
+	buf := make([]byte, headerLen, n)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data))) //nolint:gosec // bounded by MaxRecordLen
+	binary.BigEndian.PutUint64(buf[8:16], seq)
+	buf = append(buf, data...)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(buf[8:], castagnoli))
+
+	if _, err := j.file.WriteAt(buf, j.size); err != nil {
+		return 0, fmt.Errorf("failed to append to journal: %w", err)
+	}
+
+	if err := j.file.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync journal: %w", err)
+	}
+
+	j.pending = append(j.pending, pending{seq: seq, offset: j.size})
+	j.size += n
+	j.last = seq
+
+	return seq, nil
+}
+
+// Ack records that the records up to seq were delivered, they won't be
+// replayed. The file is rewritten without them once they take more than the
+// compaction size.
+func (j *Journal) Ack(seq uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return ErrClosed
+	}
+
+	if seq > j.last {
+		return fmt.Errorf("%w: %d, the last is %d", ErrUnknownSequence, seq, j.last)
+	}
+
+	if seq <= j.acked {
+		return nil
+	}
+
+	if err := atomicfile.WriteFile(j.path+ackSuffix, []byte(strconv.FormatUint(seq, 10)+"\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to write journal acknowledgement: %w", err)
+	}
+
+	j.acked = seq
+
+	i := 0
+	for i < len(j.pending) && j.pending[i].seq <= seq {
+		i++
+	}
+
+	j.pending = j.pending[i:]
+
+	if j.ackedSize() >= j.compactSize {
+		return j.compact()
+	}
+
+	return nil
+}
+
+// ackedSize returns the length of the acknowledged prefix of the file
+func (j *Journal) ackedSize() int64 {
+	if len(j.pending) == 0 {
+		return j.size
+	}
+
+	return j.pending[0].offset
+}
+
+// compact rewrites the file without its acknowledged prefix. The records
+// are copied to a temporary file renamed over the journal, and the directory
+// synced, a crash leaves either file whole.
+func (j *Journal) compact() error {
+	start := j.ackedSize()
+	if start == 0 {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+
+	defer func() {
+		//nolint:errcheck,gosec // the file is renamed away unless the compaction failed
+		os.Remove(tmp.Name())
+	}()
+
+	_, err = io.Copy(tmp, io.NewSectionReader(j.file, start, j.size-start))
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Clean(j.path), os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen journal: %w", err)
+	}
+
+	//nolint:errcheck,gosec // the old file was only read since the last sync
+	j.file.Close()
+
+	j.file = f
+	j.size -= start
+
+	for i := range j.pending {
+		j.pending[i].offset -= start
+	}
+
+	// the rename is only durable once the directory is, until then a crash
+	// may bring the old file back alongside the acknowledgement
+	if err := syncDir(filepath.Dir(j.path)); err != nil {
+		return fmt.Errorf("failed to sync journal directory: %w", err)
+	}
+
+	return nil
+}
+
+// syncDir flushes the entries of dir, such as a file renamed into it
+func syncDir(dir string) error {
+	d, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Replay returns the records not acknowledged, in order
+func (j *Journal) Replay() ([]Record, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil, ErrClosed
+	}
+
+	records := make([]Record, 0, len(j.pending))
+
+	var header [headerLen]byte
+
+	for _, p := range j.pending {
+		var data []byte
+
+		if _, _, err := readRecord(j.file, j.size, p.offset, header[:], &data); err != nil {
+			return nil, fmt.Errorf("failed to read record %d: %w", p.seq, err)
+		}
+
+		records = append(records, Record{Seq: p.seq, Data: data})
+	}
+
+	return records, nil
+}
+
+// Acked returns the last acknowledged sequence
+func (j *Journal) Acked() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.acked
+}
+
+// Last returns the sequence of the last record appended
+func (j *Journal) Last() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.last
+}
+
+// Dropped returns the bytes of corrupt tail Open dropped
+func (j *Journal) Dropped() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.dropped
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return ErrClosed
+	}
+
+	err := j.file.Close()
+	j.file = nil
+
+	return err
+}
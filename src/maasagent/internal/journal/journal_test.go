@@ -0,0 +1,253 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package journal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openT(t *testing.T, path string, options ...Option) *Journal {
+	t.Helper()
+
+	j, err := Open(path, options...)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		//nolint:errcheck // closed by the tests reopening the journal
+		j.Close()
+	})
+
+	return j
+}
+
+func appendT(t *testing.T, j *Journal, records ...string) {
+	t.Helper()
+
+	for _, r := range records {
+		_, err := j.Append([]byte(r))
+		require.NoError(t, err)
+	}
+}
+
+func replayed(t *testing.T, j *Journal) []string {
+	t.Helper()
+
+	records, err := j.Replay()
+	require.NoError(t, err)
+
+	out := make([]string, 0, len(records))
+	for _, r := range records {
+		out = append(out, fmt.Sprintf("%d:%s", r.Seq, r.Data))
+	}
+
+	return out
+}
+
+func TestJournalReplay(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events")
+
+	j := openT(t, path)
+	appendT(t, j, "new", "moved", "refreshed")
+	require.NoError(t, j.Ack(1))
+
+	assert.Equal(t, []string{"2:moved", "3:refreshed"}, replayed(t, j))
+
+	// the records not acknowledged survive a restart
+	require.NoError(t, j.Close())
+
+	j = openT(t, path)
+
+	assert.Equal(t, []string{"2:moved", "3:refreshed"}, replayed(t, j))
+	assert.Equal(t, uint64(1), j.Acked())
+	assert.Equal(t, uint64(3), j.Last())
+	assert.Zero(t, j.Dropped())
+
+	seq, err := j.Append([]byte("new"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), seq)
+}
+
+func TestJournalAck(t *testing.T) {
+	t.Parallel()
+
+	j := openT(t, filepath.Join(t.TempDir(), "events"))
+	appendT(t, j, "a", "b")
+
+	require.NoError(t, j.Ack(2))
+	// acknowledging again is a no-op
+	require.NoError(t, j.Ack(1))
+	assert.Equal(t, uint64(2), j.Acked())
+	assert.Empty(t, replayed(t, j))
+
+	assert.ErrorIs(t, j.Ack(3), ErrUnknownSequence)
+}
+
+func TestJournalCorruptTail(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		corrupt func(data []byte) []byte
+		out     []string
+		dropped int
+	}{
+		"torn header": {
+			corrupt: func(data []byte) []byte { return append(data, 0x00, 0x00, 0x00) },
+			out:     []string{"1:new", "2:moved", "3:refreshed"},
+			dropped: 3,
+		},
+		"torn data": {
+			corrupt: func(data []byte) []byte { return data[:len(data)-2] },
+			out:     []string{"1:new", "2:moved"},
+			dropped: headerLen + len("refreshed") - 2,
+		},
+		"flipped bit": {
+			corrupt: func(data []byte) []byte {
+				data[len(data)-1] ^= 0x01
+				return data
+			},
+			out:     []string{"1:new", "2:moved"},
+			dropped: headerLen + len("refreshed"),
+		},
+		"corrupt middle record": {
+			corrupt: func(data []byte) []byte {
+				data[headerLen+len("new")+headerLen] ^= 0x01
+				return data
+			},
+			out:     []string{"1:new"},
+			dropped: 2*headerLen + len("moved") + len("refreshed"),
+		},
+		"garbage length": {
+			corrupt: func(data []byte) []byte { return append(append(data, 0xff, 0xff, 0xff, 0xff), make([]byte, 12)...) },
+			out:     []string{"1:new", "2:moved", "3:refreshed"},
+			dropped: headerLen,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "events")
+
+			j := openT(t, path)
+			appendT(t, j, "new", "moved", "refreshed")
+			require.NoError(t, j.Close())
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(path, tc.corrupt(data), 0o600))
+
+			j = openT(t, path)
+
+			assert.Equal(t, tc.out, replayed(t, j))
+			assert.Equal(t, int64(tc.dropped), j.Dropped())
+
+			// the next record follows the valid prefix
+			appendT(t, j, "next")
+			require.NoError(t, j.Close())
+
+			j = openT(t, path)
+
+			assert.Len(t, replayed(t, j), len(tc.out)+1)
+			assert.Zero(t, j.Dropped())
+		})
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events")
+	j := openT(t, path, WithCompactSize(2*(headerLen+1)))
+
+	appendT(t, j, "a", "b", "c")
+	require.NoError(t, j.Ack(1))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3*(headerLen+1)), info.Size())
+
+	// the acknowledged prefix reached the compaction size
+	require.NoError(t, j.Ack(2))
+
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(headerLen+1), info.Size())
+	assert.Equal(t, []string{"3:c"}, replayed(t, j))
+
+	// the sequence carries on from the acknowledgement of an empty file
+	require.NoError(t, j.Ack(3))
+	require.NoError(t, j.Close())
+
+	j = openT(t, path)
+
+	assert.Empty(t, replayed(t, j))
+
+	seq, err := j.Append([]byte("d"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), seq)
+}
+
+func TestJournalFull(t *testing.T) {
+	t.Parallel()
+
+	j := openT(t, filepath.Join(t.TempDir(), "events"), WithMaxSize(2*(headerLen+1)))
+
+	appendT(t, j, "a", "b")
+
+	_, err := j.Append([]byte("c"))
+	assert.ErrorIs(t, err, ErrFull)
+
+	// acknowledging makes room
+	require.NoError(t, j.Ack(1))
+	appendT(t, j, "c")
+	assert.Equal(t, []string{"2:b", "3:c"}, replayed(t, j))
+
+	_, err = j.Append(make([]byte, MaxRecordLen+1))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestJournalCorruptAck(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events")
+	require.NoError(t, os.WriteFile(path+ackSuffix, []byte("twelve\n"), 0o600))
+
+	_, err := Open(path)
+	assert.ErrorIs(t, err, ErrCorruptAck)
+}
+
+func TestJournalClosed(t *testing.T) {
+	t.Parallel()
+
+	j := openT(t, filepath.Join(t.TempDir(), "events"))
+	require.NoError(t, j.Close())
+
+	_, err := j.Append([]byte("a"))
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, j.Ack(0), ErrClosed)
+	assert.ErrorIs(t, j.Close(), ErrClosed)
+}
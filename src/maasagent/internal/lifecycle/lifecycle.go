@@ -0,0 +1,189 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package lifecycle starts and stops the long-running components of a
+// service in a defined order
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrExited is returned when a component returns before the Group is
+	// stopped without reporting an error
+	ErrExited = errors.New("component exited")
+)
+
+// Runner is a long-running component. Run blocks until ctx is done or the
+// component fails. It returns nil once stopped by ctx, and every goroutine it
+// started has returned by the time it does.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// RunnerFunc adapts a function to a Runner
+type RunnerFunc func(ctx context.Context) error
+
+// Run calls f(ctx)
+func (f RunnerFunc) Run(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Readier is implemented by the Runners which need time to start, the Group
+// doesn't start the next component until Ready is closed
+type Readier interface {
+	Ready() <-chan struct{}
+}
+
+type component struct {
+	runner Runner
+	name   string
+}
+
+// running is a started component, done is closed when its Run returns
+type running struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	name   string
+}
+
+// Group runs components which depend on the ones added before them. They
+// are started in order and stopped in reverse order, so a component never
+// outlives the ones it consumes from or produces to.
+type Group struct {
+	components []component
+}
+
+// NewGroup returns an empty Group
+func NewGroup() *Group {
+	return &Group{}
+}
+
+// Add appends a component, it is started after the components already added
+// and stopped before them
+func (g *Group) Add(name string, r Runner) {
+	g.components = append(g.components, component{name: name, runner: r})
+}
+
+// Run starts the components and blocks until ctx is done or one of them
+// returns. Then it stops the components in reverse order, waiting for each
+// one to return before stopping the next, and returns the first error.
+func (g *Group) Run(ctx context.Context) error {
+	var (
+		stopping atomic.Bool
+		mu       sync.Mutex
+		first    error
+	)
+
+	// exited has room for every component, so none blocks on exiting
+	exited := make(chan struct{}, len(g.components))
+	started := make([]running, 0, len(g.components))
+
+	exit := func(name string, err error) {
+		// the errors of a cancelled component are only its way to stop
+		if stopping.Load() && (err == nil || errors.Is(err, context.Canceled)) {
+			return
+		}
+
+		if err == nil {
+			err = ErrExited
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if first == nil {
+			first = fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	ok := true
+
+	for _, c := range g.components {
+		started = append(started, start(ctx, c, exited, exit))
+
+		if ok = ready(ctx, c.runner, exited); !ok {
+			break
+		}
+	}
+
+	if ok {
+		select {
+		case <-ctx.Done():
+		case <-exited:
+		}
+	}
+
+	stopping.Store(true)
+
+	for i := len(started) - 1; i >= 0; i-- {
+		log.Debug().Str("component", started[i].name).Msg("Stopping")
+		started[i].cancel()
+		<-started[i].done
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	return first
+}
+
+// start runs c in a goroutine. Its context keeps the values of ctx but is
+// only cancelled by the Group, so the components can be stopped one by one.
+func start(ctx context.Context, c component, exited chan<- struct{},
+	exit func(string, error)) running {
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	r := running{name: c.name, cancel: cancel, done: make(chan struct{})}
+
+	log.Debug().Str("component", c.name).Msg("Starting")
+
+	go func() {
+		defer close(r.done)
+
+		exit(c.name, c.runner.Run(runCtx))
+		exited <- struct{}{}
+	}()
+
+	return r
+}
+
+// ready waits for a Readier to be ready, it returns false if ctx is done or a
+// component exits first
+func ready(ctx context.Context, r Runner, exited <-chan struct{}) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	readier, ok := r.(Readier)
+	if !ok {
+		return true
+	}
+
+	select {
+	case <-readier.Ready():
+		return true
+	case <-ctx.Done():
+		return false
+	case <-exited:
+		return false
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+// recorder logs the order the components start and stop in
+type recorder struct {
+	events []string
+	mu     sync.Mutex
+}
+
+func (r *recorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+// fakeComponent runs until it is cancelled, or fails with err after being
+// told to on fail
+type fakeComponent struct {
+	err      error
+	recorder *recorder
+	ready    chan struct{}
+	fail     chan struct{}
+	name     string
+}
+
+func newFakeComponent(name string, r *recorder) *fakeComponent {
+	return &fakeComponent{
+		name:     name,
+		recorder: r,
+		ready:    make(chan struct{}),
+		fail:     make(chan struct{}),
+	}
+}
+
+func (c *fakeComponent) Run(ctx context.Context) error {
+	c.recorder.record("start " + c.name)
+	close(c.ready)
+
+	defer c.recorder.record("stop " + c.name)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.fail:
+		return c.err
+	}
+}
+
+func (c *fakeComponent) Ready() <-chan struct{} {
+	return c.ready
+}
+
+func TestGroupOrder(t *testing.T) {
+	defer leak.Check(t)()
+
+	r := &recorder{}
+	g := NewGroup()
+	components := []*fakeComponent{
+		newFakeComponent("inventory", r),
+		newFakeComponent("capture", r),
+		newFakeComponent("pipeline", r),
+	}
+
+	for _, c := range components {
+		g.Add(c.name, c)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- g.Run(ctx) }()
+
+	<-components[2].Ready()
+	cancel()
+	require.NoError(t, <-errC)
+
+	assert.Equal(t, []string{
+		"start inventory", "start capture", "start pipeline",
+		"stop pipeline", "stop capture", "stop inventory",
+	}, r.events)
+}
+
+func TestGroupError(t *testing.T) {
+	defer leak.Check(t)()
+
+	testcases := map[string]struct {
+		err error
+		out error
+	}{
+		"failure": {
+			err: errors.New("socket closed"),
+			out: errors.New("capture: socket closed"),
+		},
+		"early exit": {
+			out: errors.New("capture: component exited"),
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			r := &recorder{}
+			g := NewGroup()
+			components := []*fakeComponent{
+				newFakeComponent("inventory", r),
+				newFakeComponent("capture", r),
+				newFakeComponent("pipeline", r),
+			}
+
+			components[1].err = tc.err
+
+			for _, c := range components {
+				g.Add(c.name, c)
+			}
+
+			errC := make(chan error)
+
+			go func() { errC <- g.Run(context.Background()) }()
+
+			<-components[2].Ready()
+			close(components[1].fail)
+
+			err := <-errC
+			require.Error(t, err)
+			assert.EqualError(t, err, tc.out.Error())
+
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.ErrorIs(t, err, ErrExited)
+			}
+
+			// the others are stopped in reverse order
+			assert.Equal(t, []string{
+				"start inventory", "start capture", "start pipeline",
+				"stop capture", "stop pipeline", "stop inventory",
+			}, r.events)
+		})
+	}
+}
+
+func TestGroupNotReady(t *testing.T) {
+	defer leak.Check(t)()
+
+	r := &recorder{}
+	inventory := newFakeComponent("inventory", r)
+	g := NewGroup()
+
+	g.Add("inventory", inventory)
+	g.Add("capture", readyNever{})
+	g.Add("pipeline", newFakeComponent("pipeline", r))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- g.Run(ctx) }()
+
+	<-inventory.Ready()
+	cancel()
+	require.NoError(t, <-errC)
+
+	// the pipeline waits for the capture, which never became ready
+	assert.Equal(t, []string{"start inventory", "stop inventory"}, r.events)
+}
+
+// readyNever is a Runner which never becomes ready
+type readyNever struct{}
+
+func (readyNever) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (readyNever) Ready() <-chan struct{} {
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lldp
+
+import (
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+func FuzzParseFrame(f *testing.F) {
+	for _, seed := range [][]byte{
+		testFrame(testLLDPDU()),
+		testFrame(testLLDPDU(
+			testOrgTLV(OUI8021, Subtype8021PortVLANID, 0x00, 0x64),
+			testOrgTLV(OUI8021, Subtype8021VLANName, 0x00, 0x64, 4, 'p', 'x', 'e', '0'),
+			testOrgTLV(OUI8023, Subtype8023PowerViaMDI, 0x0f, 0x02, 0x05, 0x52, 0x00, 0xfa, 0x00, 0xfa),
+		)),
+		testFrame(nil),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			d, err := ParseFrame(in)
+			if err != nil {
+				return
+			}
+
+			_ = d.ChassisID.String()
+			_ = d.PortID.String()
+		})
+	})
+}
+
+func FuzzTLVs(f *testing.F) {
+	f.Add(testLLDPDU())
+	f.Add(testOrgTLV(OUI8023, Subtype8023MACPHY, 0x03, 0x6c, 0x00, 0x00, 0x1e))
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			tlvs, err := ParseTLVs(in)
+			if err != nil {
+				return
+			}
+
+			for _, tlv := range tlvs {
+				if org, err := tlv.Org(); err == nil {
+					var d LLDPDU
+
+					_ = d.decodeOrg(org)
+				}
+			}
+		})
+	})
+}
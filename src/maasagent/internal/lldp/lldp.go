@@ -0,0 +1,364 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package lldp decodes the LLDPDUs of IEEE 802.1AB, which tell the switch
+// port an interface is connected to, and the TLVs IEEE 802.1 and 802.3
+// define for them
+package lldp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"unicode"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	// EthernetType is the ethertype of LLDP
+	EthernetType = 0x88cc
+
+	tlvHeaderLen = 2
+
+	ethernetHeaderLen = 14
+)
+
+var (
+	// ErrMalformedLLDPDU is returned when an LLDPDU or one of its TLVs
+	// is truncated, or the mandatory TLVs are missing
+	ErrMalformedLLDPDU = errors.New("malformed LLDPDU")
+	// ErrNotLLDP is returned by ParseFrame for frames of another type
+	ErrNotLLDP = errors.New("ethernet frame not of type LLDP")
+)
+
+// TLVType is the type of a TLV
+type TLVType uint8
+
+const (
+	// TLVEnd marks the end of an LLDPDU
+	TLVEnd TLVType = 0
+	// TLVChassisID identifies the switch, mandatory and first
+	TLVChassisID TLVType = 1
+	// TLVPortID identifies the port of the switch, mandatory and second
+	TLVPortID TLVType = 2
+	// TLVTTL is how long the information is valid, mandatory and third
+	TLVTTL TLVType = 3
+	// TLVPortDescription describes the port
+	TLVPortDescription TLVType = 4
+	// TLVSystemName is the name of the switch
+	TLVSystemName TLVType = 5
+	// TLVSystemDescription describes the switch
+	TLVSystemDescription TLVType = 6
+	// TLVSystemCapabilities are the capabilities of the switch
+	TLVSystemCapabilities TLVType = 7
+	// TLVManagementAddress is an address the switch is managed at
+	TLVManagementAddress TLVType = 8
+	// TLVOrganizationSpecific is a TLV defined by the organization of its
+	// OUI
+	TLVOrganizationSpecific TLVType = 127
+)
+
+// Reserved returns whether 802.1AB reserves the type, which no TLV is to
+// have
+func (t TLVType) Reserved() bool {
+	return t > TLVManagementAddress && t < TLVOrganizationSpecific
+}
+
+// TLV is a type-length-value element of an LLDPDU, Value aliases the
+// buffer it was parsed from
+type TLV struct {
+	Value []byte
+	Type  TLVType
+}
+
+// ParseTLVs returns the TLVs of an LLDPDU up to the end TLV, which is
+// optional, or the end of buf
+func ParseTLVs(buf []byte) ([]TLV, error) {
+	return ParseTLVsWith(buf, ethernet.ParserOptions{})
+}
+
+// ParseTLVsWith is ParseTLVs with the TLVs of a reserved type and a TLV
+// cut short handled as the options decide, see
+// ethernet.ParserOptions.SkipUnknownOptions and KeepTruncatedOptions, and
+// up to the TLVs of their limits, see ethernet.DecodeLimits
+func ParseTLVsWith(buf []byte, opts ethernet.ParserOptions) ([]TLV, error) {
+	budget := opts.Budget()
+
+	return parseTLVs(buf, opts, &budget)
+}
+
+// parseTLVs is ParseTLVsWith spending budget
+func parseTLVs(buf []byte, opts ethernet.ParserOptions, budget *ethernet.DecodeBudget) ([]TLV, error) {
+	var tlvs []TLV
+
+	limit := opts.DecodeLimits().Options
+
+	for len(buf) > 0 {
+		if len(buf) < tlvHeaderLen {
+			if opts.KeepTruncatedOptions() {
+				break
+			}
+
+			return nil, fmt.Errorf("%w: truncated TLV header: %w", ErrMalformedLLDPDU, io.ErrUnexpectedEOF)
+		}
+
+		header := binary.BigEndian.Uint16(buf)
+		typ, n := TLVType(header>>9), int(header&0x01ff)
+
+		if typ == TLVEnd {
+			break
+		}
+
+		if len(buf) < tlvHeaderLen+n {
+			if opts.KeepTruncatedOptions() {
+				break
+			}
+
+			return nil, fmt.Errorf("%w: TLV %d of %d bytes in %d: %w", ErrMalformedLLDPDU, typ, n,
+				len(buf)-tlvHeaderLen, io.ErrUnexpectedEOF)
+		}
+
+		if typ.Reserved() && !opts.SkipUnknownOptions() {
+			return nil, fmt.Errorf("%w: TLV of reserved type %d", ErrMalformedLLDPDU, typ)
+		}
+
+		if len(tlvs) == limit {
+			return nil, ethernet.LimitExceeded("LLDP", "TLVs", ErrMalformedLLDPDU, limit, "TLVs")
+		}
+
+		if err := budget.Spend("LLDP", ErrMalformedLLDPDU); err != nil {
+			return nil, err
+		}
+
+		tlvs = append(tlvs, TLV{Type: typ, Value: buf[tlvHeaderLen : tlvHeaderLen+n : tlvHeaderLen+n]})
+		buf = buf[tlvHeaderLen+n:]
+	}
+
+	return tlvs, nil
+}
+
+// ChassisIDSubtype tells what a ChassisID is
+type ChassisIDSubtype uint8
+
+const (
+	// ChassisIDMAC is the MAC of the switch
+	ChassisIDMAC ChassisIDSubtype = 4
+	// ChassisIDNetworkAddress is a network address of the switch
+	ChassisIDNetworkAddress ChassisIDSubtype = 5
+)
+
+// ChassisID identifies a switch
+type ChassisID struct {
+	ID      []byte
+	Subtype ChassisIDSubtype
+}
+
+// String returns the MAC or the address of the switch for these subtypes,
+// the ID as text otherwise
+func (c ChassisID) String() string {
+	switch c.Subtype {
+	case ChassisIDMAC:
+		return idString(c.ID, true, false)
+	case ChassisIDNetworkAddress:
+		return idString(c.ID, false, true)
+	}
+
+	return idString(c.ID, false, false)
+}
+
+// PortIDSubtype tells what a PortID is
+type PortIDSubtype uint8
+
+const (
+	// PortIDMAC is the MAC of the port
+	PortIDMAC PortIDSubtype = 3
+	// PortIDNetworkAddress is a network address of the port
+	PortIDNetworkAddress PortIDSubtype = 4
+	// PortIDInterfaceName is the name of the port, such as Ethernet1/12
+	PortIDInterfaceName PortIDSubtype = 5
+)
+
+// PortID identifies a port of a switch
+type PortID struct {
+	ID      []byte
+	Subtype PortIDSubtype
+}
+
+// String returns the MAC or the address of the port for these subtypes,
+// the ID as text otherwise
+func (p PortID) String() string {
+	switch p.Subtype {
+	case PortIDMAC:
+		return idString(p.ID, true, false)
+	case PortIDNetworkAddress:
+		return idString(p.ID, false, true)
+	}
+
+	return idString(p.ID, false, false)
+}
+
+// idString returns an ID as a MAC, as a network address prefixed with its
+// IANA address family, or as text when printable and in hex otherwise
+func idString(id []byte, mac, address bool) string {
+	switch {
+	case mac && len(id) == 6:
+		return net.HardwareAddr(id).String()
+	case address && len(id) > 1:
+		if ip, ok := netip.AddrFromSlice(id[1:]); ok && (id[0] == 1 && ip.Is4() || id[0] == 2 && ip.Is6()) {
+			return ip.String()
+		}
+	}
+
+	text := string(id)
+	if strings.IndexFunc(text, func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+		return text
+	}
+
+	return fmt.Sprintf("%x", id)
+}
+
+// LLDPDU is an LLDP data unit, with the TLVs of IEEE 802.1 and 802.3
+// decoded. The IDs and the raw TLVs alias the buffer it was parsed from.
+type LLDPDU struct {
+	// Aggregation is the link aggregation status of the port, from the
+	// 802.1 TLV or the deprecated 802.3 one
+	Aggregation *LinkAggregation
+	// MACPHY is the speed and duplex configuration of the port
+	MACPHY *MACPHY
+	// Power is how the port powers the host, or is powered
+	Power *PowerViaMDI
+	// PortDescription, SystemName and SystemDescription are empty unless
+	// the switch sends them
+	PortDescription   string
+	SystemName        string
+	SystemDescription string
+	// VLANNames are the VLANs of the port the switch named
+	VLANNames []VLANName
+	// Unknown are the organizationally specific TLVs not decoded, including
+	// the known ones too short to be decoded
+	Unknown   []OrgTLV
+	ChassisID ChassisID
+	PortID    PortID
+	// TTL is the number of seconds the information is valid, 0 when the
+	// port is shutting down
+	TTL uint16
+	// PortVLAN is the native VLAN of the port, 0 when the switch doesn't
+	// tell
+	PortVLAN uint16
+	// MaxFrameSize is the largest frame the port forwards, 0 when the
+	// switch doesn't tell
+	MaxFrameSize uint16
+}
+
+// UnmarshalBinary parses an LLDPDU, the payload of an LLDP frame
+func (d *LLDPDU) UnmarshalBinary(buf []byte) error {
+	budget := ethernet.ParserOptions{}.Budget()
+
+	return d.unmarshal(buf, ethernet.ParserOptions{}, &budget)
+}
+
+func (d *LLDPDU) unmarshal(buf []byte, opts ethernet.ParserOptions, budget *ethernet.DecodeBudget) error {
+	tlvs, err := parseTLVs(buf, opts, budget)
+	if err != nil {
+		return err
+	}
+
+	if len(tlvs) < 3 || tlvs[0].Type != TLVChassisID || tlvs[1].Type != TLVPortID || tlvs[2].Type != TLVTTL {
+		return fmt.Errorf("%w: missing the chassis ID, port ID or TTL", ErrMalformedLLDPDU)
+	}
+
+	chassis, port, ttl := tlvs[0].Value, tlvs[1].Value, tlvs[2].Value
+	if len(chassis) < 2 || len(port) < 2 || len(ttl) < 2 {
+		return fmt.Errorf("%w: truncated chassis ID, port ID or TTL", ErrMalformedLLDPDU)
+	}
+
+	*d = LLDPDU{
+		ChassisID: ChassisID{Subtype: ChassisIDSubtype(chassis[0]), ID: chassis[1:]},
+		PortID:    PortID{Subtype: PortIDSubtype(port[0]), ID: port[1:]},
+		TTL:       binary.BigEndian.Uint16(ttl),
+	}
+
+	for _, tlv := range tlvs[3:] {
+		switch tlv.Type {
+		case TLVPortDescription:
+			d.PortDescription = string(tlv.Value)
+		case TLVSystemName:
+			d.SystemName = string(tlv.Value)
+		case TLVSystemDescription:
+			d.SystemDescription = string(tlv.Value)
+		case TLVOrganizationSpecific:
+			org, err := tlv.Org()
+			if err != nil {
+				return err
+			}
+
+			if !d.decodeOrg(org) {
+				d.Unknown = append(d.Unknown, org)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ParseFrame returns the LLDPDU of an ethernet frame, after any VLAN tags,
+// or ErrNotLLDP for a frame of another type
+func ParseFrame(frame []byte) (LLDPDU, error) {
+	return ParseFrameWith(frame, ethernet.ParserOptions{})
+}
+
+// ParseFrameWith is ParseFrame with the LLDPDU decoded as the options
+// decide, see ParseTLVsWith. The tags and the TLVs of the frame share the
+// budget of the options.
+func ParseFrameWith(frame []byte, opts ethernet.ParserOptions) (LLDPDU, error) {
+	if len(frame) < ethernetHeaderLen {
+		return LLDPDU{}, ErrNotLLDP
+	}
+
+	off := 12
+	ethertype := binary.BigEndian.Uint16(frame[off:])
+	budget := opts.Budget()
+	limits := opts.DecodeLimits()
+
+	for n := 0; (ethertype == 0x8100 || ethertype == 0x88a8) && len(frame) >= off+6; n++ {
+		if n == limits.Tags {
+			return LLDPDU{}, ethernet.LimitExceeded("LLDP", "VLAN tags", ErrMalformedLLDPDU, limits.Tags, "tags")
+		}
+
+		if err := budget.Spend("LLDP", ErrMalformedLLDPDU); err != nil {
+			return LLDPDU{}, err
+		}
+
+		off += 4
+		ethertype = binary.BigEndian.Uint16(frame[off:])
+	}
+
+	if ethertype != EthernetType {
+		return LLDPDU{}, ErrNotLLDP
+	}
+
+	var d LLDPDU
+
+	if err := d.unmarshal(frame[off+2:], opts, &budget); err != nil {
+		return LLDPDU{}, err
+	}
+
+	return d, nil
+}
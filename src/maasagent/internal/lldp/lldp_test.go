@@ -0,0 +1,355 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lldp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+// testTLV encodes a TLV, its value must fit in the 9 bits of the length
+func testTLV(typ TLVType, value ...byte) []byte {
+	tlv := make([]byte, tlvHeaderLen, tlvHeaderLen+len(value))
+	binary.BigEndian.PutUint16(tlv, uint16(typ)<<9|uint16(len(value))) //nolint:gosec // test TLVs are small
+
+	return append(tlv, value...)
+}
+
+func testOrgTLV(oui OUI, subtype uint8, info ...byte) []byte {
+	return testTLV(TLVOrganizationSpecific, append([]byte{oui[0], oui[1], oui[2], subtype}, info...)...)
+}
+
+func testLLDPDU(tlvs ...[]byte) []byte {
+	pdu := append(testTLV(TLVChassisID, 4, 0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x01),
+		testTLV(TLVPortID, append([]byte{5}, "Ethernet1/12"...)...)...)
+	pdu = append(pdu, testTLV(TLVTTL, 0x00, 0x78)...)
+
+	for _, tlv := range tlvs {
+		pdu = append(pdu, tlv...)
+	}
+
+	return append(pdu, testTLV(TLVEnd)...)
+}
+
+func testFrame(pdu []byte) []byte {
+	frame := []byte{
+		0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e,
+		0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x01,
+		0x88, 0xcc,
+	}
+
+	return append(frame, pdu...)
+}
+
+func TestParseTLVs(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []byte
+		out []TLV
+		err error
+	}{
+		"stops at the end TLV": {
+			in: append(append(testTLV(TLVSystemName, 'a'), testTLV(TLVEnd)...), 0xff, 0xff),
+			out: []TLV{
+				{Type: TLVSystemName, Value: []byte("a")},
+			},
+		},
+		"without the end TLV": {
+			in: append(testTLV(TLVSystemName, 'a'), testTLV(TLVPortDescription)...),
+			out: []TLV{
+				{Type: TLVSystemName, Value: []byte("a")},
+				{Type: TLVPortDescription, Value: []byte{}},
+			},
+		},
+		"truncated header": {
+			in:  append(testTLV(TLVSystemName, 'a'), 0x0a),
+			err: ErrMalformedLLDPDU,
+		},
+		"truncated value": {
+			in:  testTLV(TLVSystemName, 'a', 'b')[:3],
+			err: ErrMalformedLLDPDU,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tlvs, err := ParseTLVs(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, tlvs)
+		})
+	}
+}
+
+func TestParseTLVsBound(t *testing.T) {
+	t.Parallel()
+
+	var buf []byte
+	for range ethernet.DefaultOptionLimit + 1 {
+		buf = append(buf, testTLV(TLVPortDescription)...)
+	}
+
+	_, err := ParseTLVs(buf)
+	assert.ErrorIs(t, err, ErrMalformedLLDPDU)
+	assert.ErrorIs(t, err, ethernet.ErrDecodeLimitExceeded)
+
+	tlvs, err := ParseTLVs(buf[:ethernet.DefaultOptionLimit*tlvHeaderLen])
+	require.NoError(t, err)
+	assert.Len(t, tlvs, ethernet.DefaultOptionLimit)
+
+	_, err = ParseTLVsWith(buf[:8*tlvHeaderLen], ethernet.ParserOptions{Limits: ethernet.DecodeLimits{Options: 7}})
+	assert.ErrorIs(t, err, ethernet.ErrDecodeLimitExceeded)
+}
+
+func TestParseFrameLimits(t *testing.T) {
+	t.Parallel()
+
+	frame := testFrame(testLLDPDU())
+
+	// a stack of tags deeper than any encapsulation
+	deep := append([]byte{}, frame[:12]...)
+	for range ethernet.MaxTags + 1 {
+		deep = append(deep, 0x81, 0x00, 0x00, 0x0a)
+	}
+
+	deep = append(deep, frame[12:]...)
+
+	// TLVs of no value filling a jumbo frame, within the TLV limit but not
+	// the budget of the frame
+	var empty []byte
+	for range 1000 {
+		empty = append(empty, testTLV(TLVPortDescription)...)
+	}
+
+	testcases := map[string]struct {
+		in   []byte
+		opts ethernet.ParserOptions
+	}{
+		"tags": {
+			in: deep,
+		},
+		"budget": {
+			in:   testFrame(testLLDPDU(empty)),
+			opts: ethernet.ParserOptions{Limits: ethernet.DecodeLimits{Options: 2000, Frame: 500}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseFrameWith(tc.in, tc.opts)
+			assert.ErrorIs(t, err, ErrMalformedLLDPDU)
+			assert.ErrorIs(t, err, ethernet.ErrDecodeLimitExceeded)
+		})
+	}
+}
+
+func TestParseTLVsStrictness(t *testing.T) {
+	t.Parallel()
+
+	reserved := append(testTLV(TLVSystemName, 'a'), testTLV(TLVType(20), 0x01)...)
+	truncated := append(testTLV(TLVSystemName, 'a'), testTLV(TLVPortDescription, 'b', 'c')[:3]...)
+	name := TLV{Type: TLVSystemName, Value: []byte("a")}
+
+	testcases := map[string]struct {
+		in  []byte
+		out map[ethernet.Strictness][]TLV
+	}{
+		"reserved type": {
+			in: reserved,
+			out: map[ethernet.Strictness][]TLV{
+				ethernet.StrictnessLenient:  {name, {Type: 20, Value: []byte{0x01}}},
+				ethernet.StrictnessStandard: {name, {Type: 20, Value: []byte{0x01}}},
+			},
+		},
+		"truncated value": {
+			in: truncated,
+			out: map[ethernet.Strictness][]TLV{
+				ethernet.StrictnessLenient: {name},
+			},
+		},
+		"truncated header": {
+			in: append(testTLV(TLVSystemName, 'a'), 0x0a),
+			out: map[ethernet.Strictness][]TLV{
+				ethernet.StrictnessLenient: {name},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, s := range []ethernet.Strictness{
+				ethernet.StrictnessLenient, ethernet.StrictnessStandard, ethernet.StrictnessStrict,
+			} {
+				tlvs, err := ParseTLVsWith(tc.in, ethernet.ParserOptions{Strictness: s})
+
+				out, ok := tc.out[s]
+				if !ok {
+					assert.ErrorIs(t, err, ErrMalformedLLDPDU, s)
+					continue
+				}
+
+				require.NoError(t, err, s)
+				assert.Equal(t, out, tlvs, s)
+			}
+		})
+	}
+}
+
+func TestIDString(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  interface{ String() string }
+		out string
+	}{
+		"chassis MAC": {
+			in:  ChassisID{Subtype: ChassisIDMAC, ID: []byte{0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x01}},
+			out: "00:1c:73:aa:bb:01",
+		},
+		"chassis IPv4": {
+			in:  ChassisID{Subtype: ChassisIDNetworkAddress, ID: []byte{1, 10, 0, 0, 1}},
+			out: "10.0.0.1",
+		},
+		"chassis address of the wrong family": {
+			in:  ChassisID{Subtype: ChassisIDNetworkAddress, ID: []byte{2, 10, 0, 0, 1}},
+			out: "020a000001",
+		},
+		"chassis local": {
+			in:  ChassisID{Subtype: 7, ID: []byte("chassis-7f2a")},
+			out: "chassis-7f2a",
+		},
+		"port name": {
+			in:  PortID{Subtype: PortIDInterfaceName, ID: []byte("Ethernet1/12")},
+			out: "Ethernet1/12",
+		},
+		"port MAC of the wrong length": {
+			in:  PortID{Subtype: PortIDMAC, ID: []byte{0x00, 0x1c}},
+			out: "001c",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.in.String())
+		})
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	t.Parallel()
+
+	// lldp-chassis-mac of the conformance corpus
+	frame, err := hex.DecodeString("0180c200000e001c73aabb0188cc020704001c73aabb01040d0545746865726e" +
+		"6574312f3132060200780a06746f722d3031081075706c696e6b20746f207261636b20330000")
+	require.NoError(t, err)
+
+	d, err := ParseFrame(frame)
+	require.NoError(t, err)
+
+	assert.Equal(t, "00:1c:73:aa:bb:01", d.ChassisID.String())
+	assert.Equal(t, PortIDInterfaceName, d.PortID.Subtype)
+	assert.Equal(t, "Ethernet1/12", d.PortID.String())
+	assert.Equal(t, uint16(120), d.TTL)
+	assert.Equal(t, "tor-01", d.SystemName)
+	assert.Equal(t, "uplink to rack 3", d.PortDescription)
+	assert.Empty(t, d.Unknown)
+}
+
+func TestParseFrameVLAN(t *testing.T) {
+	t.Parallel()
+
+	frame := testFrame(testLLDPDU())
+	tagged := append(append(append([]byte{}, frame[:12]...), 0x81, 0x00, 0x00, 0x0a), frame[12:]...)
+
+	d, err := ParseFrame(tagged)
+	require.NoError(t, err)
+	assert.Equal(t, "Ethernet1/12", d.PortID.String())
+}
+
+func TestParseFrameErrors(t *testing.T) {
+	t.Parallel()
+
+	ttlFirst := append(testTLV(TLVTTL, 0x00, 0x78), testLLDPDU()...)
+
+	testcases := map[string]struct {
+		in  []byte
+		err error
+	}{
+		"runt": {
+			in:  testFrame(nil)[:10],
+			err: ErrNotLLDP,
+		},
+		"not LLDP": {
+			in:  append(testFrame(nil)[:12], 0x08, 0x06),
+			err: ErrNotLLDP,
+		},
+		"empty": {
+			in:  testFrame(nil),
+			err: ErrMalformedLLDPDU,
+		},
+		"mandatory TLVs out of order": {
+			in:  testFrame(ttlFirst),
+			err: ErrMalformedLLDPDU,
+		},
+		"truncated TTL": {
+			in: testFrame(append(append(testTLV(TLVChassisID, 7, 'a'), testTLV(TLVPortID, 5, 'b')...),
+				testTLV(TLVTTL, 0x00)...)),
+			err: ErrMalformedLLDPDU,
+		},
+		"truncated organizationally specific TLV": {
+			in:  testFrame(testLLDPDU(testTLV(TLVOrganizationSpecific, 0x00, 0x80, 0xc2))),
+			err: ErrMalformedLLDPDU,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseFrame(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
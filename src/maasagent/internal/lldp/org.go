@@ -0,0 +1,206 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lldp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// orgHeaderLen is the OUI and the subtype of an organizationally specific
+// TLV
+const orgHeaderLen = 4
+
+// OUI is the organizationally unique identifier of an organizationally
+// specific TLV
+type OUI [3]byte
+
+var (
+	// OUI8021 is the OUI of the IEEE 802.1 TLVs of Annex D
+	OUI8021 = OUI{0x00, 0x80, 0xc2}
+	// OUI8023 is the OUI of the IEEE 802.3 TLVs of Annex E
+	OUI8023 = OUI{0x00, 0x12, 0x0f}
+)
+
+// String returns the OUI in the IEEE hyphenated format
+func (o OUI) String() string {
+	return fmt.Sprintf("%02x-%02x-%02x", o[0], o[1], o[2])
+}
+
+// MarshalText implements encoding.TextMarshaler for OUI
+func (o OUI) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// The subtypes of the IEEE 802.1 TLVs
+const (
+	Subtype8021PortVLANID         uint8 = 1
+	Subtype8021PortProtocolVLANID uint8 = 2
+	Subtype8021VLANName           uint8 = 3
+	Subtype8021LinkAggregation    uint8 = 7
+)
+
+// The subtypes of the IEEE 802.3 TLVs
+const (
+	Subtype8023MACPHY          uint8 = 1
+	Subtype8023PowerViaMDI     uint8 = 2
+	Subtype8023LinkAggregation uint8 = 3
+	Subtype8023MaxFrameSize    uint8 = 4
+)
+
+// OrgTLV is an organizationally specific TLV, Info aliases the buffer it
+// was parsed from
+type OrgTLV struct {
+	Info    []byte `json:"info"`
+	OUI     OUI    `json:"oui"`
+	Subtype uint8  `json:"subtype"`
+}
+
+// Org returns the organizationally specific TLV tlv is
+func (tlv TLV) Org() (OrgTLV, error) {
+	if tlv.Type != TLVOrganizationSpecific {
+		return OrgTLV{}, fmt.Errorf("%w: TLV %d isn't organizationally specific", ErrMalformedLLDPDU, tlv.Type)
+	}
+
+	if len(tlv.Value) < orgHeaderLen {
+		return OrgTLV{}, fmt.Errorf("%w: truncated organizationally specific TLV", ErrMalformedLLDPDU)
+	}
+
+	return OrgTLV{
+		OUI:     OUI(tlv.Value[:3]),
+		Subtype: tlv.Value[3],
+		Info:    tlv.Value[orgHeaderLen:],
+	}, nil
+}
+
+// VLANName is a VLAN of a port and its name
+type VLANName struct {
+	Name string
+	ID   uint16
+}
+
+// LinkAggregation is the link aggregation status of a port
+type LinkAggregation struct {
+	// PortID is the ifIndex of the aggregate, 0 unless Enabled
+	PortID uint32
+	// Capable is set when the port can be aggregated, and Enabled when
+	// it is part of an aggregate
+	Capable bool
+	Enabled bool
+}
+
+// MACPHY is the MAC/PHY configuration of a port
+type MACPHY struct {
+	// Advertised are the capabilities advertised by auto-negotiation, as
+	// the ifMauAutoNegCapAdvertisedBits of RFC 4836
+	Advertised uint16
+	// MAUType is the operational MAU type of RFC 4836, such as 30 for
+	// 10GBASE-T
+	MAUType          uint16
+	AutonegSupported bool
+	AutonegEnabled   bool
+}
+
+// PowerViaMDI is the Power over Ethernet of a port
+type PowerViaMDI struct {
+	// Requested and Allocated are in tenths of a watt, of the 802.3at
+	// extension
+	Requested uint16
+	Allocated uint16
+	// PowerPairs are the pairs powered, 1 for the signal pairs and 2 for
+	// the spare ones
+	PowerPairs uint8
+	// Class is the power class plus one, 1 for class 0
+	Class uint8
+	// PowerType, Source and Priority are the fields of the 802.3at
+	// extension, which sets Extended
+	PowerType uint8
+	Source    uint8
+	Priority  uint8
+	// PSE is set when the port is a power sourcing equipment, a switch
+	// port, rather than a powered device
+	PSE          bool
+	Supported    bool
+	Enabled      bool
+	PairsControl bool
+	Extended     bool
+}
+
+// decodeOrg decodes the 802.1 and 802.3 TLVs into d, it returns false for
+// the other TLVs and the known ones too short to be decoded
+func (d *LLDPDU) decodeOrg(org OrgTLV) bool {
+	info := org.Info
+
+	switch {
+	case org.OUI == OUI8021 && org.Subtype == Subtype8021PortVLANID && len(info) >= 2:
+		d.PortVLAN = binary.BigEndian.Uint16(info)
+	case org.OUI == OUI8021 && org.Subtype == Subtype8021VLANName && len(info) >= 3:
+		n := int(info[2])
+		if len(info) < 3+n {
+			return false
+		}
+
+		d.VLANNames = append(d.VLANNames, VLANName{
+			ID:   binary.BigEndian.Uint16(info),
+			Name: string(info[3 : 3+n]),
+		})
+	case (org.OUI == OUI8021 && org.Subtype == Subtype8021LinkAggregation ||
+		org.OUI == OUI8023 && org.Subtype == Subtype8023LinkAggregation) && len(info) >= 5:
+		// the 802.1 TLV supersedes the deprecated 802.3 one
+		if d.Aggregation != nil && org.OUI == OUI8023 {
+			return true
+		}
+
+		d.Aggregation = &LinkAggregation{
+			Capable: info[0]&0x01 != 0,
+			Enabled: info[0]&0x02 != 0,
+			PortID:  binary.BigEndian.Uint32(info[1:5]),
+		}
+	case org.OUI == OUI8023 && org.Subtype == Subtype8023MACPHY && len(info) >= 5:
+		d.MACPHY = &MACPHY{
+			AutonegSupported: info[0]&0x01 != 0,
+			AutonegEnabled:   info[0]&0x02 != 0,
+			Advertised:       binary.BigEndian.Uint16(info[1:3]),
+			MAUType:          binary.BigEndian.Uint16(info[3:5]),
+		}
+	case org.OUI == OUI8023 && org.Subtype == Subtype8023PowerViaMDI && len(info) >= 3:
+		p := &PowerViaMDI{
+			PSE:          info[0]&0x01 != 0,
+			Supported:    info[0]&0x02 != 0,
+			Enabled:      info[0]&0x04 != 0,
+			PairsControl: info[0]&0x08 != 0,
+			PowerPairs:   info[1],
+			Class:        info[2],
+		}
+
+		if len(info) >= 8 {
+			p.Extended = true
+			p.PowerType = info[3] >> 6
+			p.Source = info[3] >> 4 & 0x03
+			p.Priority = info[3] & 0x03
+			p.Requested = binary.BigEndian.Uint16(info[4:6])
+			p.Allocated = binary.BigEndian.Uint16(info[6:8])
+		}
+
+		d.Power = p
+	case org.OUI == OUI8023 && org.Subtype == Subtype8023MaxFrameSize && len(info) >= 2:
+		d.MaxFrameSize = binary.BigEndian.Uint16(info)
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lldp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOUIString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "00-80-c2", OUI8021.String())
+
+	text, err := OUI8023.MarshalText()
+	require.NoError(t, err)
+	assert.Equal(t, "00-12-0f", string(text))
+}
+
+func TestTLVOrg(t *testing.T) {
+	t.Parallel()
+
+	org, err := TLV{Type: TLVOrganizationSpecific, Value: []byte{0x00, 0x80, 0xc2, 0x01, 0x00, 0x64}}.Org()
+	require.NoError(t, err)
+	assert.Equal(t, OrgTLV{OUI: OUI8021, Subtype: 1, Info: []byte{0x00, 0x64}}, org)
+
+	_, err = TLV{Type: TLVSystemName, Value: []byte{0x00, 0x80, 0xc2, 0x01}}.Org()
+	assert.ErrorIs(t, err, ErrMalformedLLDPDU)
+}
+
+func TestUnmarshalOrgTLVs(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		tlvs    [][]byte
+		out     LLDPDU
+		unknown int
+	}{
+		"port VLAN ID": {
+			tlvs: [][]byte{testOrgTLV(OUI8021, Subtype8021PortVLANID, 0x00, 0x64)},
+			out:  LLDPDU{PortVLAN: 100},
+		},
+		"VLAN names": {
+			tlvs: [][]byte{
+				testOrgTLV(OUI8021, Subtype8021VLANName, 0x00, 0x64, 4, 'p', 'x', 'e', '0'),
+				testOrgTLV(OUI8021, Subtype8021VLANName, 0x00, 0xc8, 7, 's', 't', 'o', 'r', 'a', 'g', 'e'),
+			},
+			out: LLDPDU{VLANNames: []VLANName{{ID: 100, Name: "pxe0"}, {ID: 200, Name: "storage"}}},
+		},
+		"VLAN name longer than the TLV": {
+			tlvs:    [][]byte{testOrgTLV(OUI8021, Subtype8021VLANName, 0x00, 0x64, 8, 'p', 'x', 'e')},
+			unknown: 1,
+		},
+		"link aggregation": {
+			tlvs: [][]byte{testOrgTLV(OUI8021, Subtype8021LinkAggregation, 0x03, 0x00, 0x00, 0x01, 0xf5)},
+			out:  LLDPDU{Aggregation: &LinkAggregation{Capable: true, Enabled: true, PortID: 501}},
+		},
+		"802.1 link aggregation supersedes the 802.3 one": {
+			tlvs: [][]byte{
+				testOrgTLV(OUI8021, Subtype8021LinkAggregation, 0x01, 0x00, 0x00, 0x00, 0x00),
+				testOrgTLV(OUI8023, Subtype8023LinkAggregation, 0x03, 0x00, 0x00, 0x01, 0xf5),
+			},
+			out: LLDPDU{Aggregation: &LinkAggregation{Capable: true}},
+		},
+		"MAC/PHY": {
+			tlvs: [][]byte{testOrgTLV(OUI8023, Subtype8023MACPHY, 0x03, 0x6c, 0x00, 0x00, 0x1e)},
+			out: LLDPDU{MACPHY: &MACPHY{
+				AutonegSupported: true,
+				AutonegEnabled:   true,
+				Advertised:       0x6c00,
+				MAUType:          30,
+			}},
+		},
+		"power": {
+			tlvs: [][]byte{testOrgTLV(OUI8023, Subtype8023PowerViaMDI, 0x07, 0x01, 0x01)},
+			out: LLDPDU{Power: &PowerViaMDI{
+				PSE:        true,
+				Supported:  true,
+				Enabled:    true,
+				PowerPairs: 1,
+				Class:      1,
+			}},
+		},
+		"power with the 802.3at extension": {
+			tlvs: [][]byte{testOrgTLV(OUI8023, Subtype8023PowerViaMDI, 0x0f, 0x02, 0x05, 0x52, 0x00, 0xfa, 0x00, 0xfa)},
+			out: LLDPDU{Power: &PowerViaMDI{
+				PSE:          true,
+				Supported:    true,
+				Enabled:      true,
+				PairsControl: true,
+				PowerPairs:   2,
+				Class:        5,
+				Extended:     true,
+				PowerType:    1,
+				Source:       1,
+				Priority:     2,
+				Requested:    250,
+				Allocated:    250,
+			}},
+		},
+		"maximum frame size": {
+			tlvs: [][]byte{testOrgTLV(OUI8023, Subtype8023MaxFrameSize, 0x23, 0x28)},
+			out:  LLDPDU{MaxFrameSize: 9000},
+		},
+		"truncated known TLV": {
+			tlvs:    [][]byte{testOrgTLV(OUI8023, Subtype8023MACPHY, 0x03)},
+			unknown: 1,
+		},
+		"unknown subtype": {
+			tlvs:    [][]byte{testOrgTLV(OUI8021, 0x0b, 0x01)},
+			unknown: 1,
+		},
+		"unknown OUI": {
+			tlvs:    [][]byte{testOrgTLV(OUI{0x00, 0x01, 0x42}, Subtype8021PortVLANID, 0x00, 0x64)},
+			unknown: 1,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var d LLDPDU
+
+			require.NoError(t, d.UnmarshalBinary(testLLDPDU(tc.tlvs...)))
+			assert.Len(t, d.Unknown, tc.unknown)
+
+			assert.Equal(t, tc.out.PortVLAN, d.PortVLAN)
+			assert.Equal(t, tc.out.VLANNames, d.VLANNames)
+			assert.Equal(t, tc.out.Aggregation, d.Aggregation)
+			assert.Equal(t, tc.out.MACPHY, d.MACPHY)
+			assert.Equal(t, tc.out.Power, d.Power)
+			assert.Equal(t, tc.out.MaxFrameSize, d.MaxFrameSize)
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package loadgen
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	ethernetHeaderLen = 14
+	arpPacketLen      = 28
+	minFrameLen       = 60
+	// maxLLCLen is the longest payload an 802.3 length field describes
+	maxLLCLen = 1500
+	// maxNoiseLen is the longest payload of a KindIPv4 datagram
+	maxNoiseLen = 512
+
+	// serverNumber is the offset in the prefix of the DHCP server, the
+	// hosts follow it
+	serverNumber = 1
+	firstHost    = 2
+
+	bootpLen        = 236
+	portBOOTPServer = 67
+	portBOOTPClient = 68
+
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+	dhcpLease    = 3600
+)
+
+var dhcpBroadcast = netip.AddrFrom4([4]byte{255, 255, 255, 255})
+
+// dhcpExchange is the exchange which the next KindDHCP frame belongs to
+type dhcpExchange struct {
+	client int
+	xid    uint32
+	step   uint8
+}
+
+// hostAddr returns the address at offset n in p
+func hostAddr(p netip.Prefix, n int) netip.Addr {
+	a := p.Addr().As4()
+	binary.BigEndian.PutUint32(a[:], binary.BigEndian.Uint32(a[:])+uint32(n)) //nolint:gosec // n is validated to fit in the prefix
+
+	return netip.AddrFrom4(a)
+}
+
+// hostMAC returns the locally administered MAC of the address at offset n
+func hostMAC(n int) net.HardwareAddr {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(mac[2:], uint32(n)) //nolint:gosec // n is validated to fit in the prefix
+
+	return mac
+}
+
+// host picks the offset of a random host
+func (g *Generator) host() int {
+	return firstHost + g.rng.IntN(g.hosts)
+}
+
+func (g *Generator) addr(n int) netip.Addr {
+	return hostAddr(g.prefix, n)
+}
+
+// build returns a frame of kind
+func (g *Generator) build(kind Kind) ([]byte, error) {
+	switch kind {
+	case KindARPRequest:
+		sender, target := g.host(), g.host()
+
+		g.sawARP(sender - firstHost)
+
+		return ethernet.NewFrame().Src(hostMAC(sender)).ARPRequest(g.addr(sender), g.addr(target)).
+			Padded().Build()
+	case KindGratuitousARP:
+		n := g.host()
+
+		g.sawARP(n - firstHost)
+
+		// either form is seen in the wild, the reply repeats the sender
+		// hardware address as the target
+		frame := ethernet.NewFrame().Src(hostMAC(n)).Padded()
+		if g.rng.IntN(4) == 0 {
+			return frame.ARPReply(g.addr(n), hostMAC(n), g.addr(n)).Build()
+		}
+
+		return frame.ARPRequest(g.addr(n), g.addr(n)).Build()
+	case KindDHCP:
+		return g.dhcpMessage()
+	case KindIPv4:
+		return g.noise()
+	default:
+		return g.malformed()
+	}
+}
+
+// dhcpMessage returns the next message of the current exchange, which
+// starts with a new client after an ack
+func (g *Generator) dhcpMessage() ([]byte, error) {
+	x := &g.dhcp
+	if x.step == 0 {
+		x.client = g.host()
+		x.xid = g.rng.Uint32()
+	}
+
+	msgType := [...]uint8{dhcpDiscover, dhcpOffer, dhcpRequest, dhcpAck}[x.step]
+	fromClient := x.step%2 == 0
+
+	x.step = (x.step + 1) % 4
+	if x.step == 0 {
+		g.summary.DHCPExchanges++
+	}
+
+	client, server := g.addr(x.client).As4(), g.addr(serverNumber).As4()
+
+	msg := make([]byte, bootpLen, bootpLen+32)
+	msg[0] = 2 // BOOTREPLY
+	msg[1] = byte(ethernet.HardwareTypeEthernet)
+	msg[2] = 6
+	binary.BigEndian.PutUint32(msg[4:8], x.xid)
+	// the client has no address yet, answers must be broadcast
+	binary.BigEndian.PutUint16(msg[10:12], 0x8000)
+	copy(msg[28:44], hostMAC(x.client))
+
+	// magic cookie and DHCP message type
+	msg = append(msg, 99, 130, 83, 99, 53, 1, msgType)
+
+	switch msgType {
+	case dhcpRequest:
+		msg = append(msg, 50, 4)
+		msg = append(msg, client[:]...)
+		msg = append(msg, 54, 4)
+		msg = append(msg, server[:]...)
+	case dhcpOffer, dhcpAck:
+		copy(msg[16:20], client[:])
+		copy(msg[20:24], server[:])
+
+		msg = append(msg, 54, 4)
+		msg = append(msg, server[:]...)
+		msg = append(msg, 51, 4)
+		msg = binary.BigEndian.AppendUint32(msg, dhcpLease)
+		msg = append(msg, 1, 4)
+		msg = binary.BigEndian.AppendUint32(msg, ^uint32(0)<<(32-g.prefix.Bits()))
+	}
+
+	msg = append(msg, 0xff)
+
+	if fromClient {
+		msg[0] = 1 // BOOTREQUEST
+
+		return ethernet.NewFrame().Src(hostMAC(x.client)).
+			UDP(netip.AddrPortFrom(netip.IPv4Unspecified(), portBOOTPClient),
+				netip.AddrPortFrom(dhcpBroadcast, portBOOTPServer), msg).Build()
+	}
+
+	return ethernet.NewFrame().Src(hostMAC(serverNumber)).
+		UDP(netip.AddrPortFrom(g.addr(serverNumber), portBOOTPServer),
+			netip.AddrPortFrom(dhcpBroadcast, portBOOTPClient), msg).Build()
+}
+
+// noise returns a datagram of random data between two hosts
+func (g *Generator) noise() ([]byte, error) {
+	src, dst := g.host(), g.host()
+	data := make([]byte, g.rng.IntN(maxNoiseLen+1))
+
+	for i := range data {
+		data[i] = byte(g.rng.Uint32())
+	}
+
+	//nolint:gosec // the ports are any 16 bits
+	srcPort, dstPort := uint16(g.rng.Uint32()), uint16(g.rng.Uint32())
+
+	return ethernet.NewFrame().Src(hostMAC(src)).Dst(hostMAC(dst)).
+		UDP(netip.AddrPortFrom(g.addr(src), srcPort), netip.AddrPortFrom(g.addr(dst), dstPort), data).
+		Padded().Build()
+}
+
+// malformed returns an 802.3 frame shorter than its length field, an ARP
+// packet cut short or an IPv4 header with a wrong checksum. Runts aren't
+// generated, the kernel doesn't send frames shorter than their header.
+func (g *Generator) malformed() ([]byte, error) {
+	sender, target := g.host(), g.host()
+
+	switch g.rng.IntN(3) {
+	case 0:
+		// the length field is above what the padding holds
+		length := ethernet.EthernetType(minFrameLen - ethernetHeaderLen + 1 + g.rng.IntN(maxLLCLen-minFrameLen))
+
+		return ethernet.NewFrame().Src(hostMAC(sender)).Payload(length, nil).Padded().Build()
+	case 1:
+		// the padding would complete the packet again
+		frame, err := ethernet.NewFrame().Src(hostMAC(sender)).ARPRequest(g.addr(sender), g.addr(target)).Build()
+		if err != nil {
+			return nil, err
+		}
+
+		return frame[:ethernetHeaderLen+1+g.rng.IntN(arpPacketLen-1)], nil
+	default:
+		frame, err := g.noise()
+		if err == nil {
+			frame[ethernetHeaderLen+10] ^= 0xff
+		}
+
+		return frame, err
+	}
+}
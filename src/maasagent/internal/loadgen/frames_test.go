@@ -0,0 +1,162 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package loadgen
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/checksum"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const ipv4HeaderLen = 20
+
+// classify returns the kind of a generated frame from its content, the ARP
+// kinds aren't told apart
+func classify(t *testing.T, frame []byte) Kind {
+	t.Helper()
+
+	var eth ethernet.EthernetFrame
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return KindMalformed
+	}
+
+	switch eth.EthernetType {
+	case ethernet.EthernetTypeARP:
+		pkt, err := eth.ExtractARPPacketStrict()
+		if err != nil {
+			return KindMalformed
+		}
+
+		assert.Equal(t, eth.SrcMAC, pkt.SendHwAddr)
+		assert.Len(t, frame, 60, "valid frames are padded")
+
+		return KindARPRequest
+	case ethernet.EthernetTypeIPv4:
+		hdr := eth.Payload[:ipv4HeaderLen]
+		if checksum.Sum(hdr) != 0 {
+			return KindMalformed
+		}
+
+		length := binary.BigEndian.Uint16(hdr[2:])
+		require.NoError(t, checksum.Verify(hdr, eth.Payload[ipv4HeaderLen:length]))
+
+		assert.GreaterOrEqual(t, len(frame), 60, "valid frames are padded")
+
+		ports := eth.Payload[ipv4HeaderLen:]
+		if src, dst := binary.BigEndian.Uint16(ports), binary.BigEndian.Uint16(ports[2:]); (src == portBOOTPClient &&
+			dst == portBOOTPServer) || (src == portBOOTPServer && dst == portBOOTPClient) {
+			return KindDHCP
+		}
+
+		return KindIPv4
+	}
+
+	t.Fatalf("unexpected ethertype %s", eth.EthernetType)
+
+	return kinds
+}
+
+func TestGeneratedFrames(t *testing.T) {
+	t.Parallel()
+
+	g, err := New(WithSeed(3), WithCount(5000), WithHosts(1000))
+	require.NoError(t, err)
+
+	var counts [kinds]uint64
+
+	for _, f := range readAll(t, g) {
+		counts[classify(t, f)]++
+	}
+
+	s := g.Summary()
+	assert.Equal(t, s.ARPRequests+s.GratuitousARPs, counts[KindARPRequest])
+	assert.Equal(t, s.DHCP, counts[KindDHCP])
+	assert.Equal(t, s.IPv4, counts[KindIPv4])
+	assert.Equal(t, s.Malformed, counts[KindMalformed])
+}
+
+func TestGratuitousARP(t *testing.T) {
+	t.Parallel()
+
+	g, err := New(WithMix(Mix{GratuitousARP: 1}), WithCount(100), WithHosts(10))
+	require.NoError(t, err)
+
+	for _, f := range readAll(t, g) {
+		var eth ethernet.EthernetFrame
+
+		require.NoError(t, eth.UnmarshalBinary(f))
+
+		pkt, err := eth.ExtractARPPacketStrict()
+		require.NoError(t, err)
+		assert.Equal(t, pkt.SendIPAddr, pkt.TgtIPAddr)
+	}
+
+	assert.Equal(t, 10, g.Summary().Hosts)
+}
+
+func TestDHCPExchange(t *testing.T) {
+	t.Parallel()
+
+	prefix := netip.MustParsePrefix("192.168.1.0/24")
+
+	g, err := New(WithMix(Mix{DHCP: 1}), WithCount(8), WithPrefix(prefix), WithHosts(100))
+	require.NoError(t, err)
+
+	// the message type is the first option, following the magic cookie
+	const (
+		bootp   = 14 + ipv4HeaderLen + 8
+		msgType = bootp + bootpLen + 6
+	)
+
+	frames := readAll(t, g)
+	server := netip.MustParseAddr("192.168.1.1")
+
+	for i, f := range frames {
+		assert.Equal(t, []uint8{dhcpDiscover, dhcpOffer, dhcpRequest, dhcpAck}[i%4], f[msgType])
+
+		// an exchange keeps its transaction ID and client
+		first := frames[i-i%4]
+		assert.Equal(t, first[bootp+4:bootp+8], f[bootp+4:bootp+8])
+		assert.Equal(t, first[bootp+28:bootp+34], f[bootp+28:bootp+34])
+
+		if i%2 == 1 {
+			yiaddr, ok := netip.AddrFromSlice(f[bootp+16 : bootp+20])
+			require.True(t, ok)
+			assert.True(t, prefix.Contains(yiaddr))
+			assert.NotEqual(t, server, yiaddr)
+			assert.Equal(t, server.AsSlice(), f[14+12:14+16], "answers come from the server")
+		}
+	}
+
+	assert.Equal(t, uint64(2), g.Summary().DHCPExchanges)
+}
+
+func TestHost(t *testing.T) {
+	t.Parallel()
+
+	g, err := New(WithPrefix(netip.MustParsePrefix("192.168.1.7/24")), WithHosts(10))
+	require.NoError(t, err)
+
+	assert.Equal(t, netip.MustParseAddr("192.168.1.2"), g.Host(0))
+	assert.Equal(t, netip.MustParseAddr("192.168.1.3"), g.Host(1))
+}
@@ -0,0 +1,589 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package loadgen generates a synthetic mix of frames at a target rate, to
+// load and soak test the capture pipeline without lab hardware. The frames
+// are either read in memory, the Generator being a capture.FrameReader, or
+// transmitted on an interface such as a TAP or a veth with Transmit.
+//
+// The frames only depend on the seed, two generators with the same options
+// produce the same sequence whatever the rate.
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+const (
+	defaultHosts = 256
+	// maxHosts bounds the set of hosts tracked by the summary to 2 MiB
+	maxHosts = 1 << 24
+	// batchLen is the number of frames Transmit writes at most at once
+	batchLen = 64
+)
+
+var (
+	// ErrInvalidConfig is returned by New for options generating nothing
+	// or more hosts than the prefix holds
+	ErrInvalidConfig = errors.New("invalid traffic generator configuration")
+)
+
+// Kind is the kind of a generated frame
+type Kind uint8
+
+const (
+	// KindARPRequest is an ARP request from a host for another one
+	KindARPRequest Kind = iota
+	// KindGratuitousARP is a host announcing its own address
+	KindGratuitousARP
+	// KindDHCP is a frame of a DHCP exchange between a host and the server,
+	// each exchange being a discover, an offer, a request and an ack
+	KindDHCP
+	// KindIPv4 is a UDP datagram between two hosts
+	KindIPv4
+	// KindMalformed is a runt frame, an invalid ARP packet or an IPv4
+	// header with a wrong checksum
+	KindMalformed
+
+	kinds
+)
+
+var kindNames = [kinds]string{"ARPRequest", "GratuitousARP", "DHCP", "IPv4", "Malformed"}
+
+func (k Kind) String() string {
+	if k >= kinds {
+		return fmt.Sprintf("Kind(%d)", k)
+	}
+
+	return kindNames[k]
+}
+
+// Mix is the share of each kind of frame, the weights are relative to their
+// sum so percentages can be used
+type Mix struct {
+	ARPRequest    float64 `json:"arp_request"`
+	GratuitousARP float64 `json:"gratuitous_arp"`
+	DHCP          float64 `json:"dhcp"`
+	IPv4          float64 `json:"ipv4"`
+	Malformed     float64 `json:"malformed"`
+}
+
+// DefaultMix is mostly ARP with some background traffic, it is the Mix of a
+// Generator created without WithMix
+func DefaultMix() Mix {
+	return Mix{
+		ARPRequest:    70,
+		GratuitousARP: 5,
+		DHCP:          5,
+		IPv4:          19,
+		Malformed:     1,
+	}
+}
+
+func (m Mix) weights() [kinds]float64 {
+	return [kinds]float64{m.ARPRequest, m.GratuitousARP, m.DHCP, m.IPv4, m.Malformed}
+}
+
+// Summary is what a Generator has produced, to compare to what the pipeline
+// observed
+type Summary struct {
+	// Start is the time the first frame was due, zero until then
+	Start time.Time `json:"start"`
+	// End is the time the last frame was produced
+	End time.Time `json:"end"`
+	// Frames is the number of frames produced
+	Frames uint64 `json:"frames"`
+	// Bytes is the total length of the frames
+	Bytes uint64 `json:"bytes"`
+	// ARPRequests is the number of KindARPRequest frames
+	ARPRequests uint64 `json:"arp_requests"`
+	// GratuitousARPs is the number of KindGratuitousARP frames
+	GratuitousARPs uint64 `json:"gratuitous_arps"`
+	// DHCP is the number of KindDHCP frames
+	DHCP uint64 `json:"dhcp"`
+	// IPv4 is the number of KindIPv4 frames
+	IPv4 uint64 `json:"ipv4"`
+	// Malformed is the number of KindMalformed frames
+	Malformed uint64 `json:"malformed"`
+	// DHCPExchanges is the number of DHCP exchanges which reached the ack
+	DHCPExchanges uint64 `json:"dhcp_exchanges"`
+	// Seed is the seed the frames were generated from
+	Seed uint64 `json:"seed"`
+	// Hosts is the number of hosts which sent a valid ARP packet, the
+	// bindings the pipeline can learn
+	Hosts int `json:"hosts"`
+}
+
+// Count returns the number of frames of kind k
+func (s Summary) Count(k Kind) uint64 {
+	switch k {
+	case KindARPRequest:
+		return s.ARPRequests
+	case KindGratuitousARP:
+		return s.GratuitousARPs
+	case KindDHCP:
+		return s.DHCP
+	case KindIPv4:
+		return s.IPv4
+	case KindMalformed:
+		return s.Malformed
+	}
+
+	return 0
+}
+
+func (s *Summary) add(k Kind) {
+	switch k {
+	case KindARPRequest:
+		s.ARPRequests++
+	case KindGratuitousARP:
+		s.GratuitousARPs++
+	case KindDHCP:
+		s.DHCP++
+	case KindIPv4:
+		s.IPv4++
+	case KindMalformed:
+		s.Malformed++
+	}
+}
+
+// Rate returns the frames per second produced between Start and End
+func (s Summary) Rate() float64 {
+	elapsed := s.End.Sub(s.Start)
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(s.Frames) / elapsed.Seconds()
+}
+
+// Generator produces the frames, it implements capture.FrameReader and
+// capture.MetadataReader. A read blocks until the next frame is due.
+type Generator struct {
+	clock    clock.Clock
+	deadline time.Time
+	start    time.Time
+	rng      *rand.Rand
+	wake     chan struct{}
+	arpSeen  []uint64
+	iface    string
+	prefix   netip.Prefix
+	summary  Summary
+	dhcp     dhcpExchange
+	weights  [kinds]float64
+	rate     float64
+	count    uint64
+	hosts    int
+	mu       sync.Mutex
+	closed   bool
+}
+
+type config struct {
+	clock  clock.Clock
+	iface  string
+	prefix netip.Prefix
+	mix    Mix
+	seed   uint64
+	rate   float64
+	count  uint64
+	hosts  int
+}
+
+// Option configures a Generator
+type Option func(*config)
+
+// WithSeed sets the seed of the frames, the default is 0
+func WithSeed(seed uint64) Option {
+	return func(c *config) {
+		c.seed = seed
+	}
+}
+
+// WithRate sets the target number of frames per second, the default of 0
+// produces them as fast as they are consumed
+func WithRate(pps float64) Option {
+	return func(c *config) {
+		c.rate = pps
+	}
+}
+
+// WithCount stops the Generator after n frames, reads then return io.EOF.
+// The default of 0 never stops.
+func WithCount(n uint64) Option {
+	return func(c *config) {
+		c.count = n
+	}
+}
+
+// WithHosts sets the number of hosts exchanging frames, 256 by default
+func WithHosts(n int) Option {
+	return func(c *config) {
+		c.hosts = n
+	}
+}
+
+// WithPrefix sets the IPv4 subnet of the hosts, 10.0.0.0/8 by default. Its
+// first address is the DHCP server, the hosts follow.
+func WithPrefix(p netip.Prefix) Option {
+	return func(c *config) {
+		c.prefix = p.Masked()
+	}
+}
+
+// WithMix sets the share of each kind of frame
+func WithMix(m Mix) Option {
+	return func(c *config) {
+		c.mix = m
+	}
+}
+
+// WithInterface sets the interface reported in the metadata of the frames
+func WithInterface(name string) Option {
+	return func(c *config) {
+		c.iface = name
+	}
+}
+
+// WithClock sets the clock pacing the frames
+func WithClock(clk clock.Clock) Option {
+	return func(c *config) {
+		c.clock = clk
+	}
+}
+
+// New returns a Generator, it fails with ErrInvalidConfig when the mix has
+// no positive weight or the hosts don't fit in the prefix
+func New(options ...Option) (*Generator, error) {
+	cfg := config{
+		clock:  clock.System{},
+		prefix: netip.MustParsePrefix("10.0.0.0/8"),
+		mix:    DefaultMix(),
+		hosts:  defaultHosts,
+	}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	g := &Generator{
+		clock:   cfg.clock,
+		rng:     rand.New(rand.NewPCG(cfg.seed, cfg.seed)), //nolint:gosec // the frames are reproducible on purpose
+		wake:    make(chan struct{}, 1),
+		arpSeen: make([]uint64, (cfg.hosts+63)/64),
+		iface:   cfg.iface,
+		prefix:  cfg.prefix,
+		rate:    cfg.rate,
+		count:   cfg.count,
+		hosts:   cfg.hosts,
+	}
+
+	g.summary.Seed = cfg.seed
+
+	// the weights are made cumulative, a kind is picked by a single draw
+	var sum float64
+
+	for k, w := range cfg.mix.weights() {
+		sum += w
+		g.weights[k] = sum
+	}
+
+	for k := range g.weights {
+		g.weights[k] /= sum
+	}
+
+	return g, nil
+}
+
+func (c config) validate() error {
+	var sum float64
+
+	for k, w := range c.mix.weights() {
+		if w < 0 {
+			return fmt.Errorf("%w: negative weight for %s", ErrInvalidConfig, Kind(k))
+		}
+
+		sum += w
+	}
+
+	if sum == 0 {
+		return fmt.Errorf("%w: empty mix", ErrInvalidConfig)
+	}
+
+	if c.rate < 0 {
+		return fmt.Errorf("%w: negative rate", ErrInvalidConfig)
+	}
+
+	if !c.prefix.Addr().Is4() {
+		return fmt.Errorf("%w: prefix %s isn't IPv4", ErrInvalidConfig, c.prefix)
+	}
+
+	// the network address, the server and the broadcast address aren't hosts
+	if size := 1 << (32 - c.prefix.Bits()); c.hosts < 1 || c.hosts > min(size-3, maxHosts) {
+		return fmt.Errorf("%w: %d hosts in %s", ErrInvalidConfig, c.hosts, c.prefix)
+	}
+
+	return nil
+}
+
+// Host returns the address of the host i, starting at 0
+func (g *Generator) Host(i int) netip.Addr {
+	return hostAddr(g.prefix, i+2)
+}
+
+// Summary returns what has been produced so far
+func (g *Generator) Summary() Summary {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.summary
+}
+
+// ReadFrame waits for the next frame to be due and reads it into buf, it
+// returns io.EOF once the count is reached
+func (g *Generator) ReadFrame(buf []byte) (int, error) {
+	md, err := g.ReadFrameMetadata(buf)
+
+	return md.CaptureLength, err
+}
+
+// ReadFrameMetadata is ReadFrame, the frame is timestamped with the time it
+// was produced
+func (g *Generator) ReadFrameMetadata(buf []byte) (capture.Metadata, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.count != 0 && g.summary.Frames >= g.count {
+		return capture.Metadata{}, io.EOF
+	}
+
+	if err := g.waitDue(); err != nil {
+		return capture.Metadata{}, err
+	}
+
+	frame, err := g.next()
+	if err != nil {
+		return capture.Metadata{}, err
+	}
+
+	return capture.Metadata{
+		Timestamp:       g.summary.End,
+		TimestampSource: capture.TimestampSoftware,
+		Interface:       g.iface,
+		CaptureLength:   copy(buf, frame),
+		Length:          len(frame),
+	}, nil
+}
+
+// SetReadDeadline interrupts a read waiting at t, a zero t never does
+func (g *Generator) SetReadDeadline(t time.Time) error {
+	g.mu.Lock()
+	g.deadline = t
+	g.mu.Unlock()
+
+	g.notify()
+
+	return nil
+}
+
+// Close makes the reads fail with capture.ErrClosed
+func (g *Generator) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+
+	g.notify()
+
+	return nil
+}
+
+func (g *Generator) notify() {
+	select {
+	case g.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Transmit writes the frames to w at the rate until the count is reached or
+// ctx is done, the frames are written in batches to catch up with the rate
+// after a late wake up. It returns what has been transmitted.
+func (g *Generator) Transmit(ctx context.Context, w capture.FrameWriter) (Summary, error) {
+	batch := make([][]byte, 0, batchLen)
+
+	for ctx.Err() == nil {
+		g.mu.Lock()
+
+		if g.start.IsZero() {
+			g.start = g.clock.Now()
+			g.summary.Start = g.start
+		}
+
+		n := g.dueFrames(g.clock.Now())
+
+		batch = batch[:0]
+		for range min(n, batchLen) {
+			frame, err := g.next()
+			if err != nil {
+				g.mu.Unlock()
+				return g.Summary(), err
+			}
+
+			batch = append(batch, frame)
+		}
+
+		done := g.count != 0 && g.summary.Frames >= g.count
+		wait := g.due(g.summary.Frames).Sub(g.clock.Now())
+
+		g.mu.Unlock()
+
+		if len(batch) > 0 {
+			if _, err := capture.WriteFrames(w, batch); err != nil {
+				return g.Summary(), err
+			}
+		}
+
+		if done {
+			break
+		}
+
+		if len(batch) < batchLen && wait > 0 {
+			if err := g.clock.Sleep(ctx, wait); err != nil {
+				break
+			}
+		}
+	}
+
+	return g.Summary(), nil
+}
+
+// due returns the time the frame i is due, starting at 0
+func (g *Generator) due(i uint64) time.Time {
+	if g.rate == 0 {
+		return g.start
+	}
+
+	return g.start.Add(time.Duration(float64(i) / g.rate * float64(time.Second)))
+}
+
+// dueFrames returns the number of frames due at now which are yet to be
+// produced, the rate is kept over the whole run rather than per frame so
+// that a late frame doesn't delay the ones after it
+func (g *Generator) dueFrames(now time.Time) uint64 {
+	var n uint64
+
+	if g.rate == 0 {
+		n = batchLen
+	} else if elapsed := now.Sub(g.start); elapsed >= 0 {
+		total := uint64(elapsed.Seconds()*g.rate) + 1
+		n = total - min(total, g.summary.Frames)
+	}
+
+	if g.count != 0 {
+		n = min(n, g.count-g.summary.Frames)
+	}
+
+	return n
+}
+
+// waitDue waits for the next frame to be due, g.mu is held and released
+// while waiting
+func (g *Generator) waitDue() error {
+	if g.start.IsZero() {
+		g.start = g.clock.Now()
+		g.summary.Start = g.start
+	}
+
+	due := g.due(g.summary.Frames)
+
+	for {
+		if g.closed {
+			return capture.ErrClosed
+		}
+
+		now := g.clock.Now()
+
+		if !g.deadline.IsZero() && !now.Before(g.deadline) {
+			return os.ErrDeadlineExceeded
+		}
+
+		if !now.Before(due) {
+			return nil
+		}
+
+		wait := due.Sub(now)
+		if !g.deadline.IsZero() {
+			wait = min(wait, g.deadline.Sub(now))
+		}
+
+		t := g.clock.NewTimer(wait)
+
+		g.mu.Unlock()
+
+		select {
+		case <-t.C():
+		case <-g.wake:
+		}
+
+		t.Stop()
+		g.mu.Lock()
+	}
+}
+
+// next builds the next frame and accounts for it
+func (g *Generator) next() ([]byte, error) {
+	draw := g.rng.Float64()
+
+	kind := KindMalformed
+	for k, w := range g.weights {
+		if draw < w {
+			kind = Kind(k) //nolint:gosec // there are fewer kinds than 256
+			break
+		}
+	}
+
+	frame, err := g.build(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	g.summary.Frames++
+	g.summary.Bytes += uint64(len(frame))
+	g.summary.add(kind)
+	g.summary.End = g.clock.Now()
+
+	return frame, nil
+}
+
+// sawARP records that the host i sent a valid ARP packet
+func (g *Generator) sawARP(i int) {
+	if word, bit := i/64, uint64(1)<<(i%64); g.arpSeen[word]&bit == 0 {
+		g.arpSeen[word] |= bit
+		g.summary.Hosts++
+	}
+}
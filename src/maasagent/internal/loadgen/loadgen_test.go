@@ -0,0 +1,279 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package loadgen
+
+import (
+	"context"
+	"io"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+// frameRecorder is a capture.FrameWriter keeping the frames written
+type frameRecorder struct {
+	frames [][]byte
+}
+
+func (r *frameRecorder) WriteFrame(frame []byte) error {
+	r.frames = append(r.frames, frame)
+	return nil
+}
+
+func readAll(t *testing.T, g *Generator) [][]byte {
+	t.Helper()
+
+	var frames [][]byte
+
+	buf := make([]byte, 1514)
+
+	for {
+		n, err := g.ReadFrame(buf)
+		if err == io.EOF {
+			return frames
+		}
+
+		require.NoError(t, err)
+
+		frames = append(frames, append([]byte(nil), buf[:n]...))
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string][]Option{
+		"negative weight": {WithMix(Mix{ARPRequest: 1, Malformed: -1})},
+		"empty mix":       {WithMix(Mix{})},
+		"negative rate":   {WithRate(-1)},
+		"IPv6 prefix":     {WithPrefix(netip.MustParsePrefix("2001:db8::/64"))},
+		"no hosts":        {WithHosts(0)},
+		"too many hosts":  {WithPrefix(netip.MustParsePrefix("192.168.0.0/29")), WithHosts(6)},
+	}
+
+	for name, options := range testcases {
+		options := options
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := New(options...)
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+		})
+	}
+
+	// the network, server and broadcast addresses aside, a /29 holds 5
+	_, err := New(WithPrefix(netip.MustParsePrefix("192.168.0.0/29")), WithHosts(5))
+	assert.NoError(t, err)
+}
+
+func TestGeneratorDeterministic(t *testing.T) {
+	t.Parallel()
+
+	generate := func(seed uint64) [][]byte {
+		g, err := New(WithSeed(seed), WithCount(1000))
+		require.NoError(t, err)
+
+		return readAll(t, g)
+	}
+
+	first := generate(42)
+
+	assert.Len(t, first, 1000)
+	assert.Equal(t, first, generate(42))
+	assert.NotEqual(t, first, generate(43))
+}
+
+func TestGeneratorMix(t *testing.T) {
+	t.Parallel()
+
+	const count = 20000
+
+	g, err := New(WithSeed(1), WithCount(count))
+	require.NoError(t, err)
+
+	frames := readAll(t, g)
+	require.Len(t, frames, count)
+
+	s := g.Summary()
+	assert.Equal(t, uint64(count), s.Frames)
+	assert.Equal(t, uint64(1), s.Seed)
+
+	var total, bytes uint64
+
+	mix := DefaultMix().weights()
+
+	for k := range kinds {
+		total += s.Count(k)
+
+		// the weights of DefaultMix add up to 100
+		assert.InDelta(t, mix[k], float64(s.Count(k))*100/count, 1, k.String())
+	}
+
+	for _, f := range frames {
+		bytes += uint64(len(f))
+	}
+
+	assert.Equal(t, s.Frames, total)
+	assert.Equal(t, bytes, s.Bytes)
+	assert.Equal(t, s.DHCP/4, s.DHCPExchanges)
+	assert.Equal(t, defaultHosts, s.Hosts)
+}
+
+func TestGeneratorReadPacing(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+
+	g, err := New(WithRate(10), WithClock(clk), WithInterface("veth0"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 1514)
+
+	// the first frame is due straight away
+	md, err := g.ReadFrameMetadata(buf)
+	require.NoError(t, err)
+	assert.Equal(t, start, md.Timestamp)
+	assert.Equal(t, "veth0", md.Interface)
+	assert.Equal(t, capture.TimestampSoftware, md.TimestampSource)
+
+	mdC := make(chan capture.Metadata)
+
+	go func() {
+		md, err := g.ReadFrameMetadata(buf)
+		assert.NoError(t, err)
+
+		mdC <- md
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(50 * time.Millisecond)
+
+	select {
+	case <-mdC:
+		t.Fatal("frame read before it was due")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clk.BlockUntil(1)
+	clk.Advance(50 * time.Millisecond)
+
+	assert.Equal(t, start.Add(100*time.Millisecond), (<-mdC).Timestamp)
+}
+
+func TestGeneratorInterrupt(t *testing.T) {
+	t.Parallel()
+
+	// the second frame is due in 1000s
+	g, err := New(WithRate(0.001))
+	require.NoError(t, err)
+
+	buf := make([]byte, 1514)
+
+	_, err = g.ReadFrame(buf)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := capture.InterruptReads(ctx, g)
+
+	defer stop()
+
+	errC := make(chan error)
+
+	go func() {
+		_, err := g.ReadFrame(buf)
+		errC <- err
+	}()
+
+	cancel()
+	assert.ErrorIs(t, <-errC, os.ErrDeadlineExceeded)
+
+	require.NoError(t, g.SetReadDeadline(time.Time{}))
+
+	go func() {
+		_, err := g.ReadFrame(buf)
+		errC <- err
+	}()
+
+	require.NoError(t, g.Close())
+	assert.ErrorIs(t, <-errC, capture.ErrClosed)
+}
+
+func TestTransmit(t *testing.T) {
+	t.Parallel()
+
+	g, err := New(WithSeed(7), WithCount(200))
+	require.NoError(t, err)
+
+	var w frameRecorder
+
+	s, err := g.Transmit(context.Background(), &w)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(200), s.Frames)
+
+	// the frames don't depend on how they are consumed
+	g, err = New(WithSeed(7), WithCount(200))
+	require.NoError(t, err)
+
+	assert.Equal(t, readAll(t, g), w.frames)
+}
+
+func TestTransmitRate(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+
+	g, err := New(WithRate(1000), WithClock(clk))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	summaryC := make(chan Summary)
+
+	var w frameRecorder
+
+	go func() {
+		s, err := g.Transmit(ctx, &w)
+		assert.NoError(t, err)
+
+		summaryC <- s
+	}()
+
+	// the clock jumps by 100 frames at each step, Transmit catches up in
+	// batches
+	for range 10 {
+		clk.BlockUntil(1)
+		clk.Advance(100 * time.Millisecond)
+	}
+
+	clk.BlockUntil(1)
+	cancel()
+
+	s := <-summaryC
+	assert.Equal(t, uint64(1001), s.Frames)
+	assert.Len(t, w.frames, 1001)
+	assert.Equal(t, start, s.Start)
+	assert.Equal(t, start.Add(time.Second), s.End)
+	assert.InDelta(t, 1001, s.Rate(), 0.01)
+}
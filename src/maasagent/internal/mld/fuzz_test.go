@@ -0,0 +1,70 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mld
+
+import (
+	"net/netip"
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+func mldFrame(pkt []byte) []byte {
+	frame := []byte{
+		0x33, 0x33, 0x00, 0x00, 0x00, 0x16,
+		0x00, 0x16, 0x3e, 0x00, 0x00, 0x01,
+		0x86, 0xdd,
+	}
+
+	return append(frame, pkt...)
+}
+
+func FuzzParseFrame(f *testing.F) {
+	for _, seed := range [][]byte{
+		mldFrame(ipv6Packet(nextHeaderHopByHop, routerAlert, v2Report())),
+		mldFrame(ipv6Packet(nextHeaderHopByHop, routerAlert, v1Message(TypeReportV1, 0, testGroup))),
+		mldFrame(ipv6Packet(NextHeaderICMPv6, v1Message(TypeQuery, 10000, netip.IPv6Unspecified()))),
+		mldFrame(ipv6Packet(NextHeaderICMPv6)),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			msg, _, err := ParseFrame(in)
+			if err != nil {
+				return
+			}
+
+			for _, r := range msg.Records {
+				_ = r.Listening()
+			}
+		})
+	})
+}
+
+func FuzzMessage(f *testing.F) {
+	f.Add(v2Report())
+	f.Add(v1Message(TypeDone, 0, testGroup))
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			var msg Message
+
+			_ = msg.UnmarshalBinary(in)
+		})
+	})
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mld
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"maas.io/core/src/maasagent/internal/checksum"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	ipv6HeaderLen = 40
+
+	nextHeaderHopByHop    = 0
+	nextHeaderRouting     = 43
+	nextHeaderFragment    = 44
+	nextHeaderAH          = 51
+	nextHeaderDestination = 60
+	nextHeaderMobility    = 135
+	nextHeaderHIP         = 139
+	nextHeaderShim6       = 140
+	// NextHeaderICMPv6 is the upper layer protocol number of ICMPv6
+	NextHeaderICMPv6 = 58
+
+	optionPad1        = 0
+	optionRouterAlert = 5
+	// routerAlertMLD is the Router Alert value of MLD messages, RFC 2711
+	routerAlertMLD = 0
+)
+
+// IPv6 is an IPv6 packet after its extension headers have been walked
+type IPv6 struct {
+	Src netip.Addr
+	Dst netip.Addr
+	// Payload is the upper layer payload, bounded by the payload length
+	Payload []byte
+	// NextHeader is the protocol of Payload
+	NextHeader uint8
+	HopLimit   uint8
+	// RouterAlertMLD is true when a Hop-by-Hop Router Alert option holds
+	// the value assigned to MLD
+	RouterAlertMLD bool
+	// Fragment is true when the packet is a non-first fragment, its
+	// payload doesn't start with an upper layer header
+	Fragment bool
+}
+
+// UnmarshalBinary parses an IPv6 packet and walks its extension headers
+// up to the upper layer protocol
+func (p *IPv6) UnmarshalBinary(buf []byte) error {
+	budget := ethernet.ParserOptions{}.Budget()
+
+	return p.UnmarshalWith(buf, ethernet.ParserOptions{}, &budget)
+}
+
+// UnmarshalWith is UnmarshalBinary walking up to the extension headers and
+// Hop-by-Hop options of the limits of opts, see ethernet.DecodeLimits. The
+// walk spends budget, which the decoders of the rest of the frame share.
+func (p *IPv6) UnmarshalWith(buf []byte, opts ethernet.ParserOptions, budget *ethernet.DecodeBudget) error {
+	if len(buf) < ipv6HeaderLen {
+		return truncated("header")
+	}
+
+	if buf[0]>>4 != 6 {
+		return ErrMalformedPacket
+	}
+
+	// the header of a packet cut short is still set
+	*p = IPv6{
+		NextHeader: buf[6],
+		HopLimit:   buf[7],
+		Src:        netip.AddrFrom16([16]byte(buf[8:24])),
+		Dst:        netip.AddrFrom16([16]byte(buf[24:40])),
+	}
+
+	length := int(binary.BigEndian.Uint16(buf[4:6]))
+	if len(buf) < ipv6HeaderLen+length {
+		return truncated("payload of %d bytes in %d", length, len(buf)-ipv6HeaderLen)
+	}
+
+	// trailing bytes are ethernet padding
+	payload := buf[ipv6HeaderLen : ipv6HeaderLen+length]
+	limits := opts.DecodeLimits()
+
+	for n := 0; ; n++ {
+		var hdrLen int
+
+		switch p.NextHeader {
+		case nextHeaderHopByHop, nextHeaderRouting, nextHeaderDestination,
+			nextHeaderMobility, nextHeaderHIP, nextHeaderShim6:
+			if len(payload) < 2 {
+				return truncated("extension header %d", p.NextHeader)
+			}
+
+			hdrLen = (int(payload[1]) + 1) * 8
+		case nextHeaderFragment:
+			hdrLen = 8
+		case nextHeaderAH:
+			if len(payload) < 2 {
+				return truncated("authentication header")
+			}
+
+			hdrLen = (int(payload[1]) + 2) * 4
+		default:
+			p.Payload = payload
+
+			return nil
+		}
+
+		if n == limits.ExtensionHeaders {
+			return ethernet.LimitExceeded("IPv6", "extension headers", ErrMalformedPacket, limits.ExtensionHeaders,
+				"extension headers")
+		}
+
+		if err := budget.Spend("IPv6", ErrMalformedPacket); err != nil {
+			return err
+		}
+
+		if len(payload) < hdrLen {
+			return truncated("extension header %d", p.NextHeader)
+		}
+
+		switch p.NextHeader {
+		case nextHeaderHopByHop:
+			if err := p.parseHopByHop(payload[2:hdrLen], limits.Options, budget); err != nil {
+				return err
+			}
+		case nextHeaderFragment:
+			if binary.BigEndian.Uint16(payload[2:4])&0xfff8 != 0 {
+				p.Fragment = true
+			}
+		}
+
+		p.NextHeader = payload[0]
+		payload = payload[hdrLen:]
+
+		if p.Fragment {
+			p.Payload = payload
+
+			return nil
+		}
+	}
+}
+
+func (p *IPv6) parseHopByHop(options []byte, limit int, budget *ethernet.DecodeBudget) error {
+	for n := 0; len(options) > 0; n++ {
+		if n == limit {
+			return ethernet.LimitExceeded("IPv6", "Hop-by-Hop options", ErrMalformedPacket, limit, "options")
+		}
+
+		if err := budget.Spend("IPv6", ErrMalformedPacket); err != nil {
+			return err
+		}
+
+		if options[0] == optionPad1 {
+			options = options[1:]
+			continue
+		}
+
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			return truncated("Hop-by-Hop option %d", options[0])
+		}
+
+		typ, data := options[0], options[2:2+int(options[1])]
+
+		if typ == optionRouterAlert && len(data) == 2 && binary.BigEndian.Uint16(data) == routerAlertMLD {
+			p.RouterAlertMLD = true
+		}
+
+		options = options[2+len(data):]
+	}
+
+	return nil
+}
+
+// VerifyChecksum returns an error matching ErrMalformedPacket and
+// checksum.ErrMismatch unless the checksum of the ICMPv6 message of the
+// packet is correct
+func (p IPv6) VerifyChecksum() error {
+	var pseudo [ipv6HeaderLen]byte
+
+	src, dst := p.Src.As16(), p.Dst.As16()
+	copy(pseudo[0:16], src[:])
+	copy(pseudo[16:32], dst[:])
+	binary.BigEndian.PutUint32(pseudo[32:36], uint32(len(p.Payload))) //nolint:gosec // bounded by the payload length
+	pseudo[39] = NextHeaderICMPv6
+
+	if checksum.Sum(pseudo[:], p.Payload) != 0 {
+		return fmt.Errorf("%w: ICMPv6: %w", ErrMalformedPacket, checksum.ErrMismatch)
+	}
+
+	return nil
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mld
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var (
+	testSrc = netip.MustParseAddr("fe80::216:3eff:fe00:1")
+	testDst = netip.MustParseAddr("ff02::16")
+	// routerAlert is a Hop-by-Hop header with the MLD Router Alert option
+	// and a PadN option
+	routerAlert = []byte{NextHeaderICMPv6, 0x00, 0x05, 0x02, 0x00, 0x00, 0x01, 0x00}
+)
+
+func ipv6Packet(nextHeader uint8, payload ...[]byte) []byte {
+	var body []byte
+
+	for _, p := range payload {
+		body = append(body, p...)
+	}
+
+	pkt := make([]byte, ipv6HeaderLen)
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:6], uint16(len(body))) //nolint:gosec // test packets are small
+	pkt[6] = nextHeader
+	pkt[7] = 1
+	copy(pkt[8:24], testSrc.AsSlice())
+	copy(pkt[24:40], testDst.AsSlice())
+
+	return append(pkt, body...)
+}
+
+func TestIPv6UnmarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	icmp := []byte{0x8f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	testcases := map[string]struct {
+		in  []byte
+		out IPv6
+		err error
+	}{
+		"router alert": {
+			in: ipv6Packet(nextHeaderHopByHop, routerAlert, icmp),
+			out: IPv6{
+				NextHeader:     NextHeaderICMPv6,
+				Payload:        icmp,
+				RouterAlertMLD: true,
+			},
+		},
+		"destination options and padding": {
+			in: append(ipv6Packet(nextHeaderDestination,
+				[]byte{NextHeaderICMPv6, 0x00, 0x00, 0x00, 0x01, 0x02, 0x00, 0x00}, icmp), 0x00, 0x00),
+			out: IPv6{
+				NextHeader: NextHeaderICMPv6,
+				Payload:    icmp,
+			},
+		},
+		"router alert of another protocol": {
+			in: ipv6Packet(nextHeaderHopByHop,
+				[]byte{NextHeaderICMPv6, 0x00, 0x05, 0x02, 0x00, 0x02, 0x01, 0x00}, icmp),
+			out: IPv6{
+				NextHeader: NextHeaderICMPv6,
+				Payload:    icmp,
+			},
+		},
+		"first fragment": {
+			in: ipv6Packet(nextHeaderFragment,
+				[]byte{NextHeaderICMPv6, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x2a}, icmp),
+			out: IPv6{
+				NextHeader: NextHeaderICMPv6,
+				Payload:    icmp,
+			},
+		},
+		"later fragment": {
+			in: ipv6Packet(nextHeaderFragment,
+				[]byte{NextHeaderICMPv6, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x2a}, icmp),
+			out: IPv6{
+				NextHeader: NextHeaderICMPv6,
+				Payload:    icmp,
+				Fragment:   true,
+			},
+		},
+		"authentication header": {
+			in: ipv6Packet(nextHeaderAH, []byte{NextHeaderICMPv6, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, icmp),
+			out: IPv6{
+				NextHeader: NextHeaderICMPv6,
+				Payload:    icmp,
+			},
+		},
+		"truncated extension header": {
+			in:  ipv6Packet(nextHeaderHopByHop, routerAlert[:6]),
+			err: ErrMalformedPacket,
+		},
+		"truncated option": {
+			in:  ipv6Packet(nextHeaderHopByHop, []byte{NextHeaderICMPv6, 0x00, 0x05, 0x02, 0x00, 0x00, 0x01, 0x01}),
+			err: ErrMalformedPacket,
+		},
+		"payload length past the end": {
+			in:  ipv6Packet(NextHeaderICMPv6, icmp)[:45],
+			err: ErrMalformedPacket,
+		},
+		"IPv4": {
+			in:  append([]byte{0x45}, make([]byte, 39)...),
+			err: ErrMalformedPacket,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var p IPv6
+
+			err := p.UnmarshalBinary(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			tc.out.Src, tc.out.Dst, tc.out.HopLimit = testSrc, testDst, 1
+			assert.Equal(t, tc.out, p)
+		})
+	}
+}
+
+func TestIPv6DecodeLimits(t *testing.T) {
+	t.Parallel()
+
+	icmp := []byte{0x8f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	// chain returns n destination options headers of 8 bytes, followed by
+	// the ICMPv6 payload
+	chain := func(n int) []byte {
+		pkt := ipv6Packet(nextHeaderDestination)
+
+		for i := range n {
+			next := byte(nextHeaderDestination)
+			if i == n-1 {
+				next = NextHeaderICMPv6
+			}
+
+			pkt = append(pkt, next, 0x00, 0x01, 0x04, 0x00, 0x00, 0x00, 0x00)
+		}
+
+		pkt = append(pkt, icmp...)
+		binary.BigEndian.PutUint16(pkt[4:6], uint16(len(pkt)-ipv6HeaderLen)) //nolint:gosec // test packets are small
+
+		return pkt
+	}
+
+	// pads returns a Hop-by-Hop header of Pad1 options of n bytes
+	pads := func(n int) []byte {
+		hdr := make([]byte, n)
+		hdr[0], hdr[1] = NextHeaderICMPv6, byte(n/8-1) //nolint:gosec // at most 2048 bytes
+
+		return ipv6Packet(nextHeaderHopByHop, hdr, icmp)
+	}
+
+	testcases := map[string]struct {
+		limits ethernet.DecodeLimits
+		in     []byte
+		err    bool
+	}{
+		"extension headers at the limit": {
+			in: chain(ethernet.DefaultExtensionHeaderLimit),
+		},
+		"extension headers past the limit": {
+			in:  chain(ethernet.DefaultExtensionHeaderLimit + 1),
+			err: true,
+		},
+		"extension headers past a custom limit": {
+			limits: ethernet.DecodeLimits{ExtensionHeaders: 2},
+			in:     chain(3),
+			err:    true,
+		},
+		"options within the limit": {
+			in: pads(256),
+		},
+		"options past the limit": {
+			in:  pads(1024),
+			err: true,
+		},
+		"options past the budget": {
+			limits: ethernet.DecodeLimits{Options: 2000, Frame: 1000},
+			in:     pads(1024),
+			err:    true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var p IPv6
+
+			opts := ethernet.ParserOptions{Limits: tc.limits}
+			budget := opts.Budget()
+
+			err := p.UnmarshalWith(tc.in, opts, &budget)
+			if tc.err {
+				assert.ErrorIs(t, err, ethernet.ErrDecodeLimitExceeded)
+				assert.ErrorIs(t, err, ErrMalformedPacket)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, icmp, p.Payload)
+		})
+	}
+}
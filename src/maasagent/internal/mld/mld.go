@@ -0,0 +1,332 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package mld decodes the Multicast Listener Discovery messages IPv6 hosts
+// send to join multicast groups, RFC 2710 for version 1 and RFC 3810 for
+// version 2.
+package mld
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var (
+	// ErrMalformedPacket is returned when an IPv6 packet or an MLD message
+	// is too short or inconsistent
+	ErrMalformedPacket = errors.New("malformed packet")
+	// ErrNotMLD is returned when parsing a frame which doesn't carry an MLD
+	// message
+	ErrNotMLD = errors.New("not an MLD message")
+)
+
+// truncated returns an error matching ErrMalformedPacket for a packet
+// ending before what it declares, it also matches io.ErrUnexpectedEOF for
+// the callers telling a capture's snaplen apart
+func truncated(format string, args ...any) error {
+	return fmt.Errorf("%w: truncated %s: %w", ErrMalformedPacket, fmt.Sprintf(format, args...), io.ErrUnexpectedEOF)
+}
+
+const (
+	ethernetHeaderLen = 14
+	ethertypeIPv6     = 0x86dd
+
+	mldv1Len      = 24
+	mldv2QueryLen = 28
+	reportLen     = 8
+	recordLen     = 20
+)
+
+// Type is the ICMPv6 type of an MLD message
+type Type uint8
+
+const (
+	// TypeQuery is sent by the querier router, for all groups or a single one
+	TypeQuery Type = 130
+	// TypeReportV1 is an MLDv1 report of a host listening to a group
+	TypeReportV1 Type = 131
+	// TypeDone is an MLDv1 message of a host leaving a group
+	TypeDone Type = 132
+	// TypeReportV2 is an MLDv2 report, with a record per group
+	TypeReportV2 Type = 143
+)
+
+// String returns the name of the message type
+func (t Type) String() string {
+	switch t {
+	case TypeQuery:
+		return "Query"
+	case TypeReportV1:
+		return "ReportV1"
+	case TypeDone:
+		return "Done"
+	case TypeReportV2:
+		return "ReportV2"
+	}
+
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
+// RecordType is the type of an MLDv2 multicast address record
+type RecordType uint8
+
+const (
+	// RecordModeIsInclude reports a listener of the sources of the record
+	RecordModeIsInclude RecordType = 1
+	// RecordModeIsExclude reports a listener of all but the sources
+	RecordModeIsExclude RecordType = 2
+	// RecordChangeToInclude switches to the sources of the record, it
+	// leaves the group when there are none
+	RecordChangeToInclude RecordType = 3
+	// RecordChangeToExclude switches to all but the sources, it joins the
+	// group when there are none
+	RecordChangeToExclude RecordType = 4
+	// RecordAllowNewSources adds sources to listen to
+	RecordAllowNewSources RecordType = 5
+	// RecordBlockOldSources removes sources to listen to
+	RecordBlockOldSources RecordType = 6
+)
+
+// AddressRecord is the state of a listener for a multicast address
+type AddressRecord struct {
+	Multicast netip.Addr
+	Sources   []netip.Addr
+	Type      RecordType
+}
+
+// Listening returns true when the record leaves the host listening to the
+// group for at least one source. A block record only prunes sources.
+func (r AddressRecord) Listening() bool {
+	switch r.Type {
+	case RecordModeIsExclude, RecordChangeToExclude:
+		return true
+	case RecordModeIsInclude, RecordChangeToInclude, RecordAllowNewSources:
+		return len(r.Sources) > 0
+	}
+
+	return false
+}
+
+// Message is an MLD message
+type Message struct {
+	// Multicast is the group of an MLDv1 report, a done message or a
+	// group specific query, it is unspecified for a general query
+	Multicast netip.Addr
+	// Sources are the sources of a source specific MLDv2 query
+	Sources []netip.Addr
+	// Records are the records of an MLDv2 report
+	Records []AddressRecord
+	// MaxResponseDelay is the time a host has to answer a query
+	MaxResponseDelay time.Duration
+	Type             Type
+	// Version is 1 or 2, queries of both versions share a type
+	Version uint8
+}
+
+// UnmarshalBinary parses an ICMPv6 message into an MLD message
+func (m *Message) UnmarshalBinary(buf []byte) error {
+	budget := ethernet.ParserOptions{}.Budget()
+
+	return m.unmarshal(buf, &budget)
+}
+
+// unmarshal is UnmarshalBinary spending budget on the records of a report
+func (m *Message) unmarshal(buf []byte, budget *ethernet.DecodeBudget) error {
+	if len(buf) < reportLen {
+		return truncated("message")
+	}
+
+	*m = Message{Type: Type(buf[0])}
+
+	switch m.Type {
+	case TypeQuery, TypeReportV1, TypeDone:
+		return m.unmarshalV1(buf)
+	case TypeReportV2:
+		return m.unmarshalReport(buf, budget)
+	}
+
+	return fmt.Errorf("%w: ICMPv6 type %d", ErrNotMLD, buf[0])
+}
+
+func (m *Message) unmarshalV1(buf []byte) error {
+	if len(buf) < mldv1Len {
+		return truncated("message")
+	}
+
+	m.Version = 1
+	m.MaxResponseDelay = maxResponseDelay(binary.BigEndian.Uint16(buf[4:6]))
+	m.Multicast = netip.AddrFrom16([16]byte(buf[8:24]))
+
+	// RFC 3810 tells the versions of queries apart by their length
+	if m.Type != TypeQuery || len(buf) < mldv2QueryLen {
+		return nil
+	}
+
+	m.Version = 2
+
+	sources, _, err := parseSources(buf[mldv2QueryLen:], int(binary.BigEndian.Uint16(buf[26:28])))
+	if err != nil {
+		return err
+	}
+
+	m.Sources = sources
+
+	return nil
+}
+
+func (m *Message) unmarshalReport(buf []byte, budget *ethernet.DecodeBudget) error {
+	m.Version = 2
+
+	n := int(binary.BigEndian.Uint16(buf[6:8]))
+	buf = buf[reportLen:]
+
+	for i := range n {
+		if len(buf) < recordLen {
+			return truncated("record %d", i)
+		}
+
+		if err := budget.Spend("MLD", ErrMalformedPacket); err != nil {
+			return err
+		}
+
+		r := AddressRecord{
+			Type:      RecordType(buf[0]),
+			Multicast: netip.AddrFrom16([16]byte(buf[4:20])),
+		}
+		aux := int(buf[1]) * 4
+
+		sources, rest, err := parseSources(buf[recordLen:], int(binary.BigEndian.Uint16(buf[2:4])))
+		if err != nil {
+			return fmt.Errorf("record %d: %w", i, err)
+		}
+
+		if len(rest) < aux {
+			return truncated("auxiliary data of record %d", i)
+		}
+
+		r.Sources = sources
+		m.Records = append(m.Records, r)
+		buf = rest[aux:]
+	}
+
+	return nil
+}
+
+func parseSources(buf []byte, n int) ([]netip.Addr, []byte, error) {
+	if len(buf) < n*16 {
+		return nil, nil, truncated("list of %d sources", n)
+	}
+
+	var sources []netip.Addr
+
+	for i := range n {
+		sources = append(sources, netip.AddrFrom16([16]byte(buf[i*16:(i+1)*16])))
+	}
+
+	return sources, buf[n*16:], nil
+}
+
+// maxResponseDelay decodes the maximum response code of a query, in
+// milliseconds with a floating point encoding for large values
+func maxResponseDelay(code uint16) time.Duration {
+	if code < 0x8000 {
+		return time.Duration(code) * time.Millisecond
+	}
+
+	mant := uint64(code & 0x0fff)
+	exp := (code >> 12) & 0x07
+
+	return time.Duration((mant|0x1000)<<(exp+3)) * time.Millisecond //nolint:gosec // at most 2^28
+}
+
+// ParseFrame returns the MLD message of an ethernet frame, after any VLAN
+// tags and IPv6 extension headers, together with its IPv6 header
+func ParseFrame(frame []byte) (Message, IPv6, error) {
+	return ParseFrameWith(frame, ethernet.ParserOptions{})
+}
+
+// ParseFrameWith is ParseFrame with the checksum of the message verified
+// as the options decide, see ethernet.ParserOptions.VerifyChecksums, and
+// within their limits, see ethernet.DecodeLimits
+func ParseFrameWith(frame []byte, opts ethernet.ParserOptions) (Message, IPv6, error) {
+	var (
+		msg Message
+		pkt IPv6
+	)
+
+	if len(frame) < ethernetHeaderLen {
+		return msg, pkt, truncated("ethernet header")
+	}
+
+	budget := opts.Budget()
+
+	off, ethertype, err := SkipTags(frame, opts, &budget)
+	if err != nil {
+		return msg, pkt, err
+	}
+
+	if ethertype != ethertypeIPv6 {
+		return msg, pkt, ErrNotMLD
+	}
+
+	if err := pkt.UnmarshalWith(frame[off+2:], opts, &budget); err != nil {
+		return msg, pkt, err
+	}
+
+	if pkt.NextHeader != NextHeaderICMPv6 || pkt.Fragment {
+		return msg, pkt, ErrNotMLD
+	}
+
+	if opts.VerifyChecksums() {
+		if err := pkt.VerifyChecksum(); err != nil {
+			return msg, pkt, err
+		}
+	}
+
+	err = msg.unmarshal(pkt.Payload, &budget)
+
+	return msg, pkt, err
+}
+
+// SkipTags returns the offset of the ethertype following the VLAN tags of
+// frame, of at least an ethernet header, and that ethertype. The tags past
+// the limits of opts, see ethernet.DecodeLimits, are an error matching
+// ErrMalformedPacket and ethernet.ErrDecodeLimitExceeded.
+func SkipTags(frame []byte, opts ethernet.ParserOptions, budget *ethernet.DecodeBudget) (int, uint16, error) {
+	off := 12
+	ethertype := binary.BigEndian.Uint16(frame[off:])
+	limit := opts.DecodeLimits().Tags
+
+	for n := 0; (ethertype == 0x8100 || ethertype == 0x88a8) && len(frame) >= off+6; n++ {
+		if n == limit {
+			return 0, 0, ethernet.LimitExceeded("VLAN", "tags", ErrMalformedPacket, limit, "tags")
+		}
+
+		if err := budget.Spend("VLAN", ErrMalformedPacket); err != nil {
+			return 0, 0, err
+		}
+
+		off += 4
+		ethertype = binary.BigEndian.Uint16(frame[off:])
+	}
+
+	return off, ethertype, nil
+}
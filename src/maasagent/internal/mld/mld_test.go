@@ -0,0 +1,265 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mld
+
+import (
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/checksum"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var (
+	testGroup  = netip.MustParseAddr("ff02::1:ff00:1")
+	testSource = netip.MustParseAddr("2001:db8::1")
+)
+
+func v1Message(t Type, delay uint16, group netip.Addr) []byte {
+	msg := []byte{byte(t), 0x00, 0x00, 0x00, byte(delay >> 8), byte(delay), 0x00, 0x00}
+
+	return append(msg, group.AsSlice()...)
+}
+
+func v2Report() []byte {
+	msg := []byte{byte(TypeReportV2), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02}
+	// a join of testGroup, with one word of auxiliary data
+	msg = append(msg, byte(RecordChangeToExclude), 0x01, 0x00, 0x00)
+	msg = append(msg, testGroup.AsSlice()...)
+	msg = append(msg, 0xde, 0xad, 0xbe, 0xef)
+	// listening to a single source of ff05::1:3
+	msg = append(msg, byte(RecordAllowNewSources), 0x00, 0x00, 0x01)
+	msg = append(msg, netip.MustParseAddr("ff05::1:3").AsSlice()...)
+
+	return append(msg, testSource.AsSlice()...)
+}
+
+func TestMessageUnmarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	v2Query := append(v1Message(TypeQuery, 0x8123, testGroup), 0x02, 0x7d, 0x00, 0x01)
+	v2Query = append(v2Query, testSource.AsSlice()...)
+
+	testcases := map[string]struct {
+		in  []byte
+		out Message
+		err error
+	}{
+		"v1 general query": {
+			in: v1Message(TypeQuery, 10000, netip.IPv6Unspecified()),
+			out: Message{
+				Multicast:        netip.IPv6Unspecified(),
+				MaxResponseDelay: 10 * time.Second,
+				Type:             TypeQuery,
+				Version:          1,
+			},
+		},
+		"v2 source specific query": {
+			in: v2Query,
+			out: Message{
+				Multicast:        testGroup,
+				Sources:          []netip.Addr{testSource},
+				MaxResponseDelay: (0x123 | 0x1000) << 3 * time.Millisecond,
+				Type:             TypeQuery,
+				Version:          2,
+			},
+		},
+		"v1 report": {
+			in: v1Message(TypeReportV1, 0, testGroup),
+			out: Message{
+				Multicast: testGroup,
+				Type:      TypeReportV1,
+				Version:   1,
+			},
+		},
+		"done": {
+			in: v1Message(TypeDone, 0, testGroup),
+			out: Message{
+				Multicast: testGroup,
+				Type:      TypeDone,
+				Version:   1,
+			},
+		},
+		"v2 report": {
+			in: v2Report(),
+			out: Message{
+				Records: []AddressRecord{
+					{Multicast: testGroup, Type: RecordChangeToExclude},
+					{
+						Multicast: netip.MustParseAddr("ff05::1:3"),
+						Sources:   []netip.Addr{testSource},
+						Type:      RecordAllowNewSources,
+					},
+				},
+				Type:    TypeReportV2,
+				Version: 2,
+			},
+		},
+		"v2 report truncated record": {
+			in:  v2Report()[:20],
+			err: ErrMalformedPacket,
+		},
+		"v2 report truncated auxiliary data": {
+			in:  v2Report()[:30],
+			err: ErrMalformedPacket,
+		},
+		"v2 report truncated sources": {
+			in:  v2Report()[:60],
+			err: ErrMalformedPacket,
+		},
+		"v2 query truncated sources": {
+			in:  v2Query[:40],
+			err: ErrMalformedPacket,
+		},
+		"truncated v1": {
+			in:  v1Message(TypeReportV1, 0, testGroup)[:20],
+			err: ErrMalformedPacket,
+		},
+		"echo request": {
+			in:  []byte{0x80, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01},
+			err: ErrNotMLD,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var m Message
+
+			err := m.UnmarshalBinary(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, m)
+		})
+	}
+}
+
+func TestAddressRecordListening(t *testing.T) {
+	t.Parallel()
+
+	sources := []netip.Addr{testSource}
+
+	testcases := map[string]struct {
+		in  AddressRecord
+		out bool
+	}{
+		"exclude none": {
+			in:  AddressRecord{Type: RecordChangeToExclude},
+			out: true,
+		},
+		"include none": {
+			in: AddressRecord{Type: RecordChangeToInclude},
+		},
+		"include some": {
+			in:  AddressRecord{Type: RecordModeIsInclude, Sources: sources},
+			out: true,
+		},
+		"block": {
+			in: AddressRecord{Type: RecordBlockOldSources, Sources: sources},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.in.Listening())
+		})
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	t.Parallel()
+
+	ethernet := []byte{
+		0x33, 0x33, 0x00, 0x00, 0x00, 0x16, 0x00, 0x16, 0x3e, 0x00, 0x00, 0x01,
+		0x81, 0x00, 0x00, 0x64, 0x86, 0xdd,
+	}
+	frame := append(ethernet, ipv6Packet(nextHeaderHopByHop, routerAlert, v2Report())...)
+
+	msg, pkt, err := ParseFrame(frame)
+	require.NoError(t, err)
+	assert.Equal(t, TypeReportV2, msg.Type)
+	assert.Len(t, msg.Records, 2)
+	assert.True(t, pkt.RouterAlertMLD)
+	assert.Equal(t, testSrc, pkt.Src)
+
+	_, _, err = ParseFrame(append(ethernet[:16:16], 0x08, 0x00))
+	assert.ErrorIs(t, err, ErrNotMLD)
+
+	udp := append(append([]byte{}, ethernet...), ipv6Packet(17, make([]byte, 8))...)
+	_, _, err = ParseFrame(udp)
+	assert.ErrorIs(t, err, ErrNotMLD)
+}
+
+func TestParseFrameSnaplen(t *testing.T) {
+	t.Parallel()
+
+	in := mldFrame(ipv6Packet(nextHeaderHopByHop, routerAlert, v2Report()))
+
+	// wherever a snaplen cuts the frame, it is truncated rather than
+	// inconsistent
+	for n := range len(in) {
+		_, _, err := ParseFrame(in[:n])
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "%d bytes", n)
+	}
+}
+
+func TestParseFrameChecksum(t *testing.T) {
+	t.Parallel()
+
+	msg := v2Report()
+
+	// the checksum covers the message, not the Hop-by-Hop header before it
+	plain := ipv6Packet(NextHeaderICMPv6, msg)
+	sum, err := checksum.Compute(plain[:ipv6HeaderLen], plain[ipv6HeaderLen:])
+	require.NoError(t, err)
+
+	good := append([]byte(nil), msg...)
+	good[2], good[3] = byte(sum>>8), byte(sum)
+
+	bad := append([]byte(nil), good...)
+	bad[3]++
+
+	strict := ethernet.ParserOptions{Strictness: ethernet.StrictnessStrict}
+
+	for _, s := range []ethernet.Strictness{ethernet.StrictnessLenient, ethernet.StrictnessStandard} {
+		_, _, err := ParseFrameWith(mldFrame(ipv6Packet(nextHeaderHopByHop, routerAlert, bad)),
+			ethernet.ParserOptions{Strictness: s})
+		assert.NoError(t, err, s)
+	}
+
+	_, _, err = ParseFrameWith(mldFrame(ipv6Packet(nextHeaderHopByHop, routerAlert, good)), strict)
+	assert.NoError(t, err)
+
+	_, _, err = ParseFrameWith(mldFrame(ipv6Packet(nextHeaderHopByHop, routerAlert, bad)), strict)
+	assert.ErrorIs(t, err, ErrMalformedPacket)
+	assert.ErrorIs(t, err, checksum.ErrMismatch)
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ndp decodes the IPv6 Neighbor Discovery messages hosts resolve
+// and defend their addresses with, RFC 4861, the solicitations and the
+// advertisements
+package ndp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/mld"
+)
+
+var (
+	// ErrMalformedMessage is returned when a Neighbor Discovery message is
+	// too short or inconsistent
+	ErrMalformedMessage = errors.New("malformed neighbor discovery message")
+	// ErrNotNDP is returned when parsing a frame which doesn't carry a
+	// neighbor solicitation or advertisement
+	ErrNotNDP = errors.New("not a neighbor discovery message")
+)
+
+// truncated returns an error matching ErrMalformedMessage and
+// io.ErrUnexpectedEOF for a message ending before what it declares, as mld
+// does for the IPv6 packets
+func truncated(what string) error {
+	return fmt.Errorf("%w: truncated %s: %w", ErrMalformedMessage, what, io.ErrUnexpectedEOF)
+}
+
+const (
+	ethernetHeaderLen = 14
+	ethertypeIPv6     = 0x86dd
+
+	messageLen = 24
+	// hopLimit is the hop limit of every valid Neighbor Discovery message,
+	// which proves it wasn't forwarded by a router
+	hopLimit = 255
+
+	optionSourceLinkLayerAddr = 1
+	optionTargetLinkLayerAddr = 2
+
+	flagRouter    = 0x80
+	flagSolicited = 0x40
+	flagOverride  = 0x20
+)
+
+// Type is the ICMPv6 type of a Neighbor Discovery message
+type Type uint8
+
+const (
+	// TypeNeighborSolicitation asks for the link-layer address of the
+	// target, or checks no other host uses it when sent from the
+	// unspecified address
+	TypeNeighborSolicitation Type = 135
+	// TypeNeighborAdvertisement announces the link-layer address of the
+	// target
+	TypeNeighborAdvertisement Type = 136
+)
+
+// String returns the name of the message type
+func (t Type) String() string {
+	switch t {
+	case TypeNeighborSolicitation:
+		return "neighbor solicitation"
+	case TypeNeighborAdvertisement:
+		return "neighbor advertisement"
+	}
+
+	return fmt.Sprintf("type %d", uint8(t))
+}
+
+// Message is a neighbor solicitation or advertisement
+type Message struct {
+	// Target is the address solicited or advertised
+	Target netip.Addr
+	// LinkLayerAddr is the source link-layer address option of a
+	// solicitation, or the target one of an advertisement, nil without
+	LinkLayerAddr net.HardwareAddr
+	Type          Type
+	// Router, Solicited and Override are the flags of an advertisement
+	Router    bool
+	Solicited bool
+	Override  bool
+}
+
+// UnmarshalBinary parses an ICMPv6 message into a Neighbor Discovery
+// message
+func (m *Message) UnmarshalBinary(buf []byte) error {
+	budget := ethernet.ParserOptions{}.Budget()
+
+	return m.unmarshal(buf, ethernet.DefaultOptionLimit, &budget)
+}
+
+// unmarshal is UnmarshalBinary reading up to limit options, spending budget
+func (m *Message) unmarshal(buf []byte, limit int, budget *ethernet.DecodeBudget) error {
+	if len(buf) < 4 {
+		return truncated("header")
+	}
+
+	*m = Message{Type: Type(buf[0])}
+
+	if m.Type != TypeNeighborSolicitation && m.Type != TypeNeighborAdvertisement {
+		return fmt.Errorf("%w: ICMPv6 type %d", ErrNotNDP, buf[0])
+	}
+
+	if len(buf) < messageLen {
+		return truncated("message")
+	}
+
+	if buf[1] != 0 {
+		return ErrMalformedMessage
+	}
+
+	if m.Type == TypeNeighborAdvertisement {
+		m.Router = buf[4]&flagRouter != 0
+		m.Solicited = buf[4]&flagSolicited != 0
+		m.Override = buf[4]&flagOverride != 0
+	}
+
+	m.Target = netip.AddrFrom16([16]byte(buf[8:24]))
+	if m.Target.IsMulticast() {
+		return fmt.Errorf("%w: multicast target %s", ErrMalformedMessage, m.Target)
+	}
+
+	want := optionSourceLinkLayerAddr
+	if m.Type == TypeNeighborAdvertisement {
+		want = optionTargetLinkLayerAddr
+	}
+
+	for n, options := 0, buf[messageLen:]; len(options) > 0; n++ {
+		if n == limit {
+			return ethernet.LimitExceeded("NDP", "options", ErrMalformedMessage, limit, "options")
+		}
+
+		if err := budget.Spend("NDP", ErrMalformedMessage); err != nil {
+			return err
+		}
+
+		if len(options) >= 2 && options[1] == 0 {
+			return fmt.Errorf("%w: option of length 0", ErrMalformedMessage)
+		}
+
+		if len(options) < 2 || len(options) < int(options[1])*8 {
+			return truncated("option")
+		}
+
+		length := int(options[1]) * 8
+
+		if int(options[0]) == want && length == 8 {
+			m.LinkLayerAddr = net.HardwareAddr(append([]byte{}, options[2:8]...))
+		}
+
+		options = options[length:]
+	}
+
+	return nil
+}
+
+// ParseFrame returns the Neighbor Discovery message of an ethernet frame,
+// after any VLAN tags and IPv6 extension headers, together with its IPv6
+// header. The messages which went through a router are rejected.
+func ParseFrame(frame []byte) (Message, mld.IPv6, error) {
+	return ParseFrameWith(frame, ethernet.ParserOptions{})
+}
+
+// ParseFrameWith is ParseFrame with the checksum of the message verified
+// as the options decide, see ethernet.ParserOptions.VerifyChecksums, and
+// within their limits, see ethernet.DecodeLimits
+func ParseFrameWith(frame []byte, opts ethernet.ParserOptions) (Message, mld.IPv6, error) {
+	var (
+		msg Message
+		pkt mld.IPv6
+	)
+
+	if len(frame) < ethernetHeaderLen {
+		return msg, pkt, truncated("ethernet header")
+	}
+
+	budget := opts.Budget()
+
+	off, ethertype, err := mld.SkipTags(frame, opts, &budget)
+	if err != nil {
+		return msg, pkt, err
+	}
+
+	if ethertype != ethertypeIPv6 {
+		return msg, pkt, ErrNotNDP
+	}
+
+	if err := pkt.UnmarshalWith(frame[off+2:], opts, &budget); err != nil {
+		return msg, pkt, err
+	}
+
+	if pkt.NextHeader != mld.NextHeaderICMPv6 || pkt.Fragment {
+		return msg, pkt, ErrNotNDP
+	}
+
+	if opts.VerifyChecksums() {
+		if err := pkt.VerifyChecksum(); err != nil {
+			return msg, pkt, err
+		}
+	}
+
+	if err := msg.unmarshal(pkt.Payload, opts.DecodeLimits().Options, &budget); err != nil {
+		return msg, pkt, err
+	}
+
+	if pkt.HopLimit != hopLimit {
+		return msg, pkt, fmt.Errorf("%w: hop limit %d", ErrMalformedMessage, pkt.HopLimit)
+	}
+
+	return msg, pkt, nil
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ndp
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/checksum"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/mld"
+)
+
+var (
+	testTarget = netip.MustParseAddr("2001:db8::10")
+	testMAC    = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+)
+
+func message(t Type, flags byte, target netip.Addr, options ...byte) []byte {
+	msg := []byte{byte(t), 0x00, 0x00, 0x00, flags, 0x00, 0x00, 0x00}
+	msg = append(msg, target.AsSlice()...)
+
+	return append(msg, options...)
+}
+
+func linkLayerOption(typ byte, mac net.HardwareAddr) []byte {
+	return append([]byte{typ, 0x01}, mac...)
+}
+
+// frame returns an ethernet frame carrying msg in an IPv6 packet from src
+// with the given hop limit
+func frame(src netip.Addr, hops byte, msg []byte) []byte {
+	f := []byte{
+		0x33, 0x33, 0xff, 0x00, 0x00, 0x10,
+		0x00, 0x16, 0x3e, 0x00, 0x00, 0x01,
+		0x86, 0xdd,
+	}
+
+	ip := make([]byte, 40)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(len(msg))) //nolint:gosec // test packets are small
+	ip[6] = mld.NextHeaderICMPv6
+	ip[7] = hops
+	copy(ip[8:24], src.AsSlice())
+	copy(ip[24:40], netip.MustParseAddr("ff02::1:ff00:10").AsSlice())
+
+	return append(append(f, ip...), msg...)
+}
+
+func TestMessageUnmarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		err error
+		in  []byte
+		out Message
+	}{
+		"DAD solicitation": {
+			in:  message(TypeNeighborSolicitation, 0, testTarget),
+			out: Message{Type: TypeNeighborSolicitation, Target: testTarget},
+		},
+		"solicitation with source address": {
+			in: message(TypeNeighborSolicitation, 0, testTarget, linkLayerOption(1, testMAC)...),
+			out: Message{
+				Type:          TypeNeighborSolicitation,
+				Target:        testTarget,
+				LinkLayerAddr: testMAC,
+			},
+		},
+		"advertisement": {
+			in: message(TypeNeighborAdvertisement, 0xa0, testTarget, linkLayerOption(2, testMAC)...),
+			out: Message{
+				Type:          TypeNeighborAdvertisement,
+				Target:        testTarget,
+				LinkLayerAddr: testMAC,
+				Router:        true,
+				Override:      true,
+			},
+		},
+		"advertisement ignores the source option": {
+			in:  message(TypeNeighborAdvertisement, 0x40, testTarget, linkLayerOption(1, testMAC)...),
+			out: Message{Type: TypeNeighborAdvertisement, Target: testTarget, Solicited: true},
+		},
+		"router solicitation": {
+			in:  []byte{133, 0, 0, 0, 0, 0, 0, 0},
+			err: ErrNotNDP,
+		},
+		"truncated": {
+			in:  message(TypeNeighborSolicitation, 0, testTarget)[:20],
+			err: ErrMalformedMessage,
+		},
+		"multicast target": {
+			in:  message(TypeNeighborSolicitation, 0, netip.MustParseAddr("ff02::1")),
+			err: ErrMalformedMessage,
+		},
+		"zero length option": {
+			in:  message(TypeNeighborSolicitation, 0, testTarget, 1, 0, 0, 0, 0, 0, 0, 0),
+			err: ErrMalformedMessage,
+		},
+		"truncated option": {
+			in:  message(TypeNeighborSolicitation, 0, testTarget, linkLayerOption(1, testMAC)[:6]...),
+			err: ErrMalformedMessage,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var msg Message
+
+			err := msg.UnmarshalBinary(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, msg)
+		})
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	t.Parallel()
+
+	ns := message(TypeNeighborSolicitation, 0, testTarget)
+
+	tagged := frame(netip.IPv6Unspecified(), 255, ns)
+	tagged = append(tagged[:12:12], append([]byte{0x81, 0x00, 0x00, 0x02}, tagged[12:]...)...)
+
+	testcases := map[string]struct {
+		err error
+		in  []byte
+	}{
+		"solicitation": {
+			in: frame(netip.IPv6Unspecified(), 255, ns),
+		},
+		"VLAN tagged": {
+			in: tagged,
+		},
+		"forwarded": {
+			in:  frame(netip.IPv6Unspecified(), 64, ns),
+			err: ErrMalformedMessage,
+		},
+		"IPv4": {
+			in:  []byte{12: 0x08, 13: 0x00, 33: 0},
+			err: ErrNotNDP,
+		},
+		"MLD": {
+			in:  frame(netip.MustParseAddr("fe80::1"), 1, []byte{143, 0, 0, 0, 0, 0, 0, 0}),
+			err: ErrNotNDP,
+		},
+		"truncated": {
+			in:  []byte{0x00},
+			err: ErrMalformedMessage,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, pkt, err := ParseFrame(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, netip.IPv6Unspecified(), pkt.Src)
+			assert.Equal(t, Message{Type: TypeNeighborSolicitation, Target: testTarget}, msg)
+		})
+	}
+}
+
+func TestParseFrameSnaplen(t *testing.T) {
+	t.Parallel()
+
+	in := frame(netip.IPv6Unspecified(), 255,
+		message(TypeNeighborSolicitation, 0, testTarget, linkLayerOption(1, testMAC)...))
+
+	// wherever a snaplen cuts the frame, it is truncated rather than
+	// inconsistent
+	for n := range len(in) {
+		_, _, err := ParseFrame(in[:n])
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "%d bytes", n)
+	}
+}
+
+func TestParseFrameChecksum(t *testing.T) {
+	t.Parallel()
+
+	good := frame(netip.IPv6Unspecified(), 255, message(TypeNeighborSolicitation, 0, testTarget))
+
+	sum, err := checksum.Compute(good[14:54], good[54:])
+	require.NoError(t, err)
+	binary.BigEndian.PutUint16(good[56:58], sum)
+
+	bad := append([]byte(nil), good...)
+	bad[57]++
+
+	testcases := map[ethernet.Strictness]error{
+		ethernet.StrictnessLenient:  nil,
+		ethernet.StrictnessStandard: nil,
+		ethernet.StrictnessStrict:   checksum.ErrMismatch,
+	}
+
+	for s, want := range testcases {
+		s, want := s, want
+
+		t.Run(s.String(), func(t *testing.T) {
+			t.Parallel()
+
+			opts := ethernet.ParserOptions{Strictness: s}
+
+			_, _, err := ParseFrameWith(good, opts)
+			require.NoError(t, err)
+
+			_, _, err = ParseFrameWith(bad, opts)
+			if want == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorIs(t, err, want)
+		})
+	}
+}
+
+func TestMessageOptionLimit(t *testing.T) {
+	t.Parallel()
+
+	// options returns n options of an unknown type, of 8 bytes each
+	options := func(n int) []byte {
+		var opts []byte
+		for range n {
+			opts = append(opts, 99, 0x01, 0, 0, 0, 0, 0, 0)
+		}
+
+		return opts
+	}
+
+	var msg Message
+
+	require.NoError(t, msg.UnmarshalBinary(message(TypeNeighborSolicitation, 0, testTarget,
+		options(ethernet.DefaultOptionLimit)...)))
+
+	err := msg.UnmarshalBinary(message(TypeNeighborSolicitation, 0, testTarget,
+		options(ethernet.DefaultOptionLimit+1)...))
+	require.ErrorIs(t, err, ethernet.ErrDecodeLimitExceeded)
+	require.ErrorIs(t, err, ErrMalformedMessage)
+
+	// the limits of the options hold in the frames
+	f := frame(netip.MustParseAddr("fe80::1"), 255,
+		message(TypeNeighborSolicitation, 0, testTarget, options(8)...))
+
+	_, _, err = ParseFrameWith(f, ethernet.ParserOptions{Limits: ethernet.DecodeLimits{Options: 8}})
+	require.NoError(t, err)
+
+	_, _, err = ParseFrameWith(f, ethernet.ParserOptions{Limits: ethernet.DecodeLimits{Options: 7}})
+	assert.ErrorIs(t, err, ethernet.ErrDecodeLimitExceeded)
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ethFlagRxVLAN is ETH_FLAG_RXVLAN from linux/ethtool.h, it is set when
+	// the NIC strips 802.1Q tags on receive (rx-vlan-offload)
+	ethFlagRxVLAN = 1 << 8
+)
+
+// OffloadState is the state of a NIC offload feature
+type OffloadState uint8
+
+const (
+	// OffloadUnknown is used when the driver doesn't support the
+	// ethtool request
+	OffloadUnknown OffloadState = iota
+	// OffloadOff means the feature is disabled
+	OffloadOff
+	// OffloadOn means the feature is enabled
+	OffloadOn
+)
+
+var offloadStateNames = map[OffloadState]string{
+	OffloadUnknown: "unknown",
+	OffloadOff:     "off",
+	OffloadOn:      "on",
+}
+
+// String returns the string version of the OffloadState
+func (s OffloadState) String() string {
+	if name, ok := offloadStateNames[s]; ok {
+		return name
+	}
+
+	return offloadStateNames[OffloadUnknown]
+}
+
+// MarshalText implements encoding.TextMarshaler for OffloadState
+func (s OffloadState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ethtoolValue is struct ethtool_value from linux/ethtool.h
+type ethtoolValue struct {
+	cmd  uint32
+	data uint32
+}
+
+// ifreqData mirrors the kernel's struct ifreq when the union carries a
+// pointer, as required by SIOCETHTOOL
+//
+//nolint:govet // the layout must match struct ifreq
+type ifreqData struct {
+	name [unix.IFNAMSIZ]byte
+	data unsafe.Pointer
+	_    [24 - unsafe.Sizeof(uintptr(0))]byte
+}
+
+// isUnsupported returns true for errors drivers use to say they
+// don't implement an ethtool request
+func isUnsupported(err error) bool {
+	return errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.ENODEV)
+}
+
+// ethtoolFlags issues ETHTOOL_GFLAGS for the given interface
+func ethtoolFlags(name string) (uint32, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed opening ethtool socket: %w", err)
+	}
+
+	defer unix.Close(fd) //nolint:errcheck // ignoring close error on query socket
+
+	value := ethtoolValue{cmd: unix.ETHTOOL_GFLAGS}
+	ifr := ifreqData{data: unsafe.Pointer(&value)}
+
+	if len(name) >= unix.IFNAMSIZ {
+		return 0, fmt.Errorf("interface name %q too long: %w", name, unix.EINVAL)
+	}
+
+	copy(ifr.name[:], name)
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL,
+		uintptr(unsafe.Pointer(&ifr)))
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return value.data, nil
+}
+
+// vlanOffload returns the rx-vlan-offload state of the interface
+func vlanOffload(name string) (OffloadState, error) {
+	flags, err := ethtoolFlags(name)
+	if err != nil {
+		if isUnsupported(err) {
+			return OffloadUnknown, nil
+		}
+
+		return OffloadUnknown, err
+	}
+
+	if flags&ethFlagRxVLAN != 0 {
+		return OffloadOn, nil
+	}
+
+	return OffloadOff, nil
+}
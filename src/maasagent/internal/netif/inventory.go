@@ -0,0 +1,277 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// netlinkGroups are the rtnetlink multicast groups that signal
+	// a change of the inventory
+	netlinkGroups = unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR
+	// netlinkReadSize is large enough for any single notification burst
+	netlinkReadSize = 1 << 16
+)
+
+// Inventory keeps a list of the network interfaces of the host, built from
+// rtnetlink dumps and kept up to date with rtnetlink notifications
+type Inventory struct {
+	subscribers map[chan struct{}]struct{}
+	dump        func() ([]Link, error)
+	// ready is closed once the inventory is first populated
+	ready     chan struct{}
+	links     []Link
+	readyOnce sync.Once
+	mu        sync.RWMutex
+}
+
+// NewInventory returns an empty Inventory, Refresh or Run must be called to
+// populate it
+func NewInventory() *Inventory {
+	return &Inventory{
+		subscribers: make(map[chan struct{}]struct{}),
+		dump:        dumpInventory,
+		ready:       make(chan struct{}),
+	}
+}
+
+// dumpInventory returns every link with its addresses attached
+func dumpInventory() ([]Link, error) {
+	links, err := dumpLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := dumpAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	byIndex := make(map[int]int, len(links))
+	for i, l := range links {
+		byIndex[l.Index] = i
+	}
+
+	for _, a := range addrs {
+		if i, ok := byIndex[a.index]; ok {
+			links[i].Addrs = append(links[i].Addrs, a.prefix)
+		}
+	}
+
+	return links, nil
+}
+
+// Refresh replaces the content of the inventory with a fresh dump and
+// notifies subscribers
+func (inv *Inventory) Refresh() error {
+	links, err := inv.dump()
+	if err != nil {
+		return err
+	}
+
+	inv.set(links)
+
+	return nil
+}
+
+func (inv *Inventory) set(links []Link) {
+	slices.SortFunc(links, func(a, b Link) int { return a.Index - b.Index })
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	inv.links = links
+
+	inv.readyOnce.Do(func() { close(inv.ready) })
+
+	for ch := range inv.subscribers {
+		// notifications are coalesced, subscribers only need to know
+		// that they should look at the inventory again
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Ready returns a channel closed once the inventory is populated, the
+// components looking up links can be started then
+func (inv *Inventory) Ready() <-chan struct{} {
+	return inv.ready
+}
+
+// Links returns a copy of every link, ordered by index
+func (inv *Inventory) Links() []Link {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+
+	return slices.Clone(inv.links)
+}
+
+// LinkByName returns the link with the given name
+func (inv *Inventory) LinkByName(name string) (Link, bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+
+	return findLink(inv.links, func(l Link) bool { return l.Name == name })
+}
+
+// LinkByIndex returns the link with the given index
+func (inv *Inventory) LinkByIndex(index int) (Link, bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+
+	return findLink(inv.links, func(l Link) bool { return l.Index == index })
+}
+
+// LinkAddrs returns the MAC and the addresses of the link with the given
+// name, or of its VLAN sub-interface vid unless 0. It implements
+// capture.LinkAddrSource.
+func (inv *Inventory) LinkAddrs(name string, vid uint16) (net.HardwareAddr, []netip.Prefix, bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+
+	link, ok := inv.subInterface(name, vid)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return slices.Clone(link.HardwareAddr), slices.Clone(link.Addrs), true
+}
+
+// SourceFor returns the address the probes of target are sent from on the
+// link with the given name, or on its VLAN sub-interface vid unless 0, see
+// SelectSource. A link-local IPv6 address is zoned to the link.
+func (inv *Inventory) SourceFor(name string, vid uint16, target netip.Prefix,
+	options ...SourceOption) (netip.Addr, error) {
+	inv.mu.RLock()
+	link, ok := inv.subInterface(name, vid)
+	inv.mu.RUnlock()
+
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("%w: %s VLAN %d", ErrLinkNotFound, name, vid)
+	}
+
+	src, err := SelectSource(link.Addrs, target, options...)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%s: %w", link.Name, err)
+	}
+
+	if src.Is6() && src.IsLinkLocalUnicast() && src.Zone() == "" {
+		src = src.WithZone(link.Name)
+	}
+
+	return src, nil
+}
+
+// subInterface returns the link with the given name, or its VLAN
+// sub-interface vid unless 0. inv.mu must be held.
+func (inv *Inventory) subInterface(name string, vid uint16) (Link, bool) {
+	link, ok := findLink(inv.links, func(l Link) bool { return l.Name == name })
+	if ok && vid != 0 {
+		link, ok = findLink(inv.links, func(l Link) bool {
+			return l.VLAN() && l.ParentIndex == link.Index && l.VID == vid
+		})
+	}
+
+	return link, ok
+}
+
+// Select returns the links matching the selector
+func (inv *Inventory) Select(s *Selector) []Link {
+	return s.Select(inv.Links())
+}
+
+// Subscribe returns a channel receiving a value every time the inventory
+// changes and a function to cancel the subscription. Notifications are
+// coalesced, so a slow subscriber only sees the latest state.
+func (inv *Inventory) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	inv.mu.Lock()
+	inv.subscribers[ch] = struct{}{}
+	inv.mu.Unlock()
+
+	return ch, func() {
+		inv.mu.Lock()
+		delete(inv.subscribers, ch)
+		inv.mu.Unlock()
+	}
+}
+
+// Run populates the inventory and keeps it up to date with rtnetlink
+// notifications until the context is cancelled
+func (inv *Inventory) Run(ctx context.Context) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK,
+		unix.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed opening rtnetlink socket: %w", err)
+	}
+
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: netlinkGroups}); err != nil {
+		unix.Close(fd) //nolint:errcheck // already returning the bind error
+
+		return fmt.Errorf("failed to subscribe to rtnetlink groups: %w", err)
+	}
+
+	// a non-blocking fd wrapped in os.File is handled by the runtime poller,
+	// so a read deadline unblocks a pending Read
+	f := os.NewFile(uintptr(fd), "rtnetlink")
+
+	defer f.Close() //nolint:errcheck // nothing is read from f anymore
+
+	stop := context.AfterFunc(ctx, func() {
+		f.SetReadDeadline(time.Unix(1, 0)) //nolint:errcheck,gosec // interrupting the read loop
+	})
+	defer stop()
+
+	// subscribe before the initial dump, so no change can be missed
+	if err = inv.Refresh(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, netlinkReadSize)
+
+	for {
+		_, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			// ENOBUFS means notifications were lost, a full dump recovers
+			if !errors.Is(err, unix.ENOBUFS) {
+				return fmt.Errorf("failed reading rtnetlink notifications: %w", err)
+			}
+		}
+
+		if err := inv.Refresh(); err != nil {
+			log.Error().Err(err).Msg("Failed to refresh interface inventory")
+		}
+	}
+}
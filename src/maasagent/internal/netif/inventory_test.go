@@ -0,0 +1,145 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+func TestInventoryLookup(t *testing.T) {
+	t.Parallel()
+
+	inv := NewInventory()
+	inv.dump = func() ([]Link, error) {
+		links := testLinks()
+		// dumps are not guaranteed to be ordered
+		links[0], links[6] = links[6], links[0]
+
+		return links, nil
+	}
+
+	require.NoError(t, inv.Refresh())
+
+	links := inv.Links()
+	require.Len(t, links, 7)
+	assert.Equal(t, 1, links[0].Index)
+	assert.Equal(t, 7, links[6].Index)
+
+	l, ok := inv.LinkByName("eth0.100")
+	assert.True(t, ok)
+	assert.Equal(t, uint16(100), l.VID)
+
+	l, ok = inv.LinkByIndex(5)
+	assert.True(t, ok)
+	assert.Equal(t, "br0", l.Name)
+
+	_, ok = inv.LinkByName("eth9")
+	assert.False(t, ok)
+
+	mac, _, ok := inv.LinkAddrs("eth0", 200)
+	assert.True(t, ok)
+	assert.Equal(t, links[6].HardwareAddr, mac)
+
+	_, _, ok = inv.LinkAddrs("eth0", 300)
+	assert.False(t, ok)
+
+	_, _, ok = inv.LinkAddrs("eth1", 100)
+	assert.False(t, ok)
+}
+
+func TestInventorySubscribe(t *testing.T) {
+	t.Parallel()
+
+	inv := NewInventory()
+	inv.dump = func() ([]Link, error) { return testLinks(), nil }
+
+	ch, cancel := inv.Subscribe()
+
+	// several refreshes are coalesced into a single notification
+	require.NoError(t, inv.Refresh())
+	require.NoError(t, inv.Refresh())
+
+	select {
+	case <-ch:
+	default:
+		t.Fatal("expected a notification")
+	}
+
+	select {
+	case <-ch:
+		t.Fatal("expected notifications to be coalesced")
+	default:
+	}
+
+	cancel()
+
+	require.NoError(t, inv.Refresh())
+
+	select {
+	case <-ch:
+		t.Fatal("unexpected notification after unsubscribing")
+	default:
+	}
+}
+
+func TestInventoryRun(t *testing.T) {
+	if _, err := dumpInventory(); err != nil {
+		t.Skipf("rtnetlink is not available: %v", err)
+	}
+
+	defer leak.Check(t)()
+
+	inv := NewInventory()
+	ch, cancel := inv.Subscribe()
+
+	defer cancel()
+
+	ctx, stop := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+
+	go func() { errC <- inv.Run(ctx) }()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("inventory was not populated")
+	}
+
+	_, ok := findLink(inv.Links(), func(l Link) bool { return l.Loopback() })
+	assert.True(t, ok)
+
+	select {
+	case <-inv.Ready():
+	default:
+		t.Fatal("populated inventory is not ready")
+	}
+
+	stop()
+
+	select {
+	case err := <-errC:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+}
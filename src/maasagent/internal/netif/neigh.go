@@ -0,0 +1,309 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
+)
+
+const sizeofNdMsg = 12
+
+// NeighState is the NUD_* state of a neighbor cache entry, a bit mask
+// although the kernel sets a single state at a time
+type NeighState uint16
+
+// The states are those of the kernel, see NUD_* in linux/neighbour.h
+const (
+	NeighIncomplete NeighState = unix.NUD_INCOMPLETE
+	NeighReachable  NeighState = unix.NUD_REACHABLE
+	NeighStale      NeighState = unix.NUD_STALE
+	NeighDelay      NeighState = unix.NUD_DELAY
+	NeighProbe      NeighState = unix.NUD_PROBE
+	NeighFailed     NeighState = unix.NUD_FAILED
+	NeighNoARP      NeighState = unix.NUD_NOARP
+	NeighPermanent  NeighState = unix.NUD_PERMANENT
+)
+
+var neighStateNames = []struct {
+	name  string
+	state NeighState
+}{
+	{"incomplete", NeighIncomplete},
+	{"reachable", NeighReachable},
+	{"stale", NeighStale},
+	{"delay", NeighDelay},
+	{"probe", NeighProbe},
+	{"failed", NeighFailed},
+	{"noarp", NeighNoARP},
+	{"permanent", NeighPermanent},
+}
+
+// String returns the names ip-neigh(8) uses for the states
+func (s NeighState) String() string {
+	var names []string
+
+	for _, n := range neighStateNames {
+		if s&n.state != 0 {
+			names = append(names, n.name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Resolved returns true if the entry has a usable link layer address
+func (s NeighState) Resolved() bool {
+	return s&(NeighReachable|NeighStale|NeighDelay|NeighProbe|NeighPermanent) != 0
+}
+
+// Neighbor is an ARP or NDP entry of the kernel neighbor cache as described
+// by an RTM_NEWNEIGH message
+type Neighbor struct {
+	// IP is the address of the neighbor
+	IP netip.Addr
+	// HardwareAddr is the link layer address the IP resolved to, it is empty
+	// for entries that are incomplete or failed
+	HardwareAddr net.HardwareAddr
+	// Index is the index of the interface the entry belongs to
+	Index int
+	// State is the NUD_* state of the entry
+	State NeighState
+	// Flags are the NTF_* flags of the entry
+	Flags uint8
+}
+
+// parseNeighMessage parses the payload of an RTM_NEWNEIGH message
+func parseNeighMessage(data []byte) (Neighbor, error) {
+	var n Neighbor
+
+	if len(data) < sizeofNdMsg {
+		return n, fmt.Errorf("%w: short ndmsg", ErrMalformedMessage)
+	}
+
+	n.Index = int(int32(hostorder.Uint32(data[4:8]))) //nolint:gosec // ifindex is a signed int in the kernel
+	n.State = NeighState(hostorder.Uint16(data[8:10]))
+	n.Flags = data[10]
+
+	attrs, err := parseAttrs(data[sizeofNdMsg:])
+	if err != nil {
+		return n, err
+	}
+
+	for _, a := range attrs {
+		switch a.Type {
+		case unix.NDA_DST:
+			ip, ok := netip.AddrFromSlice(a.Value)
+			if !ok {
+				return n, fmt.Errorf("%w: invalid destination attribute", ErrMalformedMessage)
+			}
+
+			n.IP = ip.Unmap()
+		case unix.NDA_LLADDR:
+			n.HardwareAddr = append(net.HardwareAddr(nil), a.Value...)
+		}
+	}
+
+	if !n.IP.IsValid() {
+		return n, fmt.Errorf("%w: neighbor without destination", ErrMalformedMessage)
+	}
+
+	return n, nil
+}
+
+// parseNeighMessages parses a netlink RTM_GETNEIGH dump
+func parseNeighMessages(rib []byte) ([]Neighbor, error) {
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	neighbors := make([]Neighbor, 0, len(msgs))
+
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWNEIGH {
+			continue
+		}
+
+		n, err := parseNeighMessage(m.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		neighbors = append(neighbors, n)
+	}
+
+	return neighbors, nil
+}
+
+// DumpNeighbors returns the ARP and NDP entries of the kernel neighbor cache
+func DumpNeighbors() ([]Neighbor, error) {
+	rib, err := syscall.NetlinkRIB(unix.RTM_GETNEIGH, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump neighbors: %w", err)
+	}
+
+	return parseNeighMessages(rib)
+}
+
+// ErrInvalidNeighbor is returned when setting or deleting a Neighbor without
+// an address or an interface
+var ErrInvalidNeighbor = errors.New("invalid neighbor")
+
+// appendAttr appends the netlink attribute typ of value to msg, aligned
+func appendAttr(msg []byte, typ uint16, value []byte) []byte {
+	l := sizeofRtAttr + len(value)
+
+	msg = hostorder.AppendUint16(msg, uint16(l)) //nolint:gosec // the attributes are a few bytes
+	msg = hostorder.AppendUint16(msg, typ)
+	msg = append(msg, value...)
+
+	return append(msg, make([]byte, (l+unix.NLA_ALIGNTO-1)&^(unix.NLA_ALIGNTO-1)-l)...)
+}
+
+// neighRequest returns the netlink message typ, RTM_NEWNEIGH or
+// RTM_DELNEIGH, of the entry n
+func neighRequest(typ, flags uint16, n Neighbor) []byte {
+	msg := make([]byte, unix.NLMSG_HDRLEN+sizeofNdMsg)
+	ndmsg := msg[unix.NLMSG_HDRLEN:]
+
+	ndmsg[0] = unix.AF_INET6
+	if n.IP.Is4() {
+		ndmsg[0] = unix.AF_INET
+	}
+
+	hostorder.PutUint32(ndmsg[4:8], uint32(n.Index)) //nolint:gosec // ifindex is a signed int in the kernel
+	hostorder.PutUint16(ndmsg[8:10], uint16(n.State))
+	ndmsg[10] = n.Flags
+
+	msg = appendAttr(msg, unix.NDA_DST, n.IP.AsSlice())
+	if len(n.HardwareAddr) > 0 {
+		msg = appendAttr(msg, unix.NDA_LLADDR, n.HardwareAddr)
+	}
+
+	hostorder.PutUint32(msg[0:4], uint32(len(msg))) //nolint:gosec // a message is a few bytes
+	hostorder.PutUint16(msg[4:6], typ)
+	hostorder.PutUint16(msg[6:8], flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	hostorder.PutUint32(msg[8:12], 1)
+
+	return msg
+}
+
+// parseAck returns the error the kernel acknowledged a request with, nil if
+// it succeeded. ok is false until the acknowledgement is in msgs.
+func parseAck(msgs []syscall.NetlinkMessage) (ok bool, err error) {
+	for _, m := range msgs {
+		if m.Header.Type != unix.NLMSG_ERROR {
+			continue
+		}
+
+		if len(m.Data) < 4 {
+			return true, fmt.Errorf("%w: short acknowledgement", ErrMalformedMessage)
+		}
+
+		if errno := int32(hostorder.Uint32(m.Data[0:4])); errno != 0 { //nolint:gosec // the errno is signed
+			return true, syscall.Errno(-errno)
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// request sends the netlink request msg and waits for its acknowledgement
+func request(msg []byte) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = unix.Close(fd) }()
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, unix.Getpagesize())
+
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+		}
+
+		if ok, err := parseAck(msgs); ok {
+			return err
+		}
+	}
+}
+
+func validNeighbor(n Neighbor) error {
+	switch {
+	case !n.IP.IsValid():
+		return fmt.Errorf("%w: missing address", ErrInvalidNeighbor)
+	case n.Index <= 0:
+		return fmt.Errorf("%w: missing interface", ErrInvalidNeighbor)
+	default:
+		return nil
+	}
+}
+
+// SetNeighbor installs n into the kernel neighbor cache, replacing the entry
+// of its IP on its interface. It needs CAP_NET_ADMIN, its lack is an error
+// matching os.ErrPermission.
+func SetNeighbor(n Neighbor) error {
+	if err := validNeighbor(n); err != nil {
+		return err
+	}
+
+	if err := request(neighRequest(unix.RTM_NEWNEIGH, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, n)); err != nil {
+		return fmt.Errorf("failed to set neighbor %s: %w", n.IP, err)
+	}
+
+	return nil
+}
+
+// DeleteNeighbor removes the entry of the IP of n on its interface from the
+// kernel neighbor cache
+func DeleteNeighbor(n Neighbor) error {
+	if err := validNeighbor(n); err != nil {
+		return err
+	}
+
+	if err := request(neighRequest(unix.RTM_DELNEIGH, 0, Neighbor{IP: n.IP, Index: n.Index})); err != nil {
+		return fmt.Errorf("failed to delete neighbor %s: %w", n.IP, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
+)
+
+func ndmsg(family uint8, index int32, state uint16, attrs ...[]byte) []byte {
+	buf := make([]byte, sizeofNdMsg)
+	buf[0] = family
+	hostorder.PutUint32(buf[4:8], uint32(index))
+	hostorder.PutUint16(buf[8:10], state)
+
+	for _, a := range attrs {
+		buf = append(buf, a...)
+	}
+
+	return buf
+}
+
+func TestParseNeighMessage(t *testing.T) {
+	t.Parallel()
+
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	testcases := map[string]struct {
+		in  []byte
+		out Neighbor
+		err error
+	}{
+		"reachable IPv4": {
+			in: ndmsg(unix.AF_INET, 2, unix.NUD_REACHABLE,
+				rtattr(unix.NDA_DST, []byte{192, 0, 2, 1}),
+				rtattr(unix.NDA_LLADDR, mac),
+			),
+			out: Neighbor{
+				IP:           netip.MustParseAddr("192.0.2.1"),
+				HardwareAddr: mac,
+				Index:        2,
+				State:        unix.NUD_REACHABLE,
+			},
+		},
+		"stale IPv6": {
+			in: ndmsg(unix.AF_INET6, 3, unix.NUD_STALE,
+				rtattr(unix.NDA_DST, netip.MustParseAddr("fe80::1").AsSlice()),
+				rtattr(unix.NDA_LLADDR, mac),
+			),
+			out: Neighbor{
+				IP:           netip.MustParseAddr("fe80::1"),
+				HardwareAddr: mac,
+				Index:        3,
+				State:        unix.NUD_STALE,
+			},
+		},
+		"failed without address": {
+			in: ndmsg(unix.AF_INET, 2, unix.NUD_FAILED,
+				rtattr(unix.NDA_DST, []byte{192, 0, 2, 2}),
+			),
+			out: Neighbor{
+				IP:    netip.MustParseAddr("192.0.2.2"),
+				Index: 2,
+				State: unix.NUD_FAILED,
+			},
+		},
+		"short header": {
+			in:  make([]byte, sizeofNdMsg-1),
+			err: ErrMalformedMessage,
+		},
+		"no destination": {
+			in:  ndmsg(unix.AF_INET, 2, unix.NUD_REACHABLE, rtattr(unix.NDA_LLADDR, mac)),
+			err: ErrMalformedMessage,
+		},
+		"invalid destination": {
+			in:  ndmsg(unix.AF_INET, 2, unix.NUD_REACHABLE, rtattr(unix.NDA_DST, []byte{192, 0, 2})),
+			err: ErrMalformedMessage,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := parseNeighMessage(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+
+			if err == nil {
+				assert.Equal(t, tc.out, res)
+			}
+		})
+	}
+}
+
+func TestNeighState(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in       NeighState
+		out      string
+		resolved bool
+	}{
+		"reachable": {in: unix.NUD_REACHABLE, out: "reachable", resolved: true},
+		"stale":     {in: unix.NUD_STALE, out: "stale", resolved: true},
+		"permanent": {in: unix.NUD_PERMANENT, out: "permanent", resolved: true},
+		"failed":    {in: unix.NUD_FAILED, out: "failed"},
+		"several":   {in: unix.NUD_INCOMPLETE | unix.NUD_NOARP, out: "incomplete,noarp"},
+		"none":      {out: "none"},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.in.String())
+			assert.Equal(t, tc.resolved, tc.in.Resolved())
+		})
+	}
+}
+
+func TestDumpNeighbors(t *testing.T) {
+	t.Parallel()
+
+	neighbors, err := DumpNeighbors()
+	if err != nil {
+		t.Skipf("rtnetlink is not available: %v", err)
+	}
+
+	for _, n := range neighbors {
+		assert.True(t, n.IP.IsValid())
+		assert.NotZero(t, n.Index)
+	}
+}
+
+func TestNeighRequest(t *testing.T) {
+	t.Parallel()
+
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	testcases := map[string]struct {
+		typ uint16
+		in  Neighbor
+		out Neighbor
+	}{
+		"set IPv4": {
+			typ: unix.RTM_NEWNEIGH,
+			in: Neighbor{IP: netip.MustParseAddr("192.0.2.1"), HardwareAddr: mac, Index: 2,
+				State: NeighReachable},
+		},
+		"set IPv6": {
+			typ: unix.RTM_NEWNEIGH,
+			in:  Neighbor{IP: netip.MustParseAddr("2001:db8::1"), HardwareAddr: mac, Index: 3, State: NeighStale},
+		},
+		"delete": {
+			typ: unix.RTM_DELNEIGH,
+			in:  Neighbor{IP: netip.MustParseAddr("192.0.2.1"), Index: 2},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			msgs, err := syscall.ParseNetlinkMessage(neighRequest(tc.typ, unix.NLM_F_CREATE, tc.in))
+			require.NoError(t, err)
+			require.Len(t, msgs, 1)
+
+			assert.Equal(t, tc.typ, msgs[0].Header.Type)
+			assert.Equal(t, uint16(unix.NLM_F_REQUEST|unix.NLM_F_ACK|unix.NLM_F_CREATE), msgs[0].Header.Flags)
+
+			family := uint8(unix.AF_INET6)
+			if tc.in.IP.Is4() {
+				family = unix.AF_INET
+			}
+
+			assert.Equal(t, family, msgs[0].Data[0])
+
+			n, err := parseNeighMessage(msgs[0].Data)
+			require.NoError(t, err)
+			assert.Equal(t, tc.in, n)
+		})
+	}
+}
+
+func TestParseAck(t *testing.T) {
+	t.Parallel()
+
+	nlmsgerr := func(errno int32) syscall.NetlinkMessage {
+		return syscall.NetlinkMessage{
+			Header: syscall.NlMsghdr{Type: unix.NLMSG_ERROR},
+			Data:   hostorder.AppendUint32(nil, uint32(errno)),
+		}
+	}
+
+	ok, err := parseAck([]syscall.NetlinkMessage{{Header: syscall.NlMsghdr{Type: unix.RTM_NEWNEIGH}}})
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	ok, err = parseAck([]syscall.NetlinkMessage{nlmsgerr(0)})
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	ok, err = parseAck([]syscall.NetlinkMessage{nlmsgerr(-int32(unix.EPERM))})
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	_, err = parseAck([]syscall.NetlinkMessage{{Header: syscall.NlMsghdr{Type: unix.NLMSG_ERROR}}})
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+}
+
+func TestSetNeighborInvalid(t *testing.T) {
+	t.Parallel()
+
+	assert.ErrorIs(t, SetNeighbor(Neighbor{Index: 1}), ErrInvalidNeighbor)
+	assert.ErrorIs(t, DeleteNeighbor(Neighbor{IP: netip.MustParseAddr("192.0.2.1")}), ErrInvalidNeighbor)
+}
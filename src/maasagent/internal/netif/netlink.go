@@ -0,0 +1,415 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
+)
+
+const (
+	sizeofIfInfomsg = 16
+	sizeofIfAddrmsg = 8
+	sizeofRtAttr    = 4
+)
+
+var (
+	// ErrMalformedMessage is returned when a netlink message can't be parsed
+	ErrMalformedMessage = errors.New("malformed netlink message")
+	// ErrLinkNotFound is returned when the requested interface doesn't exist
+	ErrLinkNotFound = errors.New("link not found")
+)
+
+// OperState is the RFC 2863 operational state of a link as reported by
+// the kernel in IFLA_OPERSTATE
+type OperState uint8
+
+const (
+	OperStateUnknown OperState = iota
+	OperStateNotPresent
+	OperStateDown
+	OperStateLowerLayerDown
+	OperStateTesting
+	OperStateDormant
+	OperStateUp
+)
+
+var operStateNames = map[OperState]string{
+	OperStateUnknown:        "unknown",
+	OperStateNotPresent:     "notpresent",
+	OperStateDown:           "down",
+	OperStateLowerLayerDown: "lowerlayerdown",
+	OperStateTesting:        "testing",
+	OperStateDormant:        "dormant",
+	OperStateUp:             "up",
+}
+
+// String returns the name the kernel uses for the operational state
+func (s OperState) String() string {
+	if name, ok := operStateNames[s]; ok {
+		return name
+	}
+
+	return operStateNames[OperStateUnknown]
+}
+
+// Link is a network interface as described by an RTM_NEWLINK message
+type Link struct {
+	// Name is the interface name
+	Name string
+	// Kind is the IFLA_INFO_KIND of the link (e.g. bridge, bond, vlan),
+	// it is empty for physical interfaces
+	Kind string
+	// HardwareAddr is the link layer address of the interface
+	HardwareAddr net.HardwareAddr
+	// Addrs are the addresses configured on the link
+	Addrs []netip.Prefix
+	// Index is the interface index
+	Index int
+	// MTU is the maximum transmission unit of the link
+	MTU int
+	// MasterIndex is the index of the bridge or bond the link is enslaved
+	// to or 0 if there is none
+	MasterIndex int
+	// ParentIndex is the index of the parent link of a VLAN sub-interface
+	// (IFLA_LINK) or 0 if there is none
+	ParentIndex int
+	// Flags are the IFF_* flags of the link
+	Flags uint32
+	// VID is the VLAN ID of a VLAN sub-interface
+	VID uint16
+	// OperState is the operational state of the link
+	OperState OperState
+	// Carrier is true when the link reports a carrier
+	Carrier bool
+}
+
+// Up returns true if the link is administratively up
+func (l Link) Up() bool {
+	return l.Flags&unix.IFF_UP != 0
+}
+
+// Promiscuous returns true if the link is in promiscuous mode
+func (l Link) Promiscuous() bool {
+	return l.Flags&unix.IFF_PROMISC != 0
+}
+
+// Loopback returns true if the link is a loopback interface
+func (l Link) Loopback() bool {
+	return l.Flags&unix.IFF_LOOPBACK != 0
+}
+
+// Physical returns true if the link looks like a physical NIC, which
+// the kernel reports without a link kind
+func (l Link) Physical() bool {
+	return l.Kind == "" && !l.Loopback() && len(l.HardwareAddr) == 6
+}
+
+// VLAN returns true if the link is a VLAN sub-interface
+func (l Link) VLAN() bool {
+	return l.Kind == "vlan"
+}
+
+// attr is a single netlink attribute
+type attr struct {
+	Value []byte
+	Type  uint16
+}
+
+// parseAttrs walks a buffer of netlink attributes. It is used for both
+// top level and nested attributes, which share the same encoding.
+func parseAttrs(buf []byte) ([]attr, error) {
+	var attrs []attr
+
+	for len(buf) >= sizeofRtAttr {
+		l := int(hostorder.Uint16(buf[0:2]))
+		t := hostorder.Uint16(buf[2:4])
+
+		if l < sizeofRtAttr || l > len(buf) {
+			return nil, fmt.Errorf("%w: attribute length %d", ErrMalformedMessage, l)
+		}
+
+		attrs = append(attrs, attr{
+			// NLA_F_NESTED and NLA_F_NET_BYTEORDER are not part of the type
+			Type:  t &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER),
+			Value: buf[sizeofRtAttr:l],
+		})
+
+		aligned := (l + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+		if aligned > len(buf) {
+			break
+		}
+
+		buf = buf[aligned:]
+	}
+
+	return attrs, nil
+}
+
+// attrString returns a NUL terminated string attribute value
+func attrString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+
+	return string(b)
+}
+
+func attrUint32(b []byte) (uint32, error) {
+	if len(b) < 4 {
+		return 0, fmt.Errorf("%w: short uint32 attribute", ErrMalformedMessage)
+	}
+
+	return hostorder.Uint32(b), nil
+}
+
+// parseLinkMessage parses the payload of an RTM_NEWLINK message
+func parseLinkMessage(data []byte) (Link, error) {
+	var l Link
+
+	if len(data) < sizeofIfInfomsg {
+		return l, fmt.Errorf("%w: short ifinfomsg", ErrMalformedMessage)
+	}
+
+	l.Index = int(int32(hostorder.Uint32(data[4:8]))) //nolint:gosec // ifindex is a signed int in the kernel
+	l.Flags = hostorder.Uint32(data[8:12])
+
+	attrs, err := parseAttrs(data[sizeofIfInfomsg:])
+	if err != nil {
+		return l, err
+	}
+
+	for _, a := range attrs {
+		switch a.Type {
+		case unix.IFLA_IFNAME:
+			l.Name = attrString(a.Value)
+		case unix.IFLA_ADDRESS:
+			l.HardwareAddr = append(net.HardwareAddr(nil), a.Value...)
+		case unix.IFLA_MTU:
+			v, err := attrUint32(a.Value)
+			if err != nil {
+				return l, err
+			}
+
+			l.MTU = int(v)
+		case unix.IFLA_MASTER:
+			v, err := attrUint32(a.Value)
+			if err != nil {
+				return l, err
+			}
+
+			l.MasterIndex = int(v)
+		case unix.IFLA_LINK:
+			v, err := attrUint32(a.Value)
+			if err != nil {
+				return l, err
+			}
+
+			l.ParentIndex = int(v)
+		case unix.IFLA_OPERSTATE:
+			if len(a.Value) > 0 {
+				l.OperState = OperState(a.Value[0])
+			}
+		case unix.IFLA_CARRIER:
+			l.Carrier = len(a.Value) > 0 && a.Value[0] != 0
+		case unix.IFLA_LINKINFO:
+			if err := parseLinkInfo(&l, a.Value); err != nil {
+				return l, err
+			}
+		}
+	}
+
+	return l, nil
+}
+
+// parseLinkInfo parses the nested IFLA_LINKINFO attribute, which carries
+// the link kind and, for VLAN sub-interfaces, the VLAN ID
+func parseLinkInfo(l *Link, buf []byte) error {
+	nested, err := parseAttrs(buf)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+
+	for _, n := range nested {
+		switch n.Type {
+		case unix.IFLA_INFO_KIND:
+			l.Kind = attrString(n.Value)
+		case unix.IFLA_INFO_DATA:
+			data = n.Value
+		}
+	}
+
+	// IFLA_INFO_DATA is kind specific, only VLAN data is of interest
+	if l.Kind != "vlan" || data == nil {
+		return nil
+	}
+
+	vlan, err := parseAttrs(data)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vlan {
+		if v.Type != unix.IFLA_VLAN_ID {
+			continue
+		}
+
+		if len(v.Value) < 2 {
+			return fmt.Errorf("%w: short VLAN ID attribute", ErrMalformedMessage)
+		}
+
+		l.VID = hostorder.Uint16(v.Value)
+	}
+
+	return nil
+}
+
+// addrMessage is an address as described by an RTM_NEWADDR message
+type addrMessage struct {
+	prefix netip.Prefix
+	index  int
+}
+
+// parseAddrMessage parses the payload of an RTM_NEWADDR message
+func parseAddrMessage(data []byte) (addrMessage, error) {
+	var m addrMessage
+
+	if len(data) < sizeofIfAddrmsg {
+		return m, fmt.Errorf("%w: short ifaddrmsg", ErrMalformedMessage)
+	}
+
+	prefixLen := int(data[1])
+	m.index = int(hostorder.Uint32(data[4:8]))
+
+	attrs, err := parseAttrs(data[sizeofIfAddrmsg:])
+	if err != nil {
+		return m, err
+	}
+
+	var address, local []byte
+
+	for _, a := range attrs {
+		switch a.Type {
+		case unix.IFA_ADDRESS:
+			address = a.Value
+		case unix.IFA_LOCAL:
+			local = a.Value
+		}
+	}
+
+	// on point-to-point links IFA_ADDRESS is the peer address,
+	// IFA_LOCAL is the address configured on the interface
+	raw := address
+	if local != nil {
+		raw = local
+	}
+
+	ip, ok := netip.AddrFromSlice(raw)
+	if !ok {
+		return m, fmt.Errorf("%w: invalid address attribute", ErrMalformedMessage)
+	}
+
+	m.prefix, err = ip.Prefix(prefixLen)
+	if err != nil {
+		return m, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	// Prefix() masks the address, but we want to keep the host bits
+	m.prefix = netip.PrefixFrom(ip, m.prefix.Bits())
+
+	return m, nil
+}
+
+// parseAddrMessages parses a netlink RTM_GETADDR dump
+func parseAddrMessages(rib []byte) ([]addrMessage, error) {
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	addrs := make([]addrMessage, 0, len(msgs))
+
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWADDR {
+			continue
+		}
+
+		a, err := parseAddrMessage(m.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs = append(addrs, a)
+	}
+
+	return addrs, nil
+}
+
+// dumpAddrs returns every address known to the kernel
+func dumpAddrs() ([]addrMessage, error) {
+	rib, err := syscall.NetlinkRIB(unix.RTM_GETADDR, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump addresses: %w", err)
+	}
+
+	return parseAddrMessages(rib)
+}
+
+// parseLinkMessages parses a netlink RTM_GETLINK dump
+func parseLinkMessages(rib []byte) ([]Link, error) {
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	links := make([]Link, 0, len(msgs))
+
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWLINK {
+			continue
+		}
+
+		l, err := parseLinkMessage(m.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, l)
+	}
+
+	return links, nil
+}
+
+// dumpLinks returns every link known to the kernel
+func dumpLinks() ([]Link, error) {
+	rib, err := syscall.NetlinkRIB(unix.RTM_GETLINK, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump links: %w", err)
+	}
+
+	return parseLinkMessages(rib)
+}
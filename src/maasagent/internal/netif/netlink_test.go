@@ -0,0 +1,228 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/hostorder"
+)
+
+func rtattr(t uint16, value []byte) []byte {
+	l := sizeofRtAttr + len(value)
+	buf := make([]byte, (l+unix.NLA_ALIGNTO-1)&^(unix.NLA_ALIGNTO-1))
+	hostorder.PutUint16(buf[0:2], uint16(l))
+	hostorder.PutUint16(buf[2:4], t)
+	copy(buf[sizeofRtAttr:], value)
+
+	return buf
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	hostorder.PutUint32(b, v)
+
+	return b
+}
+
+func ifinfomsg(index int32, flags uint32, attrs ...[]byte) []byte {
+	buf := make([]byte, sizeofIfInfomsg)
+	hostorder.PutUint32(buf[4:8], uint32(index))
+	hostorder.PutUint32(buf[8:12], flags)
+
+	for _, a := range attrs {
+		buf = append(buf, a...)
+	}
+
+	return buf
+}
+
+func TestParseLinkMessage(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []byte
+		out Link
+		err error
+	}{
+		"bridge member": {
+			in: ifinfomsg(3, unix.IFF_UP|unix.IFF_PROMISC,
+				rtattr(unix.IFLA_IFNAME, []byte("eth0\x00")),
+				rtattr(unix.IFLA_ADDRESS, []byte{0x00, 0x16, 0x3e, 0x01, 0x02, 0x03}),
+				rtattr(unix.IFLA_MTU, u32(9000)),
+				rtattr(unix.IFLA_MASTER, u32(7)),
+				rtattr(unix.IFLA_OPERSTATE, []byte{byte(OperStateUp)}),
+				rtattr(unix.IFLA_CARRIER, []byte{1}),
+			),
+			out: Link{
+				Name:         "eth0",
+				Index:        3,
+				HardwareAddr: net.HardwareAddr{0x00, 0x16, 0x3e, 0x01, 0x02, 0x03},
+				MTU:          9000,
+				MasterIndex:  7,
+				Flags:        unix.IFF_UP | unix.IFF_PROMISC,
+				OperState:    OperStateUp,
+				Carrier:      true,
+			},
+		},
+		"bridge with nested link info": {
+			in: ifinfomsg(7, unix.IFF_UP,
+				rtattr(unix.IFLA_IFNAME, []byte("br0\x00")),
+				rtattr(unix.IFLA_LINKINFO|unix.NLA_F_NESTED,
+					rtattr(unix.IFLA_INFO_KIND, []byte("bridge\x00"))),
+			),
+			out: Link{
+				Name:  "br0",
+				Index: 7,
+				Kind:  "bridge",
+				Flags: unix.IFF_UP,
+			},
+		},
+		"VLAN sub-interface": {
+			in: ifinfomsg(8, unix.IFF_UP,
+				rtattr(unix.IFLA_IFNAME, []byte("eth0.100\x00")),
+				rtattr(unix.IFLA_LINK, u32(3)),
+				rtattr(unix.IFLA_LINKINFO|unix.NLA_F_NESTED, append(
+					rtattr(unix.IFLA_INFO_KIND, []byte("vlan\x00")),
+					rtattr(unix.IFLA_INFO_DATA|unix.NLA_F_NESTED,
+						rtattr(unix.IFLA_VLAN_ID, []byte{100, 0}))...)),
+			),
+			out: Link{
+				Name:        "eth0.100",
+				Index:       8,
+				Kind:        "vlan",
+				ParentIndex: 3,
+				VID:         hostorder.Uint16([]byte{100, 0}),
+				Flags:       unix.IFF_UP,
+			},
+		},
+		"short header": {
+			in:  []byte{0x00, 0x01},
+			err: ErrMalformedMessage,
+		},
+		"attribute longer than buffer": {
+			in:  ifinfomsg(1, 0, []byte{0xff, 0x00, 0x03, 0x00}),
+			err: ErrMalformedMessage,
+		},
+		"short MTU attribute": {
+			in:  ifinfomsg(1, 0, rtattr(unix.IFLA_MTU, []byte{0x01})),
+			err: ErrMalformedMessage,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := parseLinkMessage(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+
+			if err == nil {
+				assert.Equal(t, tc.out, res)
+			}
+		})
+	}
+}
+
+func ifaddrmsg(family, prefixLen uint8, index uint32, attrs ...[]byte) []byte {
+	buf := make([]byte, sizeofIfAddrmsg)
+	buf[0] = family
+	buf[1] = prefixLen
+	hostorder.PutUint32(buf[4:8], index)
+
+	for _, a := range attrs {
+		buf = append(buf, a...)
+	}
+
+	return buf
+}
+
+func TestParseAddrMessage(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []byte
+		out addrMessage
+		err error
+	}{
+		"IPv4 address": {
+			in: ifaddrmsg(unix.AF_INET, 24, 2,
+				rtattr(unix.IFA_ADDRESS, []byte{10, 0, 0, 5}),
+				rtattr(unix.IFA_LOCAL, []byte{10, 0, 0, 5}),
+			),
+			out: addrMessage{index: 2, prefix: netip.MustParsePrefix("10.0.0.5/24")},
+		},
+		"point-to-point peer": {
+			in: ifaddrmsg(unix.AF_INET, 32, 2,
+				rtattr(unix.IFA_ADDRESS, []byte{10, 0, 0, 1}),
+				rtattr(unix.IFA_LOCAL, []byte{10, 0, 0, 2}),
+			),
+			out: addrMessage{index: 2, prefix: netip.MustParsePrefix("10.0.0.2/32")},
+		},
+		"IPv6 address": {
+			in: ifaddrmsg(unix.AF_INET6, 64, 3,
+				rtattr(unix.IFA_ADDRESS, netip.MustParseAddr("fe80::1").AsSlice()),
+			),
+			out: addrMessage{index: 3, prefix: netip.MustParsePrefix("fe80::1/64")},
+		},
+		"invalid prefix length": {
+			in: ifaddrmsg(unix.AF_INET, 33, 2,
+				rtattr(unix.IFA_ADDRESS, []byte{10, 0, 0, 5}),
+			),
+			err: ErrMalformedMessage,
+		},
+		"missing address": {
+			in:  ifaddrmsg(unix.AF_INET, 24, 2),
+			err: ErrMalformedMessage,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := parseAddrMessage(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+
+			if err == nil {
+				assert.Equal(t, tc.out, res)
+			}
+		})
+	}
+}
+
+func TestDumpLinks(t *testing.T) {
+	t.Parallel()
+
+	links, err := dumpLinks()
+	if err != nil {
+		t.Skipf("rtnetlink is not available: %v", err)
+	}
+
+	lo, ok := findLink(links, func(l Link) bool { return l.Loopback() })
+	assert.True(t, ok)
+	assert.NotZero(t, lo.Index)
+	assert.NotEmpty(t, lo.Name)
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/capture"
+)
+
+const (
+	defaultExpectedMTU = 1500
+)
+
+// WarningCode identifies the kind of problem found by Preflight
+type WarningCode string
+
+const (
+	// WarningVLANOffload is raised when the NIC strips VLAN tags on receive,
+	// tags then have to be recovered from PACKET_AUXDATA
+	WarningVLANOffload WarningCode = "vlan_offload"
+	// WarningOffloadUnknown is raised when the driver doesn't answer ethtool
+	// requests, so the VLAN offload state can't be determined
+	WarningOffloadUnknown WarningCode = "offload_unknown"
+	// WarningDown is raised when the interface is administratively down
+	WarningDown WarningCode = "down"
+	// WarningNoCarrier is raised when the interface has no carrier
+	WarningNoCarrier WarningCode = "no_carrier"
+	// WarningSmallMTU is raised when the MTU is smaller than expected
+	WarningSmallMTU WarningCode = "small_mtu"
+	// WarningEnslaved is raised when the interface is a bridge or bond member
+	WarningEnslaved WarningCode = "enslaved"
+	// WarningPartialVisibility is raised when the capture is to receive
+	// fewer frames than in promiscuous mode, the message tells which
+	WarningPartialVisibility WarningCode = "partial_visibility"
+)
+
+// lostVisibility tells what a capture doesn't receive in each membership
+// narrower than promiscuous mode
+var lostVisibility = map[capture.Membership]string{
+	capture.MembershipUnicast: "only the frames sent to the host, the broadcasts and the multicast " +
+		"groups of the host are received",
+	capture.MembershipGroups: "the unicast frames between other hosts and the multicast groups not " +
+		"joined, such as the solicited-node groups of other hosts, are not received",
+	capture.MembershipAllMulticast: "the unicast frames between other hosts are not received",
+}
+
+// Warning is a problem found by Preflight that might affect capture
+type Warning struct {
+	Code    WarningCode `json:"code"`
+	Message string      `json:"message"`
+}
+
+// Report is the result of Preflight
+type Report struct {
+	Name        string       `json:"name"`
+	Master      string       `json:"master,omitempty"`
+	MasterKind  string       `json:"master_kind,omitempty"`
+	Warnings    []Warning    `json:"warnings,omitempty"`
+	Index       int          `json:"index"`
+	MTU         int          `json:"mtu"`
+	VLANOffload OffloadState `json:"vlan_offload"`
+	Up          bool         `json:"up"`
+	Carrier     bool         `json:"carrier"`
+	Promiscuous bool         `json:"promiscuous"`
+	// AllMulticast is true when the link receives every multicast frame
+	AllMulticast bool `json:"all_multicast"`
+	// AuxdataVLAN is true when VLAN tags should be recovered from
+	// PACKET_AUXDATA instead of being read from the frame
+	AuxdataVLAN bool `json:"auxdata_vlan"`
+	// Membership is the mode the capture receives frames in, the one given
+	// with WithMembership or else the widest the flags of the link grant
+	Membership capture.Membership `json:"membership"`
+}
+
+// HasWarning returns true if the report contains a warning with the given code
+func (r *Report) HasWarning(code WarningCode) bool {
+	for _, w := range r.Warnings {
+		if w.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+type preflightConfig struct {
+	expectedMTU   int
+	membership    capture.Membership
+	hasMembership bool
+}
+
+// PreflightOption allows to change the checks done by Preflight
+type PreflightOption func(*preflightConfig)
+
+// WithExpectedMTU sets the MTU below which a warning is raised
+func WithExpectedMTU(mtu int) PreflightOption {
+	return func(c *preflightConfig) {
+		if mtu == 0 {
+			return
+		}
+
+		c.expectedMTU = mtu
+	}
+}
+
+// WithMembership reports the mode the capture is to run in, with a warning
+// telling what it doesn't receive unless promiscuous
+func WithMembership(m capture.Membership) PreflightOption {
+	return func(c *preflightConfig) {
+		c.membership = m
+		c.hasMembership = true
+	}
+}
+
+// Preflight inspects the interface configuration before starting capture
+// and reports settings known to affect what can be observed. Interfaces
+// whose driver doesn't support ethtool are reported with an unknown offload
+// state rather than an error.
+func Preflight(name string, options ...PreflightOption) (*Report, error) {
+	cfg := preflightConfig{expectedMTU: defaultExpectedMTU}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	links, err := dumpLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	link, ok := findLink(links, func(l Link) bool { return l.Name == name })
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrLinkNotFound, name)
+	}
+
+	var master *Link
+
+	if link.MasterIndex != 0 {
+		if m, ok := findLink(links, func(l Link) bool { return l.Index == link.MasterIndex }); ok {
+			master = &m
+		}
+	}
+
+	offload, err := vlanOffload(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query VLAN offload of %s: %w", name, err)
+	}
+
+	return newReport(link, master, offload, cfg), nil
+}
+
+func findLink(links []Link, match func(Link) bool) (Link, bool) {
+	for _, l := range links {
+		if match(l) {
+			return l, true
+		}
+	}
+
+	return Link{}, false
+}
+
+func newReport(link Link, master *Link, offload OffloadState, cfg preflightConfig) *Report {
+	r := &Report{
+		Name:        link.Name,
+		Index:       link.Index,
+		MTU:         link.MTU,
+		VLANOffload: offload,
+		Up:          link.Up(),
+		Carrier:     link.Carrier,
+		Promiscuous: link.Promiscuous(),
+		// IFF_ALLMULTI is set while any socket asks for all multicast
+		AllMulticast: link.Flags&unix.IFF_ALLMULTI != 0,
+		Membership:   cfg.membership,
+	}
+
+	if !cfg.hasMembership {
+		switch {
+		case r.Promiscuous:
+			r.Membership = capture.MembershipPromiscuous
+		case r.AllMulticast:
+			r.Membership = capture.MembershipAllMulticast
+		default:
+			r.Membership = capture.MembershipUnicast
+		}
+	} else if lost, ok := lostVisibility[cfg.membership]; ok {
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    WarningPartialVisibility,
+			Message: "capture runs in " + cfg.membership.String() + " mode, " + lost,
+		})
+	}
+
+	switch offload {
+	case OffloadOn:
+		r.AuxdataVLAN = true
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    WarningVLANOffload,
+			Message: "rx-vlan-offload is enabled, VLAN tags will be recovered from PACKET_AUXDATA",
+		})
+	case OffloadUnknown:
+		// without knowing better it is safer to look at auxdata too,
+		// it is simply absent when the kernel didn't strip a tag
+		r.AuxdataVLAN = true
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    WarningOffloadUnknown,
+			Message: "driver does not report VLAN offload state",
+		})
+	case OffloadOff:
+	}
+
+	if !r.Up {
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    WarningDown,
+			Message: "interface is administratively down",
+		})
+	}
+
+	// loopback never reports a carrier
+	if r.Up && !link.Carrier && !link.Loopback() {
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    WarningNoCarrier,
+			Message: "interface has no carrier",
+		})
+	}
+
+	if link.MTU < cfg.expectedMTU {
+		r.Warnings = append(r.Warnings, Warning{
+			Code: WarningSmallMTU,
+			Message: "MTU " + strconv.Itoa(link.MTU) + " is smaller than expected " +
+				strconv.Itoa(cfg.expectedMTU),
+		})
+	}
+
+	if master != nil {
+		r.Master = master.Name
+		r.MasterKind = master.Kind
+		r.Warnings = append(r.Warnings, Warning{
+			Code: WarningEnslaved,
+			Message: "interface is enslaved to " + kindOrLink(master.Kind) + " " + master.Name +
+				", traffic might only be visible on the master",
+		})
+	}
+
+	return r
+}
+
+func kindOrLink(kind string) string {
+	if kind == "" {
+		return "link"
+	}
+
+	return kind
+}
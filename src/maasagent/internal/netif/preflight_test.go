@@ -0,0 +1,165 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/capture"
+)
+
+func TestNewReport(t *testing.T) {
+	t.Parallel()
+
+	up := uint32(unix.IFF_UP)
+
+	testcases := map[string]struct {
+		link       Link
+		master     *Link
+		options    []PreflightOption
+		offload    OffloadState
+		warnings   []WarningCode
+		membership capture.Membership
+		auxdata    bool
+	}{
+		"healthy interface": {
+			link:    Link{Name: "eth0", MTU: 1500, Flags: up, Carrier: true},
+			offload: OffloadOff,
+		},
+		"VLAN offload enabled": {
+			link:     Link{Name: "eth0", MTU: 1500, Flags: up, Carrier: true},
+			offload:  OffloadOn,
+			warnings: []WarningCode{WarningVLANOffload},
+			auxdata:  true,
+		},
+		"ethtool not supported": {
+			link:     Link{Name: "eth0", MTU: 1500, Flags: up, Carrier: true},
+			offload:  OffloadUnknown,
+			warnings: []WarningCode{WarningOffloadUnknown},
+			auxdata:  true,
+		},
+		"bridge without carrier": {
+			link:     Link{Name: "br0", MTU: 1500, Flags: up, Kind: "bridge"},
+			offload:  OffloadOff,
+			warnings: []WarningCode{WarningNoCarrier},
+		},
+		"down interface": {
+			link:     Link{Name: "eth0", MTU: 1500},
+			offload:  OffloadOff,
+			warnings: []WarningCode{WarningDown},
+		},
+		"small MTU": {
+			link:     Link{Name: "eth0", MTU: 1280, Flags: up, Carrier: true},
+			offload:  OffloadOff,
+			warnings: []WarningCode{WarningSmallMTU},
+		},
+		"bond member": {
+			link:     Link{Name: "eth0", MTU: 1500, Flags: up, Carrier: true, MasterIndex: 4},
+			master:   &Link{Name: "bond0", Index: 4, Kind: "bond"},
+			offload:  OffloadOff,
+			warnings: []WarningCode{WarningEnslaved},
+		},
+		"loopback without carrier": {
+			link:    Link{Name: "lo", MTU: 65536, Flags: up | unix.IFF_LOOPBACK},
+			offload: OffloadOff,
+		},
+		"promiscuous link": {
+			link:       Link{Name: "eth0", MTU: 1500, Flags: up | unix.IFF_PROMISC | unix.IFF_ALLMULTI, Carrier: true},
+			offload:    OffloadOff,
+			membership: capture.MembershipPromiscuous,
+		},
+		"all multicast link": {
+			link:       Link{Name: "eth0", MTU: 1500, Flags: up | unix.IFF_ALLMULTI, Carrier: true},
+			offload:    OffloadOff,
+			membership: capture.MembershipAllMulticast,
+		},
+		"capture joining multicast groups": {
+			link:       Link{Name: "eth0", MTU: 1500, Flags: up, Carrier: true},
+			options:    []PreflightOption{WithMembership(capture.MembershipGroups)},
+			offload:    OffloadOff,
+			warnings:   []WarningCode{WarningPartialVisibility},
+			membership: capture.MembershipGroups,
+		},
+		"promiscuous capture": {
+			link:       Link{Name: "eth0", MTU: 1500, Flags: up, Carrier: true},
+			options:    []PreflightOption{WithMembership(capture.MembershipPromiscuous)},
+			offload:    OffloadOff,
+			membership: capture.MembershipPromiscuous,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := preflightConfig{expectedMTU: defaultExpectedMTU}
+			for _, opt := range tc.options {
+				opt(&cfg)
+			}
+
+			r := newReport(tc.link, tc.master, tc.offload, cfg)
+
+			codes := make([]WarningCode, 0, len(r.Warnings))
+			for _, w := range r.Warnings {
+				codes = append(codes, w.Code)
+			}
+
+			assert.ElementsMatch(t, tc.warnings, codes)
+			assert.Equal(t, tc.auxdata, r.AuxdataVLAN)
+			assert.Equal(t, tc.membership, r.Membership)
+
+			if tc.master != nil {
+				assert.Equal(t, tc.master.Name, r.Master)
+				assert.Equal(t, tc.master.Kind, r.MasterKind)
+			}
+		})
+	}
+}
+
+func TestPreflightLoopback(t *testing.T) {
+	t.Parallel()
+
+	links, err := dumpLinks()
+	if err != nil {
+		t.Skipf("rtnetlink is not available: %v", err)
+	}
+
+	lo, ok := findLink(links, func(l Link) bool { return l.Loopback() })
+	if !ok {
+		t.Skip("no loopback interface")
+	}
+
+	r, err := Preflight(lo.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, lo.Index, r.Index)
+	assert.False(t, r.HasWarning(WarningSmallMTU))
+}
+
+func TestPreflightMissingInterface(t *testing.T) {
+	t.Parallel()
+
+	if _, err := dumpLinks(); err != nil {
+		t.Skipf("rtnetlink is not available: %v", err)
+	}
+
+	_, err := Preflight("doesnotexist0")
+	assert.ErrorIs(t, err, ErrLinkNotFound)
+}
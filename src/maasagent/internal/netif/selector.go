@@ -0,0 +1,238 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidSelector is returned when a selector expression can't be parsed
+	ErrInvalidSelector = errors.New("invalid interface selector")
+)
+
+// predicate matches a link, the full list of links is needed to resolve
+// references to other links by name
+type predicate func(l Link, byIndex map[int]Link) bool
+
+var keywords = map[string]predicate{
+	"all":      func(Link, map[int]Link) bool { return true },
+	"up":       func(l Link, _ map[int]Link) bool { return l.Up() },
+	"down":     func(l Link, _ map[int]Link) bool { return !l.Up() },
+	"carrier":  func(l Link, _ map[int]Link) bool { return l.Carrier },
+	"physical": func(l Link, _ map[int]Link) bool { return l.Physical() },
+	"virtual":  func(l Link, _ map[int]Link) bool { return !l.Physical() && !l.Loopback() },
+	"loopback": func(l Link, _ map[int]Link) bool { return l.Loopback() },
+	"vlan":     func(l Link, _ map[int]Link) bool { return l.VLAN() },
+	"bridge":   func(l Link, _ map[int]Link) bool { return l.Kind == "bridge" },
+	"bond":     func(l Link, _ map[int]Link) bool { return l.Kind == "bond" },
+	"enslaved": func(l Link, _ map[int]Link) bool { return l.MasterIndex != 0 },
+}
+
+// Selector selects a set of links from an inventory
+type Selector struct {
+	expr     string
+	require  []predicate
+	names    []predicate
+	excludes []predicate
+}
+
+// ParseSelector parses an interface selection expression. The expression
+// is a list of terms separated by spaces or commas:
+//
+//   - keywords: all, up, down, carrier, physical, virtual, loopback, vlan,
+//     bridge, bond, enslaved
+//   - master=NAME for links enslaved to NAME, parent=NAME for VLAN
+//     sub-interfaces of NAME and vid=N for VLAN sub-interfaces with ID N
+//   - anything else is an interface name, shell patterns such as eth* are
+//     allowed
+//
+// A link is selected when it matches every keyword and key=value term and,
+// if any name is given, at least one name. Terms prefixed with ! or
+// following the "except" keyword exclude the links they match, so
+// "physical up except lo master=br0" selects every physical interface that
+// is up and not enslaved to br0.
+func ParseSelector(expr string) (*Selector, error) {
+	s := &Selector{expr: expr}
+
+	fields := strings.FieldsFunc(expr, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: empty expression", ErrInvalidSelector)
+	}
+
+	var except bool
+
+	for _, f := range fields {
+		if f == "except" {
+			if except {
+				return nil, fmt.Errorf("%w: repeated except", ErrInvalidSelector)
+			}
+
+			except = true
+
+			continue
+		}
+
+		negated := except
+
+		if strings.HasPrefix(f, "!") {
+			negated = true
+			f = f[1:]
+		}
+
+		p, isName, err := parseTerm(f)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case negated:
+			s.excludes = append(s.excludes, p)
+		case isName:
+			s.names = append(s.names, p)
+		default:
+			s.require = append(s.require, p)
+		}
+	}
+
+	if len(s.require) == 0 && len(s.names) == 0 {
+		// an expression that only excludes starts from every link
+		s.require = append(s.require, keywords["all"])
+	}
+
+	return s, nil
+}
+
+// parseTerm returns the predicate for a term and whether it is a name
+func parseTerm(term string) (predicate, bool, error) {
+	if term == "" {
+		return nil, false, fmt.Errorf("%w: empty term", ErrInvalidSelector)
+	}
+
+	if p, ok := keywords[term]; ok {
+		return p, false, nil
+	}
+
+	if key, value, ok := strings.Cut(term, "="); ok {
+		p, err := parseKeyValue(key, value)
+
+		return p, false, err
+	}
+
+	if _, err := path.Match(term, ""); err != nil {
+		return nil, false, fmt.Errorf("%w: bad pattern %q", ErrInvalidSelector, term)
+	}
+
+	return func(l Link, _ map[int]Link) bool {
+		ok, _ := path.Match(term, l.Name) //nolint:errcheck // pattern validated above
+
+		return ok
+	}, true, nil
+}
+
+func parseKeyValue(key, value string) (predicate, error) {
+	if value == "" {
+		return nil, fmt.Errorf("%w: missing value for %s", ErrInvalidSelector, key)
+	}
+
+	switch key {
+	case "master":
+		return func(l Link, byIndex map[int]Link) bool {
+			m, ok := byIndex[l.MasterIndex]
+
+			return ok && l.MasterIndex != 0 && m.Name == value
+		}, nil
+	case "parent":
+		return func(l Link, byIndex map[int]Link) bool {
+			p, ok := byIndex[l.ParentIndex]
+
+			return ok && l.VLAN() && p.Name == value
+		}, nil
+	case "vid":
+		vid, err := strconv.ParseUint(value, 10, 12)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad VLAN ID %q", ErrInvalidSelector, value)
+		}
+
+		return func(l Link, _ map[int]Link) bool {
+			return l.VLAN() && uint64(l.VID) == vid
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidSelector, key)
+	}
+}
+
+// String returns the expression the selector was parsed from
+func (s *Selector) String() string {
+	return s.expr
+}
+
+// Select returns the subset of links matched by the selector, in the
+// order they were given
+func (s *Selector) Select(links []Link) []Link {
+	byIndex := make(map[int]Link, len(links))
+	for _, l := range links {
+		byIndex[l.Index] = l
+	}
+
+	var res []Link
+
+	for _, l := range links {
+		if s.match(l, byIndex) {
+			res = append(res, l)
+		}
+	}
+
+	return res
+}
+
+func (s *Selector) match(l Link, byIndex map[int]Link) bool {
+	for _, p := range s.require {
+		if !p(l, byIndex) {
+			return false
+		}
+	}
+
+	if len(s.names) > 0 {
+		var named bool
+
+		for _, p := range s.names {
+			if p(l, byIndex) {
+				named = true
+				break
+			}
+		}
+
+		if !named {
+			return false
+		}
+	}
+
+	for _, p := range s.excludes {
+		if p(l, byIndex) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func testLinks() []Link {
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	up := uint32(unix.IFF_UP)
+
+	return []Link{
+		{Index: 1, Name: "lo", Flags: up | unix.IFF_LOOPBACK},
+		{Index: 2, Name: "eth0", Flags: up, HardwareAddr: mac, Carrier: true},
+		{Index: 3, Name: "eth1", Flags: up, HardwareAddr: mac, MasterIndex: 5},
+		{Index: 4, Name: "eth2", HardwareAddr: mac},
+		{Index: 5, Name: "br0", Flags: up, Kind: "bridge", HardwareAddr: mac},
+		{Index: 6, Name: "eth0.100", Flags: up, Kind: "vlan", ParentIndex: 2, VID: 100, HardwareAddr: mac},
+		{Index: 7, Name: "eth0.200", Flags: up, Kind: "vlan", ParentIndex: 2, VID: 200, HardwareAddr: mac},
+	}
+}
+
+func TestSelector(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		expr string
+		out  []string
+		err  error
+	}{
+		"all": {
+			expr: "all",
+			out:  []string{"lo", "eth0", "eth1", "eth2", "br0", "eth0.100", "eth0.200"},
+		},
+		"physical up except lo and bridge members": {
+			expr: "physical up except lo master=br0",
+			out:  []string{"eth0"},
+		},
+		"negation": {
+			expr: "up,!loopback,!vlan",
+			out:  []string{"eth0", "eth1", "br0"},
+		},
+		"names are alternatives": {
+			expr: "eth0 br0",
+			out:  []string{"eth0", "br0"},
+		},
+		"pattern": {
+			expr: "eth* !vlan",
+			out:  []string{"eth0", "eth1", "eth2"},
+		},
+		"VLANs of a parent": {
+			expr: "parent=eth0",
+			out:  []string{"eth0.100", "eth0.200"},
+		},
+		"VLAN by ID": {
+			expr: "vid=200",
+			out:  []string{"eth0.200"},
+		},
+		"exclusion only": {
+			expr: "except lo",
+			out:  []string{"eth0", "eth1", "eth2", "br0", "eth0.100", "eth0.200"},
+		},
+		"nothing matches": {
+			expr: "bond",
+		},
+		"empty": {
+			expr: " , ",
+			err:  ErrInvalidSelector,
+		},
+		"unknown key": {
+			expr: "speed=10G",
+			err:  ErrInvalidSelector,
+		},
+		"bad VLAN ID": {
+			expr: "vid=4096",
+			err:  ErrInvalidSelector,
+		},
+		"bad pattern": {
+			expr: "eth[",
+			err:  ErrInvalidSelector,
+		},
+		"repeated except": {
+			expr: "all except lo except eth0",
+			err:  ErrInvalidSelector,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := ParseSelector(tc.expr)
+			assert.ErrorIs(t, err, tc.err)
+
+			if err != nil {
+				return
+			}
+
+			var names []string
+			for _, l := range s.Select(testLinks()) {
+				names = append(names, l.Name)
+			}
+
+			assert.Equal(t, tc.out, names)
+		})
+	}
+}
+
+func TestInventorySelect(t *testing.T) {
+	t.Parallel()
+
+	inv := NewInventory()
+	inv.dump = func() ([]Link, error) { return testLinks(), nil }
+
+	require.NoError(t, inv.Refresh())
+
+	s, err := ParseSelector("physical up except master=br0")
+	require.NoError(t, err)
+
+	links := inv.Select(s)
+	require.Len(t, links, 1)
+	assert.Equal(t, "eth0", links[0].Name)
+}
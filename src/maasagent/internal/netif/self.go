@@ -0,0 +1,92 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// SelfMACs is the set of MAC addresses of the host interfaces, it tells
+// the frames the host sent apart from the received ones even when they are
+// reflected back to the capture with an inbound direction
+type SelfMACs struct {
+	inv  *Inventory
+	macs map[[6]byte]struct{}
+	mu   sync.RWMutex
+}
+
+// NewSelfMACs returns the SelfMACs of the links of inv, Run keeps them up to
+// date with the inventory
+func NewSelfMACs(inv *Inventory) *SelfMACs {
+	r := &SelfMACs{inv: inv}
+	r.Update(inv.Links())
+
+	return r
+}
+
+// Update replaces the addresses with the ones of links
+func (r *SelfMACs) Update(links []Link) {
+	macs := make(map[[6]byte]struct{}, len(links))
+
+	for _, l := range links {
+		// loopback and tunnel interfaces have no or shorter addresses
+		if len(l.HardwareAddr) != 6 || l.Loopback() {
+			continue
+		}
+
+		macs[[6]byte(l.HardwareAddr)] = struct{}{}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.macs = macs
+}
+
+// Contains returns true when mac belongs to one of the host interfaces
+func (r *SelfMACs) Contains(mac net.HardwareAddr) bool {
+	if len(mac) != 6 {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.macs[[6]byte(mac)]
+
+	return ok
+}
+
+// Run updates the addresses every time the inventory changes, until ctx is
+// done
+func (r *SelfMACs) Run(ctx context.Context) error {
+	ch, cancel := r.inv.Subscribe()
+	defer cancel()
+
+	// the inventory may have changed before the subscription
+	r.Update(r.inv.Links())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ch:
+			r.Update(r.inv.Links())
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSelfMACs(t *testing.T) {
+	t.Parallel()
+
+	inv := NewInventory()
+	inv.dump = func() ([]Link, error) { return testLinks(), nil }
+
+	require.NoError(t, inv.Refresh())
+
+	r := NewSelfMACs(inv)
+
+	assert.True(t, r.Contains(net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}))
+	assert.False(t, r.Contains(net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}))
+	assert.False(t, r.Contains(net.HardwareAddr{0x00, 0x16}))
+
+	// the loopback address is all zeros, which is never a source
+	r.Update([]Link{{Index: 1, Name: "lo", Flags: unix.IFF_LOOPBACK, HardwareAddr: make(net.HardwareAddr, 6)}})
+	assert.False(t, r.Contains(make(net.HardwareAddr, 6)))
+	assert.False(t, r.Contains(net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}))
+}
+
+func TestSelfMACsRun(t *testing.T) {
+	t.Parallel()
+
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+
+	inv := NewInventory()
+	inv.dump = func() ([]Link, error) { return testLinks(), nil }
+
+	r := NewSelfMACs(inv)
+	assert.False(t, r.Contains(mac))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() { done <- r.Run(ctx) }()
+
+	inv.dump = func() ([]Link, error) {
+		return append(testLinks(), Link{Index: 8, Name: "eth3", HardwareAddr: mac}), nil
+	}
+
+	require.NoError(t, inv.Refresh())
+	assert.Eventually(t, func() bool { return r.Contains(mac) }, time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
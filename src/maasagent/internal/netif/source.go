@@ -0,0 +1,139 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// ErrNoSourceAddress is returned when no address of an interface can be the
+// source of the probes of a target
+var ErrNoSourceAddress = errors.New("no source address")
+
+// IPv4Fallback is the source picked for an IPv4 target none of the
+// addresses of the interface is on
+type IPv4Fallback uint8
+
+const (
+	// IPv4FallbackNone fails with ErrNoSourceAddress, the hosts of another
+	// subnet would ignore the probes or answer them through a router
+	IPv4FallbackNone IPv4Fallback = iota
+	// IPv4FallbackPrimary picks the first IPv4 address of the interface
+	IPv4FallbackPrimary
+	// IPv4FallbackLinkLocal picks an address of 169.254.0.0/16 the
+	// interface has
+	IPv4FallbackLinkLocal
+)
+
+type sourceConfig struct {
+	override netip.Addr
+	fallback IPv4Fallback
+}
+
+// SourceOption configures SelectSource
+type SourceOption func(*sourceConfig)
+
+// WithSourceOverride makes SelectSource return addr whatever the addresses
+// of the interface, unless invalid
+func WithSourceOverride(addr netip.Addr) SourceOption {
+	return func(c *sourceConfig) {
+		c.override = addr
+	}
+}
+
+// WithIPv4Fallback sets the source picked for an IPv4 target off the
+// subnets of the interface, IPv4FallbackNone by default
+func WithIPv4Fallback(f IPv4Fallback) SourceOption {
+	return func(c *sourceConfig) {
+		c.fallback = f
+	}
+}
+
+// SelectSource returns which of addrs, the addresses of an interface, the
+// probes of target are sent from: the address on the subnet of target, the
+// most specific one when several are. An IPv6 target off the subnets is
+// probed from a link-local address, an IPv4 one as WithIPv4Fallback says.
+func SelectSource(addrs []netip.Prefix, target netip.Prefix, options ...SourceOption) (netip.Addr, error) {
+	var cfg sourceConfig
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	if cfg.override.IsValid() {
+		return cfg.override, nil
+	}
+
+	if !target.IsValid() {
+		return netip.Addr{}, fmt.Errorf("%w: invalid target", ErrNoSourceAddress)
+	}
+
+	target = target.Masked()
+
+	var (
+		best     netip.Addr
+		bestRank = -1
+	)
+
+	for _, p := range addrs {
+		if p.Addr().Is4() != target.Addr().Is4() || !p.Overlaps(target) {
+			continue
+		}
+
+		// a subnet holding the whole of target comes before one holding
+		// only part of it, then the most specific
+		rank := p.Bits()
+		if p.Bits() <= target.Bits() {
+			rank += 1 << 8
+		}
+
+		if rank > bestRank {
+			best, bestRank = p.Addr(), rank
+		}
+	}
+
+	if best.IsValid() {
+		return best, nil
+	}
+
+	if src, ok := fallbackSource(addrs, target.Addr().Is6(), cfg.fallback); ok {
+		return src, nil
+	}
+
+	return netip.Addr{}, fmt.Errorf("%w: for %s", ErrNoSourceAddress, target)
+}
+
+func fallbackSource(addrs []netip.Prefix, ipv6 bool, fallback IPv4Fallback) (netip.Addr, bool) {
+	for _, p := range addrs {
+		a := p.Addr()
+
+		switch {
+		case ipv6:
+			if a.Is6() && a.IsLinkLocalUnicast() {
+				return a, true
+			}
+		case !a.Is4():
+		case fallback == IPv4FallbackPrimary:
+			return a, true
+		case fallback == IPv4FallbackLinkLocal && a.IsLinkLocalUnicast():
+			return a, true
+		}
+	}
+
+	return netip.Addr{}, false
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func prefixes(s ...string) []netip.Prefix {
+	out := make([]netip.Prefix, 0, len(s))
+	for _, p := range s {
+		out = append(out, netip.MustParsePrefix(p))
+	}
+
+	return out
+}
+
+func TestSelectSource(t *testing.T) {
+	t.Parallel()
+
+	multihomed := prefixes("192.168.1.10/24", "10.0.0.5/16", "10.0.5.1/24", "2001:db8:1::10/64", "fe80::1/64")
+
+	testcases := map[string]struct {
+		err     error
+		addrs   []netip.Prefix
+		target  string
+		options []SourceOption
+		out     string
+	}{
+		"same subnet": {
+			addrs:  multihomed,
+			target: "192.168.1.0/24",
+			out:    "192.168.1.10",
+		},
+		"most specific subnet": {
+			addrs:  multihomed,
+			target: "10.0.5.0/28",
+			out:    "10.0.5.1",
+		},
+		"subnet holding the whole target": {
+			addrs:  multihomed,
+			target: "10.0.0.0/20",
+			out:    "10.0.0.5",
+		},
+		"subnet holding part of the target": {
+			addrs:  prefixes("192.168.1.10/24", "10.0.5.1/24"),
+			target: "10.0.0.0/16",
+			out:    "10.0.5.1",
+		},
+		"unmasked target": {
+			addrs:  multihomed,
+			target: "192.168.1.77/24",
+			out:    "192.168.1.10",
+		},
+		"IPv6 subnet": {
+			addrs:  multihomed,
+			target: "2001:db8:1::/112",
+			out:    "2001:db8:1::10",
+		},
+		"IPv6 off the subnets is probed from link-local": {
+			addrs:  multihomed,
+			target: "2001:db8:2::/64",
+			out:    "fe80::1",
+		},
+		"IPv6 without link-local": {
+			addrs:  prefixes("2001:db8:1::10/64"),
+			target: "2001:db8:2::/64",
+			err:    ErrNoSourceAddress,
+		},
+		"IPv4 off the subnets": {
+			addrs:  multihomed,
+			target: "172.16.0.0/24",
+			err:    ErrNoSourceAddress,
+		},
+		"IPv4 off the subnets from the primary address": {
+			addrs:   multihomed,
+			target:  "172.16.0.0/24",
+			options: []SourceOption{WithIPv4Fallback(IPv4FallbackPrimary)},
+			out:     "192.168.1.10",
+		},
+		"IPv4 off the subnets from link-local": {
+			addrs:   prefixes("192.168.1.10/24", "169.254.10.20/16"),
+			target:  "172.16.0.0/24",
+			options: []SourceOption{WithIPv4Fallback(IPv4FallbackLinkLocal)},
+			out:     "169.254.10.20",
+		},
+		"IPv4 without link-local": {
+			addrs:   multihomed,
+			target:  "172.16.0.0/24",
+			options: []SourceOption{WithIPv4Fallback(IPv4FallbackLinkLocal)},
+			err:     ErrNoSourceAddress,
+		},
+		"IPv4 target with only IPv6 addresses": {
+			addrs:   prefixes("fe80::1/64"),
+			target:  "192.168.1.0/24",
+			options: []SourceOption{WithIPv4Fallback(IPv4FallbackPrimary)},
+			err:     ErrNoSourceAddress,
+		},
+		"override": {
+			addrs:   multihomed,
+			target:  "192.168.1.0/24",
+			options: []SourceOption{WithSourceOverride(netip.MustParseAddr("10.0.0.5"))},
+			out:     "10.0.0.5",
+		},
+		"no addresses": {
+			target: "192.168.1.0/24",
+			err:    ErrNoSourceAddress,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			src, err := SelectSource(tc.addrs, netip.MustParsePrefix(tc.target), tc.options...)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, netip.MustParseAddr(tc.out), src)
+		})
+	}
+}
+
+func TestInventorySourceFor(t *testing.T) {
+	t.Parallel()
+
+	links := testLinks()
+	links[1].Addrs = prefixes("192.168.1.10/24", "10.0.0.5/24", "fe80::1/64")
+	// the sub-interface of VLAN 100 is on another subnet than its parent
+	links[5].Addrs = prefixes("10.100.0.1/24", "fe80::2/64")
+
+	inv := NewInventory()
+	inv.dump = func() ([]Link, error) { return links, nil }
+	require.NoError(t, inv.Refresh())
+
+	testcases := map[string]struct {
+		err    error
+		name   string
+		target string
+		out    string
+		vid    uint16
+	}{
+		"multi-homed": {
+			name:   "eth0",
+			target: "10.0.0.0/24",
+			out:    "10.0.0.5",
+		},
+		"VLAN sub-interface": {
+			name:   "eth0",
+			vid:    100,
+			target: "10.100.0.0/24",
+			out:    "10.100.0.1",
+		},
+		"subnet of the parent from the sub-interface": {
+			name:   "eth0",
+			vid:    100,
+			target: "192.168.1.0/24",
+			err:    ErrNoSourceAddress,
+		},
+		"link-local of the sub-interface": {
+			name:   "eth0",
+			vid:    100,
+			target: "2001:db8::/64",
+			out:    "fe80::2%eth0.100",
+		},
+		"link-local of the link": {
+			name:   "eth0",
+			target: "2001:db8::/64",
+			out:    "fe80::1%eth0",
+		},
+		"VLAN without sub-interface": {
+			name:   "eth0",
+			vid:    300,
+			target: "10.0.0.0/24",
+			err:    ErrLinkNotFound,
+		},
+		"unknown link": {
+			name:   "eth9",
+			target: "10.0.0.0/24",
+			err:    ErrLinkNotFound,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			src, err := inv.SourceFor(tc.name, tc.vid, netip.MustParsePrefix(tc.target))
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, netip.MustParseAddr(tc.out), src)
+		})
+	}
+}
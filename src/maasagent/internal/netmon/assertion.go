@@ -0,0 +1,311 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+)
+
+// ErrInvalidAssertion is returned when a BindingAssertion can't be parsed
+var ErrInvalidAssertion = errors.New("invalid binding assertion")
+
+// BindingAssertion is the MAC an operator knows an IP is bound to, such as
+// the one of a gateway. The assertion only applies to an interface or a VLAN
+// when they are set.
+type BindingAssertion struct {
+	VID       *uint16 `json:"vid,omitempty"`
+	Interface string  `json:"interface,omitempty"`
+	IP        string  `json:"ip"`
+	MAC       string  `json:"mac"`
+	// Implicit is set on the assertions a SelfAddressMonitor derives from
+	// the addresses of the host, rather than given by an operator
+	Implicit bool `json:"implicit,omitempty"`
+}
+
+// AssertionDocument is the JSON document Assertions are loaded from
+type AssertionDocument struct {
+	Assertions []BindingAssertion `json:"assertions"`
+}
+
+// assertion is the parsed form of a BindingAssertion
+type assertion struct {
+	vid   *uint16
+	spec  BindingAssertion
+	iface string
+	mac   net.HardwareAddr
+}
+
+func (a assertion) applies(iface string, vid *uint16) bool {
+	if a.iface != "" && a.iface != iface {
+		return false
+	}
+
+	return a.vid == nil || (vid != nil && *vid == *a.vid)
+}
+
+// Assertions holds the asserted bindings, they can be replaced at any time
+// while the Services sharing them observe traffic
+type Assertions struct {
+	byIP map[netip.Addr][]assertion
+	mu   sync.RWMutex
+}
+
+// NewAssertions returns an empty set of assertions
+func NewAssertions() *Assertions {
+	return &Assertions{byIP: make(map[netip.Addr][]assertion)}
+}
+
+// Set replaces the assertions. Nothing changes if one of them is invalid.
+func (a *Assertions) Set(list []BindingAssertion) error {
+	byIP := make(map[netip.Addr][]assertion, len(list))
+
+	for i, spec := range list {
+		// the IP is unmapped, as those of the bindings, and both are those
+		// of a host
+		ip, err := addrutil.ParseUnicastIP("ip", spec.IP)
+		if err != nil {
+			return fmt.Errorf("%w %d: %w", ErrInvalidAssertion, i, err)
+		}
+
+		mac, err := addrutil.ParseMAC("mac", spec.MAC)
+		if err == nil {
+			mac, err = addrutil.UnicastMAC("mac", mac)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w %d: %w", ErrInvalidAssertion, i, err)
+		}
+
+		spec.IP, spec.MAC = ip.String(), mac.String()
+
+		byIP[ip] = append(byIP[ip], assertion{
+			vid:   spec.VID,
+			mac:   mac,
+			spec:  spec,
+			iface: spec.Interface,
+		})
+	}
+
+	a.mu.Lock()
+	a.byIP = byIP
+	a.mu.Unlock()
+
+	return nil
+}
+
+// Load replaces the assertions with those of an AssertionDocument
+func (a *Assertions) Load(r io.Reader) error {
+	var doc AssertionDocument
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidAssertion, err)
+	}
+
+	return a.Set(doc.Assertions)
+}
+
+// LoadFile replaces the assertions with those of the AssertionDocument at
+// path, it is safe to call again to reload them
+func (a *Assertions) LoadFile(path string) error {
+	f, err := os.Open(path) //nolint:gosec // the path is given by the operator
+	if err != nil {
+		return err
+	}
+
+	defer f.Close() //nolint:errcheck // read only
+
+	if err := a.Load(f); err != nil {
+		return fmt.Errorf("failed loading %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// List returns the assertions, in the order of IP then interface
+func (a *Assertions) List() []BindingAssertion {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var list []BindingAssertion
+
+	for _, ip := range slices.SortedFunc(maps.Keys(a.byIP), netip.Addr.Compare) {
+		n := len(list)
+
+		for _, as := range a.byIP[ip] {
+			list = append(list, as.spec)
+		}
+
+		slices.SortStableFunc(list[n:], func(x, y BindingAssertion) int {
+			return cmp.Compare(x.Interface, y.Interface)
+		})
+	}
+
+	return list
+}
+
+// Check returns the assertion contradicted by ip being bound to mac on the
+// interface and VLAN. There is no violation when mac is asserted by one of
+// the assertions applying to the IP, or when none of them apply.
+func (a *Assertions) Check(iface string, vid *uint16, ip netip.Addr, mac net.HardwareAddr) (BindingAssertion, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var (
+		violated BindingAssertion
+		ok       bool
+	)
+
+	for _, as := range a.byIP[ip.Unmap()] {
+		if !as.applies(iface, vid) {
+			continue
+		}
+
+		if bytes.Equal(as.mac, mac) {
+			return BindingAssertion{}, false
+		}
+
+		if !ok {
+			violated, ok = as.spec, true
+		}
+	}
+
+	return violated, ok
+}
+
+// BindingViolation is an IP seen bound to another MAC than the asserted one,
+// it stays active until the asserted MAC is observed again
+type BindingViolation struct {
+	VID       *uint16          `json:"vid"`
+	Assertion BindingAssertion `json:"assertion"`
+	IP        string           `json:"ip"`
+	// MAC is the observed MAC contradicting the assertion
+	MAC       string `json:"mac"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+	// Count is the number of contradicting observations
+	Count uint64 `json:"count"`
+}
+
+func compareViolations(a, b BindingViolation) int {
+	return compareSnapshotBindings(SnapshotBinding{IP: a.IP, VID: a.VID}, SnapshotBinding{IP: b.IP, VID: b.VID})
+}
+
+// checkAssertions returns the violation Result of a binding contradicting the
+// assertions, or clears the violation of a binding back to the asserted MAC.
+// It is called with the shard of key locked, for every observation however
+// the binding events are coalesced.
+func (s *Service) checkAssertions(key bindingKey, b Binding) (Result, bool) {
+	// a proxy answers for the addresses of other hosts by design
+	if s.assertions == nil || b.ViaProxy {
+		return Result{}, false
+	}
+
+	spec, violated := s.assertions.Check(s.iface, b.VID, b.IP, b.MAC)
+
+	s.violationsMu.Lock()
+	defer s.violationsMu.Unlock()
+
+	if !violated {
+		delete(s.violations, key)
+
+		return Result{}, false
+	}
+
+	mac := b.MAC.String()
+
+	v, ok := s.violations[key]
+	if !ok || v.MAC != mac {
+		v = BindingViolation{
+			VID:       b.VID,
+			IP:        b.IP.String(),
+			MAC:       mac,
+			FirstSeen: b.Time.Unix(),
+		}
+	}
+
+	v.Assertion = spec
+	v.LastSeen = b.Time.Unix()
+	v.Count++
+	s.violations[key] = v
+
+	res := Result{
+		IP:        v.IP,
+		MAC:       mac,
+		VID:       b.VID,
+		Time:      v.LastSeen,
+		Event:     EventBindingViolation,
+		Violation: &v,
+	}
+
+	if s.evidence != nil {
+		res.Evidence = &ResultEvidence{
+			IP:  s.evidence.ByIP(b.IP),
+			MAC: s.evidence.ByMAC(b.MAC),
+		}
+	}
+
+	return res, true
+}
+
+// activeViolations returns the violations which still contradict the
+// assertions, which may have been reloaded since
+func (s *Service) activeViolations() []BindingViolation {
+	if s.assertions == nil {
+		return nil
+	}
+
+	s.violationsMu.Lock()
+	defer s.violationsMu.Unlock()
+
+	var active []BindingViolation
+
+	for key, v := range s.violations {
+		mac, err := net.ParseMAC(v.MAC)
+		if err != nil {
+			continue
+		}
+
+		spec, ok := s.assertions.Check(s.iface, v.VID, key.ip, mac)
+		if !ok {
+			delete(s.violations, key)
+
+			continue
+		}
+
+		v.Assertion = spec
+		active = append(active, v)
+	}
+
+	slices.SortFunc(active, compareViolations)
+
+	return active
+}
@@ -0,0 +1,296 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+)
+
+const gatewayAssertions = `{
+	"assertions": [
+		{"ip": "10.0.0.254", "mac": "00:16:3E:00:00:FE"},
+		{"ip": "10.0.1.254", "mac": "00:16:3e:00:01:fe", "interface": "eth1"},
+		{"ip": "10.0.0.1", "mac": "00:16:3e:00:00:01", "interface": "eth0", "vid": 100},
+		{"ip": "10.0.0.253", "mac": "00:16:3e:00:00:fd"},
+		{"ip": "10.0.0.253", "mac": "00:16:3e:00:00:fc"}
+	]
+}`
+
+func testAssertions(t *testing.T) *Assertions {
+	t.Helper()
+
+	a := NewAssertions()
+	require.NoError(t, a.Load(strings.NewReader(gatewayAssertions)))
+
+	return a
+}
+
+func TestAssertionsLoad(t *testing.T) {
+	t.Parallel()
+
+	a := testAssertions(t)
+
+	assert.Equal(t, []BindingAssertion{
+		{VID: uint16Pointer(100), Interface: "eth0", IP: "10.0.0.1", MAC: "00:16:3e:00:00:01"},
+		{IP: "10.0.0.253", MAC: "00:16:3e:00:00:fd"},
+		{IP: "10.0.0.253", MAC: "00:16:3e:00:00:fc"},
+		{IP: "10.0.0.254", MAC: "00:16:3e:00:00:fe"},
+		{Interface: "eth1", IP: "10.0.1.254", MAC: "00:16:3e:00:01:fe"},
+	}, a.List())
+
+	testcases := map[string]string{
+		"invalid IP":    `{"assertions": [{"ip": "10.0.0", "mac": "00:16:3e:00:00:01"}]}`,
+		"invalid MAC":   `{"assertions": [{"ip": "10.0.0.1", "mac": "00:16:3e"}]}`,
+		"unknown field": `{"assertions": [{"ip": "10.0.0.1", "mac": "00:16:3e:00:00:01", "vlan": 1}]}`,
+		"not JSON":      `assertions`,
+	}
+
+	for name, doc := range testcases {
+		doc := doc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			b := testAssertions(t)
+
+			assert.ErrorIs(t, b.Load(strings.NewReader(doc)), ErrInvalidAssertion)
+			// the previous assertions are kept
+			assert.Len(t, b.List(), 5)
+		})
+	}
+}
+
+func TestAssertionsSetAddresses(t *testing.T) {
+	t.Parallel()
+
+	a := NewAssertions()
+	require.NoError(t, a.Set([]BindingAssertion{{IP: "::ffff:10.0.0.1", MAC: "00-16-3E-00-00-01"}}))
+	assert.Equal(t, []BindingAssertion{{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01"}}, a.List())
+
+	testcases := map[string]struct {
+		spec  BindingAssertion
+		field string
+		err   error
+	}{
+		"EUI-64": {
+			spec:  BindingAssertion{IP: "10.0.0.1", MAC: "00:16:3e:ff:fe:00:00:01"},
+			field: "mac",
+			err:   addrutil.ErrInvalidMAC,
+		},
+		"group MAC": {
+			spec:  BindingAssertion{IP: "10.0.0.1", MAC: "01:00:5e:00:00:01"},
+			field: "mac",
+			err:   addrutil.ErrInvalidMAC,
+		},
+		"zero MAC": {
+			spec:  BindingAssertion{IP: "10.0.0.1", MAC: "00:00:00:00:00:00"},
+			field: "mac",
+			err:   addrutil.ErrInvalidMAC,
+		},
+		"unspecified IP": {
+			spec:  BindingAssertion{IP: "::", MAC: "00:16:3e:00:00:01"},
+			field: "ip",
+			err:   addrutil.ErrInvalidIP,
+		},
+		"multicast IP": {
+			spec:  BindingAssertion{IP: "224.0.0.1", MAC: "00:16:3e:00:00:01"},
+			field: "ip",
+			err:   addrutil.ErrInvalidIP,
+		},
+		"broadcast IP": {
+			spec:  BindingAssertion{IP: "255.255.255.255", MAC: "00:16:3e:00:00:01"},
+			field: "ip",
+			err:   addrutil.ErrInvalidIP,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := NewAssertions().Set([]BindingAssertion{tc.spec})
+			assert.ErrorIs(t, err, ErrInvalidAssertion)
+			assert.ErrorIs(t, err, tc.err)
+
+			var fe *addrutil.FieldError
+			require.ErrorAs(t, err, &fe)
+			assert.Equal(t, tc.field, fe.Field)
+		})
+	}
+}
+
+func TestAssertionsLoadFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "assertions.json")
+	a := NewAssertions()
+
+	assert.ErrorIs(t, a.LoadFile(path), os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(path, []byte(gatewayAssertions), 0o600))
+	require.NoError(t, a.LoadFile(path))
+	assert.Len(t, a.List(), 5)
+
+	// reloading replaces the assertions
+	require.NoError(t, os.WriteFile(path, []byte(`{"assertions": []}`), 0o600))
+	require.NoError(t, a.LoadFile(path))
+	assert.Empty(t, a.List())
+}
+
+func TestAssertionsCheck(t *testing.T) {
+	t.Parallel()
+
+	a := testAssertions(t)
+
+	testcases := map[string]struct {
+		iface    string
+		vid      *uint16
+		ip       string
+		mac      string
+		violated string
+	}{
+		"asserted MAC": {
+			iface: "eth0", ip: "10.0.0.254", mac: "00:16:3e:00:00:fe",
+		},
+		"other MAC": {
+			iface: "eth0", ip: "10.0.0.254", mac: "00:16:3e:00:00:01", violated: "00:16:3e:00:00:fe",
+		},
+		"one of the asserted MACs": {
+			iface: "eth2", ip: "10.0.0.253", mac: "00:16:3e:00:00:fc",
+		},
+		"none of the asserted MACs": {
+			iface: "eth2", ip: "10.0.0.253", mac: "00:16:3e:00:00:01", violated: "00:16:3e:00:00:fd",
+		},
+		"other interface": {
+			iface: "eth0", ip: "10.0.1.254", mac: "00:16:3e:00:00:01",
+		},
+		"on the interface": {
+			iface: "eth1", ip: "10.0.1.254", mac: "00:16:3e:00:00:01", violated: "00:16:3e:00:01:fe",
+		},
+		"untagged": {
+			iface: "eth0", ip: "10.0.0.1", mac: "00:16:3e:00:00:02",
+		},
+		"on the VLAN": {
+			iface: "eth0", vid: uint16Pointer(100), ip: "10.0.0.1", mac: "00:16:3e:00:00:02", violated: "00:16:3e:00:00:01",
+		},
+		"not asserted": {
+			iface: "eth0", ip: "10.0.0.2", mac: "00:16:3e:00:00:02",
+		},
+		"IPv4 mapped": {
+			iface: "eth0", ip: "::ffff:10.0.0.254", mac: "00:16:3e:00:00:01", violated: "00:16:3e:00:00:fe",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			spec, ok := a.Check(tc.iface, tc.vid, netip.MustParseAddr(tc.ip), mustParseMAC(tc.mac))
+			assert.Equal(t, tc.violated != "", ok)
+			assert.Equal(t, tc.violated, spec.MAC)
+		})
+	}
+}
+
+func TestServiceBindingViolation(t *testing.T) {
+	t.Parallel()
+
+	a := testAssertions(t)
+	svc := NewService("eth0", WithAssertions(a), WithEvidenceLog(NewEvidenceLog()))
+
+	pkt := testARPPacket()
+	pkt.SendIPAddr = netip.MustParseAddr("10.0.0.254")
+	pkt.SendHwAddr = mustParseMAC("00:16:3e:00:00:66")
+
+	timestamp := time.Unix(1700000000, 0)
+
+	res := svc.updateBindings(pkt, nil, timestamp)
+	require.Len(t, res, 2)
+	assert.Equal(t, EventBindingViolation, res[0].Event)
+	assert.Equal(t, "00:16:3e:00:00:66", res[0].MAC)
+	assert.Equal(t, &BindingViolation{
+		Assertion: BindingAssertion{IP: "10.0.0.254", MAC: "00:16:3e:00:00:fe"},
+		IP:        "10.0.0.254",
+		MAC:       "00:16:3e:00:00:66",
+		FirstSeen: 1700000000,
+		LastSeen:  1700000000,
+		Count:     1,
+	}, res[0].Violation)
+	require.NotNil(t, res[0].Evidence)
+	assert.Len(t, res[0].Evidence.IP, 1)
+	assert.Equal(t, EventNew, res[1].Event)
+
+	// the binding isn't refreshed yet, the violation is reported anyway
+	res = svc.updateBindings(pkt, nil, timestamp.Add(time.Second))
+	require.Len(t, res, 1)
+	assert.Equal(t, EventBindingViolation, res[0].Event)
+	assert.Equal(t, uint64(2), res[0].Violation.Count)
+
+	violations := svc.Snapshot().Violations
+	require.Len(t, violations, 1)
+	assert.Equal(t, int64(1700000000), violations[0].FirstSeen)
+	assert.Equal(t, int64(1700000001), violations[0].LastSeen)
+
+	// a restarted Service keeps the alert from the snapshot
+	restored := NewService("eth0", WithAssertions(a), WithViolations(violations))
+	assert.Equal(t, violations, restored.Snapshot().Violations)
+
+	// until the asserted MAC is back
+	pkt.SendHwAddr = mustParseMAC("00:16:3e:00:00:fe")
+
+	res = restored.updateBindings(pkt, nil, timestamp.Add(2*time.Second))
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+	assert.Empty(t, restored.Snapshot().Violations)
+
+	// dropping the assertion clears the violation too
+	require.NoError(t, a.Set(nil))
+	assert.Empty(t, svc.Snapshot().Violations)
+}
+
+func TestSnapshotDiffViolations(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0", WithAssertions(testAssertions(t)))
+	first := svc.Snapshot()
+
+	pkt := testARPPacket()
+	pkt.SendIPAddr = netip.MustParseAddr("10.0.0.254")
+	pkt.SendHwAddr = mustParseMAC("00:16:3e:00:00:66")
+	svc.updateBindings(pkt, nil, time.Unix(1700000000, 0))
+
+	second := svc.Snapshot()
+	diff := second.Diff(first)
+	assert.Equal(t, second.Violations, diff.Violations)
+
+	applied, err := diff.Apply(first)
+	require.NoError(t, err)
+	assert.Equal(t, second, applied)
+}
@@ -0,0 +1,573 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime/pprof"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/dnsmsg"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/lldp"
+	"maas.io/core/src/maasagent/internal/ndp"
+	"maas.io/core/src/maasagent/internal/testing/alloc"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+// benchTraffic is a sample of what reaches the capture filter, the ARP
+// frames come from a few hosts of two VLANs
+func benchTraffic(tb testing.TB) [][]byte {
+	tb.Helper()
+
+	build := func(b *ethernet.FrameBuilder) []byte {
+		buf, err := b.Build()
+		require.NoError(tb, err)
+
+		return buf
+	}
+
+	var frames [][]byte
+
+	for i := range 4 {
+		mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x4a, 0x10, byte(i)}
+		ip := netip.AddrFrom4([4]byte{10, 0, 0, byte(10 + i)})
+
+		frames = append(frames,
+			build(ethernet.NewFrame().Src(mac).Padded().ARPRequest(ip, netip.MustParseAddr("10.0.0.1"))),
+			build(ethernet.NewFrame().Src(mac).VLAN(100).Padded().ARPRequest(ip, netip.MustParseAddr("10.0.0.1"))),
+			build(ethernet.NewFrame().Src(mac).UDP(netip.AddrPortFrom(ip, 4789),
+				netip.MustParseAddrPort("10.0.0.1:4789"), make([]byte, 1400))),
+			build(ethernet.NewFrame().Src(mac).NeighborSolicitation(netip.MustParseAddr("fe80::1"),
+				netip.MustParseAddr("fe80::2"))),
+		)
+	}
+
+	return frames
+}
+
+func BenchmarkARPFilter(b *testing.B) {
+	filter, err := arpFilter()
+	require.NoError(b, err)
+
+	vm, err := bpf.NewVM(disassemble(b, filter))
+	require.NoError(b, err)
+
+	frames := benchTraffic(b)
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		for _, frame := range frames {
+			_, _ = vm.Run(frame) //nolint:errcheck // the filter is valid
+		}
+	}
+}
+
+// BenchmarkHandleFrame measures the frames accepted by the filter once the
+// bindings are known, which is the steady state of a monitored segment
+func BenchmarkHandleFrame(b *testing.B) {
+	frames := acceptedFrames(b)
+	svc := benchService()
+	md := capture.Metadata{Direction: capture.DirectionInbound}
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		for _, frame := range frames {
+			_, _ = svc.handleFrame(frame, md) //nolint:errcheck // the frames are valid
+		}
+	}
+}
+
+// BenchmarkPipelineStages compares BenchmarkHandleFrame without a pipeline,
+// with the stages labeled as a capture does, and with them timed by
+// WithStageTiming. The labels must cost a few nanoseconds a frame at most.
+func BenchmarkPipelineStages(b *testing.B) {
+	frames := acceptedFrames(b)
+	md := capture.Metadata{Direction: capture.DirectionInbound}
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewManualReader()))
+
+	testcases := map[string]struct {
+		svc     *Service
+		labeled bool
+	}{
+		"unlabeled": {svc: benchService()},
+		"labeled":   {svc: benchService(), labeled: true},
+		"timed":     {svc: benchService(WithStageTiming(provider.Meter("bench"))), labeled: true},
+	}
+
+	for name, tc := range testcases {
+		b.Run(name, func(b *testing.B) {
+			pprof.Do(context.Background(), pprof.Labels(LabelInterface, "eth0"), func(ctx context.Context) {
+				var p *pipeline
+				if tc.labeled {
+					p = tc.svc.newPipeline(ctx)
+				}
+
+				b.ReportAllocs()
+
+				for b.Loop() {
+					for _, frame := range frames {
+						p.enter(StageCapture)
+						_, _ = tc.svc.handle(p, frame, md) //nolint:errcheck // the frames are valid
+						p.enter(StageDispatch)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkDeduplicator delivers every frame on a bridge then on its member
+// port, the second delivery is suppressed
+func BenchmarkDeduplicator(b *testing.B) {
+	frames := benchTraffic(b)
+	d := NewDeduplicator()
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		for _, frame := range frames {
+			d.duplicate("br0", frame)
+			d.duplicate("eth0", frame)
+		}
+	}
+}
+
+// BenchmarkNeighborTable observes and looks up the bindings of a table
+// from every core while it is snapshotted, as the uploader does, comparing
+// a single lock for the table with the default shards
+func BenchmarkNeighborTable(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		for _, shards := range []int{1, defaultShards} {
+			b.Run(fmt.Sprintf("bindings=%d/shards=%d", size, shards), func(b *testing.B) {
+				benchNeighborTable(b, size, shards)
+			})
+		}
+	}
+}
+
+func benchNeighborTable(b *testing.B, size, shards int) {
+	s := NewService("eth0", WithTableShards(shards), WithLimits(Limits{Bindings: 2 * size}))
+	start := time.Unix(1700000000, 0)
+
+	hosts := make([]netip.Addr, size)
+	macs := make([]net.HardwareAddr, size)
+
+	for i := range size {
+		hosts[i] = netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)})
+		macs[i] = net.HardwareAddr{0x02, 0x00, 0x00, byte(i >> 16), byte(i >> 8), byte(i)}
+		s.Observe(ObservationARPReply, hosts[i], macs[i], nil, start)
+	}
+
+	done := make(chan struct{})
+	snapshots := make(chan int)
+
+	go func() {
+		n := 0
+
+		defer func() { snapshots <- n }()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				s.Snapshot()
+				n++
+			}
+		}
+	}()
+
+	var next atomic.Uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	// three observations for a lookup, the hosts are already bound so the
+	// observations are refreshes
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(next.Add(7919))
+
+		for pb.Next() {
+			i++
+			h := i % size
+
+			if i%4 == 0 {
+				s.Lookup(hosts[h], nil)
+			} else {
+				s.Observe(ObservationARPReply, hosts[h], macs[h], nil, start.Add(time.Duration(i)*time.Millisecond))
+			}
+		}
+	})
+
+	b.StopTimer()
+	close(done)
+	b.ReportMetric(float64(<-snapshots), "snapshots")
+}
+
+func acceptedFrames(tb testing.TB) [][]byte {
+	tb.Helper()
+
+	filter, err := arpFilter()
+	require.NoError(tb, err)
+
+	vm, err := bpf.NewVM(disassemble(tb, filter))
+	require.NoError(tb, err)
+
+	var accepted [][]byte
+
+	for _, frame := range benchTraffic(tb) {
+		if n, err := vm.Run(frame); err == nil && n > 0 {
+			accepted = append(accepted, frame)
+		}
+	}
+
+	return accepted
+}
+
+func benchService(options ...ServiceOption) *Service {
+	clock := clocktest.NewFake(time.Unix(1700000000, 0))
+
+	return NewService("eth0", append([]ServiceOption{WithClock(clock),
+		WithDuplicateMACDetector(NewDuplicateMACDetector(WithDuplicateClock(clock)))}, options...)...)
+}
+
+// TestAllocationBudgets pins the allocations per frame of the capture path,
+// a change exceeding a budget must be justified and the budget raised with
+// it
+func TestAllocationBudgets(t *testing.T) {
+	filter, err := arpFilter()
+	require.NoError(t, err)
+
+	vm, err := bpf.NewVM(disassemble(t, filter))
+	require.NoError(t, err)
+
+	frames := acceptedFrames(t)
+	svc := benchService()
+	md := capture.Metadata{Direction: capture.DirectionInbound}
+
+	// learn the bindings first
+	for _, frame := range frames {
+		_, err := svc.handleFrame(frame, md)
+		require.NoError(t, err)
+	}
+
+	alloc.Budget(t, "arpFilter", 0, func() {
+		_, _ = vm.Run(frames[0]) //nolint:errcheck // the filter is valid
+	})
+	alloc.Budget(t, "Service.handleFrame of a known binding", 4, func() {
+		_, _ = svc.handleFrame(frames[1], md) //nolint:errcheck // the frame is valid
+	})
+
+	// switching the labels of the stages doesn't allocate
+	pprof.Do(context.Background(), pprof.Labels(LabelInterface, "eth0"), func(ctx context.Context) {
+		p := svc.newPipeline(ctx)
+
+		alloc.Budget(t, "Service.handle of a known binding in a labeled pipeline", 4, func() {
+			p.enter(StageCapture)
+			_, _ = svc.handle(p, frames[1], md) //nolint:errcheck // the frame is valid
+			p.enter(StageDispatch)
+		})
+	})
+
+	dedup := NewDeduplicator()
+	alloc.Budget(t, "Deduplicator.duplicate", 0, func() {
+		dedup.duplicate("br0", frames[0])
+		dedup.duplicate("eth0", frames[0])
+	})
+}
+
+// historyMonth is the time monthOfHistory covers, and historyStep the time
+// between the results it records
+const (
+	historyMonth = 30 * 24 * time.Hour
+	historyStep  = 10 * time.Minute
+)
+
+// historyBatch returns the results of a /22 at step, every IP is refreshed
+// and moves between two MACs once a day
+func historyBatch(res []Result, step int) []Result {
+	res = res[:0]
+	t := historyStart.Add(time.Duration(step) * historyStep).Unix()
+	daily := int(24 * time.Hour / historyStep)
+
+	for i := range 1024 {
+		ip := netip.AddrFrom4([4]byte{10, 0, byte(i / 256), byte(i % 256)})
+		macs := [2]net.HardwareAddr{
+			{0x00, 0x16, 0x3e, byte(i / 256), byte(i % 256), 0x01},
+			{0x00, 0x16, 0x3e, byte(i / 256), byte(i % 256), 0x02},
+		}
+		day := step / daily
+		r := Result{IP: ip.String(), MAC: macs[day%2].String(), Time: t, Event: EventRefreshed}
+
+		switch {
+		case step == 0:
+			r.Event = EventNew
+		case step%daily == i%daily:
+			r.Event, r.PreviousMAC = EventMoved, macs[(day+1)%2].String()
+		}
+
+		res = append(res, r)
+	}
+
+	return res
+}
+
+// monthOfHistory returns a History holding a month of historyBatch, and
+// the next step
+func monthOfHistory(tb testing.TB) (*History, int) {
+	tb.Helper()
+
+	h := NewHistory()
+	steps := int(historyMonth / historyStep)
+
+	var res []Result
+
+	for step := range steps {
+		res = historyBatch(res, step)
+		h.record("eth0", res)
+	}
+
+	return h, steps
+}
+
+func BenchmarkHistoryRecord(b *testing.B) {
+	h, step := monthOfHistory(b)
+	batches := make([][]Result, 144)
+
+	for i := range batches {
+		batches[i] = historyBatch(nil, step+i)
+	}
+
+	b.ReportAllocs()
+
+	// past the month, every batch drops what the retention no longer
+	// keeps, the time of the batches goes on so it keeps dropping
+	for b.Loop() {
+		batch := batches[step%len(batches)]
+		for i := range batch {
+			batch[i].Time = historyStart.Add(time.Duration(step) * historyStep).Unix()
+		}
+
+		h.record("eth0", batch)
+		step++
+	}
+}
+
+func BenchmarkHistoryBindingsForIP(b *testing.B) {
+	h, _ := monthOfHistory(b)
+	ip := netip.MustParseAddr("10.0.2.100")
+	from := historyStart.Add(historyMonth / 2)
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		_ = h.BindingsForIP(ip, from, from.Add(24*time.Hour))
+	}
+}
+
+func BenchmarkHistoryBindingsForMAC(b *testing.B) {
+	h, _ := monthOfHistory(b)
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x02, 0x64, 0x01}
+	from := historyStart.Add(historyMonth / 2)
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		_ = h.BindingsForMAC(mac, from, from.Add(24*time.Hour))
+	}
+}
+
+func BenchmarkHistoryActivityTimeline(b *testing.B) {
+	h, _ := monthOfHistory(b)
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		_ = h.ActivityTimeline("eth0", nil, historyStart, historyStart.Add(historyMonth), time.Hour)
+	}
+}
+
+// jumboLen is the length of the adversarial frames, the most a capture
+// hands the decoders
+const jumboLen = 9000
+
+// adversarialFrame is a frame filling a jumbo frame with the structures
+// one of the loops of the decoders iterates over, and the decoder
+type adversarialFrame struct {
+	frame  []byte
+	decode func([]byte, ethernet.ParserOptions) error
+}
+
+// adversarialFrames are the worst cases of the loops of the decoders:
+// chains of the smallest structures they follow, and names pointing at
+// one another
+func adversarialFrames(tb testing.TB) map[string]adversarialFrame {
+	tb.Helper()
+
+	header := func(ethertype uint16) []byte {
+		frame := append(bytes.Clone(ethernet.Broadcast), 0x00, 0x16, 0x3e, 0x00, 0x00, 0x01)
+		return binary.BigEndian.AppendUint16(frame, ethertype)
+	}
+
+	// an IPv6 packet of the extension headers of next and payload
+	ipv6 := func(next uint8, payload []byte) []byte {
+		frame := header(uint16(ethernet.EthernetTypeIPv6))
+		frame = append(frame, 0x60, 0, 0, 0)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload))) //nolint:gosec // a jumbo frame
+		frame = append(frame, next, 255)
+		frame = append(frame, make([]byte, 32)...)
+
+		return append(frame, payload...)
+	}
+
+	tags := header(uint16(ethernet.EthernetTypeVLAN))
+	for len(tags)+4 <= jumboLen-32 {
+		tags = append(tags, 0x00, 0x0a, 0x81, 0x00)
+	}
+
+	tags = append(tags, 0x00, 0x0a, 0x08, 0x06)
+	tags = append(tags, make([]byte, 28)...)
+
+	tlvs := header(0x88cc)
+	for len(tlvs) < jumboLen {
+		// TLVs of a port description of no value
+		tlvs = append(tlvs, 0x08, 0x00)
+	}
+
+	// destination options headers of 8 bytes, padded by a PadN option
+	var chain []byte
+	for len(chain) < jumboLen-62 {
+		chain = append(chain, 60, 0, 1, 4, 0, 0, 0, 0)
+	}
+
+	chain = append(chain[:len(chain)-8], 58, 0, 1, 4, 0, 0, 0, 0)
+
+	// Hop-by-Hop headers of 2048 bytes of Pad1 options
+	var pads []byte
+	for len(pads) < jumboLen-62-2048 {
+		hdr := make([]byte, 2048)
+		hdr[0], hdr[1] = 0, 255
+		pads = append(pads, hdr...)
+	}
+
+	pads[len(pads)-2048] = 58
+
+	dhcp := buildFrame(tb, ethernet.NewFrame().Src(testDHCPServer).UDP(netip.MustParseAddrPort("10.0.0.1:67"),
+		netip.MustParseAddrPort("255.255.255.255:68"), append(append(func() []byte {
+			msg := make([]byte, bootpLen)
+			msg[0], msg[1], msg[2] = 2, byte(ethernet.HardwareTypeEthernet), 6
+
+			return msg
+		}(), dhcpMagicCookie...), make([]byte, jumboLen-300)...)), nil)
+
+	// questions pointing at the end of the longest chain of pointers
+	dns := []byte{0, 1, 0, 0, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 1, 'a', 0, 0, 1, 0, 1}
+	for prev := 12; len(dns) < jumboLen-50; {
+		next := len(dns)
+		dns = append(dns, 0xc0|byte(prev>>8), byte(prev), 0, 1, 0, 1)
+
+		if next < 12+7+6*63 {
+			prev = next
+		}
+	}
+
+	dnsFrame := buildFrame(tb, ethernet.NewFrame().Src(testDHCPServer).UDP(netip.MustParseAddrPort("10.0.0.1:53"),
+		netip.MustParseAddrPort("10.0.0.2:40000"), dns), nil)
+
+	ndpDecode := func(frame []byte, opts ethernet.ParserOptions) error {
+		_, _, err := ndp.ParseFrameWith(frame, opts)
+		return err
+	}
+
+	return map[string]adversarialFrame{
+		"VLAN tags": {frame: tags, decode: func(frame []byte, opts ethernet.ParserOptions) error {
+			var eth ethernet.EthernetFrame
+			if err := eth.UnmarshalBinary(frame); err != nil {
+				return err
+			}
+
+			_, _, _, err := eth.AppendTagsWith(nil, opts)
+
+			return err
+		}},
+		"LLDP TLVs": {frame: tlvs, decode: func(frame []byte, opts ethernet.ParserOptions) error {
+			_, err := lldp.ParseFrameWith(frame, opts)
+			return err
+		}},
+		"IPv6 extension headers": {frame: ipv6(60, chain), decode: ndpDecode},
+		"Hop-by-Hop options":     {frame: ipv6(0, pads), decode: ndpDecode},
+		"DHCP options": {frame: dhcp, decode: func(frame []byte, opts ethernet.ParserOptions) error {
+			if parseDHCP(frame, opts).msgType == 0 {
+				return ethernet.ErrDecodeLimitExceeded
+			}
+
+			return nil
+		}},
+		"DNS names": {frame: dnsFrame, decode: func(frame []byte, opts ethernet.ParserOptions) error {
+			_, _, err := dnsmsg.ParseFrameWith(frame, opts)
+			return err
+		}},
+	}
+}
+
+// TestAdversarialFrames checks that the frames of BenchmarkAdversarialFrames
+// are abandoned at a limit
+func TestAdversarialFrames(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range adversarialFrames(t) {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.LessOrEqual(t, len(tc.frame), jumboLen)
+			assert.ErrorIs(t, tc.decode(tc.frame, ethernet.ParserOptions{}), ethernet.ErrDecodeLimitExceeded)
+		})
+	}
+}
+
+// BenchmarkAdversarialFrames decodes the worst cases of each loop of the
+// decoders. Whatever their content, the frames cost the decoders at most
+// the budget of a frame: a regression shows as a frame an order of
+// magnitude slower than the others.
+func BenchmarkAdversarialFrames(b *testing.B) {
+	for name, tc := range adversarialFrames(b) {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for b.Loop() {
+				_ = tc.decode(tc.frame, ethernet.ParserOptions{}) //nolint:errcheck // the frames are rejected
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/capture"
+)
+
+// ErrMissingCapability is returned by the features disabled because the
+// process lacks a Capability
+var ErrMissingCapability = errors.New("missing capability")
+
+// Capability is a privilege of the process some features need
+type Capability string
+
+// CapabilityNetRaw lets the process open the raw sockets capturing and
+// probing the segments
+const CapabilityNetRaw Capability = "CAP_NET_RAW"
+
+// CapabilityNetAdmin lets the process change the kernel neighbor cache
+const CapabilityNetAdmin Capability = "CAP_NET_ADMIN"
+
+// The features of discovery, as DegradedCapability lists them
+const (
+	// FeatureCapture observes the frames of the segments
+	FeatureCapture = "capture"
+	// FeatureProbing sends the scans, the probes of the monitors and the
+	// Wake-on-LAN packets
+	FeatureProbing = "probing"
+	// FeatureKernelNeighbors reconciles the bindings with the kernel
+	// neighbor cache, read over rtnetlink, see Reconciler
+	FeatureKernelNeighbors = "kernel_neighbors"
+	// FeatureIngestion takes the observations of other sources, such as
+	// the leases of dhcpd, see Service.Ingest
+	FeatureIngestion = "ingestion"
+	// FeaturePriming installs the bindings of the hosts about to boot into
+	// the kernel neighbor cache, see Primer
+	FeaturePriming = "priming"
+)
+
+// DegradedCapability reports a Capability the process lacks: the features
+// needing it are disabled, once, rather than failing each time they run,
+// and the passive features fed by other sources keep running. It tells why
+// the discovery data is partial.
+type DegradedCapability struct {
+	Capability Capability `json:"capability"`
+	// Error is why the Capability is deemed missing
+	Error string `json:"error"`
+	// Disabled are the features which need the Capability, Running those
+	// which keep running without it
+	Disabled []string `json:"disabled"`
+	Running  []string `json:"running"`
+}
+
+// Capabilities are the capabilities a Service runs without, none unless
+// detected missing
+type Capabilities struct {
+	Degraded []DegradedCapability `json:"degraded,omitempty"`
+}
+
+// DetectCapabilities checks that the process can capture with check,
+// capture.CheckRawAccess unless testing. A check failing for another reason
+// than a lack of privileges returns its error.
+func DetectCapabilities(check func() error) (Capabilities, error) {
+	err := check()
+
+	switch {
+	case err == nil:
+		return Capabilities{}, nil
+	case errors.Is(err, capture.ErrNoRawAccess):
+		return Capabilities{Degraded: []DegradedCapability{degradedNetRaw(err)}}, nil
+	default:
+		return Capabilities{}, err
+	}
+}
+
+func degradedNetRaw(err error) DegradedCapability {
+	return DegradedCapability{
+		Capability: CapabilityNetRaw,
+		Error:      err.Error(),
+		Disabled:   []string{FeatureCapture, FeatureProbing},
+		Running:    []string{FeatureKernelNeighbors, FeatureIngestion},
+	}
+}
+
+// Lacks returns true when c is missing
+func (c Capabilities) Lacks(capability Capability) bool {
+	return slices.ContainsFunc(c.Degraded, func(d DegradedCapability) bool {
+		return d.Capability == capability
+	})
+}
+
+// Err returns an error matching ErrMissingCapability when c is missing
+func (c Capabilities) Err(capability Capability) error {
+	if !c.Lacks(capability) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrMissingCapability, capability)
+}
+
+// Log reports the degraded capabilities, a line each
+func (c Capabilities) Log() {
+	for _, d := range c.Degraded {
+		log.Warn().
+			Str("capability", string(d.Capability)).
+			Str("error", d.Error).
+			Strs("disabled", d.Disabled).
+			Strs("running", d.Running).
+			Msg("Discovery degraded, features disabled for a missing capability")
+	}
+}
+
+// WithCapabilities sets the capabilities the Service runs with, see
+// DetectCapabilities. Without CapabilityNetRaw it captures nothing, its
+// bindings only come from Ingest and the Reconciler.
+func WithCapabilities(c Capabilities) ServiceOption {
+	return func(s *Service) {
+		s.capabilities = c
+	}
+}
+
+// Capabilities returns the capabilities the Service runs without, those set
+// with WithCapabilities and CapabilityNetRaw once a capture was denied
+func (s *Service) Capabilities() Capabilities {
+	s.targetMu.Lock()
+	defer s.targetMu.Unlock()
+
+	return s.capabilities
+}
+
+// degrade runs the Service without capture since it lacks CapabilityNetRaw,
+// err is why, until ctx is done
+func (s *Service) degrade(ctx context.Context, err error) error {
+	s.targetMu.Lock()
+
+	if !s.capabilities.Lacks(CapabilityNetRaw) {
+		d := Capabilities{Degraded: []DegradedCapability{degradedNetRaw(err)}}
+		d.Log()
+
+		s.capabilities.Degraded = append(s.capabilities.Degraded, d.Degraded...)
+	}
+
+	s.targetMu.Unlock()
+
+	<-ctx.Done()
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/capture"
+)
+
+// errDenied is what capture.CheckRawAccess returns without CAP_NET_RAW
+var errDenied = fmt.Errorf("failed opening AF_PACKET socket: %w: %w", capture.ErrNoRawAccess, unix.EPERM)
+
+func TestDetectCapabilities(t *testing.T) {
+	t.Parallel()
+
+	caps, err := DetectCapabilities(func() error { return nil })
+	require.NoError(t, err)
+	assert.Empty(t, caps.Degraded)
+	assert.False(t, caps.Lacks(CapabilityNetRaw))
+	assert.NoError(t, caps.Err(CapabilityNetRaw))
+
+	caps, err = DetectCapabilities(func() error { return errDenied })
+	require.NoError(t, err)
+	assert.Equal(t, Capabilities{Degraded: []DegradedCapability{{
+		Capability: CapabilityNetRaw,
+		Error:      errDenied.Error(),
+		Disabled:   []string{FeatureCapture, FeatureProbing},
+		Running:    []string{FeatureKernelNeighbors, FeatureIngestion},
+	}}}, caps)
+	assert.True(t, caps.Lacks(CapabilityNetRaw))
+	assert.ErrorIs(t, caps.Err(CapabilityNetRaw), ErrMissingCapability)
+
+	// a check failing for another reason isn't a missing capability
+	_, err = DetectCapabilities(func() error { return unix.EAFNOSUPPORT })
+	assert.ErrorIs(t, err, unix.EAFNOSUPPORT)
+}
+
+func TestServiceDegraded(t *testing.T) {
+	t.Parallel()
+
+	caps, err := DetectCapabilities(func() error { return errDenied })
+	require.NoError(t, err)
+
+	svc := NewService("eth0", WithCapabilities(caps), WithPortAttributor(NewPortAttributor()))
+	resultC := make(chan Result)
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- svc.Start(ctx, resultC) }()
+
+	// the passive features keep feeding the neighbor table
+	res, err := svc.Ingest(Observation{Source: "dhcpd-leases", IP: netip.MustParseAddr("10.0.0.10"),
+		MAC: testPXEClient, Kind: ObservationDHCPAck, Confidence: ConfidenceHigh})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+	assert.Len(t, svc.Snapshot().Bindings, 1)
+
+	// the captures are disabled rather than failing
+	assert.ErrorIs(t, svc.CaptureMember(ctx, "eth1"), ErrMissingCapability)
+
+	st, err := svc.CaptureStatus()
+	require.NoError(t, err)
+	assert.False(t, st.Running)
+	assert.Equal(t, caps, st.Capabilities)
+
+	select {
+	case err := <-errC:
+		t.Fatalf("degraded Service stopped: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel()
+	require.NoError(t, <-errC)
+
+	_, ok := <-resultC
+	assert.False(t, ok)
+}
+
+func TestServiceDegrade(t *testing.T) {
+	t.Parallel()
+
+	// a capture denied at Start degrades the Service from then on
+	svc := NewService("eth0")
+	assert.Empty(t, svc.Capabilities().Degraded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.NoError(t, svc.degrade(ctx, errDenied))
+	require.NoError(t, svc.degrade(ctx, errors.Join(errDenied, errDenied)))
+
+	caps := svc.Capabilities()
+	require.Len(t, caps.Degraded, 1)
+	assert.Equal(t, errDenied.Error(), caps.Degraded[0].Error)
+	assert.True(t, caps.Lacks(CapabilityNetRaw))
+}
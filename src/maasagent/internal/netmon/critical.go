@@ -0,0 +1,563 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	defaultCriticalInterval = 10 * time.Second
+	defaultCriticalJitter   = 0.1
+	// defaultCriticalTimeout is the arpReplyWindow, a host answering later
+	// is as good as gone for its clients
+	defaultCriticalTimeout = arpReplyWindow
+	defaultCriticalMisses  = 3
+	// defaultCriticalWindow is the number of probes the success rate is
+	// computed over, 200s at the default interval
+	defaultCriticalWindow = 20
+)
+
+// ErrInvalidCriticalHost is returned for a CriticalHost without a unicast
+// address, or with a MAC which isn't an ethernet one
+var ErrInvalidCriticalHost = errors.New("invalid critical host")
+
+// CriticalHost is a host a CriticalHostMonitor probes, such as the gateway
+// or the DHCP server of a VLAN
+type CriticalHost struct {
+	// Name tells the operators what the host is, it labels its metrics
+	Name string
+	IP   netip.Addr
+	// MAC is where the probes are sent, when known. The MAC answering the
+	// probes is used otherwise, they are broadcast until one answers.
+	MAC net.HardwareAddr
+	// VID is the VLAN the host is probed on, nil for the untagged frames
+	VID *uint16
+}
+
+// CriticalHostStatus is the state of a CriticalHost of a
+// CriticalHostMonitor
+type CriticalHostStatus struct {
+	VID  *uint16 `json:"vid"`
+	IP   string  `json:"ip"`
+	Name string  `json:"name,omitempty"`
+	// MAC is the MAC the probes are sent to, empty until the host answered
+	// if it wasn't configured
+	MAC string `json:"mac,omitempty"`
+	// Unresponsive is set once the host missed as many probes in a row as
+	// the monitor tolerates, until it answers again
+	Unresponsive bool `json:"unresponsive"`
+	// Misses is the number of probes missed in a row
+	Misses int `json:"misses"`
+	// Probes is the number of the latest probes SuccessRate is computed
+	// over, the share of them which was answered
+	Probes      int     `json:"probes"`
+	SuccessRate float64 `json:"success_rate"`
+	// Latency is the time the last answer took, in seconds
+	Latency float64 `json:"latency,omitempty"`
+	// LastAnswer is the time of the last answer, Since the time the host
+	// turned responsive or unresponsive, or was first probed
+	LastAnswer int64 `json:"last_answer,omitempty"`
+	Since      int64 `json:"since"`
+}
+
+type criticalHost struct {
+	since      time.Time
+	lastAnswer time.Time
+	// sent is the time of the probe awaiting an answer, zero if none
+	sent time.Time
+	// mac is the configured MAC, or the one which answered last
+	mac   net.HardwareAddr
+	attrs metric.MeasurementOption
+	// outcomes are those of the latest probes, oldest first
+	outcomes []bool
+	CriticalHost
+	latency      time.Duration
+	misses       int
+	unresponsive bool
+	// recovered is set by an answer of an unresponsive host, for the next
+	// evaluation to report it
+	recovered bool
+}
+
+func (h *criticalHost) record(answered bool, window int) {
+	h.outcomes = append(h.outcomes, answered)
+	if len(h.outcomes) > window {
+		h.outcomes = slices.Delete(h.outcomes, 0, len(h.outcomes)-window)
+	}
+}
+
+func (h *criticalHost) status() CriticalHostStatus {
+	st := CriticalHostStatus{
+		VID:          h.VID,
+		IP:           h.IP.String(),
+		Name:         h.Name,
+		Unresponsive: h.unresponsive,
+		Misses:       h.misses,
+		Probes:       len(h.outcomes),
+		Latency:      h.latency.Seconds(),
+		Since:        h.since.Unix(),
+	}
+
+	if h.mac != nil {
+		st.MAC = h.mac.String()
+	}
+
+	if !h.lastAnswer.IsZero() {
+		st.LastAnswer = h.lastAnswer.Unix()
+	}
+
+	if len(h.outcomes) > 0 {
+		answered := 0
+
+		for _, ok := range h.outcomes {
+			if ok {
+				answered++
+			}
+		}
+
+		st.SuccessRate = float64(answered) / float64(len(h.outcomes))
+	}
+
+	return st
+}
+
+// CriticalHostMonitor probes the hosts the segments can't do without, the
+// gateways and the DHCP servers, with unicast ARP requests and neighbor
+// solicitations, and times their answers. A host missing as many probes in
+// a row as tolerated is reported with an EventCriticalHostUnresponsive, and
+// with an EventCriticalHostRecovered once it answers again.
+//
+// The IPv4 probes are RFC 5227 probes, which don't update the ARP cache of
+// the hosts, and the IPv6 ones are sent from the link-local address of the
+// source MAC, unless WithCriticalHostSource sets other addresses.
+type CriticalHostMonitor struct {
+	clock    clock.Clock
+	limiter  *ProbeLimiter
+	hosts    map[bindingKey]*criticalHost
+	latency  metric.Float64Histogram
+	missed   metric.Int64Counter
+	random   func(n int64) int64
+	src      net.HardwareAddr
+	src4     netip.Addr
+	src6     netip.Addr
+	interval time.Duration
+	timeout  time.Duration
+	jitter   float64
+	misses   int
+	window   int
+	mu       sync.Mutex
+}
+
+// CriticalHostOption configures a CriticalHostMonitor
+type CriticalHostOption func(*CriticalHostMonitor)
+
+// WithCriticalHostInterval sets the time between the probes of a host, and
+// the time its answer is waited for
+func WithCriticalHostInterval(interval, timeout time.Duration) CriticalHostOption {
+	return func(m *CriticalHostMonitor) {
+		if interval > 0 {
+			m.interval = interval
+		}
+
+		if timeout > 0 {
+			m.timeout = timeout
+		}
+	}
+}
+
+// WithCriticalHostJitter delays the probes by up to fraction of the
+// interval, at random, so the racks of a segment don't probe together
+func WithCriticalHostJitter(fraction float64) CriticalHostOption {
+	return func(m *CriticalHostMonitor) {
+		if fraction >= 0 && fraction <= 1 {
+			m.jitter = fraction
+		}
+	}
+}
+
+// WithCriticalHostMisses sets the number of probes missed in a row a host
+// is unresponsive after, and the number of the latest probes its success
+// rate is computed over
+func WithCriticalHostMisses(misses, window int) CriticalHostOption {
+	return func(m *CriticalHostMonitor) {
+		if misses > 0 {
+			m.misses = misses
+		}
+
+		if window > 0 {
+			m.window = window
+		}
+	}
+}
+
+// WithCriticalHostSource sets the addresses the probes are sent from, one
+// per family, instead of the unspecified IPv4 address and the link-local
+// IPv6 address of the source MAC
+func WithCriticalHostSource(addrs ...netip.Addr) CriticalHostOption {
+	return func(m *CriticalHostMonitor) {
+		for _, addr := range addrs {
+			if addr.Is4() {
+				m.src4 = addr
+			} else if addr.Is6() {
+				m.src6 = addr
+			}
+		}
+	}
+}
+
+// WithCriticalHostLimiter sets the limiter the probes wait for, so the
+// probing of several interfaces is bounded together
+func WithCriticalHostLimiter(l *ProbeLimiter) CriticalHostOption {
+	return func(m *CriticalHostMonitor) {
+		m.limiter = l
+	}
+}
+
+// WithCriticalHostMeter records the latency of the answers into the
+// netmon.critical_host.latency histogram of meter, and the probes missed
+// into the netmon.critical_host.misses counter, by host
+func WithCriticalHostMeter(meter metric.Meter) CriticalHostOption {
+	return func(m *CriticalHostMonitor) {
+		m.latency = must(meter.Float64Histogram("netmon.critical_host.latency",
+			metric.WithDescription("Time a critical host took to answer a probe"),
+			metric.WithUnit("s")))
+		m.missed = must(meter.Int64Counter("netmon.critical_host.misses",
+			metric.WithDescription("Number of probes a critical host didn't answer")))
+	}
+}
+
+// WithCriticalHostClock sets the clock timing the probes and timestamping
+// the frames observed without a timestamp
+func WithCriticalHostClock(c clock.Clock) CriticalHostOption {
+	return func(m *CriticalHostMonitor) {
+		m.clock = c
+	}
+}
+
+// NewCriticalHostMonitor returns a CriticalHostMonitor sending its probes
+// from src, without hosts until SetHosts
+func NewCriticalHostMonitor(src net.HardwareAddr, options ...CriticalHostOption) (*CriticalHostMonitor, error) {
+	if len(src) != 6 {
+		return nil, fmt.Errorf("%w: source MAC %q", ethernet.ErrBuildFrame, src)
+	}
+
+	m := &CriticalHostMonitor{
+		clock:    clock.System{},
+		hosts:    make(map[bindingKey]*criticalHost),
+		random:   rand.Int64N, //nolint:gosec // the jitter isn't security sensitive
+		src:      slices.Clone(src),
+		src4:     netip.IPv4Unspecified(),
+		src6:     linkLocal6(src),
+		interval: defaultCriticalInterval,
+		timeout:  defaultCriticalTimeout,
+		jitter:   defaultCriticalJitter,
+		misses:   defaultCriticalMisses,
+		window:   defaultCriticalWindow,
+	}
+
+	for _, opt := range options {
+		opt(m)
+	}
+
+	if m.limiter == nil {
+		m.limiter = NewProbeLimiter(defaultProbeRate, defaultProbeBurst, WithProbeLimiterClock(m.clock))
+	}
+
+	return m, nil
+}
+
+// SetHosts replaces the hosts probed, while the monitor runs. The hosts
+// kept, by address and VLAN, keep their state, a removed host is dropped
+// without an event.
+func (m *CriticalHostMonitor) SetHosts(hosts []CriticalHost) error {
+	for _, h := range hosts {
+		if !h.IP.IsValid() || h.IP.IsUnspecified() || h.IP.IsMulticast() || h.IP.Is4In6() ||
+			(h.MAC != nil && len(h.MAC) != 6) {
+			return fmt.Errorf("%w: %s at %q", ErrInvalidCriticalHost, h.IP, h.MAC)
+		}
+	}
+
+	now := m.clock.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous := m.hosts
+	m.hosts = make(map[bindingKey]*criticalHost, len(hosts))
+
+	for _, h := range hosts {
+		h.MAC = slices.Clone(h.MAC)
+		if h.VID != nil {
+			vid := *h.VID
+			h.VID = &vid
+		}
+
+		key := mappingKey(h.IP, h.VID)
+
+		entry, ok := previous[key]
+		if !ok {
+			entry = &criticalHost{since: now}
+		}
+
+		entry.CriticalHost = h
+		if h.MAC != nil {
+			entry.mac = h.MAC
+		}
+
+		entry.attrs = criticalHostAttributes(h)
+		m.hosts[key] = entry
+	}
+
+	return nil
+}
+
+func criticalHostAttributes(h CriticalHost) metric.MeasurementOption {
+	attrs := []attribute.KeyValue{attribute.String("ip", h.IP.String()), attribute.String("name", h.Name)}
+	if h.VID != nil {
+		attrs = append(attrs, attribute.Int("vid", int(*h.VID)))
+	}
+
+	return metric.WithAttributeSet(attribute.NewSet(attrs...))
+}
+
+// Hosts returns the state of every host, by address and VLAN
+func (m *CriticalHostMonitor) Hosts() []CriticalHostStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := m.sortedKeys()
+
+	statuses := make([]CriticalHostStatus, 0, len(keys))
+	for _, key := range keys {
+		statuses = append(statuses, m.hosts[key].status())
+	}
+
+	return statuses
+}
+
+func (m *CriticalHostMonitor) sortedKeys() []bindingKey {
+	keys := make([]bindingKey, 0, len(m.hosts))
+	for key := range m.hosts {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b bindingKey) int {
+		return cmp.Or(a.ip.Compare(b.ip), cmp.Compare(a.vid, b.vid))
+	})
+
+	return keys
+}
+
+// Run probes the hosts every interval through w until ctx is done, and
+// calls emit with the Results of the hosts turning unresponsive or
+// recovering. The answers reach the monitor through Observe.
+func (m *CriticalHostMonitor) Run(ctx context.Context, w capture.FrameWriter, emit func(Result)) {
+	for m.probe(ctx, w) == nil {
+		if m.clock.Sleep(ctx, m.timeout) != nil {
+			return
+		}
+
+		for _, res := range m.evaluate(m.clock.Now()) {
+			emit(res)
+		}
+
+		wait := max(m.interval-m.timeout, 0)
+		if maxJitter := int64(float64(m.interval) * m.jitter); maxJitter > 0 {
+			wait += time.Duration(m.random(maxJitter))
+		}
+
+		if m.clock.Sleep(ctx, wait) != nil {
+			return
+		}
+	}
+}
+
+// probe sends a probe to every host, it only fails once ctx is done
+func (m *CriticalHostMonitor) probe(ctx context.Context, w capture.FrameWriter) error {
+	m.mu.Lock()
+	keys := m.sortedKeys()
+	m.mu.Unlock()
+
+	for _, key := range keys {
+		if err := m.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+
+		h, ok := m.hosts[key]
+		if !ok {
+			// removed by SetHosts meanwhile
+			m.mu.Unlock()
+			continue
+		}
+
+		frame, err := m.probeFrame(h)
+		if err == nil {
+			h.sent = m.clock.Now()
+			err = w.WriteFrame(frame)
+		}
+
+		if err != nil {
+			h.sent = time.Time{}
+		}
+
+		m.mu.Unlock()
+
+		// a probe which can't be sent isn't missed by the host, nor worth
+		// a warning in standby
+		if err != nil && !errors.Is(err, ErrStandby) {
+			log.Warn().Err(err).Str("ip", key.ip.String()).Msg("critical host probe not sent")
+		}
+	}
+
+	return nil
+}
+
+// probeFrame returns the probe of h, to its MAC if known
+func (m *CriticalHostMonitor) probeFrame(h *criticalHost) ([]byte, error) {
+	b := ethernet.NewFrame().Src(m.src)
+	if h.mac != nil {
+		b = b.Dst(h.mac)
+	}
+
+	if h.VID != nil {
+		b = b.VLAN(*h.VID)
+	}
+
+	if h.IP.Is4() {
+		return b.Padded().ARPRequest(m.src4, h.IP).Build()
+	}
+
+	return b.NeighborSolicitation(m.src6, h.IP).Build()
+}
+
+// evaluate counts the probes left unanswered as missed, and returns the
+// Results of the hosts which turned unresponsive or recovered
+func (m *CriticalHostMonitor) evaluate(now time.Time) []Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var res []Result
+
+	for _, key := range m.sortedKeys() {
+		h := m.hosts[key]
+
+		if !h.sent.IsZero() {
+			h.sent = time.Time{}
+			h.misses++
+			h.record(false, m.window)
+
+			if m.missed != nil {
+				m.missed.Add(context.Background(), 1, h.attrs)
+			}
+		}
+
+		event := Event(0)
+
+		switch {
+		case !h.unresponsive && h.misses >= m.misses:
+			h.unresponsive, h.since, event = true, now, EventCriticalHostUnresponsive
+
+			log.Warn().Str("ip", h.IP.String()).Str("name", h.Name).Int("misses", h.misses).
+				Msg("critical host unresponsive")
+		case h.recovered:
+			h.unresponsive, h.since, event = false, h.lastAnswer, EventCriticalHostRecovered
+
+			log.Info().Str("ip", h.IP.String()).Str("name", h.Name).Msg("critical host answers again")
+		}
+
+		h.recovered = false
+
+		if event == 0 {
+			continue
+		}
+
+		status := h.status()
+
+		res = append(res, Result{
+			IP:       status.IP,
+			MAC:      status.MAC,
+			VID:      h.VID,
+			Time:     now.Unix(),
+			Event:    event,
+			Critical: &status,
+		})
+	}
+
+	return res
+}
+
+// Observe reads an ARP reply or a neighbor advertisement received on vid,
+// the answer of a host awaited records its latency
+func (m *CriticalHostMonitor) Observe(frame []byte, vid *uint16, md capture.Metadata) {
+	msg, ok := readNeighborMessage(frame)
+	if !ok || msg.target.IsValid() || msg.probe || len(msg.mac) != 6 {
+		return
+	}
+
+	// the answer is timed on the clock the probe was, unless the capture
+	// did it
+	timestamp := md.Timestamp
+	if timestamp.IsZero() || md.TimestampSource == capture.TimestampHardware {
+		timestamp = m.clock.Now()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.hosts[mappingKey(msg.addr, vid)]
+	if !ok || h.sent.IsZero() {
+		return
+	}
+
+	h.latency = max(timestamp.Sub(h.sent), 0)
+	h.lastAnswer = timestamp
+	h.sent = time.Time{}
+	h.misses = 0
+	h.record(true, m.window)
+
+	if h.unresponsive {
+		h.recovered = true
+	}
+
+	if h.MAC == nil && !bytes.Equal(h.mac, msg.mac) {
+		h.mac = slices.Clone(msg.mac)
+	}
+
+	if m.latency != nil {
+		m.latency.Record(context.Background(), h.latency.Seconds(), h.attrs)
+	}
+}
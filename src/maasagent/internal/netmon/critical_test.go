@@ -0,0 +1,246 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+var (
+	testGatewayMAC = net.HardwareAddr{0x00, 0x16, 0x3e, 0xcc, 0x00, 0x01}
+	testGateway4   = netip.MustParseAddr("10.0.0.254")
+	testGateway6   = netip.MustParseAddr("2001:db8::1")
+)
+
+func newTestCriticalHostMonitor(t *testing.T, options ...CriticalHostOption) (*CriticalHostMonitor,
+	*clocktest.Fake) {
+	t.Helper()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+
+	m, err := NewCriticalHostMonitor(testRackMAC, append([]CriticalHostOption{WithCriticalHostClock(clk),
+		WithCriticalHostLimiter(NewProbeLimiter(0, 0)), WithCriticalHostJitter(0)}, options...)...)
+	require.NoError(t, err)
+
+	return m, clk
+}
+
+// gatewayAnswer returns the answer of the gateway to a probe for ip
+func gatewayAnswer(t *testing.T, ip netip.Addr) []byte {
+	t.Helper()
+
+	b := ethernet.NewFrame().Src(testGatewayMAC).Dst(testRackMAC)
+	if ip.Is4() {
+		return buildFrame(t, b.Padded().ARPReply(ip, testRackMAC, netip.IPv4Unspecified()), nil)
+	}
+
+	return buildFrame(t, b.NeighborAdvertisement(ip, linkLocal6(testRackMAC), ethernet.NAFlagSolicited), nil)
+}
+
+func TestNewCriticalHostMonitor(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewCriticalHostMonitor(nil)
+	assert.ErrorIs(t, err, ethernet.ErrBuildFrame)
+
+	m, _ := newTestCriticalHostMonitor(t)
+
+	testcases := map[string]CriticalHost{
+		"no IP":       {},
+		"unspecified": {IP: netip.IPv6Unspecified()},
+		"multicast":   {IP: netip.MustParseAddr("ff02::1")},
+		"bad MAC":     {IP: testGateway4, MAC: net.HardwareAddr{1, 2, 3}},
+	}
+
+	for name, h := range testcases {
+		h := h
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.ErrorIs(t, m.SetHosts([]CriticalHost{h}), ErrInvalidCriticalHost)
+		})
+	}
+}
+
+func TestCriticalHostMonitor(t *testing.T) {
+	t.Parallel()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	m, clk := newTestCriticalHostMonitor(t, WithCriticalHostInterval(10*time.Second, time.Second),
+		WithCriticalHostMisses(2, 4), WithCriticalHostMeter(provider.Meter("test")))
+	require.NoError(t, m.SetHosts([]CriticalHost{{Name: "gateway", IP: testGateway4, VID: vid10()}}))
+
+	w := &responderWriter{}
+	resultC := make(chan Result, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		m.Run(ctx, w, func(res Result) { resultC <- res })
+	}()
+
+	// round runs a round of probes, the gateway answering after 3ms if
+	// answer is set
+	round := func(answer bool) {
+		clk.BlockUntil(1)
+
+		if answer {
+			m.Observe(gatewayAnswer(t, testGateway4), vid10(), capture.Metadata{
+				Timestamp: clk.Now().Add(3 * time.Millisecond)})
+		}
+
+		clk.Advance(time.Second)
+		clk.BlockUntil(1)
+		clk.Advance(9 * time.Second)
+	}
+
+	// the first probe is broadcast, the next ones are sent to the MAC which
+	// answered
+	round(true)
+	round(false)
+	assert.Empty(t, resultC)
+
+	round(false)
+	require.Len(t, resultC, 1)
+
+	res := <-resultC
+	assert.Equal(t, EventCriticalHostUnresponsive, res.Event)
+	assert.Equal(t, testGatewayMAC.String(), res.MAC)
+	assert.Equal(t, vid10(), res.VID)
+	require.NotNil(t, res.Critical)
+	assert.Equal(t, CriticalHostStatus{
+		VID:          vid10(),
+		IP:           "10.0.0.254",
+		Name:         "gateway",
+		MAC:          testGatewayMAC.String(),
+		Unresponsive: true,
+		Misses:       2,
+		Probes:       3,
+		SuccessRate:  1.0 / 3,
+		Latency:      0.003,
+		LastAnswer:   1700000000,
+		Since:        1700000021,
+	}, *res.Critical)
+
+	round(true)
+	require.Len(t, resultC, 1)
+
+	res = <-resultC
+	assert.Equal(t, EventCriticalHostRecovered, res.Event)
+	assert.False(t, res.Critical.Unresponsive)
+	assert.Equal(t, 0.5, res.Critical.SuccessRate)
+
+	clk.BlockUntil(1)
+	cancel()
+	<-done
+
+	frames := w.sent()
+	require.Len(t, frames, 5)
+	assert.Equal(t, ethernet.Broadcast, net.HardwareAddr(frames[0][:6]))
+
+	for _, frame := range frames[1:] {
+		assert.Equal(t, testGatewayMAC, net.HardwareAddr(frame[:6]))
+	}
+
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	for _, metric := range rm.ScopeMetrics[0].Metrics {
+		switch data := metric.Data.(type) {
+		case metricdata.Histogram[float64]:
+			assert.Equal(t, "netmon.critical_host.latency", metric.Name)
+			require.Len(t, data.DataPoints, 1)
+			assert.Equal(t, uint64(2), data.DataPoints[0].Count)
+
+			name, _ := data.DataPoints[0].Attributes.Value("name")
+			assert.Equal(t, "gateway", name.AsString())
+		case metricdata.Sum[int64]:
+			assert.Equal(t, "netmon.critical_host.misses", metric.Name)
+			require.Len(t, data.DataPoints, 1)
+			assert.Equal(t, int64(2), data.DataPoints[0].Value)
+		default:
+			t.Errorf("unexpected metric %s", metric.Name)
+		}
+	}
+}
+
+func TestCriticalHostMonitorSetHosts(t *testing.T) {
+	t.Parallel()
+
+	m, clk := newTestCriticalHostMonitor(t, WithCriticalHostMisses(1, 0))
+	require.NoError(t, m.SetHosts([]CriticalHost{{IP: testGateway4}, {IP: testGateway6, MAC: testGatewayMAC}}))
+
+	w := &responderWriter{}
+	require.NoError(t, m.probe(context.Background(), w))
+	require.Len(t, w.sent(), 2)
+
+	res := m.evaluate(clk.Now())
+	require.Len(t, res, 2)
+	assert.Equal(t, "10.0.0.254", res[0].IP)
+	assert.Equal(t, "2001:db8::1", res[1].IP)
+
+	// the hosts kept keep their state, the others are dropped
+	clk.Advance(time.Minute)
+	require.NoError(t, m.SetHosts([]CriticalHost{{Name: "router", IP: testGateway6, MAC: testGatewayMAC},
+		{IP: netip.MustParseAddr("10.0.0.1")}}))
+
+	hosts := m.Hosts()
+	require.Len(t, hosts, 2)
+	assert.Equal(t, CriticalHostStatus{IP: "10.0.0.1", Since: 1700000060}, hosts[0])
+	assert.Equal(t, CriticalHostStatus{IP: "2001:db8::1", Name: "router", MAC: testGatewayMAC.String(),
+		Unresponsive: true, Misses: 1, Probes: 1, Since: 1700000000}, hosts[1])
+}
+
+func TestServiceCriticalHostMonitor(t *testing.T) {
+	t.Parallel()
+
+	m, clk := newTestCriticalHostMonitor(t)
+	require.NoError(t, m.SetHosts([]CriticalHost{{IP: testGateway6}}))
+
+	svc := NewService("eth0", WithCriticalHostMonitor(m))
+
+	assert.True(t, svc.observesNDP())
+
+	require.NoError(t, m.probe(context.Background(), &responderWriter{}))
+
+	_, err := svc.handleFrame(gatewayAnswer(t, testGateway6), capture.Metadata{
+		Timestamp: clk.Now().Add(2 * time.Millisecond)})
+	require.NoError(t, err)
+
+	snap := svc.Snapshot()
+	require.Len(t, snap.CriticalHosts, 1)
+	assert.Equal(t, testGatewayMAC.String(), snap.CriticalHosts[0].MAC)
+	assert.Equal(t, 0.002, snap.CriticalHosts[0].Latency)
+	assert.Equal(t, 1.0, snap.CriticalHosts[0].SuccessRate)
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ndp"
+)
+
+const (
+	// defaultDADTimeout leaves a prober the RetransTimer of RFC 4861, one
+	// second per probe, for up to three probes
+	defaultDADTimeout = 3 * time.Second
+	// defaultDADProbes bounds the solicitations kept for matching, a
+	// segment rarely has that many hosts configuring addresses at once
+	defaultDADProbes = 128
+)
+
+// DADConflict reports a host whose Duplicate Address Detection failed, it
+// won't use the tentative address another host defended
+type DADConflict struct {
+	// Tentative is the presentation format of the probed address
+	Tentative string `json:"tentative"`
+	// SolicitingMAC is the presentation format of the MAC of the prober
+	SolicitingMAC string `json:"soliciting_mac"`
+	// DefendingMAC is the presentation format of the MAC of the host
+	// which advertised or probed the same address
+	DefendingMAC string `json:"defending_mac"`
+}
+
+type dadKey struct {
+	target netip.Addr
+	iface  string
+	vid    uint16
+}
+
+type dadProbe struct {
+	time time.Time
+	mac  net.HardwareAddr
+}
+
+// DADDetector matches the Duplicate Address Detection probes of RFC 4862,
+// the neighbor solicitations from the unspecified address, with the
+// messages of another host claiming the same address. It can be shared by
+// the Services of several interfaces.
+type DADDetector struct {
+	clock     clock.Clock
+	probes    map[dadKey]dadProbe
+	timeout   time.Duration
+	size      int
+	evictions uint64
+	mu        sync.Mutex
+}
+
+// DADDetectorOption configures a DADDetector
+type DADDetectorOption func(*DADDetector)
+
+// WithDADTimeout sets how long after a probe a claim of the address is a
+// conflict
+func WithDADTimeout(d time.Duration) DADDetectorOption {
+	return func(dd *DADDetector) {
+		if d > 0 {
+			dd.timeout = d
+		}
+	}
+}
+
+// WithDADProbes sets the number of probes kept for matching, the oldest
+// one is forgotten for a new one once there are n
+func WithDADProbes(n int) DADDetectorOption {
+	return func(d *DADDetector) {
+		if n > 0 {
+			d.size = n
+		}
+	}
+}
+
+// WithDADLimits sets the number of probes from l, as WithDADProbes
+func WithDADLimits(l Limits) DADDetectorOption {
+	return WithDADProbes(l.DADProbes)
+}
+
+// WithDADClock sets the clock timestamping the messages observed without a
+// timestamp
+func WithDADClock(c clock.Clock) DADDetectorOption {
+	return func(d *DADDetector) {
+		d.clock = c
+	}
+}
+
+// NewDADDetector returns a DADDetector
+func NewDADDetector(options ...DADDetectorOption) *DADDetector {
+	d := &DADDetector{
+		clock:   clock.System{},
+		probes:  make(map[dadKey]dadProbe),
+		timeout: defaultDADTimeout,
+		size:    defaultDADProbes,
+	}
+
+	for _, opt := range options {
+		opt(d)
+	}
+
+	return d
+}
+
+// Observe records a Neighbor Discovery message sent from src by mac on
+// iface. It returns a DADConflict when the message is an advertisement of,
+// or another probe for, an address a different MAC probed within the
+// timeout. A conflict is reported once per probe.
+func (d *DADDetector) Observe(msg ndp.Message, src netip.Addr, mac net.HardwareAddr, iface string,
+	vid *uint16, timestamp time.Time) (DADConflict, bool) {
+	probe := msg.Type == ndp.TypeNeighborSolicitation && src.IsUnspecified()
+
+	// a solicitation from a configured address resolves the target, the
+	// sender doesn't claim it
+	if !probe && msg.Type != ndp.TypeNeighborAdvertisement {
+		return DADConflict{}, false
+	}
+
+	if timestamp.IsZero() {
+		timestamp = d.clock.Now()
+	}
+
+	key := dadKey{iface: iface, target: msg.Target}
+	if vid != nil {
+		key.vid = *vid
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	previous, ok := d.probes[key]
+	if ok && timestamp.Sub(previous.time) > d.timeout {
+		delete(d.probes, key)
+
+		ok = false
+	}
+
+	switch {
+	case ok && !bytes.Equal(previous.mac, mac):
+		delete(d.probes, key)
+
+		return DADConflict{
+			Tentative:     msg.Target.String(),
+			SolicitingMAC: previous.mac.String(),
+			DefendingMAC:  mac.String(),
+		}, true
+	case probe:
+		d.add(key, dadProbe{time: timestamp, mac: bytes.Clone(mac)})
+	}
+
+	return DADConflict{}, false
+}
+
+// add records a probe, forgetting the expired ones, then the oldest ones,
+// when there is no room left
+func (d *DADDetector) add(key dadKey, probe dadProbe) {
+	if _, ok := d.probes[key]; !ok && len(d.probes) >= d.size {
+		for k, p := range d.probes {
+			if probe.time.Sub(p.time) > d.timeout {
+				delete(d.probes, k)
+			}
+		}
+
+		if len(d.probes) >= d.size {
+			d.evictions += evictOldest(d.probes, func(p dadProbe) int64 {
+				return p.time.UnixNano()
+			})
+		}
+	}
+
+	d.probes[key] = probe
+}
+
+// Evictions returns the number of probes forgotten before they expired, for
+// new ones
+func (d *DADDetector) Evictions() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.evictions
+}
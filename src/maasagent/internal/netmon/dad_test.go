@@ -0,0 +1,289 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ndp"
+)
+
+var (
+	testTentative = netip.MustParseAddr("2001:db8::10")
+	testProber    = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	testDefender  = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+)
+
+type ndpObservation struct {
+	time time.Time
+	vid  *uint16
+	src  netip.Addr
+	mac  net.HardwareAddr
+	msg  ndp.Message
+}
+
+func TestDADDetector(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1000, 0)
+	vid := uint16(2)
+	ns := ndp.Message{Type: ndp.TypeNeighborSolicitation, Target: testTentative}
+	na := ndp.Message{Type: ndp.TypeNeighborAdvertisement, Target: testTentative, Override: true}
+	unspecified := netip.IPv6Unspecified()
+	other := ndp.Message{Type: ndp.TypeNeighborAdvertisement, Target: netip.MustParseAddr("2001:db8::11")}
+
+	testcases := map[string]struct {
+		in        []ndpObservation
+		conflicts int
+	}{
+		"defended": {
+			in: []ndpObservation{
+				{msg: ns, src: unspecified, mac: testProber, time: start},
+				{msg: na, src: testTentative, mac: testDefender, time: start.Add(time.Second)},
+			},
+			conflicts: 1,
+		},
+		"simultaneous probes": {
+			in: []ndpObservation{
+				{msg: ns, src: unspecified, mac: testProber, time: start},
+				{msg: ns, src: unspecified, mac: testDefender, time: start.Add(time.Second)},
+			},
+			conflicts: 1,
+		},
+		"reported once": {
+			in: []ndpObservation{
+				{msg: ns, src: unspecified, mac: testProber, time: start},
+				{msg: na, src: testTentative, mac: testDefender, time: start.Add(time.Second)},
+				{msg: na, src: testTentative, mac: testDefender, time: start.Add(2 * time.Second)},
+			},
+			conflicts: 1,
+		},
+		"retransmitted probe": {
+			in: []ndpObservation{
+				{msg: ns, src: unspecified, mac: testProber, time: start},
+				{msg: ns, src: unspecified, mac: testProber, time: start.Add(time.Second)},
+			},
+		},
+		"advertised by the prober": {
+			in: []ndpObservation{
+				{msg: ns, src: unspecified, mac: testProber, time: start},
+				{msg: na, src: testTentative, mac: testProber, time: start.Add(4 * time.Second)},
+			},
+		},
+		"advertised after the timeout": {
+			in: []ndpObservation{
+				{msg: ns, src: unspecified, mac: testProber, time: start},
+				{msg: na, src: testTentative, mac: testDefender, time: start.Add(4 * time.Second)},
+			},
+		},
+		"address resolution": {
+			in: []ndpObservation{
+				{msg: ns, src: unspecified, mac: testProber, time: start},
+				{msg: ns, src: netip.MustParseAddr("2001:db8::1"), mac: testDefender, time: start},
+			},
+		},
+		"other VLAN": {
+			in: []ndpObservation{
+				{msg: ns, src: unspecified, mac: testProber, time: start},
+				{msg: na, src: testTentative, mac: testDefender, time: start, vid: &vid},
+			},
+		},
+		"other target": {
+			in: []ndpObservation{
+				{msg: ns, src: unspecified, mac: testProber, time: start},
+				{msg: other, src: testTentative, mac: testDefender, time: start},
+			},
+		},
+		"unsolicited advertisement": {
+			in: []ndpObservation{
+				{msg: na, src: testTentative, mac: testDefender, time: start},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d := NewDADDetector()
+
+			var conflicts []DADConflict
+
+			for _, o := range tc.in {
+				if c, ok := d.Observe(o.msg, o.src, o.mac, "eth0", o.vid, o.time); ok {
+					conflicts = append(conflicts, c)
+				}
+			}
+
+			require.Len(t, conflicts, tc.conflicts)
+
+			for _, c := range conflicts {
+				assert.Equal(t, DADConflict{
+					Tentative:     testTentative.String(),
+					SolicitingMAC: testProber.String(),
+					DefendingMAC:  testDefender.String(),
+				}, c)
+			}
+		})
+	}
+}
+
+func TestDADDetectorBounded(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1000, 0)
+	d := NewDADDetector(WithDADProbes(2))
+
+	for i, target := range []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"} {
+		ns := ndp.Message{Type: ndp.TypeNeighborSolicitation, Target: netip.MustParseAddr(target)}
+		_, ok := d.Observe(ns, netip.IPv6Unspecified(), testProber, "eth0", nil, start.Add(time.Duration(i)*time.Millisecond))
+		require.False(t, ok)
+	}
+
+	assert.Len(t, d.probes, 2)
+	assert.Equal(t, uint64(1), d.Evictions())
+
+	// the oldest probe was forgotten
+	for target, conflict := range map[string]bool{"2001:db8::1": false, "2001:db8::2": true, "2001:db8::3": true} {
+		na := ndp.Message{Type: ndp.TypeNeighborAdvertisement, Target: netip.MustParseAddr(target)}
+		_, ok := d.Observe(na, netip.MustParseAddr(target), testDefender, "eth0", nil, start.Add(time.Second))
+		assert.Equal(t, conflict, ok, target)
+	}
+}
+
+// ndpFrame returns a Neighbor Discovery message sent from src by mac
+func ndpFrame(mac net.HardwareAddr, src netip.Addr, typ ndp.Type, target netip.Addr) []byte {
+	frame := append([]byte{0x33, 0x33, 0xff, 0x00, 0x00, 0x10}, mac...)
+	frame = append(frame, 0x86, 0xdd)
+
+	ip := make([]byte, 40)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], 24)
+	ip[6] = 58
+	ip[7] = 255
+	copy(ip[8:24], src.AsSlice())
+	copy(ip[24:40], netip.MustParseAddr("ff02::1:ff00:10").AsSlice())
+
+	icmp := append([]byte{byte(typ), 0, 0, 0, 0, 0, 0, 0}, target.AsSlice()...)
+
+	return append(append(frame, ip...), icmp...)
+}
+
+func TestServiceDADConflict(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0", WithDADDetector(NewDADDetector()))
+	md := capture.Metadata{Timestamp: time.Unix(1000, 0)}
+
+	res, err := svc.handleFrame(ndpFrame(testProber, netip.IPv6Unspecified(), ndp.TypeNeighborSolicitation,
+		testTentative), md)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	md.Timestamp = md.Timestamp.Add(time.Second)
+
+	res, err = svc.handleFrame(ndpFrame(testDefender, testTentative, ndp.TypeNeighborAdvertisement,
+		testTentative), md)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, Result{
+		IP:    testTentative.String(),
+		MAC:   testProber.String(),
+		Time:  1001,
+		Event: EventDADConflict,
+		DAD: &DADConflict{
+			Tentative:     testTentative.String(),
+			SolicitingMAC: testProber.String(),
+			DefendingMAC:  testDefender.String(),
+		},
+	}, res[0])
+
+	// without a detector the frames are skipped
+	res, err = NewService("eth0").handleFrame(ndpFrame(testProber, netip.IPv6Unspecified(),
+		ndp.TypeNeighborSolicitation, testTentative), md)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
+
+func TestNDPFilter(t *testing.T) {
+	t.Parallel()
+
+	filter, err := ndpFilter()
+	require.NoError(t, err)
+
+	vm, err := bpf.NewVM(disassemble(t, filter))
+	require.NoError(t, err)
+
+	ns := ndpFrame(testProber, netip.IPv6Unspecified(), ndp.TypeNeighborSolicitation, testTentative)
+	tagged := append(append(ns[:12:12], 0x81, 0x00, 0x00, 0x02), ns[12:]...)
+	rs := ndpFrame(testProber, netip.IPv6Unspecified(), 133, testTentative)
+
+	testcases := map[string]struct {
+		in  []byte
+		len int
+	}{
+		"ARP": {
+			in:  []byte{12: 0x08, 13: 0x06, 41: 0},
+			len: snapLen,
+		},
+		"802.1Q ARP": {
+			in:  []byte{12: 0x81, 13: 0x00, 16: 0x08, 17: 0x06, 45: 0},
+			len: snapLen,
+		},
+		"neighbor solicitation": {
+			in:  ns,
+			len: ndpSnapLen,
+		},
+		"neighbor advertisement": {
+			in:  ndpFrame(testDefender, testTentative, ndp.TypeNeighborAdvertisement, testTentative),
+			len: ndpSnapLen,
+		},
+		"802.1Q neighbor solicitation": {
+			in:  tagged,
+			len: ndpSnapLen,
+		},
+		"router solicitation": {
+			in: rs,
+		},
+		"IPv4": {
+			in: []byte{12: 0x08, 13: 0x00, 33: 0},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			n, err := vm.Run(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.len, n)
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"maas.io/core/src/maasagent/internal/eapol"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	mdnsPort = 5353
+	ssdpPort = 1900
+)
+
+// DecoderSet is the set of the protocols the pipeline of a Service decodes.
+// The frames of the others are never parsed past their ethernet header and
+// VLAN tags, and the detectors observing them don't run, so that a bug in
+// their decoders can't be reached from the segment.
+type DecoderSet uint16
+
+const (
+	// DecoderARP learns the bindings from the ARP frames
+	DecoderARP DecoderSet = 1 << iota
+	// DecoderNDP observes the Neighbor Discovery messages, for the DAD and
+	// proxy detectors, the responder and the monitors
+	DecoderNDP
+	// DecoderDHCP observes the DHCPv4 DISCOVERs and OFFERs, for the port
+	// authentication detector
+	DecoderDHCP
+	// DecoderEAPOL observes the 802.1X identity requests, for the port
+	// authentication detector
+	DecoderEAPOL
+	// DecoderLLDP observes the LLDP advertisements, for the Topology
+	DecoderLLDP
+	// DecoderCDP observes the CDP advertisements, for the Topology
+	DecoderCDP
+	// DecoderMDNS decodes the datagrams of the protocol registered on the
+	// mDNS port
+	DecoderMDNS
+	// DecoderSSDP decodes the datagrams of the protocol registered on the
+	// SSDP port
+	DecoderSSDP
+	// DecoderRegistered decodes the other protocols registered with
+	// ethernet.RegisterEtherType and ethernet.RegisterUDPPort
+	DecoderRegistered
+
+	decoderCount = iota
+
+	// DefaultDecoders are the protocols discovery needs, the others are
+	// opt-in
+	DefaultDecoders = DecoderARP | DecoderNDP | DecoderDHCP
+	// AllDecoders are all the protocols
+	AllDecoders DecoderSet = 1<<decoderCount - 1
+)
+
+var decoderNames = [decoderCount]string{"arp", "ndp", "dhcp", "eapol", "lldp", "cdp", "mdns", "ssdp", "registered"}
+
+// ParseDecoderSet parses a comma-separated list of the names of decoders,
+// as DecoderSet.String returns them
+func ParseDecoderSet(s string) (DecoderSet, error) {
+	var set DecoderSet
+
+	for name := range strings.SplitSeq(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		d, ok := parseDecoder(name)
+		if !ok {
+			return 0, fmt.Errorf("unknown decoder %q", name)
+		}
+
+		set |= d
+	}
+
+	return set, nil
+}
+
+func parseDecoder(name string) (DecoderSet, bool) {
+	for i, n := range decoderNames {
+		if strings.EqualFold(name, n) {
+			return 1 << i, true
+		}
+	}
+
+	return 0, false
+}
+
+// Has returns true when every decoder of d is in s
+func (s DecoderSet) Has(d DecoderSet) bool {
+	return s&d == d
+}
+
+// Names returns the names of the decoders of s, in the order of their
+// constants
+func (s DecoderSet) Names() []string {
+	names := make([]string, 0, bits.OnesCount16(uint16(s)))
+
+	for i, name := range decoderNames {
+		if s.Has(1 << i) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func (s DecoderSet) String() string {
+	return strings.Join(s.Names(), ",")
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (s DecoderSet) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (s *DecoderSet) UnmarshalText(text []byte) error {
+	set, err := ParseDecoderSet(string(text))
+	if err != nil {
+		return err
+	}
+
+	*s = set
+
+	return nil
+}
+
+// layers returns the protocols of r whose decoders are in s
+func (s DecoderSet) layers(r *ethernet.Registry) *ethernet.Registry {
+	return r.Only(func(ethernet.EthernetType) bool {
+		return s.Has(DecoderRegistered)
+	}, func(port uint16) bool {
+		switch port {
+		case mdnsPort:
+			return s.Has(DecoderMDNS)
+		case ssdpPort:
+			return s.Has(DecoderSSDP)
+		default:
+			return s.Has(DecoderRegistered)
+		}
+	})
+}
+
+// portAuthType returns true for the ethernet types of the frames the port
+// authentication detector observes with the decoders of s
+func (s DecoderSet) portAuthType(t ethernet.EthernetType) bool {
+	if t == eapol.EthernetType {
+		return s.Has(DecoderEAPOL)
+	}
+
+	return isPortAuthType(t) && s.Has(DecoderDHCP)
+}
+
+// topologyType returns true for the ethernet types of the frames the
+// Topology observes with the decoders of s: LLDP, and the 802.3 lengths of
+// the LLC frames of CDP
+func (s DecoderSet) topologyType(t ethernet.EthernetType) bool {
+	if t == ethernet.EthernetTypeLLDP {
+		return s.Has(DecoderLLDP)
+	}
+
+	return isTopologyType(t) && s.Has(DecoderCDP)
+}
+
+// WithDecoders sets the protocols the Service decodes, DefaultDecoders
+// unless set. The detectors of the Service only see the frames of the
+// protocols of set: the Topology needs DecoderLLDP or DecoderCDP, the
+// protocols registered with the ethernet package DecoderRegistered.
+func WithDecoders(set DecoderSet) ServiceOption {
+	return func(s *Service) {
+		s.decoders = set
+	}
+}
+
+// WithDecoderMeter reports the decoders of the Service in the
+// netmon.pipeline.decoders gauge of meter, 1 for those it runs and 0 for
+// the others, by interface and decoder, and the frames abandoned at their
+// limits in the netmon.pipeline.decode_limit_exceeded counter, by
+// interface
+func WithDecoderMeter(meter metric.Meter) ServiceOption {
+	return func(s *Service) {
+		s.decoderMeter = meter
+	}
+}
+
+// registerDecoders creates the gauge and the counter of WithDecoderMeter
+func (s *Service) registerDecoders(meter metric.Meter) {
+	iface := attribute.String("interface", s.iface)
+
+	must(meter.Int64ObservableGauge("netmon.pipeline.decoders",
+		metric.WithDescription("Decoders run by the capture pipeline"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			for i, name := range decoderNames {
+				var on int64
+				if s.decoders.Has(1 << i) {
+					on = 1
+				}
+
+				o.Observe(on, metric.WithAttributes(iface, attribute.String("decoder", name)))
+			}
+
+			return nil
+		})))
+
+	must(meter.Int64ObservableCounter("netmon.pipeline.decode_limit_exceeded",
+		metric.WithDescription("Frames abandoned at a limit of the decoders"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(s.limited.Load()), metric.WithAttributes(iface)) //nolint:gosec // a count of frames
+
+			return nil
+		})))
+}
+
+// Decoders returns the protocols the Service decodes
+func (s *Service) Decoders() DecoderSet {
+	return s.decoders
+}
@@ -0,0 +1,327 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+// the decoder of SSDP is broken: a frame reaching it panics
+func init() {
+	err := ethernet.RegisterUDPPort(ssdpPort, func([]byte) (ethernet.Layer, error) {
+		panic("broken SSDP decoder")
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func ssdpFrame(tb testing.TB) []byte {
+	tb.Helper()
+
+	return buildFrame(tb, ethernet.NewFrame().Src(testPXEClient).
+		UDP(netip.MustParseAddrPort("10.0.0.2:40000"), netip.MustParseAddrPort("239.255.255.250:1900"),
+			[]byte("M-SEARCH * HTTP/1.1\r\n")), nil)
+}
+
+func TestParseDecoderSet(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  string
+		out DecoderSet
+		err bool
+	}{
+		"empty": {},
+		"defaults": {
+			in:  "arp,ndp,dhcp",
+			out: DefaultDecoders,
+		},
+		"spaces and case": {
+			in:  " LLDP , cdp,",
+			out: DecoderLLDP | DecoderCDP,
+		},
+		"all": {
+			in:  AllDecoders.String(),
+			out: AllDecoders,
+		},
+		"unknown": {
+			in:  "arp,dns",
+			err: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			set, err := ParseDecoderSet(tc.in)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, set)
+		})
+	}
+}
+
+func TestDecoderSetText(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"arp", "ndp", "dhcp"}, DefaultDecoders.Names())
+	assert.Empty(t, DecoderSet(0).Names())
+
+	type config struct {
+		Decoders DecoderSet `json:"decoders"`
+	}
+
+	b, err := json.Marshal(config{Decoders: DefaultDecoders | DecoderMDNS})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"decoders":"arp,ndp,dhcp,mdns"}`, string(b))
+
+	var c config
+
+	require.NoError(t, json.Unmarshal(b, &c))
+	assert.Equal(t, DefaultDecoders|DecoderMDNS, c.Decoders)
+	assert.Error(t, json.Unmarshal([]byte(`{"decoders":"smtp"}`), &c))
+}
+
+func TestDecoderSetLayers(t *testing.T) {
+	t.Parallel()
+
+	registered := ethernet.Registered()
+
+	assert.Nil(t, DefaultDecoders.layers(registered))
+
+	ssdp := (DefaultDecoders | DecoderSSDP).layers(registered)
+	require.NotNil(t, ssdp)
+	assert.Equal(t, []uint16{ssdpPort}, ssdp.UDPPorts())
+	assert.Empty(t, ssdp.EtherTypes())
+
+	others := DecoderRegistered.layers(registered)
+	require.NotNil(t, others)
+	assert.Contains(t, others.EtherTypes(), testLayerType)
+	assert.Contains(t, others.UDPPorts(), testLayerPort)
+	assert.NotContains(t, others.UDPPorts(), uint16(ssdpPort))
+}
+
+func TestServiceBrokenDecoder(t *testing.T) {
+	t.Parallel()
+
+	// the frames of a disabled protocol never reach its decoder
+	svc := NewService("eth0")
+
+	assert.NotPanics(t, func() {
+		res, err := svc.handleFrame(ssdpFrame(t), capture.Metadata{})
+		require.NoError(t, err)
+		assert.Empty(t, res)
+	})
+
+	svc = NewService("eth0", WithDecoders(DefaultDecoders|DecoderSSDP))
+
+	assert.Panics(t, func() {
+		_, _ = svc.handleFrame(ssdpFrame(t), capture.Metadata{}) //nolint:errcheck // the decoder panics
+	})
+}
+
+func TestServiceDecoders(t *testing.T) {
+	t.Parallel()
+
+	vid := uint16(10)
+	detector := func() ServiceOption {
+		return WithPortAuthDetector(NewPortAuthDetector(WithPortAuthThreshold(1)))
+	}
+
+	testcases := map[string]struct {
+		decoders DecoderSet
+		opts     []ServiceOption
+		in       [][]byte
+		event    Event
+	}{
+		"ARP": {
+			decoders: DefaultDecoders,
+			in: [][]byte{buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Padded().
+				ARPRequest(netip.MustParseAddr("10.0.0.10"), netip.MustParseAddr("10.0.0.1")), &vid)},
+			event: EventNew,
+		},
+		"ARP disabled": {
+			decoders: DecoderNDP | DecoderDHCP,
+			in: [][]byte{buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Padded().
+				ARPRequest(netip.MustParseAddr("10.0.0.10"), netip.MustParseAddr("10.0.0.1")), &vid)},
+		},
+		"EAPOL": {
+			decoders: DefaultDecoders | DecoderEAPOL,
+			opts:     []ServiceOption{detector()},
+			in:       [][]byte{requestIdentityFrame(t, &vid), discoverFrame(t, testPXEClient, &vid)},
+			event:    EventPortAuthenticationSuspected,
+		},
+		"EAPOL disabled": {
+			decoders: DefaultDecoders,
+			opts:     []ServiceOption{detector()},
+			in:       [][]byte{requestIdentityFrame(t, &vid), discoverFrame(t, testPXEClient, &vid)},
+		},
+		"DHCP disabled": {
+			decoders: DecoderARP | DecoderEAPOL,
+			opts:     []ServiceOption{detector()},
+			in:       [][]byte{requestIdentityFrame(t, &vid), discoverFrame(t, testPXEClient, &vid)},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := NewService("eth0", append(tc.opts, WithDecoders(tc.decoders))...)
+			assert.Equal(t, tc.decoders, svc.Decoders())
+
+			var res []Result
+
+			for _, frame := range tc.in {
+				out, err := svc.handleFrame(frame, capture.Metadata{})
+				require.NoError(t, err)
+
+				res = append(res, out...)
+			}
+
+			if tc.event == 0 {
+				assert.Empty(t, res)
+				return
+			}
+
+			require.Len(t, res, 1)
+			assert.Equal(t, tc.event, res[0].Event)
+		})
+	}
+}
+
+func TestServiceDecoderMeter(t *testing.T) {
+	t.Parallel()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	NewService("eth0", WithDecoderMeter(provider.Meter("test")))
+
+	var rm metricdata.ResourceMetrics
+
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	require.Len(t, rm.ScopeMetrics[0].Metrics, 2)
+
+	metric := rm.ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "netmon.pipeline.decoders", metric.Name)
+
+	gauge, ok := metric.Data.(metricdata.Gauge[int64])
+	require.True(t, ok)
+	require.Len(t, gauge.DataPoints, decoderCount)
+
+	on := make(map[string]int64)
+
+	for _, dp := range gauge.DataPoints {
+		iface, _ := dp.Attributes.Value("interface")
+		assert.Equal(t, "eth0", iface.AsString())
+
+		decoder, _ := dp.Attributes.Value("decoder")
+		on[decoder.AsString()] = dp.Value
+	}
+
+	assert.Equal(t, map[string]int64{"arp": 1, "ndp": 1, "dhcp": 1, "eapol": 0, "lldp": 0, "cdp": 0,
+		"mdns": 0, "ssdp": 0, "registered": 0}, on)
+}
+
+func TestServiceDecodeLimits(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	stacked := func(tags int, b *ethernet.FrameBuilder) []byte {
+		for range tags {
+			b = b.VLAN(10)
+		}
+
+		return buildFrame(t, b, nil)
+	}
+
+	frames := [][]byte{
+		stacked(2, ethernet.NewFrame().Src(src).Padded().ARPRequest(netip.MustParseAddr("10.0.0.1"),
+			netip.MustParseAddr("10.0.0.2"))),
+		stacked(3, ethernet.NewFrame().Src(src).Padded().ARPRequest(netip.MustParseAddr("10.0.0.3"),
+			netip.MustParseAddr("10.0.0.2"))),
+		stacked(3, ethernet.NewFrame().Src(src).
+			NeighborSolicitation(netip.IPv6Unspecified(), netip.MustParseAddr("fd00::1"))),
+	}
+
+	var pcap bytes.Buffer
+
+	w, err := capture.NewPcapWriter(&pcap, 1522)
+	require.NoError(t, err)
+
+	for _, frame := range frames {
+		require.NoError(t, w.WriteFrame(frame, capture.Metadata{Timestamp: time.Unix(1, 0), Length: len(frame)}))
+	}
+
+	r, err := capture.NewPcapReader(&pcap, "eth0")
+	require.NoError(t, err)
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	svc := NewService("eth0", WithDADDetector(NewDADDetector()), WithDecoderMeter(provider.Meter("test")),
+		WithParserOptions(ethernet.ParserOptions{Limits: ethernet.DecodeLimits{Tags: 2}}))
+
+	// the frames past the limits don't stop the Service
+	require.NoError(t, svc.Serve(context.Background(), r, make(chan Result, 4)))
+
+	bindings := svc.Bindings()
+	require.Len(t, bindings, 1)
+	assert.Equal(t, "10.0.0.1", bindings[0].IP)
+
+	st, err := svc.CaptureStatus()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), st.LimitExceeded)
+	assert.Zero(t, st.Malformed)
+
+	var rm metricdata.ResourceMetrics
+
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+
+	metric := rm.ScopeMetrics[0].Metrics[1]
+	assert.Equal(t, "netmon.pipeline.decode_limit_exceeded", metric.Name)
+
+	sum, ok := metric.Data.(metricdata.Sum[int64])
+	require.True(t, ok)
+	require.Len(t, sum.DataPoints, 1)
+	assert.Equal(t, int64(2), sum.DataPoints[0].Value)
+}
@@ -0,0 +1,299 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"cmp"
+	"hash/maphash"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+const (
+	// defaultDedupWindow is long enough for the copy of a frame forwarded
+	// by a bridge or mirrored by a switch, short enough that a host
+	// retransmitting isn't taken for one
+	defaultDedupWindow = 20 * time.Millisecond
+	// defaultDedupFrames holds the frames of the window at 400k frames/s
+	defaultDedupFrames = 8192
+	// maxDedupInterfaces is what the mask of an entry holds, the frames of
+	// the interfaces beyond it aren't deduplicated
+	maxDedupInterfaces = 64
+)
+
+// DedupStats are the frames an interface delivered to a Deduplicator
+type DedupStats struct {
+	// Frames are the frames delivered, the suppressed ones included unless
+	// WithUniqueFrameCounts
+	Frames uint64 `json:"frames"`
+	// Suppressed are the frames already delivered by another interface
+	Suppressed uint64 `json:"suppressed"`
+}
+
+// DuplicatePath is a pair of interfaces delivering the same frames, such
+// as a bridge and its member port, or a SPAN session overlapping a direct
+// capture
+type DuplicatePath struct {
+	// First is the interface which delivered the frames first
+	First string `json:"first"`
+	// Duplicate is the interface which delivered them again
+	Duplicate string `json:"duplicate"`
+	// Frames are the frames Duplicate delivered again
+	Frames uint64 `json:"frames"`
+}
+
+// DedupReport is the state of a Deduplicator
+type DedupReport struct {
+	// Interfaces are the frames of each interface
+	Interfaces map[string]DedupStats `json:"interfaces"`
+	// Paths are the pairs of interfaces delivering the same frames, the
+	// busiest first
+	Paths []DuplicatePath `json:"paths"`
+	// Evictions are the frames forgotten before the end of their window,
+	// their duplicates weren't suppressed
+	Evictions uint64 `json:"evictions"`
+}
+
+type dedupEntry struct {
+	seen time.Time
+	// ifaces is the mask of the interfaces which delivered the frame
+	ifaces uint64
+	first  uint8
+}
+
+// dedupSlot is an entry in the order the frames were first seen, seen
+// tells whether the entry has since been replaced by a later sighting
+type dedupSlot struct {
+	seen time.Time
+	hash uint64
+}
+
+// Deduplicator suppresses the frames delivered by more than one interface
+// within a short window, which the captures of a bridge and its member
+// port, or a mirrored port, all see. It is meant to be shared by the
+// Services of every monitored interface, each frame reaching only the
+// first of them. The frames are recognised by a hash of their bytes, a
+// frame repeated on the same interface isn't a duplicate.
+type Deduplicator struct {
+	clock   clock.Clock
+	entries map[uint64]dedupEntry
+	// index interns the names of the interfaces, names and stats are
+	// indexed by it
+	index map[string]uint8
+	paths map[[2]uint8]uint64
+	// ring holds the entries in the order they were seen, from head, so
+	// that the expired and the oldest are found without a scan
+	ring      []dedupSlot
+	names     []string
+	stats     []DedupStats
+	seed      maphash.Seed
+	window    time.Duration
+	size      int
+	head      int
+	count     int
+	evictions uint64
+	mu        sync.Mutex
+	unique    bool
+}
+
+// DeduplicatorOption configures a Deduplicator
+type DeduplicatorOption func(*Deduplicator)
+
+// WithDedupWindow sets how close the copies of a frame must be for the
+// later ones to be suppressed
+func WithDedupWindow(window time.Duration) DeduplicatorOption {
+	return func(d *Deduplicator) {
+		if window > 0 {
+			d.window = window
+		}
+	}
+}
+
+// WithDedupLimits bounds the frames remembered to those of l. The oldest
+// are forgotten first, before the end of their window if they must.
+func WithDedupLimits(l Limits) DeduplicatorOption {
+	return func(d *Deduplicator) {
+		if l.DedupFrames > 0 {
+			d.size = l.DedupFrames
+		}
+	}
+}
+
+// WithDedupClock sets the clock the windows are measured with, the copies
+// of a frame are captured by sockets which may not share a timestamp
+// source
+func WithDedupClock(c clock.Clock) DeduplicatorOption {
+	return func(d *Deduplicator) {
+		d.clock = c
+	}
+}
+
+// WithUniqueFrameCounts leaves the suppressed frames out of the Frames of
+// their interface, which then counts those it delivered first only
+func WithUniqueFrameCounts() DeduplicatorOption {
+	return func(d *Deduplicator) {
+		d.unique = true
+	}
+}
+
+// NewDeduplicator returns a Deduplicator without any frame
+func NewDeduplicator(options ...DeduplicatorOption) *Deduplicator {
+	d := &Deduplicator{
+		clock:  clock.System{},
+		index:  make(map[string]uint8),
+		paths:  make(map[[2]uint8]uint64),
+		seed:   maphash.MakeSeed(),
+		window: defaultDedupWindow,
+		size:   defaultDedupFrames,
+	}
+
+	for _, opt := range options {
+		opt(d)
+	}
+
+	d.entries = make(map[uint64]dedupEntry, d.size)
+	d.ring = make([]dedupSlot, d.size)
+
+	return d
+}
+
+// duplicate records that iface delivered frame and returns true if another
+// interface delivered it within the window
+func (d *Deduplicator) duplicate(iface string, frame []byte) bool {
+	hash := maphash.Bytes(d.seed, frame)
+	now := d.clock.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.interfaceIndex(iface)
+	if !ok {
+		return false
+	}
+
+	d.expire(now)
+
+	bit := uint64(1) << i
+	st := &d.stats[i]
+
+	if e, found := d.entries[hash]; found && e.ifaces&bit == 0 && now.Sub(e.seen) < d.window {
+		e.ifaces |= bit
+		d.entries[hash] = e
+		d.paths[[2]uint8{e.first, i}]++
+
+		st.Suppressed++
+
+		if !d.unique {
+			st.Frames++
+		}
+
+		return true
+	}
+
+	st.Frames++
+
+	d.insert(hash, dedupEntry{seen: now, ifaces: bit, first: i})
+
+	return false
+}
+
+// interfaceIndex returns the index of iface, interning it if it is new
+func (d *Deduplicator) interfaceIndex(iface string) (uint8, bool) {
+	if i, ok := d.index[iface]; ok {
+		return i, true
+	}
+
+	if len(d.names) == maxDedupInterfaces {
+		return 0, false
+	}
+
+	i := uint8(len(d.names)) //nolint:gosec // bounded by maxDedupInterfaces
+	d.index[iface] = i
+	d.names = append(d.names, iface)
+	d.stats = append(d.stats, DedupStats{})
+
+	return i, true
+}
+
+// expire forgets the entries whose window ended before now
+func (d *Deduplicator) expire(now time.Time) {
+	for d.count > 0 && now.Sub(d.ring[d.head].seen) >= d.window {
+		d.pop()
+	}
+}
+
+// pop forgets the oldest slot, and its entry unless seen again since, and
+// returns whether the entry was forgotten
+func (d *Deduplicator) pop() bool {
+	slot := d.ring[d.head]
+	d.head = (d.head + 1) % len(d.ring)
+	d.count--
+
+	if e, ok := d.entries[slot.hash]; ok && e.seen.Equal(slot.seen) {
+		delete(d.entries, slot.hash)
+		return true
+	}
+
+	return false
+}
+
+// insert records e as the latest sighting of the frame, forgetting the
+// oldest when full
+func (d *Deduplicator) insert(hash uint64, e dedupEntry) {
+	if d.count == len(d.ring) && d.pop() {
+		d.evictions++
+	}
+
+	d.ring[(d.head+d.count)%len(d.ring)] = dedupSlot{seen: e.seen, hash: hash}
+	d.count++
+	d.entries[hash] = e
+}
+
+// Report returns the frames each interface delivered and the pairs of
+// interfaces delivering the same frames
+func (d *Deduplicator) Report() DedupReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	report := DedupReport{
+		Interfaces: make(map[string]DedupStats, len(d.names)),
+		Paths:      make([]DuplicatePath, 0, len(d.paths)),
+		Evictions:  d.evictions,
+	}
+
+	for i, name := range d.names {
+		report.Interfaces[name] = d.stats[i]
+	}
+
+	for pair, frames := range d.paths {
+		report.Paths = append(report.Paths, DuplicatePath{
+			First:     d.names[pair[0]],
+			Duplicate: d.names[pair[1]],
+			Frames:    frames,
+		})
+	}
+
+	slices.SortFunc(report.Paths, func(a, b DuplicatePath) int {
+		return cmp.Or(cmp.Compare(b.Frames, a.Frames), strings.Compare(a.First, b.First),
+			strings.Compare(a.Duplicate, b.Duplicate))
+	})
+
+	return report
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+func TestDeduplicator(t *testing.T) {
+	t.Parallel()
+
+	frame := []byte("frame")
+	other := []byte("other")
+
+	type delivery struct {
+		iface     string
+		frame     []byte
+		after     time.Duration
+		duplicate bool
+	}
+
+	testcases := map[string]struct {
+		options    []DeduplicatorOption
+		deliveries []delivery
+		stats      map[string]DedupStats
+		paths      []DuplicatePath
+	}{
+		"another interface": {
+			deliveries: []delivery{
+				{iface: "br0", frame: frame},
+				{iface: "eth0", frame: frame, duplicate: true},
+				{iface: "eth1", frame: frame, duplicate: true},
+				{iface: "eth0", frame: other},
+			},
+			stats: map[string]DedupStats{
+				"br0":  {Frames: 1},
+				"eth0": {Frames: 2, Suppressed: 1},
+				"eth1": {Frames: 1, Suppressed: 1},
+			},
+			paths: []DuplicatePath{
+				{First: "br0", Duplicate: "eth0", Frames: 1},
+				{First: "br0", Duplicate: "eth1", Frames: 1},
+			},
+		},
+		"same interface": {
+			deliveries: []delivery{
+				{iface: "eth0", frame: frame},
+				{iface: "eth0", frame: frame},
+			},
+			stats: map[string]DedupStats{
+				"eth0": {Frames: 2},
+			},
+		},
+		"suppressed once per interface": {
+			deliveries: []delivery{
+				{iface: "br0", frame: frame},
+				{iface: "eth0", frame: frame, duplicate: true},
+				{iface: "eth0", frame: frame},
+				{iface: "br0", frame: frame, duplicate: true},
+			},
+			stats: map[string]DedupStats{
+				"br0":  {Frames: 2, Suppressed: 1},
+				"eth0": {Frames: 2, Suppressed: 1},
+			},
+			paths: []DuplicatePath{
+				{First: "br0", Duplicate: "eth0", Frames: 1},
+				{First: "eth0", Duplicate: "br0", Frames: 1},
+			},
+		},
+		"after the window": {
+			deliveries: []delivery{
+				{iface: "br0", frame: frame},
+				{iface: "eth0", frame: frame, after: defaultDedupWindow},
+			},
+			stats: map[string]DedupStats{
+				"br0":  {Frames: 1},
+				"eth0": {Frames: 1},
+			},
+		},
+		"within a longer window": {
+			options: []DeduplicatorOption{WithDedupWindow(time.Second)},
+			deliveries: []delivery{
+				{iface: "br0", frame: frame},
+				{iface: "eth0", frame: frame, after: 500 * time.Millisecond, duplicate: true},
+			},
+			stats: map[string]DedupStats{
+				"br0":  {Frames: 1},
+				"eth0": {Frames: 1, Suppressed: 1},
+			},
+			paths: []DuplicatePath{
+				{First: "br0", Duplicate: "eth0", Frames: 1},
+			},
+		},
+		"unique frame counts": {
+			options: []DeduplicatorOption{WithUniqueFrameCounts()},
+			deliveries: []delivery{
+				{iface: "br0", frame: frame},
+				{iface: "eth0", frame: frame, duplicate: true},
+			},
+			stats: map[string]DedupStats{
+				"br0":  {Frames: 1},
+				"eth0": {Suppressed: 1},
+			},
+			paths: []DuplicatePath{
+				{First: "br0", Duplicate: "eth0", Frames: 1},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := clocktest.NewFake(time.Unix(1700000000, 0))
+			d := NewDeduplicator(append([]DeduplicatorOption{WithDedupClock(clock)}, tc.options...)...)
+
+			for i, dv := range tc.deliveries {
+				clock.Advance(dv.after)
+				assert.Equal(t, dv.duplicate, d.duplicate(dv.iface, dv.frame), "delivery %d", i)
+			}
+
+			report := d.Report()
+			assert.Equal(t, tc.stats, report.Interfaces)
+			assert.ElementsMatch(t, tc.paths, report.Paths)
+			assert.Zero(t, report.Evictions)
+		})
+	}
+}
+
+func TestDeduplicatorLimits(t *testing.T) {
+	t.Parallel()
+
+	clock := clocktest.NewFake(time.Unix(1700000000, 0))
+	d := NewDeduplicator(WithDedupClock(clock), WithDedupLimits(Limits{DedupFrames: 4}))
+
+	for i := range 6 {
+		assert.False(t, d.duplicate("br0", []byte{byte(i)}))
+	}
+
+	// the first two were forgotten before the end of their window
+	assert.False(t, d.duplicate("eth0", []byte{0}))
+	assert.True(t, d.duplicate("eth0", []byte{5}))
+	assert.Len(t, d.entries, 4)
+	assert.Equal(t, uint64(3), d.Report().Evictions)
+
+	// the expired are forgotten without counting
+	clock.Advance(defaultDedupWindow)
+	assert.False(t, d.duplicate("br0", []byte{6}))
+	assert.Len(t, d.entries, 1)
+	assert.Equal(t, uint64(3), d.Report().Evictions)
+}
+
+func TestDeduplicatorInterfaces(t *testing.T) {
+	t.Parallel()
+
+	d := NewDeduplicator(WithDedupClock(clocktest.NewFake(time.Unix(1700000000, 0))))
+
+	for i := range maxDedupInterfaces {
+		assert.False(t, d.duplicate(fmt.Sprintf("eth%d", i), []byte{byte(i)}))
+	}
+
+	// the frames of the interfaces beyond the mask go through
+	assert.False(t, d.duplicate("extra", []byte{0}))
+	assert.True(t, d.duplicate("eth1", []byte{0}))
+	assert.Len(t, d.Report().Interfaces, maxDedupInterfaces)
+}
+
+func TestDedupReportOrder(t *testing.T) {
+	t.Parallel()
+
+	d := NewDeduplicator(WithDedupClock(clocktest.NewFake(time.Unix(1700000000, 0))))
+
+	for i := range 3 {
+		d.duplicate("br0", []byte{byte(i)})
+		d.duplicate("eth1", []byte{byte(i)})
+	}
+
+	d.duplicate("br0", []byte{4})
+	d.duplicate("eth0", []byte{4})
+
+	assert.Equal(t, []DuplicatePath{
+		{First: "br0", Duplicate: "eth1", Frames: 3},
+		{First: "br0", Duplicate: "eth0", Frames: 1},
+	}, d.Report().Paths)
+}
+
+// TestServiceDeduplicator captures the same frames on a bridge and on its
+// member port, only the capture seeing them first reports the bindings
+func TestServiceDeduplicator(t *testing.T) {
+	t.Parallel()
+
+	var recording bytes.Buffer
+
+	w, err := capture.NewPcapWriter(&recording, 65535)
+	require.NoError(t, err)
+
+	for i := range 8 {
+		frame := buildFrame(t, ethernet.NewFrame().Src(testPXEClient).
+			ARPRequest(netip.AddrFrom4([4]byte{10, 0, 0, byte(10 + i)}), netip.MustParseAddr("10.0.0.1")), nil)
+
+		require.NoError(t, w.WriteFrame(frame, capture.Metadata{Timestamp: time.Unix(1700000000, 0),
+			Length: len(frame)}))
+	}
+
+	d := NewDeduplicator(WithDedupClock(clocktest.NewFake(time.Unix(1700000000, 0))))
+	serve := func(iface string) []Result {
+		r, err := capture.NewPcapReader(bytes.NewReader(recording.Bytes()), iface)
+		require.NoError(t, err)
+
+		svc := NewService(iface, WithDeduplicator(d))
+		resultC := make(chan Result)
+		errC := make(chan error, 1)
+
+		go func() { errC <- svc.Serve(context.Background(), r, resultC) }()
+
+		var res []Result
+		for r := range resultC {
+			res = append(res, r)
+		}
+
+		require.NoError(t, <-errC)
+
+		return res
+	}
+
+	assert.Len(t, serve("br0"), 8)
+	assert.Empty(t, serve("eth0"))
+	assert.Equal(t, map[string]DedupStats{
+		"br0":  {Frames: 8},
+		"eth0": {Frames: 8, Suppressed: 8},
+	}, d.Report().Interfaces)
+}
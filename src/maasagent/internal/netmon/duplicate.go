@@ -0,0 +1,331 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/fhrp"
+	"maas.io/core/src/maasagent/internal/netif"
+)
+
+const (
+	defaultDuplicateWindow = 5 * time.Minute
+)
+
+// LinkSource provides the network interfaces of the host,
+// netif.Inventory implements it
+type LinkSource interface {
+	Links() []netif.Link
+}
+
+// MACLocation is where and when a MAC address was last seen
+type MACLocation struct {
+	// VID is the VLAN ID if one exists
+	VID *uint16 `json:"vid"`
+	// Interface is the name of the interface the MAC was seen on
+	Interface string `json:"interface"`
+	// LastSeen is the time the MAC was last seen on the interface
+	LastSeen int64 `json:"last_seen"`
+}
+
+// DuplicateMACLocation reports a unicast MAC address seen on two
+// interfaces, usually a cabling or bridging mistake or an L2 loop
+type DuplicateMACLocation struct {
+	// MAC is the presentation format of the duplicated MAC
+	MAC string `json:"mac"`
+	// Locations are the interfaces the MAC was seen on, the latest
+	// sighting last
+	Locations [2]MACLocation `json:"locations"`
+}
+
+type macSighting struct {
+	time time.Time
+	vid  *uint16
+}
+
+// macSightings are the interfaces a MAC was seen on, last is the latest
+// of their sightings
+type macSightings struct {
+	last   time.Time
+	ifaces map[string]macSighting
+}
+
+type duplicateKey struct {
+	ifaceA string
+	ifaceB string
+	mac    [6]byte
+}
+
+// DuplicateMACDetector tracks the interfaces source MACs are seen on and
+// reports the ones seen on more than one interface within a window. It is
+// meant to be shared by the Services of every monitored interface.
+type DuplicateMACDetector struct {
+	links     LinkSource
+	clock     clock.Clock
+	lastSweep time.Time
+	sightings map[[6]byte]*macSightings
+	reported  map[duplicateKey]time.Time
+	window    time.Duration
+	size      int
+	evictions uint64
+	mu        sync.Mutex
+}
+
+// DuplicateMACDetectorOption configures a DuplicateMACDetector
+type DuplicateMACDetectorOption func(*DuplicateMACDetector)
+
+// WithDuplicateWindow sets how close two sightings must be to be reported
+func WithDuplicateWindow(window time.Duration) DuplicateMACDetectorOption {
+	return func(d *DuplicateMACDetector) {
+		if window > 0 {
+			d.window = window
+		}
+	}
+}
+
+// WithDuplicateLimits bounds the MACs tracked, and the duplicates
+// remembered, to those of l. The least recently seen are forgotten first.
+func WithDuplicateLimits(l Limits) DuplicateMACDetectorOption {
+	return func(d *DuplicateMACDetector) {
+		if l.DuplicateMACs > 0 {
+			d.size = l.DuplicateMACs
+		}
+	}
+}
+
+// WithDuplicateClock sets the clock timestamping the sightings observed
+// without a timestamp
+func WithDuplicateClock(c clock.Clock) DuplicateMACDetectorOption {
+	return func(d *DuplicateMACDetector) {
+		d.clock = c
+	}
+}
+
+// WithLinkSource makes the detector ignore the MACs of the host interfaces,
+// which bonds and bridges legitimately share with their ports, and frames
+// seen on both an interface and its master or parent
+func WithLinkSource(links LinkSource) DuplicateMACDetectorOption {
+	return func(d *DuplicateMACDetector) {
+		d.links = links
+	}
+}
+
+// NewDuplicateMACDetector returns a DuplicateMACDetector
+func NewDuplicateMACDetector(options ...DuplicateMACDetectorOption) *DuplicateMACDetector {
+	d := &DuplicateMACDetector{
+		window:    defaultDuplicateWindow,
+		size:      defaultDuplicateMACs,
+		clock:     clock.System{},
+		sightings: make(map[[6]byte]*macSightings),
+		reported:  make(map[duplicateKey]time.Time),
+	}
+
+	for _, opt := range options {
+		opt(d)
+	}
+
+	return d
+}
+
+// Observe records a frame received from mac on iface. It returns a
+// DuplicateMACLocation the first time within a window the MAC is seen on
+// another interface.
+func (d *DuplicateMACDetector) Observe(mac net.HardwareAddr, iface string, vid *uint16,
+	timestamp time.Time) (DuplicateMACLocation, bool) {
+	// multicast and broadcast sources are bogus but never a location
+	if len(mac) != 6 || mac[0]&0x01 != 0 || bytes.Equal(mac, make([]byte, 6)) {
+		return DuplicateMACLocation{}, false
+	}
+
+	if timestamp.IsZero() {
+		timestamp = d.clock.Now()
+	}
+
+	key := [6]byte(mac)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(timestamp)
+
+	seen, ok := d.sightings[key]
+	if !ok {
+		if len(d.sightings) >= d.size {
+			d.evictions += evictOldest(d.sightings, func(seen *macSightings) int64 {
+				return seen.last.UnixNano()
+			})
+		}
+
+		seen = &macSightings{ifaces: make(map[string]macSighting)}
+		d.sightings[key] = seen
+	}
+
+	seen.ifaces[iface] = macSighting{time: timestamp, vid: vid}
+	if timestamp.After(seen.last) {
+		seen.last = timestamp
+	}
+
+	for other, sighting := range seen.ifaces {
+		if other == iface || timestamp.Sub(sighting.time) > d.window {
+			continue
+		}
+
+		dup := duplicateKey{mac: key, ifaceA: min(iface, other), ifaceB: max(iface, other)}
+
+		if last, ok := d.reported[dup]; ok && timestamp.Sub(last) <= d.window {
+			continue
+		}
+
+		if d.legitimate(mac, iface, other) {
+			continue
+		}
+
+		if len(d.reported) >= d.size {
+			d.evictions += evictOldest(d.reported, time.Time.UnixNano)
+		}
+
+		d.reported[dup] = timestamp
+
+		return DuplicateMACLocation{
+			MAC: mac.String(),
+			Locations: [2]MACLocation{
+				{Interface: other, VID: sighting.vid, LastSeen: sighting.time.Unix()},
+				{Interface: iface, VID: vid, LastSeen: timestamp.Unix()},
+			},
+		}, true
+	}
+
+	return DuplicateMACLocation{}, false
+}
+
+// Evictions returns the number of MACs and reported duplicates forgotten
+// before the window passed, for new ones
+func (d *DuplicateMACDetector) Evictions() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.evictions
+}
+
+// legitimate returns true when mac is a virtual gateway address, which
+// moves between routers on a failover, when it belongs to the host, or when
+// the frames of one interface are also received by the other, as for a
+// bridge and its ports or a link and its VLANs. Two ports of the same
+// bridge are not stacked, a MAC behind both of them is a loop.
+func (d *DuplicateMACDetector) legitimate(mac net.HardwareAddr, ifaceA, ifaceB string) bool {
+	if _, ok := fhrp.VirtualMAC(mac); ok {
+		return true
+	}
+
+	if d.links == nil {
+		return false
+	}
+
+	links := d.links.Links()
+
+	var a, b int
+
+	for _, l := range links {
+		if bytes.Equal(l.HardwareAddr, mac) {
+			return true
+		}
+
+		switch l.Name {
+		case ifaceA:
+			a = l.Index
+		case ifaceB:
+			b = l.Index
+		}
+	}
+
+	if a == 0 || b == 0 {
+		return false
+	}
+
+	return stacked(links, a, b) || stacked(links, b, a)
+}
+
+// stacked returns true if frames received on lower reach upper, going up
+// to masters and VLAN sub-interfaces
+func stacked(links []netif.Link, lower, upper int) bool {
+	visited := map[int]bool{lower: true}
+	next := []int{lower}
+
+	for len(next) > 0 {
+		var above []int
+
+		for _, l := range links {
+			for _, idx := range next {
+				up := 0
+
+				switch {
+				case l.Index == idx:
+					up = l.MasterIndex
+				case l.ParentIndex == idx && l.VLAN():
+					up = l.Index
+				}
+
+				if up == 0 || visited[up] {
+					continue
+				}
+
+				if up == upper {
+					return true
+				}
+
+				visited[up] = true
+				above = append(above, up)
+			}
+		}
+
+		next = above
+	}
+
+	return false
+}
+
+// sweep forgets the sightings and reports older than the window, at most
+// once per window
+func (d *DuplicateMACDetector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.window {
+		return
+	}
+
+	d.lastSweep = now
+
+	for mac, seen := range d.sightings {
+		for iface, sighting := range seen.ifaces {
+			if now.Sub(sighting.time) > d.window {
+				delete(seen.ifaces, iface)
+			}
+		}
+
+		if len(seen.ifaces) == 0 {
+			delete(d.sightings, mac)
+		}
+	}
+
+	for key, last := range d.reported {
+		if now.Sub(last) > d.window {
+			delete(d.reported, key)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/netif"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+type staticLinks []netif.Link
+
+func (l staticLinks) Links() []netif.Link {
+	return l
+}
+
+type sighting struct {
+	mac   string
+	iface string
+	vid   *uint16
+	after time.Duration
+}
+
+func TestDuplicateMACDetector(t *testing.T) {
+	t.Parallel()
+
+	links := staticLinks{
+		{Name: "eth0", Index: 2, HardwareAddr: mustParseMAC("52:54:00:00:00:02"), MasterIndex: 4},
+		{Name: "eth1", Index: 3, HardwareAddr: mustParseMAC("52:54:00:00:00:03")},
+		{Name: "br0", Index: 4, HardwareAddr: mustParseMAC("52:54:00:00:00:02"), Kind: "bridge"},
+		{Name: "br0.100", Index: 5, HardwareAddr: mustParseMAC("52:54:00:00:00:02"), Kind: "vlan", ParentIndex: 4},
+		{Name: "eth2", Index: 6, HardwareAddr: mustParseMAC("52:54:00:00:00:06")},
+		{Name: "eth3", Index: 7, HardwareAddr: mustParseMAC("52:54:00:00:00:07"), MasterIndex: 4},
+	}
+
+	testcases := map[string]struct {
+		in  []sighting
+		out []DuplicateMACLocation
+	}{
+		"same interface": {
+			in: []sighting{
+				{mac: "00:16:3e:00:00:01", iface: "eth1"},
+				{mac: "00:16:3e:00:00:01", iface: "eth1", vid: uint16Pointer(2)},
+			},
+		},
+		"two interfaces": {
+			in: []sighting{
+				{mac: "00:16:3e:00:00:01", iface: "eth1", vid: uint16Pointer(2)},
+				{mac: "00:16:3e:00:00:01", iface: "eth2", after: time.Second},
+				{mac: "00:16:3e:00:00:01", iface: "eth1", after: 2 * time.Second},
+			},
+			out: []DuplicateMACLocation{
+				{
+					MAC: "00:16:3e:00:00:01",
+					Locations: [2]MACLocation{
+						{Interface: "eth1", VID: uint16Pointer(2), LastSeen: 1700000000},
+						{Interface: "eth2", LastSeen: 1700000001},
+					},
+				},
+			},
+		},
+		"reported again after the window": {
+			in: []sighting{
+				{mac: "00:16:3e:00:00:01", iface: "eth1"},
+				{mac: "00:16:3e:00:00:01", iface: "eth2", after: time.Second},
+				{mac: "00:16:3e:00:00:01", iface: "eth2", after: 90 * time.Second},
+				{mac: "00:16:3e:00:00:01", iface: "eth1", after: 100 * time.Second},
+			},
+			out: []DuplicateMACLocation{
+				{
+					MAC: "00:16:3e:00:00:01",
+					Locations: [2]MACLocation{
+						{Interface: "eth1", LastSeen: 1700000000},
+						{Interface: "eth2", LastSeen: 1700000001},
+					},
+				},
+				{
+					MAC: "00:16:3e:00:00:01",
+					Locations: [2]MACLocation{
+						{Interface: "eth2", LastSeen: 1700000090},
+						{Interface: "eth1", LastSeen: 1700000100},
+					},
+				},
+			},
+		},
+		"outside the window": {
+			in: []sighting{
+				{mac: "00:16:3e:00:00:01", iface: "eth1"},
+				{mac: "00:16:3e:00:00:01", iface: "eth2", after: 2 * time.Minute},
+			},
+		},
+		"broadcast source": {
+			in: []sighting{
+				{mac: "ff:ff:ff:ff:ff:ff", iface: "eth1"},
+				{mac: "ff:ff:ff:ff:ff:ff", iface: "eth2"},
+			},
+		},
+		"HSRP virtual gateway": {
+			in: []sighting{
+				{mac: "00:00:0c:07:ac:01", iface: "eth1"},
+				{mac: "00:00:0c:07:ac:01", iface: "eth2", after: time.Second},
+			},
+		},
+		"GLBP virtual forwarder": {
+			in: []sighting{
+				{mac: "00:07:b4:00:01:02", iface: "eth1"},
+				{mac: "00:07:b4:00:01:02", iface: "eth2", after: time.Second},
+			},
+		},
+		"multicast source": {
+			in: []sighting{
+				{mac: "01:00:5e:00:00:fb", iface: "eth1"},
+				{mac: "01:00:5e:00:00:fb", iface: "eth2"},
+			},
+		},
+		"host MAC": {
+			in: []sighting{
+				{mac: "52:54:00:00:00:02", iface: "eth1"},
+				{mac: "52:54:00:00:00:02", iface: "eth2"},
+			},
+		},
+		"bridge port": {
+			in: []sighting{
+				{mac: "00:16:3e:00:00:01", iface: "eth0"},
+				{mac: "00:16:3e:00:00:01", iface: "br0"},
+			},
+		},
+		"ports of a bridge": {
+			in: []sighting{
+				{mac: "00:16:3e:00:00:01", iface: "eth0"},
+				{mac: "00:16:3e:00:00:01", iface: "eth3"},
+			},
+			out: []DuplicateMACLocation{
+				{
+					MAC: "00:16:3e:00:00:01",
+					Locations: [2]MACLocation{
+						{Interface: "eth0", LastSeen: 1700000000},
+						{Interface: "eth3", LastSeen: 1700000000},
+					},
+				},
+			},
+		},
+		"VLAN on the bridge of a port": {
+			in: []sighting{
+				{mac: "00:16:3e:00:00:01", iface: "br0.100"},
+				{mac: "00:16:3e:00:00:01", iface: "eth0", vid: uint16Pointer(100)},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d := NewDuplicateMACDetector(WithDuplicateWindow(time.Minute), WithLinkSource(links))
+			start := time.Unix(1700000000, 0)
+
+			var out []DuplicateMACLocation
+
+			for _, s := range tc.in {
+				dup, ok := d.Observe(mustParseMAC(s.mac), s.iface, s.vid, start.Add(s.after))
+				if ok {
+					out = append(out, dup)
+				}
+			}
+
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestDuplicateMACDetectorSweep(t *testing.T) {
+	t.Parallel()
+
+	d := NewDuplicateMACDetector(WithDuplicateWindow(time.Minute))
+	start := time.Unix(1700000000, 0)
+
+	for i := range 100 {
+		mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, byte(i)}
+		d.Observe(mac, "eth1", nil, start)
+	}
+
+	d.Observe(mustParseMAC("00:16:3e:00:01:00"), "eth1", nil, start.Add(2*time.Minute))
+
+	assert.Len(t, d.sightings, 1)
+}
+
+func TestDuplicateMACDetectorLimits(t *testing.T) {
+	t.Parallel()
+
+	d := NewDuplicateMACDetector(WithDuplicateLimits(Limits{DuplicateMACs: 16}))
+	start := time.Unix(1700000000, 0)
+	first := mustParseMAC("00:16:3e:00:00:00")
+
+	// the first MAC is seen again on eth1 after the others, the second one
+	// is now the least recently seen
+	for i := range 16 {
+		d.Observe(net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, byte(i)}, "eth0", nil, start.Add(time.Duration(i)))
+	}
+
+	_, ok := d.Observe(first, "eth1", nil, start.Add(time.Second))
+	require.True(t, ok)
+
+	d.Observe(mustParseMAC("00:16:3e:00:01:00"), "eth0", nil, start.Add(2*time.Second))
+
+	assert.Len(t, d.sightings, 16-16/evictionBatch+1)
+	assert.Equal(t, uint64(16/evictionBatch), d.Evictions())
+	assert.Contains(t, d.sightings, [6]byte(first))
+	assert.NotContains(t, d.sightings, [6]byte(mustParseMAC("00:16:3e:00:00:01")))
+}
+
+func TestServiceDuplicateMAC(t *testing.T) {
+	t.Parallel()
+
+	frame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x08, 0x06, 0x00, 0x01,
+		0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0xc0, 0xa8, 0x0a, 0x1a,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19,
+	}
+	timestamp := time.Unix(1700000000, 0)
+
+	d := NewDuplicateMACDetector()
+	eth1 := NewService("eth1", WithDuplicateMACDetector(d))
+	eth2 := NewService("eth2", WithDuplicateMACDetector(d), WithOwnTraffic())
+
+	_, err := eth1.handleFrame(frame, capture.Metadata{Timestamp: timestamp, Direction: capture.DirectionInbound})
+	require.NoError(t, err)
+
+	// the frame is only forwarded by the host
+	res, err := eth2.handleFrame(frame, capture.Metadata{Timestamp: timestamp, Direction: capture.DirectionOutbound})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+
+	res, err = eth2.handleFrame(frame, capture.Metadata{Timestamp: timestamp, Direction: capture.DirectionInbound})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, Result{
+		MAC:   "84:39:c0:0b:22:25",
+		Time:  1700000000,
+		Event: EventDuplicateMACLocation,
+		Duplicate: &DuplicateMACLocation{
+			MAC: "84:39:c0:0b:22:25",
+			Locations: [2]MACLocation{
+				{Interface: "eth1", LastSeen: 1700000000},
+				{Interface: "eth2", LastSeen: 1700000000},
+			},
+		},
+	}, res[0])
+}
+
+func mustParseMAC(s string) net.HardwareAddr {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return mac
+}
+
+func TestServiceDuplicateMACClock(t *testing.T) {
+	t.Parallel()
+
+	frame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x08, 0x06, 0x00, 0x01,
+		0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0xc0, 0xa8, 0x0a, 0x1a,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19,
+	}
+	md := capture.Metadata{Direction: capture.DirectionInbound}
+
+	clock := clocktest.NewFake(time.Unix(1700000000, 0))
+	d := NewDuplicateMACDetector(WithDuplicateWindow(time.Minute), WithDuplicateClock(clock))
+	eth1 := NewService("eth1", WithDuplicateMACDetector(d), WithClock(clock))
+	eth2 := NewService("eth2", WithDuplicateMACDetector(d), WithClock(clock))
+
+	_, err := eth1.handleFrame(frame, md)
+	require.NoError(t, err)
+
+	// the sighting on eth1 expired
+	clock.Advance(2 * time.Minute)
+
+	res, err := eth2.handleFrame(frame, md)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+
+	clock.Advance(30 * time.Second)
+
+	res, err = eth1.handleFrame(frame, md)
+	require.NoError(t, err)
+	require.NotEmpty(t, res)
+	assert.Equal(t, EventDuplicateMACLocation, res[0].Event)
+	assert.Equal(t, [2]MACLocation{
+		{Interface: "eth2", LastSeen: 1700000120},
+		{Interface: "eth1", LastSeen: 1700000150},
+	}, res[0].Duplicate.Locations)
+}
@@ -0,0 +1,366 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+// EncapsulatedScanFunc probes ips from src on the interface, through the
+// tags of path, ScanThrough implements it
+type EncapsulatedScanFunc func(ctx context.Context, iface string, src netip.Addr, path []ethernet.Tag,
+	ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error)
+
+// ProbeConn is the part of a capture.Conn the probes of ScanConn need
+type ProbeConn interface {
+	capture.FrameReader
+	capture.FrameWriter
+	Interface() *net.Interface
+}
+
+// ScanThrough sends ARP requests for the IPv4 addresses of ips from iface,
+// every frame carrying the tags of path, outermost first. It returns what
+// Scan does, the IPv6 addresses never reply. The requests are sent from
+// src, or are probes from 0.0.0.0 when src is invalid.
+func ScanThrough(ctx context.Context, iface string, src netip.Addr, path []ethernet.Tag,
+	ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+	return scanThroughIface(ctx, iface, src, path, ips, nil, nil)
+}
+
+// scanThroughIface is ScanThrough counting the replies in probes, the
+// probes being paced by pace unless nil
+func scanThroughIface(ctx context.Context, iface string, src netip.Addr, path []ethernet.Tag,
+	ips []netip.Addr, probes *probeCounter, pace *AdaptiveRate) (map[netip.Addr]net.HardwareAddr, error) {
+	filter, err := arpFilter()
+	if err == nil {
+		filter, err = stackedARPFilter(filter)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := capture.Listen(iface, capture.WithFilter(filter))
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close() //nolint:errcheck // nothing is read from conn anymore
+
+	return scanThrough(ctx, conn, src, path, ips, OperationTimeout, probes, pace)
+}
+
+// ScanConn is ScanThrough on conn rather than on a capture of an interface
+// it opens, such as an endpoint of a simulated segment
+func ScanConn(ctx context.Context, conn ProbeConn, src netip.Addr, path []ethernet.Tag,
+	ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+	return scanThrough(ctx, conn, src, path, ips, OperationTimeout, nil, nil)
+}
+
+// ScanConnPaced is ScanConn at the rate of pace, the addresses which didn't
+// reply being probed again up to its retries, as the Scheduler scans
+func ScanConnPaced(ctx context.Context, conn ProbeConn, src netip.Addr, path []ethernet.Tag,
+	ips []netip.Addr, pace *AdaptiveRate) (map[netip.Addr]net.HardwareAddr, error) {
+	return scanThrough(ctx, conn, src, path, ips, OperationTimeout, nil, pace)
+}
+
+// scanThrough probes ips through path on conn and waits for the replies
+// until they all came or timeout, counting them in probes. ARP has no room
+// for a token: a reply is only that of a probe when it comes within
+// arpReplyWindow of it, from an address still waiting for one.
+//
+// With pace the probes are sent at its rate, the addresses which didn't
+// reply are probed again up to its retries, and the replies and the drops
+// of conn, when it has statistics, adapt the rate. The probes are then timed
+// with the clock of pace rather than the system one.
+func scanThrough(ctx context.Context, conn ProbeConn, src netip.Addr, path []ethernet.Tag, ips []netip.Addr,
+	timeout time.Duration, probes *probeCounter, pace *AdaptiveRate) (map[netip.Addr]net.HardwareAddr, error) {
+	result := make(map[netip.Addr]net.HardwareAddr, len(ips))
+	pending := make([]netip.Addr, 0, len(ips))
+
+	if !src.Is4() {
+		src = netip.IPv4Unspecified()
+	}
+
+	mac := make(net.HardwareAddr, 6)
+	if ifi := conn.Interface(); ifi != nil && len(ifi.HardwareAddr) == 6 {
+		mac = ifi.HardwareAddr
+	}
+
+	for _, ip := range ips {
+		result[ip] = nil
+
+		if ip.Is4() {
+			pending = append(pending, ip)
+		}
+	}
+
+	attempts := 1
+
+	var clk clock.Clock = clock.System{}
+
+	if pace != nil {
+		attempts += pace.retries()
+		clk = pace.clock
+
+		if stats, ok := conn.(capture.StatsSource); ok {
+			pace.watch(stats)
+		}
+	}
+
+	sweep := arpSweep{conn: conn, clock: clk, src: src, mac: mac, path: path, probes: probes, pace: pace,
+		result: result, queue: make(map[netip.Addr]time.Time, len(pending))}
+
+	for attempt := range attempts {
+		if len(pending) == 0 {
+			break
+		}
+
+		sent, err := sweep.round(ctx, pending, timeout, attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if !sent || ctx.Err() != nil {
+			break
+		}
+
+		// the addresses are probed again in the order of ips
+		pending = slices.DeleteFunc(pending, func(ip netip.Addr) bool {
+			_, ok := sweep.queue[ip]
+			return !ok
+		})
+	}
+
+	return result, nil
+}
+
+// arpSweep is a scan of scanThrough, the addresses it waits for a reply
+// from being queued with the time of their last probe
+type arpSweep struct {
+	conn   ProbeConn
+	clock  clock.Clock
+	probes *probeCounter
+	pace   *AdaptiveRate
+	result map[netip.Addr]net.HardwareAddr
+	queue  map[netip.Addr]time.Time
+	src    netip.Addr
+	mac    net.HardwareAddr
+	path   []ethernet.Tag
+	// mu guards result, queue and sending, the replies being read while
+	// the probes are sent
+	mu      sync.Mutex
+	sending bool
+}
+
+// round probes ips and reads the replies as they come, until every queued
+// address replied, timeout elapsed on the clock of s after the last probe or
+// ctx is done, retried telling that the probes are retries. It returns false
+// when ctx was done before every probe was sent.
+//
+// The replies are read while the probes are sent, rather than once they all
+// were, so that the time they are read at is that they came at.
+func (s *arpSweep) round(ctx context.Context, ips []netip.Addr, timeout time.Duration, retried bool) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stop := capture.InterruptReads(ctx, s.conn)
+	defer stop()
+
+	// the interruption of the reads of a round doesn't outlive it
+	defer s.conn.SetReadDeadline(time.Time{}) //nolint:errcheck // the next reads fail too if it does
+
+	s.sending = true
+	read := make(chan error, 1)
+
+	go func() {
+		read <- s.receive(ctx, retried)
+	}()
+
+	sent, err := s.send(ctx, ips)
+
+	s.mu.Lock()
+	s.sending = false
+	replied := len(s.queue) == 0
+	s.mu.Unlock()
+
+	if err != nil || !sent || replied {
+		cancel()
+		<-read
+
+		return sent, err
+	}
+
+	timer := s.clock.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-read:
+		return true, err
+	case <-timer.C():
+	case <-ctx.Done():
+	}
+
+	cancel()
+
+	return true, <-read
+}
+
+// send probes ips, it returns false when ctx was done before they all were
+func (s *arpSweep) send(ctx context.Context, ips []netip.Addr) (bool, error) {
+	for _, ip := range ips {
+		if s.pace != nil {
+			if err := s.pace.Wait(ctx); err != nil {
+				return false, nil //nolint:nilerr // a cancelled scan returns what it found
+			}
+		}
+
+		frame, err := ethernet.NewFrame().Src(s.mac).Tags(s.path...).Padded().ARPRequest(s.src, ip).Build()
+		if err != nil {
+			return false, fmt.Errorf("failed building the probe of %s: %w", ip, err)
+		}
+
+		// queued before it is sent, its reply may be read first
+		s.mu.Lock()
+		s.queue[ip] = s.clock.Now()
+		s.mu.Unlock()
+
+		if err := s.conn.WriteFrame(frame); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// receive reads the replies until every queued address replied once the
+// probes are sent, or ctx is done, retried telling that the probes were
+// retries
+func (s *arpSweep) receive(ctx context.Context, retried bool) error {
+	buf := make([]byte, snapLen)
+
+	for {
+		md, err := capture.ReadFrameMetadata(s.conn, buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		ip, hw, ok := probeReply(buf[:md.CaptureLength], md, s.src, s.mac, s.path)
+		if !ok {
+			continue
+		}
+
+		if s.reply(ip, hw, retried) {
+			return nil
+		}
+	}
+}
+
+// reply counts the reply of ip, and returns true once every queued address
+// replied and the probes are sent. The timestamp of the frame may be that of
+// the NIC, the replies are timed on the clock the probes were.
+func (s *arpSweep) reply(ip netip.Addr, hw net.HardwareAddr, retried bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sent, queued := s.queue[ip]
+	if !queued || s.clock.Now().Sub(sent) >= arpReplyWindow {
+		s.probes.unsolicitedReply()
+		return false
+	}
+
+	s.probes.reply()
+
+	if s.pace != nil {
+		s.pace.Reply(retried)
+	}
+
+	s.result[ip] = hw
+	delete(s.queue, ip)
+
+	return !s.sending && len(s.queue) == 0
+}
+
+// probeReply returns the sender of the ARP reply frame, when it answers
+// a probe sent from src and mac through path
+func probeReply(frame []byte, md capture.Metadata, src netip.Addr, mac net.HardwareAddr,
+	path []ethernet.Tag) (netip.Addr, net.HardwareAddr, bool) {
+	var eth ethernet.EthernetFrame
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return netip.Addr{}, nil, false
+	}
+
+	var stack [ethernet.MaxTags + 1]ethernet.Tag
+
+	tags := stack[:0]
+
+	// the tag the NIC stripped was the outermost
+	if md.VLAN.Valid {
+		tags = append(tags, strippedTag(md.VLAN))
+	}
+
+	tags, ethType, _, err := eth.AppendTags(tags)
+	if err != nil || ethType != ethernet.EthernetTypeARP || !onPath(tags, path) {
+		return netip.Addr{}, nil, false
+	}
+
+	pkt, err := eth.ExtractARPPacket()
+	if err != nil || pkt.OpCode != ethernet.OpReply || pkt.TargetAddr() != src ||
+		!bytes.Equal(pkt.TgtHwAddr, mac) {
+		return netip.Addr{}, nil, false
+	}
+
+	return pkt.SenderAddr(), pkt.SendHwAddr, true
+}
+
+// onPath returns whether a reply carrying tags came back through path. The
+// networks differ in what they echo back: the whole stack, or only one of
+// its tags when the provider bridge pops the other. The TPIDs aren't
+// compared, provider bridges rewrite them.
+func onPath(tags, path []ethernet.Tag) bool {
+	switch {
+	case len(path) == 0:
+		return len(tags) == 0
+	case len(tags) == 1:
+		return tags[0].VID == path[0].VID || tags[0].VID == path[len(path)-1].VID
+	case len(tags) != len(path):
+		return false
+	}
+
+	for i := range tags {
+		if tags[i].VID != path[i].VID {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,498 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+func TestScanThrough(t *testing.T) {
+	t.Parallel()
+
+	src := netip.MustParseAddr("10.0.12.1")
+	target := netip.MustParseAddr("10.0.12.5")
+	qinq := []ethernet.Tag{{TPID: ethernet.EthernetTypeQinQ, VID: 100}, {VID: 12}}
+
+	reply := func(tb testing.TB, tags ...ethernet.Tag) []byte {
+		tb.Helper()
+
+		frame, err := ethernet.NewFrame().Src(testPXEClient).Dst(testRackMAC).Tags(tags...).Padded().
+			ARPReply(target, testRackMAC, src).Build()
+		require.NoError(tb, err)
+
+		return frame
+	}
+
+	testcases := map[string]struct {
+		replies [][]byte
+		path    []ethernet.Tag
+		found   bool
+	}{
+		"whole stack echoed": {
+			path:    qinq,
+			replies: [][]byte{reply(t, qinq...)},
+			found:   true,
+		},
+		"inner tag echoed": {
+			path:    qinq,
+			replies: [][]byte{reply(t, ethernet.Tag{VID: 12})},
+			found:   true,
+		},
+		"TPID rewritten": {
+			path:    qinq,
+			replies: [][]byte{reply(t, ethernet.Tag{TPID: ethernet.EthernetTypeQinQLegacy, VID: 100}, ethernet.Tag{VID: 12})},
+			found:   true,
+		},
+		"other path": {
+			path: qinq,
+			replies: [][]byte{
+				reply(t, ethernet.Tag{TPID: ethernet.EthernetTypeQinQ, VID: 200}, ethernet.Tag{VID: 12}),
+				reply(t),
+			},
+		},
+		"single tag": {
+			path:    []ethernet.Tag{{VID: 12}},
+			replies: [][]byte{reply(t, qinq...), reply(t, ethernet.Tag{VID: 12})},
+			found:   true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l := newWakeLink()
+
+			go func() {
+				<-l.sent
+
+				for _, frame := range tc.replies {
+					select {
+					case l.frames <- frame:
+					case <-l.interrupt:
+						return
+					}
+				}
+			}()
+
+			result, err := scanThrough(context.Background(), l, src, tc.path,
+				[]netip.Addr{target}, 100*time.Millisecond, nil, nil)
+			require.NoError(t, err)
+
+			if tc.found {
+				assert.Equal(t, map[netip.Addr]net.HardwareAddr{target: testPXEClient}, result)
+			} else {
+				assert.Equal(t, map[netip.Addr]net.HardwareAddr{target: nil}, result)
+			}
+		})
+	}
+}
+
+// lateLink is a wakeLink timestamping the frames delay after they are read,
+// as a NIC whose clock isn't that of the host
+type lateLink struct {
+	*wakeLink
+	delay time.Duration
+}
+
+func (l lateLink) ReadFrameMetadata(buf []byte) (capture.Metadata, error) {
+	md, err := l.wakeLink.ReadFrameMetadata(buf)
+	md.Timestamp = time.Now().Add(l.delay)
+
+	return md, err
+}
+
+func TestScanThroughUnsolicited(t *testing.T) {
+	t.Parallel()
+
+	src := netip.MustParseAddr("10.0.12.1")
+	target := netip.MustParseAddr("10.0.12.5")
+	other := netip.MustParseAddr("10.0.12.6")
+
+	reply := func(tb testing.TB, from netip.Addr, to netip.Addr, mac net.HardwareAddr) []byte {
+		tb.Helper()
+
+		frame, err := ethernet.NewFrame().Src(testPXEClient).Dst(mac).Padded().
+			ARPReply(from, mac, to).Build()
+		require.NoError(tb, err)
+
+		return frame
+	}
+
+	testcases := map[string]struct {
+		replies [][]byte
+		delay   time.Duration
+		found   bool
+		stats   ProbeStats
+	}{
+		"reply": {
+			replies: [][]byte{reply(t, target, src, testRackMAC)},
+			found:   true,
+			stats:   ProbeStats{Replies: 1},
+		},
+		"repeated reply": {
+			replies: [][]byte{reply(t, target, src, testRackMAC), reply(t, target, src, testRackMAC)},
+			found:   true,
+			stats:   ProbeStats{Replies: 1},
+		},
+		"address not probed": {
+			replies: [][]byte{reply(t, other, src, testRackMAC)},
+			stats:   ProbeStats{Unsolicited: 1},
+		},
+		"hardware timestamp": {
+			replies: [][]byte{reply(t, target, src, testRackMAC)},
+			delay:   time.Hour,
+			found:   true,
+			stats:   ProbeStats{Replies: 1},
+		},
+		"reply to another agent": {
+			replies: [][]byte{reply(t, target, netip.MustParseAddr("10.0.12.2"), testPXEClient)},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l := lateLink{wakeLink: newWakeLink(), delay: tc.delay}
+
+			go func() {
+				<-l.sent
+
+				for _, frame := range tc.replies {
+					select {
+					case l.frames <- frame:
+					case <-l.interrupt:
+						return
+					}
+				}
+			}()
+
+			var probes probeCounter
+
+			result, err := scanThrough(context.Background(), l, src, nil, []netip.Addr{target},
+				100*time.Millisecond, &probes, nil)
+			require.NoError(t, err)
+
+			if tc.found {
+				assert.Equal(t, testPXEClient, result[target])
+			} else {
+				assert.Nil(t, result[target])
+			}
+
+			// the replies after the first aren't waited for, those not read
+			// aren't counted
+			assert.Equal(t, tc.stats, probes.stats())
+		})
+	}
+}
+
+func TestScanThroughProbe(t *testing.T) {
+	t.Parallel()
+
+	l := newWakeLink()
+	path := []ethernet.Tag{{TPID: ethernet.EthernetTypeQinQ, VID: 100}, {VID: 12, Priority: 5}}
+	v6 := netip.MustParseAddr("fd00::5")
+
+	probes := make(chan []byte, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		probes <- <-l.sent
+
+		cancel()
+	}()
+
+	result, err := scanThrough(ctx, l, netip.Addr{}, path,
+		[]netip.Addr{netip.MustParseAddr("10.0.12.5"), v6}, time.Minute, nil, nil)
+	require.NoError(t, err)
+	assert.Contains(t, result, v6)
+
+	// the IPv6 target isn't probed
+	assert.Empty(t, l.sent)
+
+	var eth ethernet.EthernetFrame
+
+	require.NoError(t, eth.UnmarshalBinary(<-probes))
+	assert.Equal(t, testRackMAC, eth.SrcMAC)
+
+	tags, ethType, _, err := eth.AppendTags(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ethernet.EthernetTypeARP, ethType)
+	assert.Equal(t, []ethernet.Tag{
+		{TPID: ethernet.EthernetTypeQinQ, VID: 100},
+		{TPID: ethernet.EthernetTypeVLAN, VID: 12, Priority: 5},
+	}, tags)
+
+	pkt, err := eth.ExtractARPPacket()
+	require.NoError(t, err)
+	assert.Equal(t, netip.IPv4Unspecified(), pkt.SenderAddr())
+}
+
+func TestARPSweepClock(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1700000000, 0)
+	clk := clocktest.NewFake(start)
+	pace := NewAdaptiveRate(ScanRateConfig{Initial: 10, Ceiling: 10}, WithAdaptiveRateClock(clk))
+	targets := []netip.Addr{netip.MustParseAddr("10.0.12.5"), netip.MustParseAddr("10.0.12.6")}
+
+	l := newWakeLink()
+	sweep := arpSweep{conn: l, clock: pace.clock, src: netip.MustParseAddr("10.0.12.1"), mac: testRackMAC,
+		pace: pace, queue: make(map[netip.Addr]time.Time)}
+
+	sent := make(chan bool)
+
+	go func() {
+		ok, err := sweep.send(context.Background(), targets)
+		assert.NoError(t, err)
+
+		sent <- ok
+	}()
+
+	// the second probe waits for the limiter, on the clock of the scan
+	<-l.sent
+	clk.BlockUntil(1)
+	clk.Advance(100 * time.Millisecond)
+	<-l.sent
+
+	require.True(t, <-sent)
+	assert.Equal(t, map[netip.Addr]time.Time{
+		targets[0]: start,
+		targets[1]: start.Add(100 * time.Millisecond),
+	}, sweep.queue)
+}
+
+func TestARPSweepReplyWindow(t *testing.T) {
+	t.Parallel()
+
+	src := netip.MustParseAddr("10.0.12.1")
+	target := netip.MustParseAddr("10.0.12.5")
+
+	frame, err := ethernet.NewFrame().Src(testPXEClient).Dst(testRackMAC).Padded().
+		ARPReply(target, testRackMAC, src).Build()
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		delay time.Duration
+		found bool
+		stats ProbeStats
+	}{
+		"within the window": {
+			delay: arpReplyWindow - time.Nanosecond,
+			found: true,
+			stats: ProbeStats{Replies: 1},
+		},
+		"after the window": {
+			delay: arpReplyWindow,
+			stats: ProbeStats{Unsolicited: 1},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clk := clocktest.NewFake(time.Unix(1700000000, 0))
+			l := newWakeLink()
+
+			var probes probeCounter
+
+			sweep := arpSweep{conn: l, clock: clk, src: src, mac: testRackMAC, probes: &probes,
+				result: map[netip.Addr]net.HardwareAddr{target: nil}, queue: make(map[netip.Addr]time.Time)}
+
+			done := make(chan error)
+
+			go func() {
+				_, err := sweep.round(context.Background(), []netip.Addr{target}, time.Minute, false)
+				done <- err
+			}()
+
+			// the round waits on its timeout once the probe is sent
+			<-l.sent
+			clk.BlockUntil(1)
+			clk.Advance(tc.delay)
+
+			l.frames <- frame
+
+			// the reply wasn't that of the probe, the sweep waits for its
+			// timeout
+			if !tc.found {
+				clk.Advance(time.Minute - tc.delay)
+			}
+
+			require.NoError(t, <-done)
+
+			if tc.found {
+				assert.Equal(t, testPXEClient, sweep.result[target])
+			} else {
+				assert.Nil(t, sweep.result[target])
+			}
+
+			assert.Equal(t, tc.stats, probes.stats())
+		})
+	}
+}
+
+func TestARPSweepReadsWhileSending(t *testing.T) {
+	t.Parallel()
+
+	src := netip.MustParseAddr("10.0.12.1")
+	targets := []netip.Addr{netip.MustParseAddr("10.0.12.5"), netip.MustParseAddr("10.0.12.6")}
+
+	reply := func(tb testing.TB, from netip.Addr) []byte {
+		tb.Helper()
+
+		frame, err := ethernet.NewFrame().Src(testPXEClient).Dst(testRackMAC).Padded().
+			ARPReply(from, testRackMAC, src).Build()
+		require.NoError(tb, err)
+
+		return frame
+	}
+
+	// a probe every 2s, longer than the reply window
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	pace := NewAdaptiveRate(ScanRateConfig{Initial: 0.5, Floor: 0.5, Ceiling: 0.5}, WithAdaptiveRateClock(clk))
+
+	l := newWakeLink()
+
+	var probes probeCounter
+
+	sweep := arpSweep{conn: l, clock: clk, src: src, mac: testRackMAC, probes: &probes, pace: pace,
+		result: map[netip.Addr]net.HardwareAddr{targets[0]: nil, targets[1]: nil},
+		queue:  make(map[netip.Addr]time.Time)}
+
+	done := make(chan error)
+
+	go func() {
+		_, err := sweep.round(context.Background(), targets, time.Minute, false)
+		done <- err
+	}()
+
+	<-l.sent
+
+	first := reply(t, targets[0])
+
+	go func() { l.frames <- first }()
+
+	// the first reply is read while the second probe waits for its turn
+	assert.Eventually(t, func() bool { return probes.stats().Replies == 1 }, 5*time.Second, time.Millisecond)
+
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Second)
+	<-l.sent
+
+	l.frames <- reply(t, targets[1])
+
+	require.NoError(t, <-done)
+	assert.Equal(t, ProbeStats{Replies: 2}, probes.stats())
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{targets[0]: testPXEClient, targets[1]: testPXEClient}, sweep.result)
+}
+
+func TestProbeReply(t *testing.T) {
+	t.Parallel()
+
+	src := netip.MustParseAddr("10.0.12.1")
+	target := netip.MustParseAddr("10.0.12.5")
+	path := []ethernet.Tag{{TPID: ethernet.EthernetTypeQinQ, VID: 100}, {VID: 12}}
+
+	// the NIC stripped the outer tag
+	frame, err := ethernet.NewFrame().Src(testPXEClient).Tags(ethernet.Tag{VID: 12}).
+		ARPReply(target, testRackMAC, src).Build()
+	require.NoError(t, err)
+
+	md := capture.Metadata{VLAN: capture.VLANInfo{TCI: 100, Valid: true}}
+
+	ip, hw, ok := probeReply(frame, md, src, testRackMAC, path)
+	require.True(t, ok)
+	assert.Equal(t, target, ip)
+	assert.Equal(t, testPXEClient, hw)
+
+	// a reply to another sender isn't one to the probe
+	_, _, ok = probeReply(frame, md, netip.MustParseAddr("10.0.12.2"), testRackMAC, path)
+	assert.False(t, ok)
+}
+
+func TestOnPath(t *testing.T) {
+	t.Parallel()
+
+	qinq := []ethernet.Tag{{TPID: ethernet.EthernetTypeQinQ, VID: 100}, {VID: 12}}
+
+	testcases := map[string]struct {
+		tags []ethernet.Tag
+		path []ethernet.Tag
+		ok   bool
+	}{
+		"untagged": {
+			ok: true,
+		},
+		"tagged reply to untagged": {
+			tags: []ethernet.Tag{{VID: 12}},
+		},
+		"outer": {
+			tags: []ethernet.Tag{{VID: 100}},
+			path: qinq,
+			ok:   true,
+		},
+		"inner": {
+			tags: []ethernet.Tag{{VID: 12}},
+			path: qinq,
+			ok:   true,
+		},
+		"other": {
+			tags: []ethernet.Tag{{VID: 13}},
+			path: qinq,
+		},
+		"swapped": {
+			tags: []ethernet.Tag{{VID: 12}, {VID: 100}},
+			path: qinq,
+		},
+		"deeper": {
+			tags: []ethernet.Tag{{VID: 100}, {VID: 12}, {VID: 7}},
+			path: qinq,
+		},
+		"untagged reply": {
+			path: qinq,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.ok, onPath(tc.tags, tc.path))
+		})
+	}
+}
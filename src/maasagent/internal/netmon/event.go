@@ -33,25 +33,113 @@ const (
 	// EventMoved is the Event value for a Result where the IP has
 	// changed its MAC address
 	EventMoved
+	// EventDuplicateMACLocation is the Event value for a Result where
+	// the MAC was seen on more than one interface
+	EventDuplicateMACLocation
+	// EventBindingViolation is the Event value for a Result where the IP
+	// was seen with another MAC than the one asserted for it
+	EventBindingViolation
+	// EventDADConflict is the Event value for a Result where a host probing
+	// an IPv6 address found it in use by another one
+	EventDADConflict
+	// EventPortAuthenticationSuspected is the Event value for a Result where
+	// the DHCP DISCOVERs of a segment go unanswered while an 802.1X
+	// authenticator asks for the identity of its supplicants
+	EventPortAuthenticationSuspected
+	// EventPortAuthenticationCleared is the Event value for a Result where
+	// the DISCOVERs of such a segment are answered again
+	EventPortAuthenticationCleared
+	// EventCustomLayer is the Event value for a Result where a frame was
+	// decoded by a protocol registered with the ethernet package
+	EventCustomLayer
+	// EventResponderSuspended is the Event value for a Result where a
+	// Responder stopped answering for an address another host claims
+	EventResponderSuspended
+	// EventCriticalHostUnresponsive is the Event value for a Result where a
+	// critical host missed the probes of a CriticalHostMonitor in a row
+	EventCriticalHostUnresponsive
+	// EventCriticalHostRecovered is the Event value for a Result where such
+	// a host answers again
+	EventCriticalHostRecovered
+	// EventUpstreamPortChanged is the Event value for a Result where an
+	// interface was advertised another switch port than before, it was
+	// recabled
+	EventUpstreamPortChanged
+	// EventAddressTheft is the Event value for a Result where another MAC
+	// claims an address of the host, see SelfAddressMonitor
+	EventAddressTheft
+	// EventAnnouncementUndelivered is the Event value for a Result where
+	// the announcements of an address of the host stopped being captured
+	// back
+	EventAnnouncementUndelivered
+	// EventNativeVLANMismatch is the Event value for a Result where the
+	// switch advertises another native VLAN than the one configured for the
+	// interface
+	EventNativeVLANMismatch
+	// EventModeChanged is the Event value for a Result where the agent was
+	// promoted to active or demoted to standby, see TransmitGate
+	EventModeChanged
 )
 
 const (
-	eventNewStr       = "NEW"
-	eventRefreshedStr = "REFRESHED"
-	eventMovedStr     = "MOVED"
+	eventNewStr                  = "NEW"
+	eventRefreshedStr            = "REFRESHED"
+	eventMovedStr                = "MOVED"
+	eventDuplicateMACLocationStr = "DUPLICATE_MAC_LOCATION"
+	eventBindingViolationStr     = "BINDING_VIOLATION"
+	eventDADConflictStr          = "DAD_CONFLICT"
+	eventPortAuthSuspectedStr    = "PORT_AUTHENTICATION_SUSPECTED"
+	eventPortAuthClearedStr      = "PORT_AUTHENTICATION_CLEARED"
+	eventCustomLayerStr          = "CUSTOM_LAYER"
+	eventResponderSuspendedStr   = "RESPONDER_SUSPENDED"
+	eventCriticalUnresponsiveStr = "CRITICAL_HOST_UNRESPONSIVE"
+	eventCriticalRecoveredStr    = "CRITICAL_HOST_RECOVERED"
+	eventUpstreamPortChangedStr  = "UPSTREAM_PORT_CHANGED"
+	eventAddressTheftStr         = "ADDRESS_THEFT"
+	eventAnnouncementLostStr     = "ANNOUNCEMENT_UNDELIVERED"
+	eventNativeVLANMismatchStr   = "NATIVE_VLAN_MISMATCH"
+	eventModeChangedStr          = "MODE_CHANGED"
 )
 
 var (
 	eventToString = map[Event]string{
-		EventNew:       eventNewStr,
-		EventRefreshed: eventRefreshedStr,
-		EventMoved:     eventMovedStr,
+		EventNew:                         eventNewStr,
+		EventRefreshed:                   eventRefreshedStr,
+		EventMoved:                       eventMovedStr,
+		EventDuplicateMACLocation:        eventDuplicateMACLocationStr,
+		EventBindingViolation:            eventBindingViolationStr,
+		EventDADConflict:                 eventDADConflictStr,
+		EventPortAuthenticationSuspected: eventPortAuthSuspectedStr,
+		EventPortAuthenticationCleared:   eventPortAuthClearedStr,
+		EventCustomLayer:                 eventCustomLayerStr,
+		EventResponderSuspended:          eventResponderSuspendedStr,
+		EventCriticalHostUnresponsive:    eventCriticalUnresponsiveStr,
+		EventCriticalHostRecovered:       eventCriticalRecoveredStr,
+		EventUpstreamPortChanged:         eventUpstreamPortChangedStr,
+		EventAddressTheft:                eventAddressTheftStr,
+		EventAnnouncementUndelivered:     eventAnnouncementLostStr,
+		EventNativeVLANMismatch:          eventNativeVLANMismatchStr,
+		EventModeChanged:                 eventModeChangedStr,
 	}
 
 	stringToEvent = map[string]Event{
-		eventNewStr:       EventNew,
-		eventRefreshedStr: EventRefreshed,
-		eventMovedStr:     EventMoved,
+		eventNewStr:                  EventNew,
+		eventRefreshedStr:            EventRefreshed,
+		eventMovedStr:                EventMoved,
+		eventDuplicateMACLocationStr: EventDuplicateMACLocation,
+		eventBindingViolationStr:     EventBindingViolation,
+		eventDADConflictStr:          EventDADConflict,
+		eventPortAuthSuspectedStr:    EventPortAuthenticationSuspected,
+		eventPortAuthClearedStr:      EventPortAuthenticationCleared,
+		eventCustomLayerStr:          EventCustomLayer,
+		eventResponderSuspendedStr:   EventResponderSuspended,
+		eventCriticalUnresponsiveStr: EventCriticalHostUnresponsive,
+		eventCriticalRecoveredStr:    EventCriticalHostRecovered,
+		eventUpstreamPortChangedStr:  EventUpstreamPortChanged,
+		eventAddressTheftStr:         EventAddressTheft,
+		eventAnnouncementLostStr:     EventAnnouncementUndelivered,
+		eventNativeVLANMismatchStr:   EventNativeVLANMismatch,
+		eventModeChangedStr:          EventModeChanged,
 	}
 )
 
@@ -113,3 +201,26 @@ func (e *Event) UnmarshalJSON(b []byte) error {
 
 	return nil
 }
+
+// MarshalText implements encoding.TextMarshaler for Event, which lets
+// Events key a JSON object
+func (e Event) MarshalText() ([]byte, error) {
+	str, err := e.ValidString()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(str), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for Event
+func (e *Event) UnmarshalText(b []byte) error {
+	event, ok := stringToEvent[string(b)]
+	if !ok {
+		return fmt.Errorf("%w string: %s", errInvalidEvent, b)
+	}
+
+	*e = event
+
+	return nil
+}
@@ -20,6 +20,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEventString(t *testing.T) {
@@ -42,6 +43,18 @@ func TestEventString(t *testing.T) {
 			in:  EventMoved,
 			out: eventMovedStr,
 		},
+		"event duplicate MAC location": {
+			in:  EventDuplicateMACLocation,
+			out: eventDuplicateMACLocationStr,
+		},
+		"event binding violation": {
+			in:  EventBindingViolation,
+			out: eventBindingViolationStr,
+		},
+		"event DAD conflict": {
+			in:  EventDADConflict,
+			out: eventDADConflictStr,
+		},
 		"unknown": {
 			in:  Event(0xff),
 			out: "UNKNOWN",
@@ -145,3 +158,18 @@ func TestEventUnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestEventMapKey(t *testing.T) {
+	t.Parallel()
+
+	b, err := json.Marshal(map[Event]int{EventMoved: 1, EventDADConflict: 2})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"MOVED": 1, "DAD_CONFLICT": 2}`, string(b))
+
+	var out map[Event]int
+
+	require.NoError(t, json.Unmarshal(b, &out))
+	assert.Equal(t, map[Event]int{EventMoved: 1, EventDADConflict: 2}, out)
+
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"MISSING": 1}`), &out), errInvalidEvent)
+}
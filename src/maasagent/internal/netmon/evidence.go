@@ -0,0 +1,249 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const (
+	defaultEvidenceDepth = 8
+	// defaultEvidenceKeys bounds the IPs and the MACs with a history, the
+	// least recently observed ones are forgotten first so a flood of
+	// spoofed addresses can't grow the log
+	defaultEvidenceKeys = 1024
+)
+
+// Evidence is a single observation of a binding
+type Evidence struct {
+	// VID is the VLAN ID of the frame, if it had one
+	VID       *uint16 `json:"vid"`
+	Interface string  `json:"interface"`
+	IP        string  `json:"ip"`
+	MAC       string  `json:"mac"`
+	// Frame is the kind of observation the binding was seen in, such as
+	// arp_request, arp_reply or dhcp_ack
+	Frame string `json:"frame"`
+	Time  int64  `json:"time"`
+}
+
+// ResultEvidence is the recent history attached to a Result
+type ResultEvidence struct {
+	// IP holds the observations of the IP of the Result
+	IP []Evidence `json:"ip,omitempty"`
+	// MAC holds the observations of the MAC of the Result
+	MAC []Evidence `json:"mac,omitempty"`
+	// PreviousMAC holds the observations of the MAC an EventMoved
+	// replaced
+	PreviousMAC []Evidence `json:"previous_mac,omitempty"`
+}
+
+// observation is the compact form of Evidence kept in the log
+type observation struct {
+	time  time.Time
+	ip    netip.Addr
+	vid   *uint16
+	iface string
+	mac   [6]byte
+	kind  ObservationKind
+}
+
+// evidenceRing holds the latest observations of a key
+type evidenceRing struct {
+	entries []observation
+	next    int
+	full    bool
+}
+
+func (r *evidenceRing) add(o observation) {
+	r.entries[r.next] = o
+	r.next = (r.next + 1) % len(r.entries)
+
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the observations, oldest first
+func (r *evidenceRing) list() []Evidence {
+	var out []Evidence
+
+	if r.full {
+		out = make([]Evidence, 0, len(r.entries))
+
+		for _, o := range r.entries[r.next:] {
+			out = append(out, o.evidence())
+		}
+	}
+
+	for _, o := range r.entries[:r.next] {
+		out = append(out, o.evidence())
+	}
+
+	return out
+}
+
+func (o observation) evidence() Evidence {
+	e := Evidence{
+		Interface: o.iface,
+		IP:        o.ip.String(),
+		MAC:       net.HardwareAddr(o.mac[:]).String(),
+		Frame:     o.kind.String(),
+		Time:      o.time.Unix(),
+	}
+
+	// the Result may be modified by the consumer
+	if o.vid != nil {
+		vid := *o.vid
+		e.VID = &vid
+	}
+
+	return e
+}
+
+// EvidenceLog keeps the latest observations of every IP and MAC, so the
+// events of a binding changing can carry the frames that led to them. It
+// can be shared by the Services of every monitored interface. It keeps
+// copies of the addresses it records, never slices of a capture buffer.
+type EvidenceLog struct {
+	byIP      *lru.Cache[netip.Addr, *evidenceRing]
+	byMAC     *lru.Cache[[6]byte, *evidenceRing]
+	depth     int
+	keys      int
+	evictions uint64
+	mu        sync.Mutex
+}
+
+// EvidenceLogOption configures an EvidenceLog
+type EvidenceLogOption func(*EvidenceLog)
+
+// WithEvidenceDepth sets the number of observations kept per IP and MAC
+func WithEvidenceDepth(depth int) EvidenceLogOption {
+	return func(l *EvidenceLog) {
+		if depth > 0 {
+			l.depth = depth
+		}
+	}
+}
+
+// WithEvidenceKeys sets the number of IPs, and the number of MACs, the
+// log keeps a history for
+func WithEvidenceKeys(keys int) EvidenceLogOption {
+	return func(l *EvidenceLog) {
+		if keys > 0 {
+			l.keys = keys
+		}
+	}
+}
+
+// WithEvidenceLimits sets the number of keys from l, as WithEvidenceKeys
+func WithEvidenceLimits(l Limits) EvidenceLogOption {
+	return WithEvidenceKeys(l.EvidenceKeys)
+}
+
+// NewEvidenceLog returns an empty EvidenceLog
+func NewEvidenceLog(options ...EvidenceLogOption) *EvidenceLog {
+	l := &EvidenceLog{
+		depth: defaultEvidenceDepth,
+		keys:  defaultEvidenceKeys,
+	}
+
+	for _, opt := range options {
+		opt(l)
+	}
+
+	// lru.NewWithEvict only fails for a non-positive size, and the caches
+	// only evict in record, which holds l.mu
+	l.byIP, _ = lru.NewWithEvict(l.keys, func(netip.Addr, *evidenceRing) { l.evictions++ })
+	l.byMAC, _ = lru.NewWithEvict(l.keys, func([6]byte, *evidenceRing) { l.evictions++ })
+
+	return l
+}
+
+func (l *EvidenceLog) record(b Binding, iface string) {
+	if len(b.MAC) != 6 {
+		return
+	}
+
+	o := observation{
+		time:  b.Time,
+		ip:    b.IP.Unmap(),
+		vid:   b.VID,
+		iface: iface,
+		mac:   [6]byte(b.MAC),
+		kind:  b.Kind,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ipRing, ok := l.byIP.Get(o.ip)
+	if !ok {
+		ipRing = &evidenceRing{entries: make([]observation, l.depth)}
+		l.byIP.Add(o.ip, ipRing)
+	}
+
+	macRing, ok := l.byMAC.Get(o.mac)
+	if !ok {
+		macRing = &evidenceRing{entries: make([]observation, l.depth)}
+		l.byMAC.Add(o.mac, macRing)
+	}
+
+	ipRing.add(o)
+	macRing.add(o)
+}
+
+// ByIP returns the latest observations of ip, oldest first
+func (l *EvidenceLog) ByIP(ip netip.Addr) []Evidence {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if r, ok := l.byIP.Peek(ip.Unmap()); ok {
+		return r.list()
+	}
+
+	return nil
+}
+
+// ByMAC returns the latest observations of mac, oldest first
+func (l *EvidenceLog) ByMAC(mac net.HardwareAddr) []Evidence {
+	if len(mac) != 6 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if r, ok := l.byMAC.Peek([6]byte(mac)); ok {
+		return r.list()
+	}
+
+	return nil
+}
+
+// Evictions returns the number of IPs and MACs whose history was forgotten
+// for new ones
+func (l *EvidenceLog) Evictions() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.evictions
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+func TestEvidenceLog(t *testing.T) {
+	t.Parallel()
+
+	l := NewEvidenceLog(WithEvidenceDepth(3))
+	ip := netip.MustParseAddr("10.0.0.1")
+	mac := mustParseMAC("00:16:3e:00:00:01")
+
+	for i := range 5 {
+		l.record(Binding{
+			IP:   ip,
+			MAC:  mac,
+			VID:  uint16Pointer(uint16(i)), //nolint:gosec // small test values
+			Time: time.Unix(1700000000+int64(i), 0),
+			Kind: ObservationARPReply,
+		}, "eth0")
+	}
+
+	l.record(Binding{
+		IP:   netip.MustParseAddr("10.0.0.2"),
+		MAC:  mac,
+		Time: time.Unix(1700000010, 0),
+	}, "eth1")
+
+	assert.Equal(t, []Evidence{
+		{VID: uint16Pointer(2), Interface: "eth0", IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Frame: "arp_reply", Time: 1700000002},
+		{VID: uint16Pointer(3), Interface: "eth0", IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Frame: "arp_reply", Time: 1700000003},
+		{VID: uint16Pointer(4), Interface: "eth0", IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Frame: "arp_reply", Time: 1700000004},
+	}, l.ByIP(ip))
+	assert.Equal(t, []Evidence{
+		{VID: uint16Pointer(3), Interface: "eth0", IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Frame: "arp_reply", Time: 1700000003},
+		{VID: uint16Pointer(4), Interface: "eth0", IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Frame: "arp_reply", Time: 1700000004},
+		{Interface: "eth1", IP: "10.0.0.2", MAC: "00:16:3e:00:00:01", Frame: "arp_request", Time: 1700000010},
+	}, l.ByMAC(mac))
+	assert.Nil(t, l.ByIP(netip.MustParseAddr("10.0.0.3")))
+	assert.Nil(t, l.ByMAC(mustParseMAC("00:16:3e:00:00:02")))
+}
+
+func TestEvidenceLogBounds(t *testing.T) {
+	t.Parallel()
+
+	l := NewEvidenceLog(WithEvidenceDepth(2), WithEvidenceKeys(4))
+	first := netip.MustParseAddr("10.0.0.0")
+
+	// a spoofer cycling through addresses only keeps the latest ones
+	for i := range 256 {
+		l.record(Binding{
+			IP:   netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}),
+			MAC:  mustParseMAC("00:16:3e:00:00:01"),
+			Time: time.Unix(1700000000, 0),
+		}, "eth0")
+	}
+
+	assert.Equal(t, 4, l.byIP.Len())
+	assert.Equal(t, 1, l.byMAC.Len())
+	assert.Equal(t, uint64(252), l.Evictions())
+	assert.Nil(t, l.ByIP(first))
+	assert.Len(t, l.ByIP(netip.MustParseAddr("10.0.0.255")), 1)
+	assert.Len(t, l.ByMAC(mustParseMAC("00:16:3e:00:00:01")), 2)
+}
+
+func TestServiceEvidence(t *testing.T) {
+	t.Parallel()
+
+	s := NewService("eth0", WithEvidenceLog(NewEvidenceLog()))
+	timestamp := time.Unix(1700000000, 0)
+
+	pkt := testARPPacket()
+	pkt.SendHwAddr = mustParseMAC("00:16:3e:00:00:01")
+
+	res := s.updateBindings(pkt, nil, timestamp)
+	require.Len(t, res, 1)
+	assert.Nil(t, res[0].Evidence)
+
+	pkt.SendHwAddr = mustParseMAC("00:16:3e:00:00:02")
+
+	res = s.updateBindings(pkt, nil, timestamp.Add(time.Second))
+	require.Len(t, res, 1)
+	assert.Equal(t, EventMoved, res[0].Event)
+	assert.Equal(t, &ResultEvidence{
+		IP: []Evidence{
+			{Interface: "eth0", IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Frame: "arp_request", Time: 1700000000},
+			{Interface: "eth0", IP: "10.0.0.1", MAC: "00:16:3e:00:00:02", Frame: "arp_request", Time: 1700000001},
+		},
+		MAC: []Evidence{
+			{Interface: "eth0", IP: "10.0.0.1", MAC: "00:16:3e:00:00:02", Frame: "arp_request", Time: 1700000001},
+		},
+		PreviousMAC: []Evidence{
+			{Interface: "eth0", IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Frame: "arp_request", Time: 1700000000},
+		},
+	}, res[0].Evidence)
+}
+
+func TestResultEvidenceJSON(t *testing.T) {
+	t.Parallel()
+
+	pkt := testARPPacket()
+	pkt.SendHwAddr = mustParseMAC("00:16:3e:00:00:01")
+
+	s := NewService("eth0")
+	s.updateBindings(pkt, nil, time.Unix(1700000000, 0))
+
+	pkt.SendHwAddr = mustParseMAC("00:16:3e:00:00:02")
+	res := s.updateBindings(pkt, nil, time.Unix(1700000001, 0))
+	require.Len(t, res, 1)
+
+	// the evidence is only serialised by a Service with an EvidenceLog
+	b, err := json.Marshal(res[0])
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "evidence")
+}
+
+func TestServiceDuplicateMACEvidence(t *testing.T) {
+	t.Parallel()
+
+	frame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x08, 0x06, 0x00, 0x01,
+		0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0xc0, 0xa8, 0x0a, 0x1a,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19,
+	}
+	md := capture.Metadata{Timestamp: time.Unix(1700000000, 0), Direction: capture.DirectionInbound}
+
+	d, l := NewDuplicateMACDetector(), NewEvidenceLog()
+	eth1 := NewService("eth1", WithDuplicateMACDetector(d), WithEvidenceLog(l))
+	eth2 := NewService("eth2", WithDuplicateMACDetector(d), WithEvidenceLog(l))
+
+	_, err := eth1.handleFrame(frame, md)
+	require.NoError(t, err)
+
+	// the history is the one of the MAC when the duplicate is correlated
+	res, err := eth2.handleFrame(frame, md)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, EventDuplicateMACLocation, res[0].Event)
+	require.NotNil(t, res[0].Evidence)
+	assert.Equal(t, []Evidence{
+		{Interface: "eth1", IP: "192.168.10.26", MAC: "84:39:c0:0b:22:25", Frame: "arp_request", Time: 1700000000},
+	}, res[0].Evidence.MAC)
+	assert.Nil(t, res[1].Evidence)
+}
+
+func TestServiceReorderedDuplicateMACEvidence(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	d, l := NewDuplicateMACDetector(), NewEvidenceLog()
+	r := NewReorderer(WithReorderClock(clk))
+	eth1 := NewService("eth1", WithDuplicateMACDetector(d), WithEvidenceLog(l), WithReorderer(r))
+	eth2 := NewService("eth2", WithDuplicateMACDetector(d), WithEvidenceLog(l), WithReorderer(r))
+
+	request := func(src string, ip string) []byte {
+		return buildFrame(t, ethernet.NewFrame().Src(mustParseMAC(src)).Padded().
+			ARPRequest(netip.MustParseAddr(ip), netip.MustParseAddr("10.0.0.1")), nil)
+	}
+
+	_, err := eth1.handleFrame(request("00:16:3e:00:00:01", "10.0.0.10"), capture.Metadata{Timestamp: clk.Now()})
+	require.NoError(t, err)
+
+	_, err = eth2.handleFrame(request("00:16:3e:00:00:01", "10.0.0.10"),
+		capture.Metadata{Timestamp: clk.Now().Add(time.Millisecond)})
+	require.NoError(t, err)
+
+	// the frame of another MAC releasing the duplicate doesn't lend it its
+	// history
+	res, err := eth1.handleFrame(request("00:16:3e:00:00:02", "10.0.0.20"),
+		capture.Metadata{Timestamp: clk.Now().Add(time.Second)})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, EventDuplicateMACLocation, res[0].Event)
+	require.NotNil(t, res[0].Evidence)
+	require.Len(t, res[0].Evidence.MAC, 2)
+
+	for _, e := range res[0].Evidence.MAC {
+		assert.Equal(t, "00:16:3e:00:00:01", e.MAC)
+	}
+
+	assert.Equal(t, "10.0.0.20", res[1].IP)
+	assert.Nil(t, res[1].Evidence)
+}
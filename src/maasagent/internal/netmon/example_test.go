@@ -0,0 +1,230 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+// toyEtherType is a local experimental ethertype of IEEE 802
+const toyEtherType ethernet.EthernetType = 0x88b6
+
+// Toy is the layer of a toy protocol, a version followed by a greeting
+type Toy struct {
+	Greeting string `json:"greeting"`
+	Version  uint8  `json:"version"`
+}
+
+func (*Toy) LayerName() string { return "toy" }
+
+func decodeToy(payload []byte) (ethernet.Layer, error) {
+	if len(payload) < 2 {
+		return nil, errors.New("truncated toy")
+	}
+
+	// the frame is padded to the minimum ethernet length
+	greeting, _, _ := bytes.Cut(payload[1:], []byte{0})
+
+	return &Toy{Version: payload[0], Greeting: string(greeting)}, nil
+}
+
+// the protocols are registered before any pipeline is created
+func init() {
+	if err := ethernet.RegisterEtherType(toyEtherType, decodeToy); err != nil {
+		panic(err)
+	}
+}
+
+// A frame of a registered protocol reaches the handler of the Results with
+// the layer its decoder returned
+func ExampleService_Serve_registeredProtocol() {
+	frame, err := ethernet.NewFrame().
+		Src([]byte{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}).
+		Dst([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}).
+		Payload(toyEtherType, append([]byte{1}, "hello"...)).
+		Build()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// a recording of the frame, as a capture would produce it
+	var recording bytes.Buffer
+
+	w, err := capture.NewPcapWriter(&recording, 65535)
+	if err == nil {
+		err = w.WriteFrame(frame, capture.Metadata{Timestamp: time.Unix(1700000000, 0), Length: len(frame)})
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	r, err := capture.NewPcapReader(&recording, "eth0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// the registered protocols are decoded when asked for
+	svc := netmon.NewService("eth0", netmon.WithDecoders(netmon.DefaultDecoders|netmon.DecoderRegistered))
+	resultC := make(chan netmon.Result)
+
+	go func() {
+		if err := svc.Serve(context.Background(), r, resultC); err != nil {
+			fmt.Println(err)
+		}
+	}()
+
+	for res := range resultC {
+		if toy, ok := res.Layer.(*Toy); ok {
+			fmt.Println(res.Event, res.MAC, toy.LayerName(), toy.Version, toy.Greeting)
+		}
+
+		line, err := json.Marshal(res)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println(string(line))
+	}
+	// Output:
+	// CUSTOM_LAYER 00:16:3e:00:00:01 toy 1 hello
+	// {"vid":null,"layer":{"greeting":"hello","version":1},"ip":"","mac":"00:16:3e:00:00:01","time":1700000000,"event":"CUSTOM_LAYER"}
+}
+
+// The stages of the pipeline are labeled in the CPU profiles, such as those
+// of the debug server, and timed into a histogram with WithStageTiming.
+//
+// A profile is broken down by interface and stage with
+//
+//	go tool pprof -tags cpu.pprof
+//
+// which lists, for each label, the share of the samples carrying it:
+//
+//	netmon.interface: Total 2.1s
+//	                  1.6s (76.19%): eth0
+//	                  0.5s (23.81%): br0
+//
+//	netmon.stage: Total 2.1s
+//	              1.2s (57.14%): observe
+//	              0.5s (23.81%): decode
+//	              ...
+//
+// A stage is then looked into on its own, here the decoding of the frames
+// of eth0:
+//
+//	go tool pprof -tagfocus netmon.interface=eth0 -tagfocus netmon.stage=decode -top cpu.pprof
+//
+// The capture stage is mostly spent waiting in the kernel, off the CPU, so a
+// capture stage heavy in a CPU profile points to the cost of the reads
+// themselves.
+func ExampleWithStageTiming() {
+	frame, err := ethernet.NewFrame().
+		Src([]byte{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}).
+		ARPRequest(netip.MustParseAddr("10.0.0.10"), netip.MustParseAddr("10.0.0.1")).
+		Build()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var recording bytes.Buffer
+
+	w, err := capture.NewPcapWriter(&recording, 65535)
+	if err == nil {
+		err = w.WriteFrame(frame, capture.Metadata{Timestamp: time.Unix(1700000000, 0), Length: len(frame)})
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	r, err := capture.NewPcapReader(&recording, "eth0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	svc := netmon.NewService("eth0", netmon.WithStageTiming(provider.Meter("example")))
+	resultC := make(chan netmon.Result)
+
+	go func() {
+		if err := svc.Serve(context.Background(), r, resultC); err != nil {
+			fmt.Println(err)
+		}
+	}()
+
+	for res := range resultC {
+		fmt.Println(res.Event, res.IP)
+	}
+
+	var rm metricdata.ResourceMetrics
+
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// the durations vary, the stages the frame went through don't
+	var stages []string
+
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			hist, ok := m.Data.(metricdata.Histogram[float64])
+			if !ok {
+				continue
+			}
+
+			for _, dp := range hist.DataPoints {
+				stage, _ := dp.Attributes.Value("stage")
+				stages = append(stages, fmt.Sprintf("%s %s: %d frame", m.Name, stage.AsString(), dp.Count))
+			}
+		}
+	}
+
+	slices.Sort(stages)
+
+	for _, s := range stages {
+		fmt.Println(s)
+	}
+	// Output:
+	// NEW 10.0.0.10
+	// netmon.pipeline.duration decode: 1 frame
+	// netmon.pipeline.duration dispatch: 1 frame
+	// netmon.pipeline.duration filter: 1 frame
+	// netmon.pipeline.duration observe: 1 frame
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"testing"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+func FuzzHandleFrame(f *testing.F) {
+	request := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x08, 0x06, 0x00, 0x01,
+		0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0xc0, 0xa8, 0x0a, 0x1a,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19,
+	}
+	tagged := append(append(append([]byte(nil), request[:12]...), 0x81, 0x00, 0x00, 0x02), request[12:]...)
+
+	f.Add(request)
+	f.Add(tagged)
+	f.Add(tagged[:18])
+
+	clock := clocktest.NewFake(time.Unix(1700000000, 0))
+	md := capture.Metadata{Direction: capture.DirectionInbound}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		d := NewDuplicateMACDetector(WithDuplicateClock(clock))
+		svc := NewService("eth0", WithClock(clock), WithDuplicateMACDetector(d), WithEvidenceLog(NewEvidenceLog()))
+
+		fuzz.Bounded(t, in, func() {
+			_, _ = svc.handleFrame(in, md) //nolint:errcheck // only panics matter
+		})
+	})
+}
@@ -0,0 +1,548 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"cmp"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHistoryRetention keeps a month of history, what a support
+	// case usually looks back on
+	defaultHistoryRetention = 30 * 24 * time.Hour
+	// defaultHistoryTransitions bounds the transitions kept whatever their
+	// age, about 64 MiB of them
+	defaultHistoryTransitions = 1 << 20
+	// historySegments is the number of segments the transitions are split
+	// into at most by size, retention drops a whole segment at once
+	historySegments = 16
+	// historySegmentSpan is the time a segment covers at most
+	historySegmentSpan = time.Hour
+	// historyResolution is the width of the smallest bucket of an
+	// ActivityTimeline
+	historyResolution = time.Minute
+)
+
+// BindingSpan is the time an IP was bound to a MAC, from the transition
+// binding them to the one replacing the MAC
+type BindingSpan struct {
+	// VID is the VLAN ID of the binding, if it had one
+	VID       *uint16 `json:"vid"`
+	Interface string  `json:"interface"`
+	IP        string  `json:"ip"`
+	MAC       string  `json:"mac"`
+	From      int64   `json:"from"`
+	// To is when another MAC took the IP over, zero while the binding is
+	// the latest the history knows of
+	To int64 `json:"to,omitempty"`
+}
+
+// ActivityBucket counts the events of a segment during a bucket of an
+// ActivityTimeline
+type ActivityBucket struct {
+	// Events holds the number of each Event, by name
+	Events map[string]int `json:"events"`
+	Start  int64          `json:"start"`
+	Total  int            `json:"total"`
+}
+
+// historyKey identifies the bindings of an IP, as a Service keys them, on
+// an interface
+type historyKey struct {
+	ip     netip.Addr
+	iface  string
+	vid    uint16
+	tagged bool
+}
+
+// transition is a binding transition kept in the history
+type transition struct {
+	key  historyKey
+	time int64
+	mac  [6]byte
+	prev [6]byte
+}
+
+// activityKey identifies the segment an event was observed on
+type activityKey struct {
+	iface  string
+	vid    uint16
+	tagged bool
+}
+
+// eventCounts counts the events of a historyResolution, by Event
+type eventCounts [EventModeChanged + 1]uint32
+
+// historySegment holds the transitions and the activity recorded over a
+// span of time, indexed by IP and by MAC
+type historySegment struct {
+	byIP        map[netip.Addr][]int32
+	byMAC       map[[6]byte][]int32
+	activity    map[activityKey]map[int64]*eventCounts
+	transitions []transition
+	start       int64
+	minTime     int64
+	maxTime     int64
+}
+
+func newHistorySegment(t int64) *historySegment {
+	return &historySegment{
+		byIP:     make(map[netip.Addr][]int32),
+		byMAC:    make(map[[6]byte][]int32),
+		activity: make(map[activityKey]map[int64]*eventCounts),
+		start:    t - t%int64(historySegmentSpan/time.Second),
+		minTime:  t,
+		maxTime:  t,
+	}
+}
+
+func (seg *historySegment) add(tr transition) {
+	i := int32(len(seg.transitions)) //nolint:gosec // a segment holds fewer transitions than the history bound
+	seg.transitions = append(seg.transitions, tr)
+	seg.byIP[tr.key.ip] = append(seg.byIP[tr.key.ip], i)
+	seg.byMAC[tr.mac] = append(seg.byMAC[tr.mac], i)
+
+	if tr.prev != tr.mac && tr.prev != [6]byte{} {
+		seg.byMAC[tr.prev] = append(seg.byMAC[tr.prev], i)
+	}
+}
+
+func (seg *historySegment) count(key activityKey, t int64, e Event) {
+	minutes, ok := seg.activity[key]
+	if !ok {
+		minutes = make(map[int64]*eventCounts)
+		seg.activity[key] = minutes
+	}
+
+	slot := t - t%int64(historyResolution/time.Second)
+
+	counts, ok := minutes[slot]
+	if !ok {
+		counts = &eventCounts{}
+		minutes[slot] = counts
+	}
+
+	counts[e]++
+}
+
+// History records the binding transitions and the events reported by the
+// Services sharing it, so that the bindings of an IP or a MAC can be looked
+// up at a time past, rather than only now as a Snapshot does. It keeps
+// their activity counted per minute.
+//
+// A segment of the history is dropped once older than the retention, or
+// when the transitions exceed their bound. The latest transition of each
+// binding dropped is kept, so an IP bound long ago and never moved is still
+// found.
+type History struct {
+	// base holds the latest transition of each binding whose segment was
+	// dropped, bounded like the bindings of a Service
+	base        map[historyKey]transition
+	segments    []*historySegment
+	retention   time.Duration
+	transitions int
+	total       int
+	maxBase     int
+	newest      int64
+	mu          sync.Mutex
+}
+
+// HistoryOption configures a History
+type HistoryOption func(*History)
+
+// WithHistoryRetention sets the age past which the history is dropped
+func WithHistoryRetention(d time.Duration) HistoryOption {
+	return func(h *History) {
+		if d > 0 {
+			h.retention = d
+		}
+	}
+}
+
+// WithHistoryTransitions bounds the transitions kept, the oldest are
+// dropped first whatever their age
+func WithHistoryTransitions(n int) HistoryOption {
+	return func(h *History) {
+		if n > 0 {
+			h.transitions = n
+		}
+	}
+}
+
+// WithHistoryLimits bounds the bindings whose latest transition outlives
+// its segment to those of a Service with l
+func WithHistoryLimits(l Limits) HistoryOption {
+	return func(h *History) {
+		if l.Bindings > 0 {
+			h.maxBase = l.Bindings
+		}
+	}
+}
+
+// NewHistory returns an empty History
+func NewHistory(options ...HistoryOption) *History {
+	h := &History{
+		base:        make(map[historyKey]transition),
+		retention:   defaultHistoryRetention,
+		transitions: defaultHistoryTransitions,
+		maxBase:     defaultBindings,
+	}
+
+	for _, opt := range options {
+		opt(h)
+	}
+
+	return h
+}
+
+// record adds the results reported on iface, their events are counted and
+// the transitions of their bindings kept
+func (h *History) record(iface string, res []Result) {
+	if len(res) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range res {
+		key := activityKey{iface: iface}
+		if r.VID != nil {
+			key.vid, key.tagged = *r.VID, true
+		}
+
+		seg := h.segment(r.Time)
+
+		if int(r.Event) < len(eventCounts{}) {
+			seg.count(key, r.Time, r.Event)
+		}
+
+		if r.Event != EventNew && r.Event != EventMoved {
+			continue
+		}
+
+		tr, ok := parseTransition(key, r)
+		if !ok {
+			continue
+		}
+
+		seg.add(tr)
+		h.total++
+	}
+
+	h.enforce()
+}
+
+func parseTransition(key activityKey, r Result) (transition, bool) {
+	ip, err := netip.ParseAddr(r.IP)
+	if err != nil {
+		return transition{}, false
+	}
+
+	mac, err := net.ParseMAC(r.MAC)
+	if err != nil || len(mac) != 6 {
+		return transition{}, false
+	}
+
+	tr := transition{
+		key:  historyKey{ip: ip.Unmap(), iface: key.iface, vid: key.vid, tagged: key.tagged},
+		time: r.Time,
+		mac:  [6]byte(mac),
+	}
+
+	if prev, err := net.ParseMAC(r.PreviousMAC); err == nil && len(prev) == 6 {
+		tr.prev = [6]byte(prev)
+	}
+
+	return tr, true
+}
+
+// segment returns the segment recording t, the current one unless t is
+// past its span or the current one is full. h.mu must be held.
+func (h *History) segment(t int64) *historySegment {
+	h.newest = max(h.newest, t)
+
+	if len(h.segments) > 0 {
+		seg := h.segments[len(h.segments)-1]
+
+		// a result timestamped before the current segment, such as one of
+		// another interface, is kept in it rather than reopening an older
+		// one
+		if t < seg.start+int64(historySegmentSpan/time.Second) &&
+			len(seg.transitions) < max(h.transitions/historySegments, 1) {
+			seg.minTime = min(seg.minTime, t)
+			seg.maxTime = max(seg.maxTime, t)
+
+			return seg
+		}
+	}
+
+	seg := newHistorySegment(t)
+	h.segments = append(h.segments, seg)
+
+	return seg
+}
+
+// enforce drops the segments past the retention, and the oldest ones while
+// the transitions exceed their bound. h.mu must be held.
+func (h *History) enforce() {
+	oldest := h.newest - int64(h.retention/time.Second)
+
+	drop := 0
+
+	for i, seg := range h.segments[:len(h.segments)-1] {
+		if seg.maxTime >= oldest && h.total <= h.transitions {
+			break
+		}
+
+		h.total -= len(seg.transitions)
+		drop = i + 1
+	}
+
+	if drop == 0 {
+		return
+	}
+
+	for _, seg := range h.segments[:drop] {
+		for _, tr := range seg.transitions {
+			if b, ok := h.base[tr.key]; !ok || b.time <= tr.time {
+				h.base[tr.key] = tr
+			}
+		}
+	}
+
+	for len(h.base) > h.maxBase {
+		evictOldest(h.base, func(tr transition) int64 {
+			return tr.time
+		})
+	}
+
+	clear(h.segments[:drop])
+	h.segments = h.segments[drop:]
+}
+
+// BindingsForIP returns the spans of the bindings of ip overlapping the
+// time from from to to, on every interface and VLAN, oldest first
+func (h *History) BindingsForIP(ip netip.Addr, from, to time.Time) []BindingSpan {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.spans(ip.Unmap(), from.Unix(), to.Unix(), nil)
+}
+
+// BindingsForMAC returns the spans of the bindings of mac overlapping the
+// time from from to to, on every interface and VLAN, oldest first
+func (h *History) BindingsForMAC(mac net.HardwareAddr, from, to time.Time) []BindingSpan {
+	if len(mac) != 6 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hw, end := [6]byte(mac), to.Unix()
+
+	// the IPs bound to mac before to, the spans of each tell when
+	var ips []netip.Addr
+
+	for key, tr := range h.base {
+		if tr.mac == hw && tr.time <= end {
+			ips = append(ips, key.ip)
+		}
+	}
+
+	for _, seg := range h.segments {
+		if seg.minTime > end {
+			continue
+		}
+
+		for _, i := range seg.byMAC[hw] {
+			if tr := seg.transitions[i]; tr.time <= end {
+				ips = append(ips, tr.key.ip)
+			}
+		}
+	}
+
+	slices.SortFunc(ips, netip.Addr.Compare)
+
+	var out []BindingSpan
+
+	for _, ip := range slices.Compact(ips) {
+		out = append(out, h.spans(ip, from.Unix(), end, &hw)...)
+	}
+
+	slices.SortStableFunc(out, compareBindingSpans)
+
+	return out
+}
+
+// spans returns the spans of the bindings of ip overlapping from to to,
+// only those of mac unless nil. h.mu must be held.
+func (h *History) spans(ip netip.Addr, from, to int64, mac *[6]byte) []BindingSpan {
+	var trs []transition
+
+	for key, tr := range h.base {
+		if key.ip == ip {
+			trs = append(trs, tr)
+		}
+	}
+
+	// the transitions after to are needed too, for when the spans end
+	for _, seg := range h.segments {
+		for _, i := range seg.byIP[ip] {
+			trs = append(trs, seg.transitions[i])
+		}
+	}
+
+	// the transitions of each binding in the order they happened, those of
+	// a segment were kept in the order they were reported
+	slices.SortStableFunc(trs, func(a, b transition) int {
+		return cmp.Or(
+			cmp.Compare(a.key.iface, b.key.iface),
+			cmp.Compare(a.key.vidOrder(), b.key.vidOrder()),
+			cmp.Compare(a.time, b.time),
+		)
+	})
+
+	var out []BindingSpan
+
+	for i := 0; i < len(trs); {
+		tr := trs[i]
+
+		// the binding is renewed rather than replaced by a transition to
+		// the same MAC, such as it being learned again once evicted
+		j := i + 1
+		for j < len(trs) && trs[j].key == tr.key && trs[j].mac == tr.mac {
+			j++
+		}
+
+		var end int64
+		if j < len(trs) && trs[j].key == tr.key {
+			end = trs[j].time
+		}
+
+		i = j
+
+		if tr.time > to || (end != 0 && end < from) || (mac != nil && tr.mac != *mac) {
+			continue
+		}
+
+		span := BindingSpan{
+			Interface: tr.key.iface,
+			IP:        tr.key.ip.String(),
+			MAC:       net.HardwareAddr(tr.mac[:]).String(),
+			From:      tr.time,
+			To:        end,
+		}
+
+		if tr.key.tagged {
+			vid := tr.key.vid
+			span.VID = &vid
+		}
+
+		out = append(out, span)
+	}
+
+	slices.SortStableFunc(out, compareBindingSpans)
+
+	return out
+}
+
+func (k historyKey) vidOrder() int {
+	if !k.tagged {
+		return -1
+	}
+
+	return int(k.vid)
+}
+
+func compareBindingSpans(a, b BindingSpan) int {
+	return cmp.Or(cmp.Compare(a.From, b.From), cmp.Compare(a.Interface, b.Interface))
+}
+
+// ActivityTimeline returns the events observed on iface, and the VLAN vid
+// or the untagged frames when nil, from from to to in buckets of bucket,
+// which is at least a minute. Only the buckets with events are returned,
+// oldest first.
+func (h *History) ActivityTimeline(iface string, vid *uint16, from, to time.Time,
+	bucket time.Duration) []ActivityBucket {
+	key := activityKey{iface: iface}
+	if vid != nil {
+		key.vid, key.tagged = *vid, true
+	}
+
+	resolution := int64(historyResolution / time.Second)
+	width := int64(max(bucket, historyResolution).Truncate(historyResolution) / time.Second)
+
+	// the buckets start with the minute of from
+	start, end := from.Unix(), to.Unix()
+	start -= start % resolution
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := make(map[int64]*eventCounts)
+
+	for _, seg := range h.segments {
+		if seg.maxTime < start || seg.minTime > end {
+			continue
+		}
+
+		for slot, counts := range seg.activity[key] {
+			if slot < start || slot > end {
+				continue
+			}
+
+			b := start + (slot-start)/width*width
+
+			sum, ok := buckets[b]
+			if !ok {
+				sum = &eventCounts{}
+				buckets[b] = sum
+			}
+
+			for e, n := range counts {
+				sum[e] += n
+			}
+		}
+	}
+
+	out := make([]ActivityBucket, 0, len(buckets))
+
+	for b, counts := range buckets {
+		ab := ActivityBucket{Start: b, Events: make(map[string]int)}
+
+		for e, n := range counts {
+			if n > 0 {
+				ab.Events[Event(e).String()] = int(n) //nolint:gosec // e indexes the events, an Event is a uint8
+				ab.Total += int(n)
+			}
+		}
+
+		out = append(out, ab)
+	}
+
+	slices.SortFunc(out, func(a, b ActivityBucket) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+
+	return out
+}
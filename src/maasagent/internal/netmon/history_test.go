@@ -0,0 +1,231 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	historyStart = time.Unix(1700000040, 0)
+	historyMAC1  = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	historyMAC2  = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+)
+
+func historyResult(event Event, ip string, mac, previous net.HardwareAddr, vid *uint16, at time.Duration) Result {
+	r := Result{
+		IP:    ip,
+		MAC:   mac.String(),
+		VID:   vid,
+		Time:  historyStart.Add(at).Unix(),
+		Event: event,
+	}
+
+	if previous != nil {
+		r.PreviousMAC = previous.String()
+	}
+
+	return r
+}
+
+func historySpan(ip string, mac net.HardwareAddr, vid *uint16, from, to time.Duration) BindingSpan {
+	s := BindingSpan{
+		Interface: "eth0",
+		IP:        ip,
+		MAC:       mac.String(),
+		VID:       vid,
+		From:      historyStart.Add(from).Unix(),
+	}
+
+	if to != 0 {
+		s.To = historyStart.Add(to).Unix()
+	}
+
+	return s
+}
+
+func TestHistoryBindings(t *testing.T) {
+	t.Parallel()
+
+	vid := uint16(10)
+
+	h := NewHistory()
+	h.record("eth0", []Result{
+		historyResult(EventNew, "10.0.0.1", historyMAC1, nil, nil, 0),
+		historyResult(EventRefreshed, "10.0.0.1", historyMAC1, nil, nil, 10*time.Minute),
+		historyResult(EventNew, "10.0.0.1", historyMAC1, nil, &vid, 20*time.Minute),
+		historyResult(EventMoved, "10.0.0.1", historyMAC2, historyMAC1, nil, time.Hour),
+		// learned again once evicted, the binding goes on
+		historyResult(EventNew, "10.0.0.1", historyMAC2, nil, nil, 2*time.Hour),
+		historyResult(EventNew, "10.0.0.2", historyMAC1, nil, nil, 3*time.Hour),
+	})
+
+	at := func(d time.Duration) time.Time {
+		return historyStart.Add(d)
+	}
+
+	testcases := map[string]struct {
+		query func() []BindingSpan
+		out   []BindingSpan
+	}{
+		"IP before it moved": {
+			query: func() []BindingSpan {
+				return h.BindingsForIP(netip.MustParseAddr("10.0.0.1"), at(5*time.Minute), at(10*time.Minute))
+			},
+			out: []BindingSpan{historySpan("10.0.0.1", historyMAC1, nil, 0, time.Hour)},
+		},
+		"IP across the move": {
+			query: func() []BindingSpan {
+				return h.BindingsForIP(netip.MustParseAddr("10.0.0.1"), at(30*time.Minute), at(90*time.Minute))
+			},
+			out: []BindingSpan{
+				historySpan("10.0.0.1", historyMAC1, nil, 0, time.Hour),
+				historySpan("10.0.0.1", historyMAC1, &vid, 20*time.Minute, 0),
+				historySpan("10.0.0.1", historyMAC2, nil, time.Hour, 0),
+			},
+		},
+		"IPv4-mapped IP": {
+			query: func() []BindingSpan {
+				return h.BindingsForIP(netip.MustParseAddr("::ffff:10.0.0.2"), at(0), at(4*time.Hour))
+			},
+			out: []BindingSpan{historySpan("10.0.0.2", historyMAC1, nil, 3*time.Hour, 0)},
+		},
+		"IP before it was seen": {
+			query: func() []BindingSpan {
+				return h.BindingsForIP(netip.MustParseAddr("10.0.0.2"), at(0), at(time.Hour))
+			},
+		},
+		"MAC": {
+			query: func() []BindingSpan {
+				return h.BindingsForMAC(historyMAC1, at(2*time.Hour), at(4*time.Hour))
+			},
+			out: []BindingSpan{
+				historySpan("10.0.0.1", historyMAC1, &vid, 20*time.Minute, 0),
+				historySpan("10.0.0.2", historyMAC1, nil, 3*time.Hour, 0),
+			},
+		},
+		"previous MAC": {
+			query: func() []BindingSpan {
+				return h.BindingsForMAC(historyMAC2, at(0), at(30*time.Minute))
+			},
+		},
+		"invalid MAC": {
+			query: func() []BindingSpan {
+				return h.BindingsForMAC(net.HardwareAddr{0x00}, at(0), at(4*time.Hour))
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.query())
+		})
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	t.Parallel()
+
+	t.Run("by age", func(t *testing.T) {
+		t.Parallel()
+
+		h := NewHistory(WithHistoryRetention(2 * time.Hour))
+		h.record("eth0", []Result{
+			historyResult(EventNew, "10.0.0.1", historyMAC1, nil, nil, 0),
+			historyResult(EventNew, "10.0.0.2", historyMAC2, nil, nil, 10*time.Minute),
+			historyResult(EventMoved, "10.0.0.2", historyMAC1, historyMAC2, nil, 20*time.Minute),
+		})
+		h.record("eth0", []Result{historyResult(EventNew, "10.0.0.3", historyMAC2, nil, nil, 4*time.Hour)})
+
+		assert.Len(t, h.segments, 1)
+		assert.Empty(t, h.ActivityTimeline("eth0", nil, historyStart, historyStart.Add(time.Hour), time.Hour))
+
+		// the latest transition of a dropped binding is still known
+		assert.Equal(t, []BindingSpan{historySpan("10.0.0.1", historyMAC1, nil, 0, 0)},
+			h.BindingsForIP(netip.MustParseAddr("10.0.0.1"), historyStart.Add(3*time.Hour),
+				historyStart.Add(5*time.Hour)))
+		assert.Equal(t, []BindingSpan{historySpan("10.0.0.2", historyMAC1, nil, 20*time.Minute, 0)},
+			h.BindingsForIP(netip.MustParseAddr("10.0.0.2"), historyStart, historyStart.Add(5*time.Hour)))
+	})
+
+	t.Run("by size", func(t *testing.T) {
+		t.Parallel()
+
+		h := NewHistory(WithHistoryTransitions(32), WithHistoryLimits(Limits{Bindings: 8}))
+
+		for i := range 100 {
+			ip := netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}).String()
+			h.record("eth0", []Result{historyResult(EventNew, ip, historyMAC1, nil, nil, time.Duration(i)*time.Second)})
+		}
+
+		assert.LessOrEqual(t, h.total, 32)
+		assert.LessOrEqual(t, len(h.base), 8)
+
+		// the newest bindings are kept
+		assert.Len(t, h.BindingsForMAC(historyMAC1, historyStart, historyStart.Add(time.Hour)), h.total+len(h.base))
+		assert.Len(t, h.BindingsForIP(netip.MustParseAddr("10.0.0.99"), historyStart, historyStart.Add(time.Hour)), 1)
+	})
+}
+
+func TestHistoryActivityTimeline(t *testing.T) {
+	t.Parallel()
+
+	vid := uint16(10)
+
+	h := NewHistory()
+	h.record("eth0", []Result{
+		historyResult(EventNew, "10.0.0.1", historyMAC1, nil, nil, 30*time.Second),
+		historyResult(EventRefreshed, "10.0.0.1", historyMAC1, nil, nil, 2*time.Minute),
+		historyResult(EventMoved, "10.0.0.1", historyMAC2, historyMAC1, nil, 3*time.Minute),
+		historyResult(EventNew, "10.0.0.2", historyMAC1, nil, &vid, 4*time.Minute),
+		{MAC: historyMAC1.String(), Time: historyStart.Add(7 * time.Minute).Unix(), Event: EventDuplicateMACLocation},
+	})
+	h.record("eth1", []Result{historyResult(EventNew, "10.0.0.3", historyMAC1, nil, nil, time.Minute)})
+
+	assert.Equal(t, []ActivityBucket{
+		{Start: historyStart.Unix(), Events: map[string]int{"NEW": 1, "REFRESHED": 1, "MOVED": 1}, Total: 3},
+		{Start: historyStart.Add(5 * time.Minute).Unix(), Events: map[string]int{"DUPLICATE_MAC_LOCATION": 1}, Total: 1},
+	}, h.ActivityTimeline("eth0", nil, historyStart, historyStart.Add(time.Hour), 5*time.Minute))
+
+	assert.Equal(t, []ActivityBucket{
+		{Start: historyStart.Add(4 * time.Minute).Unix(), Events: map[string]int{"NEW": 1}, Total: 1},
+	}, h.ActivityTimeline("eth0", &vid, historyStart.Add(4*time.Minute), historyStart.Add(4*time.Minute), 0))
+
+	assert.Empty(t, h.ActivityTimeline("eth0", nil, historyStart.Add(8*time.Minute), historyStart.Add(time.Hour), time.Hour))
+}
+
+func TestServiceHistory(t *testing.T) {
+	t.Parallel()
+
+	h := NewHistory()
+	svc := NewService("eth0", WithHistory(h))
+
+	svc.Observe(ObservationDHCPAck, netip.MustParseAddr("10.0.0.1"), historyMAC1, nil, historyStart)
+
+	spans := h.BindingsForIP(netip.MustParseAddr("10.0.0.1"), historyStart, historyStart)
+	require.Len(t, spans, 1)
+	assert.Equal(t, historySpan("10.0.0.1", historyMAC1, nil, 0, 0), spans[0])
+}
@@ -0,0 +1,423 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	// defaultHostCheckFreshness is how old a binding answering a check may
+	// be, a host seen that recently is taken to be up
+	defaultHostCheckFreshness = 30 * time.Second
+	defaultHostCheckTimeout   = arpReplyWindow
+	defaultHostCheckUnicast   = 2
+	defaultHostCheckBroadcast = 2
+)
+
+// ErrHostCheckDisabled is returned by Service.CheckHost for a Service
+// without a HostChecker
+var ErrHostCheckDisabled = errors.New("host checks not enabled")
+
+// HostCheck is the outcome of checking a host is up
+type HostCheck struct {
+	VID       *uint16 `json:"vid"`
+	Interface string  `json:"interface"`
+	IP        string  `json:"ip"`
+	// MAC is the MAC which answered, or that of the binding for a cached
+	// answer, empty if the host is down
+	MAC string `json:"mac,omitempty"`
+	// Expected is the MAC the host was expected to answer with, if any,
+	// and Matched is set when MAC is that one
+	Expected string `json:"expected,omitempty"`
+	// Age is how old the binding of a cached answer is, and Latency the
+	// time the host took to answer a probe, both in seconds
+	Age     float64 `json:"age,omitempty"`
+	Latency float64 `json:"latency,omitempty"`
+	// Unicast and Broadcast are the numbers of probes sent to the MAC of
+	// the host and to every host
+	Unicast   int  `json:"unicast"`
+	Broadcast int  `json:"broadcast"`
+	Alive     bool `json:"alive"`
+	Matched   bool `json:"matched"`
+	// Cached is set when the answer is the binding of the neighbor table,
+	// no probe was sent
+	Cached bool `json:"cached"`
+}
+
+// answered records mac answering for the host
+func (c *HostCheck) answered(mac, expected net.HardwareAddr) {
+	c.Alive = true
+	c.MAC = mac.String()
+	c.Matched = expected != nil && bytes.Equal(mac, expected)
+}
+
+// hostCheckKey is a host checked on an interface
+type hostCheckKey struct {
+	iface string
+	bindingKey
+}
+
+// hostAnswer is an answer of a checked host
+type hostAnswer struct {
+	at  time.Time
+	mac net.HardwareAddr
+}
+
+// HostChecker tells whether a single host is up on the segment of a
+// Service, cheaply: a binding of the neighbor table fresh enough answers
+// without any probe. The host is probed otherwise, with ARP requests or
+// neighbor solicitations sent to its MAC first, the one expected or that
+// of a stale binding, so the other hosts aren't bothered, then to every
+// host. The first answer ends the check.
+//
+// The probes are sent and the answers read through the capture of the
+// Service, a HostChecker may be shared by the Services of several
+// interfaces and checks many hosts at once. The IPv4 probes are RFC 5227
+// probes, which don't update the ARP cache of the hosts, and the IPv6 ones
+// are sent from the link-local address of the interface, unless
+// WithHostCheckSource sets other addresses.
+type HostChecker struct {
+	clock   clock.Clock
+	limiter *ProbeLimiter
+	// waiters are the answers of the hosts being checked, several checks
+	// of a host wait together
+	waiters   map[hostCheckKey]map[chan hostAnswer]struct{}
+	src4      netip.Addr
+	src6      netip.Addr
+	freshness time.Duration
+	timeout   time.Duration
+	unicast   int
+	broadcast int
+	mu        sync.Mutex
+}
+
+// HostCheckOption configures a HostChecker
+type HostCheckOption func(*HostChecker)
+
+// WithHostCheckFreshness sets how old a binding may be to answer a check
+// without probing, 0 always probes
+func WithHostCheckFreshness(d time.Duration) HostCheckOption {
+	return func(c *HostChecker) {
+		if d >= 0 {
+			c.freshness = d
+		}
+	}
+}
+
+// WithHostCheckProbes sets the number of probes sent to the MAC of the host
+// and then to every host, at most. Without any unicast probe, the probes
+// are all broadcast; without any broadcast one, a host whose MAC isn't
+// known isn't probed.
+func WithHostCheckProbes(unicast, broadcast int) HostCheckOption {
+	return func(c *HostChecker) {
+		if unicast >= 0 && broadcast >= 0 && unicast+broadcast > 0 {
+			c.unicast, c.broadcast = unicast, broadcast
+		}
+	}
+}
+
+// WithHostCheckTimeout sets the time the answer to a probe is waited for,
+// before the next one is sent
+func WithHostCheckTimeout(d time.Duration) HostCheckOption {
+	return func(c *HostChecker) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// WithHostCheckSource sets the addresses the probes are sent from, one per
+// family, instead of the unspecified IPv4 address and the link-local IPv6
+// address of the interface
+func WithHostCheckSource(addrs ...netip.Addr) HostCheckOption {
+	return func(c *HostChecker) {
+		for _, addr := range addrs {
+			if addr.Is4() {
+				c.src4 = addr
+			} else if addr.Is6() {
+				c.src6 = addr
+			}
+		}
+	}
+}
+
+// WithHostCheckLimiter sets the limiter the probes wait for, so the checks
+// of many hosts are bounded together
+func WithHostCheckLimiter(l *ProbeLimiter) HostCheckOption {
+	return func(c *HostChecker) {
+		c.limiter = l
+	}
+}
+
+// WithHostCheckClock sets the clock timing the probes and timestamping
+// the answers captured without a timestamp
+func WithHostCheckClock(clk clock.Clock) HostCheckOption {
+	return func(c *HostChecker) {
+		c.clock = clk
+	}
+}
+
+// NewHostChecker returns a HostChecker
+func NewHostChecker(options ...HostCheckOption) *HostChecker {
+	c := &HostChecker{
+		clock:     clock.System{},
+		waiters:   make(map[hostCheckKey]map[chan hostAnswer]struct{}),
+		src4:      netip.IPv4Unspecified(),
+		freshness: defaultHostCheckFreshness,
+		timeout:   defaultHostCheckTimeout,
+		unicast:   defaultHostCheckUnicast,
+		broadcast: defaultHostCheckBroadcast,
+	}
+
+	for _, opt := range options {
+		opt(c)
+	}
+
+	if c.limiter == nil {
+		c.limiter = NewProbeLimiter(defaultProbeRate, defaultProbeBurst, WithProbeLimiterClock(c.clock))
+	}
+
+	return c
+}
+
+// CheckHost tells whether the host with ip is up on vid, nil for the
+// untagged frames, and whether it answers with expected, which may be nil.
+// A binding of the neighbor table fresh enough answers at once, the host is
+// probed through the capture otherwise, see HostChecker. A host which
+// doesn't answer isn't an error, the outcome tells it is down.
+//
+// It returns ErrHostCheckDisabled without a HostChecker, and, when probes
+// are needed, ErrNotCapturing unless Start runs or an error matching
+// ErrMissingCapability without CapabilityNetRaw. The outcome so far is
+// returned with the error of ctx. An ip or an expected MAC no host can have
+// returns an addrutil error.
+func (s *Service) CheckHost(ctx context.Context, vid *uint16, ip netip.Addr,
+	expected net.HardwareAddr) (HostCheck, error) {
+	check := HostCheck{Interface: s.iface, IP: ip.String()}
+
+	if vid != nil {
+		v := *vid
+		check.VID = &v
+	}
+
+	if s.checker == nil {
+		return check, ErrHostCheckDisabled
+	}
+
+	ip, err := addrutil.UnicastIP("ip", ip)
+	if err != nil {
+		return check, err
+	}
+
+	check.IP = ip.String()
+
+	if expected != nil {
+		if expected, err = addrutil.UnicastMAC("expected", expected); err != nil {
+			return check, err
+		}
+
+		check.Expected = expected.String()
+	}
+
+	b, known := s.table.lookup(mappingKey(ip, vid))
+
+	// a primed binding is what the host should be, it has to answer
+	if age := s.clock.Now().Sub(b.Time); known && b.Source != BindingSourcePrimed && age <= s.checker.freshness {
+		check.answered(b.MAC, expected)
+		check.Cached, check.Age = true, max(age, 0).Seconds()
+
+		return check, nil
+	}
+
+	if err := s.Capabilities().Err(CapabilityNetRaw); err != nil {
+		return check, err
+	}
+
+	s.targetMu.Lock()
+	conn := s.conn
+	s.targetMu.Unlock()
+
+	if conn == nil {
+		return check, ErrNotCapturing
+	}
+
+	// the expected MAC is where the host should be, a stale binding where
+	// it was last seen
+	dst := expected
+	if dst == nil && known {
+		dst = b.MAC
+	}
+
+	return s.checker.probe(ctx, s.writer(TransmitScan, conn), conn.Interface().HardwareAddr, check, ip, dst, expected)
+}
+
+// probe sends the probes of check through w from src until ip answers,
+// those to dst first when known
+func (c *HostChecker) probe(ctx context.Context, w capture.FrameWriter, src net.HardwareAddr, check HostCheck,
+	ip netip.Addr, dst, expected net.HardwareAddr) (HostCheck, error) {
+	if len(src) != 6 {
+		return check, fmt.Errorf("%w: source MAC %q", ethernet.ErrBuildFrame, src)
+	}
+
+	key := hostCheckKey{iface: check.Interface, bindingKey: mappingKey(ip, check.VID)}
+	answers := make(chan hostAnswer, 1)
+
+	c.wait(key, answers)
+	defer c.done(key, answers)
+
+	for n := range c.unicast + c.broadcast {
+		unicast := n < c.unicast
+		if unicast && dst == nil {
+			continue
+		}
+
+		frame, err := c.probeFrame(src, check.VID, ip, unicast, dst)
+		if err != nil {
+			return check, err
+		}
+
+		if err := c.limiter.Wait(ctx); err != nil {
+			return check, err
+		}
+
+		sent := c.clock.Now()
+
+		if err := w.WriteFrame(frame); err != nil {
+			return check, err
+		}
+
+		if unicast {
+			check.Unicast++
+		} else {
+			check.Broadcast++
+		}
+
+		timeout := c.clock.NewTimer(c.timeout)
+
+		select {
+		case <-ctx.Done():
+			timeout.Stop()
+			return check, ctx.Err()
+		case a := <-answers:
+			timeout.Stop()
+			check.answered(a.mac, expected)
+			check.Latency = max(a.at.Sub(sent), 0).Seconds()
+
+			return check, nil
+		case <-timeout.C():
+		}
+	}
+
+	return check, nil
+}
+
+// probeFrame returns a probe for ip from src, to dst if unicast
+func (c *HostChecker) probeFrame(src net.HardwareAddr, vid *uint16, ip netip.Addr, unicast bool,
+	dst net.HardwareAddr) ([]byte, error) {
+	b := ethernet.NewFrame().Src(src)
+	if unicast {
+		b = b.Dst(dst)
+	}
+
+	if vid != nil {
+		b = b.VLAN(*vid)
+	}
+
+	if ip.Is4() {
+		return b.Padded().ARPRequest(c.src4, ip).Build()
+	}
+
+	src6 := c.src6
+	if !src6.IsValid() {
+		src6 = linkLocal6(src)
+	}
+
+	return b.NeighborSolicitation(src6, ip).Build()
+}
+
+func (c *HostChecker) wait(key hostCheckKey, answers chan hostAnswer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.waiters[key] == nil {
+		c.waiters[key] = make(map[chan hostAnswer]struct{})
+	}
+
+	c.waiters[key][answers] = struct{}{}
+}
+
+func (c *HostChecker) done(key hostCheckKey, answers chan hostAnswer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.waiters[key], answers)
+
+	if len(c.waiters[key]) == 0 {
+		delete(c.waiters, key)
+	}
+}
+
+// Observe reads an ARP reply or a neighbor advertisement received on the
+// interface iface and vid, the answer of a host being checked ends its
+// checks
+func (c *HostChecker) Observe(iface string, frame []byte, vid *uint16, md capture.Metadata) {
+	c.mu.Lock()
+	idle := len(c.waiters) == 0
+	c.mu.Unlock()
+
+	if idle {
+		return
+	}
+
+	msg, ok := readNeighborMessage(frame)
+	if !ok || msg.target.IsValid() || msg.probe || len(msg.mac) != 6 {
+		return
+	}
+
+	// the answer is timed on the clock the probe was, unless the capture
+	// did it
+	timestamp := md.Timestamp
+	if timestamp.IsZero() || md.TimestampSource == capture.TimestampHardware {
+		timestamp = c.clock.Now()
+	}
+
+	answer := hostAnswer{at: timestamp, mac: slices.Clone(msg.mac)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for answers := range c.waiters[hostCheckKey{iface: iface, bindingKey: mappingKey(msg.addr, vid)}] {
+		// a check already answered keeps its first answer
+		select {
+		case answers <- answer:
+		default:
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+func newTestHostChecker(options ...HostCheckOption) (*HostChecker, *clocktest.Fake) {
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+
+	return NewHostChecker(append([]HostCheckOption{WithHostCheckClock(clk),
+		WithHostCheckLimiter(NewProbeLimiter(0, 0))}, options...)...), clk
+}
+
+func TestServiceCheckHost(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	c, _ := newTestHostChecker(WithHostCheckFreshness(time.Minute))
+	svc := NewService("eth0", WithClock(clk), WithHostChecker(c))
+	ctx := context.Background()
+
+	svc.Observe(ObservationARPReply, testGateway4, testGatewayMAC, vid10(), time.Time{})
+	clk.Advance(20 * time.Second)
+
+	// a fresh binding answers without probing
+	check, err := svc.CheckHost(ctx, vid10(), testGateway4, testGatewayMAC)
+	require.NoError(t, err)
+	assert.Equal(t, HostCheck{VID: vid10(), Interface: "eth0", IP: "10.0.0.254", MAC: testGatewayMAC.String(),
+		Expected: testGatewayMAC.String(), Age: 20, Alive: true, Matched: true, Cached: true}, check)
+
+	check, err = svc.CheckHost(ctx, vid10(), testGateway4, testPXEClient)
+	require.NoError(t, err)
+	assert.True(t, check.Alive)
+	assert.False(t, check.Matched)
+
+	// the others need the capture
+	clk.Advance(time.Minute)
+
+	_, err = svc.CheckHost(ctx, vid10(), testGateway4, nil)
+	assert.ErrorIs(t, err, ErrNotCapturing)
+
+	_, err = svc.CheckHost(ctx, nil, testGateway4, nil)
+	assert.ErrorIs(t, err, ErrNotCapturing)
+
+	_, err = svc.CheckHost(ctx, nil, netip.MustParseAddr("ff02::1"), nil)
+	assert.ErrorIs(t, err, addrutil.ErrInvalidIP)
+
+	_, err = svc.CheckHost(ctx, nil, testGateway4, ethernet.Broadcast)
+	assert.ErrorIs(t, err, addrutil.ErrInvalidMAC)
+
+	degraded := NewService("eth0", WithHostChecker(c), WithCapabilities(Capabilities{Degraded: []DegradedCapability{
+		{Capability: CapabilityNetRaw}}}))
+
+	_, err = degraded.CheckHost(ctx, nil, testGateway4, nil)
+	assert.ErrorIs(t, err, ErrMissingCapability)
+
+	_, err = NewService("eth0").CheckHost(ctx, nil, testGateway4, nil)
+	assert.ErrorIs(t, err, ErrHostCheckDisabled)
+}
+
+func TestHostCheckerProbe(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		ip       netip.Addr
+		dst      net.HardwareAddr
+		expected net.HardwareAddr
+		options  []HostCheckOption
+		// answer is the number of the probe the gateway answers, from 1, 0
+		// if none
+		answer int
+		check  HostCheck
+	}{
+		"unicast answer": {
+			ip:       testGateway4,
+			dst:      testGatewayMAC,
+			expected: testGatewayMAC,
+			answer:   1,
+			check:    HostCheck{MAC: testGatewayMAC.String(), Unicast: 1, Alive: true, Matched: true},
+		},
+		"broadcast answer of another MAC": {
+			ip:       testGateway4,
+			dst:      testPXEClient,
+			expected: testPXEClient,
+			answer:   3,
+			check:    HostCheck{MAC: testGatewayMAC.String(), Unicast: 2, Broadcast: 1, Alive: true},
+		},
+		"unknown MAC": {
+			ip:     testGateway6,
+			answer: 2,
+			check:  HostCheck{MAC: testGatewayMAC.String(), Broadcast: 2, Alive: true},
+		},
+		"broadcast only": {
+			ip:      testGateway4,
+			dst:     testGatewayMAC,
+			options: []HostCheckOption{WithHostCheckProbes(0, 1)},
+			check:   HostCheck{Broadcast: 1},
+		},
+		"down": {
+			ip:    testGateway4,
+			dst:   testGatewayMAC,
+			check: HostCheck{Unicast: 2, Broadcast: 2},
+		},
+		"unicast only, unknown MAC": {
+			ip:      testGateway4,
+			options: []HostCheckOption{WithHostCheckProbes(3, 0)},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c, clk := newTestHostChecker(tc.options...)
+
+			// the network answers the probe tc.answer, the others time out
+			var probes int
+
+			w := &responderWriter{written: func([]byte) {
+				probes++
+				if probes == tc.answer {
+					c.Observe("eth0", gatewayAnswer(t, tc.ip), vid10(), capture.Metadata{
+						Timestamp: clk.Now().Add(2 * time.Millisecond)})
+					return
+				}
+
+				go func() {
+					clk.BlockUntil(1)
+					clk.Advance(defaultHostCheckTimeout)
+				}()
+			}}
+
+			check, err := c.probe(context.Background(), w, testRackMAC, HostCheck{VID: vid10(), Interface: "eth0"},
+				tc.ip, tc.dst, tc.expected)
+			require.NoError(t, err)
+
+			tc.check.VID, tc.check.Interface = vid10(), "eth0"
+			if tc.check.Alive {
+				tc.check.Latency = 0.002
+			}
+
+			assert.Equal(t, tc.check, check)
+
+			// the unicast probes come first, tagged
+			for i, frame := range w.sent() {
+				var eth ethernet.EthernetFrame
+
+				require.NoError(t, eth.UnmarshalBinary(frame))
+				assert.Equal(t, testRackMAC, eth.SrcMAC)
+				assert.Equal(t, i < tc.check.Unicast, bytes.Equal(tc.dst, eth.DstMAC), "probe %d", i)
+			}
+
+			assert.Empty(t, c.waiters)
+		})
+	}
+}
+
+func TestHostCheckerConcurrent(t *testing.T) {
+	t.Parallel()
+
+	const hosts = 32
+
+	c, _ := newTestHostChecker()
+
+	ip := func(i int) netip.Addr { return netip.AddrFrom4([4]byte{10, 0, 1, byte(i)}) }
+	mac := func(i int) net.HardwareAddr { return net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x01, byte(i)} }
+
+	// every host answers its own probe, on the interface it was probed on
+	w := &responderWriter{written: func(frame []byte) {
+		msg, ok := readNeighborMessage(frame)
+		if !ok {
+			t.Errorf("probe %x", frame)
+			return
+		}
+
+		i := int(msg.target.As4()[3])
+		answer := buildFrame(t, ethernet.NewFrame().Src(mac(i)).Dst(testRackMAC).Padded().
+			ARPReply(msg.target, testRackMAC, netip.IPv4Unspecified()), nil)
+
+		c.Observe(fmt.Sprintf("eth%d", i%2), answer, nil, capture.Metadata{})
+	}}
+
+	var wg sync.WaitGroup
+
+	for i := range hosts {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			iface := fmt.Sprintf("eth%d", i%2)
+
+			check, err := c.probe(context.Background(), w, testRackMAC, HostCheck{Interface: iface}, ip(i),
+				mac(i), mac(i))
+			assert.NoError(t, err)
+			assert.True(t, check.Matched, iface)
+			assert.Equal(t, 1, check.Unicast)
+		}()
+	}
+
+	wg.Wait()
+	assert.Len(t, w.sent(), hosts)
+	assert.Empty(t, c.waiters)
+}
+
+func TestHostCheckerObserve(t *testing.T) {
+	t.Parallel()
+
+	c, _ := newTestHostChecker()
+	key := hostCheckKey{iface: "eth0", bindingKey: mappingKey(testGateway4, nil)}
+	answers := make(chan hostAnswer, 1)
+
+	c.wait(key, answers)
+	defer c.done(key, answers)
+
+	// the requests and the probes of the host answer nothing
+	c.Observe("eth0", buildFrame(t, ethernet.NewFrame().Src(testGatewayMAC).Padded().
+		ARPRequest(testGateway4, netip.MustParseAddr("10.0.0.1")), nil), nil, capture.Metadata{})
+	c.Observe("eth0", buildFrame(t, ethernet.NewFrame().Src(testGatewayMAC).Padded().
+		ARPRequest(netip.IPv4Unspecified(), testGateway4), nil), nil, capture.Metadata{})
+	// nor do the answers on another interface or VLAN
+	c.Observe("eth1", gatewayAnswer(t, testGateway4), nil, capture.Metadata{})
+	c.Observe("eth0", gatewayAnswer(t, testGateway4), vid10(), capture.Metadata{})
+	assert.Empty(t, answers)
+
+	c.Observe("eth0", gatewayAnswer(t, testGateway4), nil, capture.Metadata{})
+	require.Len(t, answers, 1)
+	assert.Equal(t, testGatewayMAC, (<-answers).mac)
+}
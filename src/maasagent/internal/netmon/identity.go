@@ -0,0 +1,614 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+const (
+	// defaultIdentityMACs bounds the MACs a Correlator keeps the evidence
+	// of, as many as the DuplicateMACDetector tracks
+	defaultIdentityMACs = 16384
+	// maxIdentityHostnames bounds the names a MAC is remembered by, the
+	// oldest is forgotten first
+	maxIdentityHostnames = 8
+)
+
+// The evidence tying the MACs of a host with several NICs together, as
+// HostIdentity.LinkedBy lists it
+const (
+	IdentityLinkClientID = "client_id"
+	IdentityLinkHostname = "hostname"
+)
+
+// ErrInvalidIdentity is returned by Correlator.Identify for evidence it
+// can't record, it matches the addrutil error of an invalid MAC too
+var ErrInvalidIdentity = errors.New("invalid identity evidence")
+
+// stableLocalPrefixes are the locally administered MAC prefixes given to
+// the NICs of virtual machines, which keep them: those of libvirt and QEMU
+var stableLocalPrefixes = []net.HardwareAddr{{0x52, 0x54, 0x00}}
+
+// ephemeralMAC returns true for a MAC which is likely randomized, a locally
+// administered one outside of the prefixes of the hypervisors
+func ephemeralMAC(mac net.HardwareAddr) bool {
+	if len(mac) == 0 || mac[0]&0x02 == 0 {
+		return false
+	}
+
+	return !slices.ContainsFunc(stableLocalPrefixes, func(prefix net.HardwareAddr) bool {
+		return bytes.HasPrefix(mac, prefix)
+	})
+}
+
+// IdentityEvidence is what a source tells of the host behind a MAC, such
+// as a DHCP server acknowledging a lease
+type IdentityEvidence struct {
+	// Time is when the source observed the host, the time it is recorded
+	// when zero
+	Time time.Time
+	MAC  net.HardwareAddr
+	// Hostname is the name the host gave itself
+	Hostname string
+	// ClientID is the DHCP client identifier of the host, option 61, which
+	// a host may send from every NIC
+	ClientID string
+}
+
+// normalize returns a copy of e with its MAC and names in their normal
+// form, or an error matching ErrInvalidIdentity
+func (e IdentityEvidence) normalize() (IdentityEvidence, error) {
+	mac, err := addrutil.UnicastMAC("mac", e.MAC)
+	if err != nil {
+		return IdentityEvidence{}, fmt.Errorf("%w: %w", ErrInvalidIdentity, err)
+	}
+
+	e.MAC = mac
+	e.Hostname = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(e.Hostname), "."))
+	e.ClientID = strings.TrimSpace(e.ClientID)
+
+	if e.Hostname == "" && e.ClientID == "" {
+		return IdentityEvidence{}, fmt.Errorf("%w: neither hostname nor client identifier", ErrInvalidIdentity)
+	}
+
+	return e, nil
+}
+
+// IdentitySegment is an interface and VLAN a host was observed on
+type IdentitySegment struct {
+	VID       *uint16 `json:"vid"`
+	Interface string  `json:"interface"`
+}
+
+func compareIdentitySegments(a, b IdentitySegment) int {
+	return cmp.Or(cmp.Compare(a.Interface, b.Interface), cmp.Compare(vidOrder(a.VID), vidOrder(b.VID)))
+}
+
+// HostIdentity is a host as a whole: the bindings of its MACs on every
+// segment, IPv4 and IPv6 together, and the names the sources know it by.
+// Every list is sorted.
+type HostIdentity struct {
+	// ID is the primary MAC of the host, the lowest of MACs
+	ID   string   `json:"id"`
+	MACs []string `json:"macs"`
+	IPv4 []string `json:"ipv4"`
+	IPv6 []string `json:"ipv6"`
+	// Hostnames are the names the host gave itself, and ClientIDs its DHCP
+	// client identifiers
+	Hostnames []string          `json:"hostnames"`
+	ClientIDs []string          `json:"client_ids"`
+	Segments  []IdentitySegment `json:"segments"`
+	// LinkedBy is the evidence tying several MACs into the identity, see
+	// IdentityLinkClientID and IdentityLinkHostname
+	LinkedBy []string `json:"linked_by,omitempty"`
+	// FirstSeen and LastSeen bound the observations of the MACs
+	FirstSeen int64 `json:"first_seen"`
+	LastSeen  int64 `json:"last_seen"`
+	// Confidence is the highest score of the bindings of the host
+	Confidence float64 `json:"confidence"`
+	// Ephemeral is set when every MAC of the host looks randomized, the
+	// host is likely to show up again as another identity
+	Ephemeral bool `json:"ephemeral"`
+}
+
+// IdentitySnapshot is the hosts of the segments at a point in time
+type IdentitySnapshot struct {
+	// Identities are in the order of their ID
+	Identities []HostIdentity `json:"identities"`
+	Time       int64          `json:"time"`
+}
+
+// IdentityEventKind is what happened to a HostIdentity
+type IdentityEventKind string
+
+const (
+	// IdentityNew is a host none of whose MACs was known
+	IdentityNew IdentityEventKind = "IDENTITY_NEW"
+	// IdentityChanged is a host whose addresses, names or segments changed
+	IdentityChanged IdentityEventKind = "IDENTITY_CHANGED"
+	// IdentityLinked is a host some other MACs were tied to
+	IdentityLinked IdentityEventKind = "IDENTITY_LINKED"
+	// IdentityGone is a host none of whose MACs is bound anymore
+	IdentityGone IdentityEventKind = "IDENTITY_GONE"
+)
+
+// IdentityEvent is a change of a HostIdentity between two snapshots
+type IdentityEvent struct {
+	Event    IdentityEventKind `json:"event"`
+	Identity HostIdentity      `json:"identity"`
+	// Linked are the IDs of the identities an IdentityLinked one was made of
+	Linked []string `json:"linked,omitempty"`
+	Time   int64    `json:"time"`
+}
+
+// sameIdentity returns true if a and b only differ by their times and
+// confidence, which change with every observation
+func sameIdentity(a, b HostIdentity) bool {
+	return a.ID == b.ID && slices.Equal(a.MACs, b.MACs) && slices.Equal(a.IPv4, b.IPv4) &&
+		slices.Equal(a.IPv6, b.IPv6) && slices.Equal(a.Hostnames, b.Hostnames) &&
+		slices.Equal(a.ClientIDs, b.ClientIDs) && slices.Equal(a.LinkedBy, b.LinkedBy) &&
+		a.Ephemeral == b.Ephemeral && slices.EqualFunc(a.Segments, b.Segments, func(x, y IdentitySegment) bool {
+		return compareIdentitySegments(x, y) == 0
+	})
+}
+
+// Diff returns the events turning previous into s, in the order of the
+// IDs. An identity made of the MACs of several previous ones is linked,
+// and those none of whose MACs remain are gone.
+func (s IdentitySnapshot) Diff(previous IdentitySnapshot) []IdentityEvent {
+	var events []IdentityEvent
+
+	byID := make(map[string]HostIdentity, len(previous.Identities))
+	byMAC := make(map[string]string)
+
+	for _, id := range previous.Identities {
+		byID[id.ID] = id
+
+		for _, mac := range id.MACs {
+			byMAC[mac] = id.ID
+		}
+	}
+
+	remaining := make(map[string]bool, len(s.Identities))
+
+	for _, cur := range s.Identities {
+		var prevIDs []string
+
+		for _, mac := range cur.MACs {
+			remaining[mac] = true
+
+			if id, ok := byMAC[mac]; ok && !slices.Contains(prevIDs, id) {
+				prevIDs = append(prevIDs, id)
+			}
+		}
+
+		slices.Sort(prevIDs)
+
+		prevMACs := 0
+		for _, id := range prevIDs {
+			prevMACs += len(byID[id].MACs)
+		}
+
+		switch {
+		case len(prevIDs) == 0:
+			events = append(events, IdentityEvent{Event: IdentityNew, Identity: cur, Time: s.Time})
+		case len(prevIDs) > 1 || len(cur.MACs) > prevMACs:
+			events = append(events, IdentityEvent{Event: IdentityLinked, Identity: cur, Linked: prevIDs, Time: s.Time})
+		case !sameIdentity(cur, byID[prevIDs[0]]):
+			events = append(events, IdentityEvent{Event: IdentityChanged, Identity: cur, Time: s.Time})
+		}
+	}
+
+	for _, prev := range previous.Identities {
+		if !slices.ContainsFunc(prev.MACs, func(mac string) bool { return remaining[mac] }) {
+			events = append(events, IdentityEvent{Event: IdentityGone, Identity: prev, Time: s.Time})
+		}
+	}
+
+	slices.SortStableFunc(events, func(a, b IdentityEvent) int {
+		return cmp.Compare(a.Identity.ID, b.Identity.ID)
+	})
+
+	return events
+}
+
+// macRecord is what a Correlator knows of a MAC beyond its bindings
+type macRecord struct {
+	first time.Time
+	last  time.Time
+	// hostnames are the names of the MAC, with when they were last given
+	hostnames map[string]time.Time
+	clientID  string
+}
+
+// Correlator groups the bindings of the Services into host identities,
+// keyed by MAC. The MACs of a host with several NICs are only tied
+// together by evidence of the sources: a DHCP client identifier they share,
+// or a hostname they share while observed on different segments, as two
+// NICs of a host on the same segment can't be told apart from two hosts
+// given the same name. The MACs which look randomized make ephemeral
+// identities.
+//
+// The Services given a Correlator tell it when each MAC is observed, and
+// the sources their evidence with Identify, such as the follower of the
+// leases of a DHCP server through Service.Ingest.
+type Correlator struct {
+	clock clock.Clock
+	macs  map[string]*macRecord
+	// previous is the snapshot of the last Update
+	previous  IdentitySnapshot
+	size      int
+	evictions atomic.Uint64
+	mu        sync.Mutex
+}
+
+// CorrelatorOption configures a Correlator
+type CorrelatorOption func(*Correlator)
+
+// WithCorrelatorClock sets the clock timestamping the evidence recorded
+// without a time, and the snapshots
+func WithCorrelatorClock(c clock.Clock) CorrelatorOption {
+	return func(r *Correlator) {
+		r.clock = c
+	}
+}
+
+// WithIdentityLimits bounds the MACs the Correlator keeps the evidence of
+// to l.IdentityMACs
+func WithIdentityLimits(l Limits) CorrelatorOption {
+	return func(r *Correlator) {
+		if l.IdentityMACs > 0 {
+			r.size = l.IdentityMACs
+		}
+	}
+}
+
+// NewCorrelator returns a Correlator without any evidence
+func NewCorrelator(options ...CorrelatorOption) *Correlator {
+	r := &Correlator{
+		clock: clock.System{},
+		macs:  make(map[string]*macRecord),
+		size:  defaultIdentityMACs,
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	return r
+}
+
+// record returns the record of mac, created if need be. r.mu must be held.
+func (r *Correlator) record(mac net.HardwareAddr, at time.Time) *macRecord {
+	rec, ok := r.macs[string(mac)]
+	if !ok {
+		if len(r.macs) >= r.size {
+			r.evictions.Add(evictOldest(r.macs, func(rec *macRecord) int64 {
+				return rec.last.UnixNano()
+			}))
+		}
+
+		rec = &macRecord{first: at, last: at}
+		r.macs[string(mac)] = rec
+	}
+
+	if at.Before(rec.first) {
+		rec.first = at
+	}
+
+	if at.After(rec.last) {
+		rec.last = at
+	}
+
+	return rec
+}
+
+// seen records mac observed at
+func (r *Correlator) seen(mac net.HardwareAddr, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record(mac, at)
+}
+
+// Identify records what a source tells of the host behind the MAC of e
+func (r *Correlator) Identify(e IdentityEvidence) error {
+	e, err := e.normalize()
+	if err != nil {
+		return err
+	}
+
+	if e.Time.IsZero() {
+		e.Time = r.clock.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.record(e.MAC, e.Time)
+
+	if e.ClientID != "" {
+		rec.clientID = e.ClientID
+	}
+
+	if e.Hostname != "" {
+		if rec.hostnames == nil {
+			rec.hostnames = make(map[string]time.Time)
+		}
+
+		if _, ok := rec.hostnames[e.Hostname]; !ok && len(rec.hostnames) >= maxIdentityHostnames {
+			oldest := slices.MinFunc(slices.Collect(maps.Keys(rec.hostnames)), func(a, b string) int {
+				return rec.hostnames[a].Compare(rec.hostnames[b])
+			})
+			delete(rec.hostnames, oldest)
+		}
+
+		rec.hostnames[e.Hostname] = e.Time
+	}
+
+	return nil
+}
+
+// Evictions returns the number of MACs forgotten to stay within the limit
+func (r *Correlator) Evictions() uint64 {
+	return r.evictions.Load()
+}
+
+// appendUnique appends v to s unless s holds it
+func appendUnique[T comparable](s []T, v T) []T {
+	if slices.Contains(s, v) {
+		return s
+	}
+
+	return append(s, v)
+}
+
+// identityOf returns the identity of a single MAC, from its bindings
+func identityOf(mac string) *HostIdentity {
+	hw, _ := net.ParseMAC(mac)
+
+	return &HostIdentity{
+		ID:        mac,
+		MACs:      []string{mac},
+		IPv4:      []string{},
+		IPv6:      []string{},
+		Hostnames: []string{},
+		ClientIDs: []string{},
+		Ephemeral: ephemeralMAC(hw),
+	}
+}
+
+// Correlate returns the identities of the hosts bound in the snapshots of
+// the Services, with the evidence recorded of their MACs
+func (r *Correlator) Correlate(snapshots ...Snapshot) IdentitySnapshot {
+	now := r.clock.Now()
+	byMAC := make(map[string]*HostIdentity)
+
+	for _, snap := range snapshots {
+		for _, b := range snap.Bindings {
+			// the MAC of a binding learned from a proxy is the proxy's
+			if b.ViaProxy {
+				continue
+			}
+
+			id, ok := byMAC[b.MAC]
+			if !ok {
+				id = identityOf(b.MAC)
+				id.FirstSeen = b.Time
+				byMAC[b.MAC] = id
+			}
+
+			if ip, err := netip.ParseAddr(b.IP); err == nil && ip.Is4() {
+				id.IPv4 = appendUnique(id.IPv4, b.IP)
+			} else {
+				id.IPv6 = appendUnique(id.IPv6, b.IP)
+			}
+
+			seg := IdentitySegment{VID: b.VID, Interface: snap.Interface}
+			if !slices.ContainsFunc(id.Segments, func(s IdentitySegment) bool {
+				return compareIdentitySegments(s, seg) == 0
+			}) {
+				id.Segments = append(id.Segments, seg)
+			}
+
+			id.FirstSeen = min(id.FirstSeen, b.Time)
+			id.LastSeen = max(id.LastSeen, b.Time)
+			id.Confidence = max(id.Confidence, b.Score)
+		}
+	}
+
+	macs := slices.Sorted(maps.Keys(byMAC))
+
+	r.mu.Lock()
+
+	for _, mac := range macs {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			continue
+		}
+
+		rec, ok := r.macs[string(hw)]
+		if !ok {
+			continue
+		}
+
+		id := byMAC[mac]
+		id.FirstSeen = min(id.FirstSeen, rec.first.Unix())
+		id.LastSeen = max(id.LastSeen, rec.last.Unix())
+		id.Hostnames = slices.AppendSeq(id.Hostnames, maps.Keys(rec.hostnames))
+
+		if rec.clientID != "" {
+			id.ClientIDs = append(id.ClientIDs, rec.clientID)
+		}
+	}
+
+	r.mu.Unlock()
+
+	return IdentitySnapshot{Identities: link(macs, byMAC), Time: now.Unix()}
+}
+
+// link merges the identities of macs tied together by a client identifier,
+// or by a hostname on different segments, and returns them in order
+func link(macs []string, byMAC map[string]*HostIdentity) []HostIdentity {
+	parent := make(map[string]string, len(macs))
+	linkedBy := make(map[string][]string)
+
+	var find func(string) string
+
+	find = func(mac string) string {
+		if parent[mac] == "" || parent[mac] == mac {
+			return mac
+		}
+
+		parent[mac] = find(parent[mac])
+
+		return parent[mac]
+	}
+
+	union := func(a, b, by string) {
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			// the lowest MAC is the root, and so the ID
+			ra, rb = min(ra, rb), max(ra, rb)
+			parent[rb] = ra
+			linkedBy[ra] = append(linkedBy[ra], linkedBy[rb]...)
+			delete(linkedBy, rb)
+		}
+
+		linkedBy[ra] = appendUnique(linkedBy[ra], by)
+	}
+
+	byClientID := make(map[string][]string)
+	byHostname := make(map[string][]string)
+
+	for _, mac := range macs {
+		for _, c := range byMAC[mac].ClientIDs {
+			byClientID[c] = append(byClientID[c], mac)
+		}
+
+		for _, h := range byMAC[mac].Hostnames {
+			byHostname[h] = append(byHostname[h], mac)
+		}
+	}
+
+	for _, c := range slices.Sorted(maps.Keys(byClientID)) {
+		for _, mac := range byClientID[c][1:] {
+			union(byClientID[c][0], mac, IdentityLinkClientID)
+		}
+	}
+
+	for _, h := range slices.Sorted(maps.Keys(byHostname)) {
+		group := byHostname[h]
+
+		for i, a := range group {
+			for _, b := range group[i+1:] {
+				if !sharesSegment(byMAC[a], byMAC[b]) {
+					union(a, b, IdentityLinkHostname)
+				}
+			}
+		}
+	}
+
+	merged := make(map[string]*HostIdentity)
+
+	for _, mac := range macs {
+		root := find(mac)
+		id := byMAC[mac]
+
+		m, ok := merged[root]
+		if !ok {
+			merged[root] = id
+			continue
+		}
+
+		m.MACs = append(m.MACs, id.MACs...)
+		m.IPv4 = append(m.IPv4, id.IPv4...)
+		m.IPv6 = append(m.IPv6, id.IPv6...)
+		m.Hostnames = append(m.Hostnames, id.Hostnames...)
+		m.ClientIDs = append(m.ClientIDs, id.ClientIDs...)
+		m.Segments = append(m.Segments, id.Segments...)
+		m.FirstSeen = min(m.FirstSeen, id.FirstSeen)
+		m.LastSeen = max(m.LastSeen, id.LastSeen)
+		m.Confidence = max(m.Confidence, id.Confidence)
+		m.Ephemeral = m.Ephemeral && id.Ephemeral
+	}
+
+	identities := make([]HostIdentity, 0, len(merged))
+
+	for _, root := range slices.Sorted(maps.Keys(merged)) {
+		id := merged[root]
+		id.LinkedBy = slices.Sorted(slices.Values(linkedBy[root]))
+
+		for _, s := range []*[]string{&id.MACs, &id.IPv4, &id.IPv6, &id.Hostnames, &id.ClientIDs} {
+			slices.Sort(*s)
+			*s = slices.Compact(*s)
+		}
+
+		slices.SortFunc(id.Segments, compareIdentitySegments)
+		id.Segments = slices.CompactFunc(id.Segments, func(a, b IdentitySegment) bool {
+			return compareIdentitySegments(a, b) == 0
+		})
+
+		identities = append(identities, *id)
+	}
+
+	return identities
+}
+
+// sharesSegment returns true if a and b were observed on a same segment
+func sharesSegment(a, b *HostIdentity) bool {
+	return slices.ContainsFunc(a.Segments, func(s IdentitySegment) bool {
+		return slices.ContainsFunc(b.Segments, func(t IdentitySegment) bool {
+			return compareIdentitySegments(s, t) == 0
+		})
+	})
+}
+
+// Update correlates the snapshots and returns the identities, with the
+// events since the previous Update
+func (r *Correlator) Update(snapshots ...Snapshot) (IdentitySnapshot, []IdentityEvent) {
+	snap := r.Correlate(snapshots...)
+
+	r.mu.Lock()
+	previous := r.previous
+	r.previous = snap
+	r.mu.Unlock()
+
+	return snap, snap.Diff(previous)
+}
+
+// WithCorrelator tells c when each MAC is observed, and what the
+// observations Ingest takes tell of their host
+func WithCorrelator(c *Correlator) ServiceOption {
+	return func(s *Service) {
+		s.identities = c
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+func identitySnapshot(iface string, vid *uint16, bindings ...[2]string) Snapshot {
+	snap := Snapshot{Interface: iface}
+
+	for _, b := range bindings {
+		snap.Bindings = append(snap.Bindings, SnapshotBinding{
+			VID: vid, IP: b[0], MAC: b[1], Score: 0.7, Time: 1700000000,
+		})
+	}
+
+	return snap
+}
+
+func TestEphemeralMAC(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		mac       string
+		ephemeral bool
+	}{
+		"universal": {
+			mac: "00:16:3e:00:00:01",
+		},
+		"randomized": {
+			mac:       "da:a1:19:00:00:01",
+			ephemeral: true,
+		},
+		"libvirt": {
+			mac: "52:54:00:00:00:01",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.ephemeral, ephemeralMAC(mustParseMAC(tc.mac)))
+		})
+	}
+}
+
+func TestCorrelatorIdentifyInvalid(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		evidence IdentityEvidence
+		err      error
+	}{
+		"group MAC": {
+			evidence: IdentityEvidence{MAC: mustParseMAC("01:00:5e:00:00:01"), Hostname: "node1"},
+			err:      addrutil.ErrInvalidMAC,
+		},
+		"no name": {
+			evidence: IdentityEvidence{MAC: mustParseMAC("00:16:3e:00:00:01"), Hostname: " ."},
+			err:      ErrInvalidIdentity,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := NewCorrelator().Identify(tc.evidence)
+			require.ErrorIs(t, err, tc.err)
+			assert.ErrorIs(t, err, ErrInvalidIdentity)
+		})
+	}
+}
+
+func TestCorrelatorCorrelate(t *testing.T) {
+	t.Parallel()
+
+	macA := "00:16:3e:00:00:01"
+	macB := "00:16:3e:00:00:02"
+	macC := "00:16:3e:00:00:03"
+
+	testcases := map[string]struct {
+		evidence  []IdentityEvidence
+		snapshots []Snapshot
+		ids       [][]string
+		linkedBy  []string
+	}{
+		"dual stack": {
+			snapshots: []Snapshot{
+				identitySnapshot("eth0", nil, [2]string{"10.0.0.1", macA}, [2]string{"fe80::1", macA}),
+			},
+			ids: [][]string{{macA}},
+		},
+		"client identifier": {
+			evidence: []IdentityEvidence{
+				{MAC: mustParseMAC(macA), ClientID: "01:00:16:3e:00:00:01"},
+				{MAC: mustParseMAC(macB), ClientID: "01:00:16:3e:00:00:01"},
+			},
+			snapshots: []Snapshot{
+				identitySnapshot("eth0", nil, [2]string{"10.0.0.1", macA}, [2]string{"10.0.0.2", macB}),
+			},
+			ids:      [][]string{{macA, macB}},
+			linkedBy: []string{IdentityLinkClientID},
+		},
+		"hostname on different segments": {
+			evidence: []IdentityEvidence{
+				{MAC: mustParseMAC(macA), Hostname: "Node1."},
+				{MAC: mustParseMAC(macB), Hostname: "node1"},
+			},
+			snapshots: []Snapshot{
+				identitySnapshot("eth0", nil, [2]string{"10.0.0.1", macA}),
+				identitySnapshot("eth1", nil, [2]string{"10.0.1.1", macB}),
+			},
+			ids:      [][]string{{macA, macB}},
+			linkedBy: []string{IdentityLinkHostname},
+		},
+		"hostname on the same segment": {
+			evidence: []IdentityEvidence{
+				{MAC: mustParseMAC(macA), Hostname: "node1"},
+				{MAC: mustParseMAC(macB), Hostname: "node1"},
+			},
+			snapshots: []Snapshot{
+				identitySnapshot("eth0", uint16Pointer(10), [2]string{"10.0.0.1", macA}, [2]string{"10.0.0.2", macB}),
+			},
+			ids: [][]string{{macA}, {macB}},
+		},
+		"transitive": {
+			evidence: []IdentityEvidence{
+				{MAC: mustParseMAC(macA), Hostname: "node1"},
+				{MAC: mustParseMAC(macB), Hostname: "node1", ClientID: "node1-id"},
+				{MAC: mustParseMAC(macC), ClientID: "node1-id"},
+			},
+			snapshots: []Snapshot{
+				identitySnapshot("eth0", nil, [2]string{"10.0.0.1", macA}),
+				identitySnapshot("eth1", nil, [2]string{"10.0.1.1", macB}, [2]string{"10.0.1.2", macC}),
+			},
+			ids:      [][]string{{macA, macB, macC}},
+			linkedBy: []string{IdentityLinkClientID, IdentityLinkHostname},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := NewCorrelator(WithCorrelatorClock(clocktest.NewFake(time.Unix(1700000000, 0))))
+
+			for _, e := range tc.evidence {
+				require.NoError(t, c.Identify(e))
+			}
+
+			snap := c.Correlate(tc.snapshots...)
+
+			require.Len(t, snap.Identities, len(tc.ids))
+
+			for i, macs := range tc.ids {
+				assert.Equal(t, macs[0], snap.Identities[i].ID)
+				assert.Equal(t, macs, snap.Identities[i].MACs)
+			}
+
+			if len(tc.ids) == 1 {
+				assert.Equal(t, tc.linkedBy, snap.Identities[0].LinkedBy)
+			}
+		})
+	}
+}
+
+func TestCorrelatorIdentity(t *testing.T) {
+	t.Parallel()
+
+	timestamp := time.Unix(1700000000, 0)
+	c := NewCorrelator(WithCorrelatorClock(clocktest.NewFake(timestamp)))
+
+	require.NoError(t, c.Identify(IdentityEvidence{
+		Time:     timestamp.Add(-time.Hour),
+		MAC:      mustParseMAC("00:16:3e:00:00:01"),
+		Hostname: "node1",
+		ClientID: "node1-id",
+	}))
+
+	proxied := identitySnapshot("eth0", uint16Pointer(10), [2]string{"10.0.0.9", "00:16:3e:00:00:09"})
+	proxied.Bindings[0].ViaProxy = true
+
+	snap := c.Correlate(
+		identitySnapshot("eth0", uint16Pointer(10), [2]string{"10.0.0.1", "00:16:3e:00:00:01"},
+			[2]string{"2001:db8::1", "00:16:3e:00:00:01"}),
+		identitySnapshot("eth1", nil, [2]string{"10.0.1.1", "00:16:3e:00:00:01"}),
+		proxied,
+	)
+
+	assert.Equal(t, IdentitySnapshot{
+		Identities: []HostIdentity{{
+			ID:        "00:16:3e:00:00:01",
+			MACs:      []string{"00:16:3e:00:00:01"},
+			IPv4:      []string{"10.0.0.1", "10.0.1.1"},
+			IPv6:      []string{"2001:db8::1"},
+			Hostnames: []string{"node1"},
+			ClientIDs: []string{"node1-id"},
+			Segments: []IdentitySegment{
+				{VID: uint16Pointer(10), Interface: "eth0"},
+				{Interface: "eth1"},
+			},
+			FirstSeen:  1699996400,
+			LastSeen:   1700000000,
+			Confidence: 0.7,
+		}},
+		Time: 1700000000,
+	}, snap)
+}
+
+func TestCorrelatorEphemeral(t *testing.T) {
+	t.Parallel()
+
+	c := NewCorrelator()
+
+	for _, mac := range []string{"da:a1:19:00:00:01", "da:a1:19:00:00:02", "00:16:3e:00:00:01"} {
+		require.NoError(t, c.Identify(IdentityEvidence{MAC: mustParseMAC(mac), ClientID: mac}))
+	}
+
+	require.NoError(t, c.Identify(IdentityEvidence{MAC: mustParseMAC("da:a1:19:00:00:02"), ClientID: "phone"}))
+	require.NoError(t, c.Identify(IdentityEvidence{MAC: mustParseMAC("00:16:3e:00:00:01"), ClientID: "phone"}))
+
+	snap := c.Correlate(identitySnapshot("eth0", nil,
+		[2]string{"10.0.0.1", "da:a1:19:00:00:01"},
+		[2]string{"10.0.0.2", "da:a1:19:00:00:02"},
+		[2]string{"10.0.0.3", "00:16:3e:00:00:01"},
+	))
+
+	require.Len(t, snap.Identities, 2)
+	// a host with a universal MAC stays itself whatever its other MACs
+	assert.Equal(t, []string{"00:16:3e:00:00:01", "da:a1:19:00:00:02"}, snap.Identities[0].MACs)
+	assert.False(t, snap.Identities[0].Ephemeral)
+	assert.Equal(t, "da:a1:19:00:00:01", snap.Identities[1].ID)
+	assert.True(t, snap.Identities[1].Ephemeral)
+}
+
+func TestIdentitySnapshotDiff(t *testing.T) {
+	t.Parallel()
+
+	macA := "00:16:3e:00:00:01"
+	macB := "00:16:3e:00:00:02"
+	macC := "00:16:3e:00:00:03"
+
+	timestamp := time.Unix(1700000000, 0)
+	clk := clocktest.NewFake(timestamp)
+	c := NewCorrelator(WithCorrelatorClock(clk))
+
+	eth0 := identitySnapshot("eth0", nil, [2]string{"10.0.0.1", macA}, [2]string{"10.0.0.3", macC})
+	eth1 := identitySnapshot("eth1", nil, [2]string{"10.0.1.1", macB})
+
+	first, events := c.Update(eth0, eth1)
+	require.Len(t, first.Identities, 3)
+	require.Len(t, events, 3)
+
+	for _, e := range events {
+		assert.Equal(t, IdentityNew, e.Event)
+	}
+
+	_, events = c.Update(eth0, eth1)
+	assert.Empty(t, events, "nothing changed")
+
+	require.NoError(t, c.Identify(IdentityEvidence{MAC: mustParseMAC(macA), Hostname: "node1"}))
+	require.NoError(t, c.Identify(IdentityEvidence{MAC: mustParseMAC(macB), Hostname: "node1"}))
+
+	clk.Advance(time.Minute)
+
+	eth0 = identitySnapshot("eth0", nil, [2]string{"10.0.0.1", macA})
+
+	_, events = c.Update(eth0, eth1)
+
+	require.Len(t, events, 2)
+	assert.Equal(t, IdentityLinked, events[0].Event)
+	assert.Equal(t, []string{macA, macB}, events[0].Identity.MACs)
+	assert.Equal(t, []string{macA, macB}, events[0].Linked)
+	assert.Equal(t, int64(1700000060), events[0].Time)
+	assert.Equal(t, IdentityGone, events[1].Event)
+	assert.Equal(t, macC, events[1].Identity.ID)
+
+	eth1 = identitySnapshot("eth1", nil, [2]string{"10.0.1.1", macB}, [2]string{"2001:db8::2", macB})
+
+	_, events = c.Update(eth0, eth1)
+
+	require.Len(t, events, 1)
+	assert.Equal(t, IdentityChanged, events[0].Event)
+	assert.Equal(t, []string{"2001:db8::2"}, events[0].Identity.IPv6)
+}
+
+func TestCorrelatorLimits(t *testing.T) {
+	t.Parallel()
+
+	timestamp := time.Unix(1700000000, 0)
+	c := NewCorrelator(WithIdentityLimits(Limits{IdentityMACs: 2}))
+
+	for i, mac := range []string{"00:16:3e:00:00:01", "00:16:3e:00:00:02", "00:16:3e:00:00:03"} {
+		c.seen(mustParseMAC(mac), timestamp.Add(time.Duration(i)*time.Second))
+	}
+
+	assert.Len(t, c.macs, 2)
+	assert.Equal(t, uint64(1), c.Evictions())
+	assert.NotContains(t, c.macs, string(mustParseMAC("00:16:3e:00:00:01")))
+}
+
+func TestServiceCorrelator(t *testing.T) {
+	t.Parallel()
+
+	timestamp := time.Unix(1700000000, 0)
+	c := NewCorrelator(WithCorrelatorClock(clocktest.NewFake(timestamp)))
+	s := NewService("eth0", WithClock(clocktest.NewFake(timestamp)), WithCorrelator(c))
+
+	s.Observe(ObservationARPRequest, netip.MustParseAddr("10.0.0.1"), mustParseMAC("00:16:3e:00:00:01"), nil,
+		timestamp.Add(-time.Hour))
+
+	_, err := s.Ingest(Observation{
+		Time:       timestamp,
+		Source:     "dhcpd-leases",
+		IP:         netip.MustParseAddr("10.0.0.1"),
+		MAC:        mustParseMAC("00:16:3e:00:00:01"),
+		Kind:       ObservationDHCPAck,
+		Confidence: ConfidenceHigh,
+		Hostname:   "node1",
+		ClientID:   "node1-id",
+	})
+	require.NoError(t, err)
+
+	snap := c.Correlate(s.Snapshot())
+
+	require.Len(t, snap.Identities, 1)
+	assert.Equal(t, []string{"node1"}, snap.Identities[0].Hostnames)
+	assert.Equal(t, []string{"node1-id"}, snap.Identities[0].ClientIDs)
+	assert.Equal(t, int64(1699996400), snap.Identities[0].FirstSeen)
+	assert.Equal(t, int64(1700000000), snap.Identities[0].LastSeen)
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+)
+
+// ErrInvalidObservation is returned by Ingest for an observation it can't
+// bind, it matches the addrutil error of the invalid address too
+var ErrInvalidObservation = errors.New("invalid observation")
+
+// Observation is a binding produced by another source than the capture of
+// a Service, such as the lease file of a DHCP server, an inventory of BMCs
+// or the upload of another agent
+type Observation struct {
+	// Time is when the source observed the binding, the time it is ingested
+	// when zero
+	Time time.Time
+	// VID is the VLAN of the binding, if the source knows it
+	VID *uint16
+	// Source names the source, such as "dhcpd-leases"
+	Source string
+	IP     netip.Addr
+	MAC    net.HardwareAddr
+	// Kind is the kind of evidence the source has of the binding, it is
+	// weighed as that of a capture, scaled by Confidence
+	Kind ObservationKind
+	// Confidence is how far the source vouches for the binding, an
+	// observation of ConfidenceNone weighs nothing
+	Confidence Confidence
+	// Hostname and ClientID are what the source knows of the host, such as
+	// the name and DHCP client identifier of a lease, given to the
+	// Correlator of the Service
+	Hostname string
+	ClientID string
+}
+
+// normalize returns a copy of o with its addresses in their normal form,
+// or an error matching ErrInvalidObservation
+func (o Observation) normalize() (Observation, error) {
+	ip, err := addrutil.UnicastIP("ip", o.IP)
+	if err != nil {
+		return Observation{}, fmt.Errorf("%w: %w", ErrInvalidObservation, err)
+	}
+
+	mac, err := addrutil.UnicastMAC("mac", o.MAC)
+	if err != nil {
+		return Observation{}, fmt.Errorf("%w: %w", ErrInvalidObservation, err)
+	}
+
+	switch {
+	case o.Source == "":
+		return Observation{}, fmt.Errorf("%w: missing source", ErrInvalidObservation)
+	case o.Kind > ObservationKernel:
+		return Observation{}, fmt.Errorf("%w: unknown kind %d", ErrInvalidObservation, o.Kind)
+	case o.Confidence > ConfidenceHigh:
+		return Observation{}, fmt.Errorf("%w: unknown confidence %d", ErrInvalidObservation, o.Confidence)
+	}
+
+	if o.VID != nil {
+		vid := *o.VID
+		o.VID = &vid
+	}
+
+	o.IP, o.MAC = ip, mac
+
+	return o, nil
+}
+
+// Ingest binds an observation of another source than the capture of the
+// Service, and returns the Results it produces for the caller to deliver.
+// The observation goes through what a captured one does: the assertions,
+// the scores deciding a conflict with the binding of its IP, and the
+// refreshes coalesced within seenAgainThreshold. The binding it creates is
+// a BindingSourceExternal one, which the kernel neighbor cache doesn't
+// replace.
+func (s *Service) Ingest(o Observation) ([]Result, error) {
+	o, err := o.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Time.IsZero() {
+		o.Time = s.clock.Now()
+	}
+
+	res := s.observe(Binding{
+		VID:        o.VID,
+		Time:       o.Time,
+		IP:         o.IP,
+		MAC:        o.MAC,
+		Source:     BindingSourceExternal,
+		Origin:     o.Source,
+		Confidence: o.Confidence,
+		Kind:       o.Kind,
+	})
+
+	s.label(res)
+
+	if s.history != nil {
+		s.history.record(s.iface, res)
+	}
+
+	if s.identities != nil && (o.Hostname != "" || o.ClientID != "") {
+		// the MAC was validated, only blank names could be rejected
+		_ = s.identities.Identify(IdentityEvidence{
+			Time:     o.Time,
+			MAC:      o.MAC,
+			Hostname: o.Hostname,
+			ClientID: o.ClientID,
+		})
+	}
+
+	return res, nil
+}
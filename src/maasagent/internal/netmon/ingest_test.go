@@ -0,0 +1,207 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/netif"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+func TestServiceIngestInvalid(t *testing.T) {
+	t.Parallel()
+
+	valid := Observation{
+		Source:     "dhcpd-leases",
+		IP:         netip.MustParseAddr("10.0.0.1"),
+		MAC:        mustParseMAC("00:16:3e:00:00:01"),
+		Kind:       ObservationDHCPAck,
+		Confidence: ConfidenceHigh,
+	}
+
+	testcases := map[string]struct {
+		change func(o *Observation)
+		err    error
+	}{
+		"missing IP": {
+			change: func(o *Observation) { o.IP = netip.Addr{} },
+			err:    addrutil.ErrInvalidIP,
+		},
+		"multicast IP": {
+			change: func(o *Observation) { o.IP = netip.MustParseAddr("224.0.0.1") },
+			err:    addrutil.ErrInvalidIP,
+		},
+		"group MAC": {
+			change: func(o *Observation) { o.MAC = mustParseMAC("01:00:5e:00:00:01") },
+			err:    addrutil.ErrInvalidMAC,
+		},
+		"EUI-64": {
+			change: func(o *Observation) { o.MAC = net.HardwareAddr{0x00, 0x16, 0x3e, 0xff, 0xfe, 0x00, 0x00, 0x01} },
+			err:    addrutil.ErrInvalidMAC,
+		},
+		"missing source": {
+			change: func(o *Observation) { o.Source = "" },
+		},
+		"unknown kind": {
+			change: func(o *Observation) { o.Kind = ObservationKernel + 1 },
+		},
+		"unknown confidence": {
+			change: func(o *Observation) { o.Confidence = ConfidenceHigh + 1 },
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewService("eth0")
+			o := valid
+			tc.change(&o)
+
+			res, err := s.Ingest(o)
+			require.ErrorIs(t, err, ErrInvalidObservation)
+
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			}
+
+			assert.Empty(t, res)
+			assert.Empty(t, s.Bindings())
+		})
+	}
+}
+
+func TestServiceIngest(t *testing.T) {
+	t.Parallel()
+
+	ip := netip.MustParseAddr("10.0.0.1")
+	captured := mustParseMAC("00:16:3e:00:00:01")
+	leased := mustParseMAC("00:16:3e:00:00:02")
+
+	lease := func(confidence Confidence) Observation {
+		return Observation{
+			Source:     "dhcpd-leases",
+			IP:         netip.MustParseAddr("::ffff:10.0.0.1"),
+			MAC:        leased,
+			Kind:       ObservationDHCPAck,
+			Confidence: confidence,
+		}
+	}
+
+	testcases := map[string]struct {
+		confidence Confidence
+		events     []Event
+		mac        string
+	}{
+		"confident lease outscores the capture": {
+			confidence: ConfidenceHigh,
+			events:     []Event{EventMoved},
+			mac:        "00:16:3e:00:00:02",
+		},
+		"doubtful lease doesn't": {
+			confidence: ConfidenceLow,
+			mac:        "00:16:3e:00:00:01",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Unix(1700000000, 0)
+			s := NewService("eth0", WithClock(clocktest.NewFake(now)))
+
+			s.Observe(ObservationARPReply, ip, captured, nil, now)
+
+			res, err := s.Ingest(lease(tc.confidence))
+			require.NoError(t, err)
+
+			var events []Event
+
+			for _, r := range res {
+				events = append(events, r.Event)
+			}
+
+			assert.Equal(t, tc.events, events)
+
+			b, ok := s.Lookup(ip, nil)
+			require.True(t, ok)
+			assert.Equal(t, tc.mac, b.MAC)
+		})
+	}
+}
+
+func TestServiceIngestBinding(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	clock := clocktest.NewFake(now)
+	s := NewService("eth0", WithClock(clock))
+
+	ip := netip.MustParseAddr("10.0.0.1")
+	mac := mustParseMAC("00:16:3e:00:00:01")
+	o := Observation{Source: "dhcpd-leases", IP: ip, MAC: mac, Kind: ObservationDHCPAck, Confidence: ConfidenceHigh}
+
+	res, err := s.Ingest(o)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+	assert.Equal(t, now.Unix(), res[0].Time)
+
+	// the same lease read again is coalesced with the binding
+	clock.Advance(time.Second)
+
+	res, err = s.Ingest(o)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// the kernel doesn't replace what another source observed
+	s.reconcile([]kernelEntry{{ip: ip, mac: mustParseMAC("00:16:3e:00:00:02"), state: netif.NeighReachable}})
+
+	snap := s.Snapshot()
+	require.Len(t, snap.Bindings, 1)
+	assert.Equal(t, "00:16:3e:00:00:01", snap.Bindings[0].MAC)
+	assert.Equal(t, "external", snap.Bindings[0].Source)
+	assert.Equal(t, "dhcpd-leases", snap.Bindings[0].Origin)
+	assert.Equal(t, "high", snap.Bindings[0].Confidence)
+	assert.Equal(t, "dhcp_ack", snap.Bindings[0].Observation)
+
+	// a capture outweighing a doubtful source takes the binding over
+	s = NewService("eth0", WithClock(clock))
+	o.Confidence = ConfidenceLow
+
+	_, err = s.Ingest(o)
+	require.NoError(t, err)
+
+	s.Observe(ObservationARPReply, ip, mac, nil, clock.Now())
+
+	snap = s.Snapshot()
+	require.Len(t, snap.Bindings, 1)
+	assert.Empty(t, snap.Bindings[0].Source)
+	assert.Equal(t, "arp_reply", snap.Bindings[0].Observation)
+}
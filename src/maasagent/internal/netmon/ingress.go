@@ -0,0 +1,284 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"io"
+	"maps"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+// defaultIngressWindow is how long after a member port delivered a frame
+// its bridge may deliver it and still be attributed to the port, as for
+// the Deduplicator
+const defaultIngressWindow = defaultDedupWindow
+
+// Ingress is the port the frame of a Result entered the host on, set by the
+// Services of a PortAttributor
+type Ingress struct {
+	// Port is the member port of the bridge or bond the frame entered on,
+	// or the bridge or bond itself when it couldn't be told
+	Port string `json:"port"`
+	// Attributed is set when Port is a member port, it is unset for the
+	// fallback to the bridge or bond
+	Attributed bool `json:"attributed"`
+}
+
+// IngressStats are the frames of a bridge or bond the PortAttributor
+// looked up the ingress port of
+type IngressStats struct {
+	// Attributed are the frames a member port delivered within the window
+	Attributed uint64 `json:"attributed"`
+	// Fallback are the frames attributed to the bridge or bond itself
+	Fallback uint64 `json:"fallback"`
+}
+
+type ingressEntry struct {
+	seen   time.Time
+	master string
+	port   string
+}
+
+// PortAttributor tells which member port of a bridge or bond a frame the
+// capture of the bridge or bond delivered entered on, the kernel reporting
+// the bridge for them all. The captures of the member ports record the
+// frames they receive, see Capture and Serve, and the Service of the bridge
+// or bond looks its frames up among them, see WithPortAttributor. The
+// frames are recognised by a hash of their bytes, as by the Deduplicator.
+//
+// The kernel hands a frame to the captures of the port it entered on before
+// bridging it, so a member delivers the frame first. A frame no member of
+// the bridge or bond delivered within the window before, or delivered with
+// other bytes, such as one the host sent on the bridge itself, is
+// attributed to the bridge or bond as a fallback.
+//
+// Attribution captures every member port in addition to the bridge or bond,
+// which costs as much again, so it is only enabled on request.
+type PortAttributor struct {
+	clock   clock.Clock
+	entries map[uint64]ingressEntry
+	stats   map[string]IngressStats
+	// ring holds the entries in the order they were recorded, as for the
+	// Deduplicator
+	ring      []dedupSlot
+	seed      maphash.Seed
+	window    time.Duration
+	head      int
+	count     int
+	evictions uint64
+	mu        sync.Mutex
+}
+
+// PortAttributorOption configures a PortAttributor
+type PortAttributorOption func(*PortAttributor)
+
+// WithIngressWindow sets how long after a member port delivered a frame its
+// bridge or bond may deliver it and still be attributed to the port
+func WithIngressWindow(window time.Duration) PortAttributorOption {
+	return func(a *PortAttributor) {
+		if window > 0 {
+			a.window = window
+		}
+	}
+}
+
+// WithIngressLimits bounds the frames remembered to the DedupFrames of l,
+// the oldest are forgotten first
+func WithIngressLimits(l Limits) PortAttributorOption {
+	return func(a *PortAttributor) {
+		if l.DedupFrames > 0 {
+			a.ring = make([]dedupSlot, l.DedupFrames)
+		}
+	}
+}
+
+// WithIngressClock sets the clock the window is measured with
+func WithIngressClock(c clock.Clock) PortAttributorOption {
+	return func(a *PortAttributor) {
+		a.clock = c
+	}
+}
+
+// NewPortAttributor returns a PortAttributor without any frame
+func NewPortAttributor(options ...PortAttributorOption) *PortAttributor {
+	a := &PortAttributor{
+		clock:  clock.System{},
+		stats:  make(map[string]IngressStats),
+		ring:   make([]dedupSlot, defaultDedupFrames),
+		seed:   maphash.MakeSeed(),
+		window: defaultIngressWindow,
+	}
+
+	for _, opt := range options {
+		opt(a)
+	}
+
+	a.entries = make(map[uint64]ingressEntry, len(a.ring))
+
+	return a
+}
+
+// record remembers that port, a member of master, received frame
+func (a *PortAttributor) record(master, port string, frame []byte) {
+	hash := maphash.Bytes(a.seed, frame)
+	now := a.clock.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire(now)
+
+	if a.count == len(a.ring) && a.pop() {
+		a.evictions++
+	}
+
+	a.ring[(a.head+a.count)%len(a.ring)] = dedupSlot{seen: now, hash: hash}
+	a.count++
+	a.entries[hash] = ingressEntry{seen: now, master: master, port: port}
+}
+
+// Ingress returns the port frame, which the capture of master delivered,
+// entered on
+func (a *PortAttributor) Ingress(master string, frame []byte) Ingress {
+	hash := maphash.Bytes(a.seed, frame)
+	now := a.clock.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire(now)
+
+	st := a.stats[master]
+	defer func() { a.stats[master] = st }()
+
+	if e, ok := a.entries[hash]; ok && e.master == master && now.Sub(e.seen) < a.window {
+		st.Attributed++
+
+		return Ingress{Port: e.port, Attributed: true}
+	}
+
+	st.Fallback++
+
+	return Ingress{Port: master}
+}
+
+// expire forgets the entries whose window ended before now
+func (a *PortAttributor) expire(now time.Time) {
+	for a.count > 0 && now.Sub(a.ring[a.head].seen) >= a.window {
+		a.pop()
+	}
+}
+
+// pop forgets the oldest slot, and its entry unless recorded again since,
+// and returns whether the entry was forgotten
+func (a *PortAttributor) pop() bool {
+	slot := a.ring[a.head]
+	a.head = (a.head + 1) % len(a.ring)
+	a.count--
+
+	if e, ok := a.entries[slot.hash]; ok && e.seen.Equal(slot.seen) {
+		delete(a.entries, slot.hash)
+		return true
+	}
+
+	return false
+}
+
+// Stats returns the frames looked up for each bridge or bond
+func (a *PortAttributor) Stats() map[string]IngressStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return maps.Clone(a.stats)
+}
+
+// Evictions returns the number of frames forgotten before the end of their
+// window, which fell back to their bridge or bond if delivered by it later
+func (a *PortAttributor) Evictions() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.evictions
+}
+
+// Capture records the frames received by port, a member of master, until
+// ctx is done. The options are those of the capture, such as the filter of
+// the capture of master.
+func (a *PortAttributor) Capture(ctx context.Context, master, port string, options ...capture.Option) error {
+	conn, err := capture.Listen(port, options...)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close() //nolint:errcheck // nothing is read from conn anymore
+
+	return a.Serve(ctx, master, port, conn)
+}
+
+// Serve records the frames read from r as received by port, a member of
+// master, until ctx is done or r returns io.EOF. The frames port sends are
+// those master forwards to it, they are ignored.
+func (a *PortAttributor) Serve(ctx context.Context, master, port string, r capture.FrameReader) error {
+	stop := capture.InterruptReads(ctx, r)
+	defer stop()
+
+	buf := make([]byte, max(snapLen, ndpSnapLen, portAuthSnapLen, layerSnapLen))
+
+	for {
+		md, err := capture.ReadFrameMetadata(r, buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		if md.Direction == capture.DirectionOutbound {
+			continue
+		}
+
+		a.record(master, port, buf[:md.CaptureLength])
+	}
+}
+
+// CaptureMember records the frames received by port, a member of the bridge
+// or bond of the Service, in its PortAttributor until ctx is done. The
+// frames are captured with the filter of the Service, which truncates them
+// as the frames of the Service so that they hash alike.
+func (s *Service) CaptureMember(ctx context.Context, port string) error {
+	if s.attributor == nil {
+		return ErrPortAttributionDisabled
+	}
+
+	if err := s.Capabilities().Err(CapabilityNetRaw); err != nil {
+		return err
+	}
+
+	filter, err := s.captureFilter()
+	if err != nil {
+		return err
+	}
+
+	return s.attributor.Capture(ctx, s.iface, port, capture.WithFilter(filter))
+}
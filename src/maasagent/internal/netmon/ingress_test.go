@@ -0,0 +1,231 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+// directedFrames is a capture.FrameReader returning frames in the given
+// directions, then io.EOF
+type directedFrames struct {
+	frames     [][]byte
+	directions []capture.Direction
+}
+
+func (r *directedFrames) ReadFrame(buf []byte) (int, error) {
+	md, err := r.ReadFrameMetadata(buf)
+	return md.CaptureLength, err
+}
+
+func (r *directedFrames) ReadFrameMetadata(buf []byte) (capture.Metadata, error) {
+	if len(r.frames) == 0 {
+		return capture.Metadata{}, io.EOF
+	}
+
+	n := copy(buf, r.frames[0])
+	md := capture.Metadata{CaptureLength: n, Length: len(r.frames[0]), Direction: r.directions[0]}
+	r.frames, r.directions = r.frames[1:], r.directions[1:]
+
+	return md, nil
+}
+
+func (r *directedFrames) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (r *directedFrames) Close() error {
+	return nil
+}
+
+func TestPortAttributor(t *testing.T) {
+	t.Parallel()
+
+	frame := []byte{0x01, 0x02, 0x03}
+	other := []byte{0x04, 0x05, 0x06}
+
+	testcases := map[string]struct {
+		record  func(a *PortAttributor)
+		elapsed time.Duration
+		frame   []byte
+		ingress Ingress
+		stats   IngressStats
+	}{
+		"member port": {
+			record: func(a *PortAttributor) {
+				a.record("br0", "eth0", frame)
+				a.record("br0", "eth1", other)
+			},
+			elapsed: defaultIngressWindow - time.Millisecond,
+			frame:   frame,
+			ingress: Ingress{Port: "eth0", Attributed: true},
+			stats:   IngressStats{Attributed: 1},
+		},
+		"last member port": {
+			record: func(a *PortAttributor) {
+				a.record("br0", "eth0", frame)
+				a.record("br0", "eth1", frame)
+			},
+			frame:   frame,
+			ingress: Ingress{Port: "eth1", Attributed: true},
+			stats:   IngressStats{Attributed: 1},
+		},
+		"after the window": {
+			record: func(a *PortAttributor) {
+				a.record("br0", "eth0", frame)
+			},
+			elapsed: defaultIngressWindow,
+			frame:   frame,
+			ingress: Ingress{Port: "br0"},
+			stats:   IngressStats{Fallback: 1},
+		},
+		"bridge only": {
+			record: func(a *PortAttributor) {
+				a.record("br0", "eth0", other)
+			},
+			frame:   frame,
+			ingress: Ingress{Port: "br0"},
+			stats:   IngressStats{Fallback: 1},
+		},
+		"member of another bridge": {
+			record: func(a *PortAttributor) {
+				a.record("br1", "eth2", frame)
+			},
+			frame:   frame,
+			ingress: Ingress{Port: "br0"},
+			stats:   IngressStats{Fallback: 1},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := clocktest.NewFake(time.Unix(1700000000, 0))
+			a := NewPortAttributor(WithIngressClock(clock))
+
+			tc.record(a)
+			clock.Advance(tc.elapsed)
+
+			assert.Equal(t, tc.ingress, a.Ingress("br0", tc.frame))
+			assert.Equal(t, map[string]IngressStats{"br0": tc.stats}, a.Stats())
+		})
+	}
+}
+
+func TestPortAttributorLimits(t *testing.T) {
+	t.Parallel()
+
+	clock := clocktest.NewFake(time.Unix(1700000000, 0))
+	a := NewPortAttributor(WithIngressClock(clock), WithIngressLimits(Limits{DedupFrames: 2}),
+		WithIngressWindow(time.Minute))
+
+	for i := range 3 {
+		a.record("br0", "eth0", []byte{byte(i)})
+	}
+
+	assert.Equal(t, uint64(1), a.Evictions())
+	assert.Equal(t, Ingress{Port: "br0"}, a.Ingress("br0", []byte{0}))
+	assert.Equal(t, Ingress{Port: "eth0", Attributed: true}, a.Ingress("br0", []byte{2}))
+
+	// the expired frames aren't evictions
+	clock.Advance(time.Minute)
+	a.record("br0", "eth0", []byte{3})
+
+	assert.Equal(t, uint64(1), a.Evictions())
+}
+
+func TestPortAttributorServe(t *testing.T) {
+	t.Parallel()
+
+	received := []byte{0x01, 0x02, 0x03}
+	sent := []byte{0x04, 0x05, 0x06}
+
+	a := NewPortAttributor(WithIngressClock(clocktest.NewFake(time.Unix(1700000000, 0))))
+	r := &directedFrames{
+		frames:     [][]byte{received, sent},
+		directions: []capture.Direction{capture.DirectionInbound, capture.DirectionOutbound},
+	}
+
+	require.NoError(t, a.Serve(context.Background(), "br0", "eth0", r))
+
+	// the frames the bridge forwards to the port didn't enter on it
+	assert.Equal(t, Ingress{Port: "eth0", Attributed: true}, a.Ingress("br0", received))
+	assert.Equal(t, Ingress{Port: "br0"}, a.Ingress("br0", sent))
+}
+
+// TestServicePortAttributor captures the same frames on a member port and
+// on its bridge, the Results of the bridge are attributed to the port
+func TestServicePortAttributor(t *testing.T) {
+	t.Parallel()
+
+	var recording bytes.Buffer
+
+	w, err := capture.NewPcapWriter(&recording, 65535)
+	require.NoError(t, err)
+
+	for i := range 4 {
+		frame := buildFrame(t, ethernet.NewFrame().Src(testPXEClient).
+			ARPRequest(netip.AddrFrom4([4]byte{10, 0, 0, byte(10 + i)}), netip.MustParseAddr("10.0.0.1")), nil)
+
+		require.NoError(t, w.WriteFrame(frame, capture.Metadata{Timestamp: time.Unix(1700000000, 0),
+			Length: len(frame)}))
+	}
+
+	a := NewPortAttributor(WithIngressClock(clocktest.NewFake(time.Unix(1700000000, 0))))
+
+	member, err := capture.NewPcapReader(bytes.NewReader(recording.Bytes()), "eth0")
+	require.NoError(t, err)
+	require.NoError(t, a.Serve(context.Background(), "br0", "eth0", member))
+
+	bridge, err := capture.NewPcapReader(bytes.NewReader(recording.Bytes()), "br0")
+	require.NoError(t, err)
+
+	svc := NewService("br0", WithPortAttributor(a))
+	resultC := make(chan Result)
+	errC := make(chan error, 1)
+
+	go func() { errC <- svc.Serve(context.Background(), bridge, resultC) }()
+
+	var n int
+
+	for res := range resultC {
+		n++
+
+		assert.Equal(t, &Ingress{Port: "eth0", Attributed: true}, res.Ingress)
+	}
+
+	require.NoError(t, <-errC)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, map[string]IngressStats{"br0": {Attributed: 4}}, a.Stats())
+
+	// a Service without a PortAttributor has no member port to capture
+	assert.ErrorIs(t, NewService("br0").CaptureMember(context.Background(), "eth0"), ErrPortAttributionDisabled)
+}
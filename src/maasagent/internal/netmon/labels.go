@@ -0,0 +1,93 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+)
+
+// ErrInvalidLabels is returned for labels with an empty key
+var ErrInvalidLabels = errors.New("invalid labels")
+
+// ValidateLabels returns an error matching ErrInvalidLabels if a key of
+// labels is empty
+func ValidateLabels(labels map[string]string) error {
+	if _, ok := labels[""]; ok {
+		return fmt.Errorf("%w: empty key", ErrInvalidLabels)
+	}
+
+	return nil
+}
+
+// WithLabels sets the labels of the interface, such as its fabric and
+// space, which every Result and SnapshotBinding of the Service carries
+func WithLabels(labels map[string]string) ServiceOption {
+	return func(s *Service) {
+		s.setLabels(labels)
+	}
+}
+
+// SetLabels replaces the labels of the interface. The bindings aren't
+// touched, their snapshots carry the new labels from then on, and so do
+// the Results produced after.
+func (s *Service) SetLabels(labels map[string]string) error {
+	if err := ValidateLabels(labels); err != nil {
+		return err
+	}
+
+	s.setLabels(labels)
+
+	return nil
+}
+
+func (s *Service) setLabels(labels map[string]string) {
+	if len(labels) == 0 {
+		s.labels.Store(nil)
+		return
+	}
+
+	// the map is shared by the Results, it is never modified once stored
+	labels = maps.Clone(labels)
+	s.labels.Store(&labels)
+}
+
+// Labels returns the labels of the interface
+func (s *Service) Labels() map[string]string {
+	return maps.Clone(s.currentLabels())
+}
+
+// currentLabels returns the labels shared by the Results, nil without any
+func (s *Service) currentLabels() map[string]string {
+	if l := s.labels.Load(); l != nil {
+		return *l
+	}
+
+	return nil
+}
+
+// label sets the labels of the interface on res
+func (s *Service) label(res []Result) {
+	labels := s.currentLabels()
+	if labels == nil {
+		return
+	}
+
+	for i := range res {
+		res[i].Labels = labels
+	}
+}
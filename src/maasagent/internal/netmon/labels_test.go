@@ -0,0 +1,128 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+func TestServiceSetLabels(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0")
+	assert.Nil(t, svc.Labels())
+
+	assert.ErrorIs(t, svc.SetLabels(map[string]string{"": "fabric-0"}), ErrInvalidLabels)
+	assert.Nil(t, svc.Labels())
+
+	labels := map[string]string{"fabric": "fabric-0"}
+	require.NoError(t, svc.SetLabels(labels))
+
+	// the labels of the Service are its own
+	labels["fabric"] = "fabric-1"
+	svc.Labels()["zone"] = "default"
+	assert.Equal(t, map[string]string{"fabric": "fabric-0"}, svc.Labels())
+
+	require.NoError(t, svc.SetLabels(nil))
+	assert.Nil(t, svc.Labels())
+}
+
+// TestServiceRelabel relabels the bindings of a Service without losing
+// their history
+func TestServiceRelabel(t *testing.T) {
+	t.Parallel()
+
+	clock := clocktest.NewFake(time.Unix(1700000000, 0))
+	h := NewHistory()
+	svc := NewService("eth0", WithClock(clock), WithHistory(h), WithLabels(map[string]string{"fabric": "fabric-0"}))
+
+	ip := netip.MustParseAddr("10.0.0.1")
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+
+	res := svc.Observe(ObservationARPReply, ip, mac, nil, time.Time{})
+	require.Len(t, res, 1)
+	assert.Equal(t, map[string]string{"fabric": "fabric-0"}, res[0].Labels)
+
+	before := svc.Snapshot()
+	require.Len(t, before.Bindings, 1)
+	assert.Equal(t, map[string]string{"fabric": "fabric-0"}, before.Bindings[0].Labels)
+
+	labels := map[string]string{"fabric": "fabric-1", "space": "management"}
+	require.NoError(t, svc.SetLabels(labels))
+
+	after := svc.Snapshot()
+	diff := after.Diff(before)
+	assert.Empty(t, diff.Added)
+	assert.Empty(t, diff.Removed)
+	require.Len(t, diff.Changed, 1)
+	assert.Equal(t, labels, diff.Changed[0].Labels)
+	assert.Equal(t, before.Bindings[0].Time, diff.Changed[0].Time)
+
+	applied, err := diff.Apply(before)
+	require.NoError(t, err)
+	assert.Equal(t, after.Bindings, applied.Bindings)
+
+	res = svc.Observe(ObservationARPReply, netip.MustParseAddr("10.0.0.2"), mac, nil, time.Time{})
+	require.Len(t, res, 1)
+	assert.Equal(t, labels, res[0].Labels)
+
+	assert.Equal(t, []BindingSpan{{Interface: "eth0", IP: ip.String(), MAC: mac.String(), From: 1700000000}},
+		h.BindingsForIP(ip, time.Unix(0, 0), clock.Now()))
+}
+
+func TestServiceServeLabels(t *testing.T) {
+	t.Parallel()
+
+	var recording bytes.Buffer
+
+	w, err := capture.NewPcapWriter(&recording, 65535)
+	require.NoError(t, err)
+
+	frame := buildFrame(t, ethernet.NewFrame().Src(testPXEClient).
+		ARPRequest(netip.MustParseAddr("10.0.0.10"), netip.MustParseAddr("10.0.0.1")), nil)
+	require.NoError(t, w.WriteFrame(frame, capture.Metadata{Timestamp: time.Unix(1700000000, 0),
+		Length: len(frame)}))
+
+	r, err := capture.NewPcapReader(bytes.NewReader(recording.Bytes()), "eth0")
+	require.NoError(t, err)
+
+	svc := NewService("eth0", WithLabels(map[string]string{"zone": "az1"}))
+	resultC := make(chan Result)
+	errC := make(chan error, 1)
+
+	go func() { errC <- svc.Serve(context.Background(), r, resultC) }()
+
+	var res []Result
+	for r := range resultC {
+		res = append(res, r)
+	}
+
+	require.NoError(t, <-errC)
+	require.Len(t, res, 1)
+	assert.Equal(t, map[string]string{"zone": "az1"}, res[0].Labels)
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/checksum"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	// layerSnapLen keeps the whole of an untruncated frame, what the
+	// registered decoders need of it isn't known
+	layerSnapLen = 1522
+)
+
+// observeLayer decodes a frame with the protocols registered when the
+// Service was created, the Result carries the layer the decoder returned
+func (s *Service) observeLayer(frame []byte, src net.HardwareAddr, vid *uint16, timestamp time.Time) (Result, bool) {
+	layer, err := s.layers.Decode(frame)
+	if err != nil {
+		if !errors.Is(err, ethernet.ErrNotRegistered) {
+			log.Debug().Err(err).Msg("skipping frame the registered decoder rejected")
+		}
+
+		return Result{}, false
+	}
+
+	if timestamp.IsZero() {
+		timestamp = s.clock.Now()
+	}
+
+	return Result{
+		MAC:   src.String(),
+		VID:   vid,
+		Time:  timestamp.Unix(),
+		Event: EventCustomLayer,
+		Layer: layer,
+	}, true
+}
+
+// layerFilter prepends to base the instructions accepting the frames of the
+// registered ethertypes, and the UDP datagrams to or from the registered
+// ports, tagged or not. The datagrams are looked for behind an IPv4 header
+// without options, unless a fragment, or an IPv6 header without extension
+// headers, as the registry decodes them.
+func layerFilter(base []bpf.RawInstruction, types []ethernet.EthernetType,
+	ports []uint16) ([]bpf.RawInstruction, error) {
+	const (
+		ipv4VersionOff  = 14
+		ipv4FragmentOff = 14 + 6
+		ipv4ProtocolOff = 14 + 9
+		ipv4PortsOff    = 14 + 20
+		ipv6NextOff     = 14 + 6
+		ipv6PortsOff    = 14 + 40
+	)
+
+	// the targets of the jumps, resolved once every label is placed
+	const (
+		next = iota
+		labelTypes
+		labelIPv4
+		labelIPv6
+		labelAccept
+		labelBase
+	)
+
+	type jump struct {
+		at      int
+		onTrue  int
+		onFalse int
+	}
+
+	if len(types) == 0 && len(ports) == 0 {
+		return base, nil
+	}
+
+	var (
+		insns  []bpf.Instruction
+		jumps  []jump
+		labels = make(map[int]int)
+	)
+
+	place := func(label int) {
+		labels[label] = len(insns)
+	}
+	jumpIf := func(cond bpf.JumpTest, val uint32, onTrue, onFalse int) {
+		jumps = append(jumps, jump{at: len(insns), onTrue: onTrue, onFalse: onFalse})
+		insns = append(insns, bpf.JumpIf{Cond: cond, Val: val})
+	}
+	jumpTo := func(label int) {
+		jumps = append(jumps, jump{at: len(insns), onTrue: label})
+		insns = append(insns, bpf.Jump{})
+	}
+	matchPorts := func(off uint32) {
+		insns = append(insns, bpf.LoadIndirect{Off: off, Size: 2})
+
+		for _, port := range ports {
+			jumpIf(bpf.JumpEqual, uint32(port), labelAccept, next)
+		}
+	}
+
+	insns = append(insns,
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.LoadConstant{Dst: bpf.RegX, Val: 0},
+	)
+	jumpIf(bpf.JumpEqual, uint32(ethernet.EthernetTypeVLAN), next, labelTypes)
+	// the headers follow the tag
+	insns = append(insns,
+		bpf.LoadAbsolute{Off: 16, Size: 2},
+		bpf.LoadConstant{Dst: bpf.RegX, Val: 4},
+	)
+	place(labelTypes)
+
+	for _, t := range types {
+		jumpIf(bpf.JumpEqual, uint32(t), labelAccept, next)
+	}
+
+	if len(ports) > 0 {
+		jumpIf(bpf.JumpEqual, uint32(ethernet.EthernetTypeIPv4), labelIPv4, next)
+		jumpIf(bpf.JumpEqual, uint32(ethernet.EthernetTypeIPv6), labelIPv6, labelBase)
+
+		place(labelIPv4)
+		insns = append(insns, bpf.LoadIndirect{Off: ipv4VersionOff, Size: 1})
+		jumpIf(bpf.JumpEqual, 0x45, next, labelBase)
+		insns = append(insns, bpf.LoadIndirect{Off: ipv4ProtocolOff, Size: 1})
+		jumpIf(bpf.JumpEqual, checksum.ProtocolUDP, next, labelBase)
+		insns = append(insns, bpf.LoadIndirect{Off: ipv4FragmentOff, Size: 2})
+		jumpIf(bpf.JumpBitsSet, 0x3fff, labelBase, next)
+		matchPorts(ipv4PortsOff)
+		matchPorts(ipv4PortsOff + 2)
+		jumpTo(labelBase)
+
+		place(labelIPv6)
+		insns = append(insns, bpf.LoadIndirect{Off: ipv6NextOff, Size: 1})
+		jumpIf(bpf.JumpEqual, checksum.ProtocolUDP, next, labelBase)
+		matchPorts(ipv6PortsOff)
+		matchPorts(ipv6PortsOff + 2)
+	}
+
+	jumpTo(labelBase)
+	place(labelAccept)
+	insns = append(insns, bpf.RetConstant{Val: layerSnapLen})
+	place(labelBase)
+
+	// the registry holds a few ethertypes and ports, the longest jump is
+	// over less than 255 instructions
+	skip := func(at, label int) uint8 {
+		if label == next {
+			return 0
+		}
+
+		return uint8(labels[label] - at - 1) //nolint:gosec // bounded by the size of the registry
+	}
+
+	for _, j := range jumps {
+		switch insn := insns[j.at].(type) {
+		case bpf.JumpIf:
+			insn.SkipTrue, insn.SkipFalse = skip(j.at, j.onTrue), skip(j.at, j.onFalse)
+			insns[j.at] = insn
+		case bpf.Jump:
+			insn.Skip = uint32(skip(j.at, j.onTrue))
+			insns[j.at] = insn
+		}
+	}
+
+	prefix, err := bpf.Assemble(insns)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(prefix, base...), nil
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	testLayerType ethernet.EthernetType = 0x88b5
+	testLayerPort uint16                = 4500
+)
+
+// testLayer is what the test protocol decodes, its payload
+type testLayer string
+
+func (testLayer) LayerName() string { return "test" }
+
+func init() {
+	decode := func(payload []byte) (ethernet.Layer, error) {
+		if len(payload) == 0 {
+			return nil, errors.New("empty test layer")
+		}
+
+		return testLayer(payload), nil
+	}
+
+	if err := ethernet.RegisterEtherType(testLayerType, decode); err != nil {
+		panic(err)
+	}
+
+	if err := ethernet.RegisterUDPPort(testLayerPort, decode); err != nil {
+		panic(err)
+	}
+}
+
+func layerUDPFrame(tb testing.TB, src, dst string, vid *uint16) []byte {
+	tb.Helper()
+
+	return buildFrame(tb, ethernet.NewFrame().Src(testPXEClient).
+		UDP(netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst), []byte("test")), vid)
+}
+
+func TestLayerFilter(t *testing.T) {
+	t.Parallel()
+
+	base, err := arpFilter()
+	require.NoError(t, err)
+
+	filter, err := layerFilter(base, []ethernet.EthernetType{testLayerType, 0x88b6}, []uint16{testLayerPort, 4501})
+	require.NoError(t, err)
+
+	vm, err := bpf.NewVM(disassemble(t, filter))
+	require.NoError(t, err)
+
+	vid := uint16(10)
+	fragment := layerUDPFrame(t, "10.0.0.2:40000", "10.0.0.1:4500", nil)
+	fragment[14+6] = 0x20 // more fragments
+
+	testcases := map[string]struct {
+		in  []byte
+		len int
+	}{
+		"ethertype": {
+			in:  buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Payload(0x88b6, []byte("test")), nil),
+			len: layerSnapLen,
+		},
+		"802.1Q ethertype": {
+			in:  buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Payload(testLayerType, []byte("test")), &vid),
+			len: layerSnapLen,
+		},
+		"destination port": {
+			in:  layerUDPFrame(t, "10.0.0.2:40000", "10.0.0.1:4501", nil),
+			len: layerSnapLen,
+		},
+		"802.1Q source port": {
+			in:  layerUDPFrame(t, "10.0.0.2:4500", "10.0.0.1:40000", &vid),
+			len: layerSnapLen,
+		},
+		"IPv6 destination port": {
+			in:  layerUDPFrame(t, "[fe80::2]:40000", "[fe80::1]:4500", nil),
+			len: layerSnapLen,
+		},
+		"802.1Q IPv6 source port": {
+			in:  layerUDPFrame(t, "[fe80::2]:4501", "[fe80::1]:40000", &vid),
+			len: layerSnapLen,
+		},
+		"ARP": {
+			in:  []byte{12: 0x08, 13: 0x06, 41: 0},
+			len: snapLen,
+		},
+		"802.1Q ARP": {
+			in:  []byte{12: 0x81, 13: 0x00, 16: 0x08, 17: 0x06, 45: 0},
+			len: snapLen,
+		},
+		"other port": {
+			in: layerUDPFrame(t, "10.0.0.2:40000", "10.0.0.1:4789", nil),
+		},
+		"other IPv6 port": {
+			in: layerUDPFrame(t, "[fe80::2]:40000", "[fe80::1]:4789", nil),
+		},
+		"fragment": {
+			in: fragment,
+		},
+		"other ethertype": {
+			in: buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Payload(0x88b7, []byte("test")), nil),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			n, err := vm.Run(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.len, n)
+		})
+	}
+}
+
+func TestServiceLayer(t *testing.T) {
+	t.Parallel()
+
+	vid := uint16(10)
+
+	vlans, err := NewVLANDiscovery(testRackMAC, 1, 20)
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		in    []byte
+		md    capture.Metadata
+		opts  []ServiceOption
+		layer ethernet.Layer
+		vid   *uint16
+	}{
+		"ethertype": {
+			in:    buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Payload(testLayerType, []byte("eth")), nil),
+			layer: testLayer("eth"),
+		},
+		"802.1Q ethertype": {
+			in:    buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Payload(testLayerType, []byte("eth")), &vid),
+			layer: testLayer("eth"),
+			vid:   &vid,
+		},
+		"UDP port": {
+			in:    layerUDPFrame(t, "10.0.0.2:40000", "10.0.0.1:4500", nil),
+			layer: testLayer("test"),
+		},
+		"UDP port with the port authentication detector": {
+			in:    layerUDPFrame(t, "10.0.0.2:40000", "10.0.0.1:4500", &vid),
+			opts:  []ServiceOption{WithPortAuthDetector(NewPortAuthDetector())},
+			layer: testLayer("test"),
+			vid:   &vid,
+		},
+		"IPv6 UDP port with the DAD detector": {
+			in:    layerUDPFrame(t, "[fe80::2]:4500", "[fe80::1]:40000", nil),
+			opts:  []ServiceOption{WithDADDetector(NewDADDetector())},
+			layer: testLayer("test"),
+		},
+		"802.1Q ethertype with the VLAN discovery": {
+			in:    buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Payload(testLayerType, []byte("eth")), &vid),
+			opts:  []ServiceOption{WithVLANDiscovery(vlans)},
+			layer: testLayer("eth"),
+			vid:   &vid,
+		},
+		"decoder error": {
+			in: buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Payload(testLayerType, nil), nil),
+		},
+		"other port": {
+			in: layerUDPFrame(t, "10.0.0.2:40000", "10.0.0.1:4789", nil),
+		},
+		"sent by the host": {
+			in: buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Payload(testLayerType, []byte("eth")), nil),
+			md: capture.Metadata{Direction: capture.DirectionOutbound},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := NewService("eth0", append(tc.opts, WithDecoders(DefaultDecoders|DecoderRegistered))...)
+
+			res, err := svc.handleFrame(tc.in, tc.md)
+			require.NoError(t, err)
+
+			if tc.layer == nil {
+				assert.Empty(t, res)
+				return
+			}
+
+			require.Len(t, res, 1)
+			assert.Equal(t, EventCustomLayer, res[0].Event)
+			assert.Equal(t, testPXEClient.String(), res[0].MAC)
+			assert.Equal(t, tc.vid, res[0].VID)
+			assert.Equal(t, tc.layer, res[0].Layer)
+		})
+	}
+}
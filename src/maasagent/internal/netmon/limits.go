@@ -0,0 +1,122 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"cmp"
+	"slices"
+)
+
+const (
+	// defaultBindings holds the neighbors of a /16, more than a segment
+	// MAAS manages usually has
+	defaultBindings = 65536
+	// defaultDuplicateMACs bounds the MACs tracked across interfaces
+	defaultDuplicateMACs = 16384
+	// evictionBatch is the fraction of a full table evicted at once, a
+	// flood of new entries then costs a sort every len/evictionBatch
+	// insertions rather than a scan on each
+	evictionBatch = 8
+)
+
+// Limits bounds the state kept per remote host, which a hostile segment
+// sending frames from millions of forged addresses would otherwise grow
+// without end. Every component evicts its least recently observed entries
+// first once at its limit, and counts them. A zero field keeps the default
+// of the component.
+//
+// With DefaultLimits a Service and the detectors it shares stay under
+// 64 MiB however many hosts they observe.
+type Limits struct {
+	// Bindings bounds the bindings of a Service, and so its challengers
+	Bindings int
+	// EvidenceKeys bounds the IPs, and the MACs, an EvidenceLog keeps a
+	// history for
+	EvidenceKeys int
+	// DuplicateMACs bounds the MACs a DuplicateMACDetector tracks, and
+	// the duplicates it remembers reporting
+	DuplicateMACs int
+	// DADProbes bounds the probes a DADDetector keeps for matching
+	DADProbes int
+	// ProxyCandidates bounds the MACs a ProxyDetector counts the answers
+	// of, and the proxies it classified
+	ProxyCandidates int
+	// DedupFrames bounds the frames a Deduplicator remembers
+	DedupFrames int
+	// ReorderedObservations bounds the observations a Reorderer holds
+	ReorderedObservations int
+	// IdentityMACs bounds the MACs a Correlator keeps the evidence of
+	IdentityMACs int
+}
+
+// DefaultLimits returns the limits the components have unless configured
+func DefaultLimits() Limits {
+	return Limits{
+		Bindings:        defaultBindings,
+		EvidenceKeys:    defaultEvidenceKeys,
+		DuplicateMACs:   defaultDuplicateMACs,
+		DADProbes:       defaultDADProbes,
+		ProxyCandidates: defaultProxyCandidates,
+		DedupFrames:     defaultDedupFrames,
+
+		ReorderedObservations: defaultReorderedObservations,
+		IdentityMACs:          defaultIdentityMACs,
+	}
+}
+
+// evictOldest deletes the least recently observed entries of m, a batch of
+// them and at least one, and returns how many it deleted. last returns when
+// an entry was observed, in any unit that orders them.
+func evictOldest[K comparable, V any, O cmp.Ordered](m map[K]V, last func(V) O) uint64 {
+	if len(m) == 0 {
+		return 0
+	}
+
+	keys := make([]K, 0, len(m))
+	lasts := make([]O, 0, len(m))
+
+	for k, v := range m {
+		keys = append(keys, k)
+		lasts = append(lasts, last(v))
+	}
+
+	// only the cutoff is needed, sorting a copy of the times is cheaper
+	// than sorting the entries
+	remaining := max(1, len(keys)/evictionBatch)
+	sorted := slices.Clone(lasts)
+	slices.Sort(sorted)
+	cutoff := sorted[remaining-1]
+
+	var evicted uint64
+
+	// the entries before the cutoff are evicted, then those at the cutoff
+	// until the batch is complete
+	for _, atCutoff := range []bool{false, true} {
+		for i, k := range keys {
+			if remaining == 0 {
+				return evicted
+			}
+
+			if !atCutoff && lasts[i] < cutoff || atCutoff && lasts[i] == cutoff {
+				delete(m, k)
+				remaining--
+				evicted++
+			}
+		}
+	}
+
+	return evicted
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net"
+	"net/netip"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/ndp"
+)
+
+// memoryCeiling is what the state of a Service and the detectors it shares
+// take at most with DefaultLimits, as documented on Limits
+const memoryCeiling = 64 << 20
+
+func TestDefaultLimits(t *testing.T) {
+	t.Parallel()
+
+	// a zero field keeps the default of the component
+	var none Limits
+
+	assert.Equal(t, defaultBindings, NewService("eth0", WithLimits(none)).maxBindings)
+	assert.Equal(t, defaultEvidenceKeys, NewEvidenceLog(WithEvidenceLimits(none)).keys)
+	assert.Equal(t, defaultDuplicateMACs, NewDuplicateMACDetector(WithDuplicateLimits(none)).size)
+	assert.Equal(t, defaultDADProbes, NewDADDetector(WithDADLimits(none)).size)
+	assert.Equal(t, defaultProxyCandidates, NewProxyDetector(WithProxyLimits(none)).size)
+	assert.Equal(t, defaultDedupFrames, NewDeduplicator(WithDedupLimits(none)).size)
+	assert.Equal(t, defaultReorderedObservations, NewReorderer(WithReorderLimits(none)).size)
+	assert.Equal(t, defaultIdentityMACs, NewCorrelator(WithIdentityLimits(none)).size)
+
+	limits := DefaultLimits()
+	limits.Bindings = 10
+
+	assert.Equal(t, 10, NewService("eth0", WithLimits(limits)).maxBindings)
+}
+
+func TestEvictOldest(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		entries int
+		evicted int
+	}{
+		"batch": {
+			entries: 64,
+			evicted: 64 / evictionBatch,
+		},
+		"at least one": {
+			entries: 3,
+			evicted: 1,
+		},
+		"empty": {},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// the entries were last observed in the reverse order of
+			// their keys
+			m := make(map[int]int64, tc.entries)
+			for i := range tc.entries {
+				m[i] = int64(tc.entries - i)
+			}
+
+			n := evictOldest(m, func(last int64) int64 { return last })
+
+			assert.Equal(t, uint64(tc.evicted), n) //nolint:gosec // small test counts
+			assert.Len(t, m, tc.entries-tc.evicted)
+
+			for i := tc.entries - tc.evicted; i < tc.entries; i++ {
+				assert.NotContains(t, m, i)
+			}
+		})
+	}
+}
+
+func TestServiceBindingLimits(t *testing.T) {
+	t.Parallel()
+
+	s := NewService("eth0", WithLimits(Limits{Bindings: 16}))
+	start := time.Unix(1700000000, 0)
+	mac := mustParseMAC("00:16:3e:00:00:01")
+
+	for i := range 16 {
+		s.Observe(ObservationARPRequest, netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}), mac, nil,
+			start.Add(time.Duration(i)*time.Second))
+	}
+
+	// the first binding is observed again, the second one is the least
+	// recently observed
+	s.Observe(ObservationARPRequest, netip.MustParseAddr("10.0.0.0"), mac, nil, start.Add(time.Hour))
+
+	res := s.Observe(ObservationARPRequest, netip.MustParseAddr("10.0.1.0"), mac, nil, start.Add(time.Hour))
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+
+	bindings := s.Bindings()
+	ips := make([]string, 0, len(bindings))
+
+	for _, b := range bindings {
+		ips = append(ips, b.IP)
+	}
+
+	assert.Len(t, bindings, 16-16/evictionBatch+1)
+	assert.Equal(t, uint64(16/evictionBatch), s.Evictions())
+	assert.Contains(t, ips, "10.0.0.0")
+	assert.NotContains(t, ips, "10.0.0.1")
+}
+
+// heapAlloc returns the bytes of the live objects of the heap
+func heapAlloc() uint64 {
+	var stats runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+
+	return stats.HeapAlloc
+}
+
+// TestLimitsBoundMemory floods a Service and its detectors with hosts which
+// are all new, as a segment of forged addresses would, and checks what they
+// keep stays within the limits. The test isn't parallel, the other tests
+// would add to the heap it measures.
+func TestLimitsBoundMemory(t *testing.T) {
+	hosts := 1 << 20
+	if testing.Short() {
+		hosts = 1 << 17
+	}
+
+	limits := DefaultLimits()
+	evidence := NewEvidenceLog(WithEvidenceLimits(limits))
+	duplicates := NewDuplicateMACDetector(WithDuplicateLimits(limits))
+	dad := NewDADDetector(WithDADLimits(limits))
+	proxies := NewProxyDetector(WithProxyLimits(limits))
+	s := NewService("eth0", WithLimits(limits), WithEvidenceLog(evidence), WithDuplicateMACDetector(duplicates),
+		WithDADDetector(dad), WithProxyDetector(proxies))
+
+	before := heapAlloc()
+	start := time.Unix(1700000000, 0)
+	probe := ndp.Message{Type: ndp.TypeNeighborSolicitation}
+
+	for i := range hosts {
+		b := [4]byte{byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i)}
+		mac := net.HardwareAddr{0x02, 0x00, b[0], b[1], b[2], b[3]}
+		ip := netip.AddrFrom4([4]byte{10, b[1], b[2], b[3]})
+		timestamp := start.Add(time.Duration(i) * time.Millisecond)
+
+		s.Observe(ObservationARPReply, ip, mac, nil, timestamp)
+
+		// every MAC is a duplicate, and every 16 hosts one is a proxy
+		duplicates.Observe(mac, "eth0", nil, timestamp)
+		duplicates.Observe(mac, "eth1", nil, timestamp)
+		proxies.Observe(net.HardwareAddr{0x02, 0x01, b[0], b[1], b[2], b[3] >> 4}, ip, false)
+
+		probe.Target = netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 12: b[0], 13: b[1], 14: b[2], 15: b[3]})
+		dad.Observe(probe, netip.IPv6Unspecified(), mac, "eth0", nil, timestamp)
+	}
+
+	after := heapAlloc()
+
+	var used uint64
+	if after > before {
+		used = after - before
+	}
+
+	t.Logf("%d hosts kept in %d KiB", hosts, used>>10)
+
+	assert.Less(t, used, uint64(memoryCeiling))
+
+	var challengers int
+
+	s.table.update(func(sh *bindingShard) { challengers += len(sh.challengers) })
+
+	assert.LessOrEqual(t, s.table.size(), limits.Bindings)
+	assert.LessOrEqual(t, challengers, limits.Bindings)
+	assert.LessOrEqual(t, evidence.byIP.Len(), limits.EvidenceKeys)
+	assert.LessOrEqual(t, evidence.byMAC.Len(), limits.EvidenceKeys)
+	assert.LessOrEqual(t, len(duplicates.sightings), limits.DuplicateMACs)
+	assert.LessOrEqual(t, len(duplicates.reported), limits.DuplicateMACs)
+	assert.LessOrEqual(t, len(dad.probes), limits.DADProbes)
+	assert.LessOrEqual(t, len(proxies.candidates), limits.ProxyCandidates)
+	assert.LessOrEqual(t, len(proxies.proxies), limits.ProxyCandidates)
+
+	for name, evictions := range map[string]uint64{
+		"bindings":   s.Evictions(),
+		"evidence":   evidence.Evictions(),
+		"duplicates": duplicates.Evictions(),
+		"dad":        dad.Evictions(),
+		"proxies":    proxies.Evictions(),
+	} {
+		assert.NotZero(t, evictions, name)
+	}
+
+	runtime.KeepAlive(s)
+}
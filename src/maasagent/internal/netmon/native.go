@@ -0,0 +1,151 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/capture"
+)
+
+// ErrInvalidNativeVLAN is returned for a native VLAN outside of 0-4094
+var ErrInvalidNativeVLAN = errors.New("invalid native VLAN")
+
+// NativeVLANMismatch is the native VLAN configured for an interface and the
+// one its switch port advertises, as Protocol tells, of an
+// EventNativeVLANMismatch
+type NativeVLANMismatch struct {
+	Protocol   string `json:"protocol"`
+	Configured uint16 `json:"configured"`
+	Advertised uint16 `json:"advertised"`
+}
+
+// WithNativeVLAN attributes the frames the interface receives untagged to
+// vid, the native VLAN of the trunk it is connected to, see SetNativeVLAN
+func WithNativeVLAN(vid uint16) ServiceOption {
+	return func(s *Service) {
+		if vid <= maxVID {
+			s.native.Store(uint32(vid))
+		}
+	}
+}
+
+// SetNativeVLAN replaces the native VLAN of the interface, 0 for none. The
+// frames received untagged are attributed to it: their Results have it as
+// VID and are marked Untagged. Without one, they are attributed to the
+// native VLAN the switch advertises when the Service has a Topology, and
+// to none otherwise.
+//
+// The bindings and the history aren't touched, the observations made after
+// are attributed to vid.
+func (s *Service) SetNativeVLAN(vid uint16) error {
+	if vid > maxVID {
+		return fmt.Errorf("%w: %d", ErrInvalidNativeVLAN, vid)
+	}
+
+	s.native.Store(uint32(vid))
+
+	return nil
+}
+
+// NativeVLAN returns the VLAN the frames received untagged are attributed
+// to, 0 for none, and true when it was advertised rather than configured
+func (s *Service) NativeVLAN() (uint16, bool) {
+	if vid := s.native.Load(); vid != 0 {
+		return uint16(vid), false //nolint:gosec // at most maxVID
+	}
+
+	vid := s.advertised.Load()
+
+	return uint16(vid), vid != 0 //nolint:gosec // a VID of 12 bits
+}
+
+// nativeVLAN returns the VLAN the frames received untagged are attributed
+// to, nil for none. Each frame is given its own, for a change not to
+// relabel the Results already made.
+func (s *Service) nativeVLAN() *uint16 {
+	vid, _ := s.NativeVLAN()
+	if vid == 0 {
+		return nil
+	}
+
+	// the frames of the interfaces without one don't allocate
+	native := vid
+
+	return &native
+}
+
+// markUntagged marks the Results of a frame received untagged and
+// attributed to the native VLAN vid, those holding that very VID: the
+// Results of other frames the observers held back have their own.
+func markUntagged(res []Result, vid *uint16) {
+	for i := range res {
+		if res[i].VID == vid {
+			res[i].Untagged = true
+		}
+	}
+}
+
+// observeNativeVLAN records the native VLAN advertised to the interface
+// once its Topology read a frame. It returns an EventNativeVLANMismatch
+// when it differs from the one configured, once until either changes.
+func (s *Service) observeNativeVLAN(frame []byte, md capture.Metadata) []Result {
+	report, ok := s.topology.Report(s.iface)
+	if !ok {
+		return nil
+	}
+
+	advertised := report.Upstream.NativeVLAN
+	if report.Stale {
+		advertised = 0
+	}
+
+	s.advertised.Store(uint32(advertised))
+
+	configured := uint16(s.native.Load()) //nolint:gosec // at most maxVID
+	if configured == 0 || advertised == 0 || configured == advertised {
+		s.mismatch.Store(0)
+		return nil
+	}
+
+	mismatch := uint32(configured)<<16 | uint32(advertised)
+	if s.mismatch.Swap(mismatch) == mismatch {
+		return nil
+	}
+
+	timestamp := md.Timestamp
+	if timestamp.IsZero() {
+		timestamp = s.clock.Now()
+	}
+
+	log.Warn().Str("iface", s.iface).Str("protocol", report.Sources[0]).Uint16("configured", configured).
+		Uint16("advertised", advertised).Msg("native VLAN differs from the one advertised")
+
+	return []Result{{
+		MAC:   net.HardwareAddr(frame[6:12]).String(),
+		Time:  timestamp.Unix(),
+		Event: EventNativeVLANMismatch,
+		NativeVLAN: &NativeVLANMismatch{
+			Protocol:   report.Sources[0],
+			Configured: configured,
+			Advertised: advertised,
+		},
+	}}
+}
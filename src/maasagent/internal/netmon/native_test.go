@@ -0,0 +1,204 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+// nativeARP returns an ARP request of testPXEClient from ip, tagged with vid
+// unless nil
+func nativeARP(t *testing.T, ip string, vid *uint16) []byte {
+	t.Helper()
+
+	return buildFrame(t, ethernet.NewFrame().Src(testPXEClient).ARPRequest(netip.MustParseAddr(ip),
+		netip.MustParseAddr("10.0.0.254")), vid)
+}
+
+func TestServiceNativeVLAN(t *testing.T) {
+	t.Parallel()
+
+	native := uint16(10)
+	other := uint16(20)
+
+	testcases := map[string]struct {
+		md       capture.Metadata
+		vid      *uint16
+		out      *uint16
+		untagged bool
+	}{
+		"untagged": {
+			out:      &native,
+			untagged: true,
+		},
+		"tagged": {
+			vid: &other,
+			out: &other,
+		},
+		"tagged with the native VLAN": {
+			vid: &native,
+			out: &native,
+		},
+		"tag stripped by the NIC": {
+			md:  capture.Metadata{VLAN: capture.VLANInfo{TCI: 20, Valid: true}},
+			out: &other,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := NewService("eth0", WithNativeVLAN(native))
+
+			res, err := svc.handleFrame(nativeARP(t, "10.0.0.1", tc.vid), tc.md)
+			require.NoError(t, err)
+			require.Len(t, res, 1)
+			assert.Equal(t, tc.out, res[0].VID)
+			assert.Equal(t, tc.untagged, res[0].Untagged)
+		})
+	}
+}
+
+func TestServiceSetNativeVLAN(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0")
+
+	res, err := svc.handleFrame(nativeARP(t, "10.0.0.1", nil), capture.Metadata{})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Nil(t, res[0].VID)
+	assert.False(t, res[0].Untagged)
+
+	require.NoError(t, svc.SetNativeVLAN(10))
+
+	first, err := svc.handleFrame(nativeARP(t, "10.0.0.2", nil), capture.Metadata{})
+	require.NoError(t, err)
+	require.Len(t, first, 1)
+
+	require.NoError(t, svc.SetNativeVLAN(20))
+
+	res, err = svc.handleFrame(nativeARP(t, "10.0.0.3", nil), capture.Metadata{})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, uint16(20), *res[0].VID)
+	assert.True(t, res[0].Untagged)
+
+	// the observations made before keep their VLAN
+	assert.Equal(t, uint16(10), *first[0].VID)
+	assert.True(t, first[0].Untagged)
+
+	vids := make(map[string]uint16)
+	for _, b := range svc.Snapshot().Bindings {
+		if b.VID != nil {
+			vids[b.IP] = *b.VID
+		}
+	}
+
+	assert.Equal(t, map[string]uint16{"10.0.0.2": 10, "10.0.0.3": 20}, vids)
+
+	assert.ErrorIs(t, svc.SetNativeVLAN(4095), ErrInvalidNativeVLAN)
+
+	vid, advertised := svc.NativeVLAN()
+	assert.Equal(t, uint16(20), vid)
+	assert.False(t, advertised)
+}
+
+func TestServiceNativeVLANAdvertised(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	svc := NewService("eth0", WithClock(clk), WithTopology(NewTopology(WithTopologyClock(clk))),
+		WithDecoders(DefaultDecoders|DecoderLLDP|DecoderCDP))
+
+	res, err := svc.handleFrame(lldpAdvertisement("sw1", "Ethernet1/12", 120, 30), capture.Metadata{})
+	require.NoError(t, err)
+	assert.Empty(t, res, "nothing is configured to differ from")
+
+	vid, advertised := svc.NativeVLAN()
+	assert.Equal(t, uint16(30), vid)
+	assert.True(t, advertised)
+
+	res, err = svc.handleFrame(nativeARP(t, "10.0.0.1", nil), capture.Metadata{})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, uint16(30), *res[0].VID)
+	assert.True(t, res[0].Untagged)
+
+	// the VLAN configured takes precedence
+	require.NoError(t, svc.SetNativeVLAN(10))
+
+	res, err = svc.handleFrame(nativeARP(t, "10.0.0.2", nil), capture.Metadata{})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, uint16(10), *res[0].VID)
+
+	// once the advertisement is stale, the frames are attributed to none
+	require.NoError(t, svc.SetNativeVLAN(0))
+	clk.Advance(121 * time.Second)
+
+	res, err = svc.handleFrame(cdpAdvertisement("sw1", "Ethernet1/12", 0, 0), capture.Metadata{})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	vid, _ = svc.NativeVLAN()
+	assert.Zero(t, vid)
+}
+
+func TestServiceNativeVLANMismatch(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0", WithNativeVLAN(10), WithTopology(NewTopology()),
+		WithDecoders(DefaultDecoders|DecoderLLDP))
+
+	advertise := func(vlan uint16) []Result {
+		t.Helper()
+
+		res, err := svc.handleFrame(lldpAdvertisement("sw1", "Ethernet1/12", 120, vlan), capture.Metadata{})
+		require.NoError(t, err)
+
+		return res
+	}
+
+	res := advertise(30)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNativeVLANMismatch, res[0].Event)
+	assert.Equal(t, testSwitchMAC.String(), res[0].MAC)
+	assert.Equal(t, &NativeVLANMismatch{Protocol: TopologyLLDP, Configured: 10, Advertised: 30}, res[0].NativeVLAN)
+
+	// reported once until either changes
+	assert.Empty(t, advertise(30))
+	assert.Empty(t, advertise(10))
+	assert.Len(t, advertise(30), 1)
+
+	require.NoError(t, svc.SetNativeVLAN(20))
+	assert.Len(t, advertise(30), 1)
+
+	// a switch which doesn't tell differs from nothing
+	assert.Empty(t, advertise(0))
+}
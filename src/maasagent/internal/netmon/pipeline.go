@@ -0,0 +1,143 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// The pprof labels of the goroutine running the capture of a Service, a CPU
+// profile attributes the samples of the pipeline to its interface and its
+// stage. The time of a stage is what
+//
+//	go tool pprof -tagfocus netmon.stage=decode cpu.pprof
+//
+// keeps, and
+//
+//	go tool pprof -tags cpu.pprof
+//
+// breaks the whole profile down by interface and by stage.
+const (
+	LabelInterface = "netmon.interface"
+	LabelStage     = "netmon.stage"
+)
+
+// Stage is a stage of the pipeline of a Service, from reading a frame to
+// delivering its Results
+type Stage uint8
+
+const (
+	// StageCapture reads a frame, mostly waiting for one
+	StageCapture Stage = iota
+	// StageDecode decodes the ethernet header and the VLAN tags
+	StageDecode
+	// StageFilter decides whether the frame is observed, deduplicating the
+	// frames of bridges and skipping those of the host
+	StageFilter
+	// StageObserve decodes the protocols and updates the bindings and the
+	// detectors
+	StageObserve
+	// StageDispatch attributes, labels and records the Results, and sends
+	// them to the consumer
+	StageDispatch
+
+	stageCount
+)
+
+var stageNames = [stageCount]string{"capture", "decode", "filter", "observe", "dispatch"}
+
+func (s Stage) String() string {
+	if s < stageCount {
+		return stageNames[s]
+	}
+
+	return fmt.Sprintf("Stage(%d)", uint8(s))
+}
+
+// WithStageTiming times the stages of the pipeline into the
+// netmon.pipeline.duration histogram of meter, by interface and stage. The
+// capture stage isn't timed, its time is the wait for the next frame.
+// Timing costs about a microsecond a frame with the OpenTelemetry SDK,
+// without it the stages are only labeled, which costs a few nanoseconds;
+// BenchmarkPipelineStages compares the three.
+func WithStageTiming(meter metric.Meter) ServiceOption {
+	return func(s *Service) {
+		s.meter = meter
+	}
+}
+
+// registerStageTiming creates the histogram of WithStageTiming
+func (s *Service) registerStageTiming(meter metric.Meter) {
+	s.stageTiming = must(meter.Float64Histogram("netmon.pipeline.duration",
+		metric.WithDescription("Time spent by a frame in a stage of the capture pipeline"),
+		metric.WithUnit("s")))
+}
+
+// pipeline switches the pprof labels of the goroutine running the capture
+// between the stages, and times them with WithStageTiming. The contexts
+// carrying the labels are built once, a switch doesn't allocate.
+type pipeline struct {
+	ctx    context.Context
+	timing metric.Float64Histogram
+	start  time.Time
+	labels [stageCount]context.Context
+	attrs  [stageCount][]metric.RecordOption
+	stage  Stage
+}
+
+// newPipeline returns the pipeline of the goroutine running the capture,
+// ctx carries the labels of the interface. The goroutine enters
+// StageCapture.
+func (s *Service) newPipeline(ctx context.Context) *pipeline {
+	p := &pipeline{ctx: ctx, timing: s.stageTiming, stage: StageCapture}
+
+	for st := range stageCount {
+		p.labels[st] = pprof.WithLabels(ctx, pprof.Labels(LabelStage, st.String()))
+		p.attrs[st] = []metric.RecordOption{metric.WithAttributeSet(attribute.NewSet(
+			attribute.String("interface", s.iface), attribute.String("stage", st.String())))}
+	}
+
+	pprof.SetGoroutineLabels(p.labels[StageCapture])
+
+	return p
+}
+
+// enter switches to stage, recording the time of the stage left. A nil
+// pipeline, that of the frames handled outside a capture, does nothing.
+func (p *pipeline) enter(stage Stage) {
+	if p == nil || p.stage == stage {
+		return
+	}
+
+	if p.timing != nil {
+		now := time.Now()
+
+		if p.stage != StageCapture {
+			p.timing.Record(p.ctx, now.Sub(p.start).Seconds(), p.attrs[p.stage]...)
+		}
+
+		p.start = now
+	}
+
+	p.stage = stage
+	pprof.SetGoroutineLabels(p.labels[stage])
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+// goroutineLabels returns the goroutine profile, where the labels of every
+// goroutine are listed
+func goroutineLabels(t *testing.T) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	require.NoError(t, pprof.Lookup("goroutine").WriteTo(&buf, 1))
+
+	return buf.String()
+}
+
+// labeledReader records the goroutine profile when the pipeline reads its
+// first frame
+type labeledReader struct {
+	capture.FrameReader
+	t       *testing.T
+	profile string
+}
+
+func (r *labeledReader) ReadFrame(buf []byte) (int, error) {
+	if r.profile == "" {
+		r.profile = goroutineLabels(r.t)
+	}
+
+	return r.FrameReader.ReadFrame(buf)
+}
+
+// recordFrames returns a recording of an ARP request from every IP of ips
+func recordFrames(t *testing.T, iface string, ips ...string) capture.FrameReader {
+	t.Helper()
+
+	var recording bytes.Buffer
+
+	w, err := capture.NewPcapWriter(&recording, 65535)
+	require.NoError(t, err)
+
+	for _, ip := range ips {
+		frame := buildFrame(t, ethernet.NewFrame().Src(testPXEClient).
+			ARPRequest(netip.MustParseAddr(ip), netip.MustParseAddr("10.0.0.1")), nil)
+		require.NoError(t, w.WriteFrame(frame, capture.Metadata{Timestamp: time.Unix(1700000000, 0),
+			Length: len(frame)}))
+	}
+
+	r, err := capture.NewPcapReader(bytes.NewReader(recording.Bytes()), iface)
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestStageString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "decode", StageDecode.String())
+	assert.Equal(t, "dispatch", StageDispatch.String())
+	assert.Equal(t, "Stage(9)", Stage(9).String())
+}
+
+func TestPipelineLabels(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth-labels")
+
+	pprof.Do(context.Background(), pprof.Labels(LabelInterface, "eth-labels"), func(ctx context.Context) {
+		p := svc.newPipeline(ctx)
+		assert.Contains(t, goroutineLabels(t), `{"netmon.interface":"eth-labels", "netmon.stage":"capture"}`)
+
+		p.enter(StageObserve)
+		assert.Contains(t, goroutineLabels(t), `{"netmon.interface":"eth-labels", "netmon.stage":"observe"}`)
+	})
+
+	// the labels are restored once done
+	assert.NotContains(t, goroutineLabels(t), `"netmon.interface":"eth-labels"`)
+
+	// the frames handled outside a capture aren't labeled
+	var p *pipeline
+
+	p.enter(StageDecode)
+}
+
+func TestServiceServeStageLabels(t *testing.T) {
+	t.Parallel()
+
+	r := &labeledReader{FrameReader: recordFrames(t, "eth-serve", "10.0.0.10"), t: t}
+	svc := NewService("eth-serve")
+	resultC := make(chan Result)
+	errC := make(chan error, 1)
+
+	go func() { errC <- svc.Serve(context.Background(), r, resultC) }()
+
+	for range resultC {
+	}
+
+	require.NoError(t, <-errC)
+	assert.Contains(t, r.profile, `{"netmon.interface":"eth-serve", "netmon.stage":"capture"}`)
+}
+
+func TestServiceStageTiming(t *testing.T) {
+	t.Parallel()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	r := recordFrames(t, "eth0", "10.0.0.10", "10.0.0.11")
+	svc := NewService("eth0", WithStageTiming(provider.Meter("test")))
+	resultC := make(chan Result)
+	errC := make(chan error, 1)
+
+	go func() { errC <- svc.Serve(context.Background(), r, resultC) }()
+
+	results := 0
+	for range resultC {
+		results++
+	}
+
+	require.NoError(t, <-errC)
+	assert.Equal(t, 2, results)
+
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	require.Len(t, rm.ScopeMetrics[0].Metrics, 1)
+
+	m := rm.ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "netmon.pipeline.duration", m.Name)
+
+	hist, ok := m.Data.(metricdata.Histogram[float64])
+	require.True(t, ok)
+
+	counts := make(map[string]uint64)
+
+	for _, dp := range hist.DataPoints {
+		iface, _ := dp.Attributes.Value("interface")
+		assert.Equal(t, "eth0", iface.AsString())
+
+		stage, _ := dp.Attributes.Value("stage")
+		counts[stage.AsString()] = dp.Count
+	}
+
+	// the wait for the frames isn't timed
+	assert.Equal(t, map[string]uint64{"decode": 2, "filter": 2, "observe": 2, "dispatch": 2}, counts)
+}
@@ -0,0 +1,498 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/checksum"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/eapol"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	// defaultPortAuthWindow is how long an EAP Request-Identity and the
+	// DHCP DISCOVERs of a segment are correlated, PXE firmware retries its
+	// DISCOVERs for about a minute before giving up
+	defaultPortAuthWindow = 2 * time.Minute
+	// defaultPortAuthDiscovers is the number of unanswered DISCOVERs which
+	// make a finding, the retries of a client rather than one in flight
+	defaultPortAuthDiscovers = 3
+	// maxPortAuthSegments and maxPortAuthClients bound the segments and,
+	// per segment, the clients with unanswered DISCOVERs tracked
+	maxPortAuthSegments = 1024
+	maxPortAuthClients  = 256
+	// portAuthSnapLen keeps the whole of a DHCP message, the options come
+	// after the fixed 236 bytes of BOOTP
+	portAuthSnapLen = 1522
+
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+	bootpLen       = 236
+	dhcpOptionType = 53
+	dhcpDiscover   = 1
+	dhcpOffer      = 2
+	// dhcpMaxType is the last message type defined, RFC 7724's DHCPTLS
+	dhcpMaxType   = 18
+	dhcpOptionEnd = 0xff
+)
+
+var dhcpMagicCookie = []byte{99, 130, 83, 99}
+
+// PortAuthFinding reports a segment where PXE is likely blocked by 802.1X
+// port authentication: an authenticator asks for the identity of the
+// supplicants while the DHCP DISCOVERs go unanswered
+type PortAuthFinding struct {
+	// VID is the VLAN ID of the segment if it is tagged
+	VID       *uint16 `json:"vid"`
+	Interface string  `json:"interface"`
+	// Authenticator is the presentation format of the MAC which last sent
+	// an EAP Request-Identity, usually the switch
+	Authenticator string `json:"authenticator"`
+	// UnansweredDiscovers is the number of DISCOVERs no OFFER answered, sent
+	// by Clients clients
+	UnansweredDiscovers int `json:"unanswered_discovers"`
+	Clients             int `json:"clients"`
+	// Since is the time the finding was raised, LastSeen the time of the
+	// last DISCOVER or Request-Identity of the segment
+	Since    int64 `json:"since"`
+	LastSeen int64 `json:"last_seen"`
+}
+
+type portAuthKey struct {
+	iface string
+	vid   uint16
+}
+
+// unansweredClient counts the DISCOVERs of a client since its last OFFER
+type unansweredClient struct {
+	last      time.Time
+	discovers int
+}
+
+type portAuthSegment struct {
+	// identity is the time of the last Request-Identity, since the time the
+	// finding was raised, zero unless it is
+	identity      time.Time
+	since         time.Time
+	last          time.Time
+	clients       map[[6]byte]unansweredClient
+	vid           *uint16
+	authenticator net.HardwareAddr
+}
+
+// PortAuthDetector correlates the EAPOL and DHCP frames of each segment,
+// an interface and VLAN, to tell when the port of a PXE booting machine is
+// waiting for an 802.1X supplicant the firmware doesn't have. It can be
+// shared by the Services of several interfaces. Only the OFFERs broadcast
+// or sent through the monitored interface are seen, a segment where they
+// are unicast to the client elsewhere looks unanswered.
+type PortAuthDetector struct {
+	clock     clock.Clock
+	segments  map[portAuthKey]*portAuthSegment
+	window    time.Duration
+	threshold int
+	parser    ethernet.ParserOptions
+	mu        sync.Mutex
+}
+
+// PortAuthDetectorOption configures a PortAuthDetector
+type PortAuthDetectorOption func(*PortAuthDetector)
+
+// WithPortAuthWindow sets how long a Request-Identity and the DISCOVERs of
+// a segment are correlated. The DISCOVERs of a raised finding are kept
+// until OFFERs answer them.
+func WithPortAuthWindow(d time.Duration) PortAuthDetectorOption {
+	return func(p *PortAuthDetector) {
+		if d > 0 {
+			p.window = d
+		}
+	}
+}
+
+// WithPortAuthThreshold sets the number of unanswered DISCOVERs which, in
+// the window of a Request-Identity, raise a finding
+func WithPortAuthThreshold(n int) PortAuthDetectorOption {
+	return func(p *PortAuthDetector) {
+		if n > 0 {
+			p.threshold = n
+		}
+	}
+}
+
+// WithPortAuthClock sets the clock timestamping the frames observed without
+// a timestamp
+func WithPortAuthClock(c clock.Clock) PortAuthDetectorOption {
+	return func(p *PortAuthDetector) {
+		p.clock = c
+	}
+}
+
+// WithPortAuthParserOptions sets how the DHCP messages are decoded, see
+// ethernet.Strictness
+func WithPortAuthParserOptions(o ethernet.ParserOptions) PortAuthDetectorOption {
+	return func(p *PortAuthDetector) {
+		p.parser = o
+	}
+}
+
+// NewPortAuthDetector returns a PortAuthDetector
+func NewPortAuthDetector(options ...PortAuthDetectorOption) *PortAuthDetector {
+	d := &PortAuthDetector{
+		clock:     clock.System{},
+		segments:  make(map[portAuthKey]*portAuthSegment),
+		window:    defaultPortAuthWindow,
+		threshold: defaultPortAuthDiscovers,
+	}
+
+	for _, opt := range options {
+		opt(d)
+	}
+
+	return d
+}
+
+// Observe records an EAPOL or DHCP frame received on iface. It returns the
+// finding with EventPortAuthenticationSuspected when the frame raises one
+// for its segment, and with EventPortAuthenticationCleared when an OFFER
+// leaves fewer unanswered DISCOVERs than the threshold. Otherwise, and
+// for the other frames, the Event is 0.
+func (d *PortAuthDetector) Observe(frame []byte, iface string, vid *uint16,
+	timestamp time.Time) (PortAuthFinding, Event) {
+	var (
+		identity bool
+		msg      dhcpMessage
+	)
+
+	if pkt, err := eapol.ParseFrame(frame); err == nil {
+		eap, err := pkt.EAP()
+		if err != nil || !eap.RequestIdentity() {
+			return PortAuthFinding{}, 0
+		}
+
+		identity = true
+	} else if msg = parseDHCP(frame, d.parser); msg.msgType != dhcpDiscover && msg.msgType != dhcpOffer {
+		return PortAuthFinding{}, 0
+	}
+
+	if timestamp.IsZero() {
+		timestamp = d.clock.Now()
+	}
+
+	key := portAuthKey{iface: iface}
+	if vid != nil {
+		key.vid = *vid
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seg := d.segment(key, vid)
+	seg.expire(timestamp, d.window)
+
+	switch {
+	case identity:
+		seg.identity = timestamp
+		seg.authenticator = bytes.Clone(frame[6:12])
+		seg.last = timestamp
+	case msg.msgType == dhcpDiscover:
+		c, ok := seg.clients[msg.chaddr]
+		if !ok && len(seg.clients) >= maxPortAuthClients {
+			evictOldest(seg.clients, func(c unansweredClient) int64 {
+				return c.last.UnixNano()
+			})
+		}
+
+		c.discovers++
+		c.last = timestamp
+		seg.clients[msg.chaddr] = c
+		seg.last = timestamp
+	default:
+		delete(seg.clients, msg.chaddr)
+	}
+
+	discovers := seg.discovers()
+
+	switch {
+	case seg.since.IsZero() && !seg.identity.IsZero() && timestamp.Sub(seg.identity) <= d.window &&
+		discovers >= d.threshold:
+		seg.since = timestamp
+
+		return seg.finding(key.iface), EventPortAuthenticationSuspected
+	case !seg.since.IsZero() && msg.msgType == dhcpOffer && discovers < d.threshold:
+		f := seg.finding(key.iface)
+		seg.since = time.Time{}
+
+		return f, EventPortAuthenticationCleared
+	}
+
+	return PortAuthFinding{}, 0
+}
+
+// Findings returns the raised findings of iface, in the order of the VLANs
+func (d *PortAuthDetector) Findings(iface string) []PortAuthFinding {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var findings []PortAuthFinding
+
+	for key, seg := range d.segments {
+		if key.iface == iface && !seg.since.IsZero() {
+			findings = append(findings, seg.finding(iface))
+		}
+	}
+
+	slices.SortFunc(findings, func(a, b PortAuthFinding) int {
+		return cmp.Compare(vidOrder(a.VID), vidOrder(b.VID))
+	})
+
+	return findings
+}
+
+// segment returns the state of key, forgetting the least recently active
+// segments without a finding when there is no room for a new one
+func (d *PortAuthDetector) segment(key portAuthKey, vid *uint16) *portAuthSegment {
+	if seg, ok := d.segments[key]; ok {
+		return seg
+	}
+
+	if len(d.segments) >= maxPortAuthSegments {
+		idle := make(map[portAuthKey]*portAuthSegment, len(d.segments))
+
+		for k, seg := range d.segments {
+			if seg.since.IsZero() {
+				idle[k] = seg
+			}
+		}
+
+		evictOldest(idle, func(seg *portAuthSegment) int64 {
+			return seg.last.UnixNano()
+		})
+
+		for k, seg := range d.segments {
+			if _, ok := idle[k]; !ok && seg.since.IsZero() {
+				delete(d.segments, k)
+			}
+		}
+	}
+
+	seg := &portAuthSegment{clients: make(map[[6]byte]unansweredClient)}
+
+	if vid != nil {
+		v := *vid
+		seg.vid = &v
+	}
+
+	d.segments[key] = seg
+
+	return seg
+}
+
+// expire forgets the observations older than the window, but those of a
+// raised finding which only OFFERs clear
+func (seg *portAuthSegment) expire(now time.Time, window time.Duration) {
+	if !seg.since.IsZero() {
+		return
+	}
+
+	for mac, c := range seg.clients {
+		if now.Sub(c.last) > window {
+			delete(seg.clients, mac)
+		}
+	}
+}
+
+func (seg *portAuthSegment) discovers() int {
+	var n int
+
+	for _, c := range seg.clients {
+		n += c.discovers
+	}
+
+	return n
+}
+
+func (seg *portAuthSegment) finding(iface string) PortAuthFinding {
+	var vid *uint16
+
+	if seg.vid != nil {
+		v := *seg.vid
+		vid = &v
+	}
+
+	return PortAuthFinding{
+		VID:                 vid,
+		Interface:           iface,
+		Authenticator:       seg.authenticator.String(),
+		UnansweredDiscovers: seg.discovers(),
+		Clients:             len(seg.clients),
+		Since:               seg.since.Unix(),
+		LastSeen:            seg.last.Unix(),
+	}
+}
+
+func vidOrder(vid *uint16) int {
+	if vid == nil {
+		return -1
+	}
+
+	return int(*vid)
+}
+
+// dhcpMessage is what the PortAuthDetector needs of a DHCPv4 message,
+// msgType is 0 for the frames which aren't one
+type dhcpMessage struct {
+	chaddr  [6]byte
+	msgType uint8
+}
+
+// parseDHCP returns the client and the type of the DHCPv4 message of frame,
+// tagged or not, if there is one. The options decide whether the checksums
+// of the IPv4 header and of the UDP datagram are verified. The options of
+// the message are read up to its type, only a strict parse reads them all
+// and rejects a message whose options run past its end, which has more
+// than one type or a type DHCP doesn't define. The options past the limits
+// of p, see ethernet.DecodeLimits, reject the message.
+func parseDHCP(frame []byte, p ethernet.ParserOptions) dhcpMessage {
+	var (
+		eth ethernet.EthernetFrame
+		msg dhcpMessage
+	)
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return msg
+	}
+
+	ip, err := eth.IPv4View()
+	if err != nil || ip.Protocol() != checksum.ProtocolUDP || ip.FragmentOffset() != 0 || ip.MoreFragments() {
+		return msg
+	}
+
+	udp := ip.Payload()
+	if len(udp) < 8 {
+		return msg
+	}
+
+	if p.VerifyChecksums() && (!ip.ChecksumValid() || checksum.Verify(ip[:ip.HeaderLen()], udp) != nil) {
+		return msg
+	}
+
+	src, dst := binary.BigEndian.Uint16(udp[0:2]), binary.BigEndian.Uint16(udp[2:4])
+	if (src != dhcpClientPort || dst != dhcpServerPort) && (src != dhcpServerPort || dst != dhcpClientPort) {
+		return msg
+	}
+
+	bootp := udp[8:]
+	if len(bootp) < bootpLen+len(dhcpMagicCookie) || bootp[1] != byte(ethernet.HardwareTypeEthernet) ||
+		bootp[2] != 6 || !bytes.Equal(bootp[bootpLen:bootpLen+4], dhcpMagicCookie) {
+		return msg
+	}
+
+	readAll := !p.SkipUnknownOptions()
+	budget := p.Budget()
+	limit := p.DecodeLimits().Options
+
+	for n, opts := 0, bootp[bootpLen+4:]; len(opts) > 0; n++ {
+		if n == limit || budget.Spend("DHCP", nil) != nil {
+			return dhcpMessage{}
+		}
+
+		code := opts[0]
+
+		// the pad option has no length
+		if code == 0 {
+			opts = opts[1:]
+			continue
+		}
+
+		if code == dhcpOptionEnd {
+			break
+		}
+
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			if !p.KeepTruncatedOptions() {
+				return dhcpMessage{}
+			}
+
+			break
+		}
+
+		if code == dhcpOptionType && opts[1] == 1 {
+			if readAll && (msg.msgType != 0 || opts[2] == 0 || opts[2] > dhcpMaxType) {
+				return dhcpMessage{}
+			}
+
+			copy(msg.chaddr[:], bootp[28:34])
+			msg.msgType = opts[2]
+
+			if !readAll {
+				break
+			}
+		}
+
+		opts = opts[2+int(opts[1]):]
+	}
+
+	return msg
+}
+
+// portAuthFilter prepends to base the instructions accepting the EAPOL
+// frames and the DHCPv4 messages, tagged or not, the other frames are left
+// to base. The DHCP messages are recognised by their UDP ports, behind an
+// IPv4 header without options as their clients send.
+func portAuthFilter(base []bpf.RawInstruction) ([]bpf.RawInstruction, error) {
+	const (
+		versionOff  = 14
+		fragmentOff = 14 + 6
+		protocolOff = 14 + 9
+		dstPortOff  = 14 + 20 + 2
+	)
+
+	prefix, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.LoadConstant{Dst: bpf.RegX, Val: 0},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeVLAN), SkipFalse: 2},
+		// the headers follow the tag
+		bpf.LoadAbsolute{Off: 16, Size: 2},
+		bpf.LoadConstant{Dst: bpf.RegX, Val: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: eapol.EthernetType, SkipTrue: 10},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeIPv4), SkipFalse: 10},
+		bpf.LoadIndirect{Off: versionOff, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x45, SkipFalse: 8},
+		bpf.LoadIndirect{Off: protocolOff, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: checksum.ProtocolUDP, SkipFalse: 6},
+		bpf.LoadIndirect{Off: fragmentOff, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: 4},
+		bpf.LoadIndirect{Off: dstPortOff, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: dhcpServerPort, SkipTrue: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: dhcpClientPort, SkipFalse: 1},
+		bpf.RetConstant{Val: portAuthSnapLen},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(prefix, base...), nil
+}
@@ -0,0 +1,433 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/eapol"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var (
+	testAuthenticator = net.HardwareAddr{0x00, 0x1b, 0x21, 0xaa, 0xbb, 0xcc}
+	testDHCPServer    = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	testPXEClient     = net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	testOtherClient   = net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+)
+
+func buildFrame(tb testing.TB, b *ethernet.FrameBuilder, vid *uint16) []byte {
+	tb.Helper()
+
+	if vid != nil {
+		b = b.VLAN(*vid)
+	}
+
+	frame, err := b.Build()
+	require.NoError(tb, err)
+
+	return frame
+}
+
+// requestIdentityFrame is an EAP Request-Identity of the authenticator
+func requestIdentityFrame(tb testing.TB, vid *uint16) []byte {
+	tb.Helper()
+
+	return buildFrame(tb, ethernet.NewFrame().Src(testAuthenticator).Dst(eapol.PAEGroupAddress).Padded().
+		Payload(eapol.EthernetType, []byte{0x02, 0x00, 0x00, 0x05, 0x01, 0x01, 0x00, 0x05, 0x01}), vid)
+}
+
+func discoverFrame(tb testing.TB, client net.HardwareAddr, vid *uint16) []byte {
+	tb.Helper()
+
+	return buildFrame(tb, ethernet.NewFrame().Src(client).DHCPDiscover(1), vid)
+}
+
+// offerFrame is an OFFER of the server broadcast to client
+func offerFrame(tb testing.TB, client net.HardwareAddr, vid *uint16) []byte {
+	tb.Helper()
+
+	msg := make([]byte, bootpLen, bootpLen+8)
+	msg[0] = 2 // BOOTREPLY
+	msg[1] = byte(ethernet.HardwareTypeEthernet)
+	msg[2] = 6
+	binary.BigEndian.PutUint32(msg[4:8], 1)
+	copy(msg[28:], client)
+	msg = append(msg, 99, 130, 83, 99, dhcpOptionType, 1, dhcpOffer, 0xff)
+
+	return buildFrame(tb, ethernet.NewFrame().Src(testDHCPServer).
+		UDP(netip.MustParseAddrPort("10.0.0.1:67"), netip.MustParseAddrPort("255.255.255.255:68"), msg), vid)
+}
+
+// dhcpFrame is a BOOTREPLY of the server to client carrying options
+func dhcpFrame(tb testing.TB, client net.HardwareAddr, options ...byte) []byte {
+	tb.Helper()
+
+	msg := make([]byte, bootpLen, bootpLen+4+len(options))
+	msg[0] = 2 // BOOTREPLY
+	msg[1] = byte(ethernet.HardwareTypeEthernet)
+	msg[2] = 6
+	copy(msg[28:], client)
+	msg = append(append(msg, dhcpMagicCookie...), options...)
+
+	return buildFrame(tb, ethernet.NewFrame().Src(testDHCPServer).
+		UDP(netip.MustParseAddrPort("10.0.0.1:67"), netip.MustParseAddrPort("255.255.255.255:68"), msg), nil)
+}
+
+func TestParseDHCPStrictness(t *testing.T) {
+	t.Parallel()
+
+	corrupted := offerFrame(t, testPXEClient, nil)
+	// the transaction ID, covered by the UDP checksum only
+	corrupted[46]++
+
+	testcases := map[string]struct {
+		in []byte
+		// msgType is the type parsed at each Strictness, in the order of
+		// strictnesses
+		msgType [3]uint8
+	}{
+		"offer": {
+			in:      offerFrame(t, testPXEClient, nil),
+			msgType: [3]uint8{dhcpOffer, dhcpOffer, dhcpOffer},
+		},
+		"bad checksum": {
+			in:      corrupted,
+			msgType: [3]uint8{dhcpOffer, dhcpOffer, 0},
+		},
+		"option running past the end": {
+			in:      dhcpFrame(t, testPXEClient, dhcpOptionType, 1, dhcpOffer, 12, 10, 'a'),
+			msgType: [3]uint8{dhcpOffer, dhcpOffer, 0},
+		},
+		"two types": {
+			in:      dhcpFrame(t, testPXEClient, dhcpOptionType, 1, dhcpOffer, dhcpOptionType, 1, dhcpDiscover, 0xff),
+			msgType: [3]uint8{dhcpOffer, dhcpOffer, 0},
+		},
+		"undefined type": {
+			in:      dhcpFrame(t, testPXEClient, dhcpOptionType, 1, 200, 0xff),
+			msgType: [3]uint8{200, 200, 0},
+		},
+	}
+
+	strictnesses := []ethernet.Strictness{
+		ethernet.StrictnessLenient, ethernet.StrictnessStandard, ethernet.StrictnessStrict,
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for i, s := range strictnesses {
+				msg := parseDHCP(tc.in, ethernet.ParserOptions{Strictness: s})
+				assert.Equal(t, tc.msgType[i], msg.msgType, s)
+			}
+		})
+	}
+}
+
+func TestParseDHCPLimits(t *testing.T) {
+	t.Parallel()
+
+	// a message of pad options, each one byte of a jumbo frame, in front of
+	// its type
+	pads := append(bytes.Repeat([]byte{0}, 8000), dhcpOptionType, 1, dhcpOffer, 0xff)
+	frame := dhcpFrame(t, testPXEClient, pads...)
+
+	assert.Zero(t, parseDHCP(frame, ethernet.ParserOptions{}).msgType)
+
+	msg := parseDHCP(frame, ethernet.ParserOptions{Limits: ethernet.DecodeLimits{Options: 9000, Frame: 4000}})
+	assert.Zero(t, msg.msgType, "the budget of the frame is spent")
+
+	msg = parseDHCP(frame, ethernet.ParserOptions{Limits: ethernet.DecodeLimits{Options: 9000, Frame: 9000}})
+	assert.Equal(t, uint8(dhcpOffer), msg.msgType)
+}
+
+func TestPortAuthDetector(t *testing.T) {
+	t.Parallel()
+
+	vid10, vid20 := uint16(10), uint16(20)
+
+	type step struct {
+		frame func(testing.TB) []byte
+		vid   *uint16
+		at    time.Duration
+		event Event
+	}
+
+	identity := func(vid *uint16, at time.Duration) step {
+		return step{frame: func(tb testing.TB) []byte { return requestIdentityFrame(tb, vid) }, vid: vid, at: at}
+	}
+	discover := func(client net.HardwareAddr, vid *uint16, at time.Duration) step {
+		return step{frame: func(tb testing.TB) []byte { return discoverFrame(tb, client, vid) }, vid: vid, at: at}
+	}
+	offer := func(client net.HardwareAddr, at time.Duration) step {
+		return step{frame: func(tb testing.TB) []byte { return offerFrame(tb, client, nil) }, at: at}
+	}
+	expect := func(s step, event Event) step {
+		s.event = event
+		return s
+	}
+
+	testcases := map[string][]step{
+		"identity then discovers": {
+			identity(nil, 0),
+			discover(testPXEClient, nil, time.Second),
+			discover(testPXEClient, nil, 5*time.Second),
+			expect(discover(testPXEClient, nil, 15*time.Second), EventPortAuthenticationSuspected),
+			// raised once
+			discover(testPXEClient, nil, 30*time.Second),
+		},
+		"discovers then identity": {
+			discover(testPXEClient, nil, 0),
+			discover(testOtherClient, nil, time.Second),
+			discover(testPXEClient, nil, 5*time.Second),
+			expect(identity(nil, 30*time.Second), EventPortAuthenticationSuspected),
+		},
+		"answered": {
+			identity(nil, 0),
+			discover(testPXEClient, nil, time.Second),
+			offer(testPXEClient, 2*time.Second),
+			discover(testPXEClient, nil, 3*time.Second),
+			discover(testPXEClient, nil, 4*time.Second),
+		},
+		"cleared": {
+			identity(nil, 0),
+			discover(testPXEClient, nil, time.Second),
+			discover(testOtherClient, nil, 2*time.Second),
+			expect(discover(testPXEClient, nil, 5*time.Second), EventPortAuthenticationSuspected),
+			// the other client is still unanswered but below the threshold
+			expect(offer(testPXEClient, 10*time.Minute), EventPortAuthenticationCleared),
+			offer(testOtherClient, 11*time.Minute),
+		},
+		"identity out of the window": {
+			identity(nil, 0),
+			discover(testPXEClient, nil, 3*time.Minute),
+			discover(testPXEClient, nil, 3*time.Minute+5*time.Second),
+			discover(testPXEClient, nil, 3*time.Minute+15*time.Second),
+		},
+		"discovers expired": {
+			discover(testPXEClient, nil, 0),
+			discover(testPXEClient, nil, 5*time.Second),
+			identity(nil, 3*time.Minute),
+			discover(testPXEClient, nil, 3*time.Minute),
+		},
+		"other VLAN": {
+			identity(&vid10, 0),
+			discover(testPXEClient, &vid20, time.Second),
+			discover(testPXEClient, &vid20, 5*time.Second),
+			discover(testPXEClient, &vid20, 15*time.Second),
+		},
+		"tagged": {
+			identity(&vid10, 0),
+			discover(testPXEClient, &vid10, time.Second),
+			discover(testPXEClient, &vid10, 5*time.Second),
+			expect(discover(testPXEClient, &vid10, 15*time.Second), EventPortAuthenticationSuspected),
+		},
+		"not EAPOL nor DHCP": {
+			identity(nil, 0),
+			{frame: func(tb testing.TB) []byte {
+				return buildFrame(tb, ethernet.NewFrame().Src(testPXEClient).Padded().
+					ARPRequest(netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")), nil)
+			}},
+			{frame: func(tb testing.TB) []byte {
+				return buildFrame(tb, ethernet.NewFrame().Src(testPXEClient).
+					UDP(netip.MustParseAddrPort("0.0.0.0:68"), netip.MustParseAddrPort("255.255.255.255:67"),
+						make([]byte, 300)), nil)
+			}},
+		},
+	}
+
+	start := time.Unix(1700000000, 0)
+
+	for name, steps := range testcases {
+		steps := steps
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d := NewPortAuthDetector()
+
+			for i, s := range steps {
+				_, event := d.Observe(s.frame(t), "eth0", s.vid, start.Add(s.at))
+				assert.Equal(t, s.event, event, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestPortAuthDetectorFindings(t *testing.T) {
+	t.Parallel()
+
+	d := NewPortAuthDetector(WithPortAuthThreshold(2), WithPortAuthWindow(time.Minute))
+	start := time.Unix(1700000000, 0)
+	vid := uint16(10)
+
+	for _, v := range []*uint16{&vid, nil} {
+		d.Observe(requestIdentityFrame(t, v), "eth0", v, start)
+		d.Observe(discoverFrame(t, testPXEClient, v), "eth0", v, start.Add(time.Second))
+	}
+
+	finding, event := d.Observe(discoverFrame(t, testOtherClient, &vid), "eth0", &vid, start.Add(2*time.Second))
+	require.Equal(t, EventPortAuthenticationSuspected, event)
+
+	assert.Equal(t, PortAuthFinding{
+		VID:                 &vid,
+		Interface:           "eth0",
+		Authenticator:       testAuthenticator.String(),
+		UnansweredDiscovers: 2,
+		Clients:             2,
+		Since:               start.Add(2 * time.Second).Unix(),
+		LastSeen:            start.Add(2 * time.Second).Unix(),
+	}, finding)
+
+	_, event = d.Observe(discoverFrame(t, testPXEClient, nil), "eth0", nil, start.Add(3*time.Second))
+	require.Equal(t, EventPortAuthenticationSuspected, event)
+
+	findings := d.Findings("eth0")
+	require.Len(t, findings, 2)
+	assert.Nil(t, findings[0].VID)
+	assert.Equal(t, uint16(10), *findings[1].VID)
+	assert.Empty(t, d.Findings("eth1"))
+
+	// a raised finding outlives the window until OFFERs answer it
+	_, event = d.Observe(offerFrame(t, testPXEClient, nil), "eth0", nil, start.Add(time.Hour))
+	assert.Equal(t, EventPortAuthenticationCleared, event)
+	assert.Len(t, d.Findings("eth0"), 1)
+}
+
+func TestPortAuthFilter(t *testing.T) {
+	t.Parallel()
+
+	base, err := arpFilter()
+	require.NoError(t, err)
+
+	filter, err := portAuthFilter(base)
+	require.NoError(t, err)
+
+	vm, err := bpf.NewVM(disassemble(t, filter))
+	require.NoError(t, err)
+
+	vid := uint16(10)
+	fragment := discoverFrame(t, testPXEClient, nil)
+	fragment[14+7] = 1 // the second fragment, without the UDP header
+
+	testcases := map[string]struct {
+		in  []byte
+		len int
+	}{
+		"EAPOL": {
+			in:  requestIdentityFrame(t, nil),
+			len: portAuthSnapLen,
+		},
+		"802.1Q EAPOL": {
+			in:  requestIdentityFrame(t, &vid),
+			len: portAuthSnapLen,
+		},
+		"DISCOVER": {
+			in:  discoverFrame(t, testPXEClient, nil),
+			len: portAuthSnapLen,
+		},
+		"802.1Q DISCOVER": {
+			in:  discoverFrame(t, testPXEClient, &vid),
+			len: portAuthSnapLen,
+		},
+		"OFFER": {
+			in:  offerFrame(t, testPXEClient, nil),
+			len: portAuthSnapLen,
+		},
+		"ARP": {
+			in:  []byte{12: 0x08, 13: 0x06, 41: 0},
+			len: snapLen,
+		},
+		"802.1Q ARP": {
+			in:  []byte{12: 0x81, 13: 0x00, 16: 0x08, 17: 0x06, 45: 0},
+			len: snapLen,
+		},
+		"other UDP": {
+			in: buildFrame(t, ethernet.NewFrame().Src(testPXEClient).
+				UDP(netip.MustParseAddrPort("10.0.0.2:4789"), netip.MustParseAddrPort("10.0.0.1:4789"), nil), nil),
+		},
+		"fragment": {
+			in: fragment,
+		},
+		"IPv6": {
+			in: []byte{12: 0x86, 13: 0xdd, 61: 0},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			n, err := vm.Run(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.len, n)
+		})
+	}
+}
+
+func TestServicePortAuth(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0", WithDecoders(DefaultDecoders|DecoderEAPOL),
+		WithPortAuthDetector(NewPortAuthDetector(WithPortAuthThreshold(1))))
+	vid := uint16(10)
+
+	res, err := svc.handleFrame(requestIdentityFrame(t, &vid), capture.Metadata{})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	res, err = svc.handleFrame(discoverFrame(t, testPXEClient, &vid), capture.Metadata{})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventPortAuthenticationSuspected, res[0].Event)
+	assert.Equal(t, testAuthenticator.String(), res[0].MAC)
+	assert.Equal(t, vid, *res[0].VID)
+	require.NotNil(t, res[0].PortAuth)
+	assert.Equal(t, 1, res[0].PortAuth.UnansweredDiscovers)
+
+	snap := svc.Snapshot()
+	require.Len(t, snap.PortAuth, 1)
+	assert.Equal(t, *res[0].PortAuth, snap.PortAuth[0])
+	assert.Equal(t, snap.PortAuth, snap.Diff(Snapshot{}).PortAuth)
+
+	// the OFFERs of a DHCP server on the host are observed too
+	res, err = svc.handleFrame(offerFrame(t, testPXEClient, &vid), capture.Metadata{Direction: capture.DirectionOutbound})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventPortAuthenticationCleared, res[0].Event)
+	assert.Empty(t, svc.Snapshot().PortAuth)
+
+	// without a detector the frames are skipped
+	res, err = NewService("eth0").handleFrame(discoverFrame(t, testPXEClient, nil), capture.Metadata{})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
@@ -0,0 +1,477 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/netif"
+)
+
+const (
+	// defaultPrimeWindow is how long a primed neighbor is kept unobserved,
+	// the time a machine takes to PXE boot
+	defaultPrimeWindow = 10 * time.Minute
+	// defaultPrimeSweep is how often the primed neighbors are checked
+	defaultPrimeSweep = 10 * time.Second
+)
+
+var (
+	// ErrPrimeDisabled is returned by Service.PrimeNeighbor for a Service
+	// without a Primer
+	ErrPrimeDisabled = errors.New("neighbor priming not enabled")
+	// ErrPrimeConflict is returned when priming a binding the capture
+	// observed with another MAC
+	ErrPrimeConflict = errors.New("conflicting binding")
+	// ErrPrimeUnconfirmed is returned when the host didn't answer the
+	// probe confirming a primed neighbor, which was reverted
+	ErrPrimeUnconfirmed = errors.New("primed neighbor not confirmed")
+)
+
+// NeighborWriter changes the kernel neighbor cache
+type NeighborWriter interface {
+	SetNeighbor(n netif.Neighbor) error
+	DeleteNeighbor(n netif.Neighbor) error
+}
+
+// kernelNeighbors writes the kernel neighbor cache over rtnetlink
+type kernelNeighbors struct{}
+
+func (kernelNeighbors) SetNeighbor(n netif.Neighbor) error {
+	return netif.SetNeighbor(n)
+}
+
+func (kernelNeighbors) DeleteNeighbor(n netif.Neighbor) error {
+	return netif.DeleteNeighbor(n)
+}
+
+// primedNeighbor is a neighbor a Primer installed in the kernel neighbor
+// cache, until expires
+type primedNeighbor struct {
+	expires  time.Time
+	svc      *Service
+	neighbor netif.Neighbor
+}
+
+// Primer installs the bindings of the machines about to boot into the
+// kernel neighbor cache, so their first TFTP and HTTP packets aren't held
+// by address resolution. A primed binding is recorded in the table of the
+// Service as a BindingSourcePrimed one, which weighs nothing: the capture
+// observing the host with the same MAC makes it an observed binding, kept
+// in the kernel, and with another MAC replaces it at once, the kernel entry
+// being removed. The entries never observed are removed once the window of
+// the deployment is over.
+type Primer struct {
+	links     LinkSource
+	neighbors NeighborWriter
+	clock     clock.Clock
+	// primed are the neighbors installed and not observed yet
+	primed map[hostCheckKey]primedNeighbor
+	// wake tells Run a primed binding was replaced
+	wake chan struct{}
+	// capabilities are those the Primer runs without, CapabilityNetAdmin
+	// once it was denied
+	capabilities Capabilities
+	window       time.Duration
+	sweep        time.Duration
+	confirm      bool
+	mu           sync.Mutex
+}
+
+// PrimerOption configures a Primer
+type PrimerOption func(*Primer)
+
+// WithPrimeWindow sets how long a primed neighbor is kept without being
+// observed
+func WithPrimeWindow(d time.Duration) PrimerOption {
+	return func(p *Primer) {
+		if d > 0 {
+			p.window = d
+		}
+	}
+}
+
+// WithPrimeSweep sets how often Run checks the primed neighbors
+func WithPrimeSweep(d time.Duration) PrimerOption {
+	return func(p *Primer) {
+		if d > 0 {
+			p.sweep = d
+		}
+	}
+}
+
+// WithPrimeConfirm probes each primed neighbor with Service.CheckHost, the
+// kernel entry is reverted if the host doesn't answer with its MAC
+func WithPrimeConfirm(confirm bool) PrimerOption {
+	return func(p *Primer) {
+		p.confirm = confirm
+	}
+}
+
+// WithPrimeNeighbors replaces the kernel neighbor cache the neighbors are
+// installed into
+func WithPrimeNeighbors(w NeighborWriter) PrimerOption {
+	return func(p *Primer) {
+		p.neighbors = w
+	}
+}
+
+// WithPrimerClock sets the clock of the windows and pacing Run
+func WithPrimerClock(c clock.Clock) PrimerOption {
+	return func(p *Primer) {
+		p.clock = c
+	}
+}
+
+// NewPrimer returns a Primer, links maps the VLANs of the Services onto
+// their sub-interfaces
+func NewPrimer(links LinkSource, options ...PrimerOption) *Primer {
+	p := &Primer{
+		links:     links,
+		neighbors: kernelNeighbors{},
+		clock:     clock.System{},
+		primed:    make(map[hostCheckKey]primedNeighbor),
+		wake:      make(chan struct{}, 1),
+		window:    defaultPrimeWindow,
+		sweep:     defaultPrimeSweep,
+	}
+
+	for _, opt := range options {
+		opt(p)
+	}
+
+	return p
+}
+
+// Capabilities returns the capabilities the Primer runs without
+func (p *Primer) Capabilities() Capabilities {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.capabilities
+}
+
+// degrade disables the Primer since it lacks CapabilityNetAdmin, err is why
+func (p *Primer) degrade(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.capabilities.Lacks(CapabilityNetAdmin) {
+		return
+	}
+
+	d := Capabilities{Degraded: []DegradedCapability{{
+		Capability: CapabilityNetAdmin,
+		Error:      err.Error(),
+		Disabled:   []string{FeaturePriming},
+		Running:    []string{FeatureCapture, FeatureProbing},
+	}}}
+	d.Log()
+
+	p.capabilities.Degraded = append(p.capabilities.Degraded, d.Degraded...)
+}
+
+// index returns the index of the link of vid on iface, iface itself for
+// the untagged frames and its VLAN sub-interface otherwise
+func (p *Primer) index(iface string, vid *uint16) (int, error) {
+	links := p.links.Links()
+	parent := -1
+
+	for _, l := range links {
+		if l.Name == iface {
+			if vid == nil {
+				return l.Index, nil
+			}
+
+			parent = l.Index
+		}
+	}
+
+	for _, l := range links {
+		if l.VLAN() && l.ParentIndex == parent && vid != nil && l.VID == *vid {
+			return l.Index, nil
+		}
+	}
+
+	if vid != nil {
+		return 0, fmt.Errorf("%w: no sub-interface of %s for VLAN %d", netif.ErrLinkNotFound, iface, *vid)
+	}
+
+	return 0, fmt.Errorf("%w: %s", netif.ErrLinkNotFound, iface)
+}
+
+// prime installs the binding of ip to mac on vid of the interface of s, see
+// Service.PrimeNeighbor
+func (p *Primer) prime(ctx context.Context, s *Service, vid *uint16, ip netip.Addr, mac net.HardwareAddr) error {
+	ip, err := addrutil.UnicastIP("ip", ip)
+	if err != nil {
+		return err
+	}
+
+	if mac, err = addrutil.UnicastMAC("mac", mac); err != nil {
+		return err
+	}
+
+	if err := p.Capabilities().Err(CapabilityNetAdmin); err != nil {
+		return err
+	}
+
+	key := hostCheckKey{iface: s.iface, bindingKey: mappingKey(ip, vid)}
+
+	// what the capture observed wins over what the host is expected to be
+	if b, ok := s.table.lookup(key.bindingKey); ok && b.Source != BindingSourceKernel && b.Source != BindingSourcePrimed {
+		if !bytes.Equal(b.MAC, mac) {
+			return fmt.Errorf("%w: %s is bound to %s", ErrPrimeConflict, ip, b.MAC)
+		}
+
+		return nil
+	}
+
+	index, err := p.index(s.iface, vid)
+	if err != nil {
+		return err
+	}
+
+	n := netif.Neighbor{IP: ip, HardwareAddr: mac, Index: index, State: netif.NeighReachable}
+
+	if err := p.neighbors.SetNeighbor(n); err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			p.degrade(err)
+
+			return fmt.Errorf("%w: %w", p.Capabilities().Err(CapabilityNetAdmin), err)
+		}
+
+		return err
+	}
+
+	if b, bound := s.bindPrimed(key.bindingKey, vid, ip, mac); !bound {
+		// the capture observed the host meanwhile
+		if !bytes.Equal(b.MAC, mac) {
+			p.deleteNeighbor(n)
+
+			return fmt.Errorf("%w: %s is bound to %s", ErrPrimeConflict, ip, b.MAC)
+		}
+
+		return nil
+	}
+
+	p.mu.Lock()
+	p.primed[key] = primedNeighbor{expires: p.clock.Now().Add(p.window), svc: s, neighbor: n}
+	p.mu.Unlock()
+
+	if !p.confirm {
+		return nil
+	}
+
+	check, err := s.CheckHost(ctx, vid, ip, mac)
+	if err == nil && check.Matched {
+		return nil
+	}
+
+	p.revert(key)
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPrimeUnconfirmed, err)
+	}
+
+	return fmt.Errorf("%w: %s didn't answer with %s", ErrPrimeUnconfirmed, ip, mac)
+}
+
+// deleteNeighbor removes n from the kernel neighbor cache, an entry the
+// kernel already forgot is fine
+func (p *Primer) deleteNeighbor(n netif.Neighbor) {
+	if err := p.neighbors.DeleteNeighbor(n); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Err(err).Str("ip", n.IP.String()).Msg("Failed to remove a primed neighbor")
+	}
+}
+
+// revert removes the primed neighbor of key, from the kernel and from the
+// table unless it was observed
+func (p *Primer) revert(key hostCheckKey) {
+	p.mu.Lock()
+	e, ok := p.primed[key]
+	delete(p.primed, key)
+	p.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	p.deleteNeighbor(e.neighbor)
+	e.svc.unbindPrimed(key.bindingKey)
+}
+
+// replaced tells Run a primed binding was replaced by an observation of
+// another MAC, it never blocks
+func (p *Primer) replaced() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Sweep checks the primed neighbors: those the capture observed are left
+// to the kernel, and those replaced by another MAC or whose window is over
+// are removed
+func (p *Primer) Sweep() {
+	now := p.clock.Now()
+
+	p.mu.Lock()
+	primed := make(map[hostCheckKey]primedNeighbor, len(p.primed))
+
+	for key, e := range p.primed {
+		primed[key] = e
+	}
+	p.mu.Unlock()
+
+	for key, e := range primed {
+		b, ok := e.svc.table.lookup(key.bindingKey)
+		matches := ok && bytes.Equal(b.MAC, e.neighbor.HardwareAddr)
+
+		switch {
+		case matches && b.Source != BindingSourcePrimed:
+			// confirmed, the kernel keeps it up to date from now on
+			p.mu.Lock()
+			delete(p.primed, key)
+			p.mu.Unlock()
+		case !matches:
+			log.Info().Str("interface", key.iface).Str("ip", e.neighbor.IP.String()).
+				Str("mac", e.neighbor.HardwareAddr.String()).Msg("Removing a primed neighbor replaced by the capture")
+			p.revert(key)
+		case !now.Before(e.expires):
+			p.revert(key)
+		}
+	}
+}
+
+// Primed returns the number of primed neighbors not observed yet
+func (p *Primer) Primed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.primed)
+}
+
+// Run sweeps the primed neighbors at every sweep interval and as soon as
+// one is replaced, until ctx is done. The neighbors still primed are
+// removed then.
+func (p *Primer) Run(ctx context.Context) error {
+	ticker := p.clock.NewTicker(p.sweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.mu.Lock()
+			keys := make([]hostCheckKey, 0, len(p.primed))
+
+			for key := range p.primed {
+				keys = append(keys, key)
+			}
+			p.mu.Unlock()
+
+			for _, key := range keys {
+				p.revert(key)
+			}
+
+			return nil
+		case <-ticker.C():
+		case <-p.wake:
+		}
+
+		p.Sweep()
+	}
+}
+
+// WithPrimer answers PrimeNeighbor with p, which is told when the capture
+// replaces a primed binding
+func WithPrimer(p *Primer) ServiceOption {
+	return func(s *Service) {
+		s.primer = p
+	}
+}
+
+// PrimeNeighbor installs the binding of ip to mac on vid, nil for the
+// untagged frames, into the kernel neighbor cache as a reachable entry,
+// ahead of the host booting, see Primer. The binding is recorded as a
+// BindingSourcePrimed one, and confirmed with a probe when the Primer was
+// created WithPrimeConfirm.
+//
+// It returns ErrPrimeDisabled without a Primer, ErrPrimeConflict when the
+// capture observed ip with another MAC, ErrPrimeUnconfirmed when the probe
+// went unanswered, and an error matching ErrMissingCapability when the
+// process can't change the kernel neighbor cache.
+func (s *Service) PrimeNeighbor(ctx context.Context, vid *uint16, ip netip.Addr, mac net.HardwareAddr) error {
+	if s.primer == nil {
+		return ErrPrimeDisabled
+	}
+
+	return s.primer.prime(ctx, s, vid, ip, mac)
+}
+
+// bindPrimed binds ip to mac as a BindingSourcePrimed binding unless the
+// capture or another source observed it, which it returns
+func (s *Service) bindPrimed(key bindingKey, vid *uint16, ip netip.Addr, mac net.HardwareAddr) (Binding, bool) {
+	sh := s.table.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if b, ok := sh.bindings[key]; ok && b.Source != BindingSourceKernel && b.Source != BindingSourcePrimed {
+		return b, false
+	}
+
+	if vid != nil {
+		v := *vid
+		vid = &v
+	}
+
+	b := Binding{
+		VID:    vid,
+		Time:   s.clock.Now(),
+		IP:     ip,
+		MAC:    mac,
+		Source: BindingSourcePrimed,
+		Kind:   ObservationKernel,
+	}
+	s.table.bind(sh, key, b)
+
+	return b, true
+}
+
+// unbindPrimed removes the binding of key while it is still primed
+func (s *Service) unbindPrimed(key bindingKey) {
+	sh := s.table.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if b, ok := sh.bindings[key]; ok && b.Source == BindingSourcePrimed {
+		delete(sh.bindings, key)
+	}
+}
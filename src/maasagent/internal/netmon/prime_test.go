@@ -0,0 +1,325 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/netif"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+// fakeNeighbors is a kernel neighbor cache
+type fakeNeighbors struct {
+	entries map[netip.Addr]netif.Neighbor
+	err     error
+	sets    int
+	mu      sync.Mutex
+}
+
+func (f *fakeNeighbors) SetNeighbor(n netif.Neighbor) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sets++
+
+	if f.err != nil {
+		return f.err
+	}
+
+	if f.entries == nil {
+		f.entries = make(map[netip.Addr]netif.Neighbor)
+	}
+
+	f.entries[n.IP] = n
+
+	return nil
+}
+
+func (f *fakeNeighbors) DeleteNeighbor(n netif.Neighbor) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.entries, n.IP)
+
+	return nil
+}
+
+func (f *fakeNeighbors) lookup(ip netip.Addr) (netif.Neighbor, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, ok := f.entries[ip]
+
+	return n, ok
+}
+
+var primeLinks = staticLinks{
+	{Name: "eth0", Index: 2},
+	{Name: "eth0.10", Kind: "vlan", Index: 5, ParentIndex: 2, VID: 10},
+	{Name: "eth1.10", Kind: "vlan", Index: 6, ParentIndex: 3, VID: 10},
+}
+
+func newTestPrimer(options ...PrimerOption) (*Primer, *Service, *fakeNeighbors, *clocktest.Fake) {
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	neighbors := &fakeNeighbors{}
+	p := NewPrimer(primeLinks, append([]PrimerOption{WithPrimerClock(clk), WithPrimeNeighbors(neighbors),
+		WithPrimeWindow(time.Minute)}, options...)...)
+
+	return p, NewService("eth0", WithClock(clk), WithPrimer(p)), neighbors, clk
+}
+
+func TestServicePrimeNeighbor(t *testing.T) {
+	t.Parallel()
+
+	p, svc, neighbors, _ := newTestPrimer()
+	ctx := context.Background()
+
+	require.NoError(t, svc.PrimeNeighbor(ctx, nil, netip.MustParseAddr("10.0.0.10"), testPXEClient))
+	require.NoError(t, svc.PrimeNeighbor(ctx, vid10(), netip.MustParseAddr("10.0.10.10"), testOtherClient))
+
+	// the entries are installed on the interface of their VLAN
+	n, ok := neighbors.lookup(netip.MustParseAddr("10.0.0.10"))
+	require.True(t, ok)
+	assert.Equal(t, netif.Neighbor{IP: netip.MustParseAddr("10.0.0.10"), HardwareAddr: testPXEClient, Index: 2,
+		State: netif.NeighReachable}, n)
+
+	n, ok = neighbors.lookup(netip.MustParseAddr("10.0.10.10"))
+	require.True(t, ok)
+	assert.Equal(t, 5, n.Index)
+
+	b, ok := svc.table.lookup(mappingKey(netip.MustParseAddr("10.0.10.10"), vid10()))
+	require.True(t, ok)
+	assert.Equal(t, BindingSourcePrimed, b.Source)
+	assert.Equal(t, 2, p.Primed())
+
+	// the Reconciler leaves them to the Primer
+	assert.True(t, svc.reconcile(nil).Empty())
+	assert.Equal(t, 2, svc.table.size())
+
+	// priming again replaces the entry
+	require.NoError(t, svc.PrimeNeighbor(ctx, nil, netip.MustParseAddr("10.0.0.10"), testOtherClient))
+
+	n, _ = neighbors.lookup(netip.MustParseAddr("10.0.0.10"))
+	assert.Equal(t, testOtherClient, n.HardwareAddr)
+	assert.Equal(t, 2, p.Primed())
+
+	assert.ErrorIs(t, svc.PrimeNeighbor(ctx, nil, netip.MustParseAddr("10.0.0.11"), nil), addrutil.ErrInvalidMAC)
+	assert.ErrorIs(t, svc.PrimeNeighbor(ctx, nil, netip.MustParseAddr("ff02::1"), testPXEClient),
+		addrutil.ErrInvalidIP)
+
+	vid20 := uint16(20)
+	assert.ErrorIs(t, svc.PrimeNeighbor(ctx, &vid20, netip.MustParseAddr("10.0.20.10"), testPXEClient),
+		netif.ErrLinkNotFound)
+	assert.ErrorIs(t, NewService("eth2", WithPrimer(p)).PrimeNeighbor(ctx, nil, netip.MustParseAddr("10.0.0.10"),
+		testPXEClient), netif.ErrLinkNotFound)
+
+	assert.ErrorIs(t, NewService("eth0").PrimeNeighbor(ctx, nil, netip.MustParseAddr("10.0.0.10"), testPXEClient),
+		ErrPrimeDisabled)
+}
+
+func TestServicePrimeNeighborObserved(t *testing.T) {
+	t.Parallel()
+
+	p, svc, neighbors, _ := newTestPrimer()
+	ctx := context.Background()
+
+	svc.Observe(ObservationARPReply, testGateway4, testGatewayMAC, nil, time.Time{})
+
+	// what the capture observed wins
+	err := svc.PrimeNeighbor(ctx, nil, testGateway4, testPXEClient)
+	assert.ErrorIs(t, err, ErrPrimeConflict)
+
+	require.NoError(t, svc.PrimeNeighbor(ctx, nil, testGateway4, testGatewayMAC))
+
+	_, ok := neighbors.lookup(testGateway4)
+	assert.False(t, ok)
+	assert.Zero(t, p.Primed())
+}
+
+func TestPrimerConfirmed(t *testing.T) {
+	t.Parallel()
+
+	p, svc, neighbors, _ := newTestPrimer()
+	ip := netip.MustParseAddr("10.0.0.10")
+
+	require.NoError(t, svc.PrimeNeighbor(context.Background(), nil, ip, testPXEClient))
+
+	// the host booting is new all the same
+	res := svc.Observe(ObservationARPRequest, ip, testPXEClient, nil, time.Time{})
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+
+	b, ok := svc.table.lookup(mappingKey(ip, nil))
+	require.True(t, ok)
+	assert.Equal(t, BindingSourceCapture, b.Source)
+
+	// the kernel keeps the entry from now on
+	p.Sweep()
+	assert.Zero(t, p.Primed())
+
+	_, ok = neighbors.lookup(ip)
+	assert.True(t, ok)
+}
+
+func TestPrimerConflict(t *testing.T) {
+	t.Parallel()
+
+	p, svc, neighbors, _ := newTestPrimer()
+	ip := netip.MustParseAddr("10.0.0.10")
+
+	require.NoError(t, svc.PrimeNeighbor(context.Background(), nil, ip, testPXEClient))
+
+	// another host answering for ip replaces the primed binding at once,
+	// whatever the weight of its observation
+	res := svc.Observe(ObservationMDNS, ip, testOtherClient, nil, time.Time{})
+	require.Len(t, res, 1)
+	assert.Equal(t, EventMoved, res[0].Event)
+	assert.Equal(t, testPXEClient.String(), res[0].PreviousMAC)
+
+	// and Run is woken up to remove the kernel entry
+	require.Len(t, p.wake, 1)
+
+	p.Sweep()
+	assert.Zero(t, p.Primed())
+
+	_, ok := neighbors.lookup(ip)
+	assert.False(t, ok)
+
+	b, ok := svc.table.lookup(mappingKey(ip, nil))
+	require.True(t, ok)
+	assert.Equal(t, testOtherClient, b.MAC)
+}
+
+func TestPrimerWindow(t *testing.T) {
+	t.Parallel()
+
+	p, svc, neighbors, clk := newTestPrimer()
+	ip := netip.MustParseAddr("10.0.0.10")
+
+	require.NoError(t, svc.PrimeNeighbor(context.Background(), nil, ip, testPXEClient))
+
+	clk.Advance(59 * time.Second)
+	p.Sweep()
+	assert.Equal(t, 1, p.Primed())
+
+	// never observed within the window, it is removed everywhere
+	clk.Advance(time.Second)
+	p.Sweep()
+	assert.Zero(t, p.Primed())
+
+	_, ok := neighbors.lookup(ip)
+	assert.False(t, ok)
+
+	_, ok = svc.table.lookup(mappingKey(ip, nil))
+	assert.False(t, ok)
+}
+
+func TestPrimerPermission(t *testing.T) {
+	t.Parallel()
+
+	p, svc, neighbors, _ := newTestPrimer()
+	neighbors.err = fmt.Errorf("failed to set neighbor: %w", syscall.EPERM)
+	ip := netip.MustParseAddr("10.0.0.10")
+
+	err := svc.PrimeNeighbor(context.Background(), nil, ip, testPXEClient)
+	assert.ErrorIs(t, err, ErrMissingCapability)
+	assert.ErrorIs(t, err, syscall.EPERM)
+	assert.True(t, p.Capabilities().Lacks(CapabilityNetAdmin))
+
+	// priming is disabled once, rather than failing each time
+	err = svc.PrimeNeighbor(context.Background(), nil, ip, testPXEClient)
+	assert.ErrorIs(t, err, ErrMissingCapability)
+	assert.Equal(t, 1, neighbors.sets)
+
+	_, ok := svc.table.lookup(mappingKey(ip, nil))
+	assert.False(t, ok)
+
+	// other failures are the caller's
+	p, svc, neighbors, _ = newTestPrimer()
+	neighbors.err = syscall.ENODEV
+
+	assert.ErrorIs(t, svc.PrimeNeighbor(context.Background(), nil, ip, testPXEClient), syscall.ENODEV)
+	assert.Empty(t, p.Capabilities().Degraded)
+}
+
+func TestPrimerUnconfirmed(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	neighbors := &fakeNeighbors{}
+	p := NewPrimer(primeLinks, WithPrimerClock(clk), WithPrimeNeighbors(neighbors), WithPrimeConfirm(true))
+	c, _ := newTestHostChecker()
+	svc := NewService("eth0", WithClock(clk), WithPrimer(p), WithHostChecker(c))
+	ip := netip.MustParseAddr("10.0.0.10")
+
+	// the primed binding doesn't answer the check, the host has to, and
+	// the capture isn't running
+	err := svc.PrimeNeighbor(context.Background(), nil, ip, testPXEClient)
+	assert.ErrorIs(t, err, ErrPrimeUnconfirmed)
+	assert.ErrorIs(t, err, ErrNotCapturing)
+
+	assert.Zero(t, p.Primed())
+
+	_, ok := neighbors.lookup(ip)
+	assert.False(t, ok)
+
+	_, ok = svc.table.lookup(mappingKey(ip, nil))
+	assert.False(t, ok)
+}
+
+func TestPrimerRun(t *testing.T) {
+	t.Parallel()
+
+	p, svc, neighbors, _ := newTestPrimer()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+
+	go func() {
+		done <- p.Run(ctx)
+	}()
+
+	replaced, kept := netip.MustParseAddr("10.0.0.10"), netip.MustParseAddr("10.0.0.11")
+
+	require.NoError(t, svc.PrimeNeighbor(ctx, nil, replaced, testPXEClient))
+	require.NoError(t, svc.PrimeNeighbor(ctx, nil, kept, testPXEClient))
+
+	svc.Observe(ObservationARPReply, replaced, testOtherClient, nil, time.Time{})
+
+	assert.Eventually(t, func() bool {
+		_, ok := neighbors.lookup(replaced)
+		return !ok
+	}, time.Second, time.Millisecond)
+
+	// the neighbors still primed don't outlive Run
+	cancel()
+	require.NoError(t, <-done)
+
+	_, ok := neighbors.lookup(kept)
+	assert.False(t, ok)
+	assert.Zero(t, p.Primed())
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// probeTokenLen is the length of the random token of a scan, which
+	// follows probeMagic in the payload of its ICMP echo requests
+	probeTokenLen = 8
+	// arpReplyWindow is how long after an ARP request a reply is attributed
+	// to it. ARP has no room for a token, a reply only matches the address
+	// and MAC the request was sent from, and a reply coming later is more
+	// likely to answer someone else.
+	arpReplyWindow = time.Second
+)
+
+// probeMagic starts the payload of the ICMP echo requests of the scans, so
+// that the replies to the scans of other agents on the segment are told
+// apart from those to other pings
+var probeMagic = []byte("maas-scan")
+
+// ProbeStats counts the replies to the probes of the scans
+type ProbeStats struct {
+	// Replies are the replies matching a probe of a scan still waiting for
+	// one
+	Replies uint64 `json:"replies"`
+	// Unsolicited are the replies to the probes of another scan, such as
+	// one of another agent on the segment, to a probe already answered or
+	// no longer waited for, or a reply from a probed address which doesn't
+	// match its probe
+	Unsolicited uint64 `json:"unsolicited"`
+}
+
+// probeCounter counts the replies of the scans, a nil probeCounter counts
+// nothing
+type probeCounter struct {
+	replies     atomic.Uint64
+	unsolicited atomic.Uint64
+}
+
+func (c *probeCounter) reply() {
+	if c != nil {
+		c.replies.Add(1)
+	}
+}
+
+func (c *probeCounter) unsolicitedReply() {
+	if c != nil {
+		c.unsolicited.Add(1)
+	}
+}
+
+func (c *probeCounter) stats() ProbeStats {
+	return ProbeStats{Replies: c.replies.Load(), Unsolicited: c.unsolicited.Load()}
+}
+
+// echoProbe identifies the ICMP echo requests of a scan: their identifier
+// and the token their payload carries are random for each scan, their
+// sequence number is that of the target
+type echoProbe struct {
+	payload []byte
+	id      uint16
+}
+
+func newEchoProbe() echoProbe {
+	var token [2 + probeTokenLen]byte
+
+	//nolint:errcheck,gosec // rand.Read() never returns an error
+	rand.Read(token[:])
+
+	return echoProbe{
+		id:      binary.BigEndian.Uint16(token[:2]),
+		payload: append(bytes.Clone(probeMagic), token[2:]...),
+	}
+}
+
+// match returns whether an echo reply with id, seq and payload answers the
+// probe with the sequence number seq of the scan, and whether it answers
+// the probe of any scan
+func (p echoProbe) match(id, seq, want uint16, payload []byte) (bool, bool) {
+	if !bytes.HasPrefix(payload, probeMagic) {
+		return false, false
+	}
+
+	return id == p.id && seq == want && bytes.Equal(payload, p.payload), true
+}
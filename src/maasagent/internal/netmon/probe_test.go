@@ -0,0 +1,191 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestEchoProbe(t *testing.T) {
+	t.Parallel()
+
+	probe := newEchoProbe()
+	other := newEchoProbe()
+
+	require.True(t, bytes.HasPrefix(probe.payload, probeMagic))
+	assert.Len(t, probe.payload, len(probeMagic)+probeTokenLen)
+	assert.NotEqual(t, probe.payload, other.payload)
+
+	testcases := map[string]struct {
+		id      uint16
+		seq     uint16
+		payload []byte
+		ours    bool
+		scan    bool
+	}{
+		"reply": {
+			id: probe.id, seq: 3, payload: probe.payload, ours: true, scan: true,
+		},
+		"other target": {
+			id: probe.id, seq: 4, payload: probe.payload, scan: true,
+		},
+		"other identifier": {
+			id: probe.id + 1, seq: 3, payload: probe.payload, scan: true,
+		},
+		"other scan": {
+			id: probe.id, seq: 3, payload: other.payload, scan: true,
+		},
+		"truncated": {
+			id: probe.id, seq: 3, payload: probe.payload[:len(probe.payload)-1], scan: true,
+		},
+		"other ping": {
+			id: probe.id, seq: 3, payload: []byte("abcdefghijklmnopqrstuvwxyz"),
+		},
+		"no payload": {
+			id: probe.id, seq: 3,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ours, scan := probe.match(tc.id, tc.seq, 3, tc.payload)
+			assert.Equal(t, tc.ours, ours)
+			assert.Equal(t, tc.scan, scan)
+		})
+	}
+}
+
+func TestGetEchoReply(t *testing.T) {
+	t.Parallel()
+
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0xbc, 0x34, 0x46}
+	payload := []byte("maas-scan01234567")
+
+	testcases := map[string]struct {
+		layers []gopacket.SerializableLayer
+		out    echoReply
+	}{
+		"ICMPv4": {
+			layers: []gopacket.SerializableLayer{
+				&layers.Ethernet{SrcMAC: mac, DstMAC: testRackMAC, EthernetType: layers.EthernetTypeIPv4},
+				&layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4,
+					SrcIP: net.IP{10, 0, 0, 5}, DstIP: net.IP{10, 0, 0, 1}},
+				&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0),
+					Id: 0x1234, Seq: 7},
+				gopacket.Payload(payload),
+			},
+			out: echoReply{
+				IPHwAddressPair: IPHwAddressPair{IP: netip.MustParseAddr("10.0.0.5"), HwAddress: mac},
+				payload:         payload,
+				id:              0x1234,
+				seq:             7,
+			},
+		},
+		"ICMPv6": {
+			layers: []gopacket.SerializableLayer{
+				&layers.Ethernet{SrcMAC: mac, DstMAC: testRackMAC, EthernetType: layers.EthernetTypeIPv6},
+				&layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolICMPv6,
+					SrcIP: net.ParseIP("fd00::5"), DstIP: net.ParseIP("fd00::1")},
+				&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoReply, 0)},
+				&layers.ICMPv6Echo{Identifier: 0x1234, SeqNumber: 7},
+				gopacket.Payload(payload),
+			},
+			out: echoReply{
+				IPHwAddressPair: IPHwAddressPair{IP: netip.MustParseAddr("fd00::5"), HwAddress: mac},
+				payload:         payload,
+				id:              0x1234,
+				seq:             7,
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			buf := gopacket.NewSerializeBuffer()
+			require.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true},
+				tc.layers...))
+
+			packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.NoCopy)
+			reply := getEchoReply(packet)
+
+			// the payload outlives the packet data
+			clear(buf.Bytes())
+
+			assert.Equal(t, tc.out, reply)
+		})
+	}
+}
+
+func TestSchedulerProbeStats(t *testing.T) {
+	t.Parallel()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	s := NewScheduler(WithSchedulerMeter(provider.Meter("test")))
+
+	s.probes.reply()
+	s.probes.reply()
+	s.probes.unsolicitedReply()
+
+	assert.Equal(t, ProbeStats{Replies: 2, Unsolicited: 1}, s.ProbeStats())
+
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	require.Len(t, rm.ScopeMetrics[0].Metrics, 1)
+
+	m := rm.ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "netmon.scan.replies", m.Name)
+
+	sum, ok := m.Data.(metricdata.Sum[int64])
+	require.True(t, ok)
+
+	values := make(map[string]int64)
+
+	for _, dp := range sum.DataPoints {
+		typ, _ := dp.Attributes.Value(attribute.Key("type"))
+		values[typ.AsString()] = dp.Value
+	}
+
+	assert.Equal(t, map[string]int64{"solicited": 2, "unsolicited": 1}, values)
+
+	// a nil probeCounter counts nothing
+	var probes *probeCounter
+
+	probes.reply()
+	probes.unsolicitedReply()
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+// ProbeLimiter bounds the rate of the frames the agent transmits on its own,
+// the probes of every interface share one so that enabling more of them
+// doesn't add up to a flood. It lets through rate probes per second, in
+// bursts of up to burst.
+type ProbeLimiter struct {
+	clock clock.Clock
+	// next is when the bucket would be empty again, the theoretical arrival
+	// time of the next probe at the nominal rate
+	next      time.Time
+	interval  time.Duration
+	tolerance time.Duration
+	mu        sync.Mutex
+}
+
+// ProbeLimiterOption configures a ProbeLimiter
+type ProbeLimiterOption func(*ProbeLimiter)
+
+// WithProbeLimiterClock sets the clock the rate is measured with
+func WithProbeLimiterClock(c clock.Clock) ProbeLimiterOption {
+	return func(l *ProbeLimiter) {
+		l.clock = c
+	}
+}
+
+// NewProbeLimiter returns a ProbeLimiter letting through rate probes per
+// second, and up to burst at once, a rate of 0 doesn't limit them
+func NewProbeLimiter(rate float64, burst int, options ...ProbeLimiterOption) *ProbeLimiter {
+	l := &ProbeLimiter{clock: clock.System{}}
+	l.setRate(rate, burst)
+
+	for _, opt := range options {
+		opt(l)
+	}
+
+	return l
+}
+
+// setRate changes the rate and the burst of l, the probes already let
+// through count at the former rate
+func (l *ProbeLimiter) setRate(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval, l.tolerance = 0, 0
+
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+		l.tolerance = time.Duration(max(burst, 1)-1) * l.interval
+	}
+}
+
+// Wait blocks until a probe can be sent, or returns ctx.Err() when ctx is
+// done first
+func (l *ProbeLimiter) Wait(ctx context.Context) error {
+	for {
+		wait := l.reserve()
+		if wait == 0 {
+			return nil
+		}
+
+		if err := l.clock.Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes the next probe if it is allowed now, or returns how long
+// until it is
+func (l *ProbeLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.interval == 0 {
+		return 0
+	}
+
+	now := l.clock.Now()
+
+	next := l.next
+	if next.Before(now) {
+		next = now
+	}
+
+	if wait := next.Sub(now) - l.tolerance; wait > 0 {
+		return wait
+	}
+
+	l.next = next.Add(l.interval)
+
+	return 0
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+func TestProbeLimiter(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	l := NewProbeLimiter(10, 3, WithProbeLimiterClock(clk))
+	ctx := context.Background()
+
+	// the burst goes through at once
+	for range 3 {
+		require.NoError(t, l.Wait(ctx))
+	}
+
+	done := make(chan error)
+
+	go func() {
+		done <- l.Wait(ctx)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(99 * time.Millisecond)
+
+	select {
+	case <-done:
+		t.Fatal("probe let through before its interval")
+	default:
+	}
+
+	clk.Advance(time.Millisecond)
+	require.NoError(t, <-done)
+
+	// an idle limiter refills up to the burst only
+	clk.Advance(time.Hour)
+
+	for range 3 {
+		require.NoError(t, l.Wait(ctx))
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		done <- l.Wait(cctx)
+	}()
+
+	clk.BlockUntil(1)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestProbeLimiterUnlimited(t *testing.T) {
+	t.Parallel()
+
+	l := NewProbeLimiter(0, 0)
+
+	for range 1000 {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+}
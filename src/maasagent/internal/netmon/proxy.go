@@ -0,0 +1,254 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+const (
+	// defaultProxyThreshold is the number of addresses of a subnet a MAC
+	// must answer for to be a proxy, far more than the aliases of a host
+	defaultProxyThreshold = 16
+	// defaultProxyCandidates bounds the MACs tracked before being
+	// classified, and the proxies classified
+	defaultProxyCandidates = 1024
+	// maxProxySubnets bounds the subnets counted per candidate, a host
+	// answering in that many is already unusual
+	maxProxySubnets = 16
+	// proxySubnetBits group the addresses answered for into the subnets
+	// proxies usually serve
+	proxySubnetBitsIPv4 = 24
+	proxySubnetBitsIPv6 = 64
+)
+
+// proxyCandidate counts the addresses a MAC answered for, per subnet.
+// routed is set once an advertisement of the MAC had the router flag, and
+// last is the sequence number of its latest answer.
+type proxyCandidate struct {
+	subnets map[netip.Prefix]map[netip.Addr]struct{}
+	last    uint64
+	routed  bool
+}
+
+// ProxyDetector tells apart the routers answering ARP requests or neighbor
+// solicitations on behalf of other hosts, which makes one MAC reply for
+// many addresses of a subnet. It is meant to be shared by the Services of
+// every monitored interface.
+type ProxyDetector struct {
+	candidates map[[6]byte]*proxyCandidate
+	// proxies are the MACs classified, with the sequence number of their
+	// latest answer
+	proxies   map[[6]byte]uint64
+	known     map[[6]byte]struct{}
+	threshold int
+	size      int
+	// sequence orders the answers, to evict the least recent first
+	sequence  uint64
+	evictions uint64
+	mu        sync.Mutex
+}
+
+// ProxyDetectorOption configures a ProxyDetector
+type ProxyDetectorOption func(*ProxyDetector)
+
+// WithProxyThreshold sets the number of addresses of a subnet a MAC must
+// answer for to be classified as a proxy, half as many once it advertised
+// itself as a router
+func WithProxyThreshold(n int) ProxyDetectorOption {
+	return func(d *ProxyDetector) {
+		if n > 0 {
+			d.threshold = n
+		}
+	}
+}
+
+// WithProxyLimits bounds the candidates, and the proxies classified, to
+// those of l. The MACs which answered least recently are forgotten first,
+// a proxy is classified again once it answers for enough addresses.
+func WithProxyLimits(l Limits) ProxyDetectorOption {
+	return func(d *ProxyDetector) {
+		if l.ProxyCandidates > 0 {
+			d.size = l.ProxyCandidates
+		}
+	}
+}
+
+// WithKnownProxies classifies the MACs as proxies from the start
+func WithKnownProxies(macs ...net.HardwareAddr) ProxyDetectorOption {
+	return func(d *ProxyDetector) {
+		for _, mac := range macs {
+			if len(mac) == 6 {
+				d.known[[6]byte(mac)] = struct{}{}
+			}
+		}
+	}
+}
+
+// NewProxyDetector returns a ProxyDetector
+func NewProxyDetector(options ...ProxyDetectorOption) *ProxyDetector {
+	d := &ProxyDetector{
+		candidates: make(map[[6]byte]*proxyCandidate),
+		proxies:    make(map[[6]byte]uint64),
+		known:      make(map[[6]byte]struct{}),
+		threshold:  defaultProxyThreshold,
+		size:       defaultProxyCandidates,
+	}
+
+	for _, opt := range options {
+		opt(d)
+	}
+
+	return d
+}
+
+// Observe records that mac answered for ip, router is the router flag of a
+// neighbor advertisement. It returns true when the answer makes the MAC a
+// proxy.
+func (d *ProxyDetector) Observe(mac net.HardwareAddr, ip netip.Addr, router bool) bool {
+	if len(mac) != 6 || !ip.IsValid() {
+		return false
+	}
+
+	key := [6]byte(mac)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sequence++
+
+	if _, ok := d.proxies[key]; ok {
+		d.proxies[key] = d.sequence
+		return false
+	}
+
+	if d.isProxy(key) {
+		return false
+	}
+
+	c, ok := d.candidates[key]
+	if !ok {
+		if len(d.candidates) >= d.size {
+			d.evictions += evictOldest(d.candidates, func(c *proxyCandidate) uint64 {
+				return c.last
+			})
+		}
+
+		c = &proxyCandidate{subnets: make(map[netip.Prefix]map[netip.Addr]struct{})}
+		d.candidates[key] = c
+	}
+
+	c.last = d.sequence
+	c.routed = c.routed || router
+
+	bits := proxySubnetBitsIPv4
+	if ip.Is6() && !ip.Is4In6() {
+		bits = proxySubnetBitsIPv6
+	}
+
+	ip = ip.Unmap()
+	subnet := netip.PrefixFrom(ip, bits).Masked()
+
+	ips, ok := c.subnets[subnet]
+	if !ok {
+		if len(c.subnets) >= maxProxySubnets {
+			return false
+		}
+
+		ips = make(map[netip.Addr]struct{})
+		c.subnets[subnet] = ips
+	}
+
+	ips[ip] = struct{}{}
+
+	threshold := d.threshold
+	if c.routed {
+		threshold = max(1, threshold/2)
+	}
+
+	if len(ips) < threshold {
+		return false
+	}
+
+	delete(d.candidates, key)
+
+	if len(d.proxies) >= d.size {
+		d.evictions += evictOldest(d.proxies, func(last uint64) uint64 {
+			return last
+		})
+	}
+
+	d.proxies[key] = d.sequence
+
+	return true
+}
+
+// IsProxy returns true if mac was classified as a proxy or is a known one
+func (d *ProxyDetector) IsProxy(mac net.HardwareAddr) bool {
+	if len(mac) != 6 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.isProxy([6]byte(mac))
+}
+
+func (d *ProxyDetector) isProxy(key [6]byte) bool {
+	_, known := d.known[key]
+	_, classified := d.proxies[key]
+
+	return known || classified
+}
+
+// Proxies returns the MACs classified as proxies and the known ones, in
+// order
+func (d *ProxyDetector) Proxies() []net.HardwareAddr {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	macs := make([]net.HardwareAddr, 0, len(d.proxies)+len(d.known))
+
+	for key := range d.proxies {
+		macs = append(macs, net.HardwareAddr(key[:]))
+	}
+
+	for key := range d.known {
+		macs = append(macs, net.HardwareAddr(key[:]))
+	}
+
+	slices.SortFunc(macs, func(a, b net.HardwareAddr) int {
+		return bytes.Compare(a, b)
+	})
+
+	return slices.CompactFunc(macs, func(a, b net.HardwareAddr) bool {
+		return bytes.Equal(a, b)
+	})
+}
+
+// Evictions returns the number of candidates and proxies forgotten for new
+// ones
+func (d *ProxyDetector) Evictions() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.evictions
+}
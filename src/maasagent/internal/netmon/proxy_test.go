@@ -0,0 +1,279 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/ndp"
+)
+
+var (
+	testProxy     = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0xaa}
+	testRequester = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	testProxied   = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x0a}
+)
+
+type proxyObservation struct {
+	ip     netip.Addr
+	router bool
+}
+
+// proxyAnswers returns the observations of n addresses from first, each
+// one further apart by step
+func proxyAnswers(first string, n int, step int, router bool) []proxyObservation {
+	ip := netip.MustParseAddr(first)
+	out := make([]proxyObservation, 0, n)
+
+	for range n {
+		out = append(out, proxyObservation{ip: ip, router: router})
+
+		for range step {
+			ip = ip.Next()
+		}
+	}
+
+	return out
+}
+
+func TestProxyDetector(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		options []ProxyDetectorOption
+		in      []proxyObservation
+		// classified is the index of the observation classifying the MAC,
+		// -1 if none does
+		classified int
+	}{
+		"below the threshold": {
+			in:         proxyAnswers("10.0.0.1", defaultProxyThreshold-1, 1, false),
+			classified: -1,
+		},
+		"at the threshold": {
+			in:         proxyAnswers("10.0.0.1", defaultProxyThreshold, 1, false),
+			classified: defaultProxyThreshold - 1,
+		},
+		"same address": {
+			in:         proxyAnswers("10.0.0.1", defaultProxyThreshold, 0, false),
+			classified: -1,
+		},
+		"spread over subnets": {
+			in:         proxyAnswers("10.0.0.1", defaultProxyThreshold, 256, false),
+			classified: -1,
+		},
+		"router": {
+			in:         proxyAnswers("2001:db8::1", defaultProxyThreshold/2, 1, true),
+			classified: defaultProxyThreshold/2 - 1,
+		},
+		"IPv6 without the router flag": {
+			in:         proxyAnswers("2001:db8::1", defaultProxyThreshold/2, 1, false),
+			classified: -1,
+		},
+		"threshold": {
+			options:    []ProxyDetectorOption{WithProxyThreshold(3)},
+			in:         proxyAnswers("10.0.0.1", 3, 1, false),
+			classified: 2,
+		},
+		"invalid threshold": {
+			options:    []ProxyDetectorOption{WithProxyThreshold(0)},
+			in:         proxyAnswers("10.0.0.1", 3, 1, false),
+			classified: -1,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d := NewProxyDetector(tc.options...)
+			classified := -1
+
+			for i, o := range tc.in {
+				if d.Observe(testProxy, o.ip, o.router) {
+					require.Equal(t, -1, classified, "classified twice")
+
+					classified = i
+				}
+			}
+
+			assert.Equal(t, tc.classified, classified)
+			assert.Equal(t, tc.classified >= 0, d.IsProxy(testProxy))
+		})
+	}
+}
+
+func TestProxyDetectorKnownProxies(t *testing.T) {
+	t.Parallel()
+
+	d := NewProxyDetector(WithProxyThreshold(2), WithKnownProxies(testProxy, net.HardwareAddr{0x01}))
+
+	assert.True(t, d.IsProxy(testProxy))
+	assert.False(t, d.IsProxy(testProxied))
+	// a known proxy isn't classified again
+	assert.False(t, d.Observe(testProxy, netip.MustParseAddr("10.0.0.1"), false))
+	assert.False(t, d.Observe(testProxy, netip.MustParseAddr("10.0.0.2"), false))
+
+	assert.False(t, d.Observe(testProxied, netip.MustParseAddr("10.0.0.1"), false))
+	assert.True(t, d.Observe(testProxied, netip.MustParseAddr("10.0.0.2"), false))
+
+	assert.Equal(t, []net.HardwareAddr{testProxied, testProxy}, d.Proxies())
+}
+
+func TestProxyDetectorBounded(t *testing.T) {
+	t.Parallel()
+
+	d := NewProxyDetector()
+
+	for i := range defaultProxyCandidates + 10 {
+		mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, byte(i >> 8), byte(i)}
+		d.Observe(mac, netip.MustParseAddr("10.0.0.1"), false)
+	}
+
+	assert.LessOrEqual(t, len(d.candidates), defaultProxyCandidates)
+	assert.Equal(t, uint64(defaultProxyCandidates/evictionBatch), d.Evictions())
+	// the first MAC answered least recently
+	assert.NotContains(t, d.candidates, [6]byte{0x00, 0x16, 0x3e})
+
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	for range maxProxySubnets * 2 {
+		d.Observe(testRequester, ip, false)
+		ip = netip.AddrFrom4([4]byte{10, 0, ip.As4()[2] + 1, 1})
+	}
+
+	assert.Len(t, d.candidates[[6]byte(testRequester)].subnets, maxProxySubnets)
+}
+
+// proxyReply is the reply of the proxy to testRequester for ip
+func proxyReply(ip string) *ethernet.ARPPacket {
+	pkt := testARPPacket()
+	pkt.OpCode = ethernet.OpReply
+	pkt.SendIPAddr = netip.MustParseAddr(ip)
+	pkt.SendHwAddr = testProxy
+	pkt.TgtIPAddr = netip.MustParseAddr("10.0.0.1")
+	pkt.TgtHwAddr = testRequester
+
+	return pkt
+}
+
+// resultsFor returns the results about ip
+func resultsFor(res []Result, ip string) []Result {
+	var out []Result
+
+	for _, r := range res {
+		if r.IP == ip {
+			out = append(out, r)
+		}
+	}
+
+	return out
+}
+
+func TestServiceProxy(t *testing.T) {
+	t.Parallel()
+
+	a := NewAssertions()
+	require.NoError(t, a.Set([]BindingAssertion{{IP: "10.0.0.254", MAC: "00:16:3e:00:00:fe"}}))
+
+	svc := NewService("eth0", WithAssertions(a),
+		WithProxyDetector(NewProxyDetector(WithProxyThreshold(4))))
+	timestamp := time.Unix(1700000000, 0)
+
+	// a host answering for itself before the proxy is classified
+	direct := testARPPacket()
+	direct.SendIPAddr = netip.MustParseAddr("10.0.0.5")
+	direct.SendHwAddr = testProxied
+	svc.updateBindings(direct, nil, timestamp)
+
+	for i := range 4 {
+		res := svc.updateBindings(proxyReply(fmt.Sprintf("10.0.0.%d", 10+i)), nil, timestamp)
+		assert.Len(t, resultsFor(res, fmt.Sprintf("10.0.0.%d", 10+i)), 1)
+	}
+
+	// the bindings learned before the classification are marked too
+	for _, b := range svc.Snapshot().Bindings {
+		assert.Equal(t, b.MAC == testProxy.String(), b.ViaProxy, b.IP)
+	}
+
+	// the proxy answering for the direct binding doesn't move it
+	res := svc.updateBindings(proxyReply("10.0.0.5"), nil, timestamp.Add(time.Second))
+	assert.Empty(t, resultsFor(res, "10.0.0.5"))
+
+	// nor violates an assertion
+	res = svc.updateBindings(proxyReply("10.0.0.254"), nil, timestamp.Add(time.Second))
+	require.Len(t, resultsFor(res, "10.0.0.254"), 1)
+	assert.Equal(t, EventNew, resultsFor(res, "10.0.0.254")[0].Event)
+	assert.Empty(t, svc.Snapshot().Violations)
+
+	// the host itself replaces the binding of the proxy, however fresh
+	direct.SendIPAddr = netip.MustParseAddr("10.0.0.10")
+	direct.OpCode = ethernet.OpRequest
+
+	res = svc.updateBindings(direct, nil, timestamp.Add(time.Second))
+	require.Len(t, res, 1)
+	assert.Equal(t, EventMoved, res[0].Event)
+	assert.Equal(t, testProxy.String(), res[0].PreviousMAC)
+
+	for _, b := range svc.Snapshot().Bindings {
+		if b.IP == "10.0.0.10" {
+			assert.False(t, b.ViaProxy)
+		}
+	}
+}
+
+func TestServiceNDPProxy(t *testing.T) {
+	t.Parallel()
+
+	proxies := NewProxyDetector(WithProxyThreshold(4))
+	svc := NewService("eth0", WithProxyDetector(proxies))
+	md := capture.Metadata{Timestamp: time.Unix(1700000000, 0)}
+
+	pkt := testARPPacket()
+	pkt.SendHwAddr = testProxy
+	svc.updateBindings(pkt, nil, md.Timestamp)
+
+	target := netip.MustParseAddr("2001:db8::10")
+
+	for range 2 {
+		frame := ndpFrame(testProxy, netip.MustParseAddr("fe80::1"), ndp.TypeNeighborAdvertisement, target)
+		// the router flag
+		frame[14+40+4] = 0x80
+
+		res, err := svc.handleFrame(frame, md)
+		require.NoError(t, err)
+		assert.Empty(t, res)
+
+		target = target.Next()
+	}
+
+	assert.True(t, proxies.IsProxy(testProxy))
+
+	snap := svc.Snapshot()
+	require.Len(t, snap.Bindings, 1)
+	assert.True(t, snap.Bindings[0].ViaProxy)
+}
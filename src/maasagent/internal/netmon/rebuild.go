@@ -0,0 +1,375 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/journal"
+)
+
+// ErrInvalidRecord is returned by RebuildTable for a journal record which
+// isn't a Result of a binding
+var ErrInvalidRecord = errors.New("invalid journal record")
+
+// rebuildConfig is how RebuildTable replays a journal
+type rebuildConfig struct {
+	clock    clock.Clock
+	from, to time.Time
+}
+
+// RebuildOption configures RebuildTable
+type RebuildOption func(*rebuildConfig)
+
+// WithRebuildClock sets the clock the rebuilt table is snapshotted with, a
+// fake one makes the scores of the snapshot those of a given time
+func WithRebuildClock(c clock.Clock) RebuildOption {
+	return func(cfg *rebuildConfig) {
+		cfg.clock = c
+	}
+}
+
+// WithRebuildWindow only replays the Results observed from from, and
+// before to. A zero time leaves its end of the window open.
+func WithRebuildWindow(from, to time.Time) RebuildOption {
+	return func(cfg *rebuildConfig) {
+		cfg.from, cfg.to = from, to
+	}
+}
+
+// SequenceRange is a range of journal sequences, both ends included
+type SequenceRange struct {
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+}
+
+func (r SequenceRange) String() string {
+	if r.First == r.Last {
+		return strconv.FormatUint(r.First, 10)
+	}
+
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// RebuiltTable is the neighbor table of an interface rebuilt from the
+// Results journaled for it, see RebuildTable
+type RebuiltTable struct {
+	// Snapshot is the rebuilt table, as of the clock of the rebuild
+	Snapshot Snapshot `json:"snapshot"`
+	// Replayed are the sequences of the records replayed, Skipped is the
+	// number of those outside the window or of no binding
+	Replayed SequenceRange `json:"replayed"`
+	Skipped  int           `json:"skipped"`
+	// Inconsistent are the sequences of the records which didn't follow
+	// from the table they were replayed into, such as a MOVED from another
+	// MAC than the one bound: a record is missing before them, or the table
+	// evicted the binding
+	Inconsistent []uint64 `json:"inconsistent,omitempty"`
+	// records are the sequences replayed into each binding, in order
+	records map[bindingKey][]uint64
+}
+
+// RebuildTable replays the Results journaled for iface into a fresh
+// neighbor table, oldest first. The journal holds what the scoring of the
+// bindings decided rather than the observations scored, so each NEW,
+// REFRESHED or MOVED Result sets its binding as it tells, the other
+// Results are skipped. The bindings the kernel neighbor cache or a Primer
+// removed aren't journaled, and neither are the evictions: the rebuilt
+// table holds every binding the journal tells of.
+//
+// The replay only depends on the records and the clock, so the same
+// journal rebuilds the same table.
+func RebuildTable(iface string, records []journal.Record, options ...RebuildOption) (*RebuiltTable, error) {
+	cfg := rebuildConfig{clock: clock.System{}}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	// a single shard holding every binding of the journal leaves nothing
+	// to the hash seed or to the evictions
+	s := NewService(iface, WithClock(cfg.clock))
+	s.table = newBindingTable(1, max(len(records), 1))
+
+	t := &RebuiltTable{records: make(map[bindingKey][]uint64)}
+
+	var labels map[string]string
+
+	for _, r := range records {
+		var res Result
+
+		if err := json.Unmarshal(r.Data, &res); err != nil {
+			return nil, fmt.Errorf("%w %d: %w", ErrInvalidRecord, r.Seq, err)
+		}
+
+		at := time.Unix(res.Time, 0)
+
+		if !res.Event.changesBinding() || !cfg.from.IsZero() && at.Before(cfg.from) ||
+			!cfg.to.IsZero() && !at.Before(cfg.to) {
+			t.Skipped++
+			continue
+		}
+
+		ip, err := netip.ParseAddr(res.IP)
+		if err != nil {
+			return nil, fmt.Errorf("%w %d: %w", ErrInvalidRecord, r.Seq, err)
+		}
+
+		mac, err := net.ParseMAC(res.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("%w %d: %w", ErrInvalidRecord, r.Seq, err)
+		}
+
+		b := Binding{VID: res.VID, Time: at, IP: ip.Unmap(), MAC: mac}
+
+		if !s.replay(res, b) {
+			t.Inconsistent = append(t.Inconsistent, r.Seq)
+		}
+
+		key := b.key()
+		t.records[key] = append(t.records[key], r.Seq)
+
+		if t.Replayed.First == 0 {
+			t.Replayed.First = r.Seq
+		}
+
+		t.Replayed.Last = r.Seq
+
+		if res.Labels != nil {
+			labels = res.Labels
+		}
+	}
+
+	s.setLabels(labels)
+	t.Snapshot = s.Snapshot()
+
+	return t, nil
+}
+
+// changesBinding returns true for the events of a binding being set
+func (e Event) changesBinding() bool {
+	return e == EventNew || e == EventRefreshed || e == EventMoved
+}
+
+// key returns the key of the binding in the table
+func (b Binding) key() bindingKey {
+	key := bindingKey{ip: b.IP}
+	if b.VID != nil {
+		key.vid = *b.VID
+	}
+
+	return key
+}
+
+// replay sets b as the Result res of a journal tells, and returns false
+// when res doesn't follow from the binding it replaces
+func (s *Service) replay(res Result, b Binding) bool {
+	key := b.key()
+	sh := s.table.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	binding, ok := sh.bindings[key]
+
+	var consistent bool
+
+	switch res.Event {
+	case EventNew:
+		consistent = !ok
+	case EventMoved:
+		consistent = ok && binding.MAC.String() == res.PreviousMAC
+	case EventRefreshed:
+		if ok && bytes.Equal(binding.MAC, b.MAC) {
+			binding = s.weights.corroborate(binding, b)
+			binding.Time, binding.VID = b.Time, b.VID
+			sh.bindings[key] = binding
+
+			return true
+		}
+	}
+
+	s.table.bind(sh, key, b)
+	delete(sh.challengers, key)
+
+	return consistent
+}
+
+// Divergence is a binding a RebuiltTable and another snapshot disagree on
+type Divergence struct {
+	VID *uint16 `json:"vid"`
+	IP  string  `json:"ip"`
+	// Rebuilt and Other are the MACs of the binding in either table, empty
+	// when the table doesn't have it
+	Rebuilt string `json:"rebuilt,omitempty"`
+	Other   string `json:"other,omitempty"`
+	// Records are the sequences of the records replayed into the binding,
+	// none when the journal doesn't tell of it
+	Records []SequenceRange `json:"records,omitempty"`
+}
+
+func (d Divergence) String() string {
+	var b strings.Builder
+
+	b.WriteString(d.IP)
+
+	if d.VID != nil {
+		fmt.Fprintf(&b, " vlan %d", *d.VID)
+	}
+
+	switch {
+	case d.Rebuilt == "":
+		fmt.Fprintf(&b, ": %s missing from the rebuilt table", d.Other)
+	case d.Other == "":
+		fmt.Fprintf(&b, ": %s missing from the other table", d.Rebuilt)
+	default:
+		fmt.Fprintf(&b, ": %s rebuilt, %s in the other table", d.Rebuilt, d.Other)
+	}
+
+	if len(d.Records) == 0 {
+		b.WriteString(", no record")
+		return b.String()
+	}
+
+	ranges := make([]string, 0, len(d.Records))
+	for _, r := range d.Records {
+		ranges = append(ranges, r.String())
+	}
+
+	fmt.Fprintf(&b, ", records %s", strings.Join(ranges, ", "))
+
+	return b.String()
+}
+
+// RebuildReport tells where a RebuiltTable and another snapshot of the
+// interface diverge
+type RebuildReport struct {
+	Interface string        `json:"interface"`
+	Replayed  SequenceRange `json:"replayed"`
+	// Divergences are in the order of Snapshot.Bindings
+	Divergences []Divergence `json:"divergences,omitempty"`
+	// Inconsistent are those of the RebuiltTable
+	Inconsistent []uint64 `json:"inconsistent,omitempty"`
+}
+
+// String returns the report for a reader, a line per divergence
+func (r RebuildReport) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s: %d bindings diverge, records %s replayed\n", r.Interface, len(r.Divergences), r.Replayed)
+
+	for _, d := range r.Divergences {
+		fmt.Fprintf(&b, "  %s\n", d)
+	}
+
+	if len(r.Inconsistent) > 0 {
+		seqs := make([]string, 0, len(r.Inconsistent))
+		for _, seq := range r.Inconsistent {
+			seqs = append(seqs, strconv.FormatUint(seq, 10))
+		}
+
+		fmt.Fprintf(&b, "records inconsistent with the table replayed into: %s\n", strings.Join(seqs, ", "))
+	}
+
+	return b.String()
+}
+
+// Diff returns the bindings t and other disagree on the MAC of, or which
+// only one of them has. The scores, sources and labels of the bindings
+// aren't compared, the journal doesn't tell them.
+func (t *RebuiltTable) Diff(other Snapshot) RebuildReport {
+	r := RebuildReport{
+		Interface:    t.Snapshot.Interface,
+		Replayed:     t.Replayed,
+		Inconsistent: t.Inconsistent,
+	}
+
+	rebuilt, others := sortedBindings(t.Snapshot.Bindings), sortedBindings(other.Bindings)
+
+	// both lists are sorted, walk them as in a merge
+	i, j := 0, 0
+
+	for i < len(rebuilt) || j < len(others) {
+		var (
+			c int
+			d Divergence
+		)
+
+		switch {
+		case i == len(rebuilt):
+			c = 1
+		case j == len(others):
+			c = -1
+		default:
+			c = compareSnapshotBindings(rebuilt[i], others[j])
+		}
+
+		switch {
+		case c < 0:
+			d = Divergence{VID: rebuilt[i].VID, IP: rebuilt[i].IP, Rebuilt: rebuilt[i].MAC}
+			i++
+		case c > 0:
+			d = Divergence{VID: others[j].VID, IP: others[j].IP, Other: others[j].MAC}
+			j++
+		default:
+			d = Divergence{VID: rebuilt[i].VID, IP: rebuilt[i].IP, Rebuilt: rebuilt[i].MAC, Other: others[j].MAC}
+			i++
+			j++
+
+			if d.Rebuilt == d.Other {
+				continue
+			}
+		}
+
+		d.Records = t.ranges(d.IP, d.VID)
+		r.Divergences = append(r.Divergences, d)
+	}
+
+	return r
+}
+
+// ranges returns the sequences of the records replayed into the binding of
+// ip on vid, consecutive ones as a range
+func (t *RebuiltTable) ranges(ip string, vid *uint16) []SequenceRange {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+
+	b := Binding{IP: addr.Unmap(), VID: vid}
+
+	var ranges []SequenceRange
+
+	for _, seq := range t.records[b.key()] {
+		if n := len(ranges); n > 0 && ranges[n-1].Last+1 == seq {
+			ranges[n-1].Last = seq
+			continue
+		}
+
+		ranges = append(ranges, SequenceRange{First: seq, Last: seq})
+	}
+
+	return ranges
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/journal"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+// journalRecords returns the Results as the records of a journal, from
+// sequence 1
+func journalRecords(t *testing.T, results []Result) []journal.Record {
+	t.Helper()
+
+	records := make([]journal.Record, 0, len(results))
+
+	for i, res := range results {
+		data, err := json.Marshal(res)
+		require.NoError(t, err)
+
+		records = append(records, journal.Record{Seq: uint64(i + 1), Data: data})
+	}
+
+	return records
+}
+
+func TestRebuildTableMatchesService(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	s := NewService("eth0", WithClock(clk))
+	require.NoError(t, s.SetLabels(map[string]string{"rack": "r1"}))
+
+	vid := uint16(5)
+	ip1, ip2, ip3 := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.3")
+
+	var results []Result
+
+	observe := func(after time.Duration, kind ObservationKind, ip netip.Addr, mac string, vid *uint16) {
+		results = append(results, s.Observe(kind, ip, mustParseMAC(mac), vid, schedulerEpoch.Add(after))...)
+	}
+
+	observe(0, ObservationARPRequest, ip1, "00:16:3e:00:00:01", nil)
+	observe(0, ObservationARPRequest, ip2, "00:16:3e:00:00:02", &vid)
+	// coalesced, then refreshed
+	observe(time.Minute, ObservationARPRequest, ip1, "00:16:3e:00:00:01", nil)
+	observe(15*time.Minute, ObservationARPReply, ip1, "00:16:3e:00:00:01", nil)
+	// a challenger scoring lower isn't journaled, a higher one moves
+	observe(16*time.Minute, ObservationMDNS, ip1, "00:16:3e:00:00:04", nil)
+	observe(3*time.Hour, ObservationDHCPAck, ip2, "00:16:3e:00:00:03", &vid)
+
+	ingested, err := s.Ingest(Observation{Source: "dhcpd-leases", IP: ip3, MAC: mustParseMAC("00:16:3e:00:00:05"),
+		Kind: ObservationDHCPAck, Confidence: ConfidenceHigh, Time: schedulerEpoch.Add(time.Hour)})
+	require.NoError(t, err)
+
+	results = append(results, ingested...)
+
+	events := make([]Event, 0, len(results))
+	for _, res := range results {
+		events = append(events, res.Event)
+	}
+
+	require.Equal(t, []Event{EventNew, EventNew, EventRefreshed, EventMoved, EventNew}, events)
+
+	// the Results go through a journal, as they do to be uploaded
+	j, err := journal.Open(filepath.Join(t.TempDir(), "results"))
+	require.NoError(t, err)
+
+	defer j.Close() //nolint:errcheck // closed once
+
+	for _, record := range journalRecords(t, results) {
+		_, err := j.Append(record.Data)
+		require.NoError(t, err)
+	}
+
+	records, err := j.Replay()
+	require.NoError(t, err)
+
+	clk.Advance(4 * time.Hour)
+
+	rebuilt, err := RebuildTable("eth0", records, WithRebuildClock(clk))
+	require.NoError(t, err)
+
+	assert.Equal(t, SequenceRange{First: 1, Last: 5}, rebuilt.Replayed)
+	assert.Zero(t, rebuilt.Skipped)
+	assert.Empty(t, rebuilt.Inconsistent)
+
+	live := s.Snapshot()
+	assert.Equal(t, live.Time, rebuilt.Snapshot.Time)
+	assert.Equal(t, live.Bindings[0].Labels, rebuilt.Snapshot.Bindings[0].Labels)
+
+	report := rebuilt.Diff(live)
+	assert.Empty(t, report.Divergences)
+	assert.Equal(t, "eth0: 0 bindings diverge, records 1-5 replayed\n", report.String())
+}
+
+func TestRebuildTableWindow(t *testing.T) {
+	t.Parallel()
+
+	at := func(d time.Duration) int64 { return schedulerEpoch.Add(d).Unix() }
+
+	records := journalRecords(t, []Result{
+		{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Time: at(0), Event: EventNew},
+		{IP: "10.0.0.2", MAC: "00:16:3e:00:00:02", Time: at(time.Hour), Event: EventNew},
+		{Time: at(time.Hour), Event: EventModeChanged, Mode: &ModeChange{Previous: ModeStandby, Current: ModeActive}},
+		{IP: "10.0.0.2", MAC: "00:16:3e:00:00:03", PreviousMAC: "00:16:3e:00:00:02", Time: at(2 * time.Hour),
+			Event: EventMoved},
+		{IP: "10.0.0.3", MAC: "00:16:3e:00:00:04", Time: at(3 * time.Hour), Event: EventNew},
+	})
+
+	clk := clocktest.NewFake(schedulerEpoch.Add(4 * time.Hour))
+
+	rebuilt, err := RebuildTable("eth0", records, WithRebuildClock(clk),
+		WithRebuildWindow(schedulerEpoch.Add(time.Hour), schedulerEpoch.Add(3*time.Hour)))
+	require.NoError(t, err)
+
+	assert.Equal(t, SequenceRange{First: 2, Last: 4}, rebuilt.Replayed)
+	assert.Equal(t, 3, rebuilt.Skipped)
+	assert.Empty(t, rebuilt.Inconsistent)
+
+	require.Len(t, rebuilt.Snapshot.Bindings, 1)
+	assert.Equal(t, "10.0.0.2", rebuilt.Snapshot.Bindings[0].IP)
+	assert.Equal(t, "00:16:3e:00:00:03", rebuilt.Snapshot.Bindings[0].MAC)
+	assert.Equal(t, at(2*time.Hour), rebuilt.Snapshot.Bindings[0].Time)
+	assert.Equal(t, clk.Now().Unix(), rebuilt.Snapshot.Time)
+}
+
+func TestRebuildTableInvalidRecord(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string][]byte{
+		"not JSON":    []byte("NEW 10.0.0.1"),
+		"invalid IP":  []byte(`{"ip": "10.0.0", "mac": "00:16:3e:00:00:01", "event": "NEW"}`),
+		"invalid MAC": []byte(`{"ip": "10.0.0.1", "mac": "00:16:3e", "event": "NEW"}`),
+	}
+
+	for name, data := range testcases {
+		data := data
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := RebuildTable("eth0", []journal.Record{{Seq: 7, Data: data}})
+			assert.ErrorIs(t, err, ErrInvalidRecord)
+			assert.ErrorContains(t, err, "record 7")
+		})
+	}
+}
+
+func TestRebuiltTableDiff(t *testing.T) {
+	t.Parallel()
+
+	vid := uint16(5)
+
+	records := journalRecords(t, []Result{
+		{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Event: EventNew},
+		{IP: "10.0.0.2", MAC: "00:16:3e:00:00:02", VID: &vid, Event: EventNew},
+		{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Time: 600, Event: EventRefreshed},
+		{IP: "10.0.0.2", MAC: "00:16:3e:00:00:02", VID: &vid, Time: 600, Event: EventRefreshed},
+		{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Time: 1200, Event: EventRefreshed},
+		// a record is missing, the binding moved twice
+		{IP: "10.0.0.2", MAC: "00:16:3e:00:00:04", PreviousMAC: "00:16:3e:00:00:03", VID: &vid, Time: 1800,
+			Event: EventMoved},
+		{IP: "10.0.0.3", MAC: "00:16:3e:00:00:05", Time: 1800, Event: EventNew},
+	})
+
+	rebuilt, err := RebuildTable("eth0", records, WithRebuildClock(clocktest.NewFake(time.Unix(3600, 0))))
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{6}, rebuilt.Inconsistent)
+
+	other := Snapshot{
+		Interface: "eth0",
+		Bindings: []SnapshotBinding{
+			{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Observation: "arp_reply", Score: 0.5},
+			{IP: "10.0.0.2", VID: &vid, MAC: "00:16:3e:00:00:03"},
+			{IP: "10.0.0.9", MAC: "00:16:3e:00:00:09"},
+		},
+	}
+
+	report := rebuilt.Diff(other)
+
+	assert.Equal(t, []Divergence{
+		{IP: "10.0.0.2", VID: &vid, Rebuilt: "00:16:3e:00:00:04", Other: "00:16:3e:00:00:03",
+			Records: []SequenceRange{{First: 2, Last: 2}, {First: 4, Last: 4}, {First: 6, Last: 6}}},
+		{IP: "10.0.0.3", Rebuilt: "00:16:3e:00:00:05", Records: []SequenceRange{{First: 7, Last: 7}}},
+		{IP: "10.0.0.9", Other: "00:16:3e:00:00:09"},
+	}, report.Divergences)
+
+	assert.Equal(t, `eth0: 3 bindings diverge, records 1-7 replayed
+  10.0.0.2 vlan 5: 00:16:3e:00:00:04 rebuilt, 00:16:3e:00:00:03 in the other table, records 2, 4, 6
+  10.0.0.3: 00:16:3e:00:00:05 missing from the other table, records 7
+  10.0.0.9: 00:16:3e:00:00:09 missing from the rebuilt table, no record
+records inconsistent with the table replayed into: 6
+`, report.String())
+
+	// the consecutive records of a binding are a range
+	rebuilt.records[bindingKey{ip: netip.MustParseAddr("10.0.0.3")}] = []uint64{7, 8, 9, 11}
+	assert.Equal(t, []SequenceRange{{First: 7, Last: 9}, {First: 11, Last: 11}}, rebuilt.ranges("10.0.0.3", nil))
+}
+
+// randomJournal returns n Results of a few bindings, in the order of their
+// times, some of which don't follow from the previous ones
+func randomJournal(t *testing.T, rng *rand.Rand, n int) []journal.Record {
+	t.Helper()
+
+	vid := uint16(5)
+	events := []Event{EventNew, EventRefreshed, EventMoved, EventDADConflict}
+	results := make([]Result, 0, n)
+	at := schedulerEpoch.Unix()
+
+	for range n {
+		at += rng.Int64N(900)
+
+		res := Result{
+			IP:    fmt.Sprintf("10.0.0.%d", rng.IntN(8)+1),
+			MAC:   fmt.Sprintf("00:16:3e:00:00:%02x", rng.IntN(4)+1),
+			Time:  at,
+			Event: events[rng.IntN(len(events))],
+		}
+
+		if rng.IntN(2) == 0 {
+			res.VID = &vid
+		}
+
+		if res.Event == EventMoved {
+			res.PreviousMAC = fmt.Sprintf("00:16:3e:00:00:%02x", rng.IntN(4)+1)
+		}
+
+		results = append(results, res)
+	}
+
+	return journalRecords(t, results)
+}
+
+func TestRebuildTableDeterministic(t *testing.T) {
+	t.Parallel()
+
+	for seed := range uint64(50) {
+		rng := rand.New(rand.NewPCG(seed, 1)) //nolint:gosec // deterministic test data
+		records := randomJournal(t, rng, 200)
+
+		rebuild := func() *RebuiltTable {
+			clk := clocktest.NewFake(schedulerEpoch.Add(48 * time.Hour))
+
+			rebuilt, err := RebuildTable("eth0", records, WithRebuildClock(clk))
+			require.NoError(t, err)
+
+			return rebuilt
+		}
+
+		first, second := rebuild(), rebuild()
+
+		// the same journal rebuilds the same table, down to the scores
+		require.Equal(t, first, second, "seed %d", seed)
+		assert.Empty(t, first.Diff(second.Snapshot).Divergences, "seed %d", seed)
+	}
+}
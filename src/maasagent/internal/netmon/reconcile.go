@@ -0,0 +1,417 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/netif"
+)
+
+// defaultReconcileInterval is how often the kernel neighbor cache is read
+const defaultReconcileInterval = time.Minute
+
+// BindingSource tells where a binding of a Service comes from
+type BindingSource uint8
+
+const (
+	// BindingSourceCapture is a binding observed in ARP traffic
+	BindingSourceCapture BindingSource = iota
+	// BindingSourceKernel is a binding merged from the kernel neighbor cache,
+	// which is replaced as soon as the binding is observed
+	BindingSourceKernel
+	// BindingSourceExternal is a binding ingested from another source than
+	// the capture, see Service.Ingest
+	BindingSourceExternal
+	// BindingSourcePrimed is a binding installed ahead of the host booting,
+	// see Service.PrimeNeighbor, which any observation replaces
+	BindingSourcePrimed
+)
+
+func (s BindingSource) String() string {
+	switch s {
+	case BindingSourceKernel:
+		return "kernel"
+	case BindingSourceExternal:
+		return "external"
+	case BindingSourcePrimed:
+		return "primed"
+	default:
+		return "capture"
+	}
+}
+
+// Confidence is how far the kernel vouches for a neighbor, from the state of
+// its neighbor cache entry
+type Confidence uint8
+
+const (
+	// ConfidenceNone is for entries the kernel failed to resolve
+	ConfidenceNone Confidence = iota
+	// ConfidenceLow is for entries the kernel is probing again
+	ConfidenceLow
+	// ConfidenceMedium is for stale entries, which were reachable a while ago
+	ConfidenceMedium
+	// ConfidenceHigh is for reachable and permanent entries
+	ConfidenceHigh
+)
+
+func (c Confidence) String() string {
+	switch c {
+	case ConfidenceLow:
+		return "low"
+	case ConfidenceMedium:
+		return "medium"
+	case ConfidenceHigh:
+		return "high"
+	default:
+		return "none"
+	}
+}
+
+// neighConfidence maps the NUD state of a kernel entry onto a Confidence
+func neighConfidence(state netif.NeighState) Confidence {
+	switch {
+	case state&(netif.NeighReachable|netif.NeighPermanent) != 0:
+		return ConfidenceHigh
+	case state&netif.NeighStale != 0:
+		return ConfidenceMedium
+	case state.Resolved():
+		return ConfidenceLow
+	default:
+		return ConfidenceNone
+	}
+}
+
+// ReconcileEntry is a binding the kernel neighbor cache and a Service don't
+// agree on
+type ReconcileEntry struct {
+	VID *uint16 `json:"vid"`
+	IP  string  `json:"ip"`
+	// MAC is the observed MAC, KernelMAC the one in the kernel cache
+	MAC       string `json:"mac,omitempty"`
+	KernelMAC string `json:"kernel_mac,omitempty"`
+	// State is the state of the kernel entry, if there is one
+	State string `json:"state,omitempty"`
+}
+
+// ReconcileReport holds the discrepancies between the kernel neighbor cache
+// and the bindings of a Service, each list is in the order of IP then VLAN
+type ReconcileReport struct {
+	Interface string `json:"interface"`
+	// KernelOnly are resolved kernel entries which were never observed
+	KernelOnly []ReconcileEntry `json:"kernel_only,omitempty"`
+	// ObservedOnly are observed bindings the kernel has no resolved entry for
+	ObservedOnly []ReconcileEntry `json:"observed_only,omitempty"`
+	// Mismatched are the bindings with another MAC in the kernel cache
+	Mismatched []ReconcileEntry `json:"mismatched,omitempty"`
+	Time       int64            `json:"time"`
+}
+
+// Empty returns true if the kernel and the Service agree
+func (r ReconcileReport) Empty() bool {
+	return len(r.KernelOnly) == 0 && len(r.ObservedOnly) == 0 && len(r.Mismatched) == 0
+}
+
+// kernelEntry is a kernel neighbor on the interface of a Service or one of
+// its VLAN sub-interfaces
+type kernelEntry struct {
+	vid   *uint16
+	ip    netip.Addr
+	mac   net.HardwareAddr
+	state netif.NeighState
+}
+
+func compareReconcileEntries(a, b ReconcileEntry) int {
+	return compareSnapshotBindings(SnapshotBinding{IP: a.IP, VID: a.VID}, SnapshotBinding{IP: b.IP, VID: b.VID})
+}
+
+// reconcile merges the kernel entries into the bindings and reports the
+// discrepancies. Observed bindings are never replaced by kernel ones, so a
+// different MAC seen on the wire is still reported as moved. The shards of
+// the table are reconciled one after the other.
+func (s *Service) reconcile(entries []kernelEntry) ReconcileReport {
+	now := s.clock.Now()
+	report := ReconcileReport{
+		Interface: s.iface,
+		Time:      now.Unix(),
+	}
+
+	kernel := make(map[bindingKey]kernelEntry, len(entries))
+
+	for _, e := range entries {
+		var vid uint16
+		if e.vid != nil {
+			vid = *e.vid
+		}
+
+		kernel[bindingKey{ip: e.ip, vid: vid}] = e
+	}
+
+	s.table.update(func(sh *bindingShard) {
+		for key, b := range sh.bindings {
+			e, ok := kernel[key]
+
+			if b.Source == BindingSourceKernel {
+				// the kernel forgot the entry or failed to resolve it again
+				if !ok || !e.state.Resolved() {
+					delete(sh.bindings, key)
+				}
+
+				continue
+			}
+
+			// the Primer installed the kernel entry and looks after it
+			if b.Source == BindingSourcePrimed {
+				continue
+			}
+
+			entry := ReconcileEntry{VID: b.VID, IP: b.IP.String(), MAC: b.MAC.String()}
+
+			if ok {
+				entry.State = e.state.String()
+			}
+
+			switch {
+			case !ok || !e.state.Resolved():
+				report.ObservedOnly = append(report.ObservedOnly, entry)
+			case !bytes.Equal(b.MAC, e.mac):
+				entry.KernelMAC = e.mac.String()
+				report.Mismatched = append(report.Mismatched, entry)
+			}
+		}
+	})
+
+	for key, e := range kernel {
+		if !e.state.Resolved() || !s.bindKernel(key, e, now) {
+			continue
+		}
+
+		report.KernelOnly = append(report.KernelOnly, ReconcileEntry{
+			VID:       e.vid,
+			IP:        e.ip.String(),
+			KernelMAC: e.mac.String(),
+			State:     e.state.String(),
+		})
+	}
+
+	slices.SortFunc(report.KernelOnly, compareReconcileEntries)
+	slices.SortFunc(report.ObservedOnly, compareReconcileEntries)
+	slices.SortFunc(report.Mismatched, compareReconcileEntries)
+
+	return report
+}
+
+// bindKernel binds the kernel entry e of key unless the capture or another
+// source observed the binding, and returns whether it did
+func (s *Service) bindKernel(key bindingKey, e kernelEntry, now time.Time) bool {
+	sh := s.table.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if b, ok := sh.bindings[key]; ok && b.Source != BindingSourceKernel {
+		return false
+	}
+
+	s.table.bind(sh, key, Binding{
+		VID:        e.vid,
+		Time:       now,
+		IP:         e.ip,
+		MAC:        e.mac,
+		Source:     BindingSourceKernel,
+		Confidence: neighConfidence(e.state),
+		Kind:       ObservationKernel,
+	})
+
+	return true
+}
+
+// Reconciler periodically compares the kernel neighbor cache with the
+// bindings of Services, to catch what either of them misses
+type Reconciler struct {
+	links     LinkSource
+	clock     clock.Clock
+	neighbors func() ([]netif.Neighbor, error)
+	reports   func(ReconcileReport)
+	services  []*Service
+	interval  time.Duration
+}
+
+// ReconcilerOption configures a Reconciler
+type ReconcilerOption func(*Reconciler)
+
+// WithReconcileInterval sets how often the kernel neighbor cache is read
+func WithReconcileInterval(d time.Duration) ReconcilerOption {
+	return func(r *Reconciler) {
+		if d > 0 {
+			r.interval = d
+		}
+	}
+}
+
+// WithReconcilerClock sets the clock pacing Run
+func WithReconcilerClock(c clock.Clock) ReconcilerOption {
+	return func(r *Reconciler) {
+		r.clock = c
+	}
+}
+
+// WithNeighborSource replaces netif.DumpNeighbors as the source of the
+// kernel entries
+func WithNeighborSource(f func() ([]netif.Neighbor, error)) ReconcilerOption {
+	return func(r *Reconciler) {
+		r.neighbors = f
+	}
+}
+
+// WithReconcileReports calls f from Run with every report which isn't empty
+func WithReconcileReports(f func(ReconcileReport)) ReconcilerOption {
+	return func(r *Reconciler) {
+		r.reports = f
+	}
+}
+
+// NewReconciler returns a Reconciler of the services, links maps the kernel
+// entries of VLAN sub-interfaces onto the VLANs of their parent
+func NewReconciler(links LinkSource, services []*Service, options ...ReconcilerOption) *Reconciler {
+	r := &Reconciler{
+		links:     links,
+		clock:     clock.System{},
+		neighbors: netif.DumpNeighbors,
+		services:  services,
+		interval:  defaultReconcileInterval,
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	return r
+}
+
+// serviceVLAN is the Service and VLAN the entries of an interface belong to
+type serviceVLAN struct {
+	svc *Service
+	vid *uint16
+}
+
+// interfaces maps the index of the interfaces onto the Services observing
+// them
+func (r *Reconciler) interfaces() map[int]serviceVLAN {
+	byName := make(map[string]*Service, len(r.services))
+
+	for _, svc := range r.services {
+		byName[svc.iface] = svc
+	}
+
+	links := r.links.Links()
+	names := make(map[int]string, len(links))
+
+	for _, l := range links {
+		names[l.Index] = l.Name
+	}
+
+	ifaces := make(map[int]serviceVLAN)
+
+	for _, l := range links {
+		if svc, ok := byName[l.Name]; ok {
+			ifaces[l.Index] = serviceVLAN{svc: svc}
+
+			continue
+		}
+
+		if !l.VLAN() {
+			continue
+		}
+
+		if svc, ok := byName[names[l.ParentIndex]]; ok {
+			vid := l.VID
+			ifaces[l.Index] = serviceVLAN{svc: svc, vid: &vid}
+		}
+	}
+
+	return ifaces
+}
+
+// Reconcile reads the kernel neighbor cache, merges it into the bindings of
+// the Services and returns a report for each of them, in the order they
+// were given
+func (r *Reconciler) Reconcile() ([]ReconcileReport, error) {
+	neighbors, err := r.neighbors()
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces := r.interfaces()
+	entries := make(map[*Service][]kernelEntry, len(r.services))
+
+	for _, n := range neighbors {
+		sv, ok := ifaces[n.Index]
+		if !ok || n.IP.IsMulticast() || n.IP.IsUnspecified() {
+			continue
+		}
+
+		entries[sv.svc] = append(entries[sv.svc], kernelEntry{
+			vid:   sv.vid,
+			ip:    n.IP,
+			mac:   n.HardwareAddr,
+			state: n.State,
+		})
+	}
+
+	reports := make([]ReconcileReport, 0, len(r.services))
+
+	for _, svc := range r.services {
+		reports = append(reports, svc.reconcile(entries[svc]))
+	}
+
+	return reports, nil
+}
+
+// Run reconciles at once and then at every interval until ctx is done,
+// failures to read the kernel cache are logged and retried next time
+func (r *Reconciler) Run(ctx context.Context) error {
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		reports, err := r.Reconcile()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to reconcile the kernel neighbor cache")
+		}
+
+		for _, rep := range reports {
+			if r.reports != nil && !rep.Empty() {
+				r.reports(rep)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/netif"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+var reconcileLinks = staticLinks{
+	{Name: "eth0", Index: 2},
+	{Name: "eth1", Index: 3},
+	{Name: "eth0.100", Index: 4, Kind: "vlan", ParentIndex: 2, VID: 100},
+}
+
+func observe(s *Service, ip, mac string, vid *uint16) []Result {
+	pkt := testARPPacket()
+	pkt.SendIPAddr = netip.MustParseAddr(ip)
+	pkt.SendHwAddr = mustParseMAC(mac)
+
+	return s.updateBindings(pkt, vid, time.Unix(1700000000, 0))
+}
+
+func neighbor(index int, ip, mac string, state netif.NeighState) netif.Neighbor {
+	n := netif.Neighbor{IP: netip.MustParseAddr(ip), Index: index, State: state}
+	if mac != "" {
+		n.HardwareAddr = mustParseMAC(mac)
+	}
+
+	return n
+}
+
+func staticNeighbors(neighbors ...netif.Neighbor) ReconcilerOption {
+	return WithNeighborSource(func() ([]netif.Neighbor, error) {
+		return neighbors, nil
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	clock := clocktest.NewFake(time.Unix(1700000100, 0))
+	eth0 := NewService("eth0", WithClock(clock))
+	eth1 := NewService("eth1", WithClock(clock))
+
+	observe(eth0, "10.0.0.1", "00:16:3e:00:00:01", nil)
+	observe(eth0, "10.0.0.2", "00:16:3e:00:00:02", nil)
+	observe(eth0, "10.0.0.3", "00:16:3e:00:00:03", nil)
+	observe(eth0, "10.0.0.4", "00:16:3e:00:00:04", uint16Pointer(100))
+
+	r := NewReconciler(reconcileLinks, []*Service{eth0, eth1}, staticNeighbors(
+		neighbor(2, "10.0.0.1", "00:16:3e:00:00:01", netif.NeighReachable),
+		neighbor(2, "10.0.0.2", "00:16:3e:00:00:22", netif.NeighStale),
+		neighbor(2, "10.0.0.3", "", netif.NeighFailed),
+		neighbor(2, "10.0.0.5", "00:16:3e:00:00:05", netif.NeighStale),
+		neighbor(2, "fe80::5", "00:16:3e:00:00:05", netif.NeighDelay),
+		neighbor(2, "224.0.0.251", "01:00:5e:00:00:fb", netif.NeighNoARP),
+		neighbor(4, "10.0.0.6", "00:16:3e:00:00:06", netif.NeighReachable),
+		neighbor(3, "10.0.1.1", "00:16:3e:00:01:01", netif.NeighPermanent),
+		neighbor(9, "10.0.9.1", "00:16:3e:00:09:01", netif.NeighReachable),
+	))
+
+	reports, err := r.Reconcile()
+	require.NoError(t, err)
+
+	assert.Equal(t, []ReconcileReport{
+		{
+			Interface: "eth0",
+			KernelOnly: []ReconcileEntry{
+				{IP: "10.0.0.5", KernelMAC: "00:16:3e:00:00:05", State: "stale"},
+				{VID: uint16Pointer(100), IP: "10.0.0.6", KernelMAC: "00:16:3e:00:00:06", State: "reachable"},
+				{IP: "fe80::5", KernelMAC: "00:16:3e:00:00:05", State: "delay"},
+			},
+			ObservedOnly: []ReconcileEntry{
+				{IP: "10.0.0.3", MAC: "00:16:3e:00:00:03", State: "failed"},
+				// the kernel entry is on eth0, not on its VLAN
+				{VID: uint16Pointer(100), IP: "10.0.0.4", MAC: "00:16:3e:00:00:04"},
+			},
+			Mismatched: []ReconcileEntry{
+				{IP: "10.0.0.2", MAC: "00:16:3e:00:00:02", KernelMAC: "00:16:3e:00:00:22", State: "stale"},
+			},
+			Time: 1700000100,
+		},
+		{
+			Interface: "eth1",
+			KernelOnly: []ReconcileEntry{
+				{IP: "10.0.1.1", KernelMAC: "00:16:3e:00:01:01", State: "permanent"},
+			},
+			Time: 1700000100,
+		},
+	}, reports)
+
+	// observed bindings are kept as they are, the kernel ones merged
+	assert.Equal(t, []SnapshotBinding{
+		{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Observation: "arp_request", Score: 0.69,
+			Time: 1700000000},
+		{IP: "10.0.0.2", MAC: "00:16:3e:00:00:02", Observation: "arp_request", Score: 0.69,
+			Time: 1700000000},
+		{IP: "10.0.0.3", MAC: "00:16:3e:00:00:03", Observation: "arp_request", Score: 0.69,
+			Time: 1700000000},
+		{VID: uint16Pointer(100), IP: "10.0.0.4", MAC: "00:16:3e:00:00:04", Observation: "arp_request", Score: 0.69,
+			Time: 1700000000},
+		{IP: "10.0.0.5", MAC: "00:16:3e:00:00:05", Source: "kernel", Confidence: "medium",
+			Observation: "kernel", Score: 0.2, Time: 1700000100},
+		{VID: uint16Pointer(100), IP: "10.0.0.6", MAC: "00:16:3e:00:00:06", Source: "kernel", Confidence: "high",
+			Observation: "kernel", Score: 0.4, Time: 1700000100},
+		{IP: "fe80::5", MAC: "00:16:3e:00:00:05", Source: "kernel", Confidence: "low",
+			Observation: "kernel", Score: 0.1, Time: 1700000100},
+	}, eth0.Snapshot().Bindings)
+}
+
+func TestReconcileForgotten(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0")
+	neighbors := []netif.Neighbor{
+		neighbor(2, "10.0.0.5", "00:16:3e:00:00:05", netif.NeighReachable),
+		neighbor(2, "10.0.0.6", "00:16:3e:00:00:06", netif.NeighReachable),
+	}
+
+	r := NewReconciler(reconcileLinks, []*Service{svc}, WithNeighborSource(func() ([]netif.Neighbor, error) {
+		return neighbors, nil
+	}))
+
+	_, err := r.Reconcile()
+	require.NoError(t, err)
+	assert.Len(t, svc.Snapshot().Bindings, 2)
+
+	// the kernel forgets one entry and fails to resolve the other
+	neighbors = []netif.Neighbor{neighbor(2, "10.0.0.6", "", netif.NeighFailed)}
+
+	reports, err := r.Reconcile()
+	require.NoError(t, err)
+	assert.True(t, reports[0].Empty())
+	assert.Empty(t, svc.Snapshot().Bindings)
+}
+
+func TestReconcileKeepsConflicts(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0")
+	r := NewReconciler(reconcileLinks, []*Service{svc}, staticNeighbors(
+		neighbor(2, "10.0.0.5", "00:16:3e:00:00:05", netif.NeighReachable),
+		neighbor(2, "10.0.0.6", "00:16:3e:00:00:06", netif.NeighReachable),
+	))
+
+	_, err := r.Reconcile()
+	require.NoError(t, err)
+
+	// the first observation of a kernel binding is still new
+	res := observe(svc, "10.0.0.5", "00:16:3e:00:00:05", nil)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+
+	// and another MAC on the wire is a move from the kernel one
+	res = observe(svc, "10.0.0.6", "00:16:3e:00:00:66", nil)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventMoved, res[0].Event)
+	assert.Equal(t, "00:16:3e:00:00:06", res[0].PreviousMAC)
+
+	// now observed, the kernel no longer overrides them
+	reports, err := r.Reconcile()
+	require.NoError(t, err)
+	assert.Equal(t, []ReconcileEntry{
+		{IP: "10.0.0.6", MAC: "00:16:3e:00:00:66", KernelMAC: "00:16:3e:00:00:06", State: "reachable"},
+	}, reports[0].Mismatched)
+	assert.Empty(t, reports[0].KernelOnly)
+
+	for _, b := range svc.Snapshot().Bindings {
+		assert.Empty(t, b.Source, b.IP)
+	}
+}
+
+func TestReconcilerRun(t *testing.T) {
+	defer leak.Check(t)()
+
+	clock := clocktest.NewFake(time.Unix(1700000000, 0))
+	svc := NewService("eth0", WithClock(clock))
+	observe(svc, "10.0.0.1", "00:16:3e:00:00:01", nil)
+
+	var calls atomic.Int32
+
+	reportC := make(chan ReconcileReport, 1)
+	r := NewReconciler(reconcileLinks, []*Service{svc},
+		WithReconcilerClock(clock),
+		WithReconcileInterval(time.Minute),
+		WithReconcileReports(func(rep ReconcileReport) { reportC <- rep }),
+		WithNeighborSource(func() ([]netif.Neighbor, error) {
+			if calls.Add(1) == 1 {
+				return nil, errors.New("netlink is busy")
+			}
+
+			return nil, nil
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- r.Run(ctx) }()
+
+	// the first attempt fails and is retried at the next interval
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+
+	rep := <-reportC
+	assert.Equal(t, "eth0", rep.Interface)
+	assert.Len(t, rep.ObservedOnly, 1)
+	assert.Equal(t, int32(2), calls.Load())
+
+	cancel()
+	assert.NoError(t, <-errC)
+}
@@ -0,0 +1,347 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+const (
+	// defaultReorderHorizon covers the goroutine scheduling and the
+	// batching of the captures, which reorder the frames of different
+	// interfaces by tens of milliseconds
+	defaultReorderHorizon = 100 * time.Millisecond
+	// defaultReorderedObservations holds the horizon at 40k frames/s
+	defaultReorderedObservations = 4096
+)
+
+// MixedTimestamps is what a Reorderer does once it is fed hardware
+// timestamps along with timestamps of the system clock. The clock of a NIC
+// isn't the system's, nor that of another NIC: it may run on TAI, or not be
+// synchronized at all. A Reorderer always shifts the hardware timestamps of
+// each interface onto the system clock, by the smallest lag seen between
+// them and the arrival of their frames, which is as accurate as the
+// capture is steady.
+type MixedTimestamps uint8
+
+const (
+	// MixedTimestampsNormalize orders the shifted hardware timestamps with
+	// those of the system clock
+	MixedTimestampsNormalize MixedTimestamps = iota
+	// MixedTimestampsBypass stops reordering once they are mixed: the
+	// observations held are released, and the next ones correlated as they
+	// arrive, timestamped with their arrival
+	MixedTimestampsBypass
+)
+
+func (m MixedTimestamps) String() string {
+	switch m {
+	case MixedTimestampsNormalize:
+		return "normalize"
+	case MixedTimestampsBypass:
+		return "bypass"
+	default:
+		return fmt.Sprintf("MixedTimestamps(%d)", uint8(m))
+	}
+}
+
+// ReorderStats are the counters of a Reorderer
+type ReorderStats struct {
+	// Buffered is the number of observations waiting for the horizon
+	Buffered int
+	// Released is the number of observations correlated in order
+	Released uint64
+	// Late is the number of observations which arrived after one
+	// timestamped later was released, they aren't correlated
+	Late uint64
+	// Overflows is the number of observations released before the horizon
+	// passed, the buffer being full
+	Overflows uint64
+	// Bypassed is true once hardware and system clock timestamps were
+	// mixed with MixedTimestampsBypass, the observations aren't reordered
+	// anymore
+	Bypassed bool
+}
+
+// correlation is an observation held by a Reorderer, correlate hands it to
+// the detectors at the time it is ordered by
+type correlation struct {
+	time      time.Time
+	arrival   time.Time
+	correlate func(timestamp time.Time) []Result
+	seq       uint64
+}
+
+type correlationHeap []*correlation
+
+func (h correlationHeap) Len() int { return len(h) }
+
+func (h correlationHeap) Less(i, j int) bool {
+	if h[i].time.Equal(h[j].time) {
+		return h[i].seq < h[j].seq
+	}
+
+	return h[i].time.Before(h[j].time)
+}
+
+func (h correlationHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *correlationHeap) Push(x any) {
+	*h = append(*h, x.(*correlation)) //nolint:forcetypeassert // only correlations are pushed
+}
+
+func (h *correlationHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return c
+}
+
+// Reorderer sorts the observations the Services of several interfaces feed
+// the cross-interface detectors, the DuplicateMACDetector and the
+// DADDetector, by their capture timestamp. The goroutines of the captures
+// and their batches deliver the frames of different interfaces tens of
+// milliseconds out of order, which the detectors would take for the order
+// the hosts sent them in.
+//
+// An observation is held until its timestamp is a horizon behind the
+// latest one seen, or until it waited a horizon, and the observations are
+// released in order. One arriving after an observation timestamped later
+// was released is late: it is counted, not correlated. A full buffer
+// releases its oldest observation early.
+//
+// The Results of the observations released are returned by the Service
+// whose frame released them, they name the interfaces they are about.
+type Reorderer struct {
+	clock    clock.Clock
+	offsets  map[string]time.Duration
+	pending  correlationHeap
+	latest   time.Time
+	released time.Time
+	horizon  time.Duration
+	size     int
+	seq      uint64
+	stats    ReorderStats
+	mixed    MixedTimestamps
+	// hardware and system are set once a timestamp of the kind was seen
+	hardware bool
+	system   bool
+	mu       sync.Mutex
+}
+
+// ReordererOption configures a Reorderer
+type ReordererOption func(*Reorderer)
+
+// WithReorderHorizon sets how long the observations are held for the late
+// ones to be ordered before them
+func WithReorderHorizon(d time.Duration) ReordererOption {
+	return func(r *Reorderer) {
+		if d > 0 {
+			r.horizon = d
+		}
+	}
+}
+
+// WithReorderLimits bounds the observations held to the
+// ReorderedObservations of l
+func WithReorderLimits(l Limits) ReordererOption {
+	return func(r *Reorderer) {
+		if l.ReorderedObservations > 0 {
+			r.size = l.ReorderedObservations
+		}
+	}
+}
+
+// WithMixedTimestamps sets what is done once hardware timestamps are mixed
+// with those of the system clock, MixedTimestampsNormalize by default
+func WithMixedTimestamps(m MixedTimestamps) ReordererOption {
+	return func(r *Reorderer) {
+		r.mixed = m
+	}
+}
+
+// WithReorderClock sets the clock the arrival of the observations is
+// timed with
+func WithReorderClock(c clock.Clock) ReordererOption {
+	return func(r *Reorderer) {
+		r.clock = c
+	}
+}
+
+// NewReorderer returns a Reorderer, to be shared by the Services of the
+// interfaces it orders the observations of
+func NewReorderer(options ...ReordererOption) *Reorderer {
+	r := &Reorderer{
+		clock:   clock.System{},
+		offsets: make(map[string]time.Duration),
+		horizon: defaultReorderHorizon,
+		size:    defaultReorderedObservations,
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	return r
+}
+
+// Push holds an observation of iface timestamped by md, and returns the
+// Results of the observations it releases, in order. correlate is called
+// once released, with the timestamp the observation is ordered by.
+func (r *Reorderer) Push(iface string, md capture.Metadata, correlate func(timestamp time.Time) []Result) []Result {
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ts, ok := r.normalize(iface, md, now)
+	if !ok {
+		// the observations held when the timestamps got mixed are
+		// correlated first
+		var res []Result
+
+		if !r.stats.Bypassed {
+			r.stats.Bypassed = true
+			res = r.flush()
+		}
+
+		r.stats.Released++
+
+		return append(res, correlate(ts)...)
+	}
+
+	if !r.released.IsZero() && ts.Before(r.released) {
+		r.stats.Late++
+		return nil
+	}
+
+	r.seq++
+	heap.Push(&r.pending, &correlation{time: ts, arrival: now, correlate: correlate, seq: r.seq})
+
+	if ts.After(r.latest) {
+		r.latest = ts
+	}
+
+	return r.release(now)
+}
+
+// Release returns the Results of the observations whose horizon passed, for
+// those not to wait for the next Push once the interfaces fall silent
+func (r *Reorderer) Release() []Result {
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.release(now)
+}
+
+// Flush returns the Results of every observation held, in order
+func (r *Reorderer) Flush() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.flush()
+}
+
+func (r *Reorderer) flush() []Result {
+	var res []Result
+
+	for r.pending.Len() > 0 {
+		res = append(res, r.pop()...)
+	}
+
+	return res
+}
+
+// Stats returns the counters of the Reorderer
+func (r *Reorderer) Stats() ReorderStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st := r.stats
+	st.Buffered = r.pending.Len()
+
+	return st
+}
+
+// normalize returns the timestamp md is ordered by, and false when the
+// observation bypasses the buffer
+func (r *Reorderer) normalize(iface string, md capture.Metadata, now time.Time) (time.Time, bool) {
+	ts := md.Timestamp
+	if ts.IsZero() {
+		ts = now
+	}
+
+	if md.TimestampSource != capture.TimestampHardware {
+		r.system = true
+	} else {
+		r.hardware = true
+
+		lag := now.Sub(ts)
+		if offset, ok := r.offsets[iface]; !ok || lag < offset {
+			r.offsets[iface] = lag
+		}
+
+		ts = ts.Add(r.offsets[iface])
+	}
+
+	if r.hardware && r.system && r.mixed == MixedTimestampsBypass {
+		return now, false
+	}
+
+	return ts, true
+}
+
+// release pops the observations a horizon behind the latest one, or which
+// waited a horizon, and those above the size
+func (r *Reorderer) release(now time.Time) []Result {
+	var res []Result
+
+	for r.pending.Len() > 0 {
+		head := r.pending[0]
+
+		switch {
+		case r.pending.Len() > r.size:
+			r.stats.Overflows++
+		case !head.time.After(r.latest.Add(-r.horizon)), !head.arrival.After(now.Add(-r.horizon)):
+		default:
+			return res
+		}
+
+		res = append(res, r.pop()...)
+	}
+
+	return res
+}
+
+// pop correlates the oldest observation held
+func (r *Reorderer) pop() []Result {
+	c := heap.Pop(&r.pending).(*correlation) //nolint:forcetypeassert // only correlations are pushed
+
+	r.released = c.time
+	r.stats.Released++
+
+	return c.correlate(c.time)
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+// feed pushes synthetic observations to a Reorderer, each correlated into a
+// Result naming it and carrying the time it was ordered by
+type feed struct {
+	r   *Reorderer
+	clk *clocktest.Fake
+}
+
+func newFeed(options ...ReordererOption) *feed {
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+
+	return &feed{r: NewReorderer(append([]ReordererOption{WithReorderClock(clk)}, options...)...), clk: clk}
+}
+
+// push pushes the observation name of iface, captured offset from now
+func (f *feed) push(name, iface string, offset time.Duration, source capture.TimestampSource) []string {
+	md := capture.Metadata{Timestamp: f.clk.Now().Add(offset), TimestampSource: source}
+
+	return names(f.r.Push(iface, md, func(timestamp time.Time) []Result {
+		return []Result{{IP: name, Time: timestamp.UnixMilli()}}
+	}))
+}
+
+func names(res []Result) []string {
+	var n []string
+
+	for _, r := range res {
+		n = append(n, r.IP)
+	}
+
+	return n
+}
+
+func TestMixedTimestampsString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "normalize", MixedTimestampsNormalize.String())
+	assert.Equal(t, "bypass", MixedTimestampsBypass.String())
+	assert.Equal(t, "MixedTimestamps(7)", MixedTimestamps(7).String())
+}
+
+func TestReordererOrder(t *testing.T) {
+	t.Parallel()
+
+	f := newFeed()
+
+	// eth1 delivers its frames 30ms late
+	assert.Empty(t, f.push("a", "eth0", 0, capture.TimestampSoftware))
+	assert.Empty(t, f.push("b", "eth1", -30*time.Millisecond, capture.TimestampSoftware))
+	assert.Empty(t, f.push("c", "eth0", 50*time.Millisecond, capture.TimestampSoftware))
+
+	// a frame a horizon past the first ones releases them, in order
+	assert.Equal(t, []string{"b", "a"}, f.push("d", "eth1", 100*time.Millisecond, capture.TimestampSoftware))
+
+	// one captured before those released is late
+	assert.Empty(t, f.push("e", "eth1", -10*time.Millisecond, capture.TimestampSoftware))
+
+	assert.Equal(t, ReorderStats{Buffered: 2, Released: 2, Late: 1}, f.r.Stats())
+	assert.Equal(t, []string{"c", "d"}, names(f.r.Flush()))
+}
+
+func TestReordererRelease(t *testing.T) {
+	t.Parallel()
+
+	f := newFeed(WithReorderHorizon(time.Second))
+
+	assert.Empty(t, f.push("a", "eth0", 0, capture.TimestampSoftware))
+	assert.Empty(t, f.push("b", "eth1", -time.Millisecond, capture.TimestampSoftware))
+	assert.Empty(t, f.r.Release())
+
+	// the interfaces fell silent, the observations waited the horizon
+	f.clk.Advance(time.Second)
+	assert.Equal(t, []string{"b", "a"}, names(f.r.Release()))
+	assert.Equal(t, ReorderStats{Released: 2}, f.r.Stats())
+}
+
+func TestReordererOverflow(t *testing.T) {
+	t.Parallel()
+
+	f := newFeed(WithReorderLimits(Limits{ReorderedObservations: 2}))
+
+	assert.Empty(t, f.push("a", "eth0", 0, capture.TimestampSoftware))
+	assert.Empty(t, f.push("b", "eth1", time.Millisecond, capture.TimestampSoftware))
+	assert.Equal(t, []string{"a"}, f.push("c", "eth0", 2*time.Millisecond, capture.TimestampSoftware))
+
+	assert.Equal(t, ReorderStats{Buffered: 2, Released: 1, Overflows: 1}, f.r.Stats())
+}
+
+func TestReordererMixedTimestamps(t *testing.T) {
+	t.Parallel()
+
+	// the NIC of eth1 timestamps on TAI, 37s ahead of the system clock
+	const tai = 37 * time.Second
+
+	t.Run("normalize", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFeed()
+
+		assert.Empty(t, f.push("a", "eth1", tai, capture.TimestampHardware))
+		assert.Empty(t, f.push("b", "eth0", -20*time.Millisecond, capture.TimestampSoftware))
+
+		// the second frame of eth1 was captured 70ms before it arrived,
+		// before the others
+		f.clk.Advance(40 * time.Millisecond)
+		assert.Empty(t, f.push("c", "eth1", tai-70*time.Millisecond, capture.TimestampHardware))
+
+		res := f.r.Flush()
+		assert.Equal(t, []string{"c", "b", "a"}, names(res))
+		assert.Equal(t, f.clk.Now().Add(-40*time.Millisecond).UnixMilli(), res[2].Time)
+		assert.False(t, f.r.Stats().Bypassed)
+	})
+
+	t.Run("bypass", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFeed(WithMixedTimestamps(MixedTimestampsBypass))
+
+		// the timestamps of a single NIC are reordered
+		assert.Empty(t, f.push("a", "eth1", tai, capture.TimestampHardware))
+		assert.Empty(t, f.push("b", "eth1", tai-time.Millisecond, capture.TimestampHardware))
+
+		// until mixed with those of the system clock
+		assert.Equal(t, []string{"b", "a", "c"}, f.push("c", "eth0", -20*time.Millisecond, capture.TimestampSoftware))
+		assert.Equal(t, []string{"d"}, f.push("d", "eth1", tai, capture.TimestampHardware))
+
+		assert.Equal(t, ReorderStats{Released: 4, Bypassed: true}, f.r.Stats())
+	})
+}
+
+func TestServiceReorderer(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	duplicates := NewDuplicateMACDetector()
+	r := NewReorderer(WithReorderClock(clk))
+
+	eth0 := NewService("eth0", WithDuplicateMACDetector(duplicates), WithReorderer(r))
+	eth1 := NewService("eth1", WithDuplicateMACDetector(duplicates), WithReorderer(r))
+
+	frame := buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Padded().
+		ARPRequest(netip.MustParseAddr("10.0.0.10"), netip.MustParseAddr("10.0.0.1")), nil)
+
+	// eth1 delivers the frame it captured last first, it is held
+	res, err := eth1.handleFrame(frame, capture.Metadata{Timestamp: clk.Now().Add(2 * time.Second)})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+
+	// and the observation of eth0, a horizon before it, is released
+	res, err = eth0.handleFrame(frame, capture.Metadata{Timestamp: clk.Now()})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+
+	// the duplicate has the latest sighting last, as captured
+	res = r.Flush()
+	require.Len(t, res, 1)
+	assert.Equal(t, EventDuplicateMACLocation, res[0].Event)
+	assert.Equal(t, "eth0", res[0].Duplicate.Locations[0].Interface)
+	assert.Equal(t, "eth1", res[0].Duplicate.Locations[1].Interface)
+}
+
+func TestServiceReordererMalformedARP(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	duplicates := NewDuplicateMACDetector()
+	r := NewReorderer(WithReorderClock(clk))
+
+	eth0 := NewService("eth0", WithDuplicateMACDetector(duplicates), WithReorderer(r))
+	eth1 := NewService("eth1", WithDuplicateMACDetector(duplicates), WithReorderer(r))
+
+	frame := buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Padded().
+		ARPRequest(netip.MustParseAddr("10.0.0.10"), netip.MustParseAddr("10.0.0.1")), nil)
+
+	// the sighting on eth1 makes a duplicate, both are held
+	_, err := eth0.handleFrame(frame, capture.Metadata{Timestamp: clk.Now()})
+	require.NoError(t, err)
+
+	_, err = eth1.handleFrame(frame, capture.Metadata{Timestamp: clk.Now().Add(time.Millisecond)})
+	require.NoError(t, err)
+
+	// a malformed ARP frame a horizon later releases them
+	malformed := []byte{
+		0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16, 0x08, 0x06, 0x00, 0x01,
+		0x08, 0x00, 0x06, 0x04, 0x00, 0x02, 0x80, 0xfc, 0x16, 0xc0, 0xa8, 0x01, 0x6c,
+		0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0xc0, 0xa8, 0x01, 0x50,
+	}
+
+	var recording bytes.Buffer
+
+	w, err := capture.NewPcapWriter(&recording, 65535)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteFrame(malformed, capture.Metadata{Timestamp: clk.Now().Add(time.Second),
+		Length: len(malformed)}))
+
+	conn, err := capture.NewPcapReader(bytes.NewReader(recording.Bytes()), "eth0")
+	require.NoError(t, err)
+
+	resultC := make(chan Result, 1)
+	require.NoError(t, eth0.run(context.Background(), conn, resultC))
+
+	// the frame is counted malformed, the duplicate it released delivered
+	assert.Equal(t, uint64(1), eth0.malformed.Load())
+	require.Len(t, resultC, 1)
+
+	res := <-resultC
+	assert.Equal(t, EventDuplicateMACLocation, res.Event)
+	assert.Equal(t, testPXEClient.String(), res.MAC)
+}
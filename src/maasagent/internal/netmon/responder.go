@@ -0,0 +1,648 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/ndp"
+)
+
+const (
+	// defaultResponderProbes and defaultResponderProbeWait are the
+	// PROBE_NUM of RFC 5227, and its PROBE_MAX between the probes
+	defaultResponderProbes    = 3
+	defaultResponderProbeWait = time.Second
+	// responderSentWindow is how long a frame written by a Responder is
+	// recognized in a capture which doesn't tell the direction of the
+	// frames
+	responderSentWindow = 2 * time.Second
+	// maxResponderSent bounds the frames remembered for that
+	maxResponderSent = 1024
+)
+
+var (
+	// ErrInvalidMapping is returned for a Mapping without a unicast
+	// address or an ethernet MAC
+	ErrInvalidMapping = errors.New("invalid responder mapping")
+	// ErrMappingConflict is returned by Responder.Activate when another
+	// host answered for the address of the Mapping
+	ErrMappingConflict = errors.New("address claimed by another host")
+	// ErrUnknownMapping is returned by Responder.Activate for a Mapping
+	// removed while it was probed
+	ErrUnknownMapping = errors.New("unknown responder mapping")
+)
+
+// MappingState is the state of a Mapping of a Responder
+type MappingState uint8
+
+const (
+	// MappingPending is the state of a Mapping whose address is probed,
+	// it isn't answered for yet
+	MappingPending MappingState = iota + 1
+	// MappingActive is the state of a Mapping answered for
+	MappingActive
+	// MappingSuspended is the state of an active Mapping no longer
+	// answered for, since another host claimed its address
+	MappingSuspended
+	// MappingFailed is the state of a Mapping whose probes another host
+	// answered, or which probing was stopped
+	MappingFailed
+)
+
+func (s MappingState) String() string {
+	switch s {
+	case MappingPending:
+		return "pending"
+	case MappingActive:
+		return "active"
+	case MappingSuspended:
+		return "suspended"
+	case MappingFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("MappingState(%d)", uint8(s))
+	}
+}
+
+// MarshalText encodes the MappingState as its String
+func (s MappingState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a MappingState from its String
+func (s *MappingState) UnmarshalText(text []byte) error {
+	for state := MappingPending; state <= MappingFailed; state++ {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown mapping state %q", text)
+}
+
+// Mapping is an address a Responder answers the ARP requests or the
+// neighbor solicitations for, with MAC
+type Mapping struct {
+	IP  netip.Addr
+	MAC net.HardwareAddr
+	// VID is the VLAN the requests are answered on, nil for the untagged
+	// frames
+	VID *uint16
+}
+
+// MappingStatus is the state of a Mapping of a Responder
+type MappingStatus struct {
+	VID *uint16 `json:"vid"`
+	IP  string  `json:"ip"`
+	MAC string  `json:"mac"`
+	// ClaimedBy is the MAC of the host which answered for the address of a
+	// failed or suspended Mapping
+	ClaimedBy string       `json:"claimed_by,omitempty"`
+	State     MappingState `json:"state"`
+	// Since is the time the Mapping entered its State
+	Since int64 `json:"since"`
+}
+
+type mapping struct {
+	since     time.Time
+	claimedBy net.HardwareAddr
+	Mapping
+	state MappingState
+}
+
+func (m *mapping) status() MappingStatus {
+	st := MappingStatus{
+		VID:   m.VID,
+		IP:    m.IP.String(),
+		MAC:   m.MAC.String(),
+		State: m.state,
+		Since: m.since.Unix(),
+	}
+
+	if m.claimedBy != nil {
+		st.ClaimedBy = m.claimedBy.String()
+	}
+
+	return st
+}
+
+// sentKey identifies a frame written by a Responder by the address it
+// claims or probes for, and the MAC it does so with
+type sentKey struct {
+	ip  netip.Addr
+	mac [6]byte
+	vid uint16
+}
+
+// neighborMessage is what a Responder reads of an ARP packet or a Neighbor
+// Discovery message
+type neighborMessage struct {
+	// addr is the address the sender claims, or probes for
+	addr netip.Addr
+	// src and target are the address of the sender and the one it
+	// resolves, target is invalid unless the message is a request or a
+	// solicitation
+	src    netip.Addr
+	target netip.Addr
+	mac    net.HardwareAddr
+	probe  bool
+	ndp    bool
+}
+
+// Responder answers the ARP requests and the neighbor solicitations for
+// the addresses of its Mappings, as a proxy would, without poisoning the
+// segment. Before a Mapping is answered for, its address is probed as RFC
+// 5227 and RFC 4862 do, and a host other than the MAC of the Mapping
+// answering fails it. Once active, a Mapping is suspended as soon as
+// another host is seen claiming its address, with an
+// EventResponderSuspended.
+//
+// The frames the Responder writes are its own: a Service given it with
+// WithResponder doesn't learn bindings from them, nor sees a conflict in
+// them. They are told apart by their direction, or by what the Responder
+// wrote recently when the capture doesn't tell it.
+type Responder struct {
+	clock    clock.Clock
+	limiter  *ProbeLimiter
+	w        capture.FrameWriter
+	mappings map[bindingKey]*mapping
+	sent     map[sentKey]time.Time
+	src      net.HardwareAddr
+	wait     time.Duration
+	probes   int
+	mu       sync.Mutex
+}
+
+// ResponderOption configures a Responder
+type ResponderOption func(*Responder)
+
+// WithResponderProbes sets the number of probes of an address before its
+// Mapping is activated, and the time waited after each
+func WithResponderProbes(n int, wait time.Duration) ResponderOption {
+	return func(r *Responder) {
+		if n > 0 {
+			r.probes = n
+		}
+
+		if wait > 0 {
+			r.wait = wait
+		}
+	}
+}
+
+// WithResponderLimiter sets the limiter the probes wait for, so the probing
+// of several interfaces is bounded together
+func WithResponderLimiter(l *ProbeLimiter) ResponderOption {
+	return func(r *Responder) {
+		r.limiter = l
+	}
+}
+
+// WithResponderClock sets the clock timing the probes and timestamping the
+// frames observed without a timestamp
+func WithResponderClock(c clock.Clock) ResponderOption {
+	return func(r *Responder) {
+		r.clock = c
+	}
+}
+
+// NewResponder returns a Responder writing to w, the probes are sent from
+// src. The answers are sent from the MACs of the Mappings for addresses
+// the host may not have, w is to be guarded accordingly.
+func NewResponder(src net.HardwareAddr, w capture.FrameWriter, options ...ResponderOption) (*Responder, error) {
+	if len(src) != 6 {
+		return nil, fmt.Errorf("%w: source MAC %q", ethernet.ErrBuildFrame, src)
+	}
+
+	r := &Responder{
+		clock:    clock.System{},
+		w:        w,
+		mappings: make(map[bindingKey]*mapping),
+		sent:     make(map[sentKey]time.Time),
+		src:      slices.Clone(src),
+		probes:   defaultResponderProbes,
+		wait:     defaultResponderProbeWait,
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	if r.limiter == nil {
+		r.limiter = NewProbeLimiter(defaultProbeRate, defaultProbeBurst, WithProbeLimiterClock(r.clock))
+	}
+
+	return r, nil
+}
+
+func mappingKey(ip netip.Addr, vid *uint16) bindingKey {
+	key := bindingKey{ip: ip}
+	if vid != nil {
+		key.vid = *vid
+	}
+
+	return key
+}
+
+// Activate probes the address of m, and answers for it once no host but
+// the MAC of m answered the probes. It replaces the Mapping of the same
+// address and VLAN, a suspended or failed one is activated again that way.
+// It returns an error matching ErrMappingConflict, naming the MAC which
+// answered, when the Mapping failed, and the error of ctx when stopped
+// while probing, which fails the Mapping too.
+func (r *Responder) Activate(ctx context.Context, m Mapping) error {
+	if !m.IP.IsValid() || m.IP.IsUnspecified() || m.IP.IsMulticast() || m.IP.Is4In6() || len(m.MAC) != 6 {
+		return fmt.Errorf("%w: %s at %q", ErrInvalidMapping, m.IP, m.MAC)
+	}
+
+	m.MAC = slices.Clone(m.MAC)
+	if m.VID != nil {
+		vid := *m.VID
+		m.VID = &vid
+	}
+
+	probe, err := r.probe(m)
+	if err != nil {
+		return err
+	}
+
+	key := mappingKey(m.IP, m.VID)
+	entry := &mapping{Mapping: m, state: MappingPending, since: r.clock.Now()}
+
+	r.mu.Lock()
+	r.mappings[key] = entry
+	r.mu.Unlock()
+
+	for range r.probes {
+		if r.claimed(entry) {
+			break
+		}
+
+		err = r.limiter.Wait(ctx)
+		if err == nil {
+			r.remember(sentKey{ip: m.IP, mac: [6]byte(r.src), vid: key.vid})
+			err = r.w.WriteFrame(probe)
+		}
+
+		if err == nil {
+			err = r.clock.Sleep(ctx, r.wait)
+		}
+
+		if err != nil {
+			r.fail(key, entry)
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mappings[key] != entry {
+		return fmt.Errorf("%w: %s removed while probed", ErrUnknownMapping, m.IP)
+	}
+
+	entry.since = r.clock.Now()
+
+	if entry.claimedBy != nil {
+		entry.state = MappingFailed
+
+		return fmt.Errorf("%w: %s is answered for by %s, not %s", ErrMappingConflict, m.IP, entry.claimedBy, m.MAC)
+	}
+
+	entry.state = MappingActive
+
+	return nil
+}
+
+// probe returns the frame probing for the address of m, an RFC 5227 ARP
+// probe or a duplicate address detection solicitation
+func (r *Responder) probe(m Mapping) ([]byte, error) {
+	b := ethernet.NewFrame().Src(r.src)
+	if m.VID != nil {
+		b = b.VLAN(*m.VID)
+	}
+
+	if m.IP.Is4() {
+		return b.Padded().ARPRequest(netip.IPv4Unspecified(), m.IP).Build()
+	}
+
+	return b.NeighborSolicitation(netip.IPv6Unspecified(), m.IP).Build()
+}
+
+func (r *Responder) claimed(entry *mapping) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return entry.claimedBy != nil
+}
+
+// fail fails entry if it still is the Mapping of key
+func (r *Responder) fail(key bindingKey, entry *mapping) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mappings[key] == entry {
+		entry.state = MappingFailed
+		entry.since = r.clock.Now()
+	}
+}
+
+// Remove stops answering for ip on vid, and returns false if there was no
+// such Mapping
+func (r *Responder) Remove(ip netip.Addr, vid *uint16) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := mappingKey(ip, vid)
+	_, ok := r.mappings[key]
+	delete(r.mappings, key)
+
+	return ok
+}
+
+// Status returns the state of the Mapping of ip on vid
+func (r *Responder) Status(ip netip.Addr, vid *uint16) (MappingStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.mappings[mappingKey(ip, vid)]
+	if !ok {
+		return MappingStatus{}, false
+	}
+
+	return m.status(), true
+}
+
+// Mappings returns the state of every Mapping, by address and VLAN
+func (r *Responder) Mappings() []MappingStatus {
+	r.mu.Lock()
+
+	keys := make([]bindingKey, 0, len(r.mappings))
+	for key := range r.mappings {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b bindingKey) int {
+		return cmp.Or(a.ip.Compare(b.ip), cmp.Compare(a.vid, b.vid))
+	})
+
+	statuses := make([]MappingStatus, 0, len(keys))
+	for _, key := range keys {
+		statuses = append(statuses, r.mappings[key].status())
+	}
+
+	r.mu.Unlock()
+
+	return statuses
+}
+
+// Observe reads an ARP or Neighbor Discovery frame received on vid. A
+// Mapping whose address another host claims is failed while probed, and
+// suspended once active, which returns an EventResponderSuspended. The
+// requests for the address of an active Mapping are answered.
+//
+// It returns true for a frame the Responder wrote, which is to be ignored:
+// an outbound frame from the MAC of a Mapping, or a probe from their
+// source, or, when the direction is unknown, one claiming what the Responder
+// recently did.
+func (r *Responder) Observe(frame []byte, vid *uint16, md capture.Metadata) ([]Result, bool) {
+	msg, ok := readNeighborMessage(frame)
+	if !ok {
+		return nil, false
+	}
+
+	timestamp := md.Timestamp
+	if timestamp.IsZero() {
+		timestamp = r.clock.Now()
+	}
+
+	key := mappingKey(msg.addr, vid)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.own(msg, key, md.Direction) {
+		return nil, true
+	}
+
+	var res []Result
+
+	if m, ok := r.mappings[key]; ok && r.claims(m, msg) {
+		switch m.state {
+		case MappingPending:
+			m.claimedBy = slices.Clone(msg.mac)
+		case MappingActive:
+			m.state = MappingSuspended
+			m.claimedBy = slices.Clone(msg.mac)
+			m.since = timestamp
+
+			status := m.status()
+
+			log.Warn().Str("ip", status.IP).Str("mac", status.MAC).Str("claimed_by", status.ClaimedBy).
+				Msg("responder mapping suspended, another host answers for its address")
+
+			res = append(res, Result{
+				IP:          status.IP,
+				MAC:         status.ClaimedBy,
+				PreviousMAC: status.MAC,
+				VID:         vid,
+				Time:        timestamp.Unix(),
+				Event:       EventResponderSuspended,
+				Responder:   &status,
+			})
+		}
+	}
+
+	if msg.target.IsValid() {
+		if m, ok := r.mappings[mappingKey(msg.target, vid)]; ok && m.state == MappingActive &&
+			!bytes.Equal(msg.mac, m.MAC) {
+			r.answer(m, msg)
+		}
+	}
+
+	return res, false
+}
+
+// own returns true for a frame the Responder wrote, and forgets it when
+// recognized by what was written
+func (r *Responder) own(msg neighborMessage, key bindingKey, dir capture.Direction) bool {
+	switch dir {
+	case capture.DirectionInbound:
+		return false
+	case capture.DirectionOutbound:
+		if msg.probe && bytes.Equal(msg.mac, r.src) {
+			return true
+		}
+
+		m, ok := r.mappings[key]
+
+		return ok && bytes.Equal(msg.mac, m.MAC)
+	}
+
+	if len(msg.mac) != 6 {
+		return false
+	}
+
+	sent := sentKey{ip: msg.addr, mac: [6]byte(msg.mac), vid: key.vid}
+
+	at, ok := r.sent[sent]
+	if !ok {
+		return false
+	}
+
+	delete(r.sent, sent)
+
+	return r.clock.Now().Sub(at) <= responderSentWindow
+}
+
+// claims returns true when msg is another host than that of m claiming its
+// address. An RFC 5227 probe from the source of the probes is the
+// Responder's, and a probe only claims the address of a Mapping not
+// answered for yet, the active ones are defended by answering it.
+func (r *Responder) claims(m *mapping, msg neighborMessage) bool {
+	if bytes.Equal(msg.mac, m.MAC) {
+		return false
+	}
+
+	if msg.probe {
+		return m.state == MappingPending && !bytes.Equal(msg.mac, r.src)
+	}
+
+	return true
+}
+
+// answer writes the reply of m to the request or solicitation msg
+func (r *Responder) answer(m *mapping, msg neighborMessage) {
+	b := ethernet.NewFrame().Src(m.MAC).Dst(msg.mac)
+	if m.VID != nil {
+		b = b.VLAN(*m.VID)
+	}
+
+	if msg.ndp {
+		// a duplicate address detection probe is answered to all the
+		// nodes, its sender has no address yet
+		dst, flags := msg.src, ethernet.NAFlagSolicited|ethernet.NAFlagOverride
+		if msg.probe {
+			dst, flags = netip.MustParseAddr("ff02::1"), ethernet.NAFlagOverride
+			b = b.Dst(net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1})
+		}
+
+		b = b.NeighborAdvertisement(m.IP, dst, flags)
+	} else {
+		b = b.Padded().ARPReply(m.IP, msg.mac, msg.src)
+	}
+
+	frame, err := b.Build()
+	if err != nil {
+		log.Debug().Err(err).Str("ip", m.IP.String()).Msg("responder failed to build an answer")
+		return
+	}
+
+	r.rememberLocked(sentKey{ip: m.IP, mac: [6]byte(m.MAC), vid: mappingKey(m.IP, m.VID).vid})
+
+	if err := r.w.WriteFrame(frame); err != nil {
+		log.Warn().Err(err).Str("ip", m.IP.String()).Msg("responder failed to answer")
+	}
+}
+
+func (r *Responder) remember(key sentKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rememberLocked(key)
+}
+
+// rememberLocked records a frame about to be written, forgetting those
+// past the window once there are too many
+func (r *Responder) rememberLocked(key sentKey) {
+	now := r.clock.Now()
+
+	if len(r.sent) >= maxResponderSent {
+		for k, at := range r.sent {
+			if now.Sub(at) > responderSentWindow {
+				delete(r.sent, k)
+			}
+		}
+	}
+
+	if len(r.sent) < maxResponderSent {
+		r.sent[key] = now
+	}
+}
+
+// readNeighborMessage reads an ARP packet or a Neighbor Discovery message
+func readNeighborMessage(frame []byte) (neighborMessage, bool) {
+	var eth ethernet.EthernetFrame
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return neighborMessage{}, false
+	}
+
+	if pkt, err := eth.ExtractARPPacket(); err == nil {
+		msg := neighborMessage{
+			addr: pkt.SenderAddr(),
+			src:  pkt.SenderAddr(),
+			mac:  pkt.SendHwAddr,
+		}
+
+		if pkt.OpCode == ethernet.OpRequest {
+			msg.target = pkt.TargetAddr()
+		}
+
+		if msg.addr.IsUnspecified() {
+			msg.addr, msg.probe = pkt.TargetAddr(), true
+		}
+
+		return msg, msg.addr.Is4()
+	}
+
+	nd, pkt, err := ndp.ParseFrame(frame)
+	if err != nil {
+		return neighborMessage{}, false
+	}
+
+	msg := neighborMessage{src: pkt.Src, mac: nd.LinkLayerAddr, ndp: true}
+	if msg.mac == nil {
+		msg.mac = eth.SrcMAC
+	}
+
+	switch {
+	case nd.Type == ndp.TypeNeighborAdvertisement:
+		msg.addr = nd.Target
+	case pkt.Src.IsUnspecified():
+		msg.addr, msg.target, msg.probe = nd.Target, nd.Target, true
+	default:
+		msg.addr, msg.target = pkt.Src, nd.Target
+	}
+
+	return msg, true
+}
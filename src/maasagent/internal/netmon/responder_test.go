@@ -0,0 +1,468 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/ndp"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+var (
+	testProxiedMAC = net.HardwareAddr{0x00, 0x16, 0x3e, 0xbb, 0x00, 0x01}
+	testProxied4   = netip.MustParseAddr("10.0.0.50")
+	testProxied6   = netip.MustParseAddr("2001:db8::50")
+)
+
+// responderWriter records the frames of a Responder, and hands each to
+// written if set
+type responderWriter struct {
+	written func(frame []byte)
+	frames  [][]byte
+	mu      sync.Mutex
+}
+
+func (w *responderWriter) WriteFrame(frame []byte) error {
+	w.mu.Lock()
+	w.frames = append(w.frames, frame)
+	w.mu.Unlock()
+
+	if w.written != nil {
+		w.written(frame)
+	}
+
+	return nil
+}
+
+func (w *responderWriter) sent() [][]byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.frames
+}
+
+func vid10() *uint16 {
+	vid := uint16(10)
+	return &vid
+}
+
+func newTestResponder(t *testing.T, w capture.FrameWriter) (*Responder, *clocktest.Fake) {
+	t.Helper()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+
+	r, err := NewResponder(testRackMAC, w, WithResponderClock(clk),
+		WithResponderLimiter(NewProbeLimiter(0, 0)))
+	require.NoError(t, err)
+
+	return r, clk
+}
+
+// activate activates m, advancing clk through the probes
+func activate(t *testing.T, r *Responder, clk *clocktest.Fake, m Mapping, probes int) error {
+	t.Helper()
+
+	errC := make(chan error, 1)
+
+	go func() { errC <- r.Activate(context.Background(), m) }()
+
+	for range probes {
+		clk.BlockUntil(1)
+		clk.Advance(defaultResponderProbeWait)
+	}
+
+	return <-errC
+}
+
+func TestMappingStateString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "suspended", MappingSuspended.String())
+	assert.Equal(t, "MappingState(9)", MappingState(9).String())
+
+	b, err := json.Marshal(MappingStatus{State: MappingActive})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"vid":null,"ip":"","mac":"","state":"active","since":0}`, string(b))
+
+	var st MappingStatus
+
+	require.NoError(t, json.Unmarshal(b, &st))
+	assert.Equal(t, MappingActive, st.State)
+	assert.Error(t, json.Unmarshal([]byte(`{"state":"MappingState(9)"}`), &st))
+}
+
+func TestNewResponder(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewResponder(nil, &responderWriter{})
+	assert.ErrorIs(t, err, ethernet.ErrBuildFrame)
+
+	r, _ := newTestResponder(t, &responderWriter{})
+
+	testcases := map[string]Mapping{
+		"no IP":       {MAC: testProxiedMAC},
+		"unspecified": {IP: netip.IPv4Unspecified(), MAC: testProxiedMAC},
+		"multicast":   {IP: netip.MustParseAddr("224.0.0.1"), MAC: testProxiedMAC},
+		"no MAC":      {IP: testProxied4},
+	}
+
+	for name, m := range testcases {
+		m := m
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.ErrorIs(t, r.Activate(context.Background(), m), ErrInvalidMapping)
+		})
+	}
+}
+
+func TestResponderActivate(t *testing.T) {
+	t.Parallel()
+
+	w := &responderWriter{}
+	r, clk := newTestResponder(t, w)
+
+	require.NoError(t, activate(t, r, clk, Mapping{IP: testProxied4, MAC: testProxiedMAC}, defaultResponderProbes))
+
+	status, ok := r.Status(testProxied4, nil)
+	require.True(t, ok)
+	assert.Equal(t, MappingStatus{IP: "10.0.0.50", MAC: "00:16:3e:bb:00:01", State: MappingActive,
+		Since: clk.Now().Unix()}, status)
+
+	// the RFC 5227 probes, from the source of the probes
+	require.Len(t, w.sent(), defaultResponderProbes)
+
+	var eth ethernet.EthernetFrame
+
+	require.NoError(t, eth.UnmarshalBinary(w.sent()[0]))
+	assert.Equal(t, testRackMAC, eth.SrcMAC)
+
+	pkt, err := eth.ExtractARPPacket()
+	require.NoError(t, err)
+	assert.Equal(t, netip.IPv4Unspecified(), pkt.SendIPAddr)
+	assert.Equal(t, testProxied4, pkt.TgtIPAddr)
+
+	_, ok = r.Status(testProxied4, vid10())
+	assert.False(t, ok)
+}
+
+func TestResponderActivateConflict(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		claim func(t *testing.T) []byte
+		err   error
+		state MappingState
+	}{
+		"another host answers": {
+			claim: func(t *testing.T) []byte {
+				return buildFrame(t, ethernet.NewFrame().Src(testHostMAC).Padded().
+					ARPReply(testProxied4, testRackMAC, netip.IPv4Unspecified()), nil)
+			},
+			err:   ErrMappingConflict,
+			state: MappingFailed,
+		},
+		"another host probes": {
+			claim: func(t *testing.T) []byte {
+				return buildFrame(t, ethernet.NewFrame().Src(testHostMAC).Padded().
+					ARPRequest(netip.IPv4Unspecified(), testProxied4), nil)
+			},
+			err:   ErrMappingConflict,
+			state: MappingFailed,
+		},
+		"the expected MAC answers": {
+			claim: func(t *testing.T) []byte {
+				return buildFrame(t, ethernet.NewFrame().Src(testProxiedMAC).Padded().
+					ARPReply(testProxied4, testRackMAC, netip.IPv4Unspecified()), nil)
+			},
+			state: MappingActive,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			claim := tc.claim(t)
+			w := &responderWriter{}
+			r, clk := newTestResponder(t, w)
+
+			w.written = func([]byte) {
+				_, own := r.Observe(claim, nil, capture.Metadata{Direction: capture.DirectionInbound})
+				assert.False(t, own)
+			}
+
+			probes := defaultResponderProbes
+			if tc.err != nil {
+				// the probing stops at the first answer
+				probes = 1
+			}
+
+			err := activate(t, r, clk, Mapping{IP: testProxied4, MAC: testProxiedMAC}, probes)
+			if tc.err == nil {
+				require.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.err)
+				assert.ErrorContains(t, err, "10.0.0.50 is answered for by 00:16:3e:aa:00:01, not 00:16:3e:bb:00:01")
+				assert.Len(t, w.sent(), 1)
+			}
+
+			status, ok := r.Status(testProxied4, nil)
+			require.True(t, ok)
+			assert.Equal(t, tc.state, status.State)
+		})
+	}
+}
+
+func TestResponderActivateCancelled(t *testing.T) {
+	t.Parallel()
+
+	r, clk := newTestResponder(t, &responderWriter{})
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+
+	go func() { errC <- r.Activate(ctx, Mapping{IP: testProxied4, MAC: testProxiedMAC}) }()
+
+	clk.BlockUntil(1)
+	cancel()
+
+	assert.ErrorIs(t, <-errC, context.Canceled)
+
+	status, ok := r.Status(testProxied4, nil)
+	require.True(t, ok)
+	assert.Equal(t, MappingFailed, status.State)
+
+	assert.True(t, r.Remove(testProxied4, nil))
+	assert.False(t, r.Remove(testProxied4, nil))
+	assert.Empty(t, r.Mappings())
+}
+
+func TestResponderAnswerAndSuspend(t *testing.T) {
+	t.Parallel()
+
+	vid := vid10()
+	w := &responderWriter{}
+	r, clk := newTestResponder(t, w)
+
+	require.NoError(t, activate(t, r, clk, Mapping{IP: testProxied4, MAC: testProxiedMAC, VID: vid},
+		defaultResponderProbes))
+
+	inbound := capture.Metadata{Direction: capture.DirectionInbound, Timestamp: clk.Now()}
+	request := buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Padded().
+		ARPRequest(netip.MustParseAddr("10.0.0.10"), testProxied4), vid)
+
+	res, own := r.Observe(request, vid, inbound)
+	assert.False(t, own)
+	assert.Empty(t, res)
+	require.Len(t, w.sent(), defaultResponderProbes+1)
+
+	var eth ethernet.EthernetFrame
+
+	answer := w.sent()[defaultResponderProbes]
+	require.NoError(t, eth.UnmarshalBinary(answer))
+	assert.Equal(t, testProxiedMAC, eth.SrcMAC)
+	assert.Equal(t, testPXEClient, eth.DstMAC)
+
+	pkt, err := eth.ExtractARPPacket()
+	require.NoError(t, err)
+	assert.Equal(t, ethernet.OpReply, pkt.OpCode)
+	assert.Equal(t, testProxied4, pkt.SendIPAddr)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.10"), pkt.TgtIPAddr)
+
+	// the requests of another VLAN aren't answered
+	_, _ = r.Observe(buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Padded().
+		ARPRequest(netip.MustParseAddr("10.0.0.10"), testProxied4), nil), nil, inbound)
+	assert.Len(t, w.sent(), defaultResponderProbes+1)
+
+	// another host answers for the address
+	res, own = r.Observe(buildFrame(t, ethernet.NewFrame().Src(testHostMAC).Padded().
+		ARPReply(testProxied4, testPXEClient, netip.MustParseAddr("10.0.0.10")), vid), vid, inbound)
+	assert.False(t, own)
+
+	status := MappingStatus{VID: vid, IP: "10.0.0.50", MAC: "00:16:3e:bb:00:01", ClaimedBy: "00:16:3e:aa:00:01",
+		State: MappingSuspended, Since: clk.Now().Unix()}
+
+	assert.Equal(t, []Result{{
+		IP:          "10.0.0.50",
+		MAC:         "00:16:3e:aa:00:01",
+		PreviousMAC: "00:16:3e:bb:00:01",
+		VID:         vid,
+		Time:        clk.Now().Unix(),
+		Event:       EventResponderSuspended,
+		Responder:   &status,
+	}}, res)
+	assert.Equal(t, []MappingStatus{status}, r.Mappings())
+
+	// and the suspended mapping isn't answered for anymore
+	_, _ = r.Observe(request, vid, inbound)
+	assert.Len(t, w.sent(), defaultResponderProbes+1)
+}
+
+func TestResponderNeighborSolicitation(t *testing.T) {
+	t.Parallel()
+
+	w := &responderWriter{}
+	r, clk := newTestResponder(t, w)
+
+	require.NoError(t, activate(t, r, clk, Mapping{IP: testProxied6, MAC: testProxiedMAC}, defaultResponderProbes))
+
+	msg, pkt, err := ndp.ParseFrame(w.sent()[0])
+	require.NoError(t, err)
+	assert.Equal(t, ndp.TypeNeighborSolicitation, msg.Type)
+	assert.Equal(t, testProxied6, msg.Target)
+	assert.Equal(t, netip.IPv6Unspecified(), pkt.Src)
+
+	solicitor := netip.MustParseAddr("2001:db8::10")
+
+	_, own := r.Observe(buildFrame(t, ethernet.NewFrame().Src(testPXEClient).
+		NeighborSolicitation(solicitor, testProxied6), nil), nil, capture.Metadata{})
+	assert.False(t, own)
+	require.Len(t, w.sent(), defaultResponderProbes+1)
+
+	msg, pkt, err = ndp.ParseFrame(w.sent()[defaultResponderProbes])
+	require.NoError(t, err)
+	assert.Equal(t, ndp.TypeNeighborAdvertisement, msg.Type)
+	assert.Equal(t, testProxied6, msg.Target)
+	assert.Equal(t, testProxiedMAC, msg.LinkLayerAddr)
+	assert.True(t, msg.Solicited)
+	assert.Equal(t, solicitor, pkt.Dst)
+}
+
+func TestResponderOwnFrames(t *testing.T) {
+	t.Parallel()
+
+	w := &responderWriter{}
+	r, clk := newTestResponder(t, w)
+
+	require.NoError(t, activate(t, r, clk, Mapping{IP: testProxied4, MAC: testProxiedMAC}, defaultResponderProbes))
+
+	_, _ = r.Observe(buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Padded().
+		ARPRequest(netip.MustParseAddr("10.0.0.10"), testProxied4), nil), nil, capture.Metadata{})
+
+	answer := w.sent()[defaultResponderProbes]
+
+	testcases := map[string]struct {
+		frame []byte
+		md    capture.Metadata
+		own   bool
+	}{
+		"outbound answer": {
+			frame: answer,
+			md:    capture.Metadata{Direction: capture.DirectionOutbound},
+			own:   true,
+		},
+		"outbound probe": {
+			frame: w.sent()[0],
+			md:    capture.Metadata{Direction: capture.DirectionOutbound},
+			own:   true,
+		},
+		"outbound request of the host": {
+			frame: buildFrame(t, ethernet.NewFrame().Src(testRackMAC).Padded().
+				ARPRequest(netip.MustParseAddr("10.0.0.1"), testProxied4), nil),
+			md: capture.Metadata{Direction: capture.DirectionOutbound},
+		},
+		"inbound": {
+			frame: answer,
+			md:    capture.Metadata{Direction: capture.DirectionInbound},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, own := r.Observe(tc.frame, nil, tc.md)
+			assert.Equal(t, tc.own, own)
+			assert.Empty(t, res)
+		})
+	}
+
+	// without a direction, the answer is recognized once, within the window
+	_, own := r.Observe(answer, nil, capture.Metadata{})
+	assert.True(t, own)
+
+	_, own = r.Observe(answer, nil, capture.Metadata{})
+	assert.False(t, own)
+
+	_, _ = r.Observe(buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Padded().
+		ARPRequest(netip.MustParseAddr("10.0.0.10"), testProxied4), nil), nil, capture.Metadata{})
+	clk.Advance(responderSentWindow + time.Second)
+
+	_, own = r.Observe(answer, nil, capture.Metadata{})
+	assert.False(t, own)
+}
+
+func TestServiceResponder(t *testing.T) {
+	t.Parallel()
+
+	w := &responderWriter{}
+	r, clk := newTestResponder(t, w)
+
+	require.NoError(t, activate(t, r, clk, Mapping{IP: testProxied4, MAC: testProxiedMAC}, defaultResponderProbes))
+
+	// the frames of the host are observed, the responder's still aren't
+	svc := NewService("eth0", WithResponder(r), WithOwnTraffic())
+	md := capture.Metadata{Timestamp: clk.Now()}
+
+	res, err := svc.handleFrame(buildFrame(t, ethernet.NewFrame().Src(testPXEClient).Padded().
+		ARPRequest(netip.MustParseAddr("10.0.0.10"), testProxied4), nil), md)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+
+	res, err = svc.handleFrame(w.sent()[defaultResponderProbes], capture.Metadata{Timestamp: clk.Now(),
+		Direction: capture.DirectionOutbound})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	for _, b := range svc.Bindings() {
+		assert.NotEqual(t, testProxied4.String(), b.IP)
+	}
+
+	// the claim of another host suspends the mapping, and is learned
+	res, err = svc.handleFrame(buildFrame(t, ethernet.NewFrame().Src(testHostMAC).Padded().
+		ARPReply(testProxied4, testPXEClient, netip.MustParseAddr("10.0.0.10")), nil), md)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, EventResponderSuspended, res[0].Event)
+	assert.Equal(t, EventNew, res[1].Event)
+	assert.Equal(t, testProxied4.String(), res[1].IP)
+
+	// the neighbor solicitations are captured as with a DADDetector
+	filter, err := svc.captureFilter()
+	require.NoError(t, err)
+
+	want, err := NewService("eth0", WithDADDetector(NewDADDetector())).captureFilter()
+	require.NoError(t, err)
+	assert.Equal(t, want, filter)
+}
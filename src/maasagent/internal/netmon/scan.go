@@ -16,6 +16,7 @@
 package netmon
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"net"
@@ -30,6 +31,9 @@ import (
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/clock"
 )
 
 const (
@@ -37,7 +41,9 @@ const (
 	// https://github.com/google/gopacket/blob/v1.1.19/pcap/pcap.go#L124
 	BlockForever     time.Duration = -time.Millisecond * 10
 	OperationTimeout time.Duration = 3 * time.Second
-	SnapLen          int32         = 64
+	// SnapLen keeps the payload of the ICMPv6 echo replies up to the token
+	// of the scan
+	SnapLen int32 = 128
 )
 
 var (
@@ -63,98 +69,281 @@ var (
 	}
 )
 
+// ScanOption configures a Scanner
+type ScanOption func(*Scanner)
+
+// WithScanClock sets the clock the replies and the timeout of the scans are
+// timed with
+func WithScanClock(c clock.Clock) ScanOption {
+	return func(s *Scanner) {
+		s.clock = c
+	}
+}
+
+// Scanner probes hosts with ICMP echo requests, Scan, ScanFrom and
+// StreamFrom are those of a Scanner with the system clock
+type Scanner struct {
+	clock clock.Clock
+	// open returns the link sending the requests of a scan and capturing
+	// the replies until ctx is done
+	open func(ctx context.Context) (echoLink, error)
+}
+
+// NewScanner returns a Scanner sending its requests from the host
+func NewScanner(options ...ScanOption) *Scanner {
+	s := &Scanner{clock: clock.System{}, open: openEchoLink}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
 // Scan sends ICMP Echo requests to provided IP addresses.
 func Scan(ctx context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+	return NewScanner().Scan(ctx, ips)
+}
+
+// ScanFrom is Scan with the requests sent from src, see Scanner.ScanFrom
+func ScanFrom(ctx context.Context, src netip.Addr, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+	return NewScanner().ScanFrom(ctx, src, ips)
+}
+
+// StreamFrom is ScanFrom calling found with the reply of each host as it
+// is captured, see Scanner.StreamFrom
+func StreamFrom(ctx context.Context, src netip.Addr, ips []netip.Addr, found func(ScanReply)) error {
+	return NewScanner().StreamFrom(ctx, src, ips, found)
+}
+
+// Scan sends ICMP Echo requests to provided IP addresses, it implements
+// ScanFunc
+func (s *Scanner) Scan(ctx context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+	return s.ScanFrom(ctx, netip.Addr{}, ips)
+}
+
+// ScanFrom is Scan with the requests sent from src, such as the address
+// netif.SelectSource picks, rather than from the address the kernel
+// routes them from. The addresses of the other family are still probed
+// from the kernel's, and so are all of them when src is invalid. The
+// IPv4-mapped addresses are probed, and reported, as the IPv4 addresses
+// they map.
+func (s *Scanner) ScanFrom(ctx context.Context, src netip.Addr,
+	ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+	return s.scanFrom(ctx, src, ips, nil)
+}
+
+// scanFrom is ScanFrom counting the replies in probes
+func (s *Scanner) scanFrom(ctx context.Context, src netip.Addr, ips []netip.Addr,
+	probes *probeCounter) (map[netip.Addr]net.HardwareAddr, error) {
 	result := make(map[netip.Addr]net.HardwareAddr, len(ips))
 
+	for _, ip := range ips {
+		result[ip.Unmap()] = nil
+	}
+
+	err := s.streamFrom(ctx, src, ips, func(reply ScanReply) {
+		result[reply.IP] = reply.MAC
+	}, probes)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ScanReply is the reply of a host to a probe
+type ScanReply struct {
+	IP  netip.Addr
+	MAC net.HardwareAddr
+	// RTT is the time between sending the probe and capturing the reply
+	RTT time.Duration
+}
+
+// StreamFrom is ScanFrom calling found with the reply of each host as it
+// is captured, in the order they come rather than that of ips. found is
+// called once per replying host, from the goroutine of StreamFrom, and no
+// more once it returned. A src which can't be the address of a host, such
+// as a multicast one, returns an addrutil error.
+//
+// The requests carry an identifier and a token random for the scan, a reply
+// only counts when it echoes those of the request sent to its address, so
+// the replies to the scans of another agent on the segment, or to other
+// pings of the host, aren't mistaken for those of the scan.
+func (s *Scanner) StreamFrom(ctx context.Context, src netip.Addr, ips []netip.Addr, found func(ScanReply)) error {
+	return s.streamFrom(ctx, src, ips, found, nil)
+}
+
+// outstandingEcho is an echo request of a scan waiting for its reply
+type outstandingEcho struct {
+	sent time.Time
+	seq  uint16
+}
+
+// streamFrom is StreamFrom counting the replies in probes
+func (s *Scanner) streamFrom(ctx context.Context, src netip.Addr, ips []netip.Addr, found func(ScanReply),
+	probes *probeCounter) error {
+	if src.IsValid() {
+		var err error
+
+		if src, err = addrutil.UnicastIP("src", src); err != nil {
+			return err
+		}
+	}
+
 	if len(ips) == 0 {
-		return result, nil
+		return nil
 	}
 
-	queue := make(map[netip.Addr]struct{})
-	conns := make(map[int]*icmp.PacketConn)
+	probe := newEchoProbe()
+	outstanding := make(map[netip.Addr]outstandingEcho)
 
 	cctx, ccancel := context.WithCancel(ctx)
 	defer ccancel()
 
-	pairs, err := capture(cctx)
+	link, err := s.open(cctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	for i, ip := range ips {
-		result[ip] = nil
+	defer func() {
+		err := link.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
 
+	for i, ip := range ips {
 		if !ip.IsValid() {
 			continue
 		}
 
-		c, ok := conns[ip.BitLen()]
-		if !ok {
-			c, err = getConn(ip)
-			if err != nil {
-				return nil, err
-			}
-
-			defer func() {
-				err := c.Close()
-				if err != nil {
-					panic(err)
-				}
-			}()
+		ip = ip.Unmap()
 
-			conns[ip.BitLen()] = c
-		}
+		seq := uint16(i) //nolint:gosec // the targets of a job fit, the IP tells those of larger scans apart
 
-		_, err := c.WriteTo(icmpMessage(ip, i), &net.IPAddr{IP: ip.AsSlice()})
-		if err != nil {
-			return nil, err
+		if err := link.Send(src, ip, icmpMessage(ip, probe, seq)); err != nil {
+			return err
 		}
 
-		queue[ip] = struct{}{}
+		outstanding[ip] = outstandingEcho{sent: s.clock.Now(), seq: seq}
 	}
 
-	ch := make(chan struct{})
-	timer := time.AfterFunc(OperationTimeout, func() {
-		close(ch)
-	})
-
+	timer := s.clock.NewTimer(OperationTimeout)
 	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			return result, nil
-		case pair := <-pairs:
-			if _, ok := queue[pair.IP]; ok {
-				result[pair.IP] = pair.HwAddress
+			return nil
+		case reply := <-link.Replies():
+			echo, ok := outstanding[reply.IP]
+
+			ours, scan := probe.match(reply.id, reply.seq, echo.seq, reply.payload)
+			if !ok || !ours {
+				// the replies to other pings of the host are none of ours
+				if ok || scan {
+					probes.unsolicitedReply()
+				}
+
+				continue
 			}
 
-			delete(queue, pair.IP)
+			probes.reply()
+			found(ScanReply{IP: reply.IP, MAC: reply.HwAddress, RTT: s.clock.Now().Sub(echo.sent)})
+
+			delete(outstanding, reply.IP)
 
-			if len(queue) == 0 {
+			if len(outstanding) == 0 {
 				ccancel()
-				return result, nil
+				return nil
 			}
-		case <-ch:
+		case <-timer.C():
 			ccancel()
-			return result, nil
+			return nil
 		}
 	}
 }
 
-func getConn(ip netip.Addr) (*icmp.PacketConn, error) {
+// echoLink sends the echo requests of a scan and captures the replies
+type echoLink interface {
+	// Send sends the ICMP message msg to ip, from src unless invalid
+	Send(src, ip netip.Addr, msg []byte) error
+	// Replies are the echo replies captured
+	Replies() <-chan echoReply
+	Close() error
+}
+
+// liveEchoLink sends the requests over ICMP sockets, one per family, and
+// captures the replies with pcap
+type liveEchoLink struct {
+	replies chan echoReply
+	conns   map[int]*icmp.PacketConn
+}
+
+// openEchoLink starts capturing the echo replies until ctx is done, the
+// sockets are opened by the first request of their family
+func openEchoLink(ctx context.Context) (echoLink, error) {
+	replies, err := captureReplies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &liveEchoLink{replies: replies, conns: make(map[int]*icmp.PacketConn)}, nil
+}
+
+func (l *liveEchoLink) Send(src, ip netip.Addr, msg []byte) error {
+	c, ok := l.conns[ip.BitLen()]
+	if !ok {
+		var err error
+
+		c, err = getConn(ip, src)
+		if err != nil {
+			return err
+		}
+
+		l.conns[ip.BitLen()] = c
+	}
+
+	_, err := c.WriteTo(msg, &net.IPAddr{IP: ip.AsSlice()})
+
+	return err
+}
+
+func (l *liveEchoLink) Replies() <-chan echoReply {
+	return l.replies
+}
+
+func (l *liveEchoLink) Close() error {
+	var errs []error
+
+	for _, c := range l.conns {
+		errs = append(errs, c.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+func getConn(ip, src netip.Addr) (*icmp.PacketConn, error) {
 	switch ip.BitLen() {
 	case 0, 32:
+		if src.Is4() {
+			return icmp.ListenPacket("ip4:icmp", src.String())
+		}
+
 		return icmp.ListenPacket("ip4:icmp", "0.0.0.0")
 	case 128:
+		if src.Is6() {
+			return icmp.ListenPacket("ip6:ipv6-icmp", src.String())
+		}
+
 		return icmp.ListenPacket("ip6:ipv6-icmp", "::")
 	default:
 		return nil, errors.New("unsupported size")
 	}
 }
 
-func icmpMessage(ip netip.Addr, id int) []byte {
+func icmpMessage(ip netip.Addr, probe echoProbe, seq uint16) []byte {
 	var icmpType icmp.Type
 
 	if ip.Is4() {
@@ -167,7 +356,7 @@ func icmpMessage(ip netip.Addr, id int) []byte {
 
 	msg := icmp.Message{
 		Type: icmpType,
-		Body: &icmp.Echo{ID: id},
+		Body: &icmp.Echo{ID: int(probe.id), Seq: int(seq), Data: probe.payload},
 	}
 
 	b, err := msg.Marshal(nil)
@@ -178,7 +367,7 @@ func icmpMessage(ip netip.Addr, id int) []byte {
 	return b
 }
 
-func capture(ctx context.Context) (chan IPHwAddressPair, error) {
+func captureReplies(ctx context.Context) (chan echoReply, error) {
 	h, err := pcap.OpenLive("", SnapLen, false, BlockForever, true)
 	if err != nil {
 		return nil, err
@@ -194,21 +383,35 @@ func capture(ctx context.Context) (chan IPHwAddressPair, error) {
 	packetSource.Lazy = true
 	packetSource.NoCopy = true
 
-	out := make(chan IPHwAddressPair)
+	out := make(chan echoReply)
+
+	go pumpReplies(ctx, packetSource.Packets(), out, h.Close)
+
+	return out, nil
+}
+
+// pumpReplies sends the echo replies of packets to out until ctx is done or
+// packets is closed, and then calls done. The scan stops reading out once it
+// times out or is cancelled, so the sends give way to ctx.
+func pumpReplies(ctx context.Context, packets <-chan gopacket.Packet, out chan<- echoReply, done func()) {
+	defer done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packet, ok := <-packets:
+			if !ok {
+				return
+			}
 
-	go func() {
-		for {
 			select {
+			case out <- getEchoReply(packet):
 			case <-ctx.Done():
-				h.Close()
 				return
-			case packet := <-packetSource.Packets():
-				out <- getIPHwAddressPair(packet)
 			}
 		}
-	}()
-
-	return out, nil
+	}
 }
 
 type IPHwAddressPair struct {
@@ -250,3 +453,33 @@ func getIPHwAddressPair(p gopacket.Packet) IPHwAddressPair {
 
 	return pair
 }
+
+// echoReply is an ICMP echo reply captured by a scan
+type echoReply struct {
+	IPHwAddressPair
+	payload []byte
+	id      uint16
+	seq     uint16
+}
+
+func getEchoReply(p gopacket.Packet) echoReply {
+	reply := echoReply{IPHwAddressPair: getIPHwAddressPair(p)}
+
+	// the packet data is reused for the next one
+	reply.HwAddress = bytes.Clone(reply.HwAddress)
+
+	if layer, ok := p.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4); ok {
+		reply.id, reply.seq = layer.Id, layer.Seq
+		reply.payload = bytes.Clone(layer.Payload)
+	}
+
+	// the ICMPv6Echo layer leaves its payload in that of the ICMPv6 layer,
+	// after the identifier and sequence number
+	echo, ok := p.Layer(layers.LayerTypeICMPv6Echo).(*layers.ICMPv6Echo)
+	if layer, icmp := p.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6); ok && icmp && len(layer.Payload) >= 4 {
+		reply.id, reply.seq = echo.Identifier, echo.SeqNumber
+		reply.payload = bytes.Clone(layer.Payload[4:])
+	}
+
+	return reply
+}
@@ -22,10 +22,17 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/icmp"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/leak"
 )
 
 // TestScan can be used for testing
@@ -99,3 +106,159 @@ func TestGetIPHwAddressPair(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamFromInvalidSource(t *testing.T) {
+	t.Parallel()
+
+	ips := []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+
+	// rejected before probing, which needs privileges
+	for _, src := range []string{"0.0.0.0", "::", "224.0.0.1", "ff02::1", "255.255.255.255"} {
+		err := StreamFrom(context.Background(), netip.MustParseAddr(src), ips, func(ScanReply) {})
+		assert.ErrorIs(t, err, addrutil.ErrInvalidIP, src)
+	}
+}
+
+// echoRequest is a request sent through a fakeEchoLink
+type echoRequest struct {
+	ip  netip.Addr
+	msg *icmp.Echo
+}
+
+// fakeEchoLink is an echoLink whose replies are sent by the test
+type fakeEchoLink struct {
+	sent    chan echoRequest
+	replies chan echoReply
+}
+
+func (l *fakeEchoLink) Send(_, ip netip.Addr, msg []byte) error {
+	m, err := icmp.ParseMessage(1, msg)
+	if err != nil {
+		return err
+	}
+
+	l.sent <- echoRequest{ip: ip, msg: m.Body.(*icmp.Echo)} //nolint:forcetypeassert // an echo request
+
+	return nil
+}
+
+func (l *fakeEchoLink) Replies() <-chan echoReply {
+	return l.replies
+}
+
+func (l *fakeEchoLink) Close() error {
+	return nil
+}
+
+func TestScannerClock(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	link := &fakeEchoLink{sent: make(chan echoRequest, 2), replies: make(chan echoReply)}
+
+	s := NewScanner(WithScanClock(clk))
+	s.open = func(context.Context) (echoLink, error) { return link, nil }
+
+	ips := []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")}
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	found := make(chan ScanReply)
+	done := make(chan error)
+
+	go func() {
+		done <- s.StreamFrom(context.Background(), netip.Addr{}, ips, func(r ScanReply) { found <- r })
+	}()
+
+	// the scan waits on its timer once the requests are sent
+	clk.BlockUntil(1)
+
+	req := <-link.sent
+
+	clk.Advance(40 * time.Millisecond)
+
+	link.replies <- echoReply{
+		IPHwAddressPair: IPHwAddressPair{IP: req.ip, HwAddress: mac},
+		payload:         req.msg.Data,
+		id:              uint16(req.msg.ID),  //nolint:gosec // an echo identifier
+		seq:             uint16(req.msg.Seq), //nolint:gosec // an echo sequence number
+	}
+
+	assert.Equal(t, ScanReply{IP: req.ip, MAC: mac, RTT: 40 * time.Millisecond}, <-found)
+
+	// the other host never replies, the scan ends on the clock
+	clk.Advance(OperationTimeout - 40*time.Millisecond - time.Nanosecond)
+
+	select {
+	case err := <-done:
+		t.Fatalf("scan ended before its timeout: %v", err)
+	default:
+	}
+
+	clk.Advance(time.Nanosecond)
+	require.NoError(t, <-done)
+}
+
+func TestScannerCancelWhileReplying(t *testing.T) {
+	defer leak.Check(t)()
+
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+
+	// the replies of a host which isn't scanned, the scan reads them all
+	buf := gopacket.NewSerializeBuffer()
+	require.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true},
+		&layers.Ethernet{SrcMAC: mac, DstMAC: mac, EthernetType: layers.EthernetTypeIPv4},
+		&layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4,
+			SrcIP: net.IPv4(10, 0, 0, 9), DstIP: net.IPv4(10, 0, 0, 254)},
+		&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0), Id: 1, Seq: 1},
+	))
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+
+	packets := make(chan gopacket.Packet)
+	stop := make(chan struct{})
+	arrived := make(chan struct{})
+
+	defer close(stop)
+
+	go func() {
+		for {
+			select {
+			case packets <- packet:
+			case <-stop:
+				return
+			}
+
+			select {
+			case arrived <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	link := &fakeEchoLink{sent: make(chan echoRequest, 1), replies: make(chan echoReply)}
+
+	s := NewScanner(WithScanClock(clk))
+	s.open = func(ctx context.Context) (echoLink, error) {
+		go pumpReplies(ctx, packets, link.replies, func() {})
+		return link, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- s.StreamFrom(ctx, netip.Addr{}, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, func(ScanReply) {})
+	}()
+
+	clk.BlockUntil(1)
+	<-link.sent
+
+	for range 10 {
+		<-arrived
+	}
+
+	cancel()
+
+	// the replies still arriving don't hold the pump once the scan is over
+	require.NoError(t, <-done)
+}
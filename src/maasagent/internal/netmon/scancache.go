@@ -0,0 +1,214 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	defaultScanMissThreshold = 3
+	defaultScanFullRefresh   = 10
+)
+
+// ScanHost is a host which replied to a scan
+type ScanHost struct {
+	// IP is the presentation format of the address of the host
+	IP string `json:"ip"`
+	// MAC is the presentation format of the address the host replied from
+	MAC string `json:"mac"`
+}
+
+// ScanHostChange is a host which replied from another MAC address
+type ScanHostChange struct {
+	IP          string `json:"ip"`
+	MAC         string `json:"mac"`
+	PreviousMAC string `json:"previous_mac"`
+}
+
+// ScanReport is what changed since the previous run of a job. Every Full
+// report also lists all the known hosts, so a consumer which missed a
+// report catches up.
+type ScanReport struct {
+	// Job is the ID of the job
+	Job string `json:"job"`
+	// Source is the address the probes were sent from, unset when the
+	// kernel picked it
+	Source string `json:"source,omitempty"`
+	// Tags are the encapsulation the hosts were probed through, outermost
+	// first, the VLAN of the hosts is the innermost
+	Tags []ethernet.Tag `json:"tags,omitempty"`
+	// Hosts are all the known hosts, for a full report
+	Hosts []ScanHost `json:"hosts,omitempty"`
+	// New are the hosts which replied for the first time
+	New []ScanHost `json:"new,omitempty"`
+	// Changed are the hosts which replied from another MAC
+	Changed []ScanHostChange `json:"changed,omitempty"`
+	// Gone are the hosts which missed MissThreshold runs in a row
+	Gone []ScanHost `json:"gone,omitempty"`
+	// Time is when the run started
+	Time int64 `json:"time"`
+	// Full is set when Hosts lists every known host
+	Full bool `json:"full"`
+}
+
+// Empty returns true when the report has nothing to tell
+func (r ScanReport) Empty() bool {
+	return !r.Full && len(r.New) == 0 && len(r.Changed) == 0 && len(r.Gone) == 0
+}
+
+type cachedHost struct {
+	mac    net.HardwareAddr
+	misses int
+}
+
+type scanCacheEntry struct {
+	hosts map[netip.Addr]*cachedHost
+	runs  int
+}
+
+// ScanCache keeps the hosts found by the previous runs of scan jobs, so
+// repeated scans only report what changed. Jobs scanning the same targets
+// from the same interface share their results.
+type ScanCache struct {
+	entries map[string]*scanCacheEntry
+	mu      sync.Mutex
+}
+
+// NewScanCache returns an empty ScanCache
+func NewScanCache() *ScanCache {
+	return &ScanCache{entries: make(map[string]*scanCacheEntry)}
+}
+
+// scanCacheKey identifies the targets of a job
+func scanCacheKey(job ScanJob) string {
+	prefixes := make([]string, len(job.Targets))
+	for i, p := range job.Targets {
+		prefixes[i] = p.Masked().String()
+	}
+
+	slices.Sort(prefixes)
+
+	vid := ""
+	if job.VID != nil {
+		vid = strconv.Itoa(int(*job.VID))
+	}
+
+	return job.Interface + "|" + vid + "|" + strings.Join(slices.Compact(prefixes), ",")
+}
+
+// Update records the hosts found by a run of job and returns what changed.
+// found holds every scanned address, nil for the ones which didn't reply.
+func (c *ScanCache) Update(job ScanJob, found map[netip.Addr]net.HardwareAddr, timestamp time.Time) ScanReport {
+	threshold := job.MissThreshold
+	if threshold == 0 {
+		threshold = defaultScanMissThreshold
+	}
+
+	refresh := job.FullRefresh
+	if refresh == 0 {
+		refresh = defaultScanFullRefresh
+	}
+
+	key := scanCacheKey(job)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &scanCacheEntry{hosts: make(map[netip.Addr]*cachedHost)}
+		c.entries[key] = entry
+	}
+
+	entry.runs++
+
+	report := ScanReport{
+		Job:  job.ID,
+		Time: timestamp.Unix(),
+		Full: (entry.runs-1)%refresh == 0,
+	}
+
+	for _, ip := range slices.SortedFunc(maps.Keys(found), netip.Addr.Compare) {
+		mac := found[ip]
+		if mac == nil {
+			continue
+		}
+
+		host, ok := entry.hosts[ip]
+
+		switch {
+		case !ok:
+			entry.hosts[ip] = &cachedHost{mac: slices.Clone(mac)}
+			report.New = append(report.New, ScanHost{IP: ip.String(), MAC: mac.String()})
+		case !bytes.Equal(host.mac, mac):
+			report.Changed = append(report.Changed, ScanHostChange{
+				IP:          ip.String(),
+				MAC:         mac.String(),
+				PreviousMAC: host.mac.String(),
+			})
+			host.mac = slices.Clone(mac)
+		}
+
+		if ok {
+			host.misses = 0
+		}
+	}
+
+	known := slices.SortedFunc(maps.Keys(entry.hosts), netip.Addr.Compare)
+
+	for _, ip := range known {
+		host := entry.hosts[ip]
+		if found[ip] != nil {
+			continue
+		}
+
+		host.misses++
+
+		if host.misses >= threshold {
+			delete(entry.hosts, ip)
+			report.Gone = append(report.Gone, ScanHost{IP: ip.String(), MAC: host.mac.String()})
+		}
+	}
+
+	if report.Full {
+		for _, ip := range known {
+			if host, ok := entry.hosts[ip]; ok {
+				report.Hosts = append(report.Hosts, ScanHost{IP: ip.String(), MAC: host.mac.String()})
+			}
+		}
+	}
+
+	return report
+}
+
+// Forget drops the hosts found for the targets of job
+func (c *ScanCache) Forget(job ScanJob) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, scanCacheKey(job))
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+var (
+	scanHostA = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	scanHostB = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+)
+
+// scanRun returns the result of scanning 10.0.0.1 and 10.0.0.2, macs are
+// the replies of each
+func scanRun(macs ...net.HardwareAddr) map[netip.Addr]net.HardwareAddr {
+	return map[netip.Addr]net.HardwareAddr{
+		netip.MustParseAddr("10.0.0.1"): macs[0],
+		netip.MustParseAddr("10.0.0.2"): macs[1],
+	}
+}
+
+func TestScanCacheUpdate(t *testing.T) {
+	t.Parallel()
+
+	job := ScanJob{
+		ID:            "a",
+		Targets:       []netip.Prefix{netip.MustParsePrefix("10.0.0.0/30")},
+		MissThreshold: 2,
+		FullRefresh:   4,
+	}
+
+	testcases := map[string]struct {
+		runs []map[netip.Addr]net.HardwareAddr
+		out  ScanReport
+	}{
+		"first run": {
+			runs: []map[netip.Addr]net.HardwareAddr{scanRun(scanHostA, nil)},
+			out: ScanReport{
+				Job:   "a",
+				Full:  true,
+				Hosts: []ScanHost{{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01"}},
+				New:   []ScanHost{{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01"}},
+			},
+		},
+		"unchanged": {
+			runs: []map[netip.Addr]net.HardwareAddr{
+				scanRun(scanHostA, nil),
+				scanRun(scanHostA, nil),
+			},
+			out: ScanReport{Job: "a"},
+		},
+		"new host": {
+			runs: []map[netip.Addr]net.HardwareAddr{
+				scanRun(scanHostA, nil),
+				scanRun(scanHostA, scanHostB),
+			},
+			out: ScanReport{Job: "a", New: []ScanHost{{IP: "10.0.0.2", MAC: "00:16:3e:00:00:02"}}},
+		},
+		"MAC change": {
+			runs: []map[netip.Addr]net.HardwareAddr{
+				scanRun(scanHostA, nil),
+				scanRun(scanHostB, nil),
+			},
+			out: ScanReport{Job: "a", Changed: []ScanHostChange{
+				{IP: "10.0.0.1", MAC: "00:16:3e:00:00:02", PreviousMAC: "00:16:3e:00:00:01"},
+			}},
+		},
+		"single miss": {
+			runs: []map[netip.Addr]net.HardwareAddr{
+				scanRun(scanHostA, nil),
+				scanRun(nil, nil),
+			},
+			out: ScanReport{Job: "a"},
+		},
+		"gone": {
+			runs: []map[netip.Addr]net.HardwareAddr{
+				scanRun(scanHostA, nil),
+				scanRun(nil, nil),
+				scanRun(nil, nil),
+			},
+			out: ScanReport{Job: "a", Gone: []ScanHost{{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01"}}},
+		},
+		"reply resets the misses": {
+			runs: []map[netip.Addr]net.HardwareAddr{
+				scanRun(scanHostA, nil),
+				scanRun(nil, nil),
+				scanRun(scanHostA, nil),
+				scanRun(nil, nil),
+			},
+			out: ScanReport{Job: "a"},
+		},
+		"full refresh": {
+			runs: []map[netip.Addr]net.HardwareAddr{
+				scanRun(scanHostA, nil),
+				scanRun(scanHostA, nil),
+				scanRun(scanHostA, nil),
+				scanRun(scanHostA, nil),
+				scanRun(scanHostA, scanHostB),
+			},
+			out: ScanReport{
+				Job:  "a",
+				Full: true,
+				Hosts: []ScanHost{
+					{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01"},
+					{IP: "10.0.0.2", MAC: "00:16:3e:00:00:02"},
+				},
+				New: []ScanHost{{IP: "10.0.0.2", MAC: "00:16:3e:00:00:02"}},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := NewScanCache()
+
+			var report ScanReport
+
+			for _, run := range tc.runs {
+				report = c.Update(job, run, time.Unix(0, 0))
+			}
+
+			assert.Equal(t, tc.out, report)
+		})
+	}
+}
+
+func TestScanCacheKey(t *testing.T) {
+	t.Parallel()
+
+	c := NewScanCache()
+	a := ScanJob{ID: "a", Interface: "eth0", Targets: []netip.Prefix{
+		netip.MustParsePrefix("10.0.1.0/30"),
+		netip.MustParsePrefix("10.0.0.0/30"),
+	}}
+	// the same targets in another order share the results
+	b := ScanJob{ID: "b", Interface: "eth0", Targets: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.1/30"),
+		netip.MustParsePrefix("10.0.1.0/30"),
+	}}
+	// but not when scanned on another VLAN
+	v := ScanJob{ID: "v", Interface: "eth0", VID: uint16Pointer(10), Targets: b.Targets}
+
+	assert.True(t, c.Update(a, scanRun(scanHostA, nil), time.Unix(0, 0)).Full)
+	assert.True(t, c.Update(b, scanRun(scanHostA, nil), time.Unix(0, 0)).Empty())
+	assert.True(t, c.Update(v, scanRun(scanHostA, nil), time.Unix(0, 0)).Full)
+
+	c.Forget(b)
+	assert.True(t, c.Update(a, scanRun(scanHostA, nil), time.Unix(0, 0)).Full)
+}
+
+func TestSchedulerReports(t *testing.T) {
+	defer leak.Check(t)()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	replies := make(chan map[netip.Addr]net.HardwareAddr)
+	reports := make(chan ScanReport, 1)
+
+	scan := func(ctx context.Context, _ []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		select {
+		case found := <-replies:
+			return found, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s := NewScheduler(WithSchedulerClock(clk), WithScanFunc(scan), WithScanJitter(0),
+		WithScanReports(func(r ScanReport) { reports <- r }))
+
+	require.NoError(t, s.Add(ScanJob{
+		ID:       "a",
+		Targets:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/30")},
+		Interval: time.Minute,
+	}))
+
+	stop := startScheduler(t, s)
+	defer stop()
+
+	replies <- scanRun(scanHostA, nil)
+
+	report := <-reports
+	assert.True(t, report.Full)
+	assert.Equal(t, schedulerEpoch.Unix(), report.Time)
+
+	// an unchanged run isn't reported
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	replies <- scanRun(scanHostA, nil)
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	replies <- scanRun(scanHostA, scanHostB)
+
+	assert.Equal(t, ScanReport{
+		Job:  "a",
+		Time: schedulerEpoch.Add(2 * time.Minute).Unix(),
+		New:  []ScanHost{{IP: "10.0.0.2", MAC: "00:16:3e:00:00:02"}},
+	}, <-reports)
+}
@@ -0,0 +1,556 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	// scanIntentEtherType is the IEEE local experimental ethertype, the
+	// magic of the payload tells the intents apart from the probes of a
+	// self-test
+	scanIntentEtherType ethernet.EthernetType = 0x88b5
+	scanIntentVersion                         = 1
+	// scanIntentFlagVID is set when the intent carries a VID
+	scanIntentFlagVID = 1 << 0
+	// maxScanIntentAgent and maxScanIntentTargets bound what an intent
+	// declares, a job scans a few prefixes at most
+	maxScanIntentAgent   = 64
+	maxScanIntentTargets = 64
+	// maxScanIntents bounds the intents of peers held, the oldest are
+	// forgotten first
+	maxScanIntents = 256
+	// defaultScanIntentMaxAge is how far the clocks of the agents may
+	// drift apart, an intent sent longer ago is refused
+	defaultScanIntentMaxAge = 30 * time.Second
+	// minScanIntentWindow is the window announced for a job which never
+	// ran, or ran faster
+	minScanIntentWindow = time.Minute
+)
+
+// ScanIntentMAC is the multicast group the intents are sent to, locally
+// administered
+var ScanIntentMAC = net.HardwareAddr{0x03, 0x6d, 0x61, 0x61, 0x73, 0x00}
+
+// scanIntentMagic starts the payload of every intent
+var scanIntentMagic = []byte("maas-scan-intent")
+
+var (
+	// ErrScanIntentSecret is returned for a ScanCoordinator without a
+	// shared secret, the intents of peers couldn't be trusted
+	ErrScanIntentSecret = errors.New("scan intents need a shared secret")
+	// ErrMalformedScanIntent is returned for an intent which can't be
+	// decoded
+	ErrMalformedScanIntent = errors.New("malformed scan intent")
+	// ErrScanIntentSignature is returned for an intent not signed with the
+	// shared secret
+	ErrScanIntentSignature = errors.New("scan intent signature mismatch")
+	// ErrStaleScanIntent is returned for an intent sent too long ago, or
+	// too far ahead, to be trusted
+	ErrStaleScanIntent = errors.New("stale scan intent")
+	// ErrReplayedScanIntent is returned for an intent already received
+	ErrReplayedScanIntent = errors.New("replayed scan intent")
+)
+
+// ScanIntent is what an agent declares it is about to scan, for the other
+// agents of the segment not to scan it as well
+type ScanIntent struct {
+	// Sent is when the intent was sent, the scan starts then
+	Sent time.Time
+	// VID is the VLAN of the targets, if any
+	VID *uint16
+	// Agent identifies the agent scanning
+	Agent   string
+	Targets []netip.Prefix
+	// Window is how long the scan is expected to take
+	Window time.Duration
+}
+
+// marshal returns the payload of the intent signed with secret: the magic,
+// the version, the flags, the VID, the time sent in nanoseconds since the
+// epoch, the window in milliseconds, the agent and the targets, each
+// prefixed by its length, then the HMAC-SHA256 of all of it
+func (i ScanIntent) marshal(secret []byte) ([]byte, error) {
+	if len(i.Agent) == 0 || len(i.Agent) > maxScanIntentAgent {
+		return nil, fmt.Errorf("%w: agent of %d bytes", ErrMalformedScanIntent, len(i.Agent))
+	}
+
+	if len(i.Targets) == 0 || len(i.Targets) > maxScanIntentTargets {
+		return nil, fmt.Errorf("%w: %d targets", ErrMalformedScanIntent, len(i.Targets))
+	}
+
+	buf := make([]byte, 0, len(scanIntentMagic)+20+len(i.Agent)+1+18*len(i.Targets)+sha256.Size)
+	buf = append(buf, scanIntentMagic...)
+	buf = append(buf, scanIntentVersion)
+
+	var (
+		flags byte
+		vid   uint16
+	)
+
+	if i.VID != nil {
+		flags |= scanIntentFlagVID
+		vid = *i.VID
+	}
+
+	window := min(i.Window.Milliseconds(), int64(^uint32(0)))
+
+	buf = append(buf, flags)
+	buf = binary.BigEndian.AppendUint16(buf, vid)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(i.Sent.UnixNano())) //nolint:gosec // after the epoch
+	buf = binary.BigEndian.AppendUint32(buf, uint32(max(window, 0)))    //nolint:gosec // bounded above
+	buf = append(buf, byte(len(i.Agent)))
+	buf = append(buf, i.Agent...)
+	buf = append(buf, byte(len(i.Targets)))
+
+	// the high bit of the length of a prefix tells an IPv6 one
+	for _, p := range i.Targets {
+		bits := byte(p.Bits()) //nolint:gosec // at most 128
+		if p.Addr().Is6() {
+			bits |= 0x80
+		}
+
+		buf = append(buf, bits)
+		buf = append(buf, p.Addr().AsSlice()...)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(buf)
+
+	return mac.Sum(buf), nil
+}
+
+// parseScanIntent returns the intent of payload, checking it is signed with
+// secret. It returns the signature as well, which identifies the intent.
+func parseScanIntent(payload, secret []byte) (ScanIntent, []byte, error) {
+	const fixed = 1 + 1 + 2 + 8 + 4
+
+	if len(payload) < len(scanIntentMagic)+fixed+2+sha256.Size {
+		return ScanIntent{}, nil, fmt.Errorf("%w: %d bytes", ErrMalformedScanIntent, len(payload))
+	}
+
+	signed, sum := payload[:len(payload)-sha256.Size], payload[len(payload)-sha256.Size:]
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(signed)
+
+	if !hmac.Equal(mac.Sum(nil), sum) {
+		return ScanIntent{}, nil, ErrScanIntentSignature
+	}
+
+	buf := signed[len(scanIntentMagic):]
+	if buf[0] != scanIntentVersion {
+		return ScanIntent{}, nil, fmt.Errorf("%w: version %d", ErrMalformedScanIntent, buf[0])
+	}
+
+	var i ScanIntent
+
+	if buf[1]&scanIntentFlagVID != 0 {
+		vid := binary.BigEndian.Uint16(buf[2:4])
+		i.VID = &vid
+	}
+
+	i.Sent = time.Unix(0, int64(binary.BigEndian.Uint64(buf[4:12]))).UTC() //nolint:gosec // signed by a peer
+	i.Window = time.Duration(binary.BigEndian.Uint32(buf[12:16])) * time.Millisecond
+	buf = buf[fixed:]
+
+	n := int(buf[0])
+	if n == 0 || len(buf) < 1+n+1 {
+		return ScanIntent{}, nil, fmt.Errorf("%w: agent of %d bytes", ErrMalformedScanIntent, n)
+	}
+
+	i.Agent = string(buf[1 : 1+n])
+	buf = buf[1+n:]
+
+	count := int(buf[0])
+	buf = buf[1:]
+
+	if count == 0 || count > maxScanIntentTargets {
+		return ScanIntent{}, nil, fmt.Errorf("%w: %d targets", ErrMalformedScanIntent, count)
+	}
+
+	i.Targets = make([]netip.Prefix, 0, count)
+
+	for range count {
+		if len(buf) < 1 {
+			return ScanIntent{}, nil, fmt.Errorf("%w: truncated targets", ErrMalformedScanIntent)
+		}
+
+		bits, size := int(buf[0]), net.IPv4len
+		if bits&0x80 != 0 {
+			bits, size = bits&0x7f, net.IPv6len
+		}
+
+		if len(buf) < 1+size {
+			return ScanIntent{}, nil, fmt.Errorf("%w: truncated targets", ErrMalformedScanIntent)
+		}
+
+		addr, _ := netip.AddrFromSlice(buf[1 : 1+size])
+
+		p, err := addr.Prefix(bits)
+		if err != nil || p.Bits() != bits {
+			return ScanIntent{}, nil, fmt.Errorf("%w: prefix /%d of %s", ErrMalformedScanIntent, bits, addr)
+		}
+
+		i.Targets = append(i.Targets, p)
+		buf = buf[1+size:]
+	}
+
+	if len(buf) > 0 {
+		return ScanIntent{}, nil, fmt.Errorf("%w: %d trailing bytes", ErrMalformedScanIntent, len(buf))
+	}
+
+	return i, sum, nil
+}
+
+// covers returns true if the intent declares a target of job, on its VLAN
+// when both tell theirs
+func (i ScanIntent) covers(job ScanJob) bool {
+	if i.VID != nil && job.VID != nil && *i.VID != *job.VID {
+		return false
+	}
+
+	for _, p := range i.Targets {
+		for _, t := range job.Targets {
+			if p.Overlaps(t) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// same returns true if the intents are those of the same agent for the
+// same targets
+func (i ScanIntent) same(other ScanIntent) bool {
+	sameVID := (i.VID == nil) == (other.VID == nil) && (i.VID == nil || *i.VID == *other.VID)
+
+	return sameVID && i.Agent == other.Agent && slices.Equal(i.Targets, other.Targets)
+}
+
+// ScanDeferral is a run of a job deferred to a peer scanning its targets
+type ScanDeferral struct {
+	// Since is when the job was due, and Until when it is due again
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+	// Peer is the agent the job deferred to
+	Peer string `json:"peer"`
+}
+
+// ScanCoordinatorStats are the counters of a ScanCoordinator
+type ScanCoordinatorStats struct {
+	// Announced is the number of intents sent
+	Announced uint64
+	// Observed is the number of intents of peers accepted
+	Observed uint64
+	// Rejected is the number of intents malformed or not signed with the
+	// shared secret
+	Rejected uint64
+	// Stale and Replayed are the numbers of intents refused for their age
+	// and for having been received already
+	Stale    uint64
+	Replayed uint64
+	// Deferred is the number of runs deferred to a peer
+	Deferred uint64
+	// Peers is the number of intents of peers held
+	Peers int
+}
+
+// scanIntentWriter writes the intents of an interface
+type scanIntentWriter struct {
+	w   capture.FrameWriter
+	mac net.HardwareAddr
+}
+
+// peerIntent is an intent of a peer heard on an interface
+type peerIntent struct {
+	iface string
+	ScanIntent
+}
+
+// ScanCoordinator keeps the agents sharing a segment from scanning the same
+// targets at once. Before a run, the Scheduler announces the targets of the
+// job and how long it expects to scan them, a ScanIntent multicast to
+// ScanIntentMAC from the interface of the job, and the Services give the
+// intents they capture back to the ScanCoordinator. A job due while a peer
+// is scanning its targets, or has scanned them within the deferral period,
+// is deferred until then.
+//
+// The intents are signed with a secret the agents share, an intent which
+// isn't or is older than the drift allowed between the clocks of the agents
+// is refused, as is one already received. An agent which hears no peer, or
+// whose Services don't capture, scans on its own schedule.
+type ScanCoordinator struct {
+	clock clock.Clock
+	// writers are those of the interfaces of the Services capturing
+	writers map[string]scanIntentWriter
+	intents []peerIntent
+	// seen are the signatures of the intents received, by the time they
+	// were sent, which are forgotten once stale
+	seen     map[string]time.Time
+	agent    string
+	secret   []byte
+	stats    ScanCoordinatorStats
+	deferral time.Duration
+	maxAge   time.Duration
+	mu       sync.Mutex
+}
+
+// ScanCoordinatorOption configures a ScanCoordinator
+type ScanCoordinatorOption func(*ScanCoordinator)
+
+// WithScanDeferral sets how long after the window of a peer scanning the
+// targets of a job it is deferred, the interval of the job by default: a
+// peer scanning regularly takes the job over
+func WithScanDeferral(d time.Duration) ScanCoordinatorOption {
+	return func(c *ScanCoordinator) {
+		if d > 0 {
+			c.deferral = d
+		}
+	}
+}
+
+// WithScanIntentMaxAge sets how far from now the time an intent was sent
+// may be, the drift allowed between the clocks of the agents
+func WithScanIntentMaxAge(d time.Duration) ScanCoordinatorOption {
+	return func(c *ScanCoordinator) {
+		if d > 0 {
+			c.maxAge = d
+		}
+	}
+}
+
+// WithScanCoordinatorClock sets the clock the intents are sent and checked
+// with
+func WithScanCoordinatorClock(clk clock.Clock) ScanCoordinatorOption {
+	return func(c *ScanCoordinator) {
+		c.clock = clk
+	}
+}
+
+// NewScanCoordinator returns the ScanCoordinator of the agent, whose intents
+// are signed with secret
+func NewScanCoordinator(agent string, secret []byte, options ...ScanCoordinatorOption) (*ScanCoordinator, error) {
+	if len(secret) == 0 {
+		return nil, ErrScanIntentSecret
+	}
+
+	if agent == "" || len(agent) > maxScanIntentAgent {
+		return nil, fmt.Errorf("%w: agent of %d bytes", ErrMalformedScanIntent, len(agent))
+	}
+
+	c := &ScanCoordinator{
+		clock:   clock.System{},
+		writers: make(map[string]scanIntentWriter),
+		seen:    make(map[string]time.Time),
+		agent:   agent,
+		secret:  slices.Clone(secret),
+		maxAge:  defaultScanIntentMaxAge,
+	}
+
+	for _, opt := range options {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// attach sends the intents of the jobs of iface to w, from mac, until the
+// returned function is called
+func (c *ScanCoordinator) attach(iface string, mac net.HardwareAddr, w capture.FrameWriter) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writer := scanIntentWriter{w: w, mac: mac}
+	c.writers[iface] = writer
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if cur, ok := c.writers[iface]; ok && cur.w == writer.w {
+			delete(c.writers, iface)
+		}
+	}
+}
+
+// announce sends the intent of scanning the targets of job for window, it
+// returns an error matching ErrNotCapturing when no Service captures its
+// interface
+func (c *ScanCoordinator) announce(job ScanJob, window time.Duration) error {
+	c.mu.Lock()
+	w, ok := c.writers[job.Interface]
+	c.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w on %s", ErrNotCapturing, job.Interface)
+	}
+
+	intent := ScanIntent{
+		Sent:    c.clock.Now(),
+		VID:     job.VID,
+		Agent:   c.agent,
+		Targets: job.Targets,
+		Window:  max(window, minScanIntentWindow),
+	}
+
+	payload, err := intent.marshal(c.secret)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, ScanIntentMAC...)
+	frame = append(frame, w.mac...)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(scanIntentEtherType))
+	frame = append(frame, payload...)
+
+	if err := w.w.WriteFrame(frame); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.stats.Announced++
+	c.mu.Unlock()
+
+	return nil
+}
+
+// observe records the intent of payload, the one of a frame of the
+// scanIntentEtherType heard on iface. The payloads of other protocols
+// sharing the ethertype are ignored.
+func (c *ScanCoordinator) observe(iface string, payload []byte) {
+	if !bytes.HasPrefix(payload, scanIntentMagic) {
+		return
+	}
+
+	intent, sum, err := parseScanIntent(payload, c.secret)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+
+	switch {
+	case err != nil:
+		c.stats.Rejected++
+	case intent.Agent == c.agent:
+		return
+	case now.Sub(intent.Sent).Abs() > c.maxAge:
+		c.stats.Stale++
+		err = ErrStaleScanIntent
+	default:
+		if _, ok := c.seen[string(sum)]; ok {
+			c.stats.Replayed++
+			err = ErrReplayedScanIntent
+		}
+	}
+
+	if err != nil {
+		log.Debug().Err(err).Str("interface", iface).Msg("Ignoring scan intent")
+		return
+	}
+
+	c.forget(now)
+
+	c.seen[string(sum)] = intent.Sent
+	c.stats.Observed++
+
+	// the last intent of a peer for the same targets supersedes the others
+	c.intents = slices.DeleteFunc(c.intents, func(i peerIntent) bool {
+		return i.iface == iface && i.same(intent)
+	})
+
+	if len(c.intents) >= maxScanIntents {
+		c.intents = slices.Delete(c.intents, 0, 1)
+	}
+
+	c.intents = append(c.intents, peerIntent{iface: iface, ScanIntent: intent})
+}
+
+// forget drops the signatures of the intents which are stale now, those
+// are refused whether seen or not, c.mu must be held
+func (c *ScanCoordinator) forget(now time.Time) {
+	for sum, sent := range c.seen {
+		if now.Sub(sent).Abs() > c.maxAge {
+			delete(c.seen, sum)
+		}
+	}
+}
+
+// peerScan returns the agent scanning the targets of job and until when the
+// job defers to it, if one announced it did within the deferral period
+func (c *ScanCoordinator) peerScan(job ScanJob, now time.Time) (string, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	period := c.deferral
+	if period == 0 {
+		period = job.Interval
+	}
+
+	var (
+		peer  string
+		until time.Time
+	)
+
+	for _, i := range c.intents {
+		if i.iface != job.Interface || !i.covers(job) {
+			continue
+		}
+
+		if end := i.Sent.Add(i.Window + period); end.After(now) && end.After(until) {
+			peer, until = i.Agent, end
+		}
+	}
+
+	if until.IsZero() {
+		return "", time.Time{}, false
+	}
+
+	c.stats.Deferred++
+
+	return peer, until, true
+}
+
+// Stats returns the counters of the ScanCoordinator
+func (c *ScanCoordinator) Stats() ScanCoordinatorStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.stats
+	st.Peers = len(c.intents)
+
+	return st
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+var (
+	testScanSecret = []byte("shared secret")
+	testPeerMAC    = net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x0b}
+)
+
+func testScanJob() ScanJob {
+	return ScanJob{
+		ID:        "a",
+		Interface: "eth0",
+		VID:       uint16Pointer(10),
+		Targets:   []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+		Interval:  time.Hour,
+	}
+}
+
+// newTestCoordinator returns the ScanCoordinator of agent on clk
+func newTestCoordinator(t *testing.T, agent string, clk *clocktest.Fake) *ScanCoordinator {
+	t.Helper()
+
+	c, err := NewScanCoordinator(agent, testScanSecret, WithScanCoordinatorClock(clk))
+	require.NoError(t, err)
+
+	return c
+}
+
+// peerIntentFrame returns the frame of the intent of the peer scanning job
+// on the interface of the job
+func peerIntentFrame(t *testing.T, clk *clocktest.Fake, job ScanJob, window time.Duration) []byte {
+	t.Helper()
+
+	peer := newTestCoordinator(t, "rack-b", clk)
+	w := &recordingWriter{}
+
+	defer peer.attach(job.Interface, testPeerMAC, w)()
+
+	require.NoError(t, peer.announce(job, window))
+
+	written := w.written()
+	require.Len(t, written, 1)
+
+	return []byte(written[0])
+}
+
+func TestScanIntentMarshal(t *testing.T) {
+	t.Parallel()
+
+	intent := ScanIntent{
+		Sent:  schedulerEpoch,
+		VID:   uint16Pointer(10),
+		Agent: "rack-a",
+		Targets: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/24"),
+			netip.MustParsePrefix("2001:db8::/120"),
+		},
+		Window: 90 * time.Second,
+	}
+
+	payload, err := intent.marshal(testScanSecret)
+	require.NoError(t, err)
+
+	got, sum, err := parseScanIntent(payload, testScanSecret)
+	require.NoError(t, err)
+	assert.Len(t, sum, 32)
+	assert.Equal(t, intent, got)
+
+	_, _, err = parseScanIntent(payload, []byte("another secret"))
+	require.ErrorIs(t, err, ErrScanIntentSignature)
+
+	_, _, err = parseScanIntent(payload[:20], testScanSecret)
+	require.ErrorIs(t, err, ErrMalformedScanIntent)
+
+	_, err = ScanIntent{Agent: "rack-a"}.marshal(testScanSecret)
+	require.ErrorIs(t, err, ErrMalformedScanIntent)
+
+	_, err = NewScanCoordinator("rack-a", nil)
+	require.ErrorIs(t, err, ErrScanIntentSecret)
+}
+
+func TestScanCoordinatorObserve(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	c := newTestCoordinator(t, "rack-a", clk)
+	job := testScanJob()
+
+	frame := peerIntentFrame(t, clk, job, 0)
+	assert.Equal(t, ScanIntentMAC, net.HardwareAddr(frame[:6]))
+	assert.Equal(t, testPeerMAC, net.HardwareAddr(frame[6:12]))
+
+	c.observe("eth0", frame[14:])
+
+	// the peer scans for the minimum window, the job defers for an
+	// interval past it
+	peer, until, ok := c.peerScan(job, schedulerEpoch)
+	require.True(t, ok)
+	assert.Equal(t, "rack-b", peer)
+	assert.Equal(t, schedulerEpoch.Add(minScanIntentWindow+time.Hour), until)
+
+	// a replay is refused, whatever its age
+	c.observe("eth0", frame[14:])
+
+	// an intent of another secret, or sent too long ago, is refused
+	other, err := NewScanCoordinator("rack-c", []byte("another secret"), WithScanCoordinatorClock(clk))
+	require.NoError(t, err)
+
+	w := &recordingWriter{}
+	defer other.attach("eth0", testPeerMAC, w)()
+
+	require.NoError(t, other.announce(job, 0))
+	c.observe("eth0", []byte(w.written()[0])[14:])
+
+	clk.Advance(defaultScanIntentMaxAge + time.Second)
+
+	c.observe("eth0", peerIntentFrame(t, clocktest.NewFake(schedulerEpoch), job, 0)[14:])
+
+	// the intents of the agent itself, and the self-test probes sharing
+	// the ethertype, are ignored
+	own := newTestCoordinator(t, "rack-a", clk)
+	w = &recordingWriter{}
+	defer own.attach("eth0", testPeerMAC, w)()
+
+	require.NoError(t, own.announce(job, 0))
+	c.observe("eth0", []byte(w.written()[0])[14:])
+	c.observe("eth0", []byte("maas-selftest"))
+
+	assert.Equal(t, ScanCoordinatorStats{Observed: 1, Rejected: 1, Stale: 1, Replayed: 1, Deferred: 1, Peers: 1},
+		c.Stats())
+}
+
+func TestScanCoordinatorPeerScan(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	job := testScanJob()
+
+	c, err := NewScanCoordinator("rack-a", testScanSecret, WithScanCoordinatorClock(clk),
+		WithScanDeferral(10*time.Minute))
+	require.NoError(t, err)
+
+	c.observe("eth0", peerIntentFrame(t, clk, job, 5*time.Minute)[14:])
+
+	testcases := map[string]struct {
+		change func(*ScanJob)
+		at     time.Duration
+		ok     bool
+	}{
+		"same targets":      {ok: true},
+		"overlapping":       {change: func(j *ScanJob) { j.Targets[0] = netip.MustParsePrefix("10.0.0.128/25") }, ok: true},
+		"untagged":          {change: func(j *ScanJob) { j.VID = nil }, ok: true},
+		"within the period": {at: 14 * time.Minute, ok: true},
+		"past the period":   {at: 15 * time.Minute},
+		"other targets":     {change: func(j *ScanJob) { j.Targets[0] = netip.MustParsePrefix("10.0.1.0/24") }},
+		"other VLAN":        {change: func(j *ScanJob) { j.VID = uint16Pointer(20) }},
+		"other interface":   {change: func(j *ScanJob) { j.Interface = "eth1" }},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			j := testScanJob()
+			if tc.change != nil {
+				tc.change(&j)
+			}
+
+			peer, until, ok := c.peerScan(j, schedulerEpoch.Add(tc.at))
+			require.Equal(t, tc.ok, ok)
+
+			if ok {
+				assert.Equal(t, "rack-b", peer)
+				assert.Equal(t, schedulerEpoch.Add(15*time.Minute), until)
+			}
+		})
+	}
+
+}
+
+func TestScanCoordinatorSupersede(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	c := newTestCoordinator(t, "rack-a", clk)
+	job := testScanJob()
+
+	c.observe("eth0", peerIntentFrame(t, clk, job, 5*time.Minute)[14:])
+
+	// the last intent of the peer supersedes the previous one
+	clk.Advance(20 * time.Minute)
+	c.observe("eth0", peerIntentFrame(t, clk, job, 5*time.Minute)[14:])
+
+	_, until, ok := c.peerScan(job, clk.Now())
+	require.True(t, ok)
+	assert.Equal(t, schedulerEpoch.Add(85*time.Minute), until)
+	assert.Equal(t, 1, c.Stats().Peers)
+}
+
+func TestSchedulerDefersToPeer(t *testing.T) {
+	defer leak.Check(t)()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	c := newTestCoordinator(t, "rack-a", clk)
+	job := testScanJob()
+	scan, calls := recordingScan()
+
+	w := &recordingWriter{}
+	defer c.attach("eth0", testPeerMAC, w)()
+
+	c.observe("eth0", peerIntentFrame(t, clk, job, 0)[14:])
+
+	s := NewScheduler(WithSchedulerClock(clk), WithScanFunc(scan), WithScanJitter(0),
+		WithSchedulerCoordinator(c))
+	require.NoError(t, s.Add(job))
+
+	stop := startScheduler(t, s)
+	defer stop()
+
+	// the job due on start defers to the peer
+	until := schedulerEpoch.Add(minScanIntentWindow + time.Hour)
+
+	clk.BlockUntil(1)
+
+	st := s.Status()[0]
+	assert.Equal(t, until, st.NextRun)
+	assert.Equal(t, &ScanDeferral{Since: schedulerEpoch, Until: until, Peer: "rack-b"}, st.Deferred)
+	assert.Empty(t, w.written())
+
+	// a triggered run doesn't wait, and announces itself
+	require.NoError(t, s.Trigger("a"))
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), <-calls)
+
+	clk.BlockUntil(1)
+	assert.Nil(t, s.Status()[0].Deferred)
+	require.Len(t, w.written(), 1)
+
+	// due at its interval, the job defers until the peer is done, the
+	// peer isn't heard anymore
+	clk.Advance(time.Hour)
+	clk.BlockUntil(1)
+	assert.Equal(t, until, s.Status()[0].NextRun)
+
+	clk.Advance(minScanIntentWindow)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), <-calls)
+
+	clk.BlockUntil(1)
+	assert.Equal(t, uint64(2), c.Stats().Announced)
+}
+
+func TestServiceScanIntents(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	c := newTestCoordinator(t, "rack-a", clk)
+	svc := NewService("eth0", WithScanCoordinator(c), WithClock(clk))
+	job := testScanJob()
+
+	filter, err := svc.captureFilter()
+	require.NoError(t, err)
+
+	vm, err := bpf.NewVM(disassemble(t, filter))
+	require.NoError(t, err)
+
+	frame := peerIntentFrame(t, clk, job, 0)
+
+	n, err := vm.Run(frame)
+	require.NoError(t, err)
+	assert.Equal(t, layerSnapLen, n)
+
+	// the intents are observed untagged or tagged, and reported as nothing
+	tagged := append(append(append([]byte{}, frame[:12]...), 0x81, 0x00, 0x00, 0x0a), frame[12:]...)
+
+	n, err = vm.Run(tagged)
+	require.NoError(t, err)
+	assert.Equal(t, layerSnapLen, n)
+
+	for _, f := range [][]byte{frame, tagged} {
+		res, err := svc.handleFrame(f, capture.Metadata{Length: len(f)})
+		require.NoError(t, err)
+		assert.Empty(t, res)
+	}
+
+	assert.Equal(t, uint64(1), c.Stats().Observed)
+	assert.Equal(t, uint64(1), c.Stats().Replayed)
+}
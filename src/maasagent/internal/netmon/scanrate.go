@@ -0,0 +1,323 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+const (
+	defaultScanRateInitial  = 50
+	defaultScanRateFloor    = 10
+	defaultScanRateCeiling  = 1000
+	defaultScanRateIncrease = 25
+	defaultScanRateBackoff  = 0.5
+	// defaultMaxRetryRate lets one reply in ten answer a retry before the
+	// probes are deemed lost
+	defaultMaxRetryRate   = 0.1
+	defaultScanRateWindow = time.Second
+	defaultScanRetries    = 2
+)
+
+// RateReason tells why the rate of a scan changed
+type RateReason string
+
+const (
+	// RateStart is the rate the scan started at
+	RateStart RateReason = "start"
+	// RateIncrease is an increase after a window without lost probes nor
+	// kernel drops
+	RateIncrease RateReason = "increase"
+	// RateRetries is a backoff after too many replies answered a retry,
+	// their first probe or its reply having been lost
+	RateRetries RateReason = "retries"
+	// RateKernelDrops is a backoff after the kernel dropped too many of
+	// the captured frames
+	RateKernelDrops RateReason = "kernel_drops"
+)
+
+// ScanRateConfig configures the adaptive rate of the probes of a scan,
+// which increases by Increase every Window while the probes are answered
+// at the first attempt and the kernel keeps up with the replies, and is
+// multiplied by Backoff otherwise, between Floor and Ceiling. A zero field
+// keeps its default, see DefaultScanRate.
+type ScanRateConfig struct {
+	// Initial is the rate a scan starts at, in probes per second
+	Initial float64
+	// Floor and Ceiling bound the rate, in probes per second
+	Floor   float64
+	Ceiling float64
+	// Increase is added to the rate after a calm window
+	Increase float64
+	// Backoff multiplies the rate after a window with lost probes or
+	// kernel drops, in (0, 1)
+	Backoff float64
+	// MaxRetryRate is the fraction of the replies of a window which may
+	// answer a retry
+	MaxRetryRate float64
+	// MaxDrops is the number of frames the kernel may drop during a window
+	MaxDrops uint64
+	// Window is the time between two adjustments of the rate
+	Window time.Duration
+	// Retries is the number of times the addresses which didn't reply are
+	// probed again
+	Retries int
+}
+
+// DefaultScanRate returns the ScanRateConfig of the fields left to zero
+func DefaultScanRate() ScanRateConfig {
+	return ScanRateConfig{
+		Initial:      defaultScanRateInitial,
+		Floor:        defaultScanRateFloor,
+		Ceiling:      defaultScanRateCeiling,
+		Increase:     defaultScanRateIncrease,
+		Backoff:      defaultScanRateBackoff,
+		MaxRetryRate: defaultMaxRetryRate,
+		Window:       defaultScanRateWindow,
+		Retries:      defaultScanRetries,
+	}
+}
+
+// normalize returns c with the defaults of its zero fields, a Backoff out
+// of (0, 1) reset to its default and the rates within Floor and Ceiling
+func (c ScanRateConfig) normalize() ScanRateConfig {
+	d := DefaultScanRate()
+
+	if c.Floor <= 0 {
+		c.Floor = d.Floor
+	}
+
+	if c.Ceiling <= 0 {
+		c.Ceiling = max(d.Ceiling, c.Floor)
+	}
+
+	if c.Ceiling < c.Floor {
+		c.Ceiling = c.Floor
+	}
+
+	if c.Initial <= 0 {
+		c.Initial = d.Initial
+	}
+
+	c.Initial = min(max(c.Initial, c.Floor), c.Ceiling)
+
+	if c.Increase <= 0 {
+		c.Increase = d.Increase
+	}
+
+	if c.Backoff <= 0 || c.Backoff >= 1 {
+		c.Backoff = d.Backoff
+	}
+
+	if c.MaxRetryRate <= 0 {
+		c.MaxRetryRate = d.MaxRetryRate
+	}
+
+	if c.Window <= 0 {
+		c.Window = d.Window
+	}
+
+	if c.Retries <= 0 {
+		c.Retries = d.Retries
+	}
+
+	return c
+}
+
+// RateAdjustment is a change of the rate of a scan
+type RateAdjustment struct {
+	// Time is when the rate changed
+	Time   time.Time  `json:"time"`
+	Reason RateReason `json:"reason"`
+	// Rate is the rate from then on, in probes per second
+	Rate float64 `json:"rate"`
+	// RetryRate is the fraction of the replies of the previous window
+	// which answered a retry
+	RetryRate float64 `json:"retry_rate,omitempty"`
+	// Drops is the number of frames the kernel dropped during the
+	// previous window
+	Drops uint64 `json:"drops,omitempty"`
+}
+
+// AdaptiveRate paces the probes of a scan in the manner of a congestion
+// control: the rate grows additively while the replies come at the first
+// attempt and the kernel doesn't drop frames, and shrinks multiplicatively
+// when they don't. It keeps the trajectory of the rate, every adjustment
+// with its reason.
+type AdaptiveRate struct {
+	clock   clock.Clock
+	limiter *ProbeLimiter
+	// stats are the counters of the capture of the replies, if known
+	stats       capture.StatsSource
+	windowStart time.Time
+	trajectory  []RateAdjustment
+	cfg         ScanRateConfig
+	rate        float64
+	drops       uint64
+	replies     int
+	retried     int
+	mu          sync.Mutex
+}
+
+// AdaptiveRateOption configures an AdaptiveRate
+type AdaptiveRateOption func(*AdaptiveRate)
+
+// WithAdaptiveRateClock sets the clock the windows and the probes are timed
+// with
+func WithAdaptiveRateClock(c clock.Clock) AdaptiveRateOption {
+	return func(a *AdaptiveRate) {
+		a.clock = c
+	}
+}
+
+// NewAdaptiveRate returns an AdaptiveRate starting at the Initial rate of
+// cfg
+func NewAdaptiveRate(cfg ScanRateConfig, options ...AdaptiveRateOption) *AdaptiveRate {
+	a := &AdaptiveRate{clock: clock.System{}, cfg: cfg.normalize()}
+
+	for _, opt := range options {
+		opt(a)
+	}
+
+	a.rate = a.cfg.Initial
+	a.limiter = NewProbeLimiter(a.rate, 1, WithProbeLimiterClock(a.clock))
+	a.windowStart = a.clock.Now()
+	a.trajectory = []RateAdjustment{{Time: a.windowStart, Reason: RateStart, Rate: a.rate}}
+
+	return a
+}
+
+// Wait adjusts the rate once a window elapsed, then blocks until the next
+// probe can be sent or returns ctx.Err() when ctx is done first
+func (a *AdaptiveRate) Wait(ctx context.Context) error {
+	a.adjust()
+
+	return a.limiter.Wait(ctx)
+}
+
+// Reply counts a reply to a probe, retried when it answered a retry
+func (a *AdaptiveRate) Reply(retried bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.replies++
+
+	if retried {
+		a.retried++
+	}
+}
+
+// Rate returns the current rate, in probes per second
+func (a *AdaptiveRate) Rate() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.rate
+}
+
+// Trajectory returns the adjustments of the rate, the first being the
+// start of the scan
+func (a *AdaptiveRate) Trajectory() []RateAdjustment {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return slices.Clone(a.trajectory)
+}
+
+// retries returns the number of times an address is probed again
+func (a *AdaptiveRate) retries() int {
+	return a.cfg.Retries
+}
+
+// watch counts the frames the kernel drops from the capture of stats from
+// now on
+func (a *AdaptiveRate) watch(stats capture.StatsSource) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.stats = stats
+	a.drops = a.readDrops()
+}
+
+// readDrops returns the frames the kernel dropped since the capture
+// started, or those of the last reading when they can't be read, a.mu must
+// be held
+func (a *AdaptiveRate) readDrops() uint64 {
+	if a.stats == nil {
+		return a.drops
+	}
+
+	st, err := a.stats.Stats()
+	if err != nil {
+		log.Debug().Err(err).Msg("Adapting the scan rate without the kernel drops")
+
+		return a.drops
+	}
+
+	return st.Drops
+}
+
+// adjust changes the rate from what happened during the window, once it
+// elapsed
+func (a *AdaptiveRate) adjust() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.clock.Now()
+	if now.Sub(a.windowStart) < a.cfg.Window {
+		return
+	}
+
+	drops := a.readDrops()
+	adj := RateAdjustment{Time: now, Drops: drops - a.drops}
+
+	if a.replies > 0 {
+		adj.RetryRate = float64(a.retried) / float64(a.replies)
+	}
+
+	a.windowStart = now
+	a.drops = drops
+	a.replies, a.retried = 0, 0
+
+	switch {
+	case adj.Drops > a.cfg.MaxDrops:
+		adj.Reason = RateKernelDrops
+		adj.Rate = max(a.rate*a.cfg.Backoff, a.cfg.Floor)
+	case adj.RetryRate > a.cfg.MaxRetryRate:
+		adj.Reason = RateRetries
+		adj.Rate = max(a.rate*a.cfg.Backoff, a.cfg.Floor)
+	default:
+		adj.Reason = RateIncrease
+		adj.Rate = min(a.rate+a.cfg.Increase, a.cfg.Ceiling)
+	}
+
+	// the rate held at a bound isn't an adjustment
+	if adj.Rate == a.rate {
+		return
+	}
+
+	a.rate = adj.Rate
+	a.limiter.setRate(a.rate, 1)
+	a.trajectory = append(a.trajectory, adj)
+}
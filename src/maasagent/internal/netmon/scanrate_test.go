@@ -0,0 +1,313 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+// dropCounter is a capture.StatsSource of a settable number of drops
+type dropCounter struct {
+	err   error
+	drops uint64
+	mu    sync.Mutex
+}
+
+func (c *dropCounter) Stats() (capture.Stats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return capture.Stats{Drops: c.drops}, c.err
+}
+
+func (c *dropCounter) drop(n uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.drops += n
+	c.err = err
+}
+
+func TestScanRateConfigNormalize(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  ScanRateConfig
+		out ScanRateConfig
+	}{
+		"defaults": {
+			out: DefaultScanRate(),
+		},
+		"initial above the ceiling": {
+			in: ScanRateConfig{Initial: 500, Ceiling: 200},
+			out: ScanRateConfig{Initial: 200, Floor: 10, Ceiling: 200, Increase: 25, Backoff: 0.5,
+				MaxRetryRate: 0.1, Window: time.Second, Retries: 2},
+		},
+		"floor above the default ceiling": {
+			in: ScanRateConfig{Floor: 2000, Backoff: 1},
+			out: ScanRateConfig{Initial: 2000, Floor: 2000, Ceiling: 2000, Increase: 25, Backoff: 0.5,
+				MaxRetryRate: 0.1, Window: time.Second, Retries: 2},
+		},
+		"ceiling below the floor": {
+			in: ScanRateConfig{Floor: 100, Ceiling: 50, MaxDrops: 3},
+			out: ScanRateConfig{Initial: 100, Floor: 100, Ceiling: 100, Increase: 25, Backoff: 0.5,
+				MaxRetryRate: 0.1, MaxDrops: 3, Window: time.Second, Retries: 2},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.in.normalize())
+		})
+	}
+}
+
+func TestAdaptiveRate(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1700000000, 0)
+	clk := clocktest.NewFake(start)
+	stats := &dropCounter{}
+	ctx := context.Background()
+
+	a := NewAdaptiveRate(ScanRateConfig{Initial: 100, Floor: 40, Ceiling: 160, Increase: 50, MaxDrops: 2},
+		WithAdaptiveRateClock(clk))
+	a.watch(stats)
+
+	// window is a window of the scan, with its replies and drops
+	window := func(replies, retried int, drops uint64) {
+		for i := range replies {
+			a.Reply(i < retried)
+		}
+
+		if drops > 0 {
+			stats.drop(drops, nil)
+		}
+
+		clk.Advance(time.Second)
+		require.NoError(t, a.Wait(ctx))
+	}
+
+	require.NoError(t, a.Wait(ctx))
+
+	// no reply tells nothing of a loss
+	window(0, 0, 0)
+	window(10, 1, 2)
+	// the ceiling bounds the increase, and the rate held at it isn't an
+	// adjustment
+	window(10, 0, 0)
+	window(20, 3, 0)
+	window(10, 0, 3)
+	window(10, 0, 100)
+
+	// the drops which can't be read don't count
+	stats.drop(100, errors.New("closed"))
+	window(0, 0, 0)
+
+	at := func(n int) time.Time { return start.Add(time.Duration(n) * time.Second) }
+
+	assert.Equal(t, []RateAdjustment{
+		{Time: at(0), Reason: RateStart, Rate: 100},
+		{Time: at(1), Reason: RateIncrease, Rate: 150},
+		{Time: at(2), Reason: RateIncrease, Rate: 160, RetryRate: 0.1, Drops: 2},
+		{Time: at(4), Reason: RateRetries, Rate: 80, RetryRate: 0.15},
+		{Time: at(5), Reason: RateKernelDrops, Rate: 40, Drops: 3},
+		{Time: at(7), Reason: RateIncrease, Rate: 90},
+	}, a.Trajectory())
+	assert.InDelta(t, 90, a.Rate(), 0)
+}
+
+func TestAdaptiveRatePacing(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	a := NewAdaptiveRate(ScanRateConfig{Initial: 10}, WithAdaptiveRateClock(clk))
+	ctx := context.Background()
+
+	require.NoError(t, a.Wait(ctx))
+
+	done := make(chan error)
+
+	go func() {
+		done <- a.Wait(ctx)
+	}()
+
+	// the probes are 100ms apart at 10 per second
+	clk.BlockUntil(1)
+	clk.Advance(99 * time.Millisecond)
+
+	select {
+	case <-done:
+		t.Fatal("probe sent before its interval")
+	default:
+	}
+
+	clk.Advance(time.Millisecond)
+	require.NoError(t, <-done)
+
+	// the rate changes with the window
+	clk.Advance(time.Second)
+	require.NoError(t, a.Wait(ctx))
+
+	go func() {
+		done <- a.Wait(ctx)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(29 * time.Millisecond)
+	require.NoError(t, <-done)
+	assert.InDelta(t, 35, a.Rate(), 0)
+}
+
+// lossyLink is a ProbeConn losing the first ARP request of every address,
+// and answering the next ones
+type lossyLink struct {
+	interrupt chan struct{}
+	frames    chan []byte
+	probed    map[netip.Addr]int
+	mu        sync.Mutex
+}
+
+func newLossyLink() *lossyLink {
+	return &lossyLink{
+		interrupt: make(chan struct{}),
+		frames:    make(chan []byte, 16),
+		probed:    make(map[netip.Addr]int),
+	}
+}
+
+func (l *lossyLink) ReadFrame(buf []byte) (int, error) {
+	md, err := l.ReadFrameMetadata(buf)
+
+	return md.CaptureLength, err
+}
+
+func (l *lossyLink) ReadFrameMetadata(buf []byte) (capture.Metadata, error) {
+	l.mu.Lock()
+	interrupt := l.interrupt
+	l.mu.Unlock()
+
+	select {
+	case frame := <-l.frames:
+		n := copy(buf, frame)
+
+		return capture.Metadata{CaptureLength: n, Length: len(frame)}, nil
+	case <-interrupt:
+		return capture.Metadata{}, os.ErrDeadlineExceeded
+	}
+}
+
+func (l *lossyLink) SetReadDeadline(t time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if t.IsZero() {
+		l.interrupt = make(chan struct{})
+	} else {
+		close(l.interrupt)
+	}
+
+	return nil
+}
+
+func (l *lossyLink) WriteFrame(frame []byte) error {
+	var eth ethernet.EthernetFrame
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return err
+	}
+
+	pkt, err := eth.ExtractARPPacket()
+	if err != nil {
+		return err
+	}
+
+	target := pkt.TargetAddr()
+
+	l.mu.Lock()
+	l.probed[target]++
+	lost := l.probed[target] == 1
+	l.mu.Unlock()
+
+	if lost {
+		return nil
+	}
+
+	reply, err := ethernet.NewFrame().Src(testPXEClient).Dst(testRackMAC).Padded().
+		ARPReply(target, testRackMAC, pkt.SenderAddr()).Build()
+	if err != nil {
+		return err
+	}
+
+	l.frames <- reply
+
+	return nil
+}
+
+func (l *lossyLink) Interface() *net.Interface {
+	return &net.Interface{Name: "eth0", HardwareAddr: testRackMAC}
+}
+
+func (l *lossyLink) Close() error { return nil }
+
+func TestScanThroughRetries(t *testing.T) {
+	t.Parallel()
+
+	src := netip.MustParseAddr("10.0.12.1")
+	targets := []netip.Addr{netip.MustParseAddr("10.0.12.5"), netip.MustParseAddr("10.0.12.6")}
+
+	// without an adaptive rate the addresses are probed once
+	l := newLossyLink()
+
+	result, err := scanThrough(context.Background(), l, src, nil, targets, 10*time.Millisecond, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{targets[0]: nil, targets[1]: nil}, result)
+
+	l = newLossyLink()
+	pace := NewAdaptiveRate(ScanRateConfig{Initial: 1000, Ceiling: 1000})
+
+	var probes probeCounter
+
+	result, err = scanThrough(context.Background(), l, src, nil, targets, 100*time.Millisecond, &probes, pace)
+	require.NoError(t, err)
+	assert.Equal(t, map[netip.Addr]net.HardwareAddr{targets[0]: testPXEClient, targets[1]: testPXEClient}, result)
+	assert.Equal(t, map[netip.Addr]int{targets[0]: 2, targets[1]: 2}, l.probed)
+	assert.Equal(t, ProbeStats{Replies: 2}, probes.stats())
+
+	pace.mu.Lock()
+	defer pace.mu.Unlock()
+
+	// both replies answered a retry
+	assert.Equal(t, 2, pace.retried)
+}
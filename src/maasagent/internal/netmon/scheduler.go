@@ -0,0 +1,1113 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/atomicfile"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/netif"
+)
+
+const (
+	defaultScanConcurrency = 1
+	defaultScanJitter      = 0.1
+	// maxScanTargetBits bounds the addresses of a job to those of a /16
+	maxScanTargetBits = 16
+	day               = 24 * time.Hour
+)
+
+var (
+	// ErrUnknownScanJob is returned when no job has the given ID
+	ErrUnknownScanJob = errors.New("unknown scan job")
+	// ErrDuplicateScanJob is returned when adding a job with the ID of
+	// another one
+	ErrDuplicateScanJob = errors.New("scan job already exists")
+	// ErrInvalidScanJob is returned when adding a job which can't be run
+	ErrInvalidScanJob = errors.New("invalid scan job")
+)
+
+// ScanFunc returns the hardware address each of ips replied from, nil for
+// the ones which didn't reply, Scan implements it
+type ScanFunc func(ctx context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error)
+
+// SourceScanFunc is a ScanFunc sending the probes from src, unless invalid,
+// ScanFrom implements it
+type SourceScanFunc func(ctx context.Context, src netip.Addr, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error)
+
+// SourceStreamFunc is a SourceScanFunc calling found with the reply of each
+// host as it comes rather than returning them all at the end, StreamFrom
+// implements it
+type SourceStreamFunc func(ctx context.Context, src netip.Addr, ips []netip.Addr, found func(ScanReply)) error
+
+// SourceSelector picks the address the probes of target are sent from on
+// the link with the given name, or on its VLAN sub-interface vid unless 0,
+// netif.Inventory implements it
+type SourceSelector interface {
+	SourceFor(name string, vid uint16, target netip.Prefix, options ...netif.SourceOption) (netip.Addr, error)
+}
+
+// BlackoutWindow is a daily period during which a job doesn't run. Start
+// and End are offsets from midnight in the location of the clock, a window
+// ending before it starts spans midnight.
+type BlackoutWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// until returns the end of the window if t is within it
+func (w BlackoutWindow) until(t time.Time) (time.Time, bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+
+	switch {
+	case w.Start < w.End && offset >= w.Start && offset < w.End:
+		return midnight.Add(w.End), true
+	case w.Start > w.End && offset >= w.Start:
+		return midnight.AddDate(0, 0, 1).Add(w.End), true
+	case w.Start > w.End && offset < w.End:
+		return midnight.Add(w.End), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// ScanJob is a recurring scan of a set of targets
+type ScanJob struct {
+	// VID is the VLAN the targets are on, if any
+	VID *uint16
+	// ID identifies the job
+	ID string
+	// Interface is the interface the targets are reached from
+	Interface string
+	// Targets are the prefixes scanned, the network and broadcast
+	// addresses of IPv4 prefixes excluded
+	Targets []netip.Prefix
+	// Source is the address the probes are sent from, when invalid it is
+	// selected for the first target with WithSourceSelection
+	Source netip.Addr
+	// Encapsulation are the VLAN tags the probes carry, outermost first,
+	// for the targets only reached through a provider bridge. They are
+	// then probed with ARP, and VID is the innermost tag.
+	Encapsulation []ethernet.Tag
+	// Blackouts are the periods during which the job doesn't run
+	Blackouts []BlackoutWindow
+	// Interval is the time between the start of two runs
+	Interval time.Duration
+	// MissThreshold is the number of consecutive runs a host must not
+	// reply to be reported gone, 0 for the default
+	MissThreshold int
+	// FullRefresh is the number of runs between two reports of every
+	// host, 0 for the default
+	FullRefresh int
+}
+
+// Addresses returns the addresses of the targets, in order
+func (j ScanJob) Addresses() ([]netip.Addr, error) {
+	j, err := j.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []netip.Addr
+
+	for _, p := range j.Targets {
+		p = p.Masked()
+
+		bits := p.Addr().BitLen() - p.Bits()
+		if bits > maxScanTargetBits {
+			return nil, fmt.Errorf("%w: target %s is too large", ErrInvalidScanJob, p)
+		}
+
+		start := len(ips)
+
+		for ip := p.Addr(); ip.IsValid() && p.Contains(ip); ip = ip.Next() {
+			ips = append(ips, ip)
+		}
+
+		if p.Addr().Is4() && bits > 1 {
+			ips = append(ips[:start], ips[start+1:len(ips)-1]...)
+		}
+	}
+
+	slices.SortFunc(ips, netip.Addr.Compare)
+	ips = slices.Compact(ips)
+
+	if len(ips) == 0 || len(ips) > 1<<maxScanTargetBits {
+		return nil, fmt.Errorf("%w: %d targets", ErrInvalidScanJob, len(ips))
+	}
+
+	return ips, nil
+}
+
+// Validate returns the error Scheduler.Add would return for the job, other
+// than ErrDuplicateScanJob
+func (j ScanJob) Validate() error {
+	j, err := j.normalize()
+	if err != nil {
+		return err
+	}
+
+	if err := j.validate(); err != nil {
+		return err
+	}
+
+	_, err = j.Addresses()
+
+	return err
+}
+
+// normalize returns a copy of the job with its addresses in their normal
+// form, an IPv4-mapped target as the IPv4 one it maps, or an error
+// matching ErrInvalidScanJob and the addrutil error of the field
+func (j ScanJob) normalize() (ScanJob, error) {
+	targets := make([]netip.Prefix, 0, len(j.Targets))
+
+	for i, p := range j.Targets {
+		p, err := addrutil.Prefix(addrutil.Index("targets", i), p)
+		if err != nil {
+			return ScanJob{}, fmt.Errorf("%w: %w", ErrInvalidScanJob, err)
+		}
+
+		targets = append(targets, p)
+	}
+
+	// the source is only set to probe from another address than the one
+	// selected, which must be one a host can have
+	if j.Source.IsValid() {
+		src, err := addrutil.UnicastIP("source", j.Source)
+		if err != nil {
+			return ScanJob{}, fmt.Errorf("%w: %w", ErrInvalidScanJob, err)
+		}
+
+		j.Source = src
+	}
+
+	j.Targets = targets
+	j.Blackouts = slices.Clone(j.Blackouts)
+	j.Encapsulation = slices.Clone(j.Encapsulation)
+
+	return j, nil
+}
+
+func (j ScanJob) validate() error {
+	if j.ID == "" {
+		return fmt.Errorf("%w: missing ID", ErrInvalidScanJob)
+	}
+
+	if j.Interval <= 0 {
+		return fmt.Errorf("%w: non-positive interval", ErrInvalidScanJob)
+	}
+
+	if j.MissThreshold < 0 || j.FullRefresh < 0 {
+		return fmt.Errorf("%w: negative report threshold", ErrInvalidScanJob)
+	}
+
+	for _, w := range j.Blackouts {
+		if w.Start < 0 || w.Start >= day || w.End < 0 || w.End > day || w.Start == w.End {
+			return fmt.Errorf("%w: blackout window %s-%s", ErrInvalidScanJob, w.Start, w.End)
+		}
+	}
+
+	return j.validateEncapsulation()
+}
+
+// validateEncapsulation checks the tags of the encapsulation, and that the
+// targets can be probed through it
+func (j ScanJob) validateEncapsulation() error {
+	if len(j.Encapsulation) == 0 {
+		return nil
+	}
+
+	if len(j.Encapsulation) > ethernet.MaxTags {
+		return fmt.Errorf("%w: %d encapsulation tags", ErrInvalidScanJob, len(j.Encapsulation))
+	}
+
+	for _, t := range j.Encapsulation {
+		if t.VID > maxVID || t.Priority > 7 || t.TPID != 0 && !ethernet.IsTPID(t.TPID) {
+			return fmt.Errorf("%w: encapsulation tag %s %d with priority %d", ErrInvalidScanJob, t.TPID, t.VID,
+				t.Priority)
+		}
+	}
+
+	inner := j.Encapsulation[len(j.Encapsulation)-1].VID
+	if j.VID != nil && *j.VID != inner {
+		return fmt.Errorf("%w: VLAN %d isn't the innermost tag %d", ErrInvalidScanJob, *j.VID, inner)
+	}
+
+	for _, p := range j.Targets {
+		if p.Addr().Is6() {
+			return fmt.Errorf("%w: IPv6 target %s can't be probed through an encapsulation", ErrInvalidScanJob, p)
+		}
+	}
+
+	return nil
+}
+
+// outsideBlackouts returns the first time from t outside of every window,
+// overlapping windows are followed until one ends outside of the others
+func (j ScanJob) outsideBlackouts(t time.Time) time.Time {
+	for range len(j.Blackouts) + 1 {
+		moved := false
+
+		for _, w := range j.Blackouts {
+			if end, ok := w.until(t); ok {
+				t, moved = end, true
+			}
+		}
+
+		if !moved {
+			break
+		}
+	}
+
+	return t
+}
+
+// ScanSummary is the outcome of a run
+type ScanSummary struct {
+	// Error is set when the scan failed
+	Error string `json:"error,omitempty"`
+	// Source is the address the probes were sent from, unset when the
+	// kernel picked it
+	Source string `json:"source,omitempty"`
+	// Duration is the time the scan took
+	Duration time.Duration `json:"duration"`
+	// Targets is the number of scanned addresses
+	Targets int `json:"targets"`
+	// Responded is the number of addresses which replied
+	Responded int `json:"responded"`
+	// Rate is the trajectory of the rate of the probes of an adaptive
+	// scan, see WithAdaptiveScanRate
+	Rate []RateAdjustment `json:"rate,omitempty"`
+}
+
+// ScanJobStatus describes the state of a job
+type ScanJobStatus struct {
+	// LastRun is when the job last started, zero if it never ran
+	LastRun time.Time `json:"last_run,omitzero"`
+	// NextRun is when the job is due, zero until the Scheduler runs
+	NextRun time.Time `json:"next_run,omitzero"`
+	// LastResult is the outcome of the last run of this process
+	LastResult *ScanSummary `json:"last_result,omitempty"`
+	VID        *uint16      `json:"vid"`
+	ID         string       `json:"id"`
+	Interface  string       `json:"interface"`
+	// Deferred is set while the job is deferred due to a peer scanning its
+	// targets, see WithSchedulerCoordinator
+	Deferred *ScanDeferral `json:"deferred,omitempty"`
+	Paused   bool          `json:"paused"`
+	Running  bool          `json:"running"`
+}
+
+type scheduledJob struct {
+	next      time.Time
+	lastRun   time.Time
+	last      *ScanSummary
+	deferred  *ScanDeferral
+	targets   []netip.Addr
+	job       ScanJob
+	paused    bool
+	running   bool
+	triggered bool
+}
+
+func (j *scheduledJob) due(now time.Time) bool {
+	if j.running {
+		return false
+	}
+
+	return j.triggered || (!j.paused && !j.next.IsZero() && !j.next.After(now))
+}
+
+// scanState is what is persisted of the jobs so a restart doesn't run
+// all of them at once
+type scanState struct {
+	LastRun map[string]time.Time `json:"last_run"`
+}
+
+// Scheduler runs scan jobs at their interval, with a bounded number of
+// scans at a time. Runs are delayed by a random jitter so the racks
+// started together don't scan together.
+type Scheduler struct {
+	clock   clock.Clock
+	sources SourceSelector
+	scan    SourceScanFunc
+	// encapScan scans the jobs with an encapsulation, when set with
+	// WithEncapsulatedScanFunc
+	encapScan EncapsulatedScanFunc
+	// rate adapts the rate of the default scans with an encapsulation
+	rate    *ScanRateConfig
+	results func(id string, found map[netip.Addr]net.HardwareAddr)
+	reports func(ScanReport)
+	cache   *ScanCache
+	meter   metric.Meter
+	// coordinator announces the runs to the peers, and defers those of
+	// the targets they scan
+	coordinator *ScanCoordinator
+	// gate holds the runs in standby, see WithSchedulerGate
+	gate *TransmitGate
+	jobs map[string]*scheduledJob
+	wake chan struct{}
+	// random returns a number in [0, n), it spreads the runs
+	random    func(n int64) int64
+	stateFile string
+	// sourceOpts configure the selection of the sources
+	sourceOpts  []netif.SourceOption
+	jitter      float64
+	concurrency int
+	running     int
+	started     bool
+	mu          sync.Mutex
+	// saveMu serializes the writes of the state file
+	saveMu sync.Mutex
+	// probes counts the replies of the default scans
+	probes probeCounter
+}
+
+// SchedulerOption configures a Scheduler
+type SchedulerOption func(*Scheduler)
+
+// WithScanFunc sets the function scanning the targets, which leaves the
+// source of the probes to the kernel. ScanFrom is the default.
+func WithScanFunc(f ScanFunc) SchedulerOption {
+	return func(s *Scheduler) {
+		s.scan = func(ctx context.Context, _ netip.Addr, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+			return f(ctx, ips)
+		}
+	}
+}
+
+// WithSourceScanFunc sets the function scanning the targets from the source
+// of the job, ScanFrom by default
+func WithSourceScanFunc(f SourceScanFunc) SchedulerOption {
+	return func(s *Scheduler) {
+		s.scan = f
+	}
+}
+
+// WithEncapsulatedScanFunc sets the function scanning the targets of the
+// jobs with an Encapsulation, ScanThrough by default
+func WithEncapsulatedScanFunc(f EncapsulatedScanFunc) SchedulerOption {
+	return func(s *Scheduler) {
+		s.encapScan = f
+	}
+}
+
+// WithAdaptiveScanRate paces the ARP probes of the jobs with an
+// Encapsulation with an AdaptiveRate configured by cfg, the addresses which
+// didn't reply being probed again. The trajectory of the rate is in the
+// ScanSummary of the runs. Only the default scans adapt, not those of
+// WithEncapsulatedScanFunc.
+func WithAdaptiveScanRate(cfg ScanRateConfig) SchedulerOption {
+	return func(s *Scheduler) {
+		s.rate = &cfg
+	}
+}
+
+// WithSourceSelection selects the source of the jobs without one from the
+// addresses of their interface. Without it the kernel picks the source,
+// which may be on another subnet of a multi-homed interface.
+func WithSourceSelection(sel SourceSelector, options ...netif.SourceOption) SchedulerOption {
+	return func(s *Scheduler) {
+		s.sources = sel
+		s.sourceOpts = options
+	}
+}
+
+// WithScanConcurrency sets the number of jobs running at the same time
+func WithScanConcurrency(n int) SchedulerOption {
+	return func(s *Scheduler) {
+		if n > 0 {
+			s.concurrency = n
+		}
+	}
+}
+
+// WithScanJitter sets the delay added to a run, as a maximum fraction of
+// the interval of its job. 0 runs the jobs exactly at their interval.
+func WithScanJitter(fraction float64) SchedulerOption {
+	return func(s *Scheduler) {
+		if fraction >= 0 {
+			s.jitter = fraction
+		}
+	}
+}
+
+// WithSchedulerClock sets the clock timing the runs
+func WithSchedulerClock(c clock.Clock) SchedulerOption {
+	return func(s *Scheduler) {
+		s.clock = c
+	}
+}
+
+// WithScanStateFile persists the last run of the jobs in path, the jobs
+// added back after a restart keep their schedule
+func WithScanStateFile(path string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.stateFile = path
+	}
+}
+
+// WithScanResults calls f with the addresses found by each run
+func WithScanResults(f func(id string, found map[netip.Addr]net.HardwareAddr)) SchedulerOption {
+	return func(s *Scheduler) {
+		s.results = f
+	}
+}
+
+// WithScanReports calls f with the changes found by each run, and with
+// every host on a periodic full refresh
+func WithScanReports(f func(ScanReport)) SchedulerOption {
+	return func(s *Scheduler) {
+		s.reports = f
+	}
+}
+
+// WithSchedulerMeter sets the OpenTelemetry meter collecting the replies
+// to the probes of the scans, those of a scan and the unsolicited ones.
+// Only the default scans count them, not those of WithScanFunc,
+// WithSourceScanFunc or WithEncapsulatedScanFunc.
+func WithSchedulerMeter(meter metric.Meter) SchedulerOption {
+	return func(s *Scheduler) {
+		s.meter = meter
+	}
+}
+
+// WithSchedulerCoordinator announces the runs of the jobs through c, and
+// defers those of the jobs whose targets a peer scans, unless triggered.
+// The Services of the interfaces of the jobs must share c to send and
+// receive the intents, see WithScanCoordinator.
+func WithSchedulerCoordinator(c *ScanCoordinator) SchedulerOption {
+	return func(s *Scheduler) {
+		s.coordinator = c
+	}
+}
+
+// WithSchedulerGate runs the jobs only while g is active, their runs wait
+// for its promotion and a demotion cancels those running, which run again
+// once promoted
+func WithSchedulerGate(g *TransmitGate) SchedulerOption {
+	return func(s *Scheduler) {
+		s.gate = g
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// NewScheduler returns a Scheduler without jobs
+func NewScheduler(options ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		clock:       clock.System{},
+		jobs:        make(map[string]*scheduledJob),
+		wake:        make(chan struct{}, 1),
+		random:      rand.Int64N, //nolint:gosec // the jitter isn't security sensitive
+		cache:       NewScanCache(),
+		jitter:      defaultScanJitter,
+		concurrency: defaultScanConcurrency,
+	}
+
+	s.scan = s.scanFrom
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	if s.meter != nil {
+		s.registerMetrics(s.meter)
+	}
+
+	return s
+}
+
+func (s *Scheduler) registerMetrics(meter metric.Meter) {
+	solicited := attribute.String("type", "solicited")
+	unsolicited := attribute.String("type", "unsolicited")
+
+	must(meter.Int64ObservableCounter("netmon.scan.replies",
+		metric.WithUnit("{count}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			st := s.ProbeStats()
+			o.Observe(int64(st.Replies), metric.WithAttributes(solicited))       //nolint:gosec // counters fit
+			o.Observe(int64(st.Unsolicited), metric.WithAttributes(unsolicited)) //nolint:gosec // counters fit
+
+			return nil
+		})))
+}
+
+// ProbeStats returns the replies to the probes of the default scans, a
+// growing count of unsolicited replies tells of another agent scanning the
+// same segments
+func (s *Scheduler) ProbeStats() ProbeStats {
+	return s.probes.stats()
+}
+
+// scanFrom is ScanFrom counting the replies, timed with the clock of the
+// Scheduler
+func (s *Scheduler) scanFrom(ctx context.Context, src netip.Addr,
+	ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+	return NewScanner(WithScanClock(s.clock)).scanFrom(ctx, src, ips, &s.probes)
+}
+
+// scanThrough is ScanThrough counting the replies, and adapting the rate
+// of the probes with WithAdaptiveScanRate. It returns the trajectory of the
+// rate, if adapted.
+func (s *Scheduler) scanThrough(ctx context.Context, iface string, src netip.Addr, path []ethernet.Tag,
+	ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, []RateAdjustment, error) {
+	if s.rate == nil {
+		found, err := scanThroughIface(ctx, iface, src, path, ips, &s.probes, nil)
+
+		return found, nil, err
+	}
+
+	pace := NewAdaptiveRate(*s.rate, WithAdaptiveRateClock(s.clock))
+	found, err := scanThroughIface(ctx, iface, src, path, ips, &s.probes, pace)
+
+	return found, pace.Trajectory(), err
+}
+
+// Add adds a job, it is scheduled once the Scheduler runs
+func (s *Scheduler) Add(job ScanJob) error {
+	job, err := job.normalize()
+	if err != nil {
+		return err
+	}
+
+	if err := job.validate(); err != nil {
+		return err
+	}
+
+	targets, err := job.Addresses()
+	if err != nil {
+		return err
+	}
+
+	// the hosts found through an encapsulation are on its innermost VLAN
+	if len(job.Encapsulation) > 0 && job.VID == nil {
+		vid := job.Encapsulation[len(job.Encapsulation)-1].VID
+		job.VID = &vid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.ID]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateScanJob, job.ID)
+	}
+
+	j := &scheduledJob{job: job, targets: targets}
+	s.jobs[job.ID] = j
+
+	if s.started {
+		s.schedule(j, s.clock.Now())
+		s.notify()
+	}
+
+	return nil
+}
+
+// Remove removes a job, a running scan of the job completes
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownScanJob, id)
+	}
+
+	delete(s.jobs, id)
+	s.cache.Forget(j.job)
+
+	return nil
+}
+
+// Pause stops scheduling a job until it is resumed
+func (s *Scheduler) Pause(id string) error {
+	return s.update(id, func(j *scheduledJob) {
+		j.paused = true
+	})
+}
+
+// Resume schedules a paused job again, it runs right away, give or take
+// the jitter, if it was due while paused
+func (s *Scheduler) Resume(id string) error {
+	return s.update(id, func(j *scheduledJob) {
+		if !j.paused {
+			return
+		}
+
+		j.paused = false
+
+		if s.started && !j.running {
+			s.schedule(j, s.clock.Now())
+		}
+	})
+}
+
+// Trigger runs a job now, even if it is paused or in a blackout window.
+// A job triggered while running runs again once done.
+func (s *Scheduler) Trigger(id string) error {
+	return s.update(id, func(j *scheduledJob) {
+		j.triggered = true
+	})
+}
+
+func (s *Scheduler) update(id string, f func(*scheduledJob)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownScanJob, id)
+	}
+
+	f(j)
+	s.notify()
+
+	return nil
+}
+
+// Status returns the status of every job, ordered by ID
+func (s *Scheduler) Status() []ScanJobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := make([]ScanJobStatus, 0, len(s.jobs))
+
+	for _, j := range s.jobs {
+		st := ScanJobStatus{
+			ID:        j.job.ID,
+			Interface: j.job.Interface,
+			VID:       j.job.VID,
+			LastRun:   j.lastRun,
+			NextRun:   j.next,
+			Paused:    j.paused,
+			Running:   j.running,
+		}
+
+		if j.last != nil {
+			last := *j.last
+			st.LastResult = &last
+		}
+
+		if j.deferred != nil {
+			deferred := *j.deferred
+			st.Deferred = &deferred
+		}
+
+		status = append(status, st)
+	}
+
+	slices.SortFunc(status, func(a, b ScanJobStatus) int { return cmp.Compare(a.ID, b.ID) })
+
+	return status
+}
+
+// notify wakes up Run, s.mu must be held
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule sets the next run of j from its last one, a job which never ran
+// or is overdue runs from now, s.mu must be held
+func (s *Scheduler) schedule(j *scheduledJob, now time.Time) {
+	next := now
+
+	if !j.lastRun.IsZero() {
+		if due := j.lastRun.Add(j.job.Interval); due.After(now) {
+			next = due
+		}
+	}
+
+	if maxJitter := int64(float64(j.job.Interval) * s.jitter); maxJitter > 0 {
+		next = next.Add(time.Duration(s.random(maxJitter)))
+	}
+
+	j.next = j.job.outsideBlackouts(next)
+}
+
+// Run runs the jobs when they are due until ctx is done, it returns once
+// the running scans have returned
+func (s *Scheduler) Run(ctx context.Context) error {
+	state := s.loadState()
+
+	s.mu.Lock()
+	s.started = true
+	now := s.clock.Now()
+
+	for id, j := range s.jobs {
+		if j.lastRun.IsZero() {
+			j.lastRun = state.LastRun[id]
+		}
+
+		s.schedule(j, now)
+	}
+
+	s.mu.Unlock()
+
+	// the runs held in standby start on promotion
+	var promoted <-chan struct{}
+
+	if s.gate != nil {
+		ch, cancel := s.gate.watch()
+		defer cancel()
+
+		promoted = ch
+	}
+
+	var wg sync.WaitGroup
+
+	defer wg.Wait()
+
+	for {
+		wait, ok := s.startDue(ctx, &wg)
+
+		var (
+			timer  clock.Timer
+			timerC <-chan time.Time
+		)
+
+		if ok {
+			timer = s.clock.NewTimer(wait)
+			timerC = timer.C()
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+
+			return nil
+		case <-s.wake:
+		case <-promoted:
+		case <-timerC:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// startDue starts the due jobs, the earliest first, and returns the time
+// until the next one is due, if a scan can then be started
+func (s *Scheduler) startDue(ctx context.Context, wg *sync.WaitGroup) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+
+	var due []*scheduledJob
+
+	for _, j := range s.jobs {
+		if j.due(now) && !s.deferToPeer(j, now) {
+			due = append(due, j)
+		}
+	}
+
+	slices.SortFunc(due, func(a, b *scheduledJob) int {
+		if a.triggered != b.triggered {
+			if a.triggered {
+				return -1
+			}
+
+			return 1
+		}
+
+		return cmp.Or(a.next.Compare(b.next), cmp.Compare(a.job.ID, b.job.ID))
+	})
+
+	standby := false
+
+	for _, j := range due {
+		if s.running >= s.concurrency {
+			break
+		}
+
+		jobCtx, release := ctx, func() {}
+
+		if s.gate != nil {
+			var err error
+
+			// the due jobs wait for the promotion
+			if jobCtx, release, err = s.gate.Begin(ctx); err != nil {
+				standby = true
+				break
+			}
+		}
+
+		s.running++
+		j.running, j.triggered, j.deferred = true, false, nil
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer release()
+
+			s.runJob(jobCtx, j)
+		}()
+	}
+
+	if standby || s.running >= s.concurrency {
+		return 0, false
+	}
+
+	var next time.Time
+
+	for _, j := range s.jobs {
+		if j.running || j.paused || j.next.IsZero() {
+			continue
+		}
+
+		if next.IsZero() || j.next.Before(next) {
+			next = j.next
+		}
+	}
+
+	if next.IsZero() {
+		return 0, false
+	}
+
+	return max(next.Sub(now), 0), true
+}
+
+// deferToPeer defers j until a peer is done scanning its targets, if one
+// is, s.mu must be held
+func (s *Scheduler) deferToPeer(j *scheduledJob, now time.Time) bool {
+	if s.coordinator == nil || j.triggered {
+		return false
+	}
+
+	peer, until, ok := s.coordinator.peerScan(j.job, now)
+	if !ok {
+		return false
+	}
+
+	if j.deferred == nil {
+		j.deferred = &ScanDeferral{Since: now, Peer: peer}
+
+		log.Info().Str("job", j.job.ID).Str("peer", peer).Time("until", until).
+			Msg("Scan deferred due to peer")
+	}
+
+	j.deferred.Peer, j.deferred.Until = peer, until
+	j.next = j.job.outsideBlackouts(until)
+
+	return true
+}
+
+func (s *Scheduler) runJob(ctx context.Context, j *scheduledJob) {
+	var (
+		found map[netip.Addr]net.HardwareAddr
+		rate  []RateAdjustment
+	)
+
+	if s.coordinator != nil {
+		s.announce(j)
+	}
+
+	start := s.clock.Now()
+
+	src, err := s.source(j.job)
+
+	switch {
+	case err != nil:
+	case len(j.job.Encapsulation) > 0 && s.encapScan != nil:
+		found, err = s.encapScan(ctx, j.job.Interface, src, j.job.Encapsulation, j.targets)
+	case len(j.job.Encapsulation) > 0:
+		found, rate, err = s.scanThrough(ctx, j.job.Interface, src, j.job.Encapsulation, j.targets)
+	default:
+		found, err = s.scan(ctx, src, j.targets)
+	}
+
+	// a scan cancelled by a demotion didn't run, it runs again once
+	// promoted
+	if err != nil && errors.Is(context.Cause(ctx), ErrStandby) {
+		log.Info().Str("job", j.job.ID).Msg("Scan cancelled, the agent is in standby")
+
+		s.mu.Lock()
+		s.running--
+		j.running = false
+		s.schedule(j, s.clock.Now())
+		s.notify()
+		s.mu.Unlock()
+
+		return
+	}
+
+	summary := &ScanSummary{Targets: len(j.targets), Duration: s.clock.Now().Sub(start), Rate: rate}
+
+	if src.IsValid() {
+		summary.Source = src.String()
+	}
+
+	if err != nil {
+		summary.Error = err.Error()
+
+		log.Warn().Err(err).Str("job", j.job.ID).Msg("Scan failed")
+	}
+
+	for _, hw := range found {
+		if hw != nil {
+			summary.Responded++
+		}
+	}
+
+	if err == nil && s.results != nil {
+		s.results(j.job.ID, found)
+	}
+
+	// a failed scan tells nothing of the hosts, it isn't a miss
+	if err == nil && s.reports != nil {
+		if report := s.cache.Update(j.job, found, start); !report.Empty() {
+			report.Source = summary.Source
+			report.Tags = j.job.Encapsulation
+			s.reports(report)
+		}
+	}
+
+	s.mu.Lock()
+	s.running--
+	j.running = false
+	j.lastRun = start
+	j.last = summary
+	s.schedule(j, s.clock.Now())
+	s.notify()
+	s.mu.Unlock()
+
+	s.saveState()
+}
+
+// announce tells the peers j is about to run for as long as it last did,
+// a peer which doesn't hear it only scans as well
+func (s *Scheduler) announce(j *scheduledJob) {
+	var window time.Duration
+
+	s.mu.Lock()
+	if j.last != nil {
+		window = j.last.Duration
+	}
+	s.mu.Unlock()
+
+	if err := s.coordinator.announce(j.job, window); err != nil && !errors.Is(err, ErrNotCapturing) {
+		log.Warn().Err(err).Str("job", j.job.ID).Msg("Scan intent not announced")
+	}
+}
+
+// source returns the address the probes of job are sent from, invalid
+// when the kernel picks it
+func (s *Scheduler) source(job ScanJob) (netip.Addr, error) {
+	if job.Source.IsValid() || s.sources == nil || len(job.Targets) == 0 {
+		return job.Source, nil
+	}
+
+	var vid uint16
+	if job.VID != nil {
+		vid = *job.VID
+	}
+
+	src, err := s.sources.SourceFor(job.Interface, vid, job.Targets[0], s.sourceOpts...)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed selecting the source of %s: %w", job.ID, err)
+	}
+
+	return src, nil
+}
+
+func (s *Scheduler) loadState() scanState {
+	var state scanState
+
+	if s.stateFile == "" {
+		return state
+	}
+
+	data, err := os.ReadFile(s.stateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("path", s.stateFile).Msg("Failed reading scan state")
+		}
+
+		return state
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Warn().Err(err).Str("path", s.stateFile).Msg("Ignoring malformed scan state")
+	}
+
+	return state
+}
+
+// saveState writes the last run of the jobs, the state is read once the
+// previous write is done so an older one never replaces it
+func (s *Scheduler) saveState() {
+	if s.stateFile == "" {
+		return
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	state := scanState{LastRun: make(map[string]time.Time, len(s.jobs))}
+
+	for id, j := range s.jobs {
+		if !j.lastRun.IsZero() {
+			state.LastRun[id] = j.lastRun
+		}
+	}
+
+	s.mu.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed encoding scan state")
+		return
+	}
+
+	if err := atomicfile.WriteFile(s.stateFile, data, 0o600); err != nil {
+		log.Warn().Err(err).Str("path", s.stateFile).Msg("Failed writing scan state")
+	}
+}
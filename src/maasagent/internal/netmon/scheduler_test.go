@@ -0,0 +1,684 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/netif"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+var schedulerEpoch = time.Date(2025, 1, 6, 12, 0, 0, 0, time.UTC)
+
+// recordingScan returns a ScanFunc sending the first target of each scan
+// to the returned channel, every target replies
+func recordingScan() (ScanFunc, chan netip.Addr) {
+	calls := make(chan netip.Addr)
+
+	return func(ctx context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		select {
+		case calls <- ips[0]:
+		case <-ctx.Done():
+		}
+
+		found := make(map[netip.Addr]net.HardwareAddr, len(ips))
+		for _, ip := range ips {
+			found[ip] = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+		}
+
+		return found, nil
+	}, calls
+}
+
+func startScheduler(t *testing.T, s *Scheduler) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- s.Run(ctx) }()
+
+	return func() {
+		cancel()
+		assert.NoError(t, <-errC)
+	}
+}
+
+func TestScanJobAddresses(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []netip.Prefix
+		out []netip.Addr
+		err error
+	}{
+		"IPv4 subnet": {
+			in:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/30")},
+			out: []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")},
+		},
+		"point-to-point": {
+			in:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/31")},
+			out: []netip.Addr{netip.MustParseAddr("10.0.0.0"), netip.MustParseAddr("10.0.0.1")},
+		},
+		"overlapping": {
+			in: []netip.Prefix{
+				netip.MustParsePrefix("10.0.0.2/32"),
+				netip.MustParsePrefix("10.0.0.3/24"),
+			},
+			out: func() []netip.Addr {
+				var ips []netip.Addr
+				for ip := netip.MustParseAddr("10.0.0.1"); ip.Compare(netip.MustParseAddr("10.0.0.254")) <= 0; ip = ip.Next() {
+					ips = append(ips, ip)
+				}
+
+				return ips
+			}(),
+		},
+		"IPv6": {
+			in: []netip.Prefix{netip.MustParsePrefix("2001:db8::/127")},
+			out: []netip.Addr{
+				netip.MustParseAddr("2001:db8::"),
+				netip.MustParseAddr("2001:db8::1"),
+			},
+		},
+		"too large": {
+			in:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			err: ErrInvalidScanJob,
+		},
+		"invalid": {
+			in:  []netip.Prefix{{}},
+			err: ErrInvalidScanJob,
+		},
+		"IPv4-mapped": {
+			in:  []netip.Prefix{netip.MustParsePrefix("::ffff:10.0.0.0/126")},
+			out: []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")},
+		},
+		"IPv4-mapped and IPv6": {
+			in:  []netip.Prefix{netip.MustParsePrefix("::ffff:0.0.0.0/95")},
+			err: addrutil.ErrInvalidPrefix,
+		},
+		"empty": {
+			err: ErrInvalidScanJob,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ips, err := ScanJob{Targets: tc.in}.Addresses()
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, ips)
+		})
+	}
+}
+
+func TestBlackoutWindow(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in     time.Time
+		window BlackoutWindow
+		out    time.Time
+	}{
+		"within": {
+			in:     schedulerEpoch,
+			window: BlackoutWindow{Start: 9 * time.Hour, End: 17 * time.Hour},
+			out:    schedulerEpoch.Add(5 * time.Hour),
+		},
+		"before": {
+			in:     schedulerEpoch.Add(-4 * time.Hour),
+			window: BlackoutWindow{Start: 9 * time.Hour, End: 17 * time.Hour},
+		},
+		"at the end": {
+			in:     schedulerEpoch.Add(5 * time.Hour),
+			window: BlackoutWindow{Start: 9 * time.Hour, End: 17 * time.Hour},
+		},
+		"spanning midnight, evening": {
+			in:     schedulerEpoch.Add(11 * time.Hour),
+			window: BlackoutWindow{Start: 22 * time.Hour, End: 2 * time.Hour},
+			out:    schedulerEpoch.Add(14 * time.Hour),
+		},
+		"spanning midnight, morning": {
+			in:     schedulerEpoch.Add(-11 * time.Hour),
+			window: BlackoutWindow{Start: 22 * time.Hour, End: 2 * time.Hour},
+			out:    schedulerEpoch.Add(-10 * time.Hour),
+		},
+		"spanning midnight, outside": {
+			in:     schedulerEpoch,
+			window: BlackoutWindow{Start: 22 * time.Hour, End: 2 * time.Hour},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			end, ok := tc.window.until(tc.in)
+			assert.Equal(t, !tc.out.IsZero(), ok)
+			assert.Equal(t, tc.out, end)
+		})
+	}
+}
+
+func TestScanJobOutsideBlackouts(t *testing.T) {
+	t.Parallel()
+
+	job := ScanJob{Blackouts: []BlackoutWindow{
+		{Start: 13 * time.Hour, End: 14 * time.Hour},
+		{Start: 9 * time.Hour, End: 13 * time.Hour},
+	}}
+
+	assert.Equal(t, schedulerEpoch.Add(2*time.Hour), job.outsideBlackouts(schedulerEpoch))
+	assert.Equal(t, schedulerEpoch.Add(3*time.Hour), job.outsideBlackouts(schedulerEpoch.Add(3*time.Hour)))
+}
+
+func TestSchedulerAdd(t *testing.T) {
+	t.Parallel()
+
+	target := []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}
+
+	testcases := map[string]struct {
+		in  ScanJob
+		err error
+	}{
+		"valid": {
+			in: ScanJob{ID: "b", Targets: target, Interval: time.Hour},
+		},
+		"duplicate": {
+			in:  ScanJob{ID: "a", Targets: target, Interval: time.Hour},
+			err: ErrDuplicateScanJob,
+		},
+		"missing ID": {
+			in:  ScanJob{Targets: target, Interval: time.Hour},
+			err: ErrInvalidScanJob,
+		},
+		"no interval": {
+			in:  ScanJob{ID: "b", Targets: target},
+			err: ErrInvalidScanJob,
+		},
+		"no targets": {
+			in:  ScanJob{ID: "b", Interval: time.Hour},
+			err: ErrInvalidScanJob,
+		},
+		"empty blackout": {
+			in: ScanJob{
+				ID: "b", Targets: target, Interval: time.Hour,
+				Blackouts: []BlackoutWindow{{Start: time.Hour, End: time.Hour}},
+			},
+			err: ErrInvalidScanJob,
+		},
+		"blackout past midnight": {
+			in: ScanJob{
+				ID: "b", Targets: target, Interval: time.Hour,
+				Blackouts: []BlackoutWindow{{Start: time.Hour, End: 25 * time.Hour}},
+			},
+			err: ErrInvalidScanJob,
+		},
+		"encapsulation": {
+			in: ScanJob{
+				ID: "b", Targets: target, Interval: time.Hour,
+				Encapsulation: []ethernet.Tag{{TPID: ethernet.EthernetTypeQinQ, VID: 100}, {VID: 12}},
+			},
+		},
+		"encapsulation of another VLAN": {
+			in: ScanJob{
+				ID: "b", Targets: target, Interval: time.Hour, VID: uint16Pointer(13),
+				Encapsulation: []ethernet.Tag{{TPID: ethernet.EthernetTypeQinQ, VID: 100}, {VID: 12}},
+			},
+			err: ErrInvalidScanJob,
+		},
+		"encapsulation with an invalid TPID": {
+			in: ScanJob{
+				ID: "b", Targets: target, Interval: time.Hour,
+				Encapsulation: []ethernet.Tag{{TPID: ethernet.EthernetTypeIPv4, VID: 100}, {VID: 12}},
+			},
+			err: ErrInvalidScanJob,
+		},
+		"encapsulation with an invalid VID": {
+			in: ScanJob{
+				ID: "b", Targets: target, Interval: time.Hour,
+				Encapsulation: []ethernet.Tag{{VID: 4095}},
+			},
+			err: ErrInvalidScanJob,
+		},
+		"encapsulation too deep": {
+			in: ScanJob{
+				ID: "b", Targets: target, Interval: time.Hour,
+				Encapsulation: make([]ethernet.Tag, ethernet.MaxTags+1),
+			},
+			err: ErrInvalidScanJob,
+		},
+		"encapsulation of IPv4-mapped targets": {
+			in: ScanJob{
+				ID: "b", Targets: []netip.Prefix{netip.MustParsePrefix("::ffff:10.0.0.1/128")}, Interval: time.Hour,
+				Encapsulation: []ethernet.Tag{{VID: 12}},
+			},
+		},
+		"unspecified source": {
+			in:  ScanJob{ID: "b", Targets: target, Interval: time.Hour, Source: netip.IPv4Unspecified()},
+			err: addrutil.ErrInvalidIP,
+		},
+		"multicast source": {
+			in:  ScanJob{ID: "b", Targets: target, Interval: time.Hour, Source: netip.MustParseAddr("ff02::1")},
+			err: addrutil.ErrInvalidIP,
+		},
+		"encapsulation of IPv6 targets": {
+			in: ScanJob{
+				ID: "b", Targets: []netip.Prefix{netip.MustParsePrefix("fd00::1/128")}, Interval: time.Hour,
+				Encapsulation: []ethernet.Tag{{VID: 12}},
+			},
+			err: ErrInvalidScanJob,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewScheduler()
+			require.NoError(t, s.Add(ScanJob{ID: "a", Targets: target, Interval: time.Hour}))
+
+			assert.ErrorIs(t, s.Add(tc.in), tc.err)
+		})
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	defer leak.Check(t)()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	scan, calls := recordingScan()
+
+	var found []string
+
+	s := NewScheduler(WithSchedulerClock(clk), WithScanFunc(scan), WithScanJitter(0),
+		WithScanResults(func(id string, _ map[netip.Addr]net.HardwareAddr) { found = append(found, id) }))
+
+	require.NoError(t, s.Add(ScanJob{
+		ID:        "a",
+		Interface: "eth0",
+		Targets:   []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")},
+		Interval:  10 * time.Minute,
+	}))
+	require.NoError(t, s.Add(ScanJob{
+		ID:        "b",
+		Interface: "eth1",
+		VID:       uint16Pointer(100),
+		Targets:   []netip.Prefix{netip.MustParsePrefix("10.0.1.0/30")},
+		Interval:  time.Hour,
+	}))
+
+	stop := startScheduler(t, s)
+	defer stop()
+
+	// both jobs are due on start, one at a time
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), <-calls)
+	assert.Equal(t, netip.MustParseAddr("10.0.1.1"), <-calls)
+
+	clk.BlockUntil(1)
+	clk.Advance(10 * time.Minute)
+
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), <-calls)
+
+	clk.BlockUntil(1)
+
+	assert.Equal(t, []ScanJobStatus{
+		{
+			ID:         "a",
+			Interface:  "eth0",
+			LastRun:    schedulerEpoch.Add(10 * time.Minute),
+			NextRun:    schedulerEpoch.Add(20 * time.Minute),
+			LastResult: &ScanSummary{Targets: 1, Responded: 1},
+		},
+		{
+			ID:         "b",
+			Interface:  "eth1",
+			VID:        uint16Pointer(100),
+			LastRun:    schedulerEpoch,
+			NextRun:    schedulerEpoch.Add(time.Hour),
+			LastResult: &ScanSummary{Targets: 2, Responded: 2},
+		},
+	}, s.Status())
+	assert.Equal(t, []string{"a", "b", "a"}, found)
+}
+
+func TestSchedulerEncapsulation(t *testing.T) {
+	defer leak.Check(t)()
+
+	path := []ethernet.Tag{{TPID: ethernet.EthernetTypeQinQ, VID: 100}, {VID: 12}}
+	paths := make(chan []ethernet.Tag)
+	reports := make(chan ScanReport, 1)
+
+	encapScan := func(ctx context.Context, iface string, _ netip.Addr, path []ethernet.Tag,
+		ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		select {
+		case paths <- path:
+		case <-ctx.Done():
+		}
+
+		return map[netip.Addr]net.HardwareAddr{ips[0]: testPXEClient}, nil
+	}
+	scan := func(context.Context, []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		t.Error("the encapsulated job was scanned without its tags")
+		return nil, nil
+	}
+
+	s := NewScheduler(WithSchedulerClock(clocktest.NewFake(schedulerEpoch)), WithScanFunc(scan),
+		WithEncapsulatedScanFunc(encapScan), WithScanJitter(0),
+		WithScanReports(func(rep ScanReport) { reports <- rep }))
+
+	require.NoError(t, s.Add(ScanJob{
+		ID:            "a",
+		Interface:     "eth0",
+		Targets:       []netip.Prefix{netip.MustParsePrefix("10.0.12.5/32")},
+		Interval:      time.Hour,
+		Encapsulation: path,
+	}))
+
+	stop := startScheduler(t, s)
+	defer stop()
+
+	assert.Equal(t, path, <-paths)
+
+	rep := <-reports
+	assert.Equal(t, path, rep.Tags)
+	assert.Len(t, rep.New, 1)
+
+	// the VID of the job is the innermost tag
+	assert.Equal(t, uint16Pointer(12), s.Status()[0].VID)
+}
+
+// fakeSources is a SourceSelector with a source per interface
+type fakeSources map[string]netip.Addr
+
+func (f fakeSources) SourceFor(name string, _ uint16, _ netip.Prefix,
+	_ ...netif.SourceOption) (netip.Addr, error) {
+	src, ok := f[name]
+	if !ok {
+		return netip.Addr{}, netif.ErrLinkNotFound
+	}
+
+	return src, nil
+}
+
+func TestSchedulerSource(t *testing.T) {
+	defer leak.Check(t)()
+
+	sources := make(chan netip.Addr)
+
+	scan := func(ctx context.Context, src netip.Addr, _ []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		select {
+		case sources <- src:
+		case <-ctx.Done():
+		}
+
+		return nil, nil
+	}
+
+	s := NewScheduler(WithSchedulerClock(clocktest.NewFake(schedulerEpoch)), WithSourceScanFunc(scan),
+		WithScanJitter(0), WithSourceSelection(fakeSources{"eth0": netip.MustParseAddr("10.0.0.5")}))
+
+	require.NoError(t, s.Add(ScanJob{
+		ID:        "a",
+		Interface: "eth0",
+		Targets:   []netip.Prefix{netip.MustParsePrefix("10.0.0.0/30")},
+		Interval:  time.Hour,
+	}))
+	require.NoError(t, s.Add(ScanJob{
+		ID:        "b",
+		Interface: "eth0",
+		Source:    netip.MustParseAddr("10.0.0.9"),
+		Targets:   []netip.Prefix{netip.MustParsePrefix("10.0.0.8/30")},
+		Interval:  time.Hour,
+	}))
+	require.NoError(t, s.Add(ScanJob{
+		ID:        "c",
+		Interface: "eth1",
+		Targets:   []netip.Prefix{netip.MustParsePrefix("10.0.1.0/30")},
+		Interval:  time.Hour,
+	}))
+
+	stop := startScheduler(t, s)
+	defer stop()
+
+	assert.ElementsMatch(t, []netip.Addr{
+		netip.MustParseAddr("10.0.0.5"),
+		netip.MustParseAddr("10.0.0.9"),
+	}, []netip.Addr{<-sources, <-sources})
+
+	// a job whose source can't be selected fails without scanning
+	require.Eventually(t, func() bool {
+		for _, st := range s.Status() {
+			if st.LastResult == nil {
+				return false
+			}
+		}
+
+		return true
+	}, time.Second, time.Millisecond)
+
+	summaries := make(map[string]*ScanSummary)
+	for _, st := range s.Status() {
+		summaries[st.ID] = st.LastResult
+	}
+
+	assert.Equal(t, "10.0.0.5", summaries["a"].Source)
+	assert.Equal(t, "10.0.0.9", summaries["b"].Source)
+	assert.Empty(t, summaries["c"].Source)
+	assert.Contains(t, summaries["c"].Error, netif.ErrLinkNotFound.Error())
+}
+
+func TestSchedulerControls(t *testing.T) {
+	defer leak.Check(t)()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	scan, calls := recordingScan()
+	s := NewScheduler(WithSchedulerClock(clk), WithScanFunc(scan), WithScanJitter(0))
+
+	require.NoError(t, s.Add(ScanJob{
+		ID:       "a",
+		Targets:  []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")},
+		Interval: 10 * time.Minute,
+	}))
+	require.NoError(t, s.Add(ScanJob{
+		ID:       "b",
+		Targets:  []netip.Prefix{netip.MustParsePrefix("10.0.1.1/32")},
+		Interval: time.Hour,
+	}))
+	require.NoError(t, s.Pause("a"))
+	assert.ErrorIs(t, s.Pause("c"), ErrUnknownScanJob)
+
+	stop := startScheduler(t, s)
+	defer stop()
+
+	assert.Equal(t, netip.MustParseAddr("10.0.1.1"), <-calls)
+
+	clk.BlockUntil(1)
+
+	status := s.Status()
+	assert.True(t, status[0].Paused)
+	assert.Zero(t, status[0].LastRun)
+
+	// a trigger runs a paused job
+	require.NoError(t, s.Trigger("a"))
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), <-calls)
+
+	require.NoError(t, s.Trigger("b"))
+	assert.Equal(t, netip.MustParseAddr("10.0.1.1"), <-calls)
+
+	clk.BlockUntil(1)
+	require.NoError(t, s.Resume("a"))
+	clk.BlockUntil(1)
+
+	status = s.Status()
+	assert.False(t, status[0].Paused)
+	assert.Equal(t, schedulerEpoch.Add(10*time.Minute), status[0].NextRun)
+
+	require.NoError(t, s.Remove("b"))
+	assert.ErrorIs(t, s.Remove("b"), ErrUnknownScanJob)
+	assert.Len(t, s.Status(), 1)
+}
+
+func TestSchedulerConcurrency(t *testing.T) {
+	defer leak.Check(t)()
+
+	calls := make(chan netip.Addr)
+	release := make(chan struct{})
+
+	scan := func(_ context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		calls <- ips[0]
+		<-release
+
+		return nil, nil
+	}
+
+	s := NewScheduler(WithSchedulerClock(clocktest.NewFake(schedulerEpoch)), WithScanFunc(scan),
+		WithScanJitter(0), WithScanConcurrency(2))
+
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, s.Add(ScanJob{
+			ID:       id,
+			Targets:  []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")},
+			Interval: time.Hour,
+		}))
+	}
+
+	stop := startScheduler(t, s)
+	defer stop()
+
+	<-calls
+	<-calls
+
+	running := 0
+
+	for _, st := range s.Status() {
+		if st.Running {
+			running++
+		}
+	}
+
+	assert.Equal(t, 2, running)
+
+	close(release)
+	<-calls
+}
+
+func TestSchedulerBlackout(t *testing.T) {
+	defer leak.Check(t)()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	scan, _ := recordingScan()
+	s := NewScheduler(WithSchedulerClock(clk), WithScanFunc(scan), WithScanJitter(0))
+
+	require.NoError(t, s.Add(ScanJob{
+		ID:        "a",
+		Targets:   []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")},
+		Interval:  time.Hour,
+		Blackouts: []BlackoutWindow{{Start: 9 * time.Hour, End: 17 * time.Hour}},
+	}))
+	assert.Zero(t, s.Status()[0].NextRun)
+
+	stop := startScheduler(t, s)
+	defer stop()
+
+	clk.BlockUntil(1)
+	assert.Equal(t, schedulerEpoch.Add(5*time.Hour), s.Status()[0].NextRun)
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	t.Parallel()
+
+	s := NewScheduler(WithSchedulerClock(clocktest.NewFake(schedulerEpoch)), WithScanJitter(0.5))
+	s.random = func(n int64) int64 { return n - 1 }
+
+	j := &scheduledJob{job: ScanJob{Interval: time.Hour}}
+
+	s.schedule(j, schedulerEpoch)
+	assert.Equal(t, schedulerEpoch.Add(30*time.Minute-1), j.next)
+
+	j.lastRun = schedulerEpoch.Add(-10 * time.Minute)
+	s.schedule(j, schedulerEpoch)
+	assert.Equal(t, schedulerEpoch.Add(80*time.Minute-1), j.next)
+
+	s = NewScheduler()
+	j.lastRun = time.Time{}
+
+	for range 100 {
+		s.schedule(j, schedulerEpoch)
+		assert.False(t, j.next.Before(schedulerEpoch))
+		assert.True(t, j.next.Before(schedulerEpoch.Add(6*time.Minute)))
+	}
+}
+
+func TestSchedulerState(t *testing.T) {
+	defer leak.Check(t)()
+
+	path := filepath.Join(t.TempDir(), "scans.json")
+	job := ScanJob{
+		ID:       "a",
+		Targets:  []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")},
+		Interval: 10 * time.Minute,
+	}
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	scan, calls := recordingScan()
+	s := NewScheduler(WithSchedulerClock(clk), WithScanFunc(scan), WithScanJitter(0), WithScanStateFile(path))
+	require.NoError(t, s.Add(job))
+
+	stop := startScheduler(t, s)
+	<-calls
+	clk.BlockUntil(1)
+	stop()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"last_run": {"a": "2025-01-06T12:00:00Z"}}`, string(data))
+
+	// after a restart, the job isn't due before its interval
+	clk.Advance(5 * time.Minute)
+
+	s = NewScheduler(WithSchedulerClock(clk), WithScanFunc(scan), WithScanJitter(0), WithScanStateFile(path))
+	require.NoError(t, s.Add(job))
+
+	stop = startScheduler(t, s)
+	defer stop()
+
+	clk.BlockUntil(1)
+
+	status := s.Status()
+	assert.Equal(t, schedulerEpoch, status[0].LastRun)
+	assert.Equal(t, schedulerEpoch.Add(10*time.Minute), status[0].NextRun)
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	_ "embed"
+)
+
+// ResultSchema is the JSON schema of a Result
+//
+//go:embed result.schema.json
+var ResultSchema []byte
+
+// SnapshotSchema is the JSON schema of a Snapshot
+//
+//go:embed snapshot.schema.json
+var SnapshotSchema []byte
+
+// TopologySchema is the JSON schema of a TopologyReport
+//
+//go:embed topology.schema.json
+var TopologySchema []byte
@@ -0,0 +1,223 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"encoding/json"
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current JSON")
+
+type jsonSchema struct {
+	Properties map[string]struct {
+		Items struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+			Required   []string                   `json:"required"`
+		} `json:"items"`
+		Enum []string `json:"enum"`
+	} `json:"properties"`
+	Required []string `json:"required"`
+}
+
+// goldenResult and goldenSnapshot have every field set, so their JSON has
+// every property of the schemas
+func goldenResult() Result {
+	vid := uint16(12)
+
+	return Result{
+		VID:       &vid,
+		Duplicate: &DuplicateMACLocation{MAC: "52:54:00:00:00:01"},
+		Evidence:  &ResultEvidence{},
+		Violation: &BindingViolation{},
+		DAD:       &DADConflict{},
+		PortAuth:  &PortAuthFinding{},
+		Ingress:   &Ingress{Port: "eth1", Attributed: true},
+		Responder: &MappingStatus{IP: "10.0.0.1", MAC: "52:54:00:00:00:03", State: MappingSuspended},
+		Critical:  &CriticalHostStatus{IP: "10.0.0.1", Name: "gateway", Unresponsive: true, Misses: 3},
+		SelfAddress: &SelfAddressStatus{Interface: "eth0", IP: "10.0.0.2", MAC: "52:54:00:00:00:04",
+			Undelivered: true, Misses: 2},
+		Upstream: &UpstreamChange{Protocol: TopologyLLDP, Previous: UpstreamPort{ChassisID: "00:1c:73:aa:bb:01",
+			PortID: "Ethernet1/12"}, Current: UpstreamPort{ChassisID: "00:1c:73:aa:bb:01", PortID: "Ethernet1/13"}},
+		NativeVLAN:  &NativeVLANMismatch{Protocol: TopologyLLDP, Configured: 12, Advertised: 1},
+		Mode:        &ModeChange{Previous: ModeStandby, Current: ModeActive, Replayed: 2},
+		Layer:       testLayer("payload"),
+		IP:          "10.0.0.1",
+		MAC:         "52:54:00:00:00:01",
+		PreviousMAC: "52:54:00:00:00:02",
+		Tags:        []ethernet.Tag{{TPID: 0x88a8, VID: 100}, {TPID: 0x8100, VID: 12}},
+		Labels:      map[string]string{"fabric": "fabric-0", "space": "management"},
+		Time:        1700000000,
+		Event:       EventMoved,
+		Untagged:    true,
+	}
+}
+
+func goldenSnapshot() Snapshot {
+	vid := uint16(12)
+
+	return Snapshot{
+		Interface: "eth0",
+		Bindings: []SnapshotBinding{{
+			VID:         &vid,
+			IP:          "10.0.0.1",
+			MAC:         "52:54:00:00:00:01",
+			Source:      BindingSourceExternal.String(),
+			Origin:      "dhcpd-leases",
+			Confidence:  ConfidenceHigh.String(),
+			Observation: ObservationDHCPAck.String(),
+			Score:       0.9,
+			Time:        1700000000,
+			Labels:      map[string]string{"fabric": "fabric-0", "zone": "az1"},
+			ViaProxy:    true,
+		}},
+		Violations: []BindingViolation{{IP: "10.0.0.2", MAC: "52:54:00:00:00:02"}},
+		PortAuth:   []PortAuthFinding{{Interface: "eth0"}},
+		CriticalHosts: []CriticalHostStatus{{IP: "10.0.0.254", Name: "gateway", MAC: "52:54:00:00:00:fe",
+			Probes: 20, SuccessRate: 1, Latency: 0.0012, LastAnswer: 1700000055, Since: 1700000000}},
+		Sequence: 7,
+		Time:     1700000060,
+	}
+}
+
+func goldenTopology() TopologyReport {
+	return TopologyReport{
+		Interface: "eth0",
+		Upstream: UpstreamPort{
+			Aggregation:     &PortAggregation{Capable: true, Enabled: true, PortID: 1000001},
+			ChassisID:       "00:1c:73:aa:bb:01",
+			SystemName:      "sw1.example.com",
+			PortID:          "Ethernet1/12",
+			PortDescription: "rack 3 server 12",
+			NativeVLAN:      100,
+		},
+		Sources:           []string{TopologyLLDP, TopologyCDP},
+		Conflicting:       true,
+		LastAdvertisement: 1700000000,
+		Age:               30,
+		Stale:             false,
+	}
+}
+
+func keys(b []byte) []string {
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(b, &fields); err != nil {
+		panic(err)
+	}
+
+	return slices.Collect(maps.Keys(fields))
+}
+
+// TestSchemaGolden checks the JSON of a Result and of a Snapshot against the
+// golden files and the schemas describing them. A change of the JSON is
+// accepted by running the test with -update, reviewing the diff of the
+// golden files and updating the schemas.
+func TestSchemaGolden(t *testing.T) {
+	testcases := map[string]struct {
+		value  any
+		schema []byte
+		golden string
+	}{
+		"result": {
+			value:  goldenResult(),
+			schema: ResultSchema,
+			golden: "result.golden.json",
+		},
+		"snapshot": {
+			value:  goldenSnapshot(),
+			schema: SnapshotSchema,
+			golden: "snapshot.golden.json",
+		},
+		"topology": {
+			value:  goldenTopology(),
+			schema: TopologySchema,
+			golden: "topology.golden.json",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			got, err := json.MarshalIndent(tc.value, "", "  ")
+			require.NoError(t, err)
+
+			got = append(got, '\n')
+			path := filepath.Join("testdata", tc.golden)
+
+			if *update {
+				require.NoError(t, os.WriteFile(path, got, 0o600))
+				return
+			}
+
+			want, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(want), string(got))
+
+			var schema jsonSchema
+
+			require.NoError(t, json.Unmarshal(tc.schema, &schema))
+
+			fields := keys(got)
+			assert.ElementsMatch(t, slices.Collect(maps.Keys(schema.Properties)), fields)
+			assert.Subset(t, fields, schema.Required)
+		})
+	}
+}
+
+func TestSnapshotSchemaBindings(t *testing.T) {
+	t.Parallel()
+
+	var schema jsonSchema
+
+	require.NoError(t, json.Unmarshal(SnapshotSchema, &schema))
+
+	b, err := json.Marshal(goldenSnapshot().Bindings[0])
+	require.NoError(t, err)
+
+	items := schema.Properties["bindings"].Items
+	fields := keys(b)
+
+	assert.ElementsMatch(t, slices.Collect(maps.Keys(items.Properties)), fields)
+	assert.Subset(t, fields, items.Required)
+}
+
+func TestResultSchemaEvents(t *testing.T) {
+	t.Parallel()
+
+	var schema jsonSchema
+
+	require.NoError(t, json.Unmarshal(ResultSchema, &schema))
+
+	var events []string
+
+	for e := Event(1); e.String() != "UNKNOWN"; e++ {
+		events = append(events, e.String())
+	}
+
+	assert.ElementsMatch(t, events, schema.Properties["event"].Enum)
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"math"
+	"time"
+)
+
+// ObservationKind is the kind of evidence behind a binding, each kind has
+// its own weight in the score of the binding
+type ObservationKind uint8
+
+const (
+	// ObservationARPRequest is the sender of an ARP request
+	ObservationARPRequest ObservationKind = iota
+	// ObservationARPReply is either side of an ARP reply
+	ObservationARPReply
+	// ObservationDHCPAck is a lease acknowledged by a DHCP server
+	ObservationDHCPAck
+	// ObservationMDNS is an mDNS announcement
+	ObservationMDNS
+	// ObservationKernel is an entry of the kernel neighbor cache, its weight
+	// is scaled by the Confidence of the entry
+	ObservationKernel
+)
+
+func (k ObservationKind) String() string {
+	switch k {
+	case ObservationARPReply:
+		return "arp_reply"
+	case ObservationDHCPAck:
+		return "dhcp_ack"
+	case ObservationMDNS:
+		return "mdns"
+	case ObservationKernel:
+		return "kernel"
+	default:
+		return "arp_request"
+	}
+}
+
+const (
+	defaultScoreHalfLife     = time.Hour
+	defaultCorroboration     = 0.1
+	defaultMaxCorroborations = 3
+	defaultProxyPenalty      = 0.5
+	// scorePrecision rounds the scores of a snapshot to 2 decimals
+	scorePrecision = 100
+)
+
+// ScoreWeights configure how a binding is scored, the binding with the
+// higher score wins when observations disagree on the MAC of an IP.
+//
+// The score of a binding is the weight of the strongest kind of observation
+// vouching for it, plus Corroboration for every further observation of the
+// same MAC up to MaxCorroborations, capped at 1 and halved every HalfLife
+// since the binding was last created or refreshed. A conflicting
+// observation is scored the same way, including its own corroborations,
+// and replaces the binding once its score is at least as high.
+//
+// With the defaults a DHCP ACK of 1 outweighs a fresh conflicting ARP reply
+// of 0.8 for about 20 minutes, after which the ARP reply wins, and a kernel
+// STALE entry of 0.2 loses to any fresh observation, even an mDNS one of
+// 0.3. A binding learned from a proxy loses ProxyPenalty of its score.
+type ScoreWeights struct {
+	// Kinds weighs a fresh observation of each kind, from 0 to 1, the
+	// kinds missing weigh 0
+	Kinds map[ObservationKind]float64
+	// HalfLife is the age at which a binding scores half as much, 0 keeps
+	// the scores from decaying
+	HalfLife time.Duration
+	// Corroboration is added for every observation confirming a binding
+	Corroboration float64
+	// ProxyPenalty is the share of the score a binding learned from a
+	// proxy loses, from 0 to 1
+	ProxyPenalty float64
+	// MaxCorroborations bounds the corroborations counted
+	MaxCorroborations int
+}
+
+// DefaultScoreWeights returns the weights of a Service without
+// WithScoreWeights
+func DefaultScoreWeights() ScoreWeights {
+	return ScoreWeights{
+		Kinds: map[ObservationKind]float64{
+			ObservationDHCPAck:    1,
+			ObservationARPReply:   0.8,
+			ObservationARPRequest: 0.7,
+			ObservationKernel:     0.4,
+			ObservationMDNS:       0.3,
+		},
+		HalfLife:          defaultScoreHalfLife,
+		Corroboration:     defaultCorroboration,
+		ProxyPenalty:      defaultProxyPenalty,
+		MaxCorroborations: defaultMaxCorroborations,
+	}
+}
+
+// weight returns the weight of a fresh observation of b
+func (w ScoreWeights) weight(b Binding) float64 {
+	// a primed binding is what the host is expected to be, not evidence
+	if b.Source == BindingSourcePrimed {
+		return 0
+	}
+
+	weight := w.Kinds[b.Kind]
+
+	// the sources other than the capture vouch for their bindings as far
+	// as their confidence goes
+	if b.Kind == ObservationKernel || b.Source == BindingSourceExternal {
+		// a stale entry was reachable a while ago, a probed one may be gone
+		switch b.Confidence {
+		case ConfidenceHigh:
+		case ConfidenceMedium:
+			weight /= 2
+		case ConfidenceLow:
+			weight /= 4
+		default:
+			weight = 0
+		}
+	}
+
+	return weight
+}
+
+// Score returns the score of b at now, from 0 to 1
+func (w ScoreWeights) Score(b Binding, now time.Time) float64 {
+	score := w.weight(b) + w.Corroboration*float64(min(b.Corroborations, w.MaxCorroborations))
+	score = min(score, 1)
+
+	if b.ViaProxy {
+		score *= 1 - min(max(w.ProxyPenalty, 0), 1)
+	}
+
+	if age := now.Sub(b.Time); w.HalfLife > 0 && age > 0 {
+		score *= math.Exp2(-float64(age) / float64(w.HalfLife))
+	}
+
+	return score
+}
+
+// corroborate returns b confirmed by an observation of the same MAC, which
+// keeps the strongest kind seen and its source
+func (w ScoreWeights) corroborate(b, observed Binding) Binding {
+	b.Corroborations++
+
+	if w.weight(observed) > w.weight(b) {
+		b.Kind = observed.Kind
+		b.Confidence = observed.Confidence
+		b.Source = observed.Source
+		b.Origin = observed.Origin
+	}
+
+	return b
+}
+
+// snapshotScore rounds a score for a snapshot, which doesn't need more
+// precision
+func snapshotScore(score float64) float64 {
+	return math.Round(score*scorePrecision) / scorePrecision
+}
@@ -0,0 +1,265 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/netif"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+func TestScoreWeightsScore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	w := DefaultScoreWeights()
+
+	testcases := map[string]struct {
+		in      Binding
+		weights ScoreWeights
+		out     float64
+	}{
+		"fresh DHCP ACK": {
+			in:  Binding{Kind: ObservationDHCPAck, Time: now},
+			out: 1,
+		},
+		"fresh ARP reply": {
+			in:  Binding{Kind: ObservationARPReply, Time: now},
+			out: 0.8,
+		},
+		"ARP request a half-life ago": {
+			in:  Binding{Kind: ObservationARPRequest, Time: now.Add(-time.Hour)},
+			out: 0.35,
+		},
+		"corroborated": {
+			in:  Binding{Kind: ObservationARPRequest, Corroborations: 2, Time: now},
+			out: 0.9,
+		},
+		"corroborations are bounded": {
+			in:  Binding{Kind: ObservationMDNS, Corroborations: 100, Time: now},
+			out: 0.6,
+		},
+		"capped": {
+			in:  Binding{Kind: ObservationDHCPAck, Corroborations: 3, Time: now},
+			out: 1,
+		},
+		"kernel reachable": {
+			in:  Binding{Kind: ObservationKernel, Confidence: ConfidenceHigh, Time: now},
+			out: 0.4,
+		},
+		"kernel stale": {
+			in:  Binding{Kind: ObservationKernel, Confidence: ConfidenceMedium, Time: now},
+			out: 0.2,
+		},
+		"kernel failed": {
+			in:  Binding{Kind: ObservationKernel, Confidence: ConfidenceNone, Time: now},
+			out: 0,
+		},
+		"observed after now": {
+			in:  Binding{Kind: ObservationARPReply, Time: now.Add(time.Minute)},
+			out: 0.8,
+		},
+		"without decay": {
+			in:      Binding{Kind: ObservationARPReply, Time: now.Add(-24 * time.Hour)},
+			weights: ScoreWeights{Kinds: w.Kinds},
+			out:     0.8,
+		},
+		"via a proxy": {
+			in:  Binding{Kind: ObservationARPReply, ViaProxy: true, Time: now},
+			out: 0.4,
+		},
+		"via a proxy without a penalty": {
+			in:      Binding{Kind: ObservationARPReply, ViaProxy: true, Time: now},
+			weights: ScoreWeights{Kinds: w.Kinds},
+			out:     0.8,
+		},
+		"kind without a weight": {
+			in:      Binding{Kind: ObservationMDNS, Time: now},
+			weights: ScoreWeights{Kinds: map[ObservationKind]float64{ObservationDHCPAck: 1}},
+			out:     0,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			weights := w
+			if tc.weights.Kinds != nil {
+				weights = tc.weights
+			}
+
+			assert.InDelta(t, tc.out, weights.Score(tc.in, now), 1e-9)
+		})
+	}
+}
+
+func TestServiceScoredConflicts(t *testing.T) {
+	t.Parallel()
+
+	ip := netip.MustParseAddr("10.0.0.1")
+	start := time.Unix(1700000000, 0)
+	bound, other := mustParseMAC("00:16:3e:00:00:01"), mustParseMAC("00:16:3e:00:00:02")
+
+	type observation struct {
+		mac  string
+		kind ObservationKind
+		age  time.Duration
+	}
+
+	testcases := map[string]struct {
+		options []ServiceOption
+		in      []observation
+		// out is the bound MAC after the last observation, events the
+		// events of the last observation
+		out    string
+		events []Event
+	}{
+		"old DHCP binding vs fresh conflicting ARP": {
+			in: []observation{
+				{mac: bound.String(), kind: ObservationDHCPAck},
+				{mac: other.String(), kind: ObservationARPReply, age: time.Hour},
+			},
+			out:    other.String(),
+			events: []Event{EventMoved},
+		},
+		"recent DHCP binding vs fresh conflicting ARP": {
+			in: []observation{
+				{mac: bound.String(), kind: ObservationDHCPAck},
+				{mac: other.String(), kind: ObservationARPReply, age: 10 * time.Minute},
+			},
+			out: bound.String(),
+		},
+		"conflicting ARP corroborated until it outscores DHCP": {
+			in: []observation{
+				{mac: bound.String(), kind: ObservationDHCPAck},
+				{mac: other.String(), kind: ObservationARPReply, age: 10 * time.Minute},
+				{mac: other.String(), kind: ObservationARPReply, age: 10*time.Minute + time.Second},
+			},
+			out:    other.String(),
+			events: []Event{EventMoved},
+		},
+		"ARP replacing ARP": {
+			in: []observation{
+				{mac: bound.String(), kind: ObservationARPRequest},
+				{mac: other.String(), kind: ObservationARPRequest, age: time.Second},
+			},
+			out:    other.String(),
+			events: []Event{EventMoved},
+		},
+		"corroborated ARP against a single conflicting one": {
+			in: []observation{
+				{mac: bound.String(), kind: ObservationARPRequest},
+				{mac: bound.String(), kind: ObservationARPRequest, age: time.Second},
+				{mac: other.String(), kind: ObservationARPRequest, age: 2 * time.Second},
+			},
+			out: bound.String(),
+		},
+		"corroborating keeps the strongest kind": {
+			in: []observation{
+				{mac: bound.String(), kind: ObservationARPRequest},
+				{mac: bound.String(), kind: ObservationDHCPAck, age: time.Second},
+				{mac: bound.String(), kind: ObservationMDNS, age: 2 * time.Second},
+				{mac: other.String(), kind: ObservationARPReply, age: 3 * time.Second},
+			},
+			out: bound.String(),
+		},
+		"mDNS against a fresh conflicting DHCP ACK": {
+			in: []observation{
+				{mac: bound.String(), kind: ObservationMDNS},
+				{mac: other.String(), kind: ObservationDHCPAck, age: time.Second},
+			},
+			out:    other.String(),
+			events: []Event{EventMoved},
+		},
+		"DHCP against mDNS": {
+			in: []observation{
+				{mac: bound.String(), kind: ObservationDHCPAck},
+				{mac: other.String(), kind: ObservationMDNS, age: time.Second},
+			},
+			out: bound.String(),
+		},
+		"weights favouring ARP over DHCP": {
+			options: []ServiceOption{WithScoreWeights(ScoreWeights{
+				Kinds: map[ObservationKind]float64{ObservationDHCPAck: 0.5, ObservationARPReply: 0.9},
+			})},
+			in: []observation{
+				{mac: bound.String(), kind: ObservationDHCPAck},
+				{mac: other.String(), kind: ObservationARPReply, age: time.Second},
+			},
+			out:    other.String(),
+			events: []Event{EventMoved},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewService("eth0", append([]ServiceOption{WithClock(clocktest.NewFake(start))}, tc.options...)...)
+
+			var res []Result
+
+			for _, o := range tc.in {
+				res = s.Observe(o.kind, ip, mustParseMAC(o.mac), nil, start.Add(o.age))
+			}
+
+			var events []Event
+
+			for _, r := range res {
+				events = append(events, r.Event)
+			}
+
+			assert.Equal(t, tc.events, events)
+
+			snap := s.Snapshot()
+			require.Len(t, snap.Bindings, 1)
+			assert.Equal(t, tc.out, snap.Bindings[0].MAC)
+		})
+	}
+}
+
+func TestServiceKernelStaleVsMDNS(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000100, 0)
+	s := NewService("eth0", WithClock(clocktest.NewFake(now)))
+
+	s.reconcile([]kernelEntry{{
+		ip:    netip.MustParseAddr("10.0.0.1"),
+		mac:   mustParseMAC("00:16:3e:00:00:01"),
+		state: netif.NeighStale,
+	}})
+
+	res := s.Observe(ObservationMDNS, netip.MustParseAddr("10.0.0.1"), mustParseMAC("00:16:3e:00:00:02"), nil, now)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventMoved, res[0].Event)
+
+	snap := s.Snapshot()
+	require.Len(t, snap.Bindings, 1)
+	assert.Equal(t, "mdns", snap.Bindings[0].Observation)
+	assert.InDelta(t, 0.3, snap.Bindings[0].Score, 1e-9)
+}
@@ -0,0 +1,606 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/netif"
+)
+
+const (
+	defaultSelfAnnounceInterval = 2 * time.Minute
+	// defaultSelfAnnounceTimeout is how long the capture of an
+	// announcement is waited for, it is the outgoing copy, as the one of a
+	// capture.SelfTest
+	defaultSelfAnnounceTimeout = time.Second
+	defaultSelfAnnounceMisses  = 2
+	// defaultSelfTheftWindow is how long a host claiming an address of the
+	// agent is reported once
+	defaultSelfTheftWindow = 5 * time.Minute
+	// maxSelfThefts bounds the claims remembered, the expired ones are
+	// dropped to make room
+	maxSelfThefts = 1024
+)
+
+// SelfAddressStatus is the state of the announcements of an address of the
+// host, on the interface of a SelfAddressMonitor
+type SelfAddressStatus struct {
+	VID       *uint16 `json:"vid"`
+	Interface string  `json:"interface"`
+	IP        string  `json:"ip"`
+	MAC       string  `json:"mac"`
+	// Undelivered is set once as many announcements in a row as the
+	// monitor tolerates weren't captured back, until one is
+	Undelivered bool `json:"undelivered"`
+	// Misses is the number of announcements not captured back in a row
+	Misses int `json:"misses"`
+	// LastAnnounced is the time of the last announcement, LastDelivered
+	// the time the last one captured back was
+	LastAnnounced int64 `json:"last_announced,omitempty"`
+	LastDelivered int64 `json:"last_delivered,omitempty"`
+}
+
+type selfAddress struct {
+	mac           net.HardwareAddr
+	vid           *uint16
+	lastAnnounced time.Time
+	lastDelivered time.Time
+	// sent is the time of the announcement awaiting its capture, zero if
+	// none
+	sent        time.Time
+	ip          netip.Addr
+	misses      int
+	undelivered bool
+}
+
+func (a *selfAddress) status(iface string) SelfAddressStatus {
+	st := SelfAddressStatus{
+		VID:         a.vid,
+		Interface:   iface,
+		IP:          a.ip.String(),
+		MAC:         a.mac.String(),
+		Undelivered: a.undelivered,
+		Misses:      a.misses,
+	}
+
+	if !a.lastAnnounced.IsZero() {
+		st.LastAnnounced = a.lastAnnounced.Unix()
+	}
+
+	if !a.lastDelivered.IsZero() {
+		st.LastDelivered = a.lastDelivered.Unix()
+	}
+
+	return st
+}
+
+type theftKey struct {
+	bindingKey
+	mac [6]byte
+}
+
+// selfTheft is a host claiming an address of the host, reported at most
+// once per window
+type selfTheft struct {
+	reported  time.Time
+	violation BindingViolation
+}
+
+// linkNotifier is a LinkSource telling when its links change, such as a
+// netif.Inventory
+type linkNotifier interface {
+	Subscribe() (<-chan struct{}, func())
+}
+
+// SelfAddressMonitor watches the traffic claiming the identity of the host.
+//
+// The addresses of every link of the host are implicit binding assertions,
+// to any of its MACs: an ARP packet or a neighbor advertisement claiming one
+// of them from another MAC is reported with an EventAddressTheft, the
+// address stolen or cloned by a misconfigured host.
+//
+// The addresses of the interface of the monitor, and of its VLAN
+// sub-interfaces, are announced with gratuitous ARP requests and unsolicited
+// neighbor advertisements, and the announcements are expected back through
+// the capture as the probe of a capture.SelfTest is. The addresses whose
+// announcements aren't captured back as many times in a row as tolerated
+// are reported with an EventAnnouncementUndelivered: what the host says of
+// itself doesn't reach the wire.
+//
+// The addresses follow the links, added and removed at runtime.
+type SelfAddressMonitor struct {
+	clock      clock.Clock
+	links      LinkSource
+	limiter    *ProbeLimiter
+	assertions *Assertions
+	macs       map[[6]byte]struct{}
+	addresses  map[bindingKey]*selfAddress
+	thefts     map[theftKey]*selfTheft
+	iface      string
+	interval   time.Duration
+	timeout    time.Duration
+	window     time.Duration
+	misses     int
+	mu         sync.Mutex
+}
+
+// SelfAddressOption configures a SelfAddressMonitor
+type SelfAddressOption func(*SelfAddressMonitor)
+
+// WithSelfAnnounceInterval sets the time between the announcements of an
+// address, and the time their capture is waited for
+func WithSelfAnnounceInterval(interval, timeout time.Duration) SelfAddressOption {
+	return func(m *SelfAddressMonitor) {
+		if interval > 0 {
+			m.interval = interval
+		}
+
+		if timeout > 0 {
+			m.timeout = timeout
+		}
+	}
+}
+
+// WithSelfAnnounceMisses sets the number of announcements not captured back
+// in a row an address is reported after
+func WithSelfAnnounceMisses(misses int) SelfAddressOption {
+	return func(m *SelfAddressMonitor) {
+		if misses > 0 {
+			m.misses = misses
+		}
+	}
+}
+
+// WithSelfTheftWindow sets how long a host claiming an address of the host
+// is reported once
+func WithSelfTheftWindow(window time.Duration) SelfAddressOption {
+	return func(m *SelfAddressMonitor) {
+		if window > 0 {
+			m.window = window
+		}
+	}
+}
+
+// WithSelfAddressLimiter sets the limiter the announcements wait for, so
+// the probing of several interfaces is bounded together
+func WithSelfAddressLimiter(l *ProbeLimiter) SelfAddressOption {
+	return func(m *SelfAddressMonitor) {
+		m.limiter = l
+	}
+}
+
+// WithSelfAddressClock sets the clock timing the announcements and
+// timestamping the frames observed without a timestamp
+func WithSelfAddressClock(c clock.Clock) SelfAddressOption {
+	return func(m *SelfAddressMonitor) {
+		m.clock = c
+	}
+}
+
+// NewSelfAddressMonitor returns a SelfAddressMonitor of the interface
+// iface, with the addresses of the links of links
+func NewSelfAddressMonitor(iface string, links LinkSource, options ...SelfAddressOption) *SelfAddressMonitor {
+	m := &SelfAddressMonitor{
+		clock:      clock.System{},
+		links:      links,
+		assertions: NewAssertions(),
+		addresses:  make(map[bindingKey]*selfAddress),
+		thefts:     make(map[theftKey]*selfTheft),
+		iface:      iface,
+		interval:   defaultSelfAnnounceInterval,
+		timeout:    defaultSelfAnnounceTimeout,
+		window:     defaultSelfTheftWindow,
+		misses:     defaultSelfAnnounceMisses,
+	}
+
+	for _, opt := range options {
+		opt(m)
+	}
+
+	if m.limiter == nil {
+		m.limiter = NewProbeLimiter(defaultProbeRate, defaultProbeBurst, WithProbeLimiterClock(m.clock))
+	}
+
+	m.Update(links.Links())
+
+	return m
+}
+
+// Update replaces the addresses with those of links. The addresses kept,
+// by address and VLAN, keep their state, a removed address is dropped
+// without an event.
+func (m *SelfAddressMonitor) Update(links []netif.Link) {
+	var (
+		assertions []BindingAssertion
+		ips        []netip.Addr
+	)
+
+	macs := make(map[[6]byte]struct{}, len(links))
+	announced := make(map[bindingKey]*selfAddress)
+
+	var index int
+
+	for _, l := range links {
+		if l.Name == m.iface {
+			index = l.Index
+		}
+	}
+
+	for _, l := range links {
+		// loopback and tunnel interfaces have no or shorter addresses
+		if len(l.HardwareAddr) != 6 || l.Loopback() {
+			continue
+		}
+
+		macs[[6]byte(l.HardwareAddr)] = struct{}{}
+
+		var vid *uint16
+
+		own := l.Name == m.iface
+		if index != 0 && l.ParentIndex == index && l.VID != 0 {
+			own, vid = true, &l.VID
+		}
+
+		for _, p := range l.Addrs {
+			ip := p.Addr().Unmap()
+			if !ip.IsGlobalUnicast() && !ip.IsLinkLocalUnicast() {
+				continue
+			}
+
+			ips = append(ips, ip)
+
+			if own {
+				announced[mappingKey(ip, vid)] = &selfAddress{ip: ip, vid: vid, mac: slices.Clone(l.HardwareAddr)}
+			}
+		}
+	}
+
+	slices.SortFunc(ips, netip.Addr.Compare)
+	ips = slices.Compact(ips)
+
+	sortedMACs := slices.SortedFunc(maps.Keys(macs), func(a, b [6]byte) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	for _, ip := range ips {
+		for _, mac := range sortedMACs {
+			assertions = append(assertions, BindingAssertion{IP: ip.String(),
+				MAC: net.HardwareAddr(mac[:]).String(), Implicit: true})
+		}
+	}
+
+	// the addresses are valid by construction
+	_ = m.assertions.Set(assertions) //nolint:errcheck // see above
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, a := range announced {
+		if prev, ok := m.addresses[key]; ok && bytes.Equal(prev.mac, a.mac) {
+			announced[key] = prev
+		}
+	}
+
+	m.macs = macs
+	m.addresses = announced
+
+	for key := range m.thefts {
+		if _, ok := slices.BinarySearchFunc(ips, key.ip, netip.Addr.Compare); !ok {
+			delete(m.thefts, key)
+		}
+	}
+}
+
+// Assertions returns the implicit binding assertions of the addresses of
+// the host, in the order of IP
+func (m *SelfAddressMonitor) Assertions() []BindingAssertion {
+	return m.assertions.List()
+}
+
+// Addresses returns the state of the announcements of every address of
+// the interface, by address and VLAN
+func (m *SelfAddressMonitor) Addresses() []SelfAddressStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := m.sortedKeys()
+
+	statuses := make([]SelfAddressStatus, 0, len(keys))
+	for _, key := range keys {
+		statuses = append(statuses, m.addresses[key].status(m.iface))
+	}
+
+	return statuses
+}
+
+func (m *SelfAddressMonitor) sortedKeys() []bindingKey {
+	keys := make([]bindingKey, 0, len(m.addresses))
+	for key := range m.addresses {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b bindingKey) int {
+		return cmp.Or(a.ip.Compare(b.ip), cmp.Compare(a.vid, b.vid))
+	})
+
+	return keys
+}
+
+// Run announces the addresses every interval through w until ctx is done,
+// and calls emit with the Results of the addresses whose announcements
+// stopped being captured back. The addresses are updated from the links
+// before each round, and as soon as they change when the LinkSource tells,
+// as a netif.Inventory does. The captured frames reach the monitor through
+// Observe.
+func (m *SelfAddressMonitor) Run(ctx context.Context, w capture.FrameWriter, emit func(Result)) {
+	if n, ok := m.links.(linkNotifier); ok {
+		ch, cancel := n.Subscribe()
+		defer cancel()
+
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ch:
+					m.Update(m.links.Links())
+				}
+			}
+		}()
+	}
+
+	for {
+		m.Update(m.links.Links())
+
+		if m.announce(ctx, w) != nil || m.clock.Sleep(ctx, m.timeout) != nil {
+			return
+		}
+
+		for _, res := range m.evaluate(m.clock.Now()) {
+			emit(res)
+		}
+
+		if m.clock.Sleep(ctx, max(m.interval-m.timeout, 0)) != nil {
+			return
+		}
+	}
+}
+
+// announce sends the announcement of every address, it only fails once ctx
+// is done
+func (m *SelfAddressMonitor) announce(ctx context.Context, w capture.FrameWriter) error {
+	m.mu.Lock()
+	keys := m.sortedKeys()
+	m.mu.Unlock()
+
+	for _, key := range keys {
+		if err := m.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+
+		a, ok := m.addresses[key]
+		if !ok {
+			// removed by Update meanwhile
+			m.mu.Unlock()
+			continue
+		}
+
+		// the announcement is awaited before it is sent, its capture may
+		// be observed before WriteFrame returns
+		frame, err := announcement(a)
+		if err == nil {
+			a.sent = m.clock.Now()
+			a.lastAnnounced = a.sent
+		}
+
+		m.mu.Unlock()
+
+		if err == nil {
+			err = w.WriteFrame(frame)
+		}
+
+		// an announcement which can't be sent can't be lost on the way
+		if err != nil {
+			m.mu.Lock()
+			a.sent = time.Time{}
+			m.mu.Unlock()
+
+			// held in standby, for the promotion
+			if errors.Is(err, ErrStandby) {
+				continue
+			}
+
+			log.Warn().Err(err).Str("ip", key.ip.String()).Msg("announcement of own address not sent")
+		}
+	}
+
+	return nil
+}
+
+// announcement returns the gratuitous ARP request, or the unsolicited
+// neighbor advertisement to all the nodes, of a
+func announcement(a *selfAddress) ([]byte, error) {
+	b := ethernet.NewFrame().Src(a.mac)
+	if a.vid != nil {
+		b = b.VLAN(*a.vid)
+	}
+
+	if a.ip.Is4() {
+		return b.Padded().ARPRequest(a.ip, a.ip).Build()
+	}
+
+	return b.NeighborAdvertisement(a.ip, netip.IPv6LinkLocalAllNodes(), ethernet.NAFlagOverride).Build()
+}
+
+// evaluate counts the announcements not captured back as missed, and
+// returns the Results of the addresses which turned undelivered
+func (m *SelfAddressMonitor) evaluate(now time.Time) []Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var res []Result
+
+	for _, key := range m.sortedKeys() {
+		a := m.addresses[key]
+
+		if a.sent.IsZero() {
+			continue
+		}
+
+		a.sent = time.Time{}
+		a.misses++
+
+		if a.undelivered || a.misses < m.misses {
+			continue
+		}
+
+		a.undelivered = true
+
+		log.Warn().Str("iface", m.iface).Str("ip", a.ip.String()).Int("misses", a.misses).
+			Msg("announcements of own address not captured back")
+
+		status := a.status(m.iface)
+
+		res = append(res, Result{
+			IP:          status.IP,
+			MAC:         status.MAC,
+			VID:         a.vid,
+			Time:        now.Unix(),
+			Event:       EventAnnouncementUndelivered,
+			SelfAddress: &status,
+		})
+	}
+
+	return res
+}
+
+// Observe reads an ARP packet or a neighbor discovery message received on
+// vid. A claim of an address of the host from another MAC returns an
+// EventAddressTheft, and an announcement of the monitor captured back
+// records its delivery.
+func (m *SelfAddressMonitor) Observe(frame []byte, vid *uint16, md capture.Metadata) []Result {
+	msg, ok := readNeighborMessage(frame)
+	if !ok || msg.probe || len(msg.mac) != 6 {
+		return nil
+	}
+
+	timestamp := md.Timestamp
+	if timestamp.IsZero() {
+		timestamp = m.clock.Now()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, own := m.macs[[6]byte(msg.mac)]; own {
+		a, ok := m.addresses[mappingKey(msg.addr, vid)]
+		if ok && !a.sent.IsZero() && bytes.Equal(a.mac, msg.mac) {
+			a.sent = time.Time{}
+			a.lastDelivered = timestamp
+			a.misses = 0
+
+			if a.undelivered {
+				a.undelivered = false
+
+				log.Info().Str("iface", m.iface).Str("ip", a.ip.String()).
+					Msg("announcements of own address captured back again")
+			}
+		}
+
+		return nil
+	}
+
+	spec, stolen := m.assertions.Check(m.iface, vid, msg.addr, msg.mac)
+	if !stolen {
+		return nil
+	}
+
+	return m.theft(spec, msg, vid, timestamp)
+}
+
+// theft records the claim of msg on an address of the host, and returns
+// its EventAddressTheft unless it was reported within the window
+func (m *SelfAddressMonitor) theft(spec BindingAssertion, msg neighborMessage, vid *uint16,
+	timestamp time.Time) []Result {
+	key := theftKey{bindingKey: mappingKey(msg.addr, vid), mac: [6]byte(msg.mac)}
+
+	t, ok := m.thefts[key]
+	if !ok {
+		if len(m.thefts) >= maxSelfThefts {
+			m.expireThefts(timestamp)
+		}
+
+		if len(m.thefts) >= maxSelfThefts {
+			return nil
+		}
+
+		t = &selfTheft{violation: BindingViolation{
+			VID:       vid,
+			IP:        msg.addr.String(),
+			MAC:       msg.mac.String(),
+			FirstSeen: timestamp.Unix(),
+		}}
+		m.thefts[key] = t
+	}
+
+	t.violation.Assertion = spec
+	t.violation.LastSeen = timestamp.Unix()
+	t.violation.Count++
+
+	if ok && timestamp.Sub(t.reported) < m.window {
+		return nil
+	}
+
+	t.reported = timestamp
+	v := t.violation
+
+	log.Warn().Str("iface", m.iface).Str("ip", v.IP).Str("mac", v.MAC).
+		Msg("address of the host claimed by another MAC")
+
+	return []Result{{
+		IP:        v.IP,
+		MAC:       v.MAC,
+		VID:       vid,
+		Time:      v.LastSeen,
+		Event:     EventAddressTheft,
+		Violation: &v,
+	}}
+}
+
+func (m *SelfAddressMonitor) expireThefts(now time.Time) {
+	for key, t := range m.thefts {
+		if now.Sub(time.Unix(t.violation.LastSeen, 0)) >= m.window {
+			delete(m.thefts, key)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/netif"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+var testOtherRackMAC = net.HardwareAddr{0x00, 0x16, 0x3e, 0x12, 0x34, 0x57}
+
+// selfLinks returns the links of a host with an address on eth0, on its
+// VLAN 10 and on eth1
+func selfLinks() staticLinks {
+	return staticLinks{
+		{Name: "lo", Index: 1, Flags: unix.IFF_LOOPBACK,
+			Addrs: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/8")}},
+		{Name: "eth0", Index: 2, HardwareAddr: testRackMAC, Addrs: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.2/24"), netip.MustParsePrefix("2001:db8::2/64")}},
+		{Name: "eth0.10", Index: 3, HardwareAddr: testRackMAC, Kind: "vlan", ParentIndex: 2, VID: 10,
+			Addrs: []netip.Prefix{netip.MustParsePrefix("10.0.10.2/24")}},
+		{Name: "eth1", Index: 4, HardwareAddr: testOtherRackMAC,
+			Addrs: []netip.Prefix{netip.MustParsePrefix("192.168.0.2/24")}},
+	}
+}
+
+func newTestSelfAddressMonitor(t *testing.T, links LinkSource, options ...SelfAddressOption) (*SelfAddressMonitor,
+	*clocktest.Fake) {
+	t.Helper()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+
+	return NewSelfAddressMonitor("eth0", links, append([]SelfAddressOption{WithSelfAddressClock(clk),
+		WithSelfAddressLimiter(NewProbeLimiter(0, 0))}, options...)...), clk
+}
+
+// claim returns the gratuitous ARP reply or the neighbor advertisement of
+// mac claiming ip
+func claim(t *testing.T, mac net.HardwareAddr, ip netip.Addr, vid *uint16) []byte {
+	t.Helper()
+
+	b := ethernet.NewFrame().Src(mac)
+	if ip.Is4() {
+		return buildFrame(t, b.Padded().ARPReply(ip, ethernet.Broadcast, ip), vid)
+	}
+
+	return buildFrame(t, b.NeighborAdvertisement(ip, netip.IPv6LinkLocalAllNodes(), ethernet.NAFlagOverride), vid)
+}
+
+func TestSelfAddressMonitorUpdate(t *testing.T) {
+	t.Parallel()
+
+	m, _ := newTestSelfAddressMonitor(t, selfLinks())
+
+	assertions := m.Assertions()
+	require.Len(t, assertions, 8)
+	assert.Equal(t, BindingAssertion{IP: "10.0.0.2", MAC: testRackMAC.String(), Implicit: true}, assertions[0])
+	assert.Equal(t, BindingAssertion{IP: "10.0.0.2", MAC: testOtherRackMAC.String(), Implicit: true},
+		assertions[1])
+
+	addresses := m.Addresses()
+	require.Len(t, addresses, 3)
+	assert.Equal(t, SelfAddressStatus{Interface: "eth0", IP: "10.0.0.2", MAC: testRackMAC.String()}, addresses[0])
+	assert.Equal(t, SelfAddressStatus{VID: vid10(), Interface: "eth0", IP: "10.0.10.2", MAC: testRackMAC.String()},
+		addresses[1])
+	assert.Equal(t, "2001:db8::2", addresses[2].IP)
+
+	// the state of the addresses kept is kept, the thefts of the addresses
+	// removed are forgotten
+	require.Len(t, m.Observe(claim(t, testGatewayMAC, netip.MustParseAddr("192.168.0.2"), nil), nil,
+		capture.Metadata{}), 1)
+	m.addresses[mappingKey(netip.MustParseAddr("10.0.0.2"), nil)].misses = 1
+
+	links := selfLinks()
+	links[1].Addrs = links[1].Addrs[:1]
+	links[3].Addrs = nil
+	m.Update(links)
+
+	addresses = m.Addresses()
+	require.Len(t, addresses, 2)
+	assert.Equal(t, 1, addresses[0].Misses)
+	assert.Len(t, m.Assertions(), 4)
+	assert.Empty(t, m.thefts)
+}
+
+func TestSelfAddressMonitorTheft(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		ip  netip.Addr
+		vid *uint16
+	}{
+		"ARP": {
+			ip: netip.MustParseAddr("10.0.0.2"),
+		},
+		"NA": {
+			ip: netip.MustParseAddr("2001:db8::2"),
+		},
+		"VLAN": {
+			ip:  netip.MustParseAddr("10.0.10.2"),
+			vid: vid10(),
+		},
+		"other interface": {
+			ip: netip.MustParseAddr("192.168.0.2"),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m, clk := newTestSelfAddressMonitor(t, selfLinks(), WithSelfTheftWindow(time.Minute))
+
+			// the host claiming its addresses from any of its MACs is fine
+			assert.Empty(t, m.Observe(claim(t, testRackMAC, tc.ip, tc.vid), tc.vid, capture.Metadata{}))
+			assert.Empty(t, m.Observe(claim(t, testOtherRackMAC, tc.ip, tc.vid), tc.vid, capture.Metadata{}))
+
+			res := m.Observe(claim(t, testGatewayMAC, tc.ip, tc.vid), tc.vid, capture.Metadata{})
+			require.Len(t, res, 1)
+			assert.Equal(t, EventAddressTheft, res[0].Event)
+			assert.Equal(t, tc.ip.String(), res[0].IP)
+			assert.Equal(t, testGatewayMAC.String(), res[0].MAC)
+			assert.Equal(t, tc.vid, res[0].VID)
+			assert.Equal(t, int64(1700000000), res[0].Time)
+			require.NotNil(t, res[0].Violation)
+			assert.True(t, res[0].Violation.Assertion.Implicit)
+			assert.Equal(t, uint64(1), res[0].Violation.Count)
+
+			// a theft is reported once per window
+			clk.Advance(30 * time.Second)
+			assert.Empty(t, m.Observe(claim(t, testGatewayMAC, tc.ip, tc.vid), tc.vid, capture.Metadata{}))
+
+			clk.Advance(30 * time.Second)
+
+			res = m.Observe(claim(t, testGatewayMAC, tc.ip, tc.vid), tc.vid, capture.Metadata{})
+			require.Len(t, res, 1)
+			assert.Equal(t, uint64(3), res[0].Violation.Count)
+			assert.Equal(t, int64(1700000000), res[0].Violation.FirstSeen)
+			assert.Equal(t, int64(1700000060), res[0].Violation.LastSeen)
+		})
+	}
+}
+
+func TestSelfAddressMonitorIgnores(t *testing.T) {
+	t.Parallel()
+
+	m, _ := newTestSelfAddressMonitor(t, selfLinks())
+
+	testcases := map[string][]byte{
+		"other address": claim(t, testGatewayMAC, testGateway4, nil),
+		"loopback":      claim(t, testGatewayMAC, netip.MustParseAddr("127.0.0.1"), nil),
+		"probe": buildFrame(t, ethernet.NewFrame().Src(testGatewayMAC).Padded().
+			ARPRequest(netip.IPv4Unspecified(), netip.MustParseAddr("10.0.0.2")), nil),
+		"not ARP": {12: 0x08, 13: 0x00, 41: 0},
+	}
+
+	for name, frame := range testcases {
+		assert.Empty(t, m.Observe(frame, nil, capture.Metadata{}), name)
+	}
+}
+
+func TestSelfAddressMonitorRun(t *testing.T) {
+	t.Parallel()
+
+	m, clk := newTestSelfAddressMonitor(t, selfLinks(), WithSelfAnnounceInterval(10*time.Second, time.Second),
+		WithSelfAnnounceMisses(2))
+
+	// the announcements are captured back but the one of 10.0.0.2, lost
+	// from the second round on
+	var round atomic.Int32
+
+	w := &responderWriter{}
+	w.written = func(frame []byte) {
+		var vid *uint16
+		if frame[12] == 0x81 {
+			vid = vid10()
+		}
+
+		msg, ok := readNeighborMessage(frame)
+		assert.True(t, ok)
+
+		if round.Load() > 0 && msg.addr == netip.MustParseAddr("10.0.0.2") {
+			return
+		}
+
+		m.Observe(frame, vid, capture.Metadata{Direction: capture.DirectionOutbound})
+	}
+
+	resultC := make(chan Result, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		m.Run(ctx, w, func(res Result) { resultC <- res })
+	}()
+
+	next := func() {
+		clk.BlockUntil(1)
+		clk.Advance(time.Second)
+		clk.BlockUntil(1)
+		round.Add(1)
+		clk.Advance(9 * time.Second)
+	}
+
+	next()
+	next()
+	assert.Empty(t, resultC)
+
+	next()
+	require.Len(t, resultC, 1)
+
+	res := <-resultC
+	assert.Equal(t, EventAnnouncementUndelivered, res.Event)
+	assert.Equal(t, "10.0.0.2", res.IP)
+	assert.Equal(t, testRackMAC.String(), res.MAC)
+	assert.Equal(t, int64(1700000021), res.Time)
+	require.NotNil(t, res.SelfAddress)
+	assert.Equal(t, SelfAddressStatus{
+		Interface:     "eth0",
+		IP:            "10.0.0.2",
+		MAC:           testRackMAC.String(),
+		Undelivered:   true,
+		Misses:        2,
+		LastAnnounced: 1700000020,
+		LastDelivered: 1700000000,
+	}, *res.SelfAddress)
+
+	// an undelivered address is reported once
+	next()
+	assert.Empty(t, resultC)
+
+	clk.BlockUntil(1)
+	cancel()
+	<-done
+
+	frames := w.sent()
+	require.Len(t, frames, 15)
+
+	var eth ethernet.EthernetFrame
+
+	require.NoError(t, eth.UnmarshalBinary(frames[0]))
+
+	pkt, err := eth.ExtractARPPacket()
+	require.NoError(t, err)
+	assert.Equal(t, ethernet.Broadcast, eth.DstMAC)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.2"), pkt.SenderAddr())
+	assert.Equal(t, netip.MustParseAddr("10.0.0.2"), pkt.TargetAddr())
+
+	for _, status := range m.Addresses()[1:] {
+		assert.False(t, status.Undelivered, status.IP)
+		assert.Zero(t, status.Misses, status.IP)
+	}
+}
+
+// notifyingLinks tells when its links change, as a netif.Inventory does
+type notifyingLinks struct {
+	ch    chan struct{}
+	links staticLinks
+	mu    sync.Mutex
+}
+
+func (l *notifyingLinks) Links() []netif.Link {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.links
+}
+
+func (l *notifyingLinks) Subscribe() (<-chan struct{}, func()) {
+	return l.ch, func() {}
+}
+
+func (l *notifyingLinks) set(links staticLinks) {
+	l.mu.Lock()
+	l.links = links
+	l.mu.Unlock()
+
+	l.ch <- struct{}{}
+}
+
+func TestSelfAddressMonitorFollowsLinks(t *testing.T) {
+	t.Parallel()
+
+	links := &notifyingLinks{links: selfLinks(), ch: make(chan struct{})}
+	m, clk := newTestSelfAddressMonitor(t, links)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		m.Run(ctx, &responderWriter{}, func(Result) {})
+	}()
+
+	// an address added between two rounds is monitored at once
+	clk.BlockUntil(1)
+
+	added := selfLinks()
+	added[1].Addrs = append(added[1].Addrs, netip.MustParsePrefix("10.0.0.3/24"))
+	links.set(added)
+
+	assert.Eventually(t, func() bool {
+		return len(m.Addresses()) == 4
+	}, time.Second, time.Millisecond)
+
+	res := m.Observe(claim(t, testGatewayMAC, netip.MustParseAddr("10.0.0.3"), nil), nil, capture.Metadata{})
+	require.Len(t, res, 1)
+	assert.Equal(t, EventAddressTheft, res[0].Event)
+
+	cancel()
+	<-done
+}
+
+func TestServiceSelfAddressMonitor(t *testing.T) {
+	t.Parallel()
+
+	m, _ := newTestSelfAddressMonitor(t, selfLinks())
+	svc := NewService("eth0", WithSelfAddressMonitor(m))
+
+	assert.True(t, svc.observesNDP())
+
+	res, err := svc.handleFrame(claim(t, testGatewayMAC, netip.MustParseAddr("2001:db8::2"), nil),
+		capture.Metadata{})
+	require.NoError(t, err)
+
+	var thefts int
+
+	for _, r := range res {
+		if r.Event == EventAddressTheft {
+			thefts++
+		}
+	}
+
+	assert.Equal(t, 1, thefts)
+
+	// the announcements of the host are captured back although its frames
+	// aren't observed
+	m.addresses[mappingKey(netip.MustParseAddr("10.0.0.2"), nil)].sent = time.Unix(1700000000, 0)
+
+	res, err = svc.handleFrame(claim(t, testRackMAC, netip.MustParseAddr("10.0.0.2"), nil),
+		capture.Metadata{Direction: capture.DirectionOutbound})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+	assert.Equal(t, int64(1700000000), m.Addresses()[0].LastDelivered)
+}
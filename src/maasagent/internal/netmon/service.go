@@ -17,22 +17,35 @@ package netmon
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"errors"
+	"io"
 	"net"
 	"net/netip"
-	"strconv"
+	"runtime/pprof"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	pcap "github.com/packetcap/go-pcap"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/net/bpf"
 
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/eapol"
 	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/mld"
+	"maas.io/core/src/maasagent/internal/ndp"
 )
 
 const (
-	snapLen            int32         = 64
-	timeout            time.Duration = -1
+	snapLen int = 64
+	// ndpSnapLen holds a tagged neighbor solicitation or advertisement up
+	// to its target address
+	ndpSnapLen         int           = 96
 	seenAgainThreshold time.Duration = 600 * time.Second
 )
 
@@ -55,12 +68,67 @@ type Binding struct {
 	IP netip.Addr
 	// MAC is the MAC address the IP is currently bound to
 	MAC net.HardwareAddr
+	// Corroborations counts the observations confirming the binding since
+	// it was created
+	Corroborations int
+	// Source tells whether the binding was observed, merged from the
+	// kernel neighbor cache or ingested from another source
+	Source BindingSource
+	// Origin names the source of a BindingSourceExternal binding
+	Origin string
+	// Confidence is how far the kernel vouches for a BindingSourceKernel
+	// binding, or its source for a BindingSourceExternal one
+	Confidence Confidence
+	// Kind is the strongest kind of observation vouching for the binding
+	Kind ObservationKind
+	// ViaProxy is set when the MAC is a proxy answering for the IP, the
+	// host behind it wasn't observed
+	ViaProxy bool
 }
 
 // Result is the result of observed ARP packets
 type Result struct {
 	// VID is the VLAN ID if one exists
 	VID *uint16 `json:"vid"`
+	// Duplicate holds the locations of the MAC for an
+	// EventDuplicateMACLocation
+	Duplicate *DuplicateMACLocation `json:"duplicate,omitempty"`
+	// Evidence holds the recent observations behind an EventMoved, an
+	// EventDuplicateMACLocation or an EventBindingViolation, when the
+	// Service has an EvidenceLog
+	Evidence *ResultEvidence `json:"evidence,omitempty"`
+	// Violation holds the assertion an EventBindingViolation or an
+	// EventAddressTheft contradicts
+	Violation *BindingViolation `json:"violation,omitempty"`
+	// DAD holds the addresses and the hosts of an EventDADConflict
+	DAD *DADConflict `json:"dad,omitempty"`
+	// PortAuth holds the segment of an EventPortAuthenticationSuspected or
+	// an EventPortAuthenticationCleared, whose MAC is the authenticator
+	PortAuth *PortAuthFinding `json:"port_auth,omitempty"`
+	// Ingress is the member port of the bridge or bond of the Service the
+	// frame of the Result entered on, see WithPortAttributor
+	Ingress *Ingress `json:"ingress,omitempty"`
+	// Responder holds the Mapping an EventResponderSuspended suspended,
+	// whose MAC is the host claiming its address
+	Responder *MappingStatus `json:"responder,omitempty"`
+	// Critical holds the host of an EventCriticalHostUnresponsive or an
+	// EventCriticalHostRecovered
+	Critical *CriticalHostStatus `json:"critical_host,omitempty"`
+	// SelfAddress holds the address of the host of an
+	// EventAnnouncementUndelivered
+	SelfAddress *SelfAddressStatus `json:"self_address,omitempty"`
+	// Upstream holds the switch ports of an EventUpstreamPortChanged, whose
+	// MAC is that of the switch
+	Upstream *UpstreamChange `json:"upstream,omitempty"`
+	// NativeVLAN holds the native VLANs of an EventNativeVLANMismatch,
+	// whose MAC is that of the switch
+	NativeVLAN *NativeVLANMismatch `json:"native_vlan,omitempty"`
+	// Mode holds the transition of an EventModeChanged, which isn't of a
+	// host
+	Mode *ModeChange `json:"mode,omitempty"`
+	// Layer is what a protocol registered with the ethernet package decoded
+	// for an EventCustomLayer, opaque to the Service and passed on as is
+	Layer ethernet.Layer `json:"layer,omitempty"`
 	// IP is the presentation format of an observed IP
 	IP string `json:"ip"`
 	// MAC is the presentation format of an observed MAC
@@ -68,102 +136,703 @@ type Result struct {
 	// Previous MAC is the presentation format of a previous MAC if
 	// an EventMoved was observed
 	PreviousMAC string `json:"previous_mac,omitempty"`
+	// Tags are the VLAN tags of a binding learned from a frame with more
+	// than one, outermost first, VID is the innermost
+	Tags []ethernet.Tag `json:"tags,omitempty"`
+	// Labels are the labels of the interface of the Service, see
+	// WithLabels, shared by the Results and never modified
+	Labels map[string]string `json:"labels,omitempty"`
 	// Time is the time the packet creating the Result was observed
 	Time int64 `json:"time"`
 	// Event is the type of event the Result is
 	Event Event `json:"event"`
+	// Untagged is set when the frame was received untagged and VID is the
+	// native VLAN of the interface, see SetNativeVLAN
+	Untagged bool `json:"untagged,omitempty"`
+}
+
+// SelfMACSource tells the MAC addresses of the host apart,
+// netif.SelfMACs implements it
+type SelfMACSource interface {
+	Contains(mac net.HardwareAddr) bool
+}
+
+// bindingKey identifies a binding, netip.Addr is comparable so an IPv4
+// address is the same key however it was parsed
+type bindingKey struct {
+	ip  netip.Addr
+	vid uint16
 }
 
 // Service is responsible for starting packet capture and
 // converting observed ARP packets into discovered Results
 type Service struct {
-	bindings map[string]Binding
-	iface    string
+	// table holds the bindings and the conflicting ones which didn't
+	// outscore them yet, the challengers, one per key
+	table      *bindingTable
+	violations map[bindingKey]BindingViolation
+	clock      clock.Clock
+	assertions *Assertions
+	duplicates *DuplicateMACDetector
+	dad        *DADDetector
+	portAuth   *PortAuthDetector
+	vlans      *VLANDiscovery
+	proxies    *ProxyDetector
+	responder  *Responder
+	critical   *CriticalHostMonitor
+	checker    *HostChecker
+	primer     *Primer
+	identities *Correlator
+	selfAddrs  *SelfAddressMonitor
+	topology   *Topology
+	reorder    *Reorderer
+	evidence   *EvidenceLog
+	history    *History
+	dedup      *Deduplicator
+	attributor *PortAttributor
+	// coordinator receives the scan intents of the peers, and sends those
+	// of the jobs of the interface
+	coordinator *ScanCoordinator
+	// labels are those of the interface, replaced as a whole by SetLabels
+	labels atomic.Pointer[map[string]string]
+	// native is the native VLAN configured, see SetNativeVLAN, advertised
+	// the one the switch advertises, and mismatch the pair of them last
+	// reported differing
+	native     atomic.Uint32
+	advertised atomic.Uint32
+	mismatch   atomic.Uint32
+	// layers are the protocols registered when the Service was created,
+	// those of its decoders
+	layers *ethernet.Registry
+	self   SelfMACSource
+	// targeted filters the capture of a running Service, conn, and target
+	// is kept for the next Start. targetMu protects the three and the
+	// capabilities.
+	targeted    *capture.TargetedReader
+	conn        *capture.Conn
+	ring        *capture.PcapRing
+	target      capture.Target
+	iface       string
+	captureOpts []capture.Option
+	guard       []capture.GuardOption
+	transmit    *TransmitQueue
+	gate        *TransmitGate
+	weights     ScoreWeights
+	// capabilities are those the Service runs without
+	capabilities Capabilities
+	// parser decodes the frames, extract holds it for ExtractARPPacket
+	parser   ethernet.ParserOptions
+	extract  []ethernet.ExtractOption
+	targetMu sync.Mutex
+	// sequence numbers the snapshots
+	sequence    atomic.Uint64
+	maxBindings int
+	shards      int
+	// violationsMu protects violations, it is taken with the lock of a
+	// shard of the table held
+	violationsMu sync.Mutex
+	// malformed and truncated count the frames which failed to decode,
+	// the truncated ones only because the snaplen cut them short
+	malformed atomic.Uint64
+	truncated atomic.Uint64
+	// limited counts the frames abandoned at a limit of the decoders, see
+	// ethernet.DecodeLimits
+	limited atomic.Uint64
+	// meter times the stages of the pipeline into stageTiming, see
+	// WithStageTiming
+	meter       metric.Meter
+	stageTiming metric.Float64Histogram
+	// decoderMeter reports decoders, see WithDecoderMeter
+	decoderMeter metric.Meter
+	// decoders are the protocols the pipeline decodes
+	decoders DecoderSet
+	// ownTraffic observes the frames sent by the host like the others
+	ownTraffic bool
+}
+
+// ServiceOption configures a Service
+type ServiceOption func(*Service)
+
+// WithDuplicateMACDetector reports source MACs also seen by the other
+// Services sharing the detector
+func WithDuplicateMACDetector(d *DuplicateMACDetector) ServiceOption {
+	return func(s *Service) {
+		s.duplicates = d
+	}
+}
+
+// WithDADDetector reports the IPv6 Duplicate Address Detection failures
+// seen by the Service, which then captures the Neighbor Discovery messages
+// as well as ARP
+func WithDADDetector(d *DADDetector) ServiceOption {
+	return func(s *Service) {
+		s.dad = d
+	}
+}
+
+// WithPortAuthDetector reports the segments where PXE is likely blocked by
+// 802.1X port authentication, the Service then captures the EAPOL frames
+// and the DHCPv4 messages as well
+func WithPortAuthDetector(d *PortAuthDetector) ServiceOption {
+	return func(s *Service) {
+		s.portAuth = d
+	}
+}
+
+// WithVLANDiscovery records the traffic of the VLANs of d, the Service then
+// captures every tagged frame, and lets DiscoverVLANs probe them
+func WithVLANDiscovery(d *VLANDiscovery) ServiceOption {
+	return func(s *Service) {
+		s.vlans = d
+	}
+}
+
+// WithProxyDetector marks the bindings learned from the proxies d
+// classifies, which then neither challenge a binding observed directly nor
+// violate an assertion. The neighbor advertisements are captured as well
+// as ARP, for d to classify the NDP proxies.
+func WithProxyDetector(d *ProxyDetector) ServiceOption {
+	return func(s *Service) {
+		s.proxies = d
+	}
+}
+
+// WithResponder gives the ARP and Neighbor Discovery frames to r, which
+// answers them for its Mappings and polices them. The frames r wrote are
+// neither observed nor learned from, the Service would otherwise take its
+// answers for those of the hosts. The neighbor solicitations and
+// advertisements are captured as well as ARP.
+func WithResponder(r *Responder) ServiceOption {
+	return func(s *Service) {
+		s.responder = r
+	}
+}
+
+// WithCriticalHostMonitor probes the critical hosts of m through the
+// capture while the Service runs Start, and gives m the answers. The Results
+// of the hosts turning unresponsive or recovering are sent with those of
+// the frames. The neighbor advertisements are captured as well as ARP.
+func WithCriticalHostMonitor(m *CriticalHostMonitor) ServiceOption {
+	return func(s *Service) {
+		s.critical = m
+	}
+}
+
+// WithHostChecker answers CheckHost with c, the probes are sent through the
+// capture while the Service runs Start, and c is given the answers. The
+// neighbor advertisements are captured as well as ARP.
+func WithHostChecker(c *HostChecker) ServiceOption {
+	return func(s *Service) {
+		s.checker = c
+	}
+}
+
+// WithSelfAddressMonitor announces the addresses of m through the capture
+// while the Service runs Start, and gives m the ARP packets and the
+// neighbor advertisements received, whether the host sent them or not. The
+// Results of the addresses stolen or whose announcements are lost are sent
+// with those of the frames.
+func WithSelfAddressMonitor(m *SelfAddressMonitor) ServiceOption {
+	return func(s *Service) {
+		s.selfAddrs = m
+	}
+}
+
+// WithTopology gives the LLDP and CDP advertisements the interface
+// receives to t, which reports the switch port it is connected to. The
+// advertisements of the host itself are ignored.
+func WithTopology(t *Topology) ServiceOption {
+	return func(s *Service) {
+		s.topology = t
+	}
+}
+
+// WithScanCoordinator gives the scan intents the interface receives to c,
+// and sends those of the jobs of the interface c announces while
+// capturing, as TransmitScan, see ScanCoordinator. The intents of the host
+// itself are ignored.
+func WithScanCoordinator(c *ScanCoordinator) ServiceOption {
+	return func(s *Service) {
+		s.coordinator = c
+	}
+}
+
+// WithReorderer orders the observations of the cross-interface detectors
+// with those of the other Services sharing r, see Reorderer
+func WithReorderer(r *Reorderer) ServiceOption {
+	return func(s *Service) {
+		s.reorder = r
+	}
+}
+
+// WithDeduplicator skips the frames d has seen delivered by another
+// interface, d is shared by the Services of the interfaces whose captures
+// overlap
+func WithDeduplicator(d *Deduplicator) ServiceOption {
+	return func(s *Service) {
+		s.dedup = d
+	}
+}
+
+// WithPortAttributor sets the Ingress of the Results of the Service, whose
+// interface is a bridge or bond, to the member port their frame entered on.
+// The members are captured by a, see PortAttributor.Capture.
+func WithPortAttributor(a *PortAttributor) ServiceOption {
+	return func(s *Service) {
+		s.attributor = a
+	}
+}
+
+// WithClock sets the clock timestamping the frames captured without a
+// timestamp and the snapshots
+func WithClock(c clock.Clock) ServiceOption {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithEvidenceLog records the observed bindings in l and attaches their
+// history to the events of a binding changing
+func WithEvidenceLog(l *EvidenceLog) ServiceOption {
+	return func(s *Service) {
+		s.evidence = l
+	}
+}
+
+// WithHistory records the results of the Service in h, for the bindings
+// and the activity of a time past to be looked up
+func WithHistory(h *History) ServiceOption {
+	return func(s *Service) {
+		s.history = h
+	}
+}
+
+// WithSelfMACs recognizes the frames sourced from the host addresses as its
+// own, even when they come back through the capture as inbound frames
+func WithSelfMACs(self SelfMACSource) ServiceOption {
+	return func(s *Service) {
+		s.self = self
+	}
+}
+
+// WithAssertions reports the observations contradicting the assertions as
+// EventBindingViolation, in addition to the events of the binding
+func WithAssertions(a *Assertions) ServiceOption {
+	return func(s *Service) {
+		s.assertions = a
+	}
+}
+
+// WithViolations restores the violations of a previous Snapshot, so an alert
+// outlives a restart until the asserted MAC is observed
+func WithViolations(violations []BindingViolation) ServiceOption {
+	return func(s *Service) {
+		for _, v := range violations {
+			ip, err := netip.ParseAddr(v.IP)
+			if err != nil {
+				continue
+			}
+
+			var vid uint16
+			if v.VID != nil {
+				vid = *v.VID
+			}
+
+			s.violations[bindingKey{ip: ip, vid: vid}] = v
+		}
+	}
+}
+
+// WithOwnTraffic observes the frames sent by the host, which are otherwise
+// ignored, to debug what the agent itself transmits
+func WithOwnTraffic() ServiceOption {
+	return func(s *Service) {
+		s.ownTraffic = true
+	}
+}
+
+// WithParserOptions sets how the frames are decoded, see
+// ethernet.Strictness. The detectors shared by Services are configured on
+// their own.
+func WithParserOptions(o ethernet.ParserOptions) ServiceOption {
+	return func(s *Service) {
+		s.parser = o
+		s.extract = nil
+
+		// the ARP packets of the default options are extracted without
+		// any, which doesn't allocate
+		if o != (ethernet.ParserOptions{}) {
+			s.extract = []ethernet.ExtractOption{ethernet.WithParserOptions(o)}
+		}
+	}
+}
+
+// WithCaptureOptions adds options to the capture started by Start, such as
+// capture.WithPromiscuous
+func WithCaptureOptions(options ...capture.Option) ServiceOption {
+	return func(s *Service) {
+		s.captureOpts = append(s.captureOpts, options...)
+	}
+}
+
+// WithScoreWeights replaces DefaultScoreWeights in deciding which binding
+// wins when observations disagree
+func WithScoreWeights(w ScoreWeights) ServiceOption {
+	return func(s *Service) {
+		s.weights = w
+	}
+}
+
+// WithLimits bounds the bindings of the Service, the least recently
+// observed ones are evicted first for new ones
+func WithLimits(l Limits) ServiceOption {
+	return func(s *Service) {
+		if l.Bindings > 0 {
+			s.maxBindings = l.Bindings
+		}
+	}
+}
+
+// WithTableShards splits the neighbor table into n shards, each with its
+// own lock, so that the capture loop, the other observers and the readers
+// such as Snapshot contend less. A table bounded to few bindings, see
+// WithLimits, has less shards. 1 shard is a single lock for the table.
+func WithTableShards(n int) ServiceOption {
+	return func(s *Service) {
+		if n > 0 {
+			s.shards = n
+		}
+	}
+}
+
+// WithTransmitGuard configures the capture.GuardedWriter checking the
+// frames the Service sends, such as the probes of DiscoverVLANs, are
+// sourced from its interface
+func WithTransmitGuard(options ...capture.GuardOption) ServiceOption {
+	return func(s *Service) {
+		s.guard = append(s.guard, options...)
+	}
+}
+
+// WithTransmitQueue sends the frames of the Service through q, which it
+// runs while capturing: the announcements of its addresses as
+// TransmitAnnouncement, its probes as TransmitScan. The other senders of
+// the interface, such as a Responder, share q with Writer.
+func WithTransmitQueue(q *TransmitQueue) ServiceOption {
+	return func(s *Service) {
+		s.transmit = q
+	}
+}
+
+// WithTransmitGate holds the frames of the Service while g is in standby,
+// its probes, its announcements and those of its scan coordination. The
+// other senders of the interface, such as a Responder, share g with
+// Writer.
+func WithTransmitGate(g *TransmitGate) ServiceOption {
+	return func(s *Service) {
+		s.gate = g
+	}
+}
+
+// writer returns the writer of the frames of class on conn, checked by the
+// guard, queued by the TransmitQueue of the Service if any and held by its
+// TransmitGate in standby
+func (s *Service) writer(class TransmitClass, conn *capture.Conn) capture.FrameWriter {
+	var w capture.FrameWriter = capture.NewGuardedWriter(conn, s.iface, s.guard...)
+
+	if s.transmit != nil {
+		w = s.transmit.Writer(class, w)
+	}
+
+	if s.gate != nil {
+		w = s.gate.Writer(class, w)
+	}
+
+	return w
+}
+
+// WithTargetRing copies the frames matching the target set with SetTarget
+// into ring, for a later download as a pcap file
+func WithTargetRing(ring *capture.PcapRing) ServiceOption {
+	return func(s *Service) {
+		s.ring = ring
+	}
 }
 
 // NewService returns a pointer to a Service. It
 // takes the desired interface to observe's name as an argument
-func NewService(iface string) *Service {
-	return &Service{
-		iface:    iface,
-		bindings: make(map[string]Binding),
+func NewService(iface string, options ...ServiceOption) *Service {
+	s := &Service{
+		iface:       iface,
+		violations:  make(map[bindingKey]BindingViolation),
+		weights:     DefaultScoreWeights(),
+		clock:       clock.System{},
+		maxBindings: defaultBindings,
+		shards:      defaultShards,
+		layers:      ethernet.Registered(),
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	if s.decoders == 0 {
+		s.decoders = DefaultDecoders
+	}
+
+	s.layers = s.decoders.layers(s.layers)
+	s.table = newBindingTable(s.shards, s.maxBindings)
+
+	if s.meter != nil {
+		s.registerStageTiming(s.meter)
 	}
+
+	if s.decoderMeter != nil {
+		s.registerDecoders(s.decoderMeter)
+	}
+
+	return s
 }
 
 func (s *Service) updateBindings(pkt *ethernet.ARPPacket, vid *uint16, timestamp time.Time) []Result {
 	var res []Result
 
 	if timestamp.IsZero() {
-		timestamp = time.Now()
+		timestamp = s.clock.Now()
 	}
 
-	var vidLabel int
-	if vid != nil {
-		vidLabel = int(*vid)
+	kind := ObservationARPRequest
+	if pkt.OpCode == ethernet.OpReply {
+		kind = ObservationARPReply
 	}
 
 	discoveredBindings := []Binding{
 		{
-			IP:   pkt.SendIPAddr,
+			IP:   pkt.SenderAddr(),
 			MAC:  pkt.SendHwAddr,
 			VID:  vid,
 			Time: timestamp,
+			Kind: kind,
 		},
 	}
 
 	if pkt.OpCode == ethernet.OpReply {
 		discoveredBindings = append(discoveredBindings, Binding{
-			IP:   pkt.TgtIPAddr,
+			IP:   pkt.TargetAddr(),
 			MAC:  pkt.TgtHwAddr,
 			VID:  vid,
 			Time: timestamp,
+			Kind: kind,
 		})
 	}
 
-	prefix := strconv.Itoa(vidLabel) + "_"
+	// a proxy answers on behalf of other hosts, only replies tell of it
+	if s.proxies != nil && pkt.OpCode == ethernet.OpReply &&
+		s.proxies.Observe(pkt.SendHwAddr, pkt.SenderAddr(), false) {
+		s.markProxy(pkt.SendHwAddr)
+	}
 
 	for _, discoveredBinding := range discoveredBindings {
-		key := prefix + discoveredBinding.IP.String()
-
-		binding, ok := s.bindings[key]
-		if !ok {
-			s.bindings[key] = discoveredBinding
-			res = append(res, Result{
-				IP:    discoveredBinding.IP.String(),
-				MAC:   discoveredBinding.MAC.String(),
-				VID:   discoveredBinding.VID,
-				Time:  discoveredBinding.Time.Unix(),
-				Event: EventNew,
-			})
-
-			continue
-		}
-
-		if !bytes.Equal(binding.MAC, discoveredBinding.MAC) {
-			s.bindings[key] = discoveredBinding
-			res = append(res, Result{
-				IP:          discoveredBinding.IP.String(),
-				PreviousMAC: binding.MAC.String(),
-				MAC:         discoveredBinding.MAC.String(),
-				VID:         discoveredBinding.VID,
-				Time:        discoveredBinding.Time.Unix(),
-				Event:       EventMoved,
-			})
-		} else if discoveredBinding.Time.Sub(binding.Time) >= seenAgainThreshold {
-			s.bindings[key] = discoveredBinding
-			res = append(res, Result{
-				IP:    discoveredBinding.IP.String(),
-				MAC:   discoveredBinding.MAC.String(),
-				VID:   discoveredBinding.VID,
-				Time:  discoveredBinding.Time.Unix(),
-				Event: EventRefreshed,
-			})
+		res = append(res, s.observe(discoveredBinding)...)
+	}
+
+	return res
+}
+
+// markProxy marks the bindings of a MAC newly classified as a proxy, and
+// drops the challenges it made
+func (s *Service) markProxy(mac net.HardwareAddr) {
+	log.Info().Str("mac", mac.String()).Str("iface", s.iface).Msg("MAC classified as a proxy")
+
+	s.table.update(func(sh *bindingShard) {
+		for key, b := range sh.bindings {
+			if bytes.Equal(b.MAC, mac) {
+				b.ViaProxy = true
+				sh.bindings[key] = b
+			}
+		}
+
+		for key, c := range sh.challengers {
+			if bytes.Equal(c.MAC, mac) {
+				delete(sh.challengers, key)
+			}
 		}
+	})
+}
+
+// Observe records a binding seen by another observer than the capture of
+// the Service, such as a DHCP server acknowledging a lease, and returns the
+// Results it produces for the caller to deliver
+func (s *Service) Observe(kind ObservationKind, ip netip.Addr, mac net.HardwareAddr, vid *uint16,
+	timestamp time.Time) []Result {
+	if timestamp.IsZero() {
+		timestamp = s.clock.Now()
+	}
+
+	res := s.observe(Binding{
+		IP:   ip,
+		MAC:  slices.Clone(mac),
+		VID:  vid,
+		Time: timestamp,
+		Kind: kind,
+	})
+
+	s.label(res)
+
+	if s.history != nil {
+		s.history.record(s.iface, res)
 	}
 
 	return res
 }
 
+// observe updates the bindings with an observation, a conflicting one only
+// replaces the binding once it scores at least as high. Only the shard of
+// the binding is locked, the observations of other hosts go on.
+func (s *Service) observe(discoveredBinding Binding) []Result {
+	var (
+		res      []Result
+		vidLabel uint16
+	)
+
+	if discoveredBinding.VID != nil {
+		vidLabel = *discoveredBinding.VID
+	}
+
+	key := bindingKey{ip: discoveredBinding.IP, vid: vidLabel}
+
+	if s.proxies != nil {
+		discoveredBinding.ViaProxy = s.proxies.IsProxy(discoveredBinding.MAC)
+	}
+
+	if s.identities != nil && !discoveredBinding.ViaProxy {
+		s.identities.seen(discoveredBinding.MAC, discoveredBinding.Time)
+	}
+
+	sh := s.table.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if s.evidence != nil {
+		s.evidence.record(discoveredBinding, s.iface)
+	}
+
+	if v, ok := s.checkAssertions(key, discoveredBinding); ok {
+		res = append(res, v)
+	}
+
+	binding, ok := sh.bindings[key]
+
+	// a kernel or primed entry doesn't make the first observation of a
+	// binding any less new, and a different MAC is reported as moved all
+	// the same
+	if ok && (binding.Source == BindingSourceKernel || binding.Source == BindingSourcePrimed) &&
+		bytes.Equal(binding.MAC, discoveredBinding.MAC) {
+		ok = false
+	}
+
+	if !ok {
+		s.table.bind(sh, key, discoveredBinding)
+		delete(sh.challengers, key)
+
+		return append(res, Result{
+			IP:    discoveredBinding.IP.String(),
+			MAC:   discoveredBinding.MAC.String(),
+			VID:   discoveredBinding.VID,
+			Time:  discoveredBinding.Time.Unix(),
+			Event: EventNew,
+		})
+	}
+
+	if !bytes.Equal(binding.MAC, discoveredBinding.MAC) {
+		// a proxy replying for a host doesn't tell the host moved
+		if discoveredBinding.ViaProxy && !binding.ViaProxy {
+			log.Debug().Str("ip", binding.IP.String()).Str("mac", binding.MAC.String()).
+				Str("proxy", discoveredBinding.MAC.String()).Msg("Keeping the binding observed directly")
+
+			return res
+		}
+
+		challenger := discoveredBinding
+
+		// a challenger seen again is corroborated like a binding
+		if c, ok := sh.challengers[key]; ok && bytes.Equal(c.MAC, discoveredBinding.MAC) {
+			challenger = s.weights.corroborate(c, discoveredBinding)
+			challenger.Time = discoveredBinding.Time
+			challenger.VID = discoveredBinding.VID
+		}
+
+		// the host itself replaces what a proxy said of it, whatever the
+		// scores
+		direct := binding.ViaProxy && !challenger.ViaProxy
+
+		now := discoveredBinding.Time
+		if !direct && s.weights.Score(challenger, now) < s.weights.Score(binding, now) {
+			sh.challengers[key] = challenger
+
+			log.Debug().Str("ip", binding.IP.String()).Str("mac", binding.MAC.String()).
+				Str("challenger", challenger.MAC.String()).Msg("Keeping the binding with the higher score")
+
+			return res
+		}
+
+		delete(sh.challengers, key)
+		sh.bindings[key] = challenger
+
+		if binding.Source == BindingSourcePrimed && s.primer != nil {
+			s.primer.replaced()
+		}
+
+		return append(res, Result{
+			IP:          discoveredBinding.IP.String(),
+			PreviousMAC: binding.MAC.String(),
+			MAC:         discoveredBinding.MAC.String(),
+			VID:         discoveredBinding.VID,
+			Time:        discoveredBinding.Time.Unix(),
+			Event:       EventMoved,
+			Evidence:    s.movedEvidence(discoveredBinding, binding.MAC),
+		})
+	}
+
+	binding = s.weights.corroborate(binding, discoveredBinding)
+	binding.ViaProxy = discoveredBinding.ViaProxy
+
+	if discoveredBinding.Time.Sub(binding.Time) >= seenAgainThreshold {
+		binding.Time = discoveredBinding.Time
+		binding.VID = discoveredBinding.VID
+		res = append(res, Result{
+			IP:    discoveredBinding.IP.String(),
+			MAC:   discoveredBinding.MAC.String(),
+			VID:   discoveredBinding.VID,
+			Time:  discoveredBinding.Time.Unix(),
+			Event: EventRefreshed,
+		})
+	}
+
+	sh.bindings[key] = binding
+
+	return res
+}
+
+// Evictions returns the number of bindings evicted for new ones since the
+// Service was created
+func (s *Service) Evictions() uint64 {
+	return s.table.evictions.Load()
+}
+
+func (s *Service) movedEvidence(b Binding, previous net.HardwareAddr) *ResultEvidence {
+	if s.evidence == nil {
+		return nil
+	}
+
+	return &ResultEvidence{
+		IP:          s.evidence.ByIP(b.IP),
+		MAC:         s.evidence.ByMAC(b.MAC),
+		PreviousMAC: s.evidence.ByMAC(previous),
+	}
+}
+
 func isValidARPPacket(pkt *ethernet.ARPPacket) bool {
 	if pkt.HardwareType != ethernet.HardwareTypeEthernet && pkt.HardwareType != ethernet.HardwareTypeExpEth {
 		return false
@@ -184,51 +853,411 @@ func isValidARPPacket(pkt *ethernet.ARPPacket) bool {
 	return true
 }
 
-func (s *Service) handlePacket(pkt pcap.Packet) ([]Result, error) {
-	if pkt.Error != nil {
-		return nil, pkt.Error
+// handleFrame extracts the bindings of an ARP frame. The VLAN comes from the
+// 802.1Q header, or from the capture metadata when the NIC stripped the tag.
+func (s *Service) handleFrame(frame []byte, md capture.Metadata) ([]Result, error) {
+	return s.handle(nil, frame, md)
+}
+
+// handle is handleFrame entering the stages of p
+func (s *Service) handle(p *pipeline, frame []byte, md capture.Metadata) ([]Result, error) {
+	native := s.nativeVLAN()
+
+	res, err := s.decode(p, frame, md, native)
+	if native != nil {
+		markUntagged(res, native)
 	}
 
-	if len(pkt.B) == 0 {
+	return res, err
+}
+
+// decode is handle attributing the frames received untagged to native
+func (s *Service) decode(p *pipeline, frame []byte, md capture.Metadata, native *uint16) ([]Result, error) {
+	if len(frame) == 0 {
 		return nil, ErrEmptyPacket
 	}
 
+	p.enter(StageDecode)
+
 	eth := &ethernet.EthernetFrame{}
 
-	err := eth.UnmarshalBinary(pkt.B)
+	// what the snaplen cut off is told apart from what is malformed
+	err := eth.UnmarshalCaptured(frame, md.Length)
 	if err != nil {
 		return nil, err
 	}
 
-	if eth.EthernetType != ethernet.EthernetTypeVLAN && eth.EthernetType != ethernet.EthernetTypeARP {
+	p.enter(StageFilter)
+
+	// the frames of the protocols which aren't decoded go no further
+	neighbors := s.observesNDP()
+	arpFrame := s.decoders.Has(DecoderARP) && eth.EthernetType == ethernet.EthernetTypeARP
+	ndpFrame := neighbors && eth.EthernetType == ethernet.EthernetTypeIPv6
+	portAuthFrame := s.portAuth != nil && s.decoders.portAuthType(eth.EthernetType)
+	layerFrame := s.layers.Handles(eth.EthernetType)
+	topologyFrame := s.topology != nil && s.decoders.topologyType(eth.EthernetType)
+	intentFrame := s.coordinator != nil && eth.EthernetType == scanIntentEtherType
+
+	if !ethernet.IsTPID(eth.EthernetType) && !arpFrame &&
+		!ndpFrame && !portAuthFrame && !layerFrame && !topologyFrame && !intentFrame {
 		log.Debug().Msg("skipping non-ARP packet")
 		return nil, nil
 	}
 
-	var vid *uint16
+	var (
+		vid     *uint16
+		stack   [ethernet.MaxTags + 1]ethernet.Tag
+		tags    []ethernet.Tag
+		payload = eth.Payload
+	)
 
-	if eth.EthernetType == ethernet.EthernetTypeVLAN {
-		var vlan *ethernet.VLAN
+	if ethernet.IsTPID(eth.EthernetType) {
+		var inner ethernet.EthernetType
 
-		vlan, err = eth.ExtractVLAN()
+		p.enter(StageDecode)
+
+		tags = stack[:0]
+
+		// the tag the NIC stripped was the outermost
+		if md.VLAN.Valid {
+			tags = append(tags, strippedTag(md.VLAN))
+		}
+
+		tags, inner, payload, err = eth.AppendTagsWith(tags, s.parser)
 		if err != nil {
 			return nil, err
 		}
 
-		vid = &vlan.ID
+		if err := s.parser.CheckTags(tags); err != nil {
+			return nil, err
+		}
+
+		// the hosts are on the innermost VLAN, the outer tags are the path
+		// the provider bridges it over
+		p.enter(StageFilter)
+
+		id := tags[len(tags)-1].VID
+		vid = &id
+		arpFrame = s.decoders.Has(DecoderARP) && inner == ethernet.EthernetTypeARP
+		ndpFrame = neighbors && inner == ethernet.EthernetTypeIPv6
+		portAuthFrame = s.portAuth != nil && s.decoders.portAuthType(inner)
+		layerFrame = s.layers.Handles(inner)
+		topologyFrame = s.topology != nil && s.decoders.topologyType(inner)
+		intentFrame = s.coordinator != nil && inner == scanIntentEtherType
+
+		// the probes of the host prove nothing of the VLAN, and the frames
+		// captured only for their VLAN have nothing else to observe. The
+		// VLANs of the link are the outermost tags.
+		if s.vlans != nil && !s.sentByHost(eth.SrcMAC, md) {
+			s.vlans.Observe(frame, tags[0].VID, md.Timestamp)
+		}
+
+		if s.vlans != nil && !arpFrame && !ndpFrame && !portAuthFrame && !layerFrame && !topologyFrame &&
+			!intentFrame {
+			return nil, nil
+		}
+	} else if md.VLAN.Valid {
+		id := md.VLAN.ID()
+		vid = &id
+
+		if s.vlans != nil && !s.sentByHost(eth.SrcMAC, md) {
+			s.vlans.Observe(frame, id, md.Timestamp)
+		}
+	} else {
+		vid = native
+	}
+
+	// the intents tell nothing of the hosts, whoever sent them
+	if intentFrame {
+		if !s.sentByHost(eth.SrcMAC, md) {
+			p.enter(StageObserve)
+			s.coordinator.observe(s.iface, payload)
+		}
+
+		return nil, nil
+	}
+
+	// the OFFERs of a DHCP server running on the host answer the DISCOVERs
+	// like any other, so the frames it sends are observed too
+	if portAuthFrame {
+		p.enter(StageObserve)
+
+		res := s.observePortAuth(frame, vid, md.Timestamp)
+		if len(res) > 0 || !layerFrame {
+			return res, nil
+		}
+
+		p.enter(StageFilter)
+	}
+
+	// the advertisements of the switch tell nothing of the hosts, and
+	// those of the host nothing of the switch
+	if topologyFrame {
+		if s.sentByHost(eth.SrcMAC, md) {
+			return nil, nil
+		}
+
+		p.enter(StageObserve)
+
+		res := s.topology.Observe(s.iface, frame, s.parser, md)
+
+		return append(res, s.observeNativeVLAN(frame, md)...), nil
+	}
+
+	var res []Result
+
+	neighborFrame := arpFrame || ndpFrame
+
+	// the answers of the responder never reach the bindings, whether the
+	// frames of the host are observed or not
+	if s.responder != nil && neighborFrame {
+		p.enter(StageObserve)
+
+		found, own := s.responder.Observe(frame, vid, md)
+		if own {
+			return nil, nil
+		}
+
+		res = found
+
+		p.enter(StageFilter)
+	}
+
+	if s.critical != nil && neighborFrame {
+		p.enter(StageObserve)
+		s.critical.Observe(frame, vid, md)
+		p.enter(StageFilter)
+	}
+
+	if s.checker != nil && neighborFrame {
+		p.enter(StageObserve)
+		s.checker.Observe(s.iface, frame, vid, md)
+		p.enter(StageFilter)
+	}
+
+	if s.selfAddrs != nil && neighborFrame {
+		p.enter(StageObserve)
+		res = append(res, s.selfAddrs.Observe(frame, vid, md)...)
+		p.enter(StageFilter)
+	}
+
+	if !s.ownTraffic && s.sentByHost(eth.SrcMAC, md) {
+		log.Debug().Msg("skipping packet sent by the host")
+		return res, nil
+	}
+
+	p.enter(StageObserve)
+
+	// a frame the host sends goes out of every port of a bridge, only
+	// received frames tell where a MAC is
+	if s.duplicates != nil && md.Direction != capture.DirectionOutbound {
+		if s.reorder == nil {
+			res = append(res, s.observeDuplicate(eth.SrcMAC, vid, md.Timestamp)...)
+		} else {
+			// the frame is reused once handled, the observation may be held
+			mac := slices.Clone(eth.SrcMAC)
+
+			res = append(res, s.reorder.Push(s.iface, md, func(timestamp time.Time) []Result {
+				return s.observeDuplicate(mac, vid, timestamp)
+			})...)
+		}
+	}
+
+	if ndpFrame {
+		found := s.observeNDP(frame, eth.SrcMAC, vid, md)
+		if len(found) > 0 || !layerFrame {
+			return append(res, found...), nil
+		}
+	}
+
+	// the registered protocols are decoded after the built-in ones
+	if layerFrame {
+		if layer, ok := s.observeLayer(frame, eth.SrcMAC, vid, md.Timestamp); ok {
+			return append(res, layer), nil
+		}
 	}
 
-	arpPkt, err := eth.ExtractARPPacket()
+	if !arpFrame {
+		log.Debug().Stringer("ethertype", eth.EthernetType).Msg("skipping frame of no decoder")
+		return res, nil
+	}
+
+	arpPkt, err := eth.ExtractARPPacket(s.extract...)
+	if errors.Is(err, ethernet.ErrNotARP) {
+		// frames of every type are read when the reader doesn't support
+		// the capture filter
+		log.Debug().Stringer("ethertype", eth.EthernetType).Msg("skipping non-ARP frame")
+		return res, nil
+	}
+
+	// the observations released are returned with the error, they are
+	// about earlier frames
 	if err != nil {
-		return nil, err
+		return res, err
 	}
 
 	if !isValidARPPacket(arpPkt) {
 		log.Debug().Msg("skipping non-ethernet+IPv4 ARP packet")
-		return nil, nil
+		return res, nil
+	}
+
+	bound := s.updateBindings(arpPkt, vid, md.Timestamp)
+
+	// the bindings are attributed to the innermost VLAN, the results keep
+	// the path to it
+	if len(tags) > 1 {
+		stacked := slices.Clone(tags)
+
+		for i := range bound {
+			bound[i].Tags = stacked
+		}
+	}
+
+	return append(res, bound...), nil
+}
+
+// observeDuplicate returns the DuplicateMACLocation of a frame from mac, if
+// any
+func (s *Service) observeDuplicate(mac net.HardwareAddr, vid *uint16, timestamp time.Time) []Result {
+	dup, ok := s.duplicates.Observe(mac, s.iface, vid, timestamp)
+	if !ok {
+		return nil
+	}
+
+	log.Warn().Str("mac", dup.MAC).Str("iface", dup.Locations[0].Interface).
+		Str("other_iface", dup.Locations[1].Interface).Msg("MAC seen on more than one interface")
+
+	r := Result{
+		MAC:       dup.MAC,
+		VID:       vid,
+		Time:      dup.Locations[1].LastSeen,
+		Event:     EventDuplicateMACLocation,
+		Duplicate: &dup,
+	}
+
+	// a held observation is correlated once the frames of other MACs
+	// were handled, the history is the one of mac
+	if s.evidence != nil {
+		r.Evidence = &ResultEvidence{MAC: s.evidence.ByMAC(mac)}
+	}
+
+	return []Result{r}
+}
+
+// observeNDP returns the DAD conflict a Neighbor Discovery frame reveals,
+// if any, and gives the advertisements to the proxy detector
+func (s *Service) observeNDP(frame []byte, src net.HardwareAddr, vid *uint16, md capture.Metadata) []Result {
+	msg, pkt, err := ndp.ParseFrameWith(frame, s.parser)
+	if err != nil {
+		err = ethernet.Snapped(err, md.CaptureLength, md.Length)
+
+		switch {
+		case errors.Is(err, ethernet.ErrDecodeLimitExceeded):
+			s.limited.Add(1)
+			log.Debug().Err(err).Msg("skipping IPv6 frame past the decode limits")
+		case errors.Is(err, ethernet.ErrTruncatedBySnaplen):
+			s.truncated.Add(1)
+			log.Debug().Err(err).Msg("skipping IPv6 frame cut by the snaplen")
+		case errors.Is(err, ndp.ErrMalformedMessage) || errors.Is(err, mld.ErrMalformedPacket):
+			s.malformed.Add(1)
+			log.Debug().Err(err).Msg("skipping malformed IPv6 frame")
+		default:
+			log.Debug().Err(err).Msg("skipping non-NDP IPv6 frame")
+		}
+
+		return nil
+	}
+
+	if s.proxies != nil && msg.Type == ndp.TypeNeighborAdvertisement &&
+		s.proxies.Observe(src, msg.Target, msg.Router) {
+		s.markProxy(src)
 	}
 
-	return s.updateBindings(arpPkt, vid, pkt.Info.Timestamp), nil
+	if s.dad == nil {
+		return nil
+	}
+
+	if s.reorder == nil {
+		return s.observeDAD(msg, pkt.Src, src, vid, md.Timestamp)
+	}
+
+	// the frame is reused once handled, the observation may be held
+	src = slices.Clone(src)
+	msg.LinkLayerAddr = slices.Clone(msg.LinkLayerAddr)
+
+	return s.reorder.Push(s.iface, md, func(timestamp time.Time) []Result {
+		return s.observeDAD(msg, pkt.Src, src, vid, timestamp)
+	})
+}
+
+// observeDAD returns the DAD conflict of a Neighbor Discovery message sent
+// from ip by mac, if any
+func (s *Service) observeDAD(msg ndp.Message, ip netip.Addr, mac net.HardwareAddr, vid *uint16,
+	timestamp time.Time) []Result {
+	if timestamp.IsZero() {
+		timestamp = s.clock.Now()
+	}
+
+	conflict, ok := s.dad.Observe(msg, ip, mac, s.iface, vid, timestamp)
+	if !ok {
+		return nil
+	}
+
+	log.Warn().Str("tentative", conflict.Tentative).Str("mac", conflict.SolicitingMAC).
+		Str("defending_mac", conflict.DefendingMAC).Msg("IPv6 duplicate address detection failed")
+
+	return []Result{{
+		IP:    conflict.Tentative,
+		MAC:   conflict.SolicitingMAC,
+		VID:   vid,
+		Time:  timestamp.Unix(),
+		Event: EventDADConflict,
+		DAD:   &conflict,
+	}}
+}
+
+// observePortAuth gives an EAPOL or IPv4 frame to the port authentication
+// detector and returns the finding it raises or clears, if any
+func (s *Service) observePortAuth(frame []byte, vid *uint16, timestamp time.Time) []Result {
+	if timestamp.IsZero() {
+		timestamp = s.clock.Now()
+	}
+
+	finding, event := s.portAuth.Observe(frame, s.iface, vid, timestamp)
+
+	switch event {
+	case EventPortAuthenticationSuspected:
+		log.Warn().Str("authenticator", finding.Authenticator).Int("unanswered_discovers", finding.UnansweredDiscovers).
+			Int("clients", finding.Clients).Msg("PXE likely blocked by 802.1X port authentication")
+	case EventPortAuthenticationCleared:
+		log.Info().Str("authenticator", finding.Authenticator).Msg("DHCP DISCOVERs answered again behind the 802.1X authenticator")
+	default:
+		return nil
+	}
+
+	return []Result{{
+		MAC:      finding.Authenticator,
+		VID:      vid,
+		Time:     timestamp.Unix(),
+		Event:    event,
+		PortAuth: &finding,
+	}}
+}
+
+// isPortAuthType returns true for the ethernet types the port
+// authentication detector observes
+func isPortAuthType(t ethernet.EthernetType) bool {
+	return t == eapol.EthernetType || t == ethernet.EthernetTypeIPv4
+}
+
+// sentByHost returns true for the frames the host sent, the probes and
+// announcements of the agent would otherwise be observed as neighbours
+func (s *Service) sentByHost(src net.HardwareAddr, md capture.Metadata) bool {
+	if md.Direction == capture.DirectionOutbound {
+		return true
+	}
+
+	return s.self != nil && s.self.Contains(src)
 }
 
 func isRecoverableError(err error) bool {
@@ -237,46 +1266,507 @@ func isRecoverableError(err error) bool {
 		ethernet.ErrMalformedARPPacket) || errors.Is(err, ethernet.ErrMalformedVLAN) || errors.Is(err, ethernet.ErrMalformedFrame)
 }
 
-// Start will start packet capture and send results to a channel
+// arpFilter accepts ARP frames, with or without an 802.1Q tag
+func arpFilter() ([]bpf.RawInstruction, error) {
+	return bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeARP), SkipFalse: 1},
+		bpf.RetConstant{Val: uint32(snapLen)},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeVLAN), SkipFalse: 3},
+		bpf.LoadAbsolute{Off: 16, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeARP), SkipFalse: 1},
+		bpf.RetConstant{Val: uint32(snapLen)},
+		bpf.RetConstant{Val: 0},
+	})
+}
+
+// ndpFilter accepts what arpFilter does, and the neighbor solicitations and
+// advertisements without IPv6 extension headers, tagged or not
+func ndpFilter() ([]bpf.RawInstruction, error) {
+	const (
+		nextHeaderOff = 14 + 6
+		icmpTypeOff   = 14 + 40
+	)
+
+	return bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeARP), SkipTrue: 14},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeIPv6), SkipTrue: 6},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeVLAN), SkipFalse: 13},
+		bpf.LoadAbsolute{Off: 16, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeARP), SkipTrue: 10},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeIPv6), SkipFalse: 10},
+		// the IPv6 header follows the tag
+		bpf.LoadConstant{Dst: bpf.RegX, Val: 4},
+		bpf.Jump{Skip: 1},
+		bpf.LoadConstant{Dst: bpf.RegX, Val: 0},
+		bpf.LoadIndirect{Off: nextHeaderOff, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(mld.NextHeaderICMPv6), SkipFalse: 5},
+		bpf.LoadIndirect{Off: icmpTypeOff, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpLessThan, Val: uint32(ndp.TypeNeighborSolicitation), SkipTrue: 3},
+		bpf.JumpIf{Cond: bpf.JumpGreaterThan, Val: uint32(ndp.TypeNeighborAdvertisement), SkipTrue: 2},
+		bpf.RetConstant{Val: uint32(ndpSnapLen)},
+		bpf.RetConstant{Val: uint32(snapLen)},
+		bpf.RetConstant{Val: 0},
+	})
+}
+
+// stackedARPFilter wraps base to also accept the ARP frames carrying a
+// service tag, or two customer tags, before the customer tag
+func stackedARPFilter(base []bpf.RawInstruction) ([]bpf.RawInstruction, error) {
+	prefix, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeQinQ), SkipTrue: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeQinQLegacy), SkipTrue: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeVLAN), SkipFalse: 5},
+		bpf.LoadAbsolute{Off: 16, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeVLAN), SkipFalse: 3},
+		bpf.LoadAbsolute{Off: 20, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeARP), SkipFalse: 1},
+		bpf.RetConstant{Val: uint32(snapLen)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(prefix, base...), nil
+}
+
+// strippedTag returns the tag the NIC stripped as v
+func strippedTag(v capture.VLANInfo) ethernet.Tag {
+	return ethernet.Tag{
+		TPID:     cmp.Or(ethernet.EthernetType(v.TPID), ethernet.EthernetTypeVLAN),
+		VID:      v.ID(),
+		Priority: uint8(v.TCI >> 13), //nolint:gosec // the priority is the 3 top bits
+	}
+}
+
+// observesNDP returns true when a detector of the Service reads the
+// Neighbor Discovery messages
+func (s *Service) observesNDP() bool {
+	return s.decoders.Has(DecoderNDP) && (s.dad != nil || s.proxies != nil || s.responder != nil ||
+		s.critical != nil || s.checker != nil || s.selfAddrs != nil)
+}
+
+// observesPortAuth returns true when the port authentication detector
+// observes frames of the decoders
+func (s *Service) observesPortAuth() bool {
+	return s.portAuth != nil && (s.decoders.Has(DecoderDHCP) || s.decoders.Has(DecoderEAPOL))
+}
+
+// observesTopology returns true when the Topology observes frames of the
+// decoders
+func (s *Service) observesTopology() bool {
+	return s.topology != nil && (s.decoders.Has(DecoderLLDP) || s.decoders.Has(DecoderCDP))
+}
+
+// captureFilter returns the filter of the frames the Service handles
+func (s *Service) captureFilter() ([]bpf.RawInstruction, error) {
+	var (
+		filter []bpf.RawInstruction
+		err    error
+	)
+
+	if s.observesNDP() {
+		filter, err = ndpFilter()
+	} else {
+		filter, err = arpFilter()
+	}
+
+	if err == nil {
+		filter, err = stackedARPFilter(filter)
+	}
+
+	if err == nil && s.observesPortAuth() {
+		filter, err = portAuthFilter(filter)
+	}
+
+	if err == nil && s.observesTopology() {
+		filter, err = topologyFilter(filter)
+	}
+
+	if err == nil && s.vlans != nil {
+		filter, err = s.vlanDiscoveryFilter(filter)
+	}
+
+	if err == nil && s.coordinator != nil {
+		filter, err = layerFilter(filter, []ethernet.EthernetType{scanIntentEtherType}, nil)
+	}
+
+	// the frames of the registered protocols are captured in full, before
+	// any other filter truncates them
+	if err == nil && s.layers != nil {
+		filter, err = layerFilter(filter, s.layers.EtherTypes(), s.layers.UDPPorts())
+	}
+
+	return filter, err
+}
+
+// vlanDiscoveryFilter wraps filter to also capture the frames the VLAN
+// discovery observes
+func (s *Service) vlanDiscoveryFilter(filter []bpf.RawInstruction) ([]bpf.RawInstruction, error) {
+	// the frames the other filters capture in full are left to them
+	var deferred []ethernet.EthernetType
+
+	if s.observesNDP() {
+		deferred = append(deferred, ethernet.EthernetTypeIPv6)
+	}
+
+	if s.observesPortAuth() {
+		deferred = append(deferred, ethernet.EthernetTypeIPv4, eapol.EthernetType)
+	}
+
+	if s.observesTopology() {
+		deferred = append(deferred, ethernet.EthernetTypeLLDP)
+	}
+
+	return vlanFilter(filter, deferred...)
+}
+
+// Start will start packet capture and send results to a channel, it
+// returns nil once ctx is done and closes the channel. Without
+// CapabilityNetRaw, it captures nothing until then, see WithCapabilities.
 func (s *Service) Start(ctx context.Context, resultC chan<- Result) error {
 	defer close(resultC)
 
-	hndlr, err := pcap.OpenLive(s.iface, snapLen, false, timeout, true)
+	if s.Capabilities().Lacks(CapabilityNetRaw) {
+		<-ctx.Done()
+		return nil
+	}
+
+	filter, err := s.captureFilter()
 	if err != nil {
 		return err
 	}
 
-	defer hndlr.Close()
+	conn, err := capture.Listen(s.iface, append(slices.Clone(s.captureOpts), capture.WithFilter(filter))...)
+	if errors.Is(err, capture.ErrNoRawAccess) {
+		return s.degrade(ctx, err)
+	}
 
-	err = hndlr.SetBPFFilter("ether proto arp")
 	if err != nil {
 		return err
 	}
 
-	pkts := hndlr.Listen()
+	defer conn.Close() //nolint:errcheck // nothing is read from conn anymore
+
+	options := []capture.TargetedReaderOption{capture.WithBaseFilter(filter)}
+	if s.ring != nil {
+		options = append(options, capture.WithTargetRing(s.ring))
+	}
+
+	targeted := capture.NewTargetedReader(conn, options...)
+
+	s.targetMu.Lock()
+
+	if !s.target.Empty() {
+		if err := targeted.SetTarget(s.target); err != nil {
+			s.targetMu.Unlock()
+			return err
+		}
+	}
+
+	s.targeted = targeted
+	s.conn = conn
+	s.targetMu.Unlock()
+
+	defer func() {
+		s.targetMu.Lock()
+		s.targeted = nil
+		s.conn = nil
+		s.targetMu.Unlock()
+	}()
+
+	if s.coordinator != nil {
+		defer s.coordinator.attach(s.iface, conn.Interface().HardwareAddr, s.writer(TransmitScan, conn))()
+	}
+
+	monitors := make(map[TransmitClass]monitor)
+
+	if s.critical != nil {
+		monitors[TransmitScan] = s.critical.Run
+	}
+
+	if s.selfAddrs != nil {
+		monitors[TransmitAnnouncement] = s.selfAddrs.Run
+	}
+
+	if len(monitors) > 0 || s.transmit != nil {
+		monitorCtx, cancel := context.WithCancel(ctx)
+
+		var wg sync.WaitGroup
+
+		for class, run := range monitors {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				s.runMonitor(monitorCtx, run, s.writer(class, conn), resultC)
+			}()
+		}
+
+		if s.transmit != nil {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				s.transmit.Run(monitorCtx) //nolint:errcheck // Run only stops with its context
+			}()
+		}
+
+		// resultC is only closed once the monitors are done with it
+		defer func() {
+			cancel()
+			wg.Wait()
+		}()
+	}
+
+	return s.run(ctx, targeted, resultC)
+}
+
+// Serve observes the frames read from r rather than captured on the
+// interface, such as a recording or a synthetic feed. The target and the
+// capture filter don't apply. It returns nil once ctx is done or r returns
+// io.EOF, and closes the channel.
+func (s *Service) Serve(ctx context.Context, r capture.FrameReader, resultC chan<- Result) error {
+	defer close(resultC)
+
+	return s.run(ctx, r, resultC)
+}
+
+// monitor is the Run method of a prober of the Service, sending its frames
+// through w and passing its Results to emit until ctx is done
+type monitor func(ctx context.Context, w capture.FrameWriter, emit func(Result))
+
+// runMonitor runs a prober of the Service until ctx is done, sending its
+// Results to resultC
+func (s *Service) runMonitor(ctx context.Context, run monitor, w capture.FrameWriter, resultC chan<- Result) {
+	run(ctx, w, func(r Result) {
+		res := []Result{r}
+
+		s.label(res)
+
+		if s.history != nil {
+			s.history.record(s.iface, res)
+		}
 
-	for {
 		select {
+		case resultC <- res[0]:
 		case <-ctx.Done():
-			return nil
-		case pkt, ok := <-pkts:
-			if !ok {
+		}
+	})
+}
+
+// SetTarget restricts the Service to the frames from or to the hosts of the
+// target, an empty Target observes the whole segment again. The filter of a
+// running capture is swapped without reopening it.
+func (s *Service) SetTarget(target capture.Target) error {
+	s.targetMu.Lock()
+	defer s.targetMu.Unlock()
+
+	if s.targeted != nil {
+		if err := s.targeted.SetTarget(target); err != nil {
+			return err
+		}
+	} else if _, err := capture.TargetFilter(target, nil); err != nil {
+		return err
+	}
+
+	s.target = target
+
+	return nil
+}
+
+// DiscoverVLANs runs the VLANDiscovery of the Service, the probes are sent
+// through its capture. It returns the live VLANs, in order.
+func (s *Service) DiscoverVLANs(ctx context.Context) ([]LiveVLAN, error) {
+	if s.vlans == nil {
+		return nil, ErrVLANDiscoveryDisabled
+	}
+
+	s.targetMu.Lock()
+	conn := s.conn
+	s.targetMu.Unlock()
+
+	if conn == nil {
+		return nil, ErrNotCapturing
+	}
+
+	vlans, err := s.vlans.Run(ctx, s.writer(TransmitScan, conn))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range vlans {
+		vlans[i].Interface = s.iface
+	}
+
+	return vlans, nil
+}
+
+// CaptureStatus is the state of the capture of a Service
+type CaptureStatus struct {
+	// Stats are the counters of the capture socket, nil unless running
+	Stats     *capture.Stats
+	Interface string
+	// Target is the target set with SetTarget
+	Target capture.Target
+	// Filter is the socket filter the capture is opened with, or would be
+	Filter []bpf.RawInstruction
+	// Malformed is the number of frames dropped as malformed since the
+	// Service was created, Truncated that of the frames which only failed
+	// to decode because the snaplen cut them short
+	Malformed uint64
+	Truncated uint64
+	// LimitExceeded is the number of frames abandoned at a limit of the
+	// decoders, see ethernet.DecodeLimits
+	LimitExceeded uint64
+	// Decoders are the protocols the Service decodes
+	Decoders DecoderSet
+	// Capabilities are those the Service runs without
+	Capabilities Capabilities
+	Running      bool
+}
+
+// CaptureStatus returns the state of the capture, the error is that of
+// reading the counters of a running one
+func (s *Service) CaptureStatus() (CaptureStatus, error) {
+	s.targetMu.Lock()
+	defer s.targetMu.Unlock()
+
+	st := CaptureStatus{
+		Interface:     s.iface,
+		Target:        s.target,
+		Malformed:     s.malformed.Load(),
+		Truncated:     s.truncated.Load(),
+		LimitExceeded: s.limited.Load(),
+		Decoders:      s.decoders,
+		Capabilities:  s.capabilities,
+		Running:       s.targeted != nil,
+	}
+
+	base, err := s.captureFilter()
+	if err != nil {
+		return st, err
+	}
+
+	st.Filter = base
+
+	if !s.target.Empty() {
+		st.Filter, err = capture.TargetFilter(s.target, base)
+		if err != nil {
+			return st, err
+		}
+	}
+
+	if s.conn != nil {
+		stats, err := s.conn.Stats()
+		if err != nil {
+			return st, err
+		}
+
+		st.Stats = &stats
+	}
+
+	return st, nil
+}
+
+// Interface returns the name of the interface the Service observes
+func (s *Service) Interface() string {
+	return s.iface
+}
+
+// run sends the results of the frames read from conn until ctx is done
+func (s *Service) run(ctx context.Context, conn capture.FrameReader, resultC chan<- Result) error {
+	var err error
+
+	// the labels of the goroutine are restored once the capture stops
+	pprof.Do(ctx, pprof.Labels(LabelInterface, s.iface), func(ctx context.Context) {
+		err = s.runPipeline(ctx, s.newPipeline(ctx), conn, resultC)
+	})
+
+	return err
+}
+
+// runPipeline observes the frames read from conn until ctx is done,
+// entering the stages of p
+func (s *Service) runPipeline(ctx context.Context, p *pipeline, conn capture.FrameReader,
+	resultC chan<- Result) error {
+	stop := capture.InterruptReads(ctx, conn)
+	defer stop()
+
+	buf := make([]byte, max(snapLen, ndpSnapLen, portAuthSnapLen, layerSnapLen, topologySnapLen))
+
+	for {
+		p.enter(StageCapture)
+
+		md, err := capture.ReadFrameMetadata(conn, buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			if errors.Is(err, capture.ErrClosed) {
 				log.Debug().Msg("packet capture has closed")
 				return ErrPacketCaptureClosed
 			}
 
-			res, err := s.handlePacket(pkt)
-			if err != nil {
-				if isRecoverableError(err) {
-					log.Error().Err(err).Send()
-					continue
-				}
+			return err
+		}
+
+		md.Labels = s.currentLabels()
+
+		if s.dedup != nil {
+			p.enter(StageFilter)
+
+			if s.dedup.duplicate(s.iface, buf[:md.CaptureLength]) {
+				continue
+			}
+		}
+
+		// a frame skipped may still release the observations of others
+		res, err := s.handle(p, buf[:md.CaptureLength], md)
 
-				return err
+		switch {
+		case err == nil:
+		// the frames past the limits are crafted or broken, they are
+		// counted apart from the malformed ones
+		case errors.Is(err, ethernet.ErrDecodeLimitExceeded):
+			s.limited.Add(1)
+			log.Debug().Err(err).Msg("skipping frame past the decode limits")
+		// a healthy network floods the logs with the frames a snaplen
+		// cuts, they aren't malformed
+		case errors.Is(err, ethernet.ErrTruncatedBySnaplen):
+			s.truncated.Add(1)
+			log.Debug().Err(err).Msg("skipping frame cut by the snaplen")
+		case isRecoverableError(err):
+			s.malformed.Add(1)
+			log.Error().Err(err).Send()
+		default:
+			return err
+		}
+
+		p.enter(StageDispatch)
+
+		if s.attributor != nil && len(res) > 0 {
+			ingress := s.attributor.Ingress(s.iface, buf[:md.CaptureLength])
+			for i := range res {
+				res[i].Ingress = &ingress
 			}
+		}
+
+		s.label(res)
+
+		if s.history != nil {
+			s.history.record(s.iface, res)
+		}
 
-			for _, r := range res {
-				resultC <- r
+		// the consumer may be gone, a result must not block the shutdown
+		for _, r := range res {
+			select {
+			case resultC <- r:
+			case <-ctx.Done():
+				return nil
 			}
 		}
 	}
@@ -16,16 +16,24 @@
 package netmon
 
 import (
+	"bytes"
+	"context"
 	"net"
 	"net/netip"
+	"os"
 	"testing"
 	"time"
 
-	"github.com/google/gopacket"
-	pcap "github.com/packetcap/go-pcap"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
 
+	"maas.io/core/src/maasagent/internal/capture"
 	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/loadgen"
+	"maas.io/core/src/maasagent/internal/netif"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/leak"
 )
 
 func uint16Pointer(v uint16) *uint16 {
@@ -105,7 +113,7 @@ func TestUpdateBindings(t *testing.T) {
 		p               func(p *ethernet.ARPPacket)
 		vid             *uint16
 		time            time.Time
-		bindingsFixture map[string]Binding
+		bindingsFixture map[bindingKey]Binding
 	}
 
 	testcases := map[string]struct {
@@ -176,8 +184,8 @@ func TestUpdateBindings(t *testing.T) {
 					p.SendHwAddr = net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01}
 				},
 				time: timestamp.Add(seenAgainThreshold + time.Second),
-				bindingsFixture: map[string]Binding{
-					"0_10.0.0.1": {
+				bindingsFixture: map[bindingKey]Binding{
+					{ip: netip.MustParseAddr("10.0.0.1")}: {
 						IP:   netip.MustParseAddr("10.0.0.1"),
 						MAC:  net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01},
 						Time: timestamp,
@@ -199,8 +207,33 @@ func TestUpdateBindings(t *testing.T) {
 					p.SendHwAddr = net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x1d}
 				},
 				time: timestamp,
-				bindingsFixture: map[string]Binding{
-					"0_10.0.0.1": {
+				bindingsFixture: map[bindingKey]Binding{
+					{ip: netip.MustParseAddr("10.0.0.1")}: {
+						IP:   netip.MustParseAddr("10.0.0.1"),
+						MAC:  net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01},
+						Time: timestamp,
+					},
+				},
+			},
+			out: []Result{
+				{
+					IP:          "10.0.0.1",
+					MAC:         "c0:ff:ee:15:c0:1d",
+					PreviousMAC: "c0:ff:ee:15:c0:01",
+					Time:        timestamp.Unix(),
+					Event:       EventMoved,
+				},
+			},
+		},
+		"IPv4-mapped sender matches the IPv4 binding": {
+			in: in{
+				p: func(p *ethernet.ARPPacket) {
+					p.SendIPAddr = netip.MustParseAddr("::ffff:10.0.0.1")
+					p.SendHwAddr = net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x1d}
+				},
+				time: timestamp,
+				bindingsFixture: map[bindingKey]Binding{
+					{ip: netip.MustParseAddr("10.0.0.1")}: {
 						IP:   netip.MustParseAddr("10.0.0.1"),
 						MAC:  net.HardwareAddr{0xc0, 0xff, 0xee, 0x15, 0xc0, 0x01},
 						Time: timestamp,
@@ -231,8 +264,8 @@ func TestUpdateBindings(t *testing.T) {
 			}
 
 			svc := NewService("lo")
-			if tc.in.bindingsFixture != nil {
-				svc.bindings = tc.in.bindingsFixture
+			for key, b := range tc.in.bindingsFixture {
+				svc.table.shard(key).bindings[key] = b
 			}
 
 			res := svc.updateBindings(packet, tc.in.vid, tc.in.time)
@@ -258,25 +291,42 @@ func testARPPacket() *ethernet.ARPPacket {
 func TestServiceHandlePacket(t *testing.T) {
 	t.Parallel()
 
-	timestamp := time.Now()
+	timestamp := time.Unix(1700000000, 0)
 	testcases := map[string]struct {
-		in  pcap.Packet
+		in  []byte
+		md  capture.Metadata
 		out []Result
 		err error
 	}{
 		"valid request packet": {
-			in: pcap.Packet{
-				// generated from tcpdump
-				B: []byte{
-					0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x81, 0x00, 0x00, 0x02,
-					0x08, 0x06, 0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25,
-					0xc0, 0xa8, 0x0a, 0x1a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19, 0x00, 0x00,
-					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-				},
-				Info: gopacket.CaptureInfo{
-					Timestamp: timestamp,
+			// generated from tcpdump
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x81, 0x00, 0x00, 0x02,
+				0x08, 0x06, 0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25,
+				0xc0, 0xa8, 0x0a, 0x1a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			md: capture.Metadata{Timestamp: timestamp},
+			out: []Result{
+				{
+					IP:    "192.168.10.26",
+					MAC:   "84:39:c0:0b:22:25",
+					VID:   uint16Pointer(2),
+					Time:  timestamp.Unix(),
+					Event: EventNew,
 				},
 			},
+		},
+		"VLAN tag stripped by the NIC": {
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x08, 0x06, 0x00, 0x01,
+				0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0xc0, 0xa8, 0x0a, 0x1a,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19,
+			},
+			md: capture.Metadata{
+				Timestamp: timestamp,
+				VLAN:      capture.VLANInfo{TCI: 0x2002, TPID: 0x8100, Valid: true},
+			},
 			out: []Result{
 				{
 					IP:    "192.168.10.26",
@@ -287,14 +337,52 @@ func TestServiceHandlePacket(t *testing.T) {
 				},
 			},
 		},
-		"valid reply packet": {
-			in: pcap.Packet{
-				B: []byte{
-					0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16, 0x08, 0x06, 0x00, 0x01,
-					0x08, 0x00, 0x06, 0x04, 0x00, 0x02, 0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16, 0xc0, 0xa8, 0x01, 0x6c,
-					0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0xc0, 0xa8, 0x01, 0x50,
+		"QinQ request packet": {
+			in: buildFrame(t, ethernet.NewFrame().Src(testPXEClient).
+				Tags(ethernet.Tag{TPID: ethernet.EthernetTypeQinQ, VID: 100}, ethernet.Tag{VID: 12}).
+				ARPRequest(netip.MustParseAddr("10.0.12.5"), netip.MustParseAddr("10.0.12.1")), nil),
+			md: capture.Metadata{Timestamp: timestamp},
+			out: []Result{
+				{
+					IP:    "10.0.12.5",
+					MAC:   testPXEClient.String(),
+					VID:   uint16Pointer(12),
+					Time:  timestamp.Unix(),
+					Event: EventNew,
+					Tags: []ethernet.Tag{
+						{TPID: ethernet.EthernetTypeQinQ, VID: 100},
+						{TPID: ethernet.EthernetTypeVLAN, VID: 12},
+					},
+				},
+			},
+		},
+		"outer tag stripped by the NIC": {
+			in: buildFrame(t, ethernet.NewFrame().Src(testPXEClient).
+				ARPRequest(netip.MustParseAddr("10.0.12.5"), netip.MustParseAddr("10.0.12.1")), uint16Pointer(12)),
+			md: capture.Metadata{
+				Timestamp: timestamp,
+				VLAN:      capture.VLANInfo{TCI: 100, TPID: 0x88a8, Valid: true},
+			},
+			out: []Result{
+				{
+					IP:    "10.0.12.5",
+					MAC:   testPXEClient.String(),
+					VID:   uint16Pointer(12),
+					Time:  timestamp.Unix(),
+					Event: EventNew,
+					Tags: []ethernet.Tag{
+						{TPID: ethernet.EthernetTypeQinQ, VID: 100},
+						{TPID: ethernet.EthernetTypeVLAN, VID: 12},
+					},
 				},
 			},
+		},
+		"valid reply packet": {
+			in: []byte{
+				0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16, 0x08, 0x06, 0x00, 0x01,
+				0x08, 0x00, 0x06, 0x04, 0x00, 0x02, 0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16, 0xc0, 0xa8, 0x01, 0x6c,
+				0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0xc0, 0xa8, 0x01, 0x50,
+			},
 			out: []Result{
 				{
 					IP:    "192.168.1.108",
@@ -316,25 +404,28 @@ func TestServiceHandlePacket(t *testing.T) {
 			err: ErrEmptyPacket,
 		},
 		"malformed packet": {
-			in: pcap.Packet{
-				B: []byte{
-					0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22,
-					0x08, 0x06, 0x00, 0x01, 0x08, 0x06, 0x04, 0x00, 0x01, 0x84,
-					0xc0, 0xa8, 0x0a, 0x1a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0,
-					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-				},
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22,
+				0x08, 0x06, 0x00, 0x01, 0x08, 0x06, 0x04, 0x00, 0x01, 0x84,
+				0xc0, 0xa8, 0x0a, 0x1a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 			},
 		},
 		"short packet": {
-			in: pcap.Packet{
-				B: []byte{
-					0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16, 0x08, 0x06, 0x00, 0x01,
-					0x08, 0x00, 0x06, 0x04, 0x00, 0x02, 0x80, 0xfc, 0x16, 0xc0, 0xa8, 0x01, 0x6c,
-					0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0xc0, 0xa8, 0x01, 0x50,
-				},
+			in: []byte{
+				0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0x80, 0x61, 0x5f, 0x08, 0xfc, 0x16, 0x08, 0x06, 0x00, 0x01,
+				0x08, 0x00, 0x06, 0x04, 0x00, 0x02, 0x80, 0xfc, 0x16, 0xc0, 0xa8, 0x01, 0x6c,
+				0x24, 0x4b, 0xfe, 0xe1, 0xea, 0x26, 0xc0, 0xa8, 0x01, 0x50,
 			},
 			err: ethernet.ErrMalformedARPPacket,
 		},
+		"VLAN tag of another ethertype": {
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x81, 0x00, 0x00, 0x02,
+				0x08, 0x00, 0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25,
+				0xc0, 0xa8, 0x0a, 0x1a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19,
+			},
+		},
 	}
 
 	for name, tc := range testcases {
@@ -343,11 +434,13 @@ func TestServiceHandlePacket(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			svc := NewService("")
-			res, err := svc.handlePacket(tc.in)
+			svc := NewService("", WithClock(clocktest.NewFake(timestamp)))
+			res, err := svc.handleFrame(tc.in, tc.md)
 			assert.ErrorIs(t, err, tc.err)
 
 			if err == nil {
+				require.Len(t, res, len(tc.out))
+
 				for i, expected := range tc.out {
 					assert.Equal(t, expected, res[i])
 				}
@@ -357,3 +450,561 @@ func TestServiceHandlePacket(t *testing.T) {
 		})
 	}
 }
+
+func TestServiceOwnTraffic(t *testing.T) {
+	t.Parallel()
+
+	self := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	other := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+
+	selfMACs := netif.NewSelfMACs(netif.NewInventory())
+	selfMACs.Update([]netif.Link{{Index: 2, Name: "eth0", HardwareAddr: self}})
+
+	frame := func(src net.HardwareAddr) []byte {
+		buf, err := ethernet.NewFrame().Src(src).Padded().
+			ARPRequest(netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")).Build()
+		require.NoError(t, err)
+
+		return buf
+	}
+
+	testcases := map[string]struct {
+		in      []byte
+		md      capture.Metadata
+		options []ServiceOption
+		results int
+	}{
+		"inbound": {
+			in:      frame(other),
+			md:      capture.Metadata{Direction: capture.DirectionInbound},
+			results: 1,
+		},
+		"outbound": {
+			in: frame(other),
+			md: capture.Metadata{Direction: capture.DirectionOutbound},
+		},
+		"outbound included": {
+			in:      frame(other),
+			md:      capture.Metadata{Direction: capture.DirectionOutbound},
+			options: []ServiceOption{WithOwnTraffic()},
+			results: 1,
+		},
+		"reflected": {
+			in:      frame(self),
+			md:      capture.Metadata{Direction: capture.DirectionInbound},
+			options: []ServiceOption{WithSelfMACs(selfMACs)},
+		},
+		"reflected included": {
+			in:      frame(self),
+			md:      capture.Metadata{Direction: capture.DirectionInbound},
+			options: []ServiceOption{WithSelfMACs(selfMACs), WithOwnTraffic()},
+			results: 1,
+		},
+		"not self": {
+			in:      frame(other),
+			md:      capture.Metadata{Direction: capture.DirectionInbound},
+			options: []ServiceOption{WithSelfMACs(selfMACs)},
+			results: 1,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := NewService("eth0", tc.options...)
+			res, err := svc.handleFrame(tc.in, tc.md)
+			require.NoError(t, err)
+			assert.Len(t, res, tc.results)
+			assert.Len(t, svc.Snapshot().Bindings, tc.results)
+		})
+	}
+}
+
+func TestServiceParserOptions(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+	sender, target := netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")
+
+	reserved, err := ethernet.NewFrame().Src(src).VLAN(ethernet.ReservedVID).Padded().
+		ARPRequest(sender, target).Build()
+	require.NoError(t, err)
+
+	// an address length of 0 for IPv4, the packet holding 4 bytes addresses
+	lengths, err := ethernet.NewFrame().Src(src).Padded().ARPRequest(sender, target).Build()
+	require.NoError(t, err)
+
+	lengths[19] = 0
+
+	testcases := map[string]struct {
+		in []byte
+		// results is the number of Results at each Strictness, a frame
+		// rejected at one of them is recoverable
+		results  map[ethernet.Strictness]int
+		rejected map[ethernet.Strictness]bool
+	}{
+		"reserved VID": {
+			in: reserved,
+			results: map[ethernet.Strictness]int{
+				ethernet.StrictnessLenient:  1,
+				ethernet.StrictnessStandard: 1,
+			},
+			rejected: map[ethernet.Strictness]bool{ethernet.StrictnessStrict: true},
+		},
+		"ARP lengths": {
+			in: lengths,
+			results: map[ethernet.Strictness]int{
+				ethernet.StrictnessLenient: 1,
+			},
+			// only salvaged, the empty addresses aren't IP addresses
+			rejected: map[ethernet.Strictness]bool{ethernet.StrictnessStandard: true, ethernet.StrictnessStrict: true},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, s := range []ethernet.Strictness{
+				ethernet.StrictnessLenient, ethernet.StrictnessStandard, ethernet.StrictnessStrict,
+			} {
+				svc := NewService("eth0", WithParserOptions(ethernet.ParserOptions{Strictness: s}))
+
+				res, err := svc.handleFrame(tc.in, capture.Metadata{})
+				if tc.rejected[s] {
+					assert.True(t, isRecoverableError(err), s)
+				} else {
+					require.NoError(t, err, s)
+				}
+
+				assert.Len(t, res, tc.results[s], s)
+			}
+		})
+	}
+}
+
+func TestARPFilter(t *testing.T) {
+	t.Parallel()
+
+	filter, err := arpFilter()
+	require.NoError(t, err)
+
+	vm, err := bpf.NewVM(disassemble(t, filter))
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		in     []byte
+		accept bool
+	}{
+		"ARP": {
+			in:     []byte{12: 0x08, 13: 0x06, 41: 0},
+			accept: true,
+		},
+		"802.1Q ARP": {
+			in:     []byte{12: 0x81, 13: 0x00, 16: 0x08, 17: 0x06, 45: 0},
+			accept: true,
+		},
+		"IPv4": {
+			in: []byte{12: 0x08, 13: 0x00, 33: 0},
+		},
+		"802.1Q IPv4": {
+			in: []byte{12: 0x81, 13: 0x00, 16: 0x08, 17: 0x00, 37: 0},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			n, err := vm.Run(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.accept, n > 0)
+		})
+	}
+}
+
+func TestStackedARPFilter(t *testing.T) {
+	t.Parallel()
+
+	base, err := arpFilter()
+	require.NoError(t, err)
+
+	filter, err := stackedARPFilter(base)
+	require.NoError(t, err)
+
+	vm, err := bpf.NewVM(disassemble(t, filter))
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		in     []byte
+		accept bool
+	}{
+		"ARP": {
+			in:     []byte{12: 0x08, 13: 0x06, 41: 0},
+			accept: true,
+		},
+		"802.1Q ARP": {
+			in:     []byte{12: 0x81, 13: 0x00, 16: 0x08, 17: 0x06, 45: 0},
+			accept: true,
+		},
+		"QinQ ARP": {
+			in:     []byte{12: 0x88, 13: 0xa8, 16: 0x81, 17: 0x00, 20: 0x08, 21: 0x06, 49: 0},
+			accept: true,
+		},
+		"legacy QinQ ARP": {
+			in:     []byte{12: 0x91, 13: 0x00, 16: 0x81, 17: 0x00, 20: 0x08, 21: 0x06, 49: 0},
+			accept: true,
+		},
+		"double 802.1Q ARP": {
+			in:     []byte{12: 0x81, 13: 0x00, 16: 0x81, 17: 0x00, 20: 0x08, 21: 0x06, 49: 0},
+			accept: true,
+		},
+		"QinQ IPv4": {
+			in: []byte{12: 0x88, 13: 0xa8, 16: 0x81, 17: 0x00, 20: 0x08, 21: 0x00, 41: 0},
+		},
+		"QinQ without an inner tag": {
+			in: []byte{12: 0x88, 13: 0xa8, 16: 0x08, 17: 0x06, 45: 0},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			n, err := vm.Run(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.accept, n > 0)
+		})
+	}
+}
+
+func disassemble(tb testing.TB, raw []bpf.RawInstruction) []bpf.Instruction {
+	tb.Helper()
+
+	insns, ok := bpf.Disassemble(raw)
+	require.True(tb, ok)
+
+	return insns
+}
+
+func TestServiceRefreshClock(t *testing.T) {
+	t.Parallel()
+
+	frame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x08, 0x06, 0x00, 0x01,
+		0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0xc0, 0xa8, 0x0a, 0x1a,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19,
+	}
+
+	clock := clocktest.NewFake(time.Unix(1700000000, 0))
+	svc := NewService("eth0", WithClock(clock))
+
+	res, err := svc.handleFrame(frame, capture.Metadata{})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventNew, res[0].Event)
+	assert.Equal(t, int64(1700000000), res[0].Time)
+
+	clock.Advance(seenAgainThreshold - time.Second)
+
+	res, err = svc.handleFrame(frame, capture.Metadata{})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	clock.Advance(time.Second)
+
+	res, err = svc.handleFrame(frame, capture.Metadata{})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventRefreshed, res[0].Event)
+	assert.Equal(t, clock.Now().Unix(), res[0].Time)
+	assert.Equal(t, clock.Now().Unix(), svc.Snapshot().Time)
+}
+
+// pipeReader is a capture.FrameReader reading from a pipe
+type pipeReader struct {
+	*os.File
+}
+
+func (r pipeReader) ReadFrame(buf []byte) (int, error) {
+	return r.Read(buf)
+}
+
+func TestServiceRun(t *testing.T) {
+	defer leak.Check(t)()
+
+	frame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0x08, 0x06, 0x00, 0x01,
+		0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x84, 0x39, 0xc0, 0x0b, 0x22, 0x25, 0xc0, 0xa8, 0x0a, 0x1a,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x0a, 0x19,
+	}
+
+	pr, pw, err := os.Pipe()
+	require.NoError(t, err)
+
+	defer pr.Close() //nolint:errcheck // test cleanup
+	defer pw.Close() //nolint:errcheck // test cleanup
+
+	svc := NewService("eth0")
+	resultC := make(chan Result)
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- svc.run(ctx, pipeReader{pr}, resultC) }()
+
+	_, err = pw.Write(frame)
+	require.NoError(t, err)
+
+	res := <-resultC
+	assert.Equal(t, "192.168.10.26", res.IP)
+
+	// nobody receives the result of this one, it must not block the stop
+	other := bytes.Clone(frame)
+	other[31] = 0x1b
+
+	_, err = pw.Write(other)
+	require.NoError(t, err)
+
+	cancel()
+	assert.NoError(t, <-errC)
+}
+
+func TestServiceServe(t *testing.T) {
+	t.Parallel()
+
+	gen, err := loadgen.New(loadgen.WithSeed(1), loadgen.WithCount(5000), loadgen.WithHosts(500))
+	require.NoError(t, err)
+
+	svc := NewService("eth0")
+	resultC := make(chan Result)
+	errC := make(chan error)
+
+	go func() { errC <- svc.Serve(context.Background(), gen, resultC) }()
+
+	// the end of the feed stops the Service, the malformed frames don't
+	ips := make(map[string]struct{})
+	for res := range resultC {
+		ips[res.IP] = struct{}{}
+	}
+
+	require.NoError(t, <-errC)
+
+	summary := gen.Summary()
+	assert.Equal(t, uint64(5000), summary.Frames)
+	assert.NotZero(t, summary.Malformed)
+	assert.Len(t, ips, summary.Hosts)
+	assert.Len(t, svc.Bindings(), summary.Hosts)
+
+	st, err := svc.CaptureStatus()
+	require.NoError(t, err)
+	assert.NotZero(t, st.Malformed)
+	assert.Zero(t, st.Truncated)
+
+	for i := range 500 {
+		if _, ok := ips[gen.Host(i).String()]; !ok {
+			t.Errorf("no result for %s", gen.Host(i))
+		}
+	}
+}
+
+func TestServiceSnaplen(t *testing.T) {
+	t.Parallel()
+
+	src := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	ip := netip.MustParseAddr("10.0.0.1")
+	arp := buildFrame(t, ethernet.NewFrame().Src(src).Padded().ARPRequest(ip, netip.MustParseAddr("10.0.0.2")), nil)
+	ns := buildFrame(t, ethernet.NewFrame().Src(src).
+		NeighborSolicitation(netip.IPv6Unspecified(), netip.MustParseAddr("fd00::1")), nil)
+
+	// a runt cut short on the wire, which the capture kept whole
+	runt := arp[:34]
+
+	testcases := map[string]struct {
+		snaplen   uint32
+		bound     bool
+		truncated uint64
+	}{
+		"whole frames": {
+			snaplen: 1522,
+			bound:   true,
+		},
+		"padding cut": {
+			snaplen:   60,
+			bound:     true,
+			truncated: 1,
+		},
+		"ARP tuple kept": {
+			snaplen:   42,
+			bound:     true,
+			truncated: 1,
+		},
+		"ARP tuple cut": {
+			snaplen:   36,
+			truncated: 2,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var pcap bytes.Buffer
+
+			w, err := capture.NewPcapWriter(&pcap, tc.snaplen)
+			require.NoError(t, err)
+
+			for _, frame := range [][]byte{arp, ns, runt} {
+				require.NoError(t, w.WriteFrame(frame, capture.Metadata{Timestamp: time.Unix(1, 0), Length: len(frame)}))
+			}
+
+			r, err := capture.NewPcapReader(&pcap, "eth0")
+			require.NoError(t, err)
+
+			svc := NewService("eth0", WithDADDetector(NewDADDetector()))
+			resultC := make(chan Result, 4)
+
+			require.NoError(t, svc.Serve(context.Background(), r, resultC))
+
+			// the frames the snaplen cut aren't malformed, the runt is
+			st, err := svc.CaptureStatus()
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), st.Malformed)
+			assert.Equal(t, tc.truncated, st.Truncated)
+
+			assert.Equal(t, tc.bound, len(svc.Bindings()) == 1)
+		})
+	}
+}
+
+func TestServiceSetTarget(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0")
+
+	assert.ErrorIs(t, svc.SetTarget(capture.Target{MACs: []net.HardwareAddr{{0x00, 0x16}}}), capture.ErrInvalidTarget)
+	assert.NoError(t, svc.SetTarget(capture.Target{IPs: []netip.Addr{netip.MustParseAddr("10.0.0.1")}}))
+	assert.NoError(t, svc.SetTarget(capture.Target{}))
+}
+
+func TestServiceCaptureStatus(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0", WithDADDetector(NewDADDetector()))
+
+	st, err := svc.CaptureStatus()
+	require.NoError(t, err)
+	assert.Equal(t, "eth0", st.Interface)
+	assert.False(t, st.Running)
+	assert.Nil(t, st.Stats)
+	assert.Equal(t, DefaultDecoders, st.Decoders)
+
+	// the protocols the tests register are left out by the default decoders
+	base, err := ndpFilter()
+	require.NoError(t, err)
+
+	base, err = stackedARPFilter(base)
+	require.NoError(t, err)
+	assert.Equal(t, base, st.Filter)
+
+	// and captured with theirs
+	all := NewService("eth0", WithDADDetector(NewDADDetector()), WithDecoders(AllDecoders))
+
+	st, err = all.CaptureStatus()
+	require.NoError(t, err)
+
+	layers := ethernet.Registered()
+	withLayers, err := layerFilter(base, layers.EtherTypes(), layers.UDPPorts())
+	require.NoError(t, err)
+	assert.Equal(t, withLayers, st.Filter)
+
+	// the target filter is the one the capture would be opened with
+	target := capture.Target{IPs: []netip.Addr{netip.MustParseAddr("10.0.0.1")}}
+	require.NoError(t, svc.SetTarget(target))
+
+	filter, err := capture.TargetFilter(target, base)
+	require.NoError(t, err)
+
+	st, err = svc.CaptureStatus()
+	require.NoError(t, err)
+	assert.Equal(t, target, st.Target)
+	assert.Equal(t, filter, st.Filter)
+}
+
+// TestServiceTarget requires CAP_NET_RAW and an interface which loops frames
+// back, such as lo:
+// sudo TEST_CAPTURE_IFACE=lo \
+// go test maas.io/core/src/maasagent/internal/netmon -run TestServiceTarget -count 1 -v
+func TestServiceTarget(t *testing.T) {
+	iface := os.Getenv("TEST_CAPTURE_IFACE")
+	if iface == "" {
+		t.Skip("set TEST_CAPTURE_IFACE to run this test")
+	}
+
+	defer leak.Check(t)()
+
+	ring := capture.NewPcapRing(8)
+	svc := NewService(iface, WithOwnTraffic(), WithTargetRing(ring))
+	target := netip.MustParseAddr("10.0.0.2")
+
+	require.NoError(t, svc.SetTarget(capture.Target{IPs: []netip.Addr{target}}))
+
+	resultC := make(chan Result)
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- svc.Start(ctx, resultC) }()
+
+	w, err := capture.Listen(iface, capture.WithProtocol(uint16(ethernet.EthernetTypeARP)))
+	require.NoError(t, err)
+
+	defer w.Close() //nolint:errcheck // test cleanup
+
+	mac := net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+
+	var frames [][]byte
+
+	for _, sender := range []string{"10.0.0.1", "10.0.0.2"} {
+		frame, err := ethernet.NewFrame().Src(mac).Dst(ethernet.Broadcast).
+			ARPRequest(netip.MustParseAddr(sender), netip.MustParseAddr("10.0.0.254")).Build()
+		require.NoError(t, err)
+
+		frames = append(frames, frame)
+	}
+
+	// the capture may not be up yet, the frames are sent until one arrives
+	var res Result
+
+	for res.IP == "" {
+		for _, frame := range frames {
+			require.NoError(t, w.WriteFrame(frame))
+		}
+
+		select {
+		case res = <-resultC:
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	assert.Equal(t, target.String(), res.IP)
+	assert.Positive(t, ring.Len())
+
+	cancel()
+
+	for range resultC { //nolint:revive // drain until Start closes it
+	}
+
+	assert.NoError(t, <-errC)
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidSeverity is returned when parsing an unknown severity
+	ErrInvalidSeverity = errors.New("invalid severity")
+)
+
+// Severity is how urgently an event needs an operator, the events are
+// filtered and routed by it
+type Severity uint8
+
+const (
+	// SeverityDebug is for the routine events which only tell that nothing
+	// changed, such as a binding refreshed
+	SeverityDebug Severity = iota + 1
+	// SeverityInfo is for the events of the normal life of a network
+	SeverityInfo
+	// SeverityWarning is for the events which may need an operator
+	SeverityWarning
+	// SeverityCritical is for the events an operator must act upon
+	SeverityCritical
+)
+
+var (
+	severityToString = map[Severity]string{
+		SeverityDebug:    "debug",
+		SeverityInfo:     "info",
+		SeverityWarning:  "warning",
+		SeverityCritical: "critical",
+	}
+
+	stringToSeverity = map[string]Severity{
+		"debug":    SeverityDebug,
+		"info":     SeverityInfo,
+		"warning":  SeverityWarning,
+		"critical": SeverityCritical,
+	}
+)
+
+// String returns the name of the Severity
+func (s Severity) String() string {
+	if str, ok := severityToString[s]; ok {
+		return str
+	}
+
+	return "unknown"
+}
+
+// Valid returns true if s is one of the severities
+func (s Severity) Valid() bool {
+	_, ok := severityToString[s]
+
+	return ok
+}
+
+// MarshalText implements encoding.TextMarshaler for Severity
+func (s Severity) MarshalText() ([]byte, error) {
+	str, ok := severityToString[s]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidSeverity, s)
+	}
+
+	return []byte(str), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for Severity
+func (s *Severity) UnmarshalText(b []byte) error {
+	severity, ok := stringToSeverity[string(b)]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidSeverity, b)
+	}
+
+	*s = severity
+
+	return nil
+}
+
+// DefaultSeverities returns the Severity of each Event, unless a deployment
+// overrides it. The events missing are SeverityInfo.
+func DefaultSeverities() map[Event]Severity {
+	return map[Event]Severity{
+		EventNew:                         SeverityInfo,
+		EventRefreshed:                   SeverityDebug,
+		EventMoved:                       SeverityWarning,
+		EventDuplicateMACLocation:        SeverityWarning,
+		EventBindingViolation:            SeverityCritical,
+		EventDADConflict:                 SeverityCritical,
+		EventPortAuthenticationSuspected: SeverityWarning,
+		EventPortAuthenticationCleared:   SeverityInfo,
+		EventCustomLayer:                 SeverityInfo,
+		EventResponderSuspended:          SeverityWarning,
+		EventCriticalHostUnresponsive:    SeverityCritical,
+		EventCriticalHostRecovered:       SeverityInfo,
+		EventUpstreamPortChanged:         SeverityWarning,
+		EventAddressTheft:                SeverityCritical,
+		EventAnnouncementUndelivered:     SeverityCritical,
+		EventNativeVLANMismatch:          SeverityWarning,
+		EventModeChanged:                 SeverityWarning,
+	}
+}
+
+// SeverityOf returns the Severity of event in severities, SeverityInfo when
+// it is missing
+func SeverityOf(severities map[Event]Severity, event Event) Severity {
+	if s, ok := severities[event]; ok {
+		return s
+	}
+
+	return SeverityInfo
+}
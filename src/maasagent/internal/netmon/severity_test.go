@@ -0,0 +1,71 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeverityText(t *testing.T) {
+	t.Parallel()
+
+	for s, name := range severityToString {
+		b, err := s.MarshalText()
+		require.NoError(t, err)
+		assert.Equal(t, name, string(b))
+		assert.True(t, s.Valid())
+
+		var parsed Severity
+
+		require.NoError(t, parsed.UnmarshalText(b))
+		assert.Equal(t, s, parsed)
+	}
+
+	var s Severity
+
+	assert.ErrorIs(t, s.UnmarshalText([]byte("fatal")), ErrInvalidSeverity)
+
+	_, err := Severity(0).MarshalText()
+	assert.ErrorIs(t, err, ErrInvalidSeverity)
+	assert.Equal(t, "unknown", Severity(0).String())
+	assert.False(t, Severity(0).Valid())
+}
+
+func TestSeverityOrder(t *testing.T) {
+	t.Parallel()
+
+	assert.Less(t, SeverityDebug, SeverityInfo)
+	assert.Less(t, SeverityInfo, SeverityWarning)
+	assert.Less(t, SeverityWarning, SeverityCritical)
+}
+
+func TestDefaultSeverities(t *testing.T) {
+	t.Parallel()
+
+	severities := DefaultSeverities()
+
+	// every Event has a default
+	for e := Event(1); e.String() != "UNKNOWN"; e++ {
+		assert.True(t, severities[e].Valid(), e.String())
+	}
+
+	assert.Equal(t, SeverityDebug, SeverityOf(severities, EventRefreshed))
+	assert.Equal(t, SeverityCritical, SeverityOf(severities, EventBindingViolation))
+	assert.Equal(t, SeverityInfo, SeverityOf(severities, Event(0xff)))
+}
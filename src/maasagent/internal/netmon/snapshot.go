@@ -0,0 +1,293 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// ErrSnapshotSequence is returned when applying a SnapshotDiff to another
+// snapshot than the one it was computed from, the consumer missed a diff
+// and needs a full snapshot
+var ErrSnapshotSequence = errors.New("snapshot sequence mismatch")
+
+// SnapshotBinding is a binding of the neighbor table of a Service. A
+// binding is identified by its IP and VLAN.
+type SnapshotBinding struct {
+	VID *uint16 `json:"vid"`
+	IP  string  `json:"ip"`
+	MAC string  `json:"mac"`
+	// Source and Confidence are only set for the bindings merged from the
+	// kernel neighbor cache or ingested from another source, Origin names
+	// the latter
+	Source     string `json:"source,omitempty"`
+	Origin     string `json:"origin,omitempty"`
+	Confidence string `json:"confidence,omitempty"`
+	// Observation is the strongest kind of observation vouching for the
+	// binding, and Score its score at the time of the snapshot
+	Observation string  `json:"observation"`
+	Score       float64 `json:"score"`
+	// Time is when the binding was last created or refreshed
+	Time int64 `json:"time"`
+	// Labels are the labels of the interface at the time of the snapshot,
+	// see WithLabels
+	Labels map[string]string `json:"labels,omitempty"`
+	// ViaProxy is set when the binding was only learned from a proxy
+	ViaProxy bool `json:"via_proxy,omitempty"`
+}
+
+func (b SnapshotBinding) vid() int {
+	if b.VID == nil {
+		return -1
+	}
+
+	return int(*b.VID)
+}
+
+func compareSnapshotBindings(a, b SnapshotBinding) int {
+	return cmp.Or(cmp.Compare(a.IP, b.IP), cmp.Compare(a.vid(), b.vid()))
+}
+
+// Snapshot is the neighbor table of a Service at a point in time
+type Snapshot struct {
+	Interface string `json:"interface"`
+	// Bindings are in lexical order of IP, then by VLAN
+	Bindings []SnapshotBinding `json:"bindings"`
+	// Violations are the active binding violations, in the order of
+	// Bindings, so a restored Service keeps alerting
+	Violations []BindingViolation `json:"violations,omitempty"`
+	// PortAuth are the segments where PXE is likely blocked by 802.1X, in
+	// the order of their VLANs
+	PortAuth []PortAuthFinding `json:"port_auth,omitempty"`
+	// CriticalHosts are the hosts of the CriticalHostMonitor, by address
+	// and VLAN
+	CriticalHosts []CriticalHostStatus `json:"critical_hosts,omitempty"`
+	// Sequence increases with every snapshot of a Service
+	Sequence uint64 `json:"sequence"`
+	Time     int64  `json:"time"`
+}
+
+// SnapshotDiff holds the changes between two snapshots of a Service
+type SnapshotDiff struct {
+	Interface string            `json:"interface"`
+	Added     []SnapshotBinding `json:"added,omitempty"`
+	Removed   []SnapshotBinding `json:"removed,omitempty"`
+	// Changed are the bindings whose MAC, source, labels or proxy mark
+	// differs, a refresh or a new score alone isn't a change
+	Changed []SnapshotBinding `json:"changed,omitempty"`
+	// Violations are all the active violations, there are few of them
+	Violations []BindingViolation `json:"violations,omitempty"`
+	// PortAuth are all the port authentication findings, like Violations
+	PortAuth []PortAuthFinding `json:"port_auth,omitempty"`
+	// CriticalHosts are all the critical hosts, like Violations
+	CriticalHosts []CriticalHostStatus `json:"critical_hosts,omitempty"`
+	// Base is the sequence of the snapshot the diff applies to
+	Base     uint64 `json:"base"`
+	Sequence uint64 `json:"sequence"`
+	Time     int64  `json:"time"`
+}
+
+// Diff returns the changes from previous to s, each list is in the order
+// of Snapshot.Bindings
+func (s Snapshot) Diff(previous Snapshot) SnapshotDiff {
+	d := SnapshotDiff{
+		Interface:     s.Interface,
+		Violations:    s.Violations,
+		PortAuth:      s.PortAuth,
+		CriticalHosts: s.CriticalHosts,
+		Base:          previous.Sequence,
+		Sequence:      s.Sequence,
+		Time:          s.Time,
+	}
+
+	cur, prev := sortedBindings(s.Bindings), sortedBindings(previous.Bindings)
+
+	// both lists are sorted, walk them as in a merge
+	i, j := 0, 0
+
+	for i < len(cur) || j < len(prev) {
+		var c int
+
+		switch {
+		case i == len(cur):
+			c = 1
+		case j == len(prev):
+			c = -1
+		default:
+			c = compareSnapshotBindings(cur[i], prev[j])
+		}
+
+		switch {
+		case c < 0:
+			d.Added = append(d.Added, cur[i])
+			i++
+		case c > 0:
+			d.Removed = append(d.Removed, prev[j])
+			j++
+		default:
+			if cur[i].MAC != prev[j].MAC || cur[i].Source != prev[j].Source || cur[i].Origin != prev[j].Origin ||
+				cur[i].ViaProxy != prev[j].ViaProxy || !maps.Equal(cur[i].Labels, prev[j].Labels) {
+				d.Changed = append(d.Changed, cur[i])
+			}
+
+			i++
+			j++
+		}
+	}
+
+	return d
+}
+
+// Apply returns the snapshot d was computed from, given the snapshot it
+// was computed against
+func (d SnapshotDiff) Apply(base Snapshot) (Snapshot, error) {
+	if base.Sequence != d.Base {
+		return Snapshot{}, fmt.Errorf("%w: diff applies to %d, have %d", ErrSnapshotSequence, d.Base, base.Sequence)
+	}
+
+	out := Snapshot{
+		Interface:     d.Interface,
+		Violations:    d.Violations,
+		PortAuth:      d.PortAuth,
+		CriticalHosts: d.CriticalHosts,
+		Sequence:      d.Sequence,
+		Time:          d.Time,
+	}
+
+	for _, b := range base.Bindings {
+		if _, ok := slices.BinarySearchFunc(d.Removed, b, compareSnapshotBindings); ok {
+			continue
+		}
+
+		if k, ok := slices.BinarySearchFunc(d.Changed, b, compareSnapshotBindings); ok {
+			b = d.Changed[k]
+		}
+
+		out.Bindings = append(out.Bindings, b)
+	}
+
+	out.Bindings = append(out.Bindings, d.Added...)
+	slices.SortFunc(out.Bindings, compareSnapshotBindings)
+
+	return out, nil
+}
+
+func sortedBindings(bindings []SnapshotBinding) []SnapshotBinding {
+	if slices.IsSortedFunc(bindings, compareSnapshotBindings) {
+		return bindings
+	}
+
+	sorted := slices.Clone(bindings)
+	slices.SortFunc(sorted, compareSnapshotBindings)
+
+	return sorted
+}
+
+// Snapshot returns the current neighbor table, with the next sequence
+// number. The shards of the table are copied one after the other, the
+// observations aren't paused for the whole table.
+func (s *Service) Snapshot() Snapshot {
+	now := s.clock.Now()
+
+	snap := Snapshot{
+		Interface:  s.iface,
+		Bindings:   s.snapshotBindings(now),
+		Violations: s.activeViolations(),
+		Sequence:   s.sequence.Add(1),
+		Time:       now.Unix(),
+	}
+
+	if s.portAuth != nil {
+		snap.PortAuth = s.portAuth.Findings(s.iface)
+	}
+
+	if s.critical != nil {
+		snap.CriticalHosts = s.critical.Hosts()
+	}
+
+	return snap
+}
+
+// Bindings returns the current neighbor table, in the order of a Snapshot,
+// without taking a snapshot
+func (s *Service) Bindings() []SnapshotBinding {
+	return s.snapshotBindings(s.clock.Now())
+}
+
+// Lookup returns the binding of ip on the VLAN vid, nil for untagged
+// frames. It only waits for the observations of the hosts sharing the
+// shard of the binding.
+func (s *Service) Lookup(ip netip.Addr, vid *uint16) (SnapshotBinding, bool) {
+	key := bindingKey{ip: ip.Unmap()}
+	if vid != nil {
+		key.vid = *vid
+	}
+
+	b, ok := s.table.lookup(key)
+	if !ok {
+		return SnapshotBinding{}, false
+	}
+
+	return s.snapshotBinding(b, s.clock.Now()), true
+}
+
+func (s *Service) snapshotBindings(now time.Time) []SnapshotBinding {
+	var bindings []SnapshotBinding
+
+	// the conversion is cheap enough to be made under the read locks,
+	// copying the bindings first would allocate as much again
+	s.table.each(func(_ bindingKey, b Binding) {
+		bindings = append(bindings, s.snapshotBinding(b, now))
+	})
+
+	slices.SortFunc(bindings, compareSnapshotBindings)
+
+	return bindings
+}
+
+func (s *Service) snapshotBinding(b Binding, now time.Time) SnapshotBinding {
+	var vid *uint16
+
+	if b.VID != nil {
+		v := *b.VID
+		vid = &v
+	}
+
+	sb := SnapshotBinding{
+		VID:         vid,
+		IP:          b.IP.String(),
+		MAC:         b.MAC.String(),
+		Observation: b.Kind.String(),
+		Score:       snapshotScore(s.weights.Score(b, now)),
+		Time:        b.Time.Unix(),
+		Labels:      s.currentLabels(),
+		ViaProxy:    b.ViaProxy,
+	}
+
+	if b.Source != BindingSourceCapture {
+		sb.Source = b.Source.String()
+		sb.Origin = b.Origin
+		sb.Confidence = b.Confidence.String()
+	}
+
+	return sb
+}
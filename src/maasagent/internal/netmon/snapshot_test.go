@@ -0,0 +1,198 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+	t.Parallel()
+
+	a := SnapshotBinding{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Time: 1700000000}
+	b := SnapshotBinding{IP: "10.0.0.2", MAC: "00:16:3e:00:00:02", Time: 1700000000}
+	bTagged := SnapshotBinding{VID: uint16Pointer(100), IP: "10.0.0.2", MAC: "00:16:3e:00:00:02", Time: 1700000000}
+	c := SnapshotBinding{IP: "10.0.0.3", MAC: "00:16:3e:00:00:03", Time: 1700000000}
+
+	moved := c
+	moved.MAC = "00:16:3e:00:00:04"
+
+	refreshed := a
+	refreshed.Time = 1700000900
+
+	kernel := a
+	kernel.Source, kernel.Confidence = "kernel", "high"
+
+	testcases := map[string]struct {
+		previous []SnapshotBinding
+		current  []SnapshotBinding
+		out      SnapshotDiff
+	}{
+		"unchanged": {
+			previous: []SnapshotBinding{a, b},
+			current:  []SnapshotBinding{a, b},
+			out:      SnapshotDiff{},
+		},
+		"from empty": {
+			current: []SnapshotBinding{a, b},
+			out:     SnapshotDiff{Added: []SnapshotBinding{a, b}},
+		},
+		"added removed and changed": {
+			previous: []SnapshotBinding{a, b, c},
+			current:  []SnapshotBinding{refreshed, bTagged, moved},
+			out: SnapshotDiff{
+				Added:   []SnapshotBinding{bTagged},
+				Removed: []SnapshotBinding{b},
+				Changed: []SnapshotBinding{moved},
+			},
+		},
+		"observed kernel binding": {
+			previous: []SnapshotBinding{kernel, b},
+			current:  []SnapshotBinding{a, b},
+			out:      SnapshotDiff{Changed: []SnapshotBinding{a}},
+		},
+		"unsorted input": {
+			previous: []SnapshotBinding{c, a},
+			current:  []SnapshotBinding{b, a, moved},
+			out: SnapshotDiff{
+				Added:   []SnapshotBinding{b},
+				Changed: []SnapshotBinding{moved},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			previous := Snapshot{Interface: "eth0", Bindings: tc.previous, Sequence: 4}
+			current := Snapshot{Interface: "eth0", Bindings: tc.current, Sequence: 5, Time: 1700001000}
+
+			tc.out.Interface, tc.out.Base, tc.out.Sequence, tc.out.Time = "eth0", 4, 5, 1700001000
+
+			diff := current.Diff(previous)
+			assert.Equal(t, tc.out, diff)
+
+			// the same inputs give the same diff
+			assert.Equal(t, diff, current.Diff(previous))
+
+			b, err := json.Marshal(diff)
+			require.NoError(t, err)
+
+			var decoded SnapshotDiff
+
+			require.NoError(t, json.Unmarshal(b, &decoded))
+			assert.Equal(t, diff, decoded)
+
+			// a refresh isn't part of the diff, only the times may differ
+			applied, err := decoded.Apply(Snapshot{Bindings: sortedBindings(tc.previous), Sequence: 4})
+			require.NoError(t, err)
+			assert.Equal(t, withoutTimes(sortedBindings(tc.current)), withoutTimes(applied.Bindings))
+			assert.Equal(t, uint64(5), applied.Sequence)
+		})
+	}
+}
+
+func withoutTimes(bindings []SnapshotBinding) []SnapshotBinding {
+	out := make([]SnapshotBinding, len(bindings))
+
+	for i, b := range bindings {
+		b.Time = 0
+		out[i] = b
+	}
+
+	return out
+}
+
+func TestSnapshotDiffApplyMissed(t *testing.T) {
+	t.Parallel()
+
+	diff := Snapshot{Sequence: 7}.Diff(Snapshot{Sequence: 6})
+
+	_, err := diff.Apply(Snapshot{Sequence: 5})
+	assert.ErrorIs(t, err, ErrSnapshotSequence)
+}
+
+func TestServiceSnapshot(t *testing.T) {
+	t.Parallel()
+
+	timestamp := time.Unix(1700000000, 0)
+	s := NewService("eth0", WithClock(clocktest.NewFake(timestamp)))
+
+	for _, ip := range []string{"10.0.0.2", "10.0.0.10", "10.0.0.1"} {
+		pkt := testARPPacket()
+		pkt.SendIPAddr = netip.MustParseAddr(ip)
+		pkt.SendHwAddr = mustParseMAC("00:16:3e:00:00:01")
+
+		s.updateBindings(pkt, nil, timestamp)
+	}
+
+	first := s.Snapshot()
+
+	assert.Equal(t, "eth0", first.Interface)
+	assert.Equal(t, uint64(1), first.Sequence)
+	assert.Equal(t, []SnapshotBinding{
+		{IP: "10.0.0.1", MAC: "00:16:3e:00:00:01", Observation: "arp_request", Score: 0.7, Time: 1700000000},
+		{IP: "10.0.0.10", MAC: "00:16:3e:00:00:01", Observation: "arp_request", Score: 0.7, Time: 1700000000},
+		{IP: "10.0.0.2", MAC: "00:16:3e:00:00:01", Observation: "arp_request", Score: 0.7, Time: 1700000000},
+	}, first.Bindings)
+
+	pkt := testARPPacket()
+	pkt.SendHwAddr = mustParseMAC("00:16:3e:00:00:02")
+
+	s.updateBindings(pkt, uint16Pointer(100), timestamp.Add(time.Second))
+
+	second := s.Snapshot()
+	diff := second.Diff(first)
+
+	assert.Equal(t, uint64(2), diff.Sequence)
+	assert.Equal(t, uint64(1), diff.Base)
+	assert.Equal(t, []SnapshotBinding{
+		{VID: uint16Pointer(100), IP: "10.0.0.1", MAC: "00:16:3e:00:00:02", Observation: "arp_request", Score: 0.7,
+			Time: 1700000001},
+	}, diff.Added)
+	assert.Empty(t, diff.Removed)
+	assert.Empty(t, diff.Changed)
+}
+
+func TestServiceBindings(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService("eth0")
+	svc.Observe(ObservationARPReply, netip.MustParseAddr("10.0.0.2"), mustParseMAC("00:16:3e:00:00:02"), nil,
+		time.Unix(1700000000, 0))
+	svc.Observe(ObservationARPReply, netip.MustParseAddr("10.0.0.1"), mustParseMAC("00:16:3e:00:00:01"), nil,
+		time.Unix(1700000000, 0))
+
+	bindings := svc.Bindings()
+	require.Len(t, bindings, 2)
+	assert.Equal(t, "10.0.0.1", bindings[0].IP)
+
+	// reading the table doesn't take a snapshot
+	snap := svc.Snapshot()
+	assert.Equal(t, uint64(1), snap.Sequence)
+	assert.Equal(t, bindings, snap.Bindings)
+}
@@ -0,0 +1,473 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+// defaultHeldAnnouncements bounds the announcements a TransmitGate holds in
+// standby, those of a few hundred addresses of the host
+const defaultHeldAnnouncements = 256
+
+// ErrStandby is returned for the frames and the scans refused while the
+// agent is in standby, and is the cause of the context of the scans a
+// demotion cancels
+var ErrStandby = errors.New("agent in standby")
+
+// Mode is whether an agent transmits
+type Mode uint8
+
+const (
+	// ModeActive is the Mode of an agent which transmits
+	ModeActive Mode = iota + 1
+	// ModeStandby is the Mode of an agent which captures and keeps its
+	// neighbor tables, but sends nothing, ready to take over from the
+	// active one
+	ModeStandby
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeActive:
+		return "active"
+	case ModeStandby:
+		return "standby"
+	default:
+		return fmt.Sprintf("Mode(%d)", uint8(m))
+	}
+}
+
+// MarshalText encodes the Mode as its String
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText decodes a Mode from its String
+func (m *Mode) UnmarshalText(text []byte) error {
+	for mode := ModeActive; mode <= ModeStandby; mode++ {
+		if mode.String() == string(text) {
+			*m = mode
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown mode %q", text)
+}
+
+// ModeChange is a transition of a TransmitGate, the Previous and Current
+// modes are the same when it was already in the Mode asked for
+type ModeChange struct {
+	Previous Mode `json:"previous"`
+	Current  Mode `json:"current"`
+	// Replayed is the number of announcements held in standby sent on the
+	// promotion
+	Replayed int `json:"replayed,omitempty"`
+	// Cancelled is the number of transmissions, such as scans, running
+	// when the demotion cancelled them
+	Cancelled int `json:"cancelled,omitempty"`
+}
+
+// Changed returns true if the transition changed the Mode
+func (c ModeChange) Changed() bool {
+	return c.Previous != c.Current
+}
+
+// TransmitGateStatus is the state of a TransmitGate
+type TransmitGateStatus struct {
+	Mode Mode `json:"mode"`
+	// Since is the time of the last transition, or of the creation of the
+	// TransmitGate
+	Since       time.Time `json:"since"`
+	Transitions uint64    `json:"transitions"`
+	// Held is the number of announcements waiting for the promotion
+	Held int `json:"held"`
+	// Running is the number of transmissions in flight, such as scans
+	Running int `json:"running"`
+	// Refused is the number of frames refused in standby, by TransmitClass
+	Refused map[string]uint64 `json:"refused"`
+}
+
+// heldFrame is an announcement refused in standby, sent to w on promotion
+type heldFrame struct {
+	w     capture.FrameWriter
+	frame []byte
+}
+
+// gateLease is a transmission in flight, which a demotion cancels and
+// waits for
+type gateLease struct {
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+// TransmitGate holds the transmissions of an agent in standby, so that a
+// warm standby agent captures and keeps its neighbor tables up to date
+// without sending anything until it is promoted. The senders of the
+// interfaces write through Writer, and the Scheduler begins its scans with
+// Begin, see WithTransmitGate and WithSchedulerGate.
+//
+// In standby every frame is refused with an error matching ErrStandby, and
+// the announcements of the addresses of the host are held, the latest of
+// each, to be sent on Promote so the other hosts learn the new active
+// agent at once. Demote cancels the scans running and waits for the frames
+// in flight, no frame is sent once it returns.
+type TransmitGate struct {
+	clock clock.Clock
+	since time.Time
+	// running are the transmissions in flight, watchers are woken on
+	// promotion
+	running  map[*gateLease]struct{}
+	watchers map[chan struct{}]struct{}
+	held     []heldFrame
+	refused  [transmitClassCount]uint64
+	maxHeld  int
+	mode     Mode
+	// transitions counts the Mode changes, transition serializes Promote
+	// and Demote, mu protects the rest
+	transitions uint64
+	transition  sync.Mutex
+	mu          sync.Mutex
+}
+
+// TransmitGateOption configures a TransmitGate
+type TransmitGateOption func(*TransmitGate)
+
+// WithGateClock sets the clock timing the transitions
+func WithGateClock(c clock.Clock) TransmitGateOption {
+	return func(g *TransmitGate) {
+		g.clock = c
+	}
+}
+
+// WithHeldAnnouncements sets the number of announcements held in standby,
+// the oldest are dropped beyond it
+func WithHeldAnnouncements(n int) TransmitGateOption {
+	return func(g *TransmitGate) {
+		if n > 0 {
+			g.maxHeld = n
+		}
+	}
+}
+
+// WithGateMeter reports the Mode into the netmon.mode gauge of meter, 1
+// for the current one, and the frames refused in standby into the
+// netmon.transmit.refused counter, by class
+func WithGateMeter(meter metric.Meter) TransmitGateOption {
+	return func(g *TransmitGate) {
+		g.registerMetrics(meter)
+	}
+}
+
+// NewTransmitGate returns a TransmitGate in mode
+func NewTransmitGate(mode Mode, options ...TransmitGateOption) *TransmitGate {
+	if mode != ModeStandby {
+		mode = ModeActive
+	}
+
+	g := &TransmitGate{
+		clock:    clock.System{},
+		running:  make(map[*gateLease]struct{}),
+		watchers: make(map[chan struct{}]struct{}),
+		maxHeld:  defaultHeldAnnouncements,
+		mode:     mode,
+	}
+
+	for _, opt := range options {
+		opt(g)
+	}
+
+	g.since = g.clock.Now()
+
+	return g
+}
+
+func (g *TransmitGate) registerMetrics(meter metric.Meter) {
+	must(meter.Int64ObservableGauge("netmon.mode",
+		metric.WithDescription("Mode of the agent, 1 for the current one"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			current := g.Mode()
+
+			for mode := ModeActive; mode <= ModeStandby; mode++ {
+				var v int64
+				if mode == current {
+					v = 1
+				}
+
+				o.Observe(v, metric.WithAttributes(attribute.String("mode", mode.String())))
+			}
+
+			return nil
+		})))
+
+	must(meter.Int64ObservableCounter("netmon.transmit.refused",
+		metric.WithDescription("Frames refused while the agent is in standby"),
+		metric.WithUnit("{frame}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			g.mu.Lock()
+			refused := g.refused
+			g.mu.Unlock()
+
+			for c := range transmitClassCount {
+				//nolint:gosec // counters fit
+				o.Observe(int64(refused[c]), metric.WithAttributes(attribute.String("class", c.String())))
+			}
+
+			return nil
+		})))
+}
+
+// Mode returns the current Mode
+func (g *TransmitGate) Mode() Mode {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.mode
+}
+
+// Status returns the state of the TransmitGate
+func (g *TransmitGate) Status() TransmitGateStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	st := TransmitGateStatus{
+		Mode:        g.mode,
+		Since:       g.since,
+		Transitions: g.transitions,
+		Held:        len(g.held),
+		Running:     len(g.running),
+		Refused:     make(map[string]uint64, transmitClassCount),
+	}
+
+	for c := range transmitClassCount {
+		st.Refused[c.String()] = g.refused[c]
+	}
+
+	return st
+}
+
+// Begin starts a transmission, such as a scan, which runs with the context
+// returned until release is called. The context is cancelled with the
+// cause ErrStandby on demotion, and an error matching ErrStandby is
+// returned in standby.
+func (g *TransmitGate) Begin(ctx context.Context) (context.Context, func(), error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.mode == ModeStandby {
+		return ctx, func() {}, ErrStandby
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	return ctx, g.lease(cancel), nil
+}
+
+// lease registers a transmission in flight and returns its release, g.mu
+// must be held
+func (g *TransmitGate) lease(cancel context.CancelCauseFunc) func() {
+	l := &gateLease{cancel: cancel, done: make(chan struct{})}
+	g.running[l] = struct{}{}
+
+	return sync.OnceFunc(func() {
+		g.mu.Lock()
+		delete(g.running, l)
+		g.mu.Unlock()
+
+		cancel(nil)
+		close(l.done)
+	})
+}
+
+// Writer returns the capture.FrameWriter of the frames of class, writing
+// them to w unless in standby
+func (g *TransmitGate) Writer(class TransmitClass, w capture.FrameWriter) capture.FrameWriter {
+	return &gatedWriter{gate: g, w: w, class: class}
+}
+
+// gatedWriter is a Writer of a TransmitGate
+type gatedWriter struct {
+	gate  *TransmitGate
+	w     capture.FrameWriter
+	class TransmitClass
+}
+
+// WriteFrame writes frame, it returns an error matching ErrStandby in
+// standby
+func (w *gatedWriter) WriteFrame(frame []byte) error {
+	release, err := w.gate.admit(w.class, w.w, frame)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	return w.w.WriteFrame(frame)
+}
+
+// admit lets a frame of class through, or refuses it in standby, holding
+// it if it is an announcement
+func (g *TransmitGate) admit(class TransmitClass, w capture.FrameWriter, frame []byte) (func(), error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.mode == ModeActive {
+		return g.lease(func(error) {}), nil
+	}
+
+	if class >= 0 && class < transmitClassCount {
+		g.refused[class]++
+	}
+
+	if class == TransmitAnnouncement {
+		g.hold(w, frame)
+	}
+
+	return nil, fmt.Errorf("%w: %s frame not sent", ErrStandby, class)
+}
+
+// hold keeps frame for the promotion, an earlier copy of it is replaced,
+// g.mu must be held
+func (g *TransmitGate) hold(w capture.FrameWriter, frame []byte) {
+	g.held = slices.DeleteFunc(g.held, func(f heldFrame) bool {
+		return f.w == w && bytes.Equal(f.frame, frame)
+	})
+
+	if len(g.held) >= g.maxHeld {
+		g.held = slices.Delete(g.held, 0, len(g.held)-g.maxHeld+1)
+	}
+
+	g.held = append(g.held, heldFrame{w: w, frame: slices.Clone(frame)})
+}
+
+// setMode records a transition to mode, g.mu must be held
+func (g *TransmitGate) setMode(mode Mode) {
+	g.mode = mode
+	g.since = g.clock.Now()
+	g.transitions++
+}
+
+// Promote lets the transmissions through, the announcements held in standby
+// are sent first. ctx bounds their replay, those not sent once it is done
+// are dropped, the monitors announce the addresses again anyway.
+func (g *TransmitGate) Promote(ctx context.Context) (ModeChange, error) {
+	g.transition.Lock()
+	defer g.transition.Unlock()
+
+	g.mu.Lock()
+
+	change := ModeChange{Previous: g.mode, Current: ModeActive}
+	if g.mode == ModeActive {
+		g.mu.Unlock()
+		return change, nil
+	}
+
+	g.setMode(ModeActive)
+
+	held := g.held
+	g.held = nil
+
+	for ch := range g.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+
+	g.mu.Unlock()
+
+	// Demote waits for the replay, it can't be interleaved
+	for _, f := range held {
+		if err := ctx.Err(); err != nil {
+			return change, err
+		}
+
+		if err := f.w.WriteFrame(f.frame); err != nil {
+			log.Warn().Err(err).Msg("Held announcement not replayed")
+			continue
+		}
+
+		change.Replayed++
+	}
+
+	return change, nil
+}
+
+// Demote holds the transmissions, the scans running are cancelled and
+// waited for until ctx is done, as are the frames in flight
+func (g *TransmitGate) Demote(ctx context.Context) (ModeChange, error) {
+	g.transition.Lock()
+	defer g.transition.Unlock()
+
+	g.mu.Lock()
+
+	change := ModeChange{Previous: g.mode, Current: ModeStandby}
+	if g.mode == ModeStandby {
+		g.mu.Unlock()
+		return change, nil
+	}
+
+	g.setMode(ModeStandby)
+
+	leases := slices.Collect(maps.Keys(g.running))
+	for _, l := range leases {
+		l.cancel(ErrStandby)
+	}
+
+	change.Cancelled = len(leases)
+
+	g.mu.Unlock()
+
+	for _, l := range leases {
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return change, ctx.Err()
+		}
+	}
+
+	return change, nil
+}
+
+// watch returns a channel receiving on every promotion, until cancel is
+// called
+func (g *TransmitGate) watch() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	g.mu.Lock()
+	g.watchers[ch] = struct{}{}
+	g.mu.Unlock()
+
+	return ch, func() {
+		g.mu.Lock()
+		delete(g.watchers, ch)
+		g.mu.Unlock()
+	}
+}
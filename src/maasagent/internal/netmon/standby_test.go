@@ -0,0 +1,262 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+func TestModeText(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range []Mode{ModeActive, ModeStandby} {
+		text, err := mode.MarshalText()
+		require.NoError(t, err)
+
+		var got Mode
+
+		require.NoError(t, got.UnmarshalText(text))
+		assert.Equal(t, mode, got)
+	}
+
+	var m Mode
+
+	assert.Error(t, m.UnmarshalText([]byte("passive")))
+	assert.Equal(t, "Mode(7)", Mode(7).String())
+}
+
+func TestTransmitGateWriter(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	g := NewTransmitGate(ModeStandby, WithGateClock(clk), WithHeldAnnouncements(2))
+	w := &recordingWriter{}
+
+	scan := g.Writer(TransmitScan, w)
+	announce := g.Writer(TransmitAnnouncement, w)
+
+	assert.ErrorIs(t, scan.WriteFrame([]byte("probe")), ErrStandby)
+
+	// the latest announcement of each address is held, up to 2
+	for _, frame := range []string{"garp1", "garp2", "garp1", "garp3", "garp3"} {
+		assert.ErrorIs(t, announce.WriteFrame([]byte(frame)), ErrStandby)
+	}
+
+	assert.Empty(t, w.written())
+
+	st := g.Status()
+	assert.Equal(t, ModeStandby, st.Mode)
+	assert.Equal(t, 2, st.Held)
+	assert.Equal(t, uint64(1), st.Refused["scan"])
+	assert.Equal(t, uint64(5), st.Refused["announcement"])
+
+	clk.Advance(time.Minute)
+
+	change, err := g.Promote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, ModeChange{Previous: ModeStandby, Current: ModeActive, Replayed: 2}, change)
+	assert.True(t, change.Changed())
+	assert.Equal(t, []string{"garp1", "garp3"}, w.written())
+
+	require.NoError(t, scan.WriteFrame([]byte("probe")))
+	assert.Equal(t, []string{"garp1", "garp3", "probe"}, w.written())
+
+	st = g.Status()
+	assert.Equal(t, ModeActive, st.Mode)
+	assert.Equal(t, schedulerEpoch.Add(time.Minute), st.Since)
+	assert.Equal(t, uint64(1), st.Transitions)
+	assert.Zero(t, st.Held)
+
+	// promoting again changes nothing
+	change, err = g.Promote(context.Background())
+	require.NoError(t, err)
+	assert.False(t, change.Changed())
+	assert.Equal(t, uint64(1), g.Status().Transitions)
+}
+
+func TestTransmitGateDemote(t *testing.T) {
+	defer leak.Check(t)()
+
+	g := NewTransmitGate(ModeActive)
+
+	ctx, release, err := g.Begin(context.Background())
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer release()
+
+		<-ctx.Done()
+	}()
+
+	assert.Equal(t, 1, g.Status().Running)
+
+	change, err := g.Demote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, ModeChange{Previous: ModeActive, Current: ModeStandby, Cancelled: 1}, change)
+
+	// the transmission returned before Demote did
+	select {
+	case <-done:
+	default:
+		t.Fatal("Demote returned before the transmission")
+	}
+
+	assert.ErrorIs(t, context.Cause(ctx), ErrStandby)
+	assert.Zero(t, g.Status().Running)
+
+	_, _, err = g.Begin(context.Background())
+	assert.ErrorIs(t, err, ErrStandby)
+}
+
+func TestTransmitGateDemoteTimeout(t *testing.T) {
+	t.Parallel()
+
+	g := NewTransmitGate(ModeActive)
+
+	_, release, err := g.Begin(context.Background())
+	require.NoError(t, err)
+
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// a transmission not returning doesn't block the demotion forever
+	_, err = g.Demote(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, ModeStandby, g.Mode())
+}
+
+func TestTransmitGateMeter(t *testing.T) {
+	t.Parallel()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	g := NewTransmitGate(ModeStandby, WithGateMeter(provider.Meter("test")))
+	assert.Error(t, g.Writer(TransmitLLDP, &recordingWriter{}).WriteFrame([]byte("lldp")))
+
+	var rm metricdata.ResourceMetrics
+
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	values := make(map[string]int64)
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		switch data := m.Data.(type) {
+		case metricdata.Gauge[int64]:
+			for _, dp := range data.DataPoints {
+				mode, _ := dp.Attributes.Value("mode")
+				values[m.Name+"/"+mode.AsString()] = dp.Value
+			}
+		case metricdata.Sum[int64]:
+			for _, dp := range data.DataPoints {
+				class, _ := dp.Attributes.Value("class")
+				values[m.Name+"/"+class.AsString()] = dp.Value
+			}
+		}
+	}
+
+	assert.Equal(t, int64(0), values["netmon.mode/active"])
+	assert.Equal(t, int64(1), values["netmon.mode/standby"])
+	assert.Equal(t, int64(1), values["netmon.transmit.refused/lldp"])
+	assert.Equal(t, int64(0), values["netmon.transmit.refused/scan"])
+}
+
+func TestSchedulerStandby(t *testing.T) {
+	defer leak.Check(t)()
+
+	clk := clocktest.NewFake(schedulerEpoch)
+	calls := make(chan netip.Addr)
+	cancelled := make(chan struct{}, 1)
+
+	// the first run waits to be cancelled, the next complete
+	scan := func(ctx context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		calls <- ips[0]
+
+		select {
+		case cancelled <- struct{}{}:
+			<-ctx.Done()
+			return nil, ctx.Err()
+		default:
+			return nil, nil
+		}
+	}
+
+	g := NewTransmitGate(ModeStandby)
+	s := NewScheduler(WithSchedulerClock(clk), WithScanFunc(scan), WithScanJitter(0), WithSchedulerGate(g))
+
+	require.NoError(t, s.Add(ScanJob{
+		ID:       "a",
+		Targets:  []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")},
+		Interval: time.Hour,
+	}))
+
+	stop := startScheduler(t, s)
+	defer stop()
+
+	// the job is due, but nothing runs in standby, even triggered
+	require.NoError(t, s.Trigger("a"))
+
+	select {
+	case <-calls:
+		t.Fatal("scan run in standby")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err := g.Promote(context.Background())
+	require.NoError(t, err)
+	<-calls
+
+	// the demotion cancels the run, which isn't recorded
+	<-cancelled
+	change, err := g.Demote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, change.Cancelled)
+
+	st := s.Status()[0]
+	assert.False(t, st.Running)
+	assert.Zero(t, st.LastRun)
+	assert.Nil(t, st.LastResult)
+
+	// and runs again once promoted
+	cancelled <- struct{}{}
+
+	_, err = g.Promote(context.Background())
+	require.NoError(t, err)
+	<-calls
+	<-cancelled
+
+	assert.Eventually(t, func() bool {
+		return s.Status()[0].LastResult != nil
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build stress
+
+package netmon
+
+// The stress tests hammer the state the observation layer shares between
+// goroutines, they are meant for the race detector:
+// go test -race -tags stress -run Stress ./internal/...
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+const (
+	stressWriters = 8
+	stressReaders = 4
+	stressRounds  = 2000
+	stressHosts   = 256
+)
+
+// stressMAC is the MAC of the host i, never the zero MAC
+func stressMAC(i int) net.HardwareAddr {
+	return net.HardwareAddr{0x52, 0x54, 0x00, byte(i >> 16), byte(i >> 8), byte(i)}
+}
+
+func stressIP(i int) netip.Addr {
+	return netip.AddrFrom4([4]byte{10, 1, byte(i >> 8), byte(i)})
+}
+
+// checkBindings reports the bindings breaking the invariants of the table
+func checkBindings(t *testing.T, bindings []SnapshotBinding) {
+	t.Helper()
+
+	zero := make(net.HardwareAddr, 6)
+
+	for _, b := range bindings {
+		mac, err := net.ParseMAC(b.MAC)
+		if err != nil || bytes.Equal(mac, zero) {
+			t.Errorf("binding of %s has the MAC %q", b.IP, b.MAC)
+		}
+	}
+}
+
+func TestStressNeighborTable(t *testing.T) {
+	duplicates := NewDuplicateMACDetector()
+	history := NewHistory()
+	evidence := NewEvidenceLog()
+
+	newService := func(iface string) *Service {
+		return NewService(iface, WithDuplicateMACDetector(duplicates), WithHistory(history),
+			WithEvidenceLog(evidence))
+	}
+
+	eth0, eth1 := newService("eth0"), newService("eth1")
+
+	frames := make([][]byte, stressHosts)
+	for i := range frames {
+		frames[i] = buildFrame(t, ethernet.NewFrame().Src(stressMAC(i)).Padded().
+			ARPRequest(stressIP(i), netip.MustParseAddr("10.1.255.254")), nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var writers, readers sync.WaitGroup
+
+	// the writers observe the hosts through every path, the MAC of a host
+	// moving between writers
+	for w := range stressWriters {
+		writers.Add(1)
+
+		go func() {
+			defer writers.Done()
+
+			for n := range stressRounds {
+				i := (n*stressWriters + w) % stressHosts
+				svc := eth0
+				if n%4 == 0 {
+					svc = eth1
+				}
+
+				switch n % 3 {
+				case 0:
+					if _, err := svc.handleFrame(frames[i], capture.Metadata{}); err != nil {
+						t.Error(err)
+					}
+				case 1:
+					svc.Observe(ObservationARPReply, stressIP(i), stressMAC(i+w*stressHosts), nil, time.Time{})
+				default:
+					if _, err := svc.Ingest(Observation{Source: "stress", IP: stressIP(i), MAC: stressMAC(i),
+						Kind: ObservationDHCPAck, Confidence: ConfidenceHigh}); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}()
+	}
+
+	// the readers snapshot the table, and apply the diffs of their
+	// snapshots
+	for range stressReaders {
+		readers.Add(1)
+
+		go func() {
+			defer readers.Done()
+
+			prev := eth0.Snapshot()
+
+			for ctx.Err() == nil {
+				cur := eth0.Snapshot()
+				checkBindings(t, cur.Bindings)
+
+				if cur.Sequence <= prev.Sequence {
+					t.Errorf("snapshot %d after %d", cur.Sequence, prev.Sequence)
+				}
+
+				applied, err := cur.Diff(prev).Apply(prev)
+				if err != nil {
+					t.Error(err)
+				} else if len(applied.Bindings) != len(cur.Bindings) {
+					t.Errorf("diff applied to %d bindings, snapshot has %d", len(applied.Bindings), len(cur.Bindings))
+				}
+
+				checkBindings(t, eth1.Bindings())
+				eth0.Lookup(stressIP(int(cur.Sequence)%stressHosts), nil)
+				history.BindingsForIP(stressIP(int(cur.Sequence)%stressHosts), time.Time{}, time.Now())
+
+				if _, err := eth1.CaptureStatus(); err != nil {
+					t.Error(err)
+				}
+
+				prev = cur
+			}
+		}()
+	}
+
+	// the configuration is reloaded while the table changes
+	readers.Add(1)
+
+	go func() {
+		defer readers.Done()
+
+		for n := 0; ctx.Err() == nil; n++ {
+			target := capture.Target{IPs: []netip.Addr{stressIP(n % stressHosts)}}
+			if err := eth0.SetTarget(target); err != nil {
+				t.Error(err)
+			}
+
+			if err := eth1.SetLabels(map[string]string{"fabric": "fabric-" + string(rune('a'+n%26))}); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	writers.Wait()
+	cancel()
+	readers.Wait()
+
+	// at quiescence, a snapshot holds the whole table, and every host is
+	// bound on either interface
+	ips := make(map[string]struct{})
+
+	for _, svc := range []*Service{eth0, eth1} {
+		snap := svc.Snapshot()
+		checkBindings(t, snap.Bindings)
+		assert.Equal(t, svc.table.size(), len(snap.Bindings), svc.iface)
+
+		for _, b := range snap.Bindings {
+			ips[b.IP] = struct{}{}
+		}
+	}
+
+	assert.Equal(t, stressHosts, len(ips))
+}
+
+func TestStressScheduler(t *testing.T) {
+	scan := func(_ context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+		found := make(map[netip.Addr]net.HardwareAddr, len(ips))
+		for i, ip := range ips {
+			found[ip] = stressMAC(i)
+		}
+
+		return found, nil
+	}
+
+	s := NewScheduler(WithScanFunc(scan), WithScanConcurrency(4),
+		WithScanResults(func(string, map[netip.Addr]net.HardwareAddr) {}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+
+	go func() { errC <- s.Run(ctx) }()
+
+	var wg sync.WaitGroup
+
+	// the jobs are added, triggered and removed while they run
+	for w := range stressWriters {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for n := range stressRounds / 10 {
+				job := ScanJob{
+					ID:        "eth0/" + string(rune('a'+w)),
+					Interface: "eth0",
+					Targets:   []netip.Prefix{netip.PrefixFrom(stressIP(n%stressHosts), 30).Masked()},
+					Interval:  time.Hour,
+				}
+
+				switch n % 4 {
+				case 0:
+					if err := s.Add(job); err != nil {
+						t.Error(err)
+					}
+				case 1:
+					_ = s.Trigger(job.ID) //nolint:errcheck // the job may be removed
+				case 2:
+					_ = s.Pause(job.ID)  //nolint:errcheck // the job may be removed
+					_ = s.Resume(job.ID) //nolint:errcheck // the job may be removed
+				default:
+					_ = s.Remove(job.ID) //nolint:errcheck // the job may be removed
+				}
+
+				s.Status()
+				s.ProbeStats()
+			}
+		}()
+	}
+
+	wg.Wait()
+	cancel()
+	require.NoError(t, <-errC)
+	assert.Empty(t, s.Status())
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// defaultShards lets the capture loop, the observers of other sources
+	// and a few readers go on at once without a shard per core
+	defaultShards = 16
+	// minShardBindings is the fewest bindings a shard is bounded to, the
+	// least recently observed bindings of a shard are then close enough to
+	// those of the table. A table bounded to fewer has less shards.
+	minShardBindings = 64
+)
+
+// bindingShard holds the bindings, and their challengers, of the keys
+// hashing to it. mu protects both.
+type bindingShard struct {
+	bindings    map[bindingKey]Binding
+	challengers map[bindingKey]Binding
+	max         int
+	mu          sync.RWMutex
+}
+
+// bindingTable is the neighbor table of a Service, sharded by a hash of the
+// key so that observations of different hosts only contend when they hash
+// to the same shard, and readers only pause the shard they copy. The table
+// holds the bindings of one interface, so the hash of the key covers the
+// interface, the VLAN and the IP.
+type bindingTable struct {
+	shards    []bindingShard
+	seed      maphash.Seed
+	evictions atomic.Uint64
+}
+
+// newBindingTable returns a table of the given number of shards, less for
+// a small table, bounded to maxBindings between them
+func newBindingTable(shards, maxBindings int) *bindingTable {
+	n := max(1, min(shards, maxBindings/minShardBindings))
+
+	t := &bindingTable{
+		shards: make([]bindingShard, n),
+		seed:   maphash.MakeSeed(),
+	}
+
+	for i := range t.shards {
+		t.shards[i] = bindingShard{
+			bindings:    make(map[bindingKey]Binding),
+			challengers: make(map[bindingKey]Binding),
+			max:         (maxBindings + n - 1) / n,
+		}
+	}
+
+	return t
+}
+
+// shard returns the shard of key
+func (t *bindingTable) shard(key bindingKey) *bindingShard {
+	if len(t.shards) == 1 {
+		return &t.shards[0]
+	}
+
+	return &t.shards[maphash.Comparable(t.seed, key)%uint64(len(t.shards))]
+}
+
+// lookup returns the binding of key, only taking the read lock of its shard
+func (t *bindingTable) lookup(key bindingKey) (Binding, bool) {
+	sh := t.shard(key)
+
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	b, ok := sh.bindings[key]
+
+	return b, ok
+}
+
+// each calls f with every binding, one shard at a time under its read
+// lock. f must not call the table.
+func (t *bindingTable) each(f func(bindingKey, Binding)) {
+	for i := range t.shards {
+		sh := &t.shards[i]
+
+		sh.mu.RLock()
+
+		for key, b := range sh.bindings {
+			f(key, b)
+		}
+
+		sh.mu.RUnlock()
+	}
+}
+
+// update calls f with every shard in turn, under its write lock
+func (t *bindingTable) update(f func(*bindingShard)) {
+	for i := range t.shards {
+		sh := &t.shards[i]
+
+		sh.mu.Lock()
+		f(sh)
+		sh.mu.Unlock()
+	}
+}
+
+// size returns the number of bindings, which may be changing
+func (t *bindingTable) size() int {
+	var n int
+
+	for i := range t.shards {
+		sh := &t.shards[i]
+
+		sh.mu.RLock()
+		n += len(sh.bindings)
+		sh.mu.RUnlock()
+	}
+
+	return n
+}
+
+// bind sets the binding of key, evicting the least recently observed
+// bindings of the shard and their challengers first when a new key finds it
+// full. sh.mu must be held.
+func (t *bindingTable) bind(sh *bindingShard, key bindingKey, b Binding) {
+	if _, ok := sh.bindings[key]; !ok && len(sh.bindings) >= sh.max {
+		t.evictions.Add(evictOldest(sh.bindings, func(b Binding) int64 {
+			return b.Time.UnixNano()
+		}))
+
+		for k := range sh.challengers {
+			if _, ok := sh.bindings[k]; !ok {
+				delete(sh.challengers, k)
+			}
+		}
+	}
+
+	sh.bindings[key] = b
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tableHost returns the IP and MAC of the i-th host of a table test
+func tableHost(i int) (netip.Addr, net.HardwareAddr) {
+	return netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)}),
+		net.HardwareAddr{0x02, 0x00, 0x00, byte(i >> 16), byte(i >> 8), byte(i)}
+}
+
+func TestBindingTableShards(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		options []ServiceOption
+		shards  int
+	}{
+		"default": {
+			shards: defaultShards,
+		},
+		"configured": {
+			options: []ServiceOption{WithTableShards(4)},
+			shards:  4,
+		},
+		"single lock": {
+			options: []ServiceOption{WithTableShards(1)},
+			shards:  1,
+		},
+		"ignored": {
+			options: []ServiceOption{WithTableShards(0)},
+			shards:  defaultShards,
+		},
+		"small table": {
+			options: []ServiceOption{WithLimits(Limits{Bindings: 3 * minShardBindings})},
+			shards:  3,
+		},
+		"tiny table": {
+			options: []ServiceOption{WithLimits(Limits{Bindings: 10})},
+			shards:  1,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewService("eth0", tc.options...)
+			assert.Len(t, s.table.shards, tc.shards)
+		})
+	}
+}
+
+func TestBindingTableSpread(t *testing.T) {
+	t.Parallel()
+
+	s := NewService("eth0")
+	start := time.Unix(1700000000, 0)
+	vid := uint16(100)
+
+	// the same IP on another VLAN is another binding
+	for i := range 4096 {
+		ip, mac := tableHost(i)
+		s.Observe(ObservationARPReply, ip, mac, nil, start)
+		s.Observe(ObservationARPReply, ip, mac, &vid, start)
+	}
+
+	assert.Equal(t, 8192, s.table.size())
+	assert.Len(t, s.Bindings(), 8192)
+
+	// no shard holds much more than its share
+	for i := range s.table.shards {
+		assert.Less(t, len(s.table.shards[i].bindings), 2*8192/defaultShards, "shard %d", i)
+	}
+}
+
+func TestServiceLookup(t *testing.T) {
+	t.Parallel()
+
+	s := NewService("eth0")
+	start := time.Unix(1700000000, 0)
+	vid := uint16(100)
+	ip, mac := tableHost(1)
+
+	s.Observe(ObservationARPReply, ip, mac, &vid, start)
+
+	b, ok := s.Lookup(ip, &vid)
+	require.True(t, ok)
+	assert.Equal(t, mac.String(), b.MAC)
+	assert.Equal(t, &vid, b.VID)
+	assert.Equal(t, s.Bindings()[0], b)
+
+	// an IPv4-mapped address is the IPv4 address it maps
+	mapped, ok := s.Lookup(netip.AddrFrom16(ip.As16()), &vid)
+	require.True(t, ok)
+	assert.Equal(t, b, mapped)
+
+	_, ok = s.Lookup(ip, nil)
+	assert.False(t, ok)
+
+	other, _ := tableHost(2)
+
+	_, ok = s.Lookup(other, &vid)
+	assert.False(t, ok)
+}
+
+func TestBindingTableEvictions(t *testing.T) {
+	t.Parallel()
+
+	limit := 4 * minShardBindings
+	s := NewService("eth0", WithLimits(Limits{Bindings: limit}))
+	start := time.Unix(1700000000, 0)
+
+	for i := range 2 * limit {
+		ip, mac := tableHost(i)
+		s.Observe(ObservationARPReply, ip, mac, nil, start.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	// every shard is bounded to its share of the limit, and evicts its
+	// least recently observed bindings
+	assert.LessOrEqual(t, s.table.size(), limit)
+	assert.NotZero(t, s.Evictions())
+
+	last, _ := tableHost(2*limit - 1)
+
+	_, ok := s.Lookup(last, nil)
+	assert.True(t, ok)
+
+	first, _ := tableHost(0)
+
+	_, ok = s.Lookup(first, nil)
+	assert.False(t, ok)
+}
+
+// TestBindingTableConcurrency observes from several goroutines while the
+// table is read, for the race detector
+func TestBindingTableConcurrency(t *testing.T) {
+	t.Parallel()
+
+	s := NewService("eth0", WithProxyDetector(NewProxyDetector()))
+	start := time.Unix(1700000000, 0)
+
+	var wg sync.WaitGroup
+
+	for w := range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range 1000 {
+				ip, mac := tableHost(w*1000 + i)
+				s.Observe(ObservationARPReply, ip, mac, nil, start.Add(time.Duration(i)*time.Second))
+				s.Lookup(ip, nil)
+			}
+		}()
+	}
+
+	sequences := make(chan uint64, 50)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for range cap(sequences) {
+			sequences <- s.Snapshot().Sequence
+			s.Locate(net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01})
+		}
+
+		close(sequences)
+	}()
+
+	wg.Wait()
+
+	var previous uint64
+
+	for seq := range sequences {
+		assert.Greater(t, seq, previous)
+		previous = seq
+	}
+
+	assert.Len(t, s.Bindings(), 4000)
+}
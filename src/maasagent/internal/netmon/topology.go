@@ -0,0 +1,403 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/cdp"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/lldp"
+)
+
+const (
+	// TopologyLLDP and TopologyCDP are the protocols a TopologyReport is
+	// merged from
+	TopologyLLDP = "lldp"
+	TopologyCDP  = "cdp"
+
+	// topologySnapLen keeps an LLDPDU or a CDP packet whole, the largest
+	// fill a frame
+	topologySnapLen = 1522
+)
+
+// PortAggregation is the link aggregation status of a switch port
+type PortAggregation struct {
+	// PortID is the ifIndex of the aggregate on the switch, 0 unless
+	// Enabled
+	PortID  uint32 `json:"port_id,omitempty"`
+	Capable bool   `json:"capable"`
+	Enabled bool   `json:"enabled"`
+}
+
+// UpstreamPort is the switch port an interface is connected to, as its
+// advertisements describe it. The switch and the port are identified by
+// ChassisID and PortID, the CDP device ID being the chassis ID of CDP.
+type UpstreamPort struct {
+	// Aggregation is set when the switch tells the link aggregation status
+	// of the port, which only LLDP does
+	Aggregation     *PortAggregation `json:"aggregation,omitempty"`
+	ChassisID       string           `json:"chassis_id"`
+	SystemName      string           `json:"system_name,omitempty"`
+	PortID          string           `json:"port_id"`
+	PortDescription string           `json:"port_description,omitempty"`
+	// NativeVLAN is the untagged VLAN of the port, 0 when the switch
+	// doesn't tell
+	NativeVLAN uint16 `json:"native_vlan,omitempty"`
+}
+
+// sameIdentity returns true when p and o are the same port of the same
+// switch
+func (p UpstreamPort) sameIdentity(o UpstreamPort) bool {
+	return p.ChassisID == o.ChassisID && p.PortID == o.PortID
+}
+
+// TopologyReport is the switch port an interface is connected to, merged
+// from the LLDP and CDP advertisements received on it
+type TopologyReport struct {
+	Interface string       `json:"interface"`
+	Upstream  UpstreamPort `json:"upstream"`
+	// Sources are the protocols Upstream was merged from, the one taking
+	// precedence first
+	Sources []string `json:"sources"`
+	// Conflicting is set when LLDP and CDP name different switches, the
+	// port is then that of the protocol taking precedence alone
+	Conflicting bool `json:"conflicting,omitempty"`
+	// LastAdvertisement is the time of the latest advertisement of the
+	// Sources, and Age the seconds since then
+	LastAdvertisement int64 `json:"last_advertisement"`
+	Age               int64 `json:"age"`
+	// Stale is set once the TTL of every advertisement expired without a
+	// refresh, the port may not be connected anymore
+	Stale bool `json:"stale"`
+}
+
+// UpstreamChange is the switch port of an interface before and after an
+// EventUpstreamPortChanged, as advertised by Protocol
+type UpstreamChange struct {
+	Protocol string       `json:"protocol"`
+	Previous UpstreamPort `json:"previous"`
+	Current  UpstreamPort `json:"current"`
+}
+
+type advertisement struct {
+	time    time.Time
+	expires time.Time
+	port    UpstreamPort
+}
+
+func (a *advertisement) fresh(now time.Time) bool {
+	return a != nil && now.Before(a.expires)
+}
+
+// interfaceTopology holds the latest advertisement of each protocol on an
+// interface
+type interfaceTopology struct {
+	lldp *advertisement
+	cdp  *advertisement
+}
+
+// precedence returns the advertisement taking precedence and the other
+// one, with the name of their protocol
+func (t *interfaceTopology) precedence(now time.Time) (*advertisement, string, *advertisement, string) {
+	if t.lldp == nil || !t.lldp.fresh(now) && t.cdp.fresh(now) {
+		return t.cdp, TopologyCDP, t.lldp, TopologyLLDP
+	}
+
+	return t.lldp, TopologyLLDP, t.cdp, TopologyCDP
+}
+
+// Topology tracks the switch ports the interfaces of its Services are
+// connected to, from the LLDP and CDP advertisements of the switches. An
+// interface connected to another port than the one last advertised, once
+// recabled, is reported with an EventUpstreamPortChanged.
+//
+// When a switch sends both, the report is merged with this precedence: a
+// fresh advertisement, whose TTL didn't expire, over a stale one, and LLDP
+// over CDP otherwise. The fields the advertisement taking precedence lacks
+// are filled from the other one, fresh or as stale as it, unless they name
+// different switches, which marks the report conflicting.
+type Topology struct {
+	clock      clock.Clock
+	interfaces map[string]*interfaceTopology
+	mu         sync.Mutex
+}
+
+// TopologyOption configures a Topology
+type TopologyOption func(*Topology)
+
+// WithTopologyClock sets the clock timestamping the advertisements received
+// without a timestamp and aging them
+func WithTopologyClock(c clock.Clock) TopologyOption {
+	return func(t *Topology) {
+		t.clock = c
+	}
+}
+
+// NewTopology returns a Topology, to be shared by the Services of the
+// interfaces it reports
+func NewTopology(options ...TopologyOption) *Topology {
+	t := &Topology{
+		clock:      clock.System{},
+		interfaces: make(map[string]*interfaceTopology),
+	}
+
+	for _, opt := range options {
+		opt(t)
+	}
+
+	return t
+}
+
+// Observe reads an LLDP or CDP frame received on iface, decoded as opts
+// decide. It returns an EventUpstreamPortChanged when the frame advertises
+// another port than the previous one of its protocol, and that protocol
+// takes precedence.
+func (t *Topology) Observe(iface string, frame []byte, opts ethernet.ParserOptions, md capture.Metadata) []Result {
+	protocol, port, ttl, ok := readAdvertisement(frame, opts)
+	if !ok {
+		return nil
+	}
+
+	timestamp := md.Timestamp
+	if timestamp.IsZero() {
+		timestamp = t.clock.Now()
+	}
+
+	adv := &advertisement{time: timestamp, expires: timestamp.Add(ttl), port: port}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	it, ok := t.interfaces[iface]
+	if !ok {
+		it = &interfaceTopology{}
+		t.interfaces[iface] = it
+	}
+
+	previous := &it.lldp
+	if protocol == TopologyCDP {
+		previous = &it.cdp
+	}
+
+	prev := *previous
+	*previous = adv
+
+	_, leading, _, _ := it.precedence(timestamp)
+	if prev == nil || prev.port.sameIdentity(port) || leading != protocol {
+		return nil
+	}
+
+	log.Warn().Str("iface", iface).Str("protocol", protocol).
+		Str("previous_chassis", prev.port.ChassisID).Str("previous_port", prev.port.PortID).
+		Str("chassis", port.ChassisID).Str("port", port.PortID).Msg("upstream switch port changed")
+
+	return []Result{{
+		MAC:      net.HardwareAddr(frame[6:12]).String(),
+		Time:     timestamp.Unix(),
+		Event:    EventUpstreamPortChanged,
+		Upstream: &UpstreamChange{Protocol: protocol, Previous: prev.port, Current: port},
+	}}
+}
+
+// readAdvertisement returns the protocol of an LLDP or CDP frame, the port
+// it advertises and for how long
+func readAdvertisement(frame []byte, opts ethernet.ParserOptions) (string, UpstreamPort, time.Duration, bool) {
+	d, err := lldp.ParseFrameWith(frame, opts)
+	if err == nil {
+		port := UpstreamPort{
+			ChassisID:       d.ChassisID.String(),
+			SystemName:      d.SystemName,
+			PortID:          d.PortID.String(),
+			PortDescription: d.PortDescription,
+			NativeVLAN:      d.PortVLAN,
+		}
+
+		if d.Aggregation != nil {
+			port.Aggregation = &PortAggregation{
+				Capable: d.Aggregation.Capable,
+				Enabled: d.Aggregation.Enabled,
+				PortID:  d.Aggregation.PortID,
+			}
+		}
+
+		return TopologyLLDP, port, time.Duration(d.TTL) * time.Second, true
+	}
+
+	if !errors.Is(err, lldp.ErrNotLLDP) {
+		log.Debug().Err(err).Msg("skipping malformed LLDP frame")
+		return "", UpstreamPort{}, 0, false
+	}
+
+	p, err := cdp.ParseFrame(frame)
+	if err != nil {
+		if !errors.Is(err, cdp.ErrNotCDP) {
+			log.Debug().Err(err).Msg("skipping malformed CDP frame")
+		}
+
+		return "", UpstreamPort{}, 0, false
+	}
+
+	port := UpstreamPort{
+		ChassisID:  p.DeviceID,
+		SystemName: p.DeviceID,
+		PortID:     p.PortID,
+		NativeVLAN: p.NativeVLAN,
+	}
+
+	return TopologyCDP, port, time.Duration(p.TTL) * time.Second, true
+}
+
+// Report returns the switch port iface is connected to, false until an
+// advertisement was received on it
+func (t *Topology) Report(iface string) (TopologyReport, bool) {
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	it, ok := t.interfaces[iface]
+	if !ok {
+		return TopologyReport{}, false
+	}
+
+	return it.report(iface, now), true
+}
+
+// Reports returns the switch ports of every interface an advertisement was
+// received on, by name of interface
+func (t *Topology) Reports() []TopologyReport {
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reports := make([]TopologyReport, 0, len(t.interfaces))
+	for iface, it := range t.interfaces {
+		reports = append(reports, it.report(iface, now))
+	}
+
+	slices.SortFunc(reports, func(a, b TopologyReport) int {
+		return strings.Compare(a.Interface, b.Interface)
+	})
+
+	return reports
+}
+
+// report merges the advertisements of the interface as documented by
+// Topology
+func (t *interfaceTopology) report(iface string, now time.Time) TopologyReport {
+	primary, protocol, secondary, other := t.precedence(now)
+
+	r := TopologyReport{
+		Interface:         iface,
+		Upstream:          primary.port,
+		Sources:           []string{protocol},
+		LastAdvertisement: primary.time.Unix(),
+		Stale:             !primary.fresh(now),
+	}
+
+	if secondary != nil && (secondary.fresh(now) || r.Stale) {
+		if !sameSwitch(primary.port.SystemName, secondary.port.SystemName) {
+			r.Conflicting = true
+		} else {
+			r.Upstream = mergePorts(primary.port, secondary.port)
+			r.Sources = append(r.Sources, other)
+
+			if secondary.time.After(primary.time) {
+				r.LastAdvertisement = secondary.time.Unix()
+			}
+		}
+	}
+
+	r.Age = now.Unix() - r.LastAdvertisement
+
+	return r
+}
+
+// mergePorts returns p with the fields it lacks taken from o
+func mergePorts(p, o UpstreamPort) UpstreamPort {
+	if p.SystemName == "" {
+		p.SystemName = o.SystemName
+	}
+
+	if p.PortDescription == "" {
+		p.PortDescription = o.PortDescription
+	}
+
+	if p.NativeVLAN == 0 {
+		p.NativeVLAN = o.NativeVLAN
+	}
+
+	if p.Aggregation == nil {
+		p.Aggregation = o.Aggregation
+	}
+
+	return p
+}
+
+// sameSwitch returns false when the system names a and b name different
+// switches, by their host name: a CDP device ID may be qualified with the
+// domain, or followed by the serial number in parentheses
+func sameSwitch(a, b string) bool {
+	host := func(name string) string {
+		if i := strings.IndexAny(name, ".("); i >= 0 {
+			name = name[:i]
+		}
+
+		return strings.ToLower(name)
+	}
+
+	return a == "" || b == "" || host(a) == host(b)
+}
+
+// isTopologyType returns true for the ethertype of LLDP, and for the 802.3
+// frames CDP is sent in
+func isTopologyType(t ethernet.EthernetType) bool {
+	return t == ethernet.EthernetTypeLLDP || t < ethernet.NonStdLenEthernetTypes
+}
+
+// topologyFilter prepends to base the instructions accepting the LLDP
+// frames, tagged or not, and the CDP ones, by their destination
+func topologyFilter(base []bpf.RawInstruction) ([]bpf.RawInstruction, error) {
+	prefix, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeVLAN), SkipFalse: 1},
+		// the ethertype follows the tag
+		bpf.LoadAbsolute{Off: 16, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeLLDP), SkipTrue: 4},
+		bpf.LoadAbsolute{Off: 0, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x01000ccc, SkipFalse: 3},
+		bpf.LoadAbsolute{Off: 4, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0xcccc, SkipFalse: 1},
+		bpf.RetConstant{Val: topologySnapLen},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(prefix, base...), nil
+}
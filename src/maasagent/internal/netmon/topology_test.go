@@ -0,0 +1,309 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/cdp"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+var testSwitchMAC = net.HardwareAddr{0x00, 0x1c, 0x73, 0xaa, 0xbb, 0x01}
+
+// lldpTLV encodes an LLDP TLV, its value must fit in the 9 bits of the
+// length
+func lldpTLV(typ uint16, value ...byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, typ<<9|uint16(len(value))), value...) //nolint:gosec // test TLVs are small
+}
+
+// lldpAdvertisement returns the LLDP frame of the switch advertising port
+// for ttl seconds, with the port VLAN when vlan isn't 0
+func lldpAdvertisement(system, port string, ttl, vlan uint16) []byte {
+	frame := append([]byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}, testSwitchMAC...)
+	frame = append(frame, 0x88, 0xcc)
+	frame = append(frame, lldpTLV(1, append([]byte{4}, testSwitchMAC...)...)...)
+	frame = append(frame, lldpTLV(2, append([]byte{5}, port...)...)...)
+	frame = append(frame, lldpTLV(3, byte(ttl>>8), byte(ttl))...)
+	frame = append(frame, lldpTLV(5, []byte(system)...)...)
+
+	if vlan != 0 {
+		frame = append(frame, lldpTLV(127, 0x00, 0x80, 0xc2, 0x01, byte(vlan>>8), byte(vlan))...)
+	}
+
+	return append(frame, lldpTLV(0)...)
+}
+
+// cdpTLV encodes a CDP TLV
+func cdpTLV(typ cdp.TLVType, value ...byte) []byte {
+	tlv := binary.BigEndian.AppendUint16(nil, uint16(typ))
+	tlv = binary.BigEndian.AppendUint16(tlv, uint16(4+len(value))) //nolint:gosec // test TLVs are small
+
+	return append(tlv, value...)
+}
+
+// cdpAdvertisement returns the CDP frame of the switch advertising port for
+// ttl seconds, with the native VLAN when vlan isn't 0
+func cdpAdvertisement(device, port string, ttl uint8, vlan uint16) []byte {
+	pkt := []byte{0x02, ttl, 0x00, 0x00}
+	pkt = append(pkt, cdpTLV(cdp.TLVDeviceID, []byte(device)...)...)
+	pkt = append(pkt, cdpTLV(cdp.TLVPortID, []byte(port)...)...)
+
+	if vlan != 0 {
+		pkt = append(pkt, cdpTLV(cdp.TLVNativeVLAN, byte(vlan>>8), byte(vlan))...)
+	}
+
+	frame := append(append([]byte{}, cdp.Multicast...), testSwitchMAC...)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(8+len(pkt))) //nolint:gosec // test packets are small
+	frame = append(frame, 0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00)
+
+	return append(frame, pkt...)
+}
+
+func TestTopologyReport(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	topo := NewTopology(WithTopologyClock(clk))
+
+	_, ok := topo.Report("eth0")
+	assert.False(t, ok)
+
+	assert.Empty(t, topo.Observe("eth0", lldpAdvertisement("sw1", "Ethernet1/12", 120, 0),
+		ethernet.ParserOptions{}, capture.Metadata{}))
+	assert.Empty(t, topo.Observe("eth0", []byte{12: 0x08, 13: 0x06, 41: 0},
+		ethernet.ParserOptions{}, capture.Metadata{}))
+
+	clk.Advance(30 * time.Second)
+
+	report, ok := topo.Report("eth0")
+	require.True(t, ok)
+	assert.Equal(t, TopologyReport{
+		Interface: "eth0",
+		Upstream: UpstreamPort{
+			ChassisID:  testSwitchMAC.String(),
+			SystemName: "sw1",
+			PortID:     "Ethernet1/12",
+		},
+		Sources:           []string{TopologyLLDP},
+		LastAdvertisement: 1700000000,
+		Age:               30,
+	}, report)
+
+	// the port is kept once the TTL expired, marked stale
+	clk.Advance(90 * time.Second)
+
+	report, ok = topo.Report("eth0")
+	require.True(t, ok)
+	assert.True(t, report.Stale)
+	assert.Equal(t, int64(120), report.Age)
+	assert.Equal(t, "Ethernet1/12", report.Upstream.PortID)
+}
+
+func TestTopologyMerge(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		cdp     []byte
+		advance time.Duration
+		out     TopologyReport
+	}{
+		"same switch": {
+			cdp: cdpAdvertisement("SW1.example.com", "GigabitEthernet1/0/12", 180, 100),
+			out: TopologyReport{
+				Upstream: UpstreamPort{
+					ChassisID:  testSwitchMAC.String(),
+					SystemName: "sw1",
+					PortID:     "Ethernet1/12",
+					NativeVLAN: 100,
+				},
+				Sources:           []string{TopologyLLDP, TopologyCDP},
+				LastAdvertisement: 1700000000,
+			},
+		},
+		"different switches": {
+			cdp: cdpAdvertisement("sw2(FOC1234X0AB)", "GigabitEthernet1/0/12", 180, 100),
+			out: TopologyReport{
+				Upstream: UpstreamPort{
+					ChassisID:  testSwitchMAC.String(),
+					SystemName: "sw1",
+					PortID:     "Ethernet1/12",
+				},
+				Sources:           []string{TopologyLLDP},
+				Conflicting:       true,
+				LastAdvertisement: 1700000000,
+			},
+		},
+		"stale LLDP": {
+			cdp:     cdpAdvertisement("sw1", "GigabitEthernet1/0/12", 180, 100),
+			advance: 2 * time.Minute,
+			out: TopologyReport{
+				Upstream: UpstreamPort{
+					ChassisID:  "sw1",
+					SystemName: "sw1",
+					PortID:     "GigabitEthernet1/0/12",
+					NativeVLAN: 100,
+				},
+				Sources:           []string{TopologyCDP},
+				LastAdvertisement: 1700000120,
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clk := clocktest.NewFake(time.Unix(1700000000, 0))
+			topo := NewTopology(WithTopologyClock(clk))
+
+			topo.Observe("eth0", lldpAdvertisement("sw1", "Ethernet1/12", 120, 0), ethernet.ParserOptions{},
+				capture.Metadata{})
+			clk.Advance(tc.advance)
+			topo.Observe("eth0", tc.cdp, ethernet.ParserOptions{}, capture.Metadata{})
+
+			tc.out.Interface = "eth0"
+
+			reports := topo.Reports()
+			require.Len(t, reports, 1)
+			assert.Equal(t, tc.out, reports[0])
+		})
+	}
+}
+
+func TestTopologyUpstreamPortChanged(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	topo := NewTopology(WithTopologyClock(clk))
+	md := capture.Metadata{}
+
+	topo.Observe("eth0", lldpAdvertisement("sw1", "Ethernet1/12", 120, 0), ethernet.ParserOptions{}, md)
+	topo.Observe("eth0", cdpAdvertisement("sw1", "GigabitEthernet1/0/12", 180, 0), ethernet.ParserOptions{}, md)
+
+	// a refresh of the same port changes nothing
+	clk.Advance(30 * time.Second)
+	assert.Empty(t, topo.Observe("eth0", lldpAdvertisement("sw1", "Ethernet1/12", 120, 0),
+		ethernet.ParserOptions{}, md))
+
+	// CDP doesn't take precedence over a fresh LLDP
+	assert.Empty(t, topo.Observe("eth0", cdpAdvertisement("sw1", "GigabitEthernet1/0/13", 180, 0),
+		ethernet.ParserOptions{}, md))
+
+	res := topo.Observe("eth0", lldpAdvertisement("sw1", "Ethernet1/13", 120, 0), ethernet.ParserOptions{}, md)
+	require.Len(t, res, 1)
+	assert.Equal(t, EventUpstreamPortChanged, res[0].Event)
+	assert.Equal(t, testSwitchMAC.String(), res[0].MAC)
+	assert.Equal(t, int64(1700000030), res[0].Time)
+	require.NotNil(t, res[0].Upstream)
+	assert.Equal(t, TopologyLLDP, res[0].Upstream.Protocol)
+	assert.Equal(t, "Ethernet1/12", res[0].Upstream.Previous.PortID)
+	assert.Equal(t, "Ethernet1/13", res[0].Upstream.Current.PortID)
+
+	// the interfaces are tracked apart
+	assert.Empty(t, topo.Observe("eth1", lldpAdvertisement("sw1", "Ethernet1/14", 120, 0),
+		ethernet.ParserOptions{}, md))
+	assert.Len(t, topo.Reports(), 2)
+}
+
+func TestTopologyFilter(t *testing.T) {
+	t.Parallel()
+
+	base, err := arpFilter()
+	require.NoError(t, err)
+
+	filter, err := topologyFilter(base)
+	require.NoError(t, err)
+
+	vm, err := bpf.NewVM(disassemble(t, filter))
+	require.NoError(t, err)
+
+	frame := lldpAdvertisement("sw1", "Ethernet1/12", 120, 0)
+	tagged := append(append(append([]byte{}, frame[:12]...), 0x81, 0x00, 0x00, 0x0a), frame[12:]...)
+
+	testcases := map[string]struct {
+		in  []byte
+		len int
+	}{
+		"LLDP": {
+			in:  frame,
+			len: topologySnapLen,
+		},
+		"802.1Q LLDP": {
+			in:  tagged,
+			len: topologySnapLen,
+		},
+		"CDP": {
+			in:  cdpAdvertisement("sw1", "GigabitEthernet1/0/12", 180, 0),
+			len: topologySnapLen,
+		},
+		"ARP": {
+			in:  []byte{12: 0x08, 13: 0x06, 41: 0},
+			len: snapLen,
+		},
+		"802.3": {
+			in: append([]byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x00, 11: 0, 12: 0x00, 13: 0x26}, make([]byte, 38)...),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			n, err := vm.Run(tc.in)
+			require.NoError(t, err)
+			assert.Equal(t, tc.len, n)
+		})
+	}
+}
+
+func TestServiceTopology(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	topo := NewTopology(WithTopologyClock(clk))
+	svc := NewService("eth0", WithClock(clk), WithTopology(topo), WithDecoders(DefaultDecoders|DecoderLLDP|DecoderCDP))
+
+	// the advertisements of the host itself are ignored
+	_, err := svc.handleFrame(lldpAdvertisement("host", "eth0", 120, 0),
+		capture.Metadata{Direction: capture.DirectionOutbound})
+	require.NoError(t, err)
+
+	_, ok := topo.Report("eth0")
+	assert.False(t, ok)
+
+	res, err := svc.handleFrame(cdpAdvertisement("sw1", "GigabitEthernet1/0/12", 180, 0), capture.Metadata{})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	report, ok := topo.Report("eth0")
+	require.True(t, ok)
+	assert.Equal(t, "GigabitEthernet1/0/12", report.Upstream.PortID)
+	assert.Equal(t, []string{TopologyCDP}, report.Sources)
+}
@@ -0,0 +1,386 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+// defaultTransmitDepth bounds the frames waiting in a class, a scan of a
+// /22 queued at once
+const defaultTransmitDepth = 1024
+
+var (
+	// ErrTransmitDropped is returned for a frame whose class was full
+	ErrTransmitDropped = errors.New("transmit queue full")
+	// ErrTransmitStopped is returned for the frames waiting when the
+	// TransmitQueue stopped running
+	ErrTransmitStopped = errors.New("transmit queue stopped")
+)
+
+// TransmitClass is the kind of a frame the agent sends, the classes are
+// given the rate budget of an interface in the order of their priority
+type TransmitClass int
+
+const (
+	// TransmitResponse are the replies the agent owes, such as those of a
+	// Responder
+	TransmitResponse TransmitClass = iota
+	// TransmitAnnouncement are the gratuitous ARP and unsolicited NA of the
+	// addresses of the host
+	TransmitAnnouncement
+	// TransmitLLDP are the LLDP frames the agent advertises itself with
+	TransmitLLDP
+	// TransmitScan are the probes, of the scans, the host checks and the
+	// VLAN discovery
+	TransmitScan
+	// TransmitReplay are the frames of other hosts sent again on purpose
+	TransmitReplay
+	transmitClassCount
+)
+
+var transmitClassNames = [transmitClassCount]string{"response", "announcement", "lldp", "scan", "replay"}
+
+func (c TransmitClass) String() string {
+	if c < 0 || c >= transmitClassCount {
+		return fmt.Sprintf("TransmitClass(%d)", int(c))
+	}
+
+	return transmitClassNames[c]
+}
+
+// TransmitStats are the counters of a class of a TransmitQueue
+type TransmitStats struct {
+	// Queued is the number of frames waiting
+	Queued int
+	// Sent is the number of frames written, successfully or not
+	Sent uint64
+	// Dropped is the number of frames refused, the class being full
+	Dropped uint64
+	// Latency is the total time the frames sent waited, and MaxLatency the
+	// longest a frame waited
+	Latency    time.Duration
+	MaxLatency time.Duration
+}
+
+// queuedFrame is a frame waiting for its turn, its sender waits on done
+type queuedFrame struct {
+	w        capture.FrameWriter
+	enqueued time.Time
+	done     chan error
+	frame    []byte
+}
+
+// transmitClass is the queue of the frames of a class
+type transmitClass struct {
+	attrs    metric.MeasurementOption
+	frames   []*queuedFrame
+	stats    TransmitStats
+	depth    int
+	priority int
+}
+
+// TransmitQueue orders the frames the senders of an interface transmit, so
+// that an answer the agent owes doesn't wait behind thousands of probes.
+// Each class queues up to its depth of frames, and the frame sent next is
+// the oldest of the class with the highest priority, the lowest value,
+// with a round robin between the classes of a same priority. With a
+// ProbeLimiter a frame is picked once the limiter lets one through, the
+// budget goes to the frames of the highest priority waiting then.
+//
+// A sender writes through Writer, which blocks until its frame is sent,
+// as a capture.FrameWriter does. The frames wait until Run runs.
+type TransmitQueue struct {
+	clock   clock.Clock
+	limiter *ProbeLimiter
+	latency metric.Float64Histogram
+	wake    chan struct{}
+	iface   string
+	classes [transmitClassCount]transmitClass
+	// last is the class sent last, the round robin starts after it
+	last    TransmitClass
+	stopped bool
+	mu      sync.Mutex
+}
+
+// TransmitQueueOption configures a TransmitQueue
+type TransmitQueueOption func(*TransmitQueue)
+
+// WithTransmitClock sets the clock timing the wait of the frames
+func WithTransmitClock(c clock.Clock) TransmitQueueOption {
+	return func(q *TransmitQueue) {
+		q.clock = c
+	}
+}
+
+// WithTransmitLimiter bounds the rate of the frames of every class with l,
+// which the other senders of the agent may share
+func WithTransmitLimiter(l *ProbeLimiter) TransmitQueueOption {
+	return func(q *TransmitQueue) {
+		q.limiter = l
+	}
+}
+
+// WithTransmitDepth bounds the frames waiting in class to depth
+func WithTransmitDepth(class TransmitClass, depth int) TransmitQueueOption {
+	return func(q *TransmitQueue) {
+		if class >= 0 && class < transmitClassCount && depth > 0 {
+			q.classes[class].depth = depth
+		}
+	}
+}
+
+// WithTransmitPriority sets the priority of class, the lowest is sent
+// first. The priority of a class is its value by default, the classes
+// given the same one share the budget left by those before.
+func WithTransmitPriority(class TransmitClass, priority int) TransmitQueueOption {
+	return func(q *TransmitQueue) {
+		if class >= 0 && class < transmitClassCount {
+			q.classes[class].priority = priority
+		}
+	}
+}
+
+// WithTransmitMeter records the time the frames waited into the
+// netmon.transmit.latency histogram of meter, by interface and class
+func WithTransmitMeter(meter metric.Meter) TransmitQueueOption {
+	return func(q *TransmitQueue) {
+		q.latency = must(meter.Float64Histogram("netmon.transmit.latency",
+			metric.WithDescription("Time a frame waited in the transmit queue of an interface"),
+			metric.WithUnit("s")))
+	}
+}
+
+// NewTransmitQueue returns the TransmitQueue of the interface iface
+func NewTransmitQueue(iface string, options ...TransmitQueueOption) *TransmitQueue {
+	q := &TransmitQueue{
+		clock: clock.System{},
+		wake:  make(chan struct{}, 1),
+		iface: iface,
+		last:  transmitClassCount - 1,
+	}
+
+	for c := range transmitClassCount {
+		q.classes[c] = transmitClass{
+			attrs: metric.WithAttributeSet(attribute.NewSet(attribute.String("interface", iface),
+				attribute.String("class", c.String()))),
+			depth:    defaultTransmitDepth,
+			priority: int(c),
+		}
+	}
+
+	for _, opt := range options {
+		opt(q)
+	}
+
+	return q
+}
+
+// Writer returns the capture.FrameWriter queueing the frames of class
+// before writing them to w
+func (q *TransmitQueue) Writer(class TransmitClass, w capture.FrameWriter) capture.FrameWriter {
+	return &queuedWriter{queue: q, w: w, class: class}
+}
+
+// queuedWriter is a Writer of a TransmitQueue
+type queuedWriter struct {
+	queue *TransmitQueue
+	w     capture.FrameWriter
+	class TransmitClass
+}
+
+// WriteFrame queues frame and waits until it is written, it returns an
+// error matching ErrTransmitDropped when the class is full
+func (w *queuedWriter) WriteFrame(frame []byte) error {
+	return w.queue.send(w.class, w.w, frame)
+}
+
+// send queues frame in class and waits for it to be written to w
+func (q *TransmitQueue) send(class TransmitClass, w capture.FrameWriter, frame []byte) error {
+	if class < 0 || class >= transmitClassCount {
+		return fmt.Errorf("unknown transmit class %d", int(class))
+	}
+
+	f := &queuedFrame{w: w, frame: frame, enqueued: q.clock.Now(), done: make(chan error, 1)}
+
+	q.mu.Lock()
+
+	c := &q.classes[class]
+
+	switch {
+	case q.stopped:
+		q.mu.Unlock()
+		return fmt.Errorf("%w on %s", ErrTransmitStopped, q.iface)
+	case len(c.frames) >= c.depth:
+		c.stats.Dropped++
+		q.mu.Unlock()
+
+		return fmt.Errorf("%w: %s on %s", ErrTransmitDropped, class, q.iface)
+	}
+
+	c.frames = append(c.frames, f)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return <-f.done
+}
+
+// next takes the frame sent next, nil when none waits
+func (q *TransmitQueue) next() (*queuedFrame, TransmitClass) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	best := -1
+
+	for i := range transmitClassCount {
+		// the round robin starts after the class sent last
+		c := (q.last + 1 + i) % transmitClassCount
+		if len(q.classes[c].frames) == 0 {
+			continue
+		}
+
+		if best < 0 || q.classes[c].priority < q.classes[best].priority {
+			best = int(c)
+		}
+	}
+
+	if best < 0 {
+		return nil, 0
+	}
+
+	c := &q.classes[best]
+	f := c.frames[0]
+	c.frames[0] = nil
+	c.frames = c.frames[1:]
+	q.last = TransmitClass(best)
+
+	return f, q.last
+}
+
+// pending returns true if a frame waits
+func (q *TransmitQueue) pending() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for c := range transmitClassCount {
+		if len(q.classes[c].frames) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sent records a frame of class written after waiting for wait
+func (q *TransmitQueue) sent(class TransmitClass, wait time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	st := &q.classes[class].stats
+	st.Sent++
+	st.Latency += wait
+	st.MaxLatency = max(st.MaxLatency, wait)
+}
+
+// Run writes the frames queued until ctx is done, the frames still waiting
+// then are refused with ErrTransmitStopped, as are those queued until Run
+// runs again
+func (q *TransmitQueue) Run(ctx context.Context) error {
+	q.mu.Lock()
+	q.stopped = false
+	q.mu.Unlock()
+
+	defer q.stop()
+
+	for {
+		if !q.pending() {
+			select {
+			case <-q.wake:
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		if q.limiter != nil {
+			if err := q.limiter.Wait(ctx); err != nil {
+				return nil //nolint:nilerr // the queue stops with ctx
+			}
+		}
+
+		f, class := q.next()
+		if f == nil {
+			continue
+		}
+
+		err := f.w.WriteFrame(f.frame)
+		wait := q.clock.Now().Sub(f.enqueued)
+
+		q.sent(class, wait)
+
+		if q.latency != nil {
+			q.latency.Record(ctx, wait.Seconds(), q.classes[class].attrs)
+		}
+
+		f.done <- err
+	}
+}
+
+// stop refuses the frames waiting, and those queued until Run runs again
+func (q *TransmitQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.stopped = true
+
+	for c := range transmitClassCount {
+		for _, f := range q.classes[c].frames {
+			f.done <- fmt.Errorf("%w on %s", ErrTransmitStopped, q.iface)
+		}
+
+		q.classes[c].frames = nil
+	}
+}
+
+// Stats returns the counters of every class, by its name
+func (q *TransmitQueue) Stats() map[string]TransmitStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := make(map[string]TransmitStats, transmitClassCount)
+
+	for c := range transmitClassCount {
+		st := q.classes[c].stats
+		st.Queued = len(q.classes[c].frames)
+		stats[c.String()] = st
+	}
+
+	return stats
+}
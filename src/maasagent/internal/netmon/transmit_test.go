@@ -0,0 +1,281 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+// recordingWriter records the frames written, in order
+type recordingWriter struct {
+	frames []string
+	mu     sync.Mutex
+}
+
+func (w *recordingWriter) WriteFrame(frame []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.frames = append(w.frames, string(frame))
+
+	return nil
+}
+
+func (w *recordingWriter) written() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]string(nil), w.frames...)
+}
+
+// queueFrames writes the frames from a goroutine each, once all are
+// queued in q, and returns the errors of their writers
+func queueFrames(t *testing.T, q *TransmitQueue, w *recordingWriter, class TransmitClass,
+	frames ...string) <-chan error {
+	t.Helper()
+
+	errC := make(chan error, len(frames))
+	queued := q.Stats()[class.String()].Queued
+
+	for _, f := range frames {
+		go func() { errC <- q.Writer(class, w).WriteFrame([]byte(f)) }()
+	}
+
+	require.Eventually(t, func() bool {
+		return q.Stats()[class.String()].Queued == queued+len(frames)
+	}, time.Second, time.Millisecond)
+
+	return errC
+}
+
+func runQueue(t *testing.T, q *TransmitQueue) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		assert.NoError(t, q.Run(ctx))
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestTransmitQueuePriority(t *testing.T) {
+	t.Parallel()
+
+	q := NewTransmitQueue("eth0")
+	w := &recordingWriter{}
+
+	// the frames queued before Run are sent by priority, each class in order
+	scans := queueFrames(t, q, w, TransmitScan, "scan")
+	replays := queueFrames(t, q, w, TransmitReplay, "replay")
+	responses := queueFrames(t, q, w, TransmitResponse, "response")
+	announcements := queueFrames(t, q, w, TransmitAnnouncement, "announcement")
+
+	stop := runQueue(t, q)
+	defer stop()
+
+	for _, errC := range []<-chan error{scans, replays, responses, announcements} {
+		require.NoError(t, <-errC)
+	}
+
+	assert.Equal(t, []string{"response", "announcement", "scan", "replay"}, w.written())
+}
+
+func TestTransmitQueueFairness(t *testing.T) {
+	t.Parallel()
+
+	q := NewTransmitQueue("eth0", WithTransmitPriority(TransmitReplay, int(TransmitScan)))
+	w := &recordingWriter{}
+
+	var errCs []<-chan error
+
+	for i := range 3 {
+		errCs = append(errCs, queueFrames(t, q, w, TransmitScan, fmt.Sprintf("scan%d", i)))
+	}
+
+	for i := range 3 {
+		errCs = append(errCs, queueFrames(t, q, w, TransmitReplay, fmt.Sprintf("replay%d", i)))
+	}
+
+	stop := runQueue(t, q)
+	defer stop()
+
+	for _, errC := range errCs {
+		require.NoError(t, <-errC)
+	}
+
+	// the classes of a same priority take turns
+	assert.Equal(t, []string{"scan0", "replay0", "scan1", "replay1", "scan2", "replay2"}, w.written())
+}
+
+func TestTransmitQueueDepth(t *testing.T) {
+	t.Parallel()
+
+	q := NewTransmitQueue("eth0", WithTransmitDepth(TransmitScan, 2))
+	w := &recordingWriter{}
+
+	errC := queueFrames(t, q, w, TransmitScan, "scan0", "scan1")
+
+	err := q.Writer(TransmitScan, w).WriteFrame([]byte("scan2"))
+	require.ErrorIs(t, err, ErrTransmitDropped)
+
+	// the other classes have their own room
+	responses := queueFrames(t, q, w, TransmitResponse, "response")
+
+	stop := runQueue(t, q)
+
+	require.NoError(t, <-responses)
+	require.NoError(t, <-errC)
+	require.NoError(t, <-errC)
+
+	stop()
+
+	stats := q.Stats()
+	assert.Equal(t, uint64(2), stats["scan"].Sent)
+	assert.Equal(t, uint64(1), stats["scan"].Dropped)
+	assert.Equal(t, uint64(1), stats["response"].Sent)
+
+	// a stopped queue refuses the frames until it runs again
+	err = q.Writer(TransmitScan, w).WriteFrame([]byte("scan3"))
+	assert.ErrorIs(t, err, ErrTransmitStopped)
+}
+
+func TestTransmitQueueStop(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	limiter := NewProbeLimiter(1, 1, WithProbeLimiterClock(clk))
+	q := NewTransmitQueue("eth0", WithTransmitClock(clk), WithTransmitLimiter(limiter))
+	w := &recordingWriter{}
+
+	errC := queueFrames(t, q, w, TransmitScan, "scan", "scan")
+	stop := runQueue(t, q)
+
+	// the first frame goes, the second waits for the limiter
+	require.NoError(t, <-errC)
+	clk.BlockUntil(1)
+	stop()
+
+	require.ErrorIs(t, <-errC, ErrTransmitStopped)
+	assert.Equal(t, []string{"scan"}, w.written())
+}
+
+// TestTransmitQueueResponderLatency saturates the budget of the limiter with
+// scans: a response queued behind them is sent within an interval of the
+// limiter, whatever the scans waiting.
+func TestTransmitQueueResponderLatency(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rate  = 100
+		scans = 64
+	)
+
+	interval := time.Second / rate
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	limiter := NewProbeLimiter(rate, 1, WithProbeLimiterClock(clk))
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	q := NewTransmitQueue("eth0", WithTransmitClock(clk), WithTransmitLimiter(limiter),
+		WithTransmitMeter(provider.Meter("test")))
+	w := &recordingWriter{}
+
+	frames := make([]string, scans)
+	for i := range frames {
+		frames[i] = fmt.Sprintf("scan%d", i)
+	}
+
+	scanErrC := queueFrames(t, q, w, TransmitScan, frames...)
+
+	stop := runQueue(t, q)
+	defer stop()
+
+	// the first scan goes at once, the next waits for the limiter
+	require.NoError(t, <-scanErrC)
+
+	for range 4 {
+		clk.BlockUntil(1)
+		clk.Advance(interval)
+		require.NoError(t, <-scanErrC)
+	}
+
+	clk.BlockUntil(1)
+
+	respErrC := queueFrames(t, q, w, TransmitResponse, "response")
+
+	clk.Advance(interval)
+	require.NoError(t, <-respErrC)
+
+	written := w.written()
+	assert.Equal(t, "response", written[len(written)-1], "the response is the next frame sent")
+
+	stats := q.Stats()
+	assert.LessOrEqual(t, stats["response"].MaxLatency, interval)
+	assert.Greater(t, stats["scan"].Queued, scans/2, "the scans still saturate the limiter")
+	assert.Greater(t, stats["scan"].MaxLatency, stats["response"].MaxLatency)
+
+	var rm metricdata.ResourceMetrics
+
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	hist, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[float64])
+	require.True(t, ok)
+
+	counts := make(map[string]uint64)
+
+	for _, dp := range hist.DataPoints {
+		class, _ := dp.Attributes.Value("class")
+		counts[class.AsString()] = dp.Count
+	}
+
+	assert.Equal(t, map[string]uint64{"scan": 5, "response": 1}, counts)
+}
+
+func TestServiceTransmitQueue(t *testing.T) {
+	t.Parallel()
+
+	q := NewTransmitQueue("eth0")
+
+	// the frames of the Service go through its guard, then the queue
+	w, ok := NewService("eth0", WithTransmitQueue(q)).writer(TransmitAnnouncement, nil).(*queuedWriter)
+	require.True(t, ok)
+	assert.Equal(t, TransmitAnnouncement, w.class)
+	assert.IsType(t, &capture.GuardedWriter{}, w.w)
+
+	assert.IsType(t, &capture.GuardedWriter{}, NewService("eth0").writer(TransmitScan, nil))
+}
@@ -0,0 +1,405 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/ndp"
+)
+
+const (
+	// defaultVLANWindow is how long the traffic of a VLAN counts as a sign
+	// of life, and how long the replies to the probes are waited for
+	defaultVLANWindow = 3 * time.Second
+	// defaultProbeRate and defaultProbeBurst are those of the ProbeLimiter
+	// of a VLANDiscovery created without one
+	defaultProbeRate  = 100
+	defaultProbeBurst = 10
+	maxVID            = 4094
+
+	// VLANEvidencePassive is the evidence of a VLAN carrying traffic the
+	// probes didn't elicit
+	VLANEvidencePassive = "passive"
+	// VLANEvidenceProbeReply is the evidence of a VLAN where a host
+	// answered a probe
+	VLANEvidenceProbeReply = "probe_reply"
+)
+
+var (
+	// ErrInvalidVLANRange is returned for a range of candidate VLANs
+	// outside of 1-4094 or ending before it starts
+	ErrInvalidVLANRange = errors.New("invalid VLAN range")
+	// ErrNotCapturing is returned when the Service has no capture to
+	// transmit on
+	ErrNotCapturing = errors.New("service not capturing")
+	// ErrVLANDiscoveryDisabled is returned by Service.DiscoverVLANs for a
+	// Service without a VLANDiscovery
+	ErrVLANDiscoveryDisabled = errors.New("VLAN discovery not enabled")
+	// ErrPortAttributionDisabled is returned by Service.CaptureMember for a
+	// Service without a PortAttributor
+	ErrPortAttributionDisabled = errors.New("port attribution not enabled")
+)
+
+// LiveVLAN is a VLAN found carried by an interface
+type LiveVLAN struct {
+	Interface string `json:"interface"`
+	// Evidence is VLANEvidenceProbeReply if a host answered a probe on
+	// the VLAN, VLANEvidencePassive otherwise
+	Evidence string `json:"evidence"`
+	// Frames is the number of frames seen on the VLAN since the previous
+	// run
+	Frames   uint64 `json:"frames"`
+	LastSeen int64  `json:"last_seen"`
+	VID      uint16 `json:"vid"`
+	// Probed is true when the VLAN was probed, it wasn't if its traffic
+	// was seen before the run
+	Probed bool `json:"probed"`
+}
+
+type vlanSighting struct {
+	last   time.Time
+	reply  time.Time
+	probed time.Time
+	frames uint64
+}
+
+// VLANDiscovery finds which of a range of candidate VLANs are carried by
+// the port of an interface. The traffic of the VLANs is observed passively
+// and, during a run, the VLANs without recent traffic are probed with an
+// RFC 5227 ARP probe and a duplicate address detection solicitation for an
+// address of the host's own: they claim nothing, and anything answering
+// them or seen on the VLAN afterwards proves it live.
+type VLANDiscovery struct {
+	probe4  netip.Addr
+	probe6  netip.Addr
+	clock   clock.Clock
+	limiter *ProbeLimiter
+	seen    map[uint16]*vlanSighting
+	src     net.HardwareAddr
+	window  time.Duration
+	mu      sync.Mutex
+	// runMu serializes the runs
+	runMu sync.Mutex
+	first uint16
+	last  uint16
+}
+
+// VLANDiscoveryOption configures a VLANDiscovery
+type VLANDiscoveryOption func(*VLANDiscovery)
+
+// WithVLANWindow sets how long the traffic of a VLAN counts as a sign of
+// life, and how long a run waits for the replies after the last probe
+func WithVLANWindow(d time.Duration) VLANDiscoveryOption {
+	return func(v *VLANDiscovery) {
+		if d > 0 {
+			v.window = d
+		}
+	}
+}
+
+// WithProbeLimiter sets the limiter the probes wait for, so the probing of
+// several interfaces is bounded together
+func WithProbeLimiter(l *ProbeLimiter) VLANDiscoveryOption {
+	return func(v *VLANDiscovery) {
+		v.limiter = l
+	}
+}
+
+// WithProbeAddrs sets the addresses probed for, an invalid one isn't probed.
+// They default to an IPv4 link-local address and the IPv6 link-local
+// address derived from the MAC.
+func WithProbeAddrs(v4, v6 netip.Addr) VLANDiscoveryOption {
+	return func(v *VLANDiscovery) {
+		v.probe4, v.probe6 = netip.Addr{}, netip.Addr{}
+
+		if v4.Is4() {
+			v.probe4 = v4
+		}
+
+		if v6.Is6() && !v6.Is4In6() {
+			v.probe6 = v6
+		}
+	}
+}
+
+// WithVLANDiscoveryClock sets the clock timing the runs and timestamping
+// the frames observed without a timestamp
+func WithVLANDiscoveryClock(c clock.Clock) VLANDiscoveryOption {
+	return func(v *VLANDiscovery) {
+		v.clock = c
+	}
+}
+
+// NewVLANDiscovery returns a VLANDiscovery of the VLANs first to last, the
+// probes are sent from src
+func NewVLANDiscovery(src net.HardwareAddr, first, last uint16,
+	options ...VLANDiscoveryOption) (*VLANDiscovery, error) {
+	if first < 1 || last > maxVID || last < first {
+		return nil, fmt.Errorf("%w: %d-%d", ErrInvalidVLANRange, first, last)
+	}
+
+	if len(src) != 6 {
+		return nil, fmt.Errorf("%w: source MAC %q", ethernet.ErrBuildFrame, src)
+	}
+
+	v := &VLANDiscovery{
+		clock:  clock.System{},
+		seen:   make(map[uint16]*vlanSighting),
+		src:    slices.Clone(src),
+		probe4: linkLocal4(src),
+		probe6: linkLocal6(src),
+		window: defaultVLANWindow,
+		first:  first,
+		last:   last,
+	}
+
+	for _, opt := range options {
+		opt(v)
+	}
+
+	if v.limiter == nil {
+		v.limiter = NewProbeLimiter(defaultProbeRate, defaultProbeBurst, WithProbeLimiterClock(v.clock))
+	}
+
+	return v, nil
+}
+
+// linkLocal4 returns an address of 169.254.1.0 to 169.254.254.255, the range
+// RFC 3927 hosts pick from, derived from mac
+func linkLocal4(mac net.HardwareAddr) netip.Addr {
+	return netip.AddrFrom4([4]byte{169, 254, 1 + mac[4]%254, mac[5]})
+}
+
+// linkLocal6 returns the modified EUI-64 link-local address of mac
+func linkLocal6(mac net.HardwareAddr) netip.Addr {
+	return netip.AddrFrom16([16]byte{
+		0: 0xfe, 1: 0x80,
+		8: mac[0] ^ 0x02, 9: mac[1], 10: mac[2], 11: 0xff, 12: 0xfe, 13: mac[3], 14: mac[4], 15: mac[5],
+	})
+}
+
+// Observe records a frame received on vid, those of the VLANs outside of
+// the range are ignored
+func (v *VLANDiscovery) Observe(frame []byte, vid uint16, timestamp time.Time) {
+	if vid < v.first || vid > v.last {
+		return
+	}
+
+	if timestamp.IsZero() {
+		timestamp = v.clock.Now()
+	}
+
+	reply := v.isReply(frame)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.seen[vid]
+	if !ok {
+		s = &vlanSighting{}
+		v.seen[vid] = s
+	}
+
+	s.last = timestamp
+	s.frames++
+
+	if reply {
+		s.reply = timestamp
+	}
+}
+
+// isReply returns true for the frames about the addresses probed for, an
+// ARP packet from the IPv4 one or a neighbor advertisement of the IPv6 one
+func (v *VLANDiscovery) isReply(frame []byte) bool {
+	var eth ethernet.EthernetFrame
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return false
+	}
+
+	if arp, err := eth.ExtractARPPacket(); err == nil {
+		return v.probe4.IsValid() && arp.SendIPAddr == v.probe4
+	}
+
+	msg, _, err := ndp.ParseFrame(frame)
+
+	return err == nil && v.probe6.IsValid() && msg.Type == ndp.TypeNeighborAdvertisement && msg.Target == v.probe6
+}
+
+// Run probes the VLANs of the range without traffic in the last window,
+// as fast as the ProbeLimiter allows, waits for the replies and returns
+// the VLANs seen since the window before the run, in order. A run stopped
+// by ctx returns its error.
+func (v *VLANDiscovery) Run(ctx context.Context, w capture.FrameWriter) ([]LiveVLAN, error) {
+	v.runMu.Lock()
+	defer v.runMu.Unlock()
+
+	since := v.clock.Now().Add(-v.window)
+
+	for vid := v.first; vid <= v.last; vid++ {
+		if v.liveSince(vid, since) {
+			continue
+		}
+
+		frames, err := v.probes(vid)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, frame := range frames {
+			if err := v.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+
+			if err := w.WriteFrame(frame); err != nil {
+				return nil, err
+			}
+		}
+
+		v.markProbed(vid)
+	}
+
+	if err := v.clock.Sleep(ctx, v.window); err != nil {
+		return nil, err
+	}
+
+	return v.live(since), nil
+}
+
+// probes returns the frames probing vid
+func (v *VLANDiscovery) probes(vid uint16) ([][]byte, error) {
+	var frames [][]byte
+
+	if v.probe4.IsValid() {
+		frame, err := ethernet.NewFrame().Src(v.src).VLAN(vid).Padded().
+			ARPRequest(netip.IPv4Unspecified(), v.probe4).Build()
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, frame)
+	}
+
+	if v.probe6.IsValid() {
+		frame, err := ethernet.NewFrame().Src(v.src).VLAN(vid).
+			NeighborSolicitation(netip.IPv6Unspecified(), v.probe6).Build()
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames, nil
+}
+
+func (v *VLANDiscovery) liveSince(vid uint16, since time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.seen[vid]
+
+	return ok && !s.last.Before(since)
+}
+
+func (v *VLANDiscovery) markProbed(vid uint16) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.seen[vid]
+	if !ok {
+		s = &vlanSighting{}
+		v.seen[vid] = s
+	}
+
+	s.probed = v.clock.Now()
+}
+
+// live returns the VLANs seen since, and forgets those seen before
+func (v *VLANDiscovery) live(since time.Time) []LiveVLAN {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var vlans []LiveVLAN
+
+	for vid, s := range v.seen {
+		if s.last.Before(since) {
+			if s.probed.Before(since) {
+				delete(v.seen, vid)
+			}
+
+			continue
+		}
+
+		lv := LiveVLAN{
+			VID:      vid,
+			Evidence: VLANEvidencePassive,
+			Frames:   s.frames,
+			LastSeen: s.last.Unix(),
+			Probed:   !s.probed.Before(since),
+		}
+
+		if lv.Probed && !s.reply.Before(s.probed) {
+			lv.Evidence = VLANEvidenceProbeReply
+		}
+
+		vlans = append(vlans, lv)
+		s.frames = 0
+	}
+
+	slices.SortFunc(vlans, func(a, b LiveVLAN) int {
+		return int(a.VID) - int(b.VID)
+	})
+
+	return vlans
+}
+
+// vlanFilter prepends to base the instructions accepting the tagged frames,
+// but for those of the deferred types which are left to base, for the
+// VLANDiscovery to see all of the traffic of the VLANs
+func vlanFilter(base []bpf.RawInstruction, deferred ...ethernet.EthernetType) ([]bpf.RawInstruction, error) {
+	n := uint8(len(deferred)) //nolint:gosec // a handful of ethernet types
+
+	insns := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ethernet.EthernetTypeVLAN), SkipFalse: n + 2},
+		bpf.LoadAbsolute{Off: 16, Size: 2},
+	}
+
+	for i, t := range deferred {
+		insns = append(insns, bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(t), SkipTrue: n - uint8(i)}) //nolint:gosec // i < n
+	}
+
+	prefix, err := bpf.Assemble(append(insns, bpf.RetConstant{Val: uint32(snapLen)}))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(prefix, base...), nil
+}
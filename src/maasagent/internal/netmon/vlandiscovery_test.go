@@ -0,0 +1,279 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/ndp"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+var (
+	testRackMAC  = net.HardwareAddr{0x00, 0x16, 0x3e, 0x12, 0x34, 0x56}
+	testHostMAC  = net.HardwareAddr{0x00, 0x16, 0x3e, 0xaa, 0x00, 0x01}
+	testProbe4   = netip.MustParseAddr("169.254.53.86")
+	testProbe6   = netip.MustParseAddr("fe80::216:3eff:fe12:3456")
+	testVLANHost = netip.MustParseAddr("10.0.12.5")
+)
+
+// probeWriter records the probes and lets respond act on the VLAN of each
+type probeWriter struct {
+	respond func(vid uint16)
+	frames  [][]byte
+}
+
+func (w *probeWriter) WriteFrame(frame []byte) error {
+	w.frames = append(w.frames, frame)
+	w.respond(binary.BigEndian.Uint16(frame[14:16]) & 0x0fff)
+
+	return nil
+}
+
+func TestNewVLANDiscovery(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		err         error
+		src         net.HardwareAddr
+		first, last uint16
+	}{
+		"valid": {
+			src: testRackMAC, first: 1, last: 4094,
+		},
+		"VLAN 0": {
+			src: testRackMAC, first: 0, last: 10, err: ErrInvalidVLANRange,
+		},
+		"VLAN 4095": {
+			src: testRackMAC, first: 1, last: 4095, err: ErrInvalidVLANRange,
+		},
+		"reversed": {
+			src: testRackMAC, first: 20, last: 10, err: ErrInvalidVLANRange,
+		},
+		"no MAC": {
+			first: 1, last: 10, err: ethernet.ErrBuildFrame,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := NewVLANDiscovery(tc.src, tc.first, tc.last)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testProbe4, d.probe4)
+			assert.Equal(t, testProbe6, d.probe6)
+		})
+	}
+}
+
+func TestVLANDiscoveryRun(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1700000000, 0)
+	clk := clocktest.NewFake(start)
+
+	d, err := NewVLANDiscovery(testRackMAC, 10, 13, WithVLANDiscoveryClock(clk),
+		WithProbeLimiter(NewProbeLimiter(0, 0)))
+	require.NoError(t, err)
+
+	build := func(b *ethernet.FrameBuilder) []byte {
+		frame, err := b.Build()
+		require.NoError(t, err)
+
+		return frame
+	}
+
+	// VLAN 12 carries traffic already, it isn't probed
+	d.Observe(build(ethernet.NewFrame().Src(testHostMAC).VLAN(12).
+		UDP(netip.AddrPortFrom(testVLANHost, 5353), netip.MustParseAddrPort("224.0.0.251:5353"), nil)), 12, start)
+	// and those outside of the range are ignored
+	d.Observe(build(ethernet.NewFrame().Src(testHostMAC).VLAN(100).Padded().
+		ARPRequest(testVLANHost, netip.MustParseAddr("10.0.12.1"))), 100, start)
+
+	w := &probeWriter{respond: func(vid uint16) {
+		switch vid {
+		case 11:
+			// a host defends the address probed for
+			d.Observe(build(ethernet.NewFrame().Src(testHostMAC).VLAN(11).Padded().
+				ARPReply(testProbe4, testRackMAC, netip.IPv4Unspecified())), vid, clk.Now())
+		case 13:
+			d.Observe(build(ethernet.NewFrame().Src(testHostMAC).VLAN(13).Padded().
+				ARPRequest(testVLANHost, netip.MustParseAddr("10.0.12.1"))), vid, clk.Now())
+		}
+	}}
+
+	type result struct {
+		err   error
+		vlans []LiveVLAN
+	}
+
+	done := make(chan result)
+
+	go func() {
+		vlans, err := d.Run(context.Background(), w)
+		done <- result{vlans: vlans, err: err}
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(defaultVLANWindow)
+
+	res := <-done
+	require.NoError(t, res.err)
+
+	assert.Equal(t, []LiveVLAN{
+		{VID: 11, Evidence: VLANEvidenceProbeReply, Frames: 2, LastSeen: start.Unix(), Probed: true},
+		{VID: 12, Evidence: VLANEvidencePassive, Frames: 1, LastSeen: start.Unix()},
+		{VID: 13, Evidence: VLANEvidencePassive, Frames: 2, LastSeen: start.Unix(), Probed: true},
+	}, res.vlans)
+
+	// an ARP probe and a DAD solicitation for each of VLANs 10, 11 and 13
+	require.Len(t, w.frames, 6)
+
+	var eth ethernet.EthernetFrame
+
+	require.NoError(t, eth.UnmarshalBinary(w.frames[0]))
+	assert.Equal(t, testRackMAC, eth.SrcMAC)
+
+	arp, err := eth.ExtractARPPacket()
+	require.NoError(t, err)
+	assert.Equal(t, netip.IPv4Unspecified(), arp.SendIPAddr)
+	assert.Equal(t, testProbe4, arp.TgtIPAddr)
+
+	msg, ip, err := ndp.ParseFrame(w.frames[1])
+	require.NoError(t, err)
+	assert.Equal(t, ndp.TypeNeighborSolicitation, msg.Type)
+	assert.Equal(t, testProbe6, msg.Target)
+	assert.Equal(t, netip.IPv6Unspecified(), ip.Src)
+}
+
+func TestVLANDiscoveryRunCancelled(t *testing.T) {
+	t.Parallel()
+
+	d, err := NewVLANDiscovery(testRackMAC, 1, 4094, WithProbeLimiter(NewProbeLimiter(1, 1)))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &probeWriter{respond: func(uint16) { cancel() }}
+
+	_, err = d.Run(ctx, w)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, w.frames, 1)
+}
+
+func TestVLANFilter(t *testing.T) {
+	t.Parallel()
+
+	base, err := arpFilter()
+	require.NoError(t, err)
+
+	filter, err := vlanFilter(base, ethernet.EthernetTypeIPv6)
+	require.NoError(t, err)
+
+	vm, err := bpf.NewVM(disassemble(t, filter))
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		in  []byte
+		len int
+	}{
+		"ARP": {
+			in:  []byte{12: 0x08, 13: 0x06, 41: 0},
+			len: snapLen,
+		},
+		"802.1Q ARP": {
+			in:  []byte{12: 0x81, 13: 0x00, 16: 0x08, 17: 0x06, 45: 0},
+			len: snapLen,
+		},
+		"802.1Q IPv4": {
+			in:  []byte{12: 0x81, 13: 0x00, 16: 0x08, 17: 0x00, 45: 0},
+			len: snapLen,
+		},
+		"802.1Q IPv6 left to the base": {
+			in: []byte{12: 0x81, 13: 0x00, 16: 0x86, 17: 0xdd, 65: 0},
+		},
+		"IPv4": {
+			in: []byte{12: 0x08, 13: 0x00, 33: 0},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			n, err := vm.Run(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.len, n)
+		})
+	}
+}
+
+func TestServiceVLANDiscovery(t *testing.T) {
+	t.Parallel()
+
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+
+	d, err := NewVLANDiscovery(testRackMAC, 1, 4094, WithVLANDiscoveryClock(clk))
+	require.NoError(t, err)
+
+	svc := NewService("eth0", WithVLANDiscovery(d))
+
+	frame, err := ethernet.NewFrame().Src(testHostMAC).VLAN(12).
+		UDP(netip.AddrPortFrom(testVLANHost, 5353), netip.MustParseAddrPort("224.0.0.251:5353"), nil).Build()
+	require.NoError(t, err)
+
+	res, err := svc.handleFrame(frame, capture.Metadata{})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// the probes of the host come back through the capture
+	probe, err := ethernet.NewFrame().Src(testRackMAC).VLAN(13).Padded().
+		ARPRequest(netip.IPv4Unspecified(), testProbe4).Build()
+	require.NoError(t, err)
+
+	_, err = svc.handleFrame(probe, capture.Metadata{Direction: capture.DirectionOutbound})
+	require.NoError(t, err)
+
+	assert.True(t, d.liveSince(12, clk.Now()))
+	assert.False(t, d.liveSince(13, clk.Now()))
+
+	_, err = svc.DiscoverVLANs(context.Background())
+	assert.ErrorIs(t, err, ErrNotCapturing)
+
+	_, err = NewService("eth0").DiscoverVLANs(context.Background())
+	assert.ErrorIs(t, err, ErrVLANDiscoveryDisabled)
+}
@@ -0,0 +1,389 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/ndp"
+)
+
+const (
+	// defaultWakeWindow leaves a host the time to POST and start PXE
+	defaultWakeWindow  = 2 * time.Minute
+	defaultWakeRetries = 3
+	defaultWakeBackoff = 5 * time.Second
+
+	// WakeEvidenceARP, WakeEvidenceNDP and WakeEvidenceDHCP are the kinds of
+	// traffic telling a host woke up
+	WakeEvidenceARP  = "arp"
+	WakeEvidenceNDP  = "ndp"
+	WakeEvidenceDHCP = "dhcp"
+)
+
+// ErrMACNotFound is returned when no Service has a binding of a MAC
+var ErrMACNotFound = errors.New("MAC not found in the neighbor table")
+
+// Locate returns the VLAN of the most recent binding of mac, false if the
+// Service has none
+func (s *Service) Locate(mac net.HardwareAddr) (MACLocation, bool) {
+	var (
+		loc   MACLocation
+		found bool
+	)
+
+	s.table.each(func(_ bindingKey, b Binding) {
+		if !bytes.Equal(b.MAC, mac) || found && b.Time.Unix() <= loc.LastSeen {
+			return
+		}
+
+		loc, found = MACLocation{Interface: s.iface, LastSeen: b.Time.Unix()}, true
+
+		if b.VID != nil {
+			v := *b.VID
+			loc.VID = &v
+		}
+	})
+
+	return loc, found
+}
+
+// LocateMAC returns the location of the most recent binding of mac among
+// those of services, or an error matching ErrMACNotFound. A mac no host can
+// have returns an addrutil error.
+func LocateMAC(mac net.HardwareAddr, services ...*Service) (MACLocation, error) {
+	var (
+		loc   MACLocation
+		found bool
+	)
+
+	mac, err := addrutil.UnicastMAC("mac", mac)
+	if err != nil {
+		return loc, err
+	}
+
+	for _, svc := range services {
+		if l, ok := svc.Locate(mac); ok && (!found || l.LastSeen > loc.LastSeen) {
+			loc, found = l, true
+		}
+	}
+
+	if !found {
+		return loc, fmt.Errorf("%w: %s", ErrMACNotFound, mac)
+	}
+
+	return loc, nil
+}
+
+// WakeOutcome is the result of waking a host
+type WakeOutcome struct {
+	// FirstEvidence is when the first frame of the host was seen, zero if
+	// none was within the window
+	FirstEvidence time.Time `json:"first_evidence,omitzero"`
+	VID           *uint16   `json:"vid"`
+	MAC           string    `json:"mac"`
+	Interface     string    `json:"interface"`
+	// Evidence is the kind of that first frame, one of WakeEvidenceARP,
+	// WakeEvidenceNDP and WakeEvidenceDHCP
+	Evidence string `json:"evidence,omitempty"`
+	// Sent is the number of magic packets sent
+	Sent int `json:"sent"`
+	// TimedOut is set when nothing of the host was seen within the window
+	TimedOut bool `json:"timed_out"`
+}
+
+// wakeConn is the part of a Conn waking a host needs
+type wakeConn interface {
+	capture.FrameReader
+	capture.FrameWriter
+	Interface() *net.Interface
+}
+
+// Waker sends Wake-on-LAN magic packets and watches for the host waking up:
+// the ARP, NDP or DHCP traffic of its MAC. The packet is sent again, with
+// an exponential backoff, until the host shows up, the retries are spent
+// or the window is over.
+type Waker struct {
+	clock   clock.Clock
+	listen  func(iface string, options ...capture.Option) (wakeConn, error)
+	guard   []capture.GuardOption
+	window  time.Duration
+	backoff time.Duration
+	retries int
+}
+
+// WakerOption configures a Waker
+type WakerOption func(*Waker)
+
+// WithWakeWindow sets how long the host is waited for, from the first
+// magic packet
+func WithWakeWindow(d time.Duration) WakerOption {
+	return func(w *Waker) {
+		if d > 0 {
+			w.window = d
+		}
+	}
+}
+
+// WithWakeRetries sets how many times the magic packet is sent again when
+// the host doesn't show up, 0 sends it once
+func WithWakeRetries(n int) WakerOption {
+	return func(w *Waker) {
+		if n >= 0 {
+			w.retries = n
+		}
+	}
+}
+
+// WithWakeBackoff sets the time before the first retry, each of the
+// following waits twice as long as the previous one
+func WithWakeBackoff(d time.Duration) WakerOption {
+	return func(w *Waker) {
+		if d > 0 {
+			w.backoff = d
+		}
+	}
+}
+
+// WithWakeGuard configures the capture.GuardedWriter checking the magic
+// packets are sourced from the interface they are sent on
+func WithWakeGuard(options ...capture.GuardOption) WakerOption {
+	return func(w *Waker) {
+		w.guard = append(w.guard, options...)
+	}
+}
+
+// WithWakeClock sets the clock timing the window and the retries
+func WithWakeClock(c clock.Clock) WakerOption {
+	return func(w *Waker) {
+		w.clock = c
+	}
+}
+
+func listenWakeConn(iface string, options ...capture.Option) (wakeConn, error) {
+	return capture.Listen(iface, options...)
+}
+
+// NewWaker returns a Waker
+func NewWaker(options ...WakerOption) *Waker {
+	w := &Waker{
+		clock:   clock.System{},
+		listen:  listenWakeConn,
+		window:  defaultWakeWindow,
+		backoff: defaultWakeBackoff,
+		retries: defaultWakeRetries,
+	}
+
+	for _, opt := range options {
+		opt(w)
+	}
+
+	return w
+}
+
+type wakeEvidence struct {
+	at   time.Time
+	kind string
+}
+
+// Wake sends magic packets for mac on the interface and VLAN of loc until
+// the host shows up. A host not seen within the window isn't an error, the
+// outcome tells it timed out. The outcome so far is returned with the
+// error of a failed capture or of ctx, a mac no host can have returns an
+// addrutil error.
+func (w *Waker) Wake(ctx context.Context, mac net.HardwareAddr, loc MACLocation) (WakeOutcome, error) {
+	outcome := WakeOutcome{MAC: mac.String(), Interface: loc.Interface}
+
+	mac, err := addrutil.UnicastMAC("mac", mac)
+	if err != nil {
+		return outcome, err
+	}
+
+	if loc.VID != nil {
+		v := *loc.VID
+		outcome.VID = &v
+	}
+
+	filter, err := wakeFilter(mac)
+	if err != nil {
+		return outcome, err
+	}
+
+	conn, err := w.listen(loc.Interface, capture.WithFilter(filter))
+	if err != nil {
+		return outcome, err
+	}
+
+	defer conn.Close() //nolint:errcheck // nothing is read from conn anymore
+
+	packet, err := wakePacket(conn.Interface(), mac, loc.VID)
+	if err != nil {
+		return outcome, err
+	}
+
+	out := capture.NewGuardedWriter(conn, loc.Interface, w.guard...)
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	evidence := make(chan wakeEvidence, 1)
+	watched := make(chan struct{})
+
+	go func() {
+		defer close(watched)
+
+		w.watch(ctx, conn, evidence)
+	}()
+
+	defer func() {
+		cancel()
+		<-watched
+	}()
+
+	window := w.clock.NewTimer(w.window)
+	defer window.Stop()
+
+	backoff := w.backoff
+	retry := w.clock.NewTimer(backoff)
+
+	defer retry.Stop()
+
+	for {
+		if outcome.Sent == 0 || outcome.Sent <= w.retries {
+			if err := out.WriteFrame(packet); err != nil {
+				return outcome, err
+			}
+
+			outcome.Sent++
+		}
+
+		select {
+		case <-ctx.Done():
+			return outcome, ctx.Err()
+		case ev := <-evidence:
+			outcome.FirstEvidence, outcome.Evidence = ev.at, ev.kind
+			return outcome, nil
+		case <-window.C():
+			outcome.TimedOut = true
+			return outcome, nil
+		case <-retry.C():
+			backoff *= 2
+			retry.Reset(backoff)
+		}
+	}
+}
+
+// watch sends the first frame of the host telling it woke up to evidence,
+// it returns then or once ctx is done
+func (w *Waker) watch(ctx context.Context, conn wakeConn, evidence chan<- wakeEvidence) {
+	stop := capture.InterruptReads(ctx, conn)
+	defer stop()
+
+	buf := make([]byte, portAuthSnapLen)
+
+	for {
+		md, err := capture.ReadFrameMetadata(conn, buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warn().Err(err).Msg("Stopped watching for the host to wake up")
+			}
+
+			return
+		}
+
+		kind := wakeEvidenceKind(buf[:md.CaptureLength])
+		if kind == "" {
+			continue
+		}
+
+		at := md.Timestamp
+		if at.IsZero() {
+			at = w.clock.Now()
+		}
+
+		evidence <- wakeEvidence{at: at, kind: kind}
+
+		return
+	}
+}
+
+// wakeEvidenceKind returns the kind of traffic a waking host sends frame
+// is, or an empty string for the others
+func wakeEvidenceKind(frame []byte) string {
+	var eth ethernet.EthernetFrame
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return ""
+	}
+
+	if _, err := eth.ExtractARPPacket(); err == nil {
+		return WakeEvidenceARP
+	}
+
+	if _, _, err := ndp.ParseFrame(frame); err == nil {
+		return WakeEvidenceNDP
+	}
+
+	if parseDHCP(frame, ethernet.ParserOptions{}).msgType != 0 {
+		return WakeEvidenceDHCP
+	}
+
+	return ""
+}
+
+// wakePacket returns the magic packet for mac sent from the interface,
+// those without an ethernet address, such as lo, use a zero one
+func wakePacket(iface *net.Interface, mac net.HardwareAddr, vid *uint16) ([]byte, error) {
+	src := make(net.HardwareAddr, 6)
+	if iface != nil && len(iface.HardwareAddr) == 6 {
+		src = iface.HardwareAddr
+	}
+
+	b := ethernet.NewFrame().Src(src).WakeOnLAN(mac)
+	if vid != nil {
+		b = b.VLAN(*vid)
+	}
+
+	return b.Build()
+}
+
+// wakeFilter accepts the frames sent by mac
+func wakeFilter(mac net.HardwareAddr) ([]bpf.RawInstruction, error) {
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("%w: MAC %q", ethernet.ErrBuildFrame, mac)
+	}
+
+	return bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 6, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: binary.BigEndian.Uint32(mac[0:4]), SkipFalse: 3},
+		bpf.LoadAbsolute{Off: 10, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(binary.BigEndian.Uint16(mac[4:6])), SkipFalse: 1},
+		bpf.RetConstant{Val: uint32(portAuthSnapLen)},
+		bpf.RetConstant{Val: 0},
+	})
+}
@@ -0,0 +1,348 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netmon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+
+	"maas.io/core/src/maasagent/internal/addrutil"
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	clocktest "maas.io/core/src/maasagent/internal/testing/clock"
+)
+
+var testSleeper = net.HardwareAddr{0x52, 0x54, 0x00, 0xaa, 0xbb, 0xcc}
+
+// wakeLink is a wakeConn reading the frames sent to frames, and sending the
+// written ones to sent
+type wakeLink struct {
+	frames    chan []byte
+	sent      chan []byte
+	interrupt chan struct{}
+	once      sync.Once
+}
+
+func newWakeLink() *wakeLink {
+	return &wakeLink{
+		frames:    make(chan []byte),
+		sent:      make(chan []byte, 16),
+		interrupt: make(chan struct{}),
+	}
+}
+
+func (l *wakeLink) ReadFrame(buf []byte) (int, error) {
+	md, err := l.ReadFrameMetadata(buf)
+
+	return md.CaptureLength, err
+}
+
+func (l *wakeLink) ReadFrameMetadata(buf []byte) (capture.Metadata, error) {
+	select {
+	case frame := <-l.frames:
+		n := copy(buf, frame)
+
+		return capture.Metadata{CaptureLength: n, Length: len(frame)}, nil
+	case <-l.interrupt:
+		return capture.Metadata{}, os.ErrDeadlineExceeded
+	}
+}
+
+func (l *wakeLink) SetReadDeadline(t time.Time) error {
+	if !t.IsZero() {
+		l.once.Do(func() { close(l.interrupt) })
+	}
+
+	return nil
+}
+
+func (l *wakeLink) WriteFrame(frame []byte) error {
+	l.sent <- frame
+	return nil
+}
+
+func (l *wakeLink) Interface() *net.Interface {
+	return &net.Interface{Name: "eth0", HardwareAddr: testRackMAC}
+}
+
+func (l *wakeLink) Close() error { return nil }
+
+// wakeLinks is a capture.LinkAddrSource knowing of eth0 with mac
+type wakeLinks struct {
+	mac net.HardwareAddr
+}
+
+func (s wakeLinks) LinkAddrs(name string, vid uint16) (net.HardwareAddr, []netip.Prefix, bool) {
+	return s.mac, nil, name == "eth0" && vid == 0
+}
+
+func TestWaker(t *testing.T) {
+	t.Parallel()
+
+	vid := uint16(12)
+	build := func(tb testing.TB, b *ethernet.FrameBuilder) []byte {
+		tb.Helper()
+
+		frame, err := b.Build()
+		require.NoError(tb, err)
+
+		return frame
+	}
+
+	testcases := map[string]struct {
+		// run plays the network, the magic packets are read from l.sent
+		run     func(t *testing.T, clk *clocktest.Fake, l *wakeLink)
+		options []WakerOption
+		out     WakeOutcome
+	}{
+		"DHCP after the first packet": {
+			run: func(t *testing.T, _ *clocktest.Fake, l *wakeLink) {
+				<-l.sent
+				l.frames <- build(t, ethernet.NewFrame().Src(testSleeper).VLAN(vid).DHCPDiscover(1))
+			},
+			out: WakeOutcome{Sent: 1, Evidence: WakeEvidenceDHCP},
+		},
+		"ARP after a retry": {
+			run: func(t *testing.T, clk *clocktest.Fake, l *wakeLink) {
+				<-l.sent
+				// the other traffic of the host doesn't tell it woke up
+				l.frames <- build(t, ethernet.NewFrame().Src(testSleeper).
+					UDP(netip.MustParseAddrPort("10.0.12.5:4000"), netip.MustParseAddrPort("10.0.12.1:4000"), nil))
+
+				clk.BlockUntil(2)
+				clk.Advance(defaultWakeBackoff)
+				<-l.sent
+				l.frames <- build(t, ethernet.NewFrame().Src(testSleeper).VLAN(vid).Padded().
+					ARPRequest(netip.MustParseAddr("10.0.12.5"), netip.MustParseAddr("10.0.12.1")))
+			},
+			out: WakeOutcome{Sent: 2, Evidence: WakeEvidenceARP},
+		},
+		"timed out": {
+			options: []WakerOption{WithWakeRetries(1), WithWakeBackoff(5 * time.Second), WithWakeWindow(time.Minute)},
+			run: func(t *testing.T, clk *clocktest.Fake, l *wakeLink) {
+				<-l.sent
+				clk.BlockUntil(2)
+				clk.Advance(5 * time.Second)
+				<-l.sent
+
+				// the retries are spent, the next backoff sends nothing
+				clk.BlockUntil(2)
+				clk.Advance(10 * time.Second)
+				clk.BlockUntil(2)
+				clk.Advance(45 * time.Second)
+			},
+			out: WakeOutcome{Sent: 2, TimedOut: true},
+		},
+	}
+
+	start := time.Unix(1700000000, 0)
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clk := clocktest.NewFake(start)
+			l := newWakeLink()
+
+			w := NewWaker(append([]WakerOption{WithWakeClock(clk),
+				WithWakeGuard(capture.WithGuardSource(wakeLinks{mac: testRackMAC}))}, tc.options...)...)
+			w.listen = func(string, ...capture.Option) (wakeConn, error) {
+				return l, nil
+			}
+
+			go tc.run(t, clk, l)
+
+			out, err := w.Wake(context.Background(), testSleeper, MACLocation{Interface: "eth0", VID: &vid})
+			require.NoError(t, err)
+
+			tc.out.MAC = testSleeper.String()
+			tc.out.Interface = "eth0"
+			tc.out.VID = &vid
+
+			if !tc.out.TimedOut {
+				tc.out.FirstEvidence = clk.Now()
+			}
+
+			assert.Equal(t, tc.out, out)
+			assert.Empty(t, l.sent)
+		})
+	}
+}
+
+func TestWakerPacket(t *testing.T) {
+	t.Parallel()
+
+	l := newWakeLink()
+	vid := uint16(12)
+
+	w := NewWaker(WithWakeWindow(time.Millisecond), WithWakeGuard(capture.WithGuardSource(wakeLinks{mac: testRackMAC})))
+	w.listen = func(string, ...capture.Option) (wakeConn, error) {
+		return l, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	out, err := w.Wake(ctx, testSleeper, MACLocation{Interface: "eth0", VID: &vid})
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, out.Sent)
+
+	var eth ethernet.EthernetFrame
+
+	require.NoError(t, eth.UnmarshalBinary(<-l.sent))
+	assert.Equal(t, testRackMAC, eth.SrcMAC)
+	assert.Equal(t, ethernet.Broadcast, eth.DstMAC)
+
+	vlan, err := eth.ExtractVLAN()
+	require.NoError(t, err)
+	assert.Equal(t, vid, vlan.ID)
+	assert.Equal(t, ethernet.EthernetType(0x0842), vlan.EthernetType)
+	assert.Equal(t, []byte(testSleeper), eth.Payload[4+6+6*15:])
+}
+
+func TestWakerGuard(t *testing.T) {
+	t.Parallel()
+
+	l := newWakeLink()
+
+	// the capture is opened on another interface than the inventory's
+	w := NewWaker(WithWakeGuard(capture.WithGuardSource(wakeLinks{mac: testHostMAC})))
+	w.listen = func(string, ...capture.Option) (wakeConn, error) {
+		return l, nil
+	}
+
+	out, err := w.Wake(context.Background(), testSleeper, MACLocation{Interface: "eth0"})
+	require.ErrorIs(t, err, capture.ErrTransmitRejected)
+	assert.Zero(t, out.Sent)
+	assert.Empty(t, l.sent)
+}
+
+func TestWakeFilter(t *testing.T) {
+	t.Parallel()
+
+	filter, err := wakeFilter(testSleeper)
+	require.NoError(t, err)
+
+	vm, err := bpf.NewVM(disassemble(t, filter))
+	require.NoError(t, err)
+
+	from := func(mac net.HardwareAddr) []byte {
+		frame := make([]byte, 60)
+		copy(frame[6:], mac)
+
+		return frame
+	}
+
+	n, err := vm.Run(from(testSleeper))
+	require.NoError(t, err)
+	assert.Equal(t, portAuthSnapLen, n)
+
+	for _, mac := range []net.HardwareAddr{
+		testRackMAC,
+		{0x52, 0x54, 0x00, 0xaa, 0xbb, 0xcd},
+		{0x52, 0x54, 0x01, 0xaa, 0xbb, 0xcc},
+	} {
+		n, err = vm.Run(from(mac))
+		require.NoError(t, err)
+		assert.Zero(t, n, mac.String())
+	}
+
+	_, err = wakeFilter(nil)
+	assert.ErrorIs(t, err, ethernet.ErrBuildFrame)
+}
+
+func TestLocateMAC(t *testing.T) {
+	t.Parallel()
+
+	arp := func(vid *uint16) []byte {
+		b := ethernet.NewFrame().Src(testSleeper).Padded()
+		if vid != nil {
+			b = b.VLAN(*vid)
+		}
+
+		frame, err := b.ARPRequest(netip.MustParseAddr("10.0.12.5"), netip.MustParseAddr("10.0.12.1")).Build()
+		require.NoError(t, err)
+
+		return frame
+	}
+
+	start := time.Unix(1700000000, 0)
+	vid := uint16(12)
+	eth0, eth1 := NewService("eth0"), NewService("eth1")
+
+	_, err := eth0.handleFrame(arp(nil), capture.Metadata{Timestamp: start})
+	require.NoError(t, err)
+
+	_, err = eth1.handleFrame(arp(&vid), capture.Metadata{Timestamp: start.Add(time.Minute)})
+	require.NoError(t, err)
+
+	loc, err := LocateMAC(testSleeper, eth0, eth1)
+	require.NoError(t, err)
+	assert.Equal(t, MACLocation{Interface: "eth1", VID: &vid, LastSeen: start.Add(time.Minute).Unix()}, loc)
+
+	loc, err = LocateMAC(testSleeper, eth0)
+	require.NoError(t, err)
+	assert.Equal(t, MACLocation{Interface: "eth0", LastSeen: start.Unix()}, loc)
+
+	_, err = LocateMAC(testRackMAC, eth0, eth1)
+	assert.ErrorIs(t, err, ErrMACNotFound)
+}
+
+func TestWakeInvalidMAC(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]net.HardwareAddr{
+		"missing":       nil,
+		"7 bytes":       {0x52, 0x54, 0x00, 0xaa, 0xbb, 0xcc, 0xdd},
+		"EUI-64":        {0x52, 0x54, 0x00, 0xff, 0xfe, 0xaa, 0xbb, 0xcc},
+		"group address": {0x01, 0x00, 0x5e, 0x00, 0x00, 0x01},
+		"broadcast":     {0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"all zeros":     {0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	}
+
+	for name, mac := range testcases {
+		mac := mac
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l := newWakeLink()
+			w := NewWaker()
+			w.listen = func(string, ...capture.Option) (wakeConn, error) {
+				return l, nil
+			}
+
+			_, err := w.Wake(context.Background(), mac, MACLocation{Interface: "eth0"})
+			assert.ErrorIs(t, err, addrutil.ErrInvalidMAC)
+			assert.Empty(t, l.sent)
+
+			_, err = LocateMAC(mac, NewService("eth0"))
+			assert.ErrorIs(t, err, addrutil.ErrInvalidMAC)
+			assert.NotErrorIs(t, err, ErrMACNotFound)
+		})
+	}
+}
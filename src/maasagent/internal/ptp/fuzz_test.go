@@ -0,0 +1,60 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ptp
+
+import (
+	"testing"
+
+	"maas.io/core/src/maasagent/internal/testing/fuzz"
+)
+
+func FuzzDecapsulate(f *testing.F) {
+	msg := testAnnounce()
+
+	for _, seed := range [][]byte{
+		ethernetFrame(EthernetType, msg),
+		ethernetFrame(0x0800, ipv4Packet(0x4000, udpDatagram(GeneralPort, msg))),
+		ethernetFrame(0x86dd, ipv6Packet(udpDatagram(EventPort, testHeader(MessageSync, 0, 44)))),
+		ethernetFrame(0x8100, append([]byte{0x00, 0x64, 0x88, 0xf7}, msg...)),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		fuzz.Bounded(t, in, func() {
+			msg, transport, ok := Decapsulate(in)
+			if !ok {
+				if transport != 0 || msg != nil {
+					t.Errorf("failed decapsulation returned %v and %d bytes", transport, len(msg))
+				}
+
+				return
+			}
+
+			var h Header
+
+			if err := h.UnmarshalBinary(msg); err != nil {
+				return
+			}
+
+			var a Announce
+
+			if err := a.UnmarshalBinary(msg); err == nil {
+				_ = a.GrandmasterIdentity.String()
+			}
+		})
+	})
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ptp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// EthernetType is the ethertype of PTP over IEEE 802.3 (Annex F)
+	EthernetType = 0x88f7
+	// EventPort is the UDP port of event messages such as Sync (Annex D/E)
+	EventPort = 319
+	// GeneralPort is the UDP port of general messages such as Announce
+	GeneralPort = 320
+
+	headerLen   = 34
+	announceLen = 64
+
+	ethernetHeaderLen = 14
+	ipv6HeaderLen     = 40
+	udpHeaderLen      = 8
+	protocolUDP       = 17
+)
+
+var (
+	// ErrMalformedMessage is returned when a PTP message is too short
+	ErrMalformedMessage = errors.New("malformed PTP message")
+	// ErrNotAnnounce is returned when decoding an Announce message from a
+	// message of another type
+	ErrNotAnnounce = errors.New("PTP message is not an Announce")
+)
+
+// MessageType is the type of a PTP message
+type MessageType uint8
+
+const (
+	// MessageSync carries the master time, event message
+	MessageSync MessageType = 0x0
+	// MessageDelayReq measures the slave to master delay, event message
+	MessageDelayReq MessageType = 0x1
+	// MessagePdelayReq starts a peer delay measurement, event message
+	MessagePdelayReq MessageType = 0x2
+	// MessagePdelayResp answers a MessagePdelayReq, event message
+	MessagePdelayResp MessageType = 0x3
+	// MessageFollowUp carries the precise time of a two-step Sync
+	MessageFollowUp MessageType = 0x8
+	// MessageDelayResp answers a MessageDelayReq
+	MessageDelayResp MessageType = 0x9
+	// MessagePdelayRespFollowUp carries the precise time of a two-step
+	// MessagePdelayResp
+	MessagePdelayRespFollowUp MessageType = 0xa
+	// MessageAnnounce advertises the grandmaster used by a master
+	MessageAnnounce MessageType = 0xb
+	// MessageSignaling carries requests between clocks
+	MessageSignaling MessageType = 0xc
+	// MessageManagement queries and configures clocks
+	MessageManagement MessageType = 0xd
+)
+
+var messageTypeNames = map[MessageType]string{
+	MessageSync:               "Sync",
+	MessageDelayReq:           "Delay_Req",
+	MessagePdelayReq:          "Pdelay_Req",
+	MessagePdelayResp:         "Pdelay_Resp",
+	MessageFollowUp:           "Follow_Up",
+	MessageDelayResp:          "Delay_Resp",
+	MessagePdelayRespFollowUp: "Pdelay_Resp_Follow_Up",
+	MessageAnnounce:           "Announce",
+	MessageSignaling:          "Signaling",
+	MessageManagement:         "Management",
+}
+
+// String returns the name IEEE 1588 gives to the message type
+func (t MessageType) String() string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Reserved(%d)", uint8(t))
+}
+
+// ClockIdentity is the EUI-64 identifying a PTP clock
+type ClockIdentity [8]byte
+
+// String returns the identity in the format used by linuxptp
+func (c ClockIdentity) String() string {
+	return fmt.Sprintf("%02x%02x%02x.%02x%02x.%02x%02x%02x",
+		c[0], c[1], c[2], c[3], c[4], c[5], c[6], c[7])
+}
+
+// MarshalText implements encoding.TextMarshaler for ClockIdentity
+func (c ClockIdentity) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// PortIdentity identifies a port of a PTP clock
+type PortIdentity struct {
+	ClockIdentity ClockIdentity
+	PortNumber    uint16
+}
+
+// Header is the common header of every PTP message
+type Header struct {
+	// Correction is in nanoseconds multiplied by 2^16
+	Correction         int64
+	SourcePortIdentity PortIdentity
+	Length             uint16
+	Flags              uint16
+	SequenceID         uint16
+	MessageType        MessageType
+	TransportSpecific  uint8
+	Version            uint8
+	MinorVersion       uint8
+	Domain             uint8
+	LogMessageInterval int8
+}
+
+// UnmarshalBinary parses the header of a PTP message
+func (h *Header) UnmarshalBinary(buf []byte) error {
+	if len(buf) < headerLen {
+		return ErrMalformedMessage
+	}
+
+	h.TransportSpecific = buf[0] >> 4
+	h.MessageType = MessageType(buf[0] & 0x0f)
+	h.MinorVersion = buf[1] >> 4
+	h.Version = buf[1] & 0x0f
+	h.Length = binary.BigEndian.Uint16(buf[2:4])
+	h.Domain = buf[4]
+	h.Flags = binary.BigEndian.Uint16(buf[6:8])
+	h.Correction = int64(binary.BigEndian.Uint64(buf[8:16])) //nolint:gosec // correctionField is signed
+	copy(h.SourcePortIdentity.ClockIdentity[:], buf[20:28])
+	h.SourcePortIdentity.PortNumber = binary.BigEndian.Uint16(buf[28:30])
+	h.SequenceID = binary.BigEndian.Uint16(buf[30:32])
+	h.LogMessageInterval = int8(buf[33]) //nolint:gosec // logMessageInterval is signed
+
+	return nil
+}
+
+// ClockQuality describes the accuracy of a clock
+type ClockQuality struct {
+	// Class is the clockClass, 6 for a clock synchronised to a primary
+	// reference such as GPS, 248 for a default free running clock
+	Class uint8
+	// Accuracy is the clockAccuracy enumeration, such as 0x21 for 100ns
+	Accuracy uint8
+	// Variance is the offsetScaledLogVariance of the clock
+	Variance uint16
+}
+
+// Announce is an Announce message, which the masters of a domain send to
+// elect the grandmaster
+type Announce struct {
+	Header
+	GrandmasterQuality   ClockQuality
+	GrandmasterIdentity  ClockIdentity
+	CurrentUTCOffset     int16
+	StepsRemoved         uint16
+	GrandmasterPriority1 uint8
+	GrandmasterPriority2 uint8
+	TimeSource           uint8
+}
+
+// UnmarshalBinary parses an Announce message
+func (a *Announce) UnmarshalBinary(buf []byte) error {
+	if err := a.Header.UnmarshalBinary(buf); err != nil {
+		return err
+	}
+
+	if a.MessageType != MessageAnnounce {
+		return fmt.Errorf("%w: %s", ErrNotAnnounce, a.MessageType)
+	}
+
+	if len(buf) < announceLen {
+		return ErrMalformedMessage
+	}
+
+	// the originTimestamp of buf[34:44] is usually zero and not decoded
+	a.CurrentUTCOffset = int16(binary.BigEndian.Uint16(buf[44:46])) //nolint:gosec // currentUtcOffset is signed
+	a.GrandmasterPriority1 = buf[47]
+	a.GrandmasterQuality = ClockQuality{
+		Class:    buf[48],
+		Accuracy: buf[49],
+		Variance: binary.BigEndian.Uint16(buf[50:52]),
+	}
+	a.GrandmasterPriority2 = buf[52]
+	copy(a.GrandmasterIdentity[:], buf[53:61])
+	a.StepsRemoved = binary.BigEndian.Uint16(buf[61:63])
+	a.TimeSource = buf[63]
+
+	return nil
+}
+
+// Transport is the encapsulation of a PTP message
+type Transport uint8
+
+const (
+	// TransportEthernet is PTP directly over ethernet
+	TransportEthernet Transport = iota + 1
+	// TransportUDPv4 is PTP over UDP over IPv4
+	TransportUDPv4
+	// TransportUDPv6 is PTP over UDP over IPv6
+	TransportUDPv6
+)
+
+// Decapsulate returns the PTP message carried by an ethernet frame, either
+// directly or in a UDP datagram to port 319 or 320, after any VLAN tags.
+// IPv4 fragments and IPv6 extension headers are not followed.
+func Decapsulate(frame []byte) ([]byte, Transport, bool) {
+	if len(frame) < ethernetHeaderLen {
+		return nil, 0, false
+	}
+
+	off := 12
+	ethertype := binary.BigEndian.Uint16(frame[off:])
+
+	for (ethertype == 0x8100 || ethertype == 0x88a8) && len(frame) >= off+6 {
+		off += 4
+		ethertype = binary.BigEndian.Uint16(frame[off:])
+	}
+
+	payload := frame[off+2:]
+
+	switch ethertype {
+	case EthernetType:
+		return payload, TransportEthernet, true
+	case 0x0800:
+		if len(payload) < 20 || payload[0]>>4 != 4 || payload[9] != protocolUDP ||
+			binary.BigEndian.Uint16(payload[6:8])&0x3fff != 0 {
+			return nil, 0, false
+		}
+
+		ihl := int(payload[0]&0x0f) * 4
+		if ihl < 20 || len(payload) < ihl {
+			return nil, 0, false
+		}
+
+		if msg, ok := udpPayload(payload[ihl:]); ok {
+			return msg, TransportUDPv4, true
+		}
+	case 0x86dd:
+		if len(payload) < ipv6HeaderLen || payload[6] != protocolUDP {
+			return nil, 0, false
+		}
+
+		if msg, ok := udpPayload(payload[ipv6HeaderLen:]); ok {
+			return msg, TransportUDPv6, true
+		}
+	}
+
+	return nil, 0, false
+}
+
+func udpPayload(datagram []byte) ([]byte, bool) {
+	if len(datagram) < udpHeaderLen {
+		return nil, false
+	}
+
+	switch binary.BigEndian.Uint16(datagram[2:4]) {
+	case EventPort, GeneralPort:
+		return datagram[udpHeaderLen:], true
+	}
+
+	return nil, false
+}
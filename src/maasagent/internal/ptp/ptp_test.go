@@ -0,0 +1,295 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ptp
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testClock = ClockIdentity{0x00, 0x16, 0x3e, 0xff, 0xfe, 0x00, 0x00, 0x01}
+
+func testHeader(mt MessageType, domain uint8, length int) []byte {
+	msg := make([]byte, length)
+	msg[0] = byte(mt)
+	msg[1] = 0x02
+	binary.BigEndian.PutUint16(msg[2:4], uint16(length)) //nolint:gosec // test messages are small
+	msg[4] = domain
+	binary.BigEndian.PutUint16(msg[6:8], 0x0200)
+	binary.BigEndian.PutUint64(msg[8:16], 0x10000)
+	copy(msg[20:28], testClock[:])
+	binary.BigEndian.PutUint16(msg[28:30], 1)
+	binary.BigEndian.PutUint16(msg[30:32], 42)
+	msg[33] = 0xfd
+
+	return msg
+}
+
+func testAnnounce() []byte {
+	msg := testHeader(MessageAnnounce, 24, announceLen)
+	binary.BigEndian.PutUint16(msg[44:46], 37)
+	msg[47] = 128
+	msg[48] = 6
+	msg[49] = 0x21
+	binary.BigEndian.PutUint16(msg[50:52], 0x4e5d)
+	msg[52] = 127
+	copy(msg[53:61], testClock[:])
+	binary.BigEndian.PutUint16(msg[61:63], 1)
+	msg[63] = 0x20
+
+	return msg
+}
+
+func TestMessageTypeString(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  MessageType
+		out string
+	}{
+		"Sync": {
+			in:  MessageSync,
+			out: "Sync",
+		},
+		"Pdelay_Resp_Follow_Up": {
+			in:  MessagePdelayRespFollowUp,
+			out: "Pdelay_Resp_Follow_Up",
+		},
+		"reserved": {
+			in:  0x5,
+			out: "Reserved(5)",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.in.String())
+		})
+	}
+}
+
+func TestClockIdentityString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "00163e.fffe.000001", testClock.String())
+}
+
+func TestHeaderUnmarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []byte
+		out Header
+		err error
+	}{
+		"Sync": {
+			in: testHeader(MessageSync, 0, 44),
+			out: Header{
+				Correction: 0x10000,
+				SourcePortIdentity: PortIdentity{
+					ClockIdentity: testClock,
+					PortNumber:    1,
+				},
+				Length:             44,
+				Flags:              0x0200,
+				SequenceID:         42,
+				MessageType:        MessageSync,
+				Version:            2,
+				LogMessageInterval: -3,
+			},
+		},
+		"truncated": {
+			in:  testHeader(MessageSync, 0, 44)[:33],
+			err: ErrMalformedMessage,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var h Header
+
+			err := h.UnmarshalBinary(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, h)
+		})
+	}
+}
+
+func TestAnnounceUnmarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in  []byte
+		err error
+	}{
+		"Announce": {
+			in: testAnnounce(),
+		},
+		"truncated": {
+			in:  testAnnounce()[:60],
+			err: ErrMalformedMessage,
+		},
+		"Sync": {
+			in:  testHeader(MessageSync, 0, announceLen),
+			err: ErrNotAnnounce,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var a Announce
+
+			err := a.UnmarshalBinary(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, uint8(24), a.Domain)
+			assert.Equal(t, int16(37), a.CurrentUTCOffset)
+			assert.Equal(t, uint8(128), a.GrandmasterPriority1)
+			assert.Equal(t, uint8(127), a.GrandmasterPriority2)
+			assert.Equal(t, ClockQuality{Class: 6, Accuracy: 0x21, Variance: 0x4e5d}, a.GrandmasterQuality)
+			assert.Equal(t, testClock, a.GrandmasterIdentity)
+			assert.Equal(t, uint16(1), a.StepsRemoved)
+			assert.Equal(t, uint8(0x20), a.TimeSource)
+		})
+	}
+}
+
+func ethernetFrame(ethertype uint16, payload []byte) []byte {
+	frame := []byte{
+		0x01, 0x1b, 0x19, 0x00, 0x00, 0x00,
+		0x00, 0x16, 0x3e, 0x00, 0x00, 0x01,
+		byte(ethertype >> 8), byte(ethertype),
+	}
+
+	return append(frame, payload...)
+}
+
+func udpDatagram(port uint16, payload []byte) []byte {
+	udp := make([]byte, udpHeaderLen)
+	binary.BigEndian.PutUint16(udp[0:2], port)
+	binary.BigEndian.PutUint16(udp[2:4], port)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpHeaderLen+len(payload))) //nolint:gosec // test datagrams are small
+
+	return append(udp, payload...)
+}
+
+func ipv4Packet(flags uint16, datagram []byte) []byte {
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[6:8], flags)
+	ip[9] = protocolUDP
+
+	return append(ip, datagram...)
+}
+
+func ipv6Packet(datagram []byte) []byte {
+	ip := make([]byte, ipv6HeaderLen)
+	ip[0] = 0x60
+	ip[6] = protocolUDP
+
+	return append(ip, datagram...)
+}
+
+func TestDecapsulate(t *testing.T) {
+	t.Parallel()
+
+	msg := testAnnounce()
+
+	testcases := map[string]struct {
+		in        []byte
+		transport Transport
+		ok        bool
+	}{
+		"ethernet": {
+			in:        ethernetFrame(EthernetType, msg),
+			transport: TransportEthernet,
+			ok:        true,
+		},
+		"802.1Q ethernet": {
+			in:        ethernetFrame(0x8100, append([]byte{0x00, 0x64, 0x88, 0xf7}, msg...)),
+			transport: TransportEthernet,
+			ok:        true,
+		},
+		"UDPv4 general": {
+			in:        ethernetFrame(0x0800, ipv4Packet(0x4000, udpDatagram(GeneralPort, msg))),
+			transport: TransportUDPv4,
+			ok:        true,
+		},
+		"UDPv6 event": {
+			in:        ethernetFrame(0x86dd, ipv6Packet(udpDatagram(EventPort, msg))),
+			transport: TransportUDPv6,
+			ok:        true,
+		},
+		"other UDP port": {
+			in: ethernetFrame(0x0800, ipv4Packet(0, udpDatagram(123, msg))),
+		},
+		"IPv4 fragment": {
+			in: ethernetFrame(0x0800, ipv4Packet(0x0010, udpDatagram(GeneralPort, msg))),
+		},
+		"truncated IPv4": {
+			in: ethernetFrame(0x0800, ipv4Packet(0, nil)[:10]),
+		},
+		"empty IPv4": {
+			in: ethernetFrame(0x0800, nil),
+		},
+		"ARP": {
+			in: ethernetFrame(0x0806, msg),
+		},
+		"runt": {
+			in: ethernetFrame(EthernetType, nil)[:12],
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, transport, ok := Decapsulate(tc.in)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.transport, transport)
+
+			if tc.ok {
+				assert.Equal(t, msg, out)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"bufio"
 	"context"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -853,7 +854,7 @@ func generateTransactionID() (uint16, error) {
 	//nolint:errcheck,gosec // rand.Read() never returns an error
 	rand.Read(b[:])
 
-	return uint16(b[0])<<8 | uint16(b[1]), nil
+	return binary.BigEndian.Uint16(b[:]), nil
 }
 
 // generateEDNS0Cookie creates a cookie to be used in non-authoritative
@@ -17,6 +17,7 @@ package resolver
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"net"
 	"sync"
@@ -155,8 +156,7 @@ func (s *session) compress(compressed *[]byte, label []byte) bool {
 
 	//nolint:gosec // G115 compression as in RFC1035
 	v := uint16(idx ^ labelPointerShift)
-	(*compressed)[compressedLen] = byte(v >> 8)
-	(*compressed)[compressedLen+1] = byte(v)
+	binary.BigEndian.PutUint16((*compressed)[compressedLen:], v)
 
 	return true
 }
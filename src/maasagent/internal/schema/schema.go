@@ -0,0 +1,100 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package schema holds the types of the JSON the agent gives to the other
+// programs: the lines of maas-netmon and its journal, the events of the
+// debug endpoints and of the webhooks, the snapshots of the neighbor tables,
+// the scan results, the topology reports and the host identities. The
+// producers use these types, so every consumer sees the same shapes.
+//
+// The schemas are versioned together. The JSON Schema documents of every
+// version are generated from the types by the tests, which fail when the
+// types no longer produce the documents of Version. The schemas only evolve
+// additively: a field may be added, never removed, renamed or retyped, and
+// the fixtures of every previous version must still decode. A change is
+// made by bumping Version, describing it in CHANGELOG.md and running
+//
+//	go test ./internal/schema -update
+//
+// which records the documents and the fixtures of the new version.
+package schema
+
+import (
+	"embed"
+	"fmt"
+	"path"
+
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+// Version is the version of the schemas, see CHANGELOG.md
+const Version = 5
+
+// Header is the HTTP header telling the version of the schemas of a body
+const Header = "X-Maas-Schema-Version"
+
+// The names of the schemas, those of their documents
+const (
+	NameObservation    = "observation"
+	NameEvent          = "event"
+	NameScanResult     = "scan_result"
+	NameSnapshot       = "snapshot"
+	NameTopologyReport = "topology_report"
+	NameIdentities     = "identities"
+	NameIdentityEvent  = "identity_event"
+)
+
+// Names are the names of the schemas
+var Names = []string{NameObservation, NameEvent, NameScanResult, NameSnapshot, NameTopologyReport,
+	NameIdentities, NameIdentityEvent}
+
+//go:embed v*/*.schema.json
+var documents embed.FS
+
+type (
+	// Observation is what a netmon Service observed of a host
+	Observation = netmon.Result
+	// ScanResult is what changed since the previous run of a scan job
+	ScanResult = netmon.ScanReport
+	// Snapshot is the neighbor table of an interface
+	Snapshot = netmon.Snapshot
+	// TopologyReport is the switch port an interface is connected to
+	TopologyReport = netmon.TopologyReport
+	// Identities are the hosts of the segments, each with all its bindings
+	Identities = netmon.IdentitySnapshot
+	// IdentityEvent is a change of a host between two Identities
+	IdentityEvent = netmon.IdentityEvent
+)
+
+// Event is an Observation made on an interface
+type Event struct {
+	// Interface is the name of the interface the Observation was made on
+	Interface string `json:"interface"`
+	netmon.Result
+	// Severity is that of the event of the Observation, from the defaults
+	// of netmon.DefaultSeverities and the overrides of the deployment
+	Severity netmon.Severity `json:"severity,omitzero"`
+}
+
+// Document returns the JSON Schema document of the schema name, in the
+// given version
+func Document(version int, name string) ([]byte, error) {
+	b, err := documents.ReadFile(path.Join(fmt.Sprintf("v%d", version), name+".schema.json"))
+	if err != nil {
+		return nil, fmt.Errorf("no schema %q in version %d: %w", name, version, err)
+	}
+
+	return b, nil
+}
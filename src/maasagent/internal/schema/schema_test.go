@@ -0,0 +1,513 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package schema
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+var update = flag.Bool("update", false, "record the documents and the fixtures of a new version")
+
+// types are the Go types of the schemas, by name
+var types = map[string]reflect.Type{
+	NameObservation:    reflect.TypeFor[Observation](),
+	NameEvent:          reflect.TypeFor[Event](),
+	NameScanResult:     reflect.TypeFor[ScanResult](),
+	NameSnapshot:       reflect.TypeFor[Snapshot](),
+	NameTopologyReport: reflect.TypeFor[TopologyReport](),
+	NameIdentities:     reflect.TypeFor[Identities](),
+	NameIdentityEvent:  reflect.TypeFor[IdentityEvent](),
+}
+
+// document is the part of JSON Schema the generated documents use
+type document struct {
+	Schema               string               `json:"$schema,omitempty"`
+	ID                   string               `json:"$id,omitempty"`
+	Title                string               `json:"title,omitempty"`
+	Type                 any                  `json:"type,omitempty"`
+	Format               string               `json:"format,omitempty"`
+	Required             []string             `json:"required,omitempty"`
+	Properties           map[string]*document `json:"properties,omitempty"`
+	Items                *document            `json:"items,omitempty"`
+	AdditionalProperties *document            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	textMarshaler = reflect.TypeFor[encoding.TextMarshaler]()
+	jsonMarshaler = reflect.TypeFor[json.Marshaler]()
+	timeType      = reflect.TypeFor[time.Time]()
+)
+
+// generate returns the document of the JSON encoding/json makes of t. The
+// types marshaling themselves are described by the JSON type of their zero
+// value, the interfaces and the recursive types accept anything.
+func generate(t reflect.Type, seen map[reflect.Type]bool) *document {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &document{Type: "string", Format: "date-time"}
+	case t.Implements(textMarshaler) || reflect.PointerTo(t).Implements(textMarshaler):
+		return &document{Type: "string"}
+	case t.Implements(jsonMarshaler):
+		b, err := json.Marshal(reflect.Zero(t).Interface())
+		if err != nil {
+			return &document{}
+		}
+
+		return &document{Type: jsonType(b)}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &document{Type: "string"}
+	case reflect.Bool:
+		return &document{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &document{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &document{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &document{Type: "string"}
+		}
+
+		return &document{Type: "array", Items: generate(t.Elem(), seen)}
+	case reflect.Map:
+		return &document{Type: "object", AdditionalProperties: generate(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return &document{}
+		}
+
+		seen[t] = true
+		defer delete(seen, t)
+
+		doc := &document{Type: "object", Properties: make(map[string]*document)}
+		generateFields(doc, t, seen)
+		slices.Sort(doc.Required)
+
+		return doc
+	default:
+		return &document{}
+	}
+}
+
+// generateFields adds the fields of the struct t to doc, those of its
+// embedded structs too
+func generateFields(doc *document, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, options, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				generateFields(doc, ft, seen)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := generate(ft, seen)
+		optional := slices.ContainsFunc(strings.Split(options, ","), func(o string) bool {
+			return o == "omitempty" || o == "omitzero"
+		})
+
+		if !optional {
+			doc.Required = append(doc.Required, name)
+
+			// the nil pointers, slices and maps are null
+			if k := ft.Kind(); (k == reflect.Pointer || k == reflect.Slice || k == reflect.Map) && prop.Type != nil {
+				prop.Type = []any{prop.Type, "null"}
+			}
+		}
+
+		doc.Properties[name] = prop
+	}
+}
+
+func jsonType(b []byte) string {
+	switch b[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "boolean"
+	default:
+		return "number"
+	}
+}
+
+// render returns the document of the schema name in the current version
+func render(name string, t reflect.Type) []byte {
+	doc := generate(t, make(map[reflect.Type]bool))
+	doc.Schema = "https://json-schema.org/draft/2020-12/schema"
+	doc.ID = fmt.Sprintf("https://maas.io/schemas/v%d/%s.json", Version, name)
+	doc.Title = name
+
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	return append(b, '\n')
+}
+
+// fill sets every field v can set to a value which isn't zero, so that the
+// JSON of v has every property of its schema
+func fill(v reflect.Value) {
+	switch {
+	case v.Type() == timeType:
+		v.Set(reflect.ValueOf(time.Unix(1700000000, 0).UTC()))
+		return
+	case v.Kind() == reflect.Struct && v.NumField() > 0 && !v.Type().Field(0).IsExported():
+		// such as netip.Addr, whose zero value marshals fine
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem())
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				fill(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fill(v.Index(0))
+	case reflect.Map:
+		key, elem := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		fill(key)
+		fill(elem)
+		v.Set(reflect.MakeMap(v.Type()))
+		v.SetMapIndex(key, elem)
+	case reflect.String:
+		v.SetString("value")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(0.5)
+	default:
+		// the interfaces are left nil
+	}
+}
+
+// fixture returns the JSON of a value of t with every field set
+func fixture(t reflect.Type) []byte {
+	v := reflect.New(t)
+	fill(v.Elem())
+
+	b, err := json.MarshalIndent(v.Interface(), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	return append(b, '\n')
+}
+
+// versions returns the versions recorded in dir, in order
+func versions(t *testing.T, dir string) []int {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var found []int
+
+	for _, e := range entries {
+		if v, ok := strings.CutPrefix(e.Name(), "v"); ok && e.IsDir() {
+			n, err := strconv.Atoi(v)
+			require.NoError(t, err, e.Name())
+
+			found = append(found, n)
+		}
+	}
+
+	slices.Sort(found)
+
+	return found
+}
+
+// record writes the documents and the fixtures of a new version, those of
+// a recorded version never change
+func record(t *testing.T) {
+	t.Helper()
+
+	docs := filepath.Join(fmt.Sprintf("v%d", Version))
+	fixtures := filepath.Join("testdata", fmt.Sprintf("v%d", Version))
+
+	if _, err := os.Stat(docs); err == nil {
+		t.Fatalf("version %d is recorded, bump Version to change the schemas", Version)
+	}
+
+	require.NoError(t, os.MkdirAll(docs, 0o750))
+	require.NoError(t, os.MkdirAll(fixtures, 0o750))
+
+	for name, typ := range types {
+		require.NoError(t, os.WriteFile(filepath.Join(docs, name+".schema.json"), render(name, typ), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(fixtures, name+".json"), fixture(typ), 0o600))
+	}
+}
+
+// TestSchemaDocuments checks the types still make the documents of Version.
+// They only change with a new version: bump Version, describe the change in
+// CHANGELOG.md and run the test with -update.
+func TestSchemaDocuments(t *testing.T) {
+	if *update {
+		record(t)
+	}
+
+	assert.ElementsMatch(t, Names, slices.Collect(func(yield func(string) bool) {
+		for name := range types {
+			if !yield(name) {
+				return
+			}
+		}
+	}))
+
+	for _, name := range Names {
+		want, err := Document(Version, name)
+		require.NoError(t, err, "bump Version and run the test with -update")
+		assert.Equal(t, string(want), string(render(name, types[name])),
+			"the JSON of %s changed: bump Version, describe it in CHANGELOG.md and run the test with -update", name)
+	}
+}
+
+// TestSchemaAdditive checks every property of the documents of the previous
+// versions is still there, with the same type
+func TestSchemaAdditive(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range versions(t, ".") {
+		for _, name := range Names {
+			b, err := Document(v, name)
+			if err != nil {
+				// a schema added after v
+				continue
+			}
+
+			var previous, current document
+
+			require.NoError(t, json.Unmarshal(b, &previous))
+			require.NoError(t, json.Unmarshal(render(name, types[name]), &current))
+
+			assertContains(t, fmt.Sprintf("v%d %s", v, name), &previous, &current)
+		}
+	}
+}
+
+func assertContains(t *testing.T, at string, previous, current *document) {
+	t.Helper()
+
+	if !assert.NotNil(t, current, "%s was removed", at) {
+		return
+	}
+
+	assert.Equal(t, previous.Type, current.Type, "%s changed type", at)
+	assert.Subset(t, previous.Required, current.Required, "%s made fields optional", at)
+
+	for name, prop := range previous.Properties {
+		assertContains(t, at+"."+name, prop, current.Properties[name])
+	}
+
+	if previous.Items != nil {
+		assertContains(t, at+"[]", previous.Items, current.Items)
+	}
+
+	if previous.AdditionalProperties != nil {
+		assertContains(t, at+"{}", previous.AdditionalProperties, current.AdditionalProperties)
+	}
+}
+
+// TestSchemaCompatibility decodes the fixtures of every version into the
+// current types: none of their fields may be unknown, and each must be
+// encoded again
+func TestSchemaCompatibility(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range versions(t, "testdata") {
+		for _, name := range Names {
+			path := filepath.Join("testdata", fmt.Sprintf("v%d", v), name+".json")
+
+			b, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			require.NoError(t, err)
+
+			value := reflect.New(types[name])
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.DisallowUnknownFields()
+
+			require.NoError(t, dec.Decode(value.Interface()), path)
+
+			again, err := json.Marshal(value.Interface())
+			require.NoError(t, err, path)
+
+			var want, got any
+
+			require.NoError(t, json.Unmarshal(b, &want))
+			require.NoError(t, json.Unmarshal(again, &got))
+			assertSubtree(t, path, want, got)
+		}
+	}
+}
+
+// assertSubtree checks got has every value of want
+func assertSubtree(t *testing.T, at string, want, got any) {
+	t.Helper()
+
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !assert.True(t, ok, "%s isn't an object anymore", at) {
+			return
+		}
+
+		for k, v := range w {
+			assertSubtree(t, at+"."+k, v, g[k])
+		}
+	case []any:
+		g, ok := got.([]any)
+		if !assert.True(t, ok, "%s isn't an array anymore", at) || !assert.Len(t, g, len(w), at) {
+			return
+		}
+
+		for i := range w {
+			assertSubtree(t, fmt.Sprintf("%s[%d]", at, i), w[i], g[i])
+		}
+	default:
+		assert.Equal(t, want, got, at)
+	}
+}
+
+var changelogVersion = regexp.MustCompile(`(?m)^## Version (\d+)$`)
+
+// TestSchemaChangelog checks every version, and only those, is recorded and
+// described in CHANGELOG.md, the latest first
+func TestSchemaChangelog(t *testing.T) {
+	t.Parallel()
+
+	b, err := os.ReadFile("CHANGELOG.md")
+	require.NoError(t, err)
+
+	var described, want []int
+
+	for _, m := range changelogVersion.FindAllSubmatch(b, -1) {
+		v, err := strconv.Atoi(string(m[1]))
+		require.NoError(t, err)
+
+		described = append(described, v)
+	}
+
+	for v := Version; v > 0; v-- {
+		want = append(want, v)
+	}
+
+	assert.Equal(t, want, described, "CHANGELOG.md must describe every version, the latest first")
+
+	slices.Reverse(want)
+	assert.Equal(t, want, versions(t, "."), "the documents of every version must be recorded")
+	assert.Equal(t, want, versions(t, "testdata"), "the fixtures of every version must be recorded")
+}
+
+func TestSchemaNetmonDocuments(t *testing.T) {
+	t.Parallel()
+
+	// the documented schemas of netmon describe the same properties
+	testcases := map[string][]byte{
+		NameObservation:    netmon.ResultSchema,
+		NameSnapshot:       netmon.SnapshotSchema,
+		NameTopologyReport: netmon.TopologySchema,
+	}
+
+	for name, b := range testcases {
+		var documented, generated document
+
+		require.NoError(t, json.Unmarshal(b, &documented))
+		require.NoError(t, json.Unmarshal(render(name, types[name]), &generated))
+
+		assert.ElementsMatch(t, slices.Collect(mapsKeys(generated.Properties)),
+			slices.Collect(mapsKeys(documented.Properties)), name)
+	}
+}
+
+func mapsKeys(m map[string]*document) func(yield func(string) bool) {
+	return func(yield func(string) bool) {
+		for k := range m {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	t.Parallel()
+
+	b, err := Document(Version, NameEvent)
+	require.NoError(t, err)
+	assert.True(t, json.Valid(b))
+
+	_, err = Document(Version, "unknown")
+	assert.Error(t, err)
+
+	_, err = Document(Version+1, NameEvent)
+	assert.Error(t, err)
+}
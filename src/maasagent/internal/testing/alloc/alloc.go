@@ -0,0 +1,40 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package alloc enforces the allocation budgets of hot paths in tests
+package alloc
+
+import (
+	"testing"
+)
+
+// runs is the number of calls averaged by testing.AllocsPerRun
+const runs = 100
+
+// Budget fails t when f allocates more than budget times per call. The
+// allocations of the whole process are counted, so the test calling it must
+// not be parallel. The race detector allocates on its own, the budgets are
+// not checked under it.
+func Budget(t *testing.T, path string, budget int, f func()) {
+	t.Helper()
+
+	if raceEnabled {
+		t.Skip("allocations are not representative under the race detector")
+	}
+
+	if allocs := testing.AllocsPerRun(runs, f); allocs > float64(budget) {
+		t.Errorf("%s allocates %.1f times per call, its budget is %d", path, allocs, budget)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package clock provides a fake clock.Clock, whose time only moves when a
+// test advances it
+package clock
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+// Fake is a clock.Clock whose time only moves with Advance, which fires the
+// timers and tickers expiring on the way
+type Fake struct {
+	now     time.Time
+	added   *sync.Cond
+	waiters []*waiter
+	mu      sync.Mutex
+}
+
+// waiter is a pending timer, or a ticker when period is set
+type waiter struct {
+	when   time.Time
+	c      chan time.Time
+	period time.Duration
+}
+
+// NewFake returns a Fake clock starting at now
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.added = sync.NewCond(&f.mu)
+
+	return f
+}
+
+// Now returns the time of the fake clock
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// NewTimer returns a timer which fires when the clock is advanced by d
+func (f *Fake) NewTimer(d time.Duration) clock.Timer {
+	t := &fakeTimer{clock: f, waiter: &waiter{c: make(chan time.Time, 1)}}
+	f.schedule(t.waiter, d, 0)
+
+	return t
+}
+
+// NewTicker returns a ticker which fires every d the clock is advanced by
+func (f *Fake) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	t := &fakeTicker{clock: f, waiter: &waiter{c: make(chan time.Time, 1)}}
+	f.schedule(t.waiter, d, d)
+
+	return t
+}
+
+// Sleep returns once the clock is advanced by d or ctx is done
+func (f *Fake) Sleep(ctx context.Context, d time.Duration) error {
+	return clock.Sleep(ctx, f, d)
+}
+
+// Advance moves the clock forward by d, firing the timers and tickers in
+// the order they expire. Like a time.Ticker, a ticker whose tick wasn't
+// received drops the following ones.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	end := f.now.Add(d)
+
+	for {
+		i := f.next()
+		if i < 0 || f.waiters[i].when.After(end) {
+			break
+		}
+
+		w := f.waiters[i]
+		f.now = w.when
+		w.fire()
+
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+		} else {
+			f.waiters = slices.Delete(f.waiters, i, i+1)
+		}
+	}
+
+	f.now = end
+}
+
+// BlockUntil waits for at least n timers and tickers to be pending, so a
+// test can advance the clock once the code under test is waiting on it
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.waiters) < n {
+		f.added.Wait()
+	}
+}
+
+// next returns the index of the earliest waiter, or -1 if there are none
+func (f *Fake) next() int {
+	next := -1
+
+	for i, w := range f.waiters {
+		if next < 0 || w.when.Before(f.waiters[next].when) {
+			next = i
+		}
+	}
+
+	return next
+}
+
+// schedule makes w fire after d, and then every period if it is set
+func (f *Fake) schedule(w *waiter, d, period time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.when = f.now.Add(d)
+	w.period = period
+
+	// a timer which already expired fires right away, as with time.Timer
+	if d <= 0 && w.period == 0 {
+		w.fire()
+		return
+	}
+
+	f.waiters = append(f.waiters, w)
+	f.added.Broadcast()
+}
+
+// cancel removes w and returns true if it was pending
+func (f *Fake) cancel(w *waiter) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	i := slices.Index(f.waiters, w)
+	if i < 0 {
+		return false
+	}
+
+	f.waiters = slices.Delete(f.waiters, i, i+1)
+
+	return true
+}
+
+func (w *waiter) fire() {
+	select {
+	case w.c <- w.when:
+	default:
+	}
+}
+
+type fakeTimer struct {
+	clock  *Fake
+	waiter *waiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	return t.clock.cancel(t.waiter)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	active := t.clock.cancel(t.waiter)
+
+	// time.Timer no longer delivers a stale value after Reset
+	select {
+	case <-t.waiter.c:
+	default:
+	}
+
+	t.clock.schedule(t.waiter, d, 0)
+
+	return active
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	waiter *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.cancel(t.waiter)
+}
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for Ticker.Reset")
+	}
+
+	t.clock.cancel(t.waiter)
+	t.clock.schedule(t.waiter, d, d)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func received(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1700000000, 0)
+	f := NewFake(start)
+
+	timer := f.NewTimer(time.Minute)
+
+	f.Advance(59 * time.Second)
+
+	_, ok := received(timer.C())
+	assert.False(t, ok)
+
+	f.Advance(time.Second)
+
+	fired, ok := received(timer.C())
+	require.True(t, ok)
+	assert.Equal(t, start.Add(time.Minute), fired)
+	assert.False(t, timer.Stop())
+
+	assert.False(t, timer.Reset(time.Second))
+	assert.True(t, timer.Stop())
+
+	f.Advance(time.Hour)
+
+	_, ok = received(timer.C())
+	assert.False(t, ok)
+	assert.Equal(t, start.Add(time.Hour+time.Minute), f.Now())
+
+	// an expired timer fires right away
+	_, ok = received(f.NewTimer(0).C())
+	assert.True(t, ok)
+}
+
+func TestFakeTicker(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1700000000, 0)
+	f := NewFake(start)
+
+	ticker := f.NewTicker(10 * time.Second)
+	timer := f.NewTimer(25 * time.Second)
+
+	f.Advance(10 * time.Second)
+
+	tick, ok := received(ticker.C())
+	require.True(t, ok)
+	assert.Equal(t, start.Add(10*time.Second), tick)
+
+	// the ticks not received are dropped
+	f.Advance(time.Minute)
+
+	tick, ok = received(ticker.C())
+	require.True(t, ok)
+	assert.Equal(t, start.Add(20*time.Second), tick)
+
+	fired, ok := received(timer.C())
+	require.True(t, ok)
+	assert.Equal(t, start.Add(25*time.Second), fired)
+
+	ticker.Reset(time.Hour)
+	f.Advance(time.Minute)
+
+	_, ok = received(ticker.C())
+	assert.False(t, ok)
+
+	ticker.Stop()
+	f.Advance(2 * time.Hour)
+
+	_, ok = received(ticker.C())
+	assert.False(t, ok)
+}
+
+func TestFakeSleep(t *testing.T) {
+	t.Parallel()
+
+	f := NewFake(time.Unix(1700000000, 0))
+	errC := make(chan error)
+
+	go func() {
+		errC <- f.Sleep(context.Background(), time.Hour)
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(time.Hour)
+	assert.NoError(t, <-errC)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		errC <- f.Sleep(ctx, time.Hour)
+	}()
+
+	f.BlockUntil(1)
+	cancel()
+	assert.ErrorIs(t, <-errC, context.Canceled)
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package fuzz checks the invariants every decoder of untrusted input must
+// hold when fuzzed
+package fuzz
+
+import (
+	"runtime"
+	"testing"
+)
+
+const (
+	// allocFactor bounds the bytes a decoder may allocate per input byte
+	allocFactor = 64
+	// allocSlack is allowed on top, for the fixed size structures
+	// decoded from even the shortest inputs
+	allocSlack = 16 << 10
+)
+
+// Bounded runs decode and fails t if it allocated more than a fixed multiple
+// of the size of in, as happens when a length field read from the input is
+// trusted. Panics are reported by the fuzzing engine itself.
+func Bounded(t *testing.T, in []byte, decode func()) {
+	t.Helper()
+
+	var before, after runtime.MemStats
+
+	runtime.ReadMemStats(&before)
+	decode()
+	runtime.ReadMemStats(&after)
+
+	limit := uint64(allocFactor*len(in) + allocSlack)
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > limit {
+		t.Errorf("decoding %d bytes allocated %d bytes, more than %d", len(in), allocated, limit)
+	}
+}
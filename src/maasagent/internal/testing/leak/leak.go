@@ -0,0 +1,96 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package leak finds the goroutines a test leaves behind
+package leak
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// settleTime is how long goroutines get to return once the test is done
+	settleTime = 2 * time.Second
+)
+
+// Check records the running goroutines and returns a function failing t if
+// any goroutine started since is still running after settleTime. It is used
+// as defer leak.Check(t)(), by tests which don't call t.Parallel: the
+// goroutines of the tests running alongside would be reported too.
+func Check(t testing.TB) func() {
+	t.Helper()
+
+	before := goroutines()
+
+	return func() {
+		t.Helper()
+
+		var leaked []string
+
+		deadline := time.Now().Add(settleTime)
+
+		for {
+			leaked = leaked[:0]
+
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if len(leaked) > 0 {
+			t.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	}
+}
+
+// goroutines returns the stack of every goroutine by goroutine ID
+func goroutines() map[string]string {
+	buf := make([]byte, 64<<10)
+
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[string]string)
+
+	// every stack starts with "goroutine <id> [<state>]:"
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		fields := strings.Fields(stack)
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+
+		stacks[fields[1]] = stack
+	}
+
+	return stacks
+}
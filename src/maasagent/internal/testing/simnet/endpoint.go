@@ -0,0 +1,213 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package simnet
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+// delivery is a frame queued for an endpoint
+type delivery struct {
+	received time.Time
+	frame    []byte
+}
+
+// Endpoint is a port of a Segment, like a capture.Conn on an interface. It
+// is safe for one reader and several writers.
+type Endpoint struct {
+	segment *Segment
+	queue   chan delivery
+	closed  chan struct{}
+	// wake is closed when the deadline changes, mu protects both
+	wake     chan struct{}
+	deadline time.Time
+	// held is the block of the frames to shuffle, delivered by flush unless
+	// completed first, heldMu protects both
+	held      []delivery
+	flush     *time.Timer
+	ifi       net.Interface
+	queueSize int
+	mu        sync.Mutex
+	heldMu    sync.Mutex
+	once      sync.Once
+	promisc   bool
+}
+
+// EndpointOption configures an Endpoint
+type EndpointOption func(*Endpoint)
+
+// WithPromiscuous makes the endpoint receive the unicast frames of the
+// other endpoints too, as a capture in promiscuous mode
+func WithPromiscuous() EndpointOption {
+	return func(ep *Endpoint) {
+		ep.promisc = true
+	}
+}
+
+// WithQueueSize sets the number of frames the endpoint holds before the
+// next ones are overrun
+func WithQueueSize(n int) EndpointOption {
+	return func(ep *Endpoint) {
+		if n > 0 {
+			ep.queueSize = n
+		}
+	}
+}
+
+// Interface returns the interface of the endpoint, its name, index and MAC
+func (ep *Endpoint) Interface() *net.Interface {
+	return &ep.ifi
+}
+
+// reaches returns whether the endpoint receives frame
+func (ep *Endpoint) reaches(frame []byte) bool {
+	return ep.promisc || frame[0]&0x01 != 0 || bytes.Equal(frame[:6], ep.ifi.HardwareAddr)
+}
+
+// enqueue queues frame, false when the endpoint is closed or its queue full
+func (ep *Endpoint) enqueue(frame []byte, received time.Time) bool {
+	select {
+	case <-ep.closed:
+		return false
+	default:
+	}
+
+	select {
+	case ep.queue <- delivery{received: received, frame: frame}:
+		return true
+	default:
+		ep.segment.overrun.Add(1)
+		return false
+	}
+}
+
+// WriteFrame sends frame to the endpoints of the Segment it reaches
+func (ep *Endpoint) WriteFrame(frame []byte) error {
+	select {
+	case <-ep.closed:
+		return capture.ErrClosed
+	default:
+	}
+
+	return ep.segment.transmit(ep, frame)
+}
+
+// ReadFrame implements capture.FrameReader
+func (ep *Endpoint) ReadFrame(buf []byte) (int, error) {
+	md, err := ep.ReadFrameMetadata(buf)
+
+	return md.CaptureLength, err
+}
+
+// ReadFrameMetadata implements capture.MetadataReader, the frames longer
+// than buf are truncated
+func (ep *Endpoint) ReadFrameMetadata(buf []byte) (capture.Metadata, error) {
+	for {
+		ep.mu.Lock()
+		deadline, wake := ep.deadline, ep.wake
+		ep.mu.Unlock()
+
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return capture.Metadata{}, os.ErrDeadlineExceeded
+		}
+
+		md, done, err := ep.wait(buf, deadline, wake)
+		if done {
+			return md, err
+		}
+	}
+}
+
+// wait reads a frame until deadline, false when the deadline changed first
+func (ep *Endpoint) wait(buf []byte, deadline time.Time, wake <-chan struct{}) (capture.Metadata, bool, error) {
+	var expired <-chan time.Time
+
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+
+		expired = t.C
+	}
+
+	select {
+	case d := <-ep.queue:
+		return ep.metadata(d, copy(buf, d.frame)), true, nil
+	case <-ep.closed:
+		return capture.Metadata{}, true, capture.ErrClosed
+	case <-expired:
+		return capture.Metadata{}, true, os.ErrDeadlineExceeded
+	case <-wake:
+		return capture.Metadata{}, false, nil
+	}
+}
+
+func (ep *Endpoint) metadata(d delivery, n int) capture.Metadata {
+	md := capture.Metadata{
+		Timestamp:       d.received,
+		Interface:       ep.ifi.Name,
+		Ifindex:         ep.ifi.Index,
+		CaptureLength:   n,
+		Length:          len(d.frame),
+		TimestampSource: capture.TimestampSoftware,
+		Direction:       capture.DirectionInbound,
+		PacketType:      unix.PACKET_HOST,
+	}
+
+	switch {
+	case bytes.Equal(d.frame[:6], ethernet.Broadcast):
+		md.PacketType = unix.PACKET_BROADCAST
+	case d.frame[0]&0x01 != 0:
+		md.PacketType = unix.PACKET_MULTICAST
+	case !bytes.Equal(d.frame[:6], ep.ifi.HardwareAddr):
+		md.PacketType = unix.PACKET_OTHERHOST
+	}
+
+	return md
+}
+
+// SetReadDeadline implements capture.FrameReader, it interrupts a blocked
+// read
+func (ep *Endpoint) SetReadDeadline(t time.Time) error {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.deadline = t
+
+	close(ep.wake)
+	ep.wake = make(chan struct{})
+
+	return nil
+}
+
+// Close detaches the endpoint from the Segment, the frames it still holds
+// are lost
+func (ep *Endpoint) Close() error {
+	ep.once.Do(func() {
+		close(ep.closed)
+		ep.segment.detach(ep)
+	})
+
+	return nil
+}
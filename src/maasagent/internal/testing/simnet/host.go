@@ -0,0 +1,424 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package simnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/ndp"
+)
+
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+	bootpLen       = 236
+	udpHeaderLen   = 8
+	protocolUDP    = 17
+
+	dhcpOptionMask     = 1
+	dhcpOptionLease    = 51
+	dhcpOptionType     = 53
+	dhcpOptionServerID = 54
+	dhcpOptionEnd      = 255
+	dhcpDiscover       = 1
+	dhcpOffer          = 2
+
+	// dhcpLeaseTime is the lease of the offers, in seconds
+	dhcpLeaseTime = 3600
+	// offerQueueSize bounds the offers a client holds until Discover reads
+	// them
+	offerQueueSize = 64
+)
+
+var dhcpMagicCookie = []byte{99, 130, 83, 99}
+
+// Offer is a DHCP OFFER received by a Host
+type Offer struct {
+	// Server is the server identifier of the offer
+	Server netip.Addr
+	// Addr is the address offered
+	Addr netip.Addr
+	// ServerMAC is the source of the frame of the offer
+	ServerMAC net.HardwareAddr
+	XID       uint32
+}
+
+// dhcpServer offers the addresses of a pool in turn, the same one to a
+// client asking again
+type dhcpServer struct {
+	next   netip.Addr
+	addr   netip.Addr
+	leases map[[6]byte]netip.Addr
+	pool   netip.Prefix
+}
+
+// Host is a scripted host of a Segment. It replies to the ARP requests and
+// the neighbor solicitations for its addresses, offers addresses when it is
+// a DHCP server, and announces itself when told to.
+type Host struct {
+	ep     *Endpoint
+	vid    *uint16
+	dhcp   *dhcpServer
+	offers chan Offer
+	addrs  []netip.Addr
+	// mu protects the leases of dhcp
+	mu sync.Mutex
+}
+
+// HostOption configures a Host
+type HostOption func(*Host)
+
+// WithAddrs sets the IPv4 and IPv6 addresses of the host
+func WithAddrs(addrs ...netip.Addr) HostOption {
+	return func(h *Host) {
+		h.addrs = append(h.addrs, addrs...)
+	}
+}
+
+// WithHostVLAN puts the host on the VLAN vid: its frames are tagged and
+// it ignores those of the other VLANs
+func WithHostVLAN(vid uint16) HostOption {
+	return func(h *Host) {
+		h.vid = &vid
+	}
+}
+
+// WithDHCPServer makes the host a DHCP server with the address addr,
+// offering those of pool
+func WithDHCPServer(addr netip.Addr, pool netip.Prefix) HostOption {
+	return func(h *Host) {
+		pool = pool.Masked()
+		h.dhcp = &dhcpServer{
+			next:   pool.Addr().Next(),
+			addr:   addr,
+			leases: make(map[[6]byte]netip.Addr),
+			pool:   pool,
+		}
+	}
+}
+
+// AddHost attaches a Host with the MAC mac, it answers until it is closed
+// or the Segment is
+func (s *Segment) AddHost(name string, mac net.HardwareAddr, options ...HostOption) *Host {
+	h := &Host{
+		ep:     s.Attach(name, mac),
+		offers: make(chan Offer, offerQueueSize),
+	}
+
+	for _, opt := range options {
+		opt(h)
+	}
+
+	s.hosts.Add(1)
+
+	go func() {
+		defer s.hosts.Done()
+
+		h.serve()
+	}()
+
+	return h
+}
+
+// MAC returns the MAC of the host
+func (h *Host) MAC() net.HardwareAddr {
+	return h.ep.ifi.HardwareAddr
+}
+
+// Close detaches the host from its Segment
+func (h *Host) Close() error {
+	return h.ep.Close()
+}
+
+// frame returns a builder of a frame from the host, on its VLAN
+func (h *Host) frame() *ethernet.FrameBuilder {
+	b := ethernet.NewFrame().Src(h.MAC())
+	if h.vid != nil {
+		b = b.VLAN(*h.vid)
+	}
+
+	return b
+}
+
+func (h *Host) send(b *ethernet.FrameBuilder) error {
+	frame, err := b.Build()
+	if err != nil {
+		return err
+	}
+
+	return h.ep.WriteFrame(frame)
+}
+
+// Announce broadcasts a gratuitous ARP request for each IPv4 address of the
+// host, and an unsolicited neighbor advertisement for each IPv6 one
+func (h *Host) Announce() error {
+	var errs []error
+
+	for _, addr := range h.addrs {
+		if addr.Is4() {
+			errs = append(errs, h.send(h.frame().Padded().ARPRequest(addr, addr)))
+		} else {
+			errs = append(errs, h.send(h.frame().NeighborAdvertisement(addr,
+				netip.MustParseAddr("ff02::1"), ethernet.NAFlagOverride)))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Discover broadcasts a DHCP DISCOVER with the transaction ID xid and
+// returns the offers received for it until ctx is done
+func (h *Host) Discover(ctx context.Context, xid uint32) ([]Offer, error) {
+	if err := h.send(h.frame().DHCPDiscover(xid)); err != nil {
+		return nil, err
+	}
+
+	var offers []Offer
+
+	for {
+		select {
+		case <-ctx.Done():
+			return offers, nil
+		case o := <-h.offers:
+			if o.XID == xid {
+				offers = append(offers, o)
+			}
+		}
+	}
+}
+
+// serve answers the frames of the host until its endpoint is closed
+func (h *Host) serve() {
+	buf := make([]byte, 1522)
+
+	for {
+		n, err := h.ep.ReadFrame(buf)
+		if err != nil {
+			return
+		}
+
+		h.handle(buf[:n])
+	}
+}
+
+func (h *Host) handle(frame []byte) {
+	var eth ethernet.EthernetFrame
+
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		return
+	}
+
+	var stack [ethernet.MaxTags]ethernet.Tag
+
+	tags, ethType, payload, err := eth.AppendTags(stack[:0])
+	if err != nil {
+		return
+	}
+
+	// a host only sees the frames of its VLAN, outer tags don't matter
+	switch {
+	case h.vid == nil && len(tags) > 0:
+		return
+	case h.vid != nil && (len(tags) == 0 || tags[len(tags)-1].VID != *h.vid):
+		return
+	}
+
+	// the errors of the replies are those of a closed endpoint, the host
+	// is going away
+	switch ethType {
+	case ethernet.EthernetTypeARP:
+		h.handleARP(&eth) //nolint:errcheck,gosec // see above
+	case ethernet.EthernetTypeIPv6:
+		h.handleNDP(frame) //nolint:errcheck,gosec // see above
+	case ethernet.EthernetTypeIPv4:
+		h.handleDHCP(eth.SrcMAC, payload) //nolint:errcheck,gosec // see above
+	}
+}
+
+func (h *Host) handleARP(eth *ethernet.EthernetFrame) error {
+	pkt, err := eth.ExtractARPPacket()
+	if err != nil || pkt.OpCode != ethernet.OpRequest {
+		return nil
+	}
+
+	target, sender := pkt.TargetAddr(), pkt.SenderAddr()
+
+	// the announcements of others for the address aren't questions
+	if target == sender || !slices.Contains(h.addrs, target) {
+		return nil
+	}
+
+	return h.send(h.frame().Dst(pkt.SendHwAddr).Padded().ARPReply(target, pkt.SendHwAddr, sender))
+}
+
+func (h *Host) handleNDP(frame []byte) error {
+	msg, ip, err := ndp.ParseFrame(frame)
+	if err != nil || msg.Type != ndp.TypeNeighborSolicitation || !slices.Contains(h.addrs, msg.Target) {
+		return nil
+	}
+
+	// a duplicate address detection probe is answered to all the nodes
+	if ip.Src.IsUnspecified() {
+		return h.send(h.frame().NeighborAdvertisement(msg.Target, netip.MustParseAddr("ff02::1"),
+			ethernet.NAFlagOverride))
+	}
+
+	dst := msg.LinkLayerAddr
+	if dst == nil {
+		return nil
+	}
+
+	return h.send(h.frame().Dst(dst).NeighborAdvertisement(msg.Target, ip.Src,
+		ethernet.NAFlagSolicited|ethernet.NAFlagOverride))
+}
+
+func (h *Host) handleDHCP(src net.HardwareAddr, pkt []byte) error {
+	port, msg, ok := udpPayload(pkt)
+	if !ok || len(msg) < bootpLen+len(dhcpMagicCookie) {
+		return nil
+	}
+
+	msgType, serverID := dhcpOptions(msg[bootpLen+len(dhcpMagicCookie):])
+
+	switch {
+	case port == dhcpServerPort && msgType == dhcpDiscover && h.dhcp != nil:
+		return h.offer(msg)
+	case port == dhcpClientPort && msgType == dhcpOffer && bytes.Equal(msg[28:34], h.MAC()):
+		o := Offer{
+			Server:    serverID,
+			Addr:      netip.AddrFrom4([4]byte(msg[16:20])),
+			ServerMAC: slices.Clone(src),
+			XID:       binary.BigEndian.Uint32(msg[4:8]),
+		}
+
+		select {
+		case h.offers <- o:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// offer answers the DISCOVER msg with the address of the client, a new one
+// unless the pool is exhausted
+func (h *Host) offer(msg []byte) error {
+	chaddr := [6]byte(msg[28:34])
+
+	h.mu.Lock()
+
+	addr, ok := h.dhcp.leases[chaddr]
+	if !ok {
+		for h.dhcp.next == h.dhcp.addr {
+			h.dhcp.next = h.dhcp.next.Next()
+		}
+
+		addr = h.dhcp.next
+		if !h.dhcp.pool.Contains(addr) {
+			h.mu.Unlock()
+			return nil
+		}
+
+		h.dhcp.leases[chaddr] = addr
+		h.dhcp.next = addr.Next()
+	}
+
+	h.mu.Unlock()
+
+	reply := make([]byte, bootpLen, bootpLen+32)
+	reply[0] = 2 // BOOTREPLY
+	copy(reply[1:8], msg[1:8])
+	copy(reply[10:12], msg[10:12])
+	copy(reply[16:20], addr.AsSlice())
+	copy(reply[20:24], h.dhcp.addr.AsSlice())
+	copy(reply[28:44], msg[28:44])
+
+	mask := net.CIDRMask(h.dhcp.pool.Bits(), 32)
+	lease := binary.BigEndian.AppendUint32(nil, dhcpLeaseTime)
+
+	reply = append(reply, dhcpMagicCookie...)
+	reply = append(reply, dhcpOptionType, 1, dhcpOffer)
+	reply = append(append(reply, dhcpOptionServerID, 4), h.dhcp.addr.AsSlice()...)
+	reply = append(append(reply, dhcpOptionMask, 4), mask...)
+	reply = append(append(reply, dhcpOptionLease, 4), lease...)
+	reply = append(reply, dhcpOptionEnd)
+
+	return h.send(h.frame().UDP(netip.AddrPortFrom(h.dhcp.addr, dhcpServerPort),
+		netip.AddrPortFrom(netip.AddrFrom4([4]byte{255, 255, 255, 255}), dhcpClientPort), reply))
+}
+
+// udpPayload returns the destination port and the payload of the UDP
+// datagram of the IPv4 packet pkt
+func udpPayload(pkt []byte) (uint16, []byte, bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != protocolUDP {
+		return 0, nil, false
+	}
+
+	ihl := int(pkt[0]&0x0f) * 4
+	if len(pkt) < ihl+udpHeaderLen {
+		return 0, nil, false
+	}
+
+	udp := pkt[ihl:]
+	length := int(binary.BigEndian.Uint16(udp[4:6]))
+
+	if length < udpHeaderLen || length > len(udp) {
+		return 0, nil, false
+	}
+
+	return binary.BigEndian.Uint16(udp[2:4]), udp[udpHeaderLen:length], true
+}
+
+// dhcpOptions returns the message type and the server identifier of the
+// options following the magic cookie
+func dhcpOptions(options []byte) (byte, netip.Addr) {
+	var (
+		msgType  byte
+		serverID netip.Addr
+	)
+
+	for len(options) > 0 && options[0] != dhcpOptionEnd {
+		if options[0] == 0 {
+			options = options[1:]
+			continue
+		}
+
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			break
+		}
+
+		value := options[2 : 2+int(options[1])]
+
+		switch {
+		case options[0] == dhcpOptionType && len(value) == 1:
+			msgType = value[0]
+		case options[0] == dhcpOptionServerID && len(value) == 4:
+			serverID = netip.AddrFrom4([4]byte(value))
+		}
+
+		options = options[2+len(value):]
+	}
+
+	return msgType, serverID
+}
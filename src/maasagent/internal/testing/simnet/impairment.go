@@ -0,0 +1,211 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package simnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Impairment is what a Segment does to the frames it delivers, drawn from
+// its seed: the frames written in the same order meet the same fate. The
+// delays are in real time, the order of the frames of different flows
+// delayed by them isn't deterministic.
+type Impairment struct {
+	// Seed seeds the draws, 1 by default
+	Seed uint64
+	// Loss is the probability, from 0 to 1, of a delivery being lost
+	Loss float64
+	// Duplicate is the probability, from 0 to 1, of a delivery being made
+	// twice
+	Duplicate float64
+	// ReorderWindow shuffles the deliveries to each endpoint within blocks
+	// of that many frames, a frame is never displaced further. A block
+	// the writers don't complete is delivered after the reordering delay
+	// of WithReordering. 0 and 1 keep the order.
+	ReorderWindow int
+	// Delays hold back the frames of some flows
+	Delays []FlowDelay
+}
+
+// FlowDelay delays the deliveries of the frames of a flow, those matching
+// every field set
+type FlowDelay struct {
+	Src       net.HardwareAddr
+	Dst       net.HardwareAddr
+	EtherType uint16
+	Delay     time.Duration
+}
+
+// matches returns whether frame is of the flow
+func (f FlowDelay) matches(frame []byte) bool {
+	switch {
+	case len(f.Dst) > 0 && !bytes.Equal(frame[:6], f.Dst):
+		return false
+	case len(f.Src) > 0 && !bytes.Equal(frame[6:12], f.Src):
+		return false
+	case f.EtherType != 0 && binary.BigEndian.Uint16(frame[12:14]) != f.EtherType:
+		return false
+	}
+
+	return true
+}
+
+func (f FlowDelay) String() string {
+	var fields []string
+
+	if len(f.Src) > 0 {
+		fields = append(fields, "src="+f.Src.String())
+	}
+
+	if len(f.Dst) > 0 {
+		fields = append(fields, "dst="+f.Dst.String())
+	}
+
+	if f.EtherType != 0 {
+		fields = append(fields, fmt.Sprintf("ethertype=%#04x", f.EtherType))
+	}
+
+	return strings.Join(append(fields, "delay="+f.Delay.String()), " ")
+}
+
+// String returns the impairment on a line, for the failures to tell how to
+// reproduce them
+func (i Impairment) String() string {
+	s := fmt.Sprintf("seed=%d loss=%g duplicate=%g reorder_window=%d", i.Seed, i.Loss, i.Duplicate,
+		i.ReorderWindow)
+
+	for _, d := range i.Delays {
+		s += " delay{" + d.String() + "}"
+	}
+
+	return s
+}
+
+// WithImpairment impairs the deliveries of the Segment with i, in place of
+// the seed and the loss of WithSeed and WithLoss
+func WithImpairment(i Impairment) SegmentOption {
+	return func(s *Segment) {
+		if i.Seed == 0 {
+			i.Seed = 1
+		}
+
+		WithSeed(i.Seed)(s)
+		WithLoss(i.Loss)(s)
+
+		if i.Duplicate >= 0 && i.Duplicate <= 1 {
+			s.duplicate = i.Duplicate
+		}
+
+		s.window = max(i.ReorderWindow, 0)
+		s.delays = slices.Clone(i.Delays)
+	}
+}
+
+// Impairment returns the impairment of the Segment
+func (s *Segment) Impairment() Impairment {
+	return Impairment{
+		Seed:          s.seed,
+		Loss:          s.loss,
+		Duplicate:     s.duplicate,
+		ReorderWindow: s.window,
+		Delays:        slices.Clone(s.delays),
+	}
+}
+
+// ReportOnFailure logs the impairment and the counters of the Segment when
+// tb fails, for the scenario to be run again
+func (s *Segment) ReportOnFailure(tb testing.TB) {
+	tb.Helper()
+
+	tb.Cleanup(func() {
+		if tb.Failed() {
+			tb.Logf("simnet impairment: %s", s.Impairment())
+			tb.Logf("simnet stats: %+v", s.Stats())
+		}
+	})
+}
+
+// flowDelay returns the delay of the flow of frame
+func (s *Segment) flowDelay(frame []byte) time.Duration {
+	for _, d := range s.delays {
+		if d.matches(frame) {
+			return d.Delay
+		}
+	}
+
+	return 0
+}
+
+// hold queues d in the block of ep, and delivers the block shuffled once
+// complete or once the reordering delay passed
+func (s *Segment) hold(ep *Endpoint, d delivery) {
+	ep.heldMu.Lock()
+	defer ep.heldMu.Unlock()
+
+	ep.held = append(ep.held, d)
+
+	if len(ep.held) >= s.window {
+		s.release(ep)
+		return
+	}
+
+	if ep.flush == nil {
+		ep.flush = time.AfterFunc(s.reorderDelay, func() {
+			ep.heldMu.Lock()
+			defer ep.heldMu.Unlock()
+
+			s.release(ep)
+		})
+	}
+}
+
+// release delivers the block of ep shuffled, ep.heldMu must be held
+func (s *Segment) release(ep *Endpoint) {
+	if ep.flush != nil {
+		ep.flush.Stop()
+		ep.flush = nil
+	}
+
+	order := make([]int, len(ep.held))
+	for i := range order {
+		order[i] = i
+	}
+
+	s.mu.Lock()
+	s.rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	s.mu.Unlock()
+
+	for pos, i := range order {
+		if pos != i {
+			s.reordered.Add(1)
+		}
+
+		if ep.enqueue(ep.held[i].frame, ep.held[i].received) {
+			s.delivered.Add(1)
+		}
+
+		s.inflight.Add(-1)
+	}
+
+	ep.held = ep.held[:0]
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package simnet
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// impaired writes frames numbered frames from a to b on a Segment impaired
+// with i, and returns their numbers in the order b reads them once settled
+func impaired(t *testing.T, i Impairment, frames int) ([]uint16, Stats) {
+	t.Helper()
+
+	seg := NewSegment(WithImpairment(i))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	seg.ReportOnFailure(t)
+
+	a := seg.Attach("a", macA)
+	b := seg.Attach("b", macB)
+
+	for n := range uint16(frames) { //nolint:gosec // a few hundred at most
+		require.NoError(t, a.WriteFrame(numbered(t, macA, n)))
+	}
+
+	require.Eventually(t, seg.Settled, time.Second, time.Millisecond)
+
+	st := seg.Stats()
+	order := make([]uint16, 0, st.Delivered)
+
+	for range st.Delivered {
+		frame, _ := read(t, b)
+		order = append(order, binary.BigEndian.Uint16(frame[14:16]))
+	}
+
+	return order, st
+}
+
+func TestSegmentDuplicate(t *testing.T) {
+	t.Parallel()
+
+	order, st := impaired(t, Impairment{Seed: 4, Duplicate: 0.2}, 100)
+
+	assert.Greater(t, st.Duplicated, uint64(5))
+	assert.Len(t, order, 100+int(st.Duplicated)) //nolint:gosec // at most 100
+
+	// a duplicate follows its original
+	for i := 1; i < len(order); i++ {
+		assert.LessOrEqual(t, order[i-1], order[i])
+	}
+
+	again, _ := impaired(t, Impairment{Seed: 4, Duplicate: 0.2}, 100)
+	assert.Equal(t, order, again, "the same seed duplicates the same frames")
+}
+
+func TestSegmentReorderWindow(t *testing.T) {
+	t.Parallel()
+
+	const window = 4
+
+	order, st := impaired(t, Impairment{Seed: 5, ReorderWindow: window}, 50)
+
+	require.Len(t, order, 50)
+	assert.NotZero(t, st.Reordered)
+
+	// every frame arrives within its block
+	for pos, n := range order {
+		assert.Equal(t, pos/window, int(n)/window, "frame %d delivered at %d", n, pos)
+	}
+
+	again, _ := impaired(t, Impairment{Seed: 5, ReorderWindow: window}, 50)
+	assert.Equal(t, order, again, "the same seed shuffles the same blocks")
+}
+
+func TestSegmentFlowDelay(t *testing.T) {
+	t.Parallel()
+
+	seg := NewSegment(WithImpairment(Impairment{
+		Delays: []FlowDelay{{Src: macA, Delay: 30 * time.Millisecond}},
+	}))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	a := seg.Attach("a", macA)
+	b := seg.Attach("b", macB)
+	c := seg.Attach("c", macC)
+
+	start := time.Now()
+
+	require.NoError(t, a.WriteFrame(numbered(t, macA, 0)))
+	require.NoError(t, b.WriteFrame(numbered(t, macB, 1)))
+
+	// the frame of b overtakes that of the delayed flow
+	frame, _ := read(t, c)
+	assert.Equal(t, uint16(1), binary.BigEndian.Uint16(frame[14:16]))
+
+	frame, _ = read(t, c)
+	assert.Equal(t, uint16(0), binary.BigEndian.Uint16(frame[14:16]))
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+}
+
+func TestImpairmentString(t *testing.T) {
+	t.Parallel()
+
+	seg := NewSegment(WithImpairment(Impairment{
+		Loss:          0.1,
+		Duplicate:     0.05,
+		ReorderWindow: 8,
+		Delays:        []FlowDelay{{Src: macA, EtherType: 0x0806, Delay: 10 * time.Millisecond}},
+	}))
+
+	assert.Equal(t, "seed=1 loss=0.1 duplicate=0.05 reorder_window=8 "+
+		"delay{src=52:54:00:00:00:0a ethertype=0x0806 delay=10ms}", seg.Impairment().String())
+	assert.Equal(t, "seed=9 loss=0 duplicate=0 reorder_window=0",
+		NewSegment(WithSeed(9)).Impairment().String())
+}
+
+func TestSegmentSettled(t *testing.T) {
+	t.Parallel()
+
+	seg := NewSegment(WithLatency(20*time.Millisecond, 0))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	a := seg.Attach("a", macA)
+	b := seg.Attach("b", macB)
+
+	assert.True(t, seg.Settled())
+
+	require.NoError(t, a.WriteFrame(numbered(t, macA, 0)))
+	assert.False(t, seg.Settled(), "the delivery is delayed")
+
+	read(t, b)
+	assert.Eventually(t, seg.Settled, time.Second, time.Millisecond)
+}
@@ -0,0 +1,362 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package simnet_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/discovery"
+	"maas.io/core/src/maasagent/internal/journal"
+	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+	"maas.io/core/src/maasagent/internal/testing/simnet"
+)
+
+var rackMAC = net.HardwareAddr{0x52, 0x54, 0x00, 0xff, 0x00, 0x01}
+
+func hostMAC(i int) net.HardwareAddr {
+	return net.HardwareAddr{0x52, 0x54, 0x00, 0x01, byte(i >> 8), byte(i)}
+}
+
+// monitor runs a Multiplexer observing eth0 of seg until stop is called,
+// once its capture is attached
+func monitor(t *testing.T, seg *simnet.Segment) (<-chan discovery.Event, func()) {
+	t.Helper()
+
+	eventC := make(chan discovery.Event, 16)
+
+	return eventC, monitorFunc(t, seg, func(ev discovery.Event) { eventC <- ev })
+}
+
+// monitorFunc runs a Multiplexer observing eth0 of seg, handing its events
+// to handler, until stop is called, once its capture is attached
+func monitorFunc(t *testing.T, seg *simnet.Segment, handler func(discovery.Event)) func() {
+	t.Helper()
+
+	attached := make(chan struct{})
+
+	m := discovery.NewMultiplexer(discovery.WithFrameSource(func(iface string) (capture.FrameReader, error) {
+		defer close(attached)
+
+		return seg.Attach(iface, rackMAC, simnet.WithPromiscuous()), nil
+	}))
+
+	require.NoError(t, m.Subscribe("test", handler))
+	require.NoError(t, m.ApplyProfiles(map[string]discovery.Profile{"eth0": {Promiscuous: true}}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		assert.NoError(t, m.Run(ctx))
+	}()
+
+	<-attached
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func next(t *testing.T, eventC <-chan discovery.Event) discovery.Event {
+	t.Helper()
+
+	select {
+	case ev := <-eventC:
+		return ev
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no event")
+	}
+
+	return discovery.Event{}
+}
+
+// TestScenarioConflict has two hosts claim the same address on a lossless
+// segment with some latency, the monitor sees the binding move between them
+func TestScenarioConflict(t *testing.T) {
+	defer leak.Check(t)()
+
+	seg := simnet.NewSegment(simnet.WithLatency(time.Millisecond, time.Millisecond), simnet.WithSeed(1))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	eventC, stop := monitor(t, seg)
+	defer stop()
+
+	ip := netip.MustParseAddr("10.0.0.5")
+	a := seg.AddHost("a", hostMAC(1), simnet.WithAddrs(ip))
+	b := seg.AddHost("b", hostMAC(2), simnet.WithAddrs(ip))
+
+	require.NoError(t, a.Announce())
+
+	ev := next(t, eventC)
+	assert.Equal(t, "eth0", ev.Interface)
+	assert.Equal(t, netmon.EventNew, ev.Event)
+	assert.Equal(t, ip.String(), ev.IP)
+	assert.Equal(t, hostMAC(1).String(), ev.MAC)
+
+	require.NoError(t, b.Announce())
+
+	ev = next(t, eventC)
+	assert.Equal(t, netmon.EventMoved, ev.Event)
+	assert.Equal(t, ip.String(), ev.IP)
+	assert.Equal(t, hostMAC(2).String(), ev.MAC)
+	assert.Equal(t, hostMAC(1).String(), ev.PreviousMAC)
+}
+
+// TestScenarioRogueDHCP has a second server answer the DHCPDISCOVER of a
+// client, which is offered an address by both
+func TestScenarioRogueDHCP(t *testing.T) {
+	defer leak.Check(t)()
+
+	seg := simnet.NewSegment(simnet.WithLatency(time.Millisecond, 0))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	server := netip.MustParseAddr("10.0.0.1")
+	rogue := netip.MustParseAddr("192.168.1.1")
+
+	seg.AddHost("server", hostMAC(1), simnet.WithAddrs(server),
+		simnet.WithDHCPServer(server, netip.MustParsePrefix("10.0.0.0/24")))
+	seg.AddHost("rogue", hostMAC(2), simnet.WithAddrs(rogue),
+		simnet.WithDHCPServer(rogue, netip.MustParsePrefix("192.168.1.0/24")))
+
+	client := seg.AddHost("client", hostMAC(3))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	offers, err := client.Discover(ctx, 0x1234)
+	require.NoError(t, err)
+
+	servers := make(map[netip.Addr]netip.Addr)
+
+	for _, o := range offers {
+		assert.Equal(t, uint32(0x1234), o.XID)
+
+		servers[o.Server] = o.Addr
+	}
+
+	assert.Equal(t, map[netip.Addr]netip.Addr{
+		server: netip.MustParseAddr("10.0.0.2"),
+		rogue:  netip.MustParseAddr("192.168.1.2"),
+	}, servers)
+}
+
+// TestScenarioScan probes a segment of 1000 hosts, the scan finds them all
+// though their replies are reordered
+func TestScenarioScan(t *testing.T) {
+	defer leak.Check(t)()
+
+	const hosts = 1000
+
+	seg := simnet.NewSegment(simnet.WithReordering(0.05, 5*time.Millisecond), simnet.WithSeed(2))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	base := netip.MustParseAddr("10.1.0.0")
+	ips := make([]netip.Addr, 0, hosts)
+	want := make(map[netip.Addr]net.HardwareAddr, hosts)
+
+	ip := base
+	for i := range hosts {
+		ip = ip.Next()
+		ips = append(ips, ip)
+		want[ip] = hostMAC(i)
+
+		seg.AddHost(fmt.Sprintf("host%d", i), hostMAC(i), simnet.WithAddrs(ip))
+	}
+
+	ep := seg.Attach("eth0", rackMAC)
+	defer ep.Close() //nolint:errcheck // never fails
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	got, err := netmon.ScanConn(ctx, ep, netip.MustParseAddr("10.1.255.254"), nil, ips)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+// impairment is that of the scenarios run impaired, the loss and the
+// duplicates draw on every delivery
+var impairment = simnet.Impairment{Seed: 7, Loss: 0.03, Duplicate: 0.05, ReorderWindow: 4}
+
+// noEvent fails if eventC has an event before the segment settles
+func noEvent(t *testing.T, seg *simnet.Segment, eventC <-chan discovery.Event) {
+	t.Helper()
+
+	require.Eventually(t, seg.Settled, 5*time.Second, time.Millisecond)
+
+	select {
+	case ev := <-eventC:
+		assert.Failf(t, "unexpected event", "%+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestScenarioScanImpaired probes hosts on a segment losing, duplicating
+// and reordering the frames, the retries of the scan find them all
+func TestScenarioScanImpaired(t *testing.T) {
+	defer leak.Check(t)()
+
+	const hosts = 50
+
+	seg := simnet.NewSegment(simnet.WithImpairment(impairment))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	seg.ReportOnFailure(t)
+
+	base := netip.MustParseAddr("10.1.0.0")
+	ips := make([]netip.Addr, 0, hosts)
+	want := make(map[netip.Addr]net.HardwareAddr, hosts)
+
+	ip := base
+	for i := range hosts {
+		ip = ip.Next()
+		ips = append(ips, ip)
+		want[ip] = hostMAC(i)
+
+		seg.AddHost(fmt.Sprintf("host%d", i), hostMAC(i), simnet.WithAddrs(ip))
+	}
+
+	ep := seg.Attach("eth0", rackMAC)
+	defer ep.Close() //nolint:errcheck // never fails
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pace := netmon.NewAdaptiveRate(netmon.ScanRateConfig{Initial: 1000, Ceiling: 1000, Retries: 4})
+
+	got, err := netmon.ScanConnPaced(ctx, ep, netip.MustParseAddr("10.1.255.254"), nil, ips, pace)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.NotZero(t, seg.Stats().Lost, "the scan had probes or replies to retry")
+}
+
+// TestScenarioConflictImpaired has two hosts claim the same address in
+// turn, announcing it several times on an impaired segment: the monitor
+// sees the binding move between them exactly once
+func TestScenarioConflictImpaired(t *testing.T) {
+	defer leak.Check(t)()
+
+	seg := simnet.NewSegment(simnet.WithImpairment(impairment))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	seg.ReportOnFailure(t)
+
+	eventC, stop := monitor(t, seg)
+	defer stop()
+
+	ip := netip.MustParseAddr("10.0.0.5")
+	a := seg.AddHost("a", hostMAC(1), simnet.WithAddrs(ip))
+	b := seg.AddHost("b", hostMAC(2), simnet.WithAddrs(ip))
+
+	for range 8 {
+		require.NoError(t, a.Announce())
+	}
+
+	ev := next(t, eventC)
+	assert.Equal(t, netmon.EventNew, ev.Event)
+	assert.Equal(t, hostMAC(1).String(), ev.MAC)
+
+	noEvent(t, seg, eventC)
+
+	for range 8 {
+		require.NoError(t, b.Announce())
+	}
+
+	ev = next(t, eventC)
+	assert.Equal(t, netmon.EventMoved, ev.Event)
+	assert.Equal(t, hostMAC(2).String(), ev.MAC)
+	assert.Equal(t, hostMAC(1).String(), ev.PreviousMAC)
+
+	noEvent(t, seg, eventC)
+}
+
+// TestScenarioJournalImpaired journals the events of hosts announcing
+// themselves on an impaired segment: every host is journaled once, the
+// duplicates being coalesced, and the sequence has no gaps
+func TestScenarioJournalImpaired(t *testing.T) {
+	defer leak.Check(t)()
+
+	const hosts = 20
+
+	seg := simnet.NewSegment(simnet.WithImpairment(impairment))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	seg.ReportOnFailure(t)
+
+	j, err := journal.Open(t.TempDir() + "/events")
+	require.NoError(t, err)
+
+	defer j.Close() //nolint:errcheck // the test fails earlier if it does
+
+	stop := monitorFunc(t, seg, func(ev discovery.Event) {
+		line, err := json.Marshal(ev)
+		if assert.NoError(t, err) {
+			_, err = j.Append(line)
+			assert.NoError(t, err)
+		}
+	})
+
+	ip := netip.MustParseAddr("10.2.0.0")
+	want := make(map[string]string, hosts)
+
+	for i := range hosts {
+		ip = ip.Next()
+		want[ip.String()] = hostMAC(i).String()
+
+		h := seg.AddHost(fmt.Sprintf("host%d", i), hostMAC(i), simnet.WithAddrs(ip))
+
+		for range 4 {
+			require.NoError(t, h.Announce())
+		}
+	}
+
+	require.Eventually(t, func() bool { return j.Last() >= hosts }, 5*time.Second, time.Millisecond)
+	require.Eventually(t, seg.Settled, 5*time.Second, time.Millisecond)
+
+	stop()
+
+	records, err := j.Replay()
+	require.NoError(t, err)
+
+	got := make(map[string]string, len(records))
+
+	for i, r := range records {
+		assert.Equal(t, uint64(i+1), r.Seq) //nolint:gosec // a few records
+
+		var ev discovery.Event
+
+		require.NoError(t, json.Unmarshal(r.Data, &ev))
+		assert.Equal(t, netmon.EventNew, ev.Event, "%s at %d", ev.IP, r.Seq)
+
+		got[ev.IP] = ev.MAC
+	}
+
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,316 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package simnet is an in-process ethernet segment for the tests which
+// can't open raw sockets. Its endpoints are capture.FrameReader and
+// capture.FrameWriter pairs, the frames written to one are delivered to the
+// others as a switch would, with the latency, loss and reordering of the
+// Segment and its Impairment. Scripted hosts answer ARP, NDP and DHCP for their addresses.
+package simnet
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/clock"
+)
+
+const (
+	defaultQueueSize    = 4096
+	defaultReorderDelay = 5 * time.Millisecond
+	headerLen           = 14
+)
+
+var (
+	// ErrShortFrame is returned when writing a frame without a whole
+	// ethernet header
+	ErrShortFrame = errors.New("frame shorter than an ethernet header")
+)
+
+// Stats are the counters of a Segment, a frame delivered to several
+// endpoints counts once per endpoint
+type Stats struct {
+	// Sent is the number of frames written to the endpoints
+	Sent uint64 `json:"sent"`
+	// Delivered is the number of frames queued for an endpoint
+	Delivered uint64 `json:"delivered"`
+	// Lost is the number of frames the loss of the Segment discarded
+	Lost uint64 `json:"lost"`
+	// Reordered is the number of frames held back for the next ones to
+	// overtake, or shuffled within a reordering window
+	Reordered uint64 `json:"reordered"`
+	// Duplicated is the number of deliveries made twice
+	Duplicated uint64 `json:"duplicated"`
+	// Overrun is the number of frames discarded because the queue of the
+	// endpoint was full, as a socket buffer would
+	Overrun uint64 `json:"overrun"`
+}
+
+// Segment is a broadcast domain: the broadcast and multicast frames reach
+// every endpoint, the unicast ones their destination and the promiscuous
+// endpoints. Endpoints don't receive the frames they write.
+type Segment struct {
+	clock        clock.Clock
+	rng          *rand.Rand
+	endpoints    []*Endpoint
+	delays       []FlowDelay
+	hosts        sync.WaitGroup
+	latency      time.Duration
+	jitter       time.Duration
+	reorderDelay time.Duration
+	seed         uint64
+	loss         float64
+	reorder      float64
+	duplicate    float64
+	window       int
+	sent         atomic.Uint64
+	delivered    atomic.Uint64
+	lost         atomic.Uint64
+	reordered    atomic.Uint64
+	duplicated   atomic.Uint64
+	overrun      atomic.Uint64
+	// inflight is the number of deliveries delayed or held back
+	inflight atomic.Int64
+	// mu protects the endpoints, replaced rather than modified, and rng
+	mu        sync.Mutex
+	lastIndex int
+}
+
+// SegmentOption configures a Segment
+type SegmentOption func(*Segment)
+
+// WithLatency delays every delivery by d, plus up to jitter. The delays
+// are in real time, whatever the clock of the Segment.
+func WithLatency(d, jitter time.Duration) SegmentOption {
+	return func(s *Segment) {
+		if d >= 0 {
+			s.latency = d
+		}
+
+		if jitter >= 0 {
+			s.jitter = jitter
+		}
+	}
+}
+
+// WithLoss sets the probability, from 0 to 1, of a delivery being lost
+func WithLoss(p float64) SegmentOption {
+	return func(s *Segment) {
+		if p >= 0 && p <= 1 {
+			s.loss = p
+		}
+	}
+}
+
+// WithReordering sets the probability, from 0 to 1, of a delivery being
+// held back by delay, 5ms by default, for the next ones to overtake it
+func WithReordering(p float64, delay time.Duration) SegmentOption {
+	return func(s *Segment) {
+		if p >= 0 && p <= 1 {
+			s.reorder = p
+		}
+
+		if delay > 0 {
+			s.reorderDelay = delay
+		}
+	}
+}
+
+// WithSeed seeds the draws of the loss, the reordering and the jitter, so
+// that a test sees the same fate for the same frames
+func WithSeed(seed uint64) SegmentOption {
+	return func(s *Segment) {
+		s.seed = seed
+		s.rng = rand.New(rand.NewPCG(seed, seed)) //nolint:gosec // the draws needn't be secure
+	}
+}
+
+// WithClock sets the clock timestamping the frames delivered
+func WithClock(c clock.Clock) SegmentOption {
+	return func(s *Segment) {
+		s.clock = c
+	}
+}
+
+// NewSegment returns a Segment without endpoints
+func NewSegment(options ...SegmentOption) *Segment {
+	s := &Segment{
+		clock:        clock.System{},
+		rng:          rand.New(rand.NewPCG(1, 1)), //nolint:gosec // the draws needn't be secure
+		reorderDelay: defaultReorderDelay,
+		seed:         1,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+// Stats returns the counters of the Segment
+func (s *Segment) Stats() Stats {
+	return Stats{
+		Sent:       s.sent.Load(),
+		Delivered:  s.delivered.Load(),
+		Lost:       s.lost.Load(),
+		Reordered:  s.reordered.Load(),
+		Duplicated: s.duplicated.Load(),
+		Overrun:    s.overrun.Load(),
+	}
+}
+
+// Settled returns true once every frame written was delivered or lost,
+// none being delayed or held back for reordering
+func (s *Segment) Settled() bool {
+	return s.inflight.Load() == 0
+}
+
+// Close closes every endpoint and waits for the hosts to stop
+func (s *Segment) Close() error {
+	s.mu.Lock()
+	endpoints := s.endpoints
+	s.mu.Unlock()
+
+	for _, ep := range endpoints {
+		ep.Close() //nolint:errcheck,gosec // closing an endpoint never fails
+	}
+
+	s.hosts.Wait()
+
+	return nil
+}
+
+// Attach connects an endpoint named name with the MAC mac, it is detached
+// once closed
+func (s *Segment) Attach(name string, mac net.HardwareAddr, options ...EndpointOption) *Endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastIndex++
+
+	ep := &Endpoint{
+		segment:   s,
+		ifi:       net.Interface{Index: s.lastIndex, MTU: 1500, Name: name, HardwareAddr: slices.Clone(mac)},
+		queueSize: defaultQueueSize,
+		closed:    make(chan struct{}),
+		wake:      make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt(ep)
+	}
+
+	ep.queue = make(chan delivery, ep.queueSize)
+	s.endpoints = append(slices.Clip(s.endpoints), ep)
+
+	return ep
+}
+
+// detach removes ep from the endpoints
+func (s *Segment) detach(ep *Endpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.endpoints = slices.DeleteFunc(slices.Clone(s.endpoints), func(e *Endpoint) bool { return e == ep })
+}
+
+// transmit delivers frame, written to from, to the endpoints it reaches
+func (s *Segment) transmit(from *Endpoint, frame []byte) error {
+	if len(frame) < headerLen {
+		return ErrShortFrame
+	}
+
+	s.sent.Add(1)
+
+	// the receivers share the copy, they only read it
+	frame = bytes.Clone(frame)
+
+	s.mu.Lock()
+	endpoints := s.endpoints
+	s.mu.Unlock()
+
+	for _, ep := range endpoints {
+		if ep == from || !ep.reaches(frame) {
+			continue
+		}
+
+		delay, copies := s.fate(frame)
+		s.inflight.Add(int64(copies))
+
+		for range copies {
+			if delay == 0 {
+				s.deliver(ep, frame)
+				continue
+			}
+
+			time.AfterFunc(delay, func() { s.deliver(ep, frame) })
+		}
+	}
+
+	return nil
+}
+
+// fate draws the delay of a delivery of frame and how many times it is
+// made, 0 when it is lost
+func (s *Segment) fate(frame []byte) (time.Duration, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.loss > 0 && s.rng.Float64() < s.loss {
+		s.lost.Add(1)
+		return 0, 0
+	}
+
+	copies := 1
+
+	if s.duplicate > 0 && s.rng.Float64() < s.duplicate {
+		s.duplicated.Add(1)
+
+		copies++
+	}
+
+	delay := s.latency + s.flowDelay(frame)
+	if s.jitter > 0 {
+		delay += time.Duration(s.rng.Int64N(int64(s.jitter) + 1))
+	}
+
+	if s.reorder > 0 && s.rng.Float64() < s.reorder {
+		s.reordered.Add(1)
+
+		delay += s.reorderDelay
+	}
+
+	return delay, copies
+}
+
+func (s *Segment) deliver(ep *Endpoint, frame []byte) {
+	if s.window > 1 {
+		s.hold(ep, delivery{received: s.clock.Now(), frame: frame})
+		return
+	}
+
+	if ep.enqueue(frame, s.clock.Now()) {
+		s.delivered.Add(1)
+	}
+
+	s.inflight.Add(-1)
+}
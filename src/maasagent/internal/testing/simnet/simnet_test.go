@@ -0,0 +1,410 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package simnet
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"maas.io/core/src/maasagent/internal/capture"
+	"maas.io/core/src/maasagent/internal/ethernet"
+	"maas.io/core/src/maasagent/internal/ndp"
+	"maas.io/core/src/maasagent/internal/testing/leak"
+)
+
+var (
+	macA = net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x0a}
+	macB = net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x0b}
+	macC = net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x0c}
+)
+
+func build(tb testing.TB, b *ethernet.FrameBuilder) []byte {
+	tb.Helper()
+
+	frame, err := b.Build()
+	require.NoError(tb, err)
+
+	return frame
+}
+
+// numbered returns a broadcast frame from mac carrying n
+func numbered(tb testing.TB, mac net.HardwareAddr, n uint16) []byte {
+	tb.Helper()
+
+	return build(tb, ethernet.NewFrame().Src(mac).Padded().
+		Payload(ethernet.EthernetType(0x88b5), binary.BigEndian.AppendUint16(nil, n)))
+}
+
+// read reads a frame from ep, failing after a second
+func read(tb testing.TB, ep *Endpoint) ([]byte, capture.Metadata) {
+	tb.Helper()
+
+	require.NoError(tb, ep.SetReadDeadline(time.Now().Add(time.Second)))
+
+	buf := make([]byte, 1522)
+	md, err := ep.ReadFrameMetadata(buf)
+	require.NoError(tb, err)
+
+	return buf[:md.CaptureLength], md
+}
+
+// nothing checks ep receives no frame for a while
+func nothing(tb testing.TB, ep *Endpoint) {
+	tb.Helper()
+
+	require.NoError(tb, ep.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+
+	_, err := ep.ReadFrame(make([]byte, 1522))
+	assert.ErrorIs(tb, err, os.ErrDeadlineExceeded)
+}
+
+func TestSegmentDelivery(t *testing.T) {
+	t.Parallel()
+
+	seg := NewSegment()
+	defer seg.Close() //nolint:errcheck // never fails
+
+	a := seg.Attach("a", macA)
+	b := seg.Attach("b", macB)
+	c := seg.Attach("c", macC, WithPromiscuous())
+
+	// broadcast reaches everyone but the sender
+	broadcast := numbered(t, macA, 1)
+	require.NoError(t, a.WriteFrame(broadcast))
+
+	frame, md := read(t, b)
+	assert.Equal(t, broadcast, frame)
+	assert.Equal(t, capture.Metadata{
+		Timestamp:       md.Timestamp,
+		Interface:       "b",
+		Ifindex:         2,
+		CaptureLength:   len(broadcast),
+		Length:          len(broadcast),
+		TimestampSource: capture.TimestampSoftware,
+		Direction:       capture.DirectionInbound,
+		PacketType:      unix.PACKET_BROADCAST,
+	}, md)
+
+	_, md = read(t, c)
+	assert.Equal(t, uint8(unix.PACKET_BROADCAST), md.PacketType)
+	nothing(t, a)
+
+	// unicast reaches its destination and the promiscuous endpoints
+	unicast := build(t, ethernet.NewFrame().Src(macA).Dst(macB).Padded().
+		ARPReply(netip.MustParseAddr("10.0.0.1"), macB, netip.MustParseAddr("10.0.0.2")))
+	require.NoError(t, a.WriteFrame(unicast))
+
+	_, md = read(t, b)
+	assert.Equal(t, uint8(unix.PACKET_HOST), md.PacketType)
+
+	_, md = read(t, c)
+	assert.Equal(t, uint8(unix.PACKET_OTHERHOST), md.PacketType)
+
+	require.NoError(t, b.WriteFrame(build(t, ethernet.NewFrame().Src(macB).Dst(macA).Padded().
+		ARPReply(netip.MustParseAddr("10.0.0.2"), macA, netip.MustParseAddr("10.0.0.1")))))
+	read(t, a)
+	read(t, c)
+
+	assert.Equal(t, Stats{Sent: 3, Delivered: 6}, seg.Stats())
+}
+
+func TestEndpoint(t *testing.T) {
+	t.Parallel()
+
+	seg := NewSegment()
+	defer seg.Close() //nolint:errcheck // never fails
+
+	a := seg.Attach("a", macA)
+	b := seg.Attach("b", macB, WithQueueSize(2))
+
+	assert.Equal(t, &net.Interface{Index: 1, MTU: 1500, Name: "a", HardwareAddr: macA}, a.Interface())
+	assert.ErrorIs(t, a.WriteFrame(make([]byte, 13)), ErrShortFrame)
+
+	// a full queue overruns, as a socket buffer
+	for n := range uint16(3) {
+		require.NoError(t, a.WriteFrame(numbered(t, macA, n)))
+	}
+
+	assert.Equal(t, uint64(1), seg.Stats().Overrun)
+
+	read(t, b)
+	read(t, b)
+
+	// a blocked read is interrupted by a deadline in the past
+	errC := make(chan error)
+
+	go func() {
+		_, err := b.ReadFrame(make([]byte, 64))
+		errC <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, b.SetReadDeadline(time.Unix(1, 0)))
+	assert.ErrorIs(t, <-errC, os.ErrDeadlineExceeded)
+
+	// a closed endpoint is detached
+	require.NoError(t, b.SetReadDeadline(time.Time{}))
+	require.NoError(t, b.Close())
+
+	_, err := b.ReadFrame(make([]byte, 64))
+	require.ErrorIs(t, err, capture.ErrClosed)
+	require.ErrorIs(t, b.WriteFrame(numbered(t, macB, 0)), capture.ErrClosed)
+
+	require.NoError(t, a.WriteFrame(numbered(t, macA, 0)))
+	assert.Equal(t, uint64(4), seg.Stats().Sent)
+	assert.Equal(t, uint64(2), seg.Stats().Delivered)
+}
+
+func TestSegmentLoss(t *testing.T) {
+	t.Parallel()
+
+	received := func(seed uint64) int {
+		seg := NewSegment(WithLoss(0.5), WithSeed(seed))
+		defer seg.Close() //nolint:errcheck // never fails
+
+		a := seg.Attach("a", macA)
+		b := seg.Attach("b", macB)
+
+		for n := range uint16(100) {
+			require.NoError(t, a.WriteFrame(numbered(t, macA, n)))
+		}
+
+		st := seg.Stats()
+		assert.Equal(t, uint64(100), st.Delivered+st.Lost)
+		assert.Len(t, b.queue, int(st.Delivered)) //nolint:gosec // at most 100
+
+		return len(b.queue)
+	}
+
+	// the same seed loses the same frames
+	first := received(7)
+	assert.Equal(t, first, received(7))
+	assert.Greater(t, first, 20)
+	assert.Less(t, first, 80)
+}
+
+func TestSegmentLatency(t *testing.T) {
+	t.Parallel()
+
+	seg := NewSegment(WithLatency(30*time.Millisecond, 0))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	a := seg.Attach("a", macA)
+	b := seg.Attach("b", macB)
+
+	start := time.Now()
+
+	require.NoError(t, a.WriteFrame(numbered(t, macA, 0)))
+	read(t, b)
+
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+}
+
+func TestSegmentReordering(t *testing.T) {
+	t.Parallel()
+
+	seg := NewSegment(WithReordering(0.2, 10*time.Millisecond), WithSeed(3))
+	defer seg.Close() //nolint:errcheck // never fails
+
+	a := seg.Attach("a", macA)
+	b := seg.Attach("b", macB)
+
+	const frames = 50
+
+	for n := range uint16(frames) {
+		require.NoError(t, a.WriteFrame(numbered(t, macA, n)))
+	}
+
+	var order []uint16
+
+	for range frames {
+		frame, _ := read(t, b)
+		order = append(order, binary.BigEndian.Uint16(frame[14:16]))
+	}
+
+	// every frame arrives, those held back after the others
+	assert.ElementsMatch(t, func() []uint16 {
+		all := make([]uint16, frames)
+		for i := range all {
+			all[i] = uint16(i) //nolint:gosec // at most frames
+		}
+
+		return all
+	}(), order)
+	assert.NotEqual(t, uint64(0), seg.Stats().Reordered)
+	assert.False(t, func() bool {
+		for i := 1; i < len(order); i++ {
+			if order[i] < order[i-1] {
+				return false
+			}
+		}
+
+		return true
+	}(), "the frames weren't reordered")
+}
+
+func TestHostARP(t *testing.T) {
+	defer leak.Check(t)()
+
+	seg := NewSegment()
+	defer seg.Close() //nolint:errcheck // never fails
+
+	ip := netip.MustParseAddr("10.0.0.5")
+	seg.AddHost("a", macA, WithAddrs(ip))
+	seg.AddHost("b", macB, WithAddrs(netip.MustParseAddr("10.0.0.6")), WithHostVLAN(12))
+
+	ep := seg.Attach("probe", macC)
+	src := netip.MustParseAddr("10.0.0.1")
+
+	require.NoError(t, ep.WriteFrame(build(t, ethernet.NewFrame().Src(macC).Padded().ARPRequest(src, ip))))
+
+	frame, _ := read(t, ep)
+
+	var eth ethernet.EthernetFrame
+
+	require.NoError(t, eth.UnmarshalBinary(frame))
+
+	pkt, err := eth.ExtractARPPacket()
+	require.NoError(t, err)
+	assert.Equal(t, macC, eth.DstMAC)
+	assert.Equal(t, uint16(ethernet.OpReply), pkt.OpCode)
+	assert.Equal(t, ip, pkt.SenderAddr())
+	assert.Equal(t, macA, pkt.SendHwAddr)
+	assert.Equal(t, src, pkt.TargetAddr())
+
+	// the host on VLAN 12 only answers there
+	request := ethernet.NewFrame().Src(macC).Padded().ARPRequest(src, netip.MustParseAddr("10.0.0.6"))
+	require.NoError(t, ep.WriteFrame(build(t, request)))
+	nothing(t, ep)
+
+	require.NoError(t, ep.WriteFrame(build(t, request.VLAN(12))))
+
+	frame, _ = read(t, ep)
+	require.NoError(t, eth.UnmarshalBinary(frame))
+
+	pkt, err = eth.ExtractARPPacket()
+	require.NoError(t, err)
+	assert.Equal(t, macB, pkt.SendHwAddr)
+}
+
+func TestHostNDP(t *testing.T) {
+	defer leak.Check(t)()
+
+	seg := NewSegment()
+	defer seg.Close() //nolint:errcheck // never fails
+
+	ip := netip.MustParseAddr("fd00::5")
+	seg.AddHost("a", macA, WithAddrs(ip))
+
+	ep := seg.Attach("probe", macC)
+	src := netip.MustParseAddr("fd00::1")
+
+	require.NoError(t, ep.WriteFrame(build(t, ethernet.NewFrame().Src(macC).NeighborSolicitation(src, ip))))
+
+	frame, _ := read(t, ep)
+
+	msg, pkt, err := ndp.ParseFrame(frame)
+	require.NoError(t, err)
+	assert.Equal(t, ndp.TypeNeighborAdvertisement, msg.Type)
+	assert.Equal(t, ip, msg.Target)
+	assert.Equal(t, macA, msg.LinkLayerAddr)
+	assert.True(t, msg.Solicited)
+	assert.Equal(t, src, pkt.Dst)
+
+	// duplicate address detection is answered to all the nodes
+	require.NoError(t, ep.WriteFrame(build(t, ethernet.NewFrame().Src(macC).
+		NeighborSolicitation(netip.IPv6Unspecified(), ip))))
+
+	frame, _ = read(t, ep)
+
+	msg, pkt, err = ndp.ParseFrame(frame)
+	require.NoError(t, err)
+	assert.False(t, msg.Solicited)
+	assert.Equal(t, netip.MustParseAddr("ff02::1"), pkt.Dst)
+}
+
+func TestHostDHCP(t *testing.T) {
+	defer leak.Check(t)()
+
+	seg := NewSegment()
+	defer seg.Close() //nolint:errcheck // never fails
+
+	server := netip.MustParseAddr("10.0.0.1")
+	seg.AddHost("server", macA, WithAddrs(server), WithDHCPServer(server, netip.MustParsePrefix("10.0.0.0/30")))
+
+	client := seg.AddHost("client", macB)
+	other := seg.AddHost("other", macC)
+
+	discover := func(h *Host, xid uint32) []Offer {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		offers, err := h.Discover(ctx, xid)
+		require.NoError(t, err)
+
+		return offers
+	}
+
+	offer := Offer{Server: server, Addr: netip.MustParseAddr("10.0.0.2"), ServerMAC: macA, XID: 1}
+	assert.Equal(t, []Offer{offer}, discover(client, 1))
+
+	// the client is offered the same address again
+	offer.XID = 2
+	assert.Equal(t, []Offer{offer}, discover(client, 2))
+
+	// the server skips its own address, then runs out
+	assert.Equal(t, []Offer{{Server: server, Addr: netip.MustParseAddr("10.0.0.3"), ServerMAC: macA, XID: 3}},
+		discover(other, 3))
+	assert.Empty(t, discover(seg.AddHost("late", net.HardwareAddr{0x52, 0x54, 0x00, 0, 0, 0x0d}), 4))
+}
+
+func TestHostAnnounce(t *testing.T) {
+	defer leak.Check(t)()
+
+	seg := NewSegment()
+	defer seg.Close() //nolint:errcheck // never fails
+
+	h := seg.AddHost("a", macA, WithAddrs(netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("fd00::5")))
+	ep := seg.Attach("monitor", macC)
+
+	require.NoError(t, h.Announce())
+
+	frame, _ := read(t, ep)
+
+	var eth ethernet.EthernetFrame
+
+	require.NoError(t, eth.UnmarshalBinary(frame))
+
+	pkt, err := eth.ExtractARPPacket()
+	require.NoError(t, err)
+	assert.Equal(t, pkt.SenderAddr(), pkt.TargetAddr())
+
+	frame, _ = read(t, ep)
+
+	msg, _, err := ndp.ParseFrame(frame)
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("fd00::5"), msg.Target)
+	assert.True(t, msg.Override)
+}
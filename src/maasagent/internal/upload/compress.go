@@ -0,0 +1,124 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package upload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor compresses the chunks of an Uploader
+type Compressor interface {
+	// Encoding names the compression, as the HTTP Content-Encoding does
+	Encoding() string
+	// Compress returns data compressed
+	Compress(data []byte) ([]byte, error)
+	// Decompress returns data decompressed, for the receivers of the chunks
+	Decompress(data []byte) ([]byte, error)
+}
+
+// Gzip returns the Compressor of gzip at level, see compress/gzip
+func Gzip(level int) Compressor {
+	return gzipCompressor{level: level}
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+func (gzipCompressor) Encoding() string {
+	return "gzip"
+}
+
+func (c gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip level: %w", err)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+// Zstd returns the Compressor of zstd, at its default level
+func Zstd() Compressor {
+	return &zstdCompressor{}
+}
+
+// zstdCompressor holds an encoder and a decoder created on first use, they
+// are safe for concurrent use with EncodeAll and DecodeAll
+type zstdCompressor struct {
+	enc  *zstd.Encoder
+	dec  *zstd.Decoder
+	err  error
+	once sync.Once
+}
+
+func (c *zstdCompressor) init() error {
+	c.once.Do(func() {
+		c.enc, c.err = zstd.NewWriter(nil)
+		if c.err != nil {
+			return
+		}
+
+		c.dec, c.err = zstd.NewReader(nil)
+	})
+
+	return c.err
+}
+
+func (*zstdCompressor) Encoding() string {
+	return "zstd"
+}
+
+func (c *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	return c.enc.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	return c.dec.DecodeAll(data, nil)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package upload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressors(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte(`{"ip":"10.0.0.1","mac":"52:54:00:00:00:01","event":"NEW"}`+"\n"), 100)
+
+	testcases := map[string]struct {
+		c        Compressor
+		encoding string
+	}{
+		"gzip": {c: Gzip(gzip.BestSpeed), encoding: "gzip"},
+		"zstd": {c: Zstd(), encoding: "zstd"},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.encoding, tc.c.Encoding())
+
+			compressed, err := tc.c.Compress(data)
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), len(data)/10)
+
+			decompressed, err := tc.c.Decompress(compressed)
+			require.NoError(t, err)
+			assert.Equal(t, data, decompressed)
+
+			_, err = tc.c.Decompress(data)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := Gzip(42).Compress(data)
+	assert.Error(t, err)
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"maas.io/core/src/maasagent/internal/apiclient"
+	"maas.io/core/src/maasagent/internal/schema"
+)
+
+// maxResponseLen bounds the body of an answer of the region read, a
+// Position
+const maxResponseLen = 4 << 10
+
+const (
+	// StartHeader holds the Start of the chunk of a request, as
+	// Position.String formats it
+	StartHeader = "X-Maas-Upload-Start"
+	// EndHeader holds the End of the chunk of a request
+	EndHeader = "X-Maas-Upload-End"
+	// DigestHeader holds the hexadecimal SHA-256 of the body of a request
+	DigestHeader = "X-Maas-Content-Sha256"
+)
+
+var (
+	// ErrInvalidPosition is returned by ParsePosition for a malformed
+	// Position
+	ErrInvalidPosition = errors.New("invalid upload position")
+	// ErrDigestMismatch is returned by ReadChunk for a body whose SHA-256
+	// isn't the one of its request
+	ErrDigestMismatch = errors.New("chunk digest mismatch")
+)
+
+// ParsePosition returns the Position s, as Position.String formats it
+func ParsePosition(s string) (Position, error) {
+	seq, offset, ok := strings.Cut(s, "+")
+	if !ok {
+		return Position{}, fmt.Errorf("%w: %q", ErrInvalidPosition, s)
+	}
+
+	var (
+		p   Position
+		err error
+	)
+
+	if p.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return Position{}, fmt.Errorf("%w: %q", ErrInvalidPosition, s)
+	}
+
+	if p.Offset, err = strconv.Atoi(offset); err != nil || p.Offset < 0 {
+		return Position{}, fmt.Errorf("%w: %q", ErrInvalidPosition, s)
+	}
+
+	return p, nil
+}
+
+// Requester makes the requests of an HTTPTransport, apiclient.APIClient is
+// one
+type Requester interface {
+	Request(ctx context.Context, method, path string, body []byte,
+		options ...apiclient.RequestOption) (*http.Response, error)
+}
+
+// HTTPTransport is the Transport of the API of the region. A stream is the
+// resource of its name under a path: a GET of it returns the Position of
+// the region as JSON, or 404 for a stream it knows nothing of, and a POST
+// sends it a chunk, the region answering with its Position, or 409 when
+// the chunk doesn't start there.
+type HTTPTransport struct {
+	client Requester
+	path   string
+}
+
+// NewHTTPTransport returns the HTTPTransport of the streams under path
+func NewHTTPTransport(client Requester, path string) *HTTPTransport {
+	return &HTTPTransport{client: client, path: path}
+}
+
+func (t *HTTPTransport) resource(stream string) string {
+	return strings.TrimSuffix(t.path, "/") + "/" + url.PathEscape(stream)
+}
+
+// Position returns the Position of the region in stream
+func (t *HTTPTransport) Position(ctx context.Context, stream string) (Position, error) {
+	resp, err := t.client.Request(ctx, http.MethodGet, t.resource(stream), nil)
+	if err != nil {
+		return Position{}, err
+	}
+
+	defer resp.Body.Close() //nolint:errcheck // the body was read
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Position{}, nil
+	}
+
+	return readPosition(resp)
+}
+
+// Send posts c to the region
+func (t *HTTPTransport) Send(ctx context.Context, c Chunk) (Position, error) {
+	resp, err := t.client.Request(ctx, http.MethodPost, t.resource(c.Stream), c.Body,
+		apiclient.WithHeader("Content-Type", "application/x-ndjson"),
+		apiclient.WithHeader("Content-Encoding", c.Encoding),
+		apiclient.WithHeader(schema.Header, strconv.Itoa(c.Version)),
+		apiclient.WithHeader(StartHeader, c.Start.String()),
+		apiclient.WithHeader(EndHeader, c.End.String()),
+		apiclient.WithHeader(DigestHeader, c.SHA256))
+	if err != nil {
+		return Position{}, err
+	}
+
+	defer resp.Body.Close() //nolint:errcheck // the body was read
+
+	if resp.StatusCode == http.StatusConflict {
+		return Position{}, fmt.Errorf("%w: %s of %s", ErrOutOfSync, c.Start, c.Stream)
+	}
+
+	return readPosition(resp)
+}
+
+// readPosition returns the Position answered by the region
+func readPosition(resp *http.Response) (Position, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseLen))
+	if err != nil {
+		return Position{}, fmt.Errorf("failed to read the answer of the region: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return Position{}, fmt.Errorf("region answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var p Position
+
+	if err := json.Unmarshal(body, &p); err != nil {
+		return Position{}, fmt.Errorf("%w: %w", ErrInvalidPosition, err)
+	}
+
+	return p, nil
+}
+
+// ReadChunk returns the Chunk posted by an HTTPTransport in req to the
+// stream, its body checked against its digest. It is for the receivers of
+// the chunks, such as the simulators of the region.
+func ReadChunk(req *http.Request, stream string, maxLen int64) (Chunk, error) {
+	c := Chunk{Stream: stream, Encoding: req.Header.Get("Content-Encoding"), SHA256: req.Header.Get(DigestHeader)}
+
+	var err error
+
+	if c.Version, err = strconv.Atoi(req.Header.Get(schema.Header)); err != nil {
+		return Chunk{}, fmt.Errorf("invalid %s: %w", schema.Header, err)
+	}
+
+	if c.Start, err = ParsePosition(req.Header.Get(StartHeader)); err != nil {
+		return Chunk{}, err
+	}
+
+	if c.End, err = ParsePosition(req.Header.Get(EndHeader)); err != nil {
+		return Chunk{}, err
+	}
+
+	if c.Body, err = io.ReadAll(io.LimitReader(req.Body, maxLen)); err != nil {
+		return Chunk{}, fmt.Errorf("failed to read the chunk: %w", err)
+	}
+
+	sum := sha256.Sum256(c.Body)
+	if hex.EncodeToString(sum[:]) != c.SHA256 {
+		return Chunk{}, fmt.Errorf("%w: %s of %s", ErrDigestMismatch, c.Start, stream)
+	}
+
+	return c, nil
+}
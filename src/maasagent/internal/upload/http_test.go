@@ -0,0 +1,117 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package upload
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/schema"
+)
+
+func TestParsePosition(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range []Position{{}, {Seq: 12, Offset: 345}, {Seq: 1 << 63}} {
+		parsed, err := ParsePosition(p.String())
+		require.NoError(t, err)
+		assert.Equal(t, p, parsed)
+	}
+
+	for _, s := range []string{"", "12", "+1", "12+", "-1+0", "1+-1", "a+b"} {
+		_, err := ParsePosition(s)
+		assert.ErrorIs(t, err, ErrInvalidPosition, s)
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	t.Parallel()
+
+	r := newRegion(t)
+	tr := r.transport(t)
+	ctx := context.Background()
+
+	// the region knows nothing of the stream yet
+	pos, err := tr.Position(ctx, schema.NameSnapshot)
+	require.NoError(t, err)
+	assert.Equal(t, Position{}, pos)
+
+	u := &Uploader{stream: schema.NameSnapshot, compressor: Zstd()}
+
+	c, err := u.chunk([]byte("{}\n"), Position{}, Position{Seq: 1})
+	require.NoError(t, err)
+
+	pos, err = tr.Send(ctx, c)
+	require.NoError(t, err)
+	assert.Equal(t, Position{Seq: 1}, pos)
+
+	pos, err = tr.Position(ctx, schema.NameSnapshot)
+	require.NoError(t, err)
+	assert.Equal(t, Position{Seq: 1}, pos)
+
+	// sent again, the chunk no longer starts where the region is
+	_, err = tr.Send(ctx, c)
+	require.ErrorIs(t, err, ErrOutOfSync)
+
+	// the region rejects the chunks altered on the way
+	c.Start, c.End = Position{Seq: 1}, Position{Seq: 2}
+	c.Body = append(bytes.Clone(c.Body), 0)
+
+	_, err = tr.Send(ctx, c)
+	assert.ErrorContains(t, err, "400 Bad Request")
+	assert.Equal(t, "{}\n", string(r.stream(schema.NameSnapshot)))
+}
+
+func TestReadChunk(t *testing.T) {
+	t.Parallel()
+
+	u := &Uploader{stream: schema.NameEvent, compressor: Gzip(1)}
+
+	c, err := u.chunk([]byte("{}\n"), Position{Seq: 4, Offset: 2}, Position{Seq: 5})
+	require.NoError(t, err)
+
+	request := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/observations/event", bytes.NewReader(c.Body))
+		req.Header.Set("Content-Encoding", c.Encoding)
+		req.Header.Set(schema.Header, strconv.Itoa(schema.Version))
+		req.Header.Set(StartHeader, "4+2")
+		req.Header.Set(EndHeader, "5+0")
+		req.Header.Set(DigestHeader, c.SHA256)
+
+		return req
+	}
+
+	read, err := ReadChunk(request(), schema.NameEvent, 1<<10)
+	require.NoError(t, err)
+	assert.Equal(t, c, read)
+
+	// a chunk longer than allowed is cut, which its digest tells
+	_, err = ReadChunk(request(), schema.NameEvent, 4)
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+
+	req := request()
+	req.Header.Set(StartHeader, "4")
+
+	_, err = ReadChunk(req, schema.NameEvent, 1<<10)
+	assert.ErrorIs(t, err, ErrInvalidPosition)
+}
@@ -0,0 +1,457 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package upload exports the documents of a schema to the MAAS region in
+// chunks, over a link which may drop at any time. The documents are
+// journaled as lines of JSON, and the stream of their lines is cut into
+// chunks, each compressed and hashed. The region acknowledges a chunk with
+// its Position in the stream, a journal sequence and an offset in the
+// record following it, and the records it holds whole are acknowledged in
+// the journal. After a failure the upload resumes from the Position the
+// region reports, so that no byte is sent to it twice once it took it.
+package upload
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/clock"
+	"maas.io/core/src/maasagent/internal/journal"
+	"maas.io/core/src/maasagent/internal/schema"
+)
+
+const (
+	// DefaultChunkSize bounds the bytes of records in a chunk, before
+	// compression
+	DefaultChunkSize = 256 << 10
+
+	defaultInterval   = 10 * time.Second
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = 5 * time.Minute
+)
+
+var (
+	// ErrUnknownSchema is returned by NewUploader for a stream which isn't
+	// the name of a schema
+	ErrUnknownSchema = errors.New("unknown schema")
+	// ErrOutOfSync is matched by the errors of a Transport for a chunk
+	// which doesn't start at the Position of the region
+	ErrOutOfSync = errors.New("chunk out of sync with the region")
+	// ErrPositionAhead is returned when the region holds records past the
+	// last of the journal, which was lost or replaced
+	ErrPositionAhead = errors.New("region ahead of the journal")
+	// ErrStalled is returned when the region takes a chunk without moving
+	// its Position forward
+	ErrStalled = errors.New("region didn't take the chunk")
+)
+
+// Position is how far the region got in a stream: it holds the records up
+// to the sequence Seq whole, and the first Offset bytes of the next one
+type Position struct {
+	Seq    uint64 `json:"seq"`
+	Offset int    `json:"offset"`
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d+%d", p.Seq, p.Offset)
+}
+
+// Chunk is a part of a stream, the bytes from Start to End compressed
+type Chunk struct {
+	// Stream is the name of the schema of the documents of the stream
+	Stream string
+	// Encoding is the Compressor.Encoding of Body
+	Encoding string
+	// SHA256 is the hexadecimal SHA-256 of Body
+	SHA256 string
+	Body   []byte
+	// Start is the Position the region must be at to take the chunk, and
+	// End the one it is at once it took it
+	Start Position
+	End   Position
+	// Version is the version of the schema of the documents
+	Version int
+}
+
+// Transport sends the chunks of an Uploader to the region
+type Transport interface {
+	// Position returns the Position of the region in stream, the zero
+	// Position for a stream it knows nothing of
+	Position(ctx context.Context, stream string) (Position, error)
+	// Send sends c and returns the Position of the region once it took
+	// it. A chunk the region refuses because it doesn't start at its
+	// Position returns an error matching ErrOutOfSync.
+	Send(ctx context.Context, c Chunk) (Position, error)
+}
+
+// Stats are the counters of an Uploader
+type Stats struct {
+	// Position is the last Position of the region known
+	Position Position `json:"position"`
+	// Chunks is the number of chunks the region took
+	Chunks uint64 `json:"chunks"`
+	// Bytes and Compressed are the bytes of records of those chunks,
+	// before and after compression
+	Bytes      uint64 `json:"bytes"`
+	Compressed uint64 `json:"compressed"`
+	// Failed is the number of chunks not sent, or refused
+	Failed uint64 `json:"failed"`
+	// Resumed is the number of times the upload went on from a Position
+	// of the region other than the one expected
+	Resumed uint64 `json:"resumed"`
+}
+
+// Uploader uploads the documents of a schema journaled by Append. Run
+// uploads them as they come, Flush once.
+type Uploader struct {
+	journal    *journal.Journal
+	transport  Transport
+	compressor Compressor
+	clock      clock.Clock
+	wake       chan struct{}
+	stream     string
+	chunkSize  int
+	interval   time.Duration
+	backoff    time.Duration
+	maxBackoff time.Duration
+	chunks     atomic.Uint64
+	bytes      atomic.Uint64
+	compressed atomic.Uint64
+	failed     atomic.Uint64
+	resumed    atomic.Uint64
+	// mu serializes the flushes
+	mu sync.Mutex
+	// position is the last Position of the region known, positionMu
+	// protects it
+	position   Position
+	positionMu sync.Mutex
+}
+
+// Option configures an Uploader
+type Option func(*Uploader)
+
+// WithChunkSize bounds the bytes of records of a chunk, before compression,
+// DefaultChunkSize by default. A record longer than that spans chunks.
+func WithChunkSize(n int) Option {
+	return func(u *Uploader) {
+		if n > 0 {
+			u.chunkSize = n
+		}
+	}
+}
+
+// WithCompressor compresses the chunks with c, gzip at its default level
+// by default
+func WithCompressor(c Compressor) Option {
+	return func(u *Uploader) {
+		if c != nil {
+			u.compressor = c
+		}
+	}
+}
+
+// WithInterval sets how often Run uploads the documents not yet uploaded,
+// on top of doing so once they are appended
+func WithInterval(d time.Duration) Option {
+	return func(u *Uploader) {
+		if d > 0 {
+			u.interval = d
+		}
+	}
+}
+
+// WithBackoff sets the time Run waits after a failed upload, each of the
+// next failures waits twice as long as the previous one, up to maxBackoff
+func WithBackoff(d, maxBackoff time.Duration) Option {
+	return func(u *Uploader) {
+		if d > 0 {
+			u.backoff = d
+		}
+
+		if maxBackoff > 0 {
+			u.maxBackoff = maxBackoff
+		}
+	}
+}
+
+// WithUploadClock sets the clock timing the uploads and their retries
+func WithUploadClock(c clock.Clock) Option {
+	return func(u *Uploader) {
+		u.clock = c
+	}
+}
+
+// NewUploader returns the Uploader of the documents of the schema stream,
+// see schema.Names, journaled in j and sent through t. The journal is the
+// Uploader's own: it acknowledges the records the region holds.
+func NewUploader(j *journal.Journal, t Transport, stream string, options ...Option) (*Uploader, error) {
+	if !slices.Contains(schema.Names, stream) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSchema, stream)
+	}
+
+	u := &Uploader{
+		journal:    j,
+		transport:  t,
+		compressor: Gzip(gzip.DefaultCompression),
+		clock:      clock.System{},
+		wake:       make(chan struct{}, 1),
+		stream:     stream,
+		chunkSize:  DefaultChunkSize,
+		interval:   defaultInterval,
+		backoff:    defaultBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+
+	for _, opt := range options {
+		opt(u)
+	}
+
+	return u, nil
+}
+
+// Append journals doc, a document of the schema of the Uploader, as a line
+// of JSON, and returns its sequence. The line is uploaded by the next
+// flush.
+func (u *Uploader) Append(doc any) (uint64, error) {
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode %s: %w", u.stream, err)
+	}
+
+	seq, err := u.journal.Append(append(line, '\n'))
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+
+	return seq, nil
+}
+
+// Run uploads the documents appended until ctx is done, a failed upload
+// is tried again after a backoff
+func (u *Uploader) Run(ctx context.Context) error {
+	backoff := u.backoff
+
+	ticker := u.clock.NewTicker(u.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := u.Flush(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			log.Warn().Err(err).Str("stream", u.stream).Dur("backoff", backoff).Msg("Upload to the region failed")
+
+			if err := clock.Sleep(ctx, u.clock, backoff); err != nil {
+				return nil //nolint:nilerr // the uploader stops with ctx
+			}
+
+			backoff = min(2*backoff, u.maxBackoff)
+
+			continue
+		}
+
+		backoff = u.backoff
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-u.wake:
+		case <-ticker.C():
+		}
+	}
+}
+
+// Flush uploads the records of the journal the region doesn't hold yet,
+// from the Position it reports, and acknowledges those it holds whole
+func (u *Uploader) Flush(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	pos, err := u.transport.Position(ctx, u.stream)
+	if err != nil {
+		return fmt.Errorf("failed to get the position of the region in %s: %w", u.stream, err)
+	}
+
+	u.positionMu.Lock()
+	known := u.position
+	u.positionMu.Unlock()
+
+	if pos != known {
+		// the region took a chunk whose answer was lost, or the agent
+		// restarted
+		u.resumed.Add(1)
+	}
+
+	if err := u.acknowledge(pos); err != nil {
+		return err
+	}
+
+	records, err := u.journal.Replay()
+	if err != nil {
+		return err
+	}
+
+	for {
+		body, end := u.cut(records, pos)
+		if len(body) == 0 {
+			// the empty records left have nothing to send
+			return u.journal.Ack(end.Seq)
+		}
+
+		c, err := u.chunk(body, pos, end)
+		if err != nil {
+			return err
+		}
+
+		got, err := u.transport.Send(ctx, c)
+		if errors.Is(err, ErrOutOfSync) {
+			// the region took chunks the uploader didn't hear of, such as
+			// one whose answer was lost, it goes on from where it is
+			got, err = u.transport.Position(ctx, u.stream)
+		}
+
+		if err != nil {
+			u.failed.Add(1)
+
+			return fmt.Errorf("failed to send the chunk %s of %s: %w", pos, u.stream, err)
+		}
+
+		switch {
+		case got == pos:
+			u.failed.Add(1)
+
+			return fmt.Errorf("%w: %s at %s", ErrStalled, u.stream, pos)
+		case got != end:
+			// the region says where it is, the next chunk starts there
+			u.resumed.Add(1)
+			log.Debug().Str("stream", u.stream).Stringer("expected", end).Stringer("position", got).
+				Msg("Resuming the upload from the position of the region")
+		default:
+			u.chunks.Add(1)
+			u.bytes.Add(uint64(len(body)))
+			u.compressed.Add(uint64(len(c.Body)))
+		}
+
+		if err := u.acknowledge(got); err != nil {
+			return err
+		}
+
+		pos = got
+	}
+}
+
+// acknowledge records pos as the Position of the region, and acknowledges
+// in the journal the records it holds whole
+func (u *Uploader) acknowledge(pos Position) error {
+	if pos.Seq > u.journal.Last() {
+		return fmt.Errorf("%w: %s at %s, the journal ends at %d", ErrPositionAhead, u.stream, pos, u.journal.Last())
+	}
+
+	u.positionMu.Lock()
+	u.position = pos
+	u.positionMu.Unlock()
+
+	return u.journal.Ack(pos.Seq)
+}
+
+// cut returns the bytes of records from pos, up to the chunk size, and the
+// Position at their end
+func (u *Uploader) cut(records []journal.Record, pos Position) ([]byte, Position) {
+	var body []byte
+
+	end := pos
+
+	for _, r := range records {
+		if r.Seq <= pos.Seq {
+			continue
+		}
+
+		// the first record after pos may have been partly taken
+		offset := 0
+		if end == pos {
+			offset = min(pos.Offset, len(r.Data))
+		}
+
+		room := u.chunkSize - len(body)
+		if len(r.Data)-offset > room {
+			body = append(body, r.Data[offset:offset+room]...)
+			end.Offset = offset + room
+
+			return body, end
+		}
+
+		body = append(body, r.Data[offset:]...)
+		end = Position{Seq: r.Seq}
+
+		if len(body) == u.chunkSize {
+			break
+		}
+	}
+
+	return body, end
+}
+
+// chunk returns the Chunk of body, from start to end
+func (u *Uploader) chunk(body []byte, start, end Position) (Chunk, error) {
+	compressed, err := u.compressor.Compress(body)
+	if err != nil {
+		return Chunk{}, fmt.Errorf("failed to compress the chunk %s of %s: %w", start, u.stream, err)
+	}
+
+	sum := sha256.Sum256(compressed)
+
+	return Chunk{
+		Stream:   u.stream,
+		Encoding: u.compressor.Encoding(),
+		SHA256:   hex.EncodeToString(sum[:]),
+		Body:     compressed,
+		Start:    start,
+		End:      end,
+		Version:  schema.Version,
+	}, nil
+}
+
+// Stats returns the counters of the Uploader
+func (u *Uploader) Stats() Stats {
+	u.positionMu.Lock()
+	pos := u.position
+	u.positionMu.Unlock()
+
+	return Stats{
+		Position:   pos,
+		Chunks:     u.chunks.Load(),
+		Bytes:      u.bytes.Load(),
+		Compressed: u.compressed.Load(),
+		Failed:     u.failed.Load(),
+		Resumed:    u.resumed.Load(),
+	}
+}
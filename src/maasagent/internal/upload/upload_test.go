@@ -0,0 +1,355 @@
+// Copyright (c) 2025 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/apiclient"
+	"maas.io/core/src/maasagent/internal/journal"
+	"maas.io/core/src/maasagent/internal/netmon"
+	"maas.io/core/src/maasagent/internal/schema"
+)
+
+// region is the side of the region of the uploads, over HTTP. Every drop
+// request is cut halfway through its body, and every lose one taken
+// without its answer reaching the uploader.
+type region struct {
+	server    *httptest.Server
+	streams   map[string][]byte
+	positions map[string]Position
+	requests  int
+	drop      int
+	lose      int
+	mu        sync.Mutex
+}
+
+func newRegion(t *testing.T) *region {
+	t.Helper()
+
+	r := &region{streams: make(map[string][]byte), positions: make(map[string]Position)}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+
+	return r
+}
+
+// transport returns the HTTPTransport of the region, through the API client
+// of the agent
+func (r *region) transport(t *testing.T) *HTTPTransport {
+	t.Helper()
+
+	base, err := url.Parse(r.server.URL + "/MAAS/a/v1")
+	require.NoError(t, err)
+
+	return NewHTTPTransport(apiclient.NewAPIClient(base, r.server.Client()), "/observations")
+}
+
+// hangUp closes the connection of the request without answering
+func hangUp(w http.ResponseWriter) {
+	if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+		_ = conn.Close()
+	}
+}
+
+func (r *region) serve(w http.ResponseWriter, req *http.Request) {
+	stream := strings.TrimPrefix(req.URL.Path, "/MAAS/a/v1/observations/")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.Method == http.MethodGet {
+		pos, ok := r.positions[stream]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(pos)
+
+		return
+	}
+
+	r.requests++
+
+	if r.drop > 0 && r.requests%r.drop == 0 {
+		// half the chunk arrives
+		_, _ = io.CopyN(io.Discard, req.Body, req.ContentLength/2)
+		hangUp(w)
+
+		return
+	}
+
+	c, err := ReadChunk(req, stream, 1<<20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if c.Start != r.positions[stream] {
+		http.Error(w, "out of sync", http.StatusConflict)
+		return
+	}
+
+	data, err := Gzip(0).Decompress(c.Body)
+	if c.Encoding == "zstd" {
+		data, err = Zstd().Decompress(c.Body)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.streams[stream] = append(r.streams[stream], data...)
+	r.positions[stream] = c.End
+
+	if r.lose > 0 && r.requests%r.lose == 0 {
+		hangUp(w)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(c.End)
+}
+
+func (r *region) stream(name string) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return bytes.Clone(r.streams[name])
+}
+
+func openJournal(t *testing.T) *journal.Journal {
+	t.Helper()
+
+	j, err := journal.Open(filepath.Join(t.TempDir(), "uploads"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = j.Close() })
+
+	return j
+}
+
+// appendObservations appends n observations, and returns their lines
+func appendObservations(t *testing.T, u *Uploader, n int) []byte {
+	t.Helper()
+
+	var lines []byte
+
+	for i := range n {
+		res := schema.Observation{IP: fmt.Sprintf("10.0.%d.%d", i/250, i%250+1), MAC: "52:54:00:00:00:01",
+			Event: netmon.EventNew}
+
+		_, err := u.Append(res)
+		require.NoError(t, err)
+
+		line, err := json.Marshal(res)
+		require.NoError(t, err)
+
+		lines = append(append(lines, line...), '\n')
+	}
+
+	return lines
+}
+
+// flush flushes u until it succeeds, and returns the failed flushes
+func flush(t *testing.T, u *Uploader) int {
+	t.Helper()
+
+	for failures := range 100 {
+		if err := u.Flush(context.Background()); err == nil {
+			return failures
+		}
+	}
+
+	t.Fatal("the upload never completed")
+
+	return 0
+}
+
+func TestUploaderResume(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []Compressor{Gzip(1), Zstd()} {
+		t.Run(c.Encoding(), func(t *testing.T) {
+			t.Parallel()
+
+			r := newRegion(t)
+			r.drop, r.lose = 3, 5
+
+			j := openJournal(t)
+
+			// the records span chunks
+			u, err := NewUploader(j, r.transport(t), schema.NameObservation, WithChunkSize(100),
+				WithCompressor(c))
+			require.NoError(t, err)
+
+			lines := appendObservations(t, u, 40)
+
+			assert.NotZero(t, flush(t, u))
+
+			// every byte arrived once, in order
+			assert.Equal(t, string(lines), string(r.stream(schema.NameObservation)))
+			assert.Equal(t, j.Last(), j.Acked())
+
+			st := u.Stats()
+			assert.Equal(t, Position{Seq: j.Last()}, st.Position)
+			assert.NotZero(t, st.Failed)
+			assert.NotZero(t, st.Resumed, "the chunks whose answer was lost")
+
+			// the next documents go on from there
+			more := appendObservations(t, u, 3)
+
+			r.mu.Lock()
+			r.drop, r.lose = 0, 0
+			r.mu.Unlock()
+
+			assert.Zero(t, flush(t, u))
+			assert.Equal(t, string(lines)+string(more), string(r.stream(schema.NameObservation)))
+		})
+	}
+}
+
+func TestUploaderRestart(t *testing.T) {
+	t.Parallel()
+
+	r := newRegion(t)
+	path := filepath.Join(t.TempDir(), "uploads")
+
+	j, err := journal.Open(path)
+	require.NoError(t, err)
+
+	u, err := NewUploader(j, r.transport(t), schema.NameObservation, WithChunkSize(64))
+	require.NoError(t, err)
+
+	lines := appendObservations(t, u, 10)
+
+	// the region took the first records and part of the next, then the
+	// agent stopped
+	r.mu.Lock()
+	r.streams[schema.NameObservation] = bytes.Clone(lines[:bytes.IndexByte(lines, '\n')+11])
+	r.positions[schema.NameObservation] = Position{Seq: 1, Offset: 10}
+	r.mu.Unlock()
+
+	require.NoError(t, j.Close())
+
+	j, err = journal.Open(path)
+	require.NoError(t, err)
+
+	defer j.Close() //nolint:errcheck // closed once
+
+	u, err = NewUploader(j, r.transport(t), schema.NameObservation, WithChunkSize(64))
+	require.NoError(t, err)
+
+	assert.Zero(t, flush(t, u))
+	assert.Equal(t, string(lines), string(r.stream(schema.NameObservation)))
+	assert.Equal(t, uint64(10), j.Acked())
+}
+
+func TestUploaderCut(t *testing.T) {
+	t.Parallel()
+
+	u := &Uploader{chunkSize: 4}
+	records := []journal.Record{{Seq: 3, Data: []byte("abc")}, {Seq: 4, Data: []byte("defgh")}, {Seq: 5}}
+
+	testcases := map[string]struct {
+		body string
+		from Position
+		end  Position
+	}{
+		"whole record then part": {from: Position{Seq: 2}, body: "abcd", end: Position{Seq: 3, Offset: 1}},
+		"within a record":        {from: Position{Seq: 3, Offset: 1}, body: "efgh", end: Position{Seq: 4}},
+		"empty record":           {from: Position{Seq: 4}, end: Position{Seq: 5}},
+		"acknowledged records":   {from: Position{Seq: 5}, end: Position{Seq: 5}},
+		"offset in the last":     {from: Position{Seq: 2, Offset: 2}, body: "cdef", end: Position{Seq: 3, Offset: 3}},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			body, end := u.cut(records, tc.from)
+			assert.Equal(t, tc.body, string(body))
+			assert.Equal(t, tc.end, end)
+		})
+	}
+}
+
+func TestUploaderPositionAhead(t *testing.T) {
+	t.Parallel()
+
+	r := newRegion(t)
+	r.positions[schema.NameObservation] = Position{Seq: 7}
+
+	u, err := NewUploader(openJournal(t), r.transport(t), schema.NameObservation)
+	require.NoError(t, err)
+
+	appendObservations(t, u, 2)
+	assert.ErrorIs(t, u.Flush(context.Background()), ErrPositionAhead)
+}
+
+func TestUploaderRun(t *testing.T) {
+	t.Parallel()
+
+	r := newRegion(t)
+	r.drop = 2
+
+	u, err := NewUploader(openJournal(t), r.transport(t), schema.NameObservation,
+		WithBackoff(time.Millisecond, time.Millisecond))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() { done <- u.Run(ctx) }()
+
+	var lines []byte
+
+	for range 3 {
+		lines = append(lines, appendObservations(t, u, 2)...)
+	}
+
+	assert.Eventually(t, func() bool {
+		return bytes.Equal(lines, r.stream(schema.NameObservation))
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestNewUploaderUnknownSchema(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewUploader(openJournal(t), nil, "observations")
+	assert.ErrorIs(t, err, ErrUnknownSchema)
+}